@@ -6,18 +6,30 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"syscall"
 	"time"
 
+	"cloud.google.com/go/storage"
 	firebase "firebase.google.com/go/v4"
 	"firebase.google.com/go/v4/messaging"
 	"github.com/gin-gonic/gin"
 	"google.golang.org/api/option"
 
+	"github.com/adedejiosvaldo/safetrace/backend/internal/apierror"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/bootstrap"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/cache"
 	"github.com/adedejiosvaldo/safetrace/backend/internal/config"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/country"
 	"github.com/adedejiosvaldo/safetrace/backend/internal/database"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/flags"
 	"github.com/adedejiosvaldo/safetrace/backend/internal/handlers"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/middleware"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/params"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/scheduler"
 	"github.com/adedejiosvaldo/safetrace/backend/internal/services"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/utils"
 )
 
 func main() {
@@ -27,21 +39,65 @@ func main() {
 		log.Fatalf("Failed to load config: %v", err)
 	}
 
+	cfgStore := config.NewStore(cfg)
+
+	// Phone numbers, roaming and time zones follow the countries served
+	country.Use(cfg.Countries)
+	cfgStore.OnChange(func(next *config.Config) {
+		country.Use(next.Countries)
+	})
+
+	// Feature flags as the deployment rolls them out; users' overrides are
+	// read once Postgres is up
+	flags.Use(cfg.FeatureFlags)
+	cfgStore.OnChange(func(next *config.Config) {
+		flags.Use(next.FeatureFlags)
+	})
+
+	// Postgres and Redis may still be failing over when the container starts,
+	// so connecting is retried. With degraded boot the server starts first and
+	// answers health checks, and 503 everywhere else, until the API is up.
+	boot := bootstrap.New(bootstrap.Policy{
+		Attempts:       cfg.StartupConnectAttempts,
+		AttemptTimeout: time.Duration(cfg.StartupConnectTimeoutSeconds) * time.Second,
+		InitialBackoff: startupInitialBackoff,
+		MaxBackoff:     time.Duration(cfg.StartupMaxBackoffSeconds) * time.Second,
+	}, "postgres", "redis")
+	boot.Serve(setupBootRouter(handlers.NewBootHealthHandler(boot)))
+	srv := &http.Server{
+		Addr:    ":" + cfg.Port,
+		Handler: boot,
+	}
+	if cfg.StartupDegradedBoot {
+		startServer(srv, cfg.Port)
+	}
+
+	// A shutdown signal while connecting stops the retries
+	bootCtx, stopBoot := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+
 	// Initialize Postgres
-	postgres, err := database.NewPostgresDB(cfg.DatabaseURL)
+	postgres, err := bootstrap.Connect(bootCtx, boot, "postgres", func(ctx context.Context) (*database.PostgresDB, error) {
+		return database.NewPostgresDB(ctx, cfg.DatabaseURL, time.Duration(cfg.UserCacheTTLSeconds)*time.Second)
+	})
 	if err != nil {
 		log.Fatalf("Failed to connect to Postgres: %v", err)
 	}
 	defer postgres.Close()
 	log.Println("✓ Connected to Postgres")
+	flags.LoadOverridesWith(postgres.GetUserFlagOverrides)
 
 	// Initialize Redis
-	redis, err := database.NewRedisDB(cfg.RedisURL)
+	redis, err := bootstrap.Connect(bootCtx, boot, "redis", func(ctx context.Context) (*database.RedisDB, error) {
+		return database.NewRedisDB(ctx, cfg.RedisURL, cfg.RedisNamespace)
+	})
 	if err != nil {
 		log.Fatalf("Failed to connect to Redis: %v", err)
 	}
 	defer redis.Close()
 	log.Println("✓ Connected to Redis")
+	checkRedisKeys(redis, cfg)
+	checkRedisPayloads(redis)
+	stopBoot()
 
 	// Initialize Firebase (optional)
 	var fcmClient *messaging.Client
@@ -61,31 +117,335 @@ func main() {
 		}
 	}
 
+	// Initialize object storage for blackbox trails (optional)
+	var objectStore services.ObjectStore
+	if cfg.BlackboxBucket != "" {
+		var opts []option.ClientOption
+		if cfg.FCMCredentialsPath != "" {
+			opts = append(opts, option.WithCredentialsFile(cfg.FCMCredentialsPath))
+		}
+		storageClient, err := storage.NewClient(context.Background(), opts...)
+		if err != nil {
+			log.Printf("Warning: Failed to initialize object storage: %v", err)
+		} else {
+			defer storageClient.Close()
+			objectStore = services.NewGCSObjectStore(storageClient, cfg.BlackboxBucket)
+			log.Printf("✓ Object storage initialized (bucket %s)", cfg.BlackboxBucket)
+		}
+	}
+
 	// Initialize services
-	alertEngine := services.NewAlertEngine(cfg, fcmClient)
-	evaluator := services.NewSafetyEvaluator(cfg, postgres, redis, alertEngine)
+	healthRegistry := services.NewHealthRegistry()
+	// Periodic background jobs, with their runs recorded and triggerable by admins
+	jobScheduler := scheduler.New(postgres, redis, healthRegistry)
+
+	// Global caps on outbound SMS and WhatsApp, with a reserve for alerts
+	outboundBudget := services.NewOutboundBudget(cfgStore, redis)
+	// Retries of failed SMS are counted against it too
+	smsRouter := services.NewSMSRouter(cfg, redis, outboundBudget)
+
+	// Message wording, with this deployment's overrides
+	templateOverrides, err := services.LoadTemplateOverrides(cfg.MessageTemplatesFile)
+	if err != nil {
+		log.Fatalf("Failed to read message templates: %v", err)
+	}
+	messageTemplates, err := services.NewMessageTemplates(templateOverrides)
+	if err != nil {
+		log.Fatalf("Failed to load message templates: %v", err)
+	}
+	reloadTemplates := func(file string) bool {
+		overrides, err := services.LoadTemplateOverrides(file)
+		if err == nil {
+			err = messageTemplates.Load(overrides)
+		}
+		if err != nil {
+			log.Printf("ERROR: Message templates not reloaded, keeping current ones: %v", err)
+		}
+		return err == nil
+	}
+	cfgStore.OnChange(func(next *config.Config) {
+		// Other instances re-read their own templates file too
+		if reloadTemplates(next.MessageTemplatesFile) {
+			if err := postgres.PublishInvalidation(context.Background(), cache.Event{Kind: cache.KindTemplate}); err != nil {
+				log.Printf("WARN: Failed to tell other instances to reload message templates: %v", err)
+			}
+		}
+	})
+	postgres.Caches().Register(cache.KindTemplate, cache.Funcs{
+		FlushFunc: func() { reloadTemplates(cfgStore.Current().MessageTemplatesFile) },
+	})
+
+	// Plus codes and what3words addresses for alert locations
+	locationEncoder := services.NewLocationEncoder(cfgStore, postgres, redis)
+
+	// Outbound SMS counted per user per day, for admin stats
+	smsUsage := services.NewSMSUsage(redis)
+
+	// Map snapshots attached to alerts, deleted after the alert's retention
+	mapSnapshots := services.NewMapSnapshots(cfgStore, postgres, objectStore, healthRegistry)
+	mapSnapshots.Start()
+
+	// Notifier: real providers, or log-and-buffer for local development
+	var notifier services.Notifier
+	var devNotifier *services.DevNotifier
+	if cfg.Notifier == services.NotifierDev {
+		devNotifier = services.NewDevNotifier(cfgStore, postgres, redis, messageTemplates, locationEncoder, mapSnapshots, outboundBudget)
+		notifier = devNotifier
+		log.Println("⚠ NOTIFIER=dev: no SMS, WhatsApp or push will be sent; see GET /debug/notifications")
+	} else {
+		notifier = services.NewAlertEngine(cfgStore, postgres, redis, fcmClient, smsRouter, messageTemplates, locationEncoder, mapSnapshots, outboundBudget)
+	}
+
+	// Investigation bundles of resolved alerts, deleted when they expire
+	incidentBundles := services.NewIncidentBundles(cfgStore, postgres, objectStore, notifier, healthRegistry)
+	incidentBundles.Start()
+
+	// Slack, Teams and webhook channels; recorded with the dev notifier
+	channelNotifier := services.NewChannelNotifier(cfgStore, postgres, devNotifier)
+
+	// Scoring profiles, and shadow evaluation of a candidate profile
+	scoringProfiles := services.NewScoringProfileStore(cfgStore, postgres, healthRegistry)
+	if err := scoringProfiles.Load(context.Background()); err != nil {
+		log.Fatalf("Failed to load scoring profiles: %v", err)
+	}
+	scoringProfiles.Start()
+	shadowEvaluator := services.NewShadowEvaluator(cfgStore, postgres, redis, scoringProfiles, healthRegistry)
+	shadowEvaluator.Start()
+
+	// Alert delivery and evaluations run on fixed worker pools
+	alertOutbox := services.NewAlertOutbox(notifier, channelNotifier, cfg.AlertSendWorkers, healthRegistry)
+	alertOutbox.Start()
+	outageDetector := services.NewOutageDetector(cfgStore, redis)
+	autoResolver := services.NewAutoResolver(cfgStore, postgres, redis, notifier, alertOutbox)
+	protectionWarnings := services.NewProtectionWarnings(postgres, notifier, alertOutbox)
+
+	// Rebuilds Redis state from Postgres after Redis loses its data
+	redisWarmup := services.NewRedisWarmup(cfgStore, postgres, redis, healthRegistry)
+	redisWarmup.Start()
+
+	evaluator := services.NewSafetyEvaluator(cfgStore, postgres, redis, notifier, alertOutbox, locationEncoder, scoringProfiles, shadowEvaluator, outageDetector, autoResolver, redisWarmup, healthRegistry)
+	evaluator.Start()
+
+	// Users whose heartbeats stopped, checked when due from a Redis schedule
+	staleMonitor := services.NewStaleMonitor(cfgStore, postgres, redis, evaluator, jobScheduler)
+	staleMonitor.Start()
+
+	spoofDetector := services.NewSpoofDetector(postgres, nil) // no cell geolocation source yet
+	signatureGuard := services.NewSignatureGuard(cfgStore, postgres, redis, notifier)
+	linkService := services.NewAccountLinkService(postgres, redis)
+	contactAccess := services.NewContactAccessService(cfgStore, postgres, redis, notifier, messageTemplates, locationEncoder)
+	broadcastService := services.NewBroadcastService(cfgStore, postgres, notifier)
+	if err := broadcastService.ResumePending(context.Background()); err != nil {
+		log.Printf("Warning: Failed to resume pending broadcasts: %v", err)
+	}
 	log.Println("✓ Services initialized")
 
+	// Heartbeat ingestion buffer (disable with HEARTBEAT_BUFFER_ENABLED=false)
+	var heartbeatBuffer *services.HeartbeatBuffer
+	if cfg.HeartbeatBufferEnabled {
+		heartbeatBuffer = services.NewHeartbeatBuffer(
+			postgres,
+			evaluator,
+			cfg.HeartbeatBufferSize,
+			cfg.HeartbeatBatchSize,
+			time.Duration(cfg.HeartbeatFlushIntervalMs)*time.Millisecond,
+			healthRegistry,
+		)
+		heartbeatBuffer.Start()
+		log.Printf("✓ Heartbeat buffer enabled (batch=%d, flush=%dms)", cfg.HeartbeatBatchSize, cfg.HeartbeatFlushIntervalMs)
+	}
+
+	// Audit log writer
+	auditLogger := services.NewAuditLogger(
+		postgres,
+		healthRegistry,
+		cfg.AuditQueueSize,
+		cfg.AuditBatchSize,
+		time.Duration(cfg.AuditFlushIntervalMs)*time.Millisecond,
+	)
+	auditLogger.Start()
+
+	// Consent to the privacy policy; asks for it again when a policy version is raised
+	consentService := services.NewConsentService(cfgStore, postgres, notifier, alertOutbox, auditLogger)
+	consentService.Start()
+
+	// Score history retention
+	scoreHistoryPruner := services.NewScoreHistoryPruner(cfgStore, postgres, healthRegistry)
+	scoreHistoryPruner.Start()
+
+	// Crash-signature analysis of uploaded blackbox trails
+	impactAnalyzer := services.NewImpactAnalyzer(cfgStore, postgres, redis, evaluator, objectStore, healthRegistry)
+	impactAnalyzer.Start()
+
+	// Moves trails stored inline as data URIs to object storage, on request
+	trailMigrator := services.NewTrailMigrator(cfgStore, postgres, objectStore)
+
+	// Protection pauses, resumed automatically when they lapse
+	protectionService := services.NewProtectionService(postgres, evaluator, notifier, auditLogger, healthRegistry)
+	protectionService.Start()
+
+	// Watch sessions, checked more often and settled when they run out
+	watchService := services.NewWatchService(postgres, redis, evaluator, notifier, auditLogger, healthRegistry)
+	watchService.Start()
+
+	// Welfare checks requested by contacts and guardians, settled when unanswered
+	welfareService := services.NewWelfareCheckService(cfgStore, postgres, redis, evaluator, notifier, messageTemplates, auditLogger, healthRegistry)
+	welfareService.Start()
+
+	// Check-in prompts at CAUTION, escalated once every attempt goes unanswered
+	silentPrompts := services.NewSilentPrompts(cfgStore, postgres, redis, evaluator, notifier, messageTemplates, healthRegistry)
+	silentPrompts.Start()
+
+	// Panics from the app, held for the cancellation window and then sent
+	panicService := services.NewPanicService(cfgStore, postgres, redis, evaluator, healthRegistry)
+	panicService.Start()
+
+	// Alerts left unresolved, closed, sent again or flagged once they are old
+	alertReconciler := services.NewAlertReconciler(cfgStore, postgres, evaluator, alertOutbox, auditLogger, jobScheduler)
+	alertReconciler.Start()
+
+	// Alerts of users with auto_escalate_police, handed off to the agency of
+	// the state they're in
+	agencyTargetList, err := services.LoadAgencyTargets(cfg.AgencyTargetsFile)
+	if err != nil {
+		log.Fatalf("Failed to read agency targets: %v", err)
+	}
+	agencyTargets, err := services.NewAgencyTargets(agencyTargetList)
+	if err != nil {
+		log.Fatalf("Failed to load agency targets: %v", err)
+	}
+	cfgStore.OnChange(func(next *config.Config) {
+		targets, err := services.LoadAgencyTargets(next.AgencyTargetsFile)
+		if err == nil {
+			err = agencyTargets.Load(targets)
+		}
+		if err != nil {
+			log.Printf("ERROR: Agency targets not reloaded, keeping current ones: %v", err)
+		}
+	})
+	agencyEscalation := services.NewAgencyEscalation(cfgStore, postgres, agencyTargets, locationEncoder, notifier, channelNotifier, alertOutbox, auditLogger, jobScheduler)
+	agencyEscalation.Start()
+
+	// Guardians' notices of their wards' tracking gaps, smaller than alerts
+	guardianNotices := services.NewGuardianNotices(cfgStore, postgres, notifier, auditLogger, jobScheduler)
+	guardianNotices.Start()
+
+	// The jobs registered so far start running
+	jobScheduler.Start()
+
+	// Contact dashboards of active alerts, opened from alert links
+	unverifiedReports := services.NewUnverifiedReports(cfgStore, postgres, redis, notifier, auditLogger)
+	alertShares := services.NewAlertShareService(cfgStore, postgres, evaluator, locationEncoder, welfareService)
+
+	// Daily summaries, pushed to each active user after their local midnight
+	summaryService := services.NewDailySummaryService(cfgStore, postgres, redis, notifier, smsUsage, healthRegistry)
+	summaryService.Start()
+
+	// Combined updates held back by contacts' pacing, sent when their window ends
+	contactDigests := services.NewContactDigests(redis, notifier, healthRegistry)
+	contactDigests.Start()
+
+	// Evicts cached users, contacts, geofences and templates changed on any instance
+	cacheInvalidations := services.NewCacheInvalidations(postgres, healthRegistry)
+	cacheInvalidations.Start()
+
+	// Organizations: onboarding, default settings and scoring overrides
+	orgService := services.NewOrganizationService(cfgStore, postgres, scoringProfiles, notifier, messageTemplates)
+
+	// Responder API: keyed partner access to unresolved alerts near a location
+	responderService := services.NewResponderService(cfgStore, postgres, redis)
+
+	// Opt-in public status badge, behind a per-user token
+	publicStatus := services.NewPublicStatusService(postgres, redis)
+
+	// The app's home screen, assembled in one request
+	homeViews := services.NewHomeViewService(cfgStore, postgres, redis, evaluator)
+
 	// Initialize handlers
-	heartbeatHandler := handlers.NewHeartbeatHandler(cfg, postgres, redis, evaluator)
-	smsHandler := handlers.NewSMSHandler(cfg, postgres, redis, evaluator)
-	blackboxHandler := handlers.NewBlackboxHandler(cfg, postgres)
-	contactsHandler := handlers.NewContactsHandler(cfg, postgres)
+	healthHandler := handlers.NewHealthHandler(postgres, redis, boot, healthRegistry, auditLogger, signatureGuard, evaluator, staleMonitor, redisWarmup, alertOutbox, shadowEvaluator, alertReconciler, outboundBudget, cfg.TwilioAccountSID != "", fcmClient != nil)
+	heartbeatHandler := handlers.NewHeartbeatHandler(cfgStore, postgres, redis, evaluator, alertOutbox, heartbeatBuffer, spoofDetector, signatureGuard, auditLogger)
+	streamHandler := handlers.NewStreamHandler(cfgStore, postgres, redis, evaluator, heartbeatBuffer, spoofDetector, impactAnalyzer)
+	// Heartbeat streams stay open until told to end, which shutdown waits for
+	srv.RegisterOnShutdown(streamHandler.Close)
+	alertSLO := services.NewAlertSLO(cfgStore, postgres)
+	smsHandler := handlers.NewSMSHandler(cfgStore, postgres, redis, evaluator, smsRouter, spoofDetector, welfareService, silentPrompts, notifier, alertOutbox, smsUsage, alertSLO)
+	ussdHandler := handlers.NewUSSDHandler(cfgStore, postgres, redis, services.NewUSSDService(cfgStore, postgres, evaluator))
+	spendHandler := handlers.NewSpendHandler(outboundBudget, auditLogger)
+	blackboxHandler := handlers.NewBlackboxHandler(cfgStore, postgres, impactAnalyzer, trailMigrator, objectStore, auditLogger)
+	contactsHandler := handlers.NewContactsHandler(cfg, postgres, contactAccess, alertOutbox, protectionWarnings, auditLogger)
+	usersHandler := handlers.NewUsersHandler(cfg, postgres, evaluator, publicStatus, protectionWarnings, consentService, auditLogger)
+	lastGaspHandler := handlers.NewLastGaspHandler(cfg, postgres, auditLogger)
+	broadcastsHandler := handlers.NewBroadcastsHandler(cfg, postgres, broadcastService, auditLogger)
+	alertsHandler := handlers.NewAlertsHandler(cfg, postgres, linkService, autoResolver, auditLogger)
+	linksHandler := handlers.NewLinksHandler(cfg, postgres, linkService, consentService, notifier, auditLogger)
+	scoreHistoryHandler := handlers.NewScoreHistoryHandler(cfg, postgres, auditLogger)
+	contactAccessHandler := handlers.NewContactAccessHandler(cfg, contactAccess, auditLogger)
+	exportHandler := handlers.NewExportHandler(cfg, postgres, redis, auditLogger)
+	protectionHandler := handlers.NewProtectionHandler(cfgStore, postgres, protectionService, auditLogger)
+	watchHandler := handlers.NewWatchHandler(cfgStore, postgres, redis, watchService, auditLogger)
+	activityHandler := handlers.NewActivityHandler(cfgStore, redis)
+	auditHandler := handlers.NewAuditHandler(cfg, postgres, auditLogger)
+	templatesHandler := handlers.NewTemplatesHandler(messageTemplates)
+	channelsHandler := handlers.NewChannelsHandler(postgres, channelNotifier, auditLogger)
+	locationHandler := handlers.NewLocationHandler()
+	welfareHandler := handlers.NewWelfareHandler(postgres, welfareService, auditLogger)
+	orgHandler := handlers.NewOrgHandler(cfg, postgres, orgService, smsUsage, auditLogger)
+	summaryHandler := handlers.NewSummaryHandler(postgres)
+	panicHandler := handlers.NewPanicHandler(postgres, panicService)
+	trackHandler := handlers.NewTrackHandler(alertShares, mapSnapshots, auditLogger)
+	shadowHandler := handlers.NewShadowHandler(cfgStore, postgres, scoringProfiles, shadowEvaluator, auditLogger)
+	simulationHandler := handlers.NewSimulationHandler(cfg, postgres, services.NewSimulator(cfgStore, scoringProfiles), services.NewPointInTimeEvaluator(cfgStore, postgres), objectStore, auditLogger)
+	responderHandler := handlers.NewResponderHandler(postgres, responderService, auditLogger)
+	publicStatusHandler := handlers.NewPublicStatusHandler(publicStatus)
+	sloHandler := handlers.NewSLOHandler(cfgStore, alertSLO, postgres.Caches(), auditLogger)
+	bundlesHandler := handlers.NewBundlesHandler(postgres, incidentBundles, auditLogger)
+	checkInHandler := handlers.NewCheckInHandler(silentPrompts, auditLogger)
+	unverifiedReportsHandler := handlers.NewUnverifiedReportsHandler(unverifiedReports, auditLogger)
+	messagesHandler := handlers.NewMessagesHandler(postgres, services.NewAlertPreviewer(cfgStore, messageTemplates))
+	outagesHandler := handlers.NewOutagesHandler(outageDetector)
+	homeHandler := handlers.NewHomeHandler(postgres, homeViews, auditLogger)
+	consentsHandler := handlers.NewConsentsHandler(postgres, consentService, auditLogger)
+	flagsHandler := handlers.NewFlagsHandler(postgres, auditLogger)
+	jobsHandler := handlers.NewJobsHandler(jobScheduler, auditLogger)
 
 	// Setup Gin router
-	router := setupRouter(heartbeatHandler, smsHandler, blackboxHandler, contactsHandler)
+	router := setupRouter(cfg, healthHandler, heartbeatHandler, streamHandler, smsHandler, blackboxHandler, contactsHandler, usersHandler, lastGaspHandler, broadcastsHandler, alertsHandler, simulationHandler, auditHandler, linksHandler, scoreHistoryHandler, contactAccessHandler, exportHandler, protectionHandler, watchHandler, activityHandler, templatesHandler, channelsHandler, locationHandler, shadowHandler, welfareHandler, orgHandler, summaryHandler, panicHandler, trackHandler, ussdHandler, spendHandler, responderHandler, publicStatusHandler, sloHandler, bundlesHandler, checkInHandler, outagesHandler, homeHandler, consentsHandler, flagsHandler, jobsHandler, unverifiedReportsHandler, messagesHandler, linkService, contactAccess, responderService)
 
-	// Start server
-	srv := &http.Server{
-		Addr:    ":" + cfg.Port,
-		Handler: router,
+	// Development-only inspection of would-be notifications
+	if devNotifier != nil {
+		debugHandler := handlers.NewDebugHandler(devNotifier)
+		router.GET("/debug/notifications", debugHandler.ListNotifications)
+		router.DELETE("/debug/notifications", debugHandler.ClearNotifications)
+	}
+
+	// Start serving the API; with degraded boot the server is already up
+	// and stops answering 503
+	boot.Serve(router)
+	if !cfg.StartupDegradedBoot {
+		startServer(srv, cfg.Port)
+	}
+	log.Println("✓ API ready")
+
+	// Config reload: SIGHUP, and optionally polling the env file
+	configFile := os.Getenv("CONFIG_FILE")
+	if configFile == "" {
+		configFile = ".env"
+	}
+	stopWatch := make(chan struct{})
+	if seconds, err := strconv.Atoi(os.Getenv("CONFIG_WATCH_INTERVAL_SECONDS")); err == nil && seconds > 0 {
+		go cfgStore.Watch(configFile, time.Duration(seconds)*time.Second, stopWatch)
+		log.Printf("✓ Watching %s for config changes every %ds", configFile, seconds)
 	}
 
-	// Graceful shutdown
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
 	go func() {
-		log.Printf("🚀 SafeTrace API server starting on port %s", cfg.Port)
-		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatalf("Server failed: %v", err)
+		for range hup {
+			if err := cfgStore.Reload(configFile); err != nil {
+				log.Printf("ERROR: Config reload from %s failed, keeping current config: %v", configFile, err)
+				continue
+			}
+			log.Printf("INFO: Config reloaded from %s", configFile)
 		}
 	}()
 
@@ -93,55 +453,483 @@ func main() {
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
+	close(stopWatch)
+	signal.Stop(hup)
 
 	log.Println("Shutting down server...")
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
+	// A shutdown that times out leaves connections hanging, not work
+	// queued, so everything below still drains
+	forced := false
 	if err := srv.Shutdown(ctx); err != nil {
-		log.Fatalf("Server forced to shutdown: %v", err)
+		log.Printf("ERROR: Server forced to shutdown: %v", err)
+		forced = true
+	}
+
+	// Flush queued heartbeats before the database connection closes
+	if heartbeatBuffer != nil {
+		heartbeatBuffer.Close()
+		log.Println("Heartbeat buffer drained")
 	}
 
+	// Stop the scheduled jobs, the resumer, the watch, welfare and check-in
+	// monitors and the panic releaser first; they record audit events and
+	// queue evaluations or alerts
+	jobScheduler.Close()
+	redisWarmup.Close()
+	protectionService.Close()
+	watchService.Close()
+	welfareService.Close()
+	silentPrompts.Close()
+	panicService.Close()
+	summaryService.Close()
+
+	impactAnalyzer.Close()
+	log.Println("Impact analysis queue drained")
+	trailMigrator.Close()
+
+	// Evaluations can queue alerts, so they drain before the outbox
+	evaluator.Close()
+	log.Println("Evaluation queue drained")
+	shadowEvaluator.Close()
+	scoringProfiles.Close()
+	alertOutbox.Close()
+	log.Println("Alert outbox drained")
+	contactDigests.Close()
+	locationEncoder.Close()
+
+	auditLogger.Close()
+	log.Println("Audit log drained")
+
+	scoreHistoryPruner.Close()
+	mapSnapshots.Close()
+	incidentBundles.Close()
+	cacheInvalidations.Close()
+
+	if forced {
+		log.Println("Server stopped after a forced shutdown")
+		os.Exit(1)
+	}
 	log.Println("Server stopped gracefully")
 }
 
+// startupInitialBackoff is the wait after the first failed connection
+// attempt at startup; it doubles up to STARTUP_MAX_BACKOFF_SECONDS
+const startupInitialBackoff = time.Second
+
+// keyCensusLimit caps how many keys the startup check looks at
+const keyCensusLimit = 10000
+
+// payloadCensusLimit caps how many values the startup payload check reads
+const payloadCensusLimit = 2000
+
+// keyMigrationGrace is how long old keys are kept once a migration sets them to
+// expire, so instances still running the previous release can finish with them
+const keyMigrationGrace = time.Hour
+
+// Largest request bodies the ingestion endpoints accept, after gzip is
+// inflated. A heartbeat with six neighbor cells is about 600 bytes as JSON; a
+// blackbox point about 300, so a trail may have over 50,000. An unverified
+// report is a phone number and a position, a panic at most a short note, and
+// an SMS webhook one text with the provider's fields.
+const (
+	heartbeatBodyLimit  = 64 << 10
+	blackboxBodyLimit   = 16 << 20
+	unverifiedBodyLimit = 4 << 10
+	noteBodyLimit       = 4 << 10
+	smsWebhookBodyLimit = 64 << 10
+)
+
+// checkRedisKeys warns about keys of other namespaces in this Redis, then
+// migrates or reports this namespace's old-version and unprefixed keys
+func checkRedisKeys(redis *database.RedisDB, cfg *config.Config) {
+	ctx := context.Background()
+	census, err := redis.CensusKeys(ctx, keyCensusLimit)
+	if err != nil {
+		log.Printf("Warning: Failed to check Redis keys: %v", err)
+		return
+	}
+	for namespace, count := range census.Foreign {
+		log.Printf("WARNING: Redis holds %d keys of namespace %q; this instance uses %q. "+
+			"If that is not another deployment sharing this Redis on purpose, REDIS_NAMESPACE or REDIS_URL is wrong.",
+			count, namespace, cfg.RedisNamespace)
+	}
+	if census.Stale == 0 {
+		return
+	}
+
+	if cfg.RedisKeyMigration == database.KeyMigrationOff {
+		log.Printf("Warning: Found %d Redis keys of an older version or without a namespace; "+
+			"set REDIS_KEY_MIGRATION=rewrite or expire to migrate them", census.Stale)
+		return
+	}
+	result, err := redis.MigrateKeys(ctx, cfg.RedisKeyMigration, keyMigrationGrace)
+	if err != nil {
+		log.Printf("Warning: Redis key migration stopped: %v", err)
+	}
+	if result != nil {
+		log.Printf("✓ Migrated Redis keys: %d rewritten, %d set to expire in %v",
+			result.Rewritten, result.Expiring, keyMigrationGrace)
+	}
+}
+
+// checkRedisPayloads reports payloads in Redis written by a newer build, as
+// found during a rolling deploy or after a rollback. This build keeps their
+// unknown fields when it rewrites them, but reads them as its own version.
+func checkRedisPayloads(redis *database.RedisDB) {
+	census, err := redis.CensusPayloads(context.Background(), payloadCensusLimit)
+	if err != nil {
+		log.Printf("Warning: Failed to check Redis payload versions: %v", err)
+		return
+	}
+	if newest := census.Newest(); newest > models.RedisSchemaVersion {
+		log.Printf("WARNING: Redis holds payloads of schema version %d, newer than this build's %d "+
+			"(%d of %d sampled); their unknown fields are kept when this instance rewrites them",
+			newest, models.RedisSchemaVersion, census.Versions[newest], census.Scanned)
+	}
+}
+
+// startServer listens in the background until the server is shut down
+func startServer(srv *http.Server, port string) {
+	go func() {
+		log.Printf("🚀 SafeTrace API server starting on port %s", port)
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatalf("Server failed: %v", err)
+		}
+	}()
+}
+
+// setupBootRouter serves while Postgres and Redis connect, before the API exists
+func setupBootRouter(bootHealth *handlers.BootHealthHandler) *gin.Engine {
+	router := gin.Default()
+	router.Use(middleware.RequestID())
+	router.Use(middleware.ErrorHandler())
+
+	router.NoRoute(bootHealth.Unavailable)
+
+	router.GET("/health", bootHealth.Live)
+	router.GET("/health/live", bootHealth.Live)
+	router.GET("/health/ready", bootHealth.Ready)
+	return router
+}
+
 func setupRouter(
+	cfg *config.Config,
+	healthHandler *handlers.HealthHandler,
 	heartbeatHandler *handlers.HeartbeatHandler,
+	streamHandler *handlers.StreamHandler,
 	smsHandler *handlers.SMSHandler,
 	blackboxHandler *handlers.BlackboxHandler,
 	contactsHandler *handlers.ContactsHandler,
+	usersHandler *handlers.UsersHandler,
+	lastGaspHandler *handlers.LastGaspHandler,
+	broadcastsHandler *handlers.BroadcastsHandler,
+	alertsHandler *handlers.AlertsHandler,
+	simulationHandler *handlers.SimulationHandler,
+	auditHandler *handlers.AuditHandler,
+	linksHandler *handlers.LinksHandler,
+	scoreHistoryHandler *handlers.ScoreHistoryHandler,
+	contactAccessHandler *handlers.ContactAccessHandler,
+	exportHandler *handlers.ExportHandler,
+	protectionHandler *handlers.ProtectionHandler,
+	watchHandler *handlers.WatchHandler,
+	activityHandler *handlers.ActivityHandler,
+	templatesHandler *handlers.TemplatesHandler,
+	channelsHandler *handlers.ChannelsHandler,
+	locationHandler *handlers.LocationHandler,
+	shadowHandler *handlers.ShadowHandler,
+	welfareHandler *handlers.WelfareHandler,
+	orgHandler *handlers.OrgHandler,
+	summaryHandler *handlers.SummaryHandler,
+	panicHandler *handlers.PanicHandler,
+	trackHandler *handlers.TrackHandler,
+	ussdHandler *handlers.USSDHandler,
+	spendHandler *handlers.SpendHandler,
+	responderHandler *handlers.ResponderHandler,
+	publicStatusHandler *handlers.PublicStatusHandler,
+	sloHandler *handlers.SLOHandler,
+	bundlesHandler *handlers.BundlesHandler,
+	checkInHandler *handlers.CheckInHandler,
+	outagesHandler *handlers.OutagesHandler,
+	homeHandler *handlers.HomeHandler,
+	consentsHandler *handlers.ConsentsHandler,
+	flagsHandler *handlers.FlagsHandler,
+	jobsHandler *handlers.JobsHandler,
+	unverifiedReportsHandler *handlers.UnverifiedReportsHandler,
+	messagesHandler *handlers.MessagesHandler,
+	linkService *services.AccountLinkService,
+	contactAccess *services.ContactAccessService,
+	responders *services.ResponderService,
 ) *gin.Engine {
 	router := gin.Default()
+	router.Use(middleware.RequestID())
+	router.Use(middleware.ErrorHandler())
+	router.Use(middleware.FeatureFlags())
 
-	// Health check
-	router.GET("/health", func(c *gin.Context) {
-		c.JSON(200, gin.H{
-			"status":  "ok",
-			"service": "safetrace-api",
-			"time":    time.Now().Format(time.RFC3339),
-		})
+	router.NoRoute(func(c *gin.Context) {
+		middleware.AbortWithError(c, apierror.NotFound("route not found"))
 	})
 
+	// Health checks (/health is kept as an alias of liveness)
+	router.GET("/health", healthHandler.Live)
+	router.GET("/health/live", healthHandler.Live)
+	router.GET("/health/ready", healthHandler.Ready)
+
+	// Time-to-alert and cache metrics for Prometheus, behind METRICS_TOKEN
+	router.GET("/metrics", sloHandler.Metrics)
+
+	// Acknowledgment link sent to trusted contacts
+	router.GET("/ack/:token", alertsHandler.AcknowledgeLink)
+
+	// Map snapshot linked from, and attached to, alert messages
+	router.GET("/map/:token", trackHandler.GetMap)
+
+	// Signed download link of an investigation bundle
+	router.GET("/bundles/:bundle_id", params.UUID(params.Bundle), bundlesHandler.Download)
+
+	// Invitation link sent to new trusted contacts: a page whose form posts
+	// back to issue their access token
+	router.GET("/contact/confirm/:code", contactAccessHandler.ConfirmPage)
+	router.POST("/contact/confirm/:code", contactAccessHandler.Confirm)
+
+	// A contact's own pacing and channels, across every user they protect
+	anyContact := middleware.ContactScope(cfg.JWTSecret, contactAccess, "", "")
+	router.GET("/contact/preferences", anyContact, middleware.RequireAuth(cfg.JWTSecret),
+		middleware.RequireRole(utils.RoleContact), contactAccessHandler.GetPreferences)
+	router.PUT("/contact/preferences", anyContact, middleware.RequireAuth(cfg.JWTSecret),
+		middleware.RequireRole(utils.RoleContact), contactAccessHandler.UpdatePreferences)
+
+	// Public status badge the user embeds on their own site
+	router.GET("/public/status/:token", publicStatusHandler.Get)
+	router.GET("/public/status/:token/badge.svg", publicStatusHandler.Badge)
+
+	// Guardians get read-only access to their ward; only mount on GET routes
+	// and on handlers that check a link permission
+	guardian := middleware.GuardianAccess(linkService, params.User)
+
+	// Contact access tokens are refused everywhere except routes that mount
+	// one of these ahead of the auth middleware
+	readStatus := middleware.ContactScope(cfg.JWTSecret, contactAccess, utils.ScopeReadStatus, params.User)
+	readAlerts := middleware.ContactScope(cfg.JWTSecret, contactAccess, utils.ScopeReadAlerts, params.User)
+	welfareCheck := middleware.ContactScope(cfg.JWTSecret, contactAccess, utils.ScopeWelfareCheck, params.User)
+	// Read routes that answer conditional requests also answer HEAD
+	readMethods := []string{http.MethodGet, http.MethodHead}
+
+	anyScope := middleware.ContactScope(cfg.JWTSecret, contactAccess, "", params.User)
+
 	// API v1 routes
 	v1 := router.Group("/v1")
+	// Routes about one user; the user ID is parsed before anything else runs
+	user := v1.Group("/user/:user_id", params.UUID(params.User))
 	{
+		// Registration
+		v1.POST("/users", usersHandler.Register)
+		user.PUT("/push-token", middleware.RequireAuth(cfg.JWTSecret), usersHandler.RegisterPushToken)
+		user.PATCH("/settings", middleware.RequireAuth(cfg.JWTSecret), usersHandler.UpdateSettings)
+		user.GET("/public-status", middleware.RequireAuth(cfg.JWTSecret), publicStatusHandler.GetToken)
+		user.POST("/public-status/rotate", middleware.RequireAuth(cfg.JWTSecret), publicStatusHandler.Rotate)
+
+		// Consent to the privacy policy, per scope
+		v1.GET("/consent-policies", consentsHandler.GetPolicies)
+		user.POST("/consents", middleware.RequireAuth(cfg.JWTSecret), consentsHandler.UpdateConsents)
+		user.GET("/consents", middleware.RequireAuth(cfg.JWTSecret), consentsHandler.GetConsents)
+		user.GET("/consents/history", middleware.RequireAuth(cfg.JWTSecret), consentsHandler.GetHistory)
+
 		// Heartbeat endpoints
-		v1.POST("/heartbeat", heartbeatHandler.CreateHeartbeat)
-		v1.GET("/user/:id/status", heartbeatHandler.GetUserStatus)
-		v1.POST("/alert/:id/resolve", heartbeatHandler.ResolveAlert)
+		v1.POST("/heartbeat", middleware.BodyLimit(heartbeatBodyLimit), heartbeatHandler.CreateHeartbeat)
+		// Positions from apps that lost their keys, never evaluated; the user confirms or denies them
+		v1.POST("/heartbeat/unverified", middleware.BodyLimit(unverifiedBodyLimit), unverifiedReportsHandler.Submit)
+		user.POST("/unverified-reports/:report_id/answer", params.UUID(params.Report), middleware.RequireAuth(cfg.JWTSecret),
+			unverifiedReportsHandler.Answer)
+		// Locations and sensor samples every few seconds during an alert, over one request
+		v1.POST("/stream/heartbeats", middleware.RequireAuth(cfg.JWTSecret), middleware.RequireRole(utils.RoleUser),
+			streamHandler.StreamHeartbeats)
+		user.Match(readMethods, "/status", readStatus, middleware.RequireAuth(cfg.JWTSecret), guardian,
+			middleware.Conditional(heartbeatHandler.StatusVersion), heartbeatHandler.GetUserStatus)
+		user.GET("/devices", readStatus, middleware.RequireAuth(cfg.JWTSecret), guardian, heartbeatHandler.ListDevices)
+		user.GET("/diagnostics", middleware.RequireAuth(cfg.JWTSecret), heartbeatHandler.GetDiagnostics)
+
+		// The app's home screen: status, location, alert, contacts, protection and check-in in one read
+		user.Match(readMethods, "/home", readStatus, middleware.RequireAuth(cfg.JWTSecret), guardian,
+			middleware.Conditional(homeHandler.HomeVersion), homeHandler.GetHome)
+		v1.POST("/alert/:alert_id/resolve", params.UUID(params.Alert), middleware.RequireAuth(cfg.JWTSecret), heartbeatHandler.ResolveAlert)
+
+		// Alert acknowledgment
+		user.GET("/alerts", readAlerts, middleware.RequireAuth(cfg.JWTSecret), guardian, alertsHandler.ListUserAlerts)
+		v1.GET("/alerts/:alert_id/recipients", params.UUID(params.Alert), middleware.RequireAuth(cfg.JWTSecret), alertsHandler.GetRecipients)
+		v1.POST("/alerts/:alert_id/auto-resolve", params.UUID(params.Alert), middleware.RequireAuth(cfg.JWTSecret), alertsHandler.ConfirmAutoResolve)
+		v1.POST("/voice/ack/:token", alertsHandler.HandleVoiceAck)
+
+		// Investigation bundles of resolved alerts
+		v1.POST("/alerts/:alert_id/bundle", params.UUID(params.Alert), middleware.RequireAuth(cfg.JWTSecret), bundlesHandler.Request)
+		v1.GET("/alerts/:alert_id/bundles/:bundle_id", params.UUID(params.Alert, params.Bundle), middleware.RequireAuth(cfg.JWTSecret), bundlesHandler.Get)
+
+		// Contact dashboard of an active alert, authorized by the link's token
+		v1.GET("/track/:token/page", trackHandler.GetPage)
+		v1.POST("/track/:token/acknowledge", trackHandler.Acknowledge)
+		v1.POST("/track/:token/welfare-check", trackHandler.RequestWelfareCheck)
+		v1.POST("/track/:token/share", trackHandler.Share)
+
+		// Plus codes in alerts, back to coordinates
+		v1.GET("/location/decode", locationHandler.Decode)
+
+		// LastGasp endpoints (user and trusted contacts only)
+		user.GET("/lastgasp", readStatus, middleware.RequireAuth(cfg.JWTSecret), guardian, lastGaspHandler.GetActive)
+		user.GET("/lastgasp/history", readStatus, middleware.RequireAuth(cfg.JWTSecret), guardian, lastGaspHandler.GetHistory)
+		user.GET("/heartbeats.geojson", readStatus, middleware.RequireAuth(cfg.JWTSecret), guardian, exportHandler.ExportTrack)
+		user.GET("/heartbeats", readStatus, middleware.RequireAuth(cfg.JWTSecret), guardian, exportHandler.ExportTrack)
+		user.GET("/score-history", readStatus, middleware.RequireAuth(cfg.JWTSecret), guardian, scoreHistoryHandler.GetScoreHistory)
+		user.GET("/state-history", readStatus, middleware.RequireAuth(cfg.JWTSecret), guardian, scoreHistoryHandler.GetStateHistory)
+
+		// Protection pauses (the user pauses and resumes; contacts and guardians can see them)
+		user.POST("/protection/pause", middleware.RequireAuth(cfg.JWTSecret), protectionHandler.Pause)
+		user.POST("/protection/resume", middleware.RequireAuth(cfg.JWTSecret), protectionHandler.Resume)
+		user.GET("/protection", readStatus, middleware.RequireAuth(cfg.JWTSecret), guardian, protectionHandler.GetProtection)
+
+		// Watch sessions (the user starts, extends and completes them; contacts and guardians can see them)
+		user.POST("/watch", middleware.RequireAuth(cfg.JWTSecret), watchHandler.Start)
+		user.POST("/watch/complete", middleware.RequireAuth(cfg.JWTSecret), watchHandler.Complete)
+		user.GET("/watch", readStatus, middleware.RequireAuth(cfg.JWTSecret), guardian, watchHandler.GetWatch)
+		user.POST("/activity", middleware.RequireAuth(cfg.JWTSecret), activityHandler.RecordActivity)
+
+		// Welfare checks (contacts and guardians request them; only the user answers and lists them)
+		user.POST("/welfare-check", welfareCheck, middleware.RequireAuth(cfg.JWTSecret), guardian, welfareHandler.RequestCheck)
+		user.POST("/welfare-check/confirm", middleware.RequireAuth(cfg.JWTSecret), welfareHandler.ConfirmCheck)
+		user.GET("/welfare-checks", middleware.RequireAuth(cfg.JWTSecret), welfareHandler.ListChecks)
+		user.GET("/summaries/:date", middleware.RequireAuth(cfg.JWTSecret), summaryHandler.GetSummary)
+
+		// Check-in prompts at CAUTION (only the user answers them)
+		user.POST("/check-in", middleware.RequireAuth(cfg.JWTSecret), checkInHandler.CheckIn)
+
+		// Panics from the app, with a cancellation window
+		user.POST("/panic", middleware.BodyLimit(noteBodyLimit), middleware.RequireAuth(cfg.JWTSecret), panicHandler.Trigger)
+		user.PUT("/panic-pin", middleware.RequireAuth(cfg.JWTSecret), panicHandler.SetPIN)
+		v1.POST("/panic/:panic_id/cancel", params.UUID(params.Panic), middleware.RequireAuth(cfg.JWTSecret), panicHandler.Cancel)
+		v1.POST("/panic/:panic_id/confirm", params.UUID(params.Panic), middleware.RequireAuth(cfg.JWTSecret), panicHandler.Confirm)
+		// How the alert reads with a note, before the user relies on it
+		v1.POST("/messages/preview", middleware.BodyLimit(noteBodyLimit), middleware.RequireAuth(cfg.JWTSecret),
+			middleware.RequireRole(utils.RoleUser), messagesHandler.Preview)
+
+		user.POST("/contact-token/renew", anyScope, middleware.RequireAuth(cfg.JWTSecret), contactAccessHandler.Renew)
 
 		// SMS webhook
-		v1.POST("/sms/webhook", smsHandler.HandleIncomingSMS)
+		// Twilio's, from before other providers' texts were taken
+		v1.POST("/sms/webhook", middleware.BodyLimit(smsWebhookBodyLimit), smsHandler.HandleIncomingSMS)
+		v1.POST("/sms/webhook/:provider", middleware.BodyLimit(smsWebhookBodyLimit), smsHandler.HandleIncomingSMS)
+		v1.POST("/sms/status/:provider", smsHandler.HandleDeliveryStatus)
+
+		// USSD gateway, for feature phones
+		v1.POST("/ussd/callback", ussdHandler.HandleCallback)
 
 		// Blackbox endpoints
-		v1.POST("/blackbox/upload", blackboxHandler.UploadTrail)
-		v1.GET("/blackbox/trails/:user_id", blackboxHandler.GetUserTrails)
+		v1.POST("/blackbox/upload", middleware.BodyLimit(blackboxBodyLimit), blackboxHandler.UploadTrail)
+		user.Match(readMethods, "/blackbox/trails", readStatus, middleware.RequireAuth(cfg.JWTSecret), guardian,
+			middleware.Conditional(blackboxHandler.TrailsVersion), blackboxHandler.GetUserTrails)
+		// Deprecated alias of /v1/user/:user_id/blackbox/trails, kept for one release
+		v1.GET("/blackbox/trails/:user_id", middleware.Deprecated("/v1/user/:user_id/blackbox/trails"),
+			params.UUID(params.User), readStatus, middleware.RequireAuth(cfg.JWTSecret), guardian,
+			middleware.Conditional(blackboxHandler.TrailsVersion), blackboxHandler.GetUserTrails)
 
 		// Contact management endpoints
-		v1.GET("/user/:id/contacts", contactsHandler.GetContacts)
-		v1.POST("/user/:id/contacts", contactsHandler.AddContact)
-		v1.PUT("/user/:id/contacts/:contactId", contactsHandler.UpdateContact)
-		v1.DELETE("/user/:id/contacts/:contactId", contactsHandler.DeleteContact)
+		user.GET("/contacts", middleware.RequireAuth(cfg.JWTSecret), contactsHandler.GetContacts)
+		user.POST("/contacts", middleware.RequireAuth(cfg.JWTSecret), contactsHandler.AddContact)
+		user.POST("/contacts/bulk", middleware.RequireAuth(cfg.JWTSecret), contactsHandler.BulkAddContacts)
+		user.PUT("/contacts/:contact_id", params.UUID(params.Contact), middleware.RequireAuth(cfg.JWTSecret), contactsHandler.UpdateContact)
+		user.DELETE("/contacts/:contact_id", params.UUID(params.Contact), middleware.RequireAuth(cfg.JWTSecret), contactsHandler.DeleteContact)
+		user.POST("/contacts/:contact_id/restore", params.UUID(params.Contact), middleware.RequireAuth(cfg.JWTSecret), contactsHandler.RestoreContact)
+
+		// Guardian links (guardian requests, ward consents, either side revokes)
+		v1.POST("/links", middleware.RequireAuth(cfg.JWTSecret), linksHandler.RequestLink)
+		v1.GET("/links", middleware.RequireAuth(cfg.JWTSecret), linksHandler.ListLinks)
+		v1.POST("/links/:link_id/accept", params.UUID(params.Link), middleware.RequireAuth(cfg.JWTSecret), linksHandler.AcceptLink)
+		v1.POST("/links/:link_id/revoke", params.UUID(params.Link), middleware.RequireAuth(cfg.JWTSecret), linksHandler.RevokeLink)
+		// Guardian notices of tracking gaps (guardian sets them, either side reads or removes them)
+		v1.GET("/links/:link_id/rules", params.UUID(params.Link), middleware.RequireAuth(cfg.JWTSecret), linksHandler.GetRules)
+		v1.PUT("/links/:link_id/rules", params.UUID(params.Link), middleware.RequireAuth(cfg.JWTSecret), linksHandler.PutRules)
+		v1.DELETE("/links/:link_id/rules", params.UUID(params.Link), middleware.RequireAuth(cfg.JWTSecret), linksHandler.DeleteRules)
+		user.POST("/tracking", middleware.RequireAuth(cfg.JWTSecret), guardian, linksHandler.SetTracking)
+
+		// Slack, Teams and webhook channels that receive the user's alerts
+		user.GET("/channels", middleware.RequireAuth(cfg.JWTSecret), channelsHandler.ListChannels)
+		user.POST("/channels", middleware.RequireAuth(cfg.JWTSecret), channelsHandler.CreateChannel)
+		user.PATCH("/channels/:channel_id", params.UUID(params.Channel), middleware.RequireAuth(cfg.JWTSecret), channelsHandler.UpdateChannel)
+		user.DELETE("/channels/:channel_id", params.UUID(params.Channel), middleware.RequireAuth(cfg.JWTSecret), channelsHandler.DeleteChannel)
+		user.POST("/channels/:channel_id/test", params.UUID(params.Channel), middleware.RequireAuth(cfg.JWTSecret), channelsHandler.TestChannel)
+
+		// Audit trail of who accessed the user's data
+		user.GET("/audit", middleware.RequireAuth(cfg.JWTSecret), auditHandler.GetUserAudit)
+
+		// Organization membership (the user accepts invitations and leaves)
+		user.GET("/org", middleware.RequireAuth(cfg.JWTSecret), orgHandler.GetUserOrg)
+		user.POST("/org/invitations/:invitation_id/accept", params.UUID(params.Invitation), middleware.RequireAuth(cfg.JWTSecret), orgHandler.AcceptInvitation)
+		user.DELETE("/org", middleware.RequireAuth(cfg.JWTSecret), orgHandler.LeaveOrg)
+	}
+
+	// Operator endpoints (admin tokens only)
+	admin := router.Group("/admin", middleware.RequireAuth(cfg.JWTSecret), middleware.RequireRole(utils.RoleAdmin))
+	{
+		admin.POST("/broadcasts", broadcastsHandler.CreateBroadcast)
+		admin.GET("/broadcasts/:broadcast_id", params.UUID(params.Broadcast), broadcastsHandler.GetBroadcast)
+		admin.POST("/broadcasts/:broadcast_id/abort", params.UUID(params.Broadcast), broadcastsHandler.AbortBroadcast)
+		admin.POST("/simulate", simulationHandler.Simulate)
+		admin.POST("/users/:user_id/evaluate-at", params.UUID(params.User), simulationHandler.EvaluateAt)
+		admin.POST("/blackbox/migrate", blackboxHandler.MigrateTrails)
+		admin.GET("/blackbox/migrate", blackboxHandler.GetTrailMigration)
+		admin.GET("/templates", templatesHandler.ListTemplates)
+		admin.POST("/templates/preview", templatesHandler.PreviewTemplate)
+		admin.GET("/shadow/candidate", shadowHandler.GetCandidate)
+		admin.PUT("/shadow/candidate", shadowHandler.SetCandidate)
+		admin.DELETE("/shadow/candidate", shadowHandler.ClearCandidate)
+		admin.POST("/shadow/promote", shadowHandler.Promote)
+		admin.GET("/shadow/diff", shadowHandler.GetDiff)
+		admin.POST("/orgs", orgHandler.CreateOrg)
+		admin.GET("/orgs", orgHandler.ListOrgs)
+		admin.POST("/orgs/:org_id/admin-tokens", params.UUID(params.Org), orgHandler.IssueAdminToken)
+		admin.GET("/spend", spendHandler.GetSpend)
+		admin.PUT("/spend/limit", spendHandler.RaiseLimit)
+		admin.DELETE("/spend/limit", spendHandler.ResetLimit)
+		admin.POST("/responder-keys", responderHandler.IssueKey)
+		admin.GET("/responder-keys", responderHandler.ListKeys)
+		admin.DELETE("/responder-keys/:key_id", params.UUID(params.Responder), responderHandler.RevokeKey)
+		admin.GET("/slo", sloHandler.GetSLO)
+		admin.GET("/check-ins", checkInHandler.GetStats)
+		admin.GET("/outages", outagesHandler.ListOutages)
+		admin.GET("/alerts/reconciliation", alertsHandler.GetReconciliation)
+		admin.GET("/alerts/handoffs", alertsHandler.GetHandoffs)
+		admin.GET("/users/:user_id/flags", params.UUID(params.User), flagsHandler.GetFlags)
+		admin.POST("/users/:user_id/flags", params.UUID(params.User), flagsHandler.SetFlag)
+		admin.GET("/jobs", jobsHandler.ListJobs)
+		admin.POST("/jobs/:name/run-now", jobsHandler.RunNow)
+	}
+
+	// Reporting and member management, open to org admins too; they only
+	// see their own organization and its members
+	orgAdmin := router.Group("/admin", middleware.RequireAuth(cfg.JWTSecret), middleware.RequireRole(utils.RoleAdmin, utils.RoleOrgAdmin))
+	{
+		orgAdmin.GET("/stats", orgHandler.GetStats)
+		orgAdmin.GET("/alerts/active", orgHandler.ListActiveAlerts)
+		orgAdmin.GET("/audit", auditHandler.ListAudit)
+		orgAdmin.GET("/export/alerts.geojson", exportHandler.ExportAlerts)
+		orgAdmin.GET("/export/alerts", exportHandler.ExportAlerts)
+		orgAdmin.GET("/heatmap", exportHandler.ExportHeatmap)
+		orgAdmin.GET("/heatmap.geojson", exportHandler.ExportHeatmap)
+		orgAdmin.GET("/heatmap.csv", exportHandler.ExportHeatmap)
+		orgAdmin.DELETE("/users/:user_id/signature-lockout", params.UUID(params.User), heartbeatHandler.ClearSignatureLockout)
+		orgAdmin.GET("/orgs/:org_id", params.UUID(params.Org), orgHandler.GetOrg)
+		orgAdmin.PUT("/orgs/:org_id", params.UUID(params.Org), orgHandler.UpdateOrg)
+		orgAdmin.GET("/orgs/:org_id/members", params.UUID(params.Org), orgHandler.ListMembers)
+		orgAdmin.DELETE("/orgs/:org_id/members/:user_id", params.UUID(params.Org, params.User), orgHandler.RemoveMember)
+		orgAdmin.POST("/orgs/:org_id/invitations", params.UUID(params.Org), orgHandler.Invite)
+		orgAdmin.GET("/orgs/:org_id/invitations", params.UUID(params.Org), orgHandler.ListInvitations)
+	}
+
+	// Responder API (responder keys, not user tokens; rate limited per key)
+	responder := router.Group("/responder", middleware.RequireResponderKey(responders))
+	{
+		responder.GET("/alerts", responderHandler.ListAlerts)
+		responder.POST("/ack/:token", responderHandler.Acknowledge)
 	}
 
 	return router
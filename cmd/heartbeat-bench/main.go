@@ -0,0 +1,166 @@
+// Command heartbeat-bench drives signed heartbeats at a running API server and
+// reports throughput and latency percentiles. Run it once against a server
+// started with HEARTBEAT_BUFFER_ENABLED=true and once with =false to compare
+// the buffered and synchronous write paths.
+//
+// The per-user rate limit allows one heartbeat every 30s, so supply at least
+// rate*30 user IDs (one UUID per line) to avoid measuring 429s.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/utils"
+)
+
+func main() {
+	baseURL := flag.String("url", "http://localhost:8080", "API base URL")
+	usersFile := flag.String("users", "", "file with one user UUID per line")
+	secret := flag.String("secret", os.Getenv("HMAC_SECRET"), "HMAC secret (defaults to $HMAC_SECRET)")
+	concurrency := flag.Int("c", 50, "concurrent workers")
+	duration := flag.Duration("d", 30*time.Second, "test duration")
+	flag.Parse()
+
+	if *usersFile == "" || *secret == "" {
+		log.Fatal("-users and -secret (or HMAC_SECRET) are required")
+	}
+
+	userIDs, err := readLines(*usersFile)
+	if err != nil {
+		log.Fatalf("Failed to read users: %v", err)
+	}
+	if len(userIDs) == 0 {
+		log.Fatal("users file is empty")
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	endpoint := strings.TrimRight(*baseURL, "/") + "/v1/heartbeat"
+
+	var (
+		next      int64
+		mu        sync.Mutex
+		latencies []time.Duration
+		statuses  = make(map[int]int)
+		failures  int64
+	)
+
+	deadline := time.Now().Add(*duration)
+	var wg sync.WaitGroup
+	for w := 0; w < *concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for time.Now().Before(deadline) {
+				i := atomic.AddInt64(&next, 1)
+				userID := userIDs[int(i)%len(userIDs)]
+
+				body, err := buildHeartbeat(userID, *secret)
+				if err != nil {
+					atomic.AddInt64(&failures, 1)
+					continue
+				}
+
+				start := time.Now()
+				resp, err := client.Post(endpoint, "application/json", bytes.NewReader(body))
+				elapsed := time.Since(start)
+				if err != nil {
+					atomic.AddInt64(&failures, 1)
+					continue
+				}
+				resp.Body.Close()
+
+				mu.Lock()
+				latencies = append(latencies, elapsed)
+				statuses[resp.StatusCode]++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+
+	fmt.Printf("requests:    %d (%d transport errors)\n", len(latencies), failures)
+	fmt.Printf("throughput:  %.1f req/s\n", float64(len(latencies))/duration.Seconds())
+	fmt.Printf("p50 latency: %v\n", percentile(latencies, 0.50))
+	fmt.Printf("p99 latency: %v\n", percentile(latencies, 0.99))
+	for code, count := range statuses {
+		fmt.Printf("status %d:  %d\n", code, count)
+	}
+}
+
+// buildHeartbeat produces a request body signed the same way the mobile client signs it
+func buildHeartbeat(userID, secret string) ([]byte, error) {
+	now := time.Now().UTC().Truncate(time.Second)
+	battery := 80
+	speed := 0.0
+	cellInfo := models.CellInfo{MCC: 621, MNC: 20, CID: 12345, LAC: 678, RSSI: -75, NetworkType: "4G"}
+
+	uid, err := uuid.Parse(userID)
+	if err != nil {
+		return nil, err
+	}
+	signature := utils.SignString(utils.CanonicalHeartbeatString(&models.Heartbeat{
+		UserID:     uid,
+		Timestamp:  now,
+		Lat:        6.5244,
+		Lng:        3.3792,
+		AccuracyM:  20,
+		CellInfo:   cellInfo,
+		BatteryPct: &battery,
+		Speed:      &speed,
+	}), secret)
+
+	return json.Marshal(map[string]interface{}{
+		"user_id":     userID,
+		"timestamp":   now,
+		"lat":         6.5244,
+		"lng":         3.3792,
+		"accuracy_m":  20,
+		"cell_info":   cellInfo,
+		"battery_pct": battery,
+		"speed":       speed,
+		"last_gasp":   false,
+		"signature":   signature,
+	})
+}
+
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	idx := int(float64(len(sorted)-1) * p)
+	return sorted[idx]
+}
+
+func readLines(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines, scanner.Err()
+}
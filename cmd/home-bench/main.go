@@ -0,0 +1,143 @@
+// Command home-bench compares loading the app's home screen from GET /home
+// with loading it the way the app did before, from six reads made one after
+// another, over a connection with a simulated round-trip time. A revalidation
+// of /home with its ETag is measured too.
+//
+// The RTT is added to every request on top of the real one, so run it against
+// a server close by: a local server, or staging from inside its network.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// sequentialPaths are the reads the home screen was assembled from before
+// GET /home, relative to /v1/user/:user_id
+var sequentialPaths = []string{
+	"/status",
+	"/alerts",
+	"/contacts",
+	"/protection",
+	"/watch",
+	"/welfare-checks",
+}
+
+func main() {
+	baseURL := flag.String("url", "http://localhost:8080", "API base URL")
+	userID := flag.String("user", "", "user UUID to load the home screen of")
+	token := flag.String("token", "", "the user's access token")
+	rtt := flag.Duration("rtt", 400*time.Millisecond, "simulated round-trip time added to every request")
+	rounds := flag.Int("n", 20, "rounds of each way")
+	flag.Parse()
+
+	if *userID == "" || *token == "" {
+		log.Fatal("-user and -token are required")
+	}
+
+	client := &http.Client{
+		Timeout:   30 * time.Second,
+		Transport: &delayTransport{rtt: *rtt, next: http.DefaultTransport},
+	}
+	userURL := strings.TrimRight(*baseURL, "/") + "/v1/user/" + *userID
+
+	get := func(path, etag string) (int, string, int, error) {
+		req, err := http.NewRequest(http.MethodGet, userURL+path, nil)
+		if err != nil {
+			return 0, "", 0, err
+		}
+		req.Header.Set("Authorization", "Bearer "+*token)
+		if etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return 0, "", 0, err
+		}
+		defer resp.Body.Close()
+		n, err := io.Copy(io.Discard, resp.Body)
+		return resp.StatusCode, resp.Header.Get("ETag"), int(n), err
+	}
+
+	var sequential, home, revalidate []time.Duration
+	var sequentialBytes, homeBytes int
+	for i := 0; i < *rounds; i++ {
+		start := time.Now()
+		total := 0
+		for _, path := range sequentialPaths {
+			status, _, n, err := get(path, "")
+			if err != nil {
+				log.Fatalf("GET %s: %v", path, err)
+			}
+			if status != http.StatusOK {
+				log.Fatalf("GET %s: status %d", path, status)
+			}
+			total += n
+		}
+		sequential = append(sequential, time.Since(start))
+		sequentialBytes = total
+
+		start = time.Now()
+		status, etag, n, err := get("/home", "")
+		if err != nil {
+			log.Fatalf("GET /home: %v", err)
+		}
+		if status != http.StatusOK {
+			log.Fatalf("GET /home: status %d", status)
+		}
+		home = append(home, time.Since(start))
+		homeBytes = n
+
+		start = time.Now()
+		status, _, _, err = get("/home", etag)
+		if err != nil {
+			log.Fatalf("GET /home revalidation: %v", err)
+		}
+		if status == http.StatusNotModified {
+			revalidate = append(revalidate, time.Since(start))
+		}
+	}
+
+	fmt.Printf("simulated RTT: %v, %d rounds\n", *rtt, *rounds)
+	report(fmt.Sprintf("sequential (%d reads, %d bytes)", len(sequentialPaths), sequentialBytes), sequential)
+	report(fmt.Sprintf("GET /home (%d bytes)", homeBytes), home)
+	report("GET /home, 304", revalidate)
+	if p50 := percentile(home, 0.50); p50 > 0 {
+		fmt.Printf("speedup at p50: %.1fx\n", float64(percentile(sequential, 0.50))/float64(p50))
+	}
+}
+
+// delayTransport adds a round trip to every request, standing in for a slow
+// mobile connection
+type delayTransport struct {
+	rtt  time.Duration
+	next http.RoundTripper
+}
+
+func (t *delayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	time.Sleep(t.rtt)
+	return t.next.RoundTrip(req)
+}
+
+func report(name string, latencies []time.Duration) {
+	if len(latencies) == 0 {
+		fmt.Printf("%-40s no responses\n", name+":")
+		return
+	}
+	fmt.Printf("%-40s p50 %v  p95 %v\n", name+":", percentile(latencies, 0.50), percentile(latencies, 0.95))
+}
+
+// percentile sorts latencies in place and returns the p-th
+func percentile(latencies []time.Duration, p float64) time.Duration {
+	if len(latencies) == 0 {
+		return 0
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	return latencies[int(float64(len(latencies)-1)*p)]
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/utils"
+)
+
+// Client talks to the target API and records every request in stats
+type Client struct {
+	baseURL string
+	secret  string
+	sigV    int
+	http    *http.Client
+	stats   *Stats
+}
+
+func NewClient(baseURL, secret string, sigV int, stats *Stats) *Client {
+	return &Client{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		secret:  secret,
+		sigV:    sigV,
+		http:    &http.Client{Timeout: 10 * time.Second},
+		stats:   stats,
+	}
+}
+
+// do sends body (nil for none) and records the outcome under name. The
+// response body is returned for 2xx answers only.
+func (c *Client) do(ctx context.Context, name, method, path, token string, body []byte) (int, []byte) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, reader)
+	if err != nil {
+		c.stats.Record(name, 0, 0)
+		return 0, nil
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	start := time.Now()
+	resp, err := c.http.Do(req)
+	elapsed := time.Since(start)
+	if err != nil {
+		// A run that ends mid-request is not the server's failure
+		if ctx.Err() == nil {
+			c.stats.Record(name, 0, elapsed)
+		}
+		return 0, nil
+	}
+	defer resp.Body.Close()
+	respBody, _ := io.ReadAll(resp.Body)
+	c.stats.Record(name, resp.StatusCode, elapsed)
+
+	if resp.StatusCode >= 300 {
+		return resp.StatusCode, nil
+	}
+	return resp.StatusCode, respBody
+}
+
+// Register signs up a user with a random Nigerian number, retrying a few
+// times when the number is taken
+func (c *Client) Register(ctx context.Context, name string, phone func() string) (uuid.UUID, string, error) {
+	for attempt := 0; attempt < 3; attempt++ {
+		body, _ := json.Marshal(map[string]string{"name": name, "phone": phone()})
+		status, resp := c.do(ctx, "POST /v1/users", http.MethodPost, "/v1/users", "", body)
+		if status == http.StatusConflict {
+			continue
+		}
+		if resp == nil {
+			return uuid.Nil, "", fmt.Errorf("registration answered %d", status)
+		}
+
+		var registered struct {
+			User        models.User `json:"user"`
+			AccessToken string      `json:"access_token"`
+		}
+		if err := json.Unmarshal(resp, &registered); err != nil {
+			return uuid.Nil, "", fmt.Errorf("unexpected registration response: %w", err)
+		}
+		return registered.User.ID, registered.AccessToken, nil
+	}
+	return uuid.Nil, "", fmt.Errorf("no free phone number after 3 attempts")
+}
+
+// HeartbeatBody signs hb the way the app does, with the configured signature
+// version, and encodes the request
+func (c *Client) HeartbeatBody(hb *models.Heartbeat) []byte {
+	req := map[string]interface{}{
+		"user_id":     hb.UserID.String(),
+		"timestamp":   hb.Timestamp,
+		"lat":         hb.Lat,
+		"lng":         hb.Lng,
+		"accuracy_m":  hb.AccuracyM,
+		"cell_info":   hb.CellInfo,
+		"battery_pct": hb.BatteryPct,
+		"speed":       hb.Speed,
+		"last_gasp":   hb.LastGasp,
+		"device_id":   hb.DeviceID,
+		"source":      "http",
+	}
+	if hb.Connectivity != "" {
+		req["connectivity"] = hb.Connectivity
+	}
+
+	if c.sigV == 2 {
+		nonce := newNonce()
+		req["sig_v"] = 2
+		req["nonce"] = nonce
+		req["signature"] = utils.SignString(utils.CanonicalHeartbeatStringV2(hb, "http", nonce), c.secret)
+	} else {
+		req["signature"] = utils.SignString(utils.CanonicalHeartbeatString(hb), c.secret)
+	}
+
+	body, _ := json.Marshal(req)
+	return body
+}
+
+// SendHeartbeat posts a heartbeat body, recorded under the given label
+func (c *Client) SendHeartbeat(ctx context.Context, label string, body []byte) int {
+	name := "POST /v1/heartbeat"
+	if label != "" {
+		name += " [" + label + "]"
+	}
+	status, _ := c.do(ctx, name, http.MethodPost, "/v1/heartbeat", "", body)
+	return status
+}
+
+func newNonce() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
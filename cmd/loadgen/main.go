@@ -0,0 +1,144 @@
+// Command loadgen simulates app users against a staging environment and
+// reports latency percentiles and error rates per endpoint. It registers
+// fresh users, then each one sends signed heartbeats while moving between
+// random waypoints in a bounding box, with a draining battery and occasional
+// dead zones (a LastGasp, then readings queued offline and uploaded later).
+// Users now and then press the panic button or start and complete a watch.
+//
+// With -chaos, a share of heartbeats is replaced by malformed payloads or
+// replays, and some queued uploads arrive newest first, to exercise the
+// validation paths. Those requests are reported separately.
+//
+// Payloads are built from the server's models and signed with its utils, so
+// the generator follows format changes. Run it against staging with
+// NOTIFIER=dev: every user raises real alerts and panics.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"os/signal"
+	"sync"
+	"time"
+)
+
+func main() {
+	baseURL := flag.String("url", "http://localhost:8080", "API base URL")
+	secret := flag.String("secret", os.Getenv("HMAC_SECRET"), "HMAC secret (defaults to $HMAC_SECRET)")
+	users := flag.Int("users", 100, "simulated users to register")
+	interval := flag.Duration("interval", time.Minute, "heartbeat interval per user; at least 30s, the per-user rate limit")
+	duration := flag.Duration("d", 5*time.Minute, "test duration, after registration")
+	bbox := flag.String("bbox", "6.40,3.25,6.70,3.60", "bounding box users move in: minLat,minLng,maxLat,maxLng (default Lagos)")
+	sigV := flag.Int("sig-v", 2, "heartbeat signature version, 1 or 2")
+	deadZone := flag.Float64("deadzone-rate", 0.01, "chance per heartbeat that a user enters a dead zone")
+	panicRate := flag.Float64("panic-rate", 0.0005, "chance per heartbeat that a user presses the panic button")
+	checkIn := flag.Float64("checkin-rate", 0.005, "chance per heartbeat that a user starts or completes a watch")
+	chaos := flag.Float64("chaos", 0, "share of heartbeats replaced by malformed payloads and replays; 0 disables chaos")
+	concurrency := flag.Int("c", 20, "concurrent registrations")
+	seed := flag.Int64("seed", time.Now().UnixNano(), "random seed")
+	flag.Parse()
+
+	if *secret == "" {
+		log.Fatal("-secret (or HMAC_SECRET) is required")
+	}
+	if *users <= 0 || *concurrency <= 0 {
+		log.Fatal("-users and -c must be positive")
+	}
+	if *sigV != 1 && *sigV != 2 {
+		log.Fatal("-sig-v must be 1 or 2")
+	}
+	if *interval < 30*time.Second {
+		log.Printf("WARN: an interval under 30s will mostly measure rate-limit 429s")
+	}
+	box, err := ParseBoundingBox(*bbox)
+	if err != nil {
+		log.Fatal(err)
+	}
+	rates := Rates{DeadZone: *deadZone, Panic: *panicRate, CheckIn: *checkIn, Chaos: *chaos}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	stats := NewStats()
+	client := NewClient(*baseURL, *secret, *sigV, stats)
+	rng := rand.New(rand.NewSource(*seed))
+
+	log.Printf("INFO: Registering %d users (seed %d)", *users, *seed)
+	sims := register(ctx, client, *users, *concurrency, rng)
+	log.Printf("INFO: Registered %d of %d users", len(sims), *users)
+	if len(sims) == 0 {
+		stats.Report(os.Stdout, time.Second)
+		os.Exit(1)
+	}
+
+	runCtx, cancel := context.WithTimeout(ctx, *duration)
+	defer cancel()
+
+	log.Printf("INFO: Running for %v, one heartbeat per user every %v", *duration, *interval)
+	start := time.Now()
+	var wg sync.WaitGroup
+	for _, sim := range sims {
+		sim.rates = rates
+		sim.interval = *interval
+		sim.rng = rand.New(rand.NewSource(rng.Int63()))
+		sim.mover = NewMover(box, sim.rng)
+
+		wg.Add(1)
+		go func(sim *SimUser) {
+			defer wg.Done()
+			sim.Run(runCtx)
+		}(sim)
+	}
+	wg.Wait()
+
+	fmt.Println()
+	stats.Report(os.Stdout, time.Since(start))
+}
+
+// register signs up n users, concurrency at a time. Users that fail to
+// register are left out of the run; the failures show in the report.
+func register(ctx context.Context, client *Client, n, concurrency int, rng *rand.Rand) []*SimUser {
+	var (
+		mu   sync.Mutex
+		sims []*SimUser
+	)
+	phone := func() string {
+		mu.Lock()
+		defer mu.Unlock()
+		return fmt.Sprintf("+23490%08d", rng.Intn(100_000_000))
+	}
+
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				id, token, err := client.Register(ctx, fmt.Sprintf("loadgen-%d", i), phone)
+				if err != nil {
+					log.Printf("WARN: Failed to register user %d: %v", i, err)
+					continue
+				}
+				mu.Lock()
+				sims = append(sims, &SimUser{
+					ID:       id,
+					Token:    token,
+					DeviceID: fmt.Sprintf("loadgen-%d", i),
+					client:   client,
+				})
+				mu.Unlock()
+			}
+		}()
+	}
+	for i := 0; i < n && ctx.Err() == nil; i++ {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+	return sims
+}
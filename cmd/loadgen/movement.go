@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+)
+
+// metersPerDegree is the length of a degree of latitude, and of longitude at
+// the equator; close enough for moving simulated users around a city
+const metersPerDegree = 111_320.0
+
+// BoundingBox is the area simulated users move within
+type BoundingBox struct {
+	MinLat, MinLng, MaxLat, MaxLng float64
+}
+
+// ParseBoundingBox reads "minLat,minLng,maxLat,maxLng"
+func ParseBoundingBox(s string) (BoundingBox, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 4 {
+		return BoundingBox{}, fmt.Errorf("bounding box must be minLat,minLng,maxLat,maxLng")
+	}
+	var v [4]float64
+	for i, p := range parts {
+		f, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+		if err != nil {
+			return BoundingBox{}, fmt.Errorf("bounding box: %w", err)
+		}
+		v[i] = f
+	}
+	box := BoundingBox{MinLat: v[0], MinLng: v[1], MaxLat: v[2], MaxLng: v[3]}
+	if box.MinLat >= box.MaxLat || box.MinLng >= box.MaxLng {
+		return BoundingBox{}, fmt.Errorf("bounding box minimums must be below its maximums")
+	}
+	return box, nil
+}
+
+func (b BoundingBox) randomPoint(rng *rand.Rand) (float64, float64) {
+	return b.MinLat + rng.Float64()*(b.MaxLat-b.MinLat),
+		b.MinLng + rng.Float64()*(b.MaxLng-b.MinLng)
+}
+
+// Mover walks a user between random waypoints in the box at a speed picked
+// for each leg, from walking pace up to city traffic. It also drains the
+// battery, recharging it when it runs low, and models the radio: signal
+// strength drifts, and in a dead zone it fades out entirely.
+type Mover struct {
+	box      BoundingBox
+	rng      *rand.Rand
+	lat, lng float64
+	toLat    float64
+	toLng    float64
+	speed    float64 // m/s on the current leg
+	battery  float64
+	rssi     int
+	cellID   models.CellIdentifier
+}
+
+func NewMover(box BoundingBox, rng *rand.Rand) *Mover {
+	m := &Mover{
+		box:     box,
+		rng:     rng,
+		battery: 40 + rng.Float64()*60,
+		rssi:    -70 - rng.Intn(25),
+	}
+	m.lat, m.lng = box.randomPoint(rng)
+	m.nextLeg()
+	return m
+}
+
+func (m *Mover) nextLeg() {
+	m.toLat, m.toLng = m.box.randomPoint(m.rng)
+	m.speed = 1 + m.rng.Float64()*14
+}
+
+// Step moves the user on by dt, picking a new waypoint on arrival
+func (m *Mover) Step(dt time.Duration) {
+	dLat := (m.toLat - m.lat) * metersPerDegree
+	dLng := (m.toLng - m.lng) * metersPerDegree * math.Cos(m.lat*math.Pi/180)
+	remaining := math.Hypot(dLat, dLng)
+	travel := m.speed * dt.Seconds()
+	if travel >= remaining {
+		m.lat, m.lng = m.toLat, m.toLng
+		m.nextLeg()
+	} else {
+		m.lat += (m.toLat - m.lat) * travel / remaining
+		m.lng += (m.toLng - m.lng) * travel / remaining
+	}
+
+	// Roughly a day on a charge, faster on the move
+	m.battery -= dt.Hours() * (3 + m.speed/3)
+	if m.battery < 5 {
+		m.battery = 100
+	}
+
+	m.rssi += m.rng.Intn(7) - 3
+	m.rssi = max(-110, min(-55, m.rssi))
+	m.cellID = models.CellIdentifier(int64(m.lat*1000)*100_000 + int64(m.lng*1000))
+}
+
+// FadeSignal weakens the signal towards the edge of a dead zone
+func (m *Mover) FadeSignal() {
+	m.rssi = -105 - m.rng.Intn(10)
+}
+
+// Heartbeat is the user's current reading, unsigned
+func (m *Mover) Heartbeat(at time.Time) *models.Heartbeat {
+	battery := int(m.battery)
+	speed := math.Round(m.speed*3.6*100) / 100 // km/h, as the app reports it
+	return &models.Heartbeat{
+		Timestamp: at.UTC().Truncate(time.Second),
+		Lat:       math.Round(m.lat*1e6) / 1e6,
+		Lng:       math.Round(m.lng*1e6) / 1e6,
+		AccuracyM: 5 + m.rng.Intn(40),
+		CellInfo: models.CellInfo{
+			MCC:         621,
+			MNC:         20,
+			CID:         m.cellID,
+			LAC:         m.cellID / 1000,
+			RSSI:        m.rssi,
+			NetworkType: "4G",
+		},
+		BatteryPct: &battery,
+		Speed:      &speed,
+	}
+}
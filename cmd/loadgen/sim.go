@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+)
+
+// Rates are per-tick probabilities of each event for one user
+type Rates struct {
+	DeadZone float64 // the user enters a dead zone, sending a LastGasp first
+	Panic    float64 // the user presses the panic button
+	CheckIn  float64 // the user starts a watch, or completes the one running
+	Chaos    float64 // the heartbeat is replaced by a malformed one or a replay
+}
+
+// SimUser is one simulated app user
+type SimUser struct {
+	ID       uuid.UUID
+	Token    string
+	DeviceID string
+
+	client   *Client
+	rates    Rates
+	interval time.Duration
+	rng      *rand.Rand
+	mover    *Mover
+
+	deadTicks int                 // ticks left in the current dead zone
+	queue     []*models.Heartbeat // recorded in a dead zone, sent after it
+	queueTag  string              // "queued", or "chaos:out_of_order" when flushed newest first
+	lastBody  []byte              // the last heartbeat sent, for replays
+	watching  bool
+}
+
+// Run ticks the user until ctx ends, starting at a random point of the first
+// interval so users don't all send at once
+func (u *SimUser) Run(ctx context.Context) {
+	select {
+	case <-time.After(time.Duration(u.rng.Int63n(int64(u.interval)))):
+	case <-ctx.Done():
+		return
+	}
+
+	ticker := time.NewTicker(u.interval)
+	defer ticker.Stop()
+	for {
+		u.tick(ctx, time.Now())
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (u *SimUser) tick(ctx context.Context, now time.Time) {
+	u.mover.Step(u.interval)
+
+	if u.deadTicks > 0 {
+		u.deadTicks--
+		hb := u.reading(now)
+		hb.Connectivity = models.ConnectivityOfflineQueued
+		u.queue = append(u.queue, hb)
+		if u.deadTicks == 0 {
+			u.leaveDeadZone()
+		}
+		return
+	}
+
+	switch {
+	case u.rng.Float64() < u.rates.DeadZone:
+		u.enterDeadZone(ctx, now)
+	case u.rng.Float64() < u.rates.Chaos:
+		u.chaos(ctx, now)
+	case len(u.queue) > 0:
+		// One queued heartbeat per tick, to stay under the rate limit
+		hb := u.queue[0]
+		u.queue = u.queue[1:]
+		u.send(ctx, u.queueTag, hb)
+	default:
+		u.send(ctx, "", u.reading(now))
+	}
+
+	if u.rng.Float64() < u.rates.Panic {
+		u.pressPanic(ctx)
+	}
+	if u.rng.Float64() < u.rates.CheckIn {
+		u.checkIn(ctx)
+	}
+}
+
+func (u *SimUser) reading(now time.Time) *models.Heartbeat {
+	hb := u.mover.Heartbeat(now)
+	hb.UserID = u.ID
+	hb.DeviceID = u.DeviceID
+	return hb
+}
+
+func (u *SimUser) send(ctx context.Context, label string, hb *models.Heartbeat) {
+	body := u.client.HeartbeatBody(hb)
+	if status := u.client.SendHeartbeat(ctx, label, body); status >= 200 && status < 300 {
+		u.lastBody = body
+	}
+}
+
+// enterDeadZone sends a LastGasp as the signal fades, then goes quiet for a
+// few ticks while readings pile up offline
+func (u *SimUser) enterDeadZone(ctx context.Context, now time.Time) {
+	u.mover.FadeSignal()
+	hb := u.reading(now)
+	hb.LastGasp = true
+	u.send(ctx, "lastgasp", hb)
+	u.deadTicks = 2 + u.rng.Intn(5)
+}
+
+// leaveDeadZone lines up the readings recorded offline. With chaos on, some
+// users upload them newest first.
+func (u *SimUser) leaveDeadZone() {
+	u.queueTag = "queued"
+	if u.rates.Chaos > 0 && u.rng.Float64() < 0.5 {
+		for i, j := 0, len(u.queue)-1; i < j; i, j = i+1, j-1 {
+			u.queue[i], u.queue[j] = u.queue[j], u.queue[i]
+		}
+		u.queueTag = "chaos:out_of_order"
+	}
+}
+
+// chaos sends a heartbeat the server must reject, or a replay of the last one
+func (u *SimUser) chaos(ctx context.Context, now time.Time) {
+	if u.lastBody != nil && u.rng.Intn(3) == 0 {
+		u.client.SendHeartbeat(ctx, "chaos:replay", u.lastBody)
+		return
+	}
+
+	hb := u.reading(now)
+	var req map[string]interface{}
+	_ = json.Unmarshal(u.client.HeartbeatBody(hb), &req)
+
+	kind := "truncated"
+	switch u.rng.Intn(5) {
+	case 0:
+		body := u.client.HeartbeatBody(hb)
+		u.client.SendHeartbeat(ctx, "chaos:malformed:"+kind, body[:len(body)/2])
+		return
+	case 1:
+		kind = "missing_lat"
+		delete(req, "lat")
+	case 2:
+		kind = "lat_out_of_range"
+		req["lat"] = 91.5
+	case 3:
+		kind = "bad_mcc"
+		req["cell_info"].(map[string]interface{})["mcc"] = 62100
+	case 4:
+		kind = "bad_signature"
+		req["accuracy_m"] = hb.AccuracyM + 1
+	}
+	body, _ := json.Marshal(req)
+	u.client.SendHeartbeat(ctx, "chaos:malformed:"+kind, body)
+}
+
+// pressPanic presses the panic button. The users have no panic PIN, so it is
+// sent at once; they have no contacts either, so nobody is messaged.
+func (u *SimUser) pressPanic(ctx context.Context) {
+	path := fmt.Sprintf("/v1/user/%s/panic", u.ID)
+	u.client.do(ctx, "POST /v1/user/:user_id/panic", http.MethodPost, path, u.Token, nil)
+}
+
+// checkIn starts a watch, or completes the running one
+func (u *SimUser) checkIn(ctx context.Context) {
+	if u.watching {
+		path := fmt.Sprintf("/v1/user/%s/watch/complete", u.ID)
+		if status, _ := u.client.do(ctx, "POST /v1/user/:user_id/watch/complete", http.MethodPost, path, u.Token, nil); status < 300 && status != 0 {
+			u.watching = false
+		}
+		return
+	}
+	body, _ := json.Marshal(map[string]interface{}{"duration_minutes": 30, "note": "loadgen"})
+	path := fmt.Sprintf("/v1/user/%s/watch", u.ID)
+	if status, _ := u.client.do(ctx, "POST /v1/user/:user_id/watch", http.MethodPost, path, u.Token, body); status < 300 && status != 0 {
+		u.watching = true
+	}
+}
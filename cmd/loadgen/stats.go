@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// endpointStats are the outcomes of one kind of request
+type endpointStats struct {
+	latencies []time.Duration
+	statuses  map[int]int
+	transport int // requests that got no response
+}
+
+// Stats collects latencies and status codes per endpoint. Chaos requests are
+// kept under their own names, so the rejections they are meant to provoke
+// don't count against the endpoint's error rate.
+type Stats struct {
+	mu        sync.Mutex
+	endpoints map[string]*endpointStats
+}
+
+func NewStats() *Stats {
+	return &Stats{endpoints: make(map[string]*endpointStats)}
+}
+
+// Record adds one request; status 0 is a transport error
+func (s *Stats) Record(endpoint string, status int, elapsed time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.endpoints[endpoint]
+	if !ok {
+		e = &endpointStats{statuses: make(map[int]int)}
+		s.endpoints[endpoint] = e
+	}
+	if status == 0 {
+		e.transport++
+		return
+	}
+	e.latencies = append(e.latencies, elapsed)
+	e.statuses[status]++
+}
+
+// Report prints a table of every endpoint, then its status codes
+func (s *Stats) Report(w io.Writer, elapsed time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	names := make([]string, 0, len(s.endpoints))
+	for name := range s.endpoints {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintf(w, "%-52s %8s %7s %8s %10s %10s %10s\n", "endpoint", "requests", "req/s", "errors", "p50", "p95", "p99")
+	for _, name := range names {
+		e := s.endpoints[name]
+		sort.Slice(e.latencies, func(i, j int) bool { return e.latencies[i] < e.latencies[j] })
+
+		total := len(e.latencies) + e.transport
+		errors := e.transport
+		for status, count := range e.statuses {
+			if status >= 400 {
+				errors += count
+			}
+		}
+		fmt.Fprintf(w, "%-52s %8d %7.1f %7.1f%% %10v %10v %10v\n",
+			name, total, float64(total)/elapsed.Seconds(), 100*float64(errors)/float64(total),
+			percentile(e.latencies, 0.50).Round(time.Millisecond),
+			percentile(e.latencies, 0.95).Round(time.Millisecond),
+			percentile(e.latencies, 0.99).Round(time.Millisecond))
+	}
+
+	fmt.Fprintln(w)
+	for _, name := range names {
+		e := s.endpoints[name]
+		codes := make([]int, 0, len(e.statuses))
+		for status := range e.statuses {
+			codes = append(codes, status)
+		}
+		sort.Ints(codes)
+
+		line := ""
+		for _, status := range codes {
+			line += fmt.Sprintf(" %d=%d", status, e.statuses[status])
+		}
+		if e.transport > 0 {
+			line += fmt.Sprintf(" transport=%d", e.transport)
+		}
+		fmt.Fprintf(w, "%-52s%s\n", name, line)
+	}
+
+	for _, name := range names {
+		if strings.Contains(name, "[chaos:") {
+			fmt.Fprintln(w)
+			fmt.Fprintln(w, "chaos:malformed and chaos:replay should be rejected; any 2xx there is a validation gap.")
+			fmt.Fprintln(w, "chaos:out_of_order uploads are genuine and should be accepted as backfill.")
+			break
+		}
+	}
+}
+
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	idx := int(float64(len(sorted)-1) * p)
+	return sorted[idx]
+}
@@ -0,0 +1,4 @@
+-- Drop alert_deliveries table and related objects
+DROP INDEX IF EXISTS idx_alert_deliveries_phone_created;
+DROP INDEX IF EXISTS idx_alert_deliveries_alert_id;
+DROP TABLE IF EXISTS alert_deliveries CASCADE;
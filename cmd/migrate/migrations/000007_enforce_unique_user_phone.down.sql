@@ -0,0 +1,2 @@
+-- Remove explicit phone uniqueness index
+DROP INDEX IF EXISTS idx_users_phone_unique;
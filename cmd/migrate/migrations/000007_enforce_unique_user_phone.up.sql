@@ -0,0 +1,3 @@
+-- Enforce phone uniqueness explicitly. 000001 declares phone UNIQUE, but databases
+-- bootstrapped from older scripts may lack the constraint.
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_phone_unique ON users(phone);
@@ -0,0 +1,6 @@
+-- Drop broadcast tables and related objects
+DROP INDEX IF EXISTS idx_broadcast_deliveries_queue;
+DROP INDEX IF EXISTS idx_broadcasts_status;
+DROP TABLE IF EXISTS broadcast_deliveries CASCADE;
+DROP TABLE IF EXISTS broadcasts CASCADE;
+DROP TABLE IF EXISTS push_tokens CASCADE;
@@ -0,0 +1,35 @@
+-- Create push_tokens table (FCM registration token per user)
+CREATE TABLE IF NOT EXISTS push_tokens (
+    user_id UUID PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    fcm_token TEXT NOT NULL,
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+-- Create broadcasts table
+CREATE TABLE IF NOT EXISTS broadcasts (
+    id UUID PRIMARY KEY,
+    created_by VARCHAR(64) NOT NULL,
+    message TEXT NOT NULL,
+    channels JSONB NOT NULL DEFAULT '[]'::jsonb,
+    geofence JSONB NOT NULL,
+    audience_size INT NOT NULL DEFAULT 0,
+    status VARCHAR(20) NOT NULL CHECK (status IN ('sending', 'completed', 'aborted')),
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    completed_at TIMESTAMP
+);
+
+-- Create broadcast_deliveries table (outbox for broadcast messages)
+CREATE TABLE IF NOT EXISTS broadcast_deliveries (
+    id UUID PRIMARY KEY,
+    broadcast_id UUID NOT NULL REFERENCES broadcasts(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    channel VARCHAR(10) NOT NULL CHECK (channel IN ('push', 'sms')),
+    status VARCHAR(20) NOT NULL CHECK (status IN ('queued', 'sent', 'failed', 'aborted')),
+    error TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    sent_at TIMESTAMP
+);
+
+-- Indexes
+CREATE INDEX IF NOT EXISTS idx_broadcasts_status ON broadcasts(status);
+CREATE INDEX IF NOT EXISTS idx_broadcast_deliveries_queue ON broadcast_deliveries(broadcast_id, status);
@@ -0,0 +1,5 @@
+-- Remove spoofing columns from heartbeats
+DROP INDEX IF EXISTS idx_heartbeats_spoof_suspected;
+ALTER TABLE heartbeats DROP COLUMN IF EXISTS spoof_reasons;
+ALTER TABLE heartbeats DROP COLUMN IF EXISTS spoof_suspected;
+ALTER TABLE heartbeats DROP COLUMN IF EXISTS is_mock;
@@ -0,0 +1,2 @@
+-- Drop account_links table
+DROP TABLE IF EXISTS account_links;
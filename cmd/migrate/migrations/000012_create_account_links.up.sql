@@ -0,0 +1,17 @@
+-- Create account_links table (guardian access to a ward's account, granted by the ward)
+CREATE TABLE IF NOT EXISTS account_links (
+    id UUID PRIMARY KEY,
+    guardian_user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    ward_user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    permissions TEXT[] NOT NULL DEFAULT '{}',
+    status VARCHAR(20) NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'active', 'revoked')),
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    accepted_at TIMESTAMP,
+    revoked_at TIMESTAMP,
+    CHECK (guardian_user_id <> ward_user_id)
+);
+
+-- At most one live (pending or active) link per guardian/ward pair
+CREATE UNIQUE INDEX IF NOT EXISTS idx_account_links_live_pair
+    ON account_links(guardian_user_id, ward_user_id) WHERE status <> 'revoked';
+CREATE INDEX IF NOT EXISTS idx_account_links_ward ON account_links(ward_user_id, status);
@@ -0,0 +1,2 @@
+-- Remove identified_by from heartbeats
+ALTER TABLE heartbeats DROP COLUMN IF EXISTS identified_by;
@@ -0,0 +1,3 @@
+-- How an SMS heartbeat was attributed to its user: the uid in the payload, or the sender's phone
+ALTER TABLE heartbeats ADD COLUMN IF NOT EXISTS identified_by TEXT NOT NULL DEFAULT 'payload'
+    CHECK (identified_by IN ('payload', 'sender'));
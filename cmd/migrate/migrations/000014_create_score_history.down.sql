@@ -0,0 +1,2 @@
+-- Drop score history
+DROP TABLE IF EXISTS score_history;
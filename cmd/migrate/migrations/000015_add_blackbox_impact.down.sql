@@ -0,0 +1,4 @@
+-- Remove impact analysis columns from blackbox_trails
+DROP INDEX IF EXISTS idx_blackbox_trails_impact_unchecked;
+ALTER TABLE blackbox_trails DROP COLUMN IF EXISTS impact_at;
+ALTER TABLE blackbox_trails DROP COLUMN IF EXISTS impact_checked_at;
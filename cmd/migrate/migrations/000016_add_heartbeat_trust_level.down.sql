@@ -0,0 +1,2 @@
+-- Remove trust_level from heartbeats
+ALTER TABLE heartbeats DROP COLUMN IF EXISTS trust_level;
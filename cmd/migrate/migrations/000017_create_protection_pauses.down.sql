@@ -0,0 +1,2 @@
+-- Drop protection_pauses table
+DROP TABLE IF EXISTS protection_pauses;
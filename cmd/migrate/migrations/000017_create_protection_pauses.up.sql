@@ -0,0 +1,18 @@
+-- Create protection_pauses table (user-requested breaks in monitoring)
+CREATE TABLE IF NOT EXISTS protection_pauses (
+    id UUID PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    reason TEXT NOT NULL DEFAULT '',
+    paused_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    paused_until TIMESTAMP NOT NULL,
+    resumed_at TIMESTAMP,
+    resumed_by VARCHAR(20) CHECK (resumed_by IN ('user', 'expiry')),
+    CHECK (paused_until > paused_at)
+);
+
+-- At most one open pause per user
+CREATE UNIQUE INDEX IF NOT EXISTS idx_protection_pauses_open
+    ON protection_pauses(user_id) WHERE resumed_at IS NULL;
+CREATE INDEX IF NOT EXISTS idx_protection_pauses_expiry
+    ON protection_pauses(paused_until) WHERE resumed_at IS NULL;
+CREATE INDEX IF NOT EXISTS idx_protection_pauses_user ON protection_pauses(user_id, paused_at DESC);
@@ -0,0 +1,3 @@
+-- Drop heartbeat backfill marker
+DROP INDEX IF EXISTS idx_heartbeats_user_live_timestamp;
+ALTER TABLE heartbeats DROP COLUMN IF EXISTS backfill;
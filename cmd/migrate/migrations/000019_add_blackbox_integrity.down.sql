@@ -0,0 +1,4 @@
+-- Remove trail integrity columns from blackbox_trails
+ALTER TABLE blackbox_trails DROP COLUMN IF EXISTS chain_break_index;
+ALTER TABLE blackbox_trails DROP COLUMN IF EXISTS chain_head;
+ALTER TABLE blackbox_trails DROP COLUMN IF EXISTS integrity_status;
@@ -0,0 +1,6 @@
+-- Tamper evidence for blackbox trails: the result of verifying the client's
+-- per-entry hash chain and its signed head, and where a broken chain failed
+ALTER TABLE blackbox_trails ADD COLUMN IF NOT EXISTS integrity_status TEXT NOT NULL DEFAULT 'unverified'
+    CHECK (integrity_status IN ('verified', 'unverified', 'broken', 'bad_signature'));
+ALTER TABLE blackbox_trails ADD COLUMN IF NOT EXISTS chain_head TEXT;
+ALTER TABLE blackbox_trails ADD COLUMN IF NOT EXISTS chain_break_index INTEGER;
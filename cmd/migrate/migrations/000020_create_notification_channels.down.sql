@@ -0,0 +1,2 @@
+-- Drop notification_channels table
+DROP TABLE IF EXISTS notification_channels;
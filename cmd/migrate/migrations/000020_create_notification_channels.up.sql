@@ -0,0 +1,17 @@
+-- Create notification_channels table (team chat and webhook destinations for a user's alerts)
+CREATE TABLE IF NOT EXISTS notification_channels (
+    id UUID PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    type VARCHAR(20) NOT NULL CHECK (type IN ('slack', 'teams', 'generic_webhook')),
+    name VARCHAR(100) NOT NULL,
+    webhook_url TEXT NOT NULL,
+    enabled BOOLEAN NOT NULL DEFAULT true,
+    consecutive_failures INTEGER NOT NULL DEFAULT 0,
+    disabled_reason TEXT,
+    last_delivery_at TIMESTAMP,
+    last_error TEXT,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_notification_channels_user ON notification_channels(user_id, created_at);
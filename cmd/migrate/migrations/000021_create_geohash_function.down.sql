@@ -0,0 +1 @@
+DROP FUNCTION IF EXISTS geohash_encode(DOUBLE PRECISION, DOUBLE PRECISION, INTEGER);
@@ -0,0 +1,46 @@
+-- geohash_encode bins a coordinate into its geohash cell, for aggregate heatmaps
+CREATE OR REPLACE FUNCTION geohash_encode(lat DOUBLE PRECISION, lng DOUBLE PRECISION, chars INTEGER)
+RETURNS TEXT AS $$
+DECLARE
+    base32 CONSTANT TEXT := '0123456789bcdefghjkmnpqrstuvwxyz';
+    lat_lo DOUBLE PRECISION := -90;
+    lat_hi DOUBLE PRECISION := 90;
+    lng_lo DOUBLE PRECISION := -180;
+    lng_hi DOUBLE PRECISION := 180;
+    mid DOUBLE PRECISION;
+    hash TEXT := '';
+    ch INTEGER := 0;
+    bits INTEGER := 0;
+    even BOOLEAN := true;
+BEGIN
+    WHILE length(hash) < chars LOOP
+        IF even THEN
+            mid := (lng_lo + lng_hi) / 2;
+            IF lng >= mid THEN
+                ch := ch * 2 + 1;
+                lng_lo := mid;
+            ELSE
+                ch := ch * 2;
+                lng_hi := mid;
+            END IF;
+        ELSE
+            mid := (lat_lo + lat_hi) / 2;
+            IF lat >= mid THEN
+                ch := ch * 2 + 1;
+                lat_lo := mid;
+            ELSE
+                ch := ch * 2;
+                lat_hi := mid;
+            END IF;
+        END IF;
+        even := NOT even;
+        bits := bits + 1;
+        IF bits = 5 THEN
+            hash := hash || substr(base32, ch + 1, 1);
+            ch := 0;
+            bits := 0;
+        END IF;
+    END LOOP;
+    RETURN hash;
+END;
+$$ LANGUAGE plpgsql IMMUTABLE STRICT;
@@ -0,0 +1,2 @@
+-- Remove device_id from heartbeats
+ALTER TABLE heartbeats DROP COLUMN IF EXISTS device_id;
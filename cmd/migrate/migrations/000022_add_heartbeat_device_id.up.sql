@@ -0,0 +1,3 @@
+-- Which of a user's devices sent a heartbeat. NULL for heartbeats from
+-- clients that don't report one, including all historical rows.
+ALTER TABLE heartbeats ADD COLUMN IF NOT EXISTS device_id VARCHAR(64);
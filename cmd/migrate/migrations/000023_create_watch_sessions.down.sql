@@ -0,0 +1,2 @@
+-- Drop watch_sessions table
+DROP TABLE IF EXISTS watch_sessions;
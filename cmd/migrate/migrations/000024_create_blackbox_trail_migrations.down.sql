@@ -0,0 +1,3 @@
+-- Drop blackbox_trail_migrations table
+DROP INDEX IF EXISTS idx_blackbox_trails_inline;
+DROP TABLE IF EXISTS blackbox_trail_migrations;
@@ -0,0 +1,18 @@
+-- Create blackbox_trail_migrations table (progress of moving inline data-URI trails to object storage)
+CREATE TABLE IF NOT EXISTS blackbox_trail_migrations (
+    trail_id UUID PRIMARY KEY REFERENCES blackbox_trails(id) ON DELETE CASCADE,
+    status VARCHAR(10) NOT NULL CHECK (status IN ('done', 'failed')),
+    object_key TEXT,
+    inline_bytes INTEGER NOT NULL DEFAULT 0,
+    stored_bytes INTEGER NOT NULL DEFAULT 0,
+    sha256 VARCHAR(64),
+    error TEXT,
+    attempts INTEGER NOT NULL DEFAULT 1,
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_blackbox_trail_migrations_status ON blackbox_trail_migrations(status);
+
+-- Trails still stored inline, in the order the migration walks them
+CREATE INDEX IF NOT EXISTS idx_blackbox_trails_inline
+    ON blackbox_trails(uploaded_at, id) WHERE file_url LIKE 'data:%';
@@ -0,0 +1,3 @@
+-- Remove plus_code and what3words from alerts
+ALTER TABLE alerts DROP COLUMN IF EXISTS what3words;
+ALTER TABLE alerts DROP COLUMN IF EXISTS plus_code;
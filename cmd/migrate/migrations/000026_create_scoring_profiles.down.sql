@@ -0,0 +1,3 @@
+-- Drop shadow_results and scoring_profiles tables
+DROP TABLE IF EXISTS shadow_results;
+DROP TABLE IF EXISTS scoring_profiles;
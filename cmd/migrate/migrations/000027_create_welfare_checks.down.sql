@@ -0,0 +1,2 @@
+-- Drop welfare_checks table
+DROP TABLE IF EXISTS welfare_checks;
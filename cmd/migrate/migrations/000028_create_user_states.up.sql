@@ -0,0 +1,16 @@
+-- Every state transition of every user: the system of record for user
+-- state. Redis caches the latest state for the hot path; on a cache miss
+-- the latest row here is read back into it. A row is written only when the
+-- state changes, so repeated evaluations in the same state add nothing.
+CREATE TABLE IF NOT EXISTS user_states (
+    id BIGSERIAL PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    from_state VARCHAR(20), -- NULL for the user's first recorded state
+    to_state VARCHAR(20) NOT NULL,
+    score INT NOT NULL,
+    reason TEXT NOT NULL DEFAULT '',
+    triggered_by VARCHAR(10) NOT NULL CHECK (triggered_by IN ('heartbeat', 'monitor', 'panic', 'impact')),
+    timestamp TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_states_user_time ON user_states(user_id, timestamp DESC);
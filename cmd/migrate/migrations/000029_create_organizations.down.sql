@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS org_invitations;
+DROP INDEX IF EXISTS idx_users_org;
+ALTER TABLE users DROP COLUMN IF EXISTS org_id;
+DROP TABLE IF EXISTS organizations;
@@ -0,0 +1,35 @@
+-- Organizations running SafeTrace for their members, e.g. a university.
+-- default_settings is a partial user settings object applied when a member
+-- joins; escalation_contacts are alerted with every member's own contacts;
+-- scoring_profile, if set, overrides fields of the active scoring profile
+-- for members.
+CREATE TABLE IF NOT EXISTS organizations (
+    id UUID PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    default_settings JSONB NOT NULL DEFAULT '{}'::jsonb,
+    escalation_contacts JSONB NOT NULL DEFAULT '[]'::jsonb,
+    scoring_profile JSONB,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+-- Consumer users belong to no organization
+ALTER TABLE users ADD COLUMN IF NOT EXISTS org_id UUID REFERENCES organizations(id) ON DELETE SET NULL;
+CREATE INDEX IF NOT EXISTS idx_users_org ON users(org_id) WHERE org_id IS NOT NULL;
+
+-- Invitations to join an organization, by phone number
+CREATE TABLE IF NOT EXISTS org_invitations (
+    id UUID PRIMARY KEY,
+    org_id UUID NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    phone VARCHAR(20) NOT NULL,
+    invited_by VARCHAR(64) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMP NOT NULL,
+    accepted_at TIMESTAMP,
+    accepted_by UUID REFERENCES users(id) ON DELETE SET NULL
+);
+
+-- One open invitation per phone and organization; inviting again renews it
+CREATE UNIQUE INDEX IF NOT EXISTS idx_org_invitations_open
+    ON org_invitations(org_id, phone) WHERE accepted_at IS NULL;
+CREATE INDEX IF NOT EXISTS idx_org_invitations_phone ON org_invitations(phone) WHERE accepted_at IS NULL;
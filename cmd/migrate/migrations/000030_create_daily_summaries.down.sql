@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS daily_summaries;
+DROP FUNCTION IF EXISTS geofence_contains(JSONB, DOUBLE PRECISION, DOUBLE PRECISION);
+DROP FUNCTION IF EXISTS haversine_m(DOUBLE PRECISION, DOUBLE PRECISION, DOUBLE PRECISION, DOUBLE PRECISION);
@@ -0,0 +1,66 @@
+-- haversine_m is the great-circle distance in meters, as haversineDistance in the evaluator
+CREATE OR REPLACE FUNCTION haversine_m(lat1 DOUBLE PRECISION, lng1 DOUBLE PRECISION, lat2 DOUBLE PRECISION, lng2 DOUBLE PRECISION)
+RETURNS DOUBLE PRECISION AS $$
+    SELECT 2 * 6371000 * asin(sqrt(
+        sin(radians(lat2 - lat1) / 2) ^ 2 +
+        cos(radians(lat1)) * cos(radians(lat2)) * sin(radians(lng2 - lng1) / 2) ^ 2
+    ))
+$$ LANGUAGE sql IMMUTABLE STRICT;
+
+-- geofence_contains mirrors services.GeofenceContains for a geofence stored
+-- as JSON, e.g. a user's safe zone
+CREATE OR REPLACE FUNCTION geofence_contains(fence JSONB, lat DOUBLE PRECISION, lng DOUBLE PRECISION)
+RETURNS BOOLEAN AS $$
+DECLARE
+    points JSONB;
+    n INTEGER;
+    i INTEGER;
+    j INTEGER;
+    lat_i DOUBLE PRECISION;
+    lng_i DOUBLE PRECISION;
+    lat_j DOUBLE PRECISION;
+    lng_j DOUBLE PRECISION;
+    inside BOOLEAN := false;
+BEGIN
+    IF fence->>'type' = 'radius' THEN
+        RETURN haversine_m((fence->'center'->>'lat')::float8, (fence->'center'->>'lng')::float8, lat, lng)
+            <= (fence->>'radius_m')::float8;
+    END IF;
+    IF fence->>'type' <> 'polygon' THEN
+        RETURN false;
+    END IF;
+
+    points := fence->'points';
+    n := jsonb_array_length(points);
+    j := n - 1;
+    FOR i IN 0 .. n - 1 LOOP
+        lat_i := (points->i->>'lat')::float8;
+        lng_i := (points->i->>'lng')::float8;
+        lat_j := (points->j->>'lat')::float8;
+        lng_j := (points->j->>'lng')::float8;
+        IF (lat_i > lat) <> (lat_j > lat) AND
+           lng < (lng_j - lng_i) * (lat - lat_i) / (lat_j - lat_i) + lng_i THEN
+            inside := NOT inside;
+        END IF;
+        j := i;
+    END LOOP;
+    RETURN inside;
+END;
+$$ LANGUAGE plpgsql IMMUTABLE STRICT;
+
+-- One summary per user per local day with activity, built by the daily
+-- summary worker once the day is over in the user's time zone
+CREATE TABLE IF NOT EXISTS daily_summaries (
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    date DATE NOT NULL, -- the local day
+    timezone VARCHAR(64) NOT NULL,
+    heartbeats INT NOT NULL,
+    heartbeats_by_source JSONB NOT NULL DEFAULT '{}',
+    distance_m DOUBLE PRECISION NOT NULL DEFAULT 0,
+    min_battery_pct INT,
+    episodes JSONB NOT NULL DEFAULT '[]',
+    geofence_events JSONB NOT NULL DEFAULT '[]',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    notified_at TIMESTAMPTZ,
+    PRIMARY KEY (user_id, date)
+);
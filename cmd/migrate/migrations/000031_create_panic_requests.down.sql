@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS panic_requests;
+ALTER TABLE alerts DROP COLUMN IF EXISTS duress;
+ALTER TABLE users DROP COLUMN IF EXISTS duress_pin_hash;
+ALTER TABLE users DROP COLUMN IF EXISTS panic_pin_hash;
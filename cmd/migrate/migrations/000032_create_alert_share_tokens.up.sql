@@ -0,0 +1,12 @@
+-- Create alert_share_tokens table (links to the contact dashboard of an
+-- alert, made by a recipient to pass on; they stop working once the alert
+-- is resolved). A recipient's own ack token is acknowledge-capable too.
+CREATE TABLE IF NOT EXISTS alert_share_tokens (
+    token VARCHAR(64) PRIMARY KEY,
+    alert_id UUID NOT NULL REFERENCES alerts(id) ON DELETE CASCADE,
+    recipient_id UUID NOT NULL REFERENCES alert_recipients(id) ON DELETE CASCADE,
+    scope VARCHAR(20) NOT NULL CHECK (scope IN ('read', 'acknowledge')),
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_alert_share_tokens_alert_id ON alert_share_tokens(alert_id);
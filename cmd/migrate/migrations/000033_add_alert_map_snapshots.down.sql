@@ -0,0 +1,4 @@
+-- Remove map snapshots from alerts
+DROP INDEX IF EXISTS idx_alerts_map_snapshot;
+ALTER TABLE alerts DROP COLUMN IF EXISTS map_snapshot_at;
+ALTER TABLE alerts DROP COLUMN IF EXISTS map_snapshot_key;
@@ -0,0 +1,7 @@
+-- Object storage key of the map snapshot attached to an alert's messages,
+-- and when it was made. The image is deleted, and the key cleared,
+-- ALERT_MAP_RETENTION_HOURS after the alert is resolved.
+ALTER TABLE alerts ADD COLUMN IF NOT EXISTS map_snapshot_key VARCHAR(255);
+ALTER TABLE alerts ADD COLUMN IF NOT EXISTS map_snapshot_at TIMESTAMP;
+
+CREATE INDEX IF NOT EXISTS idx_alerts_map_snapshot ON alerts(resolved_at) WHERE map_snapshot_key IS NOT NULL;
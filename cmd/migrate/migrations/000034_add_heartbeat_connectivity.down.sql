@@ -0,0 +1,2 @@
+-- Remove connectivity from heartbeats
+ALTER TABLE heartbeats DROP COLUMN IF EXISTS connectivity;
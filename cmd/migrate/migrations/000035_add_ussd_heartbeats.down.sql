@@ -0,0 +1,5 @@
+-- Remove USSD heartbeats and landmark from heartbeats
+ALTER TABLE heartbeats DROP COLUMN IF EXISTS landmark;
+DELETE FROM heartbeats WHERE source = 'ussd';
+ALTER TABLE heartbeats DROP CONSTRAINT IF EXISTS heartbeats_source_check;
+ALTER TABLE heartbeats ADD CONSTRAINT heartbeats_source_check CHECK (source IN ('http', 'sms'));
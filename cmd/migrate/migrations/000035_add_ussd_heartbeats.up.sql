@@ -0,0 +1,5 @@
+-- Heartbeats from the USSD gateway, for users on feature phones. They have
+-- no GPS fix; landmark is where the caller said they are, if they did.
+ALTER TABLE heartbeats DROP CONSTRAINT IF EXISTS heartbeats_source_check;
+ALTER TABLE heartbeats ADD CONSTRAINT heartbeats_source_check CHECK (source IN ('http', 'sms', 'ussd'));
+ALTER TABLE heartbeats ADD COLUMN IF NOT EXISTS landmark TEXT;
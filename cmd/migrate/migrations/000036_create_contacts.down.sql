@@ -0,0 +1,17 @@
+-- Put the current (not deleted) contacts back in users.trusted_contacts
+UPDATE users u
+SET trusted_contacts = COALESCE((
+    SELECT jsonb_agg(
+        jsonb_strip_nulls(jsonb_build_object(
+            'id', c.id::text,
+            'name', c.name,
+            'phone', c.phone,
+            'preferences', c.preferences
+        ))
+        ORDER BY c.position, c.created_at
+    )
+    FROM contacts c
+    WHERE c.user_id = u.id AND c.deleted_at IS NULL
+), '[]'::jsonb);
+
+DROP TABLE IF EXISTS contacts;
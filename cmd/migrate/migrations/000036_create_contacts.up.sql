@@ -0,0 +1,47 @@
+-- Trusted contacts, moved out of users.trusted_contacts into their own rows.
+-- Removing a contact only sets deleted_at, so they can be restored with their
+-- id, and the deliveries and tokens that refer to it, intact. position keeps
+-- the order the user added them in.
+CREATE TABLE IF NOT EXISTS contacts (
+    id UUID PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name TEXT NOT NULL,
+    phone TEXT NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'invited' CHECK (status IN ('invited', 'confirmed')),
+    preferences JSONB,
+    position INT NOT NULL DEFAULT 0,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    deleted_at TIMESTAMP
+);
+
+-- Every contact lookup is a user's current (or all) contacts
+CREATE INDEX IF NOT EXISTS idx_contacts_user_deleted ON contacts(user_id, deleted_at);
+
+CREATE TRIGGER update_contacts_updated_at BEFORE UPDATE ON contacts
+    FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+
+-- Backfill from the JSONB column. Whether a contact confirmed their
+-- invitation wasn't kept, so they start as invited. The API only ever
+-- addressed contacts by UUID; any other id gets a new one.
+INSERT INTO contacts (id, user_id, name, phone, preferences, position, created_at, updated_at)
+SELECT
+    CASE WHEN c.contact->>'id' ~* '^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$'
+        THEN (c.contact->>'id')::uuid
+        ELSE gen_random_uuid()
+    END,
+    u.id,
+    COALESCE(c.contact->>'name', ''),
+    COALESCE(c.contact->>'phone', ''),
+    NULLIF(c.contact->'preferences', 'null'::jsonb),
+    c.idx - 1,
+    u.updated_at,
+    NOW()
+FROM users u
+CROSS JOIN LATERAL jsonb_array_elements(
+    CASE WHEN jsonb_typeof(u.trusted_contacts) = 'array' THEN u.trusted_contacts ELSE '[]'::jsonb END
+) WITH ORDINALITY AS c(contact, idx)
+ON CONFLICT (id) DO NOTHING;
+
+-- users.trusted_contacts is no longer read or written; it is left in place
+-- so this can be rolled back
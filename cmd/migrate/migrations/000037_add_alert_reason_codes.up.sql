@@ -0,0 +1,8 @@
+-- Structured reasons for alerts, next to the rendered reason text. Alerts
+-- raised before this only have the text, so they get a single LEGACY code.
+ALTER TABLE alerts ADD COLUMN IF NOT EXISTS reason_codes JSONB;
+
+UPDATE alerts SET reason_codes = '[{"code": "LEGACY"}]'::jsonb WHERE reason_codes IS NULL;
+
+ALTER TABLE alerts ALTER COLUMN reason_codes SET DEFAULT '[]'::jsonb;
+ALTER TABLE alerts ALTER COLUMN reason_codes SET NOT NULL;
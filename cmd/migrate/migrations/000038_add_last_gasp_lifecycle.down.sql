@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_last_gasps_open;
+ALTER TABLE last_gasps DROP COLUMN IF EXISTS superseded_at;
+ALTER TABLE last_gasps DROP COLUMN IF EXISTS repeats;
+ALTER TABLE last_gasps DROP COLUMN IF EXISTS last_at;
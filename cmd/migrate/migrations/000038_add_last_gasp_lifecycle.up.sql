@@ -0,0 +1,12 @@
+-- LastGasp lifecycle. A normal heartbeat after a LastGasp supersedes it, so
+-- the wait ends at once, and LastGasps repeated within the re-arm window fold
+-- into the existing row. last_at is when the latest of them arrived.
+ALTER TABLE last_gasps ADD COLUMN IF NOT EXISTS last_at TIMESTAMPTZ;
+ALTER TABLE last_gasps ADD COLUMN IF NOT EXISTS repeats INT NOT NULL DEFAULT 0;
+ALTER TABLE last_gasps ADD COLUMN IF NOT EXISTS superseded_at TIMESTAMPTZ;
+
+UPDATE last_gasps SET last_at = created_at WHERE last_at IS NULL;
+ALTER TABLE last_gasps ALTER COLUMN last_at SET DEFAULT NOW();
+ALTER TABLE last_gasps ALTER COLUMN last_at SET NOT NULL;
+
+CREATE INDEX IF NOT EXISTS idx_last_gasps_open ON last_gasps(user_id, last_at DESC) WHERE superseded_at IS NULL;
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS responder_acks;
+DROP TABLE IF EXISTS responder_keys;
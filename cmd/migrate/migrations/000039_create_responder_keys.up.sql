@@ -0,0 +1,21 @@
+-- API keys of partner responder organizations (private security,
+-- neighbourhood watch). Only a hash of each key is kept. Alerts outside a
+-- key's operating area are never returned to it.
+CREATE TABLE IF NOT EXISTS responder_keys (
+    id UUID PRIMARY KEY,
+    organization VARCHAR(200) NOT NULL,
+    key_prefix VARCHAR(16) NOT NULL,
+    key_hash CHAR(64) NOT NULL UNIQUE,
+    operating_area JSONB NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    last_used_at TIMESTAMPTZ,
+    revoked_at TIMESTAMPTZ
+);
+
+-- Responders acknowledging alerts they were shown
+CREATE TABLE IF NOT EXISTS responder_acks (
+    alert_id UUID NOT NULL REFERENCES alerts(id) ON DELETE CASCADE,
+    key_id UUID NOT NULL REFERENCES responder_keys(id) ON DELETE CASCADE,
+    acknowledged_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (alert_id, key_id)
+);
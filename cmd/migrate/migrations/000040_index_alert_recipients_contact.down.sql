@@ -0,0 +1 @@
+DROP INDEX IF EXISTS idx_alert_recipients_contact;
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_users_public_status_token;
+ALTER TABLE users DROP COLUMN IF EXISTS public_status_token;
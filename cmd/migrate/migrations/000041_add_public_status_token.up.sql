@@ -0,0 +1,5 @@
+-- Opt-in public status badge. The token is only set while the user has
+-- public_status enabled in their settings; rotating replaces it.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS public_status_token VARCHAR(64);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_public_status_token ON users(public_status_token) WHERE public_status_token IS NOT NULL;
@@ -0,0 +1,3 @@
+ALTER TABLE alerts DROP COLUMN IF EXISTS first_delivered_at;
+ALTER TABLE alerts DROP COLUMN IF EXISTS first_attempt_at;
+ALTER TABLE alerts DROP COLUMN IF EXISTS detected_at;
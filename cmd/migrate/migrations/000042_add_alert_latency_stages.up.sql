@@ -0,0 +1,8 @@
+-- When each stage of getting an alert to the user's contacts happened, for
+-- the time-to-alert SLO: the evaluation that raised it was requested
+-- (detected_at), the first message to a contact was attempted, and a carrier
+-- first confirmed one was delivered. Alerts raised before this migration
+-- have no detected_at and are left out of the SLO.
+ALTER TABLE alerts ADD COLUMN IF NOT EXISTS detected_at TIMESTAMPTZ;
+ALTER TABLE alerts ADD COLUMN IF NOT EXISTS first_attempt_at TIMESTAMPTZ;
+ALTER TABLE alerts ADD COLUMN IF NOT EXISTS first_delivered_at TIMESTAMPTZ;
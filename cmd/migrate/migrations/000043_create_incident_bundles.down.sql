@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS incident_bundles;
+ALTER TABLE alert_deliveries DROP COLUMN IF EXISTS provider_message_id;
+ALTER TABLE alert_deliveries DROP COLUMN IF EXISTS provider;
@@ -0,0 +1,19 @@
+-- Check-in prompts sent at CAUTION, recorded once each ends: the ladder of
+-- channels it tried, how many attempts went out, and which attempt, channel
+-- and means (app, sms or heartbeat) got an answer, to tune the default ladder
+CREATE TABLE IF NOT EXISTS silent_prompts (
+    id UUID PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    ladder TEXT[] NOT NULL,
+    timeout_seconds INT NOT NULL,
+    attempts_sent INT NOT NULL,
+    outcome VARCHAR(20) NOT NULL, -- answered | escalated | cleared
+    answered_attempt INT,
+    answered_channel VARCHAR(30),
+    answered_via VARCHAR(20),
+    started_at TIMESTAMPTZ NOT NULL,
+    ended_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_silent_prompts_user ON silent_prompts(user_id, started_at DESC);
+CREATE INDEX IF NOT EXISTS idx_silent_prompts_started ON silent_prompts(started_at);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_contacts_phone;
+ALTER TABLE contacts DROP CONSTRAINT IF EXISTS contacts_phone_recipient_fkey;
+DROP TABLE IF EXISTS contact_recipients;
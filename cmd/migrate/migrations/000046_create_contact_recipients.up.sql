@@ -0,0 +1,26 @@
+-- The person behind a phone number, across every user who lists them as a
+-- trusted contact. Each contacts row links to its recipient by phone, so
+-- the recipient's own preferences follow them from one user to the next:
+-- how many non-critical messages they'll take in ten minutes (NULL for the
+-- CONTACT_PACING_MAX_PER_10MIN default) and which channels they want.
+CREATE TABLE IF NOT EXISTS contact_recipients (
+    phone TEXT PRIMARY KEY,
+    max_per_window INT CHECK (max_per_window BETWEEN 1 AND 20),
+    sms_enabled BOOLEAN NOT NULL DEFAULT TRUE,
+    whatsapp_enabled BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE TRIGGER update_contact_recipients_updated_at BEFORE UPDATE ON contact_recipients
+    FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+
+INSERT INTO contact_recipients (phone)
+SELECT DISTINCT phone FROM contacts
+ON CONFLICT (phone) DO NOTHING;
+
+ALTER TABLE contacts ADD CONSTRAINT contacts_phone_recipient_fkey
+    FOREIGN KEY (phone) REFERENCES contact_recipients(phone) ON UPDATE CASCADE;
+
+-- A recipient's protected users are the current contacts with their phone
+CREATE INDEX IF NOT EXISTS idx_contacts_phone ON contacts(phone) WHERE deleted_at IS NULL;
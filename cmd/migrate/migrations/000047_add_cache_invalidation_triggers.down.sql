@@ -0,0 +1,7 @@
+DROP TRIGGER IF EXISTS broadcasts_cache_invalidation ON broadcasts;
+DROP TRIGGER IF EXISTS contacts_cache_invalidation ON contacts;
+DROP TRIGGER IF EXISTS users_cache_invalidation ON users;
+DROP FUNCTION IF EXISTS broadcasts_cache_invalidation();
+DROP FUNCTION IF EXISTS contacts_cache_invalidation();
+DROP FUNCTION IF EXISTS users_cache_invalidation();
+DROP FUNCTION IF EXISTS notify_cache_invalidation(TEXT, TEXT);
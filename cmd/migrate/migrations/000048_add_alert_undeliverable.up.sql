@@ -0,0 +1,4 @@
+-- Why an alert reached nobody, e.g. 'no recipients' when the user had no
+-- contacts and no fallback recipient is configured. The admin active-alerts
+-- view lists unresolved alerts with one first.
+ALTER TABLE alerts ADD COLUMN IF NOT EXISTS undeliverable TEXT;
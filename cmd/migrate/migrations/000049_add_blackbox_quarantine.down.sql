@@ -0,0 +1,3 @@
+ALTER TABLE blackbox_trails
+    DROP COLUMN IF EXISTS quarantine_url,
+    DROP COLUMN IF EXISTS rejected_points;
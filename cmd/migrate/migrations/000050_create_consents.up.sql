@@ -0,0 +1,22 @@
+-- Consent to each scope of the privacy policy (tracking, audio, community
+-- broadcasts, responder sharing), one row per grant. A grant is closed by
+-- the user revoking it, by a later grant of the same scope, or by a new
+-- policy version of its scope asking for consent again; closed rows are
+-- kept for export. The client's IP and user agent are recorded with it.
+CREATE TABLE IF NOT EXISTS consents (
+    id UUID PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    scope VARCHAR(30) NOT NULL CHECK (scope IN ('tracking', 'audio', 'community_broadcast', 'responder_sharing')),
+    policy_version INT NOT NULL,
+    granted_at TIMESTAMPTZ NOT NULL,
+    revoked_at TIMESTAMPTZ,
+    superseded_at TIMESTAMPTZ,
+    client_ip TEXT,
+    user_agent TEXT
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_consents_open ON consents(user_id, scope)
+    WHERE revoked_at IS NULL AND superseded_at IS NULL;
+CREATE INDEX IF NOT EXISTS idx_consents_user ON consents(user_id, granted_at DESC);
+CREATE INDEX IF NOT EXISTS idx_consents_scope_open ON consents(scope, policy_version)
+    WHERE revoked_at IS NULL AND superseded_at IS NULL;
@@ -0,0 +1,5 @@
+-- Remove duplicate links from heartbeats
+DROP INDEX IF EXISTS idx_heartbeats_user_live_timestamp;
+CREATE INDEX IF NOT EXISTS idx_heartbeats_user_live_timestamp
+    ON heartbeats(user_id, timestamp DESC) WHERE NOT backfill;
+ALTER TABLE heartbeats DROP COLUMN IF EXISTS duplicate_of;
@@ -0,0 +1,3 @@
+-- Remove feature flag overrides
+ALTER TABLE shadow_results DROP COLUMN IF EXISTS flags;
+DROP TABLE IF EXISTS user_feature_flags;
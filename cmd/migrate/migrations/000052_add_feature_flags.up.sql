@@ -0,0 +1,14 @@
+-- Admins' per-user feature flag overrides, which win over the deployment's
+-- FEATURE_FLAGS. A user without a row for a flag gets the deployment's
+-- setting. Flags are defined in code, so names aren't constrained here.
+CREATE TABLE IF NOT EXISTS user_feature_flags (
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    flag VARCHAR(50) NOT NULL,
+    enabled BOOLEAN NOT NULL,
+    set_by TEXT NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id, flag)
+);
+
+-- The flags each shadow evaluation ran with, by name. Empty for historical rows.
+ALTER TABLE shadow_results ADD COLUMN IF NOT EXISTS flags JSONB NOT NULL DEFAULT '{}'::jsonb;
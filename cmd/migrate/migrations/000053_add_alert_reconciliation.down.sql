@@ -0,0 +1,6 @@
+-- Remove alert reconciliation records
+DROP INDEX IF EXISTS idx_alerts_reconciled_at;
+DROP INDEX IF EXISTS idx_alerts_unresolved_created;
+ALTER TABLE alerts DROP COLUMN IF EXISTS reconciled_at;
+ALTER TABLE alerts DROP COLUMN IF EXISTS reconcile_action;
+ALTER TABLE alerts DROP COLUMN IF EXISTS reconcile_class;
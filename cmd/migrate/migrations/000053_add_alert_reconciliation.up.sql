@@ -0,0 +1,10 @@
+-- What the alert reconciler last did with an alert left unresolved: its
+-- class when looked at (no_deliveries, all_failed, unacknowledged or
+-- acknowledged) and the action taken (redispatched, flagged or closed).
+-- Alerts it closes are resolved with resolution 'reconciled'.
+ALTER TABLE alerts ADD COLUMN IF NOT EXISTS reconcile_class TEXT;
+ALTER TABLE alerts ADD COLUMN IF NOT EXISTS reconcile_action TEXT;
+ALTER TABLE alerts ADD COLUMN IF NOT EXISTS reconciled_at TIMESTAMP;
+
+CREATE INDEX IF NOT EXISTS idx_alerts_unresolved_created ON alerts(created_at, id) WHERE resolved_at IS NULL;
+CREATE INDEX IF NOT EXISTS idx_alerts_reconciled_at ON alerts(reconciled_at) WHERE reconciled_at IS NOT NULL;
@@ -0,0 +1,2 @@
+-- Remove the high-frequency marker of streamed heartbeats
+ALTER TABLE heartbeats DROP COLUMN IF EXISTS high_frequency;
@@ -0,0 +1,7 @@
+-- Remove the coarse location marker of heartbeats and the precision_escalation consent scope
+ALTER TABLE heartbeats DROP COLUMN IF EXISTS coarse;
+
+DELETE FROM consents WHERE scope = 'precision_escalation';
+ALTER TABLE consents DROP CONSTRAINT IF EXISTS consents_scope_check;
+ALTER TABLE consents ADD CONSTRAINT consents_scope_check
+    CHECK (scope IN ('tracking', 'audio', 'community_broadcast', 'responder_sharing'));
@@ -0,0 +1,6 @@
+-- Remove the reference locations of contact recipients
+ALTER TABLE contact_recipients
+    DROP COLUMN IF EXISTS ref_label,
+    DROP COLUMN IF EXISTS ref_lat,
+    DROP COLUMN IF EXISTS ref_lng,
+    DROP COLUMN IF EXISTS ref_address;
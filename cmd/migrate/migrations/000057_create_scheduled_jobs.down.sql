@@ -0,0 +1,2 @@
+-- Remove the status of scheduled jobs
+DROP TABLE IF EXISTS scheduled_jobs;
@@ -0,0 +1,17 @@
+-- Status of each background job run by the scheduler, as last recorded by
+-- any instance: when it last ran, for how long and with what error, and when
+-- it runs next. Rows are written when a job runs; a job no instance runs any
+-- more keeps its last row.
+CREATE TABLE IF NOT EXISTS scheduled_jobs (
+    name TEXT PRIMARY KEY,
+    schedule TEXT NOT NULL,
+    singleton BOOLEAN NOT NULL DEFAULT false,
+    last_started_at TIMESTAMPTZ,
+    last_duration_ms BIGINT,
+    last_error TEXT,
+    last_instance TEXT,
+    next_run_at TIMESTAMPTZ,
+    runs BIGINT NOT NULL DEFAULT 0,
+    failures BIGINT NOT NULL DEFAULT 0,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
@@ -0,0 +1,11 @@
+-- Drops the record of alerts' handoffs to emergency response agencies
+DROP INDEX IF EXISTS idx_alerts_agency_escalated_at;
+ALTER TABLE alerts DROP CONSTRAINT IF EXISTS alerts_agency_status_check;
+ALTER TABLE alerts DROP COLUMN IF EXISTS agency_handed_off_at;
+ALTER TABLE alerts DROP COLUMN IF EXISTS agency_escalated_at;
+ALTER TABLE alerts DROP COLUMN IF EXISTS agency_error;
+ALTER TABLE alerts DROP COLUMN IF EXISTS agency_reference;
+ALTER TABLE alerts DROP COLUMN IF EXISTS agency_channel;
+ALTER TABLE alerts DROP COLUMN IF EXISTS agency_target;
+ALTER TABLE alerts DROP COLUMN IF EXISTS agency_region;
+ALTER TABLE alerts DROP COLUMN IF EXISTS agency_status;
@@ -0,0 +1,20 @@
+-- Handoff of an alert to an emergency response agency, for users with
+-- auto_escalate_police: its status (pending while being sent, then sent,
+-- failed, skipped because a contact acknowledged it, or unrouted when no
+-- target matched), the region it was resolved from, the target and channel
+-- it went to, the reference the agency's system returned and any error.
+-- An alert is handed off once.
+ALTER TABLE alerts ADD COLUMN IF NOT EXISTS agency_status TEXT;
+ALTER TABLE alerts ADD COLUMN IF NOT EXISTS agency_region TEXT;
+ALTER TABLE alerts ADD COLUMN IF NOT EXISTS agency_target TEXT;
+ALTER TABLE alerts ADD COLUMN IF NOT EXISTS agency_channel TEXT;
+ALTER TABLE alerts ADD COLUMN IF NOT EXISTS agency_reference TEXT;
+ALTER TABLE alerts ADD COLUMN IF NOT EXISTS agency_error TEXT;
+ALTER TABLE alerts ADD COLUMN IF NOT EXISTS agency_escalated_at TIMESTAMPTZ;
+ALTER TABLE alerts ADD COLUMN IF NOT EXISTS agency_handed_off_at TIMESTAMPTZ;
+
+ALTER TABLE alerts DROP CONSTRAINT IF EXISTS alerts_agency_status_check;
+ALTER TABLE alerts ADD CONSTRAINT alerts_agency_status_check
+    CHECK (agency_status IN ('pending', 'sent', 'failed', 'skipped', 'unrouted'));
+
+CREATE INDEX IF NOT EXISTS idx_alerts_agency_escalated_at ON alerts(agency_escalated_at) WHERE agency_escalated_at IS NOT NULL;
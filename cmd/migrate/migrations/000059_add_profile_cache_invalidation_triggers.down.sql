@@ -0,0 +1,4 @@
+DROP TRIGGER IF EXISTS scoring_profiles_cache_invalidation ON scoring_profiles;
+DROP TRIGGER IF EXISTS organizations_cache_invalidation ON organizations;
+DROP FUNCTION IF EXISTS scoring_profiles_cache_invalidation();
+DROP FUNCTION IF EXISTS organizations_cache_invalidation();
@@ -0,0 +1,27 @@
+-- Instances cache each user's organization scoring profile and hold the
+-- active and candidate scoring profiles in memory. These triggers publish
+-- changes to organizations and scoring profiles on the
+-- safetrace_cache_invalidation channel, like those of migration 047.
+CREATE OR REPLACE FUNCTION organizations_cache_invalidation()
+RETURNS TRIGGER AS $$
+BEGIN
+    PERFORM notify_cache_invalidation('organization', OLD.id::text);
+    RETURN NULL;
+END;
+$$ language 'plpgsql';
+
+CREATE TRIGGER organizations_cache_invalidation AFTER UPDATE OR DELETE ON organizations
+    FOR EACH ROW EXECUTE FUNCTION organizations_cache_invalidation();
+
+-- No key: the active and candidate profiles are reloaded together
+CREATE OR REPLACE FUNCTION scoring_profiles_cache_invalidation()
+RETURNS TRIGGER AS $$
+BEGIN
+    PERFORM pg_notify('safetrace_cache_invalidation',
+        json_build_object('kind', 'scoring_profile')::text);
+    RETURN NULL;
+END;
+$$ language 'plpgsql';
+
+CREATE TRIGGER scoring_profiles_cache_invalidation AFTER INSERT OR UPDATE OR DELETE ON scoring_profiles
+    FOR EACH STATEMENT EXECUTE FUNCTION scoring_profiles_cache_invalidation();
@@ -0,0 +1,20 @@
+-- Notification rules a guardian sets on an account link, for anomalies
+-- smaller than alerts: the ward's phone silent for max_silent_hours, or the
+-- ward not moving for max_stationary_hours, and an opt-in daily "all good"
+-- digest. Each rule records the episode it last notified about (the last
+-- heartbeat before the silence, the start of the stillness) and when, so
+-- an episode is notified once and notices are spaced by a cooldown.
+CREATE TABLE IF NOT EXISTS guardian_rules (
+    link_id UUID PRIMARY KEY REFERENCES account_links(id) ON DELETE CASCADE,
+    max_silent_hours INT CHECK (max_silent_hours BETWEEN 1 AND 72),
+    max_stationary_hours INT CHECK (max_stationary_hours BETWEEN 1 AND 24),
+    daily_digest BOOLEAN NOT NULL DEFAULT FALSE,
+    channel VARCHAR(10) NOT NULL DEFAULT 'push' CHECK (channel IN ('push', 'sms')),
+    silent_episode TIMESTAMPTZ,
+    silent_notified_at TIMESTAMPTZ,
+    stationary_episode TIMESTAMPTZ,
+    stationary_notified_at TIMESTAMPTZ,
+    digest_sent_on DATE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
@@ -0,0 +1,3 @@
+-- Drops the notes on panics and their alerts
+ALTER TABLE alerts DROP COLUMN IF EXISTS note;
+ALTER TABLE panic_requests DROP COLUMN IF EXISTS note;
@@ -0,0 +1,6 @@
+-- A short note the user typed before pressing panic, e.g. where they are or
+-- what they are in, kept with the panic through its cancellation window and
+-- with the alert it raised, whose message quotes it. Cleaned and capped by
+-- the API before it is stored.
+ALTER TABLE panic_requests ADD COLUMN IF NOT EXISTS note TEXT;
+ALTER TABLE alerts ADD COLUMN IF NOT EXISTS note TEXT;
@@ -0,0 +1,236 @@
+// Package apierror defines the errors handlers return to API clients. Each
+// error carries a stable code, an HTTP status and a message that is safe to
+// show; the internal cause is only ever logged.
+package apierror
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+)
+
+// Error codes sent to clients
+const (
+	CodeInvalidRequest   = "invalid_request"
+	CodeValidationFailed = "validation_failed"
+	CodeUnauthorized     = "unauthorized"
+	CodeForbidden        = "forbidden"
+	CodeConsentRequired  = "consent_required"
+	CodeNotFound         = "not_found"
+	CodeNotAcceptable    = "not_acceptable"
+	CodeConflict         = "conflict"
+	CodeGone             = "gone"
+	CodeIntegrityFailed  = "integrity_failed"
+	CodeTooLarge         = "payload_too_large"
+	CodeUnsupportedType  = "unsupported_media_type"
+	CodeRateLimited      = "rate_limited"
+	CodeUnavailable      = "unavailable"
+	CodeInternal         = "internal_error"
+)
+
+// Error is an API error. Message and Fields are sent to the client; Cause is not.
+type Error struct {
+	Code    string
+	Status  int
+	Message string
+	Fields  []FieldError
+	Cause   error
+}
+
+// FieldError names a request field that failed validation
+type FieldError struct {
+	Field  string `json:"field"`
+	Reason string `json:"reason"`
+}
+
+func (e *Error) Error() string {
+	if e.Cause != nil {
+		return fmt.Sprintf("%s: %s: %v", e.Code, e.Message, e.Cause)
+	}
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Cause
+}
+
+// WithCause attaches the internal error that led to this one, for logging
+func (e *Error) WithCause(cause error) *Error {
+	copied := *e
+	copied.Cause = cause
+	return &copied
+}
+
+func New(status int, code, message string) *Error {
+	return &Error{Code: code, Status: status, Message: message}
+}
+
+func BadRequest(message string) *Error {
+	return New(http.StatusBadRequest, CodeInvalidRequest, message)
+}
+
+func Unauthorized(message string) *Error {
+	return New(http.StatusUnauthorized, CodeUnauthorized, message)
+}
+
+func Forbidden(message string) *Error {
+	return New(http.StatusForbidden, CodeForbidden, message)
+}
+
+// ConsentRequired is a Forbidden the user can lift by consenting to the
+// current privacy policy
+func ConsentRequired(message string) *Error {
+	return New(http.StatusForbidden, CodeConsentRequired, message)
+}
+
+func NotFound(message string) *Error {
+	return New(http.StatusNotFound, CodeNotFound, message)
+}
+
+func NotAcceptable(message string) *Error {
+	return New(http.StatusNotAcceptable, CodeNotAcceptable, message)
+}
+
+func Conflict(message string) *Error {
+	return New(http.StatusConflict, CodeConflict, message)
+}
+
+func Gone(message string) *Error {
+	return New(http.StatusGone, CodeGone, message)
+}
+
+func PayloadTooLarge(message string) *Error {
+	return New(http.StatusRequestEntityTooLarge, CodeTooLarge, message)
+}
+
+func UnsupportedMediaType(message string) *Error {
+	return New(http.StatusUnsupportedMediaType, CodeUnsupportedType, message)
+}
+
+func TooManyRequests(message string) *Error {
+	return New(http.StatusTooManyRequests, CodeRateLimited, message)
+}
+
+func Unavailable(message string) *Error {
+	return New(http.StatusServiceUnavailable, CodeUnavailable, message)
+}
+
+// Internal hides the cause behind a generic message
+func Internal(message string, cause error) *Error {
+	return New(http.StatusInternalServerError, CodeInternal, message).WithCause(cause)
+}
+
+// Invalid reports a failed field check performed by handler or service code
+func Invalid(field, reason string) *Error {
+	e := New(http.StatusBadRequest, CodeValidationFailed, "request validation failed")
+	e.Fields = []FieldError{{Field: field, Reason: reason}}
+	return e
+}
+
+// Unprocessable reports fields of a well-formed request that are missing or
+// out of range, found by checks binding tags can't express
+func Unprocessable(fields []FieldError) *Error {
+	e := New(http.StatusUnprocessableEntity, CodeValidationFailed, "request validation failed")
+	e.Fields = fields
+	return e
+}
+
+// Validation converts a request binding error into a validation error that
+// lists the offending fields. Malformed JSON becomes a plain invalid_request.
+func Validation(err error) *Error {
+	var verrs validator.ValidationErrors
+	if errors.As(err, &verrs) {
+		e := New(http.StatusBadRequest, CodeValidationFailed, "request validation failed").WithCause(err)
+		for _, fe := range verrs {
+			e.Fields = append(e.Fields, FieldError{Field: fieldName(fe), Reason: reason(fe)})
+		}
+		return e
+	}
+
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		e := New(http.StatusBadRequest, CodeValidationFailed, "request validation failed").WithCause(err)
+		e.Fields = []FieldError{{Field: typeErr.Field, Reason: "must be " + jsonType(typeErr.Type)}}
+		return e
+	}
+
+	return BadRequest("malformed request body").WithCause(err)
+}
+
+// From returns err as an *Error, treating anything else as an internal error
+func From(err error) *Error {
+	var apiErr *Error
+	if errors.As(err, &apiErr) {
+		return apiErr
+	}
+	return Internal("internal server error", err)
+}
+
+// jsonType describes a Go type the way a JSON client sees it
+func jsonType(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.String:
+		return "a string"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	default:
+		return "an object"
+	}
+}
+
+// fieldName drops the struct name from the validator namespace, e.g.
+// HeartbeatRequest.cell_info.mcc becomes cell_info.mcc. Field names are the
+// JSON names once UseJSONFieldNames has been applied to the validator.
+func fieldName(fe validator.FieldError) string {
+	ns := fe.Namespace()
+	if i := strings.Index(ns, "."); i >= 0 {
+		return ns[i+1:]
+	}
+	return ns
+}
+
+// UseJSONFieldNames makes the validator report fields by their json tag
+func UseJSONFieldNames(v *validator.Validate) {
+	v.RegisterTagNameFunc(func(f reflect.StructField) string {
+		name := strings.SplitN(f.Tag.Get("json"), ",", 2)[0]
+		if name == "-" {
+			return ""
+		}
+		if name == "" {
+			return f.Name
+		}
+		return name
+	})
+}
+
+func reason(fe validator.FieldError) string {
+	switch fe.Tag() {
+	case "required":
+		return "is required"
+	case "oneof":
+		return "must be one of " + fe.Param()
+	case "min":
+		return "must be at least " + fe.Param()
+	case "max":
+		return "must be at most " + fe.Param()
+	case "gt":
+		return "must be greater than " + fe.Param()
+	case "gte":
+		return "must be at least " + fe.Param()
+	case "lte":
+		return "must be at most " + fe.Param()
+	default:
+		return "failed " + fe.Tag() + " check"
+	}
+}
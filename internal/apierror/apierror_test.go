@@ -0,0 +1,119 @@
+package apierror
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/go-playground/validator/v10"
+)
+
+// Codes and statuses are part of the API: clients branch on them
+func TestConstructors(t *testing.T) {
+	tests := []struct {
+		err        *Error
+		wantStatus int
+		wantCode   string
+	}{
+		{BadRequest("x"), http.StatusBadRequest, "invalid_request"},
+		{Unauthorized("x"), http.StatusUnauthorized, "unauthorized"},
+		{Forbidden("x"), http.StatusForbidden, "forbidden"},
+		{ConsentRequired("x"), http.StatusForbidden, "consent_required"},
+		{NotFound("x"), http.StatusNotFound, "not_found"},
+		{NotAcceptable("x"), http.StatusNotAcceptable, "not_acceptable"},
+		{Conflict("x"), http.StatusConflict, "conflict"},
+		{Gone("x"), http.StatusGone, "gone"},
+		{PayloadTooLarge("x"), http.StatusRequestEntityTooLarge, "payload_too_large"},
+		{UnsupportedMediaType("x"), http.StatusUnsupportedMediaType, "unsupported_media_type"},
+		{TooManyRequests("x"), http.StatusTooManyRequests, "rate_limited"},
+		{Unavailable("x"), http.StatusServiceUnavailable, "unavailable"},
+		{Internal("x", nil), http.StatusInternalServerError, "internal_error"},
+		{Invalid("lat", "is required"), http.StatusBadRequest, "validation_failed"},
+		{Unprocessable(nil), http.StatusUnprocessableEntity, "validation_failed"},
+	}
+	for _, tt := range tests {
+		if tt.err.Status != tt.wantStatus || tt.err.Code != tt.wantCode {
+			t.Errorf("%s = %d %s, want %d %s", tt.err.Message, tt.err.Status, tt.err.Code, tt.wantStatus, tt.wantCode)
+		}
+	}
+}
+
+func TestWithCauseCopies(t *testing.T) {
+	base := NotFound("user not found")
+	cause := errors.New("no rows")
+	withCause := base.WithCause(cause)
+	if base.Cause != nil {
+		t.Errorf("WithCause changed the original")
+	}
+	if !errors.Is(withCause, cause) {
+		t.Errorf("errors.Is(%v, cause) = false", withCause)
+	}
+}
+
+func TestFrom(t *testing.T) {
+	notFound := NotFound("user not found")
+	if got := From(notFound); got != notFound {
+		t.Errorf("From(*Error) = %v, want it as is", got)
+	}
+	if got := From(fmt.Errorf("loading user: %w", notFound)); got != notFound {
+		t.Errorf("From(wrapped *Error) = %v, want the *Error", got)
+	}
+
+	plain := errors.New("connection refused")
+	got := From(plain)
+	if got.Status != http.StatusInternalServerError || got.Code != CodeInternal || got.Message != "internal server error" {
+		t.Errorf("From(plain) = %+v", got)
+	}
+	if !errors.Is(got, plain) {
+		t.Errorf("From(plain) lost its cause")
+	}
+}
+
+type validationRequest struct {
+	Lat      *float64 `json:"lat" validate:"required"`
+	Interval int      `json:"interval_s" validate:"min=30"`
+	Mode     string   `json:"mode" validate:"oneof=walk drive"`
+}
+
+func TestValidation(t *testing.T) {
+	v := validator.New()
+	UseJSONFieldNames(v)
+	verr := v.Struct(validationRequest{Interval: 5, Mode: "fly"})
+
+	var req struct {
+		Lat float64 `json:"lat"`
+	}
+	typeErr := json.Unmarshal([]byte(`{"lat":"six"}`), &req)
+
+	tests := []struct {
+		name       string
+		err        error
+		wantCode   string
+		wantFields []FieldError
+	}{
+		{"binding tags", verr, CodeValidationFailed, []FieldError{
+			{Field: "lat", Reason: "is required"},
+			{Field: "interval_s", Reason: "must be at least 30"},
+			{Field: "mode", Reason: "must be one of walk drive"},
+		}},
+		{"wrong JSON type", typeErr, CodeValidationFailed, []FieldError{{Field: "lat", Reason: "must be a number"}}},
+		{"malformed JSON", json.Unmarshal([]byte(`{"lat":`), &req), CodeInvalidRequest, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Validation(tt.err)
+			if got.Status != http.StatusBadRequest || got.Code != tt.wantCode {
+				t.Errorf("Validation() = %d %s, want 400 %s", got.Status, got.Code, tt.wantCode)
+			}
+			if !reflect.DeepEqual(got.Fields, tt.wantFields) {
+				t.Errorf("fields = %+v, want %+v", got.Fields, tt.wantFields)
+			}
+			if got.Cause == nil {
+				t.Errorf("Validation() lost its cause")
+			}
+		})
+	}
+}
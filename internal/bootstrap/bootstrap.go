@@ -0,0 +1,171 @@
+// Package bootstrap brings up the API's dependencies at startup. Postgres
+// and Redis may still be coming back from a failover when the container
+// starts, so connecting is retried with bounded exponential backoff instead
+// of exiting, and each dependency's progress is reported to the readiness
+// endpoint. Boot is the server's handler: it can serve health checks while
+// the dependencies connect and switches to the API once they have.
+package bootstrap
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Dependency states
+const (
+	StateConnecting = "connecting"
+	StateConnected  = "connected"
+	StateFailed     = "failed" // gave up after the last attempt
+)
+
+// Policy bounds the retries of connecting to a dependency
+type Policy struct {
+	Attempts       int           // tries before giving up
+	AttemptTimeout time.Duration // longest one try may take
+	InitialBackoff time.Duration // wait after the first failure, doubled after each one
+	MaxBackoff     time.Duration
+}
+
+// Backoff returns the wait after the given failed attempt, counting from 1
+func (p Policy) Backoff(attempt int) time.Duration {
+	wait := p.InitialBackoff
+	for i := 1; i < attempt && wait < p.MaxBackoff; i++ {
+		wait *= 2
+	}
+	if wait > p.MaxBackoff {
+		wait = p.MaxBackoff
+	}
+	return wait
+}
+
+// DependencyStatus is how connecting to one dependency is going
+type DependencyStatus struct {
+	State       string     `json:"state"` // connecting | connected | failed
+	Attempts    int        `json:"attempts"`
+	Error       string     `json:"error,omitempty"` // of the last failed attempt
+	ConnectedAt *time.Time `json:"connected_at,omitempty"`
+}
+
+// Boot tracks the dependencies being connected and serves HTTP with
+// whichever handler is current
+type Boot struct {
+	policy Policy
+
+	mu   sync.Mutex
+	deps map[string]*DependencyStatus
+
+	handler atomic.Pointer[http.Handler]
+}
+
+// New returns a Boot waiting on the named dependencies, so none of them
+// counts as ready before its first attempt
+func New(policy Policy, dependencies ...string) *Boot {
+	b := &Boot{
+		policy: policy,
+		deps:   make(map[string]*DependencyStatus),
+	}
+	for _, name := range dependencies {
+		b.deps[name] = &DependencyStatus{State: StateConnecting}
+	}
+	b.Serve(http.NotFoundHandler())
+	return b
+}
+
+// Connect calls dial until it succeeds, at most policy.Attempts times, each
+// bounded by policy.AttemptTimeout and separated by the backoff. It gives
+// up early when ctx is done. Progress is reported under name.
+func Connect[T any](ctx context.Context, b *Boot, name string, dial func(ctx context.Context) (T, error)) (T, error) {
+	var zero T
+	b.update(name, func(s *DependencyStatus) { s.State = StateConnecting })
+
+	for attempt := 1; ; attempt++ {
+		attemptCtx, cancel := context.WithTimeout(ctx, b.policy.AttemptTimeout)
+		conn, err := dial(attemptCtx)
+		cancel()
+		if err == nil {
+			now := time.Now()
+			b.update(name, func(s *DependencyStatus) {
+				s.State, s.Attempts, s.Error, s.ConnectedAt = StateConnected, attempt, "", &now
+			})
+			return conn, nil
+		}
+
+		b.update(name, func(s *DependencyStatus) { s.Attempts, s.Error = attempt, err.Error() })
+		if attempt >= b.policy.Attempts {
+			b.update(name, func(s *DependencyStatus) { s.State = StateFailed })
+			return zero, fmt.Errorf("%s unreachable after %d attempts: %w", name, attempt, err)
+		}
+
+		wait := b.policy.Backoff(attempt)
+		log.Printf("WARN: Connecting to %s failed (attempt %d of %d), retrying in %s: %v", name, attempt, b.policy.Attempts, wait, err)
+		if err := sleepContext(ctx, wait); err != nil {
+			b.update(name, func(s *DependencyStatus) { s.State = StateFailed })
+			return zero, fmt.Errorf("gave up connecting to %s: %w", name, err)
+		}
+	}
+}
+
+// Statuses returns every dependency's status by name
+func (b *Boot) Statuses() map[string]DependencyStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	statuses := make(map[string]DependencyStatus, len(b.deps))
+	for name, s := range b.deps {
+		statuses[name] = *s
+	}
+	return statuses
+}
+
+// Pending returns the dependencies that haven't connected, sorted
+func (b *Boot) Pending() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var pending []string
+	for name, s := range b.deps {
+		if s.State != StateConnected {
+			pending = append(pending, name)
+		}
+	}
+	sort.Strings(pending)
+	return pending
+}
+
+// Serve makes h answer every request from now on
+func (b *Boot) Serve(h http.Handler) {
+	b.handler.Store(&h)
+}
+
+func (b *Boot) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	(*b.handler.Load()).ServeHTTP(w, r)
+}
+
+func (b *Boot) update(name string, change func(s *DependencyStatus)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	s, ok := b.deps[name]
+	if !ok {
+		s = &DependencyStatus{State: StateConnecting}
+		b.deps[name] = s
+	}
+	change(s)
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
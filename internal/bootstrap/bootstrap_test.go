@@ -0,0 +1,63 @@
+package bootstrap
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+var testPolicy = Policy{Attempts: 3, AttemptTimeout: 50 * time.Millisecond, InitialBackoff: time.Millisecond, MaxBackoff: 2 * time.Millisecond}
+
+// A dependency that never answers is tried Attempts times, then reported failed
+func TestConnectFailing(t *testing.T) {
+	b := New(testPolicy, "postgres")
+	dials := 0
+	_, err := Connect(context.Background(), b, "postgres", func(ctx context.Context) (int, error) {
+		dials++
+		return 0, errors.New("connection refused")
+	})
+	if err == nil || dials != testPolicy.Attempts {
+		t.Fatalf("Connect() = %v after %d dials, want failure after %d", err, dials, testPolicy.Attempts)
+	}
+	s := b.Statuses()["postgres"]
+	if s.State != StateFailed || s.Attempts != testPolicy.Attempts || s.Error != "connection refused" {
+		t.Errorf("status = %+v, want failed after %d attempts", s, testPolicy.Attempts)
+	}
+	if pending := b.Pending(); len(pending) != 1 || pending[0] != "postgres" {
+		t.Errorf("Pending() = %v, want [postgres]", pending)
+	}
+}
+
+// A hung dial is cut off by the attempt timeout; a later attempt connects
+func TestConnectRecovers(t *testing.T) {
+	b := New(testPolicy, "redis")
+	dials := 0
+	conn, err := Connect(context.Background(), b, "redis", func(ctx context.Context) (string, error) {
+		dials++
+		if dials == 1 {
+			<-ctx.Done()
+			return "", ctx.Err()
+		}
+		return "conn", nil
+	})
+	if err != nil || conn != "conn" || dials != 2 {
+		t.Fatalf("Connect() = %q, %v after %d dials; want connected on the second", conn, err, dials)
+	}
+	s := b.Statuses()["redis"]
+	if s.State != StateConnected || s.Error != "" || s.ConnectedAt == nil {
+		t.Errorf("status = %+v, want connected", s)
+	}
+	if pending := b.Pending(); len(pending) != 0 {
+		t.Errorf("Pending() = %v, want none", pending)
+	}
+}
+
+func TestPolicyBackoff(t *testing.T) {
+	p := Policy{InitialBackoff: time.Second, MaxBackoff: 5 * time.Second}
+	for attempt, want := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 3: 4 * time.Second, 4: 5 * time.Second, 10: 5 * time.Second} {
+		if got := p.Backoff(attempt); got != want {
+			t.Errorf("Backoff(%d) = %v, want %v", attempt, got, want)
+		}
+	}
+}
@@ -0,0 +1,268 @@
+// Package cache holds in-process caches that every API instance keeps, and
+// the registry that evicts their entries when the data behind them changes
+// on any instance. Changes arrive as Events, published by Postgres triggers
+// and delivered over LISTEN/NOTIFY; entries also expire after a TTL, so a
+// missed event is bounded.
+package cache
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// errLoadPanicked is what callers sharing a load get when it panicked
+var errLoadPanicked = errors.New("cache load panicked")
+
+// Cache is a map of entries that expire ttl after they are stored. Past
+// maxEntries, storing evicts the least recently used entry. Loads through
+// GetOrLoad are shared: concurrent misses for one key run a single load.
+type Cache[K comparable, V any] struct {
+	ttl        time.Duration
+	maxEntries int
+	now        func() time.Time
+
+	mu      sync.Mutex
+	entries map[K]*list.Element // of *entry[K, V]
+	lru     *list.List          // most recently used first
+	loads   map[K]*load[V]
+
+	stats counters
+}
+
+type entry[K comparable, V any] struct {
+	key       K
+	value     V
+	expiresAt time.Time
+}
+
+// load is a GetOrLoad call in flight, which later callers for its key wait on
+type load[V any] struct {
+	done  chan struct{}
+	value V
+	err   error
+	stale bool // its key was deleted or flushed while it ran, so it isn't stored
+}
+
+// Stats counts a cache's use since it was created
+type Stats struct {
+	Hits       int64
+	Misses     int64
+	Loads      int64         // loads run by GetOrLoad; shared ones count once
+	LoadErrors int64         // of those, loads that failed
+	LoadTime   time.Duration // spent in loads
+	Shared     int64         // misses that waited for a load already running
+	Evictions  int64         // entries dropped to stay within maxEntries
+	Entries    int
+	MaxEntries int
+	TTL        time.Duration
+}
+
+type counters struct {
+	hits, misses, loads, loadErrors, loadNanos, shared, evictions atomic.Int64
+}
+
+// New returns a cache whose entries live for ttl, holding at most
+// maxEntries; 0 leaves it unbounded. A ttl of 0 or less disables it:
+// nothing is stored.
+func New[K comparable, V any](ttl time.Duration, maxEntries int) *Cache[K, V] {
+	return &Cache[K, V]{
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		now:        time.Now,
+		entries:    make(map[K]*list.Element),
+		lru:        list.New(),
+		loads:      make(map[K]*load[V]),
+	}
+}
+
+// Get returns the entry stored for key, if it hasn't expired
+func (c *Cache[K, V]) Get(key K) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	value, ok := c.get(key)
+	c.count(ok)
+	return value, ok
+}
+
+// get looks key up and marks it used. The caller holds mu.
+func (c *Cache[K, V]) get(key K) (V, bool) {
+	el, ok := c.entries[key]
+	if !ok {
+		var zero V
+		return zero, false
+	}
+	e := el.Value.(*entry[K, V])
+	if !c.now().Before(e.expiresAt) {
+		c.remove(el)
+		var zero V
+		return zero, false
+	}
+	c.lru.MoveToFront(el)
+	return e.value, true
+}
+
+func (c *Cache[K, V]) count(hit bool) {
+	if hit {
+		c.stats.hits.Add(1)
+	} else {
+		c.stats.misses.Add(1)
+	}
+}
+
+// Set stores value for key
+func (c *Cache[K, V]) Set(key K, value V) {
+	c.SetTTL(key, value, c.ttl)
+}
+
+// SetTTL stores value for key for ttl instead of the cache's own, e.g. a
+// shorter one for a negative result. A disabled cache stores nothing.
+func (c *Cache[K, V]) SetTTL(key K, value V, ttl time.Duration) {
+	if c.ttl <= 0 || ttl <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.set(key, value, ttl)
+}
+
+// set stores an entry, evicting the least recently used one if the cache is
+// full. The caller holds mu.
+func (c *Cache[K, V]) set(key K, value V, ttl time.Duration) {
+	expiresAt := c.now().Add(ttl)
+	if el, ok := c.entries[key]; ok {
+		e := el.Value.(*entry[K, V])
+		e.value, e.expiresAt = value, expiresAt
+		c.lru.MoveToFront(el)
+		return
+	}
+	if c.maxEntries > 0 && len(c.entries) >= c.maxEntries {
+		if oldest := c.lru.Back(); oldest != nil {
+			c.remove(oldest)
+			c.stats.evictions.Add(1)
+		}
+	}
+	c.entries[key] = c.lru.PushFront(&entry[K, V]{key: key, value: value, expiresAt: expiresAt})
+}
+
+// remove drops an entry. The caller holds mu.
+func (c *Cache[K, V]) remove(el *list.Element) {
+	c.lru.Remove(el)
+	delete(c.entries, el.Value.(*entry[K, V]).key)
+}
+
+// GetOrLoad returns the entry stored for key, or loads, stores and returns
+// it. While a load runs, other callers for the same key wait for it rather
+// than load again, so a hot key that expires costs one query. A failed load
+// is returned to all of them and not stored. A load overtaken by Delete or
+// Flush of its key is returned but not stored, since it may be stale. The
+// load runs with the first caller's context.
+func (c *Cache[K, V]) GetOrLoad(ctx context.Context, key K, fn func(ctx context.Context) (V, error)) (V, error) {
+	c.mu.Lock()
+	if value, ok := c.get(key); ok {
+		c.mu.Unlock()
+		c.count(true)
+		return value, nil
+	}
+	c.count(false)
+	if l, ok := c.loads[key]; ok {
+		c.mu.Unlock()
+		c.stats.shared.Add(1)
+		select {
+		case <-l.done:
+			return l.value, l.err
+		case <-ctx.Done():
+			var zero V
+			return zero, ctx.Err()
+		}
+	}
+	l := &load[V]{done: make(chan struct{})}
+	c.loads[key] = l
+	c.mu.Unlock()
+
+	started := time.Now()
+	l.err = errLoadPanicked // until fn returns, so a panic still releases the waiters
+	defer c.finish(key, l, started)
+	l.value, l.err = fn(ctx)
+	return l.value, l.err
+}
+
+// finish stores a load's value unless it failed or went stale, and releases
+// the callers waiting for it
+func (c *Cache[K, V]) finish(key K, l *load[V], started time.Time) {
+	c.stats.loads.Add(1)
+	c.stats.loadNanos.Add(int64(time.Since(started)))
+	if l.err != nil {
+		c.stats.loadErrors.Add(1)
+	}
+
+	c.mu.Lock()
+	delete(c.loads, key)
+	if l.err == nil && !l.stale && c.ttl > 0 {
+		c.set(key, l.value, c.ttl)
+	}
+	c.mu.Unlock()
+	close(l.done)
+}
+
+// Delete evicts key's entry
+func (c *Cache[K, V]) Delete(key K) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[key]; ok {
+		c.remove(el)
+	}
+	if l, ok := c.loads[key]; ok {
+		l.stale = true
+	}
+}
+
+// Flush evicts every entry
+func (c *Cache[K, V]) Flush() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = make(map[K]*list.Element)
+	c.lru.Init()
+	for _, l := range c.loads {
+		l.stale = true
+	}
+}
+
+// Len is the number of entries stored, expired or not
+func (c *Cache[K, V]) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
+
+// Stats returns the cache's counters and size
+func (c *Cache[K, V]) Stats() Stats {
+	return Stats{
+		Hits:       c.stats.hits.Load(),
+		Misses:     c.stats.misses.Load(),
+		Loads:      c.stats.loads.Load(),
+		LoadErrors: c.stats.loadErrors.Load(),
+		LoadTime:   time.Duration(c.stats.loadNanos.Load()),
+		Shared:     c.stats.shared.Load(),
+		Evictions:  c.stats.evictions.Load(),
+		Entries:    c.Len(),
+		MaxEntries: c.maxEntries,
+		TTL:        c.ttl,
+	}
+}
+
+// Evicter returns c as an Evicter for keys parse understands. A key parse
+// rejects, like one for a row that isn't cached here, is ignored.
+func (c *Cache[K, V]) Evicter(parse func(string) (K, bool)) Evicter {
+	return Funcs{
+		EvictFunc: func(key string) {
+			if k, ok := parse(key); ok {
+				c.Delete(k)
+			}
+		},
+		FlushFunc: c.Flush,
+	}
+}
@@ -0,0 +1,337 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeClock is a time a test moves by hand
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (f *fakeClock) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+func (f *fakeClock) Advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.now = f.now.Add(d)
+}
+
+func newTestCache(ttl time.Duration, maxEntries int) (*Cache[string, int], *fakeClock) {
+	clock := &fakeClock{now: time.Date(2026, 3, 9, 12, 0, 0, 0, time.UTC)}
+	c := New[string, int](ttl, maxEntries)
+	c.now = clock.Now
+	return c, clock
+}
+
+func TestTTL(t *testing.T) {
+	c, clock := newTestCache(time.Minute, 0)
+	c.Set("ada", 1)
+	c.SetTTL("negative", 0, 10*time.Second)
+
+	clock.Advance(59 * time.Second)
+	if v, ok := c.Get("ada"); !ok || v != 1 {
+		t.Errorf("Get() before the TTL = %d, %v", v, ok)
+	}
+	if _, ok := c.Get("negative"); ok {
+		t.Errorf("entry outlived its own shorter TTL")
+	}
+
+	clock.Advance(time.Second)
+	if _, ok := c.Get("ada"); ok {
+		t.Errorf("entry outlived the TTL")
+	}
+	if c.Len() != 0 {
+		t.Errorf("Len() = %d, want expired entries dropped on lookup", c.Len())
+	}
+
+	// Storing again restarts the TTL
+	c.Set("ada", 1)
+	clock.Advance(30 * time.Second)
+	c.Set("ada", 2)
+	clock.Advance(45 * time.Second)
+	if v, ok := c.Get("ada"); !ok || v != 2 {
+		t.Errorf("Get() after a second Set = %d, %v, want 2", v, ok)
+	}
+
+	stats := c.Stats()
+	if stats.Hits != 2 || stats.Misses != 2 {
+		t.Errorf("hits, misses = %d, %d, want 2, 2", stats.Hits, stats.Misses)
+	}
+}
+
+func TestDisabled(t *testing.T) {
+	c, _ := newTestCache(0, 0)
+	c.Set("ada", 1)
+	if _, ok := c.Get("ada"); ok || c.Len() != 0 {
+		t.Errorf("a disabled cache stored an entry")
+	}
+
+	loads := 0
+	for i := 0; i < 2; i++ {
+		v, err := c.GetOrLoad(context.Background(), "ada", func(context.Context) (int, error) {
+			loads++
+			return 7, nil
+		})
+		if err != nil || v != 7 {
+			t.Fatalf("GetOrLoad() = %d, %v", v, err)
+		}
+	}
+	if loads != 2 {
+		t.Errorf("a disabled cache loaded %d times for 2 lookups", loads)
+	}
+}
+
+// Past maxEntries the least recently used entry goes, where a Get counts as use
+func TestLRUEviction(t *testing.T) {
+	c, _ := newTestCache(time.Hour, 3)
+	c.Set("a", 1)
+	c.Set("b", 2)
+	c.Set("c", 3)
+	c.Get("a")    // b is now the oldest
+	c.Set("c", 4) // updating doesn't evict
+	c.Set("d", 5)
+
+	if _, ok := c.Get("b"); ok {
+		t.Errorf("b, the least recently used, wasn't evicted")
+	}
+	for _, key := range []string{"a", "c", "d"} {
+		if _, ok := c.Get(key); !ok {
+			t.Errorf("%s was evicted", key)
+		}
+	}
+
+	// Under pressure the cache stays at its size
+	for i := 0; i < 100; i++ {
+		c.Set(string(rune('e'+i)), i)
+	}
+	stats := c.Stats()
+	if stats.Entries != 3 || stats.Evictions != 101 || stats.MaxEntries != 3 {
+		t.Errorf("entries, evictions, max = %d, %d, %d, want 3, 101, 3", stats.Entries, stats.Evictions, stats.MaxEntries)
+	}
+}
+
+// waitFor polls cond until it holds or a second has passed
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// Concurrent misses for one key run one load, and all get its value
+func TestGetOrLoadStampede(t *testing.T) {
+	c, _ := newTestCache(time.Minute, 0)
+	const callers = 50
+	release := make(chan struct{})
+	var loads atomic.Int64
+
+	var wg sync.WaitGroup
+	results := make(chan int, callers)
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			v, err := c.GetOrLoad(context.Background(), "profile", func(context.Context) (int, error) {
+				loads.Add(1)
+				<-release
+				return 42, nil
+			})
+			if err != nil {
+				t.Errorf("GetOrLoad: %v", err)
+			}
+			results <- v
+		}()
+	}
+	waitFor(t, "callers to queue behind the load", func() bool { return c.Stats().Shared == callers-1 })
+	close(release)
+	wg.Wait()
+	close(results)
+
+	for v := range results {
+		if v != 42 {
+			t.Errorf("caller got %d, want 42", v)
+		}
+	}
+	stats := c.Stats()
+	if loads.Load() != 1 || stats.Loads != 1 || stats.Misses != callers {
+		t.Errorf("loads %d, counted %d, misses %d, want 1, 1, %d", loads.Load(), stats.Loads, stats.Misses, callers)
+	}
+
+	// And it is stored: the next lookup is a hit
+	if _, err := c.GetOrLoad(context.Background(), "profile", func(context.Context) (int, error) {
+		t.Error("loaded again on a warm cache")
+		return 0, nil
+	}); err != nil {
+		t.Errorf("GetOrLoad: %v", err)
+	}
+}
+
+// startLoad begins a GetOrLoad of key that blocks until release is closed,
+// and returns once it is running
+func startLoad(t *testing.T, c *Cache[string, int], key string, value int, err error, release <-chan struct{}) <-chan error {
+	t.Helper()
+	started := make(chan struct{})
+	done := make(chan error, 1)
+	go func() {
+		_, loadErr := c.GetOrLoad(context.Background(), key, func(context.Context) (int, error) {
+			close(started)
+			<-release
+			return value, err
+		})
+		done <- loadErr
+	}()
+	<-started
+	return done
+}
+
+func TestGetOrLoadFailureShared(t *testing.T) {
+	c, _ := newTestCache(time.Minute, 0)
+	release := make(chan struct{})
+	errDown := errors.New("connection refused")
+	first := startLoad(t, c, "ada", 0, errDown, release)
+
+	waiter := make(chan error, 1)
+	go func() {
+		_, err := c.GetOrLoad(context.Background(), "ada", func(context.Context) (int, error) {
+			t.Error("a second load ran alongside the first")
+			return 0, nil
+		})
+		waiter <- err
+	}()
+	waitFor(t, "the waiter", func() bool { return c.Stats().Shared == 1 })
+	close(release)
+
+	if err := <-first; !errors.Is(err, errDown) {
+		t.Errorf("loader got %v", err)
+	}
+	if err := <-waiter; !errors.Is(err, errDown) {
+		t.Errorf("waiter got %v, want the load's error", err)
+	}
+	if c.Len() != 0 || c.Stats().LoadErrors != 1 {
+		t.Errorf("failed load: %d entries, %d load errors, want 0, 1", c.Len(), c.Stats().LoadErrors)
+	}
+}
+
+// A waiter whose context ends stops waiting; the load carries on
+func TestGetOrLoadWaiterCancelled(t *testing.T) {
+	c, _ := newTestCache(time.Minute, 0)
+	release := make(chan struct{})
+	first := startLoad(t, c, "ada", 1, nil, release)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := c.GetOrLoad(ctx, "ada", nil); !errors.Is(err, context.Canceled) {
+		t.Errorf("cancelled waiter got %v", err)
+	}
+
+	close(release)
+	if err := <-first; err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if v, ok := c.Get("ada"); !ok || v != 1 {
+		t.Errorf("Get() = %d, %v, want the load stored", v, ok)
+	}
+}
+
+// A load overtaken by Delete or Flush of its key returns its value, which
+// may be stale, without storing it
+func TestGetOrLoadStale(t *testing.T) {
+	for name, invalidate := range map[string]func(*Cache[string, int]){
+		"Delete": func(c *Cache[string, int]) { c.Delete("ada") },
+		"Flush":  func(c *Cache[string, int]) { c.Flush() },
+	} {
+		t.Run(name, func(t *testing.T) {
+			c, _ := newTestCache(time.Minute, 0)
+			release := make(chan struct{})
+			first := startLoad(t, c, "ada", 1, nil, release)
+			invalidate(c)
+			close(release)
+			if err := <-first; err != nil {
+				t.Fatalf("load: %v", err)
+			}
+			if _, ok := c.Get("ada"); ok {
+				t.Errorf("stale load was stored")
+			}
+		})
+	}
+}
+
+// A panicking load releases its waiters with an error, stores nothing and
+// leaves the key free for the next load
+func TestGetOrLoadPanic(t *testing.T) {
+	c, _ := newTestCache(time.Minute, 0)
+	release := make(chan struct{})
+	started := make(chan struct{})
+	recovered := make(chan any, 1)
+	go func() {
+		defer func() { recovered <- recover() }()
+		_, _ = c.GetOrLoad(context.Background(), "ada", func(context.Context) (int, error) {
+			close(started)
+			<-release
+			panic("nil map")
+		})
+	}()
+	<-started
+
+	waiter := make(chan error, 1)
+	go func() {
+		_, err := c.GetOrLoad(context.Background(), "ada", nil)
+		waiter <- err
+	}()
+	waitFor(t, "the waiter", func() bool { return c.Stats().Shared == 1 })
+	close(release)
+
+	if r := <-recovered; r != "nil map" {
+		t.Errorf("panic = %v, want it passed on to the loading caller", r)
+	}
+	select {
+	case err := <-waiter:
+		if !errors.Is(err, errLoadPanicked) {
+			t.Errorf("waiter got %v, want errLoadPanicked", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("waiter still blocked after the load panicked")
+	}
+
+	v, err := c.GetOrLoad(context.Background(), "ada", func(context.Context) (int, error) { return 2, nil })
+	if err != nil || v != 2 {
+		t.Errorf("GetOrLoad() after the panic = %d, %v, want a new load", v, err)
+	}
+}
+
+func TestRegistryInvalidate(t *testing.T) {
+	users, _ := newTestCache(time.Minute, 0)
+	users.Set("ada", 1)
+	users.Set("bola", 2)
+	r := NewRegistry()
+	r.Register(KindUser, users.Evicter(func(key string) (string, bool) { return key, key != "" }))
+
+	r.Invalidate(Event{Kind: KindContacts, Key: "ada"}) // not registered
+	r.Invalidate(Event{Kind: KindUser, Key: "ada"})
+	if _, ok := users.Get("ada"); ok {
+		t.Errorf("ada wasn't evicted")
+	}
+	if _, ok := users.Get("bola"); !ok {
+		t.Errorf("bola was evicted by ada's event")
+	}
+
+	r.Invalidate(Event{Kind: KindUser})
+	if users.Len() != 0 {
+		t.Errorf("an event without a key didn't flush")
+	}
+}
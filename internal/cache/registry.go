@@ -0,0 +1,194 @@
+package cache
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+)
+
+// Channel is the Postgres NOTIFY channel invalidations are published on
+const Channel = "safetrace_cache_invalidation"
+
+// Kind names what changed, and so which caches an Event evicts from
+type Kind string
+
+const (
+	// KindUser events are keyed by user ID
+	KindUser Kind = "user"
+	// KindContacts events are keyed by the ID of the user whose trusted
+	// contacts changed
+	KindContacts Kind = "contacts"
+	// KindGeofences events are keyed by the ID of the user whose safe zones
+	// changed, or GeofencesBroadcasts for the areas of admin broadcasts
+	KindGeofences Kind = "geofences"
+	// KindTemplate events mean the message templates changed; they have no key
+	KindTemplate Kind = "template"
+	// KindOrganization events are keyed by the ID of the organization that
+	// changed, its scoring profile among its settings
+	KindOrganization Kind = "organization"
+	// KindScoringProfile events mean the active or candidate scoring profile
+	// changed; they have no key
+	KindScoringProfile Kind = "scoring_profile"
+)
+
+// GeofencesBroadcasts is the KindGeofences key of broadcast areas
+const GeofencesBroadcasts = "broadcasts"
+
+// Event says that data of one kind changed. An empty Key means every entry
+// of the kind is stale.
+type Event struct {
+	Kind Kind   `json:"kind"`
+	Key  string `json:"key,omitempty"`
+}
+
+// ParseEvent decodes a NOTIFY payload
+func ParseEvent(payload string) (Event, error) {
+	var e Event
+	if err := json.Unmarshal([]byte(payload), &e); err != nil {
+		return Event{}, fmt.Errorf("invalid invalidation payload %q: %w", payload, err)
+	}
+	if e.Kind == "" {
+		return Event{}, fmt.Errorf("invalidation payload %q has no kind", payload)
+	}
+	return e, nil
+}
+
+// Evicter is a cache an Event can evict from
+type Evicter interface {
+	Evict(key string)
+	Flush()
+}
+
+// Funcs adapts a pair of functions to an Evicter. A nil function does
+// nothing.
+type Funcs struct {
+	EvictFunc func(key string)
+	FlushFunc func()
+}
+
+func (f Funcs) Evict(key string) {
+	if f.EvictFunc != nil {
+		f.EvictFunc(key)
+	}
+}
+
+func (f Funcs) Flush() {
+	if f.FlushFunc != nil {
+		f.FlushFunc()
+	}
+}
+
+// Statser is a cache whose use can be reported; every Cache is one
+type Statser interface {
+	Stats() Stats
+}
+
+// Registry routes Events to the caches registered for their kind, and
+// reports the use of the caches tracked by name
+type Registry struct {
+	mu       sync.RWMutex
+	evicters map[Kind][]Evicter
+	tracked  map[string]Statser
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		evicters: make(map[Kind][]Evicter),
+		tracked:  make(map[string]Statser),
+	}
+}
+
+// Track reports c's use under name in Stats and WriteMetrics. Tracking a
+// name again replaces the cache reported under it.
+func (r *Registry) Track(name string, c Statser) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.tracked[name] = c
+}
+
+// Stats returns the use of every tracked cache, by name
+func (r *Registry) Stats() map[string]Stats {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	stats := make(map[string]Stats, len(r.tracked))
+	for name, c := range r.tracked {
+		stats[name] = c.Stats()
+	}
+	return stats
+}
+
+// WriteMetrics writes the use of every tracked cache in the Prometheus text
+// format, labelled by cache name
+func (r *Registry) WriteMetrics(w io.Writer) {
+	stats := r.Stats()
+	names := make([]string, 0, len(stats))
+	for name := range stats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	write := func(metric, kind, help string, value func(Stats) int64) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", metric, help, metric, kind)
+		for _, name := range names {
+			fmt.Fprintf(w, "%s{cache=%q} %d\n", metric, name, value(stats[name]))
+		}
+	}
+	write("safetrace_cache_hits_total", "counter", "Lookups answered from the in-process cache.",
+		func(s Stats) int64 { return s.Hits })
+	write("safetrace_cache_misses_total", "counter", "Lookups the in-process cache couldn't answer.",
+		func(s Stats) int64 { return s.Misses })
+	write("safetrace_cache_shared_loads_total", "counter", "Misses that waited for a load of the same key already running.",
+		func(s Stats) int64 { return s.Shared })
+	write("safetrace_cache_load_errors_total", "counter", "Loads into the cache that failed.",
+		func(s Stats) int64 { return s.LoadErrors })
+	write("safetrace_cache_evictions_total", "counter", "Entries dropped to keep the cache within its size.",
+		func(s Stats) int64 { return s.Evictions })
+	write("safetrace_cache_entries", "gauge", "Entries held, expired ones included until they are looked up or evicted.",
+		func(s Stats) int64 { return int64(s.Entries) })
+	write("safetrace_cache_max_entries", "gauge", "Entries the cache holds at most; 0 is unbounded.",
+		func(s Stats) int64 { return int64(s.MaxEntries) })
+
+	fmt.Fprintln(w, "# HELP safetrace_cache_load_duration_seconds Time spent loading entries into the cache.")
+	fmt.Fprintln(w, "# TYPE safetrace_cache_load_duration_seconds summary")
+	for _, name := range names {
+		s := stats[name]
+		fmt.Fprintf(w, "safetrace_cache_load_duration_seconds_sum{cache=%q} %g\n", name, s.LoadTime.Seconds())
+		fmt.Fprintf(w, "safetrace_cache_load_duration_seconds_count{cache=%q} %d\n", name, s.Loads)
+	}
+}
+
+// Register adds a cache that events of kind evict from
+func (r *Registry) Register(kind Kind, e Evicter) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.evicters[kind] = append(r.evicters[kind], e)
+}
+
+// Invalidate evicts the event's key from every cache of its kind, or
+// flushes them when it has no key. Unknown kinds are ignored.
+func (r *Registry) Invalidate(e Event) {
+	r.mu.RLock()
+	evicters := r.evicters[e.Kind]
+	r.mu.RUnlock()
+	for _, ev := range evicters {
+		if e.Key == "" {
+			ev.Flush()
+		} else {
+			ev.Evict(e.Key)
+		}
+	}
+}
+
+// Flush empties every registered cache, for when events may have been
+// missed
+func (r *Registry) Flush() {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, evicters := range r.evicters {
+		for _, ev := range evicters {
+			ev.Flush()
+		}
+	}
+}
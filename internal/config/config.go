@@ -3,8 +3,16 @@ package config
 import (
 	"fmt"
 	"os"
+	"slices"
 	"strconv"
+	"strings"
+	"time"
 
+	"github.com/adedejiosvaldo/safetrace/backend/internal/country"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/flags"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/keys"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/utils"
 	"github.com/joho/godotenv"
 )
 
@@ -12,54 +20,470 @@ type Config struct {
 	// Server
 	Port string
 
+	// Countries served, the home country first. Phone numbers, roaming,
+	// emergency numbers and default time zones follow the profile of the
+	// user's country: the home one, or in a multi-country deployment the one
+	// their phone number is from.
+	Countries country.Set
+
+	// How widely each feature flag is on, from FEATURE_FLAGS; flags left
+	// out keep their defaults. Admins' overrides for a user win over these.
+	FeatureFlags flags.Rollouts
+
 	// Database
 	DatabaseURL string
 	RedisURL    string
 
+	// Redis keys live under "<namespace>:v<version>:"; keys of an older
+	// version are rewritten or expired at startup when a migration is set
+	RedisNamespace    string
+	RedisKeyMigration string // off, rewrite or expire
+
+	// Startup: connecting to Postgres and Redis is retried with exponential
+	// backoff; with degraded boot the server answers health checks meanwhile
+	StartupConnectAttempts       int
+	StartupConnectTimeoutSeconds int // longest one connection attempt may take
+	StartupMaxBackoffSeconds     int
+	StartupDegradedBoot          bool
+
 	// Security
-	HMACSecret string
-	JWTSecret  string
+	HMACSecret              string
+	JWTSecret               string
+	TokenTTLHours           int
+	ContactTokenTTLHours    int  // lifetime of contact access tokens
+	LegacySignaturesEnabled bool // accept pre-v1 JSON-map heartbeat signatures
+
+	// Version 2 heartbeat signatures: from the sunset on, only v2 is accepted
+	SignatureV1Sunset      time.Time // zero keeps accepting v1 and legacy signatures
+	HeartbeatNonceTTLHours int       // how long v2 nonces are kept; older v2 heartbeats are rejected
+
+	// HMAC rotation: the previous secret stays valid for the overlap after a reload
+	HMACSecretPrevious         string
+	HMACRotationOverlapSeconds int
+
+	// Notifications: "twilio" sends for real, "dev" only logs and buffers
+	Notifier string
 
 	// Twilio
 	TwilioAccountSID  string
 	TwilioAuthToken   string
 	TwilioPhoneNumber string
 
+	// Alternate SMS providers
+	TermiiAPIKey           string
+	TermiiSenderID         string
+	AfricasTalkingUsername string
+	AfricasTalkingAPIKey   string
+	AfricasTalkingSenderID string
+
+	// Inbound SMS webhook authentication; Twilio's uses TwilioAuthToken
+	TermiiWebhookSecret        string
+	AfricasTalkingWebhookToken string
+
+	// SMS routing
+	SMSDefaultProvider string
+	SMSCarrierRoutes   map[string]string // carrier -> provider, e.g. MTN=termii
+	SMSHeartbeatAck    string            // which SMS heartbeats are answered: none | lastgasp | all
+	PublicBaseURL      string            // used to build delivery status callback URLs
+
+	// Texts to a user whose phone is roaming abroad, other than panic
+	// confirmations, are dropped to save international SMS charges
+	RoamingSMSSuppressed bool
+
+	// USSD gateway
+	USSDCallbackToken string // the callback URL's token query parameter; empty disables USSD
+
+	// Global budget for outbound SMS and WhatsApp messages
+	OutboundMessagesPerMinute     int
+	OutboundMessagesPerDay        int
+	OutboundEmergencyReservePct   int // share of each cap kept for alerts; other messages are dropped past the rest
+	OutboundEmergencyOverdraftPct int // share of each cap alerts may still use past it; they are dropped beyond
+
+	// Outbound message wording
+	MessageTemplatesFile string // JSON object of template name to text; empty uses the built-in wording
+
 	// Firebase
 	FCMCredentialsPath string
 
+	// Object storage for blackbox trails (Google Cloud Storage)
+	BlackboxBucket         string  // empty disables object storage
+	BlackboxMigrationRate  float64 // inline trails moved to the bucket per second
+	BlackboxMigrationBatch int
+	// Share of a trail's data points that must pass validation for the
+	// upload to be accepted; the rest are quarantined
+	BlackboxMinValidFraction float64
+
 	// Mapbox
-	MapboxToken string
+	MapboxToken            string
+	AlertMapRetentionHours int // map snapshots of alerts are deleted this long after the alert is resolved
+
+	// Investigation bundles of resolved alerts
+	IncidentBundleRetentionHours int // a bundle and its download link expire this long after it is built
+
+	// what3words addresses in alerts
+	What3WordsAPIKey    string // empty disables what3words
+	What3WordsTimeoutMS int    // a lookup is abandoned after this; alerts never wait for one
 
 	// Thresholds
 	HeartbeatIntervalSeconds int
 	HeartbeatWindowSeconds   int
 	LastGaspTimeoutSeconds   int
+	LastGaspRearmSeconds     int // a LastGasp this soon after the previous one updates it instead of opening another; 0 disables
+	LastGaspEscalateSeconds  int // a LastGasp wait this long without a heartbeat is AT_RISK; 0 waits out the timeout
 	SilentPromptSeconds      int
 	BlackboxRetentionHours   int
+	ScoreSafeThreshold       int // score >= this is SAFE
+	ScoreCautionThreshold    int // score >= this is CAUTION
+
+	// Adaptive heartbeat intervals
+	HeartbeatIntervalMinSeconds int // shortest interval the server advises
+	HeartbeatIntervalMaxSeconds int // longest interval the server advises
+	DangerZoneHours             int // how long a broadcast's area counts as a danger zone
+
+	// Score history
+	ScoreHistoryRetentionHours int
+	ScoreTrendWindow           int // evaluations the trend is measured over; 0 disables it
+
+	// Heartbeat signature failure lockout
+	SignatureFailureThreshold     int // failures within the window that lock the user out
+	SignatureFailureWindowSeconds int
+	SignatureLockoutSeconds       int
+	SignatureFailureNotify        bool // push a warning to the user when a lockout starts
+
+	// Crash detection from blackbox sensor data
+	ImpactThresholdG   float64 // acceleration magnitude that counts as an impact
+	ImpactStillSeconds int     // motionless time after the impact that confirms it
+	ImpactWorkers      int
+
+	// Heartbeat streams during an alert
+	StreamMaxPerUser   int // streams one user may have open at once
+	StreamLineMaxBytes int // longest line a stream accepts
+	StreamAckSeconds   int // how often a stream is acknowledged
+	StreamIdleSeconds  int // how long a stream may send nothing before it is closed
+	StreamMaxMinutes   int // how long one stream may stay open
+
+	// Coarse location mode
+	CoarseLocationDecimals  int // decimal places a coarse heartbeat's coordinates keep
+	CoarsePreciseTTLSeconds int // how long the precise fix of a coarse heartbeat is kept in Redis
+	PrecisionBufferMinutes  int // how long heartbeats stay precise after an incident
+
+	// Protection pauses
+	ProtectionPauseMaxMinutes int // longest pause a user may request
+
+	// Responder API
+	ResponderRateLimitPerMinute int // queries a responder key may make per minute
+
+	// Watch sessions
+	WatchMaxMinutes int // longest watch a user may request
+
+	// Panics from the app
+	PanicCancelWindowSeconds int // how long the user has to cancel with their PIN; 0 sends at once
+
+	// Welfare checks
+	WelfareCheckTimeoutMinutes int // how long the user has to confirm they are okay
+	WelfareCheckDailyLimit     int // checks one contact or guardian may request per user per day
+
+	// Auto-resolution of alerts: healthy heartbeats in a row from a safe zone
+	// before the user is asked to confirm they're home safe, and how long
+	// they have to confirm
+	AutoResolveHeartbeats    int
+	AutoResolvePromptMinutes int
+
+	// Reconciliation of alerts left unresolved: how old an alert is before
+	// the reconciler looks at it, and how long its user must have been SAFE
+	// for it to be closed
+	AlertReconcileAgeMinutes  int
+	AlertReconcileSafeMinutes int
+
+	// Handoff of alerts to emergency response agencies, for users with
+	// auto_escalate_police: how long an ALERT goes unresolved before it is
+	// handed off, the JSON file of per-state targets, the national default
+	// where none matches (no default when the endpoint is empty), and the
+	// number agencies are told to call back (the user's own when empty)
+	AgencyEscalationMinutes int
+	AgencyTargetsFile       string
+	AgencyDefaultChannel    string
+	AgencyDefaultEndpoint   string
+	AgencyCallbackPhone     string
+
+	// Unverified fallback heartbeats, from apps that lost their keys: reports
+	// one phone number or client IP may send per hour, how many of them go
+	// without a proof of work, and the work's difficulty in leading zero bits
+	UnverifiedPerPhonePerHour int
+	UnverifiedPerIPPerHour    int
+	UnverifiedFreePerHour     int
+	UnverifiedPoWBits         int
+
+	// Guardian notices about a ward's tracking gaps: the least time between
+	// two notices of one rule, and the ward's local hour from which the
+	// daily "all good" digest goes out
+	GuardianNoticeCooldownHours int
+	GuardianDigestHour          int
+
+	// App activity
+	ActivityTTLSeconds            int // how long an activity ping counts as the user being in the app
+	ActivitySuppressionMaxMinutes int // past the heartbeat window, how long activity can hold off a staleness alert; 0 disables
+
+	// Trusted contacts
+	MaxTrustedContacts int
+
+	// Contact pacing: non-critical messages one phone number gets in ten
+	// minutes across every user they protect, before the rest are held for
+	// a combined update; 0 disables pacing. Contacts may set their own.
+	ContactPacingMaxPer10Min int
+
+	// In-process user cache: how long an instance keeps a user it read, in
+	// case an invalidation was missed; 0 disables it. Read at startup.
+	UserCacheTTLSeconds int
+
+	// Devices
+	DeviceDisagreementKm float64 // devices reporting in the same window further apart than this are flagged
+
+	// Notification channels
+	ChannelDisableAfterFailures int // rejected deliveries in a row that disable a channel
+
+	// Incident heatmap
+	HeatmapPrecision       int // default geohash length of heatmap bins
+	HeatmapMinUsers        int // distinct users a bin needs to be published (k-anonymity)
+	HeatmapCacheTTLSeconds int
+
+	// Outage detection: this many users of one network going silent in the
+	// same geohash cell within the window mark it a probable outage zone
+	OutageMinUsers        int
+	OutageWindowMinutes   int
+	OutagePrecision       int // geohash length of outage zones
+	OutageCooldownMinutes int // how long a zone stays active after the last user went silent there
+	OutageGraceMinutes    int // how much longer staleness in an active zone waits to escalate; 0 disables
+
+	// Heartbeat ingestion
+	HeartbeatBufferEnabled   bool // false keeps the synchronous INSERT path
+	HeartbeatBufferSize      int
+	HeartbeatBatchSize       int
+	HeartbeatFlushIntervalMs int
+
+	// How long a heartbeat's fingerprint is remembered, to spot a copy of it
+	// sent over another channel; 0 turns duplicate detection off
+	HeartbeatDedupWindowSeconds int
+
+	// Evaluation and alert delivery workers
+	EvaluationWorkers   int // shards; a user's evaluations always run on the same one
+	EvaluationQueueSize int // queued users per shard before callers wait
+	AlertSendWorkers    int
+	ShadowQueueSize     int // evaluations waiting to be repeated with the candidate profile before new ones are dropped
+
+	EvaluationStaleSeconds       int // age of a queued evaluation's heartbeat past which it is rechecked before running
+	EvaluationLagDegradedSeconds int // queue lag past which /health/ready reports degraded
+
+	// Warmup after Redis loses its data
+	RedisWarmupRate          int // users restored per second
+	RedisWarmupLookbackHours int // users with a live heartbeat this recent are restored
+
+	// Deployment-wide recipient, e.g. a monitoring desk, of alerts for users
+	// with nobody else to alert; empty leaves those alerts undeliverable
+	FallbackAlertPhone string
+
+	// Alert latency SLO and metrics
+	AlertSLOTargetSeconds int    // detection to first confirmed delivery; slower alerts breach the SLO
+	MetricsToken          string // bearer token for GET /metrics; empty disables it
+
+	// Broadcasts
+	BroadcastRatePerSecond       int
+	BroadcastActiveWindowMinutes int
+
+	// Consent: the current privacy policy version of each consent scope, e.g.
+	// tracking=2. A scope left out needs no consent; raising a version asks
+	// everyone who consented under an older one to consent again.
+	ConsentPolicyVersions map[string]int
+
+	// Audit log
+	AuditQueueSize       int
+	AuditBatchSize       int
+	AuditFlushIntervalMs int
 }
 
 func Load() (*Config, error) {
 	// Load .env file if it exists
 	_ = godotenv.Load()
 
+	return fromEnv()
+}
+
+// LoadFile re-reads the given env file, overriding variables already set in the
+// process, and builds a validated Config. Used for reloads; variables removed
+// from the file keep their previous value.
+func LoadFile(path string) (*Config, error) {
+	if err := godotenv.Overload(path); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	return fromEnv()
+}
+
+func fromEnv() (*Config, error) {
 	cfg := &Config{
-		Port:                     getEnv("PORT", "8080"),
-		DatabaseURL:              getEnv("DATABASE_URL", ""),
-		RedisURL:                 getEnv("REDIS_URL", "redis://localhost:6379"),
-		HMACSecret:               getEnv("HMAC_SECRET", ""),
-		JWTSecret:                getEnv("JWT_SECRET", ""),
-		TwilioAccountSID:         getEnv("TWILIO_ACCOUNT_SID", ""),
-		TwilioAuthToken:          getEnv("TWILIO_AUTH_TOKEN", ""),
-		TwilioPhoneNumber:        getEnv("TWILIO_PHONE_NUMBER", ""),
-		FCMCredentialsPath:       getEnv("FCM_CREDENTIALS_PATH", ""),
-		MapboxToken:              getEnv("MAPBOX_TOKEN", ""),
-		HeartbeatIntervalSeconds: getEnvInt("HEARTBEAT_INTERVAL_SECONDS", 180),    // 3 min
-		HeartbeatWindowSeconds:   getEnvInt("HEARTBEAT_WINDOW_SECONDS", 600),      // 10 min
-		LastGaspTimeoutSeconds:   getEnvInt("LASTGASP_TIMEOUT_SECONDS", 3600),     // 60 min
-		SilentPromptSeconds:      getEnvInt("SILENT_PROMPT_SECONDS", 10),          // 10 sec
-		BlackboxRetentionHours:   getEnvInt("BLACKBOX_RETENTION_HOURS", 12),       // 12 hours
+		Port:                          getEnv("PORT", "8080"),
+		DatabaseURL:                   getEnv("DATABASE_URL", ""),
+		RedisURL:                      getEnv("REDIS_URL", "redis://localhost:6379"),
+		RedisNamespace:                getEnv("REDIS_NAMESPACE", "safetrace"),
+		RedisKeyMigration:             getEnv("REDIS_KEY_MIGRATION", "off"),
+		StartupConnectAttempts:        getEnvInt("STARTUP_CONNECT_ATTEMPTS", 10),
+		StartupConnectTimeoutSeconds:  getEnvInt("STARTUP_CONNECT_TIMEOUT_SECONDS", 5),
+		StartupMaxBackoffSeconds:      getEnvInt("STARTUP_MAX_BACKOFF_SECONDS", 30),
+		StartupDegradedBoot:           getEnvBool("STARTUP_DEGRADED_BOOT", true),
+		HMACSecret:                    getEnv("HMAC_SECRET", ""),
+		JWTSecret:                     getEnv("JWT_SECRET", ""),
+		TokenTTLHours:                 getEnvInt("TOKEN_TTL_HOURS", 720),          // 30 days
+		ContactTokenTTLHours:          getEnvInt("CONTACT_TOKEN_TTL_HOURS", 2160), // 90 days
+		LegacySignaturesEnabled:       getEnvBool("LEGACY_SIGNATURES_ENABLED", true),
+		HeartbeatNonceTTLHours:        getEnvInt("HEARTBEAT_NONCE_TTL_HOURS", 72),
+		HMACSecretPrevious:            getEnv("HMAC_SECRET_PREVIOUS", ""),
+		HMACRotationOverlapSeconds:    getEnvInt("HMAC_ROTATION_OVERLAP_SECONDS", 3600),
+		Notifier:                      getEnv("NOTIFIER", "twilio"),
+		TwilioAccountSID:              getEnv("TWILIO_ACCOUNT_SID", ""),
+		TwilioAuthToken:               getEnv("TWILIO_AUTH_TOKEN", ""),
+		TwilioPhoneNumber:             getEnv("TWILIO_PHONE_NUMBER", ""),
+		TermiiAPIKey:                  getEnv("TERMII_API_KEY", ""),
+		TermiiSenderID:                getEnv("TERMII_SENDER_ID", "SafeTrace"),
+		AfricasTalkingUsername:        getEnv("AFRICASTALKING_USERNAME", ""),
+		AfricasTalkingAPIKey:          getEnv("AFRICASTALKING_API_KEY", ""),
+		AfricasTalkingSenderID:        getEnv("AFRICASTALKING_SENDER_ID", ""),
+		TermiiWebhookSecret:           getEnv("TERMII_WEBHOOK_SECRET", ""),
+		AfricasTalkingWebhookToken:    getEnv("AFRICASTALKING_WEBHOOK_TOKEN", ""),
+		SMSDefaultProvider:            getEnv("SMS_DEFAULT_PROVIDER", "twilio"),
+		SMSCarrierRoutes:              getEnvMap("SMS_CARRIER_ROUTES", "MTN=termii,GLO=termii"),
+		SMSHeartbeatAck:               getEnv("SMS_HEARTBEAT_ACK", "lastgasp"),
+		PublicBaseURL:                 getEnv("PUBLIC_BASE_URL", ""),
+		RoamingSMSSuppressed:          getEnvBool("ROAMING_SMS_SUPPRESSED", false),
+		USSDCallbackToken:             getEnv("USSD_CALLBACK_TOKEN", ""),
+		OutboundMessagesPerMinute:     getEnvInt("OUTBOUND_MESSAGES_PER_MINUTE", 300),
+		OutboundMessagesPerDay:        getEnvInt("OUTBOUND_MESSAGES_PER_DAY", 20000),
+		OutboundEmergencyReservePct:   getEnvInt("OUTBOUND_EMERGENCY_RESERVE_PCT", 20),
+		OutboundEmergencyOverdraftPct: getEnvInt("OUTBOUND_EMERGENCY_OVERDRAFT_PCT", 25),
+		MessageTemplatesFile:          getEnv("MESSAGE_TEMPLATES_FILE", ""),
+		FCMCredentialsPath:            getEnv("FCM_CREDENTIALS_PATH", ""),
+		BlackboxBucket:                getEnv("BLACKBOX_BUCKET", ""),
+		BlackboxMigrationRate:         getEnvFloat("BLACKBOX_MIGRATION_ROWS_PER_SECOND", 5),
+		BlackboxMigrationBatch:        getEnvInt("BLACKBOX_MIGRATION_BATCH_SIZE", 20),
+		BlackboxMinValidFraction:      getEnvFloat("BLACKBOX_MIN_VALID_FRACTION", 0.5),
+		MapboxToken:                   getEnv("MAPBOX_TOKEN", ""),
+		AlertMapRetentionHours:        getEnvInt("ALERT_MAP_RETENTION_HOURS", 168), // 7 days
+		IncidentBundleRetentionHours:  getEnvInt("INCIDENT_BUNDLE_RETENTION_HOURS", 72),
+		What3WordsAPIKey:              getEnv("WHAT3WORDS_API_KEY", ""),
+		What3WordsTimeoutMS:           getEnvInt("WHAT3WORDS_TIMEOUT_MS", 1500),
+		HeartbeatIntervalSeconds:      getEnvInt("HEARTBEAT_INTERVAL_SECONDS", 180), // 3 min
+		HeartbeatWindowSeconds:        getEnvInt("HEARTBEAT_WINDOW_SECONDS", 600),   // 10 min
+		LastGaspTimeoutSeconds:        getEnvInt("LASTGASP_TIMEOUT_SECONDS", 3600),  // 60 min
+		LastGaspRearmSeconds:          getEnvInt("LASTGASP_REARM_SECONDS", 900),     // 15 min
+		LastGaspEscalateSeconds:       getEnvInt("LASTGASP_ESCALATE_SECONDS", 2400), // 40 min
+		SilentPromptSeconds:           getEnvInt("SILENT_PROMPT_SECONDS", 10),       // 10 sec
+		BlackboxRetentionHours:        getEnvInt("BLACKBOX_RETENTION_HOURS", 12),    // 12 hours
+		ScoreSafeThreshold:            getEnvInt("SCORE_SAFE_THRESHOLD", 80),
+		ScoreCautionThreshold:         getEnvInt("SCORE_CAUTION_THRESHOLD", 50),
+		HeartbeatIntervalMinSeconds:   getEnvInt("HEARTBEAT_INTERVAL_MIN_SECONDS", 60),
+		HeartbeatIntervalMaxSeconds:   getEnvInt("HEARTBEAT_INTERVAL_MAX_SECONDS", 900), // 15 min
+		DangerZoneHours:               getEnvInt("DANGER_ZONE_HOURS", 6),
+		ScoreHistoryRetentionHours:    getEnvInt("SCORE_HISTORY_RETENTION_HOURS", 168), // 7 days
+		ScoreTrendWindow:              getEnvInt("SCORE_TREND_WINDOW", 8),
+		SignatureFailureThreshold:     getEnvInt("SIGNATURE_FAILURE_THRESHOLD", 10),
+		SignatureFailureWindowSeconds: getEnvInt("SIGNATURE_FAILURE_WINDOW_SECONDS", 300), // 5 min
+		SignatureLockoutSeconds:       getEnvInt("SIGNATURE_LOCKOUT_SECONDS", 900),        // 15 min
+		SignatureFailureNotify:        getEnvBool("SIGNATURE_FAILURE_NOTIFY", true),
+		ImpactThresholdG:              getEnvFloat("IMPACT_THRESHOLD_G", 4),
+		ImpactStillSeconds:            getEnvInt("IMPACT_STILL_SECONDS", 30),
+		ImpactWorkers:                 getEnvInt("IMPACT_WORKERS", 2),
+		StreamMaxPerUser:              getEnvInt("STREAM_MAX_PER_USER", 2),
+		StreamLineMaxBytes:            getEnvInt("STREAM_LINE_MAX_BYTES", 4096),
+		StreamAckSeconds:              getEnvInt("STREAM_ACK_SECONDS", 10),
+		StreamIdleSeconds:             getEnvInt("STREAM_IDLE_SECONDS", 60),
+		StreamMaxMinutes:              getEnvInt("STREAM_MAX_MINUTES", 60),
+		CoarseLocationDecimals:        getEnvInt("COARSE_LOCATION_DECIMALS", 2),     // about 1.1km
+		CoarsePreciseTTLSeconds:       getEnvInt("COARSE_PRECISE_TTL_SECONDS", 600), // 10 min
+		PrecisionBufferMinutes:        getEnvInt("PRECISION_ESCALATION_BUFFER_MINUTES", 60),
+		ProtectionPauseMaxMinutes:     getEnvInt("PROTECTION_PAUSE_MAX_MINUTES", 720), // 12 hours
+		ResponderRateLimitPerMinute:   getEnvInt("RESPONDER_RATE_LIMIT_PER_MINUTE", 30),
+		WatchMaxMinutes:               getEnvInt("WATCH_MAX_MINUTES", 240),
+		PanicCancelWindowSeconds:      getEnvInt("PANIC_CANCEL_WINDOW_SECONDS", 15),
+		WelfareCheckTimeoutMinutes:    getEnvInt("WELFARE_CHECK_TIMEOUT_MINUTES", 15),
+		WelfareCheckDailyLimit:        getEnvInt("WELFARE_CHECK_DAILY_LIMIT", 2),
+		AutoResolveHeartbeats:         getEnvInt("AUTO_RESOLVE_HEARTBEATS", 3),
+		AutoResolvePromptMinutes:      getEnvInt("AUTO_RESOLVE_PROMPT_MINUTES", 15),
+		AlertReconcileAgeMinutes:      getEnvInt("ALERT_RECONCILE_AGE_MINUTES", 360),
+		AlertReconcileSafeMinutes:     getEnvInt("ALERT_RECONCILE_SAFE_MINUTES", 720),
+		AgencyEscalationMinutes:       getEnvInt("AGENCY_ESCALATION_MINUTES", 10),
+		AgencyTargetsFile:             getEnv("AGENCY_TARGETS_FILE", ""),
+		AgencyDefaultChannel:          getEnv("AGENCY_DEFAULT_CHANNEL", models.AgencyChannelSMS),
+		UnverifiedPerPhonePerHour:     getEnvInt("UNVERIFIED_PER_PHONE_PER_HOUR", 10),
+		UnverifiedPerIPPerHour:        getEnvInt("UNVERIFIED_PER_IP_PER_HOUR", 30),
+		UnverifiedFreePerHour:         getEnvInt("UNVERIFIED_FREE_PER_HOUR", 3),
+		UnverifiedPoWBits:             getEnvInt("UNVERIFIED_POW_BITS", 20),
+		GuardianNoticeCooldownHours:   getEnvInt("GUARDIAN_NOTICE_COOLDOWN_HOURS", 12),
+		GuardianDigestHour:            getEnvInt("GUARDIAN_DIGEST_HOUR", 20),
+		ActivityTTLSeconds:            getEnvInt("ACTIVITY_TTL_SECONDS", 300),
+		ActivitySuppressionMaxMinutes: getEnvInt("ACTIVITY_SUPPRESSION_MAX_MINUTES", 60),
+		MaxTrustedContacts:            getEnvInt("MAX_TRUSTED_CONTACTS", 10),
+		ContactPacingMaxPer10Min:      getEnvInt("CONTACT_PACING_MAX_PER_10MIN", 3),
+		UserCacheTTLSeconds:           getEnvInt("USER_CACHE_TTL_SECONDS", 300),
+		DeviceDisagreementKm:          getEnvFloat("DEVICE_DISAGREEMENT_KM", 5),
+		ChannelDisableAfterFailures:   getEnvInt("CHANNEL_DISABLE_AFTER_FAILURES", 3),
+		HeatmapPrecision:              getEnvInt("HEATMAP_PRECISION", 5),
+		HeatmapMinUsers:               getEnvInt("HEATMAP_MIN_USERS", 5),
+		HeatmapCacheTTLSeconds:        getEnvInt("HEATMAP_CACHE_TTL_SECONDS", 900),
+		OutageMinUsers:                getEnvInt("OUTAGE_MIN_USERS", 5),
+		OutageWindowMinutes:           getEnvInt("OUTAGE_WINDOW_MINUTES", 15),
+		OutagePrecision:               getEnvInt("OUTAGE_PRECISION", 5),
+		OutageCooldownMinutes:         getEnvInt("OUTAGE_COOLDOWN_MINUTES", 60),
+		OutageGraceMinutes:            getEnvInt("OUTAGE_GRACE_MINUTES", 45),
+		HeartbeatBufferEnabled:        getEnvBool("HEARTBEAT_BUFFER_ENABLED", true),
+		HeartbeatBufferSize:           getEnvInt("HEARTBEAT_BUFFER_SIZE", 10000),
+		HeartbeatBatchSize:            getEnvInt("HEARTBEAT_BATCH_SIZE", 500),
+		HeartbeatFlushIntervalMs:      getEnvInt("HEARTBEAT_FLUSH_INTERVAL_MS", 200),
+		HeartbeatDedupWindowSeconds:   getEnvInt("HEARTBEAT_DEDUP_WINDOW_SECONDS", 600),
+		EvaluationWorkers:             getEnvInt("EVALUATION_WORKERS", 16),
+		EvaluationQueueSize:           getEnvInt("EVALUATION_QUEUE_SIZE", 256),
+		AlertSendWorkers:              getEnvInt("ALERT_SEND_WORKERS", 4),
+		ShadowQueueSize:               getEnvInt("SHADOW_QUEUE_SIZE", 1000),
+		EvaluationStaleSeconds:        getEnvInt("EVALUATION_STALE_SECONDS", 120),
+		EvaluationLagDegradedSeconds:  getEnvInt("EVALUATION_LAG_DEGRADED_SECONDS", 60),
+		RedisWarmupRate:               getEnvInt("REDIS_WARMUP_RATE", 200),
+		RedisWarmupLookbackHours:      getEnvInt("REDIS_WARMUP_LOOKBACK_HOURS", 24),
+		AlertSLOTargetSeconds:         getEnvInt("ALERT_SLO_TARGET_SECONDS", 180), // 3 min
+		MetricsToken:                  getEnv("METRICS_TOKEN", ""),
+		BroadcastRatePerSecond:        getEnvInt("BROADCAST_RATE_PER_SECOND", 5),
+		BroadcastActiveWindowMinutes:  getEnvInt("BROADCAST_ACTIVE_WINDOW_MINUTES", 60),
+		AuditQueueSize:                getEnvInt("AUDIT_QUEUE_SIZE", 10000),
+		AuditBatchSize:                getEnvInt("AUDIT_BATCH_SIZE", 200),
+		AuditFlushIntervalMs:          getEnvInt("AUDIT_FLUSH_INTERVAL_MS", 1000),
+	}
+
+	sunset, err := getEnvTime("SIGNATURE_V1_SUNSET")
+	if err != nil {
+		return nil, err
 	}
+	cfg.SignatureV1Sunset = sunset
+
+	versions, err := getEnvIntMap("CONSENT_POLICY_VERSIONS", "")
+	if err != nil {
+		return nil, err
+	}
+	cfg.ConsentPolicyVersions = versions
+
+	countries, err := country.Select(getEnvList("COUNTRIES", "NG"))
+	if err != nil {
+		return nil, fmt.Errorf("COUNTRIES: %w", err)
+	}
+	cfg.Countries = countries
+	// Local numbers are read as the configured countries', not the running ones'
+	cfg.FallbackAlertPhone = countries.NormalizePhone(getEnv("FALLBACK_ALERT_PHONE", ""))
+	cfg.AgencyCallbackPhone = countries.NormalizePhone(getEnv("AGENCY_CALLBACK_PHONE", ""))
+	cfg.AgencyDefaultEndpoint = getEnv("AGENCY_DEFAULT_ENDPOINT", "")
+	if cfg.AgencyDefaultChannel != models.AgencyChannelWebhook {
+		cfg.AgencyDefaultEndpoint = countries.NormalizePhone(cfg.AgencyDefaultEndpoint)
+	}
+
+	rollouts, err := flags.ParseRollouts(getEnvMap("FEATURE_FLAGS", ""))
+	if err != nil {
+		return nil, fmt.Errorf("FEATURE_FLAGS: %w", err)
+	}
+	cfg.FeatureFlags = rollouts
 
 	if err := cfg.validate(); err != nil {
 		return nil, err
@@ -78,11 +502,289 @@ func (c *Config) validate() error {
 	if c.JWTSecret == "" {
 		return fmt.Errorf("JWT_SECRET is required")
 	}
-	if c.TwilioAccountSID == "" {
-		return fmt.Errorf("TWILIO_ACCOUNT_SID is required")
+	if c.StartupConnectAttempts <= 0 {
+		return fmt.Errorf("STARTUP_CONNECT_ATTEMPTS must be positive")
+	}
+	if c.StartupConnectTimeoutSeconds <= 0 {
+		return fmt.Errorf("STARTUP_CONNECT_TIMEOUT_SECONDS must be positive")
+	}
+	if c.StartupMaxBackoffSeconds <= 0 {
+		return fmt.Errorf("STARTUP_MAX_BACKOFF_SECONDS must be positive")
+	}
+	switch c.Notifier {
+	case "twilio":
+		if c.TwilioAccountSID == "" {
+			return fmt.Errorf("TWILIO_ACCOUNT_SID is required")
+		}
+		if c.TwilioAuthToken == "" {
+			return fmt.Errorf("TWILIO_AUTH_TOKEN is required")
+		}
+	case "dev":
+	default:
+		return fmt.Errorf("NOTIFIER must be one of twilio, dev")
+	}
+	if c.AfricasTalkingAPIKey != "" && c.AfricasTalkingUsername == "" {
+		return fmt.Errorf("AFRICASTALKING_USERNAME is required when AFRICASTALKING_API_KEY is set")
+	}
+	if c.OutboundMessagesPerMinute <= 0 || c.OutboundMessagesPerDay <= 0 {
+		return fmt.Errorf("OUTBOUND_MESSAGES_PER_MINUTE and OUTBOUND_MESSAGES_PER_DAY must be positive")
+	}
+	if c.OutboundEmergencyReservePct < 0 || c.OutboundEmergencyReservePct > 100 {
+		return fmt.Errorf("OUTBOUND_EMERGENCY_RESERVE_PCT must be between 0 and 100")
+	}
+	if c.OutboundEmergencyOverdraftPct < 0 || c.OutboundEmergencyOverdraftPct > 100 {
+		return fmt.Errorf("OUTBOUND_EMERGENCY_OVERDRAFT_PCT must be between 0 and 100")
+	}
+	if c.ScoreCautionThreshold < 0 || c.ScoreSafeThreshold > 100 || c.ScoreCautionThreshold > c.ScoreSafeThreshold {
+		return fmt.Errorf("score thresholds must satisfy 0 <= SCORE_CAUTION_THRESHOLD <= SCORE_SAFE_THRESHOLD <= 100")
+	}
+	if c.ContactTokenTTLHours <= 0 {
+		return fmt.Errorf("CONTACT_TOKEN_TTL_HOURS must be positive")
+	}
+	if c.AlertMapRetentionHours <= 0 {
+		return fmt.Errorf("ALERT_MAP_RETENTION_HOURS must be positive")
+	}
+	if c.IncidentBundleRetentionHours < 1 || c.IncidentBundleRetentionHours > 720 {
+		return fmt.Errorf("INCIDENT_BUNDLE_RETENTION_HOURS must be between 1 and 720")
+	}
+	if c.ScoreHistoryRetentionHours <= 0 {
+		return fmt.Errorf("SCORE_HISTORY_RETENTION_HOURS must be positive")
+	}
+	if c.ScoreTrendWindow != 0 && c.ScoreTrendWindow < 3 {
+		return fmt.Errorf("SCORE_TREND_WINDOW must be 0 (disabled) or at least 3")
+	}
+	if c.SignatureFailureThreshold <= 0 || c.SignatureFailureWindowSeconds <= 0 || c.SignatureLockoutSeconds <= 0 {
+		return fmt.Errorf("SIGNATURE_FAILURE_THRESHOLD, SIGNATURE_FAILURE_WINDOW_SECONDS and SIGNATURE_LOCKOUT_SECONDS must be positive")
+	}
+	if c.LastGaspTimeoutSeconds <= 0 {
+		return fmt.Errorf("LASTGASP_TIMEOUT_SECONDS must be positive")
+	}
+	if c.LastGaspRearmSeconds < 0 || c.LastGaspRearmSeconds >= c.LastGaspTimeoutSeconds {
+		return fmt.Errorf("LASTGASP_REARM_SECONDS must be at least 0 and less than LASTGASP_TIMEOUT_SECONDS")
+	}
+	if c.LastGaspEscalateSeconds < 0 || c.LastGaspEscalateSeconds >= c.LastGaspTimeoutSeconds {
+		return fmt.Errorf("LASTGASP_ESCALATE_SECONDS must be 0 (disabled) or less than LASTGASP_TIMEOUT_SECONDS")
+	}
+	if err := keys.ValidateNamespace(c.RedisNamespace); err != nil {
+		return fmt.Errorf("REDIS_NAMESPACE %w", err)
+	}
+	switch c.RedisKeyMigration {
+	case "off", "rewrite", "expire":
+	default:
+		return fmt.Errorf("REDIS_KEY_MIGRATION must be off, rewrite or expire")
+	}
+	if c.HeartbeatNonceTTLHours <= 0 {
+		return fmt.Errorf("HEARTBEAT_NONCE_TTL_HOURS must be positive")
+	}
+	if c.HeartbeatDedupWindowSeconds < 0 {
+		return fmt.Errorf("HEARTBEAT_DEDUP_WINDOW_SECONDS must not be negative")
+	}
+	if c.ImpactThresholdG <= 1 {
+		return fmt.Errorf("IMPACT_THRESHOLD_G must be greater than 1")
+	}
+	if c.ImpactStillSeconds <= 0 {
+		return fmt.Errorf("IMPACT_STILL_SECONDS must be positive")
+	}
+	if c.ImpactWorkers <= 0 {
+		return fmt.Errorf("IMPACT_WORKERS must be positive")
 	}
-	if c.TwilioAuthToken == "" {
-		return fmt.Errorf("TWILIO_AUTH_TOKEN is required")
+	if c.StreamMaxPerUser < 1 || c.StreamMaxPerUser > 10 {
+		return fmt.Errorf("STREAM_MAX_PER_USER must be between 1 and 10")
+	}
+	if c.StreamLineMaxBytes < 512 || c.StreamLineMaxBytes > 65536 {
+		return fmt.Errorf("STREAM_LINE_MAX_BYTES must be between 512 and 65536")
+	}
+	if c.StreamAckSeconds < 1 || c.StreamAckSeconds > 60 {
+		return fmt.Errorf("STREAM_ACK_SECONDS must be between 1 and 60")
+	}
+	if c.StreamIdleSeconds < c.StreamAckSeconds {
+		return fmt.Errorf("STREAM_IDLE_SECONDS must be at least STREAM_ACK_SECONDS")
+	}
+	if c.StreamMaxMinutes < 1 || c.StreamMaxMinutes > 240 {
+		return fmt.Errorf("STREAM_MAX_MINUTES must be between 1 and 240")
+	}
+	if c.CoarseLocationDecimals < 1 || c.CoarseLocationDecimals > 3 {
+		return fmt.Errorf("COARSE_LOCATION_DECIMALS must be between 1 and 3")
+	}
+	if c.CoarsePreciseTTLSeconds < 60 || c.CoarsePreciseTTLSeconds > 3600 {
+		return fmt.Errorf("COARSE_PRECISE_TTL_SECONDS must be between 60 and 3600")
+	}
+	if c.PrecisionBufferMinutes < 1 || c.PrecisionBufferMinutes > 1440 {
+		return fmt.Errorf("PRECISION_ESCALATION_BUFFER_MINUTES must be between 1 and 1440")
+	}
+	if c.ActivityTTLSeconds <= 0 {
+		return fmt.Errorf("ACTIVITY_TTL_SECONDS must be positive")
+	}
+	if c.ActivitySuppressionMaxMinutes < 0 {
+		return fmt.Errorf("ACTIVITY_SUPPRESSION_MAX_MINUTES must not be negative")
+	}
+	if c.MaxTrustedContacts <= 0 {
+		return fmt.Errorf("MAX_TRUSTED_CONTACTS must be positive")
+	}
+	if c.DeviceDisagreementKm <= 0 {
+		return fmt.Errorf("DEVICE_DISAGREEMENT_KM must be positive")
+	}
+	if c.BlackboxMigrationRate <= 0 || c.BlackboxMigrationBatch <= 0 {
+		return fmt.Errorf("BLACKBOX_MIGRATION_ROWS_PER_SECOND and BLACKBOX_MIGRATION_BATCH_SIZE must be positive")
+	}
+	if c.BlackboxMinValidFraction <= 0 || c.BlackboxMinValidFraction > 1 {
+		return fmt.Errorf("BLACKBOX_MIN_VALID_FRACTION must be above 0 and at most 1")
+	}
+	if c.ChannelDisableAfterFailures <= 0 {
+		return fmt.Errorf("CHANNEL_DISABLE_AFTER_FAILURES must be positive")
+	}
+	if c.HeatmapPrecision < 3 || c.HeatmapPrecision > 7 {
+		return fmt.Errorf("HEATMAP_PRECISION must be between 3 and 7")
+	}
+	if c.HeatmapMinUsers < 2 {
+		return fmt.Errorf("HEATMAP_MIN_USERS must be at least 2")
+	}
+	if c.HeatmapCacheTTLSeconds < 0 {
+		return fmt.Errorf("HEATMAP_CACHE_TTL_SECONDS must not be negative")
+	}
+	if c.OutageMinUsers < 2 {
+		return fmt.Errorf("OUTAGE_MIN_USERS must be at least 2")
+	}
+	if c.OutageWindowMinutes <= 0 || c.OutageCooldownMinutes <= 0 {
+		return fmt.Errorf("OUTAGE_WINDOW_MINUTES and OUTAGE_COOLDOWN_MINUTES must be positive")
+	}
+	if c.OutagePrecision < 4 || c.OutagePrecision > 7 {
+		return fmt.Errorf("OUTAGE_PRECISION must be between 4 and 7")
+	}
+	if c.OutageGraceMinutes < 0 {
+		return fmt.Errorf("OUTAGE_GRACE_MINUTES must not be negative")
+	}
+	if c.EvaluationWorkers <= 0 || c.EvaluationQueueSize <= 0 || c.AlertSendWorkers <= 0 {
+		return fmt.Errorf("EVALUATION_WORKERS, EVALUATION_QUEUE_SIZE and ALERT_SEND_WORKERS must be positive")
+	}
+	if c.ShadowQueueSize <= 0 {
+		return fmt.Errorf("SHADOW_QUEUE_SIZE must be positive")
+	}
+	if c.EvaluationStaleSeconds <= 0 || c.EvaluationLagDegradedSeconds <= 0 {
+		return fmt.Errorf("EVALUATION_STALE_SECONDS and EVALUATION_LAG_DEGRADED_SECONDS must be positive")
+	}
+	if c.RedisWarmupRate < 1 || c.RedisWarmupRate > 10000 {
+		return fmt.Errorf("REDIS_WARMUP_RATE must be between 1 and 10000")
+	}
+	if c.RedisWarmupLookbackHours < 1 || c.RedisWarmupLookbackHours > 168 {
+		return fmt.Errorf("REDIS_WARMUP_LOOKBACK_HOURS must be between 1 and 168")
+	}
+	if c.FallbackAlertPhone != "" && !utils.IsValidE164(c.FallbackAlertPhone) {
+		return fmt.Errorf("FALLBACK_ALERT_PHONE must be a valid phone number")
+	}
+	if c.AlertSLOTargetSeconds < 10 || c.AlertSLOTargetSeconds > 3600 {
+		return fmt.Errorf("ALERT_SLO_TARGET_SECONDS must be between 10 and 3600")
+	}
+	if c.ProtectionPauseMaxMinutes <= 0 {
+		return fmt.Errorf("PROTECTION_PAUSE_MAX_MINUTES must be positive")
+	}
+	if c.ResponderRateLimitPerMinute <= 0 {
+		return fmt.Errorf("RESPONDER_RATE_LIMIT_PER_MINUTE must be positive")
+	}
+	if c.WatchMaxMinutes <= 0 {
+		return fmt.Errorf("WATCH_MAX_MINUTES must be positive")
+	}
+	if c.PanicCancelWindowSeconds < 0 || c.PanicCancelWindowSeconds > 60 {
+		return fmt.Errorf("PANIC_CANCEL_WINDOW_SECONDS must be between 0 and 60")
+	}
+	if c.WelfareCheckTimeoutMinutes <= 0 || c.WelfareCheckTimeoutMinutes > 120 {
+		return fmt.Errorf("WELFARE_CHECK_TIMEOUT_MINUTES must be between 1 and 120")
+	}
+	if c.WelfareCheckDailyLimit <= 0 {
+		return fmt.Errorf("WELFARE_CHECK_DAILY_LIMIT must be positive")
+	}
+	if c.AutoResolveHeartbeats < 2 {
+		return fmt.Errorf("AUTO_RESOLVE_HEARTBEATS must be at least 2")
+	}
+	if c.AutoResolvePromptMinutes < 1 || c.AutoResolvePromptMinutes > 120 {
+		return fmt.Errorf("AUTO_RESOLVE_PROMPT_MINUTES must be between 1 and 120")
+	}
+	if c.AlertReconcileAgeMinutes < 60 {
+		return fmt.Errorf("ALERT_RECONCILE_AGE_MINUTES must be at least 60")
+	}
+	if c.AlertReconcileSafeMinutes < 30 {
+		return fmt.Errorf("ALERT_RECONCILE_SAFE_MINUTES must be at least 30")
+	}
+	if c.AgencyEscalationMinutes < 1 || c.AgencyEscalationMinutes >= c.AlertReconcileAgeMinutes {
+		return fmt.Errorf("AGENCY_ESCALATION_MINUTES must be at least 1 and less than ALERT_RECONCILE_AGE_MINUTES")
+	}
+	switch c.AgencyDefaultChannel {
+	case models.AgencyChannelSMS, models.AgencyChannelVoice:
+		if c.AgencyDefaultEndpoint != "" && !utils.IsValidE164(c.AgencyDefaultEndpoint) {
+			return fmt.Errorf("AGENCY_DEFAULT_ENDPOINT must be a valid phone number for channel %s", c.AgencyDefaultChannel)
+		}
+	case models.AgencyChannelWebhook:
+		if c.AgencyDefaultEndpoint != "" && !strings.HasPrefix(c.AgencyDefaultEndpoint, "https://") {
+			return fmt.Errorf("AGENCY_DEFAULT_ENDPOINT must be an https URL for channel %s", c.AgencyDefaultChannel)
+		}
+	default:
+		return fmt.Errorf("AGENCY_DEFAULT_CHANNEL must be sms, voice or http_webhook")
+	}
+	if c.AgencyCallbackPhone != "" && !utils.IsValidE164(c.AgencyCallbackPhone) {
+		return fmt.Errorf("AGENCY_CALLBACK_PHONE must be a valid phone number")
+	}
+	if c.UnverifiedPerPhonePerHour < 1 || c.UnverifiedPerIPPerHour < 1 {
+		return fmt.Errorf("UNVERIFIED_PER_PHONE_PER_HOUR and UNVERIFIED_PER_IP_PER_HOUR must be positive")
+	}
+	if c.UnverifiedFreePerHour < 0 || c.UnverifiedFreePerHour > c.UnverifiedPerPhonePerHour {
+		return fmt.Errorf("UNVERIFIED_FREE_PER_HOUR must be between 0 and UNVERIFIED_PER_PHONE_PER_HOUR")
+	}
+	if c.UnverifiedPoWBits < 8 || c.UnverifiedPoWBits > 28 {
+		return fmt.Errorf("UNVERIFIED_POW_BITS must be between 8 and 28")
+	}
+	if c.GuardianNoticeCooldownHours < 1 || c.GuardianNoticeCooldownHours > 168 {
+		return fmt.Errorf("GUARDIAN_NOTICE_COOLDOWN_HOURS must be between 1 and 168")
+	}
+	if c.GuardianDigestHour < 0 || c.GuardianDigestHour > 23 {
+		return fmt.Errorf("GUARDIAN_DIGEST_HOUR must be between 0 and 23")
+	}
+	if c.ContactPacingMaxPer10Min < 0 || c.ContactPacingMaxPer10Min > 20 {
+		return fmt.Errorf("CONTACT_PACING_MAX_PER_10MIN must be between 0 and 20")
+	}
+	if c.UserCacheTTLSeconds < 0 || c.UserCacheTTLSeconds > 3600 {
+		return fmt.Errorf("USER_CACHE_TTL_SECONDS must be between 0 and 3600")
+	}
+	if c.What3WordsTimeoutMS <= 0 || c.What3WordsTimeoutMS > 5000 {
+		return fmt.Errorf("WHAT3WORDS_TIMEOUT_MS must be between 1 and 5000")
+	}
+	if c.HeartbeatWindowSeconds <= 0 {
+		return fmt.Errorf("HEARTBEAT_WINDOW_SECONDS must be positive")
+	}
+	if c.HeartbeatIntervalMinSeconds > c.HeartbeatIntervalSeconds || c.HeartbeatIntervalSeconds > c.HeartbeatIntervalMaxSeconds {
+		return fmt.Errorf("HEARTBEAT_INTERVAL_MIN_SECONDS <= HEARTBEAT_INTERVAL_SECONDS <= HEARTBEAT_INTERVAL_MAX_SECONDS must hold")
+	}
+	// Users' own intervals are clamped to these, so they bound what a user can set
+	if c.HeartbeatIntervalMinSeconds < 60 || c.HeartbeatIntervalMaxSeconds > 3600 {
+		return fmt.Errorf("HEARTBEAT_INTERVAL_MIN_SECONDS and HEARTBEAT_INTERVAL_MAX_SECONDS must be between 60 and 3600")
+	}
+	if c.SilentPromptSeconds < 5 || c.SilentPromptSeconds > 120 {
+		return fmt.Errorf("SILENT_PROMPT_SECONDS must be between 5 and 120")
+	}
+	if c.DangerZoneHours < 0 {
+		return fmt.Errorf("DANGER_ZONE_HOURS must not be negative")
+	}
+	switch c.SMSDefaultProvider {
+	case "twilio", "termii", "africastalking":
+	default:
+		return fmt.Errorf("SMS_DEFAULT_PROVIDER must be one of twilio, termii, africastalking")
+	}
+	switch c.SMSHeartbeatAck {
+	case "none", "lastgasp", "all":
+	default:
+		return fmt.Errorf("SMS_HEARTBEAT_ACK must be one of none, lastgasp, all")
+	}
+	for _, p := range c.Countries {
+		if err := p.CheckSenderID(c.TermiiSenderID); err != nil {
+			return fmt.Errorf("TERMII_SENDER_ID: %w", err)
+		}
+		if err := p.CheckSenderID(c.AfricasTalkingSenderID); err != nil {
+			return fmt.Errorf("AFRICASTALKING_SENDER_ID: %w", err)
+		}
+	}
+	for scope := range c.ConsentPolicyVersions {
+		if !slices.Contains(models.ConsentScopes, scope) {
+			return fmt.Errorf("CONSENT_POLICY_VERSIONS: unknown scope %s, must be one of %s", scope, strings.Join(models.ConsentScopes, ", "))
+		}
 	}
 	return nil
 }
@@ -102,3 +804,77 @@ func getEnvInt(key string, defaultValue int) int {
 	}
 	return defaultValue
 }
+
+func getEnvFloat(key string, defaultValue float64) float64 {
+	if value := os.Getenv(key); value != "" {
+		if floatVal, err := strconv.ParseFloat(value, 64); err == nil {
+			return floatVal
+		}
+	}
+	return defaultValue
+}
+
+func getEnvBool(key string, defaultValue bool) bool {
+	if value := os.Getenv(key); value != "" {
+		if boolVal, err := strconv.ParseBool(value); err == nil {
+			return boolVal
+		}
+	}
+	return defaultValue
+}
+
+// getEnvTime parses a date (2006-01-02, midnight UTC) or an RFC 3339 time.
+// Unlike the other getters it fails on a bad value, since ignoring one would
+// silently drop a deadline. Unset is the zero time.
+func getEnvTime(key string) (time.Time, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse("2006-01-02", value); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%s must be a date (2006-01-02) or an RFC 3339 time", key)
+	}
+	return t, nil
+}
+
+// getEnvIntMap parses comma-separated key=value pairs whose values are
+// positive integers, e.g. "tracking=2,audio=1"
+func getEnvIntMap(key, defaultValue string) (map[string]int, error) {
+	result := make(map[string]int)
+	for name, value := range getEnvMap(key, defaultValue) {
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("%s: %s must be a positive integer", key, name)
+		}
+		result[name] = n
+	}
+	return result, nil
+}
+
+// getEnvList parses a comma-separated list, e.g. "NG,GH"
+func getEnvList(key, defaultValue string) []string {
+	var result []string
+	for _, item := range strings.Split(getEnv(key, defaultValue), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
+// getEnvMap parses comma-separated key=value pairs, e.g. "MTN=termii,GLO=termii"
+func getEnvMap(key, defaultValue string) map[string]string {
+	result := make(map[string]string)
+	for _, pair := range strings.Split(getEnv(key, defaultValue), ",") {
+		kv := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+		if len(kv) != 2 || kv[0] == "" || kv[1] == "" {
+			continue
+		}
+		result[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+	}
+	return result
+}
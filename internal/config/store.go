@@ -0,0 +1,160 @@
+package config
+
+import (
+	"log"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Store holds the live configuration. Components read the current snapshot
+// through Current on every use instead of keeping a copy, so a reload takes
+// effect on the next request without a restart.
+type Store struct {
+	current atomic.Pointer[Config]
+
+	mu                sync.Mutex
+	subscribers       []func(*Config)
+	previousHMAC      string
+	previousHMACUntil time.Time
+}
+
+func NewStore(cfg *Config) *Store {
+	s := &Store{}
+	s.current.Store(cfg)
+	return s
+}
+
+// Current returns the active configuration snapshot. Treat it as read-only.
+func (s *Store) Current() *Config {
+	return s.current.Load()
+}
+
+// OnChange registers a callback run after every successful swap, for
+// components that derive clients from config (e.g. Twilio credentials)
+func (s *Store) OnChange(fn func(*Config)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.subscribers = append(s.subscribers, fn)
+}
+
+// Reload re-reads the env file and swaps in the new configuration if it is valid.
+// On error the running configuration is left untouched.
+func (s *Store) Reload(path string) error {
+	cfg, err := LoadFile(path)
+	if err != nil {
+		return err
+	}
+	s.Swap(cfg)
+	return nil
+}
+
+// Swap atomically replaces the configuration and notifies subscribers. A
+// changed HMAC secret keeps the old one valid for HMACRotationOverlapSeconds.
+func (s *Store) Swap(cfg *Config) {
+	s.mu.Lock()
+	old := s.current.Load()
+	if old != nil && old.HMACSecret != cfg.HMACSecret {
+		s.previousHMAC = old.HMACSecret
+		s.previousHMACUntil = time.Now().Add(time.Duration(cfg.HMACRotationOverlapSeconds) * time.Second)
+		log.Printf("INFO: HMAC secret rotated; previous secret accepted until %s", s.previousHMACUntil.Format(time.RFC3339))
+	}
+	if old != nil {
+		for _, field := range restartRequired(old, cfg) {
+			log.Printf("WARN: %s changed but only takes effect after a restart", field)
+		}
+	}
+	s.current.Store(cfg)
+	subscribers := append([]func(*Config){}, s.subscribers...)
+	s.mu.Unlock()
+
+	for _, fn := range subscribers {
+		fn(cfg)
+	}
+}
+
+// HMACSecrets returns every secret a heartbeat signature may currently use:
+// the active one, the one replaced by the last reload while its overlap
+// window lasts, and HMAC_SECRET_PREVIOUS if set
+func (s *Store) HMACSecrets() []string {
+	cfg := s.Current()
+	secrets := []string{cfg.HMACSecret}
+
+	s.mu.Lock()
+	if s.previousHMAC != "" && time.Now().Before(s.previousHMACUntil) {
+		secrets = append(secrets, s.previousHMAC)
+	}
+	s.mu.Unlock()
+
+	if cfg.HMACSecretPrevious != "" && cfg.HMACSecretPrevious != cfg.HMACSecret {
+		secrets = append(secrets, cfg.HMACSecretPrevious)
+	}
+	return secrets
+}
+
+// Watch polls the env file and reloads when its modification time changes.
+// It returns when stop is closed.
+func (s *Store) Watch(path string, interval time.Duration, stop <-chan struct{}) {
+	var lastMod time.Time
+	if info, err := os.Stat(path); err == nil {
+		lastMod = info.ModTime()
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			info, err := os.Stat(path)
+			if err != nil || !info.ModTime().After(lastMod) {
+				continue
+			}
+			lastMod = info.ModTime()
+			if err := s.Reload(path); err != nil {
+				log.Printf("ERROR: Config reload from %s failed, keeping current config: %v", path, err)
+				continue
+			}
+			log.Printf("INFO: Config reloaded from %s", path)
+		}
+	}
+}
+
+// restartRequired lists settings that are read once at startup
+func restartRequired(old, cfg *Config) []string {
+	var fields []string
+	check := func(name string, changed bool) {
+		if changed {
+			fields = append(fields, name)
+		}
+	}
+	check("PORT", old.Port != cfg.Port)
+	check("DATABASE_URL", old.DatabaseURL != cfg.DatabaseURL)
+	check("REDIS_URL", old.RedisURL != cfg.RedisURL)
+	check("REDIS_NAMESPACE", old.RedisNamespace != cfg.RedisNamespace)
+	check("REDIS_KEY_MIGRATION", old.RedisKeyMigration != cfg.RedisKeyMigration)
+	check("STARTUP_*", old.StartupConnectAttempts != cfg.StartupConnectAttempts ||
+		old.StartupConnectTimeoutSeconds != cfg.StartupConnectTimeoutSeconds ||
+		old.StartupMaxBackoffSeconds != cfg.StartupMaxBackoffSeconds ||
+		old.StartupDegradedBoot != cfg.StartupDegradedBoot)
+	check("JWT_SECRET", old.JWTSecret != cfg.JWTSecret)
+	check("NOTIFIER", old.Notifier != cfg.Notifier)
+	check("FCM_CREDENTIALS_PATH", old.FCMCredentialsPath != cfg.FCMCredentialsPath)
+	check("BLACKBOX_BUCKET", old.BlackboxBucket != cfg.BlackboxBucket)
+	check("HEARTBEAT_BUFFER_*", old.HeartbeatBufferEnabled != cfg.HeartbeatBufferEnabled ||
+		old.HeartbeatBufferSize != cfg.HeartbeatBufferSize ||
+		old.HeartbeatBatchSize != cfg.HeartbeatBatchSize ||
+		old.HeartbeatFlushIntervalMs != cfg.HeartbeatFlushIntervalMs)
+	check("IMPACT_WORKERS", old.ImpactWorkers != cfg.ImpactWorkers)
+	check("EVALUATION_*", old.EvaluationWorkers != cfg.EvaluationWorkers ||
+		old.EvaluationQueueSize != cfg.EvaluationQueueSize)
+	check("ALERT_SEND_WORKERS", old.AlertSendWorkers != cfg.AlertSendWorkers)
+	check("SHADOW_QUEUE_SIZE", old.ShadowQueueSize != cfg.ShadowQueueSize)
+	check("AUDIT_*", old.AuditQueueSize != cfg.AuditQueueSize ||
+		old.AuditBatchSize != cfg.AuditBatchSize ||
+		old.AuditFlushIntervalMs != cfg.AuditFlushIntervalMs)
+	return fields
+}
@@ -0,0 +1,63 @@
+package config
+
+import (
+	"reflect"
+	"sync/atomic"
+	"testing"
+)
+
+func TestSwap(t *testing.T) {
+	store := NewStore(&Config{ScoreSafeThreshold: 80})
+	var notified atomic.Int32
+	var seen *Config
+	store.OnChange(func(cfg *Config) {
+		notified.Add(1)
+		seen = cfg
+	})
+
+	next := &Config{ScoreSafeThreshold: 90}
+	store.Swap(next)
+	if store.Current() != next {
+		t.Errorf("Current() isn't the swapped in config")
+	}
+	if notified.Load() != 1 || seen != next {
+		t.Errorf("subscriber called %d times with %p, want once with %p", notified.Load(), seen, next)
+	}
+}
+
+func TestHMACSecretsRotation(t *testing.T) {
+	tests := []struct {
+		name    string
+		old     *Config
+		next    *Config
+		secrets []string
+	}{
+		{"unchanged", &Config{HMACSecret: "a", HMACRotationOverlapSeconds: 3600},
+			&Config{HMACSecret: "a", HMACRotationOverlapSeconds: 3600}, []string{"a"}},
+		{"rotated, within the overlap", &Config{HMACSecret: "a"},
+			&Config{HMACSecret: "b", HMACRotationOverlapSeconds: 3600}, []string{"b", "a"}},
+		{"rotated without an overlap", &Config{HMACSecret: "a"},
+			&Config{HMACSecret: "b"}, []string{"b"}},
+		{"with HMAC_SECRET_PREVIOUS", &Config{HMACSecret: "a"},
+			&Config{HMACSecret: "b", HMACSecretPrevious: "z", HMACRotationOverlapSeconds: 3600}, []string{"b", "a", "z"}},
+		{"HMAC_SECRET_PREVIOUS the same as the secret", &Config{HMACSecret: "b"},
+			&Config{HMACSecret: "b", HMACSecretPrevious: "b"}, []string{"b"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := NewStore(tt.old)
+			store.Swap(tt.next)
+			if got := store.HMACSecrets(); !reflect.DeepEqual(got, tt.secrets) {
+				t.Errorf("HMACSecrets() = %v, want %v", got, tt.secrets)
+			}
+		})
+	}
+}
+
+func TestRestartRequired(t *testing.T) {
+	old := &Config{Port: "8080", JWTSecret: "a", ScoreSafeThreshold: 80, EvaluationWorkers: 4}
+	next := &Config{Port: "9090", JWTSecret: "a", ScoreSafeThreshold: 90, EvaluationWorkers: 8}
+	if got, want := restartRequired(old, next), []string{"PORT", "EVALUATION_*"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("restartRequired() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,230 @@
+package country
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"sync/atomic"
+)
+
+// Profile is what the backend assumes about one country: how its phone
+// numbers are written, which mobile networks are its own, the emergency
+// numbers to tell people in danger to call, its time zone and the SMS sender
+// IDs it delivers. Profiles are data only; see profiles for the ones shipped.
+type Profile struct {
+	Code           string // ISO 3166-1 alpha-2, e.g. "NG"
+	Name           string
+	CallingCode    string // without the "+", e.g. "234"
+	TrunkPrefix    string // dialled before national numbers from inside the country, e.g. "0"
+	NationalLength int    // digits after the calling code
+	MCCs           []int  // mobile country codes of the country's networks
+	Timezone       string // IANA name, the default for users and contacts from here
+	Emergency      EmergencyNumbers
+	SenderID       SenderIDRules
+
+	// CarrierPrefixes maps number prefixes, in local form (trunk prefix
+	// first), to carriers. Longer prefixes take precedence over shorter ones.
+	CarrierPrefixes map[string]string
+	// NetworkCarriers maps the mobile network codes (MNC) of the country's
+	// networks to carriers
+	NetworkCarriers map[int]string
+}
+
+// EmergencyNumbers are a country's emergency service numbers
+type EmergencyNumbers struct {
+	General   string // the one to tell people in danger to call
+	Police    string
+	Ambulance string
+	Fire      string
+}
+
+// SenderIDRules are what a country's networks accept as the sender of an SMS
+type SenderIDRules struct {
+	Alphanumeric bool // whether names are delivered, not just numbers
+	MaxLength    int  // of an alphanumeric sender ID
+}
+
+// Owns reports whether phone, in E.164, is a number of the country
+func (p *Profile) Owns(phone string) bool {
+	return strings.HasPrefix(phone, "+"+p.CallingCode) && len(phone) == 1+len(p.CallingCode)+p.NationalLength
+}
+
+// HomeNetwork reports whether mcc is one of the country's own
+func (p *Profile) HomeNetwork(mcc int) bool {
+	return slices.Contains(p.MCCs, mcc)
+}
+
+// Carrier returns the carrier of one of the country's numbers, in E.164,
+// by its prefix, or "" if it isn't known
+func (p *Profile) Carrier(phone string) string {
+	if !p.Owns(phone) {
+		return ""
+	}
+	local := p.TrunkPrefix + phone[1+len(p.CallingCode):]
+	for length := min(len(local), 6); length > len(p.TrunkPrefix); length-- {
+		if carrier, ok := p.CarrierPrefixes[local[:length]]; ok {
+			return carrier
+		}
+	}
+	return ""
+}
+
+// NetworkCarrier returns the carrier of one of the country's networks by
+// its MNC, or "" if it isn't known
+func (p *Profile) NetworkCarrier(mnc int) string {
+	return p.NetworkCarriers[mnc]
+}
+
+// CheckSenderID returns an error if texts sent as id would not be delivered
+// in the country. A number is always accepted.
+func (p *Profile) CheckSenderID(id string) error {
+	if id == "" || isDigits(strings.TrimPrefix(id, "+")) {
+		return nil
+	}
+	if !p.SenderID.Alphanumeric {
+		return fmt.Errorf("%s only delivers texts sent from a number", p.Name)
+	}
+	if len(id) > p.SenderID.MaxLength {
+		return fmt.Errorf("%s delivers sender IDs of at most %d characters", p.Name, p.SenderID.MaxLength)
+	}
+	return nil
+}
+
+// Set is the countries a deployment serves, its home country first. A
+// single-country deployment treats every user as from there; a multi-country
+// one places each user by the calling code of their phone number, falling
+// back to the home country.
+type Set []*Profile
+
+// Select returns the set of the countries with the given ISO codes, in order
+func Select(codes []string) (Set, error) {
+	if len(codes) == 0 {
+		return nil, fmt.Errorf("at least one country is required")
+	}
+	set := make(Set, 0, len(codes))
+	for _, code := range codes {
+		p, ok := profiles[strings.ToUpper(code)]
+		if !ok {
+			return nil, fmt.Errorf("unknown country %s, must be one of %s", code, strings.Join(Codes(), ", "))
+		}
+		if slices.Contains(set, p) {
+			return nil, fmt.Errorf("country %s is listed twice", p.Code)
+		}
+		set = append(set, p)
+	}
+	return set, nil
+}
+
+// Codes returns the ISO codes of every country with a profile, sorted
+func Codes() []string {
+	codes := make([]string, 0, len(profiles))
+	for code := range profiles {
+		codes = append(codes, code)
+	}
+	slices.Sort(codes)
+	return codes
+}
+
+// Home returns the deployment's home country
+func (s Set) Home() *Profile {
+	return s[0]
+}
+
+// Of returns the country of the set phone is a number of, or nil
+func (s Set) Of(phone string) *Profile {
+	phone = s.NormalizePhone(phone)
+	for _, p := range s {
+		if p.Owns(phone) {
+			return p
+		}
+	}
+	return nil
+}
+
+// ForPhone returns the country a user or contact with this phone number is
+// taken to be in: the home country, unless the number is another's in the set
+func (s Set) ForPhone(phone string) *Profile {
+	if len(s) == 1 {
+		return s.Home()
+	}
+	if p := s.Of(phone); p != nil {
+		return p
+	}
+	return s.Home()
+}
+
+// ForMCC returns the country of the set whose networks use mcc, or nil
+func (s Set) ForMCC(mcc int) *Profile {
+	for _, p := range s {
+		if p.HomeNetwork(mcc) {
+			return p
+		}
+	}
+	return nil
+}
+
+// NormalizePhone converts a phone number to E.164. Local numbers (0803...)
+// and bare calling-code numbers (234803...) of a country in the set get its
+// calling code, the home country's winning where lengths agree; spaces,
+// dashes, dots and parentheses are stripped. Numbers already in
+// international form are kept as-is apart from the stripping.
+func (s Set) NormalizePhone(phone string) string {
+	trimmed := strings.TrimSpace(phone)
+	hasPlus := strings.HasPrefix(trimmed, "+")
+
+	digits := strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, trimmed)
+
+	switch {
+	case digits == "":
+		return ""
+	case hasPlus:
+		return "+" + digits
+	case strings.HasPrefix(digits, "00"):
+		return "+" + digits[2:]
+	}
+	for _, p := range s {
+		if p.TrunkPrefix != "" && strings.HasPrefix(digits, p.TrunkPrefix) && len(digits) == len(p.TrunkPrefix)+p.NationalLength {
+			return "+" + p.CallingCode + digits[len(p.TrunkPrefix):]
+		}
+	}
+	for _, p := range s {
+		if strings.HasPrefix(digits, p.CallingCode) && len(digits) == len(p.CallingCode)+p.NationalLength {
+			return "+" + digits
+		}
+	}
+
+	return digits
+}
+
+// active is the set the running deployment serves
+var active atomic.Pointer[Set]
+
+// Use makes s the set the deployment serves, as returned by Active
+func Use(s Set) {
+	active.Store(&s)
+}
+
+// Active returns the set the deployment serves, Nigeria alone until Use is called
+func Active() Set {
+	if s := active.Load(); s != nil {
+		return *s
+	}
+	return Set{profiles["NG"]}
+}
+
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
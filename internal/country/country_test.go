@@ -0,0 +1,242 @@
+package country
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func mustSelect(t *testing.T, codes ...string) Set {
+	t.Helper()
+	s, err := Select(codes)
+	if err != nil {
+		t.Fatalf("Select(%v): %v", codes, err)
+	}
+	return s
+}
+
+// Every profile shipped is complete and consistent, so adding a country is
+// adding its data and these tests check it
+func TestProfiles(t *testing.T) {
+	for code, p := range profiles {
+		t.Run(code, func(t *testing.T) {
+			if p.Code != code || p.Name == "" {
+				t.Errorf("code %q, name %q", p.Code, p.Name)
+			}
+			if p.CallingCode == "" || !isDigits(p.CallingCode) || p.NationalLength <= 0 {
+				t.Errorf("calling code %q, national length %d", p.CallingCode, p.NationalLength)
+			}
+			if len(p.MCCs) == 0 {
+				t.Error("no mobile country codes")
+			}
+			if _, err := time.LoadLocation(p.Timezone); err != nil || p.Timezone == "" {
+				t.Errorf("time zone %q: %v", p.Timezone, err)
+			}
+			if p.Emergency.General == "" || p.Emergency.Police == "" {
+				t.Errorf("emergency numbers %+v", p.Emergency)
+			}
+			if p.SenderID.Alphanumeric && p.SenderID.MaxLength <= 0 {
+				t.Errorf("alphanumeric sender IDs of at most %d characters", p.SenderID.MaxLength)
+			}
+			for prefix, carrier := range p.CarrierPrefixes {
+				if !strings.HasPrefix(prefix, p.TrunkPrefix) || len(prefix) <= len(p.TrunkPrefix) || !isDigits(prefix) || carrier == "" {
+					t.Errorf("carrier prefix %q: %q", prefix, carrier)
+				}
+			}
+			for mnc, carrier := range p.NetworkCarriers {
+				if carrier == "" {
+					t.Errorf("network %d has no carrier", mnc)
+				}
+			}
+		})
+	}
+
+	// Two countries sharing a network code couldn't tell users apart
+	seen := map[int]string{}
+	for code, p := range profiles {
+		for _, mcc := range p.MCCs {
+			if other, ok := seen[mcc]; ok {
+				t.Errorf("MCC %d is both %s's and %s's", mcc, other, code)
+			}
+			seen[mcc] = code
+		}
+	}
+}
+
+func TestProfileNumbers(t *testing.T) {
+	tests := []struct {
+		country, phone string
+		owns           bool
+		carrier        string
+	}{
+		{"NG", "+2348031234567", true, "MTN"},
+		{"NG", "+2347025123456", true, "MTN"}, // 07025 before 0702
+		{"NG", "+2348051234567", true, "GLO"},
+		{"NG", "+2349091234567", true, "9MOBILE"},
+		{"NG", "+2347021234567", true, ""},
+		{"NG", "+234803123456", false, ""},
+		{"NG", "+233241234567", false, ""},
+		{"GH", "+233241234567", true, "MTN"},
+		{"GH", "+233201234567", true, "TELECEL"},
+		{"GH", "+233271234567", true, "AT"},
+		{"GH", "+233231234567", true, "GLO"},
+		{"GH", "+233301234567", true, ""},
+		{"GH", "+23324123456", false, ""},
+		{"GH", "+2348031234567", false, ""},
+	}
+	for _, tt := range tests {
+		p := profiles[tt.country]
+		if got := p.Owns(tt.phone); got != tt.owns {
+			t.Errorf("%s.Owns(%s) = %v, want %v", tt.country, tt.phone, got, tt.owns)
+		}
+		if got := p.Carrier(tt.phone); got != tt.carrier {
+			t.Errorf("%s.Carrier(%s) = %q, want %q", tt.country, tt.phone, got, tt.carrier)
+		}
+	}
+}
+
+func TestProfileNetworks(t *testing.T) {
+	tests := []struct {
+		country  string
+		mcc, mnc int
+		home     bool
+		carrier  string
+	}{
+		{"NG", 621, 30, true, "MTN"},
+		{"NG", 621, 20, true, "AIRTEL"},
+		{"NG", 621, 99, true, ""},
+		{"NG", 620, 1, false, ""},
+		{"GH", 620, 1, true, "MTN"},
+		{"GH", 620, 2, true, "TELECEL"},
+		{"GH", 620, 6, true, "AT"},
+		{"GH", 621, 30, false, ""},
+	}
+	for _, tt := range tests {
+		p := profiles[tt.country]
+		if got := p.HomeNetwork(tt.mcc); got != tt.home {
+			t.Errorf("%s.HomeNetwork(%d) = %v, want %v", tt.country, tt.mcc, got, tt.home)
+		}
+		if !tt.home {
+			continue
+		}
+		if got := p.NetworkCarrier(tt.mnc); got != tt.carrier {
+			t.Errorf("%s.NetworkCarrier(%d) = %q, want %q", tt.country, tt.mnc, got, tt.carrier)
+		}
+	}
+}
+
+func TestCheckSenderID(t *testing.T) {
+	numbersOnly := &Profile{Name: "Numberland", SenderID: SenderIDRules{Alphanumeric: false}}
+	tests := []struct {
+		profile *Profile
+		id      string
+		ok      bool
+	}{
+		{profiles["NG"], "SafeTrace", true},
+		{profiles["NG"], "SafeTraceNGA", false},
+		{profiles["NG"], "+2348031234567", true},
+		{profiles["NG"], "", true},
+		{profiles["GH"], "SafeTraceGH", true},
+		{profiles["GH"], "SafeTraceGHA", false},
+		{numbersOnly, "SafeTrace", false},
+		{numbersOnly, "+233241234567", true},
+		{numbersOnly, "4040", true},
+	}
+	for _, tt := range tests {
+		if err := tt.profile.CheckSenderID(tt.id); (err == nil) != tt.ok {
+			t.Errorf("%s.CheckSenderID(%q) = %v, want ok %v", tt.profile.Name, tt.id, err, tt.ok)
+		}
+	}
+}
+
+func TestSelect(t *testing.T) {
+	s := mustSelect(t, "gh", "NG")
+	if s.Home().Code != "GH" || len(s) != 2 {
+		t.Errorf("Select(gh, NG) = home %s of %d", s.Home().Code, len(s))
+	}
+	for _, codes := range [][]string{nil, {"XX"}, {"NG", "ng"}} {
+		if _, err := Select(codes); err == nil {
+			t.Errorf("Select(%v) accepted", codes)
+		}
+	}
+}
+
+// Each way a number is written normalizes by the countries served: a local
+// number is the home country's when both countries' lengths fit
+func TestNormalizePhone(t *testing.T) {
+	tests := []struct {
+		countries []string
+		in, want  string
+	}{
+		{[]string{"NG"}, "08031234567", "+2348031234567"},
+		{[]string{"NG"}, "2348031234567", "+2348031234567"},
+		{[]string{"NG"}, "0241234567", "0241234567"},
+		{[]string{"NG"}, "233241234567", "233241234567"},
+		{[]string{"GH"}, "0241234567", "+233241234567"},
+		{[]string{"GH"}, "024 123 4567", "+233241234567"},
+		{[]string{"GH"}, "233241234567", "+233241234567"},
+		{[]string{"GH"}, "08031234567", "08031234567"},
+		{[]string{"NG", "GH"}, "08031234567", "+2348031234567"},
+		{[]string{"NG", "GH"}, "0241234567", "+233241234567"},
+		{[]string{"NG", "GH"}, "233241234567", "+233241234567"},
+		{[]string{"GH", "NG"}, "2348031234567", "+2348031234567"},
+		{[]string{"NG", "GH"}, "00233241234567", "+233241234567"},
+		{[]string{"NG", "GH"}, "+447700900123", "+447700900123"},
+		{[]string{"NG", "GH"}, "", ""},
+	}
+	for _, tt := range tests {
+		s := mustSelect(t, tt.countries...)
+		if got := s.NormalizePhone(tt.in); got != tt.want {
+			t.Errorf("%v NormalizePhone(%q) = %q, want %q", tt.countries, tt.in, got, tt.want)
+		}
+	}
+}
+
+// A single-country deployment takes everyone for its own; a multi-country
+// one places them by number, and the rest at home
+func TestForPhone(t *testing.T) {
+	tests := []struct {
+		countries []string
+		phone     string
+		want      string
+	}{
+		{[]string{"NG"}, "+2348031234567", "NG"},
+		{[]string{"NG"}, "+233241234567", "NG"},
+		{[]string{"GH"}, "+2348031234567", "GH"},
+		{[]string{"NG", "GH"}, "+233241234567", "GH"},
+		{[]string{"NG", "GH"}, "0241234567", "GH"},
+		{[]string{"NG", "GH"}, "+2348031234567", "NG"},
+		{[]string{"NG", "GH"}, "+447700900123", "NG"},
+		{[]string{"GH", "NG"}, "+447700900123", "GH"},
+	}
+	for _, tt := range tests {
+		s := mustSelect(t, tt.countries...)
+		if got := s.ForPhone(tt.phone); got.Code != tt.want {
+			t.Errorf("%v ForPhone(%s) = %s, want %s", tt.countries, tt.phone, got.Code, tt.want)
+		}
+	}
+
+	s := mustSelect(t, "NG", "GH")
+	for mcc, want := range map[int]string{621: "NG", 620: "GH", 616: ""} {
+		got := ""
+		if p := s.ForMCC(mcc); p != nil {
+			got = p.Code
+		}
+		if got != want {
+			t.Errorf("ForMCC(%d) = %q, want %q", mcc, got, want)
+		}
+	}
+}
+
+func TestActive(t *testing.T) {
+	previous := Active()
+	t.Cleanup(func() { Use(previous) })
+
+	if len(previous) != 1 || previous.Home().Code != "NG" {
+		t.Errorf("before Use, home %s of %d, want Nigeria alone", previous.Home().Code, len(previous))
+	}
+	Use(mustSelect(t, "GH", "NG"))
+	if got := Active(); got.Home().Code != "GH" || len(got) != 2 {
+		t.Errorf("Active() = home %s of %d after Use", got.Home().Code, len(got))
+	}
+}
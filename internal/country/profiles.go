@@ -0,0 +1,71 @@
+package country
+
+// profiles are the countries a deployment can serve, by ISO code. Adding a
+// country is adding its profile here.
+var profiles = map[string]*Profile{
+	"NG": {
+		Code:           "NG",
+		Name:           "Nigeria",
+		CallingCode:    "234",
+		TrunkPrefix:    "0",
+		NationalLength: 10,
+		MCCs:           []int{621},
+		Timezone:       "Africa/Lagos",
+		Emergency:      EmergencyNumbers{General: "112", Police: "199", Ambulance: "112", Fire: "112"},
+		SenderID:       SenderIDRules{Alphanumeric: true, MaxLength: 11},
+
+		// NCC reallocates ranges from time to time; update this table when it does
+		CarrierPrefixes: map[string]string{
+			// MTN
+			"0703": "MTN", "0704": "MTN", "0706": "MTN", "07025": "MTN",
+			"07026": "MTN", "0803": "MTN", "0806": "MTN", "0810": "MTN",
+			"0813": "MTN", "0814": "MTN", "0816": "MTN", "0903": "MTN",
+			"0906": "MTN", "0913": "MTN", "0916": "MTN",
+
+			// Glo
+			"0705": "GLO", "0805": "GLO", "0807": "GLO", "0811": "GLO",
+			"0815": "GLO", "0905": "GLO", "0915": "GLO",
+
+			// Airtel
+			"0701": "AIRTEL", "0708": "AIRTEL", "0802": "AIRTEL", "0808": "AIRTEL",
+			"0812": "AIRTEL", "0901": "AIRTEL", "0902": "AIRTEL", "0904": "AIRTEL",
+			"0907": "AIRTEL", "0912": "AIRTEL",
+
+			// 9mobile
+			"0809": "9MOBILE", "0817": "9MOBILE", "0818": "9MOBILE",
+			"0908": "9MOBILE", "0909": "9MOBILE",
+		},
+		NetworkCarriers: map[int]string{
+			20: "AIRTEL",
+			30: "MTN",
+			50: "GLO",
+			60: "9MOBILE",
+		},
+	},
+	"GH": {
+		Code:           "GH",
+		Name:           "Ghana",
+		CallingCode:    "233",
+		TrunkPrefix:    "0",
+		NationalLength: 9,
+		MCCs:           []int{620},
+		Timezone:       "Africa/Accra",
+		Emergency:      EmergencyNumbers{General: "112", Police: "191", Ambulance: "193", Fire: "192"},
+		SenderID:       SenderIDRules{Alphanumeric: true, MaxLength: 11},
+
+		// Allocated by the NCA; ported numbers keep their original prefix
+		CarrierPrefixes: map[string]string{
+			"024": "MTN", "025": "MTN", "053": "MTN", "054": "MTN", "055": "MTN", "059": "MTN",
+			"020": "TELECEL", "050": "TELECEL",
+			"026": "AT", "027": "AT", "056": "AT", "057": "AT",
+			"023": "GLO",
+		},
+		NetworkCarriers: map[int]string{
+			1: "MTN",
+			2: "TELECEL",
+			3: "AT",
+			6: "AT",
+			7: "GLO",
+		},
+	},
+}
@@ -0,0 +1,127 @@
+package database
+
+import (
+	"context"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+const accountLinkColumns = `
+	id, guardian_user_id, ward_user_id, permissions, status, created_at, accepted_at, revoked_at
+`
+
+func scanAccountLink(row pgx.Row) (*models.AccountLink, error) {
+	var l models.AccountLink
+	err := row.Scan(
+		&l.ID, &l.GuardianUserID, &l.WardUserID, &l.Permissions, &l.Status,
+		&l.CreatedAt, &l.AcceptedAt, &l.RevokedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &l, nil
+}
+
+// Account link operations
+func (db *PostgresDB) CreateAccountLink(ctx context.Context, l *models.AccountLink) error {
+	query := `
+		INSERT INTO account_links (id, guardian_user_id, ward_user_id, permissions, status, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`
+	_, err := db.pool.Exec(ctx, query,
+		l.ID, l.GuardianUserID, l.WardUserID, l.Permissions, l.Status, l.CreatedAt,
+	)
+	if isUniqueViolation(err, "live_pair") {
+		return ErrLinkExists
+	}
+	return err
+}
+
+func (db *PostgresDB) GetAccountLink(ctx context.Context, id uuid.UUID) (*models.AccountLink, error) {
+	query := `SELECT ` + accountLinkColumns + ` FROM account_links WHERE id = $1`
+	link, err := scanAccountLink(db.pool.QueryRow(ctx, query, id))
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	return link, err
+}
+
+// GetActiveAccountLink returns the active link between guardian and ward, or nil
+func (db *PostgresDB) GetActiveAccountLink(ctx context.Context, guardianID, wardID uuid.UUID) (*models.AccountLink, error) {
+	query := `
+		SELECT ` + accountLinkColumns + `
+		FROM account_links
+		WHERE guardian_user_id = $1 AND ward_user_id = $2 AND status = 'active'
+	`
+	link, err := scanAccountLink(db.pool.QueryRow(ctx, query, guardianID, wardID))
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	return link, err
+}
+
+// GetAccountLinksForUser returns links where the user is guardian or ward, newest first
+func (db *PostgresDB) GetAccountLinksForUser(ctx context.Context, userID uuid.UUID) ([]models.AccountLink, error) {
+	query := `
+		SELECT ` + accountLinkColumns + `
+		FROM account_links
+		WHERE guardian_user_id = $1 OR ward_user_id = $1
+		ORDER BY created_at DESC
+	`
+	return db.queryAccountLinks(ctx, query, userID)
+}
+
+// GetGuardiansWithPermission returns the active links on a ward that grant the permission
+func (db *PostgresDB) GetGuardiansWithPermission(ctx context.Context, wardID uuid.UUID, permission string) ([]models.AccountLink, error) {
+	query := `
+		SELECT ` + accountLinkColumns + `
+		FROM account_links
+		WHERE ward_user_id = $1 AND status = 'active' AND $2 = ANY(permissions)
+		ORDER BY accepted_at ASC
+	`
+	return db.queryAccountLinks(ctx, query, wardID, permission)
+}
+
+// ActivateAccountLink accepts a pending link. Returns false if it was not pending.
+func (db *PostgresDB) ActivateAccountLink(ctx context.Context, id uuid.UUID) (bool, error) {
+	tag, err := db.pool.Exec(ctx, `
+		UPDATE account_links SET status = 'active', accepted_at = NOW()
+		WHERE id = $1 AND status = 'pending'
+	`, id)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// RevokeAccountLink revokes a pending or active link. Returns false if already revoked.
+func (db *PostgresDB) RevokeAccountLink(ctx context.Context, id uuid.UUID) (bool, error) {
+	tag, err := db.pool.Exec(ctx, `
+		UPDATE account_links SET status = 'revoked', revoked_at = NOW()
+		WHERE id = $1 AND status <> 'revoked'
+	`, id)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+func (db *PostgresDB) queryAccountLinks(ctx context.Context, query string, args ...interface{}) ([]models.AccountLink, error) {
+	rows, err := db.pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var links []models.AccountLink
+	for rows.Next() {
+		link, err := scanAccountLink(rows)
+		if err != nil {
+			return nil, err
+		}
+		links = append(links, *link)
+	}
+	return links, rows.Err()
+}
@@ -0,0 +1,51 @@
+package database
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+)
+
+// MarkAlertUndeliverable records why the alert reached nobody
+func (db *PostgresDB) MarkAlertUndeliverable(ctx context.Context, alertID uuid.UUID, reason string) error {
+	_, err := db.pool.Exec(ctx, `UPDATE alerts SET undeliverable = $2 WHERE id = $1`, alertID, reason)
+	return err
+}
+
+// GetActiveAlerts returns up to limit unresolved alerts of the organization's
+// members, or of every user when orgID is nil. Undeliverable alerts come
+// first, then the rest, each newest first.
+func (db *PostgresDB) GetActiveAlerts(ctx context.Context, orgID *uuid.UUID, limit int) ([]models.ActiveAlert, error) {
+	query := `
+		SELECT a.id, a.user_id, a.state, a.score, a.reason, a.reason_codes, a.sent_to, a.plus_code, a.what3words,
+		       a.duress, a.created_at, COALESCE(a.undeliverable, ''), u.name, u.phone
+		FROM alerts a
+		JOIN users u ON u.id = a.user_id
+		WHERE a.resolved_at IS NULL AND ($1::uuid IS NULL OR u.org_id = $1)
+		ORDER BY a.undeliverable IS NULL, a.created_at DESC
+		LIMIT $2
+	`
+	rows, err := db.pool.Query(ctx, query, orgID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	alerts := []models.ActiveAlert{}
+	for rows.Next() {
+		var a models.ActiveAlert
+		var sentTo models.StringArray
+		err := rows.Scan(
+			&a.ID, &a.UserID, &a.State, &a.Score, &a.Reason, &a.Reasons, &sentTo, &a.PlusCode, &a.What3Words,
+			&a.Duress, &a.CreatedAt, &a.Undeliverable, &a.UserName, &a.UserPhone,
+		)
+		if err != nil {
+			return nil, err
+		}
+		a.SentTo = sentTo
+		alerts = append(alerts, a)
+	}
+	return alerts, rows.Err()
+}
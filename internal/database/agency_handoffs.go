@@ -0,0 +1,121 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+	"github.com/google/uuid"
+)
+
+// Agency handoff operations
+
+// GetAgencyCandidates returns up to limit unresolved ALERT alerts created
+// between createdAfter and createdBefore, oldest first, after the alert at
+// (afterCreated, afterID), whose user has auto_escalate_police and which
+// haven't been handed off to an agency. Each comes with its user's name,
+// phone and settings and its acknowledgements.
+func (db *PostgresDB) GetAgencyCandidates(ctx context.Context, createdAfter, createdBefore, afterCreated time.Time, afterID uuid.UUID, limit int) ([]models.AgencyCandidate, error) {
+	query := `
+		SELECT a.id, a.user_id, a.state, a.score, a.reason, a.reason_codes, a.sent_to, a.plus_code, a.what3words,
+		       a.duress, a.created_at, COALESCE(a.undeliverable, ''),
+		       u.name, u.phone, u.settings, r.first_ack, r.acknowledged, r.recipients
+		FROM alerts a
+		JOIN users u ON u.id = a.user_id
+		CROSS JOIN LATERAL (
+			SELECT MIN(acknowledged_at) AS first_ack, COUNT(acknowledged_at) AS acknowledged, COUNT(*) AS recipients
+			FROM alert_recipients
+			WHERE alert_id = a.id
+		) r
+		WHERE a.resolved_at IS NULL AND a.agency_status IS NULL AND a.state = $1
+		  AND a.created_at > $2 AND a.created_at < $3 AND (a.created_at, a.id) > ($4, $5)
+		  AND COALESCE((u.settings->>'auto_escalate_police')::boolean, false)
+		ORDER BY a.created_at, a.id
+		LIMIT $6
+	`
+	rows, err := db.pool.Query(ctx, query, models.AlertStateAlert, createdAfter, createdBefore, afterCreated, afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var candidates []models.AgencyCandidate
+	for rows.Next() {
+		var c models.AgencyCandidate
+		var sentTo models.StringArray
+		err := rows.Scan(
+			&c.ID, &c.UserID, &c.State, &c.Score, &c.Reason, &c.Reasons, &sentTo, &c.PlusCode, &c.What3Words,
+			&c.Duress, &c.CreatedAt, &c.Undeliverable,
+			&c.UserName, &c.UserPhone, &c.Settings, &c.FirstAckAt, &c.Acknowledged, &c.RecipientCount,
+		)
+		if err != nil {
+			return nil, err
+		}
+		c.SentTo = sentTo
+		candidates = append(candidates, c)
+	}
+	return candidates, rows.Err()
+}
+
+// ClaimAgencyHandoff sets the agency status of an alert not handed off yet,
+// pending for one about to be sent or skipped, and reports whether it did,
+// so two instances can't both hand an alert off
+func (db *PostgresDB) ClaimAgencyHandoff(ctx context.Context, alertID uuid.UUID, status string, at time.Time) (bool, error) {
+	query := `
+		UPDATE alerts SET agency_status = $2, agency_escalated_at = $3
+		WHERE id = $1 AND agency_status IS NULL AND resolved_at IS NULL
+	`
+	tag, err := db.pool.Exec(ctx, query, alertID, status, at)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+// RecordAgencyHandoff records the outcome of a pending handoff
+func (db *PostgresDB) RecordAgencyHandoff(ctx context.Context, h *models.AgencyHandoff) error {
+	query := `
+		UPDATE alerts
+		SET agency_status = $2, agency_region = NULLIF($3, ''), agency_target = NULLIF($4, ''),
+		    agency_channel = NULLIF($5, ''), agency_reference = NULLIF($6, ''), agency_error = NULLIF($7, ''),
+		    agency_handed_off_at = $8
+		WHERE id = $1 AND agency_status = $9
+	`
+	_, err := db.pool.Exec(ctx, query,
+		h.AlertID, h.Status, h.Region, h.Target, h.Channel, h.Reference, h.Error, h.HandedOffAt, models.HandoffPending,
+	)
+	return err
+}
+
+// GetAgencyHandoffs returns up to limit handoffs started between from and
+// to, newest first
+func (db *PostgresDB) GetAgencyHandoffs(ctx context.Context, from, to time.Time, limit int) ([]models.AgencyHandoff, error) {
+	rows, err := db.pool.Query(ctx, `
+		SELECT id, user_id, agency_status, COALESCE(agency_region, ''), COALESCE(agency_target, ''),
+		       COALESCE(agency_channel, ''), COALESCE(agency_reference, ''), COALESCE(agency_error, ''),
+		       agency_escalated_at, agency_handed_off_at, created_at
+		FROM alerts
+		WHERE agency_escalated_at BETWEEN $1 AND $2
+		ORDER BY agency_escalated_at DESC
+		LIMIT $3
+	`, from, to, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	handoffs := []models.AgencyHandoff{}
+	for rows.Next() {
+		var h models.AgencyHandoff
+		err := rows.Scan(
+			&h.AlertID, &h.UserID, &h.Status, &h.Region, &h.Target,
+			&h.Channel, &h.Reference, &h.Error,
+			&h.EscalatedAt, &h.HandedOffAt, &h.AlertCreated,
+		)
+		if err != nil {
+			return nil, err
+		}
+		handoffs = append(handoffs, h)
+	}
+	return handoffs, rows.Err()
+}
@@ -0,0 +1,75 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+)
+
+// Alert latency operations
+
+const alertLatencyColumns = `id, user_id, state, detected_at, created_at, first_attempt_at, first_delivered_at`
+
+func scanAlertLatency(row pgx.Row) (*models.AlertLatency, error) {
+	var l models.AlertLatency
+	err := row.Scan(&l.AlertID, &l.UserID, &l.State, &l.DetectedAt, &l.CreatedAt, &l.FirstAttemptAt, &l.FirstDeliveredAt)
+	if err != nil {
+		return nil, err
+	}
+	return &l, nil
+}
+
+// MarkAlertAttempted records when a message to one of the alert's contacts
+// was first attempted; later attempts, e.g. an escalation, don't move it
+func (db *PostgresDB) MarkAlertAttempted(ctx context.Context, alertID uuid.UUID, at time.Time) error {
+	_, err := db.pool.Exec(ctx,
+		`UPDATE alerts SET first_attempt_at = $2 WHERE id = $1 AND first_attempt_at IS NULL`,
+		alertID, at,
+	)
+	return err
+}
+
+// MarkAlertDelivered records when a carrier first confirmed one of the
+// alert's messages delivered. It returns the alert's stages when this was
+// the first confirmation of an alert in the SLO, and nil otherwise.
+func (db *PostgresDB) MarkAlertDelivered(ctx context.Context, alertID uuid.UUID, at time.Time) (*models.AlertLatency, error) {
+	query := `
+		UPDATE alerts SET first_delivered_at = $2
+		WHERE id = $1 AND first_delivered_at IS NULL AND detected_at IS NOT NULL
+		RETURNING ` + alertLatencyColumns
+	latency, err := scanAlertLatency(db.pool.QueryRow(ctx, query, alertID, at))
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	return latency, err
+}
+
+// ListAlertLatencies returns the stages of the alerts raised in [from, to)
+// that are in the SLO, oldest first
+func (db *PostgresDB) ListAlertLatencies(ctx context.Context, from, to time.Time) ([]models.AlertLatency, error) {
+	query := `
+		SELECT ` + alertLatencyColumns + `
+		FROM alerts
+		WHERE created_at >= $1 AND created_at < $2 AND detected_at IS NOT NULL
+		ORDER BY created_at
+	`
+	rows, err := db.pool.Query(ctx, query, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var latencies []models.AlertLatency
+	for rows.Next() {
+		latency, err := scanAlertLatency(rows)
+		if err != nil {
+			return nil, err
+		}
+		latencies = append(latencies, *latency)
+	}
+	return latencies, rows.Err()
+}
@@ -0,0 +1,137 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+const alertRecipientColumns = `
+	id, alert_id, contact_id, contact_name, phone, channel, ack_token,
+	delivered_at, acknowledged_at, ack_method, created_at
+`
+
+func scanAlertRecipient(row pgx.Row) (*models.AlertRecipient, error) {
+	var r models.AlertRecipient
+	err := row.Scan(
+		&r.ID, &r.AlertID, &r.ContactID, &r.ContactName, &r.Phone, &r.Channel, &r.AckToken,
+		&r.DeliveredAt, &r.AcknowledgedAt, &r.AckMethod, &r.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+func (db *PostgresDB) GetAlertByID(ctx context.Context, id uuid.UUID) (*models.Alert, error) {
+	query := `
+		SELECT id, user_id, state, score, reason, reason_codes, sent_to, plus_code, what3words, duress, created_at, resolved_at,
+		       COALESCE(resolution, ''), COALESCE(undeliverable, ''), COALESCE(note, '')
+		FROM alerts
+		WHERE id = $1
+	`
+	var alert models.Alert
+	var sentTo models.StringArray
+	err := db.pool.QueryRow(ctx, query, id).Scan(
+		&alert.ID, &alert.UserID, &alert.State, &alert.Score, &alert.Reason, &alert.Reasons,
+		&sentTo, &alert.PlusCode, &alert.What3Words, &alert.Duress, &alert.CreatedAt, &alert.ResolvedAt,
+		&alert.Resolution, &alert.Undeliverable, &alert.Note,
+	)
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	alert.SentTo = sentTo
+	return &alert, nil
+}
+
+// Alert recipient operations
+func (db *PostgresDB) CreateAlertRecipient(ctx context.Context, r *models.AlertRecipient) error {
+	query := `
+		INSERT INTO alert_recipients (id, alert_id, contact_id, contact_name, phone, channel, ack_token, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	`
+	_, err := db.pool.Exec(ctx, query,
+		r.ID, r.AlertID, r.ContactID, r.ContactName, r.Phone, r.Channel, r.AckToken, r.CreatedAt,
+	)
+	return err
+}
+
+// MarkAlertRecipientDelivered records the first channel that reached the contact
+func (db *PostgresDB) MarkAlertRecipientDelivered(ctx context.Context, id uuid.UUID, channel string) error {
+	query := `
+		UPDATE alert_recipients SET delivered_at = NOW(), channel = $2
+		WHERE id = $1 AND delivered_at IS NULL
+	`
+	_, err := db.pool.Exec(ctx, query, id, channel)
+	return err
+}
+
+func (db *PostgresDB) GetAlertRecipients(ctx context.Context, alertID uuid.UUID) ([]models.AlertRecipient, error) {
+	query := `SELECT ` + alertRecipientColumns + ` FROM alert_recipients WHERE alert_id = $1 ORDER BY created_at ASC`
+	rows, err := db.pool.Query(ctx, query, alertID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var recipients []models.AlertRecipient
+	for rows.Next() {
+		r, err := scanAlertRecipient(rows)
+		if err != nil {
+			return nil, err
+		}
+		recipients = append(recipients, *r)
+	}
+	return recipients, rows.Err()
+}
+
+// AcknowledgeAlertByToken marks the recipient holding the token as acknowledged.
+// Repeated acknowledgments keep the first timestamp and method.
+func (db *PostgresDB) AcknowledgeAlertByToken(ctx context.Context, token, method string) (*models.AlertRecipient, error) {
+	query := `
+		UPDATE alert_recipients
+		SET acknowledged_at = COALESCE(acknowledged_at, NOW()),
+			ack_method = COALESCE(ack_method, $2)
+		WHERE ack_token = $1
+		RETURNING ` + alertRecipientColumns
+	r, err := scanAlertRecipient(db.pool.QueryRow(ctx, query, token, method))
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	return r, err
+}
+
+// AcknowledgeLatestAlertForPhone acknowledges the newest unacknowledged alert
+// sent to a phone since the given time, for replies that carry no token
+func (db *PostgresDB) AcknowledgeLatestAlertForPhone(ctx context.Context, phone, method string, since time.Time) (*models.AlertRecipient, error) {
+	query := `
+		UPDATE alert_recipients
+		SET acknowledged_at = NOW(), ack_method = $2
+		WHERE id = (
+			SELECT id FROM alert_recipients
+			WHERE phone = $1 AND acknowledged_at IS NULL AND created_at >= $3
+			ORDER BY created_at DESC
+			LIMIT 1
+		)
+		RETURNING ` + alertRecipientColumns
+	r, err := scanAlertRecipient(db.pool.QueryRow(ctx, query, phone, method, since))
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	return r, err
+}
+
+// HasAlertAcknowledgment reports whether any contact acknowledged the alert
+func (db *PostgresDB) HasAlertAcknowledgment(ctx context.Context, alertID uuid.UUID) (bool, error) {
+	var acked bool
+	err := db.pool.QueryRow(ctx, `
+		SELECT EXISTS (SELECT 1 FROM alert_recipients WHERE alert_id = $1 AND acknowledged_at IS NOT NULL)
+	`, alertID).Scan(&acked)
+	return acked, err
+}
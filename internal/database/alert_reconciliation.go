@@ -0,0 +1,154 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+	"github.com/google/uuid"
+)
+
+// Alert reconciliation operations
+
+// GetOrphanedAlerts returns up to limit alerts created before createdBefore
+// and still unresolved, oldest first, after the alert at (afterCreated,
+// afterID). Each comes with its delivery and acknowledgement counts and the
+// user's latest recorded state.
+func (db *PostgresDB) GetOrphanedAlerts(ctx context.Context, createdBefore, afterCreated time.Time, afterID uuid.UUID, limit int) ([]models.OrphanedAlert, error) {
+	query := `
+		SELECT a.id, a.user_id, a.state, a.score, a.reason, a.reason_codes, a.sent_to, a.plus_code, a.what3words,
+		       a.duress, a.created_at, COALESCE(a.undeliverable, ''),
+		       COALESCE(a.reconcile_class, ''), COALESCE(a.reconcile_action, ''),
+		       d.attempted, d.sent, r.acknowledged, COALESCE(s.to_state, ''), s.timestamp
+		FROM alerts a
+		CROSS JOIN LATERAL (
+			SELECT COUNT(*) FILTER (WHERE status IN ('sent', 'failed')) AS attempted,
+			       COUNT(*) FILTER (WHERE status = 'sent') AS sent
+			FROM alert_deliveries
+			WHERE alert_id = a.id
+		) d
+		CROSS JOIN LATERAL (
+			SELECT COUNT(*) FILTER (WHERE acknowledged_at IS NOT NULL) AS acknowledged
+			FROM alert_recipients
+			WHERE alert_id = a.id
+		) r
+		LEFT JOIN LATERAL (
+			SELECT to_state, timestamp
+			FROM user_states
+			WHERE user_id = a.user_id
+			ORDER BY timestamp DESC, id DESC
+			LIMIT 1
+		) s ON true
+		WHERE a.resolved_at IS NULL AND a.created_at < $1 AND (a.created_at, a.id) > ($2, $3)
+		ORDER BY a.created_at, a.id
+		LIMIT $4
+	`
+	rows, err := db.pool.Query(ctx, query, createdBefore, afterCreated, afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var alerts []models.OrphanedAlert
+	for rows.Next() {
+		var a models.OrphanedAlert
+		var sentTo models.StringArray
+		err := rows.Scan(
+			&a.ID, &a.UserID, &a.State, &a.Score, &a.Reason, &a.Reasons, &sentTo, &a.PlusCode, &a.What3Words,
+			&a.Duress, &a.CreatedAt, &a.Undeliverable,
+			&a.ReconcileClass, &a.ReconcileAction,
+			&a.Attempted, &a.Sent, &a.Acknowledged, &a.UserState, &a.StateSince,
+		)
+		if err != nil {
+			return nil, err
+		}
+		a.SentTo = sentTo
+		alerts = append(alerts, a)
+	}
+	return alerts, rows.Err()
+}
+
+// MarkAlertReconciled records the class and action of an unresolved alert
+// and reports whether it did. An alert already recorded with the same class
+// and action, or resolved meanwhile, is left as it is, so two instances
+// can't both act on it.
+func (db *PostgresDB) MarkAlertReconciled(ctx context.Context, alertID uuid.UUID, class, action string, at time.Time) (bool, error) {
+	query := `
+		UPDATE alerts SET reconcile_class = $2, reconcile_action = $3, reconciled_at = $4
+		WHERE id = $1 AND resolved_at IS NULL
+		  AND (reconcile_class IS DISTINCT FROM $2 OR reconcile_action IS DISTINCT FROM $3)
+	`
+	tag, err := db.pool.Exec(ctx, query, alertID, class, action, at)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+// CloseReconciledAlert resolves an unresolved alert as reconciled, recording
+// its class, and reports whether it did
+func (db *PostgresDB) CloseReconciledAlert(ctx context.Context, alertID uuid.UUID, class string, at time.Time) (bool, error) {
+	query := `
+		UPDATE alerts
+		SET resolved_at = $2, resolution = $3, reconcile_class = $4, reconcile_action = $5, reconciled_at = $2
+		WHERE id = $1 AND resolved_at IS NULL
+	`
+	tag, err := db.pool.Exec(ctx, query, alertID, at, models.AlertResolvedReconciled, class, models.ReconcileClosed)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+// GetReconcileCounts counts the alerts last reconciled between from and to
+// by their class and action
+func (db *PostgresDB) GetReconcileCounts(ctx context.Context, from, to time.Time) ([]models.ReconcileCount, error) {
+	rows, err := db.pool.Query(ctx, `
+		SELECT reconcile_class, reconcile_action, COUNT(*)
+		FROM alerts
+		WHERE reconciled_at BETWEEN $1 AND $2
+		GROUP BY reconcile_class, reconcile_action
+		ORDER BY reconcile_class, reconcile_action
+	`, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := []models.ReconcileCount{}
+	for rows.Next() {
+		var c models.ReconcileCount
+		if err := rows.Scan(&c.Class, &c.Action, &c.Alerts); err != nil {
+			return nil, err
+		}
+		counts = append(counts, c)
+	}
+	return counts, rows.Err()
+}
+
+// GetFlaggedAlerts returns up to limit alerts the reconciler flagged that
+// are still unresolved, oldest first
+func (db *PostgresDB) GetFlaggedAlerts(ctx context.Context, limit int) ([]models.ReconciledAlert, error) {
+	rows, err := db.pool.Query(ctx, `
+		SELECT id, user_id, state, created_at, resolved_at, reconcile_class, reconcile_action, reconciled_at
+		FROM alerts
+		WHERE resolved_at IS NULL AND reconcile_action = $1
+		ORDER BY created_at
+		LIMIT $2
+	`, models.ReconcileFlagged, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	alerts := []models.ReconciledAlert{}
+	for rows.Next() {
+		var a models.ReconciledAlert
+		err := rows.Scan(&a.ID, &a.UserID, &a.State, &a.CreatedAt, &a.ResolvedAt, &a.Class, &a.Action, &a.ReconciledAt)
+		if err != nil {
+			return nil, err
+		}
+		alerts = append(alerts, a)
+	}
+	return alerts, rows.Err()
+}
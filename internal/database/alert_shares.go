@@ -0,0 +1,50 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+	"github.com/jackc/pgx/v5"
+)
+
+// Alert share operations
+
+// GetAlertShare returns the recipient a contact dashboard token belongs to
+// and its scope, or nil. A recipient's own ack token is acknowledge-capable;
+// other tokens are the shares they made.
+func (db *PostgresDB) GetAlertShare(ctx context.Context, token string) (*models.AlertShare, error) {
+	query := `
+		SELECT ` + alertRecipientColumns + `, t.scope
+		FROM alert_recipients
+		JOIN (
+			SELECT id AS recipient_id, 'acknowledge' AS scope FROM alert_recipients WHERE ack_token = $1
+			UNION ALL
+			SELECT recipient_id, scope FROM alert_share_tokens WHERE token = $1
+		) t ON t.recipient_id = alert_recipients.id
+		LIMIT 1
+	`
+	share := models.AlertShare{Token: token}
+	r := &share.Recipient
+	err := db.pool.QueryRow(ctx, query, token).Scan(
+		&r.ID, &r.AlertID, &r.ContactID, &r.ContactName, &r.Phone, &r.Channel, &r.AckToken,
+		&r.DeliveredAt, &r.AcknowledgedAt, &r.AckMethod, &r.CreatedAt, &share.Scope,
+	)
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &share, nil
+}
+
+// CreateAlertShare stores a dashboard token made by a recipient
+func (db *PostgresDB) CreateAlertShare(ctx context.Context, share *models.AlertShare, createdAt time.Time) error {
+	query := `
+		INSERT INTO alert_share_tokens (token, alert_id, recipient_id, scope, created_at)
+		VALUES ($1, $2, $3, $4, $5)
+	`
+	_, err := db.pool.Exec(ctx, query, share.Token, share.Recipient.AlertID, share.Recipient.ID, share.Scope, createdAt)
+	return err
+}
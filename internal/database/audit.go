@@ -0,0 +1,108 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+	"github.com/jackc/pgx/v5"
+)
+
+// CopyAuditEvents bulk-inserts audit events
+func (db *PostgresDB) CopyAuditEvents(ctx context.Context, events []*models.AuditEvent) (int64, error) {
+	rows := make([][]interface{}, 0, len(events))
+	for _, e := range events {
+		metadata, err := json.Marshal(e.Metadata)
+		if err != nil {
+			return 0, err
+		}
+		rows = append(rows, []interface{}{
+			e.ID, e.ActorID, e.ActorRole, e.Action, e.ObjectType, e.ObjectID,
+			e.SubjectUserID, e.RequestID, string(metadata), e.CreatedAt,
+		})
+	}
+
+	return db.pool.CopyFrom(ctx,
+		pgx.Identifier{"audit_events"},
+		[]string{"id", "actor_id", "actor_role", "action", "object_type", "object_id", "subject_user_id", "request_id", "metadata", "created_at"},
+		pgx.CopyFromRows(rows),
+	)
+}
+
+// GetAuditEvents returns matching events newest first, plus the total match count
+func (db *PostgresDB) GetAuditEvents(ctx context.Context, filter models.AuditFilter, limit, offset int) ([]models.AuditEvent, int, error) {
+	var conditions []string
+	var args []interface{}
+	add := func(clause string, value interface{}) {
+		args = append(args, value)
+		conditions = append(conditions, fmt.Sprintf(clause, len(args)))
+	}
+
+	if filter.ActorID != "" {
+		add("actor_id = $%d", filter.ActorID)
+	}
+	if filter.SubjectUserID != nil {
+		add("subject_user_id = $%d", *filter.SubjectUserID)
+	}
+	if filter.OrgID != nil {
+		args = append(args, *filter.OrgID)
+		conditions = append(conditions, fmt.Sprintf(
+			"(subject_user_id IN (SELECT id FROM users WHERE org_id = $%d) OR (object_type = 'organization' AND object_id = $%d::text))",
+			len(args), len(args)))
+	}
+	if filter.Action != "" {
+		add("action = $%d", filter.Action)
+	}
+	if filter.ObjectType != "" {
+		add("object_type = $%d", filter.ObjectType)
+	}
+	if filter.From != nil {
+		add("created_at >= $%d", *filter.From)
+	}
+	if filter.To != nil {
+		add("created_at <= $%d", *filter.To)
+	}
+
+	where := ""
+	if len(conditions) > 0 {
+		where = "WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	var total int
+	if err := db.pool.QueryRow(ctx, "SELECT COUNT(*) FROM audit_events "+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	query := fmt.Sprintf(`
+		SELECT id, actor_id, actor_role, action, object_type, object_id, subject_user_id, request_id, metadata, created_at
+		FROM audit_events
+		%s
+		ORDER BY created_at DESC
+		LIMIT $%d OFFSET $%d
+	`, where, len(args)+1, len(args)+2)
+	rows, err := db.pool.Query(ctx, query, append(args, limit, offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var events []models.AuditEvent
+	for rows.Next() {
+		var e models.AuditEvent
+		var metadata []byte
+		err := rows.Scan(
+			&e.ID, &e.ActorID, &e.ActorRole, &e.Action, &e.ObjectType, &e.ObjectID,
+			&e.SubjectUserID, &e.RequestID, &metadata, &e.CreatedAt,
+		)
+		if err != nil {
+			return nil, 0, err
+		}
+		if err := json.Unmarshal(metadata, &e.Metadata); err != nil {
+			return nil, 0, err
+		}
+		events = append(events, e)
+	}
+	return events, total, rows.Err()
+}
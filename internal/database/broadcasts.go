@@ -0,0 +1,251 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// Push token operations
+func (db *PostgresDB) UpsertPushToken(ctx context.Context, userID uuid.UUID, token string) error {
+	query := `
+		INSERT INTO push_tokens (user_id, fcm_token, updated_at)
+		VALUES ($1, $2, NOW())
+		ON CONFLICT (user_id) DO UPDATE SET fcm_token = EXCLUDED.fcm_token, updated_at = NOW()
+	`
+	_, err := db.pool.Exec(ctx, query, userID, token)
+	return err
+}
+
+func (db *PostgresDB) GetPushToken(ctx context.Context, userID uuid.UUID) (string, error) {
+	var token string
+	err := db.pool.QueryRow(ctx, `SELECT fcm_token FROM push_tokens WHERE user_id = $1`, userID).Scan(&token)
+	if err == pgx.ErrNoRows {
+		return "", nil
+	}
+	return token, err
+}
+
+// GetLatestPositionsInBounds returns each user's latest heartbeat since the given
+// time, restricted to a bounding box. Callers refine the result to the exact shape.
+func (db *PostgresDB) GetLatestPositionsInBounds(
+	ctx context.Context,
+	minLat, maxLat, minLng, maxLng float64,
+	since time.Time,
+) ([]models.UserPosition, error) {
+	query := `
+		SELECT user_id, lat, lng, timestamp FROM (
+			SELECT DISTINCT ON (user_id) user_id, lat, lng, timestamp
+			FROM heartbeats
+			WHERE timestamp >= $5
+			ORDER BY user_id, timestamp DESC
+		) latest
+		WHERE lat BETWEEN $1 AND $2 AND lng BETWEEN $3 AND $4
+	`
+	rows, err := db.pool.Query(ctx, query, minLat, maxLat, minLng, maxLng, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var positions []models.UserPosition
+	for rows.Next() {
+		var p models.UserPosition
+		if err := rows.Scan(&p.UserID, &p.Lat, &p.Lng, &p.Timestamp); err != nil {
+			return nil, err
+		}
+		positions = append(positions, p)
+	}
+	return positions, rows.Err()
+}
+
+// Broadcast operations
+
+// CreateBroadcast stores a broadcast and its queued deliveries in one transaction
+func (db *PostgresDB) CreateBroadcast(ctx context.Context, b *models.Broadcast, deliveries []models.BroadcastDelivery) error {
+	tx, err := db.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	query := `
+		INSERT INTO broadcasts (id, created_by, message, channels, geofence, audience_size, status, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	`
+	_, err = tx.Exec(ctx, query,
+		b.ID, b.CreatedBy, b.Message, b.Channels, b.Geofence,
+		b.AudienceSize, b.Status, b.CreatedAt,
+	)
+	if err != nil {
+		return err
+	}
+
+	rows := make([][]interface{}, 0, len(deliveries))
+	for _, d := range deliveries {
+		rows = append(rows, []interface{}{d.ID, d.BroadcastID, d.UserID, d.Channel, d.Status, d.CreatedAt})
+	}
+	_, err = tx.CopyFrom(ctx,
+		pgx.Identifier{"broadcast_deliveries"},
+		[]string{"id", "broadcast_id", "user_id", "channel", "status", "created_at"},
+		pgx.CopyFromRows(rows),
+	)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+func (db *PostgresDB) GetBroadcast(ctx context.Context, id uuid.UUID) (*models.Broadcast, error) {
+	query := `
+		SELECT id, created_by, message, channels, geofence, audience_size, status, created_at, completed_at
+		FROM broadcasts WHERE id = $1
+	`
+	var b models.Broadcast
+	err := db.pool.QueryRow(ctx, query, id).Scan(
+		&b.ID, &b.CreatedBy, &b.Message, &b.Channels, &b.Geofence,
+		&b.AudienceSize, &b.Status, &b.CreatedAt, &b.CompletedAt,
+	)
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+func (db *PostgresDB) GetBroadcastIDsByStatus(ctx context.Context, status string) ([]uuid.UUID, error) {
+	rows, err := db.pool.Query(ctx, `SELECT id FROM broadcasts WHERE status = $1`, status)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []uuid.UUID
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// GetBroadcastGeofencesSince returns the areas of broadcasts created since
+// the given time, leaving out aborted ones
+func (db *PostgresDB) GetBroadcastGeofencesSince(ctx context.Context, since time.Time) ([]models.Geofence, error) {
+	query := `SELECT geofence FROM broadcasts WHERE created_at >= $1 AND status <> $2`
+	rows, err := db.pool.Query(ctx, query, since, models.BroadcastStatusAborted)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var fences []models.Geofence
+	for rows.Next() {
+		var fence models.Geofence
+		if err := rows.Scan(&fence); err != nil {
+			return nil, err
+		}
+		fences = append(fences, fence)
+	}
+	return fences, rows.Err()
+}
+
+// GetBroadcastStats counts deliveries by status
+func (db *PostgresDB) GetBroadcastStats(ctx context.Context, id uuid.UUID) (map[string]int, error) {
+	rows, err := db.pool.Query(ctx, `
+		SELECT status, COUNT(*) FROM broadcast_deliveries
+		WHERE broadcast_id = $1
+		GROUP BY status
+	`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stats := make(map[string]int)
+	for rows.Next() {
+		var status string
+		var count int
+		if err := rows.Scan(&status, &count); err != nil {
+			return nil, err
+		}
+		stats[status] = count
+	}
+	return stats, rows.Err()
+}
+
+// FinishBroadcast moves a sending broadcast to a terminal status; it is a no-op
+// if the broadcast already left the sending state (e.g. it was aborted)
+func (db *PostgresDB) FinishBroadcast(ctx context.Context, id uuid.UUID, status string) (bool, error) {
+	tag, err := db.pool.Exec(ctx, `
+		UPDATE broadcasts SET status = $2, completed_at = NOW()
+		WHERE id = $1 AND status = 'sending'
+	`, id, status)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// AbortBroadcastDeliveries marks every still-queued delivery as aborted
+func (db *PostgresDB) AbortBroadcastDeliveries(ctx context.Context, id uuid.UUID) (int64, error) {
+	tag, err := db.pool.Exec(ctx, `
+		UPDATE broadcast_deliveries SET status = 'aborted'
+		WHERE broadcast_id = $1 AND status = 'queued'
+	`, id)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+// GetQueuedBroadcastDeliveries returns the next queued deliveries with the
+// recipient's phone and push token resolved
+func (db *PostgresDB) GetQueuedBroadcastDeliveries(ctx context.Context, id uuid.UUID, limit int) ([]models.BroadcastDelivery, error) {
+	query := `
+		SELECT d.id, d.broadcast_id, d.user_id, d.channel, d.status, d.created_at,
+			u.phone, COALESCE(p.fcm_token, '')
+		FROM broadcast_deliveries d
+		JOIN users u ON u.id = d.user_id
+		LEFT JOIN push_tokens p ON p.user_id = d.user_id
+		WHERE d.broadcast_id = $1 AND d.status = 'queued'
+		ORDER BY d.created_at, d.id
+		LIMIT $2
+	`
+	rows, err := db.pool.Query(ctx, query, id, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deliveries []models.BroadcastDelivery
+	for rows.Next() {
+		var d models.BroadcastDelivery
+		err := rows.Scan(
+			&d.ID, &d.BroadcastID, &d.UserID, &d.Channel, &d.Status, &d.CreatedAt,
+			&d.Phone, &d.FCMToken,
+		)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, rows.Err()
+}
+
+// CompleteBroadcastDelivery records the send result, unless the delivery was aborted meanwhile
+func (db *PostgresDB) CompleteBroadcastDelivery(ctx context.Context, id uuid.UUID, status, errMsg string) error {
+	_, err := db.pool.Exec(ctx, `
+		UPDATE broadcast_deliveries SET status = $2, error = $3, sent_at = NOW()
+		WHERE id = $1 AND status = 'queued'
+	`, id, status, errMsg)
+	return err
+}
@@ -0,0 +1,171 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgconn"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/cache"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+)
+
+// maxCachedUsers bounds the in-process user cache
+const maxCachedUsers = 10000
+
+// Caches is the registry of this instance's in-process caches, which
+// invalidations received with ListenInvalidations evict from
+func (db *PostgresDB) Caches() *cache.Registry {
+	return db.caches
+}
+
+// orgProfile is a user's organization and its scoring profile override, or
+// uuid.Nil and nil if they have none
+type orgProfile struct {
+	orgID   uuid.UUID
+	profile json.RawMessage
+}
+
+func parseUserKey(key string) (uuid.UUID, bool) {
+	id, err := uuid.Parse(key)
+	return id, err == nil
+}
+
+// registerUserCache makes user and contact changes evict cached users
+func (db *PostgresDB) registerUserCache() {
+	evicter := db.users.Evicter(parseUserKey)
+	db.caches.Register(cache.KindUser, evicter)
+	db.caches.Register(cache.KindContacts, evicter)
+	db.caches.Track("users", db.users)
+}
+
+// registerOrgProfileCache makes a user's change of organization, and any
+// change to an organization, evict cached scoring profiles. Entries are
+// keyed by user, so an organization's change flushes them all.
+func (db *PostgresDB) registerOrgProfileCache() {
+	db.caches.Register(cache.KindUser, db.orgProfiles.Evicter(parseUserKey))
+	db.caches.Register(cache.KindOrganization, cache.Funcs{
+		EvictFunc: func(string) { db.orgProfiles.Flush() },
+		FlushFunc: db.orgProfiles.Flush,
+	})
+	db.caches.Track("org_scoring_profiles", db.orgProfiles)
+}
+
+// cachedUser returns a copy of the cached user, so callers can change it
+func (db *PostgresDB) cachedUser(id uuid.UUID) (*models.User, bool) {
+	user, ok := db.users.Get(id)
+	if !ok {
+		return nil, false
+	}
+	return cloneUser(user), true
+}
+
+// forgetUser evicts the user from this instance's cache right away, so the
+// instance that changed them reads the change back; other instances evict
+// theirs when the trigger's notification arrives
+func (db *PostgresDB) forgetUser(id uuid.UUID) {
+	db.users.Delete(id)
+	db.orgProfiles.Delete(id)
+}
+
+// cloneUser copies the user's slices and pointers, so the copy can be changed
+// without changing the cached one
+func cloneUser(u *models.User) *models.User {
+	clone := *u
+	if u.OrgID != nil {
+		orgID := *u.OrgID
+		clone.OrgID = &orgID
+	}
+	if u.TrustedContacts != nil {
+		clone.TrustedContacts = make(models.TrustedContacts, len(u.TrustedContacts))
+		for i, c := range u.TrustedContacts {
+			if c.Preferences != nil {
+				prefs := *c.Preferences
+				c.Preferences = &prefs
+			}
+			if c.DeletedAt != nil {
+				deletedAt := *c.DeletedAt
+				c.DeletedAt = &deletedAt
+			}
+			clone.TrustedContacts[i] = c
+		}
+	}
+	s := &clone.Settings
+	if s.SafeZones != nil {
+		s.SafeZones = make([]models.Geofence, len(u.Settings.SafeZones))
+		for i, zone := range u.Settings.SafeZones {
+			s.SafeZones[i] = cloneGeofence(zone)
+		}
+	}
+	s.SilentPromptLadder = append([]string(nil), u.Settings.SilentPromptLadder...)
+	s.AutoResolveZones = append([]int(nil), u.Settings.AutoResolveZones...)
+	return &clone
+}
+
+func cloneGeofence(g models.Geofence) models.Geofence {
+	if g.Center != nil {
+		center := *g.Center
+		g.Center = &center
+	}
+	g.Points = append([]models.GeoPoint(nil), g.Points...)
+	return g
+}
+
+// PublishInvalidation tells every instance, this one included, that data
+// behind their caches changed. Changes to users, contacts and broadcasts are
+// published by triggers; this is for changes made outside the database.
+func (db *PostgresDB) PublishInvalidation(ctx context.Context, e cache.Event) error {
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = db.pool.Exec(ctx, `SELECT pg_notify($1, $2)`, cache.Channel, string(payload))
+	return err
+}
+
+// ListenInvalidations holds a connection of its own listening on
+// cache.Channel and calls fn with each invalidation, until ctx ends or the
+// connection fails. listening is called once notifications are being
+// received; anything changed before then may have been missed. Every wait
+// ends after at most idle, calling fn with nothing, so the caller can tell
+// a quiet connection from a hung one. Payloads that can't be parsed are
+// skipped.
+func (db *PostgresDB) ListenInvalidations(ctx context.Context, idle time.Duration, listening func(), fn func(*cache.Event)) error {
+	pooled, err := db.pool.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	// A listening connection must never go back to the pool
+	conn := pooled.Hijack()
+	defer conn.Close(context.Background())
+
+	if _, err := conn.Exec(ctx, `LISTEN `+cache.Channel); err != nil {
+		return fmt.Errorf("listen on %s: %w", cache.Channel, err)
+	}
+	listening()
+
+	for {
+		waitCtx, cancel := context.WithTimeout(ctx, idle)
+		n, err := conn.WaitForNotification(waitCtx)
+		cancel()
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			if pgconn.Timeout(err) || errors.Is(err, context.DeadlineExceeded) {
+				fn(nil)
+				continue
+			}
+			return err
+		}
+		e, err := cache.ParseEvent(n.Payload)
+		if err != nil {
+			continue
+		}
+		fn(&e)
+	}
+}
@@ -0,0 +1,73 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/cache"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+)
+
+// warmDB returns a PostgresDB without a pool, its caches holding one user
+// and their organization's scoring profile. Any query would panic.
+func warmDB() (*PostgresDB, uuid.UUID) {
+	db := &PostgresDB{
+		caches:      cache.NewRegistry(),
+		users:       cache.New[uuid.UUID, *models.User](time.Hour, maxCachedUsers),
+		orgProfiles: cache.New[uuid.UUID, orgProfile](time.Hour, maxCachedUsers),
+	}
+	db.registerUserCache()
+	db.registerOrgProfileCache()
+
+	orgID := uuid.New()
+	user := &models.User{ID: uuid.New(), Phone: "+2348031234567", Name: "Ada", OrgID: &orgID}
+	user.Settings.SafeZones = []models.Geofence{{Type: "radius", Center: &models.GeoPoint{Lat: 6.5244, Lng: 3.3792}, RadiusM: 150}}
+	db.users.Set(user.ID, cloneUser(user))
+	db.orgProfiles.Set(user.ID, orgProfile{orgID: orgID, profile: json.RawMessage(`{"weights":{"stationary":1.5}}`)})
+	return db, user.ID
+}
+
+// evaluationLookups makes the cached reads of one evaluation: the user, with
+// their safe zones and contacts, and their organization's scoring profile
+func evaluationLookups(ctx context.Context, db *PostgresDB, userID uuid.UUID) error {
+	if _, err := db.GetUserByID(ctx, userID); err != nil {
+		return err
+	}
+	_, _, err := db.GetUserOrgScoringProfile(ctx, userID)
+	return err
+}
+
+func TestWarmCacheMakesNoQueries(t *testing.T) {
+	db, userID := warmDB()
+	if err := evaluationLookups(context.Background(), db, userID); err != nil {
+		t.Fatalf("evaluationLookups: %v", err)
+	}
+	stats := db.Caches().Stats()
+	if stats["users"].Hits != 1 || stats["org_scoring_profiles"].Hits != 1 || stats["org_scoring_profiles"].Loads != 0 {
+		t.Errorf("stats = %+v", stats)
+	}
+
+	// An invalidation evicts, so the next read would go to Postgres
+	db.Caches().Invalidate(cache.Event{Kind: cache.KindUser, Key: userID.String()})
+	if _, ok := db.cachedUser(userID); ok {
+		t.Errorf("user still cached after their invalidation")
+	}
+}
+
+// BenchmarkWarmEvaluationLookups runs an evaluation's cached reads on a warm
+// cache. The PostgresDB has no pool, so finishing at all means no queries.
+func BenchmarkWarmEvaluationLookups(b *testing.B) {
+	db, userID := warmDB()
+	ctx := context.Background()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := evaluationLookups(ctx, db, userID); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
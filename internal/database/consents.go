@@ -0,0 +1,227 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+)
+
+const consentColumns = `
+	id, user_id, scope, policy_version, granted_at, revoked_at, superseded_at,
+	COALESCE(client_ip, ''), COALESCE(user_agent, '')
+`
+
+func scanConsent(row pgx.Row) (*models.Consent, error) {
+	var c models.Consent
+	err := row.Scan(
+		&c.ID, &c.UserID, &c.Scope, &c.PolicyVersion, &c.GrantedAt, &c.RevokedAt, &c.SupersededAt,
+		&c.ClientIP, &c.UserAgent,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+func collectConsents(rows pgx.Rows) ([]models.Consent, error) {
+	defer rows.Close()
+
+	consents := []models.Consent{}
+	for rows.Next() {
+		c, err := scanConsent(rows)
+		if err != nil {
+			return nil, err
+		}
+		consents = append(consents, *c)
+	}
+	return consents, rows.Err()
+}
+
+// Consent operations
+
+// GrantConsent records the user's consent, closing their open consent to the
+// same scope as superseded. An open consent to the same policy version is
+// kept and returned as is. The user's row is locked meanwhile, so concurrent
+// grants apply one after another.
+func (db *PostgresDB) GrantConsent(ctx context.Context, c *models.Consent) (*models.Consent, error) {
+	tx, err := db.pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	var locked uuid.UUID
+	if err := tx.QueryRow(ctx, `SELECT id FROM users WHERE id = $1 FOR UPDATE`, c.UserID).Scan(&locked); err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, ErrUserNotFound
+		}
+		return nil, err
+	}
+
+	query := `
+		SELECT ` + consentColumns + `
+		FROM consents
+		WHERE user_id = $1 AND scope = $2 AND revoked_at IS NULL AND superseded_at IS NULL
+	`
+	open, err := scanConsent(tx.QueryRow(ctx, query, c.UserID, c.Scope))
+	switch {
+	case err == pgx.ErrNoRows:
+	case err != nil:
+		return nil, err
+	case open.PolicyVersion == c.PolicyVersion:
+		return open, tx.Commit(ctx)
+	default:
+		_, err := tx.Exec(ctx, `UPDATE consents SET superseded_at = $2 WHERE id = $1`, open.ID, c.GrantedAt)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	query = `
+		INSERT INTO consents (id, user_id, scope, policy_version, granted_at, client_ip, user_agent)
+		VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''), NULLIF($7, ''))
+		RETURNING ` + consentColumns
+	granted, err := scanConsent(tx.QueryRow(ctx, query,
+		c.ID, c.UserID, c.Scope, c.PolicyVersion, c.GrantedAt, c.ClientIP, c.UserAgent,
+	))
+	if err != nil {
+		return nil, err
+	}
+	return granted, tx.Commit(ctx)
+}
+
+// RevokeConsent closes the user's open consent to scope and returns it, or
+// nil if there was none
+func (db *PostgresDB) RevokeConsent(ctx context.Context, userID uuid.UUID, scope string, at time.Time) (*models.Consent, error) {
+	query := `
+		UPDATE consents
+		SET revoked_at = $3
+		WHERE user_id = $1 AND scope = $2 AND revoked_at IS NULL AND superseded_at IS NULL
+		RETURNING ` + consentColumns
+	c, err := scanConsent(db.pool.QueryRow(ctx, query, userID, scope, at))
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	return c, err
+}
+
+// GetOpenConsents returns the user's open consents, one per scope at most
+func (db *PostgresDB) GetOpenConsents(ctx context.Context, userID uuid.UUID) ([]models.Consent, error) {
+	query := `
+		SELECT ` + consentColumns + `
+		FROM consents
+		WHERE user_id = $1 AND revoked_at IS NULL AND superseded_at IS NULL
+		ORDER BY scope
+	`
+	rows, err := db.pool.Query(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	return collectConsents(rows)
+}
+
+// GetConsentHistory returns the user's consents, open and closed, newest first
+func (db *PostgresDB) GetConsentHistory(ctx context.Context, userID uuid.UUID, limit, offset int) ([]models.Consent, error) {
+	query := `
+		SELECT ` + consentColumns + `
+		FROM consents
+		WHERE user_id = $1
+		ORDER BY granted_at DESC, id
+		LIMIT $2 OFFSET $3
+	`
+	rows, err := db.pool.Query(ctx, query, userID, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	return collectConsents(rows)
+}
+
+// HasConsent reports whether the user has an open consent to scope under
+// version or a newer one
+func (db *PostgresDB) HasConsent(ctx context.Context, userID uuid.UUID, scope string, version int) (bool, error) {
+	query := `
+		SELECT EXISTS (
+			SELECT 1 FROM consents
+			WHERE user_id = $1 AND scope = $2 AND policy_version >= $3
+			  AND revoked_at IS NULL AND superseded_at IS NULL
+		)
+	`
+	var ok bool
+	err := db.pool.QueryRow(ctx, query, userID, scope, version).Scan(&ok)
+	return ok, err
+}
+
+// FilterConsented returns those of userIDs with an open consent to scope
+// under version or a newer one
+func (db *PostgresDB) FilterConsented(ctx context.Context, userIDs []uuid.UUID, scope string, version int) (map[uuid.UUID]bool, error) {
+	query := `
+		SELECT user_id FROM consents
+		WHERE user_id = ANY($1) AND scope = $2 AND policy_version >= $3
+		  AND revoked_at IS NULL AND superseded_at IS NULL
+	`
+	rows, err := db.pool.Query(ctx, query, userIDs, scope, version)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	consented := make(map[uuid.UUID]bool)
+	for rows.Next() {
+		var userID uuid.UUID
+		if err := rows.Scan(&userID); err != nil {
+			return nil, err
+		}
+		consented[userID] = true
+	}
+	return consented, rows.Err()
+}
+
+// SupersedeOutdatedConsents closes every open consent to scope under a
+// policy version older than version and returns them. Each is closed once,
+// so only one instance acts on it.
+func (db *PostgresDB) SupersedeOutdatedConsents(ctx context.Context, scope string, version int, at time.Time) ([]models.Consent, error) {
+	query := `
+		UPDATE consents
+		SET superseded_at = $3
+		WHERE scope = $1 AND policy_version < $2 AND revoked_at IS NULL AND superseded_at IS NULL
+		RETURNING ` + consentColumns
+	rows, err := db.pool.Query(ctx, query, scope, version, at)
+	if err != nil {
+		return nil, err
+	}
+	return collectConsents(rows)
+}
+
+// DisableAudioSharing turns the user's share_audio setting off
+func (db *PostgresDB) DisableAudioSharing(ctx context.Context, userID uuid.UUID) error {
+	defer db.forgetUser(userID)
+
+	query := `
+		UPDATE users
+		SET settings = jsonb_set(settings, '{share_audio}', 'false'), updated_at = NOW()
+		WHERE id = $1 AND COALESCE((settings->>'share_audio')::boolean, false)
+	`
+	_, err := db.pool.Exec(ctx, query, userID)
+	return err
+}
+
+// DisableCoarseLocation turns the user's coarse_location setting off and
+// reports whether it was on
+func (db *PostgresDB) DisableCoarseLocation(ctx context.Context, userID uuid.UUID) (bool, error) {
+	defer db.forgetUser(userID)
+
+	query := `
+		UPDATE users
+		SET settings = jsonb_set(settings, '{coarse_location}', 'false'), updated_at = NOW()
+		WHERE id = $1 AND COALESCE((settings->>'coarse_location')::boolean, false)
+	`
+	tag, err := db.pool.Exec(ctx, query, userID)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
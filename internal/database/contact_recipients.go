@@ -0,0 +1,100 @@
+package database
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+)
+
+const contactRecipientColumns = `phone, max_per_window, sms_enabled, whatsapp_enabled,
+	ref_label, ref_lat, ref_lng, ref_address, created_at, updated_at`
+
+// Contact recipient operations
+
+func scanContactRecipient(row pgx.Row) (*models.ContactRecipient, error) {
+	var r models.ContactRecipient
+	var refLabel, refAddress *string
+	var refLat, refLng *float64
+	err := row.Scan(
+		&r.Phone, &r.MaxPerWindow, &r.SMSEnabled, &r.WhatsAppEnabled,
+		&refLabel, &refLat, &refLng, &refAddress, &r.CreatedAt, &r.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if refLabel != nil && refLat != nil && refLng != nil {
+		r.Reference = &models.ContactReference{Label: *refLabel, Lat: *refLat, Lng: *refLng}
+		if refAddress != nil {
+			r.Reference.Address = *refAddress
+		}
+	}
+	return &r, nil
+}
+
+// GetContactRecipient returns the recipient of a phone number, or nil if no
+// user has ever listed it
+func (db *PostgresDB) GetContactRecipient(ctx context.Context, phone string) (*models.ContactRecipient, error) {
+	query := `SELECT ` + contactRecipientColumns + ` FROM contact_recipients WHERE phone = $1`
+	r, err := scanContactRecipient(db.pool.QueryRow(ctx, query, phone))
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	return r, err
+}
+
+// UpdateContactRecipient saves the preferences a recipient set for
+// themselves, including their reference location or its absence, and returns
+// the row as stored
+func (db *PostgresDB) UpdateContactRecipient(ctx context.Context, r *models.ContactRecipient) (*models.ContactRecipient, error) {
+	var refLabel, refAddress *string
+	var refLat, refLng *float64
+	if ref := r.Reference; ref != nil {
+		refLabel, refLat, refLng = &ref.Label, &ref.Lat, &ref.Lng
+		if ref.Address != "" {
+			refAddress = &ref.Address
+		}
+	}
+	query := `
+		INSERT INTO contact_recipients (phone, max_per_window, sms_enabled, whatsapp_enabled, ref_label, ref_lat, ref_lng, ref_address)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT (phone) DO UPDATE
+		SET max_per_window = EXCLUDED.max_per_window,
+			sms_enabled = EXCLUDED.sms_enabled,
+			whatsapp_enabled = EXCLUDED.whatsapp_enabled,
+			ref_label = EXCLUDED.ref_label,
+			ref_lat = EXCLUDED.ref_lat,
+			ref_lng = EXCLUDED.ref_lng,
+			ref_address = EXCLUDED.ref_address
+		RETURNING ` + contactRecipientColumns
+	row := db.pool.QueryRow(ctx, query, r.Phone, r.MaxPerWindow, r.SMSEnabled, r.WhatsAppEnabled, refLabel, refLat, refLng, refAddress)
+	return scanContactRecipient(row)
+}
+
+// ListProtectedUsers returns the users who currently list the phone number
+// as a trusted contact, by name
+func (db *PostgresDB) ListProtectedUsers(ctx context.Context, phone string) ([]models.ProtectedUser, error) {
+	query := `
+		SELECT u.id, u.name, c.id::text
+		FROM contacts c
+		JOIN users u ON u.id = c.user_id
+		WHERE c.phone = $1 AND c.deleted_at IS NULL
+		ORDER BY u.name, u.id
+	`
+	rows, err := db.pool.Query(ctx, query, phone)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []models.ProtectedUser{}
+	for rows.Next() {
+		var u models.ProtectedUser
+		if err := rows.Scan(&u.UserID, &u.Name, &u.ContactID); err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
@@ -0,0 +1,334 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+const contactColumns = `id, name, phone, preferences, status, deleted_at`
+
+// contactQuerier is the pool, or a transaction
+type contactQuerier interface {
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+}
+
+func scanContact(row pgx.Row) (*models.Contact, error) {
+	var c models.Contact
+	var id uuid.UUID
+	if err := row.Scan(&id, &c.Name, &c.Phone, &c.Preferences, &c.Status, &c.DeletedAt); err != nil {
+		return nil, err
+	}
+	c.ID = id.String()
+	return &c, nil
+}
+
+// listContacts returns the user's contacts in the order they were added,
+// followed by the deleted ones when includeDeleted is set
+func listContacts(ctx context.Context, q contactQuerier, userID uuid.UUID, includeDeleted bool) (models.TrustedContacts, error) {
+	query := `
+		SELECT ` + contactColumns + `
+		FROM contacts
+		WHERE user_id = $1 AND ($2 OR deleted_at IS NULL)
+		ORDER BY deleted_at IS NOT NULL, position, created_at
+	`
+	rows, err := q.Query(ctx, query, userID, includeDeleted)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	contacts := models.TrustedContacts{}
+	for rows.Next() {
+		c, err := scanContact(rows)
+		if err != nil {
+			return nil, err
+		}
+		contacts = append(contacts, *c)
+	}
+	return contacts, rows.Err()
+}
+
+// Contact management operations
+
+// TrustedContacts returns the contacts alerts go to: the user's contacts
+// that aren't deleted
+func (db *PostgresDB) TrustedContacts(ctx context.Context, userID uuid.UUID) (models.TrustedContacts, error) {
+	return listContacts(ctx, db.pool, userID, false)
+}
+
+// ListContacts returns the user's contacts, with the deleted ones last when
+// includeDeleted is set
+func (db *PostgresDB) ListContacts(ctx context.Context, userID uuid.UUID, includeDeleted bool) (models.TrustedContacts, error) {
+	return listContacts(ctx, db.pool, userID, includeDeleted)
+}
+
+// ModifyContacts replaces the user's trusted contacts with what fn returns,
+// in one transaction. The user's row is locked while fn runs, so concurrent
+// changes to the same user's contacts apply one after another instead of
+// overwriting each other. Contacts fn leaves out are soft-deleted. An error
+// from fn rolls back and is returned as is.
+func (db *PostgresDB) ModifyContacts(ctx context.Context, userID uuid.UUID, fn func(models.TrustedContacts) (models.TrustedContacts, error)) error {
+	defer db.forgetUser(userID)
+
+	tx, err := db.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if err := lockUser(ctx, tx, userID); err != nil {
+		return err
+	}
+	contacts, err := listContacts(ctx, tx, userID, false)
+	if err != nil {
+		return err
+	}
+
+	next, err := fn(append(models.TrustedContacts{}, contacts...))
+	if err != nil {
+		return err
+	}
+	if err := saveContacts(ctx, tx, userID, contacts, next); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// lockUser locks the user's row for the rest of tx
+func lockUser(ctx context.Context, tx pgx.Tx, userID uuid.UUID) error {
+	var id uuid.UUID
+	err := tx.QueryRow(ctx, `SELECT id FROM users WHERE id = $1 FOR UPDATE`, userID).Scan(&id)
+	if err == pgx.ErrNoRows {
+		return ErrUserNotFound
+	}
+	return err
+}
+
+// saveContacts writes next over the user's current contacts: new and changed
+// ones are upserted in next's order, and current ones missing from next are
+// soft-deleted
+func saveContacts(ctx context.Context, tx pgx.Tx, userID uuid.UUID, current, next models.TrustedContacts) error {
+	position := make(map[string]int, len(current))
+	for i, c := range current {
+		position[c.ID] = i
+	}
+
+	upsert := `
+		INSERT INTO contacts (id, user_id, name, phone, status, preferences, position)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (id) DO UPDATE
+		SET name = EXCLUDED.name,
+			phone = EXCLUDED.phone,
+			preferences = EXCLUDED.preferences,
+			position = EXCLUDED.position,
+			-- A new number hasn't confirmed anything
+			status = CASE WHEN contacts.phone = EXCLUDED.phone THEN contacts.status ELSE 'invited' END
+		WHERE contacts.user_id = EXCLUDED.user_id
+	`
+	ids := make([]uuid.UUID, len(next))
+	for i, c := range next {
+		id, err := uuid.Parse(c.ID)
+		if err != nil {
+			return fmt.Errorf("invalid contact id %q: %w", c.ID, err)
+		}
+		ids[i] = id
+
+		if j, ok := position[c.ID]; ok && j == i && sameContact(current[j], c) {
+			continue
+		}
+		status := c.Status
+		if status == "" {
+			status = models.ContactStatusInvited
+		}
+		// Every number has a recipient row, shared by all the users it protects
+		if _, err := tx.Exec(ctx, `INSERT INTO contact_recipients (phone) VALUES ($1) ON CONFLICT (phone) DO NOTHING`, c.Phone); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(ctx, upsert, id, userID, c.Name, c.Phone, status, c.Preferences, i); err != nil {
+			return err
+		}
+	}
+
+	query := `
+		UPDATE contacts
+		SET deleted_at = NOW()
+		WHERE user_id = $1 AND deleted_at IS NULL AND NOT (id = ANY($2::uuid[]))
+	`
+	_, err := tx.Exec(ctx, query, userID, ids)
+	return err
+}
+
+func sameContact(a, b models.Contact) bool {
+	return a.Name == b.Name && a.Phone == b.Phone && reflect.DeepEqual(a.Preferences, b.Preferences)
+}
+
+// AddContact appends a contact unless the user already has limit of them
+func (db *PostgresDB) AddContact(ctx context.Context, userID uuid.UUID, newContact models.Contact, limit int) error {
+	return db.ModifyContacts(ctx, userID, func(contacts models.TrustedContacts) (models.TrustedContacts, error) {
+		if len(contacts) >= limit {
+			return nil, ErrContactLimit
+		}
+		return append(contacts, newContact), nil
+	})
+}
+
+// UpdateContact applies the updates to a contact and returns the contact as
+// it was before. A new phone number resets them to invited. It is refused
+// with ErrContactOnActiveAlert while an alert sent to them is unresolved, so
+// a number can't be swapped out from under an emergency.
+func (db *PostgresDB) UpdateContact(ctx context.Context, userID uuid.UUID, contactID string, updates map[string]string, prefs *models.NotificationPreferences) (*models.Contact, error) {
+	var previous models.Contact
+	err := db.ModifyContacts(ctx, userID, func(contacts models.TrustedContacts) (models.TrustedContacts, error) {
+		for i, contact := range contacts {
+			if contact.ID != contactID {
+				continue
+			}
+			previous = contact
+			if name, ok := updates["name"]; ok && name != "" {
+				contacts[i].Name = name
+			}
+			if phone, ok := updates["phone"]; ok && phone != "" && phone != contact.Phone {
+				// Checked while the user is locked, so the contact can't
+				// change twice around the check
+				onAlert, err := db.contactOnActiveAlert(ctx, userID, contactID)
+				if err != nil {
+					return nil, err
+				}
+				if onAlert {
+					return nil, ErrContactOnActiveAlert
+				}
+				contacts[i].Phone = phone
+			}
+			if prefs != nil {
+				contacts[i].Preferences = prefs
+			}
+			return contacts, nil
+		}
+		return nil, ErrContactNotFound
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &previous, nil
+}
+
+// contactOnActiveAlert reports whether the contact was sent one of the
+// user's alerts that is still unresolved
+func (db *PostgresDB) contactOnActiveAlert(ctx context.Context, userID uuid.UUID, contactID string) (bool, error) {
+	query := `
+		SELECT EXISTS (
+			SELECT 1
+			FROM alert_recipients r
+			JOIN alerts a ON a.id = r.alert_id
+			WHERE r.contact_id = $2 AND a.user_id = $1 AND a.resolved_at IS NULL
+		)
+	`
+	var onAlert bool
+	err := db.pool.QueryRow(ctx, query, userID, contactID).Scan(&onAlert)
+	return onAlert, err
+}
+
+// DeleteContact soft-deletes a contact: they get no more alerts, but can be
+// restored with RestoreContact
+func (db *PostgresDB) DeleteContact(ctx context.Context, userID uuid.UUID, contactID string) error {
+	return db.ModifyContacts(ctx, userID, func(contacts models.TrustedContacts) (models.TrustedContacts, error) {
+		remaining := make(models.TrustedContacts, 0, len(contacts))
+		for _, contact := range contacts {
+			if contact.ID != contactID {
+				remaining = append(remaining, contact)
+			}
+		}
+		if len(remaining) == len(contacts) {
+			return nil, ErrContactNotFound
+		}
+		return remaining, nil
+	})
+}
+
+// RestoreContact brings back a deleted contact, last in the user's list,
+// unless the user already has limit contacts. They have to confirm their
+// invitation again, so they return as invited. Returns ErrContactNotFound
+// for an unknown contact and ErrContactNotDeleted for one that isn't deleted.
+func (db *PostgresDB) RestoreContact(ctx context.Context, userID uuid.UUID, contactID string, limit int) (*models.Contact, error) {
+	defer db.forgetUser(userID)
+
+	id, err := uuid.Parse(contactID)
+	if err != nil {
+		return nil, ErrContactNotFound
+	}
+
+	tx, err := db.pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	if err := lockUser(ctx, tx, userID); err != nil {
+		return nil, err
+	}
+
+	query := `SELECT ` + contactColumns + ` FROM contacts WHERE id = $1 AND user_id = $2`
+	contact, err := scanContact(tx.QueryRow(ctx, query, id, userID))
+	if err == pgx.ErrNoRows {
+		return nil, ErrContactNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if contact.DeletedAt == nil {
+		return nil, ErrContactNotDeleted
+	}
+
+	var count, position int
+	query = `
+		SELECT COUNT(*), COALESCE(MAX(position) + 1, 0)
+		FROM contacts
+		WHERE user_id = $1 AND deleted_at IS NULL
+	`
+	if err := tx.QueryRow(ctx, query, userID).Scan(&count, &position); err != nil {
+		return nil, err
+	}
+	if count >= limit {
+		return nil, ErrContactLimit
+	}
+
+	query = `
+		UPDATE contacts
+		SET deleted_at = NULL, status = 'invited', position = $3
+		WHERE id = $1 AND user_id = $2
+		RETURNING ` + contactColumns
+	restored, err := scanContact(tx.QueryRow(ctx, query, id, userID, position))
+	if err != nil {
+		return nil, err
+	}
+	return restored, tx.Commit(ctx)
+}
+
+// ConfirmContact marks a contact confirmed, as long as they are still on the
+// user's list with the phone number the invitation went to
+func (db *PostgresDB) ConfirmContact(ctx context.Context, userID uuid.UUID, contactID, phone string) error {
+	defer db.forgetUser(userID)
+
+	id, err := uuid.Parse(contactID)
+	if err != nil {
+		return ErrContactNotFound
+	}
+	query := `
+		UPDATE contacts
+		SET status = 'confirmed'
+		WHERE id = $1 AND user_id = $2 AND phone = $3 AND deleted_at IS NULL
+	`
+	tag, err := db.pool.Exec(ctx, query, id, userID, phone)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrContactNotFound
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package database
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// ErrPhoneAlreadyRegistered is returned by CreateUser when the phone number is taken
+var ErrPhoneAlreadyRegistered = errors.New("phone number already registered")
+
+// ErrLinkExists is returned by CreateAccountLink when the guardian already has a
+// pending or active link to the ward
+var ErrLinkExists = errors.New("account link already exists")
+
+// ErrUserNotFound is returned by ModifyContacts and the public status token
+// methods when the user doesn't exist
+var ErrUserNotFound = errors.New("user not found")
+
+// ErrContactNotFound is returned when updating or deleting an unknown contact
+var ErrContactNotFound = errors.New("contact not found")
+
+// ErrContactLimit is returned by AddContact and RestoreContact when the user has the maximum number of contacts
+var ErrContactLimit = errors.New("contact limit reached")
+
+// ErrContactNotDeleted is returned by RestoreContact for a contact that isn't deleted
+var ErrContactNotDeleted = errors.New("contact is not deleted")
+
+// ErrContactOnActiveAlert is returned by UpdateContact when changing the
+// phone of a contact who was sent an alert that isn't resolved yet
+var ErrContactOnActiveAlert = errors.New("contact is a recipient of an unresolved alert")
+
+// ErrInvitationNotFound is returned by AcceptOrgInvitation when the invitation
+// doesn't exist, has expired, was accepted or is for another phone number
+var ErrInvitationNotFound = errors.New("invitation not found")
+
+// ErrAlreadyMember is returned by AcceptOrgInvitation when the user already
+// belongs to an organization
+var ErrAlreadyMember = errors.New("user already belongs to an organization")
+
+// pgUniqueViolation is the SQLSTATE for unique_violation
+const pgUniqueViolation = "23505"
+
+// isUniqueViolation reports whether err is a unique-constraint failure on a
+// constraint or index whose name contains the given fragment
+func isUniqueViolation(err error, constraintFragment string) bool {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) || pgErr.Code != pgUniqueViolation {
+		return false
+	}
+	return strings.Contains(strings.ToLower(pgErr.ConstraintName), constraintFragment)
+}
@@ -0,0 +1,53 @@
+package database
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+)
+
+// Feature flag operations
+
+// GetUserFlagOverrides returns the user's feature flag overrides by flag name
+func (db *PostgresDB) GetUserFlagOverrides(ctx context.Context, userID uuid.UUID) (map[string]bool, error) {
+	rows, err := db.pool.Query(ctx, `SELECT flag, enabled FROM user_feature_flags WHERE user_id = $1`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	overrides := make(map[string]bool)
+	for rows.Next() {
+		var flag string
+		var enabled bool
+		if err := rows.Scan(&flag, &enabled); err != nil {
+			return nil, err
+		}
+		overrides[flag] = enabled
+	}
+	return overrides, rows.Err()
+}
+
+// SetUserFlagOverride forces a flag on or off for the user, or clears their
+// override when enabled is nil. It reports whether anything changed.
+func (db *PostgresDB) SetUserFlagOverride(ctx context.Context, userID uuid.UUID, flag string, enabled *bool, setBy string) (bool, error) {
+	if enabled == nil {
+		tag, err := db.pool.Exec(ctx, `DELETE FROM user_feature_flags WHERE user_id = $1 AND flag = $2`, userID, flag)
+		if err != nil {
+			return false, err
+		}
+		return tag.RowsAffected() > 0, nil
+	}
+
+	tag, err := db.pool.Exec(ctx, `
+		INSERT INTO user_feature_flags (user_id, flag, enabled, set_by, updated_at)
+		VALUES ($1, $2, $3, $4, NOW())
+		ON CONFLICT (user_id, flag) DO UPDATE
+		SET enabled = EXCLUDED.enabled, set_by = EXCLUDED.set_by, updated_at = NOW()
+		WHERE user_feature_flags.enabled <> EXCLUDED.enabled
+	`, userID, flag, *enabled, setBy)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
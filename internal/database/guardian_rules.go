@@ -0,0 +1,139 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// Guardian rule operations
+
+const guardianRuleColumns = `
+	r.link_id, r.max_silent_hours, r.max_stationary_hours, r.daily_digest, r.channel,
+	r.silent_episode, r.silent_notified_at, r.stationary_episode, r.stationary_notified_at,
+	COALESCE(r.digest_sent_on::text, ''), r.created_at, r.updated_at
+`
+
+func guardianRuleFields(r *models.GuardianRules) []interface{} {
+	return []interface{}{
+		&r.LinkID, &r.MaxSilentHours, &r.MaxStationaryHours, &r.DailyDigest, &r.Channel,
+		&r.SilentEpisode, &r.SilentNotifiedAt, &r.StationaryEpisode, &r.StationaryNotifiedAt,
+		&r.DigestSentOn, &r.CreatedAt, &r.UpdatedAt,
+	}
+}
+
+// GetGuardianRules returns the rules on a link, or nil if it has none
+func (db *PostgresDB) GetGuardianRules(ctx context.Context, linkID uuid.UUID) (*models.GuardianRules, error) {
+	var r models.GuardianRules
+	err := db.pool.QueryRow(ctx, `SELECT `+guardianRuleColumns+` FROM guardian_rules r WHERE r.link_id = $1`, linkID).
+		Scan(guardianRuleFields(&r)...)
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// UpsertGuardianRules creates or replaces the rules on a link. What each
+// rule last notified about is kept, so saving the rules again doesn't
+// repeat a notice.
+func (db *PostgresDB) UpsertGuardianRules(ctx context.Context, r *models.GuardianRules) error {
+	query := `
+		INSERT INTO guardian_rules AS r (link_id, max_silent_hours, max_stationary_hours, daily_digest, channel, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $6)
+		ON CONFLICT (link_id) DO UPDATE
+		SET max_silent_hours = EXCLUDED.max_silent_hours, max_stationary_hours = EXCLUDED.max_stationary_hours,
+		    daily_digest = EXCLUDED.daily_digest, channel = EXCLUDED.channel, updated_at = EXCLUDED.updated_at
+		RETURNING ` + guardianRuleColumns
+	return db.pool.QueryRow(ctx, query,
+		r.LinkID, r.MaxSilentHours, r.MaxStationaryHours, r.DailyDigest, r.Channel, r.UpdatedAt,
+	).Scan(guardianRuleFields(r)...)
+}
+
+// DeleteGuardianRules removes the rules on a link and reports whether it had any
+func (db *PostgresDB) DeleteGuardianRules(ctx context.Context, linkID uuid.UUID) (bool, error) {
+	tag, err := db.pool.Exec(ctx, `DELETE FROM guardian_rules WHERE link_id = $1`, linkID)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// GetGuardianRuleTargets returns up to limit rule sets of active links with
+// a rule on, in link ID order after the given one, with the guardian's phone
+// and the ward's name and settings
+func (db *PostgresDB) GetGuardianRuleTargets(ctx context.Context, after uuid.UUID, limit int) ([]models.GuardianRuleTarget, error) {
+	query := `
+		SELECT ` + guardianRuleColumns + `, g.id, g.phone, w.id, w.name, w.settings
+		FROM guardian_rules r
+		JOIN account_links l ON l.id = r.link_id
+		JOIN users g ON g.id = l.guardian_user_id
+		JOIN users w ON w.id = l.ward_user_id
+		WHERE l.status = $1 AND r.link_id > $2
+		  AND (r.max_silent_hours IS NOT NULL OR r.max_stationary_hours IS NOT NULL OR r.daily_digest)
+		ORDER BY r.link_id
+		LIMIT $3
+	`
+	rows, err := db.pool.Query(ctx, query, models.LinkStatusActive, after, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var targets []models.GuardianRuleTarget
+	for rows.Next() {
+		var t models.GuardianRuleTarget
+		fields := append(guardianRuleFields(&t.GuardianRules), &t.GuardianUserID, &t.GuardianPhone, &t.WardUserID, &t.WardName, &t.WardSettings)
+		if err := rows.Scan(fields...); err != nil {
+			return nil, err
+		}
+		targets = append(targets, t)
+	}
+	return targets, rows.Err()
+}
+
+// guardianEpisodeColumns are the episode and notified columns of the rules
+// that notify about episodes
+var guardianEpisodeColumns = map[string][2]string{
+	models.GuardianRuleSilent:     {"silent_episode", "silent_notified_at"},
+	models.GuardianRuleStationary: {"stationary_episode", "stationary_notified_at"},
+}
+
+// ClaimGuardianNotice records that a rule notifies about an episode and
+// reports whether it should: not if it already notified about this episode,
+// or notified about any less than cooldown before now. Two instances can't
+// both claim a notice.
+func (db *PostgresDB) ClaimGuardianNotice(ctx context.Context, linkID uuid.UUID, rule string, episode, now time.Time, cooldown time.Duration) (bool, error) {
+	columns, ok := guardianEpisodeColumns[rule]
+	if !ok {
+		return false, fmt.Errorf("guardian rule %q has no episodes", rule)
+	}
+	query := fmt.Sprintf(`
+		UPDATE guardian_rules SET %[1]s = $2, %[2]s = $3
+		WHERE link_id = $1 AND %[1]s IS DISTINCT FROM $2 AND (%[2]s IS NULL OR %[2]s <= $4)
+	`, columns[0], columns[1])
+	tag, err := db.pool.Exec(ctx, query, linkID, episode, now, now.Add(-cooldown))
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+// ClaimGuardianDigest records that a link's digest for a day is sent and
+// reports whether it should be: not if it already was
+func (db *PostgresDB) ClaimGuardianDigest(ctx context.Context, linkID uuid.UUID, day string) (bool, error) {
+	tag, err := db.pool.Exec(ctx, `
+		UPDATE guardian_rules SET digest_sent_on = $2::date
+		WHERE link_id = $1 AND digest_sent_on IS DISTINCT FROM $2::date
+	`, linkID, day)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
@@ -0,0 +1,70 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+)
+
+// Integration tests run against the Postgres of TEST_DATABASE_URL, with
+// migrations applied, and the Redis of TEST_REDIS_URL. They are skipped
+// when those aren't set.
+
+func testPostgres(t *testing.T) *PostgresDB {
+	t.Helper()
+	url := os.Getenv("TEST_DATABASE_URL")
+	if url == "" {
+		t.Skip("TEST_DATABASE_URL not set")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	db, err := NewPostgresDB(ctx, url, 0)
+	if err != nil {
+		t.Fatalf("NewPostgresDB: %v", err)
+	}
+	t.Cleanup(db.Close)
+	return db
+}
+
+// testRedis connects under a namespace of its own, so tests neither see
+// nor disturb each other's keys
+func testRedis(t *testing.T) *RedisDB {
+	t.Helper()
+	url := os.Getenv("TEST_REDIS_URL")
+	if url == "" {
+		t.Skip("TEST_REDIS_URL not set")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	namespace := "test-" + strings.ReplaceAll(uuid.NewString(), "-", "")[:12]
+	r, err := NewRedisDB(ctx, url, namespace)
+	if err != nil {
+		t.Fatalf("NewRedisDB: %v", err)
+	}
+	t.Cleanup(func() { r.Close() })
+	return r
+}
+
+// testPhone returns a Nigerian mobile number no other test run uses
+func testPhone() string {
+	return fmt.Sprintf("+234809%07d", rand.Intn(10_000_000))
+}
+
+// createTestUser stores a user with a fresh phone number
+func createTestUser(t *testing.T, db *PostgresDB, name string) *models.User {
+	t.Helper()
+	now := time.Now()
+	user := &models.User{ID: uuid.New(), Phone: testPhone(), Name: name, CreatedAt: now, UpdatedAt: now}
+	if err := db.CreateUser(context.Background(), user); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	return user
+}
@@ -0,0 +1,103 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+)
+
+// Home screen operations
+
+// GetHomeSnapshot reads the user's latest live heartbeat, newest unresolved
+// alert, open pause and watch, and pending welfare check at now in a single
+// round trip. Each lateral join yields at most one row; its key column is
+// NULL when it yields none, and the part is left nil.
+func (db *PostgresDB) GetHomeSnapshot(ctx context.Context, userID uuid.UUID, now time.Time) (*models.HomeSnapshot, error) {
+	query := `
+		SELECT h.timestamp, COALESCE(h.source, ''), COALESCE(h.lat, 0), COALESCE(h.lng, 0),
+		       COALESCE(h.accuracy_m, 0), COALESCE(h.landmark, ''),
+		       a.id, COALESCE(a.state, ''), COALESCE(a.score, 0), COALESCE(a.reason, ''), a.reason_codes,
+		       COALESCE(a.duress, false), COALESCE(a.created_at, 'epoch'), COALESCE(a.undeliverable, ''),
+		       p.id, COALESCE(p.reason, ''), COALESCE(p.paused_at, 'epoch'), COALESCE(p.paused_until, 'epoch'),
+		       w.id, COALESCE(w.note, ''), COALESCE(w.started_at, 'epoch'), COALESCE(w.ends_at, 'epoch'),
+		       wc.id, COALESCE(wc.requester_type, ''), COALESCE(wc.requester_name, ''),
+		       COALESCE(wc.requested_at, 'epoch'), COALESCE(wc.respond_by, 'epoch')
+		FROM (SELECT $1::uuid AS user_id) u
+		LEFT JOIN LATERAL (
+			SELECT timestamp, source, lat, lng, accuracy_m, landmark
+			FROM heartbeats
+			WHERE user_id = u.user_id AND NOT backfill AND duplicate_of IS NULL
+			ORDER BY timestamp DESC
+			LIMIT 1
+		) h ON true
+		LEFT JOIN LATERAL (
+			SELECT id, state, score, reason, reason_codes, duress, created_at, undeliverable
+			FROM alerts
+			WHERE user_id = u.user_id AND resolved_at IS NULL
+			ORDER BY created_at DESC
+			LIMIT 1
+		) a ON true
+		LEFT JOIN LATERAL (
+			SELECT id, reason, paused_at, paused_until
+			FROM protection_pauses
+			WHERE user_id = u.user_id AND resumed_at IS NULL AND paused_until > $2
+		) p ON true
+		LEFT JOIN LATERAL (
+			SELECT id, note, started_at, ends_at
+			FROM watch_sessions
+			WHERE user_id = u.user_id AND ended_at IS NULL AND ends_at > $2
+		) w ON true
+		LEFT JOIN LATERAL (
+			SELECT id, requester_type, requester_name, requested_at, respond_by
+			FROM welfare_checks
+			WHERE user_id = u.user_id AND status = 'pending'
+		) wc ON true
+	`
+	var (
+		hb     = models.Heartbeat{UserID: userID}
+		alert  = models.Alert{UserID: userID}
+		pause  = models.ProtectionPause{UserID: userID}
+		watch  = models.WatchSession{UserID: userID}
+		check  = models.WelfareCheck{UserID: userID, Status: models.WelfareCheckPending}
+		hbAt   *time.Time
+		keys   [4]*uuid.UUID // alert, pause, watch, welfare check
+		reason models.Reasons
+	)
+	err := db.pool.QueryRow(ctx, query, userID, now).Scan(
+		&hbAt, &hb.Source, &hb.Lat, &hb.Lng, &hb.AccuracyM, &hb.Landmark,
+		&keys[0], &alert.State, &alert.Score, &alert.Reason, &reason,
+		&alert.Duress, &alert.CreatedAt, &alert.Undeliverable,
+		&keys[1], &pause.Reason, &pause.PausedAt, &pause.PausedUntil,
+		&keys[2], &watch.Note, &watch.StartedAt, &watch.EndsAt,
+		&keys[3], &check.RequesterType, &check.RequesterName, &check.RequestedAt, &check.RespondBy,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot := &models.HomeSnapshot{}
+	if hbAt != nil {
+		hb.Timestamp = *hbAt
+		snapshot.Heartbeat = &hb
+	}
+	if keys[0] != nil {
+		alert.ID, alert.Reasons = *keys[0], reason
+		snapshot.Alert = &alert
+	}
+	if keys[1] != nil {
+		pause.ID = *keys[1]
+		snapshot.Pause = &pause
+	}
+	if keys[2] != nil {
+		watch.ID = *keys[2]
+		snapshot.Watch = &watch
+	}
+	if keys[3] != nil {
+		check.ID = *keys[3]
+		snapshot.WelfareCheck = &check
+	}
+	return snapshot, nil
+}
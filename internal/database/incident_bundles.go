@@ -0,0 +1,142 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+)
+
+// Incident bundle operations
+
+const incidentBundleColumns = `id, alert_id, user_id, requested_by, status, COALESCE(object_key, ''),
+	COALESCE(size_bytes, 0), COALESCE(manifest_signature, ''), COALESCE(error, ''),
+	created_at, completed_at, expires_at`
+
+func scanIncidentBundle(row pgx.Row) (*models.IncidentBundle, error) {
+	var b models.IncidentBundle
+	err := row.Scan(
+		&b.ID, &b.AlertID, &b.UserID, &b.RequestedBy, &b.Status, &b.ObjectKey,
+		&b.SizeBytes, &b.Signature, &b.Error,
+		&b.CreatedAt, &b.CompletedAt, &b.ExpiresAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+// CreateIncidentBundle stores a bundle request, pending until a builder claims it
+func (db *PostgresDB) CreateIncidentBundle(ctx context.Context, b *models.IncidentBundle) error {
+	query := `
+		INSERT INTO incident_bundles (id, alert_id, user_id, requested_by, status, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`
+	_, err := db.pool.Exec(ctx, query, b.ID, b.AlertID, b.UserID, b.RequestedBy, b.Status, b.CreatedAt)
+	return err
+}
+
+// GetIncidentBundle returns the bundle, or nil if there is none with this ID
+func (db *PostgresDB) GetIncidentBundle(ctx context.Context, id uuid.UUID) (*models.IncidentBundle, error) {
+	query := `SELECT ` + incidentBundleColumns + ` FROM incident_bundles WHERE id = $1`
+	b, err := scanIncidentBundle(db.pool.QueryRow(ctx, query, id))
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	return b, err
+}
+
+// GetOpenIncidentBundle returns the alert's bundle still waiting to be built
+// or being built, or nil if there is none
+func (db *PostgresDB) GetOpenIncidentBundle(ctx context.Context, alertID uuid.UUID) (*models.IncidentBundle, error) {
+	query := `
+		SELECT ` + incidentBundleColumns + `
+		FROM incident_bundles
+		WHERE alert_id = $1 AND status IN ('pending', 'building')
+		ORDER BY created_at DESC
+		LIMIT 1
+	`
+	b, err := scanIncidentBundle(db.pool.QueryRow(ctx, query, alertID))
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	return b, err
+}
+
+// ClaimIncidentBundle marks the oldest pending bundle, or one whose builder
+// claimed it before staleBefore and never finished, as being built and
+// returns it. It returns nil when there is nothing to build. Concurrent
+// callers never take the same bundle.
+func (db *PostgresDB) ClaimIncidentBundle(ctx context.Context, now, staleBefore time.Time) (*models.IncidentBundle, error) {
+	query := `
+		UPDATE incident_bundles
+		SET status = 'building', claimed_at = $1
+		WHERE id = (
+			SELECT id FROM incident_bundles
+			WHERE status = 'pending' OR (status = 'building' AND claimed_at < $2)
+			ORDER BY created_at
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING ` + incidentBundleColumns
+	b, err := scanIncidentBundle(db.pool.QueryRow(ctx, query, now, staleBefore))
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	return b, err
+}
+
+// CompleteIncidentBundle records a built bundle's object and manifest signature
+func (db *PostgresDB) CompleteIncidentBundle(ctx context.Context, b *models.IncidentBundle) error {
+	query := `
+		UPDATE incident_bundles
+		SET status = 'ready', object_key = $2, size_bytes = $3, manifest_signature = $4,
+			completed_at = $5, expires_at = $6
+		WHERE id = $1
+	`
+	_, err := db.pool.Exec(ctx, query, b.ID, b.ObjectKey, b.SizeBytes, b.Signature, b.CompletedAt, b.ExpiresAt)
+	return err
+}
+
+// FailIncidentBundle records why a bundle could not be built
+func (db *PostgresDB) FailIncidentBundle(ctx context.Context, id uuid.UUID, reason string, at time.Time) error {
+	query := `UPDATE incident_bundles SET status = 'failed', error = $2, completed_at = $3 WHERE id = $1`
+	_, err := db.pool.Exec(ctx, query, id, reason, at)
+	return err
+}
+
+// GetExpiredIncidentBundles returns at most limit ready bundles that
+// expired before the given time, oldest first
+func (db *PostgresDB) GetExpiredIncidentBundles(ctx context.Context, before time.Time, limit int) ([]models.IncidentBundle, error) {
+	query := `
+		SELECT ` + incidentBundleColumns + `
+		FROM incident_bundles
+		WHERE status = 'ready' AND expires_at < $1
+		ORDER BY expires_at
+		LIMIT $2
+	`
+	rows, err := db.pool.Query(ctx, query, before, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var bundles []models.IncidentBundle
+	for rows.Next() {
+		b, err := scanIncidentBundle(rows)
+		if err != nil {
+			return nil, err
+		}
+		bundles = append(bundles, *b)
+	}
+	return bundles, rows.Err()
+}
+
+// ExpireIncidentBundle forgets a bundle's object once it is deleted
+func (db *PostgresDB) ExpireIncidentBundle(ctx context.Context, id uuid.UUID) error {
+	_, err := db.pool.Exec(ctx, `UPDATE incident_bundles SET status = 'expired', object_key = NULL WHERE id = $1`, id)
+	return err
+}
@@ -0,0 +1,99 @@
+package database
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+)
+
+func recordLastGasp(t *testing.T, db *PostgresDB, userID uuid.UUID, at time.Time, lat float64, rearm time.Duration) *models.LastGasp {
+	t.Helper()
+	lg := &models.LastGasp{
+		ID: uuid.New(), UserID: userID, Lat: lat, Lng: 3.3792, AccuracyM: 20,
+		CreatedAt: at, LastAt: at, ExpiryTs: at.Add(time.Hour),
+	}
+	if err := db.RecordLastGasp(context.Background(), lg, rearm); err != nil {
+		t.Fatalf("RecordLastGasp: %v", err)
+	}
+	return lg
+}
+
+func countLastGasps(t *testing.T, db *PostgresDB, userID uuid.UUID) int {
+	t.Helper()
+	var n int
+	if err := db.pool.QueryRow(context.Background(), `SELECT COUNT(*) FROM last_gasps WHERE user_id = $1`, userID).Scan(&n); err != nil {
+		t.Fatalf("count last_gasps: %v", err)
+	}
+	return n
+}
+
+// A phone flapping in and out of coverage keeps one LastGasp record: each
+// heartbeat in between supersedes it, and each LastGasp within the re-arm
+// window re-opens it at the new position
+func TestLastGaspFlapping(t *testing.T) {
+	db := testPostgres(t)
+	ctx := context.Background()
+	user := createTestUser(t, db, "Ada")
+	rearm := 15 * time.Minute
+	start := time.Now().Add(-10 * time.Minute).Truncate(time.Millisecond)
+
+	first := recordLastGasp(t, db, user.ID, start, 6.50, rearm)
+	for i := 1; i <= 4; i++ {
+		at := start.Add(time.Duration(i) * 2 * time.Minute)
+		superseded, err := db.SupersedeLastGasp(ctx, user.ID, at.Add(-time.Minute))
+		if err != nil || !superseded {
+			t.Fatalf("SupersedeLastGasp %d = %t, %v; want the open one superseded", i, superseded, err)
+		}
+		if active, err := db.GetActiveLastGasp(ctx, user.ID); err != nil || active != nil {
+			t.Fatalf("active after heartbeat %d = %+v, %v; want none", i, active, err)
+		}
+
+		lg := recordLastGasp(t, db, user.ID, at, 6.50+float64(i)/100, rearm)
+		if lg.ID != first.ID || lg.Repeats != i {
+			t.Fatalf("LastGasp %d = %s with %d repeats, want %s re-armed %d times", i, lg.ID, lg.Repeats, first.ID, i)
+		}
+		active, err := db.GetActiveLastGasp(ctx, user.ID)
+		if err != nil || active == nil || active.ID != first.ID {
+			t.Fatalf("active after LastGasp %d = %+v, %v", i, active, err)
+		}
+		if active.SupersededAt != nil || !active.LastAt.Equal(at) || active.Lat != 6.50+float64(i)/100 {
+			t.Errorf("re-armed LastGasp = superseded %v, last_at %s, lat %f", active.SupersededAt, active.LastAt, active.Lat)
+		}
+		if !active.CreatedAt.Equal(start) {
+			t.Errorf("created_at moved to %s", active.CreatedAt)
+		}
+	}
+	if n := countLastGasps(t, db, user.ID); n != 1 {
+		t.Errorf("last_gasps rows = %d, want 1", n)
+	}
+
+	// A heartbeat older than the latest LastGasp doesn't end its wait
+	if superseded, err := db.SupersedeLastGasp(ctx, user.ID, start.Add(7*time.Minute)); err != nil || superseded {
+		t.Errorf("SupersedeLastGasp before last_at = %t, %v; want nothing superseded", superseded, err)
+	}
+}
+
+// A LastGasp past the re-arm window of the previous one opens a new record
+func TestLastGaspRearmWindow(t *testing.T) {
+	db := testPostgres(t)
+	ctx := context.Background()
+	user := createTestUser(t, db, "Ada")
+	start := time.Now().Add(-30 * time.Minute).Truncate(time.Millisecond)
+
+	first := recordLastGasp(t, db, user.ID, start, 6.5, 10*time.Minute)
+	second := recordLastGasp(t, db, user.ID, start.Add(20*time.Minute), 6.6, 10*time.Minute)
+	if second.ID == first.ID || second.Repeats != 0 {
+		t.Errorf("LastGasp after the window re-armed %s (%d repeats)", second.ID, second.Repeats)
+	}
+	if n := countLastGasps(t, db, user.ID); n != 2 {
+		t.Errorf("last_gasps rows = %d, want 2", n)
+	}
+	active, err := db.GetActiveLastGasp(ctx, user.ID)
+	if err != nil || active == nil || active.ID != second.ID {
+		t.Errorf("active = %+v, %v; want the newer one", active, err)
+	}
+}
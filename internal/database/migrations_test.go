@@ -0,0 +1,215 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const migrationsDir = "../../cmd/migrate/migrations"
+
+// testMigrations applies the repo's migrations, one version at a time, to a
+// schema of its own in the TEST_DATABASE_URL database, dropped afterwards
+type testMigrations struct {
+	t    *testing.T
+	pool *pgxpool.Pool
+}
+
+func newTestMigrations(t *testing.T) *testMigrations {
+	t.Helper()
+	url := os.Getenv("TEST_DATABASE_URL")
+	if url == "" {
+		t.Skip("TEST_DATABASE_URL not set")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	schema := "migration_test_" + strings.ReplaceAll(uuid.NewString(), "-", "")[:12]
+	config, err := pgxpool.ParseConfig(url)
+	if err != nil {
+		t.Fatalf("ParseConfig: %v", err)
+	}
+	config.ConnConfig.RuntimeParams["search_path"] = schema + ",public"
+	pool, err := pgxpool.NewWithConfig(ctx, config)
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	if _, err := pool.Exec(ctx, "CREATE SCHEMA "+schema); err != nil {
+		pool.Close()
+		t.Fatalf("create schema: %v", err)
+	}
+	t.Cleanup(func() {
+		pool.Exec(context.Background(), "DROP SCHEMA "+schema+" CASCADE")
+		pool.Close()
+	})
+	return &testMigrations{t: t, pool: pool}
+}
+
+// up applies every up migration from version from through version through
+func (m *testMigrations) up(from, through int) {
+	m.t.Helper()
+	for _, file := range migrationFiles(m.t, "up") {
+		if v := migrationVersion(m.t, file); v >= from && v <= through {
+			m.apply(file)
+		}
+	}
+}
+
+// down rolls back one version
+func (m *testMigrations) down(version int) {
+	m.t.Helper()
+	for _, file := range migrationFiles(m.t, "down") {
+		if migrationVersion(m.t, file) == version {
+			m.apply(file)
+			return
+		}
+	}
+	m.t.Fatalf("no down migration %d", version)
+}
+
+func (m *testMigrations) apply(file string) {
+	m.t.Helper()
+	sql, err := os.ReadFile(file)
+	if err != nil {
+		m.t.Fatalf("read %s: %v", file, err)
+	}
+	if _, err := m.pool.Exec(context.Background(), string(sql), pgx.QueryExecModeSimpleProtocol); err != nil {
+		m.t.Fatalf("apply %s: %v", filepath.Base(file), err)
+	}
+}
+
+func (m *testMigrations) exec(sql string, args ...interface{}) {
+	m.t.Helper()
+	if _, err := m.pool.Exec(context.Background(), sql, args...); err != nil {
+		m.t.Fatalf("%s: %v", sql, err)
+	}
+}
+
+func migrationFiles(t *testing.T, direction string) []string {
+	t.Helper()
+	files, err := filepath.Glob(filepath.Join(migrationsDir, "*."+direction+".sql"))
+	if err != nil || len(files) == 0 {
+		t.Fatalf("no %s migrations in %s: %v", direction, migrationsDir, err)
+	}
+	sort.Strings(files)
+	return files
+}
+
+func migrationVersion(t *testing.T, file string) int {
+	t.Helper()
+	v, err := strconv.Atoi(strings.SplitN(filepath.Base(file), "_", 2)[0])
+	if err != nil {
+		t.Fatalf("migration %s has no version: %v", file, err)
+	}
+	return v
+}
+
+func TestMigrationsApplyAndRollBack(t *testing.T) {
+	m := newTestMigrations(t)
+	files := migrationFiles(t, "up")
+	latest := migrationVersion(t, files[len(files)-1])
+
+	m.up(1, latest)
+	for v := latest; v >= 1; v-- {
+		m.down(v)
+	}
+	m.up(1, latest)
+}
+
+// Contacts are moved out of users.trusted_contacts by migration 36, and put
+// back by its rollback
+func TestMigrationCreateContacts(t *testing.T) {
+	const version = 36
+	m := newTestMigrations(t)
+	m.up(1, version-1)
+
+	keptID := uuid.New()
+	withContacts, empty, notArray := uuid.New(), uuid.New(), uuid.New()
+	fixture := fmt.Sprintf(`[
+		{"id": %q, "name": "Mum", "phone": "+2348031234567", "preferences": {"sms": true}},
+		{"id": "legacy-1", "name": "Tunde", "phone": "+2348059876543"},
+		{"name": "No ID", "phone": "+2348020000000", "preferences": null}
+	]`, keptID)
+	m.exec(`INSERT INTO users (id, phone, name, trusted_contacts) VALUES ($1, '+2348000000001', 'A', $2::jsonb)`, withContacts, fixture)
+	m.exec(`INSERT INTO users (id, phone, name, trusted_contacts) VALUES ($1, '+2348000000002', 'B', '[]'::jsonb)`, empty)
+	m.exec(`INSERT INTO users (id, phone, name, trusted_contacts) VALUES ($1, '+2348000000003', 'C', '{}'::jsonb)`, notArray)
+
+	m.up(version, version)
+
+	type row struct {
+		id          uuid.UUID
+		name, phone string
+		status      string
+		hasPrefs    bool
+		position    int
+	}
+	rows, err := m.pool.Query(context.Background(),
+		`SELECT id, user_id, name, phone, status, preferences IS NOT NULL, position FROM contacts ORDER BY user_id, position`)
+	if err != nil {
+		t.Fatalf("query contacts: %v", err)
+	}
+	byUser := map[uuid.UUID][]row{}
+	for rows.Next() {
+		var r row
+		var userID uuid.UUID
+		if err := rows.Scan(&r.id, &userID, &r.name, &r.phone, &r.status, &r.hasPrefs, &r.position); err != nil {
+			t.Fatalf("scan: %v", err)
+		}
+		byUser[userID] = append(byUser[userID], r)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatalf("rows: %v", err)
+	}
+
+	got := byUser[withContacts]
+	if len(got) != 3 {
+		t.Fatalf("user with contacts has %d rows, want 3", len(got))
+	}
+	if got[0].id != keptID || got[0].name != "Mum" || !got[0].hasPrefs {
+		t.Errorf("first contact = %+v, want id %s kept with preferences", got[0], keptID)
+	}
+	if got[1].id == uuid.Nil || got[1].name != "Tunde" {
+		t.Errorf("legacy id contact = %+v, want a new id", got[1])
+	}
+	if got[2].hasPrefs {
+		t.Errorf("null preferences were stored: %+v", got[2])
+	}
+	for i, r := range got {
+		if r.position != i || r.status != "invited" {
+			t.Errorf("contact %d: position %d status %s, want %d invited", i, r.position, r.status, i)
+		}
+	}
+	if len(byUser[empty]) != 0 || len(byUser[notArray]) != 0 {
+		t.Errorf("users without a contact array got contacts: %d, %d", len(byUser[empty]), len(byUser[notArray]))
+	}
+
+	// A deleted contact is not put back on rollback
+	m.exec(`UPDATE contacts SET deleted_at = NOW() WHERE id = $1`, got[1].id)
+	m.down(version)
+
+	var restored []byte
+	if err := m.pool.QueryRow(context.Background(), `SELECT trusted_contacts FROM users WHERE id = $1`, withContacts).Scan(&restored); err != nil {
+		t.Fatalf("read trusted_contacts: %v", err)
+	}
+	var contacts []map[string]interface{}
+	if err := json.Unmarshal(restored, &contacts); err != nil {
+		t.Fatalf("decode trusted_contacts: %v", err)
+	}
+	if len(contacts) != 2 || contacts[0]["id"] != keptID.String() || contacts[1]["name"] != "No ID" {
+		t.Errorf("rolled back trusted_contacts = %s", restored)
+	}
+	if _, ok := contacts[1]["preferences"]; ok {
+		t.Errorf("null preferences came back: %s", restored)
+	}
+}
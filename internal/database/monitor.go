@@ -0,0 +1,98 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+)
+
+// Stale-user monitor operations
+
+// GetTrackedUsers returns the latest live heartbeat of every user who sent
+// one since `since` and whose protection isn't paused at now. It rebuilds the
+// monitor's schedule in Redis; heartbeats older than since are not read.
+func (db *PostgresDB) GetTrackedUsers(ctx context.Context, since, now time.Time) (map[uuid.UUID]time.Time, error) {
+	query := `
+		SELECT h.user_id, MAX(h.timestamp)
+		FROM heartbeats h
+		WHERE h.timestamp >= $1 AND NOT h.backfill AND h.duplicate_of IS NULL
+		  AND NOT EXISTS (
+			SELECT 1 FROM protection_pauses p
+			WHERE p.user_id = h.user_id AND p.resumed_at IS NULL AND p.paused_until > $2
+		  )
+		GROUP BY h.user_id
+	`
+	rows, err := db.pool.Query(ctx, query, since, now)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	latest := make(map[uuid.UUID]time.Time)
+	for rows.Next() {
+		var userID uuid.UUID
+		var ts time.Time
+		if err := rows.Scan(&userID, &ts); err != nil {
+			return nil, err
+		}
+		latest[userID] = ts
+	}
+	return latest, rows.Err()
+}
+
+// GetWarmupUsers returns every user who sent a live heartbeat since `since`,
+// most at risk first: users with an unresolved alert, then users last
+// recorded AT_RISK or CAUTION, then the rest, each group by latest
+// heartbeat
+func (db *PostgresDB) GetWarmupUsers(ctx context.Context, since, now time.Time) ([]models.WarmupUser, error) {
+	query := `
+		WITH active AS (
+			SELECT user_id, MAX(timestamp) AS last_heartbeat
+			FROM heartbeats
+			WHERE timestamp >= $1 AND NOT backfill AND duplicate_of IS NULL
+			GROUP BY user_id
+		)
+		SELECT a.user_id, a.last_heartbeat, al.last_alert_at,
+			EXISTS (
+				SELECT 1 FROM protection_pauses p
+				WHERE p.user_id = a.user_id AND p.resumed_at IS NULL AND p.paused_until > $2
+			)
+		FROM active a
+		LEFT JOIN LATERAL (
+			SELECT MAX(created_at) AS last_alert_at,
+				BOOL_OR(resolved_at IS NULL) AS open_alert
+			FROM alerts WHERE user_id = a.user_id
+		) al ON TRUE
+		LEFT JOIN LATERAL (
+			SELECT to_state FROM user_states
+			WHERE user_id = a.user_id
+			ORDER BY timestamp DESC, id DESC
+			LIMIT 1
+		) s ON TRUE
+		ORDER BY
+			CASE
+				WHEN al.open_alert THEN 0
+				WHEN s.to_state IN ('AT_RISK', 'CAUTION') THEN 1
+				ELSE 2
+			END,
+			a.last_heartbeat DESC
+	`
+	rows, err := db.pool.Query(ctx, query, since, now)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []models.WarmupUser
+	for rows.Next() {
+		var u models.WarmupUser
+		if err := rows.Scan(&u.UserID, &u.LastHeartbeat, &u.LastAlertAt, &u.Paused); err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
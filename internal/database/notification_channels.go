@@ -0,0 +1,147 @@
+package database
+
+import (
+	"context"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+const notificationChannelColumns = `
+	id, user_id, type, name, webhook_url, enabled, consecutive_failures,
+	COALESCE(disabled_reason, ''), last_delivery_at, COALESCE(last_error, ''), created_at, updated_at
+`
+
+func scanNotificationChannel(row pgx.Row) (*models.NotificationChannel, error) {
+	var ch models.NotificationChannel
+	err := row.Scan(
+		&ch.ID, &ch.UserID, &ch.Type, &ch.Name, &ch.WebhookURL, &ch.Enabled, &ch.ConsecutiveFailures,
+		&ch.DisabledReason, &ch.LastDeliveryAt, &ch.LastError, &ch.CreatedAt, &ch.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &ch, nil
+}
+
+// Notification channel operations
+
+func (db *PostgresDB) CreateNotificationChannel(ctx context.Context, ch *models.NotificationChannel) error {
+	query := `
+		INSERT INTO notification_channels (id, user_id, type, name, webhook_url, enabled, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	`
+	_, err := db.pool.Exec(ctx, query,
+		ch.ID, ch.UserID, ch.Type, ch.Name, ch.WebhookURL, ch.Enabled, ch.CreatedAt, ch.UpdatedAt,
+	)
+	return err
+}
+
+// GetNotificationChannel returns the user's channel, or nil
+func (db *PostgresDB) GetNotificationChannel(ctx context.Context, userID, id uuid.UUID) (*models.NotificationChannel, error) {
+	query := `SELECT ` + notificationChannelColumns + ` FROM notification_channels WHERE id = $1 AND user_id = $2`
+	ch, err := scanNotificationChannel(db.pool.QueryRow(ctx, query, id, userID))
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	return ch, err
+}
+
+// GetNotificationChannels returns the user's channels, oldest first. With
+// enabledOnly, channels that are switched off or were auto-disabled are left out.
+func (db *PostgresDB) GetNotificationChannels(ctx context.Context, userID uuid.UUID, enabledOnly bool) ([]models.NotificationChannel, error) {
+	query := `
+		SELECT ` + notificationChannelColumns + `
+		FROM notification_channels
+		WHERE user_id = $1 AND (enabled OR NOT $2)
+		ORDER BY created_at
+	`
+	rows, err := db.pool.Query(ctx, query, userID, enabledOnly)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var channels []models.NotificationChannel
+	for rows.Next() {
+		ch, err := scanNotificationChannel(rows)
+		if err != nil {
+			return nil, err
+		}
+		channels = append(channels, *ch)
+	}
+	return channels, rows.Err()
+}
+
+// UpdateNotificationChannel saves the name, URL and enabled flag. Enabling a
+// channel clears its failure count and the reason it was disabled.
+func (db *PostgresDB) UpdateNotificationChannel(ctx context.Context, ch *models.NotificationChannel) (*models.NotificationChannel, error) {
+	query := `
+		UPDATE notification_channels SET
+			name = $3,
+			webhook_url = $4,
+			consecutive_failures = CASE WHEN $5 AND NOT enabled THEN 0 ELSE consecutive_failures END,
+			disabled_reason = CASE WHEN $5 THEN NULL ELSE disabled_reason END,
+			enabled = $5,
+			updated_at = NOW()
+		WHERE id = $1 AND user_id = $2
+		RETURNING ` + notificationChannelColumns
+	updated, err := scanNotificationChannel(db.pool.QueryRow(ctx, query,
+		ch.ID, ch.UserID, ch.Name, ch.WebhookURL, ch.Enabled,
+	))
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	return updated, err
+}
+
+// DeleteNotificationChannel removes the user's channel and reports whether it existed
+func (db *PostgresDB) DeleteNotificationChannel(ctx context.Context, userID, id uuid.UUID) (bool, error) {
+	tag, err := db.pool.Exec(ctx, `DELETE FROM notification_channels WHERE id = $1 AND user_id = $2`, id, userID)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+// RecordChannelDelivery resets the channel's failure count after a delivery went through
+func (db *PostgresDB) RecordChannelDelivery(ctx context.Context, id uuid.UUID) error {
+	query := `
+		UPDATE notification_channels
+		SET consecutive_failures = 0, last_delivery_at = NOW(), last_error = NULL, updated_at = NOW()
+		WHERE id = $1
+	`
+	_, err := db.pool.Exec(ctx, query, id)
+	return err
+}
+
+// RecordChannelFailure stores a failed delivery. Rejections (the endpoint
+// refused the request) count towards disabling the channel, which happens
+// once disableAfter of them arrive in a row; transient failures don't. It
+// reports whether this failure disabled the channel.
+func (db *PostgresDB) RecordChannelFailure(ctx context.Context, id uuid.UUID, detail string, rejected bool, disableAfter int) (bool, error) {
+	query := `
+		WITH prev AS (
+			SELECT enabled, consecutive_failures + CASE WHEN $3 THEN 1 ELSE 0 END AS failures
+			FROM notification_channels WHERE id = $1
+		)
+		UPDATE notification_channels c SET
+			consecutive_failures = prev.failures,
+			last_error = $2,
+			enabled = c.enabled AND prev.failures < $4,
+			disabled_reason = CASE
+				WHEN c.enabled AND prev.failures >= $4 THEN 'disabled after ' || prev.failures || ' rejected deliveries: ' || $2
+				ELSE c.disabled_reason END,
+			updated_at = NOW()
+		FROM prev
+		WHERE c.id = $1
+		RETURNING prev.enabled AND NOT c.enabled
+	`
+	var disabled bool
+	err := db.pool.QueryRow(ctx, query, id, detail, rejected, disableAfter).Scan(&disabled)
+	if err == pgx.ErrNoRows {
+		return false, nil
+	}
+	return disabled, err
+}
@@ -0,0 +1,363 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+const organizationColumns = `id, name, default_settings, escalation_contacts, scoring_profile, created_at, updated_at`
+
+func scanOrganization(row pgx.Row) (*models.Organization, error) {
+	var o models.Organization
+	var defaults, profile []byte
+	err := row.Scan(&o.ID, &o.Name, &defaults, &o.EscalationContacts, &profile, &o.CreatedAt, &o.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	o.DefaultSettings = json.RawMessage(defaults)
+	if profile != nil {
+		o.ScoringProfile = json.RawMessage(profile)
+	}
+	return &o, nil
+}
+
+// nullableJSON stores an empty raw message as NULL
+func nullableJSON(raw json.RawMessage) []byte {
+	if len(raw) == 0 {
+		return nil
+	}
+	return []byte(raw)
+}
+
+// Organization operations
+
+func (db *PostgresDB) CreateOrganization(ctx context.Context, o *models.Organization) error {
+	query := `
+		INSERT INTO organizations (id, name, default_settings, escalation_contacts, scoring_profile, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`
+	_, err := db.pool.Exec(ctx, query,
+		o.ID, o.Name, []byte(o.DefaultSettings), o.EscalationContacts, nullableJSON(o.ScoringProfile), o.CreatedAt, o.UpdatedAt,
+	)
+	return err
+}
+
+// GetOrganization returns the organization, or nil
+func (db *PostgresDB) GetOrganization(ctx context.Context, id uuid.UUID) (*models.Organization, error) {
+	query := `SELECT ` + organizationColumns + ` FROM organizations WHERE id = $1`
+	o, err := scanOrganization(db.pool.QueryRow(ctx, query, id))
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	return o, err
+}
+
+func (db *PostgresDB) ListOrganizations(ctx context.Context) ([]models.Organization, error) {
+	query := `SELECT ` + organizationColumns + ` FROM organizations ORDER BY name`
+	rows, err := db.pool.Query(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var orgs []models.Organization
+	for rows.Next() {
+		o, err := scanOrganization(rows)
+		if err != nil {
+			return nil, err
+		}
+		orgs = append(orgs, *o)
+	}
+	return orgs, rows.Err()
+}
+
+// UpdateOrganization replaces the organization's name and settings
+func (db *PostgresDB) UpdateOrganization(ctx context.Context, o *models.Organization) error {
+	// Members' cached scoring profiles; other instances flush theirs on the
+	// trigger's notification
+	defer db.orgProfiles.Flush()
+
+	query := `
+		UPDATE organizations
+		SET name = $2, default_settings = $3, escalation_contacts = $4, scoring_profile = $5, updated_at = $6
+		WHERE id = $1
+	`
+	_, err := db.pool.Exec(ctx, query,
+		o.ID, o.Name, []byte(o.DefaultSettings), o.EscalationContacts, nullableJSON(o.ScoringProfile), o.UpdatedAt,
+	)
+	return err
+}
+
+// GetUserOrgScoringProfile returns the user's organization and its scoring
+// profile override, or uuid.Nil and nil if there is none. It is read on
+// every evaluation, so it is cached, users without an override included.
+// The override returned is shared and must not be changed.
+func (db *PostgresDB) GetUserOrgScoringProfile(ctx context.Context, userID uuid.UUID) (uuid.UUID, json.RawMessage, error) {
+	p, err := db.orgProfiles.GetOrLoad(ctx, userID, func(ctx context.Context) (orgProfile, error) {
+		return db.loadUserOrgScoringProfile(ctx, userID)
+	})
+	if err != nil {
+		return uuid.Nil, nil, err
+	}
+	return p.orgID, p.profile, nil
+}
+
+func (db *PostgresDB) loadUserOrgScoringProfile(ctx context.Context, userID uuid.UUID) (orgProfile, error) {
+	query := `
+		SELECT o.id, o.scoring_profile
+		FROM users u
+		JOIN organizations o ON o.id = u.org_id
+		WHERE u.id = $1 AND o.scoring_profile IS NOT NULL
+	`
+	var orgID uuid.UUID
+	var profile []byte
+	err := db.pool.QueryRow(ctx, query, userID).Scan(&orgID, &profile)
+	if err == pgx.ErrNoRows {
+		return orgProfile{}, nil
+	}
+	if err != nil {
+		return orgProfile{}, err
+	}
+	return orgProfile{orgID: orgID, profile: json.RawMessage(profile)}, nil
+}
+
+// Membership operations
+
+// GetOrgMembers returns the organization's members by name
+func (db *PostgresDB) GetOrgMembers(ctx context.Context, orgID uuid.UUID) ([]models.OrgMember, error) {
+	rows, err := db.pool.Query(ctx, `SELECT id, name, phone FROM users WHERE org_id = $1 ORDER BY name, id`, orgID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var members []models.OrgMember
+	for rows.Next() {
+		var m models.OrgMember
+		if err := rows.Scan(&m.ID, &m.Name, &m.Phone); err != nil {
+			return nil, err
+		}
+		members = append(members, m)
+	}
+	return members, rows.Err()
+}
+
+// RemoveOrgMember takes the user out of the organization; it reports whether
+// they were a member
+func (db *PostgresDB) RemoveOrgMember(ctx context.Context, orgID, userID uuid.UUID) (bool, error) {
+	defer db.forgetUser(userID)
+
+	tag, err := db.pool.Exec(ctx,
+		`UPDATE users SET org_id = NULL, updated_at = NOW() WHERE id = $1 AND org_id = $2`, userID, orgID)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// Invitation operations
+
+const orgInvitationColumns = `id, org_id, phone, invited_by, created_at, expires_at, accepted_at, accepted_by`
+
+func scanOrgInvitation(row pgx.Row) (*models.OrgInvitation, error) {
+	var inv models.OrgInvitation
+	err := row.Scan(&inv.ID, &inv.OrgID, &inv.Phone, &inv.InvitedBy, &inv.CreatedAt, &inv.ExpiresAt, &inv.AcceptedAt, &inv.AcceptedBy)
+	if err != nil {
+		return nil, err
+	}
+	return &inv, nil
+}
+
+func collectOrgInvitations(rows pgx.Rows) ([]models.OrgInvitation, error) {
+	defer rows.Close()
+
+	var invitations []models.OrgInvitation
+	for rows.Next() {
+		inv, err := scanOrgInvitation(rows)
+		if err != nil {
+			return nil, err
+		}
+		invitations = append(invitations, *inv)
+	}
+	return invitations, rows.Err()
+}
+
+// CreateOrgInvitation stores an invitation, or renews the open one for the
+// same organization and phone, and returns what was stored
+func (db *PostgresDB) CreateOrgInvitation(ctx context.Context, inv *models.OrgInvitation) (*models.OrgInvitation, error) {
+	query := `
+		INSERT INTO org_invitations (id, org_id, phone, invited_by, created_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (org_id, phone) WHERE accepted_at IS NULL
+		DO UPDATE SET invited_by = EXCLUDED.invited_by, created_at = EXCLUDED.created_at, expires_at = EXCLUDED.expires_at
+		RETURNING ` + orgInvitationColumns
+	return scanOrgInvitation(db.pool.QueryRow(ctx, query,
+		inv.ID, inv.OrgID, inv.Phone, inv.InvitedBy, inv.CreatedAt, inv.ExpiresAt,
+	))
+}
+
+// GetOrgInvitations returns the organization's most recent invitations, newest first
+func (db *PostgresDB) GetOrgInvitations(ctx context.Context, orgID uuid.UUID, limit int) ([]models.OrgInvitation, error) {
+	query := `
+		SELECT ` + orgInvitationColumns + `
+		FROM org_invitations
+		WHERE org_id = $1
+		ORDER BY created_at DESC
+		LIMIT $2
+	`
+	rows, err := db.pool.Query(ctx, query, orgID, limit)
+	if err != nil {
+		return nil, err
+	}
+	return collectOrgInvitations(rows)
+}
+
+// GetOpenOrgInvitations returns the unexpired, unaccepted invitations for a
+// phone number, with the inviting organization's name
+func (db *PostgresDB) GetOpenOrgInvitations(ctx context.Context, phone string, now time.Time) ([]models.OrgInvitation, error) {
+	query := `
+		SELECT i.id, i.org_id, i.phone, i.invited_by, i.created_at, i.expires_at, i.accepted_at, i.accepted_by, o.name
+		FROM org_invitations i
+		JOIN organizations o ON o.id = i.org_id
+		WHERE i.phone = $1 AND i.accepted_at IS NULL AND i.expires_at > $2
+		ORDER BY i.created_at DESC
+	`
+	rows, err := db.pool.Query(ctx, query, phone, now)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var invitations []models.OrgInvitation
+	for rows.Next() {
+		var inv models.OrgInvitation
+		err := rows.Scan(&inv.ID, &inv.OrgID, &inv.Phone, &inv.InvitedBy, &inv.CreatedAt, &inv.ExpiresAt,
+			&inv.AcceptedAt, &inv.AcceptedBy, &inv.OrgName)
+		if err != nil {
+			return nil, err
+		}
+		invitations = append(invitations, inv)
+	}
+	return invitations, rows.Err()
+}
+
+// AcceptOrgInvitation makes the user a member of the inviting organization
+// and stores the settings they join with, in one transaction. It fails with
+// ErrInvitationNotFound unless the invitation is open and for the user's
+// phone, and with ErrAlreadyMember if the user belongs to an organization.
+func (db *PostgresDB) AcceptOrgInvitation(ctx context.Context, invitationID uuid.UUID, user *models.User, settings models.UserSettings, now time.Time) (*models.OrgInvitation, error) {
+	defer db.forgetUser(user.ID)
+
+	tx, err := db.pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	inv, err := scanOrgInvitation(tx.QueryRow(ctx, `
+		SELECT `+orgInvitationColumns+`
+		FROM org_invitations
+		WHERE id = $1 AND phone = $2 AND accepted_at IS NULL AND expires_at > $3
+		FOR UPDATE
+	`, invitationID, user.Phone, now))
+	if err == pgx.ErrNoRows {
+		return nil, ErrInvitationNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	tag, err := tx.Exec(ctx,
+		`UPDATE users SET org_id = $2, settings = $3, updated_at = $4 WHERE id = $1 AND org_id IS NULL`,
+		user.ID, inv.OrgID, settings, now)
+	if err != nil {
+		return nil, err
+	}
+	if tag.RowsAffected() == 0 {
+		return nil, ErrAlreadyMember
+	}
+
+	if _, err := tx.Exec(ctx,
+		`UPDATE org_invitations SET accepted_at = $2, accepted_by = $3 WHERE id = $1`,
+		inv.ID, now, user.ID); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	inv.AcceptedAt = &now
+	inv.AcceptedBy = &user.ID
+	return inv, nil
+}
+
+// LeaveOrganization takes the user out of their organization and returns
+// the one they left, or uuid.Nil if they had none
+func (db *PostgresDB) LeaveOrganization(ctx context.Context, userID uuid.UUID) (uuid.UUID, error) {
+	defer db.forgetUser(userID)
+
+	query := `
+		WITH prev AS (SELECT id, org_id FROM users WHERE id = $1 FOR UPDATE)
+		UPDATE users u SET org_id = NULL, updated_at = NOW()
+		FROM prev
+		WHERE u.id = prev.id AND prev.org_id IS NOT NULL
+		RETURNING prev.org_id
+	`
+	var orgID uuid.UUID
+	err := db.pool.QueryRow(ctx, query, userID).Scan(&orgID)
+	if err == pgx.ErrNoRows {
+		return uuid.Nil, nil
+	}
+	return orgID, err
+}
+
+// GetAdminStats counts users, alerts and LastGasps for an admin: the
+// organization's current members, or every user when orgID is nil
+func (db *PostgresDB) GetAdminStats(ctx context.Context, orgID *uuid.UUID, from, to time.Time) (*models.AdminStats, error) {
+	stats := &models.AdminStats{OrgID: orgID, From: from, To: to, Alerts: map[string]int{}}
+
+	err := db.pool.QueryRow(ctx,
+		`SELECT COUNT(*) FROM users WHERE $1::uuid IS NULL OR org_id = $1`, orgID).Scan(&stats.Users)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.pool.Query(ctx, `
+		SELECT a.state, COUNT(*)
+		FROM alerts a
+		JOIN users u ON u.id = a.user_id
+		WHERE a.created_at BETWEEN $2 AND $3 AND ($1::uuid IS NULL OR u.org_id = $1)
+		GROUP BY a.state
+	`, orgID, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var state string
+		var count int
+		if err := rows.Scan(&state, &count); err != nil {
+			return nil, err
+		}
+		stats.Alerts[state] = count
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	err = db.pool.QueryRow(ctx, `
+		SELECT
+			(SELECT COUNT(*) FROM alerts a JOIN users u ON u.id = a.user_id
+			 WHERE a.resolved_at IS NULL AND ($1::uuid IS NULL OR u.org_id = $1)),
+			(SELECT COUNT(*) FROM last_gasps l JOIN users u ON u.id = l.user_id
+			 WHERE l.created_at BETWEEN $2 AND $3 AND ($1::uuid IS NULL OR u.org_id = $1))
+	`, orgID, from, to).Scan(&stats.OpenAlerts, &stats.LastGasps)
+	if err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
@@ -0,0 +1,87 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+)
+
+// createTestOrgMember stores an organization with one member who has an
+// open alert
+func createTestOrgMember(t *testing.T, db *PostgresDB, name string) (uuid.UUID, *models.User) {
+	t.Helper()
+	ctx := context.Background()
+	now := time.Now()
+	org := &models.Organization{ID: uuid.New(), Name: name, DefaultSettings: json.RawMessage(`{}`), EscalationContacts: models.TrustedContacts{}, CreatedAt: now, UpdatedAt: now}
+	if err := db.CreateOrganization(ctx, org); err != nil {
+		t.Fatalf("CreateOrganization: %v", err)
+	}
+	member := createTestUser(t, db, name+" student")
+	if _, err := db.pool.Exec(ctx, `UPDATE users SET org_id = $2 WHERE id = $1`, member.ID, org.ID); err != nil {
+		t.Fatalf("join organization: %v", err)
+	}
+	createTestAlert(t, db, member.ID)
+	return org.ID, member
+}
+
+func createTestAlert(t *testing.T, db *PostgresDB, userID uuid.UUID) *models.Alert {
+	t.Helper()
+	alert := &models.Alert{ID: uuid.New(), UserID: userID, State: models.AlertStateAlert, Score: 20, Reason: "test", CreatedAt: time.Now()}
+	if err := db.CreateAlert(context.Background(), alert); err != nil {
+		t.Fatalf("CreateAlert: %v", err)
+	}
+	return alert
+}
+
+// What an org admin is shown covers their members only: never another
+// organization's, nor consumer users'
+func TestAdminQueriesAreOrgScoped(t *testing.T) {
+	db := testPostgres(t)
+	ctx := context.Background()
+	orgID, member := createTestOrgMember(t, db, "Unilag")
+	_, otherMember := createTestOrgMember(t, db, "Covenant")
+	consumer := createTestUser(t, db, "Consumer")
+	createTestAlert(t, db, consumer.ID)
+
+	alerts, err := db.GetActiveAlerts(ctx, &orgID, 1000)
+	if err != nil {
+		t.Fatalf("GetActiveAlerts: %v", err)
+	}
+	if len(alerts) != 1 || alerts[0].UserID != member.ID {
+		t.Errorf("organization's active alerts = %d, want only its member's", len(alerts))
+	}
+
+	all, err := db.GetActiveAlerts(ctx, nil, 100000)
+	if err != nil {
+		t.Fatalf("GetActiveAlerts: %v", err)
+	}
+	seen := map[uuid.UUID]bool{}
+	for _, a := range all {
+		seen[a.UserID] = true
+	}
+	if !seen[member.ID] || !seen[otherMember.ID] || !seen[consumer.ID] {
+		t.Errorf("platform admin misses alerts: %v", seen)
+	}
+
+	now := time.Now()
+	stats, err := db.GetAdminStats(ctx, &orgID, now.Add(-time.Hour), now.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("GetAdminStats: %v", err)
+	}
+	if stats.Users != 1 || stats.OpenAlerts != 1 || stats.Alerts[string(models.AlertStateAlert)] != 1 {
+		t.Errorf("organization stats = %+v, want one member with one open alert", stats)
+	}
+
+	members, err := db.GetOrgMembers(ctx, orgID)
+	if err != nil {
+		t.Fatalf("GetOrgMembers: %v", err)
+	}
+	if len(members) != 1 {
+		t.Errorf("%d members, want 1", len(members))
+	}
+}
@@ -0,0 +1,142 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+const panicRequestColumns = `id, user_id, created_at, release_at, status, resolution, resolved_at, COALESCE(note, '')`
+
+func scanPanicRequest(row pgx.Row) (*models.PanicRequest, error) {
+	var p models.PanicRequest
+	err := row.Scan(&p.ID, &p.UserID, &p.CreatedAt, &p.ReleaseAt, &p.Status, &p.Resolution, &p.ResolvedAt, &p.Note)
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// Panic request operations
+
+// CreatePanicRequest stores a pending panic. If the user already has one
+// pending, nothing is stored and that one is returned instead.
+func (db *PostgresDB) CreatePanicRequest(ctx context.Context, p *models.PanicRequest) (*models.PanicRequest, error) {
+	query := `
+		INSERT INTO panic_requests (id, user_id, created_at, release_at, note)
+		VALUES ($1, $2, $3, $4, NULLIF($5, ''))
+		ON CONFLICT (user_id) WHERE status = 'pending' DO NOTHING
+		RETURNING ` + panicRequestColumns
+	created, err := scanPanicRequest(db.pool.QueryRow(ctx, query, p.ID, p.UserID, p.CreatedAt, p.ReleaseAt, p.Note))
+	if err != pgx.ErrNoRows {
+		return created, err
+	}
+
+	query = `SELECT ` + panicRequestColumns + ` FROM panic_requests WHERE user_id = $1 AND status = 'pending'`
+	pending, err := scanPanicRequest(db.pool.QueryRow(ctx, query, p.UserID))
+	if err == pgx.ErrNoRows {
+		// Settled between the insert and the read; the caller can try again
+		return nil, nil
+	}
+	return pending, err
+}
+
+// GetPanicRequest returns a panic request, or nil
+func (db *PostgresDB) GetPanicRequest(ctx context.Context, id uuid.UUID) (*models.PanicRequest, error) {
+	query := `SELECT ` + panicRequestColumns + ` FROM panic_requests WHERE id = $1`
+	p, err := scanPanicRequest(db.pool.QueryRow(ctx, query, id))
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	return p, err
+}
+
+// CancelPanicRequest marks a pending panic cancelled and returns it, or nil
+// if it is no longer pending or its window has run out at now.
+func (db *PostgresDB) CancelPanicRequest(ctx context.Context, id uuid.UUID, now time.Time) (*models.PanicRequest, error) {
+	query := `
+		UPDATE panic_requests
+		SET status = 'cancelled', resolution = 'cancelled', resolved_at = $2
+		WHERE id = $1 AND status = 'pending' AND release_at > $2
+		RETURNING ` + panicRequestColumns
+	p, err := scanPanicRequest(db.pool.QueryRow(ctx, query, id, now))
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	return p, err
+}
+
+// DispatchPanicRequest marks a pending panic dispatched with the resolution
+// and returns it, or nil if it is no longer pending. Only the caller that
+// gets it back raises the alert.
+func (db *PostgresDB) DispatchPanicRequest(ctx context.Context, id uuid.UUID, resolution string, now time.Time) (*models.PanicRequest, error) {
+	query := `
+		UPDATE panic_requests
+		SET status = 'dispatched', resolution = $2, resolved_at = $3
+		WHERE id = $1 AND status = 'pending'
+		RETURNING ` + panicRequestColumns
+	p, err := scanPanicRequest(db.pool.QueryRow(ctx, query, id, resolution, now))
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	return p, err
+}
+
+// ReleaseLapsedPanicRequests marks up to limit pending panics whose window
+// has run out dispatched and returns them. Concurrent callers never take
+// the same panic.
+func (db *PostgresDB) ReleaseLapsedPanicRequests(ctx context.Context, now time.Time, limit int) ([]models.PanicRequest, error) {
+	query := `
+		UPDATE panic_requests
+		SET status = 'dispatched', resolution = 'expired', resolved_at = $1
+		WHERE id IN (
+			SELECT id FROM panic_requests
+			WHERE status = 'pending' AND release_at <= $1
+			ORDER BY release_at
+			LIMIT $2
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING ` + panicRequestColumns
+	rows, err := db.pool.Query(ctx, query, now, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var released []models.PanicRequest
+	for rows.Next() {
+		p, err := scanPanicRequest(rows)
+		if err != nil {
+			return nil, err
+		}
+		released = append(released, *p)
+	}
+	return released, rows.Err()
+}
+
+// GetPanicPINs returns the hashes of the user's panic and duress PINs
+func (db *PostgresDB) GetPanicPINs(ctx context.Context, userID uuid.UUID) (*models.PanicPINs, error) {
+	query := `SELECT COALESCE(panic_pin_hash, ''), COALESCE(duress_pin_hash, '') FROM users WHERE id = $1`
+	var pins models.PanicPINs
+	err := db.pool.QueryRow(ctx, query, userID).Scan(&pins.PIN, &pins.Duress)
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &pins, nil
+}
+
+// SetPanicPINs replaces the hashes of the user's panic and duress PINs; an
+// empty duress hash clears it
+func (db *PostgresDB) SetPanicPINs(ctx context.Context, userID uuid.UUID, pins models.PanicPINs) error {
+	defer db.forgetUser(userID)
+
+	query := `UPDATE users SET panic_pin_hash = $2, duress_pin_hash = NULLIF($3, ''), updated_at = NOW() WHERE id = $1`
+	_, err := db.pool.Exec(ctx, query, userID, pins.PIN, pins.Duress)
+	return err
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/adedejiosvaldo/safetrace/backend/internal/cache"
 	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
@@ -14,9 +15,17 @@ import (
 
 type PostgresDB struct {
 	pool *pgxpool.Pool
+
+	// caches routes invalidations to the in-process caches, users among them
+	caches      *cache.Registry
+	users       *cache.Cache[uuid.UUID, *models.User]
+	orgProfiles *cache.Cache[uuid.UUID, orgProfile] // by user ID
 }
 
-func NewPostgresDB(databaseURL string) (*PostgresDB, error) {
+// NewPostgresDB connects to Postgres and checks the connection within ctx.
+// Users read by ID, and their organization's scoring profile, are cached for
+// userCacheTTL; 0 turns the caches off.
+func NewPostgresDB(ctx context.Context, databaseURL string, userCacheTTL time.Duration) (*PostgresDB, error) {
 	config, err := pgxpool.ParseConfig(databaseURL)
 	if err != nil {
 		return nil, fmt.Errorf("unable to parse database URL: %w", err)
@@ -28,17 +37,26 @@ func NewPostgresDB(databaseURL string) (*PostgresDB, error) {
 	config.MaxConnLifetime = time.Hour
 	config.MaxConnIdleTime = 30 * time.Minute
 
-	pool, err := pgxpool.NewWithConfig(context.Background(), config)
+	pool, err := pgxpool.NewWithConfig(ctx, config)
 	if err != nil {
 		return nil, fmt.Errorf("unable to create connection pool: %w", err)
 	}
 
-	// Test connection
-	if err := pool.Ping(context.Background()); err != nil {
+	// Test connection; a failed attempt must not leave its pool dialing
+	if err := pool.Ping(ctx); err != nil {
+		pool.Close()
 		return nil, fmt.Errorf("unable to ping database: %w", err)
 	}
 
-	return &PostgresDB{pool: pool}, nil
+	db := &PostgresDB{
+		pool:        pool,
+		caches:      cache.NewRegistry(),
+		users:       cache.New[uuid.UUID, *models.User](userCacheTTL, maxCachedUsers),
+		orgProfiles: cache.New[uuid.UUID, orgProfile](userCacheTTL, maxCachedUsers),
+	}
+	db.registerUserCache()
+	db.registerOrgProfileCache()
+	return db, nil
 }
 
 func (db *PostgresDB) Close() {
@@ -46,27 +64,37 @@ func (db *PostgresDB) Close() {
 }
 
 // User operations
+
+// CreateUser stores a new user. Their trusted contacts are added separately.
 func (db *PostgresDB) CreateUser(ctx context.Context, user *models.User) error {
 	query := `
-		INSERT INTO users (id, phone, name, trusted_contacts, settings, created_at, updated_at)
-		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		INSERT INTO users (id, phone, name, settings, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
 	`
 	_, err := db.pool.Exec(ctx, query,
-		user.ID, user.Phone, user.Name, user.TrustedContacts,
+		user.ID, user.Phone, user.Name,
 		user.Settings, user.CreatedAt, user.UpdatedAt,
 	)
+	if isUniqueViolation(err, "phone") {
+		return ErrPhoneAlreadyRegistered
+	}
 	return err
 }
 
+// GetUserByID returns the user with their trusted contacts, from the cache
+// when it holds them
 func (db *PostgresDB) GetUserByID(ctx context.Context, id uuid.UUID) (*models.User, error) {
+	if user, ok := db.cachedUser(id); ok {
+		return user, nil
+	}
 	query := `
-		SELECT id, phone, name, trusted_contacts, settings, created_at, updated_at
+		SELECT id, phone, name, settings, org_id, created_at, updated_at
 		FROM users WHERE id = $1
 	`
 	var user models.User
 	err := db.pool.QueryRow(ctx, query, id).Scan(
-		&user.ID, &user.Phone, &user.Name, &user.TrustedContacts,
-		&user.Settings, &user.CreatedAt, &user.UpdatedAt,
+		&user.ID, &user.Phone, &user.Name,
+		&user.Settings, &user.OrgID, &user.CreatedAt, &user.UpdatedAt,
 	)
 	if err == pgx.ErrNoRows {
 		return nil, nil
@@ -74,18 +102,22 @@ func (db *PostgresDB) GetUserByID(ctx context.Context, id uuid.UUID) (*models.Us
 	if err != nil {
 		return nil, err
 	}
+	if user.TrustedContacts, err = db.TrustedContacts(ctx, user.ID); err != nil {
+		return nil, err
+	}
+	db.users.Set(user.ID, cloneUser(&user))
 	return &user, nil
 }
 
 func (db *PostgresDB) GetUserByPhone(ctx context.Context, phone string) (*models.User, error) {
 	query := `
-		SELECT id, phone, name, trusted_contacts, settings, created_at, updated_at
+		SELECT id, phone, name, settings, org_id, created_at, updated_at
 		FROM users WHERE phone = $1
 	`
 	var user models.User
 	err := db.pool.QueryRow(ctx, query, phone).Scan(
-		&user.ID, &user.Phone, &user.Name, &user.TrustedContacts,
-		&user.Settings, &user.CreatedAt, &user.UpdatedAt,
+		&user.ID, &user.Phone, &user.Name,
+		&user.Settings, &user.OrgID, &user.CreatedAt, &user.UpdatedAt,
 	)
 	if err == pgx.ErrNoRows {
 		return nil, nil
@@ -93,40 +125,119 @@ func (db *PostgresDB) GetUserByPhone(ctx context.Context, phone string) (*models
 	if err != nil {
 		return nil, err
 	}
+	if user.TrustedContacts, err = db.TrustedContacts(ctx, user.ID); err != nil {
+		return nil, err
+	}
 	return &user, nil
 }
 
+// UpdateUser saves the user's name and settings; contacts are changed with
+// ModifyContacts
 func (db *PostgresDB) UpdateUser(ctx context.Context, user *models.User) error {
+	defer db.forgetUser(user.ID)
+
 	query := `
 		UPDATE users
-		SET name = $2, trusted_contacts = $3, settings = $4, updated_at = $5
+		SET name = $2, settings = $3, updated_at = $4
 		WHERE id = $1
 	`
 	_, err := db.pool.Exec(ctx, query,
-		user.ID, user.Name, user.TrustedContacts, user.Settings, time.Now(),
+		user.ID, user.Name, user.Settings, time.Now(),
 	)
 	return err
 }
 
+// UpdateUserSettings replaces only the user's settings, so it can't undo a
+// concurrent contact change
+func (db *PostgresDB) UpdateUserSettings(ctx context.Context, userID uuid.UUID, settings models.UserSettings) error {
+	defer db.forgetUser(userID)
+
+	query := `UPDATE users SET settings = $2, updated_at = NOW() WHERE id = $1`
+	_, err := db.pool.Exec(ctx, query, userID, settings)
+	return err
+}
+
 // Heartbeat operations
 func (db *PostgresDB) CreateHeartbeat(ctx context.Context, hb *models.Heartbeat) error {
 	query := `
-		INSERT INTO heartbeats (id, user_id, source, lat, lng, accuracy_m, cell_info, battery_pct, speed, last_gasp, timestamp, signature, created_at)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+		INSERT INTO heartbeats (id, user_id, source, lat, lng, accuracy_m, cell_info, battery_pct, speed, last_gasp, timestamp, signature, created_at, is_mock, spoof_suspected, spoof_reasons, identified_by, trust_level, backfill, device_id, connectivity, landmark, duplicate_of, high_frequency, coarse)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22, $23, $24, $25)
 	`
 	_, err := db.pool.Exec(ctx, query,
 		hb.ID, hb.UserID, hb.Source, hb.Lat, hb.Lng, hb.AccuracyM,
 		hb.CellInfo, hb.BatteryPct, hb.Speed, hb.LastGasp, hb.Timestamp,
-		hb.Signature, hb.CreatedAt,
+		hb.Signature, hb.CreatedAt, hb.IsMock, hb.SpoofSuspected, hb.SpoofReasons, identifiedBy(hb), trustLevel(hb), hb.Backfill, deviceID(hb), connectivity(hb), landmark(hb), hb.DuplicateOf, hb.HighFrequency, hb.Coarse,
 	)
 	return err
 }
 
+// CopyHeartbeats bulk-inserts heartbeats using the COPY protocol
+func (db *PostgresDB) CopyHeartbeats(ctx context.Context, heartbeats []*models.Heartbeat) (int64, error) {
+	rows := make([][]interface{}, 0, len(heartbeats))
+	for _, hb := range heartbeats {
+		cellInfo, err := json.Marshal(hb.CellInfo)
+		if err != nil {
+			return 0, err
+		}
+		rows = append(rows, []interface{}{
+			hb.ID, hb.UserID, hb.Source, hb.Lat, hb.Lng, hb.AccuracyM,
+			cellInfo, hb.BatteryPct, hb.Speed, hb.LastGasp, hb.Timestamp,
+			hb.Signature, hb.CreatedAt, hb.IsMock, hb.SpoofSuspected, hb.SpoofReasons, identifiedBy(hb), trustLevel(hb), hb.Backfill, deviceID(hb), connectivity(hb), landmark(hb), hb.DuplicateOf, hb.HighFrequency, hb.Coarse,
+		})
+	}
+
+	return db.pool.CopyFrom(ctx,
+		pgx.Identifier{"heartbeats"},
+		[]string{"id", "user_id", "source", "lat", "lng", "accuracy_m", "cell_info", "battery_pct", "speed", "last_gasp", "timestamp", "signature", "created_at", "is_mock", "spoof_suspected", "spoof_reasons", "identified_by", "trust_level", "backfill", "device_id", "connectivity", "landmark", "duplicate_of", "high_frequency", "coarse"},
+		pgx.CopyFromRows(rows),
+	)
+}
+
+// identifiedBy defaults heartbeats built without attribution to the payload uid
+func identifiedBy(hb *models.Heartbeat) string {
+	if hb.IdentifiedBy == "" {
+		return models.IdentifiedByPayload
+	}
+	return hb.IdentifiedBy
+}
+
+// trustLevel stores heartbeats that never went through source binding as unverified
+func trustLevel(hb *models.Heartbeat) string {
+	if hb.Trust == "" {
+		return models.TrustUnverified
+	}
+	return hb.Trust
+}
+
+// deviceID stores heartbeats from clients that don't name their device as NULL
+func deviceID(hb *models.Heartbeat) *string {
+	if hb.DeviceID == "" {
+		return nil
+	}
+	return &hb.DeviceID
+}
+
+// connectivity stores heartbeats from clients that don't report it as NULL
+func connectivity(hb *models.Heartbeat) *string {
+	if hb.Connectivity == "" {
+		return nil
+	}
+	return &hb.Connectivity
+}
+
+// landmark stores heartbeats without a user-described location as NULL
+func landmark(hb *models.Heartbeat) *string {
+	if hb.Landmark == "" {
+		return nil
+	}
+	return &hb.Landmark
+}
+
 func (db *PostgresDB) GetLatestHeartbeat(ctx context.Context, userID uuid.UUID) (*models.Heartbeat, error) {
 	query := `
-		SELECT id, user_id, source, lat, lng, accuracy_m, cell_info, battery_pct, speed, last_gasp, timestamp, signature, created_at
+		SELECT id, user_id, source, lat, lng, accuracy_m, cell_info, battery_pct, speed, last_gasp, timestamp, signature, created_at, is_mock, spoof_suspected, spoof_reasons, identified_by, trust_level, backfill, COALESCE(device_id, ''), COALESCE(connectivity, ''), COALESCE(landmark, ''), duplicate_of, high_frequency, coarse
 		FROM heartbeats
-		WHERE user_id = $1
+		WHERE user_id = $1 AND NOT backfill AND duplicate_of IS NULL
 		ORDER BY timestamp DESC
 		LIMIT 1
 	`
@@ -134,7 +245,7 @@ func (db *PostgresDB) GetLatestHeartbeat(ctx context.Context, userID uuid.UUID)
 	err := db.pool.QueryRow(ctx, query, userID).Scan(
 		&hb.ID, &hb.UserID, &hb.Source, &hb.Lat, &hb.Lng, &hb.AccuracyM,
 		&hb.CellInfo, &hb.BatteryPct, &hb.Speed, &hb.LastGasp, &hb.Timestamp,
-		&hb.Signature, &hb.CreatedAt,
+		&hb.Signature, &hb.CreatedAt, &hb.IsMock, &hb.SpoofSuspected, &hb.SpoofReasons, &hb.IdentifiedBy, &hb.Trust, &hb.Backfill, &hb.DeviceID, &hb.Connectivity, &hb.Landmark, &hb.DuplicateOf, &hb.HighFrequency, &hb.Coarse,
 	)
 	if err == pgx.ErrNoRows {
 		return nil, nil
@@ -145,11 +256,49 @@ func (db *PostgresDB) GetLatestHeartbeat(ctx context.Context, userID uuid.UUID)
 	return &hb, nil
 }
 
+// GetLatestHeartbeatAsOf returns the heartbeat GetLatestHeartbeat would
+// have returned at the given time: stamped no later than it and already
+// received by then, so one that arrived late is left out. Heartbeats flagged
+// as backfill on arrival are left out as they are live.
+func (db *PostgresDB) GetLatestHeartbeatAsOf(ctx context.Context, userID uuid.UUID, at time.Time) (*models.Heartbeat, error) {
+	query := `
+		SELECT id, user_id, source, lat, lng, accuracy_m, cell_info, battery_pct, speed, last_gasp, timestamp, signature, created_at, is_mock, spoof_suspected, spoof_reasons, identified_by, trust_level, backfill, COALESCE(device_id, ''), COALESCE(connectivity, ''), COALESCE(landmark, ''), duplicate_of, high_frequency, coarse
+		FROM heartbeats
+		WHERE user_id = $1 AND timestamp <= $2 AND created_at <= $2 AND NOT backfill AND duplicate_of IS NULL
+		ORDER BY timestamp DESC
+		LIMIT 1
+	`
+	var hb models.Heartbeat
+	err := db.pool.QueryRow(ctx, query, userID, at).Scan(
+		&hb.ID, &hb.UserID, &hb.Source, &hb.Lat, &hb.Lng, &hb.AccuracyM,
+		&hb.CellInfo, &hb.BatteryPct, &hb.Speed, &hb.LastGasp, &hb.Timestamp,
+		&hb.Signature, &hb.CreatedAt, &hb.IsMock, &hb.SpoofSuspected, &hb.SpoofReasons, &hb.IdentifiedBy, &hb.Trust, &hb.Backfill, &hb.DeviceID, &hb.Connectivity, &hb.Landmark, &hb.DuplicateOf, &hb.HighFrequency, &hb.Coarse,
+	)
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &hb, nil
+}
+
+// CountLateHeartbeats counts the user's heartbeats stamped in [from, at]
+// that were only received after at
+func (db *PostgresDB) CountLateHeartbeats(ctx context.Context, userID uuid.UUID, from, at time.Time) (int, error) {
+	var n int
+	err := db.pool.QueryRow(ctx, `
+		SELECT COUNT(*) FROM heartbeats
+		WHERE user_id = $1 AND timestamp BETWEEN $2 AND $3 AND created_at > $3 AND duplicate_of IS NULL
+	`, userID, from, at).Scan(&n)
+	return n, err
+}
+
 func (db *PostgresDB) GetHeartbeatsSince(ctx context.Context, userID uuid.UUID, since time.Time) ([]models.Heartbeat, error) {
 	query := `
-		SELECT id, user_id, source, lat, lng, accuracy_m, cell_info, battery_pct, speed, last_gasp, timestamp, signature, created_at
+		SELECT id, user_id, source, lat, lng, accuracy_m, cell_info, battery_pct, speed, last_gasp, timestamp, signature, created_at, is_mock, spoof_suspected, spoof_reasons, identified_by, trust_level, backfill, COALESCE(device_id, ''), COALESCE(connectivity, ''), COALESCE(landmark, ''), duplicate_of, high_frequency, coarse
 		FROM heartbeats
-		WHERE user_id = $1 AND timestamp >= $2
+		WHERE user_id = $1 AND timestamp >= $2 AND duplicate_of IS NULL
 		ORDER BY timestamp DESC
 	`
 	rows, err := db.pool.Query(ctx, query, userID, since)
@@ -164,7 +313,7 @@ func (db *PostgresDB) GetHeartbeatsSince(ctx context.Context, userID uuid.UUID,
 		err := rows.Scan(
 			&hb.ID, &hb.UserID, &hb.Source, &hb.Lat, &hb.Lng, &hb.AccuracyM,
 			&hb.CellInfo, &hb.BatteryPct, &hb.Speed, &hb.LastGasp, &hb.Timestamp,
-			&hb.Signature, &hb.CreatedAt,
+			&hb.Signature, &hb.CreatedAt, &hb.IsMock, &hb.SpoofSuspected, &hb.SpoofReasons, &hb.IdentifiedBy, &hb.Trust, &hb.Backfill, &hb.DeviceID, &hb.Connectivity, &hb.Landmark, &hb.DuplicateOf, &hb.HighFrequency, &hb.Coarse,
 		)
 		if err != nil {
 			return nil, err
@@ -174,58 +323,274 @@ func (db *PostgresDB) GetHeartbeatsSince(ctx context.Context, userID uuid.UUID,
 	return heartbeats, nil
 }
 
-// LastGasp operations
-func (db *PostgresDB) CreateLastGasp(ctx context.Context, lg *models.LastGasp) error {
+// GetRecentHeartbeats returns the latest heartbeats from one of a user's
+// devices, newest first. An empty deviceID selects those sent without one.
+func (db *PostgresDB) GetRecentHeartbeats(ctx context.Context, userID uuid.UUID, deviceID string, limit int) ([]models.Heartbeat, error) {
 	query := `
-		INSERT INTO last_gasps (id, user_id, lat, lng, accuracy_m, cell_info, created_at, expiry_ts)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		SELECT id, user_id, source, lat, lng, accuracy_m, cell_info, battery_pct, speed, last_gasp, timestamp, signature, created_at, is_mock, spoof_suspected, spoof_reasons, identified_by, trust_level, backfill, COALESCE(device_id, ''), COALESCE(connectivity, ''), COALESCE(landmark, ''), duplicate_of, high_frequency, coarse
+		FROM heartbeats
+		WHERE user_id = $1 AND device_id IS NOT DISTINCT FROM NULLIF($2, '') AND duplicate_of IS NULL
+		ORDER BY timestamp DESC
+		LIMIT $3
 	`
-	_, err := db.pool.Exec(ctx, query,
-		lg.ID, lg.UserID, lg.Lat, lg.Lng, lg.AccuracyM,
-		lg.CellInfo, lg.CreatedAt, lg.ExpiryTs,
+	rows, err := db.pool.Query(ctx, query, userID, deviceID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var heartbeats []models.Heartbeat
+	for rows.Next() {
+		var hb models.Heartbeat
+		err := rows.Scan(
+			&hb.ID, &hb.UserID, &hb.Source, &hb.Lat, &hb.Lng, &hb.AccuracyM,
+			&hb.CellInfo, &hb.BatteryPct, &hb.Speed, &hb.LastGasp, &hb.Timestamp,
+			&hb.Signature, &hb.CreatedAt, &hb.IsMock, &hb.SpoofSuspected, &hb.SpoofReasons, &hb.IdentifiedBy, &hb.Trust, &hb.Backfill, &hb.DeviceID, &hb.Connectivity, &hb.Landmark, &hb.DuplicateOf, &hb.HighFrequency, &hb.Coarse,
+		)
+		if err != nil {
+			return nil, err
+		}
+		heartbeats = append(heartbeats, hb)
+	}
+	return heartbeats, rows.Err()
+}
+
+// GetHeartbeatsBetween returns a user's heartbeats in [from, to], oldest
+// first. Copies of a heartbeat sent over another channel are left out unless
+// includeDuplicates is set. Of the high-frequency heartbeats streamed during
+// an alert, only the first of each minute is returned.
+func (db *PostgresDB) GetHeartbeatsBetween(ctx context.Context, userID uuid.UUID, from, to time.Time, limit int, includeDuplicates bool) ([]models.Heartbeat, error) {
+	query := `
+		SELECT id, user_id, source, lat, lng, accuracy_m, cell_info, battery_pct, speed, last_gasp, timestamp, signature, created_at, is_mock, spoof_suspected, spoof_reasons, identified_by, trust_level, backfill, device_id, connectivity, landmark, duplicate_of, high_frequency, coarse
+		FROM (
+			SELECT id, user_id, source, lat, lng, accuracy_m, cell_info, battery_pct, speed, last_gasp, timestamp, signature, created_at, is_mock, spoof_suspected, spoof_reasons, identified_by, trust_level, backfill, COALESCE(device_id, '') AS device_id, COALESCE(connectivity, '') AS connectivity, COALESCE(landmark, '') AS landmark, duplicate_of, high_frequency, coarse,
+			       ROW_NUMBER() OVER (PARTITION BY high_frequency, date_trunc('minute', timestamp) ORDER BY timestamp) AS nth
+			FROM heartbeats
+			WHERE user_id = $1 AND timestamp BETWEEN $2 AND $3 AND ($5 OR duplicate_of IS NULL)
+		) h
+		WHERE NOT high_frequency OR nth = 1
+		ORDER BY timestamp ASC
+		LIMIT $4
+	`
+	rows, err := db.pool.Query(ctx, query, userID, from, to, limit, includeDuplicates)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var heartbeats []models.Heartbeat
+	for rows.Next() {
+		var hb models.Heartbeat
+		err := rows.Scan(
+			&hb.ID, &hb.UserID, &hb.Source, &hb.Lat, &hb.Lng, &hb.AccuracyM,
+			&hb.CellInfo, &hb.BatteryPct, &hb.Speed, &hb.LastGasp, &hb.Timestamp,
+			&hb.Signature, &hb.CreatedAt, &hb.IsMock, &hb.SpoofSuspected, &hb.SpoofReasons, &hb.IdentifiedBy, &hb.Trust, &hb.Backfill, &hb.DeviceID, &hb.Connectivity, &hb.Landmark, &hb.DuplicateOf, &hb.HighFrequency, &hb.Coarse,
+		)
+		if err != nil {
+			return nil, err
+		}
+		heartbeats = append(heartbeats, hb)
+	}
+	return heartbeats, rows.Err()
+}
+
+// CountHeartbeatsByTrust counts a user's heartbeats since a time, by trust level
+func (db *PostgresDB) CountHeartbeatsByTrust(ctx context.Context, userID uuid.UUID, since time.Time) (map[string]int, error) {
+	query := `
+		SELECT trust_level, COUNT(*)
+		FROM heartbeats
+		WHERE user_id = $1 AND created_at >= $2
+		GROUP BY trust_level
+	`
+	rows, err := db.pool.Query(ctx, query, userID, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int)
+	for rows.Next() {
+		var trust string
+		var n int
+		if err := rows.Scan(&trust, &n); err != nil {
+			return nil, err
+		}
+		counts[trust] = n
+	}
+	return counts, rows.Err()
+}
+
+// LastGasp operations
+
+const lastGaspColumns = `
+	id, user_id, lat, lng, accuracy_m, cell_info, created_at, expiry_ts, last_at, repeats, superseded_at
+`
+
+func scanLastGasp(row pgx.Row) (*models.LastGasp, error) {
+	var lg models.LastGasp
+	err := row.Scan(
+		&lg.ID, &lg.UserID, &lg.Lat, &lg.Lng, &lg.AccuracyM,
+		&lg.CellInfo, &lg.CreatedAt, &lg.ExpiryTs, &lg.LastAt, &lg.Repeats, &lg.SupersededAt,
 	)
-	return err
+	if err != nil {
+		return nil, err
+	}
+	return &lg, nil
+}
+
+// RecordLastGasp stores a LastGasp. If the user's latest one arrived within
+// rearm of lg, even if it was superseded since, it is re-armed instead: moved
+// to lg's position, its expiry pushed to lg's and its wait resumed. lg is
+// filled in with the stored row either way. The user's row is locked, so two
+// LastGasps arriving together can't both open one.
+func (db *PostgresDB) RecordLastGasp(ctx context.Context, lg *models.LastGasp, rearm time.Duration) error {
+	tx, err := db.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if err := lockUser(ctx, tx, lg.UserID); err != nil {
+		return err
+	}
+
+	if lg.LastAt.IsZero() {
+		lg.LastAt = lg.CreatedAt
+	}
+	query := `
+		UPDATE last_gasps
+		SET lat = $3, lng = $4, accuracy_m = $5, cell_info = $6, expiry_ts = $7,
+			last_at = $2, repeats = repeats + 1, superseded_at = NULL
+		WHERE id = (
+			SELECT id FROM last_gasps
+			WHERE user_id = $1 AND last_at > $8
+			ORDER BY last_at DESC
+			LIMIT 1
+		)
+		RETURNING ` + lastGaspColumns
+	stored, err := scanLastGasp(tx.QueryRow(ctx, query,
+		lg.UserID, lg.LastAt, lg.Lat, lg.Lng, lg.AccuracyM, lg.CellInfo, lg.ExpiryTs, lg.LastAt.Add(-rearm),
+	))
+	if err == nil {
+		*lg = *stored
+	} else if err == pgx.ErrNoRows {
+		_, err = tx.Exec(ctx, `
+			INSERT INTO last_gasps (id, user_id, lat, lng, accuracy_m, cell_info, created_at, expiry_ts, last_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		`, lg.ID, lg.UserID, lg.Lat, lg.Lng, lg.AccuracyM, lg.CellInfo, lg.CreatedAt, lg.ExpiryTs, lg.LastAt)
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// SupersedeLastGasp ends the user's open LastGasp wait because a normal
+// heartbeat arrived at at. It reports whether one was open.
+func (db *PostgresDB) SupersedeLastGasp(ctx context.Context, userID uuid.UUID, at time.Time) (bool, error) {
+	tag, err := db.pool.Exec(ctx, `
+		UPDATE last_gasps
+		SET superseded_at = $2
+		WHERE user_id = $1 AND superseded_at IS NULL AND last_at < $2 AND expiry_ts > $2
+	`, userID, at)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
 }
 
+// GetActiveLastGasp returns the user's LastGasp that has neither expired nor
+// been superseded, or nil
 func (db *PostgresDB) GetActiveLastGasp(ctx context.Context, userID uuid.UUID) (*models.LastGasp, error) {
 	query := `
-		SELECT id, user_id, lat, lng, accuracy_m, cell_info, created_at, expiry_ts
+		SELECT ` + lastGaspColumns + `
 		FROM last_gasps
-		WHERE user_id = $1 AND expiry_ts > NOW()
+		WHERE user_id = $1 AND expiry_ts > NOW() AND superseded_at IS NULL
+		ORDER BY last_at DESC
+		LIMIT 1
+	`
+	lg, err := scanLastGasp(db.pool.QueryRow(ctx, query, userID))
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	return lg, err
+}
+
+// GetLastGaspAsOf returns the LastGasp that was being waited on at the given
+// time, or nil. Its superseded time is cleared if it came later. Re-arms
+// update a LastGasp in place, so one re-armed after that time comes back
+// with its later last_at and expiry.
+func (db *PostgresDB) GetLastGaspAsOf(ctx context.Context, userID uuid.UUID, at time.Time) (*models.LastGasp, error) {
+	query := `
+		SELECT ` + lastGaspColumns + `
+		FROM last_gasps
+		WHERE user_id = $1 AND created_at <= $2 AND expiry_ts > $2 AND (superseded_at IS NULL OR superseded_at > $2)
 		ORDER BY created_at DESC
 		LIMIT 1
 	`
-	var lg models.LastGasp
-	err := db.pool.QueryRow(ctx, query, userID).Scan(
-		&lg.ID, &lg.UserID, &lg.Lat, &lg.Lng, &lg.AccuracyM,
-		&lg.CellInfo, &lg.CreatedAt, &lg.ExpiryTs,
-	)
+	lg, err := scanLastGasp(db.pool.QueryRow(ctx, query, userID, at))
 	if err == pgx.ErrNoRows {
 		return nil, nil
 	}
 	if err != nil {
 		return nil, err
 	}
-	return &lg, nil
+	lg.SupersededAt = nil
+	return lg, nil
+}
+
+func (db *PostgresDB) GetLastGaspHistory(ctx context.Context, userID uuid.UUID, limit, offset int) ([]models.LastGasp, int, error) {
+	var total int
+	if err := db.pool.QueryRow(ctx, `SELECT COUNT(*) FROM last_gasps WHERE user_id = $1`, userID).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	query := `
+		SELECT ` + lastGaspColumns + `
+		FROM last_gasps
+		WHERE user_id = $1
+		ORDER BY created_at DESC
+		LIMIT $2 OFFSET $3
+	`
+	rows, err := db.pool.Query(ctx, query, userID, limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var lastGasps []models.LastGasp
+	for rows.Next() {
+		lg, err := scanLastGasp(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		lastGasps = append(lastGasps, *lg)
+	}
+	return lastGasps, total, nil
 }
 
 // Alert operations
 func (db *PostgresDB) CreateAlert(ctx context.Context, alert *models.Alert) error {
 	query := `
-		INSERT INTO alerts (id, user_id, state, score, reason, sent_to, created_at)
-		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		INSERT INTO alerts (id, user_id, state, score, reason, reason_codes, sent_to, duress, created_at, detected_at, note)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, NULLIF($11, ''))
 	`
 	sentToJSON, _ := models.StringArray(alert.SentTo).Value()
+	var detectedAt *time.Time
+	if !alert.DetectedAt.IsZero() {
+		detectedAt = &alert.DetectedAt
+	}
 	_, err := db.pool.Exec(ctx, query,
-		alert.ID, alert.UserID, alert.State, alert.Score, alert.Reason,
-		sentToJSON, alert.CreatedAt,
+		alert.ID, alert.UserID, alert.State, alert.Score, alert.Reason, alert.Reasons,
+		sentToJSON, alert.Duress, alert.CreatedAt, detectedAt, alert.Note,
 	)
 	return err
 }
 
 func (db *PostgresDB) GetLatestAlert(ctx context.Context, userID uuid.UUID) (*models.Alert, error) {
 	query := `
-		SELECT id, user_id, state, score, reason, sent_to, created_at, resolved_at
+		SELECT id, user_id, state, score, reason, reason_codes, sent_to, plus_code, what3words, duress, created_at, resolved_at,
+		       COALESCE(resolution, ''), COALESCE(undeliverable, ''), COALESCE(note, '')
 		FROM alerts
 		WHERE user_id = $1
 		ORDER BY created_at DESC
@@ -234,8 +599,9 @@ func (db *PostgresDB) GetLatestAlert(ctx context.Context, userID uuid.UUID) (*mo
 	var alert models.Alert
 	var sentTo models.StringArray
 	err := db.pool.QueryRow(ctx, query, userID).Scan(
-		&alert.ID, &alert.UserID, &alert.State, &alert.Score, &alert.Reason,
-		&sentTo, &alert.CreatedAt, &alert.ResolvedAt,
+		&alert.ID, &alert.UserID, &alert.State, &alert.Score, &alert.Reason, &alert.Reasons,
+		&sentTo, &alert.PlusCode, &alert.What3Words, &alert.Duress, &alert.CreatedAt, &alert.ResolvedAt,
+		&alert.Resolution, &alert.Undeliverable, &alert.Note,
 	)
 	if err == pgx.ErrNoRows {
 		return nil, nil
@@ -247,28 +613,295 @@ func (db *PostgresDB) GetLatestAlert(ctx context.Context, userID uuid.UUID) (*mo
 	return &alert, nil
 }
 
+// GetAlertsForUser returns a page of the user's alerts, newest first, and the total count
+func (db *PostgresDB) GetAlertsForUser(ctx context.Context, userID uuid.UUID, limit, offset int) ([]models.Alert, int, error) {
+	var total int
+	if err := db.pool.QueryRow(ctx, `SELECT COUNT(*) FROM alerts WHERE user_id = $1`, userID).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	query := `
+		SELECT id, user_id, state, score, reason, reason_codes, sent_to, plus_code, what3words, duress, created_at, resolved_at,
+		       COALESCE(resolution, ''), COALESCE(undeliverable, ''), COALESCE(note, '')
+		FROM alerts
+		WHERE user_id = $1
+		ORDER BY created_at DESC
+		LIMIT $2 OFFSET $3
+	`
+	rows, err := db.pool.Query(ctx, query, userID, limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var alerts []models.Alert
+	for rows.Next() {
+		var alert models.Alert
+		var sentTo models.StringArray
+		err := rows.Scan(
+			&alert.ID, &alert.UserID, &alert.State, &alert.Score, &alert.Reason, &alert.Reasons,
+			&sentTo, &alert.PlusCode, &alert.What3Words, &alert.Duress, &alert.CreatedAt, &alert.ResolvedAt,
+			&alert.Resolution, &alert.Undeliverable, &alert.Note,
+		)
+		if err != nil {
+			return nil, 0, err
+		}
+		alert.SentTo = sentTo
+		alerts = append(alerts, alert)
+	}
+	return alerts, total, rows.Err()
+}
+
+// SetAlertPlusCode records the plus code of where the alert was raised
+func (db *PostgresDB) SetAlertPlusCode(ctx context.Context, alertID uuid.UUID, code string) error {
+	_, err := db.pool.Exec(ctx, `UPDATE alerts SET plus_code = $2 WHERE id = $1`, alertID, code)
+	return err
+}
+
+// SetAlertWhat3Words records the what3words address of where the alert was raised
+func (db *PostgresDB) SetAlertWhat3Words(ctx context.Context, alertID uuid.UUID, words string) error {
+	_, err := db.pool.Exec(ctx, `UPDATE alerts SET what3words = $2 WHERE id = $1`, alertID, words)
+	return err
+}
+
+// GetAlertMapSnapshot returns the object key of the alert's map snapshot, or "" if it has none
+func (db *PostgresDB) GetAlertMapSnapshot(ctx context.Context, alertID uuid.UUID) (string, error) {
+	var key *string
+	err := db.pool.QueryRow(ctx, `SELECT map_snapshot_key FROM alerts WHERE id = $1`, alertID).Scan(&key)
+	if err == pgx.ErrNoRows || key == nil {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return *key, nil
+}
+
+// SetAlertMapSnapshot records the object key of the alert's map snapshot
+func (db *PostgresDB) SetAlertMapSnapshot(ctx context.Context, alertID uuid.UUID, key string, createdAt time.Time) error {
+	_, err := db.pool.Exec(ctx, `UPDATE alerts SET map_snapshot_key = $2, map_snapshot_at = $3 WHERE id = $1`, alertID, key, createdAt)
+	return err
+}
+
+// GetExpiredAlertMapSnapshots returns, by alert ID, the map snapshot keys of
+// alerts resolved before the given time, at most limit of them
+func (db *PostgresDB) GetExpiredAlertMapSnapshots(ctx context.Context, resolvedBefore time.Time, limit int) (map[uuid.UUID]string, error) {
+	query := `
+		SELECT id, map_snapshot_key
+		FROM alerts
+		WHERE map_snapshot_key IS NOT NULL AND resolved_at < $1
+		ORDER BY resolved_at
+		LIMIT $2
+	`
+	rows, err := db.pool.Query(ctx, query, resolvedBefore, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	snapshots := make(map[uuid.UUID]string)
+	for rows.Next() {
+		var id uuid.UUID
+		var key string
+		if err := rows.Scan(&id, &key); err != nil {
+			return nil, err
+		}
+		snapshots[id] = key
+	}
+	return snapshots, rows.Err()
+}
+
+// ClearAlertMapSnapshot forgets the alert's map snapshot once it is deleted
+func (db *PostgresDB) ClearAlertMapSnapshot(ctx context.Context, alertID uuid.UUID) error {
+	_, err := db.pool.Exec(ctx, `UPDATE alerts SET map_snapshot_key = NULL, map_snapshot_at = NULL WHERE id = $1`, alertID)
+	return err
+}
+
 func (db *PostgresDB) ResolveAlert(ctx context.Context, alertID uuid.UUID) error {
-	query := `UPDATE alerts SET resolved_at = NOW() WHERE id = $1`
-	_, err := db.pool.Exec(ctx, query, alertID)
+	query := `UPDATE alerts SET resolved_at = NOW(), resolution = $2 WHERE id = $1`
+	_, err := db.pool.Exec(ctx, query, alertID, models.AlertResolvedManual)
+	return err
+}
+
+// AutoResolveAlert resolves the alert automatically unless it is already
+// resolved, and reports whether it did
+func (db *PostgresDB) AutoResolveAlert(ctx context.Context, alertID uuid.UUID, at time.Time) (bool, error) {
+	query := `UPDATE alerts SET resolved_at = $2, resolution = $3 WHERE id = $1 AND resolved_at IS NULL`
+	tag, err := db.pool.Exec(ctx, query, alertID, at, models.AlertResolvedAuto)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+// StreamAlertLocations calls fn for each alert matching the filter, oldest
+// first, with the position of the user's latest heartbeat at or before it.
+// Rows are read one at a time so exports don't load every alert into memory.
+func (db *PostgresDB) StreamAlertLocations(ctx context.Context, filter models.AlertExportFilter, fn func(*models.AlertLocation) error) error {
+	states := make([]string, 0, len(filter.States))
+	for _, s := range filter.States {
+		states = append(states, string(s))
+	}
+
+	query := `
+		SELECT a.id, a.user_id, a.state, a.score, a.reason, a.plus_code, a.what3words, a.created_at, a.resolved_at,
+		       hb.lat, hb.lng, hb.timestamp
+		FROM alerts a
+		LEFT JOIN LATERAL (
+			SELECT lat, lng, timestamp FROM heartbeats
+			WHERE user_id = a.user_id AND timestamp <= a.created_at
+			ORDER BY timestamp DESC
+			LIMIT 1
+		) hb ON true
+		WHERE a.created_at BETWEEN $1 AND $2
+		  AND (cardinality($3::text[]) = 0 OR a.state = ANY($3))
+		  AND ($4::uuid IS NULL OR a.user_id IN (SELECT id FROM users WHERE org_id = $4))
+		ORDER BY a.created_at ASC
+	`
+	rows, err := db.pool.Query(ctx, query, filter.From, filter.To, states, filter.OrgID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var loc models.AlertLocation
+		err := rows.Scan(
+			&loc.ID, &loc.UserID, &loc.State, &loc.Score, &loc.Reason, &loc.PlusCode, &loc.What3Words, &loc.CreatedAt, &loc.ResolvedAt,
+			&loc.Lat, &loc.Lng, &loc.LocatedAt,
+		)
+		if err != nil {
+			return err
+		}
+		if err := fn(&loc); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// GetHeatmapSamples bins the alerts and LastGasps raised between from and to
+// into geohash cells of the given length, per user. Alerts are placed at the
+// user's last known position when raised; events without a fix are left out.
+// A non-nil orgID keeps only the organization's current members.
+func (db *PostgresDB) GetHeatmapSamples(ctx context.Context, from, to time.Time, precision int, orgID *uuid.UUID) ([]models.HeatmapSample, error) {
+	query := `
+		WITH events AS (
+			SELECT a.user_id, hb.lat, hb.lng, 1 AS alerts, 0 AS last_gasps
+			FROM alerts a
+			JOIN LATERAL (
+				SELECT lat, lng FROM heartbeats
+				WHERE user_id = a.user_id AND timestamp <= a.created_at
+				ORDER BY timestamp DESC
+				LIMIT 1
+			) hb ON true
+			WHERE a.created_at BETWEEN $1 AND $2
+			UNION ALL
+			SELECT user_id, lat, lng, 0, 1
+			FROM last_gasps
+			WHERE created_at BETWEEN $1 AND $2
+		)
+		SELECT geohash_encode(lat, lng, $3) AS cell, user_id, SUM(alerts), SUM(last_gasps)
+		FROM events
+		WHERE NOT (lat = 0 AND lng = 0)
+		  AND ($4::uuid IS NULL OR user_id IN (SELECT id FROM users WHERE org_id = $4))
+		GROUP BY cell, user_id
+	`
+	rows, err := db.pool.Query(ctx, query, from, to, precision, orgID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var samples []models.HeatmapSample
+	for rows.Next() {
+		var s models.HeatmapSample
+		if err := rows.Scan(&s.Geohash, &s.UserID, &s.Alerts, &s.LastGasps); err != nil {
+			return nil, err
+		}
+		samples = append(samples, s)
+	}
+	return samples, rows.Err()
+}
+
+// UpgradeAlert raises an open alert to a more severe state with new reasons
+func (db *PostgresDB) UpgradeAlert(ctx context.Context, alertID uuid.UUID, state models.AlertState, reason string, reasons models.Reasons) error {
+	query := `UPDATE alerts SET state = $2, reason = $3, reason_codes = $4 WHERE id = $1 AND resolved_at IS NULL`
+	_, err := db.pool.Exec(ctx, query, alertID, state, reason, reasons)
+	return err
+}
+
+// Alert delivery operations
+func (db *PostgresDB) CreateAlertDelivery(ctx context.Context, d *models.AlertDelivery) error {
+	query := `
+		INSERT INTO alert_deliveries (
+			id, alert_id, contact_id, contact_name, phone, channel, status, detail, created_at,
+			provider, provider_message_id
+		)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, NULLIF($10, ''), NULLIF($11, ''))
+	`
+	_, err := db.pool.Exec(ctx, query,
+		d.ID, d.AlertID, d.ContactID, d.ContactName, d.Phone,
+		d.Channel, d.Status, d.Detail, d.CreatedAt,
+		d.Provider, d.ProviderMessageID,
+	)
 	return err
 }
 
+func (db *PostgresDB) GetAlertDeliveries(ctx context.Context, alertID uuid.UUID) ([]models.AlertDelivery, error) {
+	query := `
+		SELECT id, alert_id, contact_id, contact_name, phone, channel, status, detail, created_at,
+			COALESCE(provider, ''), COALESCE(provider_message_id, '')
+		FROM alert_deliveries
+		WHERE alert_id = $1
+		ORDER BY created_at ASC
+	`
+	rows, err := db.pool.Query(ctx, query, alertID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deliveries []models.AlertDelivery
+	for rows.Next() {
+		var d models.AlertDelivery
+		err := rows.Scan(
+			&d.ID, &d.AlertID, &d.ContactID, &d.ContactName, &d.Phone,
+			&d.Channel, &d.Status, &d.Detail, &d.CreatedAt,
+			&d.Provider, &d.ProviderMessageID,
+		)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, nil
+}
+
 // Blackbox operations
 func (db *PostgresDB) CreateBlackboxTrail(ctx context.Context, trail *models.BlackboxTrail) error {
 	query := `
-		INSERT INTO blackbox_trails (id, user_id, start_ts, end_ts, data_points, file_url, uploaded_at)
-		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		INSERT INTO blackbox_trails (
+			id, user_id, start_ts, end_ts, data_points, file_url, uploaded_at,
+			integrity_status, chain_head, chain_break_index,
+			rejected_points, quarantine_url
+		)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, NULLIF($9, ''), $10, $11, NULLIF($12, ''))
 	`
 	_, err := db.pool.Exec(ctx, query,
 		trail.ID, trail.UserID, trail.StartTs, trail.EndTs,
 		trail.DataPoints, trail.FileURL, trail.UploadedAt,
+		trail.IntegrityStatus, trail.ChainHead, trail.ChainBreakIndex,
+		trail.RejectedPoints, trail.QuarantineURL,
 	)
 	return err
 }
 
 func (db *PostgresDB) GetBlackboxTrails(ctx context.Context, userID uuid.UUID, limit int) ([]models.BlackboxTrail, error) {
 	query := `
-		SELECT id, user_id, start_ts, end_ts, data_points, file_url, uploaded_at
+		SELECT id, user_id, start_ts, end_ts, data_points, file_url, uploaded_at,
+			integrity_status, COALESCE(chain_head, ''), chain_break_index,
+			rejected_points, COALESCE(quarantine_url, '')
 		FROM blackbox_trails
 		WHERE user_id = $1
 		ORDER BY uploaded_at DESC
@@ -286,6 +919,8 @@ func (db *PostgresDB) GetBlackboxTrails(ctx context.Context, userID uuid.UUID, l
 		err := rows.Scan(
 			&trail.ID, &trail.UserID, &trail.StartTs, &trail.EndTs,
 			&trail.DataPoints, &trail.FileURL, &trail.UploadedAt,
+			&trail.IntegrityStatus, &trail.ChainHead, &trail.ChainBreakIndex,
+			&trail.RejectedPoints, &trail.QuarantineURL,
 		)
 		if err != nil {
 			return nil, err
@@ -295,106 +930,100 @@ func (db *PostgresDB) GetBlackboxTrails(ctx context.Context, userID uuid.UUID, l
 	return trails, nil
 }
 
-// Contact management operations
-func (db *PostgresDB) AddContact(ctx context.Context, userID uuid.UUID, contact map[string]string) error {
-	// Convert map to Contact struct
-	newContact := models.Contact{
-		ID:    contact["id"],
-		Name:  contact["name"],
-		Phone: contact["phone"],
-	}
+// GetBlackboxTrailsVersion summarizes what the user's trail listing depends
+// on: how many trails there are, the newest upload and the newest move of a
+// trail to object storage (which changes its file_url). The times are nil
+// when there is none.
+func (db *PostgresDB) GetBlackboxTrailsVersion(ctx context.Context, userID uuid.UUID) (int, *time.Time, *time.Time, error) {
+	query := `
+		SELECT COUNT(*), MAX(t.uploaded_at), MAX(m.updated_at)
+		FROM blackbox_trails t
+		LEFT JOIN blackbox_trail_migrations m ON m.trail_id = t.id AND m.status = 'done'
+		WHERE t.user_id = $1
+	`
+	var count int
+	var uploaded, migrated *time.Time
+	err := db.pool.QueryRow(ctx, query, userID).Scan(&count, &uploaded, &migrated)
+	return count, uploaded, migrated, err
+}
 
+func (db *PostgresDB) GetBlackboxTrail(ctx context.Context, id uuid.UUID) (*models.BlackboxTrail, error) {
 	query := `
-		UPDATE users
-		SET trusted_contacts = trusted_contacts || $1::jsonb,
-			updated_at = NOW()
-		WHERE id = $2
+		SELECT id, user_id, start_ts, end_ts, data_points, file_url, uploaded_at,
+			integrity_status, COALESCE(chain_head, ''), chain_break_index,
+			rejected_points, COALESCE(quarantine_url, '')
+		FROM blackbox_trails
+		WHERE id = $1
 	`
-	contactJSON, err := json.Marshal([]models.Contact{newContact})
+	var trail models.BlackboxTrail
+	err := db.pool.QueryRow(ctx, query, id).Scan(
+		&trail.ID, &trail.UserID, &trail.StartTs, &trail.EndTs,
+		&trail.DataPoints, &trail.FileURL, &trail.UploadedAt,
+		&trail.IntegrityStatus, &trail.ChainHead, &trail.ChainBreakIndex,
+		&trail.RejectedPoints, &trail.QuarantineURL,
+	)
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
 	if err != nil {
-		return err
+		return nil, err
 	}
-
-	_, err = db.pool.Exec(ctx, query, contactJSON, userID)
-	return err
+	return &trail, nil
 }
 
-func (db *PostgresDB) UpdateContact(ctx context.Context, userID uuid.UUID, contactID string, updates map[string]string) error {
-	// Get current contacts
-	user, err := db.GetUserByID(ctx, userID)
+// ClaimBlackboxTrailImpactCheck marks the trail as analyzed for impacts and
+// reports whether this caller claimed it; false means it was already analyzed
+func (db *PostgresDB) ClaimBlackboxTrailImpactCheck(ctx context.Context, id uuid.UUID) (bool, error) {
+	query := `
+		UPDATE blackbox_trails SET impact_checked_at = NOW()
+		WHERE id = $1 AND impact_checked_at IS NULL
+	`
+	tag, err := db.pool.Exec(ctx, query, id)
 	if err != nil {
-		return err
-	}
-
-	// Update the contact in the array
-	updated := false
-	for i, contact := range user.TrustedContacts {
-		if contact.ID == contactID {
-			if name, ok := updates["name"]; ok && name != "" {
-				user.TrustedContacts[i].Name = name
-			}
-			if phone, ok := updates["phone"]; ok && phone != "" {
-				user.TrustedContacts[i].Phone = phone
-			}
-			updated = true
-			break
-		}
-	}
-
-	if !updated {
-		return fmt.Errorf("contact not found")
+		return false, err
 	}
+	return tag.RowsAffected() == 1, nil
+}
 
-	// Save back to database
-	contactsJSON, err := json.Marshal(user.TrustedContacts)
-	if err != nil {
-		return err
-	}
+// ReleaseBlackboxTrailImpactCheck undoes a claim after a failed analysis so it can be retried
+func (db *PostgresDB) ReleaseBlackboxTrailImpactCheck(ctx context.Context, id uuid.UUID) error {
+	_, err := db.pool.Exec(ctx, `UPDATE blackbox_trails SET impact_checked_at = NULL WHERE id = $1`, id)
+	return err
+}
 
-	query := `
-		UPDATE users
-		SET trusted_contacts = $1::jsonb,
-			updated_at = NOW()
-		WHERE id = $2
-	`
-	_, err = db.pool.Exec(ctx, query, contactsJSON, userID)
+// SetBlackboxTrailImpact records when an impact was found in the trail
+func (db *PostgresDB) SetBlackboxTrailImpact(ctx context.Context, id uuid.UUID, at time.Time) error {
+	_, err := db.pool.Exec(ctx, `UPDATE blackbox_trails SET impact_at = $2 WHERE id = $1`, id, at)
 	return err
 }
 
-func (db *PostgresDB) DeleteContact(ctx context.Context, userID uuid.UUID, contactID string) error {
-	// Get current contacts
-	user, err := db.GetUserByID(ctx, userID)
+// GetUncheckedBlackboxTrailIDs returns trails uploaded since the given time
+// that have not been analyzed for impacts, oldest first
+func (db *PostgresDB) GetUncheckedBlackboxTrailIDs(ctx context.Context, since time.Time, limit int) ([]uuid.UUID, error) {
+	query := `
+		SELECT id FROM blackbox_trails
+		WHERE impact_checked_at IS NULL AND uploaded_at >= $1
+		ORDER BY uploaded_at ASC
+		LIMIT $2
+	`
+	rows, err := db.pool.Query(ctx, query, since, limit)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	defer rows.Close()
 
-	// Filter out the contact to delete
-	newContacts := make([]models.Contact, 0)
-	found := false
-	for _, contact := range user.TrustedContacts {
-		if contact.ID != contactID {
-			newContacts = append(newContacts, contact)
-		} else {
-			found = true
+	var ids []uuid.UUID
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
 		}
+		ids = append(ids, id)
 	}
+	return ids, rows.Err()
+}
 
-	if !found {
-		return fmt.Errorf("contact not found")
-	}
-
-	// Save back to database
-	contactsJSON, err := json.Marshal(newContacts)
-	if err != nil {
-		return err
-	}
-
-	query := `
-		UPDATE users
-		SET trusted_contacts = $1::jsonb,
-			updated_at = NOW()
-		WHERE id = $2
-	`
-	_, err = db.pool.Exec(ctx, query, contactsJSON, userID)
-	return err
+// Ping checks that a connection can be acquired and used
+func (db *PostgresDB) Ping(ctx context.Context) error {
+	return db.pool.Ping(ctx)
 }
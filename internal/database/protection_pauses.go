@@ -0,0 +1,144 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+const protectionPauseColumns = `
+	id, user_id, reason, paused_at, paused_until, resumed_at, resumed_by
+`
+
+func scanProtectionPause(row pgx.Row) (*models.ProtectionPause, error) {
+	var p models.ProtectionPause
+	err := row.Scan(
+		&p.ID, &p.UserID, &p.Reason, &p.PausedAt, &p.PausedUntil, &p.ResumedAt, &p.ResumedBy,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// Protection pause operations
+
+// UpsertProtectionPause opens a pause, or moves the end and reason of the
+// user's open pause if they already have one. The stored pause is returned.
+func (db *PostgresDB) UpsertProtectionPause(ctx context.Context, p *models.ProtectionPause) (*models.ProtectionPause, error) {
+	query := `
+		INSERT INTO protection_pauses (id, user_id, reason, paused_at, paused_until)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (user_id) WHERE resumed_at IS NULL
+		DO UPDATE SET reason = EXCLUDED.reason, paused_until = EXCLUDED.paused_until
+		RETURNING ` + protectionPauseColumns
+	return scanProtectionPause(db.pool.QueryRow(ctx, query,
+		p.ID, p.UserID, p.Reason, p.PausedAt, p.PausedUntil,
+	))
+}
+
+// GetActiveProtectionPause returns the user's open pause that has not yet
+// lapsed at now, or nil
+func (db *PostgresDB) GetActiveProtectionPause(ctx context.Context, userID uuid.UUID, now time.Time) (*models.ProtectionPause, error) {
+	query := `
+		SELECT ` + protectionPauseColumns + `
+		FROM protection_pauses
+		WHERE user_id = $1 AND resumed_at IS NULL AND paused_until > $2
+	`
+	p, err := scanProtectionPause(db.pool.QueryRow(ctx, query, userID, now))
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	return p, err
+}
+
+// GetProtectionPauseAt returns the pause the user was in at the given time, or nil
+func (db *PostgresDB) GetProtectionPauseAt(ctx context.Context, userID uuid.UUID, at time.Time) (*models.ProtectionPause, error) {
+	query := `
+		SELECT ` + protectionPauseColumns + `
+		FROM protection_pauses
+		WHERE user_id = $1 AND paused_at <= $2 AND paused_until > $2 AND (resumed_at IS NULL OR resumed_at > $2)
+		ORDER BY paused_at DESC
+		LIMIT 1
+	`
+	p, err := scanProtectionPause(db.pool.QueryRow(ctx, query, userID, at))
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	return p, err
+}
+
+// ResumeProtectionPause closes the user's open pause and returns it, or nil
+// if there was none
+func (db *PostgresDB) ResumeProtectionPause(ctx context.Context, userID uuid.UUID, by string) (*models.ProtectionPause, error) {
+	query := `
+		UPDATE protection_pauses
+		SET resumed_at = NOW(), resumed_by = $2
+		WHERE user_id = $1 AND resumed_at IS NULL
+		RETURNING ` + protectionPauseColumns
+	p, err := scanProtectionPause(db.pool.QueryRow(ctx, query, userID, by))
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	return p, err
+}
+
+// ResumeLapsedProtectionPauses closes up to limit open pauses whose end has
+// passed and returns them. Concurrent callers never close the same pause.
+func (db *PostgresDB) ResumeLapsedProtectionPauses(ctx context.Context, now time.Time, limit int) ([]models.ProtectionPause, error) {
+	query := `
+		UPDATE protection_pauses
+		SET resumed_at = paused_until, resumed_by = 'expiry'
+		WHERE id IN (
+			SELECT id FROM protection_pauses
+			WHERE resumed_at IS NULL AND paused_until <= $1
+			ORDER BY paused_until
+			LIMIT $2
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING ` + protectionPauseColumns
+	rows, err := db.pool.Query(ctx, query, now, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var pauses []models.ProtectionPause
+	for rows.Next() {
+		p, err := scanProtectionPause(rows)
+		if err != nil {
+			return nil, err
+		}
+		pauses = append(pauses, *p)
+	}
+	return pauses, rows.Err()
+}
+
+// GetProtectionPauses returns the user's most recent pauses, newest first
+func (db *PostgresDB) GetProtectionPauses(ctx context.Context, userID uuid.UUID, limit int) ([]models.ProtectionPause, error) {
+	query := `
+		SELECT ` + protectionPauseColumns + `
+		FROM protection_pauses
+		WHERE user_id = $1
+		ORDER BY paused_at DESC
+		LIMIT $2
+	`
+	rows, err := db.pool.Query(ctx, query, userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var pauses []models.ProtectionPause
+	for rows.Next() {
+		p, err := scanProtectionPause(rows)
+		if err != nil {
+			return nil, err
+		}
+		pauses = append(pauses, *p)
+	}
+	return pauses, rows.Err()
+}
@@ -0,0 +1,57 @@
+package database
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// Public status token operations
+
+// GetPublicStatusToken returns the user's public status token, or nil if they have none
+func (db *PostgresDB) GetPublicStatusToken(ctx context.Context, userID uuid.UUID) (*string, error) {
+	var token *string
+	err := db.pool.QueryRow(ctx, `SELECT public_status_token FROM users WHERE id = $1`, userID).Scan(&token)
+	if err == pgx.ErrNoRows {
+		return nil, ErrUserNotFound
+	}
+	return token, err
+}
+
+// SetPublicStatusToken replaces the user's public status token, clearing it
+// when token is nil, and returns the one it replaced
+func (db *PostgresDB) SetPublicStatusToken(ctx context.Context, userID uuid.UUID, token *string) (*string, error) {
+	query := `
+		UPDATE users u
+		SET public_status_token = $2
+		FROM (SELECT id, public_status_token FROM users WHERE id = $1 FOR UPDATE) old
+		WHERE u.id = old.id
+		RETURNING old.public_status_token
+	`
+	var previous *string
+	err := db.pool.QueryRow(ctx, query, userID, token).Scan(&previous)
+	if err == pgx.ErrNoRows {
+		return nil, ErrUserNotFound
+	}
+	return previous, err
+}
+
+// GetUserIDByPublicStatusToken returns the user the token belongs to, or nil
+// if it is unknown or the user has turned the public status off
+func (db *PostgresDB) GetUserIDByPublicStatusToken(ctx context.Context, token string) (*uuid.UUID, error) {
+	query := `
+		SELECT id FROM users
+		WHERE public_status_token = $1
+		  AND COALESCE((settings->>'public_status')::boolean, false)
+	`
+	var userID uuid.UUID
+	err := db.pool.QueryRow(ctx, query, token).Scan(&userID)
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &userID, nil
+}
@@ -3,19 +3,27 @@ package database
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/redis/go-redis/v9"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/keys"
 	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
 )
 
 type RedisDB struct {
 	client *redis.Client
+	keys   keys.Registry
 }
 
-func NewRedisDB(redisURL string) (*RedisDB, error) {
+// NewRedisDB connects to Redis and checks the connection within ctx. Every
+// key it uses is under namespace, so deployments can share a Redis.
+func NewRedisDB(ctx context.Context, redisURL, namespace string) (*RedisDB, error) {
 	opts, err := redis.ParseURL(redisURL)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse redis URL: %w", err)
@@ -24,32 +32,99 @@ func NewRedisDB(redisURL string) (*RedisDB, error) {
 	client := redis.NewClient(opts)
 
 	// Test connection
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-	
 	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
 		return nil, fmt.Errorf("failed to ping redis: %w", err)
 	}
 
-	return &RedisDB{client: client}, nil
+	return &RedisDB{client: client, keys: keys.New(namespace)}, nil
 }
 
 func (r *RedisDB) Close() error {
 	return r.client.Close()
 }
 
+// ErrStateChanged is returned by SaveUserState when another writer saved the
+// user's state after the caller read the one it built on
+var ErrStateChanged = errors.New("user state changed since it was read")
+
 // User state operations
-func (r *RedisDB) SetUserState(ctx context.Context, state *models.UserState) error {
-	key := fmt.Sprintf("user:state:%s", state.UserID)
-	data, err := json.Marshal(state)
+
+const userStateTTL = 24 * time.Hour
+
+// stateWatchAttempts bounds how often a state write is retried after the
+// key changed between its check and its write
+const stateWatchAttempts = 3
+
+// SaveUserState caches state as the user's state if the cached one is still
+// at state.Revision, the revision state was built on, or there is none, and
+// advances state.Revision. It fails with ErrStateChanged, changing nothing,
+// if another writer saved the state in between.
+func (r *RedisDB) SaveUserState(ctx context.Context, state *models.UserState) error {
+	next := *state
+	next.Revision++
+	data, err := encodePayload(&next)
 	if err != nil {
 		return err
 	}
-	return r.client.Set(ctx, key, data, 24*time.Hour).Err()
+	err = r.watchUserState(ctx, state.UserID, state.Revision, func(pipe redis.Pipeliner, key string) {
+		pipe.Set(ctx, key, data, userStateTTL)
+	})
+	if err != nil {
+		return err
+	}
+	state.Revision = next.Revision
+	return nil
+}
+
+// DropUserState removes the user's cached state if it is still state, as
+// saved by SaveUserState, so the next read rebuilds it from Postgres. A state
+// saved since by someone else is left alone.
+func (r *RedisDB) DropUserState(ctx context.Context, state *models.UserState) error {
+	err := r.watchUserState(ctx, state.UserID, state.Revision, func(pipe redis.Pipeliner, key string) {
+		pipe.Del(ctx, key)
+	})
+	if err == ErrStateChanged {
+		return nil
+	}
+	return err
+}
+
+// watchUserState runs write in a transaction on the user's state key if the
+// cached state is at revision or missing, retrying while the key changes
+// under it; ErrStateChanged if the state moved on
+func (r *RedisDB) watchUserState(ctx context.Context, userID uuid.UUID, revision int64, write func(pipe redis.Pipeliner, key string)) error {
+	key := r.keys.UserState(userID)
+	check := func(tx *redis.Tx) error {
+		data, err := tx.Get(ctx, key).Bytes()
+		if err != nil && err != redis.Nil {
+			return err
+		}
+		// An unreadable state is overwritten rather than kept forever
+		var current struct {
+			Revision int64 `json:"revision"`
+		}
+		if err == nil && json.Unmarshal(data, &current) == nil && current.Revision != revision {
+			return ErrStateChanged
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			write(pipe, key)
+			return nil
+		})
+		return err
+	}
+
+	for attempt := 0; attempt < stateWatchAttempts; attempt++ {
+		err := r.client.Watch(ctx, check, key)
+		if err != redis.TxFailedErr {
+			return err
+		}
+	}
+	return ErrStateChanged
 }
 
 func (r *RedisDB) GetUserState(ctx context.Context, userID uuid.UUID) (*models.UserState, error) {
-	key := fmt.Sprintf("user:state:%s", userID)
+	key := r.keys.UserState(userID)
 	data, err := r.client.Get(ctx, key).Result()
 	if err == redis.Nil {
 		return nil, nil
@@ -59,16 +134,16 @@ func (r *RedisDB) GetUserState(ctx context.Context, userID uuid.UUID) (*models.U
 	}
 
 	var state models.UserState
-	if err := json.Unmarshal([]byte(data), &state); err != nil {
+	if err := decodePayload([]byte(data), &state); err != nil {
 		return nil, err
 	}
 	return &state, nil
 }
 
-// Rate limiting
-func (r *RedisDB) CheckRateLimit(ctx context.Context, userID uuid.UUID, window time.Duration, limit int) (bool, error) {
-	key := fmt.Sprintf("ratelimit:%s", userID)
-	
+// Rate limiting, per user and device. Heartbeats without a device share the user's limit.
+func (r *RedisDB) CheckRateLimit(ctx context.Context, userID uuid.UUID, deviceID string, window time.Duration, limit int) (bool, error) {
+	key := r.keys.RateLimit(userID, deviceID)
+
 	count, err := r.client.Incr(ctx, key).Result()
 	if err != nil {
 		return false, err
@@ -84,7 +159,7 @@ func (r *RedisDB) CheckRateLimit(ctx context.Context, userID uuid.UUID, window t
 
 // Alert deduplication
 func (r *RedisDB) CheckAlertSent(ctx context.Context, userID uuid.UUID, window time.Duration) (bool, error) {
-	key := fmt.Sprintf("alert:sent:%s", userID)
+	key := r.keys.AlertSent(userID)
 	exists, err := r.client.Exists(ctx, key).Result()
 	if err != nil {
 		return false, err
@@ -93,14 +168,14 @@ func (r *RedisDB) CheckAlertSent(ctx context.Context, userID uuid.UUID, window t
 }
 
 func (r *RedisDB) MarkAlertSent(ctx context.Context, userID uuid.UUID, window time.Duration) error {
-	key := fmt.Sprintf("alert:sent:%s", userID)
+	key := r.keys.AlertSent(userID)
 	return r.client.Set(ctx, key, "1", window).Err()
 }
 
 // Caching
 func (r *RedisDB) CacheUser(ctx context.Context, user *models.User, ttl time.Duration) error {
-	key := fmt.Sprintf("user:cache:%s", user.ID)
-	data, err := json.Marshal(user)
+	key := r.keys.UserCache(user.ID)
+	data, err := encodePayload(user)
 	if err != nil {
 		return err
 	}
@@ -108,7 +183,7 @@ func (r *RedisDB) CacheUser(ctx context.Context, user *models.User, ttl time.Dur
 }
 
 func (r *RedisDB) GetCachedUser(ctx context.Context, userID uuid.UUID) (*models.User, error) {
-	key := fmt.Sprintf("user:cache:%s", userID)
+	key := r.keys.UserCache(userID)
 	data, err := r.client.Get(ctx, key).Result()
 	if err == redis.Nil {
 		return nil, nil
@@ -118,8 +193,1522 @@ func (r *RedisDB) GetCachedUser(ctx context.Context, userID uuid.UUID) (*models.
 	}
 
 	var user models.User
-	if err := json.Unmarshal([]byte(data), &user); err != nil {
+	if err := decodePayload([]byte(data), &user); err != nil {
 		return nil, err
 	}
 	return &user, nil
 }
+
+// Heatmap cache. Only the published, already anonymized bins are cached,
+// per scope: "all" or the organization the heatmap is limited to.
+
+// GetCachedHeatmap returns the cached bins as stored, or nil on a cache miss
+func (r *RedisDB) GetCachedHeatmap(ctx context.Context, scope string, precision, minUsers int, from, to time.Time) ([]byte, error) {
+	data, err := r.client.Get(ctx, r.keys.Heatmap(scope, precision, minUsers, from, to)).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	return data, err
+}
+
+func (r *RedisDB) CacheHeatmap(ctx context.Context, scope string, precision, minUsers int, from, to time.Time, data []byte, ttl time.Duration) error {
+	return r.client.Set(ctx, r.keys.Heatmap(scope, precision, minUsers, from, to), data, ttl).Err()
+}
+
+// Public status cache, per token. Revoking a token overwrites its entry with
+// a tombstone, which a response computed meanwhile can't replace.
+
+// publicStatusRevoked is the tombstone of a revoked public status token
+const publicStatusRevoked = "revoked"
+
+// GetCachedPublicStatus returns the cached status, or nil on a cache miss.
+// revoked is set if the token was revoked within the tombstone's lifetime.
+func (r *RedisDB) GetCachedPublicStatus(ctx context.Context, token string) (data []byte, revoked bool, err error) {
+	data, err = r.client.Get(ctx, r.keys.PublicStatus(token)).Bytes()
+	if err == redis.Nil {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	if string(data) == publicStatusRevoked {
+		return nil, true, nil
+	}
+	return data, false, nil
+}
+
+// CachePublicStatus caches a token's status unless the token has an entry,
+// such as a tombstone, already
+func (r *RedisDB) CachePublicStatus(ctx context.Context, token string, data []byte, ttl time.Duration) error {
+	return r.client.SetNX(ctx, r.keys.PublicStatus(token), data, ttl).Err()
+}
+
+// RevokePublicStatus replaces a token's cached status with a tombstone
+func (r *RedisDB) RevokePublicStatus(ctx context.Context, token string, ttl time.Duration) error {
+	return r.client.Set(ctx, r.keys.PublicStatus(token), publicStatusRevoked, ttl).Err()
+}
+
+// what3words cache, keyed by position rounded to about a metre, well inside a 3m square
+
+// GetCachedWhat3Words returns the cached address, or "" on a cache miss
+func (r *RedisDB) GetCachedWhat3Words(ctx context.Context, lat, lng float64) (string, error) {
+	words, err := r.client.Get(ctx, r.keys.What3Words(lat, lng)).Result()
+	if err == redis.Nil {
+		return "", nil
+	}
+	return words, err
+}
+
+func (r *RedisDB) CacheWhat3Words(ctx context.Context, lat, lng float64, words string, ttl time.Duration) error {
+	return r.client.Set(ctx, r.keys.What3Words(lat, lng), words, ttl).Err()
+}
+
+// Place names are cached per ~100m cell; a name covers a neighborhood
+
+// GetCachedPlaceName returns the cached place name, or "" on a cache miss
+func (r *RedisDB) GetCachedPlaceName(ctx context.Context, lat, lng float64) (string, error) {
+	name, err := r.client.Get(ctx, r.keys.PlaceName(lat, lng)).Result()
+	if err == redis.Nil {
+		return "", nil
+	}
+	return name, err
+}
+
+func (r *RedisDB) CachePlaceName(ctx context.Context, lat, lng float64, name string, ttl time.Duration) error {
+	return r.client.Set(ctx, r.keys.PlaceName(lat, lng), name, ttl).Err()
+}
+
+// GetCachedAdminArea returns the cached code and name of the state or region
+// at a location; found is false on a cache miss. A location known to lie in
+// none, e.g. offshore, is found with both empty.
+func (r *RedisDB) GetCachedAdminArea(ctx context.Context, lat, lng float64) (code, name string, found bool, err error) {
+	value, err := r.client.Get(ctx, r.keys.AdminArea(lat, lng)).Result()
+	if err == redis.Nil {
+		return "", "", false, nil
+	}
+	if err != nil {
+		return "", "", false, err
+	}
+	code, name, _ = strings.Cut(value, "|")
+	return code, name, true, nil
+}
+
+func (r *RedisDB) CacheAdminArea(ctx context.Context, lat, lng float64, code, name string, ttl time.Duration) error {
+	return r.client.Set(ctx, r.keys.AdminArea(lat, lng), code+"|"+name, ttl).Err()
+}
+
+// SMS delivery tracking
+func (r *RedisDB) SaveSMSDelivery(ctx context.Context, delivery *models.SMSDelivery, ttl time.Duration) error {
+	key := r.keys.SMSDelivery(delivery.Provider, delivery.MessageID)
+	data, err := encodePayload(delivery)
+	if err != nil {
+		return err
+	}
+	return r.client.Set(ctx, key, data, ttl).Err()
+}
+
+func (r *RedisDB) GetSMSDelivery(ctx context.Context, provider, messageID string) (*models.SMSDelivery, error) {
+	key := r.keys.SMSDelivery(provider, messageID)
+	data, err := r.client.Get(ctx, key).Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var delivery models.SMSDelivery
+	if err := decodePayload([]byte(data), &delivery); err != nil {
+		return nil, err
+	}
+	return &delivery, nil
+}
+
+// ClaimSMSRetry marks the message's failed delivery retried for ttl, unless
+// it was already, and reports whether it marked it. A provider that reports
+// the failure twice, or to two instances, gets one retry.
+func (r *RedisDB) ClaimSMSRetry(ctx context.Context, provider, messageID string, ttl time.Duration) (bool, error) {
+	return r.client.SetNX(ctx, r.keys.SMSRetry(provider, messageID), "1", ttl).Result()
+}
+
+// Outbound SMS counted per user per day (keyed by the deployment's local
+// date), in a sorted set for every user and one per organization, each with
+// a running total
+const outboundSMSTTL = 8 * 24 * time.Hour
+
+// CountOutboundSMS adds n SMS sent on the user's behalf on day
+func (r *RedisDB) CountOutboundSMS(ctx context.Context, userID uuid.UUID, orgID *uuid.UUID, day string, n int) error {
+	scopes := []*uuid.UUID{nil}
+	if orgID != nil {
+		scopes = append(scopes, orgID)
+	}
+
+	pipe := r.client.TxPipeline()
+	for _, scope := range scopes {
+		key, totalKey := r.keys.OutboundSMS(day, scope), r.keys.OutboundSMSTotal(day, scope)
+		pipe.ZIncrBy(ctx, key, float64(n), userID.String())
+		pipe.IncrBy(ctx, totalKey, int64(n))
+		pipe.Expire(ctx, key, outboundSMSTTL)
+		pipe.Expire(ctx, totalKey, outboundSMSTTL)
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// OutboundSMSCounts returns the SMS sent on day, for every user or the
+// organization's members, and the top users by count
+func (r *RedisDB) OutboundSMSCounts(ctx context.Context, orgID *uuid.UUID, day string, top int) (int64, []models.UserSMSCount, error) {
+	key := r.keys.OutboundSMS(day, orgID)
+
+	pipe := r.client.TxPipeline()
+	total := pipe.Get(ctx, r.keys.OutboundSMSTotal(day, orgID))
+	ranked := pipe.ZRevRangeWithScores(ctx, key, 0, int64(top-1))
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return 0, nil, err
+	}
+
+	sum, err := total.Int64()
+	if err != nil && err != redis.Nil {
+		return 0, nil, err
+	}
+	users := make([]models.UserSMSCount, 0, len(ranked.Val()))
+	for _, z := range ranked.Val() {
+		userID, err := uuid.Parse(fmt.Sprint(z.Member))
+		if err != nil {
+			continue
+		}
+		users = append(users, models.UserSMSCount{UserID: userID, Count: int64(z.Score)})
+	}
+	return sum, users, nil
+}
+
+// Global outbound message budget. The per-minute cap is a token bucket
+// refilled continuously, so a burst can't spend two minutes' worth across a
+// minute boundary; messages are also counted per day, by type, and per
+// clock minute for the spend report. A raised cap is a hash that expires
+// with the raise.
+const (
+	outboundMinuteTTL = 2 * time.Minute
+	outboundDayTTL    = 8 * 24 * time.Hour
+	// outboundBucketTTL outlasts a refill from the bottom of the overdraft,
+	// after which a missing bucket is a full one
+	outboundBucketTTL = 3 * time.Minute
+)
+
+// Outcomes of ClaimOutboundMessage
+const (
+	OutboundDropped = 0 // over the cap for its priority; not counted as sent
+	OutboundSent    = 1
+	OutboundOverCap = 2 // an emergency message past the cap, within the overdraft
+)
+
+// claimOutboundScript takes a token from the minute bucket and counts the
+// message against the day's cap unless it is over them. Non-emergency
+// messages stop reservePct percent short of each cap, which leaves the rest
+// for emergency messages; those may run overdraftPct percent past each cap,
+// and are refused beyond it too.
+var claimOutboundScript = redis.NewScript(`
+local minuteCap = tonumber(ARGV[1])
+local dayCap = tonumber(ARGV[2])
+local raised = redis.call("HMGET", KEYS[5], "per_minute", "per_day")
+if raised[1] then minuteCap = math.max(minuteCap, tonumber(raised[1])) end
+if raised[2] then dayCap = math.max(dayCap, tonumber(raised[2])) end
+
+local now = tonumber(ARGV[6])
+local bucket = redis.call("HMGET", KEYS[1], "tokens", "at", "cap")
+local tokens = tonumber(bucket[1]) or minuteCap
+local elapsed = math.max(0, now - (tonumber(bucket[2]) or now))
+-- A raised cap takes effect at once, with its extra tokens
+tokens = tokens + math.max(0, minuteCap - (tonumber(bucket[3]) or minuteCap))
+tokens = math.min(minuteCap, tokens + elapsed * minuteCap / 60000)
+local day = tonumber(redis.call("GET", KEYS[3]) or "0")
+
+local minuteFloor, dayLimit
+if ARGV[4] == "1" then
+	local overdraft = tonumber(ARGV[7]) / 100
+	minuteFloor = -math.floor(minuteCap * overdraft)
+	dayLimit = dayCap + math.floor(dayCap * overdraft)
+else
+	local reserve = tonumber(ARGV[3]) / 100
+	minuteFloor = minuteCap - math.floor(minuteCap * (1 - reserve))
+	dayLimit = math.floor(dayCap * (1 - reserve))
+end
+if tokens - 1 < minuteFloor or day >= dayLimit then
+	redis.call("HSET", KEYS[1], "tokens", tostring(tokens), "at", ARGV[6], "cap", minuteCap)
+	redis.call("PEXPIRE", KEYS[1], ARGV[10])
+	redis.call("HINCRBY", KEYS[4], "dropped:" .. ARGV[5], 1)
+	redis.call("EXPIRE", KEYS[4], ARGV[9])
+	return 0
+end
+
+redis.call("HSET", KEYS[1], "tokens", tostring(tokens - 1), "at", ARGV[6], "cap", minuteCap)
+redis.call("PEXPIRE", KEYS[1], ARGV[10])
+redis.call("INCR", KEYS[2])
+redis.call("EXPIRE", KEYS[2], ARGV[8])
+redis.call("INCR", KEYS[3])
+redis.call("EXPIRE", KEYS[3], ARGV[9])
+redis.call("HINCRBY", KEYS[4], "sent:" .. ARGV[5], 1)
+redis.call("EXPIRE", KEYS[4], ARGV[9])
+if tokens < 1 or day >= dayCap then
+	return 2
+end
+return 1
+`)
+
+// ClaimOutboundMessage counts a message of kind sent at the given time on
+// day against the caps, unless it is dropped, and returns the outcome
+func (r *RedisDB) ClaimOutboundMessage(ctx context.Context, kind string, emergency bool, at time.Time, day string, perMinute, perDay, reservePct, overdraftPct int) (int, error) {
+	flag := "0"
+	if emergency {
+		flag = "1"
+	}
+	return claimOutboundScript.Run(ctx, r.client,
+		[]string{r.keys.OutboundBucket(), r.keys.OutboundMinute(at), r.keys.OutboundDay(day), r.keys.OutboundDayTypes(day), r.keys.OutboundLimit()},
+		perMinute, perDay, reservePct, flag, kind, at.UnixMilli(), overdraftPct,
+		int(outboundMinuteTTL.Seconds()), int(outboundDayTTL.Seconds()), outboundBucketTTL.Milliseconds(),
+	).Int()
+}
+
+// OutboundMessageCounts returns the messages sent in the minute of at and on
+// day, and day's sent and dropped messages by type
+func (r *RedisDB) OutboundMessageCounts(ctx context.Context, at time.Time, day string) (minute, total int64, byType map[string]models.OutboundTypeCount, err error) {
+	pipe := r.client.TxPipeline()
+	minuteCmd := pipe.Get(ctx, r.keys.OutboundMinute(at))
+	totalCmd := pipe.Get(ctx, r.keys.OutboundDay(day))
+	typesCmd := pipe.HGetAll(ctx, r.keys.OutboundDayTypes(day))
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return 0, 0, nil, err
+	}
+
+	if minute, err = minuteCmd.Int64(); err != nil && err != redis.Nil {
+		return 0, 0, nil, err
+	}
+	if total, err = totalCmd.Int64(); err != nil && err != redis.Nil {
+		return 0, 0, nil, err
+	}
+	byType = make(map[string]models.OutboundTypeCount)
+	for field, value := range typesCmd.Val() {
+		outcome, kind, ok := strings.Cut(field, ":")
+		if !ok {
+			continue
+		}
+		n, _ := strconv.ParseInt(value, 10, 64)
+		count := byType[kind]
+		switch outcome {
+		case "sent":
+			count.Sent = n
+		case "dropped":
+			count.Dropped = n
+		}
+		byType[kind] = count
+	}
+	return minute, total, byType, nil
+}
+
+// SetOutboundLimitOverride raises the caps until ttl runs out, replacing any
+// earlier raise
+func (r *RedisDB) SetOutboundLimitOverride(ctx context.Context, perMinute, perDay int, ttl time.Duration) error {
+	pipe := r.client.TxPipeline()
+	pipe.Del(ctx, r.keys.OutboundLimit())
+	pipe.HSet(ctx, r.keys.OutboundLimit(), "per_minute", perMinute, "per_day", perDay)
+	pipe.Expire(ctx, r.keys.OutboundLimit(), ttl)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// GetOutboundLimitOverride returns the raised caps, or nil if none are
+func (r *RedisDB) GetOutboundLimitOverride(ctx context.Context) (*models.OutboundLimitOverride, error) {
+	pipe := r.client.TxPipeline()
+	fields := pipe.HGetAll(ctx, r.keys.OutboundLimit())
+	ttl := pipe.PTTL(ctx, r.keys.OutboundLimit())
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+	if len(fields.Val()) == 0 || ttl.Val() <= 0 {
+		return nil, nil
+	}
+
+	perMinute, _ := strconv.Atoi(fields.Val()["per_minute"])
+	perDay, _ := strconv.Atoi(fields.Val()["per_day"])
+	return &models.OutboundLimitOverride{
+		PerMinute: perMinute,
+		PerDay:    perDay,
+		ExpiresAt: time.Now().Add(ttl.Val()),
+	}, nil
+}
+
+// ClearOutboundLimitOverride ends a raise early; it reports whether there was one
+func (r *RedisDB) ClearOutboundLimitOverride(ctx context.Context) (bool, error) {
+	n, err := r.client.Del(ctx, r.keys.OutboundLimit()).Result()
+	return n > 0, err
+}
+
+// Per-contact daily notification counters (keyed by the contact's local date)
+func (r *RedisDB) GetContactDailyCount(ctx context.Context, phone, day string) (int, error) {
+	key := r.keys.ContactDaily(phone, day)
+	count, err := r.client.Get(ctx, key).Int()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	return count, err
+}
+
+func (r *RedisDB) IncrContactDailyCount(ctx context.Context, phone, day string) error {
+	key := r.keys.ContactDaily(phone, day)
+	count, err := r.client.Incr(ctx, key).Result()
+	if err != nil {
+		return err
+	}
+	if count == 1 {
+		r.client.Expire(ctx, key, 48*time.Hour)
+	}
+	return nil
+}
+
+// Contact pacing: per phone number, the non-critical messages sent in the
+// current window, the ones held back for a combined update, and the numbers
+// with one waiting, scored by when it is due in Unix milliseconds
+
+// contactDigestGrace keeps a held update past its due time, so it still goes
+// out if the digest worker was down when it fell due
+const contactDigestGrace = 24 * time.Hour
+
+var paceContactScript = redis.NewScript(`
+if redis.call("EXISTS", KEYS[2]) == 1 then
+	redis.call("RPUSH", KEYS[2], ARGV[4])
+	return 0
+end
+local sent = redis.call("INCR", KEYS[1])
+if sent == 1 then
+	redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+if sent <= tonumber(ARGV[1]) then
+	return 1
+end
+local ttl = redis.call("PTTL", KEYS[1])
+if ttl < 0 then
+	ttl = tonumber(ARGV[2])
+end
+redis.call("RPUSH", KEYS[2], ARGV[4])
+redis.call("PEXPIRE", KEYS[2], ttl + tonumber(ARGV[5]))
+redis.call("ZADD", KEYS[3], "NX", tonumber(ARGV[3]) + ttl, ARGV[6])
+return 0
+`)
+
+var takeContactDigestScript = redis.NewScript(`
+local entries = redis.call("LRANGE", KEYS[2], 0, -1)
+redis.call("DEL", KEYS[2])
+redis.call("ZREM", KEYS[3], ARGV[2])
+if #entries > 0 then
+	redis.call("SET", KEYS[1], 1, "PX", ARGV[1])
+end
+return entries
+`)
+
+// PaceContactMessage counts a non-critical message to phone against limit
+// per window and reports whether it may be sent now. Once the limit is
+// reached, or while an update is already held for the number, entry is held
+// instead, to go out combined when the window ends.
+func (r *RedisDB) PaceContactMessage(ctx context.Context, phone string, limit int, window time.Duration, entry *models.ContactDigestEntry, now time.Time) (bool, error) {
+	data, err := encodePayload(entry)
+	if err != nil {
+		return false, err
+	}
+	send, err := paceContactScript.Run(ctx, r.client,
+		[]string{r.keys.ContactPace(phone), r.keys.ContactDigest(phone), r.keys.ContactDigestDue()},
+		limit, window.Milliseconds(), now.UnixMilli(), data, contactDigestGrace.Milliseconds(), phone).Int()
+	if err != nil {
+		return false, err
+	}
+	return send == 1, nil
+}
+
+// ClaimDueContactDigests returns up to limit phone numbers whose held update
+// is due at or before now and pushes them back by lease, atomically, as
+// ClaimDueChecks does
+func (r *RedisDB) ClaimDueContactDigests(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]string, error) {
+	return claimDueScript.Run(ctx, r.client, []string{r.keys.ContactDigestDue()},
+		now.UnixMilli(), now.Add(lease).UnixMilli(), limit).StringSlice()
+}
+
+// TakeContactDigest removes and returns the messages held for phone, oldest
+// first. Sending them counts as the first message of a new window. Of two
+// instances taking the same digest, only one gets the messages.
+func (r *RedisDB) TakeContactDigest(ctx context.Context, phone string, window time.Duration) ([]models.ContactDigestEntry, error) {
+	values, err := takeContactDigestScript.Run(ctx, r.client,
+		[]string{r.keys.ContactPace(phone), r.keys.ContactDigest(phone), r.keys.ContactDigestDue()},
+		window.Milliseconds(), phone).StringSlice()
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]models.ContactDigestEntry, 0, len(values))
+	for _, value := range values {
+		var entry models.ContactDigestEntry
+		if err := decodePayload([]byte(value), &entry); err != nil {
+			return nil, fmt.Errorf("held update for %s: %w", phone, err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// Per-requester daily welfare check counters (keyed by the deployment's local date).
+// ClaimWelfareCheck counts a request and reports whether it is within limit;
+// refused requests count too, so retrying doesn't help.
+func (r *RedisDB) ClaimWelfareCheck(ctx context.Context, userID uuid.UUID, requesterID, day string, limit int) (bool, error) {
+	key := r.keys.WelfareDaily(userID, requesterID, day)
+	count, err := r.client.Incr(ctx, key).Result()
+	if err != nil {
+		return false, err
+	}
+	if count == 1 {
+		r.client.Expire(ctx, key, 48*time.Hour)
+	}
+	return count <= int64(limit), nil
+}
+
+// ClaimPanicPINAttempt counts an attempt to change the user's panic PIN and
+// reports whether it is within limit for the window, so the current PIN
+// can't be guessed by trying to change it
+func (r *RedisDB) ClaimPanicPINAttempt(ctx context.Context, userID uuid.UUID, window time.Duration, limit int) (bool, error) {
+	key := r.keys.PanicPINAttempts(userID)
+	count, err := r.client.Incr(ctx, key).Result()
+	if err != nil {
+		return false, err
+	}
+	if count == 1 {
+		r.client.Expire(ctx, key, window)
+	}
+	return count <= int64(limit), nil
+}
+
+// ClaimResponderRequest counts a request made with a responder key and
+// reports whether it is within limit for the window
+func (r *RedisDB) ClaimResponderRequest(ctx context.Context, keyID uuid.UUID, window time.Duration, limit int) (bool, error) {
+	key := r.keys.ResponderRequests(keyID)
+	count, err := r.client.Incr(ctx, key).Result()
+	if err != nil {
+		return false, err
+	}
+	if count == 1 {
+		r.client.Expire(ctx, key, window)
+	}
+	return count <= int64(limit), nil
+}
+
+// Guardian link authorization cache. A cached "none" records that no active link exists.
+const noAccountLink = "none"
+
+// GetCachedAccountLink returns the cached decision; found is false on a cache miss
+func (r *RedisDB) GetCachedAccountLink(ctx context.Context, guardianID, wardID uuid.UUID) (link *models.AccountLink, found bool, err error) {
+	data, err := r.client.Get(ctx, r.keys.AccountLink(guardianID, wardID)).Result()
+	if err == redis.Nil {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	if data == noAccountLink {
+		return nil, true, nil
+	}
+
+	var cached models.AccountLink
+	if err := decodePayload([]byte(data), &cached); err != nil {
+		return nil, false, err
+	}
+	return &cached, true, nil
+}
+
+// CacheAccountLink stores the active link between guardian and ward, or nil for none
+func (r *RedisDB) CacheAccountLink(ctx context.Context, guardianID, wardID uuid.UUID, link *models.AccountLink, ttl time.Duration) error {
+	value := noAccountLink
+	if link != nil {
+		data, err := encodePayload(link)
+		if err != nil {
+			return err
+		}
+		value = string(data)
+	}
+	return r.client.Set(ctx, r.keys.AccountLink(guardianID, wardID), value, ttl).Err()
+}
+
+// InvalidateAccountLink drops the cached decision so the next check reads Postgres
+func (r *RedisDB) InvalidateAccountLink(ctx context.Context, guardianID, wardID uuid.UUID) error {
+	return r.client.Del(ctx, r.keys.AccountLink(guardianID, wardID)).Err()
+}
+
+// Per-user evaluation lock. The token identifies the holder so an expired
+// holder cannot release a lock that has since been taken by someone else.
+var releaseLockScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+// AcquireEvaluationLock takes the user's evaluation lock if it is free
+func (r *RedisDB) AcquireEvaluationLock(ctx context.Context, userID uuid.UUID, token string, ttl time.Duration) (bool, error) {
+	return r.client.SetNX(ctx, r.keys.EvaluationLock(userID), token, ttl).Result()
+}
+
+// ReleaseEvaluationLock releases the lock if token still holds it
+func (r *RedisDB) ReleaseEvaluationLock(ctx context.Context, userID uuid.UUID, token string) error {
+	return releaseLockScript.Run(ctx, r.client, []string{r.keys.EvaluationLock(userID)}, token).Err()
+}
+
+// Scheduled job locks, held by the instance running a singleton job
+
+// AcquireJobLock takes the job's lock if no instance holds it
+func (r *RedisDB) AcquireJobLock(ctx context.Context, name, token string, ttl time.Duration) (bool, error) {
+	return r.client.SetNX(ctx, r.keys.JobLock(name), token, ttl).Result()
+}
+
+// ReleaseJobLock releases the job's lock if token still holds it
+func (r *RedisDB) ReleaseJobLock(ctx context.Context, name, token string) error {
+	return releaseLockScript.Run(ctx, r.client, []string{r.keys.JobLock(name)}, token).Err()
+}
+
+// ClaimJobRun claims the job's scheduled run for ttl if no instance has.
+// Unlike the lock it isn't released when the run ends.
+func (r *RedisDB) ClaimJobRun(ctx context.Context, name, token string, ttl time.Duration) (bool, error) {
+	return r.client.SetNX(ctx, r.keys.JobRunClaim(name), token, ttl).Result()
+}
+
+// Warmup after Redis loses its data
+
+// WarmupSentinelExists reports whether Redis still holds the sentinel set by
+// the last warmup
+func (r *RedisDB) WarmupSentinelExists(ctx context.Context) (bool, error) {
+	n, err := r.client.Exists(ctx, r.keys.WarmupSentinel()).Result()
+	return n > 0, err
+}
+
+// ClaimWarmup takes the warmup lock if no instance holds it
+func (r *RedisDB) ClaimWarmup(ctx context.Context, token string, ttl time.Duration) (bool, error) {
+	return r.client.SetNX(ctx, r.keys.WarmupLock(), token, ttl).Result()
+}
+
+var renewLockScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+// RenewWarmup extends the warmup lock, reporting whether token still holds it
+func (r *RedisDB) RenewWarmup(ctx context.Context, token string, ttl time.Duration) (bool, error) {
+	n, err := renewLockScript.Run(ctx, r.client, []string{r.keys.WarmupLock()}, token, ttl.Milliseconds()).Int()
+	return n == 1, err
+}
+
+// FinishWarmup sets the sentinel and releases the warmup lock
+func (r *RedisDB) FinishWarmup(ctx context.Context, token string, at time.Time) error {
+	if err := r.client.Set(ctx, r.keys.WarmupSentinel(), at.UTC().Format(time.RFC3339), 0).Err(); err != nil {
+		return err
+	}
+	return releaseLockScript.Run(ctx, r.client, []string{r.keys.WarmupLock()}, token).Err()
+}
+
+// RestoreUserState caches a state rebuilt from Postgres unless an evaluation
+// has cached a newer one meanwhile, and reports whether it did
+func (r *RedisDB) RestoreUserState(ctx context.Context, state *models.UserState) (bool, error) {
+	data, err := encodePayload(state)
+	if err != nil {
+		return false, err
+	}
+	return r.client.SetNX(ctx, r.keys.UserState(state.UserID), data, userStateTTL).Result()
+}
+
+// RestoreAlertSent marks the user's latest alert sent for what is left of
+// its dedup window, unless it is already marked
+func (r *RedisDB) RestoreAlertSent(ctx context.Context, userID uuid.UUID, remaining time.Duration) error {
+	return r.client.SetNX(ctx, r.keys.AlertSent(userID), "1", remaining).Err()
+}
+
+// USSD sessions, keyed by the gateway's session ID
+
+// SaveUSSDSession stores a USSD session until its next callback or expiry
+func (r *RedisDB) SaveUSSDSession(ctx context.Context, sessionID string, session *models.USSDSession, ttl time.Duration) error {
+	data, err := encodePayload(session)
+	if err != nil {
+		return err
+	}
+	return r.client.Set(ctx, r.keys.USSDSession(sessionID), data, ttl).Err()
+}
+
+// GetUSSDSession returns a USSD session, or nil if it is unknown or expired
+func (r *RedisDB) GetUSSDSession(ctx context.Context, sessionID string) (*models.USSDSession, error) {
+	data, err := r.client.Get(ctx, r.keys.USSDSession(sessionID)).Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var session models.USSDSession
+	if err := decodePayload([]byte(data), &session); err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+func (r *RedisDB) DeleteUSSDSession(ctx context.Context, sessionID string) error {
+	return r.client.Del(ctx, r.keys.USSDSession(sessionID)).Err()
+}
+
+// Contact invitations, keyed by the code in the invite link
+
+// SaveContactInvite stores an invitation until it is confirmed or expires
+func (r *RedisDB) SaveContactInvite(ctx context.Context, code string, invite *models.ContactInvite, ttl time.Duration) error {
+	data, err := encodePayload(invite)
+	if err != nil {
+		return err
+	}
+	return r.client.Set(ctx, r.keys.ContactInvite(code), data, ttl).Err()
+}
+
+// TakeContactInvite returns and deletes an invitation, so each link works once.
+// Returns nil if the code is unknown or expired.
+func (r *RedisDB) TakeContactInvite(ctx context.Context, code string) (*models.ContactInvite, error) {
+	data, err := r.client.GetDel(ctx, r.keys.ContactInvite(code)).Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var invite models.ContactInvite
+	if err := decodePayload([]byte(data), &invite); err != nil {
+		return nil, err
+	}
+	return &invite, nil
+}
+
+// Access token revocation. Each revoked token ID is a key that expires when
+// the token would have, so the revocation set never outgrows live tokens.
+// Contact tokens are also tracked per contact so removal can revoke them all.
+
+// TrackContactToken records a token issued to a contact
+func (r *RedisDB) TrackContactToken(ctx context.Context, userID uuid.UUID, contactID, tokenID string, ttl time.Duration) error {
+	key := r.keys.ContactTokens(userID, contactID)
+	pipe := r.client.TxPipeline()
+	pipe.SAdd(ctx, key, tokenID)
+	pipe.Expire(ctx, key, ttl)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// RevokeToken adds one token to the revocation set
+func (r *RedisDB) RevokeToken(ctx context.Context, tokenID string, ttl time.Duration) error {
+	return r.client.Set(ctx, r.keys.RevokedToken(tokenID), "1", ttl).Err()
+}
+
+// RevokeContactTokens revokes every token issued to the contact
+func (r *RedisDB) RevokeContactTokens(ctx context.Context, userID uuid.UUID, contactID string, ttl time.Duration) error {
+	key := r.keys.ContactTokens(userID, contactID)
+	tokenIDs, err := r.client.SMembers(ctx, key).Result()
+	if err != nil {
+		return err
+	}
+
+	pipe := r.client.TxPipeline()
+	for _, id := range tokenIDs {
+		pipe.Set(ctx, r.keys.RevokedToken(id), "1", ttl)
+	}
+	pipe.Del(ctx, key)
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// IsTokenRevoked reports whether a token ID is in the revocation set
+func (r *RedisDB) IsTokenRevoked(ctx context.Context, tokenID string) (bool, error) {
+	n, err := r.client.Exists(ctx, r.keys.RevokedToken(tokenID)).Result()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// Heartbeat signature failures, counted in sliding windows per user and per
+// source IP, and the per-user lockout they can trigger
+
+// RecordSignatureFailure adds a failure for kind ("user" or "ip") and id and
+// returns how many failures fall within the trailing window
+func (r *RedisDB) RecordSignatureFailure(ctx context.Context, kind, id string, window time.Duration) (int64, error) {
+	key := r.keys.SignatureFailures(kind, id)
+	now := time.Now()
+
+	pipe := r.client.TxPipeline()
+	pipe.ZAdd(ctx, key, redis.Z{Score: float64(now.UnixMilli()), Member: uuid.NewString()})
+	pipe.ZRemRangeByScore(ctx, key, "-inf", fmt.Sprintf("(%d", now.Add(-window).UnixMilli()))
+	count := pipe.ZCard(ctx, key)
+	pipe.Expire(ctx, key, window)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, err
+	}
+	return count.Val(), nil
+}
+
+// LockSignatures starts a lockout for the user; false means one is already running
+func (r *RedisDB) LockSignatures(ctx context.Context, userID uuid.UUID, ttl time.Duration) (bool, error) {
+	return r.client.SetNX(ctx, r.keys.SignatureLockout(userID), "1", ttl).Result()
+}
+
+// SignatureLockout returns how long the user's lockout has left, or 0 if none
+func (r *RedisDB) SignatureLockout(ctx context.Context, userID uuid.UUID) (time.Duration, error) {
+	ttl, err := r.client.PTTL(ctx, r.keys.SignatureLockout(userID)).Result()
+	if err != nil {
+		return 0, err
+	}
+	if ttl < 0 {
+		return 0, nil
+	}
+	return ttl, nil
+}
+
+// ClearSignatureLockout ends the user's lockout and forgets their failures
+func (r *RedisDB) ClearSignatureLockout(ctx context.Context, userID uuid.UUID) error {
+	return r.client.Del(ctx, r.keys.SignatureLockout(userID), r.keys.SignatureFailures("user", userID.String())).Err()
+}
+
+// Nonces of version 2 heartbeat signatures, per user
+
+// ClaimHeartbeatNonce records a signed heartbeat's nonce for ttl; false means
+// the user already sent it, so the heartbeat is a replay
+func (r *RedisDB) ClaimHeartbeatNonce(ctx context.Context, userID uuid.UUID, nonce string, ttl time.Duration) (bool, error) {
+	return r.client.SetNX(ctx, r.keys.HeartbeatNonce(userID, nonce), "1", ttl).Result()
+}
+
+// Unverified fallback heartbeats, counted per phone number and client IP
+
+// CountUnverifiedReport counts a report from phone and ip for the window and
+// returns both counts so far. Refused reports count too, so retrying
+// doesn't help.
+func (r *RedisDB) CountUnverifiedReport(ctx context.Context, phone, ip string, window time.Duration) (int, int, error) {
+	pipe := r.client.TxPipeline()
+	phoneKey, ipKey := r.keys.UnverifiedReports("phone", phone), r.keys.UnverifiedReports("ip", ip)
+	phoneCount := pipe.Incr(ctx, phoneKey)
+	ipCount := pipe.Incr(ctx, ipKey)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, 0, err
+	}
+	// Set expiry on the first report of each
+	if phoneCount.Val() == 1 {
+		r.client.Expire(ctx, phoneKey, window)
+	}
+	if ipCount.Val() == 1 {
+		r.client.Expire(ctx, ipKey, window)
+	}
+	return int(phoneCount.Val()), int(ipCount.Val()), nil
+}
+
+// IssueReportChallenge records a proof-of-work challenge for phone for ttl
+func (r *RedisDB) IssueReportChallenge(ctx context.Context, challenge, phone string, ttl time.Duration) error {
+	return r.client.Set(ctx, r.keys.ReportChallenge(challenge), phone, ttl).Err()
+}
+
+// ClaimReportChallenge removes a challenge and returns the phone number it
+// was issued for, or "" if it was unknown, expired or already used
+func (r *RedisDB) ClaimReportChallenge(ctx context.Context, challenge string) (string, error) {
+	phone, err := r.client.GetDel(ctx, r.keys.ReportChallenge(challenge)).Result()
+	if err == redis.Nil {
+		return "", nil
+	}
+	return phone, err
+}
+
+// Content fingerprints of recent heartbeats, per user, holding the ID of the
+// heartbeat first seen with each
+
+var claimFingerprintScript = redis.NewScript(`
+local first = redis.call("GET", KEYS[1])
+if first then
+  return first
+end
+redis.call("SET", KEYS[1], ARGV[1], "PX", ARGV[2])
+return ""
+`)
+
+// ClaimHeartbeatFingerprint records heartbeatID under the fingerprint for
+// ttl and returns nil, or returns the ID already recorded under it: the
+// heartbeat this one is a copy of. The check and set are atomic, so of two
+// copies arriving at once exactly one is the original.
+func (r *RedisDB) ClaimHeartbeatFingerprint(ctx context.Context, userID uuid.UUID, fingerprint string, heartbeatID uuid.UUID, ttl time.Duration) (*uuid.UUID, error) {
+	first, err := claimFingerprintScript.Run(ctx, r.client, []string{r.keys.HeartbeatFingerprint(userID, fingerprint)},
+		heartbeatID.String(), ttl.Milliseconds()).Text()
+	if err != nil || first == "" {
+		return nil, err
+	}
+	original, err := uuid.Parse(first)
+	if err != nil {
+		return nil, fmt.Errorf("fingerprint holds %q: %w", first, err)
+	}
+	return &original, nil
+}
+
+var releaseFingerprintScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+  return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+// ReleaseHeartbeatFingerprint forgets the fingerprint if heartbeatID holds it
+func (r *RedisDB) ReleaseHeartbeatFingerprint(ctx context.Context, userID uuid.UUID, fingerprint string, heartbeatID uuid.UUID) error {
+	return releaseFingerprintScript.Run(ctx, r.client, []string{r.keys.HeartbeatFingerprint(userID, fingerprint)},
+		heartbeatID.String()).Err()
+}
+
+// Heartbeat streams open per user, scored by when their lease runs out, in
+// Unix milliseconds. A stream renews its lease while it runs, so one left by
+// a crashed instance stops counting once the lease is over.
+
+var claimStreamScript = redis.NewScript(`
+redis.call("ZREMRANGEBYSCORE", KEYS[1], "-inf", ARGV[1])
+if not redis.call("ZSCORE", KEYS[1], ARGV[3]) and redis.call("ZCARD", KEYS[1]) >= tonumber(ARGV[4]) then
+	return 0
+end
+redis.call("ZADD", KEYS[1], ARGV[2], ARGV[3])
+local last = redis.call("ZRANGE", KEYS[1], -1, -1, "WITHSCORES")
+redis.call("PEXPIREAT", KEYS[1], last[2])
+return 1
+`)
+
+// ClaimHeartbeatStream opens or renews a lease of lease on one of the user's
+// limit stream slots for streamID. False means limit other streams hold one.
+func (r *RedisDB) ClaimHeartbeatStream(ctx context.Context, userID uuid.UUID, streamID string, now time.Time, lease time.Duration, limit int) (bool, error) {
+	claimed, err := claimStreamScript.Run(ctx, r.client, []string{r.keys.HeartbeatStreams(userID)},
+		now.UnixMilli(), now.Add(lease).UnixMilli(), streamID, limit).Int()
+	if err != nil {
+		return false, err
+	}
+	return claimed == 1, nil
+}
+
+// ReleaseHeartbeatStream frees the stream's slot
+func (r *RedisDB) ReleaseHeartbeatStream(ctx context.Context, userID uuid.UUID, streamID string) error {
+	return r.client.ZRem(ctx, r.keys.HeartbeatStreams(userID), streamID).Err()
+}
+
+// Coarse location mode: the precise fix of a user's newest coarse heartbeat,
+// kept only for a short while, and a marker keeping their heartbeats precise
+// through an incident
+
+// SetPreciseLocation keeps loc, replacing the previous one, for ttl
+func (r *RedisDB) SetPreciseLocation(ctx context.Context, userID uuid.UUID, loc *models.PreciseLocation, ttl time.Duration) error {
+	data, err := encodePayload(loc)
+	if err != nil {
+		return err
+	}
+	return r.client.Set(ctx, r.keys.PreciseLocation(userID), data, ttl).Err()
+}
+
+// GetPreciseLocation returns the precise fix of the user's newest coarse
+// heartbeat, or nil once it expired
+func (r *RedisDB) GetPreciseLocation(ctx context.Context, userID uuid.UUID) (*models.PreciseLocation, error) {
+	data, err := r.client.Get(ctx, r.keys.PreciseLocation(userID)).Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var loc models.PreciseLocation
+	if err := decodePayload([]byte(data), &loc); err != nil {
+		return nil, err
+	}
+	return &loc, nil
+}
+
+// EscalatePrecision keeps the user's heartbeats precise for ttl from now and
+// reports whether they weren't already
+func (r *RedisDB) EscalatePrecision(ctx context.Context, userID uuid.UUID, ttl time.Duration) (bool, error) {
+	previous, err := r.client.SetArgs(ctx, r.keys.PrecisionEscalated(userID), "1", redis.SetArgs{TTL: ttl, Get: true}).Result()
+	if err == redis.Nil {
+		return true, nil
+	}
+	return previous == "", err
+}
+
+// PrecisionEscalated reports whether the user's heartbeats are being kept precise
+func (r *RedisDB) PrecisionEscalated(ctx context.Context, userID uuid.UUID) (bool, error) {
+	n, err := r.client.Exists(ctx, r.keys.PrecisionEscalated(userID)).Result()
+	return n > 0, err
+}
+
+// Newest heartbeat timestamp seen per user, in Unix milliseconds
+
+// latestHeartbeatTTL lets the marker of an inactive user expire; the next
+// heartbeat after that is taken as the newest
+const latestHeartbeatTTL = 7 * 24 * time.Hour
+
+var advanceLatestScript = redis.NewScript(`
+local current = tonumber(redis.call("GET", KEYS[1]) or "-1")
+if tonumber(ARGV[1]) <= current then
+	return 0
+end
+redis.call("SET", KEYS[1], ARGV[1], "PX", ARGV[2])
+return 1
+`)
+
+// AdvanceLatestHeartbeat records ts as the user's newest heartbeat if it is
+// newer than every one seen so far. False means a heartbeat at or after ts
+// already arrived. The compare and set are atomic.
+func (r *RedisDB) AdvanceLatestHeartbeat(ctx context.Context, userID uuid.UUID, ts time.Time) (bool, error) {
+	advanced, err := advanceLatestScript.Run(ctx, r.client, []string{r.keys.LatestHeartbeat(userID)},
+		ts.UnixMilli(), latestHeartbeatTTL.Milliseconds()).Int()
+	if err != nil {
+		return false, err
+	}
+	return advanced == 1, nil
+}
+
+// GetLatestHeartbeatTime returns the newest heartbeat timestamp seen from
+// the user, or nil if none was seen recently
+func (r *RedisDB) GetLatestHeartbeatTime(ctx context.Context, userID uuid.UUID) (*time.Time, error) {
+	ms, err := r.client.Get(ctx, r.keys.LatestHeartbeat(userID)).Int64()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	t := time.UnixMilli(ms)
+	return &t, nil
+}
+
+// Device registry: the newest heartbeat seen from each of a user's devices,
+// as JSON per device, with its timestamp kept alongside for ordering
+
+// deviceRegistryTTL forgets the devices of a user who sent nothing for a month
+const deviceRegistryTTL = 30 * 24 * time.Hour
+
+var trackDeviceScript = redis.NewScript(`
+local current = tonumber(redis.call("HGET", KEYS[2], ARGV[1]) or "-1")
+if tonumber(ARGV[2]) <= current then
+	return 0
+end
+redis.call("HSET", KEYS[1], ARGV[1], ARGV[3])
+redis.call("HSET", KEYS[2], ARGV[1], ARGV[2])
+redis.call("PEXPIRE", KEYS[1], ARGV[4])
+redis.call("PEXPIRE", KEYS[2], ARGV[4])
+return 1
+`)
+
+// TrackDevice records device as the newest state of its device unless a
+// heartbeat at or after it was already recorded for that device
+func (r *RedisDB) TrackDevice(ctx context.Context, userID uuid.UUID, device *models.Device) error {
+	data, err := encodePayload(device)
+	if err != nil {
+		return err
+	}
+	return trackDeviceScript.Run(ctx, r.client, []string{r.keys.Devices(userID), r.keys.DevicesSeen(userID)},
+		device.DeviceID, device.LastSeen.UnixMilli(), data, deviceRegistryTTL.Milliseconds()).Err()
+}
+
+// GetDevices returns the user's known devices, most recently seen first
+func (r *RedisDB) GetDevices(ctx context.Context, userID uuid.UUID) ([]models.Device, error) {
+	entries, err := r.client.HGetAll(ctx, r.keys.Devices(userID)).Result()
+	if err != nil {
+		return nil, err
+	}
+	devices := make([]models.Device, 0, len(entries))
+	for _, data := range entries {
+		var device models.Device
+		if err := decodePayload([]byte(data), &device); err != nil {
+			return nil, err
+		}
+		devices = append(devices, device)
+	}
+	sort.Slice(devices, func(i, j int) bool {
+		return devices[i].LastSeen.After(devices[j].LastSeen)
+	})
+	return devices, nil
+}
+
+// Diagnostics: short per-user histories the user can read back to see why
+// their heartbeats were or weren't taken
+
+const diagnosticsTTL = 7 * 24 * time.Hour
+
+// RecordIngestionAttempt adds attempt to the user's recent attempts, keeping the newest keep
+func (r *RedisDB) RecordIngestionAttempt(ctx context.Context, userID uuid.UUID, attempt *models.IngestionAttempt, keep int) error {
+	return r.pushDiagnostic(ctx, r.keys.DiagnosticAttempts(userID), attempt, keep)
+}
+
+// GetIngestionAttempts returns the user's recent attempts, newest first
+func (r *RedisDB) GetIngestionAttempts(ctx context.Context, userID uuid.UUID) ([]models.IngestionAttempt, error) {
+	entries, err := r.client.LRange(ctx, r.keys.DiagnosticAttempts(userID), 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+	attempts := make([]models.IngestionAttempt, len(entries))
+	for i, data := range entries {
+		if err := decodePayload([]byte(data), &attempts[i]); err != nil {
+			return nil, err
+		}
+	}
+	return attempts, nil
+}
+
+// RecordEvaluationDiagnostic adds diag to the user's recent evaluations, keeping the newest keep
+func (r *RedisDB) RecordEvaluationDiagnostic(ctx context.Context, userID uuid.UUID, diag *models.EvaluationDiagnostic, keep int) error {
+	return r.pushDiagnostic(ctx, r.keys.DiagnosticEvaluations(userID), diag, keep)
+}
+
+// GetEvaluationDiagnostics returns the user's recent evaluations, newest first
+func (r *RedisDB) GetEvaluationDiagnostics(ctx context.Context, userID uuid.UUID) ([]models.EvaluationDiagnostic, error) {
+	entries, err := r.client.LRange(ctx, r.keys.DiagnosticEvaluations(userID), 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+	diags := make([]models.EvaluationDiagnostic, len(entries))
+	for i, data := range entries {
+		if err := decodePayload([]byte(data), &diags[i]); err != nil {
+			return nil, err
+		}
+	}
+	return diags, nil
+}
+
+func (r *RedisDB) pushDiagnostic(ctx context.Context, key string, v models.RedisPersisted, keep int) error {
+	data, err := encodePayload(v)
+	if err != nil {
+		return err
+	}
+	pipe := r.client.TxPipeline()
+	pipe.LPush(ctx, key, data)
+	pipe.LTrim(ctx, key, 0, int64(keep-1))
+	pipe.Expire(ctx, key, diagnosticsTTL)
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// RateLimitRemaining returns how many heartbeats the user's device may still
+// send in the current window of limit, and when the window resets; 0 if it
+// isn't running
+func (r *RedisDB) RateLimitRemaining(ctx context.Context, userID uuid.UUID, deviceID string, limit int) (int, time.Duration, error) {
+	key := r.keys.RateLimit(userID, deviceID)
+	pipe := r.client.Pipeline()
+	count := pipe.Get(ctx, key)
+	ttl := pipe.PTTL(ctx, key)
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return 0, 0, err
+	}
+	used, err := count.Int()
+	if err == redis.Nil {
+		return limit, 0, nil
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+	reset := ttl.Val()
+	if reset < 0 {
+		reset = 0
+	}
+	return max(limit-used, 0), reset, nil
+}
+
+// App activity: when the user last interacted with the app, and whether
+// they were recently nudged to turn location back on
+
+// MarkUserActive records that the user is using the app, for ttl
+func (r *RedisDB) MarkUserActive(ctx context.Context, userID uuid.UUID, at time.Time, ttl time.Duration) error {
+	return r.client.Set(ctx, r.keys.Activity(userID), at.UnixMilli(), ttl).Err()
+}
+
+// UserActiveAt returns when the user last used the app, or nil if the marker expired
+func (r *RedisDB) UserActiveAt(ctx context.Context, userID uuid.UUID) (*time.Time, error) {
+	ms, err := r.client.Get(ctx, r.keys.Activity(userID)).Int64()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	at := time.UnixMilli(ms)
+	return &at, nil
+}
+
+// ClaimLocationNudge reports whether the user may be nudged now; false means
+// they were nudged within ttl
+func (r *RedisDB) ClaimLocationNudge(ctx context.Context, userID uuid.UUID, ttl time.Duration) (bool, error) {
+	return r.client.SetNX(ctx, r.keys.LocationNudge(userID), "1", ttl).Result()
+}
+
+// Stale-user monitor: users being tracked, scored by when they are next due
+// an evaluation without a heartbeat, in Unix milliseconds
+var claimDueScript = redis.NewScript(`
+local due = redis.call("ZRANGEBYSCORE", KEYS[1], "-inf", ARGV[1], "LIMIT", 0, ARGV[3])
+for _, member in ipairs(due) do
+	redis.call("ZADD", KEYS[1], "XX", ARGV[2], member)
+end
+return due
+`)
+
+// ScheduleCheck sets when the user is next due an evaluation, replacing any
+// earlier schedule or claim
+func (r *RedisDB) ScheduleCheck(ctx context.Context, userID uuid.UUID, due time.Time) error {
+	return r.client.ZAdd(ctx, r.keys.MonitorDue(), redis.Z{Score: float64(due.UnixMilli()), Member: userID.String()}).Err()
+}
+
+// UnscheduleCheck stops tracking the user until they are scheduled again
+func (r *RedisDB) UnscheduleCheck(ctx context.Context, userID uuid.UUID) error {
+	return r.client.ZRem(ctx, r.keys.MonitorDue(), userID.String()).Err()
+}
+
+// ScheduleMissingChecks schedules the users that aren't tracked at all,
+// leaving existing schedules alone, and returns how many were added
+func (r *RedisDB) ScheduleMissingChecks(ctx context.Context, due map[uuid.UUID]time.Time) (int64, error) {
+	if len(due) == 0 {
+		return 0, nil
+	}
+	members := make([]redis.Z, 0, len(due))
+	for userID, at := range due {
+		members = append(members, redis.Z{Score: float64(at.UnixMilli()), Member: userID.String()})
+	}
+	return r.client.ZAddNX(ctx, r.keys.MonitorDue(), members...).Result()
+}
+
+// ClaimDueChecks returns up to limit users due at or before now and pushes
+// them back by lease, atomically, so other instances don't claim them too.
+// A claimed user whose evaluation never reschedules them is due again once
+// the lease runs out.
+func (r *RedisDB) ClaimDueChecks(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]uuid.UUID, error) {
+	return r.claimDue(ctx, r.keys.MonitorDue(), now, lease, limit)
+}
+
+// claimDue runs claimDueScript on the sorted set of user IDs at key
+func (r *RedisDB) claimDue(ctx context.Context, key string, now time.Time, lease time.Duration, limit int) ([]uuid.UUID, error) {
+	members, err := claimDueScript.Run(ctx, r.client, []string{key},
+		now.UnixMilli(), now.Add(lease).UnixMilli(), limit).StringSlice()
+	if err != nil {
+		return nil, err
+	}
+	userIDs := make([]uuid.UUID, 0, len(members))
+	for _, member := range members {
+		userID, err := uuid.Parse(member)
+		if err != nil {
+			return nil, err
+		}
+		userIDs = append(userIDs, userID)
+	}
+	return userIDs, nil
+}
+
+// DueCheckCounts returns how many users are tracked and how many are due at now
+func (r *RedisDB) DueCheckCounts(ctx context.Context, now time.Time) (tracked, due int64, err error) {
+	pipe := r.client.TxPipeline()
+	card := pipe.ZCard(ctx, r.keys.MonitorDue())
+	count := pipe.ZCount(ctx, r.keys.MonitorDue(), "-inf", fmt.Sprint(now.UnixMilli()))
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, 0, err
+	}
+	return card.Val(), count.Val(), nil
+}
+
+// Check-in prompts: each user's prompt in progress as a hash, and the users
+// with one scored by when it is next due, in Unix milliseconds
+
+// promptSessionGrace keeps a prompt past its deadline, so a late monitor pass
+// still finds it to escalate
+const promptSessionGrace = time.Hour
+
+var startPromptScript = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 1 then
+	return 0
+end
+redis.call("HSET", KEYS[1], "id", ARGV[1], "ladder", ARGV[2], "timeout", ARGV[3],
+	"started", ARGV[4], "sent", 0, "due", ARGV[4])
+redis.call("PEXPIRE", KEYS[1], ARGV[5])
+redis.call("ZADD", KEYS[2], ARGV[4], ARGV[6])
+return 1
+`)
+
+var advancePromptScript = redis.NewScript(`
+if redis.call("HGET", KEYS[1], "id") ~= ARGV[1] or redis.call("HGET", KEYS[1], "sent") ~= ARGV[2] then
+	return 0
+end
+redis.call("HSET", KEYS[1], "sent", tonumber(ARGV[2]) + 1, "due", ARGV[3])
+redis.call("ZADD", KEYS[2], ARGV[3], ARGV[4])
+return 1
+`)
+
+var finishPromptScript = redis.NewScript(`
+local id = redis.call("HGET", KEYS[1], "id")
+if not id or (ARGV[1] ~= "" and id ~= ARGV[1]) then
+	return {}
+end
+local fields = redis.call("HGETALL", KEYS[1])
+redis.call("DEL", KEYS[1])
+redis.call("ZREM", KEYS[2], ARGV[2])
+return fields
+`)
+
+var dropPromptDueScript = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 0 then
+	redis.call("ZREM", KEYS[2], ARGV[1])
+end
+return 0
+`)
+
+// StartPromptSession opens the prompt unless the user already has one, with
+// its first attempt due at once, and reports whether it was opened
+func (r *RedisDB) StartPromptSession(ctx context.Context, session *models.PromptSession) (bool, error) {
+	ttl := time.Until(session.Deadline()) + promptSessionGrace
+	started, err := startPromptScript.Run(ctx, r.client,
+		[]string{r.keys.PromptSession(session.UserID), r.keys.PromptDue()},
+		session.ID.String(), strings.Join(session.Ladder, ","), session.Timeout.Milliseconds(),
+		session.StartedAt.UnixMilli(), ttl.Milliseconds(), session.UserID.String()).Int()
+	if err != nil {
+		return false, err
+	}
+	return started == 1, nil
+}
+
+// GetPromptSession returns the user's prompt in progress, or nil if they have none
+func (r *RedisDB) GetPromptSession(ctx context.Context, userID uuid.UUID) (*models.PromptSession, error) {
+	fields, err := r.client.HGetAll(ctx, r.keys.PromptSession(userID)).Result()
+	if err != nil || len(fields) == 0 {
+		return nil, err
+	}
+	return parsePromptSession(userID, fields)
+}
+
+// ClaimDuePrompts returns up to limit users whose prompt is due at or before
+// now and pushes them back by lease, atomically, as ClaimDueChecks does
+func (r *RedisDB) ClaimDuePrompts(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]uuid.UUID, error) {
+	return r.claimDue(ctx, r.keys.PromptDue(), now, lease, limit)
+}
+
+// AdvancePromptSession counts the session's next attempt as sent and makes
+// the one after it, or the escalation, due at next. False means the session
+// was answered, replaced or already advanced by another instance, and the
+// attempt must not be sent.
+func (r *RedisDB) AdvancePromptSession(ctx context.Context, session *models.PromptSession, next time.Time) (bool, error) {
+	advanced, err := advancePromptScript.Run(ctx, r.client,
+		[]string{r.keys.PromptSession(session.UserID), r.keys.PromptDue()},
+		session.ID.String(), session.Sent, next.UnixMilli(), session.UserID.String()).Int()
+	if err != nil {
+		return false, err
+	}
+	return advanced == 1, nil
+}
+
+// FinishPromptSession ends the user's prompt and returns it as it stood, or
+// nil if there was none. A nil id ends any prompt, otherwise only that one.
+// Ending is atomic: of an answer and an escalation racing, only one gets the
+// session back.
+func (r *RedisDB) FinishPromptSession(ctx context.Context, userID, id uuid.UUID) (*models.PromptSession, error) {
+	only := ""
+	if id != uuid.Nil {
+		only = id.String()
+	}
+	values, err := finishPromptScript.Run(ctx, r.client,
+		[]string{r.keys.PromptSession(userID), r.keys.PromptDue()}, only, userID.String()).StringSlice()
+	if err != nil || len(values) == 0 {
+		return nil, err
+	}
+	fields := make(map[string]string, len(values)/2)
+	for i := 0; i+1 < len(values); i += 2 {
+		fields[values[i]] = values[i+1]
+	}
+	return parsePromptSession(userID, fields)
+}
+
+// DropPromptDue stops scheduling a user whose prompt expired, unless they
+// have a new one
+func (r *RedisDB) DropPromptDue(ctx context.Context, userID uuid.UUID) error {
+	return dropPromptDueScript.Run(ctx, r.client,
+		[]string{r.keys.PromptSession(userID), r.keys.PromptDue()}, userID.String()).Err()
+}
+
+func parsePromptSession(userID uuid.UUID, fields map[string]string) (*models.PromptSession, error) {
+	id, err := uuid.Parse(fields["id"])
+	if err != nil {
+		return nil, fmt.Errorf("prompt session of user %s: %w", userID, err)
+	}
+	var ms [4]int64
+	for i, name := range []string{"timeout", "started", "sent", "due"} {
+		if ms[i], err = strconv.ParseInt(fields[name], 10, 64); err != nil {
+			return nil, fmt.Errorf("prompt session of user %s: bad %s: %w", userID, name, err)
+		}
+	}
+	return &models.PromptSession{
+		ID:        id,
+		UserID:    userID,
+		Ladder:    strings.Split(fields["ladder"], ","),
+		Timeout:   time.Duration(ms[0]) * time.Millisecond,
+		StartedAt: time.UnixMilli(ms[1]),
+		Sent:      int(ms[2]),
+		Due:       time.UnixMilli(ms[3]),
+	}, nil
+}
+
+// Alert auto-resolution: per alert, the user's healthy heartbeats in a row
+// from a safe zone, and the prompt to confirm they're home safe
+
+// IncrAutoResolveStreak counts another healthy heartbeat towards resolving
+// the alert and returns the streak; it is forgotten after ttl without one
+func (r *RedisDB) IncrAutoResolveStreak(ctx context.Context, alertID uuid.UUID, ttl time.Duration) (int64, error) {
+	key := r.keys.AutoResolveStreak(alertID)
+	pipe := r.client.TxPipeline()
+	incr := pipe.Incr(ctx, key)
+	pipe.Expire(ctx, key, ttl)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, err
+	}
+	return incr.Val(), nil
+}
+
+// ResetAutoResolveStreak starts the alert's streak over
+func (r *RedisDB) ResetAutoResolveStreak(ctx context.Context, alertID uuid.UUID) error {
+	return r.client.Del(ctx, r.keys.AutoResolveStreak(alertID)).Err()
+}
+
+// ClaimAutoResolvePrompt marks the user as asked to confirm the alert's
+// resolution for ttl, and reports whether they weren't already
+func (r *RedisDB) ClaimAutoResolvePrompt(ctx context.Context, alertID uuid.UUID, ttl time.Duration) (bool, error) {
+	return r.client.SetNX(ctx, r.keys.AutoResolvePrompt(alertID), 1, ttl).Result()
+}
+
+// TakeAutoResolvePrompt ends the alert's prompt and reports whether one was
+// still open; of two confirmations racing, only one takes it
+func (r *RedisDB) TakeAutoResolvePrompt(ctx context.Context, alertID uuid.UUID) (bool, error) {
+	n, err := r.client.Del(ctx, r.keys.AutoResolvePrompt(alertID)).Result()
+	return n == 1, err
+}
+
+// Outage detection: per geohash cell and network, the users gone silent
+// there scored by when, in Unix milliseconds, and once enough have, the
+// zone as a hash expiring with its cooldown. Active zones are indexed by
+// when they end.
+
+var recordOutageScript = redis.NewScript(`
+redis.call("ZADD", KEYS[1], ARGV[1], ARGV[2])
+redis.call("ZREMRANGEBYSCORE", KEYS[1], "-inf", "(" .. ARGV[3])
+redis.call("PEXPIRE", KEYS[1], ARGV[4])
+local users = redis.call("ZCARD", KEYS[1])
+if users < tonumber(ARGV[5]) then
+	return {users, 0}
+end
+local latest = redis.call("ZREVRANGE", KEYS[1], 0, 0, "WITHSCORES")
+local ends = tonumber(latest[2]) + tonumber(ARGV[6])
+if ends <= tonumber(ARGV[7]) then
+	return {users, 0}
+end
+local opened = 0
+if redis.call("EXISTS", KEYS[2]) == 0 then
+	redis.call("HSET", KEYS[2], "since", ARGV[7])
+	opened = 1
+end
+redis.call("HSET", KEYS[2], "users", users, "until", ends)
+redis.call("PEXPIREAT", KEYS[2], ends)
+redis.call("ZADD", KEYS[3], ends, ARGV[8])
+return {users, opened}
+`)
+
+// RecordOutageUser counts a user who went silent at the given time in a
+// geohash cell on a network, once however often they are recorded, and
+// forgets users who went silent before the window. When at least minUsers
+// are counted the cell becomes an outage zone until cooldown after the
+// latest of them. It returns the users counted and whether the zone was
+// just opened.
+func (r *RedisDB) RecordOutageUser(ctx context.Context, geohash string, mcc, mnc int, userID uuid.UUID, silentAt, now time.Time, window, cooldown time.Duration, minUsers int) (int, bool, error) {
+	network := outageNetwork(mcc, mnc)
+	values, err := recordOutageScript.Run(ctx, r.client,
+		[]string{r.keys.OutageUsers(geohash, network), r.keys.OutageZone(geohash, network), r.keys.OutageZones()},
+		silentAt.UnixMilli(), userID.String(), now.Add(-window).UnixMilli(), window.Milliseconds(),
+		minUsers, cooldown.Milliseconds(), now.UnixMilli(), geohash+":"+network).Int64Slice()
+	if err != nil {
+		return 0, false, err
+	}
+	return int(values[0]), values[1] == 1, nil
+}
+
+// GetOutageZone returns the active outage zone of a geohash cell on a
+// network, or nil if there is none
+func (r *RedisDB) GetOutageZone(ctx context.Context, geohash string, mcc, mnc int) (*models.OutageZone, error) {
+	fields, err := r.client.HGetAll(ctx, r.keys.OutageZone(geohash, outageNetwork(mcc, mnc))).Result()
+	if err != nil || len(fields) == 0 {
+		return nil, err
+	}
+	return parseOutageZone(geohash, mcc, mnc, fields)
+}
+
+// ActiveOutageZones returns every active outage zone, soonest to end first
+func (r *RedisDB) ActiveOutageZones(ctx context.Context, now time.Time) ([]models.OutageZone, error) {
+	index := r.keys.OutageZones()
+	if err := r.client.ZRemRangeByScore(ctx, index, "-inf", strconv.FormatInt(now.UnixMilli(), 10)).Err(); err != nil {
+		return nil, err
+	}
+	members, err := r.client.ZRange(ctx, index, 0, -1).Result()
+	if err != nil || len(members) == 0 {
+		return nil, err
+	}
+
+	type zoneKey struct {
+		geohash  string
+		mcc, mnc int
+	}
+	zones := make([]zoneKey, 0, len(members))
+	pipe := r.client.Pipeline()
+	var cmds []*redis.MapStringStringCmd
+	for _, member := range members {
+		geohash, network, _ := strings.Cut(member, ":")
+		var z zoneKey
+		if _, err := fmt.Sscanf(network, "%d-%d", &z.mcc, &z.mnc); err != nil {
+			continue
+		}
+		z.geohash = geohash
+		zones = append(zones, z)
+		cmds = append(cmds, pipe.HGetAll(ctx, r.keys.OutageZone(geohash, network)))
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+
+	active := make([]models.OutageZone, 0, len(zones))
+	for i, cmd := range cmds {
+		// A zone whose hash expired between the two reads has ended
+		if len(cmd.Val()) == 0 {
+			continue
+		}
+		zone, err := parseOutageZone(zones[i].geohash, zones[i].mcc, zones[i].mnc, cmd.Val())
+		if err != nil {
+			return nil, err
+		}
+		active = append(active, *zone)
+	}
+	return active, nil
+}
+
+func outageNetwork(mcc, mnc int) string {
+	return fmt.Sprintf("%d-%d", mcc, mnc)
+}
+
+func parseOutageZone(geohash string, mcc, mnc int, fields map[string]string) (*models.OutageZone, error) {
+	var values [3]int64
+	for i, name := range []string{"users", "since", "until"} {
+		v, err := strconv.ParseInt(fields[name], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("outage zone %s:%s: bad %s: %w", geohash, outageNetwork(mcc, mnc), name, err)
+		}
+		values[i] = v
+	}
+	return &models.OutageZone{
+		Geohash: geohash,
+		MCC:     mcc,
+		MNC:     mnc,
+		Users:   int(values[0]),
+		Since:   time.UnixMilli(values[1]),
+		Until:   time.UnixMilli(values[2]),
+	}, nil
+}
+
+// Ping checks that Redis is reachable
+func (r *RedisDB) Ping(ctx context.Context) error {
+	return r.client.Ping(ctx).Err()
+}
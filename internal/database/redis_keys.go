@@ -0,0 +1,103 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/keys"
+)
+
+// Ways MigrateKeys deals with keys of an older schema version, including
+// legacy keys written before keys had a namespace
+const (
+	KeyMigrationOff     = "off"
+	KeyMigrationRewrite = "rewrite" // move them to their current key
+	KeyMigrationExpire  = "expire"  // let them expire after a grace period
+)
+
+// keyScanBatch is how many keys one SCAN call asks for
+const keyScanBatch = 1000
+
+// KeyCensus counts the keys found in Redis by where they belong
+type KeyCensus struct {
+	Scanned int            `json:"scanned"`
+	Current int            `json:"current"` // this namespace, current version
+	Stale   int            `json:"stale"`   // this namespace's older versions, and legacy keys
+	Foreign map[string]int `json:"foreign"` // other namespaces, by namespace
+}
+
+// CensusKeys scans up to limit keys and sorts them by namespace and version.
+// It is a sample on a large Redis; that is enough to notice a neighbour.
+func (r *RedisDB) CensusKeys(ctx context.Context, limit int) (*KeyCensus, error) {
+	census := &KeyCensus{Foreign: make(map[string]int)}
+	iter := r.client.Scan(ctx, 0, "*", keyScanBatch).Iterator()
+	for census.Scanned < limit && iter.Next(ctx) {
+		key := iter.Val()
+		census.Scanned++
+
+		if _, stale := r.keys.Current(key); stale {
+			census.Stale++
+			continue
+		}
+		namespace, version, ok := keys.Parse(key)
+		switch {
+		case ok && namespace == r.keys.Namespace() && version == keys.Version:
+			census.Current++
+		case ok && namespace != r.keys.Namespace():
+			census.Foreign[namespace]++
+		}
+	}
+	return census, iter.Err()
+}
+
+// KeyMigration is what MigrateKeys did
+type KeyMigration struct {
+	Rewritten int `json:"rewritten"`
+	Expiring  int `json:"expiring"`
+}
+
+// MigrateKeys finds this namespace's stale keys and, by mode, renames each to
+// its current key or sets it to expire within grace. A stale key whose
+// current key was already written since is left to expire, since the new
+// one is fresher. Keys of other namespaces are never touched.
+func (r *RedisDB) MigrateKeys(ctx context.Context, mode string, grace time.Duration) (*KeyMigration, error) {
+	if mode != KeyMigrationRewrite && mode != KeyMigrationExpire {
+		return nil, fmt.Errorf("unknown key migration %q", mode)
+	}
+
+	result := &KeyMigration{}
+	iter := r.client.Scan(ctx, 0, "*", keyScanBatch).Iterator()
+	for iter.Next(ctx) {
+		key := iter.Val()
+		current, stale := r.keys.Current(key)
+		if !stale {
+			continue
+		}
+
+		if mode == KeyMigrationRewrite {
+			renamed, err := r.client.RenameNX(ctx, key, current).Result()
+			if err != nil {
+				return result, fmt.Errorf("failed to rewrite %s: %w", key, err)
+			}
+			if renamed {
+				result.Rewritten++
+				continue
+			}
+		}
+
+		ttl, err := r.client.PTTL(ctx, key).Result()
+		if err != nil {
+			return result, err
+		}
+		// Gone meanwhile, or expiring soon enough already
+		if ttl == -2*time.Nanosecond || (ttl > 0 && ttl <= grace) {
+			continue
+		}
+		if err := r.client.Expire(ctx, key, grace).Err(); err != nil {
+			return result, err
+		}
+		result.Expiring++
+	}
+	return result, iter.Err()
+}
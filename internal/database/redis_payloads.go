@@ -0,0 +1,206 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+)
+
+// schemaVersionField is the JSON field every Redis payload is written with
+const schemaVersionField = "schema_version"
+
+// encodePayload marshals v for Redis with its schema version and any fields
+// of a newer version it was read with. A payload read from a newer build is
+// written back with that build's version, since it still has its fields.
+func encodePayload(v models.RedisPersisted) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 2 || data[0] != '{' {
+		return nil, fmt.Errorf("redis payload %T is not a JSON object", v)
+	}
+	payload := v.Payload()
+	version := max(models.RedisSchemaVersion, payload.SchemaVersion)
+
+	if len(payload.Unknown) == 0 {
+		out := make([]byte, 0, len(data)+24)
+		out = append(out, `{"`+schemaVersionField+`":`...)
+		out = strconv.AppendInt(out, int64(version), 10)
+		if len(data) > 2 {
+			out = append(out, ',')
+		}
+		return append(out, data[1:]...), nil
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	for name, raw := range payload.Unknown {
+		if _, ok := fields[name]; !ok {
+			fields[name] = raw
+		}
+	}
+	fields[schemaVersionField] = json.RawMessage(strconv.Itoa(version))
+	return json.Marshal(fields)
+}
+
+// decodePayload unmarshals a Redis payload into v, keeping its schema
+// version and the fields v doesn't know in v's RedisPayload
+func decodePayload(data []byte, v models.RedisPersisted) error {
+	if err := json.Unmarshal(data, v); err != nil {
+		return err
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+
+	payload := v.Payload()
+	payload.SchemaVersion, payload.Unknown = 0, nil
+	if raw, ok := fields[schemaVersionField]; ok {
+		if err := json.Unmarshal(raw, &payload.SchemaVersion); err != nil {
+			return fmt.Errorf("invalid %s: %w", schemaVersionField, err)
+		}
+		delete(fields, schemaVersionField)
+	}
+	known := knownFields(reflect.TypeOf(v))
+	for name := range fields {
+		// encoding/json matches field names case-insensitively
+		if known[strings.ToLower(name)] {
+			delete(fields, name)
+		}
+	}
+	if len(fields) > 0 {
+		payload.Unknown = fields
+	}
+	if payload.SchemaVersion > models.RedisSchemaVersion {
+		noteNewerPayload(payload.SchemaVersion)
+	}
+	return nil
+}
+
+// newerPayloadVersions are the newer schema versions already logged
+var newerPayloadVersions sync.Map
+
+// noteNewerPayload logs the first payload read of each newer schema version
+func noteNewerPayload(version int) {
+	if _, seen := newerPayloadVersions.LoadOrStore(version, true); !seen {
+		log.Printf("WARN: Read a Redis payload of schema version %d, newer than this build's %d; "+
+			"its unknown fields are kept when it is rewritten", version, models.RedisSchemaVersion)
+	}
+}
+
+// knownFieldCache holds the lowercased JSON field names of each payload type
+var knownFieldCache sync.Map
+
+// knownFields returns the lowercased JSON field names t decodes, including
+// those of embedded structs
+func knownFields(t reflect.Type) map[string]bool {
+	if cached, ok := knownFieldCache.Load(t); ok {
+		return cached.(map[string]bool)
+	}
+	known := make(map[string]bool)
+	collectFields(t, known)
+	knownFieldCache.Store(t, known)
+	return known
+}
+
+func collectFields(t reflect.Type, known map[string]bool) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return
+	}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+		if f.Anonymous && name == "" {
+			collectFields(f.Type, known)
+			continue
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		known[strings.ToLower(name)] = true
+	}
+}
+
+// PayloadCensus counts a sample of this namespace's JSON payloads in Redis
+// by schema version; 0 is a payload written before versioning
+type PayloadCensus struct {
+	Scanned  int         `json:"scanned"`
+	Versions map[int]int `json:"versions"`
+}
+
+// Newest is the highest schema version found
+func (c *PayloadCensus) Newest() int {
+	newest := 0
+	for version := range c.Versions {
+		newest = max(newest, version)
+	}
+	return newest
+}
+
+// CensusPayloads reads up to limit of this namespace's string values and
+// counts the JSON objects among them by schema version, so a build can tell
+// at startup that a newer one has been writing to the same Redis
+func (r *RedisDB) CensusPayloads(ctx context.Context, limit int) (*PayloadCensus, error) {
+	census := &PayloadCensus{Versions: make(map[int]int)}
+	iter := r.client.ScanType(ctx, 0, r.keys.Prefix()+"*", keyScanBatch, "string").Iterator()
+	batch := make([]string, 0, keyScanBatch)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		values, err := r.client.MGet(ctx, batch...).Result()
+		batch = batch[:0]
+		if err != nil {
+			return err
+		}
+		for _, value := range values {
+			data, ok := value.(string)
+			if !ok || !strings.HasPrefix(data, "{") {
+				continue
+			}
+			var header struct {
+				SchemaVersion int `json:"schema_version"`
+			}
+			if json.Unmarshal([]byte(data), &header) != nil {
+				continue
+			}
+			census.Scanned++
+			census.Versions[header.SchemaVersion]++
+		}
+		return nil
+	}
+
+	for read := 0; read < limit && iter.Next(ctx); read++ {
+		batch = append(batch, iter.Val())
+		if len(batch) == cap(batch) {
+			if err := flush(); err != nil {
+				return census, err
+			}
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return census, err
+	}
+	return census, flush()
+}
@@ -0,0 +1,167 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+)
+
+// nextUserState is UserState as a newer build might have it, with fields
+// this build doesn't know
+type nextUserState struct {
+	models.UserState
+
+	ScoreBreakdown  map[string]int `json:"score_breakdown"`
+	AdvisedInterval int            `json:"advised_interval_s"`
+}
+
+func newerState(userID uuid.UUID) *nextUserState {
+	state := &nextUserState{
+		UserState: models.UserState{
+			UserID:        userID,
+			State:         "AT_RISK",
+			Score:         45,
+			LastHeartbeat: time.Date(2026, 3, 9, 2, 15, 0, 0, time.UTC),
+			Revision:      7,
+		},
+		ScoreBreakdown:  map[string]int{"stationary": 20, "battery": 15},
+		AdvisedInterval: 120,
+	}
+	state.SchemaVersion = models.RedisSchemaVersion + 1 // as the newer build writes it
+	return state
+}
+
+// A newer build writes, this one reads, changes and rewrites, and the newer
+// one reads again with every field intact
+func TestPayloadRoundTripThroughOlderBuild(t *testing.T) {
+	written := newerState(uuid.New())
+	data, err := encodePayload(written)
+	if err != nil {
+		t.Fatalf("encodePayload (new): %v", err)
+	}
+
+	var old models.UserState
+	if err := decodePayload(data, &old); err != nil {
+		t.Fatalf("decodePayload (old): %v", err)
+	}
+	if old.SchemaVersion != models.RedisSchemaVersion+1 || len(old.Unknown) != 2 {
+		t.Fatalf("old build read version %d, unknown %v", old.SchemaVersion, old.Unknown)
+	}
+	if old.Score != 45 || old.State != "AT_RISK" {
+		t.Errorf("old build read %+v", old)
+	}
+	old.State, old.Score = "ALERT", 20
+	rewritten, err := encodePayload(&old)
+	if err != nil {
+		t.Fatalf("encodePayload (old): %v", err)
+	}
+
+	var header struct {
+		SchemaVersion int `json:"schema_version"`
+	}
+	if err := json.Unmarshal(rewritten, &header); err != nil || header.SchemaVersion != models.RedisSchemaVersion+1 {
+		t.Errorf("rewritten as version %d, want the newer build's %d", header.SchemaVersion, models.RedisSchemaVersion+1)
+	}
+
+	var read nextUserState
+	if err := decodePayload(rewritten, &read); err != nil {
+		t.Fatalf("decodePayload (new): %v", err)
+	}
+	want := newerState(written.UserID)
+	want.State, want.Score = "ALERT", 20
+	want.Unknown = nil
+	if !reflect.DeepEqual(&read, want) {
+		t.Errorf("newer build read back %+v, want %+v", read, want)
+	}
+}
+
+// A payload from before versioning is version 0 and written back as this
+// build's
+func TestPayloadUnversioned(t *testing.T) {
+	var state models.UserState
+	if err := decodePayload([]byte(`{"user_id":"0b5c5a0f-3d81-4824-82e1-3803d1056b95","state":"SAFE","score":90}`), &state); err != nil {
+		t.Fatalf("decodePayload: %v", err)
+	}
+	if state.SchemaVersion != 0 || state.Unknown != nil || state.Score != 90 {
+		t.Errorf("decoded %+v", state)
+	}
+	data, err := encodePayload(&state)
+	if err != nil {
+		t.Fatalf("encodePayload: %v", err)
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		t.Fatalf("encoded %s: %v", data, err)
+	}
+	if string(fields["schema_version"]) != strconv.Itoa(models.RedisSchemaVersion) || string(fields["state"]) != `"SAFE"` {
+		t.Errorf("encoded %s", data)
+	}
+}
+
+// encoding/json matches names case-insensitively, so a differently cased
+// known field isn't kept as unknown and written twice
+func TestPayloadKnownFieldCase(t *testing.T) {
+	var state models.UserState
+	if err := decodePayload([]byte(`{"schema_version":2,"Score":12,"STATE":"ALERT","extra":true}`), &state); err != nil {
+		t.Fatalf("decodePayload: %v", err)
+	}
+	if state.Score != 12 || state.State != "ALERT" {
+		t.Errorf("decoded %+v", state)
+	}
+	if len(state.Unknown) != 1 || string(state.Unknown["extra"]) != "true" {
+		t.Errorf("unknown = %v, want only extra", state.Unknown)
+	}
+	if _, err := encodePayload(&state); err != nil {
+		t.Errorf("encodePayload: %v", err)
+	}
+}
+
+func TestPayloadInvalidVersion(t *testing.T) {
+	var state models.UserState
+	if err := decodePayload([]byte(`{"schema_version":"two"}`), &state); err == nil {
+		t.Errorf("decodePayload accepted a non-numeric schema_version")
+	}
+}
+
+// The same round trip through Redis: the older build saves a state a newer
+// one wrote, and the newer fields survive
+func TestUserStateKeepsNewerFields(t *testing.T) {
+	r := testRedis(t)
+	ctx := context.Background()
+	written := newerState(uuid.New())
+	data, err := encodePayload(written)
+	if err != nil {
+		t.Fatalf("encodePayload: %v", err)
+	}
+	if err := r.client.Set(ctx, r.keys.UserState(written.UserID), data, time.Hour).Err(); err != nil {
+		t.Fatalf("SET: %v", err)
+	}
+
+	state, err := r.GetUserState(ctx, written.UserID)
+	if err != nil || state == nil {
+		t.Fatalf("GetUserState() = %v, %v", state, err)
+	}
+	state.Score = 20
+	if err := r.SaveUserState(ctx, state); err != nil {
+		t.Fatalf("SaveUserState: %v", err)
+	}
+
+	raw, err := r.client.Get(ctx, r.keys.UserState(written.UserID)).Bytes()
+	if err != nil {
+		t.Fatalf("GET: %v", err)
+	}
+	var read nextUserState
+	if err := decodePayload(raw, &read); err != nil {
+		t.Fatalf("decodePayload: %v", err)
+	}
+	if read.Score != 20 || read.AdvisedInterval != 120 || read.ScoreBreakdown["battery"] != 15 || read.Revision != 8 {
+		t.Errorf("read back %+v", read)
+	}
+}
@@ -0,0 +1,280 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+)
+
+func TestRevokeContactTokens(t *testing.T) {
+	r := testRedis(t)
+	ctx := context.Background()
+	userID := uuid.New()
+	first, second, other := uuid.NewString(), uuid.NewString(), uuid.NewString()
+
+	for _, tokenID := range []string{first, second} {
+		if err := r.TrackContactToken(ctx, userID, "c1", tokenID, time.Hour); err != nil {
+			t.Fatalf("TrackContactToken: %v", err)
+		}
+	}
+	if err := r.TrackContactToken(ctx, userID, "c2", other, time.Hour); err != nil {
+		t.Fatalf("TrackContactToken: %v", err)
+	}
+
+	if err := r.RevokeContactTokens(ctx, userID, "c1", time.Hour); err != nil {
+		t.Fatalf("RevokeContactTokens: %v", err)
+	}
+
+	for tokenID, want := range map[string]bool{first: true, second: true, other: false} {
+		revoked, err := r.IsTokenRevoked(ctx, tokenID)
+		if err != nil {
+			t.Fatalf("IsTokenRevoked: %v", err)
+		}
+		if revoked != want {
+			t.Errorf("IsTokenRevoked(%s) = %v, want %v", tokenID, revoked, want)
+		}
+	}
+}
+
+func TestClaimJobRun(t *testing.T) {
+	r := testRedis(t)
+	ctx := context.Background()
+
+	claimed, err := r.ClaimJobRun(ctx, "sweep", "a", time.Minute)
+	if err != nil || !claimed {
+		t.Fatalf("first claim = %v, %v; want claimed", claimed, err)
+	}
+	// Releasing the lock doesn't release the claim
+	if err := r.ReleaseJobLock(ctx, "sweep", "a"); err != nil {
+		t.Fatalf("ReleaseJobLock: %v", err)
+	}
+	claimed, err = r.ClaimJobRun(ctx, "sweep", "b", time.Minute)
+	if err != nil || claimed {
+		t.Fatalf("second claim = %v, %v; want refused", claimed, err)
+	}
+}
+
+// Writers that each add a field to the user's state, rebuilding on the
+// newer state when they lose a race, all end up in it
+func TestSaveUserStateConcurrentWriters(t *testing.T) {
+	r := testRedis(t)
+	ctx := context.Background()
+	userID := uuid.New()
+	const writers = 20
+
+	var wg sync.WaitGroup
+	errs := make(chan error, writers)
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(field string) {
+			defer wg.Done()
+			for {
+				state, err := r.GetUserState(ctx, userID)
+				if err != nil {
+					errs <- err
+					return
+				}
+				if state == nil {
+					state = &models.UserState{UserID: userID, State: "SAFE"}
+				}
+				state.Anomalies = append(state.Anomalies, field)
+				err = r.SaveUserState(ctx, state)
+				if !errors.Is(err, ErrStateChanged) {
+					errs <- err
+					return
+				}
+			}
+		}(fmt.Sprintf("writer-%02d", i))
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("SaveUserState: %v", err)
+		}
+	}
+
+	state, err := r.GetUserState(ctx, userID)
+	if err != nil || state == nil {
+		t.Fatalf("GetUserState() = %v, %v", state, err)
+	}
+	if len(state.Anomalies) != writers || state.Revision != writers {
+		t.Fatalf("state has %d fields at revision %d, want %d at %d", len(state.Anomalies), state.Revision, writers, writers)
+	}
+	sort.Strings(state.Anomalies)
+	for i, field := range state.Anomalies {
+		if want := fmt.Sprintf("writer-%02d", i); field != want {
+			t.Errorf("field %d = %s, want %s", i, field, want)
+		}
+	}
+}
+
+// A state built on a stale revision is refused and can't drop the newer one
+func TestSaveUserStateStale(t *testing.T) {
+	r := testRedis(t)
+	ctx := context.Background()
+	userID := uuid.New()
+
+	first := &models.UserState{UserID: userID, State: "SAFE"}
+	if err := r.SaveUserState(ctx, first); err != nil || first.Revision != 1 {
+		t.Fatalf("SaveUserState() = %v at revision %d, want revision 1", err, first.Revision)
+	}
+	stale := &models.UserState{UserID: userID, State: "ALERT"}
+	if err := r.SaveUserState(ctx, stale); !errors.Is(err, ErrStateChanged) {
+		t.Fatalf("stale SaveUserState() = %v, want ErrStateChanged", err)
+	}
+	if err := r.DropUserState(ctx, stale); err != nil {
+		t.Fatalf("DropUserState: %v", err)
+	}
+	state, err := r.GetUserState(ctx, userID)
+	if err != nil || state == nil || state.State != "SAFE" || state.Revision != 1 {
+		t.Fatalf("GetUserState() = %+v, %v; want the first state", state, err)
+	}
+
+	if err := r.DropUserState(ctx, first); err != nil {
+		t.Fatalf("DropUserState: %v", err)
+	}
+	if state, err := r.GetUserState(ctx, userID); err != nil || state != nil {
+		t.Errorf("GetUserState() = %+v, %v; want dropped", state, err)
+	}
+}
+
+// A heartbeat nonce is accepted once per user; a replay is refused
+func TestClaimHeartbeatNonce(t *testing.T) {
+	r := testRedis(t)
+	ctx := context.Background()
+	userID, other := uuid.New(), uuid.New()
+	const nonce = "3f9c2a7e1b4d4e6f"
+
+	tests := []struct {
+		name   string
+		userID uuid.UUID
+		want   bool
+	}{
+		{"first use", userID, true},
+		{"replay", userID, false},
+		{"same nonce, other user", other, true},
+	}
+	for _, tt := range tests {
+		claimed, err := r.ClaimHeartbeatNonce(ctx, tt.userID, nonce, time.Hour)
+		if err != nil {
+			t.Fatalf("ClaimHeartbeatNonce: %v", err)
+		}
+		if claimed != tt.want {
+			t.Errorf("%s: claimed = %v, want %v", tt.name, claimed, tt.want)
+		}
+	}
+}
+
+// The minute cap is a token bucket: spent, it refills at the cap per
+// minute rather than all at once on the next minute
+func TestClaimOutboundMessageBucket(t *testing.T) {
+	r := testRedis(t)
+	ctx := context.Background()
+	start := time.Date(2026, 3, 9, 10, 0, 59, 0, time.UTC)
+
+	claim := func(at time.Time) int {
+		t.Helper()
+		outcome, err := r.ClaimOutboundMessage(ctx, "broadcast", false, at, "2026-03-09", 6, 1000, 0, 0)
+		if err != nil {
+			t.Fatalf("ClaimOutboundMessage: %v", err)
+		}
+		return outcome
+	}
+
+	for i := 0; i < 6; i++ {
+		if got := claim(start); got != OutboundSent {
+			t.Fatalf("claim %d = %d, want sent", i+1, got)
+		}
+	}
+	// A fixed window would start afresh a second later
+	if got := claim(start.Add(time.Second)); got != OutboundDropped {
+		t.Errorf("claim past the cap across the minute = %d, want dropped", got)
+	}
+	// One token back every ten seconds
+	if got := claim(start.Add(11 * time.Second)); got != OutboundSent {
+		t.Errorf("claim after a refill = %d, want sent", got)
+	}
+	if got := claim(start.Add(11 * time.Second)); got != OutboundDropped {
+		t.Errorf("second claim after one refill = %d, want dropped", got)
+	}
+	// Never more than the cap at once, however long it rested
+	sent := 0
+	for i := 0; i < 10; i++ {
+		if claim(start.Add(time.Hour)) == OutboundSent {
+			sent++
+		}
+	}
+	if sent != 6 {
+		t.Errorf("%d sent from a rested bucket, want 6", sent)
+	}
+}
+
+// The day's cap holds whatever is left in the bucket, and emergency
+// messages run past it only as far as the overdraft
+func TestClaimOutboundMessageDayCap(t *testing.T) {
+	r := testRedis(t)
+	ctx := context.Background()
+	at := time.Date(2026, 3, 9, 10, 0, 0, 0, time.UTC)
+
+	var outcomes []int
+	for i := 0; i < 5; i++ {
+		outcome, err := r.ClaimOutboundMessage(ctx, "alert", true, at.Add(time.Duration(i)*time.Minute), "2026-03-09", 100, 2, 0, 50)
+		if err != nil {
+			t.Fatalf("ClaimOutboundMessage: %v", err)
+		}
+		outcomes = append(outcomes, outcome)
+	}
+	want := []int{OutboundSent, OutboundSent, OutboundOverCap, OutboundDropped, OutboundDropped}
+	for i := range want {
+		if outcomes[i] != want[i] {
+			t.Fatalf("outcomes = %v, want %v", outcomes, want)
+		}
+	}
+}
+
+// A requester gets the day's limit of checks on a user and no more, and
+// refused attempts keep counting; other requesters, users and days are
+// counted apart
+func TestClaimWelfareCheck(t *testing.T) {
+	r := testRedis(t)
+	ctx := context.Background()
+	userID := uuid.New()
+
+	claim := func(userID uuid.UUID, requesterID, day string) bool {
+		t.Helper()
+		allowed, err := r.ClaimWelfareCheck(ctx, userID, requesterID, day, 2)
+		if err != nil {
+			t.Fatalf("ClaimWelfareCheck: %v", err)
+		}
+		return allowed
+	}
+
+	for i := 0; i < 2; i++ {
+		if !claim(userID, "c1", "2026-03-09") {
+			t.Fatalf("claim %d refused, want allowed", i+1)
+		}
+	}
+	for i := 0; i < 3; i++ {
+		if claim(userID, "c1", "2026-03-09") {
+			t.Errorf("claim %d past the limit allowed", i+3)
+		}
+	}
+	if !claim(userID, "c2", "2026-03-09") {
+		t.Error("another requester refused")
+	}
+	if !claim(uuid.New(), "c1", "2026-03-09") {
+		t.Error("the requester refused on another user")
+	}
+	if !claim(userID, "c1", "2026-03-10") {
+		t.Error("the requester refused the next day")
+	}
+}
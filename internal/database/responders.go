@@ -0,0 +1,164 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+const responderKeyColumns = `
+	id, organization, key_prefix, operating_area, created_at, last_used_at, revoked_at
+`
+
+func scanResponderKey(row pgx.Row) (*models.ResponderKey, error) {
+	var k models.ResponderKey
+	var area []byte
+	err := row.Scan(&k.ID, &k.Organization, &k.KeyPrefix, &area, &k.CreatedAt, &k.LastUsedAt, &k.RevokedAt)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(area, &k.OperatingArea); err != nil {
+		return nil, err
+	}
+	return &k, nil
+}
+
+// Responder key operations
+func (db *PostgresDB) CreateResponderKey(ctx context.Context, k *models.ResponderKey, keyHash string) error {
+	query := `
+		INSERT INTO responder_keys (id, organization, key_prefix, key_hash, operating_area, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`
+	_, err := db.pool.Exec(ctx, query, k.ID, k.Organization, k.KeyPrefix, keyHash, k.OperatingArea, k.CreatedAt)
+	return err
+}
+
+// GetResponderKeyByHash returns the unrevoked key with this hash, or nil
+func (db *PostgresDB) GetResponderKeyByHash(ctx context.Context, keyHash string) (*models.ResponderKey, error) {
+	query := `SELECT ` + responderKeyColumns + ` FROM responder_keys WHERE key_hash = $1 AND revoked_at IS NULL`
+	k, err := scanResponderKey(db.pool.QueryRow(ctx, query, keyHash))
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	return k, err
+}
+
+func (db *PostgresDB) ListResponderKeys(ctx context.Context) ([]models.ResponderKey, error) {
+	query := `SELECT ` + responderKeyColumns + ` FROM responder_keys ORDER BY created_at DESC`
+	rows, err := db.pool.Query(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []models.ResponderKey{}
+	for rows.Next() {
+		k, err := scanResponderKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, *k)
+	}
+	return keys, rows.Err()
+}
+
+// RevokeResponderKey revokes a key and reports whether it was active
+func (db *PostgresDB) RevokeResponderKey(ctx context.Context, id uuid.UUID) (bool, error) {
+	tag, err := db.pool.Exec(ctx, `UPDATE responder_keys SET revoked_at = NOW() WHERE id = $1 AND revoked_at IS NULL`, id)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// TouchResponderKey records that the key was used
+func (db *PostgresDB) TouchResponderKey(ctx context.Context, id uuid.UUID) error {
+	_, err := db.pool.Exec(ctx, `UPDATE responder_keys SET last_used_at = NOW() WHERE id = $1`, id)
+	return err
+}
+
+// FindResponderAlerts returns the unresolved alerts matching q, newest
+// first, each at the position of its user's latest heartbeat. Users without
+// a fix, or without the responder_sharing consent q asks for, are left out.
+// The distance is the haversine one, in metres.
+func (db *PostgresDB) FindResponderAlerts(ctx context.Context, q models.ResponderAlertQuery) ([]models.ResponderAlert, error) {
+	query := `
+		SELECT a.id, a.user_id, a.state, a.created_at, hb.lat, hb.lng, hb.timestamp,
+		       COALESCE((u.settings->>'responder_precise_location')::boolean, false),
+		       ra.acknowledged_at
+		FROM alerts a
+		JOIN users u ON u.id = a.user_id
+		JOIN LATERAL (
+			SELECT lat, lng, timestamp FROM heartbeats
+			WHERE user_id = a.user_id
+			ORDER BY timestamp DESC
+			LIMIT 1
+		) hb ON true
+		LEFT JOIN responder_acks ra ON ra.alert_id = a.id AND ra.key_id = $1
+		WHERE a.resolved_at IS NULL
+		  AND a.created_at >= $2
+		  AND NOT (hb.lat = 0 AND hb.lng = 0)
+		  AND hb.lat BETWEEN $3 AND $4
+		  AND hb.lng BETWEEN $5 AND $6
+		  AND 2 * 6371000 * asin(sqrt(
+		        power(sin(radians(hb.lat - $7) / 2), 2) +
+		        cos(radians($7)) * cos(radians(hb.lat)) * power(sin(radians(hb.lng - $8) / 2), 2)
+		      )) <= $9
+		  AND ($11 = 0 OR EXISTS (
+		        SELECT 1 FROM consents c
+		        WHERE c.user_id = a.user_id AND c.scope = 'responder_sharing' AND c.policy_version >= $11
+		          AND c.revoked_at IS NULL AND c.superseded_at IS NULL
+		      ))
+		ORDER BY a.created_at DESC
+		LIMIT $10
+	`
+	rows, err := db.pool.Query(ctx, query,
+		q.KeyID, q.Since, q.MinLat, q.MaxLat, q.MinLng, q.MaxLng, q.Lat, q.Lng, q.RadiusM, q.Limit, q.ConsentVersion,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var alerts []models.ResponderAlert
+	for rows.Next() {
+		var a models.ResponderAlert
+		err := rows.Scan(
+			&a.AlertID, &a.UserID, &a.State, &a.CreatedAt, &a.Lat, &a.Lng, &a.LocatedAt,
+			&a.Precise, &a.AcknowledgedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		alerts = append(alerts, a)
+	}
+	return alerts, rows.Err()
+}
+
+// AcknowledgeResponderAlert records that a responder has seen an unresolved
+// alert. It returns the alert's user, or nil if the alert is unknown or
+// already resolved. Acknowledging twice keeps the first time.
+func (db *PostgresDB) AcknowledgeResponderAlert(ctx context.Context, alertID, keyID uuid.UUID) (*uuid.UUID, error) {
+	query := `
+		WITH alert AS (
+			SELECT id, user_id FROM alerts WHERE id = $1 AND resolved_at IS NULL
+		), ack AS (
+			INSERT INTO responder_acks (alert_id, key_id)
+			SELECT id, $2 FROM alert
+			ON CONFLICT (alert_id, key_id) DO NOTHING
+		)
+		SELECT user_id FROM alert
+	`
+	var userID uuid.UUID
+	err := db.pool.QueryRow(ctx, query, alertID, keyID).Scan(&userID)
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &userID, nil
+}
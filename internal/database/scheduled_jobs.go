@@ -0,0 +1,69 @@
+package database
+
+import (
+	"context"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+)
+
+// Scheduled job operations
+
+// RecordJobRun saves the outcome of a job run as the job's latest status
+func (db *PostgresDB) RecordJobRun(ctx context.Context, run *models.JobRun) error {
+	var failed int64
+	var lastError *string
+	if run.Error != "" {
+		failed = 1
+		lastError = &run.Error
+	}
+	query := `
+		INSERT INTO scheduled_jobs (
+			name, schedule, singleton, last_started_at, last_duration_ms, last_error,
+			last_instance, next_run_at, runs, failures, updated_at
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, 1, $9, NOW())
+		ON CONFLICT (name) DO UPDATE
+		SET schedule = EXCLUDED.schedule,
+			singleton = EXCLUDED.singleton,
+			last_started_at = EXCLUDED.last_started_at,
+			last_duration_ms = EXCLUDED.last_duration_ms,
+			last_error = EXCLUDED.last_error,
+			last_instance = EXCLUDED.last_instance,
+			next_run_at = EXCLUDED.next_run_at,
+			runs = scheduled_jobs.runs + 1,
+			failures = scheduled_jobs.failures + EXCLUDED.failures,
+			updated_at = NOW()
+	`
+	_, err := db.pool.Exec(ctx, query,
+		run.Name, run.Schedule, run.Singleton, run.StartedAt, run.Duration.Milliseconds(), lastError,
+		run.Instance, run.NextRunAt, failed,
+	)
+	return err
+}
+
+// GetJobStatuses returns the recorded status of every job, by name
+func (db *PostgresDB) GetJobStatuses(ctx context.Context) ([]models.JobStatus, error) {
+	rows, err := db.pool.Query(ctx, `
+		SELECT name, schedule, singleton, last_started_at, last_duration_ms, COALESCE(last_error, ''),
+		       COALESCE(last_instance, ''), next_run_at, runs, failures
+		FROM scheduled_jobs
+		ORDER BY name
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	statuses := []models.JobStatus{}
+	for rows.Next() {
+		var s models.JobStatus
+		err := rows.Scan(
+			&s.Name, &s.Schedule, &s.Singleton, &s.LastStartedAt, &s.LastDurationMs, &s.LastError,
+			&s.LastInstance, &s.NextRunAt, &s.Runs, &s.Failures,
+		)
+		if err != nil {
+			return nil, err
+		}
+		statuses = append(statuses, s)
+	}
+	return statuses, rows.Err()
+}
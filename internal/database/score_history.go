@@ -0,0 +1,103 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+)
+
+// InsertScoreRecord stores one evaluation result
+func (db *PostgresDB) InsertScoreRecord(ctx context.Context, rec *models.ScoreRecord) error {
+	query := `
+		INSERT INTO score_history (user_id, evaluated_at, state, score, trend_penalty, deterministic, breakdown, reason)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	`
+	_, err := db.pool.Exec(ctx, query,
+		rec.UserID, rec.EvaluatedAt, rec.State, rec.Score, rec.TrendPenalty,
+		rec.Deterministic, rec.Breakdown, rec.Reason,
+	)
+	return err
+}
+
+// GetScoreHistory returns a user's records in [from, to], oldest first
+func (db *PostgresDB) GetScoreHistory(ctx context.Context, userID uuid.UUID, from, to time.Time, limit int) ([]models.ScoreRecord, error) {
+	query := `
+		SELECT id, user_id, evaluated_at, state, score, trend_penalty, deterministic, COALESCE(breakdown, '{}'::jsonb), COALESCE(reason, '')
+		FROM (
+			SELECT * FROM score_history
+			WHERE user_id = $1 AND evaluated_at BETWEEN $2 AND $3
+			ORDER BY evaluated_at DESC
+			LIMIT $4
+		) recent
+		ORDER BY evaluated_at ASC
+	`
+	rows, err := db.pool.Query(ctx, query, userID, from, to, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records []models.ScoreRecord
+	for rows.Next() {
+		var rec models.ScoreRecord
+		if err := rows.Scan(
+			&rec.ID, &rec.UserID, &rec.EvaluatedAt, &rec.State, &rec.Score,
+			&rec.TrendPenalty, &rec.Deterministic, &rec.Breakdown, &rec.Reason,
+		); err != nil {
+			return nil, err
+		}
+		records = append(records, rec)
+	}
+	return records, rows.Err()
+}
+
+// GetRecentScores returns the instantaneous scores (before any trend penalty)
+// of the user's last n scored evaluations, oldest first. Deterministic
+// results are skipped; their score is a fixed value, not a measurement.
+func (db *PostgresDB) GetRecentScores(ctx context.Context, userID uuid.UUID, n int) ([]int, error) {
+	return db.recentScores(ctx, userID, nil, n)
+}
+
+// GetRecentScoresBefore is GetRecentScores for evaluations before a time
+func (db *PostgresDB) GetRecentScoresBefore(ctx context.Context, userID uuid.UUID, before time.Time, n int) ([]int, error) {
+	return db.recentScores(ctx, userID, &before, n)
+}
+
+func (db *PostgresDB) recentScores(ctx context.Context, userID uuid.UUID, before *time.Time, n int) ([]int, error) {
+	query := `
+		SELECT score + trend_penalty FROM (
+			SELECT score, trend_penalty, evaluated_at FROM score_history
+			WHERE user_id = $1 AND NOT deterministic
+			  AND ($3::timestamptz IS NULL OR evaluated_at < $3)
+			ORDER BY evaluated_at DESC
+			LIMIT $2
+		) recent
+		ORDER BY evaluated_at ASC
+	`
+	rows, err := db.pool.Query(ctx, query, userID, n, before)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var scores []int
+	for rows.Next() {
+		var score int
+		if err := rows.Scan(&score); err != nil {
+			return nil, err
+		}
+		scores = append(scores, score)
+	}
+	return scores, rows.Err()
+}
+
+// DeleteScoreHistoryBefore prunes records older than cutoff
+func (db *PostgresDB) DeleteScoreHistoryBefore(ctx context.Context, cutoff time.Time) (int64, error) {
+	tag, err := db.pool.Exec(ctx, `DELETE FROM score_history WHERE evaluated_at < $1`, cutoff)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
@@ -0,0 +1,273 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+)
+
+// ErrCandidateChanged is returned by PromoteCandidateProfile when the
+// candidate is not the one the caller meant to promote
+var ErrCandidateChanged = errors.New("candidate scoring profile changed")
+
+// Scoring profile operations
+
+const scoringProfileColumns = `id, status, profile, note, created_by, created_at, promoted_at, retired_at`
+
+func scanScoringProfile(row pgx.Row) (*models.StoredScoringProfile, error) {
+	var p models.StoredScoringProfile
+	var profile []byte
+	err := row.Scan(&p.ID, &p.Status, &profile, &p.Note, &p.CreatedBy, &p.CreatedAt, &p.PromotedAt, &p.RetiredAt)
+	if err != nil {
+		return nil, err
+	}
+	p.Profile = profile
+	return &p, nil
+}
+
+// GetCurrentScoringProfiles returns the active and candidate profiles; either is nil if there is none
+func (db *PostgresDB) GetCurrentScoringProfiles(ctx context.Context) (active, candidate *models.StoredScoringProfile, err error) {
+	rows, err := db.pool.Query(ctx,
+		`SELECT `+scoringProfileColumns+` FROM scoring_profiles WHERE status IN ('active', 'candidate')`)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		p, err := scanScoringProfile(rows)
+		if err != nil {
+			return nil, nil, err
+		}
+		if p.Status == models.ScoringProfileActive {
+			active = p
+		} else {
+			candidate = p
+		}
+	}
+	return active, candidate, rows.Err()
+}
+
+// GetScoringProfile returns a stored profile by ID, or nil
+func (db *PostgresDB) GetScoringProfile(ctx context.Context, id uuid.UUID) (*models.StoredScoringProfile, error) {
+	p, err := scanScoringProfile(db.pool.QueryRow(ctx,
+		`SELECT `+scoringProfileColumns+` FROM scoring_profiles WHERE id = $1`, id))
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	return p, err
+}
+
+// GetScoringProfileAt returns the profile that was active at the given time,
+// or nil if none was stored yet and the one derived from config applied
+func (db *PostgresDB) GetScoringProfileAt(ctx context.Context, at time.Time) (*models.StoredScoringProfile, error) {
+	p, err := scanScoringProfile(db.pool.QueryRow(ctx, `
+		SELECT `+scoringProfileColumns+` FROM scoring_profiles
+		WHERE promoted_at <= $1 AND (retired_at IS NULL OR retired_at > $1)
+		ORDER BY promoted_at DESC
+		LIMIT 1
+	`, at))
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	return p, err
+}
+
+// ReplaceCandidateProfile stores p as the candidate, retiring any previous one
+func (db *PostgresDB) ReplaceCandidateProfile(ctx context.Context, p *models.StoredScoringProfile) error {
+	tx, err := db.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx,
+		`UPDATE scoring_profiles SET status = 'retired', retired_at = NOW() WHERE status = 'candidate'`); err != nil {
+		return err
+	}
+	_, err = tx.Exec(ctx, `
+		INSERT INTO scoring_profiles (id, status, profile, note, created_by, created_at)
+		VALUES ($1, 'candidate', $2, $3, $4, $5)
+	`, p.ID, []byte(p.Profile), p.Note, p.CreatedBy, p.CreatedAt)
+	if err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// RetireCandidateProfile stops shadow evaluation; it reports whether there was a candidate
+func (db *PostgresDB) RetireCandidateProfile(ctx context.Context) (bool, error) {
+	tag, err := db.pool.Exec(ctx,
+		`UPDATE scoring_profiles SET status = 'retired', retired_at = NOW() WHERE status = 'candidate'`)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// PromoteCandidateProfile makes the candidate with the given ID active and
+// retires the active profile, in one transaction. It fails with
+// ErrCandidateChanged, changing nothing, if that is not the current candidate.
+func (db *PostgresDB) PromoteCandidateProfile(ctx context.Context, candidateID uuid.UUID) (*models.StoredScoringProfile, error) {
+	tx, err := db.pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	// Lock both rows so a concurrent replace or promote waits for this one
+	if _, err := tx.Exec(ctx,
+		`SELECT id FROM scoring_profiles WHERE status IN ('active', 'candidate') FOR UPDATE`); err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(ctx,
+		`UPDATE scoring_profiles SET status = 'retired', retired_at = NOW() WHERE status = 'active'`); err != nil {
+		return nil, err
+	}
+	promoted, err := scanScoringProfile(tx.QueryRow(ctx, `
+		UPDATE scoring_profiles SET status = 'active', promoted_at = NOW()
+		WHERE id = $1 AND status = 'candidate'
+		RETURNING `+scoringProfileColumns,
+		candidateID))
+	if err == pgx.ErrNoRows {
+		return nil, ErrCandidateChanged
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return promoted, nil
+}
+
+// Shadow result operations
+
+// CopyShadowResults bulk-inserts shadow results
+func (db *PostgresDB) CopyShadowResults(ctx context.Context, results []*models.ShadowResult) (int64, error) {
+	rows := make([][]interface{}, 0, len(results))
+	for _, r := range results {
+		rules, err := json.Marshal(r.CandidateRules)
+		if err != nil {
+			return 0, err
+		}
+		flags, err := json.Marshal(r.Flags)
+		if err != nil {
+			return 0, err
+		}
+		rows = append(rows, []interface{}{
+			r.CandidateID, r.UserID, r.EvaluatedAt,
+			r.ActiveState, r.ActiveScore, r.ActiveReason,
+			r.CandidateState, r.CandidateScore, r.CandidateReason, string(rules), r.Diverged, string(flags),
+		})
+	}
+
+	return db.pool.CopyFrom(ctx,
+		pgx.Identifier{"shadow_results"},
+		[]string{"candidate_id", "user_id", "evaluated_at", "active_state", "active_score", "active_reason",
+			"candidate_state", "candidate_score", "candidate_reason", "candidate_rules", "diverged", "flags"},
+		pgx.CopyFromRows(rows),
+	)
+}
+
+// GetShadowDiff summarizes the candidate's shadow results between from and
+// to, with the latest divergence of up to samples flipped users
+func (db *PostgresDB) GetShadowDiff(ctx context.Context, candidateID uuid.UUID, from, to time.Time, samples int) (*models.ShadowDiff, error) {
+	diff := &models.ShadowDiff{
+		Transitions: []models.ShadowTransition{},
+		Samples:     []models.ShadowResult{},
+	}
+	err := db.pool.QueryRow(ctx, `
+		SELECT COUNT(*),
+		       COUNT(DISTINCT user_id),
+		       COUNT(*) FILTER (WHERE diverged),
+		       COUNT(DISTINCT user_id) FILTER (WHERE diverged),
+		       COUNT(DISTINCT user_id) FILTER (WHERE diverged
+		           AND candidate_state IN ('AT_RISK', 'ALERT') AND active_state NOT IN ('AT_RISK', 'ALERT')),
+		       COUNT(DISTINCT user_id) FILTER (WHERE diverged
+		           AND active_state IN ('AT_RISK', 'ALERT') AND candidate_state NOT IN ('AT_RISK', 'ALERT')),
+		       COALESCE(AVG(candidate_score - active_score), 0),
+		       COALESCE(MIN(candidate_score - active_score), 0),
+		       COALESCE(MAX(candidate_score - active_score), 0)
+		FROM shadow_results
+		WHERE candidate_id = $1 AND evaluated_at BETWEEN $2 AND $3
+	`, candidateID, from, to).Scan(
+		&diff.Evaluations, &diff.Users, &diff.DivergedEvaluations, &diff.UsersFlipped,
+		&diff.WouldAlertUsers, &diff.WouldSpareUsers,
+		&diff.ScoreDeltaMean, &diff.ScoreDeltaMin, &diff.ScoreDeltaMax,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.pool.Query(ctx, `
+		SELECT active_state, candidate_state, COUNT(*), COUNT(DISTINCT user_id)
+		FROM shadow_results
+		WHERE candidate_id = $1 AND evaluated_at BETWEEN $2 AND $3 AND diverged
+		GROUP BY active_state, candidate_state
+		ORDER BY COUNT(*) DESC
+	`, candidateID, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var t models.ShadowTransition
+		if err := rows.Scan(&t.ActiveState, &t.CandidateState, &t.Evaluations, &t.Users); err != nil {
+			return nil, err
+		}
+		diff.Transitions = append(diff.Transitions, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = db.pool.Query(ctx, `
+		SELECT candidate_id, user_id, evaluated_at, active_state, active_score, active_reason,
+		       candidate_state, candidate_score, candidate_reason, candidate_rules, diverged, flags
+		FROM (
+			SELECT DISTINCT ON (user_id) *
+			FROM shadow_results
+			WHERE candidate_id = $1 AND evaluated_at BETWEEN $2 AND $3 AND diverged
+			ORDER BY user_id, evaluated_at DESC
+		) latest
+		ORDER BY evaluated_at DESC
+		LIMIT $4
+	`, candidateID, from, to, samples)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var r models.ShadowResult
+		var rules models.StringArray
+		var flags []byte
+		err := rows.Scan(
+			&r.CandidateID, &r.UserID, &r.EvaluatedAt, &r.ActiveState, &r.ActiveScore, &r.ActiveReason,
+			&r.CandidateState, &r.CandidateScore, &r.CandidateReason, &rules, &r.Diverged, &flags,
+		)
+		if err != nil {
+			return nil, err
+		}
+		r.CandidateRules = rules
+		if err := json.Unmarshal(flags, &r.Flags); err != nil {
+			return nil, err
+		}
+		diff.Samples = append(diff.Samples, r)
+	}
+	return diff, rows.Err()
+}
+
+// DeleteShadowResultsBefore removes shadow results evaluated before cutoff
+func (db *PostgresDB) DeleteShadowResultsBefore(ctx context.Context, cutoff time.Time) (int64, error) {
+	tag, err := db.pool.Exec(ctx, `DELETE FROM shadow_results WHERE evaluated_at < $1`, cutoff)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
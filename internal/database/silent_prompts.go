@@ -0,0 +1,52 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+)
+
+// Check-in prompt operations
+
+// CreateSilentPrompt records how a check-in prompt ended
+func (db *PostgresDB) CreateSilentPrompt(ctx context.Context, p *models.SilentPrompt) error {
+	query := `
+		INSERT INTO silent_prompts (
+			id, user_id, ladder, timeout_seconds, attempts_sent, outcome,
+			answered_attempt, answered_channel, answered_via, started_at, ended_at
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+	`
+	_, err := db.pool.Exec(ctx, query,
+		p.ID, p.UserID, p.Ladder, p.TimeoutSeconds, p.AttemptsSent, p.Outcome,
+		p.AnsweredAttempt, p.AnsweredChannel, p.AnsweredVia, p.StartedAt, p.EndedAt,
+	)
+	return err
+}
+
+// GetSilentPromptStats counts the prompts started in [from, to) by outcome,
+// and the answered ones by the attempt and channel that got the answer
+func (db *PostgresDB) GetSilentPromptStats(ctx context.Context, from, to time.Time) ([]models.SilentPromptStat, error) {
+	query := `
+		SELECT outcome, COALESCE(answered_attempt, 0), COALESCE(answered_channel, ''), COUNT(*)
+		FROM silent_prompts
+		WHERE started_at >= $1 AND started_at < $2
+		GROUP BY 1, 2, 3
+		ORDER BY 1, 2, 3
+	`
+	rows, err := db.pool.Query(ctx, query, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stats := []models.SilentPromptStat{}
+	for rows.Next() {
+		var s models.SilentPromptStat
+		if err := rows.Scan(&s.Outcome, &s.Attempt, &s.Channel, &s.Count); err != nil {
+			return nil, err
+		}
+		stats = append(stats, s)
+	}
+	return stats, rows.Err()
+}
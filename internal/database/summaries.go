@@ -0,0 +1,283 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// summaryFixes selects the user's located heartbeats in [$2, $3) that are
+// trustworthy enough to measure movement by: with a fix, at most $4 meters
+// inaccurate and not suspected of spoofing. Devices are kept apart so two
+// phones carried together don't zig-zag between each other's fixes.
+const summaryFixes = `
+	fixes AS (
+		SELECT COALESCE(device_id, '') AS device, lat, lng, timestamp
+		FROM heartbeats
+		WHERE user_id = $1 AND timestamp >= $2 AND timestamp < $3 AND duplicate_of IS NULL
+		  AND NOT (lat = 0 AND lng = 0) AND NOT spoof_suspected AND accuracy_m <= $4
+	)`
+
+// Daily summary operations
+
+// ListDailySummaryCandidates returns users with a heartbeat since
+// activeSince who haven't turned summaries off, or who asked for a daily SMS
+// confirmation, in ID order after the given one
+func (db *PostgresDB) ListDailySummaryCandidates(ctx context.Context, activeSince time.Time, after uuid.UUID, limit int) ([]models.DailySummaryCandidate, error) {
+	query := `
+		SELECT u.id, u.org_id, u.phone, u.settings, COALESCE((SELECT MAX(d.date) FROM daily_summaries d WHERE d.user_id = u.id)::text, '')
+		FROM users u
+		WHERE u.id > $2
+		  AND (NOT COALESCE((u.settings->>'daily_summary_disabled')::boolean, false)
+		       OR COALESCE((u.settings->>'sms_daily_confirmation')::boolean, false))
+		  AND EXISTS (SELECT 1 FROM heartbeats h WHERE h.user_id = u.id AND h.timestamp >= $1)
+		ORDER BY u.id
+		LIMIT $3
+	`
+	rows, err := db.pool.Query(ctx, query, activeSince, after, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var candidates []models.DailySummaryCandidate
+	for rows.Next() {
+		var c models.DailySummaryCandidate
+		if err := rows.Scan(&c.UserID, &c.OrgID, &c.Phone, &c.Settings, &c.LastDate); err != nil {
+			return nil, err
+		}
+		candidates = append(candidates, c)
+	}
+	return candidates, rows.Err()
+}
+
+// BuildDailySummary aggregates the user's heartbeats and state transitions
+// in [from, to) into s, which must carry the user, date and time zone. Fixes
+// less accurate than maxAccuracyM don't count toward distance or safe zone
+// crossings. episodeStates are the non-SAFE states an episode is made of,
+// least severe first. It reports whether the user had any heartbeats.
+func (db *PostgresDB) BuildDailySummary(ctx context.Context, s *models.DailySummary, from, to time.Time, maxAccuracyM int, episodeStates []string, zones []models.Geofence) (bool, error) {
+	rows, err := db.pool.Query(ctx, `
+		SELECT source, COUNT(*), MIN(battery_pct)
+		FROM heartbeats
+		WHERE user_id = $1 AND timestamp >= $2 AND timestamp < $3 AND duplicate_of IS NULL
+		GROUP BY source
+	`, s.UserID, from, to)
+	if err != nil {
+		return false, err
+	}
+	s.Heartbeats, s.HeartbeatsBySource, s.MinBatteryPct = 0, map[string]int{}, nil
+	for rows.Next() {
+		var source string
+		var count int
+		var battery *int
+		if err := rows.Scan(&source, &count, &battery); err != nil {
+			rows.Close()
+			return false, err
+		}
+		s.Heartbeats += count
+		s.HeartbeatsBySource[source] = count
+		if battery != nil && (s.MinBatteryPct == nil || *battery < *s.MinBatteryPct) {
+			s.MinBatteryPct = battery
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return false, err
+	}
+	if s.Heartbeats == 0 {
+		return false, nil
+	}
+
+	// The farthest any one device went, so a second phone doesn't double it
+	err = db.pool.QueryRow(ctx, `
+		WITH`+summaryFixes+`,
+		legs AS (
+			SELECT device, lat, lng,
+				LAG(lat) OVER w AS prev_lat, LAG(lng) OVER w AS prev_lng
+			FROM fixes
+			WINDOW w AS (PARTITION BY device ORDER BY timestamp)
+		)
+		SELECT COALESCE(MAX(distance), 0) FROM (
+			SELECT device, SUM(haversine_m(prev_lat, prev_lng, lat, lng)) AS distance
+			FROM legs
+			WHERE prev_lat IS NOT NULL
+			GROUP BY device
+		) d
+	`, s.UserID, from, to, maxAccuracyM).Scan(&s.DistanceM)
+	if err != nil {
+		return false, err
+	}
+
+	if s.Episodes, err = db.summaryEpisodes(ctx, s.UserID, from, to, episodeStates); err != nil {
+		return false, err
+	}
+	if s.GeofenceEvents, err = db.summaryGeofenceEvents(ctx, s.UserID, from, to, maxAccuracyM, zones); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// summaryEpisodes returns the episodes that began in [from, to): entries
+// into one of states from SAFE or any other state, each with the most
+// severe state reached before the user was next SAFE
+func (db *PostgresDB) summaryEpisodes(ctx context.Context, userID uuid.UUID, from, to time.Time, states []string) ([]models.SummaryEpisode, error) {
+	rows, err := db.pool.Query(ctx, `
+		SELECT COALESCE(peak.to_state, s.to_state), s.timestamp, res.resolved_at
+		FROM user_states s
+		LEFT JOIN LATERAL (
+			SELECT MIN(r.timestamp) AS resolved_at
+			FROM user_states r
+			WHERE r.user_id = s.user_id AND r.timestamp > s.timestamp AND r.to_state = 'SAFE'
+		) res ON true
+		LEFT JOIN LATERAL (
+			SELECT p.to_state
+			FROM user_states p
+			WHERE p.user_id = s.user_id AND p.timestamp >= s.timestamp
+			  AND p.timestamp < COALESCE(res.resolved_at, 'infinity') AND p.to_state = ANY($4::text[])
+			ORDER BY array_position($4::text[], p.to_state::text) DESC
+			LIMIT 1
+		) peak ON true
+		WHERE s.user_id = $1 AND s.timestamp >= $2 AND s.timestamp < $3
+		  AND s.to_state = ANY($4::text[]) AND (s.from_state IS NULL OR NOT s.from_state = ANY($4::text[]))
+		ORDER BY s.timestamp
+	`, userID, from, to, states)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	episodes := []models.SummaryEpisode{}
+	for rows.Next() {
+		var e models.SummaryEpisode
+		if err := rows.Scan(&e.State, &e.StartedAt, &e.ResolvedAt); err != nil {
+			return nil, err
+		}
+		episodes = append(episodes, e)
+	}
+	return episodes, rows.Err()
+}
+
+// summaryGeofenceEvents counts entries into and exits from each safe zone
+// between consecutive fixes of a device in [from, to), taking the device
+// that crossed most
+func (db *PostgresDB) summaryGeofenceEvents(ctx context.Context, userID uuid.UUID, from, to time.Time, maxAccuracyM int, zones []models.Geofence) ([]models.GeofenceActivity, error) {
+	events := []models.GeofenceActivity{}
+	if len(zones) == 0 {
+		return events, nil
+	}
+	zonesJSON, err := json.Marshal(zones)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.pool.Query(ctx, `
+		WITH`+summaryFixes+`,
+		zones AS (
+			SELECT (z.idx - 1)::int AS zone, z.fence
+			FROM jsonb_array_elements($5::jsonb) WITH ORDINALITY AS z(fence, idx)
+		),
+		presence AS (
+			SELECT z.zone, f.device, f.timestamp, geofence_contains(z.fence, f.lat, f.lng) AS inside
+			FROM zones z CROSS JOIN fixes f
+		),
+		crossings AS (
+			SELECT zone, device, inside,
+				LAG(inside) OVER (PARTITION BY zone, device ORDER BY timestamp) AS was_inside
+			FROM presence
+		)
+		SELECT zone, MAX(entries), MAX(exits) FROM (
+			SELECT zone, device,
+				COUNT(*) FILTER (WHERE inside AND NOT was_inside) AS entries,
+				COUNT(*) FILTER (WHERE was_inside AND NOT inside) AS exits
+			FROM crossings
+			GROUP BY zone, device
+		) d
+		GROUP BY zone
+		ORDER BY zone
+	`, userID, from, to, maxAccuracyM, zonesJSON)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var e models.GeofenceActivity
+		if err := rows.Scan(&e.Zone, &e.Entries, &e.Exits); err != nil {
+			return nil, err
+		}
+		if e.Entries > 0 || e.Exits > 0 {
+			events = append(events, e)
+		}
+	}
+	return events, rows.Err()
+}
+
+// CreateDailySummary stores s unless the user already has one for the day,
+// e.g. built by another instance, and reports whether it did
+func (db *PostgresDB) CreateDailySummary(ctx context.Context, s *models.DailySummary) (bool, error) {
+	bySource, err := json.Marshal(s.HeartbeatsBySource)
+	if err != nil {
+		return false, err
+	}
+	episodes, err := json.Marshal(s.Episodes)
+	if err != nil {
+		return false, err
+	}
+	geofenceEvents, err := json.Marshal(s.GeofenceEvents)
+	if err != nil {
+		return false, err
+	}
+
+	tag, err := db.pool.Exec(ctx, `
+		INSERT INTO daily_summaries (user_id, date, timezone, heartbeats, heartbeats_by_source, distance_m,
+			min_battery_pct, episodes, geofence_events, created_at)
+		VALUES ($1, $2::text::date, $3, $4, $5, $6, $7, $8, $9, $10)
+		ON CONFLICT (user_id, date) DO NOTHING
+	`, s.UserID, s.Date, s.Timezone, s.Heartbeats, bySource, s.DistanceM,
+		s.MinBatteryPct, episodes, geofenceEvents, s.CreatedAt)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// MarkDailySummaryNotified records when the user was sent the summary
+func (db *PostgresDB) MarkDailySummaryNotified(ctx context.Context, userID uuid.UUID, date string, at time.Time) error {
+	_, err := db.pool.Exec(ctx,
+		`UPDATE daily_summaries SET notified_at = $3 WHERE user_id = $1 AND date = $2::text::date`, userID, date, at)
+	return err
+}
+
+// GetDailySummary returns the user's summary for a local day, or nil
+func (db *PostgresDB) GetDailySummary(ctx context.Context, userID uuid.UUID, date string) (*models.DailySummary, error) {
+	var s models.DailySummary
+	var bySource, episodes, geofenceEvents []byte
+	err := db.pool.QueryRow(ctx, `
+		SELECT user_id, date::text, timezone, heartbeats, heartbeats_by_source, distance_m, min_battery_pct,
+			episodes, geofence_events, created_at, notified_at
+		FROM daily_summaries
+		WHERE user_id = $1 AND date = $2::text::date
+	`, userID, date).Scan(&s.UserID, &s.Date, &s.Timezone, &s.Heartbeats, &bySource, &s.DistanceM, &s.MinBatteryPct,
+		&episodes, &geofenceEvents, &s.CreatedAt, &s.NotifiedAt)
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(bySource, &s.HeartbeatsBySource); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(episodes, &s.Episodes); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(geofenceEvents, &s.GeofenceEvents); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
@@ -0,0 +1,116 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+	"github.com/google/uuid"
+)
+
+// ErrTrailChanged is returned when a trail's file_url changed while it was
+// being moved to object storage
+var ErrTrailChanged = errors.New("trail changed during migration")
+
+// Blackbox trail migration operations
+
+// GetInlineTrails returns up to limit trails still stored as data URIs that
+// come after (afterUploaded, afterID), oldest first. Trails whose migration
+// failed are left out unless retryFailed is set.
+func (db *PostgresDB) GetInlineTrails(ctx context.Context, afterUploaded time.Time, afterID uuid.UUID, retryFailed bool, limit int) ([]models.InlineTrail, error) {
+	query := `
+		SELECT t.id, t.user_id, t.file_url, t.uploaded_at
+		FROM blackbox_trails t
+		LEFT JOIN blackbox_trail_migrations m ON m.trail_id = t.id
+		WHERE t.file_url LIKE 'data:%'
+			AND (t.uploaded_at, t.id) > ($1, $2)
+			AND ($3 OR m.status IS DISTINCT FROM 'failed')
+		ORDER BY t.uploaded_at, t.id
+		LIMIT $4
+	`
+	rows, err := db.pool.Query(ctx, query, afterUploaded, afterID, retryFailed, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var trails []models.InlineTrail
+	for rows.Next() {
+		var t models.InlineTrail
+		if err := rows.Scan(&t.ID, &t.UserID, &t.FileURL, &t.UploadedAt); err != nil {
+			return nil, err
+		}
+		trails = append(trails, t)
+	}
+	return trails, rows.Err()
+}
+
+// CompleteTrailMigration points the trail at its object key and records the
+// migration, in one transaction. It fails with ErrTrailChanged, changing
+// nothing, if the trail's file_url is no longer inlineURL.
+func (db *PostgresDB) CompleteTrailMigration(ctx context.Context, trailID uuid.UUID, inlineURL, key, sha256 string, storedBytes int) error {
+	tx, err := db.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(ctx,
+		`UPDATE blackbox_trails SET file_url = $3 WHERE id = $1 AND file_url = $2`,
+		trailID, inlineURL, key)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrTrailChanged
+	}
+
+	_, err = tx.Exec(ctx, `
+		INSERT INTO blackbox_trail_migrations
+			(trail_id, status, object_key, inline_bytes, stored_bytes, sha256, error)
+		VALUES ($1, 'done', $2, $3, $4, $5, NULL)
+		ON CONFLICT (trail_id) DO UPDATE SET
+			status = 'done', object_key = EXCLUDED.object_key,
+			inline_bytes = EXCLUDED.inline_bytes, stored_bytes = EXCLUDED.stored_bytes,
+			sha256 = EXCLUDED.sha256, error = NULL,
+			attempts = blackbox_trail_migrations.attempts + 1, updated_at = NOW()
+	`, trailID, key, len(inlineURL), storedBytes, sha256)
+	if err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// RecordTrailMigrationFailure records why a trail could not be moved; it
+// stays inline and is skipped by later runs unless they retry failures
+func (db *PostgresDB) RecordTrailMigrationFailure(ctx context.Context, trailID uuid.UUID, inlineBytes int, reason string) error {
+	_, err := db.pool.Exec(ctx, `
+		INSERT INTO blackbox_trail_migrations (trail_id, status, inline_bytes, error)
+		VALUES ($1, 'failed', $2, $3)
+		ON CONFLICT (trail_id) DO UPDATE SET
+			status = 'failed', inline_bytes = EXCLUDED.inline_bytes, error = EXCLUDED.error,
+			attempts = blackbox_trail_migrations.attempts + 1, updated_at = NOW()
+	`, trailID, inlineBytes, reason)
+	return err
+}
+
+// GetTrailMigrationCounts summarizes the migration's progress
+func (db *PostgresDB) GetTrailMigrationCounts(ctx context.Context) (*models.TrailMigrationCounts, error) {
+	query := `
+		SELECT
+			(SELECT COUNT(*) FROM blackbox_trails t
+				LEFT JOIN blackbox_trail_migrations m ON m.trail_id = t.id
+				WHERE t.file_url LIKE 'data:%' AND m.status IS DISTINCT FROM 'failed'),
+			COUNT(*) FILTER (WHERE status = 'done'),
+			COUNT(*) FILTER (WHERE status = 'failed'),
+			COALESCE(SUM(inline_bytes - stored_bytes) FILTER (WHERE status = 'done'), 0)
+		FROM blackbox_trail_migrations
+	`
+	var counts models.TrailMigrationCounts
+	err := db.pool.QueryRow(ctx, query).Scan(&counts.Pending, &counts.Done, &counts.Failed, &counts.SavedBytes)
+	if err != nil {
+		return nil, err
+	}
+	return &counts, nil
+}
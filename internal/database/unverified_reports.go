@@ -0,0 +1,91 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// Unverified report operations
+
+const unverifiedReportColumns = `id, user_id, alert_id, lat, lng, accuracy_m, reported_at, received_at,
+	COALESCE(client_ip, ''), trust, verification_sent_at, answered_at`
+
+func scanUnverifiedReport(row pgx.Row) (*models.UnverifiedReport, error) {
+	var r models.UnverifiedReport
+	err := row.Scan(
+		&r.ID, &r.UserID, &r.AlertID, &r.Lat, &r.Lng, &r.AccuracyM, &r.ReportedAt, &r.ReceivedAt,
+		&r.ClientIP, &r.Trust, &r.VerificationSentAt, &r.AnsweredAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// CreateUnverifiedReport stores a report
+func (db *PostgresDB) CreateUnverifiedReport(ctx context.Context, r *models.UnverifiedReport) error {
+	_, err := db.pool.Exec(ctx, `
+		INSERT INTO unverified_reports (id, user_id, alert_id, lat, lng, accuracy_m, reported_at, received_at, client_ip, trust)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, NULLIF($9, ''), $10)
+	`, r.ID, r.UserID, r.AlertID, r.Lat, r.Lng, r.AccuracyM, r.ReportedAt, r.ReceivedAt, r.ClientIP, r.Trust)
+	return err
+}
+
+// MarkReportVerificationSent records when the user was asked to confirm a report
+func (db *PostgresDB) MarkReportVerificationSent(ctx context.Context, id uuid.UUID, at time.Time) error {
+	_, err := db.pool.Exec(ctx, `UPDATE unverified_reports SET verification_sent_at = $2 WHERE id = $1`, id, at)
+	return err
+}
+
+// GetUnverifiedReport returns the report, or nil if there is none
+func (db *PostgresDB) GetUnverifiedReport(ctx context.Context, id uuid.UUID) (*models.UnverifiedReport, error) {
+	r, err := scanUnverifiedReport(db.pool.QueryRow(ctx,
+		`SELECT `+unverifiedReportColumns+` FROM unverified_reports WHERE id = $1`, id))
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	return r, err
+}
+
+// AnswerUnverifiedReport sets the trust of a report still unverified and
+// reports whether it did, so a report is only answered once
+func (db *PostgresDB) AnswerUnverifiedReport(ctx context.Context, id uuid.UUID, trust string, at time.Time) (bool, error) {
+	tag, err := db.pool.Exec(ctx, `
+		UPDATE unverified_reports SET trust = $2, answered_at = $3
+		WHERE id = $1 AND trust = $4
+	`, id, trust, at, models.ReportUnverified)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+// GetAlertUnverifiedReports returns up to limit of the alert's reports the
+// user didn't deny, newest first
+func (db *PostgresDB) GetAlertUnverifiedReports(ctx context.Context, alertID uuid.UUID, limit int) ([]models.UnverifiedReport, error) {
+	rows, err := db.pool.Query(ctx, `
+		SELECT `+unverifiedReportColumns+`
+		FROM unverified_reports
+		WHERE alert_id = $1 AND trust <> $2
+		ORDER BY received_at DESC
+		LIMIT $3
+	`, alertID, models.ReportDenied, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	reports := []models.UnverifiedReport{}
+	for rows.Next() {
+		r, err := scanUnverifiedReport(rows)
+		if err != nil {
+			return nil, err
+		}
+		reports = append(reports, *r)
+	}
+	return reports, rows.Err()
+}
@@ -0,0 +1,125 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+const stateTransitionColumns = `id, user_id, from_state, to_state, score, reason, triggered_by, timestamp`
+
+func scanStateTransition(row pgx.Row) (*models.StateTransition, error) {
+	var t models.StateTransition
+	err := row.Scan(&t.ID, &t.UserID, &t.FromState, &t.ToState, &t.Score, &t.Reason, &t.TriggeredBy, &t.Timestamp)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// State transition operations
+
+// RecordStateTransition stores t if its state differs from the user's latest
+// recorded one, setting t.ID and t.FromState, and reports whether it did.
+// Recording is serialized per user by a transaction-scoped advisory lock, so
+// concurrent evaluations can't both record a change from the same state.
+func (db *PostgresDB) RecordStateTransition(ctx context.Context, t *models.StateTransition) (bool, error) {
+	tx, err := db.pool.Begin(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock(hashtextextended($1::text, 0))`, t.UserID); err != nil {
+		return false, err
+	}
+
+	var from *string
+	err = tx.QueryRow(ctx, `
+		SELECT to_state FROM user_states
+		WHERE user_id = $1
+		ORDER BY timestamp DESC, id DESC
+		LIMIT 1
+	`, t.UserID).Scan(&from)
+	if err != nil && err != pgx.ErrNoRows {
+		return false, err
+	}
+	if from != nil && *from == t.ToState {
+		return false, nil
+	}
+
+	err = tx.QueryRow(ctx, `
+		INSERT INTO user_states (user_id, from_state, to_state, score, reason, triggered_by, timestamp)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id
+	`, t.UserID, from, t.ToState, t.Score, t.Reason, t.TriggeredBy, t.Timestamp).Scan(&t.ID)
+	if err != nil {
+		return false, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return false, err
+	}
+	t.FromState = from
+	return true, nil
+}
+
+// GetLatestUserState returns the user's latest recorded transition, or nil
+func (db *PostgresDB) GetLatestUserState(ctx context.Context, userID uuid.UUID) (*models.StateTransition, error) {
+	query := `
+		SELECT ` + stateTransitionColumns + `
+		FROM user_states
+		WHERE user_id = $1
+		ORDER BY timestamp DESC, id DESC
+		LIMIT 1
+	`
+	t, err := scanStateTransition(db.pool.QueryRow(ctx, query, userID))
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	return t, err
+}
+
+// GetUserStateAt returns the user's transition in effect at the given time, or nil
+func (db *PostgresDB) GetUserStateAt(ctx context.Context, userID uuid.UUID, at time.Time) (*models.StateTransition, error) {
+	query := `
+		SELECT ` + stateTransitionColumns + `
+		FROM user_states
+		WHERE user_id = $1 AND timestamp <= $2
+		ORDER BY timestamp DESC, id DESC
+		LIMIT 1
+	`
+	t, err := scanStateTransition(db.pool.QueryRow(ctx, query, userID, at))
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	return t, err
+}
+
+// GetStateTransitions returns the user's transitions since the given time,
+// newest first
+func (db *PostgresDB) GetStateTransitions(ctx context.Context, userID uuid.UUID, since time.Time) ([]models.StateTransition, error) {
+	query := `
+		SELECT ` + stateTransitionColumns + `
+		FROM user_states
+		WHERE user_id = $1 AND timestamp >= $2
+		ORDER BY timestamp DESC, id DESC
+	`
+	rows, err := db.pool.Query(ctx, query, userID, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var transitions []models.StateTransition
+	for rows.Next() {
+		t, err := scanStateTransition(rows)
+		if err != nil {
+			return nil, err
+		}
+		transitions = append(transitions, *t)
+	}
+	return transitions, rows.Err()
+}
@@ -0,0 +1,142 @@
+package database
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+)
+
+func recordState(t *testing.T, db *PostgresDB, userID uuid.UUID, state string, at time.Time) (*models.StateTransition, bool) {
+	t.Helper()
+	transition := &models.StateTransition{UserID: userID, ToState: state, Score: 50, Reason: "test", TriggeredBy: "heartbeat", Timestamp: at}
+	recorded, err := db.RecordStateTransition(context.Background(), transition)
+	if err != nil {
+		t.Errorf("RecordStateTransition: %v", err)
+	}
+	return transition, recorded
+}
+
+// Only changes are recorded, each from the state before it
+func TestRecordStateTransition(t *testing.T) {
+	db := testPostgres(t)
+	ctx := context.Background()
+	user := createTestUser(t, db, "Ada")
+	start := time.Now().Truncate(time.Second)
+
+	first, recorded := recordState(t, db, user.ID, "SAFE", start)
+	if !recorded || first.FromState != nil {
+		t.Fatalf("first state recorded = %v from %v, want recorded from nil", recorded, first.FromState)
+	}
+	if _, recorded := recordState(t, db, user.ID, "SAFE", start.Add(time.Minute)); recorded {
+		t.Error("unchanged state recorded")
+	}
+	second, recorded := recordState(t, db, user.ID, "AT_RISK", start.Add(2*time.Minute))
+	if !recorded || second.FromState == nil || *second.FromState != "SAFE" {
+		t.Fatalf("change recorded = %v from %v, want recorded from SAFE", recorded, second.FromState)
+	}
+
+	latest, err := db.GetLatestUserState(ctx, user.ID)
+	if err != nil || latest == nil || latest.ID != second.ID {
+		t.Errorf("GetLatestUserState = %+v, %v; want transition %d", latest, err, second.ID)
+	}
+	at, err := db.GetUserStateAt(ctx, user.ID, start.Add(90*time.Second))
+	if err != nil || at == nil || at.ID != first.ID {
+		t.Errorf("GetUserStateAt = %+v, %v; want transition %d", at, err, first.ID)
+	}
+	history, err := db.GetStateTransitions(ctx, user.ID, start)
+	if err != nil || len(history) != 2 || history[0].ID != second.ID {
+		t.Errorf("GetStateTransitions = %+v, %v; want two, newest first", history, err)
+	}
+	if latest, err := db.GetLatestUserState(ctx, uuid.New()); err != nil || latest != nil {
+		t.Errorf("GetLatestUserState of a stranger = %+v, %v; want nil", latest, err)
+	}
+}
+
+// Evaluations racing to record the same change record it once
+func TestRecordStateTransitionConcurrentSameChange(t *testing.T) {
+	db := testPostgres(t)
+	user := createTestUser(t, db, "Ada")
+	now := time.Now().Truncate(time.Second)
+	recordState(t, db, user.ID, "SAFE", now)
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		recorded []*models.StateTransition
+	)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if transition, ok := recordState(t, db, user.ID, "AT_RISK", now.Add(time.Minute)); ok {
+				mu.Lock()
+				recorded = append(recorded, transition)
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if len(recorded) != 1 {
+		t.Fatalf("%d evaluations recorded the change, want 1", len(recorded))
+	}
+	if from := recorded[0].FromState; from == nil || *from != "SAFE" {
+		t.Errorf("change recorded from %v, want SAFE", from)
+	}
+}
+
+// Evaluations racing between states leave an unbroken chain: every
+// transition starts where the one before it ended, and none is a no-op
+func TestRecordStateTransitionConcurrentChain(t *testing.T) {
+	db := testPostgres(t)
+	ctx := context.Background()
+	user := createTestUser(t, db, "Ada")
+	// One timestamp for every writer, so the history is ordered as recorded
+	now := time.Now().Truncate(time.Second)
+	states := []string{"SAFE", "CAUTION", "AT_RISK"}
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		recorded int
+	)
+	for i := 0; i < 30; i++ {
+		wg.Add(1)
+		go func(state string) {
+			defer wg.Done()
+			if _, ok := recordState(t, db, user.ID, state, now); ok {
+				mu.Lock()
+				recorded++
+				mu.Unlock()
+			}
+		}(states[i%len(states)])
+	}
+	wg.Wait()
+
+	history, err := db.GetStateTransitions(ctx, user.ID, now)
+	if err != nil {
+		t.Fatalf("GetStateTransitions: %v", err)
+	}
+	if len(history) != recorded {
+		t.Fatalf("%d transitions stored, %d reported recorded", len(history), recorded)
+	}
+	// Newest first: walk it oldest first
+	var previous *string
+	for i := len(history) - 1; i >= 0; i-- {
+		transition := history[i]
+		switch {
+		case previous == nil && transition.FromState != nil:
+			t.Errorf("first transition from %s, want nil", *transition.FromState)
+		case previous != nil && (transition.FromState == nil || *transition.FromState != *previous):
+			t.Errorf("transition %d from %v, want %s", transition.ID, transition.FromState, *previous)
+		case transition.FromState != nil && *transition.FromState == transition.ToState:
+			t.Errorf("transition %d from %s to itself", transition.ID, transition.ToState)
+		}
+		previous = &history[i].ToState
+	}
+}
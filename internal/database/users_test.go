@@ -0,0 +1,49 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+)
+
+// Of concurrent registrations with one number, exactly one succeeds; the
+// rest fail with ErrPhoneAlreadyRegistered
+func TestCreateUserConcurrentSamePhone(t *testing.T) {
+	db := testPostgres(t)
+	phone := testPhone()
+	const attempts = 10
+
+	var wg sync.WaitGroup
+	errs := make([]error, attempts)
+	start := make(chan struct{})
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-start
+			now := time.Now()
+			errs[i] = db.CreateUser(context.Background(), &models.User{ID: uuid.New(), Phone: phone, Name: "Ada", CreatedAt: now, UpdatedAt: now})
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+
+	created := 0
+	for _, err := range errs {
+		switch {
+		case err == nil:
+			created++
+		case !errors.Is(err, ErrPhoneAlreadyRegistered):
+			t.Errorf("CreateUser() = %v, want ErrPhoneAlreadyRegistered", err)
+		}
+	}
+	if created != 1 {
+		t.Errorf("%d users created with one phone, want 1", created)
+	}
+}
@@ -0,0 +1,161 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+const watchSessionColumns = `
+	id, user_id, note, started_at, ends_at, ended_at, ended_by, last_state, alert_raised
+`
+
+func scanWatchSession(row pgx.Row) (*models.WatchSession, error) {
+	var w models.WatchSession
+	err := row.Scan(
+		&w.ID, &w.UserID, &w.Note, &w.StartedAt, &w.EndsAt, &w.EndedAt, &w.EndedBy, &w.LastState, &w.AlertRaised,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &w, nil
+}
+
+func collectWatchSessions(rows pgx.Rows) ([]models.WatchSession, error) {
+	defer rows.Close()
+
+	var sessions []models.WatchSession
+	for rows.Next() {
+		w, err := scanWatchSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, *w)
+	}
+	return sessions, rows.Err()
+}
+
+// Watch session operations
+
+// UpsertWatchSession opens a watch, or moves the end and note of the user's
+// open watch if they already have one. The stored session is returned.
+func (db *PostgresDB) UpsertWatchSession(ctx context.Context, w *models.WatchSession) (*models.WatchSession, error) {
+	query := `
+		INSERT INTO watch_sessions (id, user_id, note, started_at, ends_at)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (user_id) WHERE ended_at IS NULL
+		DO UPDATE SET note = EXCLUDED.note, ends_at = EXCLUDED.ends_at
+		RETURNING ` + watchSessionColumns
+	return scanWatchSession(db.pool.QueryRow(ctx, query,
+		w.ID, w.UserID, w.Note, w.StartedAt, w.EndsAt,
+	))
+}
+
+// GetActiveWatchSession returns the user's open watch that has not yet run
+// out at now, or nil
+func (db *PostgresDB) GetActiveWatchSession(ctx context.Context, userID uuid.UUID, now time.Time) (*models.WatchSession, error) {
+	query := `
+		SELECT ` + watchSessionColumns + `
+		FROM watch_sessions
+		WHERE user_id = $1 AND ended_at IS NULL AND ends_at > $2
+	`
+	w, err := scanWatchSession(db.pool.QueryRow(ctx, query, userID, now))
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	return w, err
+}
+
+// GetWatchSessionAt returns the watch the user was under at the given time, or nil
+func (db *PostgresDB) GetWatchSessionAt(ctx context.Context, userID uuid.UUID, at time.Time) (*models.WatchSession, error) {
+	query := `
+		SELECT ` + watchSessionColumns + `
+		FROM watch_sessions
+		WHERE user_id = $1 AND started_at <= $2 AND ends_at > $2 AND (ended_at IS NULL OR ended_at > $2)
+		ORDER BY started_at DESC
+		LIMIT 1
+	`
+	w, err := scanWatchSession(db.pool.QueryRow(ctx, query, userID, at))
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	return w, err
+}
+
+// GetActiveWatchSessions returns every open watch that has not yet run out at now
+func (db *PostgresDB) GetActiveWatchSessions(ctx context.Context, now time.Time) ([]models.WatchSession, error) {
+	query := `
+		SELECT ` + watchSessionColumns + `
+		FROM watch_sessions
+		WHERE ended_at IS NULL AND ends_at > $1
+	`
+	rows, err := db.pool.Query(ctx, query, now)
+	if err != nil {
+		return nil, err
+	}
+	return collectWatchSessions(rows)
+}
+
+// CompleteWatchSession closes the user's open watch as confirmed and returns
+// it, or nil if there was none. A watch that ran out but that the monitor
+// hasn't closed yet still counts; the user did confirm.
+func (db *PostgresDB) CompleteWatchSession(ctx context.Context, userID uuid.UUID) (*models.WatchSession, error) {
+	query := `
+		UPDATE watch_sessions
+		SET ended_at = NOW(), ended_by = 'completed'
+		WHERE user_id = $1 AND ended_at IS NULL
+		RETURNING ` + watchSessionColumns
+	w, err := scanWatchSession(db.pool.QueryRow(ctx, query, userID))
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	return w, err
+}
+
+// ExpireLapsedWatchSessions closes up to limit open watches whose end has
+// passed and returns them. Concurrent callers never close the same watch.
+func (db *PostgresDB) ExpireLapsedWatchSessions(ctx context.Context, now time.Time, limit int) ([]models.WatchSession, error) {
+	query := `
+		UPDATE watch_sessions
+		SET ended_at = ends_at, ended_by = 'expiry'
+		WHERE id IN (
+			SELECT id FROM watch_sessions
+			WHERE ended_at IS NULL AND ends_at <= $1
+			ORDER BY ends_at
+			LIMIT $2
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING ` + watchSessionColumns
+	rows, err := db.pool.Query(ctx, query, now, limit)
+	if err != nil {
+		return nil, err
+	}
+	return collectWatchSessions(rows)
+}
+
+// RecordWatchOutcome stores the state an expired watch ended in and whether
+// it raised an alert
+func (db *PostgresDB) RecordWatchOutcome(ctx context.Context, id uuid.UUID, lastState string, alertRaised bool) error {
+	query := `UPDATE watch_sessions SET last_state = $2, alert_raised = $3 WHERE id = $1`
+	_, err := db.pool.Exec(ctx, query, id, lastState, alertRaised)
+	return err
+}
+
+// GetWatchSessions returns the user's most recent watches, newest first
+func (db *PostgresDB) GetWatchSessions(ctx context.Context, userID uuid.UUID, limit int) ([]models.WatchSession, error) {
+	query := `
+		SELECT ` + watchSessionColumns + `
+		FROM watch_sessions
+		WHERE user_id = $1
+		ORDER BY started_at DESC
+		LIMIT $2
+	`
+	rows, err := db.pool.Query(ctx, query, userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	return collectWatchSessions(rows)
+}
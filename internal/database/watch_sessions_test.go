@@ -0,0 +1,145 @@
+package database
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+)
+
+func openWatch(t *testing.T, db *PostgresDB, userID uuid.UUID, start time.Time, d time.Duration, note string) *models.WatchSession {
+	t.Helper()
+	watch, err := db.UpsertWatchSession(context.Background(), &models.WatchSession{
+		ID: uuid.New(), UserID: userID, Note: note, StartedAt: start, EndsAt: start.Add(d),
+	})
+	if err != nil {
+		t.Fatalf("UpsertWatchSession: %v", err)
+	}
+	return watch
+}
+
+// A second watch while one is open extends it rather than overlapping it
+func TestWatchSessionExtend(t *testing.T) {
+	db := testPostgres(t)
+	ctx := context.Background()
+	user := createTestUser(t, db, "Ada")
+	now := time.Now().Truncate(time.Second)
+
+	first := openWatch(t, db, user.ID, now, 30*time.Minute, "walking home")
+	second := openWatch(t, db, user.ID, now.Add(10*time.Minute), 45*time.Minute, "taking the long way")
+	if second.ID != first.ID || !second.StartedAt.Equal(first.StartedAt) {
+		t.Errorf("extended watch = %s from %s, want %s from %s", second.ID, second.StartedAt, first.ID, first.StartedAt)
+	}
+	if !second.EndsAt.Equal(now.Add(55*time.Minute)) || second.Note != "taking the long way" {
+		t.Errorf("extended watch ends %s with %q", second.EndsAt, second.Note)
+	}
+
+	watches, err := db.GetWatchSessions(ctx, user.ID, 10)
+	if err != nil || len(watches) != 1 {
+		t.Fatalf("GetWatchSessions() = %d watches, %v, want one", len(watches), err)
+	}
+	if active, err := db.GetActiveWatchSession(ctx, user.ID, now.Add(50*time.Minute)); err != nil || active == nil || active.ID != first.ID {
+		t.Errorf("GetActiveWatchSession() past the first end = %v, %v", active, err)
+	}
+}
+
+// "I arrived" closes the watch before it runs out, so expiry never sees it
+func TestWatchSessionComplete(t *testing.T) {
+	db := testPostgres(t)
+	ctx := context.Background()
+	user := createTestUser(t, db, "Ada")
+	now := time.Now()
+	watch := openWatch(t, db, user.ID, now.Add(-10*time.Minute), 11*time.Minute, "")
+
+	done, err := db.CompleteWatchSession(ctx, user.ID)
+	if err != nil || done == nil || done.ID != watch.ID {
+		t.Fatalf("CompleteWatchSession() = %v, %v", done, err)
+	}
+	if done.EndedBy == nil || *done.EndedBy != models.WatchEndedByCompleted || done.EndedAt == nil {
+		t.Errorf("completed watch = %+v", done)
+	}
+	if active, _ := db.GetActiveWatchSession(ctx, user.ID, now); active != nil {
+		t.Errorf("watch still active after completion")
+	}
+	if again, err := db.CompleteWatchSession(ctx, user.ID); err != nil || again != nil {
+		t.Errorf("second CompleteWatchSession() = %v, %v, want nothing to complete", again, err)
+	}
+
+	expired, err := db.ExpireLapsedWatchSessions(ctx, now.Add(time.Hour), 100)
+	if err != nil {
+		t.Fatalf("ExpireLapsedWatchSessions: %v", err)
+	}
+	for _, w := range expired {
+		if w.ID == watch.ID {
+			t.Errorf("completed watch expired")
+		}
+	}
+
+	// A new watch afterwards is a new session
+	next := openWatch(t, db, user.ID, now, 30*time.Minute, "")
+	if next.ID == watch.ID {
+		t.Errorf("new watch reused the completed one")
+	}
+}
+
+// A watch that runs out without confirmation is closed by expiry at its end,
+// by exactly one of several monitors
+func TestWatchSessionExpiry(t *testing.T) {
+	db := testPostgres(t)
+	ctx := context.Background()
+	user := createTestUser(t, db, "Ada")
+	now := time.Now().Truncate(time.Second)
+	watch := openWatch(t, db, user.ID, now.Add(-40*time.Minute), 30*time.Minute, "")
+
+	if active, _ := db.GetActiveWatchSession(ctx, user.ID, now); active != nil {
+		t.Errorf("lapsed watch reported as active")
+	}
+	if at, err := db.GetWatchSessionAt(ctx, user.ID, now.Add(-15*time.Minute)); err != nil || at == nil || at.ID != watch.ID {
+		t.Errorf("GetWatchSessionAt() during the watch = %v, %v", at, err)
+	}
+
+	var mu sync.Mutex
+	closed := 0
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			watches, err := db.ExpireLapsedWatchSessions(ctx, now, 100)
+			if err != nil {
+				t.Errorf("ExpireLapsedWatchSessions: %v", err)
+				return
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			for _, w := range watches {
+				if w.ID != watch.ID {
+					continue
+				}
+				closed++
+				if w.EndedBy == nil || *w.EndedBy != models.WatchEndedByExpiry || w.EndedAt == nil || !w.EndedAt.Equal(w.EndsAt) {
+					t.Errorf("expired watch = %+v", w)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if closed != 1 {
+		t.Fatalf("watch expired %d times, want once", closed)
+	}
+
+	if err := db.RecordWatchOutcome(ctx, watch.ID, "CAUTION", true); err != nil {
+		t.Fatalf("RecordWatchOutcome: %v", err)
+	}
+	watches, err := db.GetWatchSessions(ctx, user.ID, 1)
+	if err != nil || len(watches) != 1 || watches[0].LastState == nil || *watches[0].LastState != "CAUTION" || !watches[0].AlertRaised {
+		t.Errorf("GetWatchSessions() = %+v, %v", watches, err)
+	}
+	if at, _ := db.GetWatchSessionAt(ctx, user.ID, now.Add(-5*time.Minute)); at != nil {
+		t.Errorf("watch reported at a time after it ended")
+	}
+}
@@ -0,0 +1,135 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+const welfareCheckColumns = `
+	id, user_id, requester_type, requester_id, requester_name, requester_phone,
+	requested_at, respond_by, status, resolved_at, outcome_state, outcome_score
+`
+
+func scanWelfareCheck(row pgx.Row) (*models.WelfareCheck, error) {
+	var w models.WelfareCheck
+	err := row.Scan(
+		&w.ID, &w.UserID, &w.RequesterType, &w.RequesterID, &w.RequesterName, &w.RequesterPhone,
+		&w.RequestedAt, &w.RespondBy, &w.Status, &w.ResolvedAt, &w.OutcomeState, &w.OutcomeScore,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &w, nil
+}
+
+func collectWelfareChecks(rows pgx.Rows) ([]models.WelfareCheck, error) {
+	defer rows.Close()
+
+	var checks []models.WelfareCheck
+	for rows.Next() {
+		w, err := scanWelfareCheck(rows)
+		if err != nil {
+			return nil, err
+		}
+		checks = append(checks, *w)
+	}
+	return checks, rows.Err()
+}
+
+// Welfare check operations
+
+// CreateWelfareCheck stores a pending check. It returns nil, without storing
+// anything, if the user already has a pending check.
+func (db *PostgresDB) CreateWelfareCheck(ctx context.Context, w *models.WelfareCheck) (*models.WelfareCheck, error) {
+	query := `
+		INSERT INTO welfare_checks (id, user_id, requester_type, requester_id, requester_name, requester_phone, requested_at, respond_by)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT (user_id) WHERE status = 'pending' DO NOTHING
+		RETURNING ` + welfareCheckColumns
+	created, err := scanWelfareCheck(db.pool.QueryRow(ctx, query,
+		w.ID, w.UserID, w.RequesterType, w.RequesterID, w.RequesterName, w.RequesterPhone, w.RequestedAt, w.RespondBy,
+	))
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	return created, err
+}
+
+// GetPendingWelfareCheck returns the user's pending check, or nil
+func (db *PostgresDB) GetPendingWelfareCheck(ctx context.Context, userID uuid.UUID) (*models.WelfareCheck, error) {
+	query := `
+		SELECT ` + welfareCheckColumns + `
+		FROM welfare_checks
+		WHERE user_id = $1 AND status = 'pending'
+	`
+	w, err := scanWelfareCheck(db.pool.QueryRow(ctx, query, userID))
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	return w, err
+}
+
+// ConfirmWelfareCheck marks the user's pending check confirmed and returns
+// it, or nil if there was none. A check past its deadline that the monitor
+// hasn't settled yet still counts; the user did answer.
+func (db *PostgresDB) ConfirmWelfareCheck(ctx context.Context, userID uuid.UUID) (*models.WelfareCheck, error) {
+	query := `
+		UPDATE welfare_checks
+		SET status = 'confirmed', resolved_at = NOW()
+		WHERE user_id = $1 AND status = 'pending'
+		RETURNING ` + welfareCheckColumns
+	w, err := scanWelfareCheck(db.pool.QueryRow(ctx, query, userID))
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	return w, err
+}
+
+// ExpireLapsedWelfareChecks marks up to limit pending checks whose deadline
+// has passed unanswered and returns them. Concurrent callers never take the
+// same check.
+func (db *PostgresDB) ExpireLapsedWelfareChecks(ctx context.Context, now time.Time, limit int) ([]models.WelfareCheck, error) {
+	query := `
+		UPDATE welfare_checks
+		SET status = 'unanswered', resolved_at = respond_by
+		WHERE id IN (
+			SELECT id FROM welfare_checks
+			WHERE status = 'pending' AND respond_by <= $1
+			ORDER BY respond_by
+			LIMIT $2
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING ` + welfareCheckColumns
+	rows, err := db.pool.Query(ctx, query, now, limit)
+	if err != nil {
+		return nil, err
+	}
+	return collectWelfareChecks(rows)
+}
+
+// RecordWelfareOutcome stores the state an unanswered check's evaluation reached
+func (db *PostgresDB) RecordWelfareOutcome(ctx context.Context, id uuid.UUID, state string, score int) error {
+	query := `UPDATE welfare_checks SET outcome_state = $2, outcome_score = $3 WHERE id = $1`
+	_, err := db.pool.Exec(ctx, query, id, state, score)
+	return err
+}
+
+// GetWelfareChecks returns the user's most recent checks, newest first
+func (db *PostgresDB) GetWelfareChecks(ctx context.Context, userID uuid.UUID, limit int) ([]models.WelfareCheck, error) {
+	query := `
+		SELECT ` + welfareCheckColumns + `
+		FROM welfare_checks
+		WHERE user_id = $1
+		ORDER BY requested_at DESC
+		LIMIT $2
+	`
+	rows, err := db.pool.Query(ctx, query, userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	return collectWelfareChecks(rows)
+}
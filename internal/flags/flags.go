@@ -0,0 +1,228 @@
+// Package flags gates evaluator and alerting behavior per user, so a change
+// to who gets alerted and when can reach some users before everyone. Flags
+// are defined here with their defaults. A deployment sets each one on, off
+// or on for a percentage of users with FEATURE_FLAGS, and an admin can force
+// one on or off for a single user, which wins over both.
+package flags
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/google/uuid"
+)
+
+// Flag names a gated behavior
+type Flag string
+
+const (
+	// OutageHold holds users gone silent in a probable outage zone back from escalating
+	OutageHold Flag = "outage_hold"
+	// ActivityHold holds stale users who are using the app back from AT_RISK
+	ActivityHold Flag = "activity_hold"
+	// ScoreTrend takes points off a score that keeps falling
+	ScoreTrend Flag = "score_trend"
+	// AutoResolve resolves alerts once the user is home safe
+	AutoResolve Flag = "auto_resolve"
+	// ContactPacing holds non-critical alerts past a contact's pacing for their digest
+	ContactPacing Flag = "contact_pacing"
+)
+
+// defaults are the flags and whether each is on where nothing says otherwise
+var defaults = map[Flag]bool{
+	OutageHold:    true,
+	ActivityHold:  true,
+	ScoreTrend:    true,
+	AutoResolve:   true,
+	ContactPacing: true,
+}
+
+// Names returns the name of every flag, sorted
+func Names() []string {
+	names := make([]string, 0, len(defaults))
+	for f := range defaults {
+		names = append(names, string(f))
+	}
+	slices.Sort(names)
+	return names
+}
+
+// Known reports whether name is a defined flag
+func Known(name string) bool {
+	_, ok := defaults[Flag(name)]
+	return ok
+}
+
+// Rollouts are the percentages of users a deployment turns flags on for; 0
+// is off and 100 on. Flags left out keep their defaults.
+type Rollouts map[Flag]int
+
+// ParseRollouts reads FEATURE_FLAGS pairs, each flag set to on, off or a
+// percentage such as 25%
+func ParseRollouts(pairs map[string]string) (Rollouts, error) {
+	rollouts := make(Rollouts, len(pairs))
+	for name, value := range pairs {
+		if !Known(name) {
+			return nil, fmt.Errorf("unknown flag %s, must be one of %s", name, strings.Join(Names(), ", "))
+		}
+		switch value = strings.ToLower(value); {
+		case value == "on":
+			rollouts[Flag(name)] = 100
+		case value == "off":
+			rollouts[Flag(name)] = 0
+		case strings.HasSuffix(value, "%"):
+			pct, err := strconv.Atoi(strings.TrimSuffix(value, "%"))
+			if err != nil || pct < 0 || pct > 100 {
+				return nil, fmt.Errorf("%s: %s must be a percentage from 0%% to 100%%", name, value)
+			}
+			rollouts[Flag(name)] = pct
+		default:
+			return nil, fmt.Errorf("%s must be on, off or a percentage such as 25%%", name)
+		}
+	}
+	return rollouts, nil
+}
+
+// Where a decision came from
+const (
+	SourceDefault    = "default"    // the flag's default
+	SourceDeployment = "deployment" // FEATURE_FLAGS on or off
+	SourceRollout    = "rollout"    // FEATURE_FLAGS percentage, by the user's bucket
+	SourceUser       = "user"       // an admin's override for the user
+)
+
+// Decision is whether a flag is on for a user, and why
+type Decision struct {
+	Enabled bool   `json:"enabled"`
+	Source  string `json:"source"`
+}
+
+// Values are every flag decided for one user
+type Values map[Flag]Decision
+
+// Enabled reports whether f is on for the user
+func (v Values) Enabled(f Flag) bool {
+	if d, ok := v[f]; ok {
+		return d.Enabled
+	}
+	return defaults[f]
+}
+
+// Map returns each flag's name and whether it is on, for records
+func (v Values) Map() map[string]bool {
+	m := make(map[string]bool, len(v))
+	for f, d := range v {
+		m[string(f)] = d.Enabled
+	}
+	return m
+}
+
+// Bucket places a user in one of 100 buckets for f. A percentage rollout is
+// on for the users in the buckets below it, so raising it only adds users.
+// Buckets are fixed by the flag and user ID alone, and differ between flags,
+// so the first users of one rollout aren't always the first of the next.
+func Bucket(f Flag, userID uuid.UUID) int {
+	h := fnv.New32a()
+	h.Write([]byte(f))
+	h.Write([]byte{':'})
+	h.Write(userID[:])
+	return int(h.Sum32() % 100)
+}
+
+// Decide decides every flag for a user: their overrides first, then the
+// deployment's rollouts, then the defaults. Overrides of flags no longer
+// defined are ignored.
+func Decide(userID uuid.UUID, rollouts Rollouts, overrides map[string]bool) Values {
+	values := make(Values, len(defaults))
+	for f, def := range defaults {
+		if enabled, ok := overrides[string(f)]; ok {
+			values[f] = Decision{Enabled: enabled, Source: SourceUser}
+			continue
+		}
+		pct, ok := rollouts[f]
+		switch {
+		case !ok:
+			values[f] = Decision{Enabled: def, Source: SourceDefault}
+		case pct == 0 || pct == 100:
+			values[f] = Decision{Enabled: pct == 100, Source: SourceDeployment}
+		default:
+			values[f] = Decision{Enabled: Bucket(f, userID) < pct, Source: SourceRollout}
+		}
+	}
+	return values
+}
+
+// OverrideLoader returns a user's overrides by flag name
+type OverrideLoader func(ctx context.Context, userID uuid.UUID) (map[string]bool, error)
+
+var (
+	rollouts  atomic.Pointer[Rollouts]
+	overrides atomic.Pointer[OverrideLoader]
+)
+
+// Use makes r the deployment's rollouts
+func Use(r Rollouts) {
+	rollouts.Store(&r)
+}
+
+// LoadOverridesWith makes For read users' overrides with load
+func LoadOverridesWith(load OverrideLoader) {
+	overrides.Store(&load)
+}
+
+type cacheKey struct{}
+
+// cache holds the flags decided for each user within one context
+type cache struct {
+	mu     sync.Mutex
+	values map[uuid.UUID]Values
+}
+
+// WithCache returns a context within which For decides each user's flags
+// once, so a request or evaluation sees the same flags throughout
+func WithCache(ctx context.Context) context.Context {
+	if _, ok := ctx.Value(cacheKey{}).(*cache); ok {
+		return ctx
+	}
+	return context.WithValue(ctx, cacheKey{}, &cache{values: make(map[uuid.UUID]Values)})
+}
+
+// For returns the flags decided for the user, once per context from
+// WithCache and on every call otherwise. Overrides that can't be read are
+// logged and left out.
+func For(ctx context.Context, userID uuid.UUID) Values {
+	c, _ := ctx.Value(cacheKey{}).(*cache)
+	if c != nil {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if v, ok := c.values[userID]; ok {
+			return v
+		}
+	}
+
+	var userOverrides map[string]bool
+	if load := overrides.Load(); load != nil {
+		var err error
+		if userOverrides, err = (*load)(ctx, userID); err != nil {
+			log.Printf("WARN: Feature flag overrides unavailable for user %s, using the deployment's: %v", userID, err)
+			userOverrides = nil
+		}
+	}
+	var r Rollouts
+	if p := rollouts.Load(); p != nil {
+		r = *p
+	}
+	v := Decide(userID, r, userOverrides)
+
+	if c != nil {
+		c.values[userID] = v
+	}
+	return v
+}
@@ -0,0 +1,204 @@
+package flags
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+// Buckets are pinned: a change to the hash would move users in and out of
+// every rollout in progress on the next deploy
+func TestBucketStable(t *testing.T) {
+	tests := []struct {
+		user string
+		flag Flag
+		want int
+	}{
+		{"0b5c5a0f-3d81-4824-82e1-3803d1056b95", AutoResolve, 1},
+		{"0b5c5a0f-3d81-4824-82e1-3803d1056b95", OutageHold, 24},
+		{"0b5c5a0f-3d81-4824-82e1-3803d1056b95", ScoreTrend, 13},
+		{"7f3e2a10-9c4b-4d2e-8a61-5b0c9e7d4f12", AutoResolve, 70},
+		{"7f3e2a10-9c4b-4d2e-8a61-5b0c9e7d4f12", OutageHold, 79},
+		{"7f3e2a10-9c4b-4d2e-8a61-5b0c9e7d4f12", ScoreTrend, 14},
+		{"00000000-0000-0000-0000-000000000000", AutoResolve, 1},
+		{"00000000-0000-0000-0000-000000000000", OutageHold, 96},
+	}
+	for _, tt := range tests {
+		userID := uuid.MustParse(tt.user)
+		for i := 0; i < 3; i++ {
+			if got := Bucket(tt.flag, userID); got != tt.want {
+				t.Errorf("Bucket(%s, %s) = %d, want %d", tt.flag, tt.user, got, tt.want)
+			}
+		}
+	}
+}
+
+// Users spread evenly over the buckets, independently for each flag, and a
+// rollout raised only adds users
+func TestBucketRollout(t *testing.T) {
+	const users = 20_000
+	ids := make([]uuid.UUID, users)
+	for i := range ids {
+		ids[i] = uuid.New()
+	}
+
+	for _, pct := range []int{1, 10, 25, 50, 90} {
+		on := 0
+		for _, id := range ids {
+			if Bucket(AutoResolve, id) < pct {
+				on++
+			}
+		}
+		if got := float64(on) * 100 / users; got < float64(pct)-1.5 || got > float64(pct)+1.5 {
+			t.Errorf("%d%% rollout reached %.1f%% of users", pct, got)
+		}
+	}
+
+	both := 0
+	for _, id := range ids {
+		if Bucket(AutoResolve, id) < 10 && Bucket(OutageHold, id) < 10 {
+			both++
+		}
+	}
+	// Independent buckets put about 1% of users in the first 10% of both
+	if got := float64(both) * 100 / users; got > 2 {
+		t.Errorf("%.1f%% of users are first in both rollouts", got)
+	}
+
+	rollouts := Rollouts{AutoResolve: 10}
+	for _, id := range ids[:2000] {
+		before := Decide(id, rollouts, nil).Enabled(AutoResolve)
+		after := Decide(id, Rollouts{AutoResolve: 30}, nil).Enabled(AutoResolve)
+		if before && !after {
+			t.Fatalf("user %s dropped from the rollout when it was raised", id)
+		}
+	}
+}
+
+// A user's override wins over the deployment, which wins over the default
+func TestDecidePrecedence(t *testing.T) {
+	userID := uuid.MustParse("0b5c5a0f-3d81-4824-82e1-3803d1056b95") // bucket 1 for auto_resolve
+
+	tests := []struct {
+		name       string
+		rollouts   Rollouts
+		overrides  map[string]bool
+		want       bool
+		wantSource string
+	}{
+		{"default", nil, nil, true, SourceDefault},
+		{"deployment off", Rollouts{AutoResolve: 0}, nil, false, SourceDeployment},
+		{"deployment on", Rollouts{AutoResolve: 100}, nil, true, SourceDeployment},
+		{"in the rollout", Rollouts{AutoResolve: 5}, nil, true, SourceRollout},
+		{"outside the rollout", Rollouts{AutoResolve: 1}, nil, false, SourceRollout},
+		{"user off, deployment on", Rollouts{AutoResolve: 100}, map[string]bool{"auto_resolve": false}, false, SourceUser},
+		{"user on, deployment off", Rollouts{AutoResolve: 0}, map[string]bool{"auto_resolve": true}, true, SourceUser},
+		{"user off, in the rollout", Rollouts{AutoResolve: 50}, map[string]bool{"auto_resolve": false}, false, SourceUser},
+		{"user on, outside the rollout", Rollouts{AutoResolve: 1}, map[string]bool{"auto_resolve": true}, true, SourceUser},
+		{"user off, default on", nil, map[string]bool{"auto_resolve": false}, false, SourceUser},
+		{"another flag's override", Rollouts{AutoResolve: 1}, map[string]bool{"outage_hold": true}, false, SourceRollout},
+		{"override of a retired flag", nil, map[string]bool{"movement_classifier": false}, true, SourceDefault},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			values := Decide(userID, tt.rollouts, tt.overrides)
+			if d := values[AutoResolve]; d.Enabled != tt.want || d.Source != tt.wantSource {
+				t.Errorf("auto_resolve = %+v, want %v from %s", d, tt.want, tt.wantSource)
+			}
+			if values.Enabled(AutoResolve) != tt.want || values.Map()["auto_resolve"] != tt.want {
+				t.Errorf("Enabled and Map disagree with the decision")
+			}
+			if _, ok := values["movement_classifier"]; ok {
+				t.Errorf("retired flag decided")
+			}
+			if len(values) != len(defaults) {
+				t.Errorf("%d flags decided, want %d", len(values), len(defaults))
+			}
+		})
+	}
+}
+
+func TestParseRollouts(t *testing.T) {
+	got, err := ParseRollouts(map[string]string{"auto_resolve": "25%", "outage_hold": "OFF", "score_trend": "on"})
+	if err != nil {
+		t.Fatalf("ParseRollouts: %v", err)
+	}
+	want := Rollouts{AutoResolve: 25, OutageHold: 0, ScoreTrend: 100}
+	if len(got) != len(want) {
+		t.Fatalf("rollouts = %v, want %v", got, want)
+	}
+	for f, pct := range want {
+		if got[f] != pct {
+			t.Errorf("%s = %d, want %d", f, got[f], pct)
+		}
+	}
+
+	for _, bad := range []map[string]string{
+		{"movement_classifier": "on"},
+		{"auto_resolve": "101%"},
+		{"auto_resolve": "-1%"},
+		{"auto_resolve": "half"},
+		{"auto_resolve": "25"},
+	} {
+		if _, err := ParseRollouts(bad); err == nil {
+			t.Errorf("ParseRollouts(%v) accepted", bad)
+		}
+	}
+}
+
+// restoreGlobals puts back the deployment's rollouts and override loader
+// when the test ends
+func restoreGlobals(t *testing.T) {
+	r, o := rollouts.Load(), overrides.Load()
+	t.Cleanup(func() {
+		rollouts.Store(r)
+		overrides.Store(o)
+	})
+}
+
+// Within a cached context a user's flags are decided once, so an override
+// set mid-request doesn't split it; an unreadable override falls back to the
+// deployment's rollouts
+func TestFor(t *testing.T) {
+	restoreGlobals(t)
+	userID := uuid.New()
+	Use(Rollouts{AutoResolve: 0})
+
+	loads := 0
+	userOverrides := map[string]bool{"auto_resolve": true}
+	var loadErr error
+	LoadOverridesWith(func(ctx context.Context, id uuid.UUID) (map[string]bool, error) {
+		loads++
+		if id != userID {
+			return nil, nil
+		}
+		return userOverrides, loadErr
+	})
+
+	ctx := WithCache(context.Background())
+	if WithCache(ctx) != ctx {
+		t.Error("WithCache wrapped a cached context again")
+	}
+	if d := For(ctx, userID)[AutoResolve]; !d.Enabled || d.Source != SourceUser {
+		t.Errorf("with an override = %+v, want on by the user's override", d)
+	}
+	userOverrides = map[string]bool{"auto_resolve": false}
+	if !For(ctx, userID).Enabled(AutoResolve) || loads != 1 {
+		t.Errorf("cached: %d loads, on %v; want the first decision kept", loads, For(ctx, userID).Enabled(AutoResolve))
+	}
+	For(ctx, uuid.New())
+	if loads != 2 {
+		t.Errorf("%d loads for two users, want 2", loads)
+	}
+
+	if For(context.Background(), userID).Enabled(AutoResolve) || loads != 3 {
+		t.Errorf("uncached: %d loads; want the new override read", loads)
+	}
+
+	loadErr = errors.New("connection refused")
+	if d := For(context.Background(), userID)[AutoResolve]; d.Enabled || d.Source != SourceDeployment {
+		t.Errorf("overrides unavailable = %+v, want off by the deployment", d)
+	}
+}
@@ -0,0 +1,118 @@
+// Package geojson encodes RFC 7946 GeoJSON. Feature collections are streamed
+// one feature at a time so large exports never sit in memory.
+package geojson
+
+import (
+	"encoding/json"
+	"io"
+	"math"
+	"net/http"
+)
+
+// MediaType is the registered GeoJSON content type
+const MediaType = "application/geo+json"
+
+// flushEvery is how many features are written between flushes of an HTTP response
+const flushEvery = 100
+
+// Geometry is a GeoJSON geometry. Coordinates are [longitude, latitude].
+type Geometry struct {
+	Type        string      `json:"type"`
+	Coordinates interface{} `json:"coordinates"`
+}
+
+// Feature is a GeoJSON feature. A nil Geometry encodes as null, which the
+// spec allows for features without a location.
+type Feature struct {
+	Type       string                 `json:"type"`
+	ID         string                 `json:"id,omitempty"`
+	Geometry   *Geometry              `json:"geometry"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+// NewFeature returns a feature with the given geometry and properties
+func NewFeature(id string, geometry *Geometry, properties map[string]interface{}) Feature {
+	if properties == nil {
+		properties = map[string]interface{}{}
+	}
+	return Feature{Type: "Feature", ID: id, Geometry: geometry, Properties: properties}
+}
+
+// Point returns a Point geometry
+func Point(lat, lng float64) *Geometry {
+	return &Geometry{Type: "Point", Coordinates: Position(lat, lng)}
+}
+
+// LineString returns a LineString geometry; it needs at least two positions
+func LineString(positions [][]float64) *Geometry {
+	return &Geometry{Type: "LineString", Coordinates: positions}
+}
+
+// BBoxPolygon returns a Polygon geometry covering a latitude/longitude box
+func BBoxPolygon(minLat, maxLat, minLng, maxLng float64) *Geometry {
+	ring := [][]float64{
+		Position(minLat, minLng),
+		Position(minLat, maxLng),
+		Position(maxLat, maxLng),
+		Position(maxLat, minLng),
+		Position(minLat, minLng),
+	}
+	return &Geometry{Type: "Polygon", Coordinates: [][][]float64{ring}}
+}
+
+// Position returns a [lng, lat] position rounded to 6 decimals (about 10cm),
+// as RFC 7946 recommends
+func Position(lat, lng float64) []float64 {
+	return []float64{round6(lng), round6(lat)}
+}
+
+func round6(v float64) float64 {
+	return math.Round(v*1e6) / 1e6
+}
+
+// FeatureCollectionWriter streams a FeatureCollection
+type FeatureCollectionWriter struct {
+	w       io.Writer
+	enc     *json.Encoder
+	flusher http.Flusher
+	count   int
+}
+
+// NewFeatureCollectionWriter writes the collection header to w
+func NewFeatureCollectionWriter(w io.Writer) (*FeatureCollectionWriter, error) {
+	if _, err := io.WriteString(w, `{"type":"FeatureCollection","features":[`); err != nil {
+		return nil, err
+	}
+	fw := &FeatureCollectionWriter{w: w, enc: json.NewEncoder(w)}
+	fw.flusher, _ = w.(http.Flusher)
+	return fw, nil
+}
+
+// Write appends a feature to the collection
+func (fw *FeatureCollectionWriter) Write(f Feature) error {
+	if fw.count > 0 {
+		if _, err := io.WriteString(fw.w, ","); err != nil {
+			return err
+		}
+	}
+	if err := fw.enc.Encode(f); err != nil {
+		return err
+	}
+	fw.count++
+	if fw.flusher != nil && fw.count%flushEvery == 0 {
+		fw.flusher.Flush()
+	}
+	return nil
+}
+
+// Count returns how many features were written
+func (fw *FeatureCollectionWriter) Count() int {
+	return fw.count
+}
+
+// Close ends the collection. Without it the output is not valid JSON, so a
+// client can tell a truncated export from a complete one.
+func (fw *FeatureCollectionWriter) Close() error {
+	_, err := io.WriteString(fw.w, "]}\n")
+	return err
+}
@@ -0,0 +1,213 @@
+package geojson
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// validate checks a decoded document against the RFC 7946 schema for a
+// FeatureCollection of features with Point, LineString or Polygon geometry
+func validate(doc interface{}) error {
+	fc, ok := doc.(map[string]interface{})
+	if !ok || fc["type"] != "FeatureCollection" {
+		return fmt.Errorf("not a FeatureCollection: %v", doc)
+	}
+	features, ok := fc["features"].([]interface{})
+	if !ok {
+		return fmt.Errorf("features is not an array")
+	}
+	for i, f := range features {
+		if err := validateFeature(f); err != nil {
+			return fmt.Errorf("feature %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+func validateFeature(v interface{}) error {
+	f, ok := v.(map[string]interface{})
+	if !ok || f["type"] != "Feature" {
+		return fmt.Errorf("not a Feature: %v", v)
+	}
+	for _, key := range []string{"geometry", "properties"} {
+		if _, ok := f[key]; !ok {
+			return fmt.Errorf("%s is missing", key)
+		}
+	}
+	if id, ok := f["id"]; ok {
+		switch id.(type) {
+		case string, float64:
+		default:
+			return fmt.Errorf("id %v is neither a string nor a number", id)
+		}
+	}
+	if p := f["properties"]; p != nil {
+		if _, ok := p.(map[string]interface{}); !ok {
+			return fmt.Errorf("properties is not an object")
+		}
+	}
+	if f["geometry"] == nil {
+		return nil
+	}
+	g, ok := f["geometry"].(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("geometry is not an object")
+	}
+	switch g["type"] {
+	case "Point":
+		return validatePosition(g["coordinates"])
+	case "LineString":
+		return validatePositions(g["coordinates"], 2)
+	case "Polygon":
+		rings, ok := g["coordinates"].([]interface{})
+		if !ok || len(rings) == 0 {
+			return fmt.Errorf("polygon has no rings")
+		}
+		for _, ring := range rings {
+			if err := validatePositions(ring, 4); err != nil {
+				return err
+			}
+			positions := ring.([]interface{})
+			if !reflect.DeepEqual(positions[0], positions[len(positions)-1]) {
+				return fmt.Errorf("ring is not closed")
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("unexpected geometry type %v", g["type"])
+	}
+}
+
+func validatePositions(v interface{}, minLen int) error {
+	positions, ok := v.([]interface{})
+	if !ok || len(positions) < minLen {
+		return fmt.Errorf("want at least %d positions, got %v", minLen, v)
+	}
+	for _, p := range positions {
+		if err := validatePosition(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validatePosition checks a [longitude, latitude] position within range
+func validatePosition(v interface{}) error {
+	p, ok := v.([]interface{})
+	if !ok || len(p) < 2 || len(p) > 3 {
+		return fmt.Errorf("position %v is not 2 or 3 numbers", v)
+	}
+	lng, ok1 := p[0].(float64)
+	lat, ok2 := p[1].(float64)
+	if !ok1 || !ok2 {
+		return fmt.Errorf("position %v is not numeric", v)
+	}
+	if lng < -180 || lng > 180 || lat < -90 || lat > 90 {
+		return fmt.Errorf("position %v is out of range", v)
+	}
+	return nil
+}
+
+func decode(t *testing.T, s string) interface{} {
+	t.Helper()
+	var doc interface{}
+	if err := json.Unmarshal([]byte(s), &doc); err != nil {
+		t.Fatalf("invalid JSON: %v\n%s", err, s)
+	}
+	return doc
+}
+
+// The schema check itself rejects what it should
+func TestValidate(t *testing.T) {
+	invalid := map[string]string{
+		"feature, not a collection": `{"type":"Feature","geometry":null,"properties":{}}`,
+		"swapped coordinates":       `{"type":"FeatureCollection","features":[{"type":"Feature","geometry":{"type":"Point","coordinates":[6.5,135.3]},"properties":{}}]}`,
+		"one-position LineString":   `{"type":"FeatureCollection","features":[{"type":"Feature","geometry":{"type":"LineString","coordinates":[[3.3,6.5]]},"properties":{}}]}`,
+		"open ring":                 `{"type":"FeatureCollection","features":[{"type":"Feature","geometry":{"type":"Polygon","coordinates":[[[0,0],[1,0],[1,1],[0,1]]]},"properties":{}}]}`,
+		"no properties":             `{"type":"FeatureCollection","features":[{"type":"Feature","geometry":null}]}`,
+	}
+	for name, s := range invalid {
+		if validate(decode(t, s)) == nil {
+			t.Errorf("%s passed validation", name)
+		}
+	}
+}
+
+func TestFeatureCollectionWriter(t *testing.T) {
+	tests := []struct {
+		name     string
+		features []Feature
+	}{
+		{"empty", nil},
+		{"point", []Feature{NewFeature("a1", Point(6.5244, 3.3792), map[string]interface{}{"state": "ALERT", "score": 12, "resolved": false})}},
+		{"no location", []Feature{NewFeature("a2", nil, nil)}},
+		{"line string", []Feature{NewFeature("u1", LineString([][]float64{Position(6.5244, 3.3792), Position(6.4281, 3.4219), Position(6.4531, 3.3958)}), nil)}},
+		{"polygon", []Feature{NewFeature("s14", BBoxPolygon(6.5, 6.55, 3.35, 3.4), map[string]interface{}{"alerts": 3})}},
+		{"far corners", []Feature{NewFeature("", Point(-90, -180), nil), NewFeature("", Point(90, 180), nil)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b strings.Builder
+			fw, err := NewFeatureCollectionWriter(&b)
+			if err != nil {
+				t.Fatalf("NewFeatureCollectionWriter: %v", err)
+			}
+			for _, f := range tt.features {
+				if err := fw.Write(f); err != nil {
+					t.Fatalf("Write: %v", err)
+				}
+			}
+			if err := fw.Close(); err != nil {
+				t.Fatalf("Close: %v", err)
+			}
+
+			doc := decode(t, b.String())
+			if err := validate(doc); err != nil {
+				t.Errorf("invalid GeoJSON: %v\n%s", err, b.String())
+			}
+			if got := len(doc.(map[string]interface{})["features"].([]interface{})); got != len(tt.features) || fw.Count() != got {
+				t.Errorf("%d features, Count() %d, want %d", got, fw.Count(), len(tt.features))
+			}
+		})
+	}
+}
+
+// A large collection is flushed as it's written, and is only valid once closed
+func TestFeatureCollectionWriterStreams(t *testing.T) {
+	w := httptest.NewRecorder()
+	fw, err := NewFeatureCollectionWriter(w)
+	if err != nil {
+		t.Fatalf("NewFeatureCollectionWriter: %v", err)
+	}
+	for i := 0; i < 2*flushEvery+1; i++ {
+		if err := fw.Write(NewFeature(fmt.Sprint(i), Point(6.5+float64(i)/1e4, 3.3), nil)); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+	}
+	if !w.Flushed {
+		t.Errorf("nothing flushed after %d features", fw.Count())
+	}
+	if json.Valid(w.Body.Bytes()) {
+		t.Errorf("an unclosed collection is valid JSON, so truncation can't be told apart")
+	}
+
+	fw.Close()
+	if err := validate(decode(t, w.Body.String())); err != nil {
+		t.Errorf("invalid GeoJSON: %v", err)
+	}
+}
+
+// Positions are longitude first, rounded to 6 decimals
+func TestPosition(t *testing.T) {
+	if got, want := Position(6.52443719, 3.37921549), []float64{3.379215, 6.524437}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Position() = %v, want %v", got, want)
+	}
+	ring := BBoxPolygon(6.5, 6.55, 3.35, 3.4).Coordinates.([][][]float64)[0]
+	if len(ring) != 5 || !reflect.DeepEqual(ring[0], ring[4]) || !reflect.DeepEqual(ring[2], []float64{3.4, 6.55}) {
+		t.Errorf("BBoxPolygon ring = %v", ring)
+	}
+}
@@ -0,0 +1,166 @@
+package handlers
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/apierror"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/database"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/middleware"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/params"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/utils"
+)
+
+// canAccessUser reports whether the caller may read a user's location data:
+// the user themselves, one of their trusted contacts, an admin or their
+// organization's admin, or a guardian with an active link (attached by
+// middleware.GuardianAccess)
+func canAccessUser(c *gin.Context, user *models.User) bool {
+	claims := middleware.Principal(c)
+	if claims == nil || user == nil {
+		return false
+	}
+	if middleware.GuardianLink(c, user.ID) != nil {
+		return true
+	}
+
+	switch claims.Role {
+	case utils.RoleAdmin:
+		return true
+	case utils.RoleOrgAdmin:
+		return administersOrg(claims, user.OrgID)
+	case utils.RoleUser:
+		return claims.Subject == user.ID.String()
+	case utils.RoleContact:
+		if claims.Subject != user.ID.String() {
+			return false
+		}
+		for _, contact := range user.TrustedContacts {
+			if contact.Phone != claims.Phone {
+				continue
+			}
+			// Tokens bound to a contact entry stop working if it is replaced
+			if claims.ContactID == "" || contact.ID == claims.ContactID {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// loadAuthorizedUser loads the user from the :user_id param and checks the
+// caller may read it. It writes the error response itself and returns false on failure.
+func loadAuthorizedUser(c *gin.Context, postgres *database.PostgresDB) (*models.User, bool) {
+	user, err := postgres.GetUserByID(c.Request.Context(), params.UserID(c))
+	if err != nil {
+		middleware.AbortWithError(c, apierror.Internal("database error", err))
+		return nil, false
+	}
+	if user == nil {
+		middleware.AbortWithError(c, apierror.NotFound("user not found"))
+		return nil, false
+	}
+
+	if !canAccessUser(c, user) {
+		middleware.AbortWithError(c, apierror.Forbidden("not allowed to access this user"))
+		return nil, false
+	}
+
+	return user, true
+}
+
+// requireSelf checks the caller is the :user_id user, for actions no one
+// else may take on their behalf. It writes the error response itself.
+func requireSelf(c *gin.Context, forbidden string) (uuid.UUID, bool) {
+	userID := params.UserID(c)
+	claims := middleware.Principal(c)
+	if claims == nil || claims.Role != utils.RoleUser || claims.Subject != userID.String() {
+		middleware.AbortWithError(c, apierror.Forbidden(forbidden))
+		return uuid.Nil, false
+	}
+	return userID, true
+}
+
+// requireSelfOrAdmin checks the caller is the :user_id user, an admin or
+// the admin of the user's organization, for records about the user's account
+// that their contacts and guardians have no business reading. It writes the
+// error response itself.
+func requireSelfOrAdmin(c *gin.Context, postgres *database.PostgresDB) (uuid.UUID, bool) {
+	userID := params.UserID(c)
+
+	claims := middleware.Principal(c)
+	allowed := claims != nil && (claims.Role == utils.RoleAdmin || (claims.Role == utils.RoleUser && claims.Subject == userID.String()))
+	if claims != nil && claims.Role == utils.RoleOrgAdmin {
+		user, err := postgres.GetUserByID(c.Request.Context(), userID)
+		if err != nil {
+			middleware.AbortWithError(c, apierror.Internal("database error", err))
+			return uuid.Nil, false
+		}
+		allowed = user != nil && administersOrg(claims, user.OrgID)
+	}
+	if !allowed {
+		middleware.AbortWithError(c, apierror.Forbidden("not allowed to access this user"))
+		return uuid.Nil, false
+	}
+	return userID, true
+}
+
+// mayManageAlert reports whether the caller may act on an alert, e.g.
+// resolve it: the alerted user, an admin, or the admin of the alerted user's
+// organization. Contacts, guardians, responders and share-link holders know
+// the alert's ID but may only read it.
+func mayManageAlert(claims *utils.TokenClaims, alert *models.Alert, owner *models.User) bool {
+	if claims == nil || alert == nil {
+		return false
+	}
+	switch claims.Role {
+	case utils.RoleAdmin:
+		return true
+	case utils.RoleUser:
+		return claims.Subject == alert.UserID.String()
+	case utils.RoleOrgAdmin:
+		return owner != nil && owner.ID == alert.UserID && administersOrg(claims, owner.OrgID)
+	}
+	return false
+}
+
+// administersOrg reports whether the caller is an org_admin of orgID. Users
+// outside any organization have no org admin.
+func administersOrg(claims *utils.TokenClaims, orgID *uuid.UUID) bool {
+	return claims != nil && claims.Role == utils.RoleOrgAdmin && orgID != nil && claims.OrgID == orgID.String()
+}
+
+// adminOrg returns the organization an /admin request is limited to: nil for
+// a platform admin, the token's organization for an org_admin. It writes the
+// error response itself and returns false for any other caller.
+func adminOrg(c *gin.Context) (*uuid.UUID, bool) {
+	claims := middleware.Principal(c)
+	if claims != nil && claims.Role == utils.RoleAdmin {
+		return nil, true
+	}
+	if claims != nil && claims.Role == utils.RoleOrgAdmin {
+		if orgID, err := uuid.Parse(claims.OrgID); err == nil {
+			return &orgID, true
+		}
+	}
+	middleware.AbortWithError(c, apierror.Forbidden("insufficient role"))
+	return nil, false
+}
+
+// requireOrgAccess checks the caller administers the :org_id organization,
+// as a platform admin or its org_admin. It writes the error response itself.
+func requireOrgAccess(c *gin.Context) (uuid.UUID, bool) {
+	orgID := params.Get(c, params.Org)
+	scope, ok := adminOrg(c)
+	if !ok {
+		return uuid.Nil, false
+	}
+	if scope != nil && *scope != orgID {
+		// Same response as a missing organization so IDs can't be probed
+		middleware.AbortWithError(c, apierror.NotFound("organization not found"))
+		return uuid.Nil, false
+	}
+	return orgID, true
+}
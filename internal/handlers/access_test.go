@@ -0,0 +1,192 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/middleware"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/params"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/utils"
+)
+
+const testSecret = "test-secret"
+
+// serveAs runs handler on route for a request to path, authenticated with claims
+func serveAs(t *testing.T, claims utils.TokenClaims, route, path string, handlers ...gin.HandlerFunc) *httptest.ResponseRecorder {
+	t.Helper()
+	token, err := utils.IssueToken(claims, testSecret, time.Hour)
+	if err != nil {
+		t.Fatalf("IssueToken: %v", err)
+	}
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(middleware.RequestID(), middleware.ErrorHandler())
+	router.GET(route, append([]gin.HandlerFunc{middleware.RequireAuth(testSecret)}, handlers...)...)
+
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+// testRouter returns a router that answers errors as the API does
+func testRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(middleware.RequestID(), middleware.ErrorHandler())
+	return router
+}
+
+// send serves a request with a JSON body, authenticated with claims unless
+// they are nil
+func send(t *testing.T, router http.Handler, method, path, body string, claims *utils.TokenClaims) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	if claims != nil {
+		token, err := utils.IssueToken(*claims, testSecret, time.Hour)
+		if err != nil {
+			t.Fatalf("IssueToken: %v", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestMayManageAlert(t *testing.T) {
+	orgID, otherOrg := uuid.New(), uuid.New()
+	owner := &models.User{ID: uuid.New(), OrgID: &orgID}
+	consumer := &models.User{ID: uuid.New()}
+	alert := &models.Alert{ID: uuid.New(), UserID: owner.ID}
+	consumerAlert := &models.Alert{ID: uuid.New(), UserID: consumer.ID}
+
+	tests := []struct {
+		name   string
+		claims *utils.TokenClaims
+		alert  *models.Alert
+		owner  *models.User
+		want   bool
+	}{
+		{"anonymous", nil, alert, owner, false},
+		{"alerted user", &utils.TokenClaims{Role: utils.RoleUser, Subject: owner.ID.String()}, alert, nil, true},
+		{"another user", &utils.TokenClaims{Role: utils.RoleUser, Subject: uuid.NewString()}, alert, nil, false},
+		{"admin", &utils.TokenClaims{Role: utils.RoleAdmin}, alert, nil, true},
+		{"org admin of the user's org", &utils.TokenClaims{Role: utils.RoleOrgAdmin, OrgID: orgID.String()}, alert, owner, true},
+		{"org admin of another org", &utils.TokenClaims{Role: utils.RoleOrgAdmin, OrgID: otherOrg.String()}, alert, owner, false},
+		{"org admin, owner not loaded", &utils.TokenClaims{Role: utils.RoleOrgAdmin, OrgID: orgID.String()}, alert, nil, false},
+		{"org admin, owner of another alert", &utils.TokenClaims{Role: utils.RoleOrgAdmin, OrgID: orgID.String()}, consumerAlert, owner, false},
+		{"org admin, consumer user", &utils.TokenClaims{Role: utils.RoleOrgAdmin, OrgID: orgID.String()}, consumerAlert, consumer, false},
+		{"trusted contact", &utils.TokenClaims{Role: utils.RoleContact, Subject: owner.ID.String(), Scopes: []string{utils.ScopeReadStatus}}, alert, nil, false},
+		{"missing alert", &utils.TokenClaims{Role: utils.RoleAdmin}, nil, nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := mayManageAlert(tt.claims, tt.alert, tt.owner); got != tt.want {
+				t.Errorf("mayManageAlert() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// An org admin reads their own members only: never another organization's
+// members, nor consumer users outside any organization
+func TestCanAccessUserOrgAdmin(t *testing.T) {
+	orgID, otherOrg := uuid.New(), uuid.New()
+	member := &models.User{ID: uuid.New(), OrgID: &orgID}
+	otherMember := &models.User{ID: uuid.New(), OrgID: &otherOrg}
+	consumer := &models.User{ID: uuid.New()}
+
+	orgAdmin := utils.TokenClaims{Subject: uuid.NewString(), Role: utils.RoleOrgAdmin, OrgID: orgID.String()}
+	tests := []struct {
+		name   string
+		claims utils.TokenClaims
+		user   *models.User
+		want   bool
+	}{
+		{"own member", orgAdmin, member, true},
+		{"another organization's member", orgAdmin, otherMember, false},
+		{"consumer user", orgAdmin, consumer, false},
+		{"org admin without an organization", utils.TokenClaims{Subject: uuid.NewString(), Role: utils.RoleOrgAdmin}, consumer, false},
+		{"platform admin", utils.TokenClaims{Subject: uuid.NewString(), Role: utils.RoleAdmin}, otherMember, true},
+		{"the user", utils.TokenClaims{Subject: consumer.ID.String(), Role: utils.RoleUser}, consumer, true},
+		{"another user", utils.TokenClaims{Subject: member.ID.String(), Role: utils.RoleUser}, consumer, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got bool
+			serveAs(t, tt.claims, "/", "/", func(c *gin.Context) {
+				got = canAccessUser(c, tt.user)
+			})
+			if got != tt.want {
+				t.Errorf("canAccessUser() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// /admin requests of an org admin are limited to their organization, and
+// another organization answers as if it didn't exist
+func TestRequireOrgAccess(t *testing.T) {
+	orgID, otherOrg := uuid.New(), uuid.New()
+	tests := []struct {
+		name   string
+		claims utils.TokenClaims
+		org    uuid.UUID
+		want   int
+	}{
+		{"org admin, own organization", utils.TokenClaims{Role: utils.RoleOrgAdmin, OrgID: orgID.String()}, orgID, http.StatusOK},
+		{"org admin, another organization", utils.TokenClaims{Role: utils.RoleOrgAdmin, OrgID: orgID.String()}, otherOrg, http.StatusNotFound},
+		{"org admin with a malformed organization", utils.TokenClaims{Role: utils.RoleOrgAdmin, OrgID: "campus"}, orgID, http.StatusForbidden},
+		{"platform admin", utils.TokenClaims{Role: utils.RoleAdmin}, otherOrg, http.StatusOK},
+		{"user", utils.TokenClaims{Subject: uuid.NewString(), Role: utils.RoleUser}, orgID, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serveAs(t, tt.claims, "/admin/orgs/:org_id", "/admin/orgs/"+tt.org.String(), params.UUID(params.Org), func(c *gin.Context) {
+				if _, ok := requireOrgAccess(c); ok {
+					c.Status(http.StatusOK)
+				}
+			})
+			if w.Code != tt.want {
+				t.Errorf("status %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestAdminOrg(t *testing.T) {
+	orgID := uuid.New()
+	tests := []struct {
+		name   string
+		claims utils.TokenClaims
+		want   *uuid.UUID
+		ok     bool
+	}{
+		{"platform admin sees everyone", utils.TokenClaims{Role: utils.RoleAdmin}, nil, true},
+		{"org admin sees their organization", utils.TokenClaims{Role: utils.RoleOrgAdmin, OrgID: orgID.String()}, &orgID, true},
+		{"org admin without an organization", utils.TokenClaims{Role: utils.RoleOrgAdmin}, nil, false},
+		{"user", utils.TokenClaims{Role: utils.RoleUser}, nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got *uuid.UUID
+			var ok bool
+			serveAs(t, tt.claims, "/", "/", func(c *gin.Context) {
+				got, ok = adminOrg(c)
+			})
+			if ok != tt.ok || (got == nil) != (tt.want == nil) || (got != nil && *got != *tt.want) {
+				t.Errorf("adminOrg() = %v, %v; want %v, %v", got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
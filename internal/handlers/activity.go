@@ -0,0 +1,47 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/apierror"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/config"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/database"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/middleware"
+)
+
+type ActivityHandler struct {
+	cfg   *config.Store
+	redis *database.RedisDB
+}
+
+func NewActivityHandler(cfg *config.Store, redis *database.RedisDB) *ActivityHandler {
+	return &ActivityHandler{
+		cfg:   cfg,
+		redis: redis,
+	}
+}
+
+// POST /v1/user/:user_id/activity
+// The app calls this while the user is interacting with it. Recent activity
+// holds off alerts caused only by stale heartbeats, e.g. with location off.
+func (h *ActivityHandler) RecordActivity(c *gin.Context) {
+	userID, ok := requireSelf(c, "only the user can report their own app activity")
+	if !ok {
+		return
+	}
+
+	now := time.Now()
+	ttl := time.Duration(h.cfg.Current().ActivityTTLSeconds) * time.Second
+	if err := h.redis.MarkUserActive(c.Request.Context(), userID, now, ttl); err != nil {
+		middleware.AbortWithError(c, apierror.Internal("failed to record activity", err))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"user_id":      userID,
+		"active_at":    now,
+		"active_until": now.Add(ttl),
+	})
+}
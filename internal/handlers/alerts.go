@@ -0,0 +1,317 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/apierror"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/config"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/database"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/middleware"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/params"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/services"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/utils"
+)
+
+type AlertsHandler struct {
+	cfg      *config.Config
+	postgres *database.PostgresDB
+	links    *services.AccountLinkService
+	resolver *services.AutoResolver
+	audit    *services.AuditLogger
+}
+
+func NewAlertsHandler(
+	cfg *config.Config,
+	postgres *database.PostgresDB,
+	links *services.AccountLinkService,
+	resolver *services.AutoResolver,
+	audit *services.AuditLogger,
+) *AlertsHandler {
+	return &AlertsHandler{
+		cfg:      cfg,
+		postgres: postgres,
+		links:    links,
+		resolver: resolver,
+		audit:    audit,
+	}
+}
+
+// POST /v1/alerts/:alert_id/auto-resolve
+// Confirms the "home safe?" prompt sent once the alerted user was back in an
+// auto-resolve zone, resolving the alert as automatic. Only the alerted user
+// may confirm; 409 when no prompt about the alert is waiting.
+func (h *AlertsHandler) ConfirmAutoResolve(c *gin.Context) {
+	alert, err := h.postgres.GetAlertByID(c.Request.Context(), params.Get(c, params.Alert))
+	if err != nil {
+		middleware.AbortWithError(c, apierror.Internal("database error", err))
+		return
+	}
+	claims := middleware.Principal(c)
+	if alert == nil || claims == nil || claims.Role != utils.RoleUser || claims.Subject != alert.UserID.String() {
+		middleware.AbortWithError(c, apierror.NotFound("alert not found"))
+		return
+	}
+
+	if err := h.resolver.Confirm(c.Request.Context(), alert); err != nil {
+		if errors.Is(err, services.ErrNoAutoResolvePrompt) {
+			middleware.AbortWithError(c, apierror.Conflict("no auto-resolve prompt is waiting for this alert"))
+			return
+		}
+		middleware.AbortWithError(c, apierror.Internal("failed to resolve alert", err))
+		return
+	}
+
+	recordAudit(c, h.audit, &models.AuditEvent{
+		Action:        services.AuditAlertAutoResolve,
+		ObjectType:    "alert",
+		ObjectID:      alert.ID.String(),
+		SubjectUserID: &alert.UserID,
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":      "success",
+		"message":     "alert resolved",
+		"resolution":  alert.Resolution,
+		"resolved_at": alert.ResolvedAt,
+	})
+}
+
+// GET /v1/alerts/:alert_id/recipients
+// Visible to the alerted user, their guardians, admins and their
+// organization's admins
+func (h *AlertsHandler) GetRecipients(c *gin.Context) {
+	alertID := params.Get(c, params.Alert)
+
+	alert, err := h.postgres.GetAlertByID(c.Request.Context(), alertID)
+	if err != nil {
+		middleware.AbortWithError(c, apierror.Internal("database error", err))
+		return
+	}
+
+	claims := middleware.Principal(c)
+	allowed := claims != nil && (claims.Role == utils.RoleAdmin ||
+		(claims.Role == utils.RoleUser && alert != nil && claims.Subject == alert.UserID.String()))
+	if alert != nil && claims != nil && claims.Role == utils.RoleOrgAdmin {
+		user, err := h.postgres.GetUserByID(c.Request.Context(), alert.UserID)
+		if err != nil {
+			middleware.AbortWithError(c, apierror.Internal("database error", err))
+			return
+		}
+		allowed = user != nil && administersOrg(claims, user.OrgID)
+	}
+	if alert != nil && !allowed {
+		allowed, err = h.isGuardian(c, alert.UserID)
+		if err != nil {
+			middleware.AbortWithError(c, apierror.Internal("authorization check failed", err))
+			return
+		}
+	}
+	if alert == nil || !allowed {
+		// Same response for missing and foreign alerts so IDs can't be probed
+		middleware.AbortWithError(c, apierror.NotFound("alert not found"))
+		return
+	}
+
+	recipients, err := h.postgres.GetAlertRecipients(c.Request.Context(), alertID)
+	if err != nil {
+		middleware.AbortWithError(c, apierror.Internal("database error", err))
+		return
+	}
+	if recipients == nil {
+		recipients = []models.AlertRecipient{}
+	}
+
+	recordAudit(c, h.audit, &models.AuditEvent{
+		Action:        services.AuditAlertRecipientsView,
+		ObjectType:    "alert",
+		ObjectID:      alertID.String(),
+		SubjectUserID: &alert.UserID,
+	})
+
+	acknowledged := 0
+	for _, r := range recipients {
+		if r.AcknowledgedAt != nil {
+			acknowledged++
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"alert_id":     alertID,
+		"recipients":   recipients,
+		"acknowledged": acknowledged,
+	})
+}
+
+// GET /v1/user/:user_id/alerts?limit=20&offset=0
+// The user's alert history, also readable by contacts with the read_alerts scope
+func (h *AlertsHandler) ListUserAlerts(c *gin.Context) {
+	user, ok := loadAuthorizedUser(c, h.postgres)
+	if !ok {
+		return
+	}
+
+	limit, offset := paginationParams(c, 20, 100)
+
+	alerts, total, err := h.postgres.GetAlertsForUser(c.Request.Context(), user.ID, limit, offset)
+	if err != nil {
+		middleware.AbortWithError(c, apierror.Internal("failed to get alerts", err))
+		return
+	}
+	if alerts == nil {
+		alerts = []models.Alert{}
+	}
+
+	// Contacts don't get to see each other's phone numbers
+	if claims := middleware.Principal(c); claims.Role == utils.RoleContact {
+		for i := range alerts {
+			alerts[i].SentTo = nil
+		}
+	}
+
+	recordAudit(c, h.audit, &models.AuditEvent{
+		Action:        services.AuditAlertsView,
+		ObjectType:    "alert_history",
+		SubjectUserID: &user.ID,
+		Metadata:      map[string]interface{}{"limit": limit, "offset": offset},
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"user_id": user.ID,
+		"alerts":  alerts,
+		"total":   total,
+		"limit":   limit,
+		"offset":  offset,
+	})
+}
+
+// GET /ack/:token
+// Link included in the alert message; the token itself authenticates the contact
+func (h *AlertsHandler) AcknowledgeLink(c *gin.Context) {
+	recipient, err := h.postgres.AcknowledgeAlertByToken(c.Request.Context(), c.Param("token"), models.AckMethodLink)
+	if err != nil {
+		log.Printf("ERROR: Failed to acknowledge alert via link: %v", err)
+		c.String(http.StatusInternalServerError, "Something went wrong. Please try again or reply OK to the alert SMS.")
+		return
+	}
+	if recipient == nil {
+		c.String(http.StatusNotFound, "This acknowledgment link is not valid.")
+		return
+	}
+
+	log.Printf("INFO: Alert %s acknowledged by %s via link", recipient.AlertID, recipient.Phone)
+	c.String(http.StatusOK, "Thank you. We've recorded that you have seen this SafeTrace alert.")
+}
+
+// POST /v1/voice/ack/:token
+// Twilio <Gather> callback for alert calls; pressing 1 acknowledges
+func (h *AlertsHandler) HandleVoiceAck(c *gin.Context) {
+	c.Header("Content-Type", "application/xml")
+
+	if c.PostForm("Digits") != "1" {
+		c.String(http.StatusOK, `<?xml version="1.0" encoding="UTF-8"?><Response><Say>No acknowledgment recorded.</Say></Response>`)
+		return
+	}
+
+	recipient, err := h.postgres.AcknowledgeAlertByToken(c.Request.Context(), c.Param("token"), models.AckMethodVoice)
+	if err != nil || recipient == nil {
+		if err != nil {
+			log.Printf("ERROR: Failed to acknowledge alert via voice: %v", err)
+		}
+		c.String(http.StatusOK, `<?xml version="1.0" encoding="UTF-8"?><Response><Say>Sorry, we could not record your acknowledgment.</Say></Response>`)
+		return
+	}
+
+	log.Printf("INFO: Alert %s acknowledged by %s via voice", recipient.AlertID, recipient.Phone)
+	c.String(http.StatusOK, `<?xml version="1.0" encoding="UTF-8"?><Response><Say>Thank you. Your acknowledgment has been recorded.</Say></Response>`)
+}
+
+// flaggedAlertsLimit caps how many flagged alerts the reconciliation report lists
+const flaggedAlertsLimit = 100
+
+// GET /admin/alerts/reconciliation?from=&to=
+// What the alert reconciler did with alerts left unresolved: alerts by
+// class and last action among those reconciled in the window, the last 7
+// days by default, and the oldest flagged alerts still open
+func (h *AlertsHandler) GetReconciliation(c *gin.Context) {
+	from, to, ok := timeRangeParams(c, 7*24*time.Hour)
+	if !ok {
+		return
+	}
+	ctx := c.Request.Context()
+
+	counts, err := h.postgres.GetReconcileCounts(ctx, from, to)
+	if err != nil {
+		middleware.AbortWithError(c, apierror.Internal("failed to count reconciled alerts", err))
+		return
+	}
+	flagged, err := h.postgres.GetFlaggedAlerts(ctx, flaggedAlertsLimit)
+	if err != nil {
+		middleware.AbortWithError(c, apierror.Internal("failed to get flagged alerts", err))
+		return
+	}
+
+	recordAudit(c, h.audit, &models.AuditEvent{
+		Action:     services.AuditReconcileView,
+		ObjectType: "alert_reconciliation",
+		Metadata:   map[string]interface{}{"from": from, "to": to},
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"from":    from,
+		"to":      to,
+		"counts":  counts,
+		"flagged": flagged,
+	})
+}
+
+// handoffsLimit caps how many agency handoffs are listed
+const handoffsLimit = 200
+
+// GET /admin/alerts/handoffs?from=&to=
+// Alerts handed off to emergency response agencies in the window, the last
+// 7 days by default, newest first, with the target, channel and reference
+// of each, and those skipped, failed or left without a target.
+func (h *AlertsHandler) GetHandoffs(c *gin.Context) {
+	from, to, ok := timeRangeParams(c, 7*24*time.Hour)
+	if !ok {
+		return
+	}
+
+	handoffs, err := h.postgres.GetAgencyHandoffs(c.Request.Context(), from, to, handoffsLimit)
+	if err != nil {
+		middleware.AbortWithError(c, apierror.Internal("failed to get agency handoffs", err))
+		return
+	}
+
+	recordAudit(c, h.audit, &models.AuditEvent{
+		Action:     services.AuditHandoffsView,
+		ObjectType: "agency_handoffs",
+		Metadata:   map[string]interface{}{"from": from, "to": to},
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"from":     from,
+		"to":       to,
+		"handoffs": handoffs,
+	})
+}
+
+// isGuardian reports whether the caller holds an active link to the user
+func (h *AlertsHandler) isGuardian(c *gin.Context, wardID uuid.UUID) (bool, error) {
+	claims := middleware.Principal(c)
+	if claims == nil || claims.Role != utils.RoleUser {
+		return false, nil
+	}
+	guardianID, err := uuid.Parse(claims.Subject)
+	if err != nil {
+		return false, nil
+	}
+	link, err := h.links.ActiveLink(c.Request.Context(), guardianID, wardID)
+	return link != nil, err
+}
@@ -0,0 +1,134 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/apierror"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/config"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/database"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/middleware"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/services"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/utils"
+)
+
+// recordAudit attributes an event to the caller and the current request, then queues it
+func recordAudit(c *gin.Context, audit *services.AuditLogger, event *models.AuditEvent) {
+	event.ActorRole = "anonymous"
+	if claims := middleware.Principal(c); claims != nil {
+		event.ActorID = claims.Subject
+		event.ActorRole = claims.Role
+		if claims.Role == utils.RoleContact {
+			// Contact tokens carry the protected user as subject; the contact is the phone
+			event.ActorID = claims.Phone
+		}
+	}
+	if key := middleware.Responder(c); key != nil {
+		event.ActorID = key.ID.String()
+		event.ActorRole = utils.RoleResponder
+	}
+	event.RequestID = middleware.GetRequestID(c)
+
+	if event.Metadata == nil {
+		event.Metadata = map[string]interface{}{}
+	}
+	event.Metadata["ip"] = c.ClientIP()
+
+	audit.Record(event)
+}
+
+type AuditHandler struct {
+	cfg      *config.Config
+	postgres *database.PostgresDB
+	audit    *services.AuditLogger
+}
+
+func NewAuditHandler(
+	cfg *config.Config,
+	postgres *database.PostgresDB,
+	audit *services.AuditLogger,
+) *AuditHandler {
+	return &AuditHandler{
+		cfg:      cfg,
+		postgres: postgres,
+		audit:    audit,
+	}
+}
+
+// GET /v1/user/:user_id/audit?limit=50&offset=0
+// Who accessed or changed the user's data; the user themselves, an admin or
+// their organization's admin
+func (h *AuditHandler) GetUserAudit(c *gin.Context) {
+	userID, ok := requireSelfOrAdmin(c, h.postgres)
+	if !ok {
+		return
+	}
+
+	limit, offset := paginationParams(c, 50, 200)
+	h.respond(c, models.AuditFilter{SubjectUserID: &userID}, limit, offset)
+}
+
+// GET /admin/audit?actor_id=&user_id=&action=&object_type=&from=&to=&limit=&offset=
+// An org admin only sees events about their members and their organization
+func (h *AuditHandler) ListAudit(c *gin.Context) {
+	orgID, ok := adminOrg(c)
+	if !ok {
+		return
+	}
+	filter := models.AuditFilter{
+		ActorID:    c.Query("actor_id"),
+		Action:     c.Query("action"),
+		ObjectType: c.Query("object_type"),
+		OrgID:      orgID,
+	}
+
+	if v := c.Query("user_id"); v != "" {
+		userID, err := uuid.Parse(v)
+		if err != nil {
+			middleware.AbortWithError(c, apierror.Invalid("user_id", "must be a valid UUID"))
+			return
+		}
+		filter.SubjectUserID = &userID
+	}
+
+	for param, dst := range map[string]**time.Time{"from": &filter.From, "to": &filter.To} {
+		if v := c.Query(param); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				middleware.AbortWithError(c, apierror.Invalid(param, "must be an RFC3339 timestamp"))
+				return
+			}
+			*dst = &t
+		}
+	}
+
+	recordAudit(c, h.audit, &models.AuditEvent{
+		Action:     services.AuditAuditView,
+		ObjectType: "audit",
+		Metadata:   map[string]interface{}{"query": c.Request.URL.RawQuery},
+	})
+
+	limit, offset := paginationParams(c, 50, 500)
+	h.respond(c, filter, limit, offset)
+}
+
+func (h *AuditHandler) respond(c *gin.Context, filter models.AuditFilter, limit, offset int) {
+	events, total, err := h.postgres.GetAuditEvents(c.Request.Context(), filter, limit, offset)
+	if err != nil {
+		middleware.AbortWithError(c, apierror.Internal("database error", err))
+		return
+	}
+	if events == nil {
+		events = []models.AuditEvent{}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"events": events,
+		"total":  total,
+		"limit":  limit,
+		"offset": offset,
+	})
+}
@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/apierror"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/middleware"
+)
+
+// strictRequest is a request body with fields where zero is a legitimate
+// value, such as a latitude on the equator. Gin's required tag rejects zero
+// and can't tell it from an absent field, so those fields are pointers and
+// validate checks their presence and range itself.
+type strictRequest interface {
+	validate() []apierror.FieldError
+}
+
+// bindStrict binds a strict request. Failed binding tags and failed checks
+// of validate are answered together, as 422 validation_failed naming every
+// field; malformed JSON is still 400. It writes the error response itself.
+func bindStrict(c *gin.Context, req strictRequest) bool {
+	return checkStrict(c, req, c.ShouldBindJSON(req))
+}
+
+// checkStrict reports a strict request's binding error, if any, together
+// with the failed checks of validate, as bindStrict does
+func checkStrict(c *gin.Context, req strictRequest, bindErr error) bool {
+	var fields []apierror.FieldError
+	if err := bindErr; err != nil {
+		apiErr := apierror.Validation(err)
+		if apiErr.Code != apierror.CodeValidationFailed {
+			middleware.AbortWithError(c, apiErr)
+			return false
+		}
+		fields = apiErr.Fields
+	}
+
+	// A field of the wrong type is already reported, and left unset
+	reported := make(map[string]bool, len(fields))
+	for _, f := range fields {
+		reported[f.Field] = true
+	}
+	for _, f := range req.validate() {
+		if !reported[f.Field] {
+			fields = append(fields, f)
+		}
+	}
+
+	if len(fields) > 0 {
+		middleware.AbortWithError(c, apierror.Unprocessable(fields))
+		return false
+	}
+	return true
+}
@@ -0,0 +1,206 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin/binding"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/config"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/heartbeatpb"
+)
+
+// errorFields returns the fields an error response names, with their reasons
+func errorFields(t *testing.T, w *httptest.ResponseRecorder) map[string]string {
+	t.Helper()
+	var body struct {
+		Error struct {
+			Fields []struct {
+				Field  string `json:"field"`
+				Reason string `json:"reason"`
+			} `json:"fields"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("error response %s: %v", w.Body.String(), err)
+	}
+	fields := map[string]string{}
+	for _, f := range body.Error.Fields {
+		fields[f.Field] = f.Reason
+	}
+	return fields
+}
+
+// passedFix is what a request with a valid position gets from the handlers
+// below: its user_id is no UUID, the first check after binding
+var passedFix = map[string]string{"user_id": "must be a valid UUID"}
+
+// A position of exactly 0 is on the equator or the prime meridian, and an
+// accuracy of 0 is what some devices send: none of them is missing. Absent
+// fields are named as missing, out-of-range ones as such, in JSON and
+// protobuf alike.
+func TestHeartbeatFix(t *testing.T) {
+	h := NewHeartbeatHandler(config.NewStore(&config.Config{}), nil, nil, nil, nil, nil, nil, nil, nil)
+	router := testRouter()
+	router.POST("/v1/heartbeat", h.CreateHeartbeat)
+
+	zero, zeroAccuracy, north, negative := 0.0, int32(0), 91.0, int32(-1)
+	tests := []struct {
+		name   string
+		modify func(hb *heartbeatpb.Heartbeat)
+		status int
+		fields map[string]string
+	}{
+		{"lat 0", func(hb *heartbeatpb.Heartbeat) { hb.Lat = &zero }, http.StatusBadRequest, passedFix},
+		{"lng 0", func(hb *heartbeatpb.Heartbeat) { hb.Lng = &zero }, http.StatusBadRequest, passedFix},
+		{"accuracy 0", func(hb *heartbeatpb.Heartbeat) { hb.AccuracyM = &zeroAccuracy }, http.StatusBadRequest, passedFix},
+		{"null island", func(hb *heartbeatpb.Heartbeat) { hb.Lat, hb.Lng, hb.AccuracyM = &zero, &zero, &zeroAccuracy }, http.StatusBadRequest, passedFix},
+		{"no lat", func(hb *heartbeatpb.Heartbeat) { hb.Lat = nil }, http.StatusUnprocessableEntity,
+			map[string]string{"lat": "is required"}},
+		{"no position", func(hb *heartbeatpb.Heartbeat) { hb.Lat, hb.Lng, hb.AccuracyM = nil, nil, nil }, http.StatusUnprocessableEntity,
+			map[string]string{"lat": "is required", "lng": "is required", "accuracy_m": "is required"}},
+		{"out of range", func(hb *heartbeatpb.Heartbeat) { hb.Lat, hb.AccuracyM = &north, &negative }, http.StatusUnprocessableEntity,
+			map[string]string{"lat": "must be between -90 and 90", "accuracy_m": "must be at least 0"}},
+	}
+	for _, tt := range tests {
+		hb := sampleHeartbeat()
+		hb.UserId = "not-a-uuid"
+		tt.modify(hb)
+		for _, format := range []struct {
+			name, contentType string
+			body              []byte
+		}{
+			{"JSON", "application/json", heartbeatJSON(t, hb)},
+			{"protobuf", binding.MIMEPROTOBUF, heartbeatProto(t, hb)},
+		} {
+			t.Run(tt.name+"/"+format.name, func(t *testing.T) {
+				req := httptest.NewRequest(http.MethodPost, "/v1/heartbeat", bytes.NewReader(format.body))
+				req.Header.Set("Content-Type", format.contentType)
+				w := httptest.NewRecorder()
+				router.ServeHTTP(w, req)
+				if w.Code != tt.status {
+					t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body.String())
+				}
+				if got := errorFields(t, w); !reflect.DeepEqual(got, tt.fields) {
+					t.Errorf("fields = %v, want %v", got, tt.fields)
+				}
+			})
+		}
+	}
+
+	// A key left out altogether is missing, like null
+	body := `{"user_id":"not-a-uuid","timestamp":"2026-03-09T18:42:07Z","lng":0,"accuracy_m":0,
+		"cell_info":{"mcc":621,"mnc":20,"cid":1234,"lac":56,"rssi":-80,"network_type":"LTE"},"signature":"c2lnbmF0dXJl"}`
+	w := send(t, router, http.MethodPost, "/v1/heartbeat", body, nil)
+	if got := errorFields(t, w); w.Code != http.StatusUnprocessableEntity || !reflect.DeepEqual(got, map[string]string{"lat": "is required"}) {
+		t.Errorf("heartbeat without lat = %d %v, want 422 naming lat", w.Code, got)
+	}
+}
+
+// An unverified report may leave out its accuracy, but not its position
+func TestUnverifiedReportFix(t *testing.T) {
+	router := testRouter()
+	router.POST("/v1/heartbeat/unverified", NewUnverifiedReportsHandler(nil, nil).Submit)
+	now := time.Now().UTC().Format(time.RFC3339)
+	// The phone is invalid, so a report whose position passes is still refused
+	badPhone := map[string]string{"phone": "must be a valid phone number"}
+
+	tests := []struct {
+		name   string
+		body   string
+		fields map[string]string
+	}{
+		{"zero position and accuracy", `{"phone":"x","lat":0,"lng":0,"accuracy_m":0,"timestamp":"` + now + `"}`, badPhone},
+		{"no accuracy", `{"phone":"x","lat":0,"lng":3.3792,"timestamp":"` + now + `"}`, badPhone},
+		{"no position", `{"phone":"x","timestamp":"` + now + `"}`,
+			map[string]string{"phone": "must be a valid phone number", "lat": "is required", "lng": "is required"}},
+		{"out of range", `{"phone":"x","lat":0,"lng":-181,"accuracy_m":-5,"timestamp":"` + now + `"}`,
+			map[string]string{"phone": "must be a valid phone number", "lng": "must be between -180 and 180", "accuracy_m": "must be at least 0"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := send(t, router, http.MethodPost, "/v1/heartbeat/unverified", tt.body, nil)
+			if w.Code != http.StatusUnprocessableEntity {
+				t.Fatalf("status = %d, want 422: %s", w.Code, w.Body.String())
+			}
+			if got := errorFields(t, w); !reflect.DeepEqual(got, tt.fields) {
+				t.Errorf("fields = %v, want %v", got, tt.fields)
+			}
+		})
+	}
+}
+
+// A trail's start and end are required, and the zero time is not a time
+func TestBlackboxUploadTimes(t *testing.T) {
+	router := testRouter()
+	router.POST("/v1/blackbox/upload", NewBlackboxHandler(config.NewStore(&config.Config{}), nil, nil, nil, nil, nil).UploadTrail)
+	point := `{"timestamp":"2026-03-09T18:00:00Z","lat":0,"lng":0,"accuracy_m":0,"cell_info":{}}`
+
+	tests := []struct {
+		name   string
+		times  string
+		status int
+		fields map[string]string
+	}{
+		{"valid", `"start_ts":"2026-03-09T18:00:00Z","end_ts":"2026-03-09T18:05:00Z"`, http.StatusBadRequest, passedFix},
+		{"instant trail", `"start_ts":"2026-03-09T18:00:00Z","end_ts":"2026-03-09T18:00:00Z"`, http.StatusBadRequest, passedFix},
+		{"missing", ``, http.StatusUnprocessableEntity,
+			map[string]string{"start_ts": "is required", "end_ts": "is required"}},
+		{"null end", `"start_ts":"2026-03-09T18:00:00Z","end_ts":null`, http.StatusUnprocessableEntity,
+			map[string]string{"end_ts": "is required"}},
+		{"zero time", `"start_ts":"0001-01-01T00:00:00Z","end_ts":"2026-03-09T18:05:00Z"`, http.StatusUnprocessableEntity,
+			map[string]string{"start_ts": "must be a real time, not the zero time"}},
+		{"reversed", `"start_ts":"2026-03-09T18:05:00Z","end_ts":"2026-03-09T18:00:00Z"`, http.StatusUnprocessableEntity,
+			map[string]string{"end_ts": "must not be before start_ts"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := `{"user_id":"not-a-uuid","data_points":[` + point + `]`
+			if tt.times != "" {
+				body += "," + tt.times
+			}
+			body += "}"
+			w := send(t, router, http.MethodPost, "/v1/blackbox/upload", body, nil)
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body.String())
+			}
+			if got := errorFields(t, w); !reflect.DeepEqual(got, tt.fields) {
+				t.Errorf("fields = %v, want %v", got, tt.fields)
+			}
+		})
+	}
+}
+
+// Location lines of a stream are held to the same checks as heartbeats
+func TestStreamLineFix(t *testing.T) {
+	const cell = `"cell_info":{"mcc":621,"mnc":20,"cid":1234,"lac":56}`
+	tests := []struct {
+		name   string
+		line   string
+		fields []string
+	}{
+		{"zero position and accuracy", `{"type":"location","timestamp":"2026-03-09T18:00:00Z","lat":0,"lng":0,"accuracy_m":0,` + cell + `}`, nil},
+		{"no position", `{"type":"location","timestamp":"2026-03-09T18:00:00Z",` + cell + `}`, []string{"lat", "lng", "accuracy_m"}},
+		{"out of range", `{"type":"location","timestamp":"2026-03-09T18:00:00Z","lat":-90.5,"lng":0,"accuracy_m":0,` + cell + `}`, []string{"lat"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var line streamLine
+			if err := json.Unmarshal([]byte(tt.line), &line); err != nil {
+				t.Fatalf("Unmarshal: %v", err)
+			}
+			var got []string
+			for _, f := range line.validate() {
+				got = append(got, f.Field)
+			}
+			if !reflect.DeepEqual(got, tt.fields) {
+				t.Errorf("fields = %v, want %v", got, tt.fields)
+			}
+		})
+	}
+}
@@ -1,49 +1,106 @@
 package handlers
 
 import (
-	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"log"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
 	"github.com/google/uuid"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/apierror"
 	"github.com/adedejiosvaldo/safetrace/backend/internal/config"
 	"github.com/adedejiosvaldo/safetrace/backend/internal/database"
 	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/middleware"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/services"
 )
 
 type BlackboxHandler struct {
-	cfg      *config.Config
+	cfg      *config.Store
 	postgres *database.PostgresDB
+	impacts  *services.ImpactAnalyzer
+	migrator *services.TrailMigrator
+	store    services.ObjectStore // nil without object storage
+	audit    *services.AuditLogger
 }
 
 func NewBlackboxHandler(
-	cfg *config.Config,
+	cfg *config.Store,
 	postgres *database.PostgresDB,
+	impacts *services.ImpactAnalyzer,
+	migrator *services.TrailMigrator,
+	store services.ObjectStore,
+	audit *services.AuditLogger,
 ) *BlackboxHandler {
 	return &BlackboxHandler{
 		cfg:      cfg,
 		postgres: postgres,
+		impacts:  impacts,
+		migrator: migrator,
+		store:    store,
+		audit:    audit,
 	}
 }
 
 type BlackboxUploadRequest struct {
-	UserID     string                  `json:"user_id" binding:"required"`
-	StartTs    time.Time               `json:"start_ts" binding:"required"`
-	EndTs      time.Time               `json:"end_ts" binding:"required"`
-	DataPoints []models.BlackboxEntry  `json:"data_points" binding:"required"`
+	UserID     string                 `json:"user_id" binding:"required"`
+	StartTs    *time.Time             `json:"start_ts"` // required
+	EndTs      *time.Time             `json:"end_ts"`   // required; not before start_ts
+	DataPoints []models.BlackboxEntry `json:"data_points" binding:"required"`
+	// ChainSignature signs the hash of the last data point; needed once data points carry hashes
+	ChainSignature string `json:"chain_signature"`
 }
 
+func (r *BlackboxUploadRequest) validate() []apierror.FieldError {
+	var fields []apierror.FieldError
+	for _, ts := range []struct {
+		name  string
+		value *time.Time
+	}{{"start_ts", r.StartTs}, {"end_ts", r.EndTs}} {
+		switch {
+		case ts.value == nil:
+			fields = append(fields, apierror.FieldError{Field: ts.name, Reason: "is required"})
+		case ts.value.IsZero():
+			fields = append(fields, apierror.FieldError{Field: ts.name, Reason: "must be a real time, not the zero time"})
+		}
+	}
+	if len(fields) == 0 && r.EndTs.Before(*r.StartTs) {
+		fields = append(fields, apierror.FieldError{Field: "end_ts", Reason: "must not be before start_ts"})
+	}
+	return fields
+}
+
+// bindBlackboxUpload binds an upload as bindStrict does, after quoting the
+// bare NaN and Infinity some clients write for glitched readings, so they
+// reject single data points rather than the whole body
+func bindBlackboxUpload(c *gin.Context, req *BlackboxUploadRequest) bool {
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			middleware.AbortWithError(c, apierror.PayloadTooLarge("request body is too large"))
+			return false
+		}
+		middleware.AbortWithError(c, apierror.BadRequest("failed to read request body").WithCause(err))
+		return false
+	}
+	return checkStrict(c, req, binding.JSON.BindBody(services.QuoteNonFiniteNumbers(body), req))
+}
+
+// maxRejectionFields caps the rejected data points listed when an upload is
+// refused for having too few valid ones
+const maxRejectionFields = 20
+
 // POST /v1/blackbox/upload
 func (h *BlackboxHandler) UploadTrail(c *gin.Context) {
 	var req BlackboxUploadRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		log.Printf("ERROR: Failed to bind JSON in blackbox upload: %v", err)
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "invalid request",
-			"details": err.Error(),
-		})
+	if !bindBlackboxUpload(c, &req) {
 		return
 	}
 	
@@ -54,93 +111,238 @@ func (h *BlackboxHandler) UploadTrail(c *gin.Context) {
 	userID, err := uuid.Parse(req.UserID)
 	if err != nil {
 		log.Printf("ERROR: Failed to parse user_id '%s': %v", req.UserID, err)
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "invalid user_id",
-			"details": err.Error(),
-		})
+		middleware.AbortWithError(c, apierror.Invalid("user_id", "must be a valid UUID"))
 		return
 	}
 
 	// Verify user exists
 	user, err := h.postgres.GetUserByID(c.Request.Context(), userID)
 	if err != nil {
-		log.Printf("ERROR: Failed to get user %s: %v", userID, err)
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "database error",
-			"details": err.Error(),
-		})
+		middleware.AbortWithError(c, apierror.Internal("database error", err))
 		return
 	}
 	if user == nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		middleware.AbortWithError(c, apierror.NotFound("user not found"))
 		return
 	}
 
-	// Convert data points to JSON string (in production, store in S3/Spaces)
-	dataJSON, err := json.Marshal(req.DataPoints)
-	if err != nil {
-		log.Printf("ERROR: Failed to marshal data points for user %s: %v", userID, err)
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "failed to serialize data",
-			"details": err.Error(),
-		})
+	// Data points that fail validation are quarantined rather than failing
+	// the upload, unless too few are left to be worth keeping
+	accepted, quarantined := services.ScreenBlackboxEntries(req.DataPoints, *req.StartTs, *req.EndTs)
+	minValid := h.cfg.Current().BlackboxMinValidFraction
+	if len(req.DataPoints) > 0 && float64(len(accepted)) < minValid*float64(len(req.DataPoints)) {
+		log.Printf("WARN: Refused blackbox upload for user %s: %d of %d data points valid",
+			userID, len(accepted), len(req.DataPoints))
+		fields := make([]apierror.FieldError, 0, min(len(quarantined), maxRejectionFields))
+		for _, q := range quarantined[:min(len(quarantined), maxRejectionFields)] {
+			fields = append(fields, apierror.FieldError{
+				Field:  fmt.Sprintf("data_points[%d]", q.Index),
+				Reason: strings.Join(q.Reasons, "; "),
+			})
+		}
+		e := apierror.Unprocessable(fields)
+		e.Message = fmt.Sprintf("only %d of %d data points are valid; at least %g%% must be",
+			len(accepted), len(req.DataPoints), minValid*100)
+		middleware.AbortWithError(c, e)
 		return
 	}
 
-	// For now, store as data URI (in production, upload to object storage)
-	fileURL := "data:application/json;base64," + string(dataJSON)
+	// Tamper evidence: a broken chain or bad signature is still stored, marked
+	// as such, so the data survives for investigators, but the upload fails.
+	// The chain covers every uploaded data point, quarantined or not.
+	chain := services.VerifyBlackboxChain(userID, req.DataPoints, req.ChainSignature, h.cfg.HMACSecrets())
 
 	// Create trail record
 	trail := &models.BlackboxTrail{
 		ID:         uuid.New(),
 		UserID:     userID,
-		StartTs:    req.StartTs,
-		EndTs:      req.EndTs,
-		DataPoints: len(req.DataPoints),
-		FileURL:    fileURL,
+		StartTs:    *req.StartTs,
+		EndTs:      *req.EndTs,
+		DataPoints: len(accepted),
 		UploadedAt: time.Now(),
+
+		RejectedPoints: len(quarantined),
+
+		IntegrityStatus: chain.Status,
+		ChainHead:       chain.Head,
+		ChainBreakIndex: chain.BreakIndex,
+	}
+
+	// The data points go to object storage when it is configured
+	trail.FileURL, err = services.SaveBlackboxTrail(c.Request.Context(), h.store, userID, trail.ID, accepted)
+	if err != nil {
+		middleware.AbortWithError(c, apierror.Internal("failed to store trail data", err))
+		return
+	}
+
+	if len(quarantined) > 0 {
+		trail.QuarantineURL, err = services.SaveBlackboxQuarantine(c.Request.Context(), h.store, userID, trail.ID, quarantined)
+		if err != nil {
+			middleware.AbortWithError(c, apierror.Internal("failed to store quarantined data points", err))
+			return
+		}
+		log.Printf("INFO: Quarantined %d of %d data points of trail %s for user %s",
+			len(quarantined), len(req.DataPoints), trail.ID, userID)
 	}
 
 	if err := h.postgres.CreateBlackboxTrail(c.Request.Context(), trail); err != nil {
-		log.Printf("ERROR: Failed to create blackbox trail for user %s: %v", userID, err)
-		log.Printf("Trail details: ID=%s, DataPoints=%d, StartTs=%v, EndTs=%v", 
+		log.Printf("Trail details: ID=%s, DataPoints=%d, StartTs=%v, EndTs=%v",
 			trail.ID, trail.DataPoints, trail.StartTs, trail.EndTs)
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "failed to store trail",
-			"details": err.Error(),
-			"trail_id": trail.ID.String(),
-		})
+		middleware.AbortWithError(c, apierror.Internal("failed to store trail", err))
+		return
+	}
+
+	switch chain.Status {
+	case models.TrailBroken:
+		log.Printf("WARN: Trail %s for user %s has a broken hash chain at entry %d", trail.ID, userID, *chain.BreakIndex)
+		e := apierror.New(http.StatusUnprocessableEntity, apierror.CodeIntegrityFailed,
+			fmt.Sprintf("hash chain broken at entry %d; trail %s stored as broken", *chain.BreakIndex, trail.ID))
+		e.Fields = []apierror.FieldError{{
+			Field:  fmt.Sprintf("data_points[%d].hash", *chain.BreakIndex),
+			Reason: "does not follow from the previous entry",
+		}}
+		middleware.AbortWithError(c, e)
+		return
+	case models.TrailBadSignature:
+		log.Printf("WARN: Trail %s for user %s has an invalid chain signature", trail.ID, userID)
+		e := apierror.New(http.StatusUnprocessableEntity, apierror.CodeIntegrityFailed,
+			fmt.Sprintf("chain signature invalid; trail %s stored as bad_signature", trail.ID))
+		e.Fields = []apierror.FieldError{{Field: "chain_signature", Reason: "does not match the chain head"}}
+		middleware.AbortWithError(c, e)
 		return
 	}
 
+	// Scan the sensor data for a crash signature; a trail that can't be queued
+	// now stays unanalyzed and is picked up on the next start
+	if err := h.impacts.Enqueue(trail.ID); err != nil {
+		log.Printf("WARN: Trail %s not queued for impact analysis: %v", trail.ID, err)
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"status":      "success",
 		"trail_id":    trail.ID,
 		"data_points": trail.DataPoints,
+		"accepted":    trail.DataPoints,
+		"rejected":    trail.RejectedPoints,
+		"integrity":   trail.IntegrityStatus,
+		"chain_head":  trail.ChainHead,
 		"message":     "blackbox trail uploaded successfully",
 	})
 }
 
-// GET /v1/blackbox/trails/:user_id
-func (h *BlackboxHandler) GetUserTrails(c *gin.Context) {
-	userID, err := uuid.Parse(c.Param("user_id"))
+// trailsUserKey holds the authorized user TrailsVersion loaded, for
+// GetUserTrails to reuse
+const trailsUserKey = "trails.user"
+
+// TrailsVersion versions the trail listing by the user's trail count, newest
+// upload and newest move to object storage. A listing may be reused for the
+// configured heartbeat interval.
+func (h *BlackboxHandler) TrailsVersion(c *gin.Context) (string, time.Duration, error) {
+	user, ok := loadAuthorizedUser(c, h.postgres)
+	if !ok {
+		return "", 0, nil // the error response is written and the chain aborted
+	}
+	c.Set(trailsUserKey, user)
+	count, uploaded, migrated, err := h.postgres.GetBlackboxTrailsVersion(c.Request.Context(), user.ID)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user_id"})
+		return "", 0, err
+	}
+	version := fmt.Sprintf("%d-%s-%s", count, unixNano36(uploaded), unixNano36(migrated))
+	return version, time.Duration(h.cfg.Current().HeartbeatIntervalSeconds) * time.Second, nil
+}
+
+func unixNano36(t *time.Time) string {
+	if t == nil {
+		return "0"
+	}
+	return strconv.FormatInt(t.UnixNano(), 36)
+}
+
+// GET /v1/user/:user_id/blackbox/trails
+// Also served at the deprecated GET /v1/blackbox/trails/:user_id. For the
+// user, their contacts with read_status, guardians and admins, as /status.
+func (h *BlackboxHandler) GetUserTrails(c *gin.Context) {
+	var user *models.User
+	if v, ok := c.Get(trailsUserKey); ok {
+		user = v.(*models.User)
+	} else if user, ok = loadAuthorizedUser(c, h.postgres); !ok {
 		return
 	}
+	userID := user.ID
 
 	trails, err := h.postgres.GetBlackboxTrails(c.Request.Context(), userID, 10)
 	if err != nil {
-		log.Printf("ERROR: Failed to get blackbox trails for user %s: %v", userID, err)
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "failed to get trails",
-			"details": err.Error(),
-		})
+		middleware.AbortWithError(c, apierror.Internal("failed to get trails", err))
 		return
 	}
 
+	recordAudit(c, h.audit, &models.AuditEvent{
+		Action:        services.AuditTrailsView,
+		ObjectType:    "blackbox_trail",
+		SubjectUserID: &userID,
+		Metadata:      map[string]interface{}{"count": len(trails)},
+	})
+
 	c.JSON(http.StatusOK, gin.H{
 		"user_id": userID,
 		"trails":  trails,
 	})
 }
+
+type MigrateTrailsRequest struct {
+	Limit       int  `json:"limit" binding:"min=0"`
+	RetryFailed bool `json:"retry_failed"`
+}
+
+// POST /admin/blackbox/migrate
+// Starts moving trails stored inline as data URIs to object storage in the
+// background. Runs resume where the last one stopped.
+func (h *BlackboxHandler) MigrateTrails(c *gin.Context) {
+	var req MigrateTrailsRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			middleware.AbortWithError(c, apierror.Validation(err))
+			return
+		}
+	}
+
+	err := h.migrator.Start(services.TrailMigrationOptions{Limit: req.Limit, RetryFailed: req.RetryFailed})
+	if errors.Is(err, services.ErrObjectStoreDisabled) {
+		middleware.AbortWithError(c, apierror.Unavailable("object storage is not configured; set BLACKBOX_BUCKET"))
+		return
+	}
+	if errors.Is(err, services.ErrTrailMigrationRunning) {
+		middleware.AbortWithError(c, apierror.Conflict("a trail migration is already running"))
+		return
+	}
+	if err != nil {
+		middleware.AbortWithError(c, apierror.Internal("failed to start trail migration", err))
+		return
+	}
+
+	recordAudit(c, h.audit, &models.AuditEvent{
+		Action:     services.AuditTrailMigrationStart,
+		ObjectType: "blackbox_trail",
+		Metadata: map[string]interface{}{
+			"limit":        req.Limit,
+			"retry_failed": req.RetryFailed,
+		},
+	})
+
+	c.JSON(http.StatusAccepted, gin.H{"run": h.migrator.Status()})
+}
+
+// GET /admin/blackbox/migrate
+// Progress of the current or last run and of the migration overall
+func (h *BlackboxHandler) GetTrailMigration(c *gin.Context) {
+	counts, err := h.postgres.GetTrailMigrationCounts(c.Request.Context())
+	if err != nil {
+		middleware.AbortWithError(c, apierror.Internal("failed to get migration progress", err))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"run":    h.migrator.Status(),
+		"totals": counts,
+	})
+}
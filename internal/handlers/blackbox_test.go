@@ -0,0 +1,134 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/config"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/middleware"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/params"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/services"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/utils"
+)
+
+func trailsRouter(h *BlackboxHandler) http.Handler {
+	router := testRouter()
+	router.GET("/v1/user/:user_id/blackbox/trails", params.UUID(params.User), middleware.RequireAuth(testSecret),
+		middleware.Conditional(h.TrailsVersion), h.GetUserTrails)
+	router.GET("/v1/blackbox/trails/:user_id", middleware.Deprecated("/v1/user/:user_id/blackbox/trails"),
+		params.UUID(params.User), middleware.RequireAuth(testSecret),
+		middleware.Conditional(h.TrailsVersion), h.GetUserTrails)
+	return router
+}
+
+// A trail history is where the user has been; nobody reads it anonymously,
+// on either path
+func TestGetUserTrailsRequiresAuth(t *testing.T) {
+	router := trailsRouter(NewBlackboxHandler(nil, nil, nil, nil, nil, nil))
+	userID := uuid.NewString()
+	for _, path := range []string{"/v1/user/" + userID + "/blackbox/trails", "/v1/blackbox/trails/" + userID} {
+		if w := send(t, router, http.MethodGet, path, "", nil); w.Code != http.StatusUnauthorized {
+			t.Errorf("anonymous GET %s = %d, want 401", path, w.Code)
+		}
+	}
+}
+
+func TestGetUserTrailsAccess(t *testing.T) {
+	postgres := testPostgres(t)
+	user := createTestUser(t, postgres)
+	router := trailsRouter(NewBlackboxHandler(config.NewStore(&config.Config{HeartbeatIntervalSeconds: 300}), postgres, nil, nil, nil, nil))
+
+	tests := []struct {
+		name   string
+		claims utils.TokenClaims
+		want   int
+	}{
+		{"the user", utils.TokenClaims{Subject: user.ID.String(), Role: utils.RoleUser}, http.StatusOK},
+		{"another user", utils.TokenClaims{Subject: uuid.NewString(), Role: utils.RoleUser}, http.StatusForbidden},
+		{"a stranger's contact token", utils.TokenClaims{Subject: user.ID.String(), Role: utils.RoleContact, Phone: "+2348000000000"}, http.StatusForbidden},
+		{"another org's admin", utils.TokenClaims{Subject: uuid.NewString(), Role: utils.RoleOrgAdmin, OrgID: uuid.NewString()}, http.StatusForbidden},
+		{"admin", utils.TokenClaims{Subject: uuid.NewString(), Role: utils.RoleAdmin}, http.StatusOK},
+	}
+	for _, tt := range tests {
+		for _, path := range []string{"/v1/user/" + user.ID.String() + "/blackbox/trails", "/v1/blackbox/trails/" + user.ID.String()} {
+			if w := send(t, router, http.MethodGet, path, "", &tt.claims); w.Code != tt.want {
+				t.Errorf("%s: GET %s = %d, want %d", tt.name, path, w.Code, tt.want)
+			}
+		}
+	}
+}
+
+// uploadBody wraps a fixture's data points, bare NaN and all, in an upload
+// for the trail from 10:00 to 10:01 the fixtures were recorded over
+func uploadBody(t *testing.T, userID uuid.UUID, fixture string) string {
+	t.Helper()
+	points, err := os.ReadFile(filepath.Join("../services/testdata/blackbox_uploads", fixture))
+	if err != nil {
+		t.Fatalf("read fixture: %v", err)
+	}
+	return `{"user_id":"` + userID.String() + `","start_ts":"2026-03-09T10:00:00Z","end_ts":"2026-03-09T10:01:00Z","data_points":` + string(points) + `}`
+}
+
+// Glitched data points are quarantined and the rest of the trail kept; a
+// trail with too few valid ones is refused and nothing is stored
+func TestUploadTrailQuarantine(t *testing.T) {
+	postgres := testPostgres(t)
+	cfg := config.NewStore(&config.Config{BlackboxMinValidFraction: 0.5})
+	impacts := services.NewImpactAnalyzer(cfg, postgres, nil, nil, nil, nil) // never started: trails just queue
+	router := testRouter()
+	router.POST("/v1/blackbox/upload", NewBlackboxHandler(cfg, postgres, impacts, nil, nil, nil).UploadTrail)
+	user := createTestUser(t, postgres)
+	ctx := context.Background()
+
+	w := send(t, router, http.MethodPost, "/v1/blackbox/upload", uploadBody(t, user.ID, "nan_accel_burst.json"), nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("upload with a NaN burst = %d: %s", w.Code, w.Body.String())
+	}
+	var resp struct {
+		TrailID  uuid.UUID `json:"trail_id"`
+		Accepted int       `json:"accepted"`
+		Rejected int       `json:"rejected"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("response %s: %v", w.Body.String(), err)
+	}
+	if resp.Accepted != 45 || resp.Rejected != 3 {
+		t.Errorf("accepted %d, rejected %d; want 45, 3", resp.Accepted, resp.Rejected)
+	}
+	trail, err := postgres.GetBlackboxTrail(ctx, resp.TrailID)
+	if err != nil || trail == nil {
+		t.Fatalf("GetBlackboxTrail = %v, %v", trail, err)
+	}
+	if trail.DataPoints != 45 || trail.RejectedPoints != 3 || trail.QuarantineURL == "" {
+		t.Errorf("trail = %d accepted, %d rejected, quarantine %q", trail.DataPoints, trail.RejectedPoints, trail.QuarantineURL)
+	}
+	stored, err := services.LoadBlackboxEntries(ctx, nil, trail.FileURL)
+	if err != nil || len(stored) != 45 {
+		t.Errorf("stored %d entries, %v; want the 45 accepted", len(stored), err)
+	}
+	quarantined, err := services.LoadBlackboxQuarantine(ctx, nil, trail.QuarantineURL)
+	if err != nil || len(quarantined) != 3 || quarantined[0].Index != 5 {
+		t.Errorf("quarantine = %+v, %v; want entries 5 to 7", quarantined, err)
+	}
+
+	before, err := postgres.GetBlackboxTrails(ctx, user.ID, 100)
+	if err != nil {
+		t.Fatalf("GetBlackboxTrails: %v", err)
+	}
+	w = send(t, router, http.MethodPost, "/v1/blackbox/upload", uploadBody(t, user.ID, "corrupt.json"), nil)
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("fully corrupt upload = %d, want 422: %s", w.Code, w.Body.String())
+	}
+	if fields := errorFields(t, w); len(fields) != 5 || fields["data_points[1]"] != "is not an object" {
+		t.Errorf("fields = %v, want every data point's reasons", fields)
+	}
+	if after, err := postgres.GetBlackboxTrails(ctx, user.ID, 100); err != nil || len(after) != len(before) {
+		t.Errorf("trails after a refused upload = %d, %v; want %d", len(after), err, len(before))
+	}
+}
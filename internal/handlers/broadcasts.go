@@ -0,0 +1,161 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/apierror"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/config"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/database"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/middleware"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/params"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/services"
+)
+
+type BroadcastsHandler struct {
+	cfg        *config.Config
+	postgres   *database.PostgresDB
+	broadcasts *services.BroadcastService
+	audit      *services.AuditLogger
+}
+
+func NewBroadcastsHandler(
+	cfg *config.Config,
+	postgres *database.PostgresDB,
+	broadcasts *services.BroadcastService,
+	audit *services.AuditLogger,
+) *BroadcastsHandler {
+	return &BroadcastsHandler{
+		cfg:        cfg,
+		postgres:   postgres,
+		broadcasts: broadcasts,
+		audit:      audit,
+	}
+}
+
+type CreateBroadcastRequest struct {
+	Message  string          `json:"message" binding:"required"`
+	Channels []string        `json:"channels" binding:"required"`
+	Geofence models.Geofence `json:"geofence" binding:"required"`
+	DryRun   bool            `json:"dry_run"`
+}
+
+// POST /admin/broadcasts
+func (h *BroadcastsHandler) CreateBroadcast(c *gin.Context) {
+	var req CreateBroadcastRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		middleware.AbortWithError(c, apierror.Validation(err))
+		return
+	}
+
+	if err := services.ValidateGeofence(req.Geofence); err != nil {
+		middleware.AbortWithError(c, apierror.Invalid("geofence", err.Error()))
+		return
+	}
+
+	channels, ok := normalizeChannels(req.Channels)
+	if !ok {
+		middleware.AbortWithError(c, apierror.BadRequest("channels must contain push and/or sms"))
+		return
+	}
+
+	audience, err := h.broadcasts.ResolveAudience(c.Request.Context(), req.Geofence)
+	if err != nil {
+		middleware.AbortWithError(c, apierror.Internal("failed to resolve audience", err))
+		return
+	}
+
+	if req.DryRun {
+		c.JSON(http.StatusOK, gin.H{
+			"dry_run":        true,
+			"audience_count": len(audience),
+		})
+		return
+	}
+
+	admin := middleware.Principal(c)
+	broadcast, err := h.broadcasts.Create(c.Request.Context(), admin.Subject, req.Message, channels, req.Geofence, audience)
+	if err != nil {
+		middleware.AbortWithError(c, apierror.Internal("failed to create broadcast", err))
+		return
+	}
+
+	log.Printf("INFO: Broadcast %s created by %s for %d users", broadcast.ID, admin.Subject, broadcast.AudienceSize)
+
+	recordAudit(c, h.audit, &models.AuditEvent{
+		Action:     services.AuditBroadcastCreate,
+		ObjectType: "broadcast",
+		ObjectID:   broadcast.ID.String(),
+		Metadata: map[string]interface{}{
+			"channels":      channels,
+			"audience_size": broadcast.AudienceSize,
+		},
+	})
+
+	c.JSON(http.StatusAccepted, broadcast)
+}
+
+// GET /admin/broadcasts/:broadcast_id
+func (h *BroadcastsHandler) GetBroadcast(c *gin.Context) {
+	id := params.Get(c, params.Broadcast)
+
+	broadcast, err := h.postgres.GetBroadcast(c.Request.Context(), id)
+	if err != nil {
+		middleware.AbortWithError(c, apierror.Internal("database error", err))
+		return
+	}
+	if broadcast == nil {
+		middleware.AbortWithError(c, apierror.NotFound("broadcast not found"))
+		return
+	}
+
+	stats, err := h.postgres.GetBroadcastStats(c.Request.Context(), id)
+	if err != nil {
+		middleware.AbortWithError(c, apierror.Internal("database error", err))
+		return
+	}
+	broadcast.Stats = stats
+
+	c.JSON(http.StatusOK, broadcast)
+}
+
+// POST /admin/broadcasts/:broadcast_id/abort
+func (h *BroadcastsHandler) AbortBroadcast(c *gin.Context) {
+	id := params.Get(c, params.Broadcast)
+
+	aborted, err := h.broadcasts.Abort(c.Request.Context(), id)
+	if err != nil {
+		middleware.AbortWithError(c, apierror.Internal("failed to abort broadcast", err))
+		return
+	}
+
+	recordAudit(c, h.audit, &models.AuditEvent{
+		Action:     services.AuditBroadcastAbort,
+		ObjectType: "broadcast",
+		ObjectID:   id.String(),
+		Metadata:   map[string]interface{}{"aborted_deliveries": aborted},
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":             "success",
+		"aborted_deliveries": aborted,
+	})
+}
+
+// normalizeChannels de-duplicates channels and rejects unknown ones
+func normalizeChannels(channels []string) ([]string, bool) {
+	seen := make(map[string]bool)
+	result := make([]string, 0, len(channels))
+	for _, ch := range channels {
+		if ch != services.ChannelPush && ch != services.ChannelSMS {
+			return nil, false
+		}
+		if !seen[ch] {
+			seen[ch] = true
+			result = append(result, ch)
+		}
+	}
+	return result, len(result) > 0
+}
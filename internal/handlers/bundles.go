@@ -0,0 +1,164 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/apierror"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/database"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/middleware"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/params"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/services"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/utils"
+)
+
+type BundlesHandler struct {
+	postgres *database.PostgresDB
+	bundles  *services.IncidentBundles
+	audit    *services.AuditLogger
+}
+
+func NewBundlesHandler(postgres *database.PostgresDB, bundles *services.IncidentBundles, audit *services.AuditLogger) *BundlesHandler {
+	return &BundlesHandler{
+		postgres: postgres,
+		bundles:  bundles,
+		audit:    audit,
+	}
+}
+
+// POST /v1/alerts/:alert_id/bundle
+// Queues an investigation bundle of a resolved alert, for the alerted user
+// or an admin. Answers 202 with the bundle; while one is queued or being
+// built, that one is returned instead of queuing another.
+func (h *BundlesHandler) Request(c *gin.Context) {
+	alert, ok := h.loadAlert(c)
+	if !ok {
+		return
+	}
+
+	bundle, created, err := h.bundles.Request(c.Request.Context(), alert, middleware.Principal(c).Subject)
+	switch {
+	case errors.Is(err, services.ErrIncidentBundlesDisabled):
+		middleware.AbortWithError(c, apierror.NotFound("investigation bundles are not enabled"))
+		return
+	case errors.Is(err, services.ErrAlertUnresolved):
+		middleware.AbortWithError(c, apierror.Conflict("alert is not resolved yet"))
+		return
+	case err != nil:
+		middleware.AbortWithError(c, apierror.Internal("failed to queue bundle", err))
+		return
+	}
+
+	if created {
+		recordAudit(c, h.audit, &models.AuditEvent{
+			Action:        services.AuditBundleRequest,
+			ObjectType:    "alert",
+			ObjectID:      alert.ID.String(),
+			SubjectUserID: &alert.UserID,
+			Metadata:      map[string]interface{}{"bundle_id": bundle.ID},
+		})
+	}
+
+	c.JSON(http.StatusAccepted, h.response(bundle))
+}
+
+// GET /v1/alerts/:alert_id/bundles/:bundle_id
+// A bundle's status and, once it is ready, its download link
+func (h *BundlesHandler) Get(c *gin.Context) {
+	alert, ok := h.loadAlert(c)
+	if !ok {
+		return
+	}
+
+	bundle, err := h.postgres.GetIncidentBundle(c.Request.Context(), params.Get(c, params.Bundle))
+	if err != nil {
+		middleware.AbortWithError(c, apierror.Internal("database error", err))
+		return
+	}
+	if bundle == nil || bundle.AlertID != alert.ID {
+		middleware.AbortWithError(c, apierror.NotFound("bundle not found"))
+		return
+	}
+
+	recordAudit(c, h.audit, &models.AuditEvent{
+		Action:        services.AuditBundleView,
+		ObjectType:    "incident_bundle",
+		ObjectID:      bundle.ID.String(),
+		SubjectUserID: &alert.UserID,
+	})
+
+	c.JSON(http.StatusOK, h.response(bundle))
+}
+
+// GET /bundles/:bundle_id?expires=&sig=
+// Download link of a ready bundle, signed and expiring with it. Anyone
+// holding the link can download the bundle, so each download is audited.
+func (h *BundlesHandler) Download(c *gin.Context) {
+	id := params.Get(c, params.Bundle)
+	expires, err := strconv.ParseInt(c.Query("expires"), 10, 64)
+	if err != nil || !h.bundles.VerifyLink(id, expires, c.Query("sig")) {
+		middleware.AbortWithError(c, apierror.NotFound("this link is not valid or has expired"))
+		return
+	}
+
+	bundle, err := h.postgres.GetIncidentBundle(c.Request.Context(), id)
+	if err != nil {
+		middleware.AbortWithError(c, apierror.Internal("database error", err))
+		return
+	}
+	if bundle == nil || bundle.Status != models.BundleReady {
+		middleware.AbortWithError(c, apierror.Gone("this bundle is no longer available"))
+		return
+	}
+
+	data, err := h.bundles.Download(c.Request.Context(), bundle)
+	if err != nil {
+		middleware.AbortWithError(c, apierror.Internal("failed to load bundle", err))
+		return
+	}
+
+	recordAudit(c, h.audit, &models.AuditEvent{
+		Action:        services.AuditBundleDownload,
+		ObjectType:    "incident_bundle",
+		ObjectID:      bundle.ID.String(),
+		SubjectUserID: &bundle.UserID,
+		Metadata:      map[string]interface{}{"alert_id": bundle.AlertID},
+	})
+
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="alert-%s-bundle.zip"`, bundle.AlertID))
+	c.Header("Cache-Control", "private, no-store")
+	c.Data(http.StatusOK, "application/zip", data)
+}
+
+// loadAlert returns the :alert_id alert when the caller is the alerted user
+// or an admin. It writes the error response itself, the same for missing
+// and foreign alerts so IDs can't be probed.
+func (h *BundlesHandler) loadAlert(c *gin.Context) (*models.Alert, bool) {
+	alert, err := h.postgres.GetAlertByID(c.Request.Context(), params.Get(c, params.Alert))
+	if err != nil {
+		middleware.AbortWithError(c, apierror.Internal("database error", err))
+		return nil, false
+	}
+
+	claims := middleware.Principal(c)
+	allowed := alert != nil && claims != nil && (claims.Role == utils.RoleAdmin ||
+		(claims.Role == utils.RoleUser && claims.Subject == alert.UserID.String()))
+	if !allowed {
+		middleware.AbortWithError(c, apierror.NotFound("alert not found"))
+		return nil, false
+	}
+	return alert, true
+}
+
+func (h *BundlesHandler) response(bundle *models.IncidentBundle) gin.H {
+	response := gin.H{"bundle": bundle}
+	if link := h.bundles.Link(bundle); link != "" {
+		response["download_url"] = link
+	}
+	return response
+}
@@ -0,0 +1,198 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/config"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/middleware"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/params"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/services"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/utils"
+)
+
+// memoryObjectStore keeps objects in memory
+type memoryObjectStore struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func (s *memoryObjectStore) Put(ctx context.Context, key string, data []byte, contentType string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.objects[key] = append([]byte(nil), data...)
+	return nil
+}
+
+func (s *memoryObjectStore) Get(ctx context.Context, key string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, ok := s.objects[key]
+	if !ok {
+		return nil, fmt.Errorf("object %s not found", key)
+	}
+	return data, nil
+}
+
+func (s *memoryObjectStore) Delete(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.objects, key)
+	return nil
+}
+
+type bundleResponse struct {
+	Bundle      models.IncidentBundle `json:"bundle"`
+	DownloadURL string                `json:"download_url"`
+}
+
+func decodeBundle(t *testing.T, body []byte) bundleResponse {
+	t.Helper()
+	var resp bundleResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		t.Fatalf("bundle response %s: %v", body, err)
+	}
+	return resp
+}
+
+// Only the alerted user or an admin may ask for a bundle or see it, and
+// what they get verifies against its signed manifest
+func TestIncidentBundleAccess(t *testing.T) {
+	postgres := testPostgres(t)
+	ctx := context.Background()
+	cfg := config.NewStore(&config.Config{HMACSecret: testSecret, PublicBaseURL: "https://safetrace.test", IncidentBundleRetentionHours: 24})
+	bundles := services.NewIncidentBundles(cfg, postgres, &memoryObjectStore{objects: map[string][]byte{}}, nil, services.NewHealthRegistry())
+	audit := services.NewAuditLogger(postgres, nil, 100, 100, time.Hour)
+	audit.Start()
+	h := NewBundlesHandler(postgres, bundles, audit)
+
+	router := testRouter()
+	router.GET("/bundles/:bundle_id", params.UUID(params.Bundle), h.Download)
+	router.POST("/v1/alerts/:alert_id/bundle", params.UUID(params.Alert), middleware.RequireAuth(testSecret), h.Request)
+	router.GET("/v1/alerts/:alert_id/bundles/:bundle_id", params.UUID(params.Alert, params.Bundle), middleware.RequireAuth(testSecret), h.Get)
+
+	user, other := createTestUser(t, postgres), createTestUser(t, postgres)
+	now := time.Now()
+	alert := &models.Alert{ID: uuid.New(), UserID: user.ID, State: models.AlertStateAlert, Reasons: models.Reasons{}, SentTo: []string{}, CreatedAt: now, DetectedAt: now}
+	if err := postgres.CreateAlert(ctx, alert); err != nil {
+		t.Fatalf("CreateAlert: %v", err)
+	}
+	owner := &utils.TokenClaims{Subject: user.ID.String(), Role: utils.RoleUser}
+	admin := &utils.TokenClaims{Subject: "ops", Role: utils.RoleAdmin}
+	request := "/v1/alerts/" + alert.ID.String() + "/bundle"
+
+	if w := send(t, router, http.MethodPost, request, "", owner); w.Code != http.StatusConflict {
+		t.Errorf("bundle of an open alert = %d, want 409", w.Code)
+	}
+	if err := postgres.ResolveAlert(ctx, alert.ID); err != nil {
+		t.Fatalf("ResolveAlert: %v", err)
+	}
+
+	// Anyone else gets what a missing alert gets; contact tokens aren't
+	// accepted at all
+	refused := []struct {
+		name   string
+		claims *utils.TokenClaims
+		code   int
+	}{
+		{"anonymous", nil, http.StatusUnauthorized},
+		{"another user", &utils.TokenClaims{Subject: other.ID.String(), Role: utils.RoleUser}, http.StatusNotFound},
+		{"an org admin", &utils.TokenClaims{Subject: other.ID.String(), Role: utils.RoleOrgAdmin, OrgID: uuid.NewString()}, http.StatusNotFound},
+		{"the user's contact", &utils.TokenClaims{Subject: user.ID.String(), Role: utils.RoleContact, Phone: "+2348030000001"}, http.StatusForbidden},
+	}
+	missing := send(t, router, http.MethodPost, "/v1/alerts/"+uuid.NewString()+"/bundle", "", owner)
+	for _, tt := range refused {
+		w := send(t, router, http.MethodPost, request, "", tt.claims)
+		if w.Code != tt.code {
+			t.Errorf("%s: %d, want %d", tt.name, w.Code, tt.code)
+		}
+		if tt.code == http.StatusNotFound && !strings.Contains(w.Body.String(), "alert not found") {
+			t.Errorf("%s: %s, want the answer to a missing alert %s", tt.name, w.Body.String(), missing.Body.String())
+		}
+	}
+
+	w := send(t, router, http.MethodPost, request, "", owner)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("owner's request = %d: %s", w.Code, w.Body.String())
+	}
+	queued := decodeBundle(t, w.Body.Bytes())
+	if queued.Bundle.Status != models.BundlePending || queued.Bundle.RequestedBy != user.ID.String() || queued.DownloadURL != "" {
+		t.Errorf("queued = %+v", queued)
+	}
+	// An admin asking meanwhile gets the same bundle
+	w = send(t, router, http.MethodPost, request, "", admin)
+	if w.Code != http.StatusAccepted || decodeBundle(t, w.Body.Bytes()).Bundle.ID != queued.Bundle.ID {
+		t.Errorf("admin's request = %d: %s, want bundle %s", w.Code, w.Body.String(), queued.Bundle.ID)
+	}
+
+	status := "/v1/alerts/" + alert.ID.String() + "/bundles/" + queued.Bundle.ID.String()
+	for _, tt := range refused {
+		if w := send(t, router, http.MethodGet, status, "", tt.claims); w.Code != tt.code {
+			t.Errorf("%s reading the bundle: %d, want %d", tt.name, w.Code, tt.code)
+		}
+	}
+	otherAlert := "/v1/alerts/" + uuid.NewString() + "/bundles/" + queued.Bundle.ID.String()
+	if w := send(t, router, http.MethodGet, otherAlert, "", admin); w.Code != http.StatusNotFound {
+		t.Errorf("bundle under another alert: %d, want 404", w.Code)
+	}
+
+	bundles.Start()
+	defer bundles.Close()
+	var ready bundleResponse
+	for deadline := time.Now().Add(10 * time.Second); ready.Bundle.Status != models.BundleReady; {
+		if time.Now().After(deadline) {
+			t.Fatalf("bundle still %s", ready.Bundle.Status)
+		}
+		time.Sleep(20 * time.Millisecond)
+		w := send(t, router, http.MethodGet, status, "", admin)
+		if w.Code != http.StatusOK {
+			t.Fatalf("admin reading the bundle = %d: %s", w.Code, w.Body.String())
+		}
+		ready = decodeBundle(t, w.Body.Bytes())
+	}
+	if ready.DownloadURL == "" {
+		t.Fatal("ready bundle has no download link")
+	}
+
+	w = send(t, router, http.MethodGet, ready.DownloadURL, "", nil)
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/zip" {
+		t.Fatalf("download = %d %s", w.Code, w.Header().Get("Content-Type"))
+	}
+	manifest, err := services.VerifyIncidentBundle(w.Body.Bytes(), []string{testSecret})
+	if err != nil {
+		t.Fatalf("downloaded bundle: %v", err)
+	}
+	if manifest.AlertID != alert.ID || manifest.Signature != ready.Bundle.Signature {
+		t.Errorf("manifest of alert %s signed %q, want %s signed %q", manifest.AlertID, manifest.Signature, alert.ID, ready.Bundle.Signature)
+	}
+	if _, err := services.VerifyIncidentBundle(w.Body.Bytes(), []string{"other-secret"}); err == nil {
+		t.Error("bundle verifies with another secret")
+	}
+
+	tampered := strings.Replace(ready.DownloadURL, "sig=", "sig=x", 1)
+	if w := send(t, router, http.MethodGet, tampered, "", nil); w.Code != http.StatusNotFound {
+		t.Errorf("download with a tampered link = %d, want 404", w.Code)
+	}
+
+	audit.Close()
+	events, _, err := postgres.GetAuditEvents(ctx, models.AuditFilter{SubjectUserID: &user.ID}, 100, 0)
+	if err != nil {
+		t.Fatalf("GetAuditEvents: %v", err)
+	}
+	actions := map[string]int{}
+	for _, event := range events {
+		actions[event.Action]++
+	}
+	if actions[services.AuditBundleRequest] != 1 || actions[services.AuditBundleDownload] != 1 {
+		t.Errorf("audited %v, want one request and one download", actions)
+	}
+}
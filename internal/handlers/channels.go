@@ -0,0 +1,316 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/apierror"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/database"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/middleware"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/params"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/services"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/utils"
+)
+
+type ChannelsHandler struct {
+	postgres *database.PostgresDB
+	channels *services.ChannelNotifier
+	audit    *services.AuditLogger
+}
+
+func NewChannelsHandler(postgres *database.PostgresDB, channels *services.ChannelNotifier, audit *services.AuditLogger) *ChannelsHandler {
+	return &ChannelsHandler{
+		postgres: postgres,
+		channels: channels,
+		audit:    audit,
+	}
+}
+
+type CreateChannelRequest struct {
+	Type       string `json:"type" binding:"required,oneof=slack teams generic_webhook"`
+	Name       string `json:"name" binding:"required,max=100"`
+	WebhookURL string `json:"webhook_url" binding:"required"`
+}
+
+type UpdateChannelRequest struct {
+	Name       *string `json:"name" binding:"omitempty,min=1,max=100"`
+	WebhookURL *string `json:"webhook_url"`
+	Enabled    *bool   `json:"enabled"`
+}
+
+// channelView is a channel as returned by the API: the webhook URL is a
+// credential, so only its host is shown
+type channelView struct {
+	*models.NotificationChannel
+	WebhookHost string `json:"webhook_host"`
+}
+
+func viewChannel(ch *models.NotificationChannel) channelView {
+	return channelView{NotificationChannel: ch, WebhookHost: ch.WebhookHost()}
+}
+
+// GET /v1/user/:user_id/channels
+func (h *ChannelsHandler) ListChannels(c *gin.Context) {
+	user, ok := h.authorize(c)
+	if !ok {
+		return
+	}
+
+	channels, err := h.postgres.GetNotificationChannels(c.Request.Context(), user.ID, false)
+	if err != nil {
+		middleware.AbortWithError(c, apierror.Internal("failed to get channels", err))
+		return
+	}
+
+	views := make([]channelView, len(channels))
+	for i := range channels {
+		views[i] = viewChannel(&channels[i])
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"user_id":  user.ID,
+		"channels": views,
+	})
+}
+
+// POST /v1/user/:user_id/channels
+func (h *ChannelsHandler) CreateChannel(c *gin.Context) {
+	user, ok := h.authorize(c)
+	if !ok {
+		return
+	}
+
+	var req CreateChannelRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		middleware.AbortWithError(c, apierror.Validation(err))
+		return
+	}
+	if err := validateWebhookURL(req.WebhookURL); err != nil {
+		middleware.AbortWithError(c, apierror.Invalid("webhook_url", err.Error()))
+		return
+	}
+
+	now := time.Now()
+	ch := &models.NotificationChannel{
+		ID:         uuid.New(),
+		UserID:     user.ID,
+		Type:       req.Type,
+		Name:       req.Name,
+		WebhookURL: req.WebhookURL,
+		Enabled:    true,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	}
+	if err := h.postgres.CreateNotificationChannel(c.Request.Context(), ch); err != nil {
+		middleware.AbortWithError(c, apierror.Internal("failed to create channel", err))
+		return
+	}
+
+	recordAudit(c, h.audit, &models.AuditEvent{
+		Action:        services.AuditChannelCreate,
+		ObjectType:    "notification_channel",
+		ObjectID:      ch.ID.String(),
+		SubjectUserID: &user.ID,
+		Metadata: map[string]interface{}{
+			"type":         ch.Type,
+			"webhook_host": ch.WebhookHost(),
+		},
+	})
+
+	c.JSON(http.StatusCreated, gin.H{
+		"status":  "success",
+		"channel": viewChannel(ch),
+	})
+}
+
+// PATCH /v1/user/:user_id/channels/:channel_id
+// Re-enabling a channel that was disabled after rejected deliveries clears
+// its failure count.
+func (h *ChannelsHandler) UpdateChannel(c *gin.Context) {
+	user, ok := h.authorize(c)
+	if !ok {
+		return
+	}
+	ch, ok := h.loadChannel(c, user.ID)
+	if !ok {
+		return
+	}
+
+	var req UpdateChannelRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		middleware.AbortWithError(c, apierror.Validation(err))
+		return
+	}
+	changed := []string{}
+	if req.Name != nil {
+		ch.Name = *req.Name
+		changed = append(changed, "name")
+	}
+	if req.WebhookURL != nil {
+		if err := validateWebhookURL(*req.WebhookURL); err != nil {
+			middleware.AbortWithError(c, apierror.Invalid("webhook_url", err.Error()))
+			return
+		}
+		ch.WebhookURL = *req.WebhookURL
+		changed = append(changed, "webhook_url")
+	}
+	if req.Enabled != nil {
+		ch.Enabled = *req.Enabled
+		changed = append(changed, "enabled")
+	}
+
+	updated, err := h.postgres.UpdateNotificationChannel(c.Request.Context(), ch)
+	if err != nil {
+		middleware.AbortWithError(c, apierror.Internal("failed to update channel", err))
+		return
+	}
+	if updated == nil {
+		middleware.AbortWithError(c, apierror.NotFound("channel not found"))
+		return
+	}
+
+	recordAudit(c, h.audit, &models.AuditEvent{
+		Action:        services.AuditChannelUpdate,
+		ObjectType:    "notification_channel",
+		ObjectID:      ch.ID.String(),
+		SubjectUserID: &user.ID,
+		Metadata: map[string]interface{}{
+			"changed": changed,
+			"enabled": updated.Enabled,
+		},
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"channel": viewChannel(updated),
+	})
+}
+
+// DELETE /v1/user/:user_id/channels/:channel_id
+func (h *ChannelsHandler) DeleteChannel(c *gin.Context) {
+	user, ok := h.authorize(c)
+	if !ok {
+		return
+	}
+	channelID := params.Get(c, params.Channel)
+
+	deleted, err := h.postgres.DeleteNotificationChannel(c.Request.Context(), user.ID, channelID)
+	if err != nil {
+		middleware.AbortWithError(c, apierror.Internal("failed to delete channel", err))
+		return
+	}
+	if !deleted {
+		middleware.AbortWithError(c, apierror.NotFound("channel not found"))
+		return
+	}
+
+	recordAudit(c, h.audit, &models.AuditEvent{
+		Action:        services.AuditChannelDelete,
+		ObjectType:    "notification_channel",
+		ObjectID:      channelID.String(),
+		SubjectUserID: &user.ID,
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": "channel deleted",
+	})
+}
+
+// POST /v1/user/:user_id/channels/:channel_id/test
+// Sends a test message right away, including to a disabled channel, so a
+// fixed webhook can be checked before re-enabling it. The outcome is
+// recorded on the channel like any delivery.
+func (h *ChannelsHandler) TestChannel(c *gin.Context) {
+	user, ok := h.authorize(c)
+	if !ok {
+		return
+	}
+	ch, ok := h.loadChannel(c, user.ID)
+	if !ok {
+		return
+	}
+
+	sendErr := h.channels.SendTest(c.Request.Context(), user, ch)
+
+	recordAudit(c, h.audit, &models.AuditEvent{
+		Action:        services.AuditChannelTest,
+		ObjectType:    "notification_channel",
+		ObjectID:      ch.ID.String(),
+		SubjectUserID: &user.ID,
+		Metadata:      map[string]interface{}{"delivered": sendErr == nil},
+	})
+
+	if sendErr != nil {
+		var chErr *services.ChannelError
+		if errors.As(sendErr, &chErr) && chErr.Status != 0 {
+			c.JSON(http.StatusOK, gin.H{
+				"delivered":       false,
+				"endpoint_status": chErr.Status,
+				"error":           sendErr.Error(),
+			})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"delivered": false,
+			"error":     sendErr.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"delivered": true})
+}
+
+// authorize loads the :user_id user and checks the caller is that user or an
+// admin. It writes the error response itself and returns false on failure.
+func (h *ChannelsHandler) authorize(c *gin.Context) (*models.User, bool) {
+	userID := params.UserID(c)
+	claims := middleware.Principal(c)
+	self := claims != nil && claims.Role == utils.RoleUser && claims.Subject == userID.String()
+	if !self && (claims == nil || claims.Role != utils.RoleAdmin) {
+		middleware.AbortWithError(c, apierror.Forbidden("only the user or an admin can manage notification channels"))
+		return nil, false
+	}
+
+	user, err := h.postgres.GetUserByID(c.Request.Context(), userID)
+	if err != nil {
+		middleware.AbortWithError(c, apierror.Internal("database error", err))
+		return nil, false
+	}
+	if user == nil {
+		middleware.AbortWithError(c, apierror.NotFound("user not found"))
+		return nil, false
+	}
+	return user, true
+}
+
+// loadChannel loads the user's :channel_id channel, writing the error response on failure
+func (h *ChannelsHandler) loadChannel(c *gin.Context, userID uuid.UUID) (*models.NotificationChannel, bool) {
+	channelID := params.Get(c, params.Channel)
+	ch, err := h.postgres.GetNotificationChannel(c.Request.Context(), userID, channelID)
+	if err != nil {
+		middleware.AbortWithError(c, apierror.Internal("failed to get channel", err))
+		return nil, false
+	}
+	if ch == nil {
+		middleware.AbortWithError(c, apierror.NotFound("channel not found"))
+		return nil, false
+	}
+	return ch, true
+}
+
+// validateWebhookURL accepts absolute https URLs
+func validateWebhookURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return errors.New("must be an absolute URL")
+	}
+	if u.Scheme != "https" {
+		return errors.New("must use https")
+	}
+	return nil
+}
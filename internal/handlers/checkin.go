@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/apierror"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/middleware"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/services"
+)
+
+// checkInStatsMaxSpan is the longest range check-in prompt stats cover
+const checkInStatsMaxSpan = 92 * 24 * time.Hour
+
+type CheckInHandler struct {
+	prompts *services.SilentPrompts
+	audit   *services.AuditLogger
+}
+
+func NewCheckInHandler(prompts *services.SilentPrompts, audit *services.AuditLogger) *CheckInHandler {
+	return &CheckInHandler{
+		prompts: prompts,
+		audit:   audit,
+	}
+}
+
+type CheckInRequest struct {
+	PromptID uuid.UUID `json:"prompt_id"` // from the push; left out, whichever prompt is waiting
+}
+
+// POST /v1/user/:user_id/check-in
+// The user answers a check-in prompt, from its push or in the app; the
+// prompt's remaining attempts are cancelled
+func (h *CheckInHandler) CheckIn(c *gin.Context) {
+	userID, ok := requireSelf(c, "only the user can check in")
+	if !ok {
+		return
+	}
+
+	var req CheckInRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			middleware.AbortWithError(c, apierror.Validation(err))
+			return
+		}
+	}
+
+	prompt, err := h.prompts.Answer(c.Request.Context(), userID, req.PromptID, models.PromptViaApp)
+	if errors.Is(err, services.ErrNoCheckInPrompt) {
+		middleware.AbortWithError(c, apierror.Conflict("no check-in prompt is waiting"))
+		return
+	}
+	if err != nil {
+		middleware.AbortWithError(c, apierror.Internal("failed to record check-in", err))
+		return
+	}
+
+	recordAudit(c, h.audit, &models.AuditEvent{
+		Action:        services.AuditCheckInAnswer,
+		ObjectType:    "silent_prompt",
+		ObjectID:      prompt.ID.String(),
+		SubjectUserID: &userID,
+		Metadata:      map[string]interface{}{"attempts_sent": prompt.AttemptsSent},
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": "answered",
+		"prompt": prompt,
+	})
+}
+
+// GET /admin/check-ins?from=&to=
+// How check-in prompts started in a range, by default the last 30 days,
+// ended, and which attempt and channel got the answers
+func (h *CheckInHandler) GetStats(c *gin.Context) {
+	from, to, ok := timeRangeParams(c, 30*24*time.Hour)
+	if !ok {
+		return
+	}
+	if to.Sub(from) > checkInStatsMaxSpan {
+		middleware.AbortWithError(c, apierror.Invalid("from", "range must not exceed 92 days"))
+		return
+	}
+
+	stats, err := h.prompts.Stats(c.Request.Context(), from, to)
+	if err != nil {
+		middleware.AbortWithError(c, apierror.Internal("failed to get check-in stats", err))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"from":  from,
+		"to":    to,
+		"stats": stats,
+	})
+}
@@ -0,0 +1,245 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/apierror"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/database"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/middleware"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/services"
+)
+
+type ConsentsHandler struct {
+	postgres *database.PostgresDB
+	consents *services.ConsentService
+	audit    *services.AuditLogger
+}
+
+func NewConsentsHandler(postgres *database.PostgresDB, consents *services.ConsentService, audit *services.AuditLogger) *ConsentsHandler {
+	return &ConsentsHandler{
+		postgres: postgres,
+		consents: consents,
+		audit:    audit,
+	}
+}
+
+type ConsentChange struct {
+	Scope         string `json:"scope" binding:"required"`
+	PolicyVersion int    `json:"policy_version"`
+	Granted       *bool  `json:"granted" binding:"required"`
+}
+
+type UpdateConsentsRequest struct {
+	Consents []ConsentChange `json:"consents" binding:"required,min=1,dive"`
+}
+
+// consentStatus is a scope's consent as GET /consents shows it
+type consentStatus struct {
+	Scope         string          `json:"scope"`
+	PolicyVersion int             `json:"policy_version"` // current version; 0 when the scope needs no consent
+	Required      bool            `json:"required"`       // whether the features of the scope are off until the user consents
+	Consent       *models.Consent `json:"consent"`
+}
+
+// GET /v1/consent-policies
+// The current privacy policy version of every scope that needs consent
+func (h *ConsentsHandler) GetPolicies(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"scopes":   models.ConsentScopes,
+		"policies": h.consents.Policies(),
+	})
+}
+
+// POST /v1/user/:user_id/consents
+// Grants or revokes the user's consent to each scope given. Grants must be
+// to the current policy version of their scope.
+func (h *ConsentsHandler) UpdateConsents(c *gin.Context) {
+	userID, ok := requireSelf(c, "only the user can give or withdraw their consent")
+	if !ok {
+		return
+	}
+
+	var req UpdateConsentsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		middleware.AbortWithError(c, apierror.Validation(err))
+		return
+	}
+
+	policies := h.consents.Policies()
+	var fields []apierror.FieldError
+	for i, change := range req.Consents {
+		switch {
+		case !slices.Contains(models.ConsentScopes, change.Scope):
+			fields = append(fields, apierror.FieldError{
+				Field:  fmt.Sprintf("consents[%d].scope", i),
+				Reason: fmt.Sprintf("must be one of %v", models.ConsentScopes),
+			})
+		case *change.Granted && policies[change.Scope] == 0:
+			fields = append(fields, apierror.FieldError{
+				Field:  fmt.Sprintf("consents[%d].scope", i),
+				Reason: "needs no consent",
+			})
+		case *change.Granted && change.PolicyVersion != policies[change.Scope]:
+			fields = append(fields, apierror.FieldError{
+				Field:  fmt.Sprintf("consents[%d].policy_version", i),
+				Reason: fmt.Sprintf("must be the current policy version, %d", policies[change.Scope]),
+			})
+		}
+	}
+	if len(fields) > 0 {
+		middleware.AbortWithError(c, apierror.Unprocessable(fields))
+		return
+	}
+
+	ctx := c.Request.Context()
+	changed := make([]models.Consent, 0, len(req.Consents))
+	for _, change := range req.Consents {
+		if !*change.Granted {
+			revoked, err := h.consents.Revoke(ctx, userID, change.Scope)
+			if err != nil {
+				middleware.AbortWithError(c, apierror.Internal("failed to revoke consent", err))
+				return
+			}
+			if revoked == nil {
+				continue
+			}
+			changed = append(changed, *revoked)
+			h.recordChange(c, services.AuditConsentRevoke, revoked)
+			continue
+		}
+
+		granted, err := h.consents.Grant(ctx, &models.Consent{
+			UserID:        userID,
+			Scope:         change.Scope,
+			PolicyVersion: change.PolicyVersion,
+			ClientIP:      c.ClientIP(),
+			UserAgent:     c.Request.UserAgent(),
+		})
+		switch {
+		case errors.Is(err, services.ErrConsentPolicyOutdated), errors.Is(err, services.ErrConsentNotRequired):
+			// The policy changed since the request was checked
+			middleware.AbortWithError(c, apierror.Conflict("privacy policy changed, reload it and try again"))
+			return
+		case errors.Is(err, database.ErrUserNotFound):
+			middleware.AbortWithError(c, apierror.NotFound("user not found"))
+			return
+		case err != nil:
+			middleware.AbortWithError(c, apierror.Internal("failed to record consent", err))
+			return
+		}
+		changed = append(changed, *granted)
+		h.recordChange(c, services.AuditConsentGrant, granted)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":   "success",
+		"consents": changed,
+	})
+}
+
+// GET /v1/user/:user_id/consents
+// The user's current consent to every scope, against its current policy version
+func (h *ConsentsHandler) GetConsents(c *gin.Context) {
+	user, ok := loadAuthorizedUser(c, h.postgres)
+	if !ok {
+		return
+	}
+
+	open, err := h.postgres.GetOpenConsents(c.Request.Context(), user.ID)
+	if err != nil {
+		middleware.AbortWithError(c, apierror.Internal("failed to get consents", err))
+		return
+	}
+
+	policies := h.consents.Policies()
+	statuses := make([]consentStatus, 0, len(models.ConsentScopes))
+	for _, scope := range models.ConsentScopes {
+		status := consentStatus{Scope: scope, PolicyVersion: policies[scope]}
+		for i := range open {
+			if open[i].Scope == scope {
+				status.Consent = &open[i]
+			}
+		}
+		status.Required = status.PolicyVersion > 0 &&
+			(status.Consent == nil || status.Consent.PolicyVersion < status.PolicyVersion)
+		statuses = append(statuses, status)
+	}
+
+	recordAudit(c, h.audit, &models.AuditEvent{
+		Action:        services.AuditConsentView,
+		ObjectType:    "consent",
+		SubjectUserID: &user.ID,
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"user_id":  user.ID,
+		"consents": statuses,
+	})
+}
+
+// GET /v1/user/:user_id/consents/history?limit=50&offset=0
+// Every consent the user gave, including revoked and superseded ones, newest first
+func (h *ConsentsHandler) GetHistory(c *gin.Context) {
+	user, ok := loadAuthorizedUser(c, h.postgres)
+	if !ok {
+		return
+	}
+
+	limit, offset := paginationParams(c, 50, 500)
+
+	consents, err := h.postgres.GetConsentHistory(c.Request.Context(), user.ID, limit, offset)
+	if err != nil {
+		middleware.AbortWithError(c, apierror.Internal("failed to get consent history", err))
+		return
+	}
+
+	recordAudit(c, h.audit, &models.AuditEvent{
+		Action:        services.AuditConsentView,
+		ObjectType:    "consent_history",
+		SubjectUserID: &user.ID,
+		Metadata:      map[string]interface{}{"limit": limit, "offset": offset},
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"user_id":  user.ID,
+		"consents": consents,
+		"limit":    limit,
+		"offset":   offset,
+	})
+}
+
+func (h *ConsentsHandler) recordChange(c *gin.Context, action string, consent *models.Consent) {
+	recordAudit(c, h.audit, &models.AuditEvent{
+		Action:        action,
+		ObjectType:    "consent",
+		ObjectID:      consent.ID.String(),
+		SubjectUserID: &consent.UserID,
+		Metadata: map[string]interface{}{
+			"scope":          consent.Scope,
+			"policy_version": consent.PolicyVersion,
+		},
+	})
+}
+
+// abortConsentRequired writes the response for an error from
+// ConsentService.Require, reporting whether there was one
+func abortConsentRequired(c *gin.Context, err error) bool {
+	if err == nil {
+		return false
+	}
+	var required *services.ConsentRequiredError
+	if errors.As(err, &required) {
+		middleware.AbortWithError(c, apierror.ConsentRequired(fmt.Sprintf(
+			"%s consent to privacy policy version %d is required", required.Scope, required.PolicyVersion,
+		)))
+		return true
+	}
+	middleware.AbortWithError(c, apierror.Internal("failed to check consent", err))
+	return true
+}
@@ -0,0 +1,217 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/apierror"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/config"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/middleware"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/params"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/services"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/utils"
+)
+
+// trackingNotifier records the tracking commands sent; any other
+// notification is a test failure, by the nil Notifier it embeds
+type trackingNotifier struct {
+	services.Notifier
+	mu       sync.Mutex
+	commands []string
+}
+
+func (n *trackingNotifier) SendTrackingCommand(ctx context.Context, token, action string) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.commands = append(n.commands, action)
+	return nil
+}
+
+// SendConsentRequired is sent by sweeps, to every user in the database
+func (n *trackingNotifier) SendConsentRequired(ctx context.Context, token string, scopes []string) error {
+	return nil
+}
+
+type consentFixture struct {
+	cfg      *config.Store
+	consents *services.ConsentService
+	notifier *trackingNotifier
+	router   *gin.Engine
+	user     *models.User
+	claims   utils.TokenClaims
+}
+
+func newConsentFixture(t *testing.T) *consentFixture {
+	t.Helper()
+	postgres := testPostgres(t)
+	f := &consentFixture{
+		cfg: config.NewStore(&config.Config{
+			HeartbeatIntervalSeconds: 300, HeartbeatIntervalMinSeconds: 60, HeartbeatIntervalMaxSeconds: 3600, SilentPromptSeconds: 30,
+			ConsentPolicyVersions: map[string]int{models.ConsentTracking: 1, models.ConsentAudio: 1, models.ConsentPrecisionEscalation: 1},
+		}),
+		notifier: &trackingNotifier{},
+		user:     createTestUser(t, postgres),
+	}
+	f.claims = utils.TokenClaims{Subject: f.user.ID.String(), Role: utils.RoleUser}
+	if err := postgres.UpsertPushToken(context.Background(), f.user.ID, "token-"+f.user.ID.String()); err != nil {
+		t.Fatalf("UpsertPushToken: %v", err)
+	}
+	f.consents = services.NewConsentService(f.cfg, postgres, f.notifier, services.NewAlertOutbox(f.notifier, nil, 1, nil), nil)
+
+	users := NewUsersHandler(f.cfg.Current(), postgres, nil, nil, nil, f.consents, nil)
+	links := NewLinksHandler(f.cfg.Current(), postgres, nil, f.consents, f.notifier, nil)
+	consents := NewConsentsHandler(postgres, f.consents, nil)
+	f.router = testRouter()
+	user := f.router.Group("/v1/user/:user_id", params.UUID(params.User), middleware.RequireAuth(testSecret))
+	user.PATCH("/settings", users.UpdateSettings)
+	user.POST("/tracking", links.SetTracking)
+	user.POST("/consents", consents.UpdateConsents)
+	user.GET("/consents", consents.GetConsents)
+	return f
+}
+
+func (f *consentFixture) send(t *testing.T, method, path, body string) (int, string) {
+	t.Helper()
+	w := send(t, f.router, method, "/v1/user/"+f.user.ID.String()+path, body, &f.claims)
+	var resp struct {
+		Error struct {
+			Code string `json:"code"`
+		} `json:"error"`
+	}
+	_ = json.Unmarshal(w.Body.Bytes(), &resp)
+	return w.Code, resp.Error.Code
+}
+
+// Each gated feature is refused with consent_required until the user
+// consents, and allowed after
+func TestConsentGatedFeatures(t *testing.T) {
+	f := newConsentFixture(t)
+	gated := []struct {
+		name, method, path, body string
+		allowed                  int
+	}{
+		{"tracking start", http.MethodPost, "/tracking", `{"action":"start"}`, http.StatusAccepted},
+		{"audio sharing", http.MethodPatch, "/settings", `{"share_audio":true}`, http.StatusOK},
+		{"coarse location", http.MethodPatch, "/settings", `{"coarse_location":true}`, http.StatusOK},
+	}
+	for _, g := range gated {
+		if status, code := f.send(t, g.method, g.path, g.body); status != http.StatusForbidden || code != apierror.CodeConsentRequired {
+			t.Errorf("%s without consent = %d %s, want 403 %s", g.name, status, code, apierror.CodeConsentRequired)
+		}
+	}
+
+	// What withdrawing consent asks for is never refused
+	for _, open := range []struct{ name, method, path, body string }{
+		{"tracking stop", http.MethodPost, "/tracking", `{"action":"stop"}`},
+		{"audio sharing off", http.MethodPatch, "/settings", `{"share_audio":false}`},
+		{"another setting", http.MethodPatch, "/settings", `{"heartbeat_interval":600}`},
+	} {
+		if status, _ := f.send(t, open.method, open.path, open.body); status >= 300 {
+			t.Errorf("%s without consent = %d", open.name, status)
+		}
+	}
+
+	body := `{"consents":[{"scope":"tracking","policy_version":1,"granted":true},{"scope":"audio","policy_version":1,"granted":true},` +
+		`{"scope":"precision_escalation","policy_version":1,"granted":true}]}`
+	if status, code := f.send(t, http.MethodPost, "/consents", body); status != http.StatusOK {
+		t.Fatalf("consenting = %d %s", status, code)
+	}
+	for _, g := range gated {
+		if status, code := f.send(t, g.method, g.path, g.body); status != g.allowed {
+			t.Errorf("%s with consent = %d %s, want %d", g.name, status, code, g.allowed)
+		}
+	}
+	if len(f.notifier.commands) != 2 || f.notifier.commands[1] != services.TrackingStart {
+		t.Errorf("tracking commands = %v, want stop then start", f.notifier.commands)
+	}
+}
+
+func TestUpdateConsentsValidation(t *testing.T) {
+	f := newConsentFixture(t)
+	tests := []struct {
+		name, body string
+		field      string
+	}{
+		{"unknown scope", `{"consents":[{"scope":"telepathy","policy_version":1,"granted":true}]}`, "consents[0].scope"},
+		{"scope needing no consent", `{"consents":[{"scope":"community_broadcast","policy_version":1,"granted":true}]}`, "consents[0].scope"},
+		{"old policy version", `{"consents":[{"scope":"tracking","policy_version":0,"granted":true}]}`, "consents[0].policy_version"},
+	}
+	for _, tt := range tests {
+		w := send(t, f.router, http.MethodPost, "/v1/user/"+f.user.ID.String()+"/consents", tt.body, &f.claims)
+		if w.Code != http.StatusUnprocessableEntity {
+			t.Errorf("%s = %d, want 422", tt.name, w.Code)
+			continue
+		}
+		if fields := errorFields(t, w); fields[tt.field] == "" {
+			t.Errorf("%s: fields = %v, want %s", tt.name, fields, tt.field)
+		}
+	}
+
+	// Only the user gives their consent
+	other := utils.TokenClaims{Subject: f.user.ID.String(), Role: utils.RoleAdmin}
+	w := send(t, f.router, http.MethodPost, "/v1/user/"+f.user.ID.String()+"/consents",
+		`{"consents":[{"scope":"tracking","policy_version":1,"granted":true}]}`, &other)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("admin consenting for the user = %d, want 403", w.Code)
+	}
+}
+
+// After the policy version of a scope is raised, its feature is refused
+// again until the user consents to the new version
+func TestConsentAfterPolicyBump(t *testing.T) {
+	f := newConsentFixture(t)
+	if status, code := f.send(t, http.MethodPost, "/consents",
+		`{"consents":[{"scope":"tracking","policy_version":1,"granted":true},{"scope":"audio","policy_version":1,"granted":true}]}`); status != http.StatusOK {
+		t.Fatalf("consenting = %d %s", status, code)
+	}
+
+	cfg := *f.cfg.Current()
+	cfg.ConsentPolicyVersions = map[string]int{models.ConsentTracking: 2, models.ConsentAudio: 1, models.ConsentPrecisionEscalation: 1}
+	f.cfg.Swap(&cfg)
+	f.consents.Sweep()
+
+	if status, code := f.send(t, http.MethodPost, "/tracking", `{"action":"start"}`); status != http.StatusForbidden || code != apierror.CodeConsentRequired {
+		t.Errorf("tracking start after the bump = %d %s, want 403", status, code)
+	}
+	if status, _ := f.send(t, http.MethodPatch, "/settings", `{"share_audio":true}`); status != http.StatusOK {
+		t.Errorf("audio sharing, whose policy didn't change = %d, want 200", status)
+	}
+
+	w := send(t, f.router, http.MethodGet, "/v1/user/"+f.user.ID.String()+"/consents", "", &f.claims)
+	var resp struct {
+		Consents []struct {
+			Scope         string          `json:"scope"`
+			PolicyVersion int             `json:"policy_version"`
+			Required      bool            `json:"required"`
+			Consent       *models.Consent `json:"consent"`
+		} `json:"consents"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("GET /consents = %d %s: %v", w.Code, w.Body, err)
+	}
+	for _, c := range resp.Consents {
+		switch c.Scope {
+		case models.ConsentTracking:
+			if !c.Required || c.PolicyVersion != 2 || c.Consent != nil {
+				t.Errorf("tracking = %+v, want version 2 required, with no consent open", c)
+			}
+		case models.ConsentAudio:
+			if c.Required || c.Consent == nil || c.Consent.PolicyVersion != 1 {
+				t.Errorf("audio = %+v, want the version 1 consent", c)
+			}
+		}
+	}
+
+	if status, code := f.send(t, http.MethodPost, "/consents", `{"consents":[{"scope":"tracking","policy_version":2,"granted":true}]}`); status != http.StatusOK {
+		t.Fatalf("consenting again = %d %s", status, code)
+	}
+	if status, _ := f.send(t, http.MethodPost, "/tracking", `{"action":"start"}`); status != http.StatusAccepted {
+		t.Errorf("tracking start after consenting again = %d, want 202", status)
+	}
+}
@@ -0,0 +1,263 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/apierror"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/config"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/middleware"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/services"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/utils"
+)
+
+type ContactAccessHandler struct {
+	cfg    *config.Config
+	access *services.ContactAccessService
+	audit  *services.AuditLogger
+}
+
+func NewContactAccessHandler(
+	cfg *config.Config,
+	access *services.ContactAccessService,
+	audit *services.AuditLogger,
+) *ContactAccessHandler {
+	return &ContactAccessHandler{
+		cfg:    cfg,
+		access: access,
+		audit:  audit,
+	}
+}
+
+// confirmPage is what the invitation link opens. Link previewers in SMS
+// apps fetch the link but don't submit the form, so only the contact's tap
+// uses the invitation up.
+const confirmPage = `<!DOCTYPE html>
+<html><head><meta charset="utf-8"><meta name="viewport" content="width=device-width, initial-scale=1">
+<title>SafeTrace invitation</title></head>
+<body><p>You've been added as a trusted contact on SafeTrace.</p>
+<form method="post"><button type="submit">Accept invitation</button></form></body></html>`
+
+// confirmedPage answers the form on confirmPage
+const confirmedPage = `<!DOCTYPE html>
+<html><head><meta charset="utf-8"><meta name="viewport" content="width=device-width, initial-scale=1">
+<title>SafeTrace invitation</title></head>
+<body><p>Invitation accepted. We've texted you a link to follow them.</p></body></html>`
+
+// GET /contact/confirm/:code
+// Link in the invitation SMS: a page asking the contact to accept, which
+// changes nothing
+func (h *ContactAccessHandler) ConfirmPage(c *gin.Context) {
+	c.Header("Cache-Control", "no-store")
+	c.Data(http.StatusOK, "text/html; charset=utf-8", []byte(confirmPage))
+}
+
+// POST /contact/confirm/:code
+// Accepts the invitation; the code itself authenticates the contact. The
+// page's form gets a page back, other callers the token.
+func (h *ContactAccessHandler) Confirm(c *gin.Context) {
+	token, err := h.access.Confirm(c.Request.Context(), c.Param("code"))
+	if errors.Is(err, services.ErrInviteInvalid) || errors.Is(err, services.ErrContactNotFound) {
+		middleware.AbortWithError(c, apierror.NotFound("this invitation is not valid"))
+		return
+	}
+	if err != nil {
+		middleware.AbortWithError(c, apierror.Internal("failed to confirm invitation", err))
+		return
+	}
+
+	recordAudit(c, h.audit, &models.AuditEvent{
+		Action:        services.AuditContactTokenIssue,
+		ObjectType:    "contact_token",
+		SubjectUserID: &token.UserID,
+		Metadata:      map[string]interface{}{"reason": "confirm"},
+	})
+
+	if c.ContentType() == "application/x-www-form-urlencoded" {
+		c.Header("Cache-Control", "no-store")
+		c.Data(http.StatusOK, "text/html; charset=utf-8", []byte(confirmedPage))
+		return
+	}
+	c.JSON(http.StatusOK, token)
+}
+
+// POST /v1/user/:user_id/contact-token/renew
+// Swaps a valid contact token for a fresh one; the old token stops working
+func (h *ContactAccessHandler) Renew(c *gin.Context) {
+	claims := middleware.Principal(c)
+	if claims == nil || claims.Role != utils.RoleContact {
+		middleware.AbortWithError(c, apierror.Forbidden("only contact tokens can be renewed"))
+		return
+	}
+
+	token, err := h.access.Renew(c.Request.Context(), claims)
+	if errors.Is(err, services.ErrContactNotFound) {
+		middleware.AbortWithError(c, apierror.Forbidden("no longer a trusted contact of this user"))
+		return
+	}
+	if err != nil {
+		middleware.AbortWithError(c, apierror.Internal("failed to renew token", err))
+		return
+	}
+
+	recordAudit(c, h.audit, &models.AuditEvent{
+		Action:        services.AuditContactTokenIssue,
+		ObjectType:    "contact_token",
+		SubjectUserID: &token.UserID,
+		Metadata:      map[string]interface{}{"reason": "renew"},
+	})
+
+	c.JSON(http.StatusOK, token)
+}
+
+// contactPreferencesRequest replaces a contact's own preferences
+type contactPreferencesRequest struct {
+	MaxPerWindow      *int                      `json:"max_per_window"` // null for the default pacing
+	SMSEnabled        *bool                     `json:"sms_enabled"`
+	WhatsAppEnabled   *bool                     `json:"whatsapp_enabled"`
+	ReferenceLocation *referenceLocationRequest `json:"reference_location"` // null for none
+}
+
+// referenceLocationRequest is the contact's home or workplace, as
+// coordinates or as an address to look up
+type referenceLocationRequest struct {
+	Label   string   `json:"label"`
+	Lat     *float64 `json:"lat"`
+	Lng     *float64 `json:"lng"`
+	Address string   `json:"address"`
+}
+
+func (r *contactPreferencesRequest) validate() []apierror.FieldError {
+	var fields []apierror.FieldError
+	if r.MaxPerWindow != nil && (*r.MaxPerWindow < services.ContactPacingMin || *r.MaxPerWindow > services.ContactPacingMax) {
+		fields = append(fields, apierror.FieldError{
+			Field:  "max_per_window",
+			Reason: fmt.Sprintf("must be between %d and %d", services.ContactPacingMin, services.ContactPacingMax),
+		})
+	}
+	if r.SMSEnabled == nil {
+		fields = append(fields, apierror.FieldError{Field: "sms_enabled", Reason: "is required"})
+	}
+	if r.WhatsAppEnabled == nil {
+		fields = append(fields, apierror.FieldError{Field: "whatsapp_enabled", Reason: "is required"})
+	}
+	if r.SMSEnabled != nil && r.WhatsAppEnabled != nil && !*r.SMSEnabled && !*r.WhatsAppEnabled {
+		fields = append(fields, apierror.FieldError{Field: "sms_enabled", Reason: "at least one channel must stay on"})
+	}
+	if ref := r.ReferenceLocation; ref != nil {
+		if ref.Label != models.ReferenceHome && ref.Label != models.ReferenceWork {
+			fields = append(fields, apierror.FieldError{Field: "reference_location.label", Reason: "must be home or work"})
+		}
+		address := strings.TrimSpace(ref.Address)
+		hasCoords := ref.Lat != nil || ref.Lng != nil
+		switch {
+		case address != "" && hasCoords:
+			fields = append(fields, apierror.FieldError{Field: "reference_location.address", Reason: "give either an address or lat and lng, not both"})
+		case address != "":
+			if len(address) > maxReferenceAddressLen {
+				fields = append(fields, apierror.FieldError{
+					Field:  "reference_location.address",
+					Reason: fmt.Sprintf("must be at most %d characters", maxReferenceAddressLen),
+				})
+			}
+		case ref.Lat == nil || ref.Lng == nil:
+			fields = append(fields, apierror.FieldError{Field: "reference_location", Reason: "needs an address, or lat and lng"})
+		default:
+			if *ref.Lat < -90 || *ref.Lat > 90 {
+				fields = append(fields, apierror.FieldError{Field: "reference_location.lat", Reason: "must be between -90 and 90"})
+			}
+			if *ref.Lng < -180 || *ref.Lng > 180 {
+				fields = append(fields, apierror.FieldError{Field: "reference_location.lng", Reason: "must be between -180 and 180"})
+			}
+		}
+	}
+	return fields
+}
+
+// maxReferenceAddressLen bounds an address sent to the geocoder
+const maxReferenceAddressLen = 256
+
+// GET /contact/preferences
+// The contact's own pacing and channels, across every user they protect
+func (h *ContactAccessHandler) GetPreferences(c *gin.Context) {
+	prefs, err := h.access.Preferences(c.Request.Context(), middleware.Principal(c))
+	if errors.Is(err, services.ErrContactNotFound) || errors.Is(err, utils.ErrInvalidToken) {
+		middleware.AbortWithError(c, apierror.Forbidden("no longer a trusted contact of this user"))
+		return
+	}
+	if err != nil {
+		middleware.AbortWithError(c, apierror.Internal("failed to load preferences", err))
+		return
+	}
+	c.JSON(http.StatusOK, prefs)
+}
+
+// PUT /contact/preferences
+// Replaces the contact's own pacing, channels and reference location.
+// Critical alerts still go by SMS and are never held back.
+func (h *ContactAccessHandler) UpdatePreferences(c *gin.Context) {
+	var req contactPreferencesRequest
+	if !bindStrict(c, &req) {
+		return
+	}
+
+	var ref *services.ReferenceUpdate
+	if r := req.ReferenceLocation; r != nil {
+		ref = &services.ReferenceUpdate{Label: r.Label, Lat: r.Lat, Lng: r.Lng, Address: strings.TrimSpace(r.Address)}
+	}
+
+	claims := middleware.Principal(c)
+	prefs, err := h.access.UpdatePreferences(c.Request.Context(), claims, models.ContactRecipient{
+		MaxPerWindow:    req.MaxPerWindow,
+		SMSEnabled:      *req.SMSEnabled,
+		WhatsAppEnabled: *req.WhatsAppEnabled,
+	}, ref)
+	if errors.Is(err, services.ErrContactNotFound) || errors.Is(err, utils.ErrInvalidToken) {
+		middleware.AbortWithError(c, apierror.Forbidden("no longer a trusted contact of this user"))
+		return
+	}
+	if errors.Is(err, services.ErrGeocodingDisabled) {
+		middleware.AbortWithError(c, apierror.Unprocessable([]apierror.FieldError{
+			{Field: "reference_location.address", Reason: "addresses can't be looked up; give lat and lng instead"},
+		}))
+		return
+	}
+	if errors.Is(err, services.ErrAddressNotFound) {
+		middleware.AbortWithError(c, apierror.Unprocessable([]apierror.FieldError{
+			{Field: "reference_location.address", Reason: "could not be found"},
+		}))
+		return
+	}
+	if errors.Is(err, services.ErrGeocodingFailed) {
+		log.Printf("WARN: Failed to geocode a contact's reference address: %v", err)
+		middleware.AbortWithError(c, apierror.Unavailable("the address could not be looked up; try again or give lat and lng"))
+		return
+	}
+	if err != nil {
+		middleware.AbortWithError(c, apierror.Internal("failed to update preferences", err))
+		return
+	}
+
+	userID, _ := uuid.Parse(claims.Subject)
+	recordAudit(c, h.audit, &models.AuditEvent{
+		Action:        services.AuditContactPrefsUpdate,
+		ObjectType:    "contact",
+		ObjectID:      claims.ContactID,
+		SubjectUserID: &userID,
+		Metadata: map[string]interface{}{
+			"max_per_window":   req.MaxPerWindow,
+			"sms_enabled":      prefs.SMSEnabled,
+			"whatsapp_enabled": prefs.WhatsAppEnabled,
+			// Only whether one is set: where a contact lives stays out of the log
+			"reference_location": prefs.ReferenceLocation != nil,
+		},
+	})
+
+	c.JSON(http.StatusOK, prefs)
+}
@@ -0,0 +1,33 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Opening the invitation link, as an SMS link previewer does, must not use
+// the invitation up: the GET page has no service to call, only a form
+// posting back to the same URL.
+func TestConfirmPageDoesNotConfirm(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	h := NewContactAccessHandler(nil, nil, nil)
+	router := gin.New()
+	router.GET("/contact/confirm/:code", h.ConfirmPage)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/contact/confirm/abc123", nil))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d, want 200", w.Code)
+	}
+	if got := w.Header().Get("Cache-Control"); got != "no-store" {
+		t.Errorf("Cache-Control = %q, want no-store", got)
+	}
+	if body := w.Body.String(); !strings.Contains(body, `<form method="post">`) {
+		t.Errorf("page has no form posting back:\n%s", body)
+	}
+}
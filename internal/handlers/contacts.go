@@ -1,146 +1,266 @@
 package handlers
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
+	"strconv"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/apierror"
 	"github.com/adedejiosvaldo/safetrace/backend/internal/config"
 	"github.com/adedejiosvaldo/safetrace/backend/internal/database"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/params"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/middleware"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/services"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/utils"
 )
 
 type ContactsHandler struct {
 	cfg      *config.Config
 	postgres *database.PostgresDB
+	access   *services.ContactAccessService
+	outbox   *services.AlertOutbox
+	warnings *services.ProtectionWarnings
+	audit    *services.AuditLogger
 }
 
 func NewContactsHandler(
 	cfg *config.Config,
 	postgres *database.PostgresDB,
+	access *services.ContactAccessService,
+	outbox *services.AlertOutbox,
+	warnings *services.ProtectionWarnings,
+	audit *services.AuditLogger,
 ) *ContactsHandler {
 	return &ContactsHandler{
 		cfg:      cfg,
 		postgres: postgres,
+		access:   access,
+		outbox:   outbox,
+		warnings: warnings,
+		audit:    audit,
 	}
 }
 
 type AddContactRequest struct {
-	Name  string `json:"name" binding:"required"`
-	Phone string `json:"phone" binding:"required"`
+	Name        string                          `json:"name" binding:"required"`
+	Phone       string                          `json:"phone" binding:"required"`
+	Preferences *models.NotificationPreferences `json:"preferences"`
+}
+
+type BulkAddContactsRequest struct {
+	Contacts []services.ContactCandidate `json:"contacts" binding:"required,min=1,max=20"`
 }
 
 type UpdateContactRequest struct {
-	Name  string `json:"name"`
-	Phone string `json:"phone"`
+	Name        string                          `json:"name"`
+	Phone       string                          `json:"phone"`
+	Preferences *models.NotificationPreferences `json:"preferences"`
 }
 
-// GET /v1/user/:id/contacts
+// GET /v1/user/:user_id/contacts?include_deleted=true
+// Deleted contacts are only listed, after the others, when asked for
 func (h *ContactsHandler) GetContacts(c *gin.Context) {
-	userIDStr := c.Param("id")
 
-	userID, err := uuid.Parse(userIDStr)
-	if err != nil {
-		log.Printf("ERROR: Invalid user_id '%s': %v", userIDStr, err)
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "invalid user_id",
-			"details": err.Error(),
-		})
-		return
+	includeDeleted := false
+	if v := c.Query("include_deleted"); v != "" {
+		parsed, err := strconv.ParseBool(v)
+		if err != nil {
+			middleware.AbortWithError(c, apierror.Invalid("include_deleted", "must be true or false"))
+			return
+		}
+		includeDeleted = parsed
 	}
 
-	// Verify user exists
-	user, err := h.postgres.GetUserByID(c.Request.Context(), userID)
-	if err != nil {
-		log.Printf("ERROR: Failed to get user %s: %v", userID, err)
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "database error",
-			"details": err.Error(),
-		})
+	user, ok := loadAuthorizedUser(c, h.postgres)
+	if !ok {
 		return
 	}
-	if user == nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
-		return
+	userID := user.ID
+
+	contacts := user.TrustedContacts
+	if includeDeleted {
+		var err error
+		contacts, err = h.postgres.ListContacts(c.Request.Context(), userID, true)
+		if err != nil {
+			middleware.AbortWithError(c, apierror.Internal("database error", err))
+			return
+		}
 	}
 
-	// Return contacts from user's trusted_contacts JSON field
 	c.JSON(http.StatusOK, gin.H{
 		"user_id": userID,
-		"contacts": user.TrustedContacts,
+		"contacts": contacts,
 	})
 }
 
-// POST /v1/user/:id/contacts
+// POST /v1/user/:user_id/contacts
 func (h *ContactsHandler) AddContact(c *gin.Context) {
-	userIDStr := c.Param("id")
-
-	userID, err := uuid.Parse(userIDStr)
-	if err != nil {
-		log.Printf("ERROR: Invalid user_id '%s': %v", userIDStr, err)
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "invalid user_id",
-			"details": err.Error(),
-		})
+	userID, ok := requireSelf(c, "only the user can add their own contacts")
+	if !ok {
 		return
 	}
 
 	var req AddContactRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		log.Printf("ERROR: Failed to bind JSON: %v", err)
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "invalid request",
-			"details": err.Error(),
-		})
+		middleware.AbortWithError(c, apierror.Validation(err))
+		return
+	}
+
+	if err := services.ValidatePreferences(req.Preferences); err != nil {
+		middleware.AbortWithError(c, apierror.Invalid("preferences", err.Error()))
 		return
 	}
 
 	log.Printf("INFO: Adding contact for user %s: name=%s, phone=%s", userID, req.Name, req.Phone)
 
-	contact := map[string]string{
-		"id":    uuid.New().String(),
-		"name":  req.Name,
-		"phone": req.Phone,
+	phone := utils.NormalizePhone(req.Phone)
+	if !utils.IsValidE164(phone) {
+		middleware.AbortWithError(c, apierror.Invalid("phone", "must be a valid phone number"))
+		return
 	}
 
-	if err := h.postgres.AddContact(c.Request.Context(), userID, contact); err != nil {
-		log.Printf("ERROR: Failed to add contact: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "failed to add contact",
-			"details": err.Error(),
-		})
+	contact := models.Contact{
+		ID:          uuid.New().String(),
+		Name:        req.Name,
+		Phone:       phone,
+		Preferences: req.Preferences,
+		Status:      models.ContactStatusInvited,
+	}
+
+	err := h.postgres.AddContact(c.Request.Context(), userID, contact, h.cfg.MaxTrustedContacts)
+	if errors.Is(err, database.ErrContactLimit) {
+		middleware.AbortWithError(c, apierror.Conflict(fmt.Sprintf("the limit of %d trusted contacts is reached", h.cfg.MaxTrustedContacts)))
+		return
+	}
+	if errors.Is(err, database.ErrUserNotFound) {
+		middleware.AbortWithError(c, apierror.NotFound("user not found"))
+		return
+	}
+	if err != nil {
+		middleware.AbortWithError(c, apierror.Internal("failed to add contact", err))
 		return
 	}
 
+	recordAudit(c, h.audit, &models.AuditEvent{
+		Action:        services.AuditContactAdd,
+		ObjectType:    "contact",
+		ObjectID:      contact.ID,
+		SubjectUserID: &userID,
+	})
+
+	// The contact is saved either way; the invite can be resent by re-adding
+	inviteSent := h.invite(c, userID, contact)
+
 	c.JSON(http.StatusCreated, gin.H{
 		"status": "success",
 		"contact": contact,
+		"invite_sent": inviteSent,
 		"message": "contact added successfully",
 	})
 }
 
-// PUT /v1/user/:id/contacts/:contactId
-func (h *ContactsHandler) UpdateContact(c *gin.Context) {
-	userIDStr := c.Param("id")
-	contactID := c.Param("contactId")
+// POST /v1/user/:user_id/contacts/bulk
+// Adds up to 20 contacts picked from the user's address book in one step.
+// Numbers are normalized and de-duplicated against each other and the
+// existing contacts, and the accepted ones are saved together or not at all,
+// so a retried import never adds a contact twice. Invitations go out from
+// the outbox afterwards.
+func (h *ContactsHandler) BulkAddContacts(c *gin.Context) {
+	userID, ok := requireSelf(c, "only the user can import their own contacts")
+	if !ok {
+		return
+	}
+
+	var req BulkAddContactsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		middleware.AbortWithError(c, apierror.Validation(err))
+		return
+	}
 
-	userID, err := uuid.Parse(userIDStr)
+	user, err := h.postgres.GetUserByID(c.Request.Context(), userID)
+	if err != nil {
+		middleware.AbortWithError(c, apierror.Internal("database error", err))
+		return
+	}
+	if user == nil {
+		middleware.AbortWithError(c, apierror.NotFound("user not found"))
+		return
+	}
+
+	// Planned against the contacts as locked in the transaction, so
+	// concurrent imports see each other's additions
+	var results []services.ContactImportResult
+	err = h.postgres.ModifyContacts(c.Request.Context(), userID, func(existing models.TrustedContacts) (models.TrustedContacts, error) {
+		var contacts models.TrustedContacts
+		contacts, results = services.PlanContactImport(existing, req.Contacts, h.cfg.MaxTrustedContacts)
+		return contacts, nil
+	})
 	if err != nil {
-		log.Printf("ERROR: Invalid user_id '%s': %v", userIDStr, err)
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "invalid user_id",
-			"details": err.Error(),
+		middleware.AbortWithError(c, apierror.Internal("failed to import contacts", err))
+		return
+	}
+
+	counts := map[string]int{}
+	for _, result := range results {
+		counts[result.Outcome]++
+		if result.Outcome != services.ImportAdded {
+			continue
+		}
+
+		recordAudit(c, h.audit, &models.AuditEvent{
+			Action:        services.AuditContactAdd,
+			ObjectType:    "contact",
+			ObjectID:      result.ContactID,
+			SubjectUserID: &userID,
+			Metadata:      map[string]interface{}{"bulk": true},
 		})
+
+		contact := models.Contact{ID: result.ContactID, Name: result.Name, Phone: result.Phone}
+		h.outbox.EnqueueMessage(c.Request.Context(), "invitation to contact "+contact.ID, func(ctx context.Context) error {
+			return h.access.Invite(ctx, user, contact)
+		})
+	}
+
+	status := http.StatusOK
+	if counts[services.ImportAdded] > 0 {
+		status = http.StatusCreated
+	}
+	c.JSON(status, gin.H{
+		"status":  "success",
+		"added":   counts[services.ImportAdded],
+		"counts":  counts,
+		"results": results,
+		"limit":   h.cfg.MaxTrustedContacts,
+	})
+}
+
+// PUT /v1/user/:user_id/contacts/:contact_id
+// A new phone number has to confirm again: the contact goes back to invited,
+// the new number is invited and the old one told it was removed. Refused with
+// 409 while an alert sent to the contact is unresolved.
+func (h *ContactsHandler) UpdateContact(c *gin.Context) {
+	userID, ok := requireSelf(c, "only the user can change their own contacts")
+	if !ok {
 		return
 	}
+	contactID := params.Get(c, params.Contact).String()
 
 	var req UpdateContactRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		log.Printf("ERROR: Failed to bind JSON: %v", err)
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "invalid request",
-			"details": err.Error(),
-		})
+		middleware.AbortWithError(c, apierror.Validation(err))
+		return
+	}
+
+	if err := services.ValidatePreferences(req.Preferences); err != nil {
+		middleware.AbortWithError(c, apierror.Invalid("preferences", err.Error()))
 		return
 	}
 
@@ -153,52 +273,187 @@ func (h *ContactsHandler) UpdateContact(c *gin.Context) {
 		updates["name"] = req.Name
 	}
 	if req.Phone != "" {
-		updates["phone"] = req.Phone
+		phone := utils.NormalizePhone(req.Phone)
+		if !utils.IsValidE164(phone) {
+			middleware.AbortWithError(c, apierror.Invalid("phone", "must be a valid phone number"))
+			return
+		}
+		updates["phone"] = phone
 	}
 
-	if err := h.postgres.UpdateContact(c.Request.Context(), userID, contactID, updates); err != nil {
-		log.Printf("ERROR: Failed to update contact: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "failed to update contact",
-			"details": err.Error(),
-		})
+	previous, err := h.postgres.UpdateContact(c.Request.Context(), userID, contactID, updates, req.Preferences)
+	if errors.Is(err, database.ErrContactNotFound) || errors.Is(err, database.ErrUserNotFound) {
+		middleware.AbortWithError(c, apierror.NotFound(err.Error()))
+		return
+	}
+	if errors.Is(err, database.ErrContactOnActiveAlert) {
+		middleware.AbortWithError(c, apierror.Conflict("the contact's phone can't change while an alert sent to them is unresolved"))
+		return
+	}
+	if err != nil {
+		middleware.AbortWithError(c, apierror.Internal("failed to update contact", err))
 		return
 	}
+	if phone, ok := updates["phone"]; ok && phone == previous.Phone {
+		delete(updates, "phone")
+	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"status": "success",
+	// Access tokens are bound to the phone they were sent to
+	_, phoneChanged := updates["phone"]
+	if phoneChanged {
+		if err := h.access.RevokeContact(c.Request.Context(), userID, contactID); err != nil {
+			middleware.AbortWithError(c, apierror.Unavailable("contact updated, but their old access may persist; retry to confirm").WithCause(err))
+			return
+		}
+	}
+
+	recordAudit(c, h.audit, &models.AuditEvent{
+		Action:        services.AuditContactUpdate,
+		ObjectType:    "contact",
+		ObjectID:      contactID,
+		SubjectUserID: &userID,
+		Metadata:      map[string]interface{}{"fields": updatedFields(updates, req.Preferences)},
+	})
+
+	response := gin.H{
+		"status":  "success",
 		"message": "contact updated successfully",
+	}
+	if phoneChanged {
+		response["invite_sent"] = h.reconfirm(c, userID, *previous, updates["phone"])
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// reconfirm invites a contact's new number and tells the old one it was
+// removed, reporting whether the invitation went out
+func (h *ContactsHandler) reconfirm(c *gin.Context, userID uuid.UUID, previous models.Contact, phone string) bool {
+	contact := previous
+	contact.Phone = phone
+	contact.Status = models.ContactStatusInvited
+	inviteSent := h.invite(c, userID, contact)
+
+	user, err := h.postgres.GetUserByID(c.Request.Context(), userID)
+	if err != nil || user == nil {
+		log.Printf("WARN: Could not tell the old number of contact %s for user %s it was removed: %v", contact.ID, userID, err)
+		return inviteSent
+	}
+	h.outbox.EnqueueMessage(c.Request.Context(), "removal notice to the old number of contact "+contact.ID, func(ctx context.Context) error {
+		return h.access.NotifyRemoved(ctx, user, previous)
 	})
+	return inviteSent
 }
 
-// DELETE /v1/user/:id/contacts/:contactId
+// DELETE /v1/user/:user_id/contacts/:contact_id
 func (h *ContactsHandler) DeleteContact(c *gin.Context) {
-	userIDStr := c.Param("id")
-	contactID := c.Param("contactId")
-
-	userID, err := uuid.Parse(userIDStr)
-	if err != nil {
-		log.Printf("ERROR: Invalid user_id '%s': %v", userIDStr, err)
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "invalid user_id",
-			"details": err.Error(),
-		})
+	userID, ok := requireSelf(c, "only the user can remove their own contacts")
+	if !ok {
 		return
 	}
+	contactID := params.Get(c, params.Contact).String()
 
 	log.Printf("INFO: Deleting contact %s for user %s", contactID, userID)
 
-	if err := h.postgres.DeleteContact(c.Request.Context(), userID, contactID); err != nil {
-		log.Printf("ERROR: Failed to delete contact: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "failed to delete contact",
-			"details": err.Error(),
-		})
+	// Revoke first: a deleted contact whose tokens still work could not be retried
+	if err := h.access.RevokeContact(c.Request.Context(), userID, contactID); err != nil {
+		middleware.AbortWithError(c, apierror.Unavailable("could not revoke the contact's access").WithCause(err))
 		return
 	}
 
+	err := h.postgres.DeleteContact(c.Request.Context(), userID, contactID)
+	if errors.Is(err, database.ErrContactNotFound) || errors.Is(err, database.ErrUserNotFound) {
+		middleware.AbortWithError(c, apierror.NotFound(err.Error()))
+		return
+	}
+	if err != nil {
+		middleware.AbortWithError(c, apierror.Internal("failed to delete contact", err))
+		return
+	}
+
+	recordAudit(c, h.audit, &models.AuditEvent{
+		Action:        services.AuditContactDelete,
+		ObjectType:    "contact",
+		ObjectID:      contactID,
+		SubjectUserID: &userID,
+	})
+	// Warns the user if that was their last contact
+	h.warnings.Check(c.Request.Context(), userID)
+
 	c.JSON(http.StatusOK, gin.H{
 		"status": "success",
 		"message": "contact deleted successfully",
 	})
 }
+
+// POST /v1/user/:user_id/contacts/:contact_id/restore
+// Brings back a deleted contact with their id, and the history under it.
+// Their access was revoked on deletion, so they are invited again.
+func (h *ContactsHandler) RestoreContact(c *gin.Context) {
+	userID, ok := requireSelfOrAdmin(c, h.postgres)
+	if !ok {
+		return
+	}
+	contactID := params.Get(c, params.Contact).String()
+
+	contact, err := h.postgres.RestoreContact(c.Request.Context(), userID, contactID, h.cfg.MaxTrustedContacts)
+	switch {
+	case errors.Is(err, database.ErrContactNotFound) || errors.Is(err, database.ErrUserNotFound):
+		middleware.AbortWithError(c, apierror.NotFound(err.Error()))
+		return
+	case errors.Is(err, database.ErrContactNotDeleted):
+		middleware.AbortWithError(c, apierror.Conflict("the contact is not deleted"))
+		return
+	case errors.Is(err, database.ErrContactLimit):
+		middleware.AbortWithError(c, apierror.Conflict(fmt.Sprintf("the limit of %d trusted contacts is reached", h.cfg.MaxTrustedContacts)))
+		return
+	case err != nil:
+		middleware.AbortWithError(c, apierror.Internal("failed to restore contact", err))
+		return
+	}
+
+	log.Printf("INFO: Restored contact %s for user %s", contactID, userID)
+	recordAudit(c, h.audit, &models.AuditEvent{
+		Action:        services.AuditContactRestore,
+		ObjectType:    "contact",
+		ObjectID:      contactID,
+		SubjectUserID: &userID,
+	})
+
+	inviteSent := h.invite(c, userID, *contact)
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":      "success",
+		"contact":     contact,
+		"invite_sent": inviteSent,
+	})
+}
+
+// invite texts a new contact their confirmation link, reporting whether it went out
+func (h *ContactsHandler) invite(c *gin.Context, userID uuid.UUID, contact models.Contact) bool {
+	user, err := h.postgres.GetUserByID(c.Request.Context(), userID)
+	if err == nil && user == nil {
+		return false
+	}
+	if err == nil {
+		err = h.access.Invite(c.Request.Context(), user, contact)
+	}
+	if err != nil {
+		log.Printf("WARN: Failed to invite contact %s for user %s: %v", contact.ID, userID, err)
+		return false
+	}
+	return true
+}
+
+// updatedFields lists which contact fields an update touched, without their values
+func updatedFields(updates map[string]string, prefs *models.NotificationPreferences) []string {
+	var fields []string
+	for _, f := range []string{"name", "phone"} {
+		if _, ok := updates[f]; ok {
+			fields = append(fields, f)
+		}
+	}
+	if prefs != nil {
+		fields = append(fields, "preferences")
+	}
+	return fields
+}
@@ -0,0 +1,249 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/config"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/database"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/middleware"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/params"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/services"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/utils"
+)
+
+// Only the user changes their contacts: anyone else could invite strangers,
+// move a contact to their own phone to receive the user's alerts, or remove
+// every contact. Refused before anything is read or sent.
+func TestContactChangesRequireSelf(t *testing.T) {
+	h := NewContactsHandler(nil, nil, nil, nil, nil, nil)
+	router := testRouter()
+	user := router.Group("/v1/user/:user_id", params.UUID(params.User))
+	user.POST("/contacts", middleware.RequireAuth(testSecret), h.AddContact)
+	user.POST("/contacts/bulk", middleware.RequireAuth(testSecret), h.BulkAddContacts)
+	user.PUT("/contacts/:contact_id", params.UUID(params.Contact), middleware.RequireAuth(testSecret), h.UpdateContact)
+	user.DELETE("/contacts/:contact_id", params.UUID(params.Contact), middleware.RequireAuth(testSecret), h.DeleteContact)
+
+	userID, contactID := uuid.New(), uuid.New()
+	base := "/v1/user/" + userID.String() + "/contacts"
+	body := `{"name":"Stranger","phone":"+2348031234567"}`
+	routes := []struct {
+		method, path, body string
+	}{
+		{http.MethodPost, base, body},
+		{http.MethodPost, base + "/bulk", `{"contacts":[` + body + `]}`},
+		{http.MethodPut, base + "/" + contactID.String(), body},
+		{http.MethodDelete, base + "/" + contactID.String(), ""},
+	}
+	callers := []struct {
+		name   string
+		claims *utils.TokenClaims
+		want   int
+	}{
+		{"anonymous", nil, http.StatusUnauthorized},
+		{"another user", &utils.TokenClaims{Subject: uuid.NewString(), Role: utils.RoleUser}, http.StatusForbidden},
+		{"the user's contact", &utils.TokenClaims{Subject: userID.String(), Role: utils.RoleContact, Phone: "+2348031234567"}, http.StatusForbidden},
+		{"org admin", &utils.TokenClaims{Subject: uuid.NewString(), Role: utils.RoleOrgAdmin, OrgID: uuid.NewString()}, http.StatusForbidden},
+	}
+	for _, route := range routes {
+		for _, caller := range callers {
+			t.Run(route.method+" "+route.path[len(base):]+"/"+caller.name, func(t *testing.T) {
+				if w := send(t, router, route.method, route.path, route.body, caller.claims); w.Code != caller.want {
+					t.Errorf("status %d, want %d: %s", w.Code, caller.want, w.Body)
+				}
+			})
+		}
+	}
+}
+
+// textNotifier records the texts sent; any other notification is a test
+// failure, by the nil Notifier it embeds
+type textNotifier struct {
+	services.Notifier
+	mu    sync.Mutex
+	texts map[string][]string // messages by number
+}
+
+func (n *textNotifier) SendSMS(ctx context.Context, kind, to, message string) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.texts == nil {
+		n.texts = map[string][]string{}
+	}
+	n.texts[to] = append(n.texts[to], message)
+	return nil
+}
+
+// take returns the texts sent so far and forgets them
+func (n *textNotifier) take() map[string][]string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	texts := n.texts
+	n.texts = nil
+	return texts
+}
+
+type contactsFixture struct {
+	postgres *database.PostgresDB
+	notifier *textNotifier
+	router   *gin.Engine
+	user     *models.User
+	contact  models.Contact
+	claims   *utils.TokenClaims
+}
+
+// newContactsFixture gives a new user one confirmed contact. Texts are sent
+// as they are queued: the outbox is closed, so it sends inline.
+func newContactsFixture(t *testing.T) *contactsFixture {
+	t.Helper()
+	postgres := testPostgres(t)
+	redis := testRedis(t)
+	templates, err := services.NewMessageTemplates(nil)
+	if err != nil {
+		t.Fatalf("NewMessageTemplates: %v", err)
+	}
+	cfg := &config.Config{PublicBaseURL: "https://safetrace.test"}
+	notifier := &textNotifier{}
+	access := services.NewContactAccessService(config.NewStore(cfg), postgres, redis, notifier, templates, nil)
+	outbox := services.NewAlertOutbox(notifier, nil, 1, nil)
+	outbox.Close()
+
+	h := NewContactsHandler(cfg, postgres, access, outbox, nil, nil)
+	router := testRouter()
+	router.PUT("/v1/user/:user_id/contacts/:contact_id", params.UUID(params.User), params.UUID(params.Contact), middleware.RequireAuth(testSecret), h.UpdateContact)
+
+	user := createTestUser(t, postgres)
+	contact := models.Contact{ID: uuid.NewString(), Name: "Tunde", Phone: "+2348030000001", Status: models.ContactStatusConfirmed}
+	if err := postgres.AddContact(context.Background(), user.ID, contact, 10); err != nil {
+		t.Fatalf("AddContact: %v", err)
+	}
+	return &contactsFixture{
+		postgres: postgres,
+		notifier: notifier,
+		router:   router,
+		user:     user,
+		contact:  contact,
+		claims:   &utils.TokenClaims{Subject: user.ID.String(), Role: utils.RoleUser},
+	}
+}
+
+func (f *contactsFixture) update(t *testing.T, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	return send(t, f.router, http.MethodPut, "/v1/user/"+f.user.ID.String()+"/contacts/"+f.contact.ID, body, f.claims)
+}
+
+// stored returns the contact as stored now
+func (f *contactsFixture) stored(t *testing.T) models.Contact {
+	t.Helper()
+	user, err := f.postgres.GetUserByID(context.Background(), f.user.ID)
+	if err != nil || user == nil {
+		t.Fatalf("GetUserByID: %v", err)
+	}
+	for _, c := range user.TrustedContacts {
+		if c.ID == f.contact.ID {
+			return c
+		}
+	}
+	t.Fatalf("contact %s gone", f.contact.ID)
+	return models.Contact{}
+}
+
+// A new number has to confirm again, and the old one is told it was removed
+func TestUpdateContactPhoneReconfirms(t *testing.T) {
+	f := newContactsFixture(t)
+
+	// Renaming, or giving the same number in another format, keeps the confirmation
+	for _, body := range []string{`{"name":"Tunde Bakare"}`, `{"phone":"08030000001"}`} {
+		w := f.update(t, body)
+		if w.Code != http.StatusOK || strings.Contains(w.Body.String(), "invite_sent") {
+			t.Fatalf("update %s = %d: %s", body, w.Code, w.Body.String())
+		}
+		if c := f.stored(t); c.Status != models.ContactStatusConfirmed || c.Phone != f.contact.Phone {
+			t.Errorf("after %s: contact = %s at %s, want confirmed at the old number", body, c.Status, c.Phone)
+		}
+		if texts := f.notifier.take(); len(texts) != 0 {
+			t.Errorf("after %s: texts sent %v", body, texts)
+		}
+	}
+
+	const newPhone = "+2348030000002"
+	w := f.update(t, `{"phone":"`+newPhone+`"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("phone change = %d: %s", w.Code, w.Body.String())
+	}
+	var resp struct {
+		InviteSent bool `json:"invite_sent"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || !resp.InviteSent {
+		t.Errorf("response = %s, want invite_sent", w.Body.String())
+	}
+	if c := f.stored(t); c.Status != models.ContactStatusInvited || c.Phone != newPhone {
+		t.Errorf("contact = %s at %s, want invited at %s", c.Status, c.Phone, newPhone)
+	}
+
+	texts := f.notifier.take()
+	if len(texts) != 2 || len(texts[newPhone]) != 1 || len(texts[f.contact.Phone]) != 1 {
+		t.Fatalf("texts = %v, want one to each number", texts)
+	}
+	if invite := texts[newPhone][0]; !strings.Contains(invite, "https://safetrace.test/contact/confirm/") {
+		t.Errorf("invitation = %q, want a confirmation link", invite)
+	}
+	if removed := texts[f.contact.Phone][0]; !strings.Contains(removed, "no longer get their alerts") || strings.Contains(removed, newPhone) {
+		t.Errorf("removal notice = %q, want one that doesn't give the new number", removed)
+	}
+}
+
+// While an alert sent to the contact is unresolved their number can't be
+// swapped out from under it; the rest of the contact can still change
+func TestUpdateContactPhoneLockedDuringAlert(t *testing.T) {
+	f := newContactsFixture(t)
+	ctx := context.Background()
+	now := time.Now()
+	alert := &models.Alert{ID: uuid.New(), UserID: f.user.ID, State: models.AlertStateAlert, Reasons: models.Reasons{}, SentTo: []string{}, CreatedAt: now, DetectedAt: now}
+	if err := f.postgres.CreateAlert(ctx, alert); err != nil {
+		t.Fatalf("CreateAlert: %v", err)
+	}
+	recipient := &models.AlertRecipient{
+		ID: uuid.New(), AlertID: alert.ID, ContactID: f.contact.ID, ContactName: f.contact.Name,
+		Phone: f.contact.Phone, Channel: "sms", AckToken: "ack-" + uuid.NewString(), CreatedAt: now,
+	}
+	if err := f.postgres.CreateAlertRecipient(ctx, recipient); err != nil {
+		t.Fatalf("CreateAlertRecipient: %v", err)
+	}
+
+	if w := f.update(t, `{"phone":"+2348030000002"}`); w.Code != http.StatusConflict {
+		t.Fatalf("phone change during alert = %d, want 409: %s", w.Code, w.Body.String())
+	}
+	if w := f.update(t, `{"name":"Tunde B.","phone":"+2348030000002"}`); w.Code != http.StatusConflict {
+		t.Errorf("rename with phone change during alert = %d, want 409", w.Code)
+	}
+	if c := f.stored(t); c.Phone != f.contact.Phone || c.Name != f.contact.Name || c.Status != models.ContactStatusConfirmed {
+		t.Errorf("refused change stored: %+v", c)
+	}
+	if w := f.update(t, `{"name":"Tunde B."}`); w.Code != http.StatusOK {
+		t.Errorf("rename during alert = %d, want 200", w.Code)
+	}
+	if texts := f.notifier.take(); len(texts) != 0 {
+		t.Errorf("texts sent during alert: %v", texts)
+	}
+
+	if err := f.postgres.ResolveAlert(ctx, alert.ID); err != nil {
+		t.Fatalf("ResolveAlert: %v", err)
+	}
+	if w := f.update(t, `{"phone":"+2348030000002"}`); w.Code != http.StatusOK {
+		t.Errorf("phone change after resolution = %d: %s", w.Code, w.Body.String())
+	}
+	if c := f.stored(t); c.Phone != "+2348030000002" || c.Status != models.ContactStatusInvited {
+		t.Errorf("contact after resolution = %s at %s", c.Status, c.Phone)
+	}
+}
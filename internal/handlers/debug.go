@@ -0,0 +1,34 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/services"
+)
+
+// DebugHandler exposes development-only inspection endpoints. It is only
+// mounted when NOTIFIER=dev.
+type DebugHandler struct {
+	notifier *services.DevNotifier
+}
+
+func NewDebugHandler(notifier *services.DevNotifier) *DebugHandler {
+	return &DebugHandler{notifier: notifier}
+}
+
+// GET /debug/notifications
+// Messages the dev notifier would have sent, oldest first
+func (h *DebugHandler) ListNotifications(c *gin.Context) {
+	messages := h.notifier.Messages()
+	c.JSON(http.StatusOK, gin.H{
+		"notifications": messages,
+		"count":         len(messages),
+	})
+}
+
+// DELETE /debug/notifications
+func (h *DebugHandler) ClearNotifications(c *gin.Context) {
+	h.notifier.Clear()
+	c.Status(http.StatusNoContent)
+}
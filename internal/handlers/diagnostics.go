@@ -0,0 +1,228 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/apierror"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/database"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/flags"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/middleware"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+)
+
+// diagnosticAttempts is how many of a user's latest heartbeat attempts are
+// kept for their diagnostics
+const diagnosticAttempts = 20
+
+// bodyHasher hashes a request body as the handler reads it
+type bodyHasher struct {
+	io.ReadCloser
+	hash hash.Hash
+}
+
+// hashBody starts hashing the request body
+func hashBody(c *gin.Context) *bodyHasher {
+	b := &bodyHasher{ReadCloser: c.Request.Body, hash: sha256.New()}
+	c.Request.Body = b
+	return b
+}
+
+func (b *bodyHasher) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.hash.Write(p[:n])
+	return n, err
+}
+
+// Sum reads whatever of the body the handler left and returns its payload hash
+func (b *bodyHasher) Sum() string {
+	io.Copy(io.Discard, b)
+	return truncatedHash(b.hash)
+}
+
+// payloadHash returns the payload hash of a body already read
+func payloadHash(body []byte) string {
+	h := sha256.New()
+	h.Write(body)
+	return truncatedHash(h)
+}
+
+// truncatedHash is enough of a SHA-256 for a client to match an attempt
+// against what it sent, and too little to stand in for the body
+func truncatedHash(h hash.Hash) string {
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+// rejectedAttempt describes a heartbeat turned away with err. Signature and
+// nonce failures get codes of their own; anything else keeps the API code.
+func rejectedAttempt(c *gin.Context, err error) *models.IngestionAttempt {
+	apiErr := apierror.From(err)
+	attempt := &models.IngestionAttempt{
+		At:       time.Now(),
+		Endpoint: c.Request.Method + " " + c.FullPath(),
+		Outcome:  apiErr.Code,
+		Message:  apiErr.Message,
+	}
+	switch apiErr.Code {
+	case apierror.CodeUnauthorized:
+		attempt.Outcome = models.RejectInvalidSignature
+	case apierror.CodeConflict:
+		attempt.Outcome = models.RejectReplayDetected
+	}
+	if len(apiErr.Fields) > 0 {
+		attempt.Field = apiErr.Fields[0].Field
+	}
+	return attempt
+}
+
+// acceptedAttempt describes a heartbeat that was taken; queued when it was
+// buffered rather than written
+func acceptedAttempt(c *gin.Context, heartbeat *models.Heartbeat, queued bool) *models.IngestionAttempt {
+	attempt := &models.IngestionAttempt{
+		At:          time.Now(),
+		Endpoint:    c.Request.Method + " " + c.FullPath(),
+		DeviceID:    heartbeat.DeviceID,
+		Accepted:    true,
+		Outcome:     models.AttemptStored,
+		HeartbeatID: &heartbeat.ID,
+	}
+	switch {
+	case heartbeat.DuplicateOf != nil:
+		attempt.Outcome = models.AttemptDuplicate
+	case heartbeat.Backfill:
+		attempt.Outcome = models.AttemptBackfill
+	case queued:
+		attempt.Outcome = models.AttemptQueued
+	}
+	return attempt
+}
+
+// recordAttempt keeps an attempt for the user's diagnostics. Attempts naming
+// a user that doesn't exist are dropped, so they can't fill Redis. Failures
+// are logged; they never affect the heartbeat.
+func recordAttempt(c *gin.Context, postgres *database.PostgresDB, redis *database.RedisDB, userID uuid.UUID, attempt *models.IngestionAttempt) {
+	ctx := c.Request.Context()
+	if !attempt.Accepted {
+		user, err := postgres.GetUserByID(ctx, userID)
+		if err != nil || user == nil {
+			return
+		}
+	}
+	if err := redis.RecordIngestionAttempt(ctx, userID, attempt, diagnosticAttempts); err != nil {
+		log.Printf("WARN: Failed to record heartbeat attempt for user %s: %v", userID, err)
+	}
+}
+
+// recordHeartbeatAttempt records how a POST /v1/heartbeat went, once it has.
+// A request whose user_id isn't a UUID can't be attributed and isn't kept.
+func (h *HeartbeatHandler) recordHeartbeatAttempt(c *gin.Context, req *HeartbeatRequest, heartbeat *models.Heartbeat, body *bodyHasher) {
+	userID, err := uuid.Parse(req.UserID)
+	if err != nil {
+		return
+	}
+
+	var attempt *models.IngestionAttempt
+	switch last := c.Errors.Last(); {
+	case last != nil:
+		attempt = rejectedAttempt(c, last.Err)
+		attempt.DeviceID = req.DeviceID
+	case heartbeat != nil:
+		attempt = acceptedAttempt(c, heartbeat, h.buffer != nil)
+	default:
+		return
+	}
+	attempt.PayloadHash = body.Sum()
+	recordAttempt(c, h.postgres, h.redis, userID, attempt)
+}
+
+// rateLimitView is what is left of one device's heartbeat rate limit
+type rateLimitView struct {
+	DeviceID        string `json:"device_id"` // empty for heartbeats sent without one
+	Remaining       int    `json:"remaining"`
+	ResetsInSeconds int    `json:"resets_in_seconds"`
+}
+
+// GET /v1/user/:user_id/diagnostics
+// Why the user's recent heartbeats were or weren't taken, and what the
+// evaluator made of them; the user themselves, an admin or their
+// organization's admin. Holds no location.
+func (h *HeartbeatHandler) GetDiagnostics(c *gin.Context) {
+	userID, ok := requireSelfOrAdmin(c, h.postgres)
+	if !ok {
+		return
+	}
+	ctx := c.Request.Context()
+
+	attempts, err := h.redis.GetIngestionAttempts(ctx, userID)
+	if err != nil {
+		middleware.AbortWithError(c, apierror.Internal("failed to get heartbeat attempts", err))
+		return
+	}
+	evaluations, err := h.redis.GetEvaluationDiagnostics(ctx, userID)
+	if err != nil {
+		middleware.AbortWithError(c, apierror.Internal("failed to get evaluations", err))
+		return
+	}
+
+	// Each device has a budget of its own, and heartbeats without one share another
+	devices, err := h.redis.GetDevices(ctx, userID)
+	if err != nil {
+		middleware.AbortWithError(c, apierror.Internal("failed to get devices", err))
+		return
+	}
+	deviceIDs := []string{""}
+	for _, device := range devices {
+		deviceIDs = append(deviceIDs, device.DeviceID)
+	}
+	limits := make([]rateLimitView, 0, len(deviceIDs))
+	for _, deviceID := range deviceIDs {
+		remaining, resetIn, err := h.redis.RateLimitRemaining(ctx, userID, deviceID, heartbeatRateLimit)
+		if err != nil {
+			middleware.AbortWithError(c, apierror.Internal("rate limit check failed", err))
+			return
+		}
+		limits = append(limits, rateLimitView{
+			DeviceID:        deviceID,
+			Remaining:       remaining,
+			ResetsInSeconds: int(math.Ceil(resetIn.Seconds())),
+		})
+	}
+
+	lockedFor, err := h.guard.Lockout(ctx, userID)
+	if err != nil {
+		middleware.AbortWithError(c, apierror.Internal("signature lockout check failed", err))
+		return
+	}
+
+	response := gin.H{
+		"user_id":     userID,
+		"attempts":    attempts,
+		"evaluations": evaluations,
+		"rate_limit": gin.H{
+			"limit":          heartbeatRateLimit,
+			"window_seconds": int(heartbeatRateWindow.Seconds()),
+			"devices":        limits,
+		},
+		"signature_lockout_seconds": int(math.Ceil(lockedFor.Seconds())),
+		"flags":                     flags.For(ctx, userID),
+	}
+	state, err := h.redis.GetUserState(ctx, userID)
+	if err != nil {
+		middleware.AbortWithError(c, apierror.Internal("failed to get state", err))
+		return
+	}
+	if state != nil && state.NextIntervalSeconds > 0 {
+		response["next_interval_seconds"] = state.NextIntervalSeconds
+		response["next_interval_reason"] = state.NextIntervalReason
+	}
+
+	c.JSON(http.StatusOK, response)
+}
@@ -0,0 +1,135 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/apierror"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/utils"
+)
+
+// Each way a heartbeat is turned away becomes the reason code diagnostics
+// show for it
+func TestRejectedAttempt(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tests := []struct {
+		err         error
+		outcome     string
+		field       string
+		wantMessage string
+	}{
+		{apierror.Unauthorized("invalid signature"), models.RejectInvalidSignature, "", "invalid signature"},
+		{apierror.Conflict("nonce already used"), models.RejectReplayDetected, "", "nonce already used"},
+		{apierror.TooManyRequests("rate limit exceeded"), apierror.CodeRateLimited, "", "rate limit exceeded"},
+		{apierror.Invalid("cell_info", "mcc must be a mobile country code of at most 3 digits"), apierror.CodeValidationFailed, "cell_info", ""},
+		{apierror.NotFound("user not found"), apierror.CodeNotFound, "", "user not found"},
+		{errors.New("connection reset"), apierror.CodeInternal, "", ""},
+	}
+	for _, tt := range tests {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodPost, "/v1/heartbeat", nil)
+		got := rejectedAttempt(c, tt.err)
+		if got.Accepted || got.Outcome != tt.outcome || got.Field != tt.field {
+			t.Errorf("%v: outcome %q field %q, want %q %q", tt.err, got.Outcome, got.Field, tt.outcome, tt.field)
+		}
+		if tt.wantMessage != "" && got.Message != tt.wantMessage {
+			t.Errorf("%v: message %q, want %q", tt.err, got.Message, tt.wantMessage)
+		}
+	}
+}
+
+// Each rejection, over HTTP and SMS, is listed in the user's diagnostics
+// with its reason code, newest first, and without the location it carried
+func TestDiagnosticsRejections(t *testing.T) {
+	postgres, redis := testPostgres(t), testRedis(t)
+	router, _ := ingestionRouter(t, postgres, redis)
+	user := createTestUser(t, postgres)
+	other := createTestUser(t, postgres)
+
+	hb := dedupHeartbeat(user)
+	hb.DeviceID = "pixel-7"
+	signedV2 := func(hb *models.Heartbeat, nonce string) HeartbeatRequest {
+		req := heartbeatRequest(hb)
+		req.SigV, req.Source, req.Nonce = 2, "http", nonce
+		req.Signature = utils.SignString(utils.CanonicalHeartbeatStringV2(hb, "http", nonce), ingestionHMACSecret)
+		return req
+	}
+	const nonce = "a1b2c3d4e5f60718293a4b5c"
+	replayed := *hb
+	replayed.DeviceID = "pixel-8"
+	forged := *hb
+	forged.DeviceID = "pixel-9"
+	forgedReq := heartbeatRequest(&forged)
+	forgedReq.Signature = "c2lnbmF0dXJl"
+	invalid := *hb
+	invalid.DeviceID = "pixel-10"
+	invalid.CellInfo.MCC = 6210
+	tampered := smsPayload(hb)
+	tampered = tampered[:len(tampered)-4] + "AAAA"
+
+	deliveries := []struct {
+		name    string
+		deliver func()
+		outcome string
+		field   string
+	}{
+		{"accepted", func() { postHeartbeat(t, router, signedV2(hb, nonce)) }, models.AttemptStored, ""},
+		{"nonce reused", func() { postHeartbeat(t, router, signedV2(&replayed, nonce)) }, models.RejectReplayDetected, ""},
+		{"too soon from the device", func() { postHeartbeat(t, router, heartbeatRequest(hb)) }, apierror.CodeRateLimited, ""},
+		{"bad signature", func() { postHeartbeat(t, router, forgedReq) }, models.RejectInvalidSignature, ""},
+		{"invalid cell", func() { postHeartbeat(t, router, heartbeatRequest(&invalid)) }, apierror.CodeValidationFailed, "cell_info"},
+		{"SMS with a bad signature", func() { textSMS(t, router, user.Phone, tampered) }, models.RejectInvalidSignature, ""},
+		{"SMS from another phone", func() { textSMS(t, router, other.Phone, smsPayload(hb)) }, models.RejectSenderMismatch, ""},
+	}
+	for _, d := range deliveries {
+		d.deliver()
+	}
+
+	claims := utils.TokenClaims{Subject: user.ID.String(), Role: utils.RoleUser}
+	w := send(t, router, http.MethodGet, "/v1/user/"+user.ID.String()+"/diagnostics", "", &claims)
+	if w.Code != http.StatusOK {
+		t.Fatalf("diagnostics = %d %s", w.Code, w.Body)
+	}
+	var resp struct {
+		Attempts []map[string]any `json:"attempts"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(resp.Attempts) != len(deliveries) {
+		t.Fatalf("%d attempts listed, want %d: %s", len(resp.Attempts), len(deliveries), w.Body)
+	}
+	for i, d := range deliveries {
+		got := resp.Attempts[len(deliveries)-1-i]
+		field, _ := got["field"].(string)
+		if got["outcome"] != d.outcome || field != d.field {
+			t.Errorf("%s: outcome %v field %q, want %s %q", d.name, got["outcome"], field, d.outcome, d.field)
+		}
+		if hash, _ := got["payload_hash"].(string); len(hash) != 16 {
+			t.Errorf("%s: payload hash %q", d.name, hash)
+		}
+		for _, key := range []string{"lat", "lng", "cell_info"} {
+			if _, ok := got[key]; ok {
+				t.Errorf("%s: attempt holds %s", d.name, key)
+			}
+		}
+	}
+
+	// Only the user and admins see them
+	w = send(t, router, http.MethodGet, "/v1/user/"+user.ID.String()+"/diagnostics", "",
+		&utils.TokenClaims{Subject: other.ID.String(), Role: utils.RoleUser})
+	if w.Code != http.StatusForbidden {
+		t.Errorf("another user's diagnostics = %d", w.Code)
+	}
+	w = send(t, router, http.MethodGet, "/v1/user/"+user.ID.String()+"/diagnostics", "",
+		&utils.TokenClaims{Subject: other.ID.String(), Role: utils.RoleAdmin})
+	if w.Code != http.StatusOK {
+		t.Errorf("diagnostics for an admin = %d", w.Code)
+	}
+}
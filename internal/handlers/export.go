@@ -0,0 +1,405 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/apierror"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/config"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/database"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/geojson"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/middleware"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/services"
+)
+
+const (
+	// trackMaxHeartbeats bounds how many heartbeats a track export reads
+	trackMaxHeartbeats = 50000
+	// trackMaxSpan bounds the time range of a track export
+	trackMaxSpan = 7 * 24 * time.Hour
+	// heatmapMaxSpan bounds the time range of a heatmap
+	heatmapMaxSpan = 366 * 24 * time.Hour
+)
+
+type ExportHandler struct {
+	cfg      *config.Config
+	postgres *database.PostgresDB
+	redis    *database.RedisDB
+	audit    *services.AuditLogger
+}
+
+func NewExportHandler(
+	cfg *config.Config,
+	postgres *database.PostgresDB,
+	redis *database.RedisDB,
+	audit *services.AuditLogger,
+) *ExportHandler {
+	return &ExportHandler{
+		cfg:      cfg,
+		postgres: postgres,
+		redis:    redis,
+		audit:    audit,
+	}
+}
+
+// GET /admin/export/alerts.geojson?from=&to=&state=ALERT,AT_RISK
+// One Point feature per alert, at the user's last known position when it was
+// raised. Defaults to the last 30 days. Users are not identified. An org
+// admin only gets their members' alerts.
+func (h *ExportHandler) ExportAlerts(c *gin.Context) {
+	if !negotiateGeoJSON(c) {
+		return
+	}
+
+	orgID, ok := adminOrg(c)
+	if !ok {
+		return
+	}
+	from, to, ok := timeRangeParams(c, 30*24*time.Hour)
+	if !ok {
+		return
+	}
+	filter := models.AlertExportFilter{From: from, To: to, OrgID: orgID}
+	if v := c.Query("state"); v != "" {
+		for _, s := range strings.Split(v, ",") {
+			state := models.AlertState(strings.ToUpper(strings.TrimSpace(s)))
+			switch state {
+			case models.AlertStateCaution, models.AlertStateAtRisk, models.AlertStateAlert:
+				filter.States = append(filter.States, state)
+			default:
+				middleware.AbortWithError(c, apierror.Invalid("state", "must be a comma-separated list of CAUTION, AT_RISK, ALERT"))
+				return
+			}
+		}
+	}
+
+	recordAudit(c, h.audit, &models.AuditEvent{
+		Action:     services.AuditAlertsExport,
+		ObjectType: "alert_export",
+		Metadata:   map[string]interface{}{"query": c.Request.URL.RawQuery},
+	})
+
+	c.Header("Content-Type", geojson.MediaType)
+	c.Status(http.StatusOK)
+	fw, err := geojson.NewFeatureCollectionWriter(c.Writer)
+	if err != nil {
+		return
+	}
+
+	err = h.postgres.StreamAlertLocations(c.Request.Context(), filter, func(a *models.AlertLocation) error {
+		var geometry *geojson.Geometry
+		if a.Lat != nil && a.Lng != nil {
+			geometry = geojson.Point(*a.Lat, *a.Lng)
+		}
+		return fw.Write(geojson.NewFeature(a.ID.String(), geometry, map[string]interface{}{
+			"state":       a.State,
+			"score":       a.Score,
+			"reason":      a.Reason,
+			"resolved":    a.ResolvedAt != nil,
+			"resolved_at": a.ResolvedAt,
+			"created_at":  a.CreatedAt,
+			"located_at":  a.LocatedAt,
+			"plus_code":   a.PlusCode,
+			"what3words":  a.What3Words,
+		}))
+	})
+	if err != nil {
+		// Headers are gone; leaving the collection unclosed marks it as truncated
+		log.Printf("ERROR: Alert export failed after %d features: %v", fw.Count(), err)
+		return
+	}
+	fw.Close()
+}
+
+// GET /admin/heatmap?from=&to=&precision=5&min_users=5&format=geojson|csv
+// Alert and LastGasp counts binned into geohash cells, for partners who need
+// aggregate risk maps. Bins with fewer than min_users distinct users are
+// folded into their parent cell or dropped, and no user is ever identified;
+// min_users may raise HEATMAP_MIN_USERS but not lower it. Defaults to the
+// last 30 days. An org admin's heatmap only counts their members. Results
+// are cached per organization, precision, min_users and range.
+func (h *ExportHandler) ExportHeatmap(c *gin.Context) {
+	orgID, ok := adminOrg(c)
+	if !ok {
+		return
+	}
+
+	format := c.DefaultQuery("format", "geojson")
+	switch {
+	case strings.HasSuffix(c.Request.URL.Path, ".geojson"):
+		format = "geojson"
+	case strings.HasSuffix(c.Request.URL.Path, ".csv"):
+		format = "csv"
+	}
+	if format != "geojson" && format != "csv" {
+		middleware.AbortWithError(c, apierror.Invalid("format", "must be geojson or csv"))
+		return
+	}
+
+	from, to, ok := timeRangeParams(c, 30*24*time.Hour)
+	if !ok {
+		return
+	}
+	if to.Sub(from) > heatmapMaxSpan {
+		middleware.AbortWithError(c, apierror.Invalid("from", "range must not exceed 366 days"))
+		return
+	}
+	// Whole minutes, so repeated requests for the default range share a cache entry
+	from, to = from.Truncate(time.Minute), to.Truncate(time.Minute)
+
+	precision, err := strconv.Atoi(c.DefaultQuery("precision", strconv.Itoa(h.cfg.HeatmapPrecision)))
+	if err != nil || precision < services.HeatmapMinPrecision || precision > services.HeatmapMaxPrecision {
+		middleware.AbortWithError(c, apierror.Invalid("precision", fmt.Sprintf("must be between %d and %d", services.HeatmapMinPrecision, services.HeatmapMaxPrecision)))
+		return
+	}
+	minUsers, err := strconv.Atoi(c.DefaultQuery("min_users", strconv.Itoa(h.cfg.HeatmapMinUsers)))
+	if err != nil || minUsers < h.cfg.HeatmapMinUsers {
+		middleware.AbortWithError(c, apierror.Invalid("min_users", fmt.Sprintf("must be at least %d", h.cfg.HeatmapMinUsers)))
+		return
+	}
+
+	bins, err := h.heatmapBins(c, orgID, precision, minUsers, from, to)
+	if err != nil {
+		middleware.AbortWithError(c, apierror.Internal("failed to build heatmap", err))
+		return
+	}
+
+	recordAudit(c, h.audit, &models.AuditEvent{
+		Action:     services.AuditHeatmapExport,
+		ObjectType: "heatmap",
+		Metadata: map[string]interface{}{
+			"from":      from,
+			"to":        to,
+			"precision": precision,
+			"min_users": minUsers,
+			"format":    format,
+			"bins":      len(bins),
+		},
+	})
+
+	if format == "csv" {
+		writeHeatmapCSV(c, bins)
+		return
+	}
+
+	c.Header("Content-Type", geojson.MediaType)
+	c.Status(http.StatusOK)
+	fw, err := geojson.NewFeatureCollectionWriter(c.Writer)
+	if err != nil {
+		return
+	}
+	for _, bin := range bins {
+		minLat, maxLat, minLng, maxLng := services.GeohashBounds(bin.Geohash)
+		err := fw.Write(geojson.NewFeature(bin.Geohash, geojson.BBoxPolygon(minLat, maxLat, minLng, maxLng), map[string]interface{}{
+			"geohash":    bin.Geohash,
+			"precision":  bin.Precision,
+			"alerts":     bin.Alerts,
+			"last_gasps": bin.LastGasps,
+			"users":      bin.Users,
+			"merged":     bin.Merged,
+		}))
+		if err != nil {
+			log.Printf("ERROR: Heatmap export failed after %d features: %v", fw.Count(), err)
+			return
+		}
+	}
+	fw.Close()
+}
+
+// heatmapBins returns the anonymized bins from the cache, building and
+// caching them on a miss. A cache failure only costs a rebuild.
+func (h *ExportHandler) heatmapBins(c *gin.Context, orgID *uuid.UUID, precision, minUsers int, from, to time.Time) ([]services.HeatmapBin, error) {
+	ctx := c.Request.Context()
+	scope := "all"
+	if orgID != nil {
+		scope = orgID.String()
+	}
+	cached, err := h.redis.GetCachedHeatmap(ctx, scope, precision, minUsers, from, to)
+	if err != nil {
+		log.Printf("WARN: Failed to read cached heatmap: %v", err)
+	}
+	if cached != nil {
+		var bins []services.HeatmapBin
+		if err := json.Unmarshal(cached, &bins); err == nil {
+			return bins, nil
+		}
+	}
+
+	samples, err := h.postgres.GetHeatmapSamples(ctx, from, to, precision, orgID)
+	if err != nil {
+		return nil, err
+	}
+	bins := services.BuildHeatmap(samples, minUsers)
+
+	if h.cfg.HeatmapCacheTTLSeconds > 0 {
+		data, err := json.Marshal(bins)
+		if err == nil {
+			err = h.redis.CacheHeatmap(ctx, scope, precision, minUsers, from, to, data, time.Duration(h.cfg.HeatmapCacheTTLSeconds)*time.Second)
+		}
+		if err != nil {
+			log.Printf("WARN: Failed to cache heatmap: %v", err)
+		}
+	}
+	return bins, nil
+}
+
+// writeHeatmapCSV writes one row per bin, with the cell's center and bounds
+func writeHeatmapCSV(c *gin.Context, bins []services.HeatmapBin) {
+	c.Header("Content-Type", "text/csv")
+	c.Header("Content-Disposition", `attachment; filename="heatmap.csv"`)
+	c.Status(http.StatusOK)
+
+	w := csv.NewWriter(c.Writer)
+	w.Write([]string{"geohash", "precision", "lat", "lng", "min_lat", "max_lat", "min_lng", "max_lng", "alerts", "last_gasps", "users", "merged"})
+	f := func(v float64) string { return strconv.FormatFloat(v, 'f', 6, 64) }
+	for _, bin := range bins {
+		minLat, maxLat, minLng, maxLng := services.GeohashBounds(bin.Geohash)
+		w.Write([]string{
+			bin.Geohash,
+			strconv.Itoa(bin.Precision),
+			f(bin.Lat), f(bin.Lng),
+			f(minLat), f(maxLat), f(minLng), f(maxLng),
+			strconv.Itoa(bin.Alerts),
+			strconv.Itoa(bin.LastGasps),
+			strconv.Itoa(bin.Users),
+			strconv.FormatBool(bin.Merged),
+		})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		log.Printf("ERROR: Heatmap CSV export failed: %v", err)
+	}
+}
+
+// GET /v1/user/:user_id/heartbeats.geojson?from=&to=&tolerance_m=10&max_points=2000&include_duplicates=false
+// The user's heartbeats as one LineString, downsampled for display. Defaults
+// to the last 24 hours; properties.timestamps and properties.trust line up
+// with the coordinates, and with include_duplicates properties.duplicate_of
+// too. Copies of a heartbeat sent over another channel are left out unless
+// include_duplicates is set.
+func (h *ExportHandler) ExportTrack(c *gin.Context) {
+	if !negotiateGeoJSON(c) {
+		return
+	}
+
+	user, ok := loadAuthorizedUser(c, h.postgres)
+	if !ok {
+		return
+	}
+
+	from, to, ok := timeRangeParams(c, 24*time.Hour)
+	if !ok {
+		return
+	}
+	if to.Sub(from) > trackMaxSpan {
+		middleware.AbortWithError(c, apierror.Invalid("from", "range must not exceed 7 days"))
+		return
+	}
+
+	tolerance, err := strconv.ParseFloat(c.DefaultQuery("tolerance_m", "10"), 64)
+	if err != nil || tolerance < 0 {
+		middleware.AbortWithError(c, apierror.Invalid("tolerance_m", "must be a non-negative number of meters"))
+		return
+	}
+	maxPoints, err := strconv.Atoi(c.DefaultQuery("max_points", "2000"))
+	if err != nil || maxPoints < 2 || maxPoints > 10000 {
+		middleware.AbortWithError(c, apierror.Invalid("max_points", "must be between 2 and 10000"))
+		return
+	}
+
+	includeDuplicates := false
+	if v := c.Query("include_duplicates"); v != "" {
+		parsed, err := strconv.ParseBool(v)
+		if err != nil {
+			middleware.AbortWithError(c, apierror.Invalid("include_duplicates", "must be true or false"))
+			return
+		}
+		includeDuplicates = parsed
+	}
+
+	heartbeats, err := h.postgres.GetHeartbeatsBetween(c.Request.Context(), user.ID, from, to, trackMaxHeartbeats, includeDuplicates)
+	if err != nil {
+		middleware.AbortWithError(c, apierror.Internal("failed to get heartbeats", err))
+		return
+	}
+
+	// Heartbeats without a fix (e.g. SMS sent with no GPS) would pin the line to 0,0
+	located := heartbeats[:0]
+	for _, hb := range heartbeats {
+		if hb.Lat != 0 || hb.Lng != 0 {
+			located = append(located, hb)
+		}
+	}
+	track := services.DownsampleTrack(located, tolerance, maxPoints)
+
+	recordAudit(c, h.audit, &models.AuditEvent{
+		Action:        services.AuditTrackExport,
+		ObjectType:    "heartbeat_track",
+		SubjectUserID: &user.ID,
+		Metadata:      map[string]interface{}{"from": from, "to": to, "points": len(track)},
+	})
+
+	c.Header("Content-Type", geojson.MediaType)
+	c.Status(http.StatusOK)
+	fw, err := geojson.NewFeatureCollectionWriter(c.Writer)
+	if err != nil {
+		return
+	}
+	if len(track) > 0 {
+		positions := make([][]float64, 0, len(track))
+		timestamps := make([]time.Time, 0, len(track))
+		trust := make([]string, 0, len(track))
+		duplicateOf := make([]*uuid.UUID, 0, len(track))
+		for _, hb := range track {
+			positions = append(positions, geojson.Position(hb.Lat, hb.Lng))
+			timestamps = append(timestamps, hb.Timestamp)
+			trust = append(trust, services.HeartbeatTrust(&hb))
+			duplicateOf = append(duplicateOf, hb.DuplicateOf)
+		}
+
+		// A LineString needs two positions; a single fix is a Point
+		geometry := geojson.LineString(positions)
+		if len(track) == 1 {
+			geometry = geojson.Point(track[0].Lat, track[0].Lng)
+		}
+		properties := map[string]interface{}{
+			"from":       from,
+			"to":         to,
+			"heartbeats": len(located),
+			"points":     len(track),
+			"timestamps": timestamps,
+			"trust":      trust,
+		}
+		if includeDuplicates {
+			properties["duplicate_of"] = duplicateOf
+		}
+		err = fw.Write(geojson.NewFeature(user.ID.String(), geometry, properties))
+		if err != nil {
+			log.Printf("ERROR: Track export for user %s failed: %v", user.ID, err)
+			return
+		}
+	}
+	fw.Close()
+}
+
+// negotiateGeoJSON accepts requests for the .geojson path, or whose Accept
+// header allows GeoJSON or plain JSON. It writes a 406 otherwise.
+func negotiateGeoJSON(c *gin.Context) bool {
+	if strings.HasSuffix(c.Request.URL.Path, ".geojson") || c.GetHeader("Accept") == "" {
+		return true
+	}
+	if c.NegotiateFormat(geojson.MediaType, gin.MIMEJSON) != "" {
+		return true
+	}
+	middleware.AbortWithError(c, apierror.NotAcceptable("this endpoint only produces "+geojson.MediaType))
+	return false
+}
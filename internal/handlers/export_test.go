@@ -0,0 +1,47 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GeoJSON is served for the .geojson path whatever the Accept header says,
+// and otherwise to clients that accept GeoJSON or JSON
+func TestNegotiateGeoJSON(t *testing.T) {
+	router := testRouter()
+	ok := func(c *gin.Context) {
+		if negotiateGeoJSON(c) {
+			c.Status(http.StatusNoContent)
+		}
+	}
+	router.GET("/admin/export/alerts.geojson", ok)
+	router.GET("/admin/export/alerts", ok)
+
+	tests := []struct {
+		path, accept string
+		want         int
+	}{
+		{"/admin/export/alerts.geojson", "", http.StatusNoContent},
+		{"/admin/export/alerts.geojson", "text/csv", http.StatusNoContent},
+		{"/admin/export/alerts", "", http.StatusNoContent},
+		{"/admin/export/alerts", "application/geo+json", http.StatusNoContent},
+		{"/admin/export/alerts", "application/json", http.StatusNoContent},
+		{"/admin/export/alerts", "text/csv;q=0.9, application/geo+json;q=0.5", http.StatusNoContent},
+		{"/admin/export/alerts", "text/csv", http.StatusNotAcceptable},
+		{"/admin/export/alerts", "application/vnd.google-earth.kml+xml", http.StatusNotAcceptable},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+		if tt.accept != "" {
+			req.Header.Set("Accept", tt.accept)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != tt.want {
+			t.Errorf("GET %s, Accept %q = %d, want %d", tt.path, tt.accept, w.Code, tt.want)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/apierror"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/database"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/flags"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/middleware"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/params"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/services"
+)
+
+type FlagsHandler struct {
+	postgres *database.PostgresDB
+	audit    *services.AuditLogger
+}
+
+func NewFlagsHandler(postgres *database.PostgresDB, audit *services.AuditLogger) *FlagsHandler {
+	return &FlagsHandler{
+		postgres: postgres,
+		audit:    audit,
+	}
+}
+
+// SetFlagRequest forces a flag on or off for a user; a null enabled clears
+// the override, leaving the user to the deployment's setting
+type SetFlagRequest struct {
+	Flag    string `json:"flag" binding:"required"`
+	Enabled *bool  `json:"enabled"`
+}
+
+// GET /admin/users/:user_id/flags
+// Every flag as decided for the user, and where each decision came from
+func (h *FlagsHandler) GetFlags(c *gin.Context) {
+	userID := params.UserID(c)
+	user, err := h.postgres.GetUserByID(c.Request.Context(), userID)
+	if err != nil {
+		middleware.AbortWithError(c, apierror.Internal("database error", err))
+		return
+	}
+	if user == nil {
+		middleware.AbortWithError(c, apierror.NotFound("user not found"))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"user_id": userID,
+		"flags":   flags.For(c.Request.Context(), userID),
+	})
+}
+
+// POST /admin/users/:user_id/flags
+// Forces a flag on or off for the user, or clears their override. Takes
+// effect from their next evaluation.
+func (h *FlagsHandler) SetFlag(c *gin.Context) {
+	userID := params.UserID(c)
+
+	var req SetFlagRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		middleware.AbortWithError(c, apierror.Validation(err))
+		return
+	}
+	if !flags.Known(req.Flag) {
+		middleware.AbortWithError(c, apierror.Invalid("flag", "must be one of "+strings.Join(flags.Names(), ", ")))
+		return
+	}
+
+	ctx := c.Request.Context()
+	user, err := h.postgres.GetUserByID(ctx, userID)
+	if err != nil {
+		middleware.AbortWithError(c, apierror.Internal("database error", err))
+		return
+	}
+	if user == nil {
+		middleware.AbortWithError(c, apierror.NotFound("user not found"))
+		return
+	}
+
+	setBy := "admin"
+	if claims := middleware.Principal(c); claims != nil {
+		setBy = claims.Subject
+	}
+	changed, err := h.postgres.SetUserFlagOverride(ctx, userID, req.Flag, req.Enabled, setBy)
+	if err != nil {
+		middleware.AbortWithError(c, apierror.Internal("failed to set flag", err))
+		return
+	}
+
+	if changed {
+		action := services.AuditFeatureFlagSet
+		metadata := map[string]interface{}{"flag": req.Flag}
+		if req.Enabled == nil {
+			action = services.AuditFeatureFlagClear
+		} else {
+			metadata["enabled"] = *req.Enabled
+		}
+		recordAudit(c, h.audit, &models.AuditEvent{
+			Action:        action,
+			ObjectType:    "user",
+			ObjectID:      userID.String(),
+			SubjectUserID: &userID,
+			Metadata:      metadata,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"user_id": userID,
+		"flags":   flags.For(ctx, userID),
+	})
+}
@@ -0,0 +1,243 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/apierror"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/bootstrap"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/middleware"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/services"
+)
+
+const dependencyPingTimeout = 2 * time.Second
+
+// bootRetryAfterSeconds is the Retry-After sent while dependencies connect
+const bootRetryAfterSeconds = "5"
+
+// Pinger is a dependency that can report whether it is reachable
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+type HealthHandler struct {
+	postgres       Pinger
+	redis          Pinger
+	boot           *bootstrap.Boot
+	registry       *services.HealthRegistry
+	audit          *services.AuditLogger
+	signatures     *services.SignatureGuard
+	evaluator      *services.SafetyEvaluator
+	staleMonitor   *services.StaleMonitor
+	warmup         *services.RedisWarmup
+	outbox         *services.AlertOutbox
+	shadow         *services.ShadowEvaluator
+	reconciler     *services.AlertReconciler
+	budget         *services.OutboundBudget
+	smsConfigured  bool
+	pushConfigured bool
+}
+
+func NewHealthHandler(
+	postgres Pinger,
+	redis Pinger,
+	boot *bootstrap.Boot,
+	registry *services.HealthRegistry,
+	audit *services.AuditLogger,
+	signatures *services.SignatureGuard,
+	evaluator *services.SafetyEvaluator,
+	staleMonitor *services.StaleMonitor,
+	warmup *services.RedisWarmup,
+	outbox *services.AlertOutbox,
+	shadow *services.ShadowEvaluator,
+	reconciler *services.AlertReconciler,
+	budget *services.OutboundBudget,
+	smsConfigured bool,
+	pushConfigured bool,
+) *HealthHandler {
+	return &HealthHandler{
+		postgres:       postgres,
+		redis:          redis,
+		boot:           boot,
+		registry:       registry,
+		audit:          audit,
+		signatures:     signatures,
+		evaluator:      evaluator,
+		staleMonitor:   staleMonitor,
+		warmup:         warmup,
+		outbox:         outbox,
+		shadow:         shadow,
+		reconciler:     reconciler,
+		budget:         budget,
+		smsConfigured:  smsConfigured,
+		pushConfigured: pushConfigured,
+	}
+}
+
+type componentStatus struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// GET /health/live
+func (h *HealthHandler) Live(c *gin.Context) {
+	live(c)
+}
+
+func live(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "ok",
+		"service": "safetrace-api",
+		"time":    time.Now().Format(time.RFC3339),
+	})
+}
+
+// GET /health/ready
+func (h *HealthHandler) Ready(c *gin.Context) {
+	components, workers, ready := h.readiness(c.Request.Context())
+
+	// A lagging evaluation queue still serves; it is reported, not failed
+	lag, lagging := h.evaluator.QueueLag()
+
+	status, code := "ok", http.StatusOK
+	if !ready {
+		status, code = "degraded", http.StatusServiceUnavailable
+	} else if lagging {
+		status = "degraded"
+	}
+
+	c.JSON(code, gin.H{
+		"status":       status,
+		"service":      "safetrace-api",
+		"time":         time.Now().Format(time.RFC3339),
+		"components":   components,
+		"dependencies": h.boot.Statuses(),
+		"workers":      workers,
+		"integrations": gin.H{
+			"sms_configured":  h.smsConfigured,
+			"push_configured": h.pushConfigured,
+		},
+		"audit": gin.H{
+			"queued":  h.audit.Len(),
+			"dropped": h.audit.Dropped(),
+		},
+		"signatures": gin.H{
+			"failures": h.signatures.Failures(),
+			"lockouts": h.signatures.Lockouts(),
+		},
+		"evaluations": gin.H{
+			"queued":     h.evaluator.QueueLen(),
+			"coalesced":  h.evaluator.Coalesced(),
+			"lag_ms":     lag.Milliseconds(),
+			"lagging":    lagging,
+			"stale":      h.evaluator.StaleEvaluations(),
+			"superseded": h.evaluator.SupersededEvaluations(),
+		},
+		"stale_monitor": gin.H{
+			"tracked":      h.staleMonitor.Tracked(),
+			"due":          h.staleMonitor.Due(),
+			"claimed":      h.staleMonitor.Claimed(),
+			"last_tick_ms": h.staleMonitor.LastTick().Milliseconds(),
+		},
+		"redis_warmup": gin.H{
+			"running":        h.warmup.Running(),
+			"in_window":      h.warmup.InWindow(),
+			"total":          h.warmup.Total(),
+			"processed":      h.warmup.Processed(),
+			"restored":       h.warmup.Restored(),
+			"last_completed": h.warmup.LastCompleted(),
+		},
+		"alert_outbox": gin.H{
+			"queued": h.outbox.Len(),
+		},
+		"shadow": gin.H{
+			"queued":    h.shadow.Len(),
+			"evaluated": h.shadow.Evaluated(),
+			"diverged":  h.shadow.Diverged(),
+			"dropped":   h.shadow.Dropped(),
+		},
+		"alert_reconciler": gin.H{
+			"closed":       h.reconciler.Closed(),
+			"redispatched": h.reconciler.Redispatched(),
+			"flagged":      h.reconciler.Flagged(),
+		},
+		"outbound_budget": gin.H{
+			"dropped":  h.budget.Dropped(),
+			"over_cap": h.budget.OverCap(),
+		},
+	})
+}
+
+// BootHealthHandler answers while Postgres and Redis are still connecting,
+// before the API exists: the process is live but not ready, and every other
+// route, ingestion included, returns 503 so clients retry
+type BootHealthHandler struct {
+	boot *bootstrap.Boot
+}
+
+func NewBootHealthHandler(boot *bootstrap.Boot) *BootHealthHandler {
+	return &BootHealthHandler{boot: boot}
+}
+
+// GET /health/live
+func (h *BootHealthHandler) Live(c *gin.Context) {
+	live(c)
+}
+
+// GET /health/ready
+// Always 503 while booting, with each dependency's connection attempts
+func (h *BootHealthHandler) Ready(c *gin.Context) {
+	c.JSON(http.StatusServiceUnavailable, gin.H{
+		"status":       "starting",
+		"service":      "safetrace-api",
+		"time":         time.Now().Format(time.RFC3339),
+		"dependencies": h.boot.Statuses(),
+	})
+}
+
+// Unavailable answers every other route while booting
+func (h *BootHealthHandler) Unavailable(c *gin.Context) {
+	c.Header("Retry-After", bootRetryAfterSeconds)
+	message := "starting up; try again shortly"
+	if pending := h.boot.Pending(); len(pending) > 0 {
+		message = "starting up, waiting for " + strings.Join(pending, " and ") + "; try again shortly"
+	}
+	middleware.AbortWithError(c, apierror.Unavailable(message))
+}
+
+// readiness pings the dependencies and checks the workers; the instance is
+// ready only if every one of them is up
+func (h *HealthHandler) readiness(ctx context.Context) (map[string]componentStatus, []services.WorkerStatus, bool) {
+	ready := true
+
+	components := map[string]componentStatus{
+		"postgres": h.ping(ctx, h.postgres),
+		"redis":    h.ping(ctx, h.redis),
+	}
+	for _, comp := range components {
+		if comp.Status != "ok" {
+			ready = false
+		}
+	}
+
+	workers := h.registry.Workers()
+	for _, w := range workers {
+		if !w.Healthy {
+			ready = false
+		}
+	}
+	return components, workers, ready
+}
+
+func (h *HealthHandler) ping(ctx context.Context, p Pinger) componentStatus {
+	ctx, cancel := context.WithTimeout(ctx, dependencyPingTimeout)
+	defer cancel()
+
+	if err := p.Ping(ctx); err != nil {
+		return componentStatus{Status: "down", Error: err.Error()}
+	}
+	return componentStatus{Status: "ok"}
+}
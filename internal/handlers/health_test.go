@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/bootstrap"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/middleware"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/services"
+)
+
+type pingerFunc func(ctx context.Context) error
+
+func (f pingerFunc) Ping(ctx context.Context) error { return f(ctx) }
+
+var (
+	up   = pingerFunc(func(context.Context) error { return nil })
+	down = pingerFunc(func(context.Context) error { return errors.New("connection refused") })
+	// hung answers only when the ping gives up on it
+	hung = pingerFunc(func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+)
+
+func TestReadiness(t *testing.T) {
+	start := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name      string
+		postgres  Pinger
+		redis     Pinger
+		stall     bool
+		wantReady bool
+		wantDown  []string
+	}{
+		{"all up", up, up, false, true, nil},
+		{"postgres down", down, up, false, false, []string{"postgres"}},
+		{"redis down", up, down, false, false, []string{"redis"}},
+		{"both down", down, down, false, false, []string{"postgres", "redis"}},
+		{"redis hangs", up, hung, false, false, []string{"redis"}},
+		{"worker stalled", up, up, true, false, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := services.NewFakeClock(start)
+			registry := services.NewHealthRegistryWithClock(clock)
+			registry.Register("evaluation_workers", time.Minute)
+			if tt.stall {
+				clock.Advance(time.Hour)
+			}
+			h := NewHealthHandler(tt.postgres, tt.redis, nil, registry, nil, nil, nil, nil, nil, nil, nil, nil, nil, false, false)
+
+			began := time.Now()
+			components, workers, ready := h.readiness(context.Background())
+			if elapsed := time.Since(began); elapsed > dependencyPingTimeout+time.Second {
+				t.Errorf("readiness took %v, want bounded by the ping timeout", elapsed)
+			}
+			if ready != tt.wantReady {
+				t.Errorf("ready = %v, want %v", ready, tt.wantReady)
+			}
+			for _, name := range tt.wantDown {
+				if c := components[name]; c.Status != "down" || c.Error == "" {
+					t.Errorf("%s = %+v, want down with the error", name, c)
+				}
+			}
+			if len(workers) != 1 || workers[0].Healthy == tt.stall {
+				t.Errorf("workers = %+v, stalled %v", workers, tt.stall)
+			}
+		})
+	}
+}
+
+// While the dependencies connect, readiness fails and every other route
+// asks to be retried
+func TestBootHealth(t *testing.T) {
+	boot := bootstrap.New(bootstrap.Policy{}, "postgres", "redis")
+	h := NewBootHealthHandler(boot)
+	router := gin.New()
+	router.Use(middleware.RequestID(), middleware.ErrorHandler())
+	router.GET("/health/live", h.Live)
+	router.GET("/health/ready", h.Ready)
+	router.NoRoute(h.Unavailable)
+
+	tests := []struct {
+		path       string
+		wantStatus int
+	}{
+		{"/health/live", http.StatusOK},
+		{"/health/ready", http.StatusServiceUnavailable},
+		{"/v1/heartbeat", http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		if w.Code != tt.wantStatus {
+			t.Errorf("GET %s = %d, want %d", tt.path, w.Code, tt.wantStatus)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/v1/heartbeat", nil))
+	if w.Header().Get("Retry-After") != bootRetryAfterSeconds {
+		t.Errorf("Retry-After = %q, want %s", w.Header().Get("Retry-After"), bootRetryAfterSeconds)
+	}
+}
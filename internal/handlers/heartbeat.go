@@ -1,202 +1,752 @@
 package handlers
 
 import (
+	"fmt"
+	"log"
+	"math"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/apierror"
 	"github.com/adedejiosvaldo/safetrace/backend/internal/config"
 	"github.com/adedejiosvaldo/safetrace/backend/internal/database"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/middleware"
 	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/params"
 	"github.com/adedejiosvaldo/safetrace/backend/internal/services"
 	"github.com/adedejiosvaldo/safetrace/backend/internal/utils"
 )
 
 type HeartbeatHandler struct {
-	cfg       *config.Config
+	cfg       *config.Store
 	postgres  *database.PostgresDB
 	redis     *database.RedisDB
 	evaluator *services.SafetyEvaluator
+	outbox    *services.AlertOutbox
+	buffer    *services.HeartbeatBuffer // nil when writes are synchronous
+	spoof     *services.SpoofDetector
+	guard     *services.SignatureGuard
+	audit     *services.AuditLogger
 }
 
 func NewHeartbeatHandler(
-	cfg *config.Config,
+	cfg *config.Store,
 	postgres *database.PostgresDB,
 	redis *database.RedisDB,
 	evaluator *services.SafetyEvaluator,
+	outbox *services.AlertOutbox,
+	buffer *services.HeartbeatBuffer,
+	spoof *services.SpoofDetector,
+	guard *services.SignatureGuard,
+	audit *services.AuditLogger,
 ) *HeartbeatHandler {
 	return &HeartbeatHandler{
 		cfg:       cfg,
 		postgres:  postgres,
 		redis:     redis,
 		evaluator: evaluator,
+		outbox:    outbox,
+		buffer:    buffer,
+		spoof:     spoof,
+		guard:     guard,
+		audit:     audit,
 	}
 }
 
 type HeartbeatRequest struct {
 	UserID     string           `json:"user_id" binding:"required"`
 	Timestamp  time.Time        `json:"timestamp" binding:"required"`
-	Lat        float64          `json:"lat" binding:"required"`
-	Lng        float64          `json:"lng" binding:"required"`
-	AccuracyM  int              `json:"accuracy_m" binding:"required"`
+	Lat        *float64         `json:"lat"`        // required; 0 is on the equator
+	Lng        *float64         `json:"lng"`        // required; 0 is on the prime meridian
+	AccuracyM  *int             `json:"accuracy_m"` // required; some devices send 0
 	CellInfo   models.CellInfo  `json:"cell_info" binding:"required"`
 	BatteryPct *int             `json:"battery_pct,omitempty"`
 	Speed      *float64         `json:"speed,omitempty"`
 	LastGasp   bool             `json:"last_gasp"`
+	IsMock     bool             `json:"is_mock"` // OS reports a mock location provider
+	Evaluate   string           `json:"evaluate,omitempty" binding:"omitempty,oneof=sync async"`
+	Source     string           `json:"source,omitempty"`                               // must be "http" if set; required with sig_v 2
+	DeviceID   string           `json:"device_id,omitempty" binding:"omitempty,max=64"` // optional; tells a user's devices apart
 	Signature  string           `json:"signature" binding:"required"`
+
+	// Optional; the signing scheme, 1 when absent. Version 2 also signs the
+	// source, device_id and nonce, and needs both source and nonce.
+	SigV  int    `json:"sig_v,omitempty" binding:"omitempty,oneof=1 2"`
+	Nonce string `json:"nonce,omitempty" binding:"omitempty,min=16,max=64"`
+
+	// Optional; how the device was connected when it recorded the heartbeat.
+	// Not signed, like is_mock.
+	Connectivity string `json:"connectivity,omitempty" binding:"omitempty,oneof=wifi cellular offline_queued"`
+}
+
+func (r *HeartbeatRequest) validate() []apierror.FieldError {
+	fields := validateFix(r.Lat, r.Lng, r.AccuracyM)
+	if r.SigV == 2 {
+		if r.Source == "" {
+			fields = append(fields, apierror.FieldError{Field: "source", Reason: "is required with sig_v 2"})
+		}
+		if r.Nonce == "" {
+			fields = append(fields, apierror.FieldError{Field: "nonce", Reason: "is required with sig_v 2"})
+		}
+	}
+	if strings.Contains(r.Nonce, "|") {
+		fields = append(fields, apierror.FieldError{Field: "nonce", Reason: "must not contain |"})
+	}
+	return fields
+}
+
+// validateFix checks the position a heartbeat reports, all of it required
+func validateFix(lat, lng *float64, accuracyM *int) []apierror.FieldError {
+	var fields []apierror.FieldError
+	switch {
+	case lat == nil:
+		fields = append(fields, apierror.FieldError{Field: "lat", Reason: "is required"})
+	case *lat < -90 || *lat > 90:
+		fields = append(fields, apierror.FieldError{Field: "lat", Reason: "must be between -90 and 90"})
+	}
+	switch {
+	case lng == nil:
+		fields = append(fields, apierror.FieldError{Field: "lng", Reason: "is required"})
+	case *lng < -180 || *lng > 180:
+		fields = append(fields, apierror.FieldError{Field: "lng", Reason: "must be between -180 and 180"})
+	}
+	switch {
+	case accuracyM == nil:
+		fields = append(fields, apierror.FieldError{Field: "accuracy_m", Reason: "is required"})
+	case *accuracyM < 0:
+		fields = append(fields, apierror.FieldError{Field: "accuracy_m", Reason: "must be at least 0"})
+	}
+	return fields
+}
+
+// A user's device may send heartbeatRateLimit heartbeats per heartbeatRateWindow
+const (
+	heartbeatRateWindow = 30 * time.Second
+	heartbeatRateLimit  = 1
+)
+
+// syncEvaluationBudget is how long a heartbeat with evaluate=sync waits for
+// its evaluation before answering "pending"
+const syncEvaluationBudget = 1500 * time.Millisecond
+
+// evaluationSummary is the evaluation returned inline with evaluate=sync
+type evaluationSummary struct {
+	State   string          `json:"state"`
+	Score   int             `json:"score"`
+	Reason  string          `json:"reason"`
+	Reasons []models.Reason `json:"reasons"`
+}
+
+// newHeartbeat is the record stored for a bound request, whichever format it came in
+func newHeartbeat(req *HeartbeatRequest, userID uuid.UUID) *models.Heartbeat {
+	return &models.Heartbeat{
+		ID:         uuid.New(),
+		UserID:     userID,
+		DeviceID:   req.DeviceID,
+		Lat:        *req.Lat,
+		Lng:        *req.Lng,
+		AccuracyM:  *req.AccuracyM,
+		CellInfo:   req.CellInfo,
+		BatteryPct: req.BatteryPct,
+		Speed:      req.Speed,
+		LastGasp:   req.LastGasp,
+		Timestamp:  req.Timestamp,
+		Signature:  req.Signature,
+		CreatedAt:  time.Now(),
+		IsMock:     req.IsMock,
+
+		Connectivity: req.Connectivity,
+	}
 }
 
 // POST /v1/heartbeat
+// Accepts JSON or, with Content-Type application/x-protobuf, a
+// heartbeatpb.SignedHeartbeat; either may be gzipped.
 func (h *HeartbeatHandler) CreateHeartbeat(c *gin.Context) {
 	var req HeartbeatRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	var heartbeat *models.Heartbeat
+	body := hashBody(c)
+	defer func() { h.recordHeartbeatAttempt(c, &req, heartbeat, body) }()
+
+	signed, ok := bindHeartbeat(c, &req)
+	if !ok {
 		return
 	}
 
 	// Parse user ID
 	userID, err := uuid.Parse(req.UserID)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user_id"})
+		middleware.AbortWithError(c, apierror.Invalid("user_id", "must be a valid UUID"))
+		return
+	}
+	if err := services.ValidateCellInfo(services.NormalizeCellInfo(req.CellInfo)); err != nil {
+		middleware.AbortWithError(c, apierror.Invalid("cell_info", err.Error()))
+		return
+	}
+
+	// Locked out after repeated invalid signatures. A LastGasp is still
+	// verified and accepted, and a failed lockout check lets the heartbeat
+	// through: missing an emergency is worse than a few unverified attempts.
+	lockedFor, err := h.guard.Lockout(c.Request.Context(), userID)
+	if err != nil {
+		log.Printf("WARN: Signature lockout check failed for user %s: %v", userID, err)
+	}
+	if lockedFor > 0 && !req.LastGasp {
+		seconds := int(math.Ceil(lockedFor.Seconds()))
+		c.Header("Retry-After", strconv.Itoa(seconds))
+		middleware.AbortWithError(c, apierror.TooManyRequests(
+			fmt.Sprintf("heartbeats for this user are locked for %ds after repeated invalid signatures", seconds)))
 		return
 	}
 
 	// Rate limiting check
-	allowed, err := h.redis.CheckRateLimit(c.Request.Context(), userID, 30*time.Second, 1)
+	allowed, err := h.redis.CheckRateLimit(c.Request.Context(), userID, req.DeviceID, heartbeatRateWindow, heartbeatRateLimit)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "rate limit check failed"})
+		middleware.AbortWithError(c, apierror.Internal("rate limit check failed", err))
 		return
 	}
 	if !allowed {
-		c.JSON(http.StatusTooManyRequests, gin.H{"error": "rate limit exceeded"})
+		middleware.AbortWithError(c, apierror.TooManyRequests("rate limit exceeded"))
 		return
 	}
 
 	// Verify user exists
 	user, err := h.postgres.GetUserByID(c.Request.Context(), userID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+		middleware.AbortWithError(c, apierror.Internal("database error", err))
 		return
 	}
 	if user == nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		middleware.AbortWithError(c, apierror.NotFound("user not found"))
 		return
 	}
 
-	// Verify signature (excluding signature field itself)
-	reqForVerification := map[string]interface{}{
-		"user_id":     req.UserID,
-		"timestamp":   req.Timestamp.Unix(),
-		"lat":         req.Lat,
-		"lng":         req.Lng,
-		"accuracy_m":  req.AccuracyM,
-		"cell_info":   req.CellInfo,
-		"battery_pct": req.BatteryPct,
-		"speed":       req.Speed,
-		"last_gasp":   req.LastGasp,
+	heartbeat = newHeartbeat(&req, userID)
+
+	cfg := h.cfg.Current()
+	secrets := h.cfg.HMACSecrets()
+
+	if signed != nil {
+		if !h.verifyProtobufSignature(c, &req, heartbeat, signed, secrets) {
+			return
+		}
+	} else if req.SigV == 2 {
+		if !h.verifySignatureV2(c, &req, heartbeat, secrets) {
+			return
+		}
+	} else {
+		if !cfg.SignatureV1Sunset.IsZero() && !time.Now().Before(cfg.SignatureV1Sunset) {
+			middleware.AbortWithError(c, apierror.Unauthorized("signature version 1 is no longer accepted; sign with sig_v 2"))
+			return
+		}
+
+		// Verify signature: canonical v1 string first, then the legacy JSON map
+		switch {
+		case utils.VerifyStringSignatureAny(utils.CanonicalHeartbeatString(heartbeat), req.Signature, secrets):
+		case cfg.LegacySignaturesEnabled && h.verifyLegacySignature(&req, secrets):
+			c.Header("Deprecation", "true")
+			// The legacy signature doesn't cover device_id
+			heartbeat.DeviceID = ""
+		default:
+			h.recordSignatureFailure(c, userID)
+			middleware.AbortWithError(c, apierror.Unauthorized("invalid signature"))
+			return
+		}
+		if !cfg.SignatureV1Sunset.IsZero() {
+			c.Header("Sunset", cfg.SignatureV1Sunset.UTC().Format(http.TimeFormat))
+		}
 	}
 
-	if !utils.VerifySignature(reqForVerification, req.Signature, h.cfg.HMACSecret) {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid signature"})
+	// Normalized only now: the signature covers the network type as sent
+	heartbeat.CellInfo = services.NormalizeCellInfo(heartbeat.CellInfo)
+
+	// A signed app heartbeat can only be an HTTP one, whatever it claims
+	err = services.BindSource(heartbeat, services.SourceEvidence{
+		Channel:        services.SourceHTTP,
+		ClaimedSource:  req.Source,
+		SignatureValid: true,
+	})
+	if err != nil {
+		middleware.AbortWithError(c, apierror.Invalid("source", err.Error()))
 		return
 	}
 
-	// Create heartbeat record
-	heartbeat := &models.Heartbeat{
-		ID:         uuid.New(),
-		UserID:     userID,
-		Source:     "http",
-		Lat:        req.Lat,
-		Lng:        req.Lng,
-		AccuracyM:  req.AccuracyM,
-		CellInfo:   req.CellInfo,
-		BatteryPct: req.BatteryPct,
-		Speed:      req.Speed,
-		LastGasp:   req.LastGasp,
-		Timestamp:  req.Timestamp,
-		Signature:  req.Signature,
-		CreatedAt:  time.Now(),
+	// A copy of a heartbeat already received over SMS is stored for history only
+	h.evaluator.MarkDuplicate(c.Request.Context(), heartbeat)
+	h.spoof.Inspect(c.Request.Context(), heartbeat)
+	h.evaluator.MarkBackfill(c.Request.Context(), heartbeat)
+	// Checked on the precise fix above, stored coarse if the user asked
+	h.evaluator.CoarsenLocation(c.Request.Context(), user.Settings, heartbeat)
+
+	// Buffered path: acknowledge now, the writer flushes and evaluates later
+	if h.buffer != nil {
+		if err := h.buffer.Enqueue(heartbeat); err != nil {
+			h.evaluator.ReleaseFingerprint(c.Request.Context(), heartbeat)
+			c.Header("Retry-After", "5")
+			middleware.AbortWithError(c, apierror.Unavailable("server busy, retry later"))
+			return
+		}
+	} else if err := h.postgres.CreateHeartbeat(c.Request.Context(), heartbeat); err != nil {
+		h.evaluator.ReleaseFingerprint(c.Request.Context(), heartbeat)
+		middleware.AbortWithError(c, apierror.Internal("failed to store heartbeat", err))
+		return
 	}
+	h.evaluator.TrackDevice(c.Request.Context(), heartbeat)
+
+	// A LastGasp opens or re-arms the wait and any other heartbeat ends it.
+	// One that arrives after newer heartbeats is already over.
+	h.evaluator.TrackLastGasp(c.Request.Context(), heartbeat)
+
+	inline := req.Evaluate == "sync" || c.Query("evaluate") == "sync"
 
-	// Store heartbeat
-	if err := h.postgres.CreateHeartbeat(c.Request.Context(), heartbeat); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to store heartbeat"})
+	// Stored for history only; the original was or will be evaluated
+	if heartbeat.DuplicateOf != nil {
+		response := gin.H{
+			"status":       "success",
+			"message":      "heartbeat stored as duplicate",
+			"id":           heartbeat.ID,
+			"duplicate_of": heartbeat.DuplicateOf,
+		}
+		if inline {
+			response["evaluation"] = "skipped"
+		}
+		h.addNextInterval(c, userID, response)
+		respondHeartbeat(c, http.StatusOK, response)
 		return
 	}
 
-	// Handle LastGasp
-	if req.LastGasp {
-		lastGasp := &models.LastGasp{
-			ID:        uuid.New(),
-			UserID:    userID,
-			Lat:       req.Lat,
-			Lng:       req.Lng,
-			AccuracyM: req.AccuracyM,
-			CellInfo:  req.CellInfo,
-			CreatedAt: time.Now(),
-			ExpiryTs:  time.Now().Add(time.Duration(h.cfg.LastGaspTimeoutSeconds) * time.Second),
+	// Stored for history only; the state already reflects a newer heartbeat
+	if heartbeat.Backfill {
+		response := gin.H{
+			"status":   "success",
+			"message":  "heartbeat stored as backfill",
+			"id":       heartbeat.ID,
+			"backfill": true,
 		}
-		if err := h.postgres.CreateLastGasp(c.Request.Context(), lastGasp); err != nil {
-			// Log error but don't fail the request
+		if inline {
+			response["evaluation"] = "skipped"
 		}
+		h.addNextInterval(c, userID, response)
+		respondHeartbeat(c, http.StatusOK, response)
+		return
 	}
 
-	// Trigger safety evaluation (async)
-	go func() {
-		ctx := c.Copy().Request.Context()
-		if _, err := h.evaluator.EvaluateUserSafety(ctx, userID); err != nil {
-			// Log error (in production, use proper logging)
+	if h.buffer != nil {
+		response := gin.H{
+			"status":  "accepted",
+			"message": "heartbeat queued",
+			"id":      heartbeat.ID,
 		}
-	}()
+		// Evaluation runs after the batch is written, so it can't be awaited here
+		if inline {
+			response["evaluation"] = "pending"
+		}
+		h.addNextInterval(c, userID, response)
+		respondHeartbeat(c, http.StatusAccepted, response)
+		return
+	}
 
-	c.JSON(http.StatusOK, gin.H{
+	// Trigger safety evaluation. It runs detached either way; a sync caller
+	// waits up to the budget and otherwise gets "pending" while it finishes.
+	done := h.evaluator.EvaluateHeartbeatAsync(heartbeat)
+
+	response := gin.H{
 		"status":  "success",
 		"message": "heartbeat received",
 		"id":      heartbeat.ID,
+	}
+	if inline {
+		response["evaluation"] = awaitEvaluation(done, syncEvaluationBudget)
+	}
+	h.addNextInterval(c, userID, response)
+
+	respondHeartbeat(c, http.StatusOK, response)
+}
+
+// addNextInterval adds the heartbeat interval currently advised to the user.
+// After a sync evaluation that finished it is the new advice; otherwise the
+// advice from the previous evaluation, which the client should follow until
+// its next heartbeat. Left out when there is none yet.
+func (h *HeartbeatHandler) addNextInterval(c *gin.Context, userID uuid.UUID, response gin.H) {
+	state, err := h.redis.GetUserState(c.Request.Context(), userID)
+	if err != nil || state == nil || state.NextIntervalSeconds == 0 {
+		return
+	}
+	response["next_interval_seconds"] = state.NextIntervalSeconds
+}
+
+// DELETE /admin/users/:user_id/signature-lockout
+// Lifts a signature lockout early, e.g. once a misconfigured client is fixed.
+// An org admin may only lift their members' lockouts.
+func (h *HeartbeatHandler) ClearSignatureLockout(c *gin.Context) {
+	userID := params.UserID(c)
+
+	orgID, ok := adminOrg(c)
+	if !ok {
+		return
+	}
+	if orgID != nil {
+		user, err := h.postgres.GetUserByID(c.Request.Context(), userID)
+		if err != nil {
+			middleware.AbortWithError(c, apierror.Internal("database error", err))
+			return
+		}
+		if user == nil || user.OrgID == nil || *user.OrgID != *orgID {
+			middleware.AbortWithError(c, apierror.NotFound("user not found"))
+			return
+		}
+	}
+
+	if err := h.guard.Clear(c.Request.Context(), userID); err != nil {
+		middleware.AbortWithError(c, apierror.Internal("failed to clear lockout", err))
+		return
+	}
+
+	recordAudit(c, h.audit, &models.AuditEvent{
+		Action:        services.AuditSignatureUnlock,
+		ObjectType:    "user",
+		ObjectID:      userID.String(),
+		SubjectUserID: &userID,
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": "signature lockout cleared",
 	})
 }
 
-// GET /v1/user/:id/status
-func (h *HeartbeatHandler) GetUserStatus(c *gin.Context) {
-	userID, err := uuid.Parse(c.Param("id"))
+// recordSignatureFailure counts an invalid signature and audits a lockout it starts
+func (h *HeartbeatHandler) recordSignatureFailure(c *gin.Context, userID uuid.UUID) {
+	lockout, err := h.guard.RecordFailure(c.Request.Context(), userID, c.ClientIP())
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user_id"})
+		log.Printf("WARN: Failed to record signature failure for user %s: %v", userID, err)
+		return
+	}
+	if lockout == 0 {
 		return
 	}
 
-	// Get user state from Redis
-	state, err := h.redis.GetUserState(c.Request.Context(), userID)
+	recordAudit(c, h.audit, &models.AuditEvent{
+		Action:        services.AuditSignatureLockout,
+		ObjectType:    "user",
+		ObjectID:      userID.String(),
+		SubjectUserID: &userID,
+		Metadata:      map[string]interface{}{"lockout_seconds": int(lockout.Seconds())},
+	})
+}
+
+// awaitEvaluation waits up to budget for an evaluation: its summary,
+// "pending" if it is still running, or "failed"
+func awaitEvaluation(done <-chan services.EvaluationOutcome, budget time.Duration) interface{} {
+	timer := time.NewTimer(budget)
+	defer timer.Stop()
+
+	select {
+	case outcome := <-done:
+		if outcome.Superseded {
+			return "superseded"
+		}
+		if outcome.Err != nil || outcome.Result == nil {
+			return "failed"
+		}
+		return evaluationSummary{
+			State:   outcome.Result.State,
+			Score:   outcome.Result.Score,
+			Reason:  outcome.Result.Reason,
+			Reasons: outcome.Result.Reasons,
+		}
+	case <-timer.C:
+		return "pending"
+	}
+}
+
+// verifySignatureV2 checks a version 2 signature and claims its nonce, so the
+// same signed heartbeat is accepted once. The signature covers the claimed
+// source, which BindSource then holds to the endpoint it arrived on, so a
+// signature made for one channel can't be replayed through another.
+func (h *HeartbeatHandler) verifySignatureV2(c *gin.Context, req *HeartbeatRequest, heartbeat *models.Heartbeat, secrets []string) bool {
+	if !utils.VerifyStringSignatureAny(utils.CanonicalHeartbeatStringV2(heartbeat, req.Source, req.Nonce), req.Signature, secrets) {
+		h.recordSignatureFailure(c, heartbeat.UserID)
+		middleware.AbortWithError(c, apierror.Unauthorized("invalid signature"))
+		return false
+	}
+	return h.claimNonce(c, heartbeat, req.Nonce)
+}
+
+// verifyProtobufSignature checks the signature of a protobuf heartbeat, an
+// HMAC over the serialized heartbeat exactly as received, then claims its
+// nonce as version 2 does. Every field is covered, neighbor cells included.
+func (h *HeartbeatHandler) verifyProtobufSignature(c *gin.Context, req *HeartbeatRequest, heartbeat *models.Heartbeat, signed []byte, secrets []string) bool {
+	if !utils.VerifyStringSignatureAny(string(signed), req.Signature, secrets) {
+		h.recordSignatureFailure(c, heartbeat.UserID)
+		middleware.AbortWithError(c, apierror.Unauthorized("invalid signature"))
+		return false
+	}
+	return h.claimNonce(c, heartbeat, req.Nonce)
+}
+
+// claimNonce claims the nonce of a verified heartbeat. A nonce that can't be
+// checked lets the heartbeat through, as a lockout check does.
+func (h *HeartbeatHandler) claimNonce(c *gin.Context, heartbeat *models.Heartbeat, nonce string) bool {
+	ctx := c.Request.Context()
+
+	// A nonce is only remembered for so long; anything older could be a replay
+	ttl := time.Duration(h.cfg.Current().HeartbeatNonceTTLHours) * time.Hour
+	if time.Since(heartbeat.Timestamp) > ttl {
+		middleware.AbortWithError(c, apierror.Invalid("timestamp", "is too old to accept"))
+		return false
+	}
+
+	claimed, err := h.redis.ClaimHeartbeatNonce(ctx, heartbeat.UserID, nonce, ttl)
+	if err != nil {
+		log.Printf("WARN: Nonce check failed for user %s: %v", heartbeat.UserID, err)
+		return true
+	}
+	if !claimed {
+		log.Printf("WARN: Replayed heartbeat nonce for user %s from %s", heartbeat.UserID, c.ClientIP())
+		middleware.AbortWithError(c, apierror.Conflict("nonce already used"))
+		return false
+	}
+	return true
+}
+
+// verifyLegacySignature checks the pre-v1 scheme, an HMAC over a re-marshaled JSON map.
+// Kept during the deprecation window; see LEGACY_SIGNATURES_ENABLED.
+func (h *HeartbeatHandler) verifyLegacySignature(req *HeartbeatRequest, secrets []string) bool {
+	reqForVerification := map[string]interface{}{
+		"user_id":     req.UserID,
+		"timestamp":   req.Timestamp.Unix(),
+		"lat":         *req.Lat,
+		"lng":         *req.Lng,
+		"accuracy_m":  *req.AccuracyM,
+		"cell_info":   req.CellInfo,
+		"battery_pct": req.BatteryPct,
+		"speed":       req.Speed,
+		"last_gasp":   req.LastGasp,
+	}
+	for _, secret := range secrets {
+		if utils.VerifySignature(reqForVerification, req.Signature, secret) {
+			return true
+		}
+	}
+	return false
+}
+
+// deviceView is a device as listed to the user: when it last reported and
+// its battery, but not where it was
+type deviceView struct {
+	DeviceID         string    `json:"device_id"`
+	Source           string    `json:"source"`
+	LastHeartbeat    time.Time `json:"last_heartbeat"`
+	HeartbeatID      uuid.UUID `json:"heartbeat_id"`
+	BatteryPct       *int      `json:"battery_pct,omitempty"`
+	SecondsSince     int       `json:"seconds_since"`
+	DrivesEvaluation bool      `json:"drives_evaluation"` // the freshest device, whose heartbeat is scored
+}
+
+// GET /v1/user/:user_id/devices
+// The user's devices, most recently seen first
+func (h *HeartbeatHandler) ListDevices(c *gin.Context) {
+	user, ok := loadAuthorizedUser(c, h.postgres)
+	if !ok {
+		return
+	}
+
+	devices, err := h.redis.GetDevices(c.Request.Context(), user.ID)
+	if err != nil {
+		middleware.AbortWithError(c, apierror.Internal("failed to get devices", err))
+		return
+	}
+
+	now := time.Now()
+	views := make([]deviceView, len(devices))
+	for i, d := range devices {
+		views[i] = deviceView{
+			DeviceID:         d.DeviceID,
+			Source:           d.Source,
+			LastHeartbeat:    d.LastSeen,
+			HeartbeatID:      d.HeartbeatID,
+			BatteryPct:       d.BatteryPct,
+			SecondsSince:     int(now.Sub(d.LastSeen).Seconds()),
+			DrivesEvaluation: i == 0,
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"user_id": user.ID,
+		"devices": views,
+	})
+}
+
+// statusStateKey and statusUserKey hold the state and authorized user
+// StatusVersion read, for GetUserStatus to reuse
+const (
+	statusStateKey = "status.state"
+	statusUserKey  = "status.user"
+)
+
+// StatusVersion versions GET /status by when the user's state was last saved,
+// which every evaluation does, and their protection status. A status may be
+// reused for the heartbeat interval advised to the user, but never while
+// they are AT_RISK, in ALERT or waiting on a LastGasp: then every poll
+// revalidates.
+func (h *HeartbeatHandler) StatusVersion(c *gin.Context) (string, time.Duration, error) {
+	user, ok := loadAuthorizedUser(c, h.postgres)
+	if !ok {
+		return "", 0, nil // the error response is written and the chain aborted
+	}
+	c.Set(statusUserKey, user)
+	state, err := h.evaluator.CurrentState(c.Request.Context(), user.ID)
+	if err != nil || state == nil {
+		return "", 0, err
+	}
+	c.Set(statusStateKey, state)
+
+	version := strconv.FormatInt(state.UpdatedAt.UnixNano(), 36) + "-" + services.ProtectionStatusOf(user)
+	switch state.State {
+	case services.StateAtRisk, services.StateAlert, services.StateWaitLastGasp:
+		return version, 0, nil
+	}
+	interval := state.NextIntervalSeconds
+	if interval == 0 {
+		interval = h.cfg.Current().HeartbeatIntervalSeconds
+	}
+	return version, time.Duration(interval) * time.Second, nil
+}
+
+// GET /v1/user/:user_id/status
+// For the user, their contacts and guardians, admins and their
+// organization's admins: the state carries their score, reasons and roughly
+// where they are
+func (h *HeartbeatHandler) GetUserStatus(c *gin.Context) {
+	var user *models.User
+	if v, ok := c.Get(statusUserKey); ok {
+		user = v.(*models.User)
+	} else if user, ok = loadAuthorizedUser(c, h.postgres); !ok {
+		return
+	}
+	userID := user.ID
+
+	// Cached in Redis, read through from the recorded transitions
+	var state *models.UserState
+	var err error
+	if v, ok := c.Get(statusStateKey); ok {
+		state = v.(*models.UserState)
+	} else {
+		state, err = h.evaluator.CurrentState(c.Request.Context(), userID)
+	}
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get state"})
+		middleware.AbortWithError(c, apierror.Internal("failed to get state", err))
 		return
 	}
 
+	// Whether the user has anyone of their own to alert
+	protection := services.ProtectionStatusOf(user)
+
 	if state == nil {
-		c.JSON(http.StatusOK, gin.H{
+		response := gin.H{
 			"user_id": userID,
 			"state":   "UNKNOWN",
 			"message": "No data available",
-		})
+		}
+		if protection != "" {
+			response["protection_status"] = protection
+		}
+		c.JSON(http.StatusOK, response)
+		return
+	}
+
+	// A burst of unverified heartbeats is the visible sign of someone spoofing SMS
+	trust, err := h.postgres.CountHeartbeatsByTrust(c.Request.Context(), userID, time.Now().Add(-time.Hour))
+	if err != nil {
+		middleware.AbortWithError(c, apierror.Internal("failed to count heartbeats", err))
 		return
 	}
+	response := userStatusResponse{
+		UserState:        state,
+		RecentTrust:      trust,
+		RoamingNote:      services.RoamingNote(state.Roaming),
+		ProtectionStatus: protection,
+	}
+
+	if state.LastGaspActive {
+		lastGasp, err := h.postgres.GetActiveLastGasp(c.Request.Context(), userID)
+		if err != nil {
+			middleware.AbortWithError(c, apierror.Internal("failed to get lastgasp", err))
+			return
+		}
+		if lastGasp != nil {
+			view := newLastGaspView(*lastGasp)
+			response.LastGasp = &view
+
+			recordAudit(c, h.audit, &models.AuditEvent{
+				Action:        services.AuditStatusView,
+				ObjectType:    "last_gasp",
+				ObjectID:      lastGasp.ID.String(),
+				SubjectUserID: &userID,
+			})
+		}
+	}
+
+	c.JSON(http.StatusOK, response)
+}
 
-	c.JSON(http.StatusOK, state)
+// userStatusResponse is the UserState plus LastGasp details for authorized
+// callers, the trust levels of recent heartbeats, where the user is roaming
+// and whether they have anyone to alert
+type userStatusResponse struct {
+	*models.UserState
+	LastGasp         *lastGaspView  `json:"last_gasp,omitempty"`
+	RecentTrust      map[string]int `json:"recent_trust"`                // heartbeats in the last hour, by trust level
+	RoamingNote      string         `json:"roaming_note,omitempty"`      // e.g. "currently roaming in Benin"
+	ProtectionStatus string         `json:"protection_status,omitempty"` // complete | incomplete, see services.ProtectionStatusOf
 }
 
-// POST /v1/alert/:id/resolve
+// POST /v1/alert/:alert_id/resolve
+// Only the alerted user, an admin or their organization's admin may resolve
+// an alert; it also ends its share links and contact notifications
 func (h *HeartbeatHandler) ResolveAlert(c *gin.Context) {
-	alertID, err := uuid.Parse(c.Param("id"))
+	alertID := params.Get(c, params.Alert)
+
+	alert, err := h.postgres.GetAlertByID(c.Request.Context(), alertID)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid alert_id"})
+		middleware.AbortWithError(c, apierror.Internal("database error", err))
+		return
+	}
+	claims := middleware.Principal(c)
+	var owner *models.User
+	if alert != nil && claims != nil && claims.Role == utils.RoleOrgAdmin {
+		if owner, err = h.postgres.GetUserByID(c.Request.Context(), alert.UserID); err != nil {
+			middleware.AbortWithError(c, apierror.Internal("database error", err))
+			return
+		}
+	}
+	if !mayManageAlert(claims, alert, owner) {
+		// Same response for missing and foreign alerts so IDs can't be probed
+		middleware.AbortWithError(c, apierror.NotFound("alert not found"))
 		return
 	}
 
 	if err := h.postgres.ResolveAlert(c.Request.Context(), alertID); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to resolve alert"})
+		middleware.AbortWithError(c, apierror.Internal("failed to resolve alert", err))
 		return
 	}
 
+	recordAudit(c, h.audit, &models.AuditEvent{
+		Action:        services.AuditAlertResolve,
+		ObjectType:    "alert",
+		ObjectID:      alertID.String(),
+		SubjectUserID: &alert.UserID,
+	})
+
+	// Channels that were told about the alert hear that it's over, once
+	if alert.ResolvedAt == nil {
+		h.outbox.EnqueueResolved(c.Request.Context(), alert)
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"status":  "success",
 		"message": "alert resolved",
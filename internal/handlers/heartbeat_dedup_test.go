@@ -0,0 +1,210 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/config"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/database"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/middleware"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/params"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/services"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/utils"
+)
+
+const (
+	ingestionHMACSecret   = "dedup-test-secret"
+	ingestionWebhookToken = "at-webhook-token"
+)
+
+// ingestionRouter serves the HTTP heartbeat endpoint, the Africa's Talking
+// SMS webhook and diagnostics in front of an evaluator whose pool is never
+// started, so the evaluations the heartbeats ask for stay queued to be counted
+func ingestionRouter(t *testing.T, postgres *database.PostgresDB, redis *database.RedisDB) (*gin.Engine, *services.SafetyEvaluator) {
+	t.Helper()
+	cfg := config.NewStore(&config.Config{
+		HMACSecret:                    ingestionHMACSecret,
+		HeartbeatDedupWindowSeconds:   600,
+		HeartbeatNonceTTLHours:        72,
+		SignatureFailureThreshold:     5,
+		SignatureFailureWindowSeconds: 60,
+		SignatureLockoutSeconds:       120,
+		EvaluationWorkers:             1,
+		EvaluationQueueSize:           10,
+		EvaluationStaleSeconds:        60,
+		SMSHeartbeatAck:               "lastgasp",
+		AfricasTalkingWebhookToken:    ingestionWebhookToken,
+	})
+	evaluator := services.NewSafetyEvaluator(cfg, postgres, redis, nil, nil, nil, nil, nil, nil, nil, nil, services.NewHealthRegistry())
+	spoof := services.NewSpoofDetector(postgres, nil)
+	guard := services.NewSignatureGuard(cfg, postgres, redis, nil)
+
+	heartbeats := NewHeartbeatHandler(cfg, postgres, redis, evaluator, nil, nil, spoof, guard, nil)
+	sms := NewSMSHandler(cfg, postgres, redis, evaluator, nil, spoof, nil, nil, nil, nil, nil, nil)
+	router := testRouter()
+	router.POST("/v1/heartbeat", heartbeats.CreateHeartbeat)
+	router.POST("/v1/sms/webhook/:provider", sms.HandleIncomingSMS)
+	router.GET("/v1/user/:user_id/diagnostics", params.UUID(params.User), middleware.RequireAuth(testSecret), heartbeats.GetDiagnostics)
+	return router, evaluator
+}
+
+// dedupHeartbeat is the heartbeat a phone in poor coverage sends over both
+// channels, taken at a fraction of a second the SMS copy loses
+func dedupHeartbeat(user *models.User) *models.Heartbeat {
+	battery := 41
+	return &models.Heartbeat{
+		UserID:     user.ID,
+		Timestamp:  time.Now().UTC().Add(-time.Minute).Truncate(time.Second).Add(734 * time.Millisecond),
+		Lat:        6.5243793,
+		Lng:        3.3792057,
+		AccuracyM:  35,
+		CellInfo:   models.CellInfo{MCC: 621, MNC: 20, CID: 12345, LAC: 678, RSSI: -101, NetworkType: "EDGE"},
+		BatteryPct: &battery,
+	}
+}
+
+// heartbeatRequest is hb as the app posts it, signed with sig_v 1
+func heartbeatRequest(hb *models.Heartbeat) HeartbeatRequest {
+	return HeartbeatRequest{
+		UserID:     hb.UserID.String(),
+		Timestamp:  hb.Timestamp,
+		Lat:        &hb.Lat,
+		Lng:        &hb.Lng,
+		AccuracyM:  &hb.AccuracyM,
+		CellInfo:   hb.CellInfo,
+		BatteryPct: hb.BatteryPct,
+		DeviceID:   hb.DeviceID,
+		Signature:  utils.SignString(utils.CanonicalHeartbeatString(hb), ingestionHMACSecret),
+	}
+}
+
+func postHeartbeat(t *testing.T, router http.Handler, req HeartbeatRequest) *httptest.ResponseRecorder {
+	t.Helper()
+	body, err := json.Marshal(req)
+	if err != nil {
+		t.Fatalf("json.Marshal: %v", err)
+	}
+	return send(t, router, http.MethodPost, "/v1/heartbeat", string(body), nil)
+}
+
+// sendHTTP posts hb and returns the stored heartbeat's ID and the original
+// it was linked to, if any
+func sendHTTP(t *testing.T, router http.Handler, hb *models.Heartbeat) (uuid.UUID, *uuid.UUID) {
+	t.Helper()
+	w := postHeartbeat(t, router, heartbeatRequest(hb))
+	var resp struct {
+		ID          uuid.UUID  `json:"id"`
+		DuplicateOf *uuid.UUID `json:"duplicate_of"`
+	}
+	if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &resp) != nil {
+		t.Fatalf("HTTP heartbeat = %d %s", w.Code, w.Body)
+	}
+	return resp.ID, resp.DuplicateOf
+}
+
+// smsPayload is hb as the SMS fallback texts it, signed
+func smsPayload(hb *models.Heartbeat) string {
+	payload := strings.TrimSuffix(services.NewSMSParser().BuildSMSPayload(hb), "sig=")
+	return payload + "sig=" + utils.SignString(strings.TrimSuffix(payload, ";"), ingestionHMACSecret)
+}
+
+// textSMS delivers a text from a phone through the Africa's Talking webhook
+func textSMS(t *testing.T, router http.Handler, from, text string) {
+	t.Helper()
+	form := url.Values{"from": {from}, "to": {"384"}, "text": {text}, "id": {uuid.NewString()}}
+	req := httptest.NewRequest(http.MethodPost, "/v1/sms/webhook/africastalking?token="+ingestionWebhookToken, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("SMS = %d %s", w.Code, w.Body)
+	}
+}
+
+// sendSMS texts hb from the user's phone
+func sendSMS(t *testing.T, router http.Handler, user *models.User, hb *models.Heartbeat) {
+	t.Helper()
+	textSMS(t, router, user.Phone, smsPayload(hb))
+}
+
+// The same heartbeat over HTTP and SMS, in either order: one evaluation,
+// one heartbeat in history and state, and the later copy stored linked to
+// the first
+func TestHeartbeatOverHTTPAndSMS(t *testing.T) {
+	postgres, redis := testPostgres(t), testRedis(t)
+	ctx := context.Background()
+
+	for _, httpFirst := range []bool{true, false} {
+		name := map[bool]string{true: "HTTP first", false: "SMS first"}[httpFirst]
+		t.Run(name, func(t *testing.T) {
+			router, evaluator := ingestionRouter(t, postgres, redis)
+			user := createTestUser(t, postgres)
+			hb := dedupHeartbeat(user)
+
+			var httpID uuid.UUID
+			var linked *uuid.UUID
+			if httpFirst {
+				httpID, linked = sendHTTP(t, router, hb)
+				sendSMS(t, router, user, hb)
+			} else {
+				sendSMS(t, router, user, hb)
+				httpID, linked = sendHTTP(t, router, hb)
+			}
+
+			if n, coalesced := evaluator.QueueLen(), evaluator.Coalesced(); n != 1 || coalesced != 0 {
+				t.Errorf("%d evaluations queued, %d coalesced; want exactly one asked for", n, coalesced)
+			}
+
+			from, to := hb.Timestamp.Add(-time.Minute), hb.Timestamp.Add(time.Minute)
+			effective, err := postgres.GetHeartbeatsBetween(ctx, user.ID, from, to, 10, false)
+			if err != nil {
+				t.Fatalf("GetHeartbeatsBetween: %v", err)
+			}
+			stored, err := postgres.GetHeartbeatsBetween(ctx, user.ID, from, to, 10, true)
+			if err != nil {
+				t.Fatalf("GetHeartbeatsBetween: %v", err)
+			}
+			if len(effective) != 1 || len(stored) != 2 {
+				t.Fatalf("%d heartbeats in history, %d stored; want 1 and 2", len(effective), len(stored))
+			}
+			original := effective[0]
+			var duplicate models.Heartbeat
+			for _, s := range stored {
+				if s.ID != original.ID {
+					duplicate = s
+				}
+			}
+			if duplicate.DuplicateOf == nil || *duplicate.DuplicateOf != original.ID {
+				t.Errorf("copy linked to %v, want %s", duplicate.DuplicateOf, original.ID)
+			}
+
+			wantSource := services.SourceHTTP
+			if !httpFirst {
+				wantSource = services.SourceSMS
+				if linked == nil || *linked != original.ID || duplicate.ID != httpID {
+					t.Errorf("HTTP response linked to %v, want %s", linked, original.ID)
+				}
+			} else if linked != nil || original.ID != httpID {
+				t.Errorf("HTTP original %s answered as a copy of %v", httpID, linked)
+			}
+			if original.Source != wantSource {
+				t.Errorf("original came over %q, want %q", original.Source, wantSource)
+			}
+
+			latest, err := postgres.GetLatestHeartbeat(ctx, user.ID)
+			if err != nil || latest == nil || latest.ID != original.ID {
+				t.Errorf("latest heartbeat = %+v, %v; want the original", latest, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,134 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/google/uuid"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/apierror"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/heartbeatpb"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/middleware"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+)
+
+// bindHeartbeat binds a heartbeat sent as JSON or, with Content-Type
+// application/x-protobuf, as a heartbeatpb.SignedHeartbeat. For protobuf it
+// returns the serialized heartbeat the signature covers; nil for JSON. It
+// writes the error response itself.
+func bindHeartbeat(c *gin.Context, req *HeartbeatRequest) ([]byte, bool) {
+	if c.ContentType() != binding.MIMEPROTOBUF {
+		return nil, bindStrict(c, req)
+	}
+
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			middleware.AbortWithError(c, apierror.PayloadTooLarge("request body is too large"))
+			return nil, false
+		}
+		middleware.AbortWithError(c, apierror.BadRequest("failed to read request body").WithCause(err))
+		return nil, false
+	}
+	var signed heartbeatpb.SignedHeartbeat
+	var hb heartbeatpb.Heartbeat
+	if err := proto.Unmarshal(body, &signed); err != nil {
+		middleware.AbortWithError(c, apierror.BadRequest("malformed protobuf body").WithCause(err))
+		return nil, false
+	}
+	if err := proto.Unmarshal(signed.Heartbeat, &hb); err != nil {
+		middleware.AbortWithError(c, apierror.BadRequest("malformed protobuf heartbeat").WithCause(err))
+		return nil, false
+	}
+
+	*req = heartbeatFromProto(&hb, signed.Signature)
+	return signed.Heartbeat, checkStrict(c, req, binding.Validator.ValidateStruct(req))
+}
+
+// heartbeatFromProto maps a protobuf heartbeat onto the JSON request. It is
+// always signed as a whole, so it is held to version 2's requirements: a
+// source and a nonce.
+func heartbeatFromProto(hb *heartbeatpb.Heartbeat, signature string) HeartbeatRequest {
+	req := HeartbeatRequest{
+		UserID:       hb.UserId,
+		Lat:          hb.Lat,
+		Lng:          hb.Lng,
+		Speed:        hb.Speed,
+		LastGasp:     hb.LastGasp,
+		IsMock:       hb.IsMock,
+		Evaluate:     hb.Evaluate,
+		Source:       hb.Source,
+		DeviceID:     hb.DeviceId,
+		Signature:    signature,
+		SigV:         2,
+		Nonce:        hb.Nonce,
+		Connectivity: hb.Connectivity,
+	}
+	if hb.Timestamp != nil {
+		req.Timestamp = hb.Timestamp.AsTime()
+	}
+	if hb.AccuracyM != nil {
+		accuracy := int(*hb.AccuracyM)
+		req.AccuracyM = &accuracy
+	}
+	if hb.BatteryPct != nil {
+		battery := int(*hb.BatteryPct)
+		req.BatteryPct = &battery
+	}
+	if cell := hb.CellInfo; cell != nil {
+		req.CellInfo = models.CellInfo{
+			MCC:         int(cell.Mcc),
+			MNC:         int(cell.Mnc),
+			CID:         models.CellIdentifier(cell.Cid),
+			LAC:         models.CellIdentifier(cell.Lac),
+			RSSI:        int(cell.Rssi),
+			NetworkType: cell.NetworkType,
+		}
+		for _, n := range cell.Neighbors {
+			req.CellInfo.Neighbors = append(req.CellInfo.Neighbors, models.NeighborCell{
+				CID:  models.CellIdentifier(n.Cid),
+				RSSI: int(n.Rssi),
+			})
+		}
+	}
+	return req
+}
+
+// respondHeartbeat answers with the response as protobuf when the client's
+// Accept prefers application/x-protobuf, and as JSON otherwise
+func respondHeartbeat(c *gin.Context, status int, response gin.H) {
+	if c.NegotiateFormat(gin.MIMEJSON, binding.MIMEPROTOBUF) != binding.MIMEPROTOBUF {
+		c.JSON(status, response)
+		return
+	}
+
+	out := &heartbeatpb.HeartbeatResponse{}
+	out.Status, _ = response["status"].(string)
+	out.Message, _ = response["message"].(string)
+	out.Backfill, _ = response["backfill"].(bool)
+	if id, ok := response["id"].(uuid.UUID); ok {
+		out.Id = id.String()
+	}
+	if interval, ok := response["next_interval_seconds"].(int); ok {
+		out.NextIntervalSeconds = int32(interval)
+	}
+	switch evaluation := response["evaluation"].(type) {
+	case string:
+		out.EvaluationStatus = evaluation
+	case evaluationSummary:
+		out.Evaluation = &heartbeatpb.Evaluation{
+			State:  evaluation.State,
+			Score:  int32(evaluation.Score),
+			Reason: evaluation.Reason,
+		}
+		for _, reason := range evaluation.Reasons {
+			out.Evaluation.ReasonCodes = append(out.Evaluation.ReasonCodes, reason.Code)
+		}
+	}
+	c.ProtoBuf(status, out)
+}
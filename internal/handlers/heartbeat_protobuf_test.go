@@ -0,0 +1,168 @@
+package handlers
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/heartbeatpb"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/middleware"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+)
+
+// sampleHeartbeat is a heartbeat with every field set, neighbor cells
+// included: the ~700 byte JSON a 2G client sends
+func sampleHeartbeat() *heartbeatpb.Heartbeat {
+	lat, lng, speed := 6.524379, 3.379206, 42.5
+	accuracy, battery := int32(18), int32(64)
+	cell := &heartbeatpb.CellInfo{Mcc: 621, Mnc: 20, Cid: 68719476735, Lac: 40021, Rssi: -87, NetworkType: "LTE"}
+	for i := int64(0); i < 6; i++ {
+		cell.Neighbors = append(cell.Neighbors, &heartbeatpb.NeighborCell{Cid: 2011500 + i*37, Rssi: -95 - int32(i)})
+	}
+	return &heartbeatpb.Heartbeat{
+		UserId:       "0b5c5a0f-3d81-4824-82e1-3803d1056b95",
+		Timestamp:    timestamppb.New(time.Date(2026, 3, 9, 18, 42, 7, 0, time.UTC)),
+		Lat:          &lat,
+		Lng:          &lng,
+		AccuracyM:    &accuracy,
+		CellInfo:     cell,
+		BatteryPct:   &battery,
+		Speed:        &speed,
+		LastGasp:     true,
+		IsMock:       true,
+		Source:       "http",
+		DeviceId:     "pixel-7",
+		Nonce:        "a1b2c3d4e5f60718293a4b5c",
+		Connectivity: "offline_queued",
+		Evaluate:     "sync",
+	}
+}
+
+// heartbeatJSON is hb as the JSON request, signed with sig_v 2
+func heartbeatJSON(t testing.TB, hb *heartbeatpb.Heartbeat) []byte {
+	req := heartbeatFromProto(hb, "c2lnbmF0dXJl")
+	body, err := json.Marshal(req)
+	if err != nil {
+		t.Fatalf("json.Marshal: %v", err)
+	}
+	return body
+}
+
+// heartbeatProto is hb as a signed protobuf request
+func heartbeatProto(t testing.TB, hb *heartbeatpb.Heartbeat) []byte {
+	serialized, err := proto.Marshal(hb)
+	if err != nil {
+		t.Fatalf("proto.Marshal: %v", err)
+	}
+	body, err := proto.Marshal(&heartbeatpb.SignedHeartbeat{Heartbeat: serialized, Signature: "c2lnbmF0dXJl"})
+	if err != nil {
+		t.Fatalf("proto.Marshal: %v", err)
+	}
+	return body
+}
+
+func gzipped(t testing.TB, body []byte) []byte {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(body); err != nil {
+		t.Fatalf("gzip: %v", err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("gzip: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// JSON and protobuf, plain or gzipped, bind to the same stored record
+func TestHeartbeatFormatsConform(t *testing.T) {
+	hb := sampleHeartbeat()
+	jsonBody, protoBody := heartbeatJSON(t, hb), heartbeatProto(t, hb)
+	tests := []struct {
+		name        string
+		contentType string
+		gzip        bool
+		body        []byte
+	}{
+		{"JSON", "application/json", false, jsonBody},
+		{"gzipped JSON", "application/json", true, gzipped(t, jsonBody)},
+		{"protobuf", "application/x-protobuf", false, protoBody},
+		{"gzipped protobuf", "application/x-protobuf", true, gzipped(t, protoBody)},
+	}
+
+	gin.SetMode(gin.TestMode)
+	var records []*models.Heartbeat
+	for _, tt := range tests {
+		var record *models.Heartbeat
+		var bound HeartbeatRequest
+		router := testRouter()
+		router.POST("/v1/heartbeat", middleware.BodyLimit(64<<10), func(c *gin.Context) {
+			if _, ok := bindHeartbeat(c, &bound); !ok {
+				return
+			}
+			record = newHeartbeat(&bound, uuid.MustParse(bound.UserID))
+			c.Status(http.StatusNoContent)
+		})
+		req := httptest.NewRequest(http.MethodPost, "/v1/heartbeat", bytes.NewReader(tt.body))
+		req.Header.Set("Content-Type", tt.contentType)
+		if tt.gzip {
+			req.Header.Set("Content-Encoding", "gzip")
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != http.StatusNoContent {
+			t.Fatalf("%s: status = %d: %s", tt.name, w.Code, w.Body.String())
+		}
+		if bound.Source != "http" || bound.Nonce != hb.Nonce || bound.SigV != 2 || bound.Evaluate != "sync" {
+			t.Errorf("%s: bound source %q, nonce %q, sig_v %d, evaluate %q", tt.name, bound.Source, bound.Nonce, bound.SigV, bound.Evaluate)
+		}
+		if len(record.CellInfo.Neighbors) != 6 {
+			t.Errorf("%s: %d neighbors, want 6", tt.name, len(record.CellInfo.Neighbors))
+		}
+		records = append(records, record)
+	}
+
+	// Only the ID and receipt time are the server's own
+	for _, r := range records {
+		r.ID, r.CreatedAt = uuid.Nil, time.Time{}
+		r.Timestamp = r.Timestamp.UTC()
+	}
+	for i, r := range records[1:] {
+		if !reflect.DeepEqual(r, records[0]) {
+			t.Errorf("%s record = %+v, want %+v as from JSON", tests[i+1].name, r, records[0])
+		}
+	}
+}
+
+// BenchmarkHeartbeatSize reports the size of one heartbeat in each format;
+// run with -bench HeartbeatSize and read bytes/op
+func BenchmarkHeartbeatSize(b *testing.B) {
+	hb := sampleHeartbeat()
+	formats := []struct {
+		name   string
+		encode func() []byte
+	}{
+		{"json", func() []byte { return heartbeatJSON(b, hb) }},
+		{"json_gzip", func() []byte { return gzipped(b, heartbeatJSON(b, hb)) }},
+		{"protobuf", func() []byte { return heartbeatProto(b, hb) }},
+		{"protobuf_gzip", func() []byte { return gzipped(b, heartbeatProto(b, hb)) }},
+	}
+	for _, f := range formats {
+		b.Run(f.name, func(b *testing.B) {
+			var size int
+			for i := 0; i < b.N; i++ {
+				size = len(f.encode())
+			}
+			b.ReportMetric(float64(size), "bytes/op")
+		})
+	}
+}
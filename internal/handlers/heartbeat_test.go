@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/config"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/services"
+)
+
+// A locked-out user's heartbeats are refused with the time left, except a
+// LastGasp, which goes on to be checked like any other heartbeat
+func TestCreateHeartbeatSignatureLockout(t *testing.T) {
+	postgres, redis := testPostgres(t), testRedis(t)
+	cfg := config.NewStore(&config.Config{
+		SignatureFailureThreshold:     1,
+		SignatureFailureWindowSeconds: 60,
+		SignatureLockoutSeconds:       120,
+	})
+	guard := services.NewSignatureGuard(cfg, postgres, redis, nil)
+	h := NewHeartbeatHandler(cfg, postgres, redis, nil, nil, nil, nil, guard, nil)
+	router := testRouter()
+	router.POST("/v1/heartbeat", h.CreateHeartbeat)
+
+	// A user that doesn't exist: past the lockout the request ends in 404
+	userID := uuid.New()
+	if _, err := guard.RecordFailure(context.Background(), userID, "102.89.0.1"); err != nil {
+		t.Fatalf("RecordFailure: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		lastGasp bool
+		want     int
+	}{
+		{"heartbeat", false, http.StatusTooManyRequests},
+		{"LastGasp", true, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := fmt.Sprintf(`{"user_id":%q,"timestamp":%q,"lat":6.5244,"lng":3.3792,"accuracy_m":12,
+				"cell_info":{"mcc":621,"mnc":20,"cid":1234,"lac":56,"rssi":-80,"network_type":"LTE"},
+				"last_gasp":%t,"signature":"c2lnbmF0dXJl"}`, userID, time.Now().UTC().Format(time.RFC3339), tt.lastGasp)
+			w := send(t, router, http.MethodPost, "/v1/heartbeat", body, nil)
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.want, w.Body.String())
+			}
+			retryAfter := w.Header().Get("Retry-After")
+			if tt.lastGasp != (retryAfter == "") {
+				t.Errorf("Retry-After = %q", retryAfter)
+			}
+		})
+	}
+
+}
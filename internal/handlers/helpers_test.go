@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/database"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+)
+
+// Integration tests run against the migrated Postgres of TEST_DATABASE_URL
+// and the Redis of TEST_REDIS_URL, and are skipped without them
+
+func testPostgres(t *testing.T) *database.PostgresDB {
+	t.Helper()
+	url := os.Getenv("TEST_DATABASE_URL")
+	if url == "" {
+		t.Skip("TEST_DATABASE_URL not set")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	db, err := database.NewPostgresDB(ctx, url, 0)
+	if err != nil {
+		t.Fatalf("NewPostgresDB: %v", err)
+	}
+	t.Cleanup(db.Close)
+	return db
+}
+
+// testRedis connects under a namespace of its own, so tests don't share keys
+func testRedis(t *testing.T) *database.RedisDB {
+	t.Helper()
+	url := os.Getenv("TEST_REDIS_URL")
+	if url == "" {
+		t.Skip("TEST_REDIS_URL not set")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	namespace := "test-" + strings.ReplaceAll(uuid.NewString(), "-", "")[:12]
+	r, err := database.NewRedisDB(ctx, url, namespace)
+	if err != nil {
+		t.Fatalf("NewRedisDB: %v", err)
+	}
+	t.Cleanup(func() { r.Close() })
+	return r
+}
+
+// createTestUser stores a user with a fresh phone number
+func createTestUser(t *testing.T, postgres *database.PostgresDB) *models.User {
+	t.Helper()
+	now := time.Now()
+	user := &models.User{ID: uuid.New(), Phone: fmt.Sprintf("+234809%07d", rand.Intn(10_000_000)), Name: "Ada", CreatedAt: now, UpdatedAt: now}
+	if err := postgres.CreateUser(context.Background(), user); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	return user
+}
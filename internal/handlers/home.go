@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/apierror"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/database"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/middleware"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/services"
+)
+
+// homeViewKey holds the view HomeVersion built, for GetHome to reuse
+const homeViewKey = "home.view"
+
+type HomeHandler struct {
+	postgres *database.PostgresDB
+	home     *services.HomeViewService
+	audit    *services.AuditLogger
+}
+
+func NewHomeHandler(postgres *database.PostgresDB, home *services.HomeViewService, audit *services.AuditLogger) *HomeHandler {
+	return &HomeHandler{
+		postgres: postgres,
+		home:     home,
+		audit:    audit,
+	}
+}
+
+// HomeVersion versions GET /home by its content, since it is assembled from
+// many sources that change independently. The caller is authorized first, so
+// an ETag is never given to someone who may not see the view.
+func (h *HomeHandler) HomeVersion(c *gin.Context) (string, time.Duration, error) {
+	user, ok := loadAuthorizedUser(c, h.postgres)
+	if !ok {
+		return "", 0, nil // the error response is written and the chain aborted
+	}
+	view, err := h.home.Build(c.Request.Context(), user)
+	if err != nil {
+		return "", 0, err
+	}
+	c.Set(homeViewKey, view)
+	return view.Version(), h.home.MaxAge(view), nil
+}
+
+// GET /v1/user/:user_id/home
+// Everything the app's home screen shows, see services.HomeView
+func (h *HomeHandler) GetHome(c *gin.Context) {
+	var view *services.HomeView
+	if v, ok := c.Get(homeViewKey); ok {
+		view = v.(*services.HomeView)
+	} else {
+		user, ok := loadAuthorizedUser(c, h.postgres)
+		if !ok {
+			return
+		}
+		built, err := h.home.Build(c.Request.Context(), user)
+		if err != nil {
+			middleware.AbortWithError(c, apierror.Internal("failed to get home view", err))
+			return
+		}
+		view = built
+	}
+
+	recordAudit(c, h.audit, &models.AuditEvent{
+		Action:        services.AuditHomeView,
+		ObjectType:    "user",
+		ObjectID:      view.UserID.String(),
+		SubjectUserID: &view.UserID,
+	})
+
+	c.Data(http.StatusOK, "application/json; charset=utf-8", view.Body())
+}
@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/apierror"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/middleware"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/scheduler"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/services"
+)
+
+type JobsHandler struct {
+	scheduler *scheduler.Scheduler
+	audit     *services.AuditLogger
+}
+
+func NewJobsHandler(scheduler *scheduler.Scheduler, audit *services.AuditLogger) *JobsHandler {
+	return &JobsHandler{
+		scheduler: scheduler,
+		audit:     audit,
+	}
+}
+
+// GET /admin/jobs
+// The background jobs this instance runs, with their last recorded run
+func (h *JobsHandler) ListJobs(c *gin.Context) {
+	jobs, err := h.scheduler.Jobs(c.Request.Context())
+	if err != nil {
+		middleware.AbortWithError(c, apierror.Internal("failed to load job status", err))
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"jobs": jobs})
+}
+
+// POST /admin/jobs/:name/run-now
+// Runs a job on this instance as soon as it isn't running. A singleton job
+// another instance is running is skipped, as on schedule.
+func (h *JobsHandler) RunNow(c *gin.Context) {
+	name := c.Param("name")
+	err := h.scheduler.RunNow(name)
+	if errors.Is(err, scheduler.ErrUnknownJob) {
+		middleware.AbortWithError(c, apierror.NotFound("job not found"))
+		return
+	}
+	if errors.Is(err, scheduler.ErrClosed) {
+		middleware.AbortWithError(c, apierror.Unavailable("shutting down"))
+		return
+	}
+	if err != nil {
+		middleware.AbortWithError(c, apierror.Internal("failed to run job", err))
+		return
+	}
+
+	recordAudit(c, h.audit, &models.AuditEvent{
+		Action:     services.AuditJobRunNow,
+		ObjectType: "job",
+		ObjectID:   name,
+	})
+
+	c.JSON(http.StatusAccepted, gin.H{"job": name, "queued": true})
+}
@@ -0,0 +1,170 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/apierror"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/config"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/database"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/middleware"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/services"
+)
+
+type LastGaspHandler struct {
+	cfg      *config.Config
+	postgres *database.PostgresDB
+	audit    *services.AuditLogger
+}
+
+func NewLastGaspHandler(
+	cfg *config.Config,
+	postgres *database.PostgresDB,
+	audit *services.AuditLogger,
+) *LastGaspHandler {
+	return &LastGaspHandler{
+		cfg:      cfg,
+		postgres: postgres,
+		audit:    audit,
+	}
+}
+
+// lastGaspView is a LastGasp with the time remaining before it expires
+type lastGaspView struct {
+	models.LastGasp
+	Active           bool `json:"active"`
+	ExpiresInSeconds int  `json:"expires_in_seconds"`
+}
+
+func newLastGaspView(lg models.LastGasp) lastGaspView {
+	remaining := int(time.Until(lg.ExpiryTs).Seconds())
+	if remaining < 0 {
+		remaining = 0
+	}
+	return lastGaspView{
+		LastGasp:         lg,
+		Active:           remaining > 0,
+		ExpiresInSeconds: remaining,
+	}
+}
+
+// GET /v1/user/:user_id/lastgasp
+func (h *LastGaspHandler) GetActive(c *gin.Context) {
+	user, ok := h.authorizedUser(c)
+	if !ok {
+		return
+	}
+
+	lastGasp, err := h.postgres.GetActiveLastGasp(c.Request.Context(), user.ID)
+	if err != nil {
+		middleware.AbortWithError(c, apierror.Internal("failed to get lastgasp", err))
+		return
+	}
+
+	if lastGasp == nil {
+		c.JSON(http.StatusOK, gin.H{
+			"user_id":   user.ID,
+			"active":    false,
+			"last_gasp": nil,
+		})
+		return
+	}
+
+	recordAudit(c, h.audit, &models.AuditEvent{
+		Action:        services.AuditLastGaspView,
+		ObjectType:    "last_gasp",
+		ObjectID:      lastGasp.ID.String(),
+		SubjectUserID: &user.ID,
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"user_id":   user.ID,
+		"active":    true,
+		"last_gasp": newLastGaspView(*lastGasp),
+	})
+}
+
+// GET /v1/user/:user_id/lastgasp/history?limit=20&offset=0
+func (h *LastGaspHandler) GetHistory(c *gin.Context) {
+	user, ok := h.authorizedUser(c)
+	if !ok {
+		return
+	}
+
+	limit, offset := paginationParams(c, 20, 100)
+
+	lastGasps, total, err := h.postgres.GetLastGaspHistory(c.Request.Context(), user.ID, limit, offset)
+	if err != nil {
+		middleware.AbortWithError(c, apierror.Internal("failed to get lastgasp history", err))
+		return
+	}
+
+	recordAudit(c, h.audit, &models.AuditEvent{
+		Action:        services.AuditLastGaspView,
+		ObjectType:    "last_gasp_history",
+		SubjectUserID: &user.ID,
+		Metadata:      map[string]interface{}{"limit": limit, "offset": offset},
+	})
+
+	views := make([]lastGaspView, 0, len(lastGasps))
+	for _, lg := range lastGasps {
+		views = append(views, newLastGaspView(lg))
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"user_id":    user.ID,
+		"last_gasps": views,
+		"total":      total,
+		"limit":      limit,
+		"offset":     offset,
+	})
+}
+
+// authorizedUser loads the user from the :user_id param and checks the caller may read it.
+// It writes the error response itself and returns false on failure.
+func (h *LastGaspHandler) authorizedUser(c *gin.Context) (*models.User, bool) {
+	return loadAuthorizedUser(c, h.postgres)
+}
+
+// paginationParams reads limit/offset query params with a default and upper bound
+func paginationParams(c *gin.Context, defaultLimit, maxLimit int) (int, int) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultLimit)))
+	if err != nil || limit <= 0 {
+		limit = defaultLimit
+	}
+	if limit > maxLimit {
+		limit = maxLimit
+	}
+
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		offset = 0
+	}
+
+	return limit, offset
+}
+
+// timeRangeParams reads the from/to RFC3339 query params, defaulting to the
+// span before now. It writes the error response itself and returns false on failure.
+func timeRangeParams(c *gin.Context, span time.Duration) (time.Time, time.Time, bool) {
+	to := time.Now()
+	from := to.Add(-span)
+	for param, dst := range map[string]*time.Time{"from": &from, "to": &to} {
+		if v := c.Query(param); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				middleware.AbortWithError(c, apierror.Invalid(param, "must be an RFC3339 timestamp"))
+				return time.Time{}, time.Time{}, false
+			}
+			*dst = t
+		}
+	}
+	if from.After(to) {
+		middleware.AbortWithError(c, apierror.Invalid("from", "must not be after to"))
+		return time.Time{}, time.Time{}, false
+	}
+	return from, to, true
+}
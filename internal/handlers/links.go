@@ -0,0 +1,432 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/apierror"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/config"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/database"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/middleware"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/params"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/services"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/utils"
+)
+
+type LinksHandler struct {
+	cfg      *config.Config
+	postgres *database.PostgresDB
+	links    *services.AccountLinkService
+	consents *services.ConsentService
+	alerter  services.Notifier
+	audit    *services.AuditLogger
+}
+
+func NewLinksHandler(
+	cfg *config.Config,
+	postgres *database.PostgresDB,
+	links *services.AccountLinkService,
+	consents *services.ConsentService,
+	alerter services.Notifier,
+	audit *services.AuditLogger,
+) *LinksHandler {
+	return &LinksHandler{
+		cfg:      cfg,
+		postgres: postgres,
+		links:    links,
+		consents: consents,
+		alerter:  alerter,
+		audit:    audit,
+	}
+}
+
+type RequestLinkRequest struct {
+	WardPhone   string   `json:"ward_phone" binding:"required"`
+	Permissions []string `json:"permissions"`
+}
+
+// GuardianRulesRequest sets the notices a guardian gets about the ward.
+// A threshold left out turns its rule off.
+type GuardianRulesRequest struct {
+	MaxSilentHours     *int   `json:"max_silent_hours"`
+	MaxStationaryHours *int   `json:"max_stationary_hours"`
+	DailyDigest        bool   `json:"daily_digest"`
+	Channel            string `json:"channel"` // push (default) | sms
+}
+
+type TrackingRequest struct {
+	Action string `json:"action" binding:"required,oneof=start stop"`
+}
+
+// POST /v1/links
+// A guardian asks to link to a ward; the ward must accept
+func (h *LinksHandler) RequestLink(c *gin.Context) {
+	guardianID, ok := callerUserID(c)
+	if !ok {
+		middleware.AbortWithError(c, apierror.Forbidden("only users can request links"))
+		return
+	}
+
+	var req RequestLinkRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		middleware.AbortWithError(c, apierror.Validation(err))
+		return
+	}
+	if err := services.ValidateLinkPermissions(req.Permissions); err != nil {
+		middleware.AbortWithError(c, apierror.Invalid("permissions", err.Error()))
+		return
+	}
+
+	phone := utils.NormalizePhone(req.WardPhone)
+	if !utils.IsValidE164(phone) {
+		middleware.AbortWithError(c, apierror.Invalid("ward_phone", "must be a valid phone number"))
+		return
+	}
+
+	ward, err := h.postgres.GetUserByPhone(c.Request.Context(), phone)
+	if err != nil {
+		middleware.AbortWithError(c, apierror.Internal("database error", err))
+		return
+	}
+	if ward == nil {
+		middleware.AbortWithError(c, apierror.NotFound("no user with that phone number"))
+		return
+	}
+	if ward.ID == guardianID {
+		middleware.AbortWithError(c, apierror.BadRequest("cannot link to your own account"))
+		return
+	}
+
+	link, err := h.links.Request(c.Request.Context(), guardianID, ward.ID, req.Permissions)
+	if errors.Is(err, database.ErrLinkExists) {
+		middleware.AbortWithError(c, apierror.Conflict("a pending or active link already exists"))
+		return
+	}
+	if err != nil {
+		middleware.AbortWithError(c, apierror.Internal("failed to create link", err))
+		return
+	}
+
+	recordAudit(c, h.audit, &models.AuditEvent{
+		Action:        services.AuditLinkRequest,
+		ObjectType:    "account_link",
+		ObjectID:      link.ID.String(),
+		SubjectUserID: &ward.ID,
+		Metadata:      map[string]interface{}{"permissions": link.Permissions},
+	})
+
+	c.JSON(http.StatusCreated, link)
+}
+
+// GET /v1/links
+// Links where the caller is guardian or ward
+func (h *LinksHandler) ListLinks(c *gin.Context) {
+	userID, ok := callerUserID(c)
+	if !ok {
+		middleware.AbortWithError(c, apierror.Forbidden("only users have links"))
+		return
+	}
+
+	links, err := h.postgres.GetAccountLinksForUser(c.Request.Context(), userID)
+	if err != nil {
+		middleware.AbortWithError(c, apierror.Internal("database error", err))
+		return
+	}
+	if links == nil {
+		links = []models.AccountLink{}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"links": links})
+}
+
+// POST /v1/links/:link_id/accept
+// Only the ward can give consent
+func (h *LinksHandler) AcceptLink(c *gin.Context) {
+	link, ok := h.loadLink(c)
+	if !ok {
+		return
+	}
+
+	userID, _ := callerUserID(c)
+	if link.WardUserID != userID {
+		middleware.AbortWithError(c, apierror.Forbidden("only the ward can accept a link"))
+		return
+	}
+
+	accepted, err := h.links.Accept(c.Request.Context(), link)
+	if err != nil && !accepted {
+		middleware.AbortWithError(c, apierror.Internal("failed to accept link", err))
+		return
+	}
+	if err != nil {
+		log.Printf("WARN: %v", err)
+	}
+	if !accepted {
+		middleware.AbortWithError(c, apierror.Conflict("link is not pending"))
+		return
+	}
+
+	recordAudit(c, h.audit, &models.AuditEvent{
+		Action:        services.AuditLinkAccept,
+		ObjectType:    "account_link",
+		ObjectID:      link.ID.String(),
+		SubjectUserID: &link.WardUserID,
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": "link accepted",
+	})
+}
+
+// POST /v1/links/:link_id/revoke
+// Either side can revoke; access ends immediately
+func (h *LinksHandler) RevokeLink(c *gin.Context) {
+	link, ok := h.loadLink(c)
+	if !ok {
+		return
+	}
+
+	userID, _ := callerUserID(c)
+	if link.WardUserID != userID && link.GuardianUserID != userID {
+		middleware.AbortWithError(c, apierror.NotFound("link not found"))
+		return
+	}
+
+	revoked, err := h.links.Revoke(c.Request.Context(), link)
+	if err != nil && !revoked {
+		middleware.AbortWithError(c, apierror.Internal("failed to revoke link", err))
+		return
+	}
+	if err != nil {
+		// Revoked in Postgres, but a cached decision may outlive it
+		middleware.AbortWithError(c, apierror.Unavailable("link revoked, but access may persist briefly; retry to confirm").WithCause(err))
+		return
+	}
+	if !revoked {
+		middleware.AbortWithError(c, apierror.Conflict("link is already revoked"))
+		return
+	}
+
+	recordAudit(c, h.audit, &models.AuditEvent{
+		Action:        services.AuditLinkRevoke,
+		ObjectType:    "account_link",
+		ObjectID:      link.ID.String(),
+		SubjectUserID: &link.WardUserID,
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": "link revoked",
+	})
+}
+
+// POST /v1/user/:user_id/tracking
+// The user, or a guardian with the tracking permission, starts or stops tracking on the device
+func (h *LinksHandler) SetTracking(c *gin.Context) {
+	userID := params.UserID(c)
+
+	claims := middleware.Principal(c)
+	self := claims != nil && claims.Role == utils.RoleUser && claims.Subject == userID.String()
+	if !self && !middleware.GuardianLink(c, userID).Allows(models.LinkPermissionTracking) {
+		middleware.AbortWithError(c, apierror.Forbidden("not allowed to control tracking for this user"))
+		return
+	}
+
+	var req TrackingRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		middleware.AbortWithError(c, apierror.Validation(err))
+		return
+	}
+	// Stopping is always allowed, it's what withdrawing consent asks for
+	if req.Action == services.TrackingStart &&
+		abortConsentRequired(c, h.consents.Require(c.Request.Context(), userID, models.ConsentTracking)) {
+		return
+	}
+
+	token, err := h.postgres.GetPushToken(c.Request.Context(), userID)
+	if err != nil {
+		middleware.AbortWithError(c, apierror.Internal("database error", err))
+		return
+	}
+	if token == "" {
+		middleware.AbortWithError(c, apierror.Conflict("user has no registered device"))
+		return
+	}
+
+	if err := h.alerter.SendTrackingCommand(c.Request.Context(), token, req.Action); err != nil {
+		middleware.AbortWithError(c, apierror.Unavailable("could not reach the device").WithCause(err))
+		return
+	}
+
+	recordAudit(c, h.audit, &models.AuditEvent{
+		Action:        services.AuditTrackingCommand,
+		ObjectType:    "user",
+		ObjectID:      userID.String(),
+		SubjectUserID: &userID,
+		Metadata:      map[string]interface{}{"action": req.Action},
+	})
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"status":  "accepted",
+		"message": "tracking " + req.Action + " sent to device",
+	})
+}
+
+// GET /v1/links/:link_id/rules
+// The guardian's notification rules on the link, for either side; null when there are none
+func (h *LinksHandler) GetRules(c *gin.Context) {
+	link, ok := h.loadLink(c)
+	if !ok {
+		return
+	}
+	userID, _ := callerUserID(c)
+	if link.WardUserID != userID && link.GuardianUserID != userID {
+		middleware.AbortWithError(c, apierror.NotFound("link not found"))
+		return
+	}
+
+	rules, err := h.postgres.GetGuardianRules(c.Request.Context(), link.ID)
+	if err != nil {
+		middleware.AbortWithError(c, apierror.Internal("database error", err))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"link_id": link.ID,
+		"rules":   rules,
+	})
+}
+
+// PUT /v1/links/:link_id/rules
+// The guardian sets when they are told about smaller anomalies than alerts:
+// the ward's phone silent or the ward not moving for a number of hours, and
+// a daily "all good" digest
+func (h *LinksHandler) PutRules(c *gin.Context) {
+	link, ok := h.loadLink(c)
+	if !ok {
+		return
+	}
+	userID, _ := callerUserID(c)
+	if link.GuardianUserID != userID {
+		middleware.AbortWithError(c, apierror.Forbidden("only the guardian can set notification rules"))
+		return
+	}
+	if link.Status != models.LinkStatusActive {
+		middleware.AbortWithError(c, apierror.Conflict("link is not active"))
+		return
+	}
+
+	var req GuardianRulesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		middleware.AbortWithError(c, apierror.Validation(err))
+		return
+	}
+	rules := &models.GuardianRules{
+		LinkID:             link.ID,
+		MaxSilentHours:     req.MaxSilentHours,
+		MaxStationaryHours: req.MaxStationaryHours,
+		DailyDigest:        req.DailyDigest,
+		Channel:            req.Channel,
+		UpdatedAt:          time.Now(),
+	}
+	if rules.Channel == "" {
+		rules.Channel = models.GuardianChannelPush
+	}
+	if err := services.ValidateGuardianRules(rules); err != nil {
+		middleware.AbortWithError(c, apierror.BadRequest(err.Error()))
+		return
+	}
+
+	if err := h.postgres.UpsertGuardianRules(c.Request.Context(), rules); err != nil {
+		middleware.AbortWithError(c, apierror.Internal("failed to save rules", err))
+		return
+	}
+
+	recordAudit(c, h.audit, &models.AuditEvent{
+		Action:        services.AuditGuardianRulesUpdate,
+		ObjectType:    "account_link",
+		ObjectID:      link.ID.String(),
+		SubjectUserID: &link.WardUserID,
+		Metadata: map[string]interface{}{
+			"max_silent_hours":     rules.MaxSilentHours,
+			"max_stationary_hours": rules.MaxStationaryHours,
+			"daily_digest":         rules.DailyDigest,
+			"channel":              rules.Channel,
+		},
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"link_id": link.ID,
+		"rules":   rules,
+	})
+}
+
+// DELETE /v1/links/:link_id/rules
+// Either side turns the guardian's notices off
+func (h *LinksHandler) DeleteRules(c *gin.Context) {
+	link, ok := h.loadLink(c)
+	if !ok {
+		return
+	}
+	userID, _ := callerUserID(c)
+	if link.WardUserID != userID && link.GuardianUserID != userID {
+		middleware.AbortWithError(c, apierror.NotFound("link not found"))
+		return
+	}
+
+	deleted, err := h.postgres.DeleteGuardianRules(c.Request.Context(), link.ID)
+	if err != nil {
+		middleware.AbortWithError(c, apierror.Internal("failed to delete rules", err))
+		return
+	}
+	if !deleted {
+		middleware.AbortWithError(c, apierror.NotFound("link has no notification rules"))
+		return
+	}
+
+	recordAudit(c, h.audit, &models.AuditEvent{
+		Action:        services.AuditGuardianRulesDelete,
+		ObjectType:    "account_link",
+		ObjectID:      link.ID.String(),
+		SubjectUserID: &link.WardUserID,
+	})
+
+	c.Status(http.StatusNoContent)
+}
+
+// loadLink fetches the :link_id link for a user caller, writing the error response on failure
+func (h *LinksHandler) loadLink(c *gin.Context) (*models.AccountLink, bool) {
+	if _, ok := callerUserID(c); !ok {
+		middleware.AbortWithError(c, apierror.Forbidden("only users have links"))
+		return nil, false
+	}
+
+	id := params.Get(c, params.Link)
+
+	link, err := h.postgres.GetAccountLink(c.Request.Context(), id)
+	if err != nil {
+		middleware.AbortWithError(c, apierror.Internal("database error", err))
+		return nil, false
+	}
+	if link == nil {
+		middleware.AbortWithError(c, apierror.NotFound("link not found"))
+		return nil, false
+	}
+	return link, true
+}
+
+// callerUserID returns the user ID of a user-role caller
+func callerUserID(c *gin.Context) (uuid.UUID, bool) {
+	claims := middleware.Principal(c)
+	if claims == nil || claims.Role != utils.RoleUser {
+		return uuid.Nil, false
+	}
+	id, err := uuid.Parse(claims.Subject)
+	return id, err == nil
+}
@@ -0,0 +1,47 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/apierror"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/middleware"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/pluscode"
+)
+
+type LocationHandler struct{}
+
+func NewLocationHandler() *LocationHandler {
+	return &LocationHandler{}
+}
+
+// GET /v1/location/decode?code=6FR5G9FH+QM
+// Converts a plus code from an alert back to coordinates. Public: it reads
+// nothing stored, so the contact dashboard can call it with any token or none.
+func (h *LocationHandler) Decode(c *gin.Context) {
+	code := strings.TrimSpace(c.Query("code"))
+	if code == "" {
+		middleware.AbortWithError(c, apierror.Invalid("code", "is required"))
+		return
+	}
+
+	area, err := pluscode.Decode(code)
+	if errors.Is(err, pluscode.ErrShort) {
+		middleware.AbortWithError(c, apierror.Invalid("code", "must be a full plus code, such as 6FR5G9FH+QM"))
+		return
+	}
+	if err != nil {
+		middleware.AbortWithError(c, apierror.Invalid("code", "must be a plus code"))
+		return
+	}
+
+	lat, lng := area.Center()
+	c.JSON(http.StatusOK, gin.H{
+		"code":   strings.ToUpper(code),
+		"lat":    lat,
+		"lng":    lng,
+		"bounds": area,
+	})
+}
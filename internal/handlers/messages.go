@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/apierror"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/database"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/middleware"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/services"
+)
+
+type MessagesHandler struct {
+	postgres *database.PostgresDB
+	previews *services.AlertPreviewer
+}
+
+func NewMessagesHandler(postgres *database.PostgresDB, previews *services.AlertPreviewer) *MessagesHandler {
+	return &MessagesHandler{
+		postgres: postgres,
+		previews: previews,
+	}
+}
+
+type PreviewMessageRequest struct {
+	Note string `json:"note"`
+}
+
+// POST /v1/messages/preview
+// The alert the user's contacts would get for a panic with the note, with
+// the user's name and a sample location: the text, its size on SMS (encoding,
+// length, segments) and WhatsApp, and whether the note would be cut, so the
+// app can warn before the user relies on it
+func (h *MessagesHandler) Preview(c *gin.Context) {
+	userID, err := uuid.Parse(middleware.Principal(c).Subject)
+	if err != nil {
+		middleware.AbortWithError(c, apierror.Unauthorized("missing or invalid access token"))
+		return
+	}
+
+	var req PreviewMessageRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		middleware.AbortWithError(c, apierror.Validation(err))
+		return
+	}
+
+	user, err := h.postgres.GetUserByID(c.Request.Context(), userID)
+	if err != nil {
+		middleware.AbortWithError(c, apierror.Internal("database error", err))
+		return
+	}
+	if user == nil {
+		middleware.AbortWithError(c, apierror.NotFound("user not found"))
+		return
+	}
+
+	c.JSON(http.StatusOK, h.previews.Preview(user, req.Note))
+}
@@ -0,0 +1,569 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/apierror"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/config"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/database"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/middleware"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/params"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/services"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/utils"
+)
+
+// orgInvitationListLimit is how many invitations GET /invitations returns
+const orgInvitationListLimit = 200
+
+type OrgHandler struct {
+	cfg      *config.Config
+	postgres *database.PostgresDB
+	orgs     *services.OrganizationService
+	usage    *services.SMSUsage
+	audit    *services.AuditLogger
+}
+
+func NewOrgHandler(
+	cfg *config.Config,
+	postgres *database.PostgresDB,
+	orgs *services.OrganizationService,
+	usage *services.SMSUsage,
+	audit *services.AuditLogger,
+) *OrgHandler {
+	return &OrgHandler{
+		cfg:      cfg,
+		postgres: postgres,
+		orgs:     orgs,
+		usage:    usage,
+		audit:    audit,
+	}
+}
+
+type EscalationContactRequest struct {
+	Name  string `json:"name" binding:"required,max=100"`
+	Phone string `json:"phone" binding:"required"`
+}
+
+type OrganizationRequest struct {
+	Name               string                     `json:"name" binding:"required,max=200"`
+	DefaultSettings    json.RawMessage            `json:"default_settings"`                   // partial user settings applied when a member joins
+	EscalationContacts []EscalationContactRequest `json:"escalation_contacts" binding:"dive"` // alerted with every member's own contacts
+	ScoringProfile     json.RawMessage            `json:"scoring_profile"`                    // fields overriding the active scoring profile
+}
+
+// POST /admin/orgs
+func (h *OrgHandler) CreateOrg(c *gin.Context) {
+	now := time.Now()
+	org := &models.Organization{ID: uuid.New(), CreatedAt: now, UpdatedAt: now}
+	if !h.bindOrganization(c, org) {
+		return
+	}
+
+	if err := h.postgres.CreateOrganization(c.Request.Context(), org); err != nil {
+		middleware.AbortWithError(c, apierror.Internal("failed to create organization", err))
+		return
+	}
+
+	recordAudit(c, h.audit, &models.AuditEvent{
+		Action:     services.AuditOrgCreate,
+		ObjectType: "organization",
+		ObjectID:   org.ID.String(),
+		Metadata:   map[string]interface{}{"name": org.Name},
+	})
+
+	c.JSON(http.StatusCreated, gin.H{
+		"status":       "success",
+		"organization": org,
+	})
+}
+
+// GET /admin/orgs
+func (h *OrgHandler) ListOrgs(c *gin.Context) {
+	orgs, err := h.postgres.ListOrganizations(c.Request.Context())
+	if err != nil {
+		middleware.AbortWithError(c, apierror.Internal("database error", err))
+		return
+	}
+	if orgs == nil {
+		orgs = []models.Organization{}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"organizations": orgs})
+}
+
+// GET /admin/orgs/:org_id
+func (h *OrgHandler) GetOrg(c *gin.Context) {
+	org, ok := h.loadOrg(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"organization": org})
+}
+
+// PUT /admin/orgs/:org_id
+// Replaces the organization's name and settings. Default settings only
+// apply to members who join afterwards; escalation contacts and the scoring
+// profile apply to every member from their next alert or evaluation.
+func (h *OrgHandler) UpdateOrg(c *gin.Context) {
+	org, ok := h.loadOrg(c)
+	if !ok {
+		return
+	}
+	if !h.bindOrganization(c, org) {
+		return
+	}
+	org.UpdatedAt = time.Now()
+
+	if err := h.postgres.UpdateOrganization(c.Request.Context(), org); err != nil {
+		middleware.AbortWithError(c, apierror.Internal("failed to update organization", err))
+		return
+	}
+
+	recordAudit(c, h.audit, &models.AuditEvent{
+		Action:     services.AuditOrgUpdate,
+		ObjectType: "organization",
+		ObjectID:   org.ID.String(),
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":       "success",
+		"organization": org,
+	})
+}
+
+type OrgAdminTokenRequest struct {
+	Name string `json:"name" binding:"required,max=100"` // who the token is for, kept in the audit log
+}
+
+// POST /admin/orgs/:org_id/admin-tokens
+// Issues an org_admin token, which can only manage this organization and
+// only sees its members on the /admin endpoints open to org admins.
+func (h *OrgHandler) IssueAdminToken(c *gin.Context) {
+	org, ok := h.loadOrg(c)
+	if !ok {
+		return
+	}
+
+	var req OrgAdminTokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		middleware.AbortWithError(c, apierror.Validation(err))
+		return
+	}
+
+	subject := uuid.New()
+	ttl := time.Duration(h.cfg.TokenTTLHours) * time.Hour
+	token, err := utils.IssueToken(utils.TokenClaims{
+		Subject: subject.String(),
+		Role:    utils.RoleOrgAdmin,
+		OrgID:   org.ID.String(),
+	}, h.cfg.JWTSecret, ttl)
+	if err != nil {
+		middleware.AbortWithError(c, apierror.Internal("failed to issue token", err))
+		return
+	}
+
+	recordAudit(c, h.audit, &models.AuditEvent{
+		Action:     services.AuditOrgAdminToken,
+		ObjectType: "organization",
+		ObjectID:   org.ID.String(),
+		Metadata:   map[string]interface{}{"name": req.Name, "token_subject": subject.String()},
+	})
+
+	c.JSON(http.StatusCreated, gin.H{
+		"status":       "success",
+		"access_token": token,
+		"subject":      subject,
+		"expires_at":   time.Now().Add(ttl),
+	})
+}
+
+// GET /admin/orgs/:org_id/members
+func (h *OrgHandler) ListMembers(c *gin.Context) {
+	orgID, ok := requireOrgAccess(c)
+	if !ok {
+		return
+	}
+
+	members, err := h.postgres.GetOrgMembers(c.Request.Context(), orgID)
+	if err != nil {
+		middleware.AbortWithError(c, apierror.Internal("database error", err))
+		return
+	}
+	if members == nil {
+		members = []models.OrgMember{}
+	}
+
+	recordAudit(c, h.audit, &models.AuditEvent{
+		Action:     services.AuditOrgMembersView,
+		ObjectType: "organization",
+		ObjectID:   orgID.String(),
+		Metadata:   map[string]interface{}{"members": len(members)},
+	})
+
+	c.JSON(http.StatusOK, gin.H{"members": members})
+}
+
+// DELETE /admin/orgs/:org_id/members/:user_id
+// Takes the user out of the organization. Their settings are left as they are.
+func (h *OrgHandler) RemoveMember(c *gin.Context) {
+	orgID, ok := requireOrgAccess(c)
+	if !ok {
+		return
+	}
+	userID := params.UserID(c)
+
+	removed, err := h.postgres.RemoveOrgMember(c.Request.Context(), orgID, userID)
+	if err != nil {
+		middleware.AbortWithError(c, apierror.Internal("failed to remove member", err))
+		return
+	}
+	if !removed {
+		middleware.AbortWithError(c, apierror.NotFound("member not found"))
+		return
+	}
+
+	recordAudit(c, h.audit, &models.AuditEvent{
+		Action:        services.AuditOrgMemberRemove,
+		ObjectType:    "organization",
+		ObjectID:      orgID.String(),
+		SubjectUserID: &userID,
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": "member removed",
+	})
+}
+
+type OrgInvitationRequest struct {
+	Phone string `json:"phone" binding:"required"`
+}
+
+// POST /admin/orgs/:org_id/invitations
+// Invites a phone number to join the organization, by SMS. The person
+// accepts in the app once registered with that number; inviting the same
+// number again renews the open invitation.
+func (h *OrgHandler) Invite(c *gin.Context) {
+	org, ok := h.loadOrg(c)
+	if !ok {
+		return
+	}
+
+	var req OrgInvitationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		middleware.AbortWithError(c, apierror.Validation(err))
+		return
+	}
+	phone := utils.NormalizePhone(req.Phone)
+	if !utils.IsValidE164(phone) {
+		middleware.AbortWithError(c, apierror.Invalid("phone", "must be a valid phone number"))
+		return
+	}
+
+	invitation, sent, err := h.orgs.Invite(c.Request.Context(), org, phone, middleware.Principal(c).Subject)
+	if err != nil {
+		middleware.AbortWithError(c, apierror.Internal("failed to create invitation", err))
+		return
+	}
+
+	recordAudit(c, h.audit, &models.AuditEvent{
+		Action:     services.AuditOrgInvite,
+		ObjectType: "organization",
+		ObjectID:   org.ID.String(),
+		Metadata:   map[string]interface{}{"invitation_id": invitation.ID.String(), "sms_sent": sent},
+	})
+
+	c.JSON(http.StatusCreated, gin.H{
+		"status":      "success",
+		"invitation":  invitation,
+		"invite_sent": sent,
+	})
+}
+
+// GET /admin/orgs/:org_id/invitations
+func (h *OrgHandler) ListInvitations(c *gin.Context) {
+	orgID, ok := requireOrgAccess(c)
+	if !ok {
+		return
+	}
+
+	invitations, err := h.postgres.GetOrgInvitations(c.Request.Context(), orgID, orgInvitationListLimit)
+	if err != nil {
+		middleware.AbortWithError(c, apierror.Internal("database error", err))
+		return
+	}
+	if invitations == nil {
+		invitations = []models.OrgInvitation{}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"invitations": invitations})
+}
+
+// GET /admin/alerts/active?limit=
+// Unresolved alerts, for an org admin of their members only. Alerts that
+// reached nobody come first, newest first within each group.
+func (h *OrgHandler) ListActiveAlerts(c *gin.Context) {
+	orgID, ok := adminOrg(c)
+	if !ok {
+		return
+	}
+	limit, _ := paginationParams(c, 100, 500)
+
+	alerts, err := h.postgres.GetActiveAlerts(c.Request.Context(), orgID, limit)
+	if err != nil {
+		middleware.AbortWithError(c, apierror.Internal("database error", err))
+		return
+	}
+	undeliverable := 0
+	for _, alert := range alerts {
+		if alert.Undeliverable != "" {
+			undeliverable++
+		}
+	}
+
+	recordAudit(c, h.audit, &models.AuditEvent{
+		Action:     services.AuditAdminAlertsView,
+		ObjectType: "alert",
+		Metadata:   map[string]interface{}{"count": len(alerts)},
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"alerts":        alerts,
+		"undeliverable": undeliverable,
+		"limit":         limit,
+	})
+}
+
+// GET /admin/stats?from=&to=
+// User, alert and LastGasp counts, plus today's outbound SMS: for an org
+// admin, over their members only. Defaults to the last 30 days.
+func (h *OrgHandler) GetStats(c *gin.Context) {
+	orgID, ok := adminOrg(c)
+	if !ok {
+		return
+	}
+	from, to, ok := timeRangeParams(c, 30*24*time.Hour)
+	if !ok {
+		return
+	}
+
+	stats, err := h.postgres.GetAdminStats(c.Request.Context(), orgID, from, to)
+	if err != nil {
+		middleware.AbortWithError(c, apierror.Internal("database error", err))
+		return
+	}
+	// Today's outbound SMS come from Redis; stats are still useful without them
+	if stats.OutboundSMS, err = h.usage.Today(c.Request.Context(), orgID); err != nil {
+		log.Printf("WARN: Failed to load outbound SMS counts: %v", err)
+		stats.OutboundSMS = nil
+	}
+
+	recordAudit(c, h.audit, &models.AuditEvent{
+		Action:     services.AuditAdminStatsView,
+		ObjectType: "admin_stats",
+		Metadata:   map[string]interface{}{"query": c.Request.URL.RawQuery},
+	})
+
+	c.JSON(http.StatusOK, stats)
+}
+
+// GET /v1/user/:user_id/org
+// The user's organization, if any, and the invitations they can accept
+func (h *OrgHandler) GetUserOrg(c *gin.Context) {
+	userID, ok := requireSelf(c, "only the user can see their organization")
+	if !ok {
+		return
+	}
+	user, ok := h.loadUser(c, userID)
+	if !ok {
+		return
+	}
+
+	var org gin.H
+	if user.OrgID != nil {
+		o, err := h.postgres.GetOrganization(c.Request.Context(), *user.OrgID)
+		if err != nil {
+			middleware.AbortWithError(c, apierror.Internal("database error", err))
+			return
+		}
+		if o != nil {
+			// Members see who is alerted on their behalf, not how they are scored
+			org = gin.H{"id": o.ID, "name": o.Name, "escalation_contacts": o.EscalationContacts}
+		}
+	}
+
+	invitations, err := h.postgres.GetOpenOrgInvitations(c.Request.Context(), user.Phone, time.Now())
+	if err != nil {
+		middleware.AbortWithError(c, apierror.Internal("database error", err))
+		return
+	}
+	if invitations == nil {
+		invitations = []models.OrgInvitation{}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"organization": org,
+		"invitations":  invitations,
+	})
+}
+
+// POST /v1/user/:user_id/org/invitations/:invitation_id/accept
+// Joins the inviting organization. Its default settings are applied over the
+// user's own, and its escalation contacts are alerted with theirs from now on.
+func (h *OrgHandler) AcceptInvitation(c *gin.Context) {
+	userID, ok := requireSelf(c, "only the user can join an organization")
+	if !ok {
+		return
+	}
+	user, ok := h.loadUser(c, userID)
+	if !ok {
+		return
+	}
+
+	invitation, org, err := h.orgs.Accept(c.Request.Context(), user, params.Get(c, params.Invitation))
+	if errors.Is(err, database.ErrInvitationNotFound) {
+		middleware.AbortWithError(c, apierror.NotFound("invitation not found or expired"))
+		return
+	}
+	if errors.Is(err, database.ErrAlreadyMember) {
+		middleware.AbortWithError(c, apierror.Conflict("leave your current organization first"))
+		return
+	}
+	if err != nil {
+		middleware.AbortWithError(c, apierror.Internal("failed to accept invitation", err))
+		return
+	}
+
+	recordAudit(c, h.audit, &models.AuditEvent{
+		Action:        services.AuditOrgJoin,
+		ObjectType:    "organization",
+		ObjectID:      org.ID.String(),
+		SubjectUserID: &userID,
+		Metadata:      map[string]interface{}{"invitation_id": invitation.ID.String()},
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":       "success",
+		"organization": gin.H{"id": org.ID, "name": org.Name, "escalation_contacts": org.EscalationContacts},
+		"settings":     user.Settings,
+	})
+}
+
+// DELETE /v1/user/:user_id/org
+// Leaves the user's organization. Their settings are left as they are.
+func (h *OrgHandler) LeaveOrg(c *gin.Context) {
+	userID, ok := requireSelf(c, "only the user can leave their organization")
+	if !ok {
+		return
+	}
+
+	orgID, err := h.postgres.LeaveOrganization(c.Request.Context(), userID)
+	if err != nil {
+		middleware.AbortWithError(c, apierror.Internal("failed to leave organization", err))
+		return
+	}
+	if orgID == uuid.Nil {
+		middleware.AbortWithError(c, apierror.NotFound("not a member of an organization"))
+		return
+	}
+
+	recordAudit(c, h.audit, &models.AuditEvent{
+		Action:        services.AuditOrgLeave,
+		ObjectType:    "organization",
+		ObjectID:      orgID.String(),
+		SubjectUserID: &userID,
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": "left organization",
+	})
+}
+
+// loadOrg loads the :org_id organization the caller administers. It writes
+// the error response itself and returns false on failure.
+func (h *OrgHandler) loadOrg(c *gin.Context) (*models.Organization, bool) {
+	orgID, ok := requireOrgAccess(c)
+	if !ok {
+		return nil, false
+	}
+	org, err := h.postgres.GetOrganization(c.Request.Context(), orgID)
+	if err != nil {
+		middleware.AbortWithError(c, apierror.Internal("database error", err))
+		return nil, false
+	}
+	if org == nil {
+		middleware.AbortWithError(c, apierror.NotFound("organization not found"))
+		return nil, false
+	}
+	return org, true
+}
+
+func (h *OrgHandler) loadUser(c *gin.Context, userID uuid.UUID) (*models.User, bool) {
+	user, err := h.postgres.GetUserByID(c.Request.Context(), userID)
+	if err != nil {
+		middleware.AbortWithError(c, apierror.Internal("database error", err))
+		return nil, false
+	}
+	if user == nil {
+		middleware.AbortWithError(c, apierror.NotFound("user not found"))
+		return nil, false
+	}
+	return user, true
+}
+
+// bindOrganization reads an OrganizationRequest into org and validates it.
+// Escalation contacts keep their IDs across updates when the phone is
+// unchanged. It writes the error response itself and returns false on failure.
+func (h *OrgHandler) bindOrganization(c *gin.Context, org *models.Organization) bool {
+	var req OrganizationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		middleware.AbortWithError(c, apierror.Validation(err))
+		return false
+	}
+
+	existing := make(map[string]string, len(org.EscalationContacts))
+	for _, contact := range org.EscalationContacts {
+		existing[contact.Phone] = contact.ID
+	}
+	contacts := models.TrustedContacts{}
+	seen := make(map[string]bool, len(req.EscalationContacts))
+	for _, r := range req.EscalationContacts {
+		phone := utils.NormalizePhone(r.Phone)
+		if !utils.IsValidE164(phone) {
+			middleware.AbortWithError(c, apierror.Invalid("escalation_contacts", "phone "+r.Phone+" is not a valid phone number"))
+			return false
+		}
+		if seen[phone] {
+			continue
+		}
+		seen[phone] = true
+		id := existing[phone]
+		if id == "" {
+			id = uuid.New().String()
+		}
+		contacts = append(contacts, models.Contact{ID: id, Name: r.Name, Phone: phone})
+	}
+
+	org.Name = req.Name
+	org.DefaultSettings = req.DefaultSettings
+	org.EscalationContacts = contacts
+	org.ScoringProfile = req.ScoringProfile
+
+	var settingsErr *services.OrgSettingsError
+	if err := h.orgs.Validate(org); errors.As(err, &settingsErr) {
+		middleware.AbortWithError(c, apierror.Invalid(settingsErr.Field, settingsErr.Err.Error()))
+		return false
+	} else if err != nil {
+		middleware.AbortWithError(c, apierror.Internal("failed to validate organization", err))
+		return false
+	}
+	return true
+}
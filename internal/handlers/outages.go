@@ -0,0 +1,35 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/apierror"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/middleware"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/services"
+)
+
+type OutagesHandler struct {
+	outages *services.OutageDetector
+}
+
+func NewOutagesHandler(outages *services.OutageDetector) *OutagesHandler {
+	return &OutagesHandler{outages: outages}
+}
+
+// GET /admin/outages
+// Active probable outage zones: geohash cells where many users of one
+// network went silent together, soonest to end first
+func (h *OutagesHandler) ListOutages(c *gin.Context) {
+	zones, err := h.outages.Active(c.Request.Context())
+	if err != nil {
+		middleware.AbortWithError(c, apierror.Internal("failed to get outage zones", err))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"outages": zones,
+		"count":   len(zones),
+	})
+}
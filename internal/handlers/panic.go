@@ -0,0 +1,200 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/apierror"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/database"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/middleware"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/params"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/services"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/utils"
+)
+
+type PanicHandler struct {
+	postgres *database.PostgresDB
+	panics   *services.PanicService
+}
+
+func NewPanicHandler(postgres *database.PostgresDB, panics *services.PanicService) *PanicHandler {
+	return &PanicHandler{
+		postgres: postgres,
+		panics:   panics,
+	}
+}
+
+type TriggerPanicRequest struct {
+	// Quoted in the alert; cleaned and cut to services.AlertNoteMaxRunes
+	Note string `json:"note"`
+}
+
+// POST /v1/user/:user_id/panic
+// Raises a panic from the app. It is held for PANIC_CANCEL_WINDOW_SECONDS
+// (status pending, sent at release_at) so the app can count down and offer
+// to cancel; without a panic PIN it is sent at once (status dispatched).
+// While a panic is pending, pressing again returns it. The body is optional;
+// a note in it is quoted in the alert, cut rather than refused when too long.
+func (h *PanicHandler) Trigger(c *gin.Context) {
+	userID, ok := requireSelf(c, "only the user can raise a panic")
+	if !ok {
+		return
+	}
+
+	var req TriggerPanicRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			middleware.AbortWithError(c, apierror.Validation(err))
+			return
+		}
+	}
+	note, _ := services.CleanAlertNote(req.Note)
+
+	p, err := h.panics.Trigger(c.Request.Context(), userID, note)
+	if err != nil {
+		middleware.AbortWithError(c, apierror.Internal("failed to raise panic", err))
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"status": "success",
+		"panic":  p,
+	})
+}
+
+type CancelPanicRequest struct {
+	PIN string `json:"pin" binding:"required"`
+}
+
+// POST /v1/panic/:panic_id/cancel
+// Cancels a pending panic with the user's PIN. A wrong PIN sends the alert
+// at once (403). The duress PIN is answered exactly like the user's PIN, but
+// sends the alert flagged as duress.
+func (h *PanicHandler) Cancel(c *gin.Context) {
+	p, ok := h.ownPanic(c)
+	if !ok {
+		return
+	}
+
+	var req CancelPanicRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		middleware.AbortWithError(c, apierror.Validation(err))
+		return
+	}
+
+	cancelled, err := h.panics.Cancel(c.Request.Context(), p, req.PIN)
+	switch {
+	case errors.Is(err, services.ErrPanicWrongPIN):
+		middleware.AbortWithError(c, apierror.Forbidden("wrong PIN; the alert has been sent"))
+		return
+	case errors.Is(err, services.ErrPanicNotPending):
+		middleware.AbortWithError(c, apierror.Conflict("panic was already cancelled or sent"))
+		return
+	case err != nil:
+		middleware.AbortWithError(c, apierror.Internal("failed to cancel panic", err))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": "success",
+		"panic":  cancelled,
+	})
+}
+
+// POST /v1/panic/:panic_id/confirm
+// Sends a pending panic without waiting for the cancellation window
+func (h *PanicHandler) Confirm(c *gin.Context) {
+	p, ok := h.ownPanic(c)
+	if !ok {
+		return
+	}
+
+	dispatched, err := h.panics.Confirm(c.Request.Context(), p)
+	switch {
+	case errors.Is(err, services.ErrPanicNotPending):
+		middleware.AbortWithError(c, apierror.Conflict("panic was already cancelled or sent"))
+		return
+	case err != nil:
+		middleware.AbortWithError(c, apierror.Internal("failed to send panic", err))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": "success",
+		"panic":  dispatched,
+	})
+}
+
+// ownPanic loads the :panic_id panic, which only the user who raised it may
+// act on. It writes the error response itself and returns false otherwise.
+func (h *PanicHandler) ownPanic(c *gin.Context) (*models.PanicRequest, bool) {
+	p, err := h.postgres.GetPanicRequest(c.Request.Context(), params.Get(c, params.Panic))
+	if err != nil {
+		middleware.AbortWithError(c, apierror.Internal("database error", err))
+		return nil, false
+	}
+	claims := middleware.Principal(c)
+	if p == nil || claims == nil || claims.Role != utils.RoleUser || claims.Subject != p.UserID.String() {
+		middleware.AbortWithError(c, apierror.NotFound("panic not found"))
+		return nil, false
+	}
+	return p, true
+}
+
+type SetPanicPINRequest struct {
+	CurrentPIN string `json:"current_pin"` // required once a PIN is set
+	PIN        string `json:"pin" binding:"required"`
+	DuressPIN  string `json:"duress_pin"` // optional; left out clears it
+}
+
+// PUT /v1/user/:user_id/panic-pin
+// Sets the PIN that cancels a panic and, optionally, the duress PIN. Both are
+// 4 to 8 digits and stored hashed. Changing them needs the current PIN, and
+// is limited to 5 attempts an hour.
+func (h *PanicHandler) SetPIN(c *gin.Context) {
+	userID, ok := requireSelf(c, "only the user can set their panic PIN")
+	if !ok {
+		return
+	}
+
+	var req SetPanicPINRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		middleware.AbortWithError(c, apierror.Validation(err))
+		return
+	}
+	if err := services.ValidatePanicPIN(req.PIN); err != nil {
+		middleware.AbortWithError(c, apierror.Invalid("pin", err.Error()))
+		return
+	}
+	if req.DuressPIN != "" {
+		if err := services.ValidatePanicPIN(req.DuressPIN); err != nil {
+			middleware.AbortWithError(c, apierror.Invalid("duress_pin", err.Error()))
+			return
+		}
+		if req.DuressPIN == req.PIN {
+			middleware.AbortWithError(c, apierror.Invalid("duress_pin", "must differ from pin"))
+			return
+		}
+	}
+
+	err := h.panics.SetPINs(c.Request.Context(), userID, req.CurrentPIN, req.PIN, req.DuressPIN)
+	switch {
+	case errors.Is(err, services.ErrPanicWrongPIN):
+		middleware.AbortWithError(c, apierror.Forbidden("current_pin does not match"))
+		return
+	case errors.Is(err, services.ErrPanicPINAttempts):
+		middleware.AbortWithError(c, apierror.TooManyRequests("too many panic PIN changes; try again later"))
+		return
+	case err != nil:
+		middleware.AbortWithError(c, apierror.Internal("failed to set panic PIN", err))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":     "success",
+		"message":    "panic PIN set",
+		"duress_pin": req.DuressPIN != "",
+	})
+}
@@ -0,0 +1,165 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/apierror"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/config"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/database"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/middleware"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/services"
+)
+
+// protectionHistoryLimit is how many past pauses GET /protection returns
+const protectionHistoryLimit = 20
+
+type ProtectionHandler struct {
+	cfg        *config.Store
+	postgres   *database.PostgresDB
+	protection *services.ProtectionService
+	audit      *services.AuditLogger
+}
+
+func NewProtectionHandler(
+	cfg *config.Store,
+	postgres *database.PostgresDB,
+	protection *services.ProtectionService,
+	audit *services.AuditLogger,
+) *ProtectionHandler {
+	return &ProtectionHandler{
+		cfg:        cfg,
+		postgres:   postgres,
+		protection: protection,
+		audit:      audit,
+	}
+}
+
+type PauseProtectionRequest struct {
+	DurationMinutes int    `json:"duration_minutes" binding:"required,min=1"`
+	Reason          string `json:"reason" binding:"max=200"`
+}
+
+// POST /v1/user/:user_id/protection/pause
+// The user stops being monitored until the duration lapses or they resume.
+// Refused while they have an unresolved alert.
+func (h *ProtectionHandler) Pause(c *gin.Context) {
+	userID, ok := requireSelf(c, "only the user can pause or resume their protection")
+	if !ok {
+		return
+	}
+
+	var req PauseProtectionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		middleware.AbortWithError(c, apierror.Validation(err))
+		return
+	}
+	maxMinutes := h.cfg.Current().ProtectionPauseMaxMinutes
+	if req.DurationMinutes > maxMinutes {
+		middleware.AbortWithError(c, apierror.Invalid("duration_minutes", fmt.Sprintf("must be at most %d", maxMinutes)))
+		return
+	}
+
+	pause, err := h.protection.Pause(c.Request.Context(), userID, time.Duration(req.DurationMinutes)*time.Minute, req.Reason)
+	if errors.Is(err, services.ErrAlertOpen) {
+		middleware.AbortWithError(c, apierror.Conflict("resolve the open alert before pausing protection"))
+		return
+	}
+	if errors.Is(err, services.ErrWatchActive) {
+		middleware.AbortWithError(c, apierror.Conflict("complete the active watch before pausing protection"))
+		return
+	}
+	if err != nil {
+		middleware.AbortWithError(c, apierror.Internal("failed to pause protection", err))
+		return
+	}
+
+	recordAudit(c, h.audit, &models.AuditEvent{
+		Action:        services.AuditProtectionPause,
+		ObjectType:    "protection_pause",
+		ObjectID:      pause.ID.String(),
+		SubjectUserID: &userID,
+		Metadata: map[string]interface{}{
+			"paused_until": pause.PausedUntil,
+			"reason":       pause.Reason,
+		},
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": "paused",
+		"pause":  pause,
+	})
+}
+
+// POST /v1/user/:user_id/protection/resume
+func (h *ProtectionHandler) Resume(c *gin.Context) {
+	userID, ok := requireSelf(c, "only the user can pause or resume their protection")
+	if !ok {
+		return
+	}
+
+	pause, err := h.protection.Resume(c.Request.Context(), userID)
+	if err != nil {
+		middleware.AbortWithError(c, apierror.Internal("failed to resume protection", err))
+		return
+	}
+	if pause == nil {
+		middleware.AbortWithError(c, apierror.Conflict("protection is not paused"))
+		return
+	}
+
+	recordAudit(c, h.audit, &models.AuditEvent{
+		Action:        services.AuditProtectionResume,
+		ObjectType:    "protection_pause",
+		ObjectID:      pause.ID.String(),
+		SubjectUserID: &userID,
+		Metadata:      map[string]interface{}{"resumed_by": models.PauseResumedByUser},
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": "resumed",
+		"pause":  pause,
+	})
+}
+
+// GET /v1/user/:user_id/protection
+// The open pause, if any, and recent pauses. Readable by the user, their
+// trusted contacts and guardians.
+func (h *ProtectionHandler) GetProtection(c *gin.Context) {
+	user, ok := loadAuthorizedUser(c, h.postgres)
+	if !ok {
+		return
+	}
+
+	active, err := h.protection.Active(c.Request.Context(), user.ID)
+	if err != nil {
+		middleware.AbortWithError(c, apierror.Internal("failed to get pause", err))
+		return
+	}
+	history, err := h.postgres.GetProtectionPauses(c.Request.Context(), user.ID, protectionHistoryLimit)
+	if err != nil {
+		middleware.AbortWithError(c, apierror.Internal("failed to get pauses", err))
+		return
+	}
+	if history == nil {
+		history = []models.ProtectionPause{}
+	}
+
+	recordAudit(c, h.audit, &models.AuditEvent{
+		Action:        services.AuditProtectionView,
+		ObjectType:    "user",
+		ObjectID:      user.ID.String(),
+		SubjectUserID: &user.ID,
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"user_id": user.ID,
+		"paused":  active != nil,
+		"pause":   active,
+		"history": history,
+	})
+}
@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/apierror"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/database"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/middleware"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/services"
+)
+
+// publicStatusCacheControl lets browsers and CDNs keep a status as long as
+// the server does; a revoked token can be served from their caches for at
+// most that long
+const publicStatusCacheControl = "public, max-age=60"
+
+type PublicStatusHandler struct {
+	publicStatus *services.PublicStatusService
+}
+
+func NewPublicStatusHandler(publicStatus *services.PublicStatusService) *PublicStatusHandler {
+	return &PublicStatusHandler{publicStatus: publicStatus}
+}
+
+// GET /public/status/:token
+// Whether the user was last confirmed safe, and how many minutes ago. No
+// location, score or identity; embeddable from any origin.
+func (h *PublicStatusHandler) Get(c *gin.Context) {
+	status, ok := h.status(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, status)
+}
+
+// GET /public/status/:token/badge.svg
+// The same status, as an SVG badge
+func (h *PublicStatusHandler) Badge(c *gin.Context) {
+	status, ok := h.status(c)
+	if !ok {
+		return
+	}
+	c.Data(http.StatusOK, "image/svg+xml; charset=utf-8", status.Badge())
+}
+
+// status looks up the token's status and sets the public caching headers. It
+// writes the error response itself and returns false on failure.
+func (h *PublicStatusHandler) status(c *gin.Context) (*services.PublicStatus, bool) {
+	status, err := h.publicStatus.Status(c.Request.Context(), c.Param("token"))
+	if errors.Is(err, services.ErrPublicStatusInvalid) {
+		c.Header("Cache-Control", "no-store")
+		middleware.AbortWithError(c, apierror.NotFound("this status link is not valid"))
+		return nil, false
+	}
+	if err != nil {
+		middleware.AbortWithError(c, apierror.Internal("failed to load status", err))
+		return nil, false
+	}
+
+	c.Header("Cache-Control", publicStatusCacheControl)
+	c.Header("Access-Control-Allow-Origin", "*")
+	return status, true
+}
+
+// GET /v1/user/:user_id/public-status
+// The user's own public status token, if they've turned it on
+func (h *PublicStatusHandler) GetToken(c *gin.Context) {
+	userID, ok := requireSelf(c, "only the user can see their public status link")
+	if !ok {
+		return
+	}
+
+	token, err := h.publicStatus.Token(c.Request.Context(), userID)
+	if errors.Is(err, database.ErrUserNotFound) {
+		middleware.AbortWithError(c, apierror.NotFound("user not found"))
+		return
+	}
+	if err != nil {
+		middleware.AbortWithError(c, apierror.Internal("database error", err))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"enabled": token != nil,
+		"token":   token,
+	})
+}
+
+// POST /v1/user/:user_id/public-status/rotate
+// Replaces the token; the old one stops working at once, cached responses included
+func (h *PublicStatusHandler) Rotate(c *gin.Context) {
+	userID, ok := requireSelf(c, "only the user can rotate their public status link")
+	if !ok {
+		return
+	}
+
+	token, err := h.publicStatus.Rotate(c.Request.Context(), userID)
+	switch {
+	case errors.Is(err, database.ErrUserNotFound):
+		middleware.AbortWithError(c, apierror.NotFound("user not found"))
+		return
+	case errors.Is(err, services.ErrPublicStatusDisabled):
+		middleware.AbortWithError(c, apierror.Conflict("turn the public status on first"))
+		return
+	case err != nil:
+		middleware.AbortWithError(c, apierror.Internal("failed to rotate public status token", err))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"token": token})
+}
@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/services"
+)
+
+// A rotated or disabled token stops answering at once, though its status
+// was cached a moment before; a live one shows nothing about the user
+func TestPublicStatusRotation(t *testing.T) {
+	postgres := testPostgres(t)
+	redis := testRedis(t)
+	ctx := context.Background()
+	publicStatus := services.NewPublicStatusService(postgres, redis)
+	h := NewPublicStatusHandler(publicStatus)
+	router := testRouter()
+	router.GET("/public/status/:token", h.Get)
+	router.GET("/public/status/:token/badge.svg", h.Badge)
+
+	user := createTestUser(t, postgres)
+	user.Settings.PublicStatus = true
+	if err := postgres.UpdateUserSettings(ctx, user.ID, user.Settings); err != nil {
+		t.Fatalf("UpdateUserSettings: %v", err)
+	}
+	state := &models.UserState{
+		UserID: user.ID, State: services.StateSafe, Score: 93, LastHeartbeat: time.Now().Add(-3 * time.Minute),
+		Outage: &models.OutageZone{Carrier: "MTN", Lat: 6.5244, Lng: 3.3792},
+	}
+	if err := redis.SaveUserState(ctx, state); err != nil {
+		t.Fatalf("SaveUserState: %v", err)
+	}
+	token, err := publicStatus.Enable(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("Enable: %v", err)
+	}
+
+	get := func(token string) (int, string) {
+		w := send(t, router, http.MethodGet, "/public/status/"+token, "", nil)
+		badge := send(t, router, http.MethodGet, "/public/status/"+token+"/badge.svg", "", nil)
+		if badge.Code != w.Code {
+			t.Errorf("badge = %d, status = %d", badge.Code, w.Code)
+		}
+		if w.Code == http.StatusOK {
+			if got := w.Header().Get("Cache-Control"); got != "public, max-age=60" {
+				t.Errorf("Cache-Control = %q", got)
+			}
+			for _, leak := range []string{user.ID.String(), user.Name, user.Phone, "93", "6.52", "3.37", "MTN", "SAFE"} {
+				if strings.Contains(w.Body.String(), leak) || strings.Contains(badge.Body.String(), leak) {
+					t.Errorf("status or badge shows %q", leak)
+				}
+			}
+		} else if got := w.Header().Get("Cache-Control"); got != "no-store" {
+			t.Errorf("refused with Cache-Control %q, want no-store", got)
+		}
+		return w.Code, w.Body.String()
+	}
+
+	code, body := get(token)
+	var fields map[string]interface{}
+	if err := json.Unmarshal([]byte(body), &fields); err != nil || code != http.StatusOK {
+		t.Fatalf("status = %d %s", code, body)
+	}
+	if len(fields) != 2 || fields["state_bucket"] != services.PublicStatusOK || fields["last_confirmed_minutes_ago"] != float64(3) {
+		t.Errorf("status = %s, want ok 3 minutes ago and nothing else", body)
+	}
+
+	// The first answer is cached now; rotating must still end it at once
+	rotated, err := publicStatus.Rotate(ctx, user.ID)
+	if err != nil || rotated == token {
+		t.Fatalf("Rotate = %q, %v", rotated, err)
+	}
+	if code, _ := get(token); code != http.StatusNotFound {
+		t.Errorf("old token after rotation = %d, want 404", code)
+	}
+	if code, _ := get(rotated); code != http.StatusOK {
+		t.Errorf("new token = %d, want 200", code)
+	}
+
+	if err := publicStatus.Disable(ctx, user.ID); err != nil {
+		t.Fatalf("Disable: %v", err)
+	}
+	if code, _ := get(rotated); code != http.StatusNotFound {
+		t.Errorf("token after disabling = %d, want 404", code)
+	}
+	if _, err := publicStatus.Rotate(ctx, user.ID); err != services.ErrPublicStatusDisabled {
+		t.Errorf("Rotate while off = %v, want ErrPublicStatusDisabled", err)
+	}
+	if code, _ := get("not-a-token"); code != http.StatusNotFound {
+		t.Errorf("unknown token = %d, want 404", code)
+	}
+}
@@ -0,0 +1,219 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/apierror"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/database"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/middleware"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/params"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/services"
+)
+
+type ResponderHandler struct {
+	postgres   *database.PostgresDB
+	responders *services.ResponderService
+	audit      *services.AuditLogger
+}
+
+func NewResponderHandler(
+	postgres *database.PostgresDB,
+	responders *services.ResponderService,
+	audit *services.AuditLogger,
+) *ResponderHandler {
+	return &ResponderHandler{
+		postgres:   postgres,
+		responders: responders,
+		audit:      audit,
+	}
+}
+
+// GET /responder/alerts?lat=&lng=&radius_m=3000&since=&limit=50&offset=0
+// Unresolved alerts raised since since (default 2h ago, at most 24h) whose
+// user was last seen within radius_m of lat, lng, clamped to the key's
+// operating area. Every query is audited, including empty ones.
+func (h *ResponderHandler) ListAlerts(c *gin.Context) {
+	key := middleware.Responder(c)
+
+	lat, ok := coordinateParam(c, "lat", 90)
+	if !ok {
+		return
+	}
+	lng, ok := coordinateParam(c, "lng", 180)
+	if !ok {
+		return
+	}
+
+	radiusM := float64(services.ResponderDefaultRadiusM)
+	if v := c.Query("radius_m"); v != "" {
+		r, err := strconv.ParseFloat(v, 64)
+		if err != nil || r <= 0 || r > services.ResponderMaxRadiusM {
+			middleware.AbortWithError(c, apierror.Invalid("radius_m", "must be between 0 and "+strconv.Itoa(services.ResponderMaxRadiusM)))
+			return
+		}
+		radiusM = r
+	}
+
+	now := time.Now()
+	since := now.Add(-services.ResponderDefaultWindow)
+	if v := c.Query("since"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			middleware.AbortWithError(c, apierror.Invalid("since", "must be an RFC3339 timestamp"))
+			return
+		}
+		if t.Before(now.Add(-services.ResponderMaxWindow)) {
+			middleware.AbortWithError(c, apierror.Invalid("since", "must be within the last 24 hours"))
+			return
+		}
+		since = t
+	}
+
+	limit, offset := paginationParams(c, 50, 200)
+	alerts, total, err := h.responders.FindAlerts(c.Request.Context(), key, lat, lng, radiusM, since, limit, offset)
+	if err != nil {
+		middleware.AbortWithError(c, apierror.Internal("database error", err))
+		return
+	}
+
+	alertIDs := make([]string, 0, len(alerts))
+	for _, a := range alerts {
+		alertIDs = append(alertIDs, a.AlertID.String())
+	}
+	recordAudit(c, h.audit, &models.AuditEvent{
+		Action:     services.AuditResponderQuery,
+		ObjectType: "alert",
+		Metadata: map[string]interface{}{
+			"organization": key.Organization,
+			"lat":          lat,
+			"lng":          lng,
+			"radius_m":     radiusM,
+			"since":        since,
+			"total":        total,
+			"alert_ids":    alertIDs,
+		},
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"alerts": alerts,
+		"total":  total,
+		"limit":  limit,
+		"offset": offset,
+	})
+}
+
+// coordinateParam reads a required latitude or longitude query param. It
+// writes the error response itself and returns false on failure.
+func coordinateParam(c *gin.Context, name string, bound float64) (float64, bool) {
+	v, err := strconv.ParseFloat(c.Query(name), 64)
+	if err != nil || v < -bound || v > bound {
+		middleware.AbortWithError(c, apierror.Invalid(name, "must be a number between -"+strconv.Itoa(int(bound))+" and "+strconv.Itoa(int(bound))))
+		return 0, false
+	}
+	return v, true
+}
+
+// POST /responder/ack/:token
+// Records that the responder has seen the alert. The token comes from the
+// alert's ack_token and only works with the key it was issued to.
+func (h *ResponderHandler) Acknowledge(c *gin.Context) {
+	key := middleware.Responder(c)
+
+	alertID, userID, err := h.responders.Acknowledge(c.Request.Context(), key, c.Param("token"))
+	if err != nil {
+		middleware.AbortWithError(c, apierror.Internal("failed to acknowledge alert", err))
+		return
+	}
+	if userID == nil {
+		middleware.AbortWithError(c, apierror.NotFound("alert not found or already resolved"))
+		return
+	}
+
+	recordAudit(c, h.audit, &models.AuditEvent{
+		Action:        services.AuditResponderAck,
+		ObjectType:    "alert",
+		ObjectID:      alertID.String(),
+		SubjectUserID: userID,
+		Metadata:      map[string]interface{}{"organization": key.Organization},
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":   "acknowledged",
+		"alert_id": alertID,
+	})
+}
+
+type ResponderKeyRequest struct {
+	Organization  string          `json:"organization" binding:"required,max=200"`
+	OperatingArea models.Geofence `json:"operating_area" binding:"required"` // polygon the key's queries are clamped to
+}
+
+// POST /admin/responder-keys
+// The key itself is only in this response; store it now
+func (h *ResponderHandler) IssueKey(c *gin.Context) {
+	var req ResponderKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		middleware.AbortWithError(c, apierror.Validation(err))
+		return
+	}
+	if err := services.ValidateOperatingArea(req.OperatingArea); err != nil {
+		middleware.AbortWithError(c, apierror.Invalid("operating_area", err.Error()))
+		return
+	}
+
+	key, raw, err := h.responders.IssueKey(c.Request.Context(), req.Organization, req.OperatingArea)
+	if err != nil {
+		middleware.AbortWithError(c, apierror.Internal("failed to issue responder key", err))
+		return
+	}
+
+	recordAudit(c, h.audit, &models.AuditEvent{
+		Action:     services.AuditResponderKeyIssue,
+		ObjectType: "responder_key",
+		ObjectID:   key.ID.String(),
+		Metadata:   map[string]interface{}{"organization": key.Organization},
+	})
+
+	c.JSON(http.StatusCreated, gin.H{
+		"responder_key": key,
+		"key":           raw,
+	})
+}
+
+// GET /admin/responder-keys
+func (h *ResponderHandler) ListKeys(c *gin.Context) {
+	keys, err := h.postgres.ListResponderKeys(c.Request.Context())
+	if err != nil {
+		middleware.AbortWithError(c, apierror.Internal("database error", err))
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"responder_keys": keys})
+}
+
+// DELETE /admin/responder-keys/:key_id
+func (h *ResponderHandler) RevokeKey(c *gin.Context) {
+	keyID := params.Get(c, params.Responder)
+
+	revoked, err := h.postgres.RevokeResponderKey(c.Request.Context(), keyID)
+	if err != nil {
+		middleware.AbortWithError(c, apierror.Internal("failed to revoke responder key", err))
+		return
+	}
+	if !revoked {
+		middleware.AbortWithError(c, apierror.NotFound("responder key not found or already revoked"))
+		return
+	}
+
+	recordAudit(c, h.audit, &models.AuditEvent{
+		Action:     services.AuditResponderKeyRevoke,
+		ObjectType: "responder_key",
+		ObjectID:   keyID.String(),
+	})
+
+	c.JSON(http.StatusOK, gin.H{"status": "revoked"})
+}
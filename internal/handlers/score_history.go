@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/apierror"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/config"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/database"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/middleware"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/services"
+)
+
+type ScoreHistoryHandler struct {
+	cfg      *config.Config
+	postgres *database.PostgresDB
+	audit    *services.AuditLogger
+}
+
+func NewScoreHistoryHandler(
+	cfg *config.Config,
+	postgres *database.PostgresDB,
+	audit *services.AuditLogger,
+) *ScoreHistoryHandler {
+	return &ScoreHistoryHandler{
+		cfg:      cfg,
+		postgres: postgres,
+		audit:    audit,
+	}
+}
+
+// GET /v1/user/:user_id/score-history?from=&to=&limit=500
+// Evaluation results oldest first, for charting. Defaults to the last 24 hours.
+func (h *ScoreHistoryHandler) GetScoreHistory(c *gin.Context) {
+	user, ok := loadAuthorizedUser(c, h.postgres)
+	if !ok {
+		return
+	}
+
+	from, to, ok := timeRangeParams(c, 24*time.Hour)
+	if !ok {
+		return
+	}
+
+	limit, _ := paginationParams(c, 500, 2000)
+
+	records, err := h.postgres.GetScoreHistory(c.Request.Context(), user.ID, from, to, limit)
+	if err != nil {
+		middleware.AbortWithError(c, apierror.Internal("failed to get score history", err))
+		return
+	}
+	if records == nil {
+		records = []models.ScoreRecord{}
+	}
+
+	recordAudit(c, h.audit, &models.AuditEvent{
+		Action:        services.AuditScoreHistoryView,
+		ObjectType:    "score_history",
+		SubjectUserID: &user.ID,
+		Metadata:      map[string]interface{}{"from": from, "to": to, "count": len(records)},
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"user_id": user.ID,
+		"from":    from,
+		"to":      to,
+		"scores":  records,
+	})
+}
+
+// GET /v1/user/:user_id/state-history?since=
+// Recorded state transitions newest first. Defaults to the last 7 days.
+func (h *ScoreHistoryHandler) GetStateHistory(c *gin.Context) {
+	user, ok := loadAuthorizedUser(c, h.postgres)
+	if !ok {
+		return
+	}
+
+	since := time.Now().Add(-7 * 24 * time.Hour)
+	if v := c.Query("since"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			middleware.AbortWithError(c, apierror.Invalid("since", "must be an RFC3339 timestamp"))
+			return
+		}
+		since = t
+	}
+
+	transitions, err := h.postgres.GetStateTransitions(c.Request.Context(), user.ID, since)
+	if err != nil {
+		middleware.AbortWithError(c, apierror.Internal("failed to get state history", err))
+		return
+	}
+	if transitions == nil {
+		transitions = []models.StateTransition{}
+	}
+
+	recordAudit(c, h.audit, &models.AuditEvent{
+		Action:        services.AuditStateHistoryView,
+		ObjectType:    "state_history",
+		SubjectUserID: &user.ID,
+		Metadata:      map[string]interface{}{"since": since, "count": len(transitions)},
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"user_id":     user.ID,
+		"since":       since,
+		"transitions": transitions,
+	})
+}
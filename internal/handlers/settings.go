@@ -0,0 +1,216 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/apierror"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/services"
+)
+
+// Bounds of the settings clamped rather than rejected. Heartbeat intervals
+// are clamped to HEARTBEAT_INTERVAL_MIN_SECONDS and _MAX_SECONDS, which are
+// themselves within 60 to 3600.
+const (
+	// maxSafeZones is how many safe zones a user may set
+	maxSafeZones           = 10
+	silentPromptMinSeconds = 5
+	silentPromptMaxSeconds = 120
+)
+
+// panicGestures are the panic gestures the app recognizes
+var panicGestures = []string{"power_button_3x", "shake"}
+
+// escalationOnAck are the values of escalation_on_ack; empty is the default, skip
+var escalationOnAck = []string{"", services.EscalationOnAckSkip, services.EscalationOnAckDelay, services.EscalationOnAckIgnore}
+
+// intervalSettings are the settings the heartbeat interval advice and the
+// stale-user monitor's schedule depend on
+var intervalSettings = []string{"heartbeat_interval", "max_heartbeat_interval", "safe_zones"}
+
+// settingsFields maps each setting's JSON name to its field index in
+// models.UserSettings; a PATCH naming anything else is rejected
+var settingsFields = func() map[string]int {
+	t := reflect.TypeOf(models.UserSettings{})
+	fields := make(map[string]int, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name != "" && name != "-" {
+			fields[name] = i
+		}
+	}
+	return fields
+}()
+
+// settingsAdjustment is a patched setting clamped into its bounds
+type settingsAdjustment struct {
+	Field     string `json:"field"`
+	Requested int    `json:"requested"`
+	Applied   int    `json:"applied"`
+}
+
+// mergeSettings applies a JSON Merge Patch (RFC 7396) to settings: a field
+// set to null goes back to its default and one left out is unchanged. It
+// returns the merged settings, or every field that isn't a setting or has
+// the wrong type.
+func mergeSettings(current, defaults models.UserSettings, patch map[string]json.RawMessage) (models.UserSettings, []apierror.FieldError) {
+	merged := current
+	target := reflect.ValueOf(&merged).Elem()
+	var fields []apierror.FieldError
+	for _, name := range sortedSettings(patch) {
+		index, ok := settingsFields[name]
+		if !ok {
+			fields = append(fields, apierror.FieldError{Field: name, Reason: "is not a setting"})
+			continue
+		}
+
+		field := target.Field(index)
+		if string(patch[name]) == "null" {
+			field.Set(reflect.ValueOf(defaults).Field(index))
+			continue
+		}
+		value := reflect.New(field.Type())
+		if err := json.Unmarshal(patch[name], value.Interface()); err != nil {
+			reason := "is not valid"
+			var typeErr *json.UnmarshalTypeError
+			if errors.As(err, &typeErr) {
+				reason = "must be " + jsonKind(field.Type())
+			}
+			fields = append(fields, apierror.FieldError{Field: name, Reason: reason})
+			continue
+		}
+		field.Set(value.Elem())
+	}
+	return merged, fields
+}
+
+// checkSettings clamps the patched numeric settings into their bounds and
+// validates the rest. lo and hi bound heartbeat intervals.
+func checkSettings(s *models.UserSettings, patch map[string]json.RawMessage, lo, hi int) ([]settingsAdjustment, []apierror.FieldError) {
+	var adjusted []settingsAdjustment
+	var fields []apierror.FieldError
+	clamp := func(name string, v *int, min, max int) {
+		requested := *v
+		if *v < min {
+			*v = min
+		} else if *v > max {
+			*v = max
+		}
+		if *v != requested {
+			adjusted = append(adjusted, settingsAdjustment{Field: name, Requested: requested, Applied: *v})
+		}
+	}
+	oneOf := func(name, v string, allowed []string) {
+		for _, a := range allowed {
+			if v == a {
+				return
+			}
+		}
+		fields = append(fields, apierror.FieldError{Field: name, Reason: "must be one of " + strings.Join(nonEmpty(allowed), ", ")})
+	}
+
+	for _, name := range sortedSettings(patch) {
+		switch name {
+		case "heartbeat_interval":
+			clamp(name, &s.HeartbeatInterval, lo, hi)
+		case "max_heartbeat_interval":
+			// 0 removes the user's own limit
+			if s.MaxHeartbeatInterval != 0 {
+				clamp(name, &s.MaxHeartbeatInterval, lo, hi)
+			}
+		case "silent_prompt_timeout":
+			clamp(name, &s.SilentPromptTimeout, silentPromptMinSeconds, silentPromptMaxSeconds)
+		case "silent_prompt_ladder":
+			if err := services.ValidatePromptLadder(s.SilentPromptLadder); err != nil {
+				fields = append(fields, apierror.FieldError{Field: name, Reason: err.Error()})
+			}
+		case "panic_gesture":
+			oneOf(name, s.PanicGesture, panicGestures)
+		case "escalation_on_ack":
+			oneOf(name, s.EscalationOnAck, escalationOnAck)
+		case "escalation_ack_delay_minutes":
+			if s.EscalationAckDelayMinutes < 0 {
+				fields = append(fields, apierror.FieldError{Field: name, Reason: "must not be negative"})
+			}
+		case "safe_zones":
+			if len(s.SafeZones) > maxSafeZones {
+				fields = append(fields, apierror.FieldError{Field: name, Reason: fmt.Sprintf("at most %d allowed", maxSafeZones)})
+				continue
+			}
+			for i, zone := range s.SafeZones {
+				if err := services.ValidateGeofence(zone); err != nil {
+					fields = append(fields, apierror.FieldError{Field: fmt.Sprintf("safe_zones[%d]", i), Reason: err.Error()})
+				}
+			}
+		case "auto_resolve_zones":
+			if err := services.ValidateAutoResolveZones(s.AutoResolveZones, s.SafeZones); err != nil {
+				fields = append(fields, apierror.FieldError{Field: name, Reason: err.Error()})
+			}
+		case "timezone":
+			if s.Timezone != "" {
+				if err := services.ValidateTimezone(s.Timezone); err != nil {
+					fields = append(fields, apierror.FieldError{Field: name, Reason: err.Error()})
+				}
+			}
+		}
+	}
+	return adjusted, fields
+}
+
+// settingsChanges returns each setting that differs between before and
+// after, by JSON name, with its old and new value. Safe zones are given as
+// counts, keeping their coordinates out of the audit log.
+func settingsChanges(before, after models.UserSettings) map[string]interface{} {
+	b, a := reflect.ValueOf(before), reflect.ValueOf(after)
+	changes := make(map[string]interface{})
+	for name, index := range settingsFields {
+		from, to := b.Field(index).Interface(), a.Field(index).Interface()
+		if reflect.DeepEqual(from, to) {
+			continue
+		}
+		if name == "safe_zones" {
+			from, to = len(before.SafeZones), len(after.SafeZones)
+		}
+		changes[name] = map[string]interface{}{"from": from, "to": to}
+	}
+	return changes
+}
+
+func sortedSettings(patch map[string]json.RawMessage) []string {
+	names := make([]string, 0, len(patch))
+	for name := range patch {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func nonEmpty(values []string) []string {
+	var out []string
+	for _, v := range values {
+		if v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// jsonKind names the JSON type a Go type is decoded from
+func jsonKind(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Int, reflect.Int64, reflect.Int32:
+		return "an integer"
+	case reflect.String:
+		return "a string"
+	case reflect.Slice:
+		return "an array"
+	}
+	return "an object"
+}
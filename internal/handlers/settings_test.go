@@ -0,0 +1,255 @@
+package handlers
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+)
+
+// Heartbeat interval bounds the settings below are checked against
+const (
+	testIntervalMin = 60
+	testIntervalMax = 3600
+)
+
+var (
+	currentSettings = models.UserSettings{
+		HeartbeatInterval:   300,
+		SilentPromptTimeout: 30,
+		PanicGesture:        "shake",
+		Timezone:            "Africa/Lagos",
+	}
+	defaultSettings = models.UserSettings{
+		HeartbeatInterval:   600,
+		SilentPromptTimeout: 45,
+		PanicGesture:        "power_button_3x",
+		Timezone:            "Africa/Lagos",
+	}
+)
+
+// patchSettings runs a PATCH body through the handler's merge and checks
+func patchSettings(t *testing.T, body string) (models.UserSettings, []settingsAdjustment, map[string]string) {
+	t.Helper()
+	var patch map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(body), &patch); err != nil {
+		t.Fatalf("patch %s: %v", body, err)
+	}
+	merged, fields := mergeSettings(currentSettings, defaultSettings, patch)
+	adjusted, invalid := checkSettings(&merged, patch, testIntervalMin, testIntervalMax)
+	reasons := map[string]string{}
+	for _, f := range append(fields, invalid...) {
+		reasons[f.Field] = f.Reason
+	}
+	return merged, adjusted, reasons
+}
+
+func TestSettingsPatch(t *testing.T) {
+	lagos := `{"type":"radius","center":{"lat":6.5244,"lng":3.3792},"radius_m":500}`
+	tests := []struct {
+		name     string
+		patch    string
+		want     func(s *models.UserSettings) // applied to currentSettings
+		adjusted []settingsAdjustment
+		invalid  map[string]string
+	}{
+		{
+			name:  "empty patch",
+			patch: `{}`,
+		},
+		{
+			name:  "one field",
+			patch: `{"heartbeat_interval":900}`,
+			want:  func(s *models.UserSettings) { s.HeartbeatInterval = 900 },
+		},
+		{
+			name:  "null resets to the default",
+			patch: `{"heartbeat_interval":null,"panic_gesture":null}`,
+			want: func(s *models.UserSettings) {
+				s.HeartbeatInterval = 600
+				s.PanicGesture = "power_button_3x"
+			},
+		},
+		{
+			name:     "interval below the minimum",
+			patch:    `{"heartbeat_interval":1}`,
+			want:     func(s *models.UserSettings) { s.HeartbeatInterval = 60 },
+			adjusted: []settingsAdjustment{{Field: "heartbeat_interval", Requested: 1, Applied: 60}},
+		},
+		{
+			name:     "interval above the maximum",
+			patch:    `{"heartbeat_interval":86400}`,
+			want:     func(s *models.UserSettings) { s.HeartbeatInterval = 3600 },
+			adjusted: []settingsAdjustment{{Field: "heartbeat_interval", Requested: 86400, Applied: 3600}},
+		},
+		{
+			name:  "interval on the bounds",
+			patch: `{"heartbeat_interval":60,"max_heartbeat_interval":3600}`,
+			want: func(s *models.UserSettings) {
+				s.HeartbeatInterval = 60
+				s.MaxHeartbeatInterval = 3600
+			},
+		},
+		{
+			name:     "max interval clamped",
+			patch:    `{"max_heartbeat_interval":10}`,
+			want:     func(s *models.UserSettings) { s.MaxHeartbeatInterval = 60 },
+			adjusted: []settingsAdjustment{{Field: "max_heartbeat_interval", Requested: 10, Applied: 60}},
+		},
+		{
+			name:  "max interval of 0 removes the limit",
+			patch: `{"max_heartbeat_interval":0}`,
+		},
+		{
+			name:     "prompt timeout of 0",
+			patch:    `{"silent_prompt_timeout":0}`,
+			want:     func(s *models.UserSettings) { s.SilentPromptTimeout = 5 },
+			adjusted: []settingsAdjustment{{Field: "silent_prompt_timeout", Requested: 0, Applied: 5}},
+		},
+		{
+			name:     "prompt timeout above the maximum",
+			patch:    `{"silent_prompt_timeout":600}`,
+			want:     func(s *models.UserSettings) { s.SilentPromptTimeout = 120 },
+			adjusted: []settingsAdjustment{{Field: "silent_prompt_timeout", Requested: 600, Applied: 120}},
+		},
+		{
+			name:  "several clamped at once",
+			patch: `{"silent_prompt_timeout":1,"heartbeat_interval":5}`,
+			want: func(s *models.UserSettings) {
+				s.HeartbeatInterval = 60
+				s.SilentPromptTimeout = 5
+			},
+			adjusted: []settingsAdjustment{
+				{Field: "heartbeat_interval", Requested: 5, Applied: 60},
+				{Field: "silent_prompt_timeout", Requested: 1, Applied: 5},
+			},
+		},
+		{
+			name:  "known panic gesture",
+			patch: `{"panic_gesture":"power_button_3x"}`,
+			want:  func(s *models.UserSettings) { s.PanicGesture = "power_button_3x" },
+		},
+		{
+			name:    "unknown panic gesture",
+			patch:   `{"panic_gesture":"wink"}`,
+			invalid: map[string]string{"panic_gesture": "must be one of power_button_3x, shake"},
+		},
+		{
+			name:    "empty panic gesture",
+			patch:   `{"panic_gesture":""}`,
+			invalid: map[string]string{"panic_gesture": "must be one of power_button_3x, shake"},
+		},
+		{
+			name:    "unknown escalation on ack",
+			patch:   `{"escalation_on_ack":"later"}`,
+			invalid: map[string]string{"escalation_on_ack": "must be one of skip, delay, ignore"},
+		},
+		{
+			name:    "negative ack delay",
+			patch:   `{"escalation_ack_delay_minutes":-5}`,
+			invalid: map[string]string{"escalation_ack_delay_minutes": "must not be negative"},
+		},
+		{
+			name:    "unknown fields are each listed",
+			patch:   `{"heartbeat_interval":900,"colour":"blue","is_admin":true}`,
+			invalid: map[string]string{"colour": "is not a setting", "is_admin": "is not a setting"},
+		},
+		{
+			name:    "wrong types",
+			patch:   `{"heartbeat_interval":"fast","share_audio":"yes","panic_gesture":3,"safe_zones":{}}`,
+			invalid: map[string]string{"heartbeat_interval": "must be an integer", "share_audio": "must be a boolean", "panic_gesture": "must be a string", "safe_zones": "must be an array"},
+		},
+		{
+			name:    "fractional interval",
+			patch:   `{"heartbeat_interval":90.5}`,
+			invalid: map[string]string{"heartbeat_interval": "must be an integer"},
+		},
+		{
+			name:    "unknown and invalid together",
+			patch:   `{"colour":"blue","panic_gesture":"wink"}`,
+			invalid: map[string]string{"colour": "is not a setting", "panic_gesture": "must be one of power_button_3x, shake"},
+		},
+		{
+			name:    "unknown prompt channel",
+			patch:   `{"silent_prompt_ladder":["sms","carrier_pigeon"]}`,
+			invalid: map[string]string{"silent_prompt_ladder": `unknown channel "carrier_pigeon"`},
+		},
+		{
+			name:    "bad time zone",
+			patch:   `{"timezone":"Lagos"}`,
+			invalid: map[string]string{"timezone": "must be an IANA time zone, e.g. Africa/Lagos"},
+		},
+		{
+			name:  "safe zone",
+			patch: `{"safe_zones":[` + lagos + `]}`,
+			want: func(s *models.UserSettings) {
+				s.SafeZones = []models.Geofence{{Type: "radius", Center: &models.GeoPoint{Lat: 6.5244, Lng: 3.3792}, RadiusM: 500}}
+			},
+		},
+		{
+			name:    "invalid safe zone is named by index",
+			patch:   `{"safe_zones":[` + lagos + `,{"type":"radius","radius_m":500}]}`,
+			invalid: map[string]string{"safe_zones[1]": "radius geofence requires center"},
+		},
+		{
+			name:    "auto-resolve zone that isn't a safe zone",
+			patch:   `{"auto_resolve_zones":[0]}`,
+			invalid: map[string]string{"auto_resolve_zones": "0 is not the index of a safe zone"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			merged, adjusted, invalid := patchSettings(t, tt.patch)
+			if len(tt.invalid) > 0 || len(invalid) > 0 {
+				if !reflect.DeepEqual(invalid, tt.invalid) {
+					t.Errorf("invalid = %v, want %v", invalid, tt.invalid)
+				}
+				return
+			}
+			want := currentSettings
+			if tt.want != nil {
+				tt.want(&want)
+			}
+			if !reflect.DeepEqual(merged, want) {
+				t.Errorf("settings = %+v, want %+v", merged, want)
+			}
+			if !reflect.DeepEqual(adjusted, tt.adjusted) {
+				t.Errorf("adjusted = %+v, want %+v", adjusted, tt.adjusted)
+			}
+		})
+	}
+}
+
+// Every setting the PATCH can name is a field of UserSettings, and back
+func TestSettingsFields(t *testing.T) {
+	typ := reflect.TypeOf(models.UserSettings{})
+	if len(settingsFields) != typ.NumField() {
+		t.Errorf("%d settings for %d fields", len(settingsFields), typ.NumField())
+	}
+	for name, index := range settingsFields {
+		tag := typ.Field(index).Tag.Get("json")
+		if tag != name && tag != name+",omitempty" {
+			t.Errorf("%s maps to field %s tagged %q", name, typ.Field(index).Name, tag)
+		}
+	}
+}
+
+func TestSettingsChanges(t *testing.T) {
+	after := currentSettings
+	after.HeartbeatInterval = 900
+	after.SafeZones = []models.Geofence{{Type: "radius", Center: &models.GeoPoint{Lat: 6.5244, Lng: 3.3792}, RadiusM: 500}}
+
+	got := settingsChanges(currentSettings, after)
+	want := map[string]interface{}{
+		"heartbeat_interval": map[string]interface{}{"from": 300, "to": 900},
+		"safe_zones":         map[string]interface{}{"from": 0, "to": 1},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("changes = %v, want %v", got, want)
+	}
+	if got := settingsChanges(currentSettings, currentSettings); len(got) != 0 {
+		t.Errorf("no change: got %v", got)
+	}
+}
@@ -0,0 +1,244 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/apierror"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/config"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/database"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/middleware"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/services"
+)
+
+const (
+	defaultShadowSamples = 20
+	maxShadowSamples     = 200
+)
+
+type ShadowHandler struct {
+	cfg      *config.Store
+	postgres *database.PostgresDB
+	profiles *services.ScoringProfileStore
+	shadow   *services.ShadowEvaluator
+	audit    *services.AuditLogger
+}
+
+func NewShadowHandler(
+	cfg *config.Store,
+	postgres *database.PostgresDB,
+	profiles *services.ScoringProfileStore,
+	shadow *services.ShadowEvaluator,
+	audit *services.AuditLogger,
+) *ShadowHandler {
+	return &ShadowHandler{
+		cfg:      cfg,
+		postgres: postgres,
+		profiles: profiles,
+		shadow:   shadow,
+		audit:    audit,
+	}
+}
+
+// SetCandidateRequest is a candidate profile given as overrides applied to
+// the active profile
+type SetCandidateRequest struct {
+	Profile json.RawMessage `json:"profile" binding:"required"`
+	Note    string          `json:"note" binding:"max=500"`
+}
+
+type PromoteCandidateRequest struct {
+	CandidateID uuid.UUID `json:"candidate_id" binding:"required"`
+}
+
+// GET /admin/shadow/candidate
+// The active profile in effect and the candidate being shadowed, if any
+func (h *ShadowHandler) GetCandidate(c *gin.Context) {
+	c.JSON(http.StatusOK, h.profilesBody())
+}
+
+// PUT /admin/shadow/candidate
+// Starts shadowing a candidate profile, replacing any previous candidate
+func (h *ShadowHandler) SetCandidate(c *gin.Context) {
+	var req SetCandidateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		middleware.AbortWithError(c, apierror.Validation(err))
+		return
+	}
+
+	profile := h.profiles.Active(h.cfg.Current())
+	if err := json.Unmarshal(req.Profile, &profile); err != nil {
+		middleware.AbortWithError(c, apierror.Invalid("profile", "must be a scoring profile object"))
+		return
+	}
+	if err := profile.Validate(); err != nil {
+		middleware.AbortWithError(c, apierror.Invalid("profile", err.Error()))
+		return
+	}
+
+	admin := middleware.Principal(c)
+	candidate, err := h.profiles.SetCandidate(c.Request.Context(), profile, req.Note, admin.Subject)
+	if err != nil {
+		middleware.AbortWithError(c, apierror.Internal("failed to store candidate profile", err))
+		return
+	}
+
+	log.Printf("INFO: Scoring profile %s set as candidate by %s", candidate.ID, admin.Subject)
+
+	recordAudit(c, h.audit, &models.AuditEvent{
+		Action:     services.AuditCandidateSet,
+		ObjectType: "scoring_profile",
+		ObjectID:   candidate.ID.String(),
+		Metadata:   map[string]interface{}{"note": req.Note},
+	})
+
+	c.JSON(http.StatusOK, h.profilesBody())
+}
+
+// DELETE /admin/shadow/candidate
+// Stops shadow evaluation; recorded results are kept
+func (h *ShadowHandler) ClearCandidate(c *gin.Context) {
+	_, candidate := h.profiles.Current()
+	cleared, err := h.profiles.ClearCandidate(c.Request.Context())
+	if err != nil {
+		middleware.AbortWithError(c, apierror.Internal("failed to clear candidate profile", err))
+		return
+	}
+	if !cleared {
+		middleware.AbortWithError(c, apierror.NotFound("no candidate profile"))
+		return
+	}
+
+	event := &models.AuditEvent{
+		Action:     services.AuditCandidateClear,
+		ObjectType: "scoring_profile",
+	}
+	if candidate != nil {
+		event.ObjectID = candidate.ID.String()
+	}
+	recordAudit(c, h.audit, event)
+
+	c.JSON(http.StatusOK, h.profilesBody())
+}
+
+// POST /admin/shadow/promote
+// Makes the candidate the active profile. candidate_id must name the current
+// candidate, so a candidate replaced after its diff was reviewed is never
+// promoted by mistake.
+func (h *ShadowHandler) Promote(c *gin.Context) {
+	var req PromoteCandidateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		middleware.AbortWithError(c, apierror.Validation(err))
+		return
+	}
+
+	promoted, err := h.profiles.Promote(c.Request.Context(), req.CandidateID)
+	if errors.Is(err, services.ErrNoCandidate) {
+		middleware.AbortWithError(c, apierror.NotFound("no candidate profile"))
+		return
+	}
+	if errors.Is(err, database.ErrCandidateChanged) {
+		middleware.AbortWithError(c, apierror.Conflict("candidate_id is not the current candidate"))
+		return
+	}
+	if err != nil {
+		middleware.AbortWithError(c, apierror.Internal("failed to promote candidate profile", err))
+		return
+	}
+
+	admin := middleware.Principal(c)
+	log.Printf("INFO: Scoring profile %s promoted to active by %s", promoted.ID, admin.Subject)
+
+	recordAudit(c, h.audit, &models.AuditEvent{
+		Action:     services.AuditCandidatePromote,
+		ObjectType: "scoring_profile",
+		ObjectID:   promoted.ID.String(),
+	})
+
+	c.JSON(http.StatusOK, h.profilesBody())
+}
+
+// GET /admin/shadow/diff?from=&to=&samples=20&candidate_id=
+// Where the candidate and the active profile disagreed. Defaults to the
+// current candidate over the last 24 hours.
+func (h *ShadowHandler) GetDiff(c *gin.Context) {
+	from, to, ok := timeRangeParams(c, 24*time.Hour)
+	if !ok {
+		return
+	}
+
+	samples := defaultShadowSamples
+	if v := c.Query("samples"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 || n > maxShadowSamples {
+			middleware.AbortWithError(c, apierror.Invalid("samples", "must be between 0 and 200"))
+			return
+		}
+		samples = n
+	}
+
+	var candidateID uuid.UUID
+	if v := c.Query("candidate_id"); v != "" {
+		id, err := uuid.Parse(v)
+		if err != nil {
+			middleware.AbortWithError(c, apierror.Invalid("candidate_id", "must be a valid UUID"))
+			return
+		}
+		candidateID = id
+	} else {
+		_, candidate := h.profiles.Current()
+		if candidate == nil {
+			middleware.AbortWithError(c, apierror.NotFound("no candidate profile"))
+			return
+		}
+		candidateID = candidate.ID
+	}
+
+	diff, err := h.postgres.GetShadowDiff(c.Request.Context(), candidateID, from, to, samples)
+	if err != nil {
+		middleware.AbortWithError(c, apierror.Internal("failed to summarize shadow results", err))
+		return
+	}
+
+	recordAudit(c, h.audit, &models.AuditEvent{
+		Action:     services.AuditShadowDiffView,
+		ObjectType: "scoring_profile",
+		ObjectID:   candidateID.String(),
+		Metadata:   map[string]interface{}{"from": from, "to": to},
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"candidate_id": candidateID,
+		"from":         from,
+		"to":           to,
+		"diff":         diff,
+		"process": gin.H{
+			"evaluated": h.shadow.Evaluated(),
+			"diverged":  h.shadow.Diverged(),
+			"dropped":   h.shadow.Dropped(),
+		},
+	})
+}
+
+// profilesBody describes the active and candidate profiles. Without a
+// stored active profile the one derived from config is reported.
+func (h *ShadowHandler) profilesBody() gin.H {
+	active, candidate := h.profiles.Current()
+	body := gin.H{
+		"active":            active,
+		"active_profile":    h.profiles.Active(h.cfg.Current()),
+		"candidate":         candidate,
+		"candidate_profile": nil,
+	}
+	if _, profile, ok := h.profiles.Candidate(); ok {
+		body["candidate_profile"] = profile
+	}
+	return body
+}
@@ -0,0 +1,238 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/apierror"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/config"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/database"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/middleware"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/params"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/services"
+)
+
+type SimulationHandler struct {
+	cfg       *config.Config
+	postgres  *database.PostgresDB
+	simulator *services.Simulator
+	replayer  *services.PointInTimeEvaluator
+	store     services.ObjectStore
+	audit     *services.AuditLogger
+}
+
+func NewSimulationHandler(
+	cfg *config.Config,
+	postgres *database.PostgresDB,
+	simulator *services.Simulator,
+	replayer *services.PointInTimeEvaluator,
+	store services.ObjectStore,
+	audit *services.AuditLogger,
+) *SimulationHandler {
+	return &SimulationHandler{
+		cfg:       cfg,
+		postgres:  postgres,
+		simulator: simulator,
+		replayer:  replayer,
+		store:     store,
+		audit:     audit,
+	}
+}
+
+// SimulateRequest takes exactly one heartbeat source: inline heartbeats, a
+// stored blackbox trail, or a user's stored heartbeats in a time range
+type SimulateRequest struct {
+	Heartbeats []models.Heartbeat `json:"heartbeats"`
+	TrailID    string             `json:"trail_id"`
+	UserID     string             `json:"user_id"`
+	From       *time.Time         `json:"from"`
+	To         *time.Time         `json:"to"`
+
+	Profile     json.RawMessage `json:"profile"`      // overrides applied to the live profile
+	TickSeconds int             `json:"tick_seconds"` // also evaluate between heartbeats
+	Until       *time.Time      `json:"until"`        // keep ticking until this time
+}
+
+// POST /admin/simulate
+func (h *SimulationHandler) Simulate(c *gin.Context) {
+	var req SimulateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		middleware.AbortWithError(c, apierror.Validation(err))
+		return
+	}
+
+	profile := h.simulator.DefaultProfile()
+	if len(req.Profile) > 0 {
+		if err := json.Unmarshal(req.Profile, &profile); err != nil {
+			middleware.AbortWithError(c, apierror.Invalid("profile", "must be a scoring profile object"))
+			return
+		}
+	}
+	if err := profile.Validate(); err != nil {
+		middleware.AbortWithError(c, apierror.Invalid("profile", err.Error()))
+		return
+	}
+
+	if req.TickSeconds < 0 {
+		middleware.AbortWithError(c, apierror.BadRequest("tick_seconds must not be negative"))
+		return
+	}
+
+	heartbeats, apiErr := h.loadHeartbeats(c, &req)
+	if apiErr != nil {
+		middleware.AbortWithError(c, apiErr)
+		return
+	}
+
+	var until time.Time
+	if req.Until != nil {
+		until = *req.Until
+	}
+
+	steps, err := h.simulator.Run(heartbeats, profile, time.Duration(req.TickSeconds)*time.Second, until)
+	if err != nil {
+		middleware.AbortWithError(c, apierror.BadRequest(err.Error()))
+		return
+	}
+
+	event := &models.AuditEvent{
+		Action:     services.AuditSimulationRun,
+		ObjectType: "simulation",
+		Metadata:   map[string]interface{}{"steps": len(steps)},
+	}
+	if req.TrailID != "" {
+		event.ObjectType, event.ObjectID = "blackbox_trail", req.TrailID
+	}
+	if len(heartbeats) > 0 && req.UserID+req.TrailID != "" {
+		event.SubjectUserID = &heartbeats[0].UserID
+	}
+	recordAudit(c, h.audit, event)
+
+	c.JSON(http.StatusOK, gin.H{
+		"profile": profile,
+		"steps":   steps,
+	})
+}
+
+type EvaluateAtRequest struct {
+	At        time.Time `json:"at" binding:"required"`
+	ProfileID string    `json:"profile_id"` // a stored profile instead of the one active then
+}
+
+// POST /admin/users/:user_id/evaluate-at
+// What the evaluator would have decided about the user at a past time, with
+// the data it had then, next to what was recorded. Nothing is written and
+// nobody is notified.
+func (h *SimulationHandler) EvaluateAt(c *gin.Context) {
+	var req EvaluateAtRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		middleware.AbortWithError(c, apierror.Validation(err))
+		return
+	}
+	if req.At.After(time.Now()) {
+		middleware.AbortWithError(c, apierror.Invalid("at", "must not be in the future"))
+		return
+	}
+	var profileID *uuid.UUID
+	if req.ProfileID != "" {
+		id, err := uuid.Parse(req.ProfileID)
+		if err != nil {
+			middleware.AbortWithError(c, apierror.Invalid("profile_id", "must be a valid UUID"))
+			return
+		}
+		profileID = &id
+	}
+
+	userID := params.Get(c, params.User)
+	eval, err := h.replayer.EvaluateAt(c.Request.Context(), userID, req.At, profileID)
+	if errors.Is(err, services.ErrScoringProfileNotFound) {
+		middleware.AbortWithError(c, apierror.NotFound("scoring profile not found"))
+		return
+	}
+	if err != nil {
+		middleware.AbortWithError(c, apierror.Internal("failed to evaluate", err))
+		return
+	}
+
+	recordAudit(c, h.audit, &models.AuditEvent{
+		Action:        services.AuditEvaluateAt,
+		ObjectType:    "user",
+		ObjectID:      userID.String(),
+		SubjectUserID: &userID,
+		Metadata: map[string]interface{}{
+			"at":       eval.At,
+			"profile":  eval.Inputs.ProfileSource,
+			"diverged": eval.Diverged,
+		},
+	})
+
+	c.JSON(http.StatusOK, gin.H{"evaluation": eval})
+}
+
+// loadHeartbeats resolves the request's heartbeat source
+func (h *SimulationHandler) loadHeartbeats(c *gin.Context, req *SimulateRequest) ([]models.Heartbeat, *apierror.Error) {
+	sources := 0
+	if len(req.Heartbeats) > 0 {
+		sources++
+	}
+	if req.TrailID != "" {
+		sources++
+	}
+	if req.UserID != "" {
+		sources++
+	}
+	if sources != 1 {
+		return nil, apierror.BadRequest("provide exactly one of heartbeats, trail_id or user_id")
+	}
+
+	ctx := c.Request.Context()
+
+	switch {
+	case len(req.Heartbeats) > 0:
+		if len(req.Heartbeats) > services.MaxSimulationSteps {
+			return nil, apierror.Invalid("heartbeats", fmt.Sprintf("must contain at most %d entries", services.MaxSimulationSteps))
+		}
+		return req.Heartbeats, nil
+
+	case req.TrailID != "":
+		trailID, err := uuid.Parse(req.TrailID)
+		if err != nil {
+			return nil, apierror.Invalid("trail_id", "must be a valid UUID")
+		}
+		trail, err := h.postgres.GetBlackboxTrail(ctx, trailID)
+		if err != nil {
+			return nil, apierror.Internal("database error", err)
+		}
+		if trail == nil {
+			return nil, apierror.NotFound("trail not found")
+		}
+		entries, err := services.LoadBlackboxEntries(ctx, h.store, trail.FileURL)
+		if err != nil && !services.IsInlineTrail(trail.FileURL) {
+			return nil, apierror.Unavailable("trail data could not be read from object storage").WithCause(err)
+		}
+		if err != nil {
+			return nil, apierror.New(http.StatusUnprocessableEntity, apierror.CodeInvalidRequest, "trail data could not be decoded").WithCause(err)
+		}
+		return services.BlackboxHeartbeats(trail.UserID, entries), nil
+
+	default:
+		userID, err := uuid.Parse(req.UserID)
+		if err != nil {
+			return nil, apierror.Invalid("user_id", "must be a valid UUID")
+		}
+		if req.From == nil || req.To == nil || !req.To.After(*req.From) {
+			return nil, apierror.BadRequest("from and to are required with user_id, and to must be after from")
+		}
+		heartbeats, err := h.postgres.GetHeartbeatsBetween(ctx, userID, *req.From, *req.To, services.MaxSimulationSteps, false)
+		if err != nil {
+			return nil, apierror.Internal("database error", err)
+		}
+		return heartbeats, nil
+	}
+}
@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/apierror"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/cache"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/config"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/middleware"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/services"
+)
+
+const (
+	// sloMaxSpan is the longest range an SLO report covers
+	sloMaxSpan = 92 * 24 * time.Hour
+	// sloMaxWorst is how many of the slowest alerts a report can list
+	sloMaxWorst = 100
+)
+
+type SLOHandler struct {
+	cfg    *config.Store
+	slo    *services.AlertSLO
+	caches *cache.Registry
+	audit  *services.AuditLogger
+}
+
+func NewSLOHandler(cfg *config.Store, slo *services.AlertSLO, caches *cache.Registry, audit *services.AuditLogger) *SLOHandler {
+	return &SLOHandler{
+		cfg:    cfg,
+		slo:    slo,
+		caches: caches,
+		audit:  audit,
+	}
+}
+
+// GET /metrics
+// Time-to-alert and in-process cache metrics in the Prometheus text format,
+// for scrapers holding METRICS_TOKEN as a bearer token
+func (h *SLOHandler) Metrics(c *gin.Context) {
+	token := h.cfg.Current().MetricsToken
+	if token == "" {
+		middleware.AbortWithError(c, apierror.NotFound("metrics are not enabled"))
+		return
+	}
+	bearer := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(bearer), []byte(token)) != 1 {
+		middleware.AbortWithError(c, apierror.Unauthorized("invalid metrics token"))
+		return
+	}
+
+	c.Header("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	c.Status(http.StatusOK)
+	h.slo.WriteMetrics(c.Request.Context(), c.Writer)
+	h.caches.WriteMetrics(c.Writer)
+}
+
+// GET /admin/slo?from=&to=&worst=10
+// Time-to-alert over the alerts raised in a range, by default the last 7
+// days: percentiles overall and per stage, the distribution, and the
+// slowest alerts with where their time went
+func (h *SLOHandler) GetSLO(c *gin.Context) {
+	from, to, ok := timeRangeParams(c, 7*24*time.Hour)
+	if !ok {
+		return
+	}
+	if to.Sub(from) > sloMaxSpan {
+		middleware.AbortWithError(c, apierror.Invalid("from", "range must not exceed 92 days"))
+		return
+	}
+	worst, err := strconv.Atoi(c.DefaultQuery("worst", "10"))
+	if err != nil || worst < 0 || worst > sloMaxWorst {
+		middleware.AbortWithError(c, apierror.Invalid("worst", "must be between 0 and 100"))
+		return
+	}
+
+	report, err := h.slo.Report(c.Request.Context(), from, to, worst)
+	if err != nil {
+		middleware.AbortWithError(c, apierror.Internal("failed to build SLO report", err))
+		return
+	}
+
+	recordAudit(c, h.audit, &models.AuditEvent{
+		Action:     services.AuditSLOView,
+		ObjectType: "slo",
+		Metadata:   map[string]interface{}{"from": from, "to": to, "alerts": report.Alerts},
+	})
+
+	c.JSON(http.StatusOK, report)
+}
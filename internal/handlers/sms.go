@@ -1,12 +1,18 @@
 package handlers
 
 import (
+	"context"
+	"errors"
+	"log"
 	"net/http"
 	"time"
 
+	"github.com/adedejiosvaldo/safetrace/backend/internal/apierror"
 	"github.com/adedejiosvaldo/safetrace/backend/internal/config"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/country"
 	"github.com/adedejiosvaldo/safetrace/backend/internal/database"
 	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/middleware"
 	"github.com/adedejiosvaldo/safetrace/backend/internal/services"
 	"github.com/adedejiosvaldo/safetrace/backend/internal/utils"
 	"github.com/gin-gonic/gin"
@@ -14,18 +20,34 @@ import (
 )
 
 type SMSHandler struct {
-	cfg       *config.Config
+	cfg       *config.Store
 	postgres  *database.PostgresDB
 	redis     *database.RedisDB
 	evaluator *services.SafetyEvaluator
 	smsParser *services.SMSParser
+	smsRouter *services.SMSRouter
+	spoof     *services.SpoofDetector
+	welfare   *services.WelfareCheckService
+	prompts   *services.SilentPrompts
+	notifier  services.Notifier
+	outbox    *services.AlertOutbox
+	usage     *services.SMSUsage
+	slo       *services.AlertSLO
 }
 
 func NewSMSHandler(
-	cfg *config.Config,
+	cfg *config.Store,
 	postgres *database.PostgresDB,
 	redis *database.RedisDB,
 	evaluator *services.SafetyEvaluator,
+	smsRouter *services.SMSRouter,
+	spoof *services.SpoofDetector,
+	welfare *services.WelfareCheckService,
+	prompts *services.SilentPrompts,
+	notifier services.Notifier,
+	outbox *services.AlertOutbox,
+	usage *services.SMSUsage,
+	slo *services.AlertSLO,
 ) *SMSHandler {
 	return &SMSHandler{
 		cfg:       cfg,
@@ -33,78 +55,423 @@ func NewSMSHandler(
 		redis:     redis,
 		evaluator: evaluator,
 		smsParser: services.NewSMSParser(),
+		smsRouter: smsRouter,
+		spoof:     spoof,
+		welfare:   welfare,
+		prompts:   prompts,
+		notifier:  notifier,
+		outbox:    outbox,
+		usage:     usage,
+		slo:       slo,
 	}
 }
 
-// POST /v1/sms/webhook
-// Twilio sends SMS data as form-encoded
+// inboundSMS is a text being handled and the provider whose webhook
+// brought it, which the answer is for
+type inboundSMS struct {
+	provider services.InboundSMSProvider
+	*services.InboundSMS
+}
+
+// POST /v1/sms/webhook/:provider
+// POST /v1/sms/webhook (Twilio)
+// A text sent to one of our numbers, forwarded by the provider it came in
+// on: a heartbeat, a panic trigger or an OK reply. Each provider's webhook
+// is authenticated and answered in its own way; what the text does is the
+// same whichever brought it.
 func (h *SMSHandler) HandleIncomingSMS(c *gin.Context) {
-	// Twilio sends data as form parameters
-	body := c.PostForm("Body")
+	name := c.Param("provider")
+	if name == "" {
+		name = services.ProviderTwilio
+	}
+	provider, ok := services.InboundSMSProviderFor(h.cfg.Current(), name)
+	if !ok {
+		middleware.AbortWithError(c, apierror.NotFound("unknown SMS provider"))
+		return
+	}
+	msg, err := provider.ParseInbound(c.Request)
+	if errors.Is(err, services.ErrInboundSMSUnauthenticated) {
+		log.Printf("WARN: Inbound SMS webhook from %s failed authentication", name)
+		middleware.AbortWithError(c, apierror.Forbidden("webhook authentication failed"))
+		return
+	}
+	if err != nil {
+		middleware.AbortWithError(c, apierror.BadRequest("invalid inbound SMS webhook"))
+		return
+	}
+	in := &inboundSMS{provider: provider, InboundSMS: msg}
+	body, from := in.Body, in.From
 
 	if body == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "empty message body"})
+		middleware.AbortWithError(c, apierror.BadRequest("empty message body"))
+		return
+	}
+
+	// Trusted contacts reply OK to acknowledge an alert, and users to answer a
+	// welfare check or check-in prompt
+	if services.IsAckReply(body) {
+		h.handleAckReply(c, in)
+		return
+	}
+
+	// "HELP" or "LG lat,lng" from a registered phone is a panic trigger
+	if panicSMS, ok := services.ParsePanicSMS(body); ok {
+		h.handlePanic(c, in, panicSMS)
 		return
 	}
 
 	// Parse SMS heartbeat
 	heartbeat, err := h.smsParser.ParseHeartbeatSMS(body)
 	if err != nil {
-		// Log error and return success to Twilio to avoid retries
-		c.XML(http.StatusOK, gin.H{"Response": "Message received but could not be parsed"})
+		// Answered as received so the provider doesn't retry
+		h.reply(c, in, "")
 		return
 	}
 
 	// Verify signature
-	if !utils.VerifyStringSignature(
+	if !utils.VerifyStringSignatureAny(
 		body[:len(body)-len(heartbeat.Signature)-5], // Remove ";sig=..." part
 		heartbeat.Signature,
-		h.cfg.HMACSecret,
+		h.cfg.HMACSecrets(),
 	) {
-		c.XML(http.StatusOK, gin.H{"Response": "Invalid signature"})
+		if heartbeat.UserID != uuid.Nil {
+			h.recordRejection(c, heartbeat.UserID, body, models.RejectInvalidSignature, "invalid signature")
+		}
+		h.reply(c, in, "")
 		return
 	}
 
-	// Verify user exists
-	user, err := h.postgres.GetUserByID(c.Request.Context(), heartbeat.UserID)
-	if err != nil || user == nil {
-		c.XML(http.StatusOK, gin.H{"Response": "User not found"})
+	// Attribute the heartbeat by uid, or by the sender's phone if uid is unusable
+	user, identifiedBy, err := h.identifyUser(c.Request.Context(), heartbeat.UserID, from)
+	switch {
+	case errors.Is(err, errSenderMismatch):
+		log.Printf("WARN: SMS heartbeat for user %s sent from %s, which is not their phone; possible spoofing", heartbeat.UserID, from)
+		h.recordRejection(c, heartbeat.UserID, body, models.RejectSenderMismatch, "sent from a phone that is not the user's")
+		h.reply(c, in, "")
+		return
+	case err != nil || user == nil:
+		h.reply(c, in, "")
 		return
 	}
 
 	// Set metadata
+	heartbeat.UserID = user.ID
+	heartbeat.IdentifiedBy = identifiedBy
 	heartbeat.ID = uuid.New()
-	heartbeat.Source = "sms"
 	heartbeat.CreatedAt = time.Now()
+	// identifyUser has already rejected a sender that isn't the user's phone
+	if err := services.BindSource(heartbeat, services.SourceEvidence{
+		Channel:        services.SourceSMS,
+		SignatureValid: true,
+		SenderVerified: from != "",
+	}); err != nil {
+		h.recordRejection(c, user.ID, body, apierror.CodeValidationFailed, err.Error())
+		h.reply(c, in, "")
+		return
+	}
+	// A copy of a heartbeat already received over HTTP is stored for history only
+	h.evaluator.MarkDuplicate(c.Request.Context(), heartbeat)
+	h.spoof.Inspect(c.Request.Context(), heartbeat)
+	h.evaluator.MarkBackfill(c.Request.Context(), heartbeat)
+	h.evaluator.CoarsenLocation(c.Request.Context(), user.Settings, heartbeat)
 
 	// Store heartbeat
 	if err := h.postgres.CreateHeartbeat(c.Request.Context(), heartbeat); err != nil {
-		c.XML(http.StatusOK, gin.H{"Response": "Storage error"})
+		h.evaluator.ReleaseFingerprint(c.Request.Context(), heartbeat)
+		h.recordRejection(c, user.ID, body, apierror.CodeInternal, "failed to store heartbeat")
+		h.reply(c, in, "")
 		return
 	}
+	h.evaluator.TrackDevice(c.Request.Context(), heartbeat)
+
+	// Open or re-arm a LastGasp, or end one, unless newer heartbeats already arrived
+	h.evaluator.TrackLastGasp(c.Request.Context(), heartbeat)
+
+	// Trigger safety evaluation (async); a delayed SMS or a copy of an HTTP
+	// heartbeat is history only
+	if !heartbeat.Backfill && heartbeat.DuplicateOf == nil {
+		h.evaluator.EvaluateHeartbeatAsync(heartbeat)
+	}
+
+	attempt := acceptedAttempt(c, heartbeat, false)
+	attempt.PayloadHash = payloadHash([]byte(body))
+	recordAttempt(c, h.postgres, h.redis, user.ID, attempt)
+
+	h.acknowledge(c, in, user, heartbeat)
+}
+
+// recordRejection keeps an SMS heartbeat that wasn't taken for the user's
+// diagnostics, under the given reason code
+func (h *SMSHandler) recordRejection(c *gin.Context, userID uuid.UUID, body, code, message string) {
+	recordAttempt(c, h.postgres, h.redis, userID, &models.IngestionAttempt{
+		At:          time.Now(),
+		Endpoint:    c.Request.Method + " " + c.FullPath(),
+		Outcome:     code,
+		Message:     message,
+		PayloadHash: payloadHash([]byte(body)),
+	})
+}
 
-	// Handle LastGasp if present
-	if heartbeat.LastGasp {
-		lastGasp := &models.LastGasp{
-			ID:        uuid.New(),
-			UserID:    heartbeat.UserID,
-			Lat:       heartbeat.Lat,
-			Lng:       heartbeat.Lng,
-			AccuracyM: heartbeat.AccuracyM,
-			CellInfo:  heartbeat.CellInfo,
-			CreatedAt: time.Now(),
-			ExpiryTs:  time.Now().Add(time.Duration(h.cfg.LastGaspTimeoutSeconds) * time.Second),
+// acknowledge answers a stored SMS heartbeat as SMS_HEARTBEAT_ACK says. Every
+// reply is an outbound SMS, which at one heartbeat every few minutes is
+// hundreds a day per user, so by default routine heartbeats get an empty
+// answer and only a LastGasp is acknowledged, by a text of its own.
+// Nothing is sent back to a roaming phone with ROAMING_SMS_SUPPRESSED on.
+func (h *SMSHandler) acknowledge(c *gin.Context, in *inboundSMS, user *models.User, heartbeat *models.Heartbeat) {
+	mode := h.cfg.Current().SMSHeartbeatAck
+	if h.roamingQuiet(c.Request.Context(), user, heartbeat) {
+		mode = "none"
+	}
+	if heartbeat.LastGasp && !heartbeat.Backfill && heartbeat.DuplicateOf == nil && mode != "none" {
+		h.outbox.EnqueueMessage(c.Request.Context(), "LastGasp acknowledgment to user "+user.ID.String(), func(ctx context.Context) error {
+			return h.notifier.SendLastGaspAcknowledgment(ctx, user)
+		})
+		h.reply(c, in, "")
+		return
+	}
+	if mode == "all" {
+		h.usage.Record(c.Request.Context(), user, 1)
+		h.reply(c, in, "Heartbeat received")
+		return
+	}
+	h.reply(c, in, "")
+}
+
+// roamingQuiet reports whether texts back to the sender of a heartbeat are
+// held back because their phone is roaming. The heartbeat's own country code
+// decides, as the user's state may not have caught up with it yet.
+func (h *SMSHandler) roamingQuiet(ctx context.Context, user *models.User, heartbeat *models.Heartbeat) bool {
+	if !h.cfg.Current().RoamingSMSSuppressed {
+		return false
+	}
+	if heartbeat.CellInfo.MCC != 0 {
+		return services.IsRoaming(country.Active().ForPhone(user.Phone), heartbeat.CellInfo)
+	}
+	return services.RoamingSMSSuppressed(ctx, h.cfg, h.redis, user.ID)
+}
+
+// reply answers the provider's webhook with a text for the sender, sent
+// as a message of its own, from the provider it came in on, where the
+// answer can't carry it. An empty text sends nothing back.
+func (h *SMSHandler) reply(c *gin.Context, in *inboundSMS, text string) {
+	if in.provider.Respond(c.Writer, text) || text == "" || in.From == "" {
+		return
+	}
+	provider, to := in.provider.Name(), in.From
+	h.outbox.EnqueueMessage(c.Request.Context(), "SMS reply to "+to, func(ctx context.Context) error {
+		return h.smsRouter.SendFrom(ctx, provider, to, text)
+	})
+}
+
+var (
+	errUnknownSender  = errors.New("sender phone is not registered")
+	errSenderMismatch = errors.New("uid does not belong to the sender")
+)
+
+// identifyUser resolves the user an SMS heartbeat belongs to. A valid uid is
+// used when it matches the sender's registered phone; a missing or corrupted
+// uid falls back to looking the sender up by phone. from is empty when the
+// provider did not report a sender, in which case only the uid is used.
+func (h *SMSHandler) identifyUser(ctx context.Context, uid uuid.UUID, from string) (*models.User, string, error) {
+	if uid == uuid.Nil {
+		if from == "" {
+			return nil, "", errUnknownSender
+		}
+		sender, err := h.postgres.GetUserByPhone(ctx, from)
+		if err != nil {
+			return nil, "", err
 		}
-		h.postgres.CreateLastGasp(c.Request.Context(), lastGasp)
+		if sender == nil {
+			return nil, "", errUnknownSender
+		}
+		return sender, models.IdentifiedBySender, nil
+	}
+
+	user, err := h.postgres.GetUserByID(ctx, uid)
+	if err != nil || user == nil {
+		return nil, "", err
+	}
+	if from != "" && utils.NormalizePhone(user.Phone) != from {
+		return nil, "", errSenderMismatch
 	}
+	return user, models.IdentifiedByPayload, nil
+}
 
-	// Trigger safety evaluation (async)
-	go func() {
-		ctx := c.Copy().Request.Context()
-		h.evaluator.EvaluateUserSafety(ctx, heartbeat.UserID)
-	}()
+// handlePanic raises an alert for a HELP or LG message from a registered phone.
+// There is no signature on these, so the sender's number is the only identity.
+func (h *SMSHandler) handlePanic(c *gin.Context, in *inboundSMS, panicSMS *services.PanicSMS) {
+	ctx := c.Request.Context()
+	from := in.From
 
-	// Respond with TwiML (Twilio expects this format)
-	c.Header("Content-Type", "application/xml")
-	c.String(http.StatusOK, `<?xml version="1.0" encoding="UTF-8"?><Response><Message>Heartbeat received</Message></Response>`)
+	user, err := h.postgres.GetUserByPhone(ctx, from)
+	if err != nil {
+		log.Printf("ERROR: Failed to look up panic sender %s: %v", from, err)
+		h.reply(c, in, "")
+		return
+	}
+	if from == "" || user == nil {
+		log.Printf("WARN: Panic SMS from unregistered number %q ignored", from)
+		h.reply(c, in, "")
+		return
+	}
+
+	now := time.Now()
+	heartbeat := &models.Heartbeat{
+		ID:           uuid.New(),
+		UserID:       user.ID,
+		LastGasp:     true,
+		Timestamp:    now,
+		CreatedAt:    now,
+		IdentifiedBy: models.IdentifiedBySender,
+	}
+	// The user was looked up by the sender's number, so the phone is verified
+	services.BindSource(heartbeat, services.SourceEvidence{Channel: services.SourceSMS, SenderVerified: true})
+	if panicSMS.HasLocation {
+		heartbeat.Lat, heartbeat.Lng = panicSMS.Lat, panicSMS.Lng
+	} else if last, err := h.postgres.GetLatestHeartbeat(ctx, user.ID); err == nil && last != nil {
+		// HELP carries no position; use the last one we have, as precisely as we have it
+		h.evaluator.RestoreLocation(ctx, last)
+		heartbeat.Lat, heartbeat.Lng = last.Lat, last.Lng
+		heartbeat.AccuracyM = last.AccuracyM
+		heartbeat.CellInfo = last.CellInfo
+	}
+
+	if err := h.postgres.CreateHeartbeat(ctx, heartbeat); err != nil {
+		log.Printf("ERROR: Failed to store panic heartbeat for user %s: %v", user.ID, err)
+	}
+
+	if err := h.evaluator.RecordLastGasp(ctx, heartbeat); err != nil {
+		log.Printf("ERROR: Failed to store panic lastgasp for user %s: %v", user.ID, err)
+	}
+
+	reason := services.PanicReason("sms", panicSMS.Keyword, "")
+	if err := h.evaluator.TriggerPanic(ctx, user.ID, reason); err != nil {
+		log.Printf("ERROR: Panic alert failed for user %s: %v", user.ID, err)
+		h.reply(c, in, "")
+		return
+	}
+
+	log.Printf("INFO: Panic SMS (%s) from user %s raised an alert", panicSMS.Keyword, user.ID)
+	h.usage.Record(ctx, user, 1)
+	h.reply(c, in, "Alert sent to your contacts")
+}
+
+// ackReplyWindow bounds how old an alert can be for a bare "OK" reply to acknowledge it
+const ackReplyWindow = 24 * time.Hour
+
+// handleAckReply acknowledges the sender's most recent unacknowledged alert.
+// With none to acknowledge, it confirms the sender's own pending welfare
+// check and answers their check-in prompt.
+func (h *SMSHandler) handleAckReply(c *gin.Context, in *inboundSMS) {
+	from := in.From
+
+	reply := "No recent SafeTrace alert found for this number."
+	recipient, err := h.postgres.AcknowledgeLatestAlertForPhone(
+		c.Request.Context(), from, models.AckMethodSMS, time.Now().Add(-ackReplyWindow),
+	)
+	if err != nil {
+		log.Printf("ERROR: Failed to acknowledge alert for %s: %v", from, err)
+		reply = "Sorry, we could not record your acknowledgment."
+	} else if recipient != nil {
+		log.Printf("INFO: Alert %s acknowledged by %s via SMS", recipient.AlertID, from)
+		reply = "Thank you. We've recorded that you have seen this alert."
+	} else {
+		answered := h.answerCheckIn(c.Request.Context(), from)
+		if check := h.confirmWelfareCheck(c.Request.Context(), from); check != nil {
+			reply = "Thank you. We've let " + check.RequesterName + " know you're okay."
+		} else if answered {
+			reply = "Thank you. We've recorded that you're okay."
+		}
+	}
+
+	h.reply(c, in, reply)
+}
+
+// confirmWelfareCheck confirms the pending welfare check of the user with
+// this phone, returning it, or nil if there was none
+func (h *SMSHandler) confirmWelfareCheck(ctx context.Context, from string) *models.WelfareCheck {
+	user, err := h.postgres.GetUserByPhone(ctx, from)
+	if err != nil || user == nil {
+		return nil
+	}
+	check, err := h.welfare.Confirm(ctx, user.ID)
+	if err != nil {
+		log.Printf("ERROR: Failed to confirm welfare check for user %s: %v", user.ID, err)
+		return nil
+	}
+	if check != nil {
+		log.Printf("INFO: Welfare check %s confirmed by user %s via SMS", check.ID, user.ID)
+	}
+	return check
+}
+
+// answerCheckIn answers the check-in prompt of the user with this phone,
+// reporting whether one was waiting
+func (h *SMSHandler) answerCheckIn(ctx context.Context, from string) bool {
+	user, err := h.postgres.GetUserByPhone(ctx, from)
+	if err != nil || user == nil {
+		return false
+	}
+	prompt, err := h.prompts.Answer(ctx, user.ID, uuid.Nil, models.PromptViaSMS)
+	if errors.Is(err, services.ErrNoCheckInPrompt) {
+		return false
+	}
+	if err != nil {
+		log.Printf("ERROR: Failed to answer check-in prompt for user %s: %v", user.ID, err)
+		return false
+	}
+	log.Printf("INFO: Check-in prompt %s answered by user %s via SMS", prompt.ID, user.ID)
+	return true
+}
+
+// POST /v1/sms/status/:provider
+// Delivery report callback; undelivered messages are retried on another
+// provider, and the first confirmed delivery of an alert is recorded for the
+// time-to-alert SLO
+func (h *SMSHandler) HandleDeliveryStatus(c *gin.Context) {
+	providerName := c.Param("provider")
+	provider, ok := h.smsRouter.Provider(providerName)
+	if !ok {
+		middleware.AbortWithError(c, apierror.NotFound("unknown provider"))
+		return
+	}
+
+	messageID, outcome, err := provider.ParseStatusCallback(c.Request)
+	if errors.Is(err, services.ErrStatusCallbackUnauthenticated) {
+		log.Printf("WARN: %s status callback failed authentication", providerName)
+		middleware.AbortWithError(c, apierror.Forbidden("webhook authentication failed"))
+		return
+	}
+	if err != nil {
+		log.Printf("ERROR: Invalid %s status callback: %v", providerName, err)
+		middleware.AbortWithError(c, apierror.BadRequest("invalid status callback"))
+		return
+	}
+
+	switch outcome {
+	case services.SMSReportFailed:
+		if err := h.smsRouter.HandleDeliveryFailure(c.Request.Context(), providerName, messageID); err != nil {
+			log.Printf("ERROR: Failed to retry SMS %s from %s: %v", messageID, providerName, err)
+		}
+	case services.SMSReportDelivered:
+		h.confirmAlertDelivery(c.Request.Context(), providerName, messageID)
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// confirmAlertDelivery records a delivered message that carried an alert.
+// Failures are logged; the alert then counts as unconfirmed.
+func (h *SMSHandler) confirmAlertDelivery(ctx context.Context, providerName, messageID string) {
+	delivery, err := h.smsRouter.Delivery(ctx, providerName, messageID)
+	if err != nil {
+		log.Printf("WARN: Failed to look up SMS %s from %s: %v", messageID, providerName, err)
+		return
+	}
+	if delivery == nil || delivery.AlertID == nil {
+		return
+	}
+	if err := h.slo.Delivered(ctx, *delivery.AlertID, time.Now()); err != nil {
+		log.Printf("ERROR: Failed to record delivery of alert %s: %v", *delivery.AlertID, err)
+	}
 }
@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/config"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/services"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/utils"
+)
+
+// A signed SMS heartbeat is attributed by its uid only when it comes from
+// the user's own phone, and by the sender's phone only when that is registered
+func TestIdentifySMSSender(t *testing.T) {
+	postgres := testPostgres(t)
+	h := &SMSHandler{postgres: postgres}
+	user := createTestUser(t, postgres)
+	other := createTestUser(t, postgres)
+	phone := utils.NormalizePhone(user.Phone)
+
+	tests := []struct {
+		name             string
+		uid              uuid.UUID
+		from             string
+		wantUser         *models.User
+		wantIdentifiedBy string
+		wantErr          error
+	}{
+		{"uid from the user's phone", user.ID, phone, user, models.IdentifiedByPayload, nil},
+		{"uid without a sender", user.ID, "", user, models.IdentifiedByPayload, nil},
+		{"uid from another user's phone", user.ID, utils.NormalizePhone(other.Phone), nil, "", errSenderMismatch},
+		{"uid from an unknown phone", user.ID, "+2348000000000", nil, "", errSenderMismatch},
+		{"unknown uid", uuid.New(), phone, nil, "", nil},
+		{"corrupted uid from a registered phone", uuid.Nil, phone, user, models.IdentifiedBySender, nil},
+		{"corrupted uid from an unknown phone", uuid.Nil, "+2348000000000", nil, "", errUnknownSender},
+		{"corrupted uid without a sender", uuid.Nil, "", nil, "", errUnknownSender},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, identifiedBy, err := h.identifyUser(context.Background(), tt.uid, tt.from)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("identifyUser() error = %v, want %v", err, tt.wantErr)
+			}
+			if (got == nil) != (tt.wantUser == nil) || (got != nil && got.ID != tt.wantUser.ID) {
+				t.Errorf("identifyUser() user = %v, want %v", got, tt.wantUser)
+			}
+			if identifiedBy != tt.wantIdentifiedBy {
+				t.Errorf("identified by %q, want %q", identifiedBy, tt.wantIdentifiedBy)
+			}
+		})
+	}
+}
+
+// ackNotifier counts the LastGasp acknowledgments sent; any other
+// notification is a test failure, as the nil Notifier panics
+type ackNotifier struct {
+	services.Notifier
+	mu   sync.Mutex
+	acks int
+}
+
+func (n *ackNotifier) SendLastGaspAcknowledgment(ctx context.Context, user *models.User) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.acks++
+	return nil
+}
+
+// Routine SMS heartbeats are answered with nothing, which costs nothing;
+// a LastGasp is acknowledged by a text of its own, unless it was already
+// handled or the phone is roaming
+func TestAcknowledgeSMSHeartbeat(t *testing.T) {
+	const (
+		empty    = `<?xml version="1.0" encoding="UTF-8"?><Response/>`
+		received = `<?xml version="1.0" encoding="UTF-8"?><Response><Message>Heartbeat received</Message></Response>`
+	)
+	gin.SetMode(gin.TestMode)
+	user := &models.User{ID: uuid.New(), Phone: "+2348031234567"}
+	nigeria := models.CellInfo{MCC: 621, MNC: 20}
+	duplicate := uuid.New()
+
+	tests := []struct {
+		name      string
+		ack       string
+		roaming   bool // ROAMING_SMS_SUPPRESSED
+		heartbeat models.Heartbeat
+		wantBody  string
+		wantAcks  int
+	}{
+		{"routine", "lastgasp", false, models.Heartbeat{CellInfo: nigeria}, empty, 0},
+		{"routine, acks off", "none", false, models.Heartbeat{CellInfo: nigeria}, empty, 0},
+		{"routine, every heartbeat acked", "all", false, models.Heartbeat{CellInfo: nigeria}, received, 0},
+		{"LastGasp", "lastgasp", false, models.Heartbeat{LastGasp: true, CellInfo: nigeria}, empty, 1},
+		{"LastGasp, every heartbeat acked", "all", false, models.Heartbeat{LastGasp: true, CellInfo: nigeria}, empty, 1},
+		{"LastGasp, acks off", "none", false, models.Heartbeat{LastGasp: true, CellInfo: nigeria}, empty, 0},
+		{"backfilled LastGasp", "lastgasp", false, models.Heartbeat{LastGasp: true, Backfill: true, CellInfo: nigeria}, empty, 0},
+		{"duplicate LastGasp", "lastgasp", false, models.Heartbeat{LastGasp: true, DuplicateOf: &duplicate, CellInfo: nigeria}, empty, 0},
+		{"LastGasp at home, roaming texts suppressed", "lastgasp", true, models.Heartbeat{LastGasp: true, CellInfo: nigeria}, empty, 1},
+		{"LastGasp roaming, texts suppressed", "lastgasp", true, models.Heartbeat{LastGasp: true, CellInfo: models.CellInfo{MCC: 234, MNC: 15}}, empty, 0},
+		{"routine roaming, texts suppressed", "all", true, models.Heartbeat{CellInfo: models.CellInfo{MCC: 234, MNC: 15}}, empty, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{SMSHeartbeatAck: tt.ack, RoamingSMSSuppressed: tt.roaming, TwilioAuthToken: "token"}
+			provider, ok := services.InboundSMSProviderFor(cfg, services.ProviderTwilio)
+			if !ok {
+				t.Fatal("Twilio not configured")
+			}
+			notifier := &ackNotifier{}
+			outbox := services.NewAlertOutbox(notifier, nil, 1, nil)
+			outbox.Start()
+			h := &SMSHandler{cfg: config.NewStore(cfg), notifier: notifier, outbox: outbox}
+
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest(http.MethodPost, "/v1/sms/webhook", nil)
+			in := &inboundSMS{provider: provider, InboundSMS: &services.InboundSMS{From: user.Phone}}
+			hb := tt.heartbeat
+			h.acknowledge(c, in, user, &hb)
+			outbox.Close()
+
+			if w.Code != http.StatusOK || w.Body.String() != tt.wantBody {
+				t.Errorf("answer = %d %s, want 200 %s", w.Code, w.Body.String(), tt.wantBody)
+			}
+			if notifier.acks != tt.wantAcks {
+				t.Errorf("%d LastGasp acknowledgments sent, want %d", notifier.acks, tt.wantAcks)
+			}
+		})
+	}
+}
@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/apierror"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/middleware"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/services"
+)
+
+// maxOutboundRaiseMinutes is the longest the outbound caps can be raised for
+const maxOutboundRaiseMinutes = 24 * 60
+
+type SpendHandler struct {
+	budget *services.OutboundBudget
+	audit  *services.AuditLogger
+}
+
+func NewSpendHandler(budget *services.OutboundBudget, audit *services.AuditLogger) *SpendHandler {
+	return &SpendHandler{
+		budget: budget,
+		audit:  audit,
+	}
+}
+
+type RaiseOutboundLimitRequest struct {
+	PerMinute int `json:"per_minute" binding:"required,min=1"`
+	PerDay    int `json:"per_day" binding:"required,min=1"`
+	Minutes   int `json:"minutes" binding:"required,min=1"`
+}
+
+// GET /admin/spend
+// Today's outbound SMS and WhatsApp messages by type, sent and dropped,
+// against the caps in effect
+func (h *SpendHandler) GetSpend(c *gin.Context) {
+	spend, err := h.budget.Today(c.Request.Context())
+	if err != nil {
+		middleware.AbortWithError(c, apierror.Internal("failed to read outbound spend", err))
+		return
+	}
+	c.JSON(http.StatusOK, spend)
+}
+
+// PUT /admin/spend/limit
+// Raises the outbound caps for a while, e.g. during a city-wide incident.
+// The caps can't be lowered below the configured ones this way.
+func (h *SpendHandler) RaiseLimit(c *gin.Context) {
+	var req RaiseOutboundLimitRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		middleware.AbortWithError(c, apierror.Validation(err))
+		return
+	}
+	if req.Minutes > maxOutboundRaiseMinutes {
+		middleware.AbortWithError(c, apierror.Invalid("minutes", "must be at most 1440"))
+		return
+	}
+
+	raised, err := h.budget.Raise(c.Request.Context(), req.PerMinute, req.PerDay, time.Duration(req.Minutes)*time.Minute)
+	switch {
+	case errors.Is(err, services.ErrOutboundCapTooLow):
+		middleware.AbortWithError(c, apierror.BadRequest(err.Error()))
+		return
+	case err != nil:
+		middleware.AbortWithError(c, apierror.Internal("failed to raise outbound caps", err))
+		return
+	}
+
+	admin := middleware.Principal(c)
+	log.Printf("INFO: Outbound caps raised to %d a minute and %d a day for %d minutes by %s", req.PerMinute, req.PerDay, req.Minutes, admin.Subject)
+	recordAudit(c, h.audit, &models.AuditEvent{
+		Action:     services.AuditOutboundLimitRaise,
+		ObjectType: "outbound_budget",
+		Metadata: map[string]interface{}{
+			"per_minute": req.PerMinute,
+			"per_day":    req.PerDay,
+			"minutes":    req.Minutes,
+		},
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": "success",
+		"raised": raised,
+	})
+}
+
+// DELETE /admin/spend/limit
+// Ends a raise early, back to the configured caps
+func (h *SpendHandler) ResetLimit(c *gin.Context) {
+	cleared, err := h.budget.Reset(c.Request.Context())
+	if err != nil {
+		middleware.AbortWithError(c, apierror.Internal("failed to reset outbound caps", err))
+		return
+	}
+	if !cleared {
+		middleware.AbortWithError(c, apierror.NotFound("outbound caps are not raised"))
+		return
+	}
+
+	recordAudit(c, h.audit, &models.AuditEvent{
+		Action:     services.AuditOutboundLimitReset,
+		ObjectType: "outbound_budget",
+	})
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,581 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/apierror"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/config"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/database"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/middleware"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/services"
+)
+
+const (
+	// streamMediaType is what a heartbeat stream is sent and answered in
+	streamMediaType = "application/x-ndjson"
+	// streamLocationGap is the least time between two location lines; the
+	// app sends one every 5 to 10 seconds
+	streamLocationGap = 2 * time.Second
+	// streamImpactEvery is how much newer sensor data must be before the
+	// window is checked for a crash signature again
+	streamImpactEvery = time.Second
+	// streamReadAhead is how many lines are read ahead of the one being handled
+	streamReadAhead = 16
+)
+
+// Why a heartbeat stream ended, sent in its last line
+const (
+	streamEndClosed       = "closed"       // the client finished the request
+	streamEndDisconnected = "disconnected" // the connection broke off
+	streamEndIdle         = "idle"         // nothing arrived for STREAM_IDLE_SECONDS
+	streamEndMaxDuration  = "max_duration" // open for STREAM_MAX_MINUTES
+	streamEndState        = "state"        // the user is no longer AT_RISK or in ALERT
+	streamEndShutdown     = "shutdown"     // the server is shutting down
+)
+
+// StreamHandler takes heartbeat streams: the locations and sensor samples
+// the app sends every few seconds while its user is AT_RISK or in ALERT,
+// as NDJSON over one long-lived request instead of a request per point
+type StreamHandler struct {
+	cfg       *config.Store
+	postgres  *database.PostgresDB
+	redis     *database.RedisDB
+	evaluator *services.SafetyEvaluator
+	buffer    *services.HeartbeatBuffer // nil when writes are synchronous
+	spoof     *services.SpoofDetector
+	impacts   *services.ImpactAnalyzer
+
+	closeOnce sync.Once
+	closing   chan struct{}
+}
+
+func NewStreamHandler(
+	cfg *config.Store,
+	postgres *database.PostgresDB,
+	redis *database.RedisDB,
+	evaluator *services.SafetyEvaluator,
+	buffer *services.HeartbeatBuffer,
+	spoof *services.SpoofDetector,
+	impacts *services.ImpactAnalyzer,
+) *StreamHandler {
+	return &StreamHandler{
+		cfg:       cfg,
+		postgres:  postgres,
+		redis:     redis,
+		evaluator: evaluator,
+		buffer:    buffer,
+		spoof:     spoof,
+		impacts:   impacts,
+		closing:   make(chan struct{}),
+	}
+}
+
+// Close ends every open stream with reason "shutdown"; the server calls it
+// as it starts shutting down, since it waits for open requests to finish
+func (h *StreamHandler) Close() {
+	h.closeOnce.Do(func() {
+		close(h.closing)
+	})
+}
+
+// streamLine is one line of a heartbeat stream: a location, stored as a
+// heartbeat, or a sensor sample, checked for a crash signature
+type streamLine struct {
+	Type      string    `json:"type"` // "location" or "sensor"
+	Timestamp time.Time `json:"timestamp"`
+
+	// Location lines
+	Lat          *float64         `json:"lat"`
+	Lng          *float64         `json:"lng"`
+	AccuracyM    *int             `json:"accuracy_m"`
+	CellInfo     *models.CellInfo `json:"cell_info"`
+	BatteryPct   *int             `json:"battery_pct,omitempty"`
+	Speed        *float64         `json:"speed,omitempty"`
+	IsMock       bool             `json:"is_mock"`
+	Connectivity string           `json:"connectivity,omitempty"`
+
+	// Sensor lines
+	SensorData *models.SensorData `json:"sensor_data"`
+}
+
+func (l *streamLine) validate() []apierror.FieldError {
+	var fields []apierror.FieldError
+	if l.Timestamp.IsZero() {
+		fields = append(fields, apierror.FieldError{Field: "timestamp", Reason: "is required"})
+	}
+
+	switch l.Type {
+	case "location":
+		fields = append(fields, validateFix(l.Lat, l.Lng, l.AccuracyM)...)
+		switch {
+		case l.CellInfo == nil:
+			fields = append(fields, apierror.FieldError{Field: "cell_info", Reason: "is required"})
+		default:
+			if err := services.ValidateCellInfo(services.NormalizeCellInfo(*l.CellInfo)); err != nil {
+				fields = append(fields, apierror.FieldError{Field: "cell_info", Reason: err.Error()})
+			}
+		}
+		switch l.Connectivity {
+		case "", models.ConnectivityWiFi, models.ConnectivityCellular, models.ConnectivityOfflineQueued:
+		default:
+			fields = append(fields, apierror.FieldError{Field: "connectivity", Reason: "must be one of wifi, cellular, offline_queued"})
+		}
+	case "sensor":
+		if l.SensorData == nil {
+			fields = append(fields, apierror.FieldError{Field: "sensor_data", Reason: "is required"})
+			break
+		}
+		for _, axis := range services.SensorAxesOutOfRange(*l.SensorData) {
+			fields = append(fields, apierror.FieldError{Field: "sensor_data." + axis, Reason: "is beyond what a phone can measure"})
+		}
+	default:
+		fields = append(fields, apierror.FieldError{Field: "type", Reason: "must be location or sensor"})
+	}
+	return fields
+}
+
+// streamRead is what the reader found next in a stream: a complete line,
+// a line over the size cap, or the end of the stream
+type streamRead struct {
+	line    []byte
+	tooLong bool
+	err     error
+	partial bool // the stream ended partway through a line
+}
+
+// streamingState reports whether a user in state may open a stream
+func streamingState(state *models.UserState) bool {
+	return state != nil && (state.State == services.StateAtRisk || state.State == services.StateAlert)
+}
+
+// POST /v1/stream/heartbeats
+// Takes the app's locations and sensor samples as NDJSON, one object per
+// line, over one long-lived request while the user is AT_RISK or in ALERT.
+// The access token is checked once, as the stream opens. The answer is
+// NDJSON too: an "open" line, an "error" line for each line rejected, an
+// "ack" line every STREAM_ACK_SECONDS with the user's current state, and an
+// "end" line saying why the stream ended. A client should return to regular
+// heartbeats once it ends with reason "state".
+func (h *StreamHandler) StreamHeartbeats(c *gin.Context) {
+	// Acks are written while the request is still being read; HTTP/2 always
+	// allows that, HTTP/1 has to be asked. Asked first, a stream refused is
+	// answered at once rather than once the app stops sending.
+	_ = http.NewResponseController(c.Writer).EnableFullDuplex()
+
+	userID, err := uuid.Parse(middleware.Principal(c).Subject)
+	if err != nil {
+		middleware.AbortWithError(c, apierror.Unauthorized("missing or invalid access token"))
+		return
+	}
+	if c.ContentType() != streamMediaType {
+		middleware.AbortWithError(c, apierror.UnsupportedMediaType("content type must be "+streamMediaType))
+		return
+	}
+	deviceID := c.Query("device_id")
+	if len(deviceID) > 64 {
+		middleware.AbortWithError(c, apierror.Invalid("device_id", "must be at most 64 characters"))
+		return
+	}
+
+	ctx := c.Request.Context()
+	state, err := h.evaluator.CurrentState(ctx, userID)
+	if err != nil {
+		middleware.AbortWithError(c, apierror.Internal("failed to get state", err))
+		return
+	}
+	if !streamingState(state) {
+		middleware.AbortWithError(c, apierror.Conflict("heartbeats can only be streamed while the user is AT_RISK or in ALERT"))
+		return
+	}
+
+	cfg := h.cfg.Current()
+	streamID := uuid.NewString()
+	ackEvery := time.Duration(cfg.StreamAckSeconds) * time.Second
+	claimed, err := h.redis.ClaimHeartbeatStream(ctx, userID, streamID, time.Now(), streamLease(ackEvery), cfg.StreamMaxPerUser)
+	if err != nil {
+		middleware.AbortWithError(c, apierror.Internal("stream limit check failed", err))
+		return
+	}
+	if !claimed {
+		middleware.AbortWithError(c, apierror.TooManyRequests(
+			fmt.Sprintf("at most %d heartbeat streams may be open per user", cfg.StreamMaxPerUser)))
+		return
+	}
+	defer func() {
+		if err := h.redis.ReleaseHeartbeatStream(context.Background(), userID, streamID); err != nil {
+			log.Printf("WARN: Failed to release heartbeat stream %s of user %s: %v", streamID, userID, err)
+		}
+	}()
+
+	s := &heartbeatStream{
+		h:        h,
+		c:        c,
+		id:       streamID,
+		userID:   userID,
+		deviceID: deviceID,
+		cfg:      cfg,
+		window:   services.NewSensorWindow(time.Duration(cfg.ImpactStillSeconds) * time.Second),
+		lastRead: time.Now(),
+	}
+	log.Printf("INFO: Heartbeat stream %s of user %s opened", streamID, userID)
+	s.run(state)
+}
+
+// streamLease is how long a stream's slot is held without being renewed;
+// acks renew it, so a few of them may go missing first
+func streamLease(ackEvery time.Duration) time.Duration {
+	return 3 * ackEvery
+}
+
+// heartbeatStream is one open stream
+type heartbeatStream struct {
+	h        *StreamHandler
+	c        *gin.Context
+	id       string
+	userID   uuid.UUID
+	deviceID string
+	cfg      *config.Config
+	window   *services.SensorWindow
+
+	lines        int       // lines read, blank and rejected ones included
+	accepted     int       // location and sensor lines accepted
+	rejected     int       // lines answered with an error
+	partial      bool      // the stream broke off partway through a line
+	lastRead     time.Time // when the last line arrived
+	lastLocation time.Time // timestamp of the newest location accepted
+	analyzed     time.Time // newest sensor sample checked for an impact
+
+	// A stored heartbeat not evaluated yet; evaluations run once per ack
+	// rather than for every line. Buffered heartbeats are evaluated by the
+	// buffer as it writes them.
+	pending *models.Heartbeat
+}
+
+func (s *heartbeatStream) run(state *models.UserState) {
+	c := s.c
+	c.Header("Content-Type", streamMediaType)
+	c.Header("Cache-Control", "no-store")
+	// Proxies must pass acks on as they are written
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	ackEvery := time.Duration(s.cfg.StreamAckSeconds) * time.Second
+	s.write(gin.H{
+		"type":           "open",
+		"stream_id":      s.id,
+		"ack_seconds":    s.cfg.StreamAckSeconds,
+		"line_max_bytes": s.cfg.StreamLineMaxBytes,
+		"state":          state.State,
+	})
+
+	reads := make(chan streamRead, streamReadAhead)
+	done := make(chan struct{})
+	defer close(done)
+	go readStreamLines(c.Request.Body, s.cfg.StreamLineMaxBytes, reads, done)
+
+	ticker := time.NewTicker(ackEvery)
+	defer ticker.Stop()
+	maxDuration := time.NewTimer(time.Duration(s.cfg.StreamMaxMinutes) * time.Minute)
+	defer maxDuration.Stop()
+
+	var reason string
+	for reason == "" {
+		select {
+		case r := <-reads:
+			reason = s.handle(r)
+		case <-ticker.C:
+			reason = s.acknowledge()
+		case <-maxDuration.C:
+			reason = streamEndMaxDuration
+		case <-s.h.closing:
+			reason = streamEndShutdown
+		case <-c.Request.Context().Done():
+			reason = streamEndDisconnected
+		}
+	}
+	s.finish(reason)
+}
+
+// readStreamLines reads a stream line by line into out until it ends. A
+// line over max bytes is skipped to its newline and reported as too long.
+// Whatever follows the last newline when the stream ends is a partial line,
+// cut off or not, and is never handled.
+func readStreamLines(body io.Reader, max int, out chan<- streamRead, done <-chan struct{}) {
+	reader := bufio.NewReaderSize(body, max+1) // room for the newline
+	tooLong := false
+	for {
+		chunk, err := reader.ReadSlice('\n')
+		var r streamRead
+		switch {
+		case err == bufio.ErrBufferFull:
+			tooLong = true
+			continue
+		case err != nil:
+			r = streamRead{err: err, partial: tooLong || len(chunk) > 0}
+		case tooLong:
+			r = streamRead{tooLong: true}
+			tooLong = false
+		default:
+			r = streamRead{line: bytes.Clone(chunk)}
+		}
+
+		select {
+		case out <- r:
+		case <-done:
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// handle acts on what the reader found and returns why the stream ended, if it did
+func (s *heartbeatStream) handle(r streamRead) string {
+	if r.err != nil {
+		s.partial = r.partial
+		if r.err == io.EOF {
+			return streamEndClosed
+		}
+		return streamEndDisconnected
+	}
+
+	s.lines++
+	s.lastRead = time.Now()
+	if r.tooLong {
+		s.reject(apierror.PayloadTooLarge(fmt.Sprintf("line is longer than %d bytes", s.cfg.StreamLineMaxBytes)))
+		return ""
+	}
+
+	// Blank lines keep an otherwise quiet stream from going idle
+	line := bytes.TrimSpace(r.line)
+	if len(line) == 0 {
+		return ""
+	}
+
+	var l streamLine
+	if err := json.Unmarshal(line, &l); err != nil {
+		s.reject(apierror.Validation(err))
+		return ""
+	}
+	if fields := l.validate(); len(fields) > 0 {
+		s.reject(apierror.Unprocessable(fields))
+		return ""
+	}
+
+	if l.Type == "location" {
+		s.storeLocation(&l)
+	} else {
+		s.addSample(&l)
+	}
+	return ""
+}
+
+// storeLocation stores a location line as a high-frequency heartbeat, the
+// way POST /v1/heartbeat stores one
+func (s *heartbeatStream) storeLocation(l *streamLine) {
+	if !s.lastLocation.IsZero() && l.Timestamp.Before(s.lastLocation.Add(streamLocationGap)) {
+		s.reject(apierror.Invalid("timestamp", fmt.Sprintf("must be at least %s after the previous location's", streamLocationGap)))
+		return
+	}
+
+	ctx := s.c.Request.Context()
+	evaluator := s.h.evaluator
+	hb := &models.Heartbeat{
+		ID:            uuid.New(),
+		UserID:        s.userID,
+		DeviceID:      s.deviceID,
+		Lat:           *l.Lat,
+		Lng:           *l.Lng,
+		AccuracyM:     *l.AccuracyM,
+		CellInfo:      services.NormalizeCellInfo(*l.CellInfo),
+		BatteryPct:    l.BatteryPct,
+		Speed:         l.Speed,
+		Timestamp:     l.Timestamp,
+		CreatedAt:     time.Now(),
+		IsMock:        l.IsMock,
+		Connectivity:  l.Connectivity,
+		HighFrequency: true,
+	}
+	if err := services.BindSource(hb, services.SourceEvidence{Channel: services.SourceHTTP, StreamAuthed: true}); err != nil {
+		s.reject(apierror.Internal("failed to store heartbeat", err))
+		return
+	}
+	user, err := s.h.postgres.GetUserByID(ctx, s.userID)
+	if err != nil {
+		s.reject(apierror.Internal("database error", err))
+		return
+	}
+	if user == nil {
+		s.reject(apierror.NotFound("user not found"))
+		return
+	}
+
+	evaluator.MarkDuplicate(ctx, hb)
+	s.h.spoof.Inspect(ctx, hb)
+	evaluator.MarkBackfill(ctx, hb)
+	// Streams are for incidents, when a coarse user's locations are kept
+	// precise anyway; one that just ended may not be
+	evaluator.CoarsenLocation(ctx, user.Settings, hb)
+
+	if s.h.buffer != nil {
+		if err := s.h.buffer.Enqueue(hb); err != nil {
+			evaluator.ReleaseFingerprint(ctx, hb)
+			s.reject(apierror.Unavailable("server busy, send the line again"))
+			return
+		}
+	} else if err := s.h.postgres.CreateHeartbeat(ctx, hb); err != nil {
+		evaluator.ReleaseFingerprint(ctx, hb)
+		log.Printf("ERROR: Failed to store streamed heartbeat of user %s: %v", s.userID, err)
+		s.reject(apierror.Internal("failed to store heartbeat", err))
+		return
+	}
+	evaluator.TrackDevice(ctx, hb)
+	evaluator.TrackLastGasp(ctx, hb)
+
+	s.accepted++
+	s.lastLocation = hb.Timestamp
+	if s.h.buffer == nil && !hb.Backfill && hb.DuplicateOf == nil {
+		s.pending = hb
+	}
+}
+
+// addSample adds a sensor line to the window and, once a second's worth of
+// newer samples arrived, checks the window for a crash signature
+func (s *heartbeatStream) addSample(l *streamLine) {
+	if l.Timestamp.Before(s.window.Newest()) {
+		s.reject(apierror.Invalid("timestamp", "must not be before the previous sensor sample's"))
+		return
+	}
+	s.window.Add(models.BlackboxEntry{Timestamp: l.Timestamp, SensorData: *l.SensorData})
+	s.accepted++
+
+	newest := s.window.Newest()
+	if newest.Sub(s.analyzed) < streamImpactEvery {
+		return
+	}
+	s.analyzed = newest
+	event, err := s.h.impacts.AnalyzeLive(s.c.Request.Context(), s.userID, s.window.Samples())
+	if err != nil {
+		log.Printf("ERROR: Live impact analysis of user %s failed: %v", s.userID, err)
+	}
+	if event != nil {
+		// Found once; the samples it was found in are done with
+		s.window.Forget(newest)
+	}
+}
+
+// acknowledge renews the stream's slot, evaluates the newest heartbeat
+// stored since the last ack and sends the user's state. It returns why the
+// stream ended, if it did.
+func (s *heartbeatStream) acknowledge() string {
+	idle := time.Duration(s.cfg.StreamIdleSeconds) * time.Second
+	if time.Since(s.lastRead) > idle {
+		return streamEndIdle
+	}
+
+	ctx := s.c.Request.Context()
+	ackEvery := time.Duration(s.cfg.StreamAckSeconds) * time.Second
+	if _, err := s.h.redis.ClaimHeartbeatStream(ctx, s.userID, s.id, time.Now(), streamLease(ackEvery), s.cfg.StreamMaxPerUser); err != nil {
+		log.Printf("WARN: Failed to renew heartbeat stream %s of user %s: %v", s.id, s.userID, err)
+	}
+	if s.pending != nil {
+		s.h.evaluator.EvaluateHeartbeatAsync(s.pending)
+		s.pending = nil
+	}
+
+	ack := s.counts("ack")
+	state, err := s.h.evaluator.CurrentState(ctx, s.userID)
+	if err != nil {
+		log.Printf("WARN: State unavailable for heartbeat stream of user %s: %v", s.userID, err)
+	}
+	if state != nil {
+		ack["state"] = state.State
+		ack["score"] = state.Score
+		if state.NextIntervalSeconds > 0 {
+			ack["next_interval_seconds"] = state.NextIntervalSeconds
+		}
+	}
+	if !s.write(ack) {
+		return streamEndDisconnected
+	}
+	if err == nil && !streamingState(state) {
+		return streamEndState
+	}
+	return ""
+}
+
+// finish evaluates what is left and sends the last line, which a client that
+// broke off won't read
+func (s *heartbeatStream) finish(reason string) {
+	if s.pending != nil {
+		s.h.evaluator.EvaluateHeartbeatAsync(s.pending)
+		s.pending = nil
+	}
+
+	end := s.counts("end")
+	end["reason"] = reason
+	end["partial_line"] = s.partial
+	if reason != streamEndDisconnected {
+		s.write(end)
+	}
+	log.Printf("INFO: Heartbeat stream %s of user %s ended (%s): %d lines, %d accepted, %d rejected, partial line %t",
+		s.id, s.userID, reason, s.lines, s.accepted, s.rejected, s.partial)
+}
+
+// counts starts a line of the given type with the stream's tallies
+func (s *heartbeatStream) counts(kind string) gin.H {
+	line := gin.H{
+		"type":     kind,
+		"lines":    s.lines,
+		"accepted": s.accepted,
+		"rejected": s.rejected,
+		"at":       time.Now().UTC(),
+	}
+	if !s.lastLocation.IsZero() {
+		// A client that reconnects resends from after this
+		line["last_location_at"] = s.lastLocation
+	}
+	return line
+}
+
+// reject answers the line just read with err, in the error envelope's fields
+func (s *heartbeatStream) reject(err *apierror.Error) {
+	s.rejected++
+	line := gin.H{
+		"type":    "error",
+		"line":    s.lines,
+		"code":    err.Code,
+		"message": err.Message,
+	}
+	if len(err.Fields) > 0 {
+		line["fields"] = err.Fields
+	}
+	s.write(line)
+}
+
+// write sends one line and flushes it, reporting whether it got out
+func (s *heartbeatStream) write(v gin.H) bool {
+	data, err := json.Marshal(v)
+	if err != nil {
+		log.Printf("ERROR: Failed to encode heartbeat stream line: %v", err)
+		return false
+	}
+	if _, err := s.c.Writer.Write(append(data, '\n')); err != nil {
+		return false
+	}
+	s.c.Writer.Flush()
+	return true
+}
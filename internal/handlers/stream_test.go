@@ -0,0 +1,488 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/apierror"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/config"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/database"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/middleware"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/services"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/utils"
+)
+
+// readAll collects what readStreamLines finds in body, one string per
+// read: the line, "too long", or the error it ended with and whether a
+// line was cut off
+func readAll(body io.Reader, max int) []string {
+	reads := make(chan streamRead)
+	go readStreamLines(body, max, reads, make(chan struct{}))
+	var got []string
+	for r := range reads {
+		switch {
+		case r.err != nil:
+			return append(got, fmt.Sprintf("%v partial=%t", r.err, r.partial))
+		case r.tooLong:
+			got = append(got, "too long")
+		default:
+			got = append(got, string(r.line))
+		}
+	}
+	return got
+}
+
+// Lines are found however the stream is chunked; what follows the last
+// newline is only ever reported as partial
+func TestReadStreamLines(t *testing.T) {
+	long := strings.Repeat("x", 40)
+	tests := []struct {
+		name string
+		body io.Reader
+		want []string
+	}{
+		{"whole lines", strings.NewReader("a\nb\n"), []string{"a\n", "b\n", "EOF partial=false"}},
+		{"byte at a time", iotest.OneByteReader(strings.NewReader("{\"a\":1}\n\n")), []string{"{\"a\":1}\n", "\n", "EOF partial=false"}},
+		{"partial final line", strings.NewReader("a\n{\"type\":\"loc"), []string{"a\n", "EOF partial=true"}},
+		{"line over the cap", strings.NewReader("a\n" + long + "\nb\n"), []string{"a\n", "too long", "b\n", "EOF partial=false"}},
+		{"line at the cap", strings.NewReader(long[:16] + "\n"), []string{long[:16] + "\n", "EOF partial=false"}},
+		{"partial line over the cap", strings.NewReader("a\n" + long), []string{"a\n", "EOF partial=true"}},
+		{"connection broken mid-line", io.MultiReader(strings.NewReader("a\nb"), iotest.ErrReader(io.ErrUnexpectedEOF)),
+			[]string{"a\n", "unexpected EOF partial=true"}},
+		{"connection broken between lines", io.MultiReader(strings.NewReader("a\n"), iotest.ErrReader(io.ErrUnexpectedEOF)),
+			[]string{"a\n", "unexpected EOF partial=false"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := readAll(tt.body, 16)
+			if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	// Once the stream is done with, the reader stops
+	reads, done := make(chan streamRead), make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		readStreamLines(strings.NewReader("a\nb\n"), 16, reads, done)
+		close(stopped)
+	}()
+	<-reads
+	close(done)
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Error("reader still running after the stream ended")
+	}
+}
+
+func TestStreamLineValidate(t *testing.T) {
+	at := time.Date(2026, 3, 2, 12, 0, 0, 0, time.UTC)
+	lat, lng, accuracy := 6.5244, 3.3792, 20
+	cell := &models.CellInfo{MCC: 621, MNC: 20, CID: 12345, LAC: 678}
+
+	tests := []struct {
+		name string
+		line streamLine
+		want []string // fields rejected
+	}{
+		{"location", streamLine{Type: "location", Timestamp: at, Lat: &lat, Lng: &lng, AccuracyM: &accuracy, CellInfo: cell}, nil},
+		{"sensor", streamLine{Type: "sensor", Timestamp: at, SensorData: &models.SensorData{AccelZ: 9.81}}, nil},
+		{"no timestamp", streamLine{Type: "sensor", SensorData: &models.SensorData{}}, []string{"timestamp"}},
+		{"no type", streamLine{Timestamp: at}, []string{"type"}},
+		{"unknown type", streamLine{Type: "gps", Timestamp: at}, []string{"type"}},
+		{"location without a cell", streamLine{Type: "location", Timestamp: at, Lat: &lat, Lng: &lng, AccuracyM: &accuracy}, []string{"cell_info"}},
+		{"location with a bad cell", streamLine{Type: "location", Timestamp: at, Lat: &lat, Lng: &lng, AccuracyM: &accuracy, CellInfo: &models.CellInfo{MCC: 6210}}, []string{"cell_info"}},
+		{"location with bad connectivity", streamLine{Type: "location", Timestamp: at, Lat: &lat, Lng: &lng, AccuracyM: &accuracy, CellInfo: cell, Connectivity: "5g"}, []string{"connectivity"}},
+		{"sensor without data", streamLine{Type: "sensor", Timestamp: at}, []string{"sensor_data"}},
+		{"sensor beyond a phone", streamLine{Type: "sensor", Timestamp: at, SensorData: &models.SensorData{AccelX: 1000, GyroZ: 100}}, []string{"sensor_data.accel_x", "sensor_data.gyro_z"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, f := range tt.line.validate() {
+				got = append(got, f.Field)
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("rejected %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// A line rejected mid-stream is answered with its number and the stream
+// goes on; a stream ending in a partial line says so and ends by why it ended
+func TestStreamHandle(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/stream/heartbeats", nil)
+	s := &heartbeatStream{c: c, cfg: &config.Config{StreamLineMaxBytes: 512}}
+
+	for _, r := range []streamRead{
+		{line: []byte("not json\n")},
+		{line: []byte("  \n")},
+		{tooLong: true},
+		{line: []byte(`{"type":"sensor","timestamp":"2026-03-02T12:00:00Z"}` + "\n")},
+	} {
+		if reason := s.handle(r); reason != "" {
+			t.Fatalf("stream ended (%s) on a rejected line", reason)
+		}
+	}
+	var errs []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(w.Body.String()), "\n") {
+		var e map[string]any
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			t.Fatalf("answer %q: %v", line, err)
+		}
+		errs = append(errs, e)
+	}
+	want := []struct {
+		line float64
+		code string
+	}{{1, apierror.CodeInvalidRequest}, {3, apierror.CodeTooLarge}, {4, apierror.CodeValidationFailed}}
+	if len(errs) != len(want) {
+		t.Fatalf("answered %v, want %d errors", errs, len(want))
+	}
+	for i, e := range errs {
+		if e["type"] != "error" || e["line"] != want[i].line || e["code"] != want[i].code {
+			t.Errorf("answer %d = %v, want line %v %s", i, e, want[i].line, want[i].code)
+		}
+	}
+	if s.lines != 4 || s.rejected != 3 || s.accepted != 0 {
+		t.Errorf("%d lines, %d rejected, %d accepted; want 4, 3, 0", s.lines, s.rejected, s.accepted)
+	}
+
+	tests := []struct {
+		read    streamRead
+		reason  string
+		partial bool
+	}{
+		{streamRead{err: io.EOF}, streamEndClosed, false},
+		{streamRead{err: io.EOF, partial: true}, streamEndClosed, true},
+		{streamRead{err: io.ErrUnexpectedEOF, partial: true}, streamEndDisconnected, true},
+	}
+	for _, tt := range tests {
+		if reason := s.handle(tt.read); reason != tt.reason || s.partial != tt.partial {
+			t.Errorf("%v: ended %q partial %t, want %q %t", tt.read.err, reason, s.partial, tt.reason, tt.partial)
+		}
+	}
+	if s.lines != 4 {
+		t.Errorf("the end counted as a line: %d lines", s.lines)
+	}
+}
+
+// streamServer serves heartbeat streams over a real connection, one per
+// user, acknowledged every second, in front of an evaluator whose pool is
+// never started
+func streamServer(t *testing.T, postgres *database.PostgresDB, redis *database.RedisDB) *httptest.Server {
+	t.Helper()
+	cfg := config.NewStore(&config.Config{
+		StreamMaxPerUser:            1,
+		StreamLineMaxBytes:          512,
+		StreamAckSeconds:            1,
+		StreamIdleSeconds:           30,
+		StreamMaxMinutes:            5,
+		ImpactThresholdG:            4,
+		ImpactStillSeconds:          10,
+		HeartbeatDedupWindowSeconds: 600,
+		EvaluationWorkers:           1,
+		EvaluationQueueSize:         10,
+		EvaluationStaleSeconds:      60,
+	})
+	evaluator := services.NewSafetyEvaluator(cfg, postgres, redis, nil, nil, nil, nil, nil, nil, nil, nil, services.NewHealthRegistry())
+	impacts := services.NewImpactAnalyzer(cfg, postgres, redis, evaluator, nil, nil)
+	h := NewStreamHandler(cfg, postgres, redis, evaluator, nil, services.NewSpoofDetector(postgres, nil), impacts)
+
+	router := testRouter()
+	router.POST("/v1/stream/heartbeats", middleware.RequireAuth(testSecret), h.StreamHeartbeats)
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+	t.Cleanup(h.Close)
+	return server
+}
+
+// streamClient is the app's end of a heartbeat stream: it writes lines as
+// it goes and reads the server's answers as they arrive
+type streamClient struct {
+	body    *io.PipeWriter
+	answers chan map[string]any
+}
+
+// openStream opens a stream as the user; the response is returned unread
+// when the stream was refused
+func openStream(t *testing.T, server *httptest.Server, userID uuid.UUID) (*streamClient, *http.Response) {
+	t.Helper()
+	token, err := utils.IssueToken(utils.TokenClaims{Subject: userID.String(), Role: utils.RoleUser}, testSecret, time.Hour)
+	if err != nil {
+		t.Fatalf("IssueToken: %v", err)
+	}
+	pr, pw := io.Pipe()
+	req, err := http.NewRequest(http.MethodPost, server.URL+"/v1/stream/heartbeats?device_id=pixel-7", pr)
+	if err != nil {
+		t.Fatalf("NewRequest: %v", err)
+	}
+	req.Header.Set("Content-Type", streamMediaType)
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := server.Client().Do(req)
+	if err != nil {
+		t.Fatalf("open stream: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		pw.Close()
+		resp.Body.Close()
+		return nil, resp
+	}
+
+	client := &streamClient{body: pw, answers: make(chan map[string]any, 64)}
+	go func() {
+		defer resp.Body.Close()
+		defer close(client.answers)
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			var answer map[string]any
+			if json.Unmarshal(scanner.Bytes(), &answer) == nil {
+				client.answers <- answer
+			}
+		}
+	}()
+	return client, resp
+}
+
+// write sends raw stream text, whole lines or not
+func (c *streamClient) write(t *testing.T, text string) {
+	t.Helper()
+	if _, err := io.WriteString(c.body, text); err != nil {
+		t.Fatalf("write to stream: %v", err)
+	}
+}
+
+// next returns the next answer other than an ack, or nil once the stream
+// has ended
+func (c *streamClient) next(t *testing.T) map[string]any {
+	t.Helper()
+	return c.await(t, func(a map[string]any) bool { return a["type"] != "ack" })
+}
+
+// await returns the first answer match accepts, or nil once the stream has
+// ended
+func (c *streamClient) await(t *testing.T, match func(map[string]any) bool) map[string]any {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case answer, ok := <-c.answers:
+			if !ok {
+				return nil
+			}
+			if match(answer) {
+				return answer
+			}
+		case <-timeout:
+			t.Fatal("no answer from the stream")
+		}
+	}
+}
+
+// locationLine is a location at the user's home in Lagos, taken at
+func locationLine(at time.Time) string {
+	return fmt.Sprintf(`{"type":"location","timestamp":%q,"lat":6.5244,"lng":3.3792,"accuracy_m":20,"cell_info":{"mcc":621,"mnc":20,"cid":12345,"lac":678}}`+"\n",
+		at.UTC().Format(time.RFC3339Nano))
+}
+
+func sensorLine(at time.Time, accelX float64) string {
+	return fmt.Sprintf(`{"type":"sensor","timestamp":%q,"sensor_data":{"accel_x":%g,"accel_z":9.81}}`+"\n",
+		at.UTC().Format(time.RFC3339Nano), accelX)
+}
+
+// streamingUser is a new user whose current state is state
+func streamingUser(t *testing.T, postgres *database.PostgresDB, redis *database.RedisDB, state string) *models.User {
+	t.Helper()
+	user := createTestUser(t, postgres)
+	if err := redis.SaveUserState(context.Background(), &models.UserState{UserID: user.ID, State: state, Score: 35, LastHeartbeat: time.Now()}); err != nil {
+		t.Fatalf("SaveUserState: %v", err)
+	}
+	return user
+}
+
+// Lines rejected mid-stream are answered one by one while the stream goes
+// on; a partial final line is reported and never stored
+func TestStreamValidationMidStream(t *testing.T) {
+	postgres, redis := testPostgres(t), testRedis(t)
+	server := streamServer(t, postgres, redis)
+	user := streamingUser(t, postgres, redis, services.StateAtRisk)
+	start := time.Now().Add(-time.Minute).Truncate(time.Second)
+
+	client, resp := openStream(t, server, user.ID)
+	if client == nil {
+		t.Fatalf("open stream = %d", resp.StatusCode)
+	}
+	if open := client.next(t); open["type"] != "open" || open["state"] != services.StateAtRisk {
+		t.Fatalf("first answer = %v, want open AT_RISK", open)
+	}
+
+	lines := []struct {
+		text  string
+		code  string // the error answered, "" when accepted
+		field string
+	}{
+		{locationLine(start), "", ""},
+		{"not json\n", apierror.CodeInvalidRequest, ""},
+		{`{"type":"location","timestamp":"` + start.Add(5*time.Second).UTC().Format(time.RFC3339) + `","lat":6.5,"lng":3.4,"accuracy_m":20}` + "\n", apierror.CodeValidationFailed, "cell_info"},
+		{locationLine(start.Add(time.Second)), apierror.CodeValidationFailed, "timestamp"},
+		{strings.Repeat("x", 600) + "\n", apierror.CodeTooLarge, ""},
+		{sensorLine(start, 1000), apierror.CodeValidationFailed, "sensor_data.accel_x"},
+		{"\n", "", ""},
+		{`{"type":"gps"}` + "\n", apierror.CodeValidationFailed, "type"},
+		{locationLine(start.Add(5 * time.Second)), "", ""},
+		{sensorLine(start.Add(5*time.Second), 0.2), "", ""},
+	}
+	rejected := 0
+	for i, l := range lines {
+		client.write(t, l.text)
+		if l.code == "" {
+			continue
+		}
+		rejected++
+		answer := client.next(t)
+		if answer["type"] != "error" || answer["line"] != float64(i+1) || answer["code"] != l.code {
+			t.Errorf("line %d answered %v, want error %s", i+1, answer, l.code)
+			continue
+		}
+		if l.field != "" {
+			fields, _ := answer["fields"].([]any)
+			if len(fields) == 0 || fields[0].(map[string]any)["field"] != l.field {
+				t.Errorf("line %d rejected %v, want %s", i+1, answer["fields"], l.field)
+			}
+		}
+	}
+	accepted := 3
+	ack := client.await(t, func(a map[string]any) bool { return a["type"] == "ack" && a["accepted"] == float64(accepted) })
+	if ack["state"] != services.StateAtRisk || ack["rejected"] != float64(rejected) {
+		t.Errorf("ack = %v, want AT_RISK with %d rejected", ack, rejected)
+	}
+
+	// The app is cut off partway through a line as it finishes
+	client.write(t, `{"type":"location","timestamp":"`+start.Add(10*time.Second).UTC().Format(time.RFC3339))
+	client.body.Close()
+	end := client.next(t)
+	if end["type"] != "end" || end["reason"] != streamEndClosed || end["partial_line"] != true {
+		t.Fatalf("last answer = %v, want end closed with a partial line", end)
+	}
+	if end["lines"] != float64(len(lines)) || end["accepted"] != float64(accepted) || end["rejected"] != float64(rejected) {
+		t.Errorf("end = %v, want %d lines, %d accepted, %d rejected", end, len(lines), accepted, rejected)
+	}
+	if at, _ := time.Parse(time.RFC3339Nano, fmt.Sprint(end["last_location_at"])); !at.Equal(start.Add(5 * time.Second)) {
+		t.Errorf("last location at %v, want %s", end["last_location_at"], start.Add(5*time.Second))
+	}
+	if client.next(t) != nil {
+		t.Error("answers after the end")
+	}
+
+	stored, err := postgres.GetHeartbeatsSince(context.Background(), user.ID, start.Add(-time.Second))
+	if err != nil {
+		t.Fatalf("GetHeartbeatsSince: %v", err)
+	}
+	if len(stored) != 2 {
+		t.Fatalf("%d heartbeats stored, want the 2 locations accepted", len(stored))
+	}
+	for _, hb := range stored {
+		if !hb.HighFrequency || hb.DeviceID != "pixel-7" {
+			t.Errorf("stored %s: high frequency %t, device %q", hb.Timestamp, hb.HighFrequency, hb.DeviceID)
+		}
+	}
+}
+
+// A stream cut off abruptly frees its slot, so the app can reconnect and
+// go on from the last location acknowledged; only one is open at a time
+func TestStreamDisconnectRecovery(t *testing.T) {
+	postgres, redis := testPostgres(t), testRedis(t)
+	server := streamServer(t, postgres, redis)
+	user := streamingUser(t, postgres, redis, services.StateAlert)
+	start := time.Now().Add(-time.Minute).Truncate(time.Second)
+
+	first, resp := openStream(t, server, user.ID)
+	if first == nil {
+		t.Fatalf("open stream = %d", resp.StatusCode)
+	}
+	first.next(t)
+	first.write(t, locationLine(start))
+	ack := first.await(t, func(a map[string]any) bool { return a["type"] == "ack" && a["accepted"] == float64(1) })
+	if ack["state"] != services.StateAlert {
+		t.Errorf("ack = %v, want ALERT", ack)
+	}
+
+	if _, resp := openStream(t, server, user.ID); resp.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("second stream while the first is open = %d, want 429", resp.StatusCode)
+	}
+
+	// The connection drops partway through a line
+	first.write(t, `{"type":"location","lat":6.52`)
+	first.body.CloseWithError(errors.New("network lost"))
+	if end := first.next(t); end != nil {
+		t.Errorf("broken stream answered %v", end)
+	}
+
+	var second *streamClient
+	for deadline := time.Now().Add(5 * time.Second); second == nil; {
+		second, resp = openStream(t, server, user.ID)
+		if second == nil {
+			if resp.StatusCode != http.StatusTooManyRequests || time.Now().After(deadline) {
+				t.Fatalf("reconnect = %d", resp.StatusCode)
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+	}
+	if open := second.next(t); open["type"] != "open" {
+		t.Fatalf("first answer = %v, want open", open)
+	}
+	second.write(t, locationLine(start.Add(10*time.Second)))
+	second.body.Close()
+	end := second.next(t)
+	if end["type"] != "end" || end["reason"] != streamEndClosed || end["partial_line"] != false || end["accepted"] != float64(1) {
+		t.Errorf("last answer = %v, want end closed with 1 accepted", end)
+	}
+
+	stored, err := postgres.GetHeartbeatsSince(context.Background(), user.ID, start.Add(-time.Second))
+	if err != nil {
+		t.Fatalf("GetHeartbeatsSince: %v", err)
+	}
+	if len(stored) != 2 {
+		t.Errorf("%d heartbeats stored, want one from each stream", len(stored))
+	}
+}
+
+// A stream is only for a user AT_RISK or in ALERT, in NDJSON
+func TestStreamRefused(t *testing.T) {
+	postgres, redis := testPostgres(t), testRedis(t)
+	server := streamServer(t, postgres, redis)
+
+	safe := streamingUser(t, postgres, redis, services.StateSafe)
+	if _, resp := openStream(t, server, safe.ID); resp.StatusCode != http.StatusConflict {
+		t.Errorf("stream of a SAFE user = %d, want 409", resp.StatusCode)
+	}
+
+	atRisk := streamingUser(t, postgres, redis, services.StateAtRisk)
+	claims := utils.TokenClaims{Subject: atRisk.ID.String(), Role: utils.RoleUser}
+	if w := send(t, server.Config.Handler, http.MethodPost, "/v1/stream/heartbeats", "{}", &claims); w.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("JSON body = %d, want 415", w.Code)
+	}
+	if w := send(t, server.Config.Handler, http.MethodPost, "/v1/stream/heartbeats", "{}", nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("no token = %d, want 401", w.Code)
+	}
+}
@@ -0,0 +1,46 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/apierror"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/database"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/middleware"
+)
+
+type SummaryHandler struct {
+	postgres *database.PostgresDB
+}
+
+func NewSummaryHandler(postgres *database.PostgresDB) *SummaryHandler {
+	return &SummaryHandler{postgres: postgres}
+}
+
+// GET /v1/user/:user_id/summaries/:date
+// The daily summary for a local day (YYYY-MM-DD in the user's time zone).
+// Only the user can see it. A day is summarized shortly after it ends, and
+// only if the user's devices reported that day.
+func (h *SummaryHandler) GetSummary(c *gin.Context) {
+	userID, ok := requireSelf(c, "only the user can see their daily summaries")
+	if !ok {
+		return
+	}
+	date := c.Param("date")
+	if _, err := time.Parse("2006-01-02", date); err != nil {
+		middleware.AbortWithError(c, apierror.Invalid("date", "must be a date as YYYY-MM-DD"))
+		return
+	}
+
+	summary, err := h.postgres.GetDailySummary(c.Request.Context(), userID, date)
+	if err != nil {
+		middleware.AbortWithError(c, apierror.Internal("failed to get daily summary", err))
+		return
+	}
+	if summary == nil {
+		middleware.AbortWithError(c, apierror.NotFound("no summary for this date"))
+		return
+	}
+	c.JSON(http.StatusOK, summary)
+}
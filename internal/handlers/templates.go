@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/apierror"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/middleware"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/services"
+)
+
+type TemplatesHandler struct {
+	templates *services.MessageTemplates
+}
+
+func NewTemplatesHandler(templates *services.MessageTemplates) *TemplatesHandler {
+	return &TemplatesHandler{templates: templates}
+}
+
+// GET /admin/templates
+// Every outbound message template as currently in use, with its default
+func (h *TemplatesHandler) ListTemplates(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"templates": h.templates.List(),
+		"sample":    services.SampleMessageData(),
+	})
+}
+
+// PreviewTemplateRequest renders Body (or the template in use when empty)
+// against the sample data, with Data overriding individual variables
+type PreviewTemplateRequest struct {
+	Name string          `json:"name" binding:"required"`
+	Body string          `json:"body"`
+	Data json.RawMessage `json:"data"`
+}
+
+// POST /admin/templates/preview
+func (h *TemplatesHandler) PreviewTemplate(c *gin.Context) {
+	var req PreviewTemplateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		middleware.AbortWithError(c, apierror.Validation(err))
+		return
+	}
+
+	var data *services.MessageData
+	if len(req.Data) > 0 {
+		sample := services.SampleMessageData()
+		if err := json.Unmarshal(req.Data, &sample); err != nil {
+			middleware.AbortWithError(c, apierror.Invalid("data", "must be an object of template variables"))
+			return
+		}
+		data = &sample
+	}
+
+	preview, err := h.templates.Preview(req.Name, req.Body, data)
+	if errors.Is(err, services.ErrUnknownTemplate) {
+		middleware.AbortWithError(c, apierror.Invalid("name", err.Error()))
+		return
+	}
+	if err != nil {
+		middleware.AbortWithError(c, apierror.Invalid("body", err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, preview)
+}
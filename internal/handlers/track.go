@@ -0,0 +1,238 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/apierror"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/middleware"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/services"
+)
+
+type TrackHandler struct {
+	shares *services.AlertShareService
+	maps   *services.MapSnapshots
+	audit  *services.AuditLogger
+}
+
+func NewTrackHandler(shares *services.AlertShareService, maps *services.MapSnapshots, audit *services.AuditLogger) *TrackHandler {
+	return &TrackHandler{
+		shares: shares,
+		maps:   maps,
+		audit:  audit,
+	}
+}
+
+// GET /v1/track/:token/page
+// The contact dashboard of an active alert, for a lightweight web page. The
+// token is a recipient's ack token or a link they shared; actions lists the
+// endpoints the page's buttons call, which read-only links don't get.
+func (h *TrackHandler) GetPage(c *gin.Context) {
+	share, alert, ok := h.open(c, services.AuditDashboardView)
+	if !ok {
+		return
+	}
+
+	page, err := h.shares.Page(c.Request.Context(), share, alert)
+	if err != nil {
+		middleware.AbortWithError(c, apierror.Internal("failed to build dashboard", err))
+		return
+	}
+
+	h.record(c, services.AuditDashboardView, share, alert, nil)
+	c.JSON(http.StatusOK, page)
+}
+
+// GET /map/:token
+// The alert's map snapshot, linked from the alert SMS and fetched by Twilio
+// to attach to WhatsApp messages. Any dashboard token of the alert opens it
+// until the alert is resolved. It is not audited: Twilio's fetch would be
+// recorded as the recipient viewing it.
+func (h *TrackHandler) GetMap(c *gin.Context) {
+	_, alert, err := h.shares.Open(c.Request.Context(), c.Param("token"))
+	switch {
+	case errors.Is(err, services.ErrShareInvalid):
+		middleware.AbortWithError(c, apierror.NotFound("this link is not valid"))
+		return
+	case errors.Is(err, services.ErrShareResolved):
+		middleware.AbortWithError(c, apierror.Gone("the alert has been resolved; this link no longer works"))
+		return
+	case err != nil:
+		middleware.AbortWithError(c, apierror.Internal("failed to open map", err))
+		return
+	}
+
+	image, err := h.maps.Image(c.Request.Context(), alert.ID)
+	if errors.Is(err, services.ErrMapSnapshotMissing) || errors.Is(err, services.ErrObjectStoreDisabled) {
+		middleware.AbortWithError(c, apierror.NotFound("this alert has no map"))
+		return
+	}
+	if err != nil {
+		middleware.AbortWithError(c, apierror.Internal("failed to load map", err))
+		return
+	}
+
+	c.Header("Cache-Control", "private, max-age=300")
+	c.Data(http.StatusOK, "image/png", image)
+}
+
+// POST /v1/track/:token/acknowledge
+// Records that the recipient has seen the alert (acknowledge scope only)
+func (h *TrackHandler) Acknowledge(c *gin.Context) {
+	share, alert, ok := h.open(c, services.AuditDashboardAck)
+	if !ok {
+		return
+	}
+
+	recipient, err := h.shares.Acknowledge(c.Request.Context(), share)
+	if errors.Is(err, services.ErrShareReadOnly) {
+		h.record(c, services.AuditDashboardAck, share, alert, map[string]interface{}{"refused": "read_only"})
+		middleware.AbortWithError(c, apierror.Forbidden("this link can only view the alert"))
+		return
+	}
+	if err != nil {
+		middleware.AbortWithError(c, apierror.Internal("failed to acknowledge alert", err))
+		return
+	}
+
+	h.record(c, services.AuditDashboardAck, share, alert, nil)
+	c.JSON(http.StatusOK, gin.H{
+		"status":          "success",
+		"acknowledged_at": recipient.AcknowledgedAt,
+	})
+}
+
+// POST /v1/track/:token/welfare-check
+// Asks the user to confirm they are okay on behalf of the recipient, as
+// POST /v1/user/:user_id/welfare-check does (acknowledge scope only, and
+// only while the recipient is still one of the user's trusted contacts)
+func (h *TrackHandler) RequestWelfareCheck(c *gin.Context) {
+	share, alert, ok := h.open(c, services.AuditWelfareRequest)
+	if !ok {
+		return
+	}
+
+	check, err := h.shares.RequestWelfareCheck(c.Request.Context(), share, alert)
+	refused := ""
+	switch {
+	case errors.Is(err, services.ErrShareReadOnly):
+		refused = "read_only"
+	case errors.Is(err, services.ErrShareNotContact):
+		refused = "not_contact"
+	case errors.Is(err, services.ErrWelfareCheckPending):
+		refused = "pending"
+	case errors.Is(err, services.ErrWelfareCheckLimit):
+		refused = "daily_limit"
+	case err != nil:
+		middleware.AbortWithError(c, apierror.Internal("failed to request welfare check", err))
+		return
+	}
+
+	metadata := map[string]interface{}{}
+	if check != nil {
+		metadata["welfare_check_id"] = check.ID.String()
+	}
+	if refused != "" {
+		metadata["refused"] = refused
+	}
+	h.record(c, services.AuditWelfareRequest, share, alert, metadata)
+
+	switch refused {
+	case "read_only":
+		middleware.AbortWithError(c, apierror.Forbidden("this link can only view the alert"))
+		return
+	case "not_contact":
+		middleware.AbortWithError(c, apierror.Forbidden("only a trusted contact can request a welfare check"))
+		return
+	case "pending":
+		middleware.AbortWithError(c, apierror.Conflict("a welfare check on this user is already waiting for an answer"))
+		return
+	case "daily_limit":
+		middleware.AbortWithError(c, apierror.TooManyRequests("daily welfare check limit reached for this user"))
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"status":        "requested",
+		"welfare_check": check,
+	})
+}
+
+type ShareAlertRequest struct {
+	Scope string `json:"scope" binding:"required,oneof=read acknowledge"`
+}
+
+// POST /v1/track/:token/share
+// Makes another dashboard link for the same recipient, read-only or
+// acknowledge-capable, to pass on (acknowledge scope only)
+func (h *TrackHandler) Share(c *gin.Context) {
+	share, alert, ok := h.open(c, services.AuditDashboardShare)
+	if !ok {
+		return
+	}
+
+	var req ShareAlertRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		middleware.AbortWithError(c, apierror.Validation(err))
+		return
+	}
+
+	shared, err := h.shares.Share(c.Request.Context(), share, req.Scope)
+	if errors.Is(err, services.ErrShareReadOnly) {
+		h.record(c, services.AuditDashboardShare, share, alert, map[string]interface{}{"refused": "read_only"})
+		middleware.AbortWithError(c, apierror.Forbidden("this link can only view the alert"))
+		return
+	}
+	if err != nil {
+		middleware.AbortWithError(c, apierror.Internal("failed to share alert", err))
+		return
+	}
+
+	h.record(c, services.AuditDashboardShare, share, alert, map[string]interface{}{"shared_scope": shared.Scope})
+	c.JSON(http.StatusCreated, gin.H{
+		"token": shared.Token,
+		"scope": shared.Scope,
+		"link":  h.shares.Link(shared.Token),
+		"page":  "/v1/track/" + shared.Token + "/page",
+	})
+}
+
+// open resolves the :token dashboard token. Unknown tokens get 404; once the
+// alert is resolved every token gets 410, which is audited as a refusal. It
+// writes the error response itself and returns false otherwise.
+func (h *TrackHandler) open(c *gin.Context, action string) (*models.AlertShare, *models.Alert, bool) {
+	share, alert, err := h.shares.Open(c.Request.Context(), c.Param("token"))
+	switch {
+	case errors.Is(err, services.ErrShareInvalid):
+		middleware.AbortWithError(c, apierror.NotFound("this link is not valid"))
+		return nil, nil, false
+	case errors.Is(err, services.ErrShareResolved):
+		h.record(c, action, share, alert, map[string]interface{}{"refused": "resolved"})
+		middleware.AbortWithError(c, apierror.Gone("the alert has been resolved; this link no longer works"))
+		return nil, nil, false
+	case err != nil:
+		middleware.AbortWithError(c, apierror.Internal("failed to open dashboard", err))
+		return nil, nil, false
+	}
+	return share, alert, true
+}
+
+// record audits dashboard access; the actor is whoever holds the token, so
+// the recipient it acts for and its scope go in the metadata
+func (h *TrackHandler) record(c *gin.Context, action string, share *models.AlertShare, alert *models.Alert, metadata map[string]interface{}) {
+	if metadata == nil {
+		metadata = map[string]interface{}{}
+	}
+	metadata["recipient_id"] = share.Recipient.ID.String()
+	metadata["scope"] = share.Scope
+	metadata["via"] = "dashboard"
+	recordAudit(c, h.audit, &models.AuditEvent{
+		Action:        action,
+		ObjectType:    "alert",
+		ObjectID:      alert.ID.String(),
+		SubjectUserID: &alert.UserID,
+		Metadata:      metadata,
+	})
+}
@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/config"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/database"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/services"
+)
+
+func trackRouter(h *TrackHandler) http.Handler {
+	router := testRouter()
+	router.GET("/map/:token", h.GetMap)
+	router.GET("/v1/track/:token/page", h.GetPage)
+	router.POST("/v1/track/:token/acknowledge", h.Acknowledge)
+	router.POST("/v1/track/:token/welfare-check", h.RequestWelfareCheck)
+	router.POST("/v1/track/:token/share", h.Share)
+	return router
+}
+
+// sharedAlert stores an open alert on a new user, sent to one recipient who
+// isn't among their trusted contacts, and returns it with the recipient's
+// ack token
+func sharedAlert(t *testing.T, postgres *database.PostgresDB) (*models.Alert, string) {
+	t.Helper()
+	ctx := context.Background()
+	user := createTestUser(t, postgres)
+	now := time.Now()
+	alert := &models.Alert{ID: uuid.New(), UserID: user.ID, State: models.AlertStateAlert, Reasons: models.Reasons{}, SentTo: []string{}, CreatedAt: now, DetectedAt: now}
+	if err := postgres.CreateAlert(ctx, alert); err != nil {
+		t.Fatalf("CreateAlert: %v", err)
+	}
+	token := "ack-" + uuid.NewString()
+	recipient := &models.AlertRecipient{
+		ID: uuid.New(), AlertID: alert.ID, ContactID: "c1", ContactName: "Tunde",
+		Phone: "+2348030000000", Channel: "sms", AckToken: token, CreatedAt: now,
+	}
+	if err := postgres.CreateAlertRecipient(ctx, recipient); err != nil {
+		t.Fatalf("CreateAlertRecipient: %v", err)
+	}
+	return alert, token
+}
+
+// share makes a dashboard link with the scope from token, failing unless
+// token may make one
+func share(t *testing.T, router http.Handler, token, scope string) string {
+	t.Helper()
+	w := send(t, router, http.MethodPost, "/v1/track/"+token+"/share", `{"scope":"`+scope+`"}`, nil)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("share %s = %d: %s", scope, w.Code, w.Body.String())
+	}
+	var resp struct {
+		Token string `json:"token"`
+		Scope string `json:"scope"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("share response: %v", err)
+	}
+	if resp.Scope != scope {
+		t.Fatalf("shared scope = %q, want %q", resp.Scope, scope)
+	}
+	return resp.Token
+}
+
+// A read-only link only views; an acknowledge-capable one acts for the
+// recipient, and only it can make links to pass on
+func TestTrackScopes(t *testing.T) {
+	postgres := testPostgres(t)
+	shares := services.NewAlertShareService(config.NewStore(&config.Config{}), postgres, nil, nil, nil)
+	router := trackRouter(NewTrackHandler(shares, nil, nil))
+	_, ackToken := sharedAlert(t, postgres)
+
+	readToken := share(t, router, ackToken, models.ShareScopeRead)
+	passedOn := share(t, router, ackToken, models.ShareScopeAcknowledge)
+
+	tests := []struct {
+		name   string
+		path   string
+		body   string
+		token  string
+		status int
+	}{
+		{"read-only acknowledge", "/acknowledge", "", readToken, http.StatusForbidden},
+		{"read-only welfare check", "/welfare-check", "", readToken, http.StatusForbidden},
+		{"read-only share", "/share", `{"scope":"read"}`, readToken, http.StatusForbidden},
+		{"read-only share escalating", "/share", `{"scope":"acknowledge"}`, readToken, http.StatusForbidden},
+		{"acknowledge", "/acknowledge", "", ackToken, http.StatusOK},
+		{"passed-on acknowledge", "/acknowledge", "", passedOn, http.StatusOK},
+		{"share of an unknown scope", "/share", `{"scope":"admin"}`, ackToken, http.StatusBadRequest},
+		// Allowed by scope, but the recipient isn't a trusted contact
+		{"welfare check by a non-contact", "/welfare-check", "", ackToken, http.StatusForbidden},
+		{"unknown token", "/acknowledge", "", "ack-" + uuid.NewString(), http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := send(t, router, http.MethodPost, "/v1/track/"+tt.token+tt.path, tt.body, nil)
+			if w.Code != tt.status {
+				t.Errorf("POST %s = %d, want %d: %s", tt.path, w.Code, tt.status, w.Body.String())
+			}
+		})
+	}
+}
+
+// Once the alert is resolved every link to it stops working, whatever its
+// scope, and each attempt is audited as refused
+func TestTrackLockedAfterResolution(t *testing.T) {
+	postgres := testPostgres(t)
+	ctx := context.Background()
+	audit := services.NewAuditLogger(postgres, nil, 100, 100, time.Hour)
+	audit.Start()
+	shares := services.NewAlertShareService(config.NewStore(&config.Config{}), postgres, nil, nil, nil)
+	router := trackRouter(NewTrackHandler(shares, nil, audit))
+	alert, ackToken := sharedAlert(t, postgres)
+	readToken := share(t, router, ackToken, models.ShareScopeRead)
+	passedOn := share(t, router, ackToken, models.ShareScopeAcknowledge)
+
+	if err := postgres.ResolveAlert(ctx, alert.ID); err != nil {
+		t.Fatalf("ResolveAlert: %v", err)
+	}
+
+	requests := []struct{ method, path, body string }{
+		{http.MethodGet, "/page", ""},
+		{http.MethodPost, "/acknowledge", ""},
+		{http.MethodPost, "/welfare-check", ""},
+		{http.MethodPost, "/share", `{"scope":"read"}`},
+	}
+	for _, token := range []string{ackToken, readToken, passedOn} {
+		for _, r := range requests {
+			if w := send(t, router, r.method, "/v1/track/"+token+r.path, r.body, nil); w.Code != http.StatusGone {
+				t.Errorf("%s %s after resolution = %d, want 410", r.method, r.path, w.Code)
+			}
+		}
+		if w := send(t, router, http.MethodGet, "/map/"+token, "", nil); w.Code != http.StatusGone {
+			t.Errorf("GET /map after resolution = %d, want 410", w.Code)
+		}
+	}
+
+	audit.Close()
+	events, _, err := postgres.GetAuditEvents(ctx, models.AuditFilter{SubjectUserID: &alert.UserID}, 100, 0)
+	if err != nil {
+		t.Fatalf("GetAuditEvents: %v", err)
+	}
+	refused := 0
+	for _, event := range events {
+		if event.Metadata["refused"] == "resolved" {
+			refused++
+		}
+	}
+	// The map isn't audited
+	if want := 3 * len(requests); refused != want {
+		t.Errorf("%d refusals audited, want %d", refused, want)
+	}
+}
@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/apierror"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/middleware"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/params"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/services"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/utils"
+)
+
+// An unverified report may be this old, or this far ahead of the server's clock
+const (
+	unverifiedMaxAge        = 6 * time.Hour
+	unverifiedMaxFutureSkew = 5 * time.Minute
+)
+
+type UnverifiedReportsHandler struct {
+	reports *services.UnverifiedReports
+	audit   *services.AuditLogger
+}
+
+func NewUnverifiedReportsHandler(reports *services.UnverifiedReports, audit *services.AuditLogger) *UnverifiedReportsHandler {
+	return &UnverifiedReportsHandler{
+		reports: reports,
+		audit:   audit,
+	}
+}
+
+type UnverifiedReportRequest struct {
+	Phone     string    `json:"phone" binding:"required"`
+	Lat       *float64  `json:"lat"`
+	Lng       *float64  `json:"lng"`
+	AccuracyM *int      `json:"accuracy_m,omitempty"`
+	Timestamp time.Time `json:"timestamp" binding:"required"`
+}
+
+// POST /v1/heartbeat/unverified
+// A position sent with only a phone number, by an app that lost its keys.
+// It is answered the same whether or not the number is a user's. Past the
+// first few reports an hour from a number or IP, the answer is 428 with a
+// proof-of-work challenge, and the report must be sent again with
+// X-Proof-Of-Work: <challenge>:<nonce>.
+func (h *UnverifiedReportsHandler) Submit(c *gin.Context) {
+	var req UnverifiedReportRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		middleware.AbortWithError(c, apierror.Validation(err))
+		return
+	}
+
+	phone := utils.NormalizePhone(req.Phone)
+	// Unlike a heartbeat's, the accuracy may be left out
+	accuracyM := req.AccuracyM
+	if accuracyM == nil {
+		accuracyM = new(int)
+	}
+	fields := validateFix(req.Lat, req.Lng, accuracyM)
+	if !utils.IsValidE164(phone) {
+		fields = append(fields, apierror.FieldError{Field: "phone", Reason: "must be a valid phone number"})
+	}
+	if age := time.Since(req.Timestamp); age > unverifiedMaxAge || age < -unverifiedMaxFutureSkew {
+		fields = append(fields, apierror.FieldError{Field: "timestamp", Reason: "must be within the last 6 hours"})
+	}
+	if len(fields) > 0 {
+		middleware.AbortWithError(c, apierror.Unprocessable(fields))
+		return
+	}
+
+	challenge, err := h.reports.Submit(c.Request.Context(), services.UnverifiedReportInput{
+		Phone:       phone,
+		Lat:         *req.Lat,
+		Lng:         *req.Lng,
+		AccuracyM:   req.AccuracyM,
+		ReportedAt:  req.Timestamp,
+		ClientIP:    c.ClientIP(),
+		ProofOfWork: c.GetHeader("X-Proof-Of-Work"),
+	})
+	if errors.Is(err, services.ErrReportLimited) {
+		middleware.AbortWithError(c, apierror.TooManyRequests("too many unverified reports, try again later"))
+		return
+	}
+	if err != nil {
+		middleware.AbortWithError(c, apierror.Internal("failed to accept report", err))
+		return
+	}
+	if challenge != nil {
+		c.JSON(http.StatusPreconditionRequired, gin.H{
+			"status":        "proof_of_work_required",
+			"proof_of_work": challenge,
+		})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"status": "accepted"})
+}
+
+type AnswerUnverifiedReportRequest struct {
+	Confirmed *bool `json:"confirmed" binding:"required"`
+}
+
+// POST /v1/user/:user_id/unverified-reports/:report_id/answer
+// The user answers the verification push of a report: confirmed, it is
+// labelled as theirs on the contact dashboard; denied, it is hidden
+func (h *UnverifiedReportsHandler) Answer(c *gin.Context) {
+	userID, ok := requireSelf(c, "only the user can answer for their reports")
+	if !ok {
+		return
+	}
+
+	var req AnswerUnverifiedReportRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		middleware.AbortWithError(c, apierror.Validation(err))
+		return
+	}
+
+	report, err := h.reports.Answer(c.Request.Context(), userID, params.Get(c, params.Report), *req.Confirmed)
+	if errors.Is(err, services.ErrReportNotFound) {
+		middleware.AbortWithError(c, apierror.NotFound("report not found"))
+		return
+	}
+	if errors.Is(err, services.ErrReportAnswered) {
+		middleware.AbortWithError(c, apierror.Conflict("report already answered"))
+		return
+	}
+	if err != nil {
+		middleware.AbortWithError(c, apierror.Internal("failed to answer report", err))
+		return
+	}
+
+	recordAudit(c, h.audit, &models.AuditEvent{
+		Action:        services.AuditUnverifiedAnswer,
+		ObjectType:    "unverified_report",
+		ObjectID:      report.ID.String(),
+		SubjectUserID: &userID,
+		Metadata:      map[string]interface{}{"trust": report.Trust},
+	})
+
+	c.JSON(http.StatusOK, gin.H{"report": report})
+}
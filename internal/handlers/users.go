@@ -0,0 +1,248 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/apierror"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/config"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/country"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/database"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/middleware"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/params"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/services"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/utils"
+)
+
+type UsersHandler struct {
+	cfg          *config.Config
+	postgres     *database.PostgresDB
+	evaluator    *services.SafetyEvaluator
+	publicStatus *services.PublicStatusService
+	warnings     *services.ProtectionWarnings
+	consents     *services.ConsentService
+	audit        *services.AuditLogger
+}
+
+func NewUsersHandler(
+	cfg *config.Config,
+	postgres *database.PostgresDB,
+	evaluator *services.SafetyEvaluator,
+	publicStatus *services.PublicStatusService,
+	warnings *services.ProtectionWarnings,
+	consents *services.ConsentService,
+	audit *services.AuditLogger,
+) *UsersHandler {
+	return &UsersHandler{
+		cfg:          cfg,
+		postgres:     postgres,
+		evaluator:    evaluator,
+		publicStatus: publicStatus,
+		warnings:     warnings,
+		consents:     consents,
+		audit:        audit,
+	}
+}
+
+type RegisterUserRequest struct {
+	Name  string `json:"name" binding:"required"`
+	Phone string `json:"phone" binding:"required"`
+}
+
+// POST /v1/users
+func (h *UsersHandler) Register(c *gin.Context) {
+	var req RegisterUserRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		middleware.AbortWithError(c, apierror.Validation(err))
+		return
+	}
+
+	phone := utils.NormalizePhone(req.Phone)
+	if !utils.IsValidE164(phone) {
+		middleware.AbortWithError(c, apierror.Invalid("phone", "must be a valid phone number"))
+		return
+	}
+
+	now := time.Now()
+	user := &models.User{
+		ID:              uuid.New(),
+		Phone:           phone,
+		Name:            req.Name,
+		TrustedContacts: models.TrustedContacts{},
+		Settings:        h.defaultSettings(phone),
+		CreatedAt:       now,
+		UpdatedAt:       now,
+	}
+
+	// Uniqueness is enforced by the database, so concurrent sign-ups with the
+	// same number cannot both succeed
+	if err := h.postgres.CreateUser(c.Request.Context(), user); err != nil {
+		if errors.Is(err, database.ErrPhoneAlreadyRegistered) {
+			middleware.AbortWithError(c, apierror.Conflict("phone number already registered"))
+			return
+		}
+		middleware.AbortWithError(c, apierror.Internal("failed to register user", err))
+		return
+	}
+
+	token, err := utils.IssueToken(utils.TokenClaims{
+		Subject: user.ID.String(),
+		Role:    utils.RoleUser,
+	}, h.cfg.JWTSecret, time.Duration(h.cfg.TokenTTLHours)*time.Hour)
+	if err != nil {
+		middleware.AbortWithError(c, apierror.Internal("failed to issue token", err))
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"status":       "success",
+		"user":         user,
+		"access_token": token,
+	})
+}
+
+type PushTokenRequest struct {
+	FCMToken string `json:"fcm_token" binding:"required"`
+}
+
+// PUT /v1/user/:user_id/push-token
+func (h *UsersHandler) RegisterPushToken(c *gin.Context) {
+	userID := params.UserID(c)
+
+	claims := middleware.Principal(c)
+	if claims == nil || claims.Role != utils.RoleUser || claims.Subject != userID.String() {
+		middleware.AbortWithError(c, apierror.Forbidden("not allowed to access this user"))
+		return
+	}
+
+	var req PushTokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		middleware.AbortWithError(c, apierror.Validation(err))
+		return
+	}
+
+	if err := h.postgres.UpsertPushToken(c.Request.Context(), userID, req.FCMToken); err != nil {
+		middleware.AbortWithError(c, apierror.Internal("failed to store push token", err))
+		return
+	}
+	// A user who hasn't added a contact yet learns nobody would be alerted
+	h.warnings.Check(c.Request.Context(), userID)
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": "push token registered",
+	})
+}
+
+// PATCH /v1/user/:user_id/settings
+// Applies a JSON Merge Patch to the user's settings: fields left out are
+// unchanged and null resets one to its default. Intervals and the silent
+// prompt timeout out of bounds are clamped and reported under "adjusted";
+// unknown fields and invalid values are rejected with 422. Interval changes
+// re-evaluate the user at once, so the advice and the stale-user monitor's
+// schedule follow them.
+func (h *UsersHandler) UpdateSettings(c *gin.Context) {
+	userID, ok := requireSelf(c, "only the user can change their settings")
+	if !ok {
+		return
+	}
+
+	var patch map[string]json.RawMessage
+	body, err := c.GetRawData()
+	if err == nil {
+		err = json.Unmarshal(body, &patch)
+	}
+	if err != nil || patch == nil {
+		middleware.AbortWithError(c, apierror.BadRequest("body must be a JSON object of settings"))
+		return
+	}
+
+	user, err := h.postgres.GetUserByID(c.Request.Context(), userID)
+	if err != nil {
+		middleware.AbortWithError(c, apierror.Internal("database error", err))
+		return
+	}
+	if user == nil {
+		middleware.AbortWithError(c, apierror.NotFound("user not found"))
+		return
+	}
+
+	settings, fields := mergeSettings(user.Settings, h.defaultSettings(user.Phone), patch)
+	adjusted, invalid := checkSettings(&settings, patch, h.cfg.HeartbeatIntervalMinSeconds, h.cfg.HeartbeatIntervalMaxSeconds)
+	if fields = append(fields, invalid...); len(fields) > 0 {
+		middleware.AbortWithError(c, apierror.Unprocessable(fields))
+		return
+	}
+
+	changes := settingsChanges(user.Settings, settings)
+	if _, ok := changes["share_audio"]; ok && settings.ShareAudio &&
+		abortConsentRequired(c, h.consents.Require(c.Request.Context(), userID, models.ConsentAudio)) {
+		return
+	}
+	// Coarse location mode stores locations precisely during an incident
+	if _, ok := changes["coarse_location"]; ok && settings.CoarseLocation &&
+		abortConsentRequired(c, h.consents.Require(c.Request.Context(), userID, models.ConsentPrecisionEscalation)) {
+		return
+	}
+	if len(changes) > 0 {
+		if err := h.postgres.UpdateUserSettings(c.Request.Context(), userID, settings); err != nil {
+			middleware.AbortWithError(c, apierror.Internal("failed to update settings", err))
+			return
+		}
+		recordAudit(c, h.audit, &models.AuditEvent{
+			Action:        services.AuditSettingsUpdate,
+			ObjectType:    "user",
+			ObjectID:      userID.String(),
+			SubjectUserID: &userID,
+			Metadata:      map[string]interface{}{"changes": changes},
+		})
+	}
+
+	// The token only answers while the setting is on, so it's issued after
+	// turning it on and cleared after turning it off
+	response := gin.H{
+		"status":   "success",
+		"settings": settings,
+	}
+	if len(adjusted) > 0 {
+		response["adjusted"] = adjusted
+	}
+	if _, ok := changes["public_status"]; ok {
+		if settings.PublicStatus {
+			token, err := h.publicStatus.Enable(c.Request.Context(), userID)
+			if err != nil {
+				middleware.AbortWithError(c, apierror.Internal("failed to issue public status token", err))
+				return
+			}
+			response["public_status_token"] = token
+		} else if err := h.publicStatus.Disable(c.Request.Context(), userID); err != nil {
+			middleware.AbortWithError(c, apierror.Internal("failed to revoke public status token", err))
+			return
+		}
+	}
+
+	for _, name := range intervalSettings {
+		if _, ok := changes[name]; ok {
+			h.evaluator.EvaluateAsync(userID)
+			break
+		}
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// defaultSettings are a new user's settings, and what a setting patched to
+// null goes back to
+func (h *UsersHandler) defaultSettings(phone string) models.UserSettings {
+	return models.UserSettings{
+		HeartbeatInterval:   h.cfg.HeartbeatIntervalSeconds,
+		SilentPromptTimeout: h.cfg.SilentPromptSeconds,
+		PanicGesture:        "power_button_3x",
+		Timezone:            country.Active().ForPhone(phone).Timezone,
+	}
+}
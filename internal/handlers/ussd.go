@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"log"
+	"net/http"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/apierror"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/config"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/database"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/middleware"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/services"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/utils"
+	"github.com/gin-gonic/gin"
+)
+
+type USSDHandler struct {
+	cfg      *config.Store
+	postgres *database.PostgresDB
+	menu     *services.USSDMenu
+}
+
+func NewUSSDHandler(cfg *config.Store, postgres *database.PostgresDB, redis *database.RedisDB, ussd *services.USSDService) *USSDHandler {
+	return &USSDHandler{
+		cfg:      cfg,
+		postgres: postgres,
+		menu:     services.NewUSSDMenu(redis, ussd),
+	}
+}
+
+// POST /v1/ussd/callback?token=...
+// The USSD gateway's session callback, form-encoded as Africa's Talking sends
+// it. The answer is plain text starting with CON (menu continues) or END.
+// Only registered phones get the menu.
+func (h *USSDHandler) HandleCallback(c *gin.Context) {
+	token := h.cfg.Current().USSDCallbackToken
+	if token == "" {
+		middleware.AbortWithError(c, apierror.NotFound("USSD is not enabled"))
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(c.Query("token")), []byte(token)) != 1 {
+		middleware.AbortWithError(c, apierror.Forbidden("invalid USSD callback token"))
+		return
+	}
+
+	call := services.USSDCall{
+		SessionID:   c.PostForm("sessionId"),
+		ServiceCode: c.PostForm("serviceCode"),
+		PhoneNumber: utils.NormalizePhone(c.PostForm("phoneNumber")),
+		NetworkCode: c.PostForm("networkCode"),
+		Text:        c.PostForm("text"),
+	}
+	if call.SessionID == "" || call.PhoneNumber == "" {
+		middleware.AbortWithError(c, apierror.BadRequest("sessionId and phoneNumber are required"))
+		return
+	}
+
+	user, err := h.postgres.GetUserByPhone(c.Request.Context(), call.PhoneNumber)
+	if err != nil {
+		log.Printf("ERROR: Failed to look up USSD caller %s: %v", call.PhoneNumber, err)
+		ussdReply(c, services.USSDReply{Text: "Sorry, something went wrong. Please try again.", End: true})
+		return
+	}
+	if user == nil {
+		log.Printf("WARN: USSD session from unregistered number %q ignored", call.PhoneNumber)
+		ussdReply(c, services.USSDReply{Text: "This number is not registered with SafeTrace.", End: true})
+		return
+	}
+
+	reply, err := h.menu.Handle(c.Request.Context(), user, call)
+	if err != nil {
+		log.Printf("ERROR: USSD session %s of user %s: %v", call.SessionID, user.ID, err)
+	}
+	ussdReply(c, reply)
+}
+
+func ussdReply(c *gin.Context, reply services.USSDReply) {
+	c.String(http.StatusOK, reply.String())
+}
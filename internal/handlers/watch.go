@@ -0,0 +1,187 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/apierror"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/config"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/database"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/middleware"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/services"
+)
+
+// watchHistoryLimit is how many past watches GET /watch returns
+const watchHistoryLimit = 20
+
+type WatchHandler struct {
+	cfg      *config.Store
+	postgres *database.PostgresDB
+	redis    *database.RedisDB
+	watch    *services.WatchService
+	audit    *services.AuditLogger
+}
+
+func NewWatchHandler(
+	cfg *config.Store,
+	postgres *database.PostgresDB,
+	redis *database.RedisDB,
+	watch *services.WatchService,
+	audit *services.AuditLogger,
+) *WatchHandler {
+	return &WatchHandler{
+		cfg:      cfg,
+		postgres: postgres,
+		redis:    redis,
+		watch:    watch,
+		audit:    audit,
+	}
+}
+
+type StartWatchRequest struct {
+	DurationMinutes int    `json:"duration_minutes" binding:"required,min=1"`
+	Note            string `json:"note" binding:"max=200"`
+}
+
+// POST /v1/user/:user_id/watch
+// The user asks to be watched more closely until the duration lapses or they
+// confirm they arrived. Starting again while watched extends the watch.
+// Refused while protection is paused.
+func (h *WatchHandler) Start(c *gin.Context) {
+	userID, ok := requireSelf(c, "only the user can start or complete their watch")
+	if !ok {
+		return
+	}
+
+	var req StartWatchRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		middleware.AbortWithError(c, apierror.Validation(err))
+		return
+	}
+	maxMinutes := h.cfg.Current().WatchMaxMinutes
+	if req.DurationMinutes > maxMinutes {
+		middleware.AbortWithError(c, apierror.Invalid("duration_minutes", fmt.Sprintf("must be at most %d", maxMinutes)))
+		return
+	}
+
+	watch, extended, err := h.watch.Begin(c.Request.Context(), userID, time.Duration(req.DurationMinutes)*time.Minute, req.Note)
+	if errors.Is(err, services.ErrProtectionPaused) {
+		middleware.AbortWithError(c, apierror.Conflict("resume protection before starting a watch"))
+		return
+	}
+	if err != nil {
+		middleware.AbortWithError(c, apierror.Internal("failed to start watch", err))
+		return
+	}
+
+	recordAudit(c, h.audit, &models.AuditEvent{
+		Action:        services.AuditWatchStart,
+		ObjectType:    "watch_session",
+		ObjectID:      watch.ID.String(),
+		SubjectUserID: &userID,
+		Metadata: map[string]interface{}{
+			"ends_at":  watch.EndsAt,
+			"extended": extended,
+		},
+	})
+
+	response := gin.H{
+		"status":   "watching",
+		"watch":    watch,
+		"extended": extended,
+	}
+	h.addClientHints(c, userID, response)
+	c.JSON(http.StatusOK, response)
+}
+
+// addClientHints tells the client how to behave during the watch: the
+// heartbeat interval now advised, and a silent-ping timeout half the user's own
+func (h *WatchHandler) addClientHints(c *gin.Context, userID uuid.UUID, response gin.H) {
+	if state, err := h.redis.GetUserState(c.Request.Context(), userID); err == nil && state != nil && state.NextIntervalSeconds > 0 {
+		response["next_interval_seconds"] = state.NextIntervalSeconds
+	}
+	user, err := h.postgres.GetUserByID(c.Request.Context(), userID)
+	if err != nil || user == nil || user.Settings.SilentPromptTimeout <= 0 {
+		return
+	}
+	timeout := user.Settings.SilentPromptTimeout / 2
+	if timeout < 1 {
+		timeout = 1
+	}
+	response["silent_prompt_timeout"] = timeout
+}
+
+// POST /v1/user/:user_id/watch/complete
+// "I arrived": ends the watch without an alert
+func (h *WatchHandler) Complete(c *gin.Context) {
+	userID, ok := requireSelf(c, "only the user can start or complete their watch")
+	if !ok {
+		return
+	}
+
+	watch, err := h.watch.Complete(c.Request.Context(), userID)
+	if err != nil {
+		middleware.AbortWithError(c, apierror.Internal("failed to complete watch", err))
+		return
+	}
+	if watch == nil {
+		middleware.AbortWithError(c, apierror.Conflict("no watch is active"))
+		return
+	}
+
+	recordAudit(c, h.audit, &models.AuditEvent{
+		Action:        services.AuditWatchComplete,
+		ObjectType:    "watch_session",
+		ObjectID:      watch.ID.String(),
+		SubjectUserID: &userID,
+		Metadata:      map[string]interface{}{"ended_by": models.WatchEndedByCompleted},
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": "completed",
+		"watch":  watch,
+	})
+}
+
+// GET /v1/user/:user_id/watch
+// The active watch, if any, and recent watches. Readable by the user, their
+// trusted contacts and guardians.
+func (h *WatchHandler) GetWatch(c *gin.Context) {
+	user, ok := loadAuthorizedUser(c, h.postgres)
+	if !ok {
+		return
+	}
+
+	active, err := h.watch.Active(c.Request.Context(), user.ID)
+	if err != nil {
+		middleware.AbortWithError(c, apierror.Internal("failed to get watch", err))
+		return
+	}
+	history, err := h.postgres.GetWatchSessions(c.Request.Context(), user.ID, watchHistoryLimit)
+	if err != nil {
+		middleware.AbortWithError(c, apierror.Internal("failed to get watches", err))
+		return
+	}
+	if history == nil {
+		history = []models.WatchSession{}
+	}
+
+	recordAudit(c, h.audit, &models.AuditEvent{
+		Action:        services.AuditWatchView,
+		ObjectType:    "user",
+		ObjectID:      user.ID.String(),
+		SubjectUserID: &user.ID,
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"user_id":  user.ID,
+		"watching": active != nil,
+		"watch":    active,
+		"history":  history,
+	})
+}
@@ -0,0 +1,198 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/apierror"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/database"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/middleware"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/params"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/services"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/utils"
+)
+
+// welfareHistoryLimit is how many past checks GET /welfare-checks returns
+const welfareHistoryLimit = 50
+
+type WelfareHandler struct {
+	postgres *database.PostgresDB
+	welfare  *services.WelfareCheckService
+	audit    *services.AuditLogger
+}
+
+func NewWelfareHandler(postgres *database.PostgresDB, welfare *services.WelfareCheckService, audit *services.AuditLogger) *WelfareHandler {
+	return &WelfareHandler{
+		postgres: postgres,
+		welfare:  welfare,
+		audit:    audit,
+	}
+}
+
+// POST /v1/user/:user_id/welfare-check
+// A confirmed trusted contact (contact token with the welfare_check scope) or
+// a guardian with an active link asks SafeTrace to check on the user. The
+// user is told who asked and has WELFARE_CHECK_TIMEOUT_MINUTES to confirm
+// they are okay. Refused requests are audited too.
+func (h *WelfareHandler) RequestCheck(c *gin.Context) {
+	userID := params.UserID(c)
+	user, err := h.postgres.GetUserByID(c.Request.Context(), userID)
+	if err != nil {
+		middleware.AbortWithError(c, apierror.Internal("database error", err))
+		return
+	}
+	if user == nil {
+		middleware.AbortWithError(c, apierror.NotFound("user not found"))
+		return
+	}
+
+	requester, ok := h.requester(c, user)
+	if !ok {
+		middleware.AbortWithError(c, apierror.Forbidden("only a trusted contact or guardian can request a welfare check"))
+		return
+	}
+
+	check, err := h.welfare.Request(c.Request.Context(), user, requester)
+	refused := ""
+	switch {
+	case errors.Is(err, services.ErrWelfareCheckPending):
+		refused = "pending"
+	case errors.Is(err, services.ErrWelfareCheckLimit):
+		refused = "daily_limit"
+	case err != nil:
+		middleware.AbortWithError(c, apierror.Internal("failed to request welfare check", err))
+		return
+	}
+
+	event := &models.AuditEvent{
+		Action:        services.AuditWelfareRequest,
+		ObjectType:    "welfare_check",
+		SubjectUserID: &user.ID,
+		Metadata: map[string]interface{}{
+			"requester_type": requester.Type,
+			"requester_id":   requester.ID,
+		},
+	}
+	if check != nil {
+		event.ObjectID = check.ID.String()
+	}
+	if refused != "" {
+		event.Metadata["refused"] = refused
+	}
+	recordAudit(c, h.audit, event)
+
+	switch refused {
+	case "pending":
+		middleware.AbortWithError(c, apierror.Conflict("a welfare check on this user is already waiting for an answer"))
+		return
+	case "daily_limit":
+		middleware.AbortWithError(c, apierror.TooManyRequests("daily welfare check limit reached for this user"))
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"status":        "requested",
+		"welfare_check": check,
+	})
+}
+
+// requester identifies the caller as one of the user's trusted contacts or
+// guardians. The user themselves and admins can't request a check.
+func (h *WelfareHandler) requester(c *gin.Context, user *models.User) (services.WelfareRequester, bool) {
+	claims := middleware.Principal(c)
+	if claims == nil {
+		return services.WelfareRequester{}, false
+	}
+
+	if claims.Role == utils.RoleContact && claims.Subject == user.ID.String() {
+		for _, contact := range user.TrustedContacts {
+			if contact.Phone != claims.Phone || (claims.ContactID != "" && contact.ID != claims.ContactID) {
+				continue
+			}
+			return services.WelfareRequester{
+				Type:  models.WelfareRequesterContact,
+				ID:    contact.ID,
+				Name:  contact.Name,
+				Phone: contact.Phone,
+			}, true
+		}
+		return services.WelfareRequester{}, false
+	}
+
+	link := middleware.GuardianLink(c, user.ID)
+	if claims.Role != utils.RoleUser || link == nil {
+		return services.WelfareRequester{}, false
+	}
+	guardian, err := h.postgres.GetUserByID(c.Request.Context(), link.GuardianUserID)
+	if err != nil || guardian == nil {
+		return services.WelfareRequester{}, false
+	}
+	return services.WelfareRequester{
+		Type:  models.WelfareRequesterGuardian,
+		ID:    guardian.ID.String(),
+		Name:  guardian.Name,
+		Phone: guardian.Phone,
+	}, true
+}
+
+// POST /v1/user/:user_id/welfare-check/confirm
+// "I'm okay": closes the pending check and tells the requester
+func (h *WelfareHandler) ConfirmCheck(c *gin.Context) {
+	userID, ok := requireSelf(c, "only the user can confirm they are okay")
+	if !ok {
+		return
+	}
+
+	check, err := h.welfare.Confirm(c.Request.Context(), userID)
+	if err != nil {
+		middleware.AbortWithError(c, apierror.Internal("failed to confirm welfare check", err))
+		return
+	}
+	if check == nil {
+		middleware.AbortWithError(c, apierror.Conflict("no welfare check is pending"))
+		return
+	}
+
+	recordAudit(c, h.audit, &models.AuditEvent{
+		Action:        services.AuditWelfareConfirm,
+		ObjectType:    "welfare_check",
+		ObjectID:      check.ID.String(),
+		SubjectUserID: &userID,
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":        "confirmed",
+		"welfare_check": check,
+	})
+}
+
+// GET /v1/user/:user_id/welfare-checks
+// Every recent check on the user, including who asked; only the user can see it
+func (h *WelfareHandler) ListChecks(c *gin.Context) {
+	userID, ok := requireSelf(c, "only the user can list welfare checks on them")
+	if !ok {
+		return
+	}
+
+	checks, err := h.welfare.History(c.Request.Context(), userID, welfareHistoryLimit)
+	if err != nil {
+		middleware.AbortWithError(c, apierror.Internal("failed to get welfare checks", err))
+		return
+	}
+	if checks == nil {
+		checks = []models.WelfareCheck{}
+	}
+
+	var pending *models.WelfareCheck
+	if len(checks) > 0 && checks[0].Status == models.WelfareCheckPending {
+		pending = &checks[0]
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"user_id":        userID,
+		"pending":        pending,
+		"welfare_checks": checks,
+	})
+}
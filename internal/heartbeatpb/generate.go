@@ -0,0 +1,6 @@
+// Package heartbeatpb is the protobuf wire format of HTTP heartbeats,
+// generated from heartbeat.proto. The generated code is checked in so builds
+// don't need protoc.
+package heartbeatpb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative heartbeat.proto
@@ -0,0 +1,388 @@
+// Package keys builds every Redis key the service uses. Keys live under
+// "<namespace>:v<version>:", so deployments sharing one Redis (a staging and
+// a production instance, say) never see each other's state, and a change to
+// a key's layout can move to a new version instead of misreading old entries.
+package keys
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Version is the schema version in every key. Bump it when a key's layout or
+// value encoding changes, and rewrite or expire the old keys on deploy.
+const Version = 1
+
+// legacyRoots are the first segments of keys written before keys had a
+// namespace, so the migration can tell them from another namespace's keys
+var legacyRoots = map[string]bool{
+	"activity": true, "alert": true, "contact": true, "devices": true, "eval": true,
+	"heartbeat": true, "heatmap": true, "link": true, "monitor": true, "outbound": true,
+	"panic": true, "place": true, "ratelimit": true, "sig": true, "sms": true,
+	"token": true, "user": true, "ussd": true, "w3w": true, "welfare": true,
+}
+
+var namespacePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// ValidateNamespace checks a namespace can be told apart from the rest of a key
+// and from unprefixed legacy keys
+func ValidateNamespace(namespace string) error {
+	if !namespacePattern.MatchString(namespace) {
+		return fmt.Errorf("must be lowercase letters, digits, - and _")
+	}
+	if legacyRoots[namespace] {
+		return fmt.Errorf("%q is reserved", namespace)
+	}
+	return nil
+}
+
+// Registry builds keys for one namespace
+type Registry struct {
+	namespace string
+	prefix    string
+}
+
+func New(namespace string) Registry {
+	return Registry{
+		namespace: namespace,
+		prefix:    fmt.Sprintf("%s:v%d:", namespace, Version),
+	}
+}
+
+func (k Registry) Namespace() string {
+	return k.namespace
+}
+
+// Prefix is what every key of this registry starts with
+func (k Registry) Prefix() string {
+	return k.prefix
+}
+
+func (k Registry) key(format string, args ...interface{}) string {
+	return k.prefix + fmt.Sprintf(format, args...)
+}
+
+// Parse splits a key built by any registry into its namespace and version;
+// ok is false for keys without one, such as legacy keys
+func Parse(key string) (namespace string, version int, ok bool) {
+	parts := strings.SplitN(key, ":", 3)
+	if len(parts) < 3 || !strings.HasPrefix(parts[1], "v") {
+		return "", 0, false
+	}
+	version, err := strconv.Atoi(parts[1][1:])
+	if err != nil || version <= 0 {
+		return "", 0, false
+	}
+	return parts[0], version, true
+}
+
+// IsLegacy reports whether key was written before keys had a namespace
+func IsLegacy(key string) bool {
+	if _, _, ok := Parse(key); ok {
+		return false
+	}
+	root, _, _ := strings.Cut(key, ":")
+	return legacyRoots[root]
+}
+
+// Current maps a legacy key, or one of this namespace's older versions, to
+// the key it is now. Layouts haven't changed since keys were introduced, so
+// the rest of the key carries over as is. ok is false for current keys and
+// for keys of other namespaces.
+func (k Registry) Current(key string) (string, bool) {
+	if IsLegacy(key) {
+		return k.prefix + key, true
+	}
+	namespace, version, ok := Parse(key)
+	if !ok || namespace != k.namespace || version >= Version {
+		return "", false
+	}
+	rest := strings.SplitN(key, ":", 3)[2]
+	return k.prefix + rest, true
+}
+
+// User state and caches
+
+func (k Registry) UserState(userID uuid.UUID) string {
+	return k.key("user:state:%s", userID)
+}
+
+func (k Registry) UserCache(userID uuid.UUID) string {
+	return k.key("user:cache:%s", userID)
+}
+
+// RateLimit is per user and device; heartbeats without a device share the user's limit
+func (k Registry) RateLimit(userID uuid.UUID, deviceID string) string {
+	if deviceID == "" {
+		return k.key("ratelimit:%s", userID)
+	}
+	return k.key("ratelimit:%s:%s", userID, deviceID)
+}
+
+func (k Registry) AlertSent(userID uuid.UUID) string {
+	return k.key("alert:sent:%s", userID)
+}
+
+func (k Registry) Heatmap(scope string, precision, minUsers int, from, to time.Time) string {
+	return k.key("heatmap:%s:%d:%d:%d:%d", scope, precision, minUsers, from.Unix(), to.Unix())
+}
+
+func (k Registry) What3Words(lat, lng float64) string {
+	return k.key("w3w:%.5f,%.5f", lat, lng)
+}
+
+func (k Registry) PlaceName(lat, lng float64) string {
+	return k.key("place:%.3f,%.3f", lat, lng)
+}
+
+// AdminArea caches the state or region a ~100m cell lies in
+func (k Registry) AdminArea(lat, lng float64) string {
+	return k.key("admin_area:%.3f,%.3f", lat, lng)
+}
+
+// Messaging
+
+func (k Registry) SMSDelivery(provider, messageID string) string {
+	return k.key("sms:delivery:%s:%s", provider, messageID)
+}
+
+// SMSRetry marks a message whose failed delivery has been retried
+func (k Registry) SMSRetry(provider, messageID string) string {
+	return k.key("sms:retry:%s:%s", provider, messageID)
+}
+
+// OutboundSMS is the day's SMS per user, for every user or one organization
+func (k Registry) OutboundSMS(day string, orgID *uuid.UUID) string {
+	if orgID == nil {
+		return k.key("sms:outbound:%s", day)
+	}
+	return k.key("sms:outbound:%s:org:%s", day, orgID)
+}
+
+// OutboundSMSTotal is the running total of OutboundSMS
+func (k Registry) OutboundSMSTotal(day string, orgID *uuid.UUID) string {
+	return k.OutboundSMS(day, orgID) + ":total"
+}
+
+func (k Registry) OutboundLimit() string {
+	return k.key("outbound:limit")
+}
+
+// OutboundBucket is the token bucket behind the per-minute cap
+func (k Registry) OutboundBucket() string {
+	return k.key("outbound:bucket")
+}
+
+func (k Registry) OutboundMinute(at time.Time) string {
+	return k.key("outbound:minute:%d", at.Unix()/60)
+}
+
+func (k Registry) OutboundDay(day string) string {
+	return k.key("outbound:day:%s", day)
+}
+
+// OutboundDayTypes counts the day's messages by outcome and type
+func (k Registry) OutboundDayTypes(day string) string {
+	return k.OutboundDay(day) + ":types"
+}
+
+func (k Registry) ContactDaily(phone, day string) string {
+	return k.key("contact:daily:%s:%s", phone, day)
+}
+
+// Contact pacing, per normalized phone number across every user it protects
+
+func (k Registry) ContactPace(phone string) string {
+	return k.key("contact:pace:%s", phone)
+}
+
+func (k Registry) ContactDigest(phone string) string {
+	return k.key("contact:digest:%s", phone)
+}
+
+func (k Registry) ContactDigestDue() string {
+	return k.key("contact:digest:due")
+}
+
+func (k Registry) WelfareDaily(userID uuid.UUID, requesterID, day string) string {
+	return k.key("welfare:daily:%s:%s:%s", userID, requesterID, day)
+}
+
+// Access and security
+
+func (k Registry) PanicPINAttempts(userID uuid.UUID) string {
+	return k.key("panic:pin:attempts:%s", userID)
+}
+
+func (k Registry) AccountLink(guardianID, wardID uuid.UUID) string {
+	return k.key("link:auth:%s:%s", guardianID, wardID)
+}
+
+func (k Registry) ContactInvite(code string) string {
+	return k.key("contact:invite:%s", code)
+}
+
+func (k Registry) RevokedToken(tokenID string) string {
+	return k.key("token:revoked:%s", tokenID)
+}
+
+func (k Registry) ContactTokens(userID uuid.UUID, contactID string) string {
+	return k.key("contact:tokens:%s:%s", userID, contactID)
+}
+
+// SignatureFailures counts failures per kind ("user" or "ip") and id
+func (k Registry) SignatureFailures(kind, id string) string {
+	return k.key("sig:failures:%s:%s", kind, id)
+}
+
+func (k Registry) SignatureLockout(userID uuid.UUID) string {
+	return k.key("sig:lockout:%s", userID)
+}
+
+func (k Registry) ResponderRequests(keyID uuid.UUID) string {
+	return k.key("responder:requests:%s", keyID)
+}
+
+func (k Registry) PublicStatus(token string) string {
+	return k.key("public_status:%s", token)
+}
+
+func (k Registry) HeartbeatNonce(userID uuid.UUID, nonce string) string {
+	return k.key("sig:nonce:%s:%s", userID, nonce)
+}
+
+// UnverifiedReports counts unverified heartbeats per kind ("phone" or "ip")
+// and id
+func (k Registry) UnverifiedReports(kind, id string) string {
+	return k.key("unverified:count:%s:%s", kind, id)
+}
+
+// ReportChallenge holds the phone number a proof-of-work challenge was
+// issued for
+func (k Registry) ReportChallenge(challenge string) string {
+	return k.key("unverified:challenge:%s", challenge)
+}
+
+// Evaluation
+
+func (k Registry) EvaluationLock(userID uuid.UUID) string {
+	return k.key("eval:lock:%s", userID)
+}
+
+// WarmupSentinel is set once Redis holds the state rebuilt from Postgres;
+// it going missing means Redis lost its data
+func (k Registry) WarmupSentinel() string {
+	return k.key("eval:warmup:sentinel")
+}
+
+func (k Registry) WarmupLock() string {
+	return k.key("eval:warmup:lock")
+}
+
+func (k Registry) LatestHeartbeat(userID uuid.UUID) string {
+	return k.key("heartbeat:latest:%s", userID)
+}
+
+func (k Registry) HeartbeatFingerprint(userID uuid.UUID, fingerprint string) string {
+	return k.key("heartbeat:fp:%s:%s", userID, fingerprint)
+}
+
+func (k Registry) HeartbeatStreams(userID uuid.UUID) string {
+	return k.key("heartbeat:streams:%s", userID)
+}
+
+// Coarse location mode: the precise fix of the user's newest coarse
+// heartbeat, and whether their heartbeats are kept precise for an incident
+
+func (k Registry) PreciseLocation(userID uuid.UUID) string {
+	return k.key("location:precise:%s", userID)
+}
+
+func (k Registry) PrecisionEscalated(userID uuid.UUID) string {
+	return k.key("location:escalated:%s", userID)
+}
+
+// Diagnostics: the user's latest heartbeat attempts and evaluations, newest first
+
+func (k Registry) DiagnosticAttempts(userID uuid.UUID) string {
+	return k.key("diag:attempts:%s", userID)
+}
+
+func (k Registry) DiagnosticEvaluations(userID uuid.UUID) string {
+	return k.key("diag:evals:%s", userID)
+}
+
+func (k Registry) Devices(userID uuid.UUID) string {
+	return k.key("devices:%s", userID)
+}
+
+func (k Registry) DevicesSeen(userID uuid.UUID) string {
+	return k.key("devices:seen:%s", userID)
+}
+
+func (k Registry) Activity(userID uuid.UUID) string {
+	return k.key("activity:%s", userID)
+}
+
+func (k Registry) LocationNudge(userID uuid.UUID) string {
+	return k.key("activity:nudge:%s", userID)
+}
+
+func (k Registry) MonitorDue() string {
+	return k.key("monitor:due")
+}
+
+// Check-in prompts
+
+func (k Registry) PromptSession(userID uuid.UUID) string {
+	return k.key("prompt:session:%s", userID)
+}
+
+func (k Registry) PromptDue() string {
+	return k.key("prompt:due")
+}
+
+// Alert auto-resolution
+
+func (k Registry) AutoResolveStreak(alertID uuid.UUID) string {
+	return k.key("autoresolve:streak:%s", alertID)
+}
+
+func (k Registry) AutoResolvePrompt(alertID uuid.UUID) string {
+	return k.key("autoresolve:prompt:%s", alertID)
+}
+
+// Outage detection, per geohash cell and network ("<mcc>-<mnc>")
+
+func (k Registry) OutageUsers(geohash, network string) string {
+	return k.key("outage:users:%s:%s", geohash, network)
+}
+
+func (k Registry) OutageZone(geohash, network string) string {
+	return k.key("outage:zone:%s:%s", geohash, network)
+}
+
+func (k Registry) OutageZones() string {
+	return k.key("outage:zones")
+}
+
+// USSD
+
+func (k Registry) USSDSession(sessionID string) string {
+	return k.key("ussd:session:%s", sessionID)
+}
+
+// Scheduled jobs
+
+func (k Registry) JobLock(name string) string {
+	return k.key("jobs:lock:%s", name)
+}
+
+func (k Registry) JobRunClaim(name string) string {
+	return k.key("jobs:claim:%s", name)
+}
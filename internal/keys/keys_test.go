@@ -0,0 +1,137 @@
+package keys
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestValidateNamespace(t *testing.T) {
+	tests := []struct {
+		namespace string
+		valid     bool
+	}{
+		{"safetrace", true},
+		{"staging-2", true},
+		{"prod_ng", true},
+		{"", false},
+		{"Prod", false},
+		{"prod:ng", false},
+		{"-prod", false},
+		{"user", false}, // a legacy root
+		{"sms", false},
+	}
+	for _, tt := range tests {
+		if err := ValidateNamespace(tt.namespace); (err == nil) != tt.valid {
+			t.Errorf("ValidateNamespace(%q) = %v, want valid %v", tt.namespace, err, tt.valid)
+		}
+	}
+}
+
+// registryKeys calls every key builder of k with zero arguments, by name
+func registryKeys(k Registry) map[string]string {
+	built := make(map[string]string)
+	v := reflect.ValueOf(k)
+	for i := 0; i < v.NumMethod(); i++ {
+		method := v.Type().Method(i)
+		fn := v.Method(i)
+		if method.Name == "Namespace" || method.Name == "Prefix" {
+			continue
+		}
+		if fn.Type().NumOut() != 1 || fn.Type().Out(0).Kind() != reflect.String {
+			continue
+		}
+		args := make([]reflect.Value, fn.Type().NumIn())
+		for j := range args {
+			args[j] = reflect.Zero(fn.Type().In(j))
+		}
+		built[method.Name] = fn.Call(args)[0].String()
+	}
+	return built
+}
+
+// Every key lives under its registry's namespace and version, so
+// deployments sharing a Redis never read each other's
+func TestNamespaceIsolation(t *testing.T) {
+	prod, staging := New("prod"), New("staging")
+	prodKeys, stagingKeys := registryKeys(prod), registryKeys(staging)
+	if len(prodKeys) < 50 {
+		t.Fatalf("only %d key builders found", len(prodKeys))
+	}
+
+	for name, key := range prodKeys {
+		if !strings.HasPrefix(key, "prod:v1:") {
+			t.Errorf("%s = %q, want it under prod:v1:", name, key)
+		}
+		namespace, version, ok := Parse(key)
+		if !ok || namespace != "prod" || version != Version {
+			t.Errorf("Parse(%s) = %q, %d, %v", name, namespace, version, ok)
+		}
+		if key == stagingKeys[name] {
+			t.Errorf("%s is %q in both namespaces", name, key)
+		}
+		if _, ok := staging.Current(key); ok {
+			t.Errorf("staging takes over prod's %s", name)
+		}
+		if IsLegacy(key) {
+			t.Errorf("%s = %q is taken for a legacy key", name, key)
+		}
+	}
+}
+
+// No two builders share a key
+func TestRegistryKeysDistinct(t *testing.T) {
+	seen := make(map[string]string)
+	for name, key := range registryKeys(New("prod")) {
+		if other, ok := seen[key]; ok {
+			t.Errorf("%s and %s both build %q", name, other, key)
+		}
+		seen[key] = name
+	}
+}
+
+func TestRegistryLayout(t *testing.T) {
+	k := New("prod")
+	userID := uuid.MustParse("0b5c5a0f-3d81-4824-82e1-3803d1056b95")
+	tests := []struct {
+		got, want string
+	}{
+		{k.UserState(userID), "prod:v1:user:state:0b5c5a0f-3d81-4824-82e1-3803d1056b95"},
+		{k.RateLimit(userID, ""), "prod:v1:ratelimit:0b5c5a0f-3d81-4824-82e1-3803d1056b95"},
+		{k.RateLimit(userID, "pixel-7"), "prod:v1:ratelimit:0b5c5a0f-3d81-4824-82e1-3803d1056b95:pixel-7"},
+		{k.SMSDelivery("termii", "301"), "prod:v1:sms:delivery:termii:301"},
+		{k.OutboundDayTypes("2026-03-09"), "prod:v1:outbound:day:2026-03-09:types"},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("key = %q, want %q", tt.got, tt.want)
+		}
+	}
+	if k.Namespace() != "prod" || k.Prefix() != "prod:v1:" {
+		t.Errorf("Namespace(), Prefix() = %q, %q", k.Namespace(), k.Prefix())
+	}
+}
+
+func TestCurrent(t *testing.T) {
+	k := New("prod")
+	tests := []struct {
+		key    string
+		want   string
+		moving bool
+	}{
+		{"user:state:abc", "prod:v1:user:state:abc", true}, // legacy
+		{"prod:v0:user:state:abc", "", false},              // not a version
+		{"prod:v1:user:state:abc", "", false},              // current
+		{"staging:v1:user:state:abc", "", false},           // another namespace
+		{"unknown:state:abc", "", false},                   // neither legacy nor namespaced
+		{"heartbeat:nonce:abc:1", "prod:v1:heartbeat:nonce:abc:1", true},
+	}
+	for _, tt := range tests {
+		got, ok := k.Current(tt.key)
+		if got != tt.want || ok != tt.moving {
+			t.Errorf("Current(%q) = %q, %v, want %q, %v", tt.key, got, ok, tt.want, tt.moving)
+		}
+	}
+}
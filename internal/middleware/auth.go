@@ -0,0 +1,82 @@
+package middleware
+
+import (
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/apierror"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/utils"
+)
+
+const principalKey = "auth.principal"
+
+// RequireAuth rejects requests without a valid bearer token. Contact tokens
+// are only accepted on routes that mount ContactScope first.
+func RequireAuth(secret string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims, err := parseBearer(c, secret)
+		if err != nil {
+			AbortWithError(c, apierror.Unauthorized("missing or invalid access token"))
+			return
+		}
+		if claims.Role == utils.RoleContact && !contactAllowed(c) {
+			AbortWithError(c, apierror.Forbidden("contact tokens are not accepted here"))
+			return
+		}
+		c.Set(principalKey, claims)
+		c.Next()
+	}
+}
+
+// OptionalAuth attaches the principal when a valid token is present but never
+// rejects. Contact tokens are ignored unless ContactScope accepted them.
+func OptionalAuth(secret string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if claims, err := parseBearer(c, secret); err == nil {
+			if claims.Role != utils.RoleContact || contactAllowed(c) {
+				c.Set(principalKey, claims)
+			}
+		}
+		c.Next()
+	}
+}
+
+// Principal returns the authenticated caller, or nil
+func Principal(c *gin.Context) *utils.TokenClaims {
+	if v, ok := c.Get(principalKey); ok {
+		if claims, ok := v.(*utils.TokenClaims); ok {
+			return claims
+		}
+	}
+	return nil
+}
+
+func parseBearer(c *gin.Context, secret string) (*utils.TokenClaims, error) {
+	header := c.GetHeader("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return nil, utils.ErrInvalidToken
+	}
+	return utils.ParseToken(strings.TrimPrefix(header, "Bearer "), secret)
+}
+
+// RequireRole rejects authenticated callers without one of the given roles.
+// Must run after RequireAuth.
+func RequireRole(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims := Principal(c)
+		if claims == nil || !hasRole(claims, roles) {
+			AbortWithError(c, apierror.Forbidden("insufficient role"))
+			return
+		}
+		c.Next()
+	}
+}
+
+func hasRole(claims *utils.TokenClaims, roles []string) bool {
+	for _, role := range roles {
+		if claims.Role == role {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,77 @@
+package middleware
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/apierror"
+)
+
+// BodyLimit caps request bodies at limit bytes and inflates gzip bodies
+// (Content-Encoding: gzip), so clients on slow links can compress uploads.
+// The limit applies both before and after decompression: a few compressed
+// kilobytes can't expand into gigabytes. Bodies over it are refused with
+// 413, other content encodings with 415.
+func BodyLimit(limit int64) gin.HandlerFunc {
+	tooLarge := fmt.Sprintf("request body exceeds %d bytes", limit)
+	return func(c *gin.Context) {
+		encoding := strings.ToLower(strings.TrimSpace(c.GetHeader("Content-Encoding")))
+		switch encoding {
+		case "", "identity":
+			if c.Request.ContentLength > limit {
+				AbortWithError(c, apierror.PayloadTooLarge(tooLarge))
+				return
+			}
+			c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, limit)
+			c.Next()
+			return
+		case "gzip", "x-gzip":
+		default:
+			AbortWithError(c, apierror.UnsupportedMediaType("content encoding must be gzip or identity"))
+			return
+		}
+
+		body, err := inflate(http.MaxBytesReader(c.Writer, c.Request.Body, limit), limit)
+		var maxErr *http.MaxBytesError
+		switch {
+		case errors.As(err, &maxErr) || errors.Is(err, errInflatedTooLarge):
+			AbortWithError(c, apierror.PayloadTooLarge(tooLarge))
+			return
+		case err != nil:
+			AbortWithError(c, apierror.BadRequest("request body is not valid gzip").WithCause(err))
+			return
+		}
+
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+		c.Request.ContentLength = int64(len(body))
+		c.Request.Header.Del("Content-Encoding")
+		c.Request.Header.Set("Content-Length", fmt.Sprint(len(body)))
+		c.Next()
+	}
+}
+
+var errInflatedTooLarge = errors.New("inflated body exceeds the limit")
+
+// inflate decompresses a gzip stream, reading at most limit bytes of output
+func inflate(compressed io.Reader, limit int64) ([]byte, error) {
+	zr, err := gzip.NewReader(compressed)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	body, err := io.ReadAll(io.LimitReader(zr, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(body)) > limit {
+		return nil, errInflatedTooLarge
+	}
+	return body, nil
+}
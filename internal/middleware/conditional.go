@@ -0,0 +1,69 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/apierror"
+)
+
+// VersionFunc returns the version of what a read route would respond with,
+// which must change whenever the response does, and how long a client may
+// reuse a response before revalidating. An empty version leaves the request
+// to the handler without caching headers, e.g. when there is nothing yet.
+type VersionFunc func(c *gin.Context) (version string, maxAge time.Duration, err error)
+
+// Conditional adds a weak ETag from version, answers a matching If-None-Match
+// with 304 Not Modified and a HEAD request with the headers alone, both
+// without running the handler. Responses are private: they differ by caller.
+// Mount it after the route's own authorization middleware; it answers before
+// the handler does.
+func Conditional(version VersionFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		v, maxAge, err := version(c)
+		if err != nil {
+			AbortWithError(c, apierror.Internal("failed to check resource version", err))
+			return
+		}
+		if v == "" {
+			c.Next()
+			return
+		}
+
+		etag := `W/"` + v + `"`
+		c.Header("ETag", etag)
+		c.Header("Cache-Control", "private, max-age="+strconv.Itoa(int(maxAge/time.Second))+", must-revalidate")
+		c.Header("Vary", "Authorization")
+
+		if etagMatches(c.GetHeader("If-None-Match"), etag) {
+			c.AbortWithStatus(http.StatusNotModified)
+			return
+		}
+		if c.Request.Method == http.MethodHead {
+			c.AbortWithStatus(http.StatusOK)
+			return
+		}
+		c.Next()
+	}
+}
+
+// etagMatches applies the weak comparison of If-None-Match (RFC 9110 13.1.2)
+func etagMatches(header, etag string) bool {
+	if header == "" {
+		return false
+	}
+	if strings.TrimSpace(header) == "*" {
+		return true
+	}
+	want := strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(header, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(candidate), "W/") == want {
+			return true
+		}
+	}
+	return false
+}
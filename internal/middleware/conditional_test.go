@@ -0,0 +1,156 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// versionedResource is a read route whose version the test moves, counting
+// how often the handler itself ran
+type versionedResource struct {
+	version string
+	maxAge  time.Duration
+	err     error
+	served  int
+}
+
+func (v *versionedResource) router() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(RequestID(), ErrorHandler())
+	version := func(c *gin.Context) (string, time.Duration, error) { return v.version, v.maxAge, v.err }
+	handler := func(c *gin.Context) {
+		v.served++
+		c.JSON(http.StatusOK, gin.H{"version": v.version})
+	}
+	r.GET("/status", Conditional(version), handler)
+	r.HEAD("/status", Conditional(version), handler)
+	return r
+}
+
+func (v *versionedResource) request(t *testing.T, method, ifNoneMatch string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, "/status", nil)
+	if ifNoneMatch != "" {
+		req.Header.Set("If-None-Match", ifNoneMatch)
+	}
+	w := httptest.NewRecorder()
+	v.router().ServeHTTP(w, req)
+	return w
+}
+
+func TestConditionalHeaders(t *testing.T) {
+	resource := &versionedResource{version: "abc-active", maxAge: 300 * time.Second}
+	w := resource.request(t, http.MethodGet, "")
+	if w.Code != http.StatusOK || resource.served != 1 {
+		t.Fatalf("GET = %d, served %d times; want 200 served once", w.Code, resource.served)
+	}
+	want := map[string]string{
+		"ETag":          `W/"abc-active"`,
+		"Cache-Control": "private, max-age=300, must-revalidate",
+		"Vary":          "Authorization",
+	}
+	for header, value := range want {
+		if got := w.Header().Get(header); got != value {
+			t.Errorf("%s = %q, want %q", header, got, value)
+		}
+	}
+
+	// A route that must always revalidate
+	resource.maxAge = 0
+	w = resource.request(t, http.MethodGet, "")
+	if got := w.Header().Get("Cache-Control"); got != "private, max-age=0, must-revalidate" {
+		t.Errorf("Cache-Control without a max age = %q", got)
+	}
+}
+
+// A client holding the current version gets 304 with no body and without
+// the handler running; once the version moves, the same ETag gets the new
+// response straight away
+func TestConditionalNotModified(t *testing.T) {
+	resource := &versionedResource{version: "v1", maxAge: time.Minute}
+	etag := resource.request(t, http.MethodGet, "").Header().Get("ETag")
+
+	w := resource.request(t, http.MethodGet, etag)
+	if w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+		t.Errorf("GET with the current ETag = %d with %d bytes, want 304 with none", w.Code, w.Body.Len())
+	}
+	if w.Header().Get("ETag") != etag {
+		t.Errorf("304 ETag = %q, want %q", w.Header().Get("ETag"), etag)
+	}
+	if resource.served != 1 {
+		t.Errorf("handler ran %d times, want once", resource.served)
+	}
+
+	resource.version = "v2"
+	w = resource.request(t, http.MethodGet, etag)
+	if w.Code != http.StatusOK || resource.served != 2 {
+		t.Errorf("GET with a stale ETag = %d, served %d times; want 200 served twice", w.Code, resource.served)
+	}
+	if got := w.Header().Get("ETag"); got == etag || got != `W/"v2"` {
+		t.Errorf("ETag after the change = %q, want W/\"v2\"", got)
+	}
+}
+
+// HEAD answers with the headers alone, without running the handler
+func TestConditionalHead(t *testing.T) {
+	resource := &versionedResource{version: "v1", maxAge: time.Minute}
+	w := resource.request(t, http.MethodHead, "")
+	if w.Code != http.StatusOK || w.Body.Len() != 0 || resource.served != 0 {
+		t.Errorf("HEAD = %d with %d bytes, served %d times; want 200, empty, not served", w.Code, w.Body.Len(), resource.served)
+	}
+	if w.Header().Get("ETag") != `W/"v1"` {
+		t.Errorf("HEAD ETag = %q", w.Header().Get("ETag"))
+	}
+	if w := resource.request(t, http.MethodHead, `W/"v1"`); w.Code != http.StatusNotModified {
+		t.Errorf("HEAD with the current ETag = %d, want 304", w.Code)
+	}
+}
+
+// With nothing to version the handler answers, uncached; a failing version
+// is a server error
+func TestConditionalUnversioned(t *testing.T) {
+	resource := &versionedResource{}
+	w := resource.request(t, http.MethodGet, "*")
+	if w.Code != http.StatusOK || resource.served != 1 {
+		t.Errorf("unversioned GET = %d, served %d times; want 200 served once", w.Code, resource.served)
+	}
+	if w.Header().Get("ETag") != "" || w.Header().Get("Cache-Control") != "" {
+		t.Errorf("unversioned GET cached: ETag %q, Cache-Control %q", w.Header().Get("ETag"), w.Header().Get("Cache-Control"))
+	}
+
+	resource = &versionedResource{err: errors.New("redis: connection refused")}
+	w = resource.request(t, http.MethodGet, "")
+	if w.Code != http.StatusInternalServerError || resource.served != 0 {
+		t.Errorf("GET with a failing version = %d, served %d times; want 500, not served", w.Code, resource.served)
+	}
+}
+
+func TestETagMatches(t *testing.T) {
+	etag := `W/"v1"`
+	tests := []struct {
+		header string
+		want   bool
+	}{
+		{"", false},
+		{`W/"v1"`, true},
+		{`"v1"`, true}, // weak comparison ignores the W/
+		{`W/"v2"`, false},
+		{`W/"v0", W/"v1"`, true},
+		{`W/"v0",W/"v2"`, false},
+		{"*", true},
+		{" * ", true},
+		{`W/"v1-2"`, false},
+		{`v1`, false},
+	}
+	for _, tt := range tests {
+		if got := etagMatches(tt.header, etag); got != tt.want {
+			t.Errorf("etagMatches(%q) = %v, want %v", tt.header, got, tt.want)
+		}
+	}
+}
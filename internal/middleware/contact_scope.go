@@ -0,0 +1,63 @@
+package middleware
+
+import (
+	"context"
+
+	"github.com/gin-gonic/gin"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/apierror"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/utils"
+)
+
+const contactCheckedKey = "auth.contact_checked"
+
+// TokenRevocations answers whether an access token has been revoked
+type TokenRevocations interface {
+	IsTokenRevoked(ctx context.Context, tokenID string) (bool, error)
+}
+
+// ContactScope lets contact access tokens through on this route. It must run
+// before RequireAuth/OptionalAuth, which otherwise refuse contact tokens. A
+// contact token is accepted only if it is not revoked, carries scope (any
+// scope when empty) and was issued for the user named by the param route
+// parameter; with an empty param, for any user, on routes about the contact
+// themselves. Other principals pass through untouched.
+func ContactScope(secret string, revocations TokenRevocations, scope, param string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims, err := parseBearer(c, secret)
+		if err != nil || claims.Role != utils.RoleContact {
+			c.Next()
+			return
+		}
+
+		if claims.ID == "" {
+			AbortWithError(c, apierror.Unauthorized("missing or invalid access token"))
+			return
+		}
+		revoked, err := revocations.IsTokenRevoked(c.Request.Context(), claims.ID)
+		if err != nil {
+			// Fail closed: a removed contact must never slip through
+			AbortWithError(c, apierror.Unavailable("could not verify access token").WithCause(err))
+			return
+		}
+		if revoked {
+			AbortWithError(c, apierror.Unauthorized("access token has been revoked"))
+			return
+		}
+		if scope != "" && !claims.HasScope(scope) {
+			AbortWithError(c, apierror.Forbidden("token lacks the "+scope+" scope"))
+			return
+		}
+		if param != "" && claims.Subject != c.Param(param) {
+			AbortWithError(c, apierror.Forbidden("not allowed to access this user"))
+			return
+		}
+
+		c.Set(contactCheckedKey, true)
+		c.Next()
+	}
+}
+
+// contactAllowed reports whether a contact token passed ContactScope on this route
+func contactAllowed(c *gin.Context) bool {
+	return c.GetBool(contactCheckedKey)
+}
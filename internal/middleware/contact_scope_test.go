@@ -0,0 +1,115 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/utils"
+)
+
+const testSecret = "test-secret"
+
+// fakeRevocations is an in-memory revocation set
+type fakeRevocations struct {
+	mu      sync.Mutex
+	revoked map[string]bool
+	err     error
+}
+
+func (f *fakeRevocations) IsTokenRevoked(ctx context.Context, tokenID string) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.revoked[tokenID], f.err
+}
+
+func (f *fakeRevocations) revoke(tokenID string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.revoked[tokenID] = true
+}
+
+func contactRouter(revocations TokenRevocations) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(RequestID(), ErrorHandler())
+	router.GET("/users/:user_id/status",
+		ContactScope(testSecret, revocations, utils.ScopeReadStatus, "user_id"),
+		RequireAuth(testSecret),
+		func(c *gin.Context) { c.Status(http.StatusOK) })
+	return router
+}
+
+func issue(t *testing.T, claims utils.TokenClaims) string {
+	t.Helper()
+	token, err := utils.IssueToken(claims, testSecret, time.Hour)
+	if err != nil {
+		t.Fatalf("IssueToken: %v", err)
+	}
+	return token
+}
+
+func get(router *gin.Engine, path, token string) int {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w.Code
+}
+
+func TestContactScopeRevocationIsImmediate(t *testing.T) {
+	revocations := &fakeRevocations{revoked: map[string]bool{}}
+	router := contactRouter(revocations)
+	userID := uuid.NewString()
+	claims := utils.TokenClaims{Subject: userID, Role: utils.RoleContact, Scopes: []string{utils.ScopeReadStatus}, ID: uuid.NewString()}
+	token := issue(t, claims)
+	path := "/users/" + userID + "/status"
+
+	if code := get(router, path, token); code != http.StatusOK {
+		t.Fatalf("before revocation: status %d, want 200", code)
+	}
+	revocations.revoke(claims.ID)
+	if code := get(router, path, token); code != http.StatusUnauthorized {
+		t.Fatalf("after revocation: status %d, want 401", code)
+	}
+}
+
+func TestContactScope(t *testing.T) {
+	userID := uuid.NewString()
+	contact := func(mutate func(*utils.TokenClaims)) utils.TokenClaims {
+		claims := utils.TokenClaims{Subject: userID, Role: utils.RoleContact, Scopes: []string{utils.ScopeReadStatus}, ID: uuid.NewString()}
+		if mutate != nil {
+			mutate(&claims)
+		}
+		return claims
+	}
+
+	tests := []struct {
+		name     string
+		claims   utils.TokenClaims
+		storeErr error
+		want     int
+	}{
+		{"valid contact token", contact(nil), nil, http.StatusOK},
+		{"revocation store down", contact(nil), errors.New("redis down"), http.StatusServiceUnavailable},
+		{"untracked token", contact(func(c *utils.TokenClaims) { c.ID = "" }), nil, http.StatusUnauthorized},
+		{"missing scope", contact(func(c *utils.TokenClaims) { c.Scopes = []string{utils.ScopeReadAlerts} }), nil, http.StatusForbidden},
+		{"another user's contact", contact(func(c *utils.TokenClaims) { c.Subject = uuid.NewString() }), nil, http.StatusForbidden},
+		{"user token passes through", utils.TokenClaims{Subject: userID, Role: utils.RoleUser}, nil, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := contactRouter(&fakeRevocations{revoked: map[string]bool{}, err: tt.storeErr})
+			if code := get(router, "/users/"+userID+"/status", issue(t, tt.claims)); code != tt.want {
+				t.Errorf("status %d, want %d", code, tt.want)
+			}
+		})
+	}
+}
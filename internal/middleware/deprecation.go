@@ -0,0 +1,22 @@
+package middleware
+
+import (
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Deprecated marks a route kept as an alias of successor, a route pattern
+// such as /v1/user/:user_id/blackbox/trails. Responses carry a Deprecation
+// header and a Link to the successor with this request's parameters filled in.
+func Deprecated(successor string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		link := successor
+		for _, p := range c.Params {
+			link = strings.ReplaceAll(link, ":"+p.Key, p.Value)
+		}
+		c.Header("Deprecation", "true")
+		c.Header("Link", "<"+link+`>; rel="successor-version"`)
+		c.Next()
+	}
+}
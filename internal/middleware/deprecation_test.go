@@ -0,0 +1,61 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/params"
+)
+
+// The old /v1/blackbox/trails/:user_id is an alias of the :user_id route:
+// same handler, same parsed ID, plus headers pointing at the successor
+func TestDeprecatedAlias(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(RequestID(), ErrorHandler())
+	var readID uuid.UUID
+	handler := func(c *gin.Context) {
+		readID = params.UserID(c)
+		c.Status(http.StatusNoContent)
+	}
+	router.GET("/v1/user/:user_id/blackbox/trails", params.UUID(params.User), handler)
+	router.GET("/v1/blackbox/trails/:user_id", Deprecated("/v1/user/:user_id/blackbox/trails"), params.UUID(params.User), handler)
+
+	userID := uuid.New()
+	tests := []struct {
+		path           string
+		wantDeprecated bool
+	}{
+		{"/v1/user/" + userID.String() + "/blackbox/trails", false},
+		{"/v1/blackbox/trails/" + userID.String(), true},
+	}
+	for _, tt := range tests {
+		readID = uuid.Nil
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		if w.Code != http.StatusNoContent || readID != userID {
+			t.Errorf("GET %s = %d reading %v, want 204 reading %v", tt.path, w.Code, readID, userID)
+		}
+		wantLink := ""
+		if tt.wantDeprecated {
+			wantLink = "</v1/user/" + userID.String() + `/blackbox/trails>; rel="successor-version"`
+		}
+		if got := w.Header().Get("Link"); got != wantLink {
+			t.Errorf("GET %s Link = %q, want %q", tt.path, got, wantLink)
+		}
+		if got := w.Header().Get("Deprecation") == "true"; got != tt.wantDeprecated {
+			t.Errorf("GET %s Deprecation = %v, want %v", tt.path, got, tt.wantDeprecated)
+		}
+	}
+
+	// A malformed ID is rejected the same way on the alias
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v1/blackbox/trails/42", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("GET alias with a malformed ID = %d, want 400", w.Code)
+	}
+}
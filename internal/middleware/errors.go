@@ -0,0 +1,56 @@
+package middleware
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/apierror"
+)
+
+// ErrorHandler writes errors attached with c.Error as the standard envelope
+// {"error": {"code", "message", "request_id", "fields"}}. The internal cause
+// is logged with the request ID and never sent to the client.
+// Must run after RequestID.
+func ErrorHandler() gin.HandlerFunc {
+	if v, ok := binding.Validator.Engine().(*validator.Validate); ok {
+		apierror.UseJSONFieldNames(v)
+	}
+
+	return func(c *gin.Context) {
+		c.Next()
+
+		if len(c.Errors) == 0 || c.Writer.Written() {
+			return
+		}
+
+		apiErr := apierror.From(c.Errors.Last().Err)
+		requestID := GetRequestID(c)
+		if apiErr.Cause != nil {
+			level := "WARN"
+			if apiErr.Status >= http.StatusInternalServerError {
+				level = "ERROR"
+			}
+			log.Printf("%s: [%s] %s %s: %s: %v", level, requestID, c.Request.Method, c.Request.URL.Path, apiErr.Message, apiErr.Cause)
+		}
+
+		body := gin.H{
+			"code":       apiErr.Code,
+			"message":    apiErr.Message,
+			"request_id": requestID,
+		}
+		if len(apiErr.Fields) > 0 {
+			body["fields"] = apiErr.Fields
+		}
+		c.JSON(apiErr.Status, gin.H{"error": body})
+	}
+}
+
+// AbortWithError stops the handler chain; ErrorHandler writes the response
+func AbortWithError(c *gin.Context, err error) {
+	_ = c.Error(err)
+	c.Abort()
+}
@@ -0,0 +1,125 @@
+package middleware
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/apierror"
+)
+
+func errorRouter(handler gin.HandlerFunc) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(RequestID(), ErrorHandler())
+	r.GET("/", handler)
+	return r
+}
+
+// The error envelope is a contract with the apps: exactly these keys, the
+// cause never sent, and the request ID the one in the response header
+func TestErrorHandlerEnvelope(t *testing.T) {
+	tests := []struct {
+		name       string
+		handler    gin.HandlerFunc
+		wantStatus int
+		wantError  map[string]any // without request_id
+	}{
+		{
+			name: "API error",
+			handler: func(c *gin.Context) {
+				AbortWithError(c, apierror.NotFound("user not found").WithCause(errors.New("pgx: no rows in result set")))
+			},
+			wantStatus: http.StatusNotFound,
+			wantError:  map[string]any{"code": "not_found", "message": "user not found"},
+		},
+		{
+			name: "fields",
+			handler: func(c *gin.Context) {
+				AbortWithError(c, apierror.Invalid("lat", "must be between -90 and 90"))
+			},
+			wantStatus: http.StatusBadRequest,
+			wantError: map[string]any{
+				"code":    "validation_failed",
+				"message": "request validation failed",
+				"fields":  []any{map[string]any{"field": "lat", "reason": "must be between -90 and 90"}},
+			},
+		},
+		{
+			name: "plain error",
+			handler: func(c *gin.Context) {
+				AbortWithError(c, errors.New("dial tcp 10.0.0.5:5432: connection refused"))
+			},
+			wantStatus: http.StatusInternalServerError,
+			wantError:  map[string]any{"code": "internal_error", "message": "internal server error"},
+		},
+		{
+			name: "last error wins",
+			handler: func(c *gin.Context) {
+				_ = c.Error(apierror.BadRequest("first"))
+				AbortWithError(c, apierror.Conflict("already resolved"))
+			},
+			wantStatus: http.StatusConflict,
+			wantError:  map[string]any{"code": "conflict", "message": "already resolved"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("X-Request-ID", "req-1849")
+			errorRouter(tt.handler).ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if strings.Contains(w.Body.String(), "pgx") || strings.Contains(w.Body.String(), "10.0.0.5") {
+				t.Errorf("cause leaked: %s", w.Body.String())
+			}
+
+			var body map[string]map[string]any
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || len(body) != 1 {
+				t.Fatalf("body = %s, want one error object", w.Body.String())
+			}
+			envelope := body["error"]
+			if envelope["request_id"] != "req-1849" || w.Header().Get("X-Request-ID") != "req-1849" {
+				t.Errorf("request_id = %v, header %q, want req-1849", envelope["request_id"], w.Header().Get("X-Request-ID"))
+			}
+			delete(envelope, "request_id")
+			if !reflect.DeepEqual(envelope, tt.wantError) {
+				t.Errorf("error = %v, want %v", envelope, tt.wantError)
+			}
+		})
+	}
+}
+
+// A handler that has written its response keeps it
+func TestErrorHandlerWritten(t *testing.T) {
+	w := httptest.NewRecorder()
+	errorRouter(func(c *gin.Context) {
+		c.String(http.StatusAccepted, "queued")
+		_ = c.Error(errors.New("late failure"))
+	}).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if w.Code != http.StatusAccepted || w.Body.String() != "queued" {
+		t.Errorf("response = %d %q, want 202 queued", w.Code, w.Body.String())
+	}
+}
+
+// Without errors nothing is added
+func TestErrorHandlerNoError(t *testing.T) {
+	w := httptest.NewRecorder()
+	errorRouter(func(c *gin.Context) {
+		c.Status(http.StatusNoContent)
+	}).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if w.Code != http.StatusNoContent || w.Body.Len() != 0 {
+		t.Errorf("response = %d %q, want an empty 204", w.Code, w.Body.String())
+	}
+}
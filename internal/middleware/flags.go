@@ -0,0 +1,16 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/flags"
+)
+
+// FeatureFlags decides each user's feature flags at most once per request,
+// so one request never acts on two different settings of a flag
+func FeatureFlags() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Request = c.Request.WithContext(flags.WithCache(c.Request.Context()))
+		c.Next()
+	}
+}
@@ -0,0 +1,64 @@
+package middleware
+
+import (
+	"context"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/apierror"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/utils"
+)
+
+const guardianLinkKey = "auth.guardian_link"
+
+// LinkChecker resolves a guardian's active link to a ward, or nil if none
+type LinkChecker interface {
+	ActiveLink(ctx context.Context, guardianID, wardID uuid.UUID) (*models.AccountLink, error)
+}
+
+// GuardianAccess attaches the active account link when a user token belongs to
+// a guardian of the user in the given route parameter. It never rejects on its
+// own; handlers decide what the link allows. Only mount it on read-only routes
+// and on routes that check a link permission. Must run after RequireAuth or OptionalAuth.
+func GuardianAccess(links LinkChecker, param string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims := Principal(c)
+		if claims == nil || claims.Role != utils.RoleUser {
+			c.Next()
+			return
+		}
+
+		wardID, err := uuid.Parse(c.Param(param))
+		if err != nil || claims.Subject == wardID.String() {
+			c.Next()
+			return
+		}
+		guardianID, err := uuid.Parse(claims.Subject)
+		if err != nil {
+			c.Next()
+			return
+		}
+
+		link, err := links.ActiveLink(c.Request.Context(), guardianID, wardID)
+		if err != nil {
+			AbortWithError(c, apierror.Internal("authorization check failed", err))
+			return
+		}
+		if link != nil {
+			c.Set(guardianLinkKey, link)
+		}
+		c.Next()
+	}
+}
+
+// GuardianLink returns the account link that authorized the caller for wardID, or nil
+func GuardianLink(c *gin.Context, wardID uuid.UUID) *models.AccountLink {
+	if v, ok := c.Get(guardianLinkKey); ok {
+		if link, ok := v.(*models.AccountLink); ok && link.WardUserID == wardID {
+			return link
+		}
+	}
+	return nil
+}
@@ -0,0 +1,30 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const (
+	requestIDKey    = "request.id"
+	requestIDHeader = "X-Request-ID"
+)
+
+// RequestID tags every request with an ID, reusing the caller's X-Request-ID
+// when it looks sane, and echoes it in the response
+func RequestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(requestIDHeader)
+		if id == "" || len(id) > 64 {
+			id = uuid.NewString()
+		}
+		c.Set(requestIDKey, id)
+		c.Header(requestIDHeader, id)
+		c.Next()
+	}
+}
+
+// GetRequestID returns the current request's ID, or "" outside RequestID
+func GetRequestID(c *gin.Context) string {
+	return c.GetString(requestIDKey)
+}
@@ -0,0 +1,69 @@
+package middleware
+
+import (
+	"context"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/apierror"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+)
+
+const responderKey = "auth.responder"
+
+// responderRetryAfter is the Retry-After sent when a key is over its
+// per-minute limit
+const responderRetryAfter = "60"
+
+// ResponderAuth resolves responder keys and meters their requests
+type ResponderAuth interface {
+	Authenticate(ctx context.Context, raw string) (*models.ResponderKey, error)
+	AllowRequest(ctx context.Context, key *models.ResponderKey) (bool, error)
+}
+
+// RequireResponderKey rejects requests without an active responder key in
+// the Authorization header, and keys over their rate limit
+func RequireResponderKey(auth ResponderAuth) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		header := c.GetHeader("Authorization")
+		if !strings.HasPrefix(header, "Bearer ") {
+			AbortWithError(c, apierror.Unauthorized("missing or invalid responder key"))
+			return
+		}
+
+		key, err := auth.Authenticate(c.Request.Context(), strings.TrimPrefix(header, "Bearer "))
+		if err != nil {
+			AbortWithError(c, apierror.Internal("responder key check failed", err))
+			return
+		}
+		if key == nil {
+			AbortWithError(c, apierror.Unauthorized("missing or invalid responder key"))
+			return
+		}
+
+		allowed, err := auth.AllowRequest(c.Request.Context(), key)
+		if err != nil {
+			AbortWithError(c, apierror.Unavailable("rate limiter unavailable").WithCause(err))
+			return
+		}
+		if !allowed {
+			c.Header("Retry-After", responderRetryAfter)
+			AbortWithError(c, apierror.TooManyRequests("responder key rate limit exceeded"))
+			return
+		}
+
+		c.Set(responderKey, key)
+		c.Next()
+	}
+}
+
+// Responder returns the responder key that authenticated the request, or nil
+func Responder(c *gin.Context) *models.ResponderKey {
+	if v, ok := c.Get(responderKey); ok {
+		if key, ok := v.(*models.ResponderKey); ok {
+			return key
+		}
+	}
+	return nil
+}
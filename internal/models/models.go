@@ -3,6 +3,11 @@ package models
 import (
 	"database/sql/driver"
 	"encoding/json"
+	"fmt"
+	"math"
+	"net/url"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
@@ -10,23 +15,103 @@ import (
 
 // User represents a SafeTrace user
 type User struct {
+	RedisPayload
+
 	ID              uuid.UUID       `json:"id" db:"id"`
 	Phone           string          `json:"phone" db:"phone"`
 	Name            string          `json:"name" db:"name"`
 	TrustedContacts TrustedContacts `json:"trusted_contacts" db:"trusted_contacts"`
 	Settings        UserSettings    `json:"settings" db:"settings"`
+	OrgID           *uuid.UUID      `json:"org_id,omitempty" db:"org_id"` // organization the user is a member of
 	CreatedAt       time.Time       `json:"created_at" db:"created_at"`
 	UpdatedAt       time.Time       `json:"updated_at" db:"updated_at"`
 }
 
 // Contact represents a trusted contact
 type Contact struct {
-	ID    string `json:"id"`
-	Name  string `json:"name"`
-	Phone string `json:"phone"`
+	ID          string                   `json:"id"`
+	Name        string                   `json:"name"`
+	Phone       string                   `json:"phone"`
+	Preferences *NotificationPreferences `json:"preferences,omitempty"`
+	Status      string                   `json:"status,omitempty"`     // ContactStatus*; empty for organization escalation contacts
+	DeletedAt   *time.Time               `json:"deleted_at,omitempty"` // set once the user removed them; they can be restored
+}
+
+// Trusted contact statuses
+const (
+	ContactStatusInvited   = "invited"   // added, invitation not confirmed yet
+	ContactStatusConfirmed = "confirmed" // confirmed their invitation link
+)
+
+// ContactRecipient is the person behind a phone number across every user
+// who lists them as a trusted contact, with the preferences they set for
+// themselves
+type ContactRecipient struct {
+	Phone           string            `json:"phone" db:"phone"`
+	MaxPerWindow    *int              `json:"max_per_window" db:"max_per_window"` // non-critical messages per 10 minutes; nil for the default
+	SMSEnabled      bool              `json:"sms_enabled" db:"sms_enabled"`
+	WhatsAppEnabled bool              `json:"whatsapp_enabled" db:"whatsapp_enabled"`
+	Reference       *ContactReference `json:"reference_location"` // nil unless they registered one
+	CreatedAt       time.Time         `json:"created_at" db:"created_at"`
+	UpdatedAt       time.Time         `json:"updated_at" db:"updated_at"`
+}
+
+// Reference location labels
+const (
+	ReferenceHome = "home"
+	ReferenceWork = "work"
+)
+
+// ContactReference is where a contact recipient usually is, so an alert can
+// tell them how far and which way the user is from there
+type ContactReference struct {
+	Label   string  `json:"label"` // home or work
+	Lat     float64 `json:"lat"`
+	Lng     float64 `json:"lng"`
+	Address string  `json:"address,omitempty"` // as geocoded, when they gave an address
+}
+
+// ProtectedUser is a user who lists a contact recipient as a trusted contact
+type ProtectedUser struct {
+	UserID    uuid.UUID `json:"user_id"`
+	Name      string    `json:"name"`
+	ContactID string    `json:"contact_id"`
 }
 
-// TrustedContacts is a slice of contacts stored as JSONB
+// ContactDigestEntry is a non-critical message held back by a contact's
+// pacing, to go out in a combined update
+type ContactDigestEntry struct {
+	RedisPayload
+
+	UserID   uuid.UUID `json:"user_id"`
+	UserName string    `json:"user_name"`
+	Text     string    `json:"text"` // what happened, without the user's name
+	At       time.Time `json:"at"`
+}
+
+// ContactInvite is a pending invitation for a trusted contact to confirm
+// and receive a contact access token
+type ContactInvite struct {
+	RedisPayload
+
+	UserID    uuid.UUID `json:"user_id"`
+	ContactID string    `json:"contact_id"`
+	Phone     string    `json:"phone"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// NotificationPreferences controls when a contact receives non-critical notifications.
+// ALERT-level notifications always bypass these rules.
+type NotificationPreferences struct {
+	Timezone            string `json:"timezone,omitempty"`               // IANA name, defaults to the contact's country's
+	QuietHoursStart     string `json:"quiet_hours_start,omitempty"`      // "22:00"
+	QuietHoursEnd       string `json:"quiet_hours_end,omitempty"`        // "07:00"
+	QuietSeverityFloor  string `json:"quiet_severity_floor,omitempty"`   // lowest state delivered during quiet hours
+	MaxDailyNonCritical int    `json:"max_daily_non_critical,omitempty"` // 0 = unlimited
+}
+
+// TrustedContacts is a list of contacts. A user's are rows of the contacts
+// table; an organization's escalation contacts are stored as JSONB.
 type TrustedContacts []Contact
 
 func (t TrustedContacts) Value() (driver.Value, error) {
@@ -52,6 +137,47 @@ type UserSettings struct {
 	AutoEscalatePolice  bool `json:"auto_escalate_police"`
 	ShareAudio          bool `json:"share_audio"`
 	PanicGesture        string `json:"panic_gesture"` // "power_button_3x" | "shake"
+
+	// What escalation does once a contact has acknowledged the alert:
+	// "skip" (default), "delay" by EscalationAckDelayMinutes, or "ignore" the ack
+	EscalationOnAck           string `json:"escalation_on_ack,omitempty"`
+	EscalationAckDelayMinutes int    `json:"escalation_ack_delay_minutes,omitempty"`
+
+	// Adaptive heartbeat intervals: the longest interval the user accepts
+	// (0 means the server maximum) and places where they may report less often
+	MaxHeartbeatInterval int        `json:"max_heartbeat_interval,omitempty"` // seconds
+	SafeZones            []Geofence `json:"safe_zones,omitempty"`
+
+	// The user's IANA time zone (their country's by default), which messages
+	// about them give times in and their daily summaries are cut in, and
+	// whether they opted out of daily summaries
+	Timezone             string `json:"timezone,omitempty"`
+	DailySummaryDisabled bool   `json:"daily_summary_disabled,omitempty"`
+
+	// SMS heartbeats get no reply; users on SMS fallback can instead get one
+	// text a day saying how many arrived
+	SMSDailyConfirmation bool `json:"sms_daily_confirmation,omitempty"`
+
+	// Partner responders see the position of the user's unresolved alerts
+	// rounded to 100m, unless the user lets them see it exactly
+	ResponderPreciseLocation bool `json:"responder_precise_location,omitempty"`
+
+	// A public badge of whether the user was last confirmed safe, and how
+	// long ago, for them to embed on a site; never their location or name
+	PublicStatus bool `json:"public_status,omitempty"`
+
+	// Channels a check-in prompt tries in turn at CAUTION, each waiting
+	// SilentPromptTimeout for an answer; empty means the default ladder
+	SilentPromptLadder []string `json:"silent_prompt_ladder,omitempty"`
+
+	// Safe zones, by index in SafeZones, that resolve an alert without a
+	// panic once the user sends healthy heartbeats from one and confirms
+	// they're home safe; empty leaves every alert to be resolved by hand
+	AutoResolveZones []int `json:"auto_resolve_zones,omitempty"`
+
+	// Heartbeats are stored with coarse coordinates, except during an
+	// incident, when they are kept precise; needs precision_escalation consent
+	CoarseLocation bool `json:"coarse_location,omitempty"`
 }
 
 func (s UserSettings) Value() (driver.Value, error) {
@@ -69,18 +195,22 @@ func (s *UserSettings) Scan(value interface{}) error {
 		}
 		return nil
 	}
-	b, ok := value.([]byte)
-	if !ok {
-		return nil
+	// pgx hands JSONB to a Scanner as a string
+	switch v := value.(type) {
+	case []byte:
+		return json.Unmarshal(v, s)
+	case string:
+		return json.Unmarshal([]byte(v), s)
 	}
-	return json.Unmarshal(b, s)
+	return nil
 }
 
 // Heartbeat represents a location/sensor update
 type Heartbeat struct {
 	ID         uuid.UUID `json:"id" db:"id"`
 	UserID     uuid.UUID `json:"user_id" db:"user_id"`
-	Source     string    `json:"source" db:"source"` // "http" | "sms"
+	Source     string    `json:"source" db:"source"`                 // "http" | "sms" | "ussd"
+	DeviceID   string    `json:"device_id,omitempty" db:"device_id"` // client-chosen; empty for clients that don't report one
 	Lat        float64   `json:"lat" db:"lat"`
 	Lng        float64   `json:"lng" db:"lng"`
 	AccuracyM  int       `json:"accuracy_m" db:"accuracy_m"`
@@ -91,22 +221,197 @@ type Heartbeat struct {
 	Timestamp  time.Time `json:"timestamp" db:"timestamp"`
 	Signature  string    `json:"signature" db:"signature"`
 	CreatedAt  time.Time `json:"created_at" db:"created_at"`
+
+	// Spoofing: IsMock is reported by the client, the rest is the server's verdict
+	IsMock         bool     `json:"is_mock" db:"is_mock"`
+	SpoofSuspected bool     `json:"spoof_suspected" db:"spoof_suspected"`
+	SpoofReasons   []string `json:"spoof_reasons,omitempty" db:"spoof_reasons"`
+
+	IdentifiedBy string `json:"identified_by" db:"identified_by"` // "payload" | "sender"
+	Trust        string `json:"trust" db:"trust_level"`           // see Trust* constants
+	Backfill     bool   `json:"backfill" db:"backfill"`           // arrived after a newer heartbeat; history only
+
+	// The heartbeat this one is a copy of, sent over another channel; history only
+	DuplicateOf *uuid.UUID `json:"duplicate_of,omitempty" db:"duplicate_of"`
+
+	// Streamed every few seconds during an alert; history views keep one a minute
+	HighFrequency bool `json:"high_frequency" db:"high_frequency"`
+
+	// Stored with its coordinates truncated, the user being in coarse
+	// location mode; the precise fix was kept briefly in Redis only
+	Coarse bool `json:"coarse,omitempty" db:"coarse"`
+
+	Connectivity string `json:"connectivity,omitempty" db:"connectivity"` // see Connectivity*; empty if the client didn't say
+	Landmark     string `json:"landmark,omitempty" db:"landmark"`         // where the user said they are, for heartbeats without GPS
+}
+
+// How the device was connected when it recorded a heartbeat
+const (
+	ConnectivityWiFi          = "wifi"
+	ConnectivityCellular      = "cellular"
+	ConnectivityOfflineQueued = "offline_queued" // recorded without a connection, uploaded later
+)
+
+// UnspecifiedDevice is the device heartbeats without a device_id are tracked under
+const UnspecifiedDevice = "unspecified"
+
+// Device is one of a user's devices, as of the newest heartbeat it sent
+type Device struct {
+	RedisPayload
+
+	DeviceID    string    `json:"device_id"`
+	Source      string    `json:"source"`
+	LastSeen    time.Time `json:"last_seen"`
+	HeartbeatID uuid.UUID `json:"heartbeat_id"`
+	BatteryPct  *int      `json:"battery_pct,omitempty"`
+	Lat         float64   `json:"lat"`
+	Lng         float64   `json:"lng"`
+	AccuracyM   int       `json:"accuracy_m"`
 }
 
+// PreciseLocation is the precise fix of a heartbeat stored coarse, kept
+// briefly in Redis for evaluating and alerting on the user
+type PreciseLocation struct {
+	RedisPayload
+
+	HeartbeatID uuid.UUID `json:"heartbeat_id"`
+	Lat         float64   `json:"lat"`
+	Lng         float64   `json:"lng"`
+}
+
+// IngestionAttempt is one heartbeat a user sent, accepted or not, kept in
+// Redis for their diagnostics. A rejected one carries no location, only a
+// hash of its body the client can match against what it sent.
+type IngestionAttempt struct {
+	RedisPayload
+
+	At          time.Time  `json:"at"`
+	Endpoint    string     `json:"endpoint"` // e.g. "POST /v1/heartbeat"
+	DeviceID    string     `json:"device_id,omitempty"`
+	Accepted    bool       `json:"accepted"`
+	Outcome     string     `json:"outcome"`         // an Attempt* outcome of an accepted one, or the reason code of a rejection
+	Field       string     `json:"field,omitempty"` // the first invalid field of a validation_failed rejection
+	Message     string     `json:"message,omitempty"`
+	HeartbeatID *uuid.UUID `json:"heartbeat_id,omitempty"`
+	PayloadHash string     `json:"payload_hash,omitempty"` // first 16 hex digits of the body's SHA-256
+}
+
+// What became of an accepted heartbeat
+const (
+	AttemptStored    = "stored"
+	AttemptQueued    = "queued" // buffered, to be written and evaluated shortly
+	AttemptDuplicate = "duplicate"
+	AttemptBackfill  = "backfill"
+)
+
+// Reason codes of rejected heartbeats besides the API error codes, e.g.
+// validation_failed and rate_limited, which are used as they are
+const (
+	RejectInvalidSignature = "invalid_signature"
+	RejectReplayDetected   = "replay_detected"
+	RejectSenderMismatch   = "sender_mismatch" // an SMS heartbeat from a phone other than the user's
+)
+
+// EvaluationDiagnostic is one evaluation of a user, kept in Redis for their
+// diagnostics
+type EvaluationDiagnostic struct {
+	RedisPayload
+
+	EvaluatedAt         time.Time       `json:"evaluated_at"`
+	TriggeredBy         string          `json:"triggered_by"` // heartbeat or monitor
+	HeartbeatID         *uuid.UUID      `json:"heartbeat_id,omitempty"`
+	State               string          `json:"state"`
+	Score               int             `json:"score"`
+	Deterministic       bool            `json:"deterministic"`
+	ReasonCodes         []string        `json:"reason_codes"`
+	RulesFired          []string        `json:"rules_fired,omitempty"`
+	NextIntervalSeconds int             `json:"next_interval_seconds,omitempty"`
+	Flags               map[string]bool `json:"flags,omitempty"` // the feature flags it ran with
+}
+
+// How a heartbeat was attributed to its user
+const (
+	IdentifiedByPayload = "payload" // uid field of the request or SMS
+	IdentifiedBySender  = "sender"  // registered phone of the SMS sender or USSD caller
+)
+
+// How strongly a heartbeat is bound to its user
+const (
+	TrustVerifiedDevice = "verified_device" // HTTP, signed by the app
+	TrustVerifiedPhone  = "verified_phone"  // SMS from the user's registered phone
+	TrustUnverified     = "unverified"      // nothing ties it to the user beyond its payload
+)
+
 // CellInfo represents cellular network information
 type CellInfo struct {
-	MCC       int    `json:"mcc"`        // Mobile Country Code
-	MNC       int    `json:"mnc"`        // Mobile Network Code
-	CID       int    `json:"cid"`        // Cell ID
-	LAC       int    `json:"lac"`        // Location Area Code
-	RSSI      int    `json:"rssi"`       // Signal strength
-	NetworkType string `json:"network_type"` // "2G" | "3G" | "4G" | "5G"
-	Neighbors []NeighborCell `json:"neighbors,omitempty"`
+	MCC         int            `json:"mcc"`          // Mobile Country Code
+	MNC         int            `json:"mnc"`          // Mobile Network Code
+	CID         CellIdentifier `json:"cid"`          // Cell ID; a 36-bit NR cell identity on 5G
+	LAC         CellIdentifier `json:"lac"`          // Location Area Code; the tracking area code on 4G/5G
+	RSSI        int            `json:"rssi"`         // Signal strength
+	NetworkType string         `json:"network_type"` // see NetworkType*; free text until normalized
+	Neighbors   []NeighborCell `json:"neighbors,omitempty"`
 }
 
 type NeighborCell struct {
-	CID  int `json:"cid"`
-	RSSI int `json:"rssi"`
+	CID  CellIdentifier `json:"cid"`
+	RSSI int            `json:"rssi"`
+}
+
+// Network types CellInfo.NetworkType is normalized to
+const (
+	NetworkType2G      = "2G"
+	NetworkType3G      = "3G"
+	NetworkType4G      = "4G"
+	NetworkType5G      = "5G"
+	NetworkTypeUnknown = "unknown"
+)
+
+// CellIdentifier is a cell or area identifier. NR and some LTE identities
+// don't fit in 32 bits, and some devices send them as strings, so a JSON
+// number or a decimal string is accepted; null or "" is 0.
+type CellIdentifier int64
+
+func (id *CellIdentifier) UnmarshalJSON(data []byte) error {
+	text := strings.TrimSpace(string(data))
+	if text == "null" {
+		*id = 0
+		return nil
+	}
+	if unquoted, err := strconv.Unquote(text); err == nil {
+		text = strings.TrimSpace(unquoted)
+		if text == "" {
+			*id = 0
+			return nil
+		}
+	}
+	if n, err := strconv.ParseInt(text, 10, 64); err == nil {
+		*id = CellIdentifier(n)
+		return nil
+	}
+	// Whole numbers serialized as floats, e.g. 1.234567e+06
+	f, err := strconv.ParseFloat(text, 64)
+	if err != nil || f != math.Trunc(f) || math.Abs(f) > 1<<53 {
+		return fmt.Errorf("cell identifier must be an integer, got %s", data)
+	}
+	*id = CellIdentifier(f)
+	return nil
+}
+
+// UnmarshalJSON also accepts the MCC and MNC as strings, as Android reports
+// them, e.g. "621" and "020"
+func (c *CellInfo) UnmarshalJSON(data []byte) error {
+	type cellInfo CellInfo
+	aux := struct {
+		*cellInfo
+		MCC CellIdentifier `json:"mcc"`
+		MNC CellIdentifier `json:"mnc"`
+	}{cellInfo: (*cellInfo)(c)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	c.MCC, c.MNC = int(aux.MCC), int(aux.MNC)
+	return nil
 }
 
 func (c CellInfo) Value() (driver.Value, error) {
@@ -114,15 +419,14 @@ func (c CellInfo) Value() (driver.Value, error) {
 }
 
 func (c *CellInfo) Scan(value interface{}) error {
-	if value == nil {
-		*c = CellInfo{}
-		return nil
-	}
-	b, ok := value.([]byte)
-	if !ok {
-		return nil
+	*c = CellInfo{}
+	switch v := value.(type) {
+	case []byte:
+		return json.Unmarshal(v, c)
+	case string:
+		return json.Unmarshal([]byte(v), c)
 	}
-	return json.Unmarshal(b, c)
+	return nil
 }
 
 // LastGasp represents a final known location before connectivity loss
@@ -135,18 +439,208 @@ type LastGasp struct {
 	CellInfo  CellInfo  `json:"cell_info" db:"cell_info"`
 	CreatedAt time.Time `json:"created_at" db:"created_at"`
 	ExpiryTs  time.Time `json:"expiry_ts" db:"expiry_ts"`
+
+	// LastGasps repeated within the re-arm window update this one: LastAt is
+	// when the latest arrived and Repeats counts them. A normal heartbeat
+	// after it sets SupersededAt, which ends the wait.
+	LastAt       time.Time  `json:"last_at" db:"last_at"`
+	Repeats      int        `json:"repeats" db:"repeats"`
+	SupersededAt *time.Time `json:"superseded_at,omitempty" db:"superseded_at"`
 }
 
 // Alert represents a safety alert
 type Alert struct {
-	ID         uuid.UUID    `json:"id" db:"id"`
-	UserID     uuid.UUID    `json:"user_id" db:"user_id"`
-	State      AlertState   `json:"state" db:"state"`
-	Score      int          `json:"score" db:"score"`
-	Reason     string       `json:"reason" db:"reason"`
-	SentTo     []string     `json:"sent_to" db:"sent_to"`
-	CreatedAt  time.Time    `json:"created_at" db:"created_at"`
-	ResolvedAt *time.Time   `json:"resolved_at,omitempty" db:"resolved_at"`
+	ID         uuid.UUID  `json:"id" db:"id"`
+	UserID     uuid.UUID  `json:"user_id" db:"user_id"`
+	State      AlertState `json:"state" db:"state"`
+	Score      int        `json:"score" db:"score"`
+	Reason     string     `json:"reason" db:"reason"`        // rendered from Reasons
+	Reasons    Reasons    `json:"reasons" db:"reason_codes"` // why it was raised, most important first
+	SentTo     []string   `json:"sent_to" db:"sent_to"`
+	PlusCode   *string    `json:"plus_code,omitempty" db:"plus_code"`   // where it was raised, see the pluscode package
+	What3Words *string    `json:"what3words,omitempty" db:"what3words"` // filled in after the alert when what3words is configured
+	Duress     bool       `json:"duress,omitempty" db:"duress"`         // sent because the user entered their duress PIN
+	CreatedAt  time.Time  `json:"created_at" db:"created_at"`
+	ResolvedAt *time.Time `json:"resolved_at,omitempty" db:"resolved_at"`
+	Resolution string     `json:"resolution,omitempty" db:"resolution"` // manual | auto, once resolved
+	Note       string     `json:"note,omitempty" db:"note"`             // the user's own words, sent with a panic
+
+	// Undeliverable is why the alert reached nobody, e.g. AlertNoRecipients
+	Undeliverable string `json:"undeliverable,omitempty" db:"undeliverable"`
+
+	// DetectedAt is when the evaluation that raised the alert was requested;
+	// only written, see AlertLatency
+	DetectedAt time.Time `json:"-" db:"detected_at"`
+}
+
+// How an alert was resolved: by someone resolving it, automatically once
+// the user confirmed they were home safe, or by the reconciler closing an
+// alert left open long after the user was SAFE again
+const (
+	AlertResolvedManual     = "manual"
+	AlertResolvedAuto       = "auto"
+	AlertResolvedReconciled = "reconciled"
+)
+
+// What became of an alert left unresolved, as the reconciler classes it
+const (
+	OrphanNoDeliveries   = "no_deliveries"  // no message to a contact was attempted
+	OrphanAllFailed      = "all_failed"     // every attempt failed
+	OrphanUnacknowledged = "unacknowledged" // sent, but no contact acknowledged it
+	OrphanAcknowledged   = "acknowledged"   // a contact acknowledged it, but nobody resolved it
+)
+
+// What the reconciler did with an alert left unresolved
+const (
+	ReconcileRedispatched = "redispatched" // sent again, once, as the user still wasn't SAFE
+	ReconcileClosed       = "closed"       // resolved as reconciled, the user long since SAFE
+	ReconcileFlagged      = "flagged"      // left open for someone to look at
+)
+
+// OrphanedAlert is an alert left unresolved, with what became of its
+// deliveries and the user's latest recorded state
+type OrphanedAlert struct {
+	Alert
+	Attempted       int        // deliveries sent or failed; suppressed ones aren't attempts
+	Sent            int        // deliveries sent
+	Acknowledged    int        // recipients who acknowledged it
+	ReconcileClass  string     // as of the last reconciliation, if any
+	ReconcileAction string     // as of the last reconciliation, if any
+	UserState       string     // empty when the user has no recorded state
+	StateSince      *time.Time // when the user entered UserState
+}
+
+// ReconciledAlert is an alert the reconciler acted on
+type ReconciledAlert struct {
+	ID           uuid.UUID  `json:"id"`
+	UserID       uuid.UUID  `json:"user_id"`
+	State        AlertState `json:"state"`
+	CreatedAt    time.Time  `json:"created_at"`
+	ResolvedAt   *time.Time `json:"resolved_at,omitempty"`
+	Class        string     `json:"class"`
+	Action       string     `json:"action"`
+	ReconciledAt time.Time  `json:"reconciled_at"`
+}
+
+// ReconcileCount is how many alerts of a class the reconciler last left
+// with an action
+type ReconcileCount struct {
+	Class  string `json:"class"`
+	Action string `json:"action"`
+	Alerts int    `json:"alerts"`
+}
+
+// Channels an emergency response agency takes handoffs on
+const (
+	AgencyChannelSMS     = "sms"          // a text to a duty number
+	AgencyChannelVoice   = "voice"        // a call to a duty number, the handoff read out
+	AgencyChannelWebhook = "http_webhook" // a JSON POST to the agency's API
+)
+
+// What became of an alert's handoff to an agency
+const (
+	HandoffPending  = "pending"  // claimed and being sent
+	HandoffSent     = "sent"     // the agency's channel accepted it
+	HandoffFailed   = "failed"   // the channel refused it or couldn't be reached
+	HandoffSkipped  = "skipped"  // a contact acknowledged the alert and the user's policy stops escalation
+	HandoffUnrouted = "unrouted" // no target matched and there is no national default
+)
+
+// AgencyCandidate is an unresolved alert of a user with auto_escalate_police
+// that hasn't been handed off, with what deciding the handoff needs
+type AgencyCandidate struct {
+	Alert
+	UserName       string
+	UserPhone      string
+	Settings       UserSettings
+	FirstAckAt     *time.Time // when a contact first acknowledged it, if anyone did
+	Acknowledged   int        // contacts who acknowledged it
+	RecipientCount int        // contacts it was sent to
+}
+
+// AgencyHandoff is an alert's handoff to an emergency response agency
+type AgencyHandoff struct {
+	AlertID      uuid.UUID  `json:"alert_id"`
+	UserID       uuid.UUID  `json:"user_id"`
+	Status       string     `json:"status"`
+	Region       string     `json:"region,omitempty"` // e.g. NG-LA, as reverse geocoded
+	Target       string     `json:"target,omitempty"`
+	Channel      string     `json:"channel,omitempty"`
+	Reference    string     `json:"reference,omitempty"` // the message or call ID, or what the agency's API returned
+	Error        string     `json:"error,omitempty"`
+	EscalatedAt  time.Time  `json:"escalated_at"`
+	HandedOffAt  *time.Time `json:"handed_off_at,omitempty"`
+	AlertCreated time.Time  `json:"alert_created_at"`
+}
+
+// Trust of an unverified report: unverified until the user answers the
+// verification push on their registered device
+const (
+	ReportUnverified = TrustUnverified
+	ReportConfirmed  = "confirmed" // the user confirmed it was them
+	ReportDenied     = "denied"    // the user said it wasn't them; hidden from contacts
+)
+
+// UnverifiedReport is a position sent to the unauthenticated fallback
+// endpoint with a user's phone number, e.g. by an app that lost its keys.
+// It is never evaluated, so it can't make the user look safer; contacts
+// see it on an active alert, labelled by its trust.
+type UnverifiedReport struct {
+	ID                 uuid.UUID  `json:"id"`
+	UserID             uuid.UUID  `json:"user_id"`
+	AlertID            *uuid.UUID `json:"alert_id,omitempty"` // the alert active when it arrived
+	Lat                float64    `json:"lat"`
+	Lng                float64    `json:"lng"`
+	AccuracyM          *int       `json:"accuracy_m,omitempty"`
+	ReportedAt         time.Time  `json:"reported_at"` // as the client claims
+	ReceivedAt         time.Time  `json:"received_at"`
+	ClientIP           string     `json:"-"`
+	Trust              string     `json:"trust"`
+	VerificationSentAt *time.Time `json:"verification_sent_at,omitempty"`
+	AnsweredAt         *time.Time `json:"answered_at,omitempty"`
+}
+
+// AlertNoRecipients marks an alert raised for a user with no contacts to
+// send it to and no fallback recipient configured
+const AlertNoRecipients = "undeliverable - no recipients"
+
+// AlertLatency is when each stage of getting an alert to the user's contacts
+// happened. FirstAttemptAt and FirstDeliveredAt are nil until a message to
+// a contact was attempted and a carrier confirmed one delivered.
+type AlertLatency struct {
+	AlertID          uuid.UUID
+	UserID           uuid.UUID
+	State            AlertState
+	DetectedAt       time.Time
+	CreatedAt        time.Time
+	FirstAttemptAt   *time.Time
+	FirstDeliveredAt *time.Time
+}
+
+// AlertExportFilter selects alerts for export; empty States matches every state
+type AlertExportFilter struct {
+	From   time.Time
+	To     time.Time
+	States []AlertState
+	OrgID  *uuid.UUID // only the organization's current members
+}
+
+// AlertLocation is an alert with the user's last known position when it was raised
+type AlertLocation struct {
+	Alert
+	Lat       *float64
+	Lng       *float64
+	LocatedAt *time.Time // timestamp of the heartbeat the position came from
+}
+
+// HeatmapSample is one user's alerts and LastGasps in one geohash cell. The
+// user is only there so distinct users can be counted when cells are merged;
+// it never leaves the server.
+type HeatmapSample struct {
+	Geohash   string
+	UserID    uuid.UUID
+	Alerts    int
+	LastGasps int
 }
 
 type AlertState string
@@ -171,6 +665,128 @@ func (s *AlertState) Scan(value interface{}) error {
 	return nil
 }
 
+// AlertDelivery records a single notification attempt to a trusted contact
+type AlertDelivery struct {
+	ID          uuid.UUID `json:"id" db:"id"`
+	AlertID     uuid.UUID `json:"alert_id" db:"alert_id"`
+	ContactID   string    `json:"contact_id" db:"contact_id"`
+	ContactName string    `json:"contact_name" db:"contact_name"`
+	Phone       string    `json:"phone" db:"phone"`
+	Channel     string    `json:"channel" db:"channel"` // "sms" | "whatsapp"
+	Status      string    `json:"status" db:"status"`   // "sent" | "failed" | "suppressed"
+	Detail      string    `json:"detail" db:"detail"`
+	CreatedAt   time.Time `json:"created_at" db:"created_at"`
+
+	// The SMS provider that accepted the message and its ID there, e.g. a
+	// Twilio SID; empty for other channels and messages that weren't sent
+	Provider          string `json:"provider,omitempty" db:"provider"`
+	ProviderMessageID string `json:"provider_message_id,omitempty" db:"provider_message_id"`
+}
+
+const (
+	DeliveryStatusSent       = "sent"
+	DeliveryStatusFailed     = "failed"
+	DeliveryStatusSuppressed = "suppressed"
+)
+
+// AlertRecipient tracks whether a trusted contact received and acknowledged an alert
+type AlertRecipient struct {
+	ID             uuid.UUID  `json:"id" db:"id"`
+	AlertID        uuid.UUID  `json:"alert_id" db:"alert_id"`
+	ContactID      string     `json:"contact_id" db:"contact_id"`
+	ContactName    string     `json:"contact_name" db:"contact_name"`
+	Phone          string     `json:"phone" db:"phone"`
+	Channel        string     `json:"channel" db:"channel"`
+	AckToken       string     `json:"-" db:"ack_token"`
+	DeliveredAt    *time.Time `json:"delivered_at,omitempty" db:"delivered_at"`
+	AcknowledgedAt *time.Time `json:"acknowledged_at,omitempty" db:"acknowledged_at"`
+	AckMethod      *string    `json:"ack_method,omitempty" db:"ack_method"`
+	CreatedAt      time.Time  `json:"created_at" db:"created_at"`
+}
+
+const (
+	AckMethodSMS   = "sms"
+	AckMethodLink  = "link"
+	AckMethodVoice = "voice"
+)
+
+// Contact dashboard token scopes
+const (
+	ShareScopeRead        = "read"        // view the alert only
+	ShareScopeAcknowledge = "acknowledge" // also acknowledge it and request welfare checks
+)
+
+// AlertShare is access to one alert's contact dashboard through a token,
+// acting as the recipient who was sent the alert or made the share
+type AlertShare struct {
+	Token     string
+	Scope     string
+	Recipient AlertRecipient
+}
+
+// Reason is one structured reason for a state or alert: a code clients can
+// aggregate and branch on, and the values its text is rendered with
+type Reason struct {
+	Code   string                 `json:"code"`
+	Params map[string]interface{} `json:"params,omitempty"`
+}
+
+// Reason codes
+const (
+	ReasonNoHeartbeat        = "NO_HEARTBEAT"
+	ReasonScoreNormal        = "SCORE_NORMAL"
+	ReasonScoreConcerning    = "SCORE_CONCERNING"
+	ReasonScoreAtRisk        = "SCORE_AT_RISK"
+	ReasonHeartbeatStale     = "HEARTBEAT_STALE" // minutes
+	ReasonLastGaspActive     = "LASTGASP_ACTIVE" // minutes
+	ReasonLastGaspRecent     = "LASTGASP_RECENT"
+	ReasonLastGaspProlonged  = "LASTGASP_PROLONGED" // minutes
+	ReasonEnteringDeadZone   = "ENTERING_DEAD_ZONE" // from_dbm, to_dbm
+	ReasonSpoofSuspected     = "SPOOF_SUSPECTED"
+	ReasonOfflineQueued      = "OFFLINE_QUEUED"
+	ReasonDeviceDisagreement = "DEVICE_DISAGREEMENT" // km
+	ReasonDeterioratingTrend = "DETERIORATING_TREND" // penalty
+	ReasonActiveInApp        = "ACTIVE_IN_APP"
+	ReasonProtectionPaused   = "PROTECTION_PAUSED" // until
+	ReasonPanic              = "PANIC"             // source: app, sms or ussd; keyword for sms; outcome for app
+	ReasonDuress             = "DURESS"
+	ReasonImpact             = "IMPACT"        // at
+	ReasonWatchExpired       = "WATCH_EXPIRED" // ended_at, last_state, last_score, note
+	ReasonLegacy             = "LEGACY"        // raised before reason codes; only the text is known
+
+	// Check-in prompts at CAUTION: waiting on one until a time, and escalated
+	// after the given attempts over so many seconds went unanswered
+	ReasonCheckInPending    = "CHECK_IN_PENDING"    // until
+	ReasonCheckInUnanswered = "CHECK_IN_UNANSWERED" // attempts, seconds
+
+	// Staleness put down to a probable outage of the user's network where
+	// they were last seen, held until a time
+	ReasonProbableOutage = "PROBABLE_OUTAGE" // carrier, until
+)
+
+// Reasons is a list of reasons stored as JSONB
+type Reasons []Reason
+
+func (r Reasons) Value() (driver.Value, error) {
+	if r == nil {
+		r = Reasons{}
+	}
+	return json.Marshal(r)
+}
+
+func (r *Reasons) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		*r = Reasons{}
+		return nil
+	case string:
+		return json.Unmarshal([]byte(v), r)
+	case []byte:
+		return json.Unmarshal(v, r)
+	}
+	return fmt.Errorf("cannot scan %T into Reasons", value)
+}
+
 type StringArray []string
 
 func (s StringArray) Value() (driver.Value, error) {
@@ -195,9 +811,86 @@ type BlackboxTrail struct {
 	UserID     uuid.UUID `json:"user_id" db:"user_id"`
 	StartTs    time.Time `json:"start_ts" db:"start_ts"`
 	EndTs      time.Time `json:"end_ts" db:"end_ts"`
-	DataPoints int       `json:"data_points" db:"data_points"`
+	DataPoints int       `json:"data_points" db:"data_points"` // accepted data points, stored at FileURL
 	FileURL    string    `json:"file_url" db:"file_url"`
 	UploadedAt time.Time `json:"uploaded_at" db:"uploaded_at"`
+
+	// Data points rejected on upload, kept apart with their reasons
+	RejectedPoints int    `json:"rejected_points" db:"rejected_points"`
+	QuarantineURL  string `json:"quarantine_url,omitempty" db:"quarantine_url"`
+
+	// Tamper evidence from the client's hash chain
+	IntegrityStatus string `json:"integrity_status" db:"integrity_status"` // verified | unverified | broken | bad_signature
+	ChainHead       string `json:"chain_head,omitempty" db:"chain_head"`
+	ChainBreakIndex *int   `json:"chain_break_index,omitempty" db:"chain_break_index"` // first data point that failed
+}
+
+// IncidentBundle is an investigation bundle of a resolved alert: a zip of
+// its timeline, heartbeats, blackbox trails and deliveries with a signed
+// manifest, built in the background and kept in object storage until it
+// expires (stored in Postgres)
+type IncidentBundle struct {
+	ID          uuid.UUID  `json:"id" db:"id"`
+	AlertID     uuid.UUID  `json:"alert_id" db:"alert_id"`
+	UserID      uuid.UUID  `json:"user_id" db:"user_id"`
+	RequestedBy string     `json:"requested_by" db:"requested_by"` // subject of the token that asked for it
+	Status      string     `json:"status" db:"status"`             // see Bundle* constants
+	ObjectKey   string     `json:"-" db:"object_key"`
+	SizeBytes   int64      `json:"size_bytes,omitempty" db:"size_bytes"`
+	Signature   string     `json:"manifest_signature,omitempty" db:"manifest_signature"`
+	Error       string     `json:"error,omitempty" db:"error"`
+	CreatedAt   time.Time  `json:"created_at" db:"created_at"`
+	CompletedAt *time.Time `json:"completed_at,omitempty" db:"completed_at"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty" db:"expires_at"`
+}
+
+// Incident bundle statuses
+const (
+	BundlePending  = "pending"
+	BundleBuilding = "building"
+	BundleReady    = "ready"
+	BundleFailed   = "failed"
+	BundleExpired  = "expired" // deleted from object storage
+)
+
+// Trail integrity statuses
+const (
+	TrailVerified     = "verified"      // chain intact and head signed with the device secret
+	TrailUnverified   = "unverified"    // uploaded without a hash chain
+	TrailBroken       = "broken"        // an entry's hash does not follow from the one before
+	TrailBadSignature = "bad_signature" // chain intact but the head signature is wrong
+)
+
+// Outcomes of moving an inline trail to object storage
+const (
+	TrailMigrationDone   = "done"
+	TrailMigrationFailed = "failed"
+)
+
+// InlineTrail is a trail whose data is still stored in file_url as a data URI
+type InlineTrail struct {
+	ID         uuid.UUID `db:"id"`
+	UserID     uuid.UUID `db:"user_id"`
+	FileURL    string    `db:"file_url"`
+	UploadedAt time.Time `db:"uploaded_at"`
+}
+
+// TrailMigrationCounts summarizes the move of inline trails to object storage
+type TrailMigrationCounts struct {
+	Pending    int   `json:"pending"` // still inline and not failed
+	Done       int   `json:"done"`
+	Failed     int   `json:"failed"`
+	SavedBytes int64 `json:"saved_bytes"` // inline size minus stored size of migrated trails
+}
+
+// QuarantinedEntry is a trail data point rejected on upload. Malformed
+// carries over the entry's fields that could not be decoded, which were
+// zeroed and so are not what the client hashed.
+type QuarantinedEntry struct {
+	Index     int           `json:"index"` // position in the uploaded data_points
+	Reasons   []string      `json:"reasons"`
+	Malformed []string      `json:"malformed,omitempty"`
+	Entry     BlackboxEntry `json:"entry"`
 }
 
 // BlackboxEntry represents a single trail data point
@@ -208,6 +901,69 @@ type BlackboxEntry struct {
 	AccuracyM  int       `json:"accuracy_m"`
 	CellInfo   CellInfo  `json:"cell_info"`
 	SensorData SensorData `json:"sensor_data,omitempty"`
+	Hash       string     `json:"hash,omitempty"` // chains the entry to the one before; see utils.BlackboxEntryHash
+
+	// Malformed names the fields that could not be decoded and were left
+	// zero, e.g. "lat" or "sensor_data.accel_x"; see UnmarshalJSON
+	Malformed []string `json:"-"`
+}
+
+// UnmarshalJSON decodes an entry leniently, so one glitched sensor doesn't
+// fail a whole trail: a field that can't be decoded, such as NaN or Infinity
+// in place of a number or an unreadable timestamp, is left zero and named in
+// Malformed. So is an entry that isn't an object at all. Absent and null
+// fields are zero, as before.
+func (e *BlackboxEntry) UnmarshalJSON(data []byte) error {
+	var fields struct {
+		Timestamp  json.RawMessage `json:"timestamp"`
+		Lat        json.RawMessage `json:"lat"`
+		Lng        json.RawMessage `json:"lng"`
+		AccuracyM  json.RawMessage `json:"accuracy_m"`
+		CellInfo   json.RawMessage `json:"cell_info"`
+		SensorData json.RawMessage `json:"sensor_data"`
+		Hash       json.RawMessage `json:"hash"`
+	}
+	*e = BlackboxEntry{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		e.Malformed = []string{"entry"}
+		return nil
+	}
+
+	decode := func(name string, raw json.RawMessage, dst interface{}) bool {
+		if len(raw) == 0 || json.Unmarshal(raw, dst) == nil {
+			return true
+		}
+		e.Malformed = append(e.Malformed, name)
+		return false
+	}
+	var timestamp time.Time
+	var cell CellInfo
+	if decode("timestamp", fields.Timestamp, &timestamp) {
+		e.Timestamp = timestamp
+	}
+	decode("lat", fields.Lat, &e.Lat)
+	decode("lng", fields.Lng, &e.Lng)
+	decode("accuracy_m", fields.AccuracyM, &e.AccuracyM)
+	if decode("cell_info", fields.CellInfo, &cell) {
+		e.CellInfo = cell
+	}
+
+	var sensor map[string]json.RawMessage
+	if decode("sensor_data", fields.SensorData, &sensor) {
+		s := &e.SensorData
+		for _, axis := range []struct {
+			name string
+			dst  *float64
+		}{
+			{"accel_x", &s.AccelX}, {"accel_y", &s.AccelY}, {"accel_z", &s.AccelZ},
+			{"gyro_x", &s.GyroX}, {"gyro_y", &s.GyroY}, {"gyro_z", &s.GyroZ},
+		} {
+			decode("sensor_data."+axis.name, sensor[axis.name], axis.dst)
+		}
+	}
+
+	decode("hash", fields.Hash, &e.Hash)
+	return nil
 }
 
 type SensorData struct {
@@ -219,13 +975,842 @@ type SensorData struct {
 	GyroZ  float64 `json:"gyro_z"`
 }
 
-// UserState represents current safety state (stored in Redis)
+// RedisSchemaVersion is the version of the JSON payloads this build keeps
+// in Redis. Bump it whenever a struct embedding RedisPayload gains a field,
+// so builds still running the old version during a rolling deploy can tell
+// they are reading a newer payload.
+const RedisSchemaVersion = 1
+
+// RedisPayload is embedded in every struct kept in Redis as JSON. It is
+// filled and written by the database layer, never by the struct's own JSON,
+// so API responses don't show it: the payload's schema_version as read (0
+// for one written before versioning), and the fields of a newer payload this
+// build doesn't know, which are written back when the payload is rewritten
+// so an older instance can't drop them mid-deploy.
+type RedisPayload struct {
+	SchemaVersion int                        `json:"-"`
+	Unknown       map[string]json.RawMessage `json:"-"`
+}
+
+// Payload gives the database layer the embedded RedisPayload
+func (p *RedisPayload) Payload() *RedisPayload {
+	return p
+}
+
+// RedisPersisted is implemented by the structs embedding RedisPayload
+type RedisPersisted interface {
+	Payload() *RedisPayload
+}
+
+// UserState represents current safety state (cached in Redis; transitions
+// are recorded in Postgres as StateTransition)
 type UserState struct {
+	RedisPayload
+
 	UserID         uuid.UUID  `json:"user_id"`
-	State          string     `json:"state"` // SAFE | CAUTION | AT_RISK | ALERT | WAIT_LASTGASP
+	State          string     `json:"state"` // SAFE | CAUTION | AT_RISK | ALERT | WAIT_LASTGASP | PAUSED
 	Score          int        `json:"score"`
 	LastHeartbeat  time.Time  `json:"last_heartbeat"`
+	LastTrust      string     `json:"last_trust,omitempty"` // trust level of the last heartbeat
+	PausedUntil    *time.Time `json:"paused_until,omitempty"`
+	WatchUntil     *time.Time `json:"watch_until,omitempty"` // end of an active watch session
 	LastGaspActive bool       `json:"last_gasp_active"`
 	LastGaspExpiry *time.Time `json:"last_gasp_expiry,omitempty"`
-	UpdatedAt      time.Time  `json:"updated_at"`
+	SpoofSuspected bool       `json:"spoof_suspected,omitempty"`
+	SpoofReasons   []string   `json:"spoof_reasons,omitempty"`
+	Anomalies      []string   `json:"anomalies,omitempty"` // e.g. devices disagreeing on the location
+	Reasons        []Reason   `json:"reasons,omitempty"`   // why the user is in State, most important first
+	Roaming        *Roaming   `json:"roaming,omitempty"`   // set while the phone is on a foreign network
+
+	// Probable outage zone the user was last seen in, set while their
+	// staleness is put down to it
+	Outage *OutageZone `json:"outage,omitempty"`
+
+	// Heartbeat interval advised to the client, and the longest interval it
+	// may still be following, which the staleness check allows for
+	NextIntervalSeconds      int       `json:"next_interval_seconds,omitempty"`
+	NextIntervalReason       string    `json:"next_interval_reason,omitempty"`
+	IntervalAllowanceSeconds int       `json:"interval_allowance_seconds,omitempty"`
+	UpdatedAt                time.Time `json:"updated_at"`
+
+	// Revision counts the writes of the cached state. A state built from
+	// another carries that one's revision, and is only saved if the cache
+	// is still at it.
+	Revision int64 `json:"revision,omitempty"`
+}
+
+// HomeSnapshot is what the app's home screen reads from Postgres, in one
+// query. Each part is nil when the user has none.
+type HomeSnapshot struct {
+	Heartbeat    *Heartbeat       // latest live heartbeat; source, location and timestamp only
+	Alert        *Alert           // newest unresolved alert; sent_to, locations and resolution aren't read
+	Pause        *ProtectionPause // open pause that hasn't lapsed
+	Watch        *WatchSession    // open watch that hasn't run out
+	WelfareCheck *WelfareCheck    // pending welfare check
+}
+
+// WarmupUser is a recently active user whose Redis state is rebuilt from
+// Postgres after Redis lost its data
+type WarmupUser struct {
+	UserID        uuid.UUID
+	LastHeartbeat time.Time  // latest live heartbeat
+	LastAlertAt   *time.Time // latest alert, resolved or not
+	Paused        bool       // protection is paused
+}
+
+// OutageZone is a geohash cell where many unrelated users of one network
+// went silent at once, most likely a carrier outage or a known dead zone.
+// It stays active for a cooldown after the last user to go silent there.
+type OutageZone struct {
+	Geohash string    `json:"geohash"`
+	MCC     int       `json:"mcc"`
+	MNC     int       `json:"mnc"`
+	Carrier string    `json:"carrier"` // e.g. "MTN"; "UNKNOWN" for a network not in the table
+	Lat     float64   `json:"lat"`     // center of the cell
+	Lng     float64   `json:"lng"`
+	Users   int       `json:"users"` // distinct users gone silent in the window when last counted
+	Since   time.Time `json:"since"`
+	Until   time.Time `json:"until"`
+}
+
+// Roaming is the foreign network a user's SIM is roaming on, from
+// the mobile country code of their latest heartbeat that reported one
+type Roaming struct {
+	MCC     int    `json:"mcc"`
+	Country string `json:"country"` // e.g. "Benin"; "another country (MCC 123)" for a code not in the table
+}
+
+// SMSDelivery tracks an outbound SMS so failed deliveries can be retried on another provider
+type SMSDelivery struct {
+	RedisPayload
+
+	MessageID string     `json:"message_id"`
+	Provider  string     `json:"provider"`
+	To        string     `json:"to"`
+	Message   string     `json:"message"`
+	Kind      string     `json:"kind,omitempty"` // the outbound budget's message type
+	Attempts  int        `json:"attempts"`
+	SentAt    time.Time  `json:"sent_at"`
+	AlertID   *uuid.UUID `json:"alert_id,omitempty"` // set when the message carried an alert
+}
+
+// USSDSession is where a USSD caller is in the menu, kept between the
+// gateway's callbacks
+type USSDSession struct {
+	RedisPayload
+
+	Step     string `json:"step"`
+	Consumed int    `json:"consumed"` // length of the gateway's input text already answered
+}
+
+// GeoPoint is a latitude/longitude pair
+type GeoPoint struct {
+	Lat float64 `json:"lat"`
+	Lng float64 `json:"lng"`
+}
+
+// Geofence is either a circle (center + radius) or a polygon
+type Geofence struct {
+	Type    string     `json:"type"` // "radius" | "polygon"
+	Center  *GeoPoint  `json:"center,omitempty"`
+	RadiusM float64    `json:"radius_m,omitempty"`
+	Points  []GeoPoint `json:"points,omitempty"`
+}
+
+func (g Geofence) Value() (driver.Value, error) {
+	return json.Marshal(g)
+}
+
+func (g *Geofence) Scan(value interface{}) error {
+	if value == nil {
+		*g = Geofence{}
+		return nil
+	}
+	b, ok := value.([]byte)
+	if !ok {
+		return nil
+	}
+	return json.Unmarshal(b, g)
+}
+
+// UserPosition is a user's most recent heartbeat location
+type UserPosition struct {
+	UserID    uuid.UUID `json:"user_id"`
+	Lat       float64   `json:"lat"`
+	Lng       float64   `json:"lng"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// Broadcast is a one-time advisory sent to users inside a geofence
+type Broadcast struct {
+	ID           uuid.UUID      `json:"id" db:"id"`
+	CreatedBy    string         `json:"created_by" db:"created_by"`
+	Message      string         `json:"message" db:"message"`
+	Channels     StringArray    `json:"channels" db:"channels"` // "push" | "sms"
+	Geofence     Geofence       `json:"geofence" db:"geofence"`
+	AudienceSize int            `json:"audience_size" db:"audience_size"`
+	Status       string         `json:"status" db:"status"` // sending | completed | aborted
+	Stats        map[string]int `json:"stats,omitempty" db:"-"`
+	CreatedAt    time.Time      `json:"created_at" db:"created_at"`
+	CompletedAt  *time.Time     `json:"completed_at,omitempty" db:"completed_at"`
+}
+
+const (
+	BroadcastStatusSending   = "sending"
+	BroadcastStatusCompleted = "completed"
+	BroadcastStatusAborted   = "aborted"
+)
+
+// BroadcastDelivery is one queued broadcast message to one user on one channel
+type BroadcastDelivery struct {
+	ID          uuid.UUID  `json:"id" db:"id"`
+	BroadcastID uuid.UUID  `json:"broadcast_id" db:"broadcast_id"`
+	UserID      uuid.UUID  `json:"user_id" db:"user_id"`
+	Channel     string     `json:"channel" db:"channel"`
+	Status      string     `json:"status" db:"status"` // queued | sent | failed | aborted
+	Error       string     `json:"error" db:"error"`
+	CreatedAt   time.Time  `json:"created_at" db:"created_at"`
+	SentAt      *time.Time `json:"sent_at,omitempty" db:"sent_at"`
+
+	// Resolved at send time
+	Phone    string `json:"-" db:"-"`
+	FCMToken string `json:"-" db:"-"`
+}
+
+// AuditEvent records who read or changed sensitive data
+type AuditEvent struct {
+	ID            uuid.UUID              `json:"id" db:"id"`
+	ActorID       string                 `json:"actor_id" db:"actor_id"`
+	ActorRole     string                 `json:"actor_role" db:"actor_role"` // user | contact | admin | anonymous
+	Action        string                 `json:"action" db:"action"`
+	ObjectType    string                 `json:"object_type" db:"object_type"`
+	ObjectID      string                 `json:"object_id" db:"object_id"`
+	SubjectUserID *uuid.UUID             `json:"subject_user_id,omitempty" db:"subject_user_id"` // whose data it was
+	RequestID     string                 `json:"request_id" db:"request_id"`
+	Metadata      map[string]interface{} `json:"metadata" db:"metadata"`
+	CreatedAt     time.Time              `json:"created_at" db:"created_at"`
+}
+
+// ScoreRecord is one stored evaluation result
+type ScoreRecord struct {
+	ID            int64          `json:"-" db:"id"`
+	UserID        uuid.UUID      `json:"user_id" db:"user_id"`
+	EvaluatedAt   time.Time      `json:"evaluated_at" db:"evaluated_at"`
+	State         string         `json:"state" db:"state"`
+	Score         int            `json:"score" db:"score"`
+	TrendPenalty  int            `json:"trend_penalty,omitempty" db:"trend_penalty"`
+	Deterministic bool           `json:"deterministic" db:"deterministic"`
+	Breakdown     map[string]int `json:"breakdown,omitempty" db:"breakdown"`
+	Reason        string         `json:"reason" db:"reason"`
+}
+
+// What caused a state transition
+const (
+	TriggeredByHeartbeat = "heartbeat" // an evaluation with a new heartbeat
+	TriggeredByMonitor   = "monitor"   // an evaluation without one, e.g. a watch or pause
+	TriggeredByPanic     = "panic"
+	TriggeredByImpact    = "impact"
+)
+
+// StateTransition is one change of a user's safety state (stored in Postgres)
+type StateTransition struct {
+	ID          int64     `json:"id" db:"id"`
+	UserID      uuid.UUID `json:"user_id" db:"user_id"`
+	FromState   *string   `json:"from_state" db:"from_state"` // nil for the first recorded state
+	ToState     string    `json:"to_state" db:"to_state"`
+	Score       int       `json:"score" db:"score"`
+	Reason      string    `json:"reason" db:"reason"`
+	TriggeredBy string    `json:"triggered_by" db:"triggered_by"`
+	Timestamp   time.Time `json:"timestamp" db:"timestamp"`
+}
+
+// DailySummary is what a user's devices reported over one local day, sent to
+// them so they can see the service is working (stored in Postgres)
+type DailySummary struct {
+	UserID             uuid.UUID          `json:"user_id" db:"user_id"`
+	Date               string             `json:"date" db:"date"`         // local day, YYYY-MM-DD
+	Timezone           string             `json:"timezone" db:"timezone"` // IANA name the day was cut in
+	Heartbeats         int                `json:"heartbeats" db:"heartbeats"`
+	HeartbeatsBySource map[string]int     `json:"heartbeats_by_source" db:"heartbeats_by_source"`
+	DistanceM          float64            `json:"distance_m" db:"distance_m"`
+	MinBatteryPct      *int               `json:"min_battery_pct,omitempty" db:"min_battery_pct"`
+	Episodes           []SummaryEpisode   `json:"episodes" db:"episodes"`
+	GeofenceEvents     []GeofenceActivity `json:"geofence_events" db:"geofence_events"`
+	CreatedAt          time.Time          `json:"created_at" db:"created_at"`
+	NotifiedAt         *time.Time         `json:"notified_at,omitempty" db:"notified_at"`
+}
+
+// DailySummaryCandidate is a recently active user the summary worker may
+// owe a summary
+type DailySummaryCandidate struct {
+	UserID   uuid.UUID
+	OrgID    *uuid.UUID
+	Phone    string
+	Settings UserSettings
+	LastDate string // latest summarized local day, YYYY-MM-DD; empty if none
+}
+
+// SummaryEpisode is a stretch of the day the user spent outside SAFE
+type SummaryEpisode struct {
+	State      string     `json:"state"` // the most severe state reached
+	StartedAt  time.Time  `json:"started_at"`
+	ResolvedAt *time.Time `json:"resolved_at,omitempty"` // back to SAFE; nil if still open when summarized
+}
+
+// GeofenceActivity counts the times the user entered and left one of their
+// safe zones, by its index in settings.safe_zones
+type GeofenceActivity struct {
+	Zone    int `json:"zone"`
+	Entries int `json:"entries"`
+	Exits   int `json:"exits"`
+}
+
+// Scoring profile statuses. At most one profile is active and one a candidate.
+const (
+	ScoringProfileActive    = "active"
+	ScoringProfileCandidate = "candidate"
+	ScoringProfileRetired   = "retired"
+)
+
+// StoredScoringProfile is a scoring profile kept in the database. Profile is
+// the JSON of a services.ScoringProfile.
+type StoredScoringProfile struct {
+	ID         uuid.UUID       `json:"id" db:"id"`
+	Status     string          `json:"status" db:"status"`
+	Profile    json.RawMessage `json:"profile" db:"profile"`
+	Note       string          `json:"note,omitempty" db:"note"`
+	CreatedBy  string          `json:"created_by" db:"created_by"`
+	CreatedAt  time.Time       `json:"created_at" db:"created_at"`
+	PromotedAt *time.Time      `json:"promoted_at,omitempty" db:"promoted_at"`
+	RetiredAt  *time.Time      `json:"retired_at,omitempty" db:"retired_at"`
+}
+
+// ShadowResult is the candidate profile's verdict next to the active one's
+// for one evaluation
+type ShadowResult struct {
+	CandidateID     uuid.UUID `json:"candidate_id" db:"candidate_id"`
+	UserID          uuid.UUID `json:"user_id" db:"user_id"`
+	EvaluatedAt     time.Time `json:"evaluated_at" db:"evaluated_at"`
+	ActiveState     string    `json:"active_state" db:"active_state"`
+	ActiveScore     int       `json:"active_score" db:"active_score"`
+	ActiveReason    string    `json:"active_reason" db:"active_reason"`
+	CandidateState  string    `json:"candidate_state" db:"candidate_state"`
+	CandidateScore  int       `json:"candidate_score" db:"candidate_score"`
+	CandidateReason string    `json:"candidate_reason" db:"candidate_reason"`
+	CandidateRules  []string  `json:"candidate_rules,omitempty" db:"candidate_rules"`
+	Diverged        bool      `json:"diverged" db:"diverged"` // the states differ
+	// The feature flags both evaluations ran with
+	Flags map[string]bool `json:"flags,omitempty" db:"flags"`
+}
+
+// ShadowTransition counts evaluations where the candidate reached a
+// different state than the active profile
+type ShadowTransition struct {
+	ActiveState    string `json:"active_state"`
+	CandidateState string `json:"candidate_state"`
+	Evaluations    int    `json:"evaluations"`
+	Users          int    `json:"users"`
+}
+
+// ShadowDiff summarizes where a candidate and the active profile disagreed
+// over a time window
+type ShadowDiff struct {
+	Evaluations         int                `json:"evaluations"`
+	Users               int                `json:"users"`
+	DivergedEvaluations int                `json:"diverged_evaluations"`
+	UsersFlipped        int                `json:"users_flipped"`     // users whose state the candidate would have changed at least once
+	WouldAlertUsers     int                `json:"would_alert_users"` // users the candidate puts AT_RISK or worse where the active profile didn't
+	WouldSpareUsers     int                `json:"would_spare_users"` // users the active profile put AT_RISK or worse and the candidate didn't
+	ScoreDeltaMean      float64            `json:"score_delta_mean"`  // candidate minus active
+	ScoreDeltaMin       int                `json:"score_delta_min"`
+	ScoreDeltaMax       int                `json:"score_delta_max"`
+	Transitions         []ShadowTransition `json:"transitions"`
+	Samples             []ShadowResult     `json:"samples"` // the latest divergence of some flipped users
+}
+
+// AuditFilter narrows audit event queries; zero values match everything
+type AuditFilter struct {
+	ActorID       string
+	SubjectUserID *uuid.UUID
+	OrgID         *uuid.UUID // events about the organization and its current members
+	Action        string
+	ObjectType    string
+	From          *time.Time
+	To            *time.Time
+}
+
+// Account link statuses
+const (
+	LinkStatusPending = "pending"
+	LinkStatusActive  = "active"
+	LinkStatusRevoked = "revoked"
+)
+
+// Account link permissions beyond the read-only access every active link grants
+const (
+	LinkPermissionAlerts   = "alerts"   // guardian receives the ward's alerts
+	LinkPermissionTracking = "tracking" // guardian may start and stop tracking remotely
+)
+
+// AccountLink gives a guardian read access to a ward's account once the ward accepts
+type AccountLink struct {
+	RedisPayload
+
+	ID             uuid.UUID  `json:"id" db:"id"`
+	GuardianUserID uuid.UUID  `json:"guardian_user_id" db:"guardian_user_id"`
+	WardUserID     uuid.UUID  `json:"ward_user_id" db:"ward_user_id"`
+	Permissions    []string   `json:"permissions" db:"permissions"`
+	Status         string     `json:"status" db:"status"` // pending | active | revoked
+	CreatedAt      time.Time  `json:"created_at" db:"created_at"`
+	AcceptedAt     *time.Time `json:"accepted_at,omitempty" db:"accepted_at"`
+	RevokedAt      *time.Time `json:"revoked_at,omitempty" db:"revoked_at"`
+}
+
+// Allows reports whether the link is active and grants the permission
+func (l *AccountLink) Allows(permission string) bool {
+	if l == nil || l.Status != LinkStatusActive {
+		return false
+	}
+	for _, p := range l.Permissions {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// Guardian notice rules, as recorded on a notice
+const (
+	GuardianRuleSilent     = "silent"
+	GuardianRuleStationary = "stationary"
+	GuardianRuleDigest     = "digest"
+)
+
+// Channels guardian notices are sent on
+const (
+	GuardianChannelPush = "push" // falls back to SMS when the guardian has no push token
+	GuardianChannelSMS  = "sms"
+)
+
+// GuardianRules are the notices a guardian gets about a ward on an account
+// link, for anomalies smaller than alerts. A nil threshold leaves its rule
+// off. The notified fields record the episode each rule last notified about
+// and when, so an episode is notified once.
+type GuardianRules struct {
+	LinkID               uuid.UUID  `json:"link_id"`
+	MaxSilentHours       *int       `json:"max_silent_hours"`     // the ward's phone hasn't reported for this long
+	MaxStationaryHours   *int       `json:"max_stationary_hours"` // the ward hasn't moved for this long
+	DailyDigest          bool       `json:"daily_digest"`         // an evening "all good" when nothing happened
+	Channel              string     `json:"channel"`              // push | sms
+	SilentEpisode        *time.Time `json:"-"`                    // the last heartbeat before the silence notified
+	SilentNotifiedAt     *time.Time `json:"silent_notified_at,omitempty"`
+	StationaryEpisode    *time.Time `json:"-"` // when the stillness notified began
+	StationaryNotifiedAt *time.Time `json:"stationary_notified_at,omitempty"`
+	DigestSentOn         string     `json:"digest_sent_on,omitempty"` // the ward's local day, YYYY-MM-DD
+	CreatedAt            time.Time  `json:"created_at"`
+	UpdatedAt            time.Time  `json:"updated_at"`
+}
+
+// GuardianRuleTarget is a rule set the guardian notice worker evaluates,
+// with the link, the ward and the guardian it needs
+type GuardianRuleTarget struct {
+	GuardianRules
+	GuardianUserID uuid.UUID
+	GuardianPhone  string
+	WardUserID     uuid.UUID
+	WardName       string
+	WardSettings   UserSettings
+}
+
+// Consent scopes: what the user agreed SafeTrace may do with their data
+const (
+	ConsentTracking           = "tracking"            // continuous background location tracking
+	ConsentAudio              = "audio"               // sharing audio with contacts during an alert
+	ConsentCommunityBroadcast = "community_broadcast" // receiving safety broadcasts sent to where they are
+	ConsentResponderSharing   = "responder_sharing"   // showing their alerts to partner responders nearby
+	// storing their precise location during an incident while in coarse location mode
+	ConsentPrecisionEscalation = "precision_escalation"
+)
+
+// ConsentScopes are all consent scopes, in the order they are listed
+var ConsentScopes = []string{ConsentTracking, ConsentAudio, ConsentCommunityBroadcast, ConsentResponderSharing, ConsentPrecisionEscalation}
+
+// Consent is the user's agreement to one scope under one version of the
+// privacy policy, with what their client reported when they gave it. A
+// consent stays on record after it is revoked or superseded, by a new grant
+// or by a policy version that asks for consent again.
+type Consent struct {
+	ID            uuid.UUID  `json:"id" db:"id"`
+	UserID        uuid.UUID  `json:"user_id" db:"user_id"`
+	Scope         string     `json:"scope" db:"scope"`
+	PolicyVersion int        `json:"policy_version" db:"policy_version"`
+	GrantedAt     time.Time  `json:"granted_at" db:"granted_at"`
+	RevokedAt     *time.Time `json:"revoked_at,omitempty" db:"revoked_at"`
+	SupersededAt  *time.Time `json:"superseded_at,omitempty" db:"superseded_at"`
+	ClientIP      string     `json:"client_ip,omitempty" db:"client_ip"`
+	UserAgent     string     `json:"user_agent,omitempty" db:"user_agent"`
+}
+
+// Who ended a protection pause
+const (
+	PauseResumedByUser   = "user"
+	PauseResumedByExpiry = "expiry"
+)
+
+// ProtectionPause is a user-requested break in monitoring that ends on its own
+type ProtectionPause struct {
+	ID          uuid.UUID  `json:"id" db:"id"`
+	UserID      uuid.UUID  `json:"user_id" db:"user_id"`
+	Reason      string     `json:"reason,omitempty" db:"reason"`
+	PausedAt    time.Time  `json:"paused_at" db:"paused_at"`
+	PausedUntil time.Time  `json:"paused_until" db:"paused_until"`
+	ResumedAt   *time.Time `json:"resumed_at,omitempty" db:"resumed_at"`
+	ResumedBy   *string    `json:"resumed_by,omitempty" db:"resumed_by"` // user | expiry
+}
+
+// How a watch session ended
+const (
+	WatchEndedByCompleted = "completed" // the user confirmed they arrived
+	WatchEndedByExpiry    = "expiry"
+)
+
+// WatchSession is a user-requested period of closer monitoring, e.g. while
+// walking through a risky area. It ends when the user confirms they arrived
+// or when it runs out.
+type WatchSession struct {
+	ID          uuid.UUID  `json:"id" db:"id"`
+	UserID      uuid.UUID  `json:"user_id" db:"user_id"`
+	Note        string     `json:"note,omitempty" db:"note"`
+	StartedAt   time.Time  `json:"started_at" db:"started_at"`
+	EndsAt      time.Time  `json:"ends_at" db:"ends_at"`
+	EndedAt     *time.Time `json:"ended_at,omitempty" db:"ended_at"`
+	EndedBy     *string    `json:"ended_by,omitempty" db:"ended_by"`     // completed | expiry
+	LastState   *string    `json:"last_state,omitempty" db:"last_state"` // state when it expired
+	AlertRaised bool       `json:"alert_raised" db:"alert_raised"`
+}
+
+// Who asked for a welfare check
+const (
+	WelfareRequesterContact  = "contact"
+	WelfareRequesterGuardian = "guardian"
+)
+
+// Welfare check statuses
+const (
+	WelfareCheckPending    = "pending"    // waiting for the user to confirm
+	WelfareCheckConfirmed  = "confirmed"  // the user confirmed they are okay
+	WelfareCheckUnanswered = "unanswered" // no confirmation in time; the user was re-evaluated
+)
+
+// WelfareCheck is a trusted contact's or guardian's request that SafeTrace
+// check on the user. The user is asked to confirm they are okay; if they
+// don't in time they are evaluated more strictly and the requester is told
+// their latest status.
+type WelfareCheck struct {
+	ID             uuid.UUID  `json:"id" db:"id"`
+	UserID         uuid.UUID  `json:"user_id" db:"user_id"`
+	RequesterType  string     `json:"requester_type" db:"requester_type"` // contact | guardian
+	RequesterID    string     `json:"requester_id" db:"requester_id"`     // contact ID or guardian user ID
+	RequesterName  string     `json:"requester_name" db:"requester_name"`
+	RequesterPhone string     `json:"-" db:"requester_phone"`
+	RequestedAt    time.Time  `json:"requested_at" db:"requested_at"`
+	RespondBy      time.Time  `json:"respond_by" db:"respond_by"`
+	Status         string     `json:"status" db:"status"` // pending | confirmed | unanswered
+	ResolvedAt     *time.Time `json:"resolved_at,omitempty" db:"resolved_at"`
+	OutcomeState   *string    `json:"outcome_state,omitempty" db:"outcome_state"` // state after an unanswered check
+	OutcomeScore   *int       `json:"outcome_score,omitempty" db:"outcome_score"`
+}
+
+// Check-in prompt outcomes
+const (
+	PromptAnswered  = "answered"  // the user answered, or a heartbeat brought them out of CAUTION
+	PromptEscalated = "escalated" // every attempt went unanswered and the user was moved to AT_RISK
+	PromptCleared   = "cleared"   // the user left CAUTION some other way, e.g. an alert or a pause
+)
+
+// How a check-in prompt was answered
+const (
+	PromptViaApp       = "app"
+	PromptViaSMS       = "sms"
+	PromptViaHeartbeat = "heartbeat"
+)
+
+// SilentPrompt is a finished check-in prompt: the ladder of channels it
+// tried at CAUTION and which attempt, if any, the user answered
+type SilentPrompt struct {
+	ID              uuid.UUID `json:"id" db:"id"`
+	UserID          uuid.UUID `json:"user_id" db:"user_id"`
+	Ladder          []string  `json:"ladder" db:"ladder"`
+	TimeoutSeconds  int       `json:"timeout_seconds" db:"timeout_seconds"` // each attempt's wait
+	AttemptsSent    int       `json:"attempts_sent" db:"attempts_sent"`
+	Outcome         string    `json:"outcome" db:"outcome"`                             // answered | escalated | cleared
+	AnsweredAttempt *int      `json:"answered_attempt,omitempty" db:"answered_attempt"` // 1-based; the latest attempt sent before the answer
+	AnsweredChannel *string   `json:"answered_channel,omitempty" db:"answered_channel"` // that attempt's channel
+	AnsweredVia     *string   `json:"answered_via,omitempty" db:"answered_via"`         // app | sms | heartbeat
+	StartedAt       time.Time `json:"started_at" db:"started_at"`
+	EndedAt         time.Time `json:"ended_at" db:"ended_at"`
+}
+
+// PromptSession is a check-in prompt in progress, kept in Redis until it is
+// answered, cleared or escalated
+type PromptSession struct {
+	ID        uuid.UUID
+	UserID    uuid.UUID
+	Ladder    []string
+	Timeout   time.Duration // each attempt's wait for an answer
+	StartedAt time.Time
+	Sent      int       // attempts sent so far
+	Due       time.Time // when the next attempt is sent, or the prompt escalates once all were
+}
+
+// Deadline is when the prompt escalates if nothing answers it
+func (p *PromptSession) Deadline() time.Time {
+	return p.Due.Add(time.Duration(len(p.Ladder)-p.Sent) * p.Timeout)
+}
+
+// SilentPromptStat counts finished prompts by outcome and, for answered
+// ones, by the attempt and channel that got the answer
+type SilentPromptStat struct {
+	Outcome string `json:"outcome"`
+	Attempt int    `json:"attempt,omitempty"`
+	Channel string `json:"channel,omitempty"`
+	Count   int    `json:"count"`
+}
+
+// Organization runs SafeTrace for its members, e.g. a university for its students
+type Organization struct {
+	ID                 uuid.UUID       `json:"id" db:"id"`
+	Name               string          `json:"name" db:"name"`
+	DefaultSettings    json.RawMessage `json:"default_settings" db:"default_settings"`         // partial UserSettings applied when a member joins
+	EscalationContacts TrustedContacts `json:"escalation_contacts" db:"escalation_contacts"`   // alerted with every member's own contacts
+	ScoringProfile     json.RawMessage `json:"scoring_profile,omitempty" db:"scoring_profile"` // fields overriding the active scoring profile for members
+	CreatedAt          time.Time       `json:"created_at" db:"created_at"`
+	UpdatedAt          time.Time       `json:"updated_at" db:"updated_at"`
+}
+
+// OrgInvitation invites a phone number to join an organization
+type OrgInvitation struct {
+	ID         uuid.UUID  `json:"id" db:"id"`
+	OrgID      uuid.UUID  `json:"org_id" db:"org_id"`
+	OrgName    string     `json:"org_name,omitempty" db:"-"`
+	Phone      string     `json:"phone" db:"phone"`
+	InvitedBy  string     `json:"invited_by" db:"invited_by"`
+	CreatedAt  time.Time  `json:"created_at" db:"created_at"`
+	ExpiresAt  time.Time  `json:"expires_at" db:"expires_at"`
+	AcceptedAt *time.Time `json:"accepted_at,omitempty" db:"accepted_at"`
+	AcceptedBy *uuid.UUID `json:"accepted_by,omitempty" db:"accepted_by"`
+}
+
+// OrgMember is a member as listed to the organization's admins
+type OrgMember struct {
+	ID    uuid.UUID `json:"id" db:"id"`
+	Name  string    `json:"name" db:"name"`
+	Phone string    `json:"phone" db:"phone"`
+}
+
+// ActiveAlert is an unresolved alert with the member it was raised for, as
+// listed to admins
+type ActiveAlert struct {
+	Alert
+	UserName  string `json:"user_name"`
+	UserPhone string `json:"user_phone"`
+}
+
+// AdminStats summarizes users and alerts for an admin, over [From, To]
+type AdminStats struct {
+	OrgID      *uuid.UUID     `json:"org_id,omitempty"` // nil when covering every user
+	From       time.Time      `json:"from"`
+	To         time.Time      `json:"to"`
+	Users      int            `json:"users"`
+	Alerts     map[string]int `json:"alerts"` // raised in the range, by state
+	OpenAlerts int            `json:"open_alerts"`
+	LastGasps  int            `json:"last_gasps"`
+
+	OutboundSMS *OutboundSMSStats `json:"outbound_sms,omitempty"` // today's, from Redis; left out if unavailable
+}
+
+// OutboundSMSStats counts the SMS sent on users' behalf on one day (Lagos
+// time), with the users who cost the most, so cost anomalies are visible
+type OutboundSMSStats struct {
+	Date     string         `json:"date"` // YYYY-MM-DD
+	Total    int64          `json:"total"`
+	TopUsers []UserSMSCount `json:"top_users"`
+}
+
+// OutboundSpend is one day's (Lagos time) outbound SMS and WhatsApp messages
+// against the global budget, by message type
+
+type OutboundSpend struct {
+	Date                  string                       `json:"date"` // YYYY-MM-DD
+	SentThisMinute        int64                        `json:"sent_this_minute"`
+	SentToday             int64                        `json:"sent_today"`
+	PerMinute             int                          `json:"per_minute"` // caps in effect, raised ones included
+	PerDay                int                          `json:"per_day"`
+	EmergencyReservePct   int                          `json:"emergency_reserve_pct"`   // share of each cap only emergency messages may use
+	EmergencyOverdraftPct int                          `json:"emergency_overdraft_pct"` // share of each cap emergency messages may use past it
+	Raised                *OutboundLimitOverride       `json:"raised,omitempty"`
+	ByType                map[string]OutboundTypeCount `json:"by_type"`
+}
+
+// OutboundTypeCount is how many messages of one type were sent, and how many
+// were dropped for the budget
+type OutboundTypeCount struct {
+	Sent    int64 `json:"sent"`
+	Dropped int64 `json:"dropped"`
+}
+
+// OutboundLimitOverride raises the outbound message caps until it expires
+type OutboundLimitOverride struct {
+	PerMinute int       `json:"per_minute"`
+	PerDay    int       `json:"per_day"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// UserSMSCount is how many SMS were sent on a user's behalf
+type UserSMSCount struct {
+	UserID uuid.UUID `json:"user_id"`
+	Count  int64     `json:"count"`
+}
+
+// NotificationChannel is a team chat or webhook destination that receives a
+// user's alerts alongside SMS, e.g. a campus security Slack channel
+type NotificationChannel struct {
+	ID                  uuid.UUID  `json:"id" db:"id"`
+	UserID              uuid.UUID  `json:"user_id" db:"user_id"`
+	Type                string     `json:"type" db:"type"` // slack | teams | generic_webhook
+	Name                string     `json:"name" db:"name"`
+	WebhookURL          string     `json:"-" db:"webhook_url"` // a credential; never returned
+	Enabled             bool       `json:"enabled" db:"enabled"`
+	ConsecutiveFailures int        `json:"consecutive_failures" db:"consecutive_failures"`
+	DisabledReason      string     `json:"disabled_reason,omitempty" db:"disabled_reason"`
+	LastDeliveryAt      *time.Time `json:"last_delivery_at,omitempty" db:"last_delivery_at"`
+	LastError           string     `json:"last_error,omitempty" db:"last_error"`
+	CreatedAt           time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt           time.Time  `json:"updated_at" db:"updated_at"`
+}
+
+// Notification channel types
+const (
+	ChannelTypeSlack   = "slack"
+	ChannelTypeTeams   = "teams"
+	ChannelTypeWebhook = "generic_webhook"
+)
+
+// WebhookHost is the host of the webhook URL, safe to show in place of the URL
+func (ch *NotificationChannel) WebhookHost() string {
+	u, err := url.Parse(ch.WebhookURL)
+	if err != nil {
+		return ""
+	}
+	return u.Host
+}
+
+// Panic request statuses
+const (
+	PanicPending    = "pending"    // inside the cancellation window
+	PanicCancelled  = "cancelled"  // the user cancelled it with their PIN
+	PanicDispatched = "dispatched" // the alert was raised
+)
+
+// How a panic request left the cancellation window
+const (
+	PanicResolutionCancelled = "cancelled" // the user's PIN
+	PanicResolutionConfirmed = "confirmed" // the user asked for it to be sent now
+	PanicResolutionWrongPIN  = "wrong_pin"
+	PanicResolutionDuress    = "duress"    // the duress PIN; looks cancelled to the user
+	PanicResolutionExpired   = "expired"   // the window ran out
+	PanicResolutionImmediate = "immediate" // no window: disabled, or the user has no PIN to cancel with
+)
+
+// PanicRequest is a panic raised from the app, held for the cancellation
+// window before the alert is sent. The resolution is never returned, since
+// it would tell a duress cancellation from a real one.
+type PanicRequest struct {
+	ID         uuid.UUID  `json:"id" db:"id"`
+	UserID     uuid.UUID  `json:"user_id" db:"user_id"`
+	CreatedAt  time.Time  `json:"created_at" db:"created_at"`
+	ReleaseAt  time.Time  `json:"release_at" db:"release_at"` // when the alert is sent unless cancelled
+	Status     string     `json:"status" db:"status"`         // pending | cancelled | dispatched
+	Resolution *string    `json:"-" db:"resolution"`
+	ResolvedAt *time.Time `json:"resolved_at,omitempty" db:"resolved_at"`
+	Note       string     `json:"note,omitempty" db:"note"` // quoted in the alert, see services.CleanAlertNote
+}
+
+// PanicPINs are the hashes of a user's panic PINs; empty when not set
+type PanicPINs struct {
+	PIN    string
+	Duress string
+}
+
+// ResponderKey is the API key of a partner responder organization. It only
+// ever sees alerts inside its operating area, a polygon geofence. The key
+// itself is shown once when issued; KeyPrefix identifies it afterwards.
+type ResponderKey struct {
+	ID            uuid.UUID  `json:"id" db:"id"`
+	Organization  string     `json:"organization" db:"organization"`
+	KeyPrefix     string     `json:"key_prefix" db:"key_prefix"`
+	OperatingArea Geofence   `json:"operating_area" db:"operating_area"`
+	CreatedAt     time.Time  `json:"created_at" db:"created_at"`
+	LastUsedAt    *time.Time `json:"last_used_at,omitempty" db:"last_used_at"`
+	RevokedAt     *time.Time `json:"revoked_at,omitempty" db:"revoked_at"`
+}
+
+// ResponderAlertQuery finds unresolved alerts raised since Since whose user
+// was last seen within RadiusM of a point and inside the key's operating
+// area bounds. The polygon itself is checked by the caller.
+type ResponderAlertQuery struct {
+	KeyID   uuid.UUID
+	Lat     float64
+	Lng     float64
+	RadiusM float64
+	Since   time.Time
+	MinLat  float64
+	MaxLat  float64
+	MinLng  float64
+	MaxLng  float64
+	Limit   int // most candidates read
+
+	// ConsentVersion is the responder_sharing policy version users must have
+	// consented to for their alerts to be found; 0 when none is required
+	ConsentVersion int
+}
+
+// ResponderAlert is an unresolved alert as a responder query finds it, at
+// the user's last known position
+type ResponderAlert struct {
+	AlertID        uuid.UUID
+	UserID         uuid.UUID
+	State          AlertState
+	CreatedAt      time.Time
+	Lat            float64
+	Lng            float64
+	LocatedAt      time.Time  // timestamp of the heartbeat the position came from
+	Precise        bool       // the user lets responders see their exact position
+	AcknowledgedAt *time.Time // when this key acknowledged it
+}
+
+// JobRun is one run of a scheduled job, as the scheduler records it
+type JobRun struct {
+	Name      string
+	Schedule  string // e.g. "every 1m0s" or "cron 0 6 * * *"
+	Singleton bool
+	StartedAt time.Time
+	Duration  time.Duration
+	Error     string // empty when the run succeeded
+	Instance  string
+	NextRunAt time.Time
+}
+
+// JobStatus is the recorded status of a scheduled job
+type JobStatus struct {
+	Name           string     `json:"name"`
+	Schedule       string     `json:"schedule"`
+	Singleton      bool       `json:"singleton"`
+	LastStartedAt  *time.Time `json:"last_started_at"`
+	LastDurationMs *int64     `json:"last_duration_ms"`
+	LastError      string     `json:"last_error,omitempty"`
+	LastInstance   string     `json:"last_instance,omitempty"`
+	NextRunAt      *time.Time `json:"next_run_at"`
+	Runs           int64      `json:"runs"`
+	Failures       int64      `json:"failures"`
 }
@@ -0,0 +1,83 @@
+// Package params parses UUID route parameters once, in route middleware, so
+// handlers read typed values instead of repeating uuid.Parse and its 400.
+package params
+
+import (
+	"fmt"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/apierror"
+)
+
+// Route parameter names. A user is always :user_id, whatever the route.
+const (
+	User       = "user_id"
+	Contact    = "contact_id"
+	Channel    = "channel_id"
+	Alert      = "alert_id"
+	Link       = "link_id"
+	Broadcast  = "broadcast_id"
+	Org        = "org_id"
+	Invitation = "invitation_id"
+	Panic      = "panic_id"
+	Responder  = "key_id"
+	Bundle     = "bundle_id"
+	Report     = "report_id"
+)
+
+const keyPrefix = "params."
+
+// UUID parses the named route parameters and stores them for Get. A missing
+// or malformed one aborts the request with 400 naming the parameter; the
+// error envelope is written by middleware.ErrorHandler.
+func UUID(names ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		for _, name := range names {
+			raw := c.Param(name)
+			if raw == "" {
+				abort(c, apierror.Invalid(name, "is required"))
+				return
+			}
+			id, err := uuid.Parse(raw)
+			if err != nil {
+				abort(c, apierror.Invalid(name, "must be a valid UUID"))
+				return
+			}
+			c.Set(keyPrefix+name, id)
+		}
+		c.Next()
+	}
+}
+
+// Get returns a parameter parsed by UUID. It panics if the route does not
+// declare it, which is a wiring mistake rather than a bad request.
+func Get(c *gin.Context, name string) uuid.UUID {
+	v, ok := c.Get(keyPrefix + name)
+	if !ok {
+		panic(fmt.Sprintf("params: route %s does not parse %q", c.FullPath(), name))
+	}
+	return v.(uuid.UUID)
+}
+
+// Lookup returns a parameter parsed by UUID and whether the route declares it
+func Lookup(c *gin.Context, name string) (uuid.UUID, bool) {
+	v, ok := c.Get(keyPrefix + name)
+	if !ok {
+		return uuid.Nil, false
+	}
+	return v.(uuid.UUID), true
+}
+
+// UserID returns the :user_id parameter
+func UserID(c *gin.Context) uuid.UUID {
+	return Get(c, User)
+}
+
+// abort mirrors middleware.AbortWithError, which this package can't import
+// since the auth middleware builds on it
+func abort(c *gin.Context, err error) {
+	_ = c.Error(err)
+	c.Abort()
+}
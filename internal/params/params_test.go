@@ -0,0 +1,120 @@
+package params
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/apierror"
+)
+
+// serve routes path through UUID(names...) and returns the error the request
+// was aborted with, if any, and the IDs the handler read
+func serve(t *testing.T, route, path string, names ...string) (*apierror.Error, map[string]uuid.UUID) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	var abortErr *apierror.Error
+	router.Use(func(c *gin.Context) {
+		c.Next()
+		if len(c.Errors) > 0 {
+			abortErr = apierror.From(c.Errors.Last().Err)
+		}
+	})
+	var got map[string]uuid.UUID
+	router.GET(route, UUID(names...), func(c *gin.Context) {
+		got = make(map[string]uuid.UUID)
+		for _, name := range names {
+			got[name] = Get(c, name)
+		}
+		c.Status(http.StatusNoContent)
+	})
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	return abortErr, got
+}
+
+func TestUUID(t *testing.T) {
+	userID, contactID := uuid.New(), uuid.New()
+	tests := []struct {
+		name      string
+		route     string
+		path      string
+		names     []string
+		wantField string
+		wantWhy   string
+	}{
+		{"parsed", "/user/:user_id/contacts/:contact_id", "/user/" + userID.String() + "/contacts/" + contactID.String(),
+			[]string{User, Contact}, "", ""},
+		{"uppercase", "/user/:user_id", "/user/" + strings.ToUpper(userID.String()), []string{User}, "", ""},
+		{"malformed", "/user/:user_id", "/user/not-a-uuid", []string{User}, User, "must be a valid UUID"},
+		{"truncated", "/user/:user_id", "/user/" + userID.String()[:35], []string{User}, User, "must be a valid UUID"},
+		{"second malformed", "/user/:user_id/contacts/:contact_id", "/user/" + userID.String() + "/contacts/42",
+			[]string{User, Contact}, Contact, "must be a valid UUID"},
+		{"not in the route", "/user/:user_id", "/user/" + userID.String(), []string{User, Contact}, Contact, "is required"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			abortErr, got := serve(t, tt.route, tt.path, tt.names...)
+			if tt.wantField == "" {
+				if abortErr != nil {
+					t.Fatalf("aborted with %v", abortErr)
+				}
+				if got[User] != userID || (len(tt.names) > 1 && got[Contact] != contactID) {
+					t.Errorf("handler read %v", got)
+				}
+				return
+			}
+			if got != nil {
+				t.Errorf("handler ran with %v", got)
+			}
+			if abortErr == nil || abortErr.Status != http.StatusBadRequest || abortErr.Code != apierror.CodeValidationFailed {
+				t.Fatalf("aborted with %v, want 400 validation_failed", abortErr)
+			}
+			want := []apierror.FieldError{{Field: tt.wantField, Reason: tt.wantWhy}}
+			if len(abortErr.Fields) != 1 || abortErr.Fields[0] != want[0] {
+				t.Errorf("fields = %+v, want %+v", abortErr.Fields, want)
+			}
+		})
+	}
+}
+
+func TestLookupAndGet(t *testing.T) {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+
+	if id, ok := Lookup(c, User); ok || id != uuid.Nil {
+		t.Errorf("Lookup() before parsing = %v, %v", id, ok)
+	}
+
+	userID := uuid.New()
+	c.Set(keyPrefix+User, userID)
+	if id, ok := Lookup(c, User); !ok || id != userID {
+		t.Errorf("Lookup() = %v, %v, want %v", id, ok, userID)
+	}
+	if UserID(c) != userID {
+		t.Errorf("UserID() = %v, want %v", UserID(c), userID)
+	}
+
+	// Reading a parameter the route never declared is a wiring mistake
+	defer func() {
+		if r := recover(); r == nil {
+			t.Errorf("Get() of an undeclared parameter didn't panic")
+		}
+	}()
+	Get(c, Contact)
+}
+
+// abort leaves the error for middleware.ErrorHandler and stops the chain
+func TestAbort(t *testing.T) {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	err := apierror.Invalid(User, "is required")
+	abort(c, err)
+	if !c.IsAborted() || len(c.Errors) != 1 || !errors.Is(c.Errors.Last().Err, err) {
+		t.Errorf("aborted %v with %v", c.IsAborted(), c.Errors)
+	}
+}
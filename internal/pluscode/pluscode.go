@@ -0,0 +1,268 @@
+// Package pluscode encodes and decodes Open Location Codes ("plus codes"),
+// which name a spot anywhere without a street address. It follows the
+// reference specification at github.com/google/open-location-code and works
+// offline.
+package pluscode
+
+import (
+	"errors"
+	"math"
+	"strings"
+)
+
+const (
+	// Separator follows the eighth digit of every code
+	Separator = '+'
+	// DefaultLength is a 10-digit code, a cell of about 14m by 14m
+	DefaultLength = pairCodeLength
+	// MinLength and MaxLength bound the digits in a code
+	MinLength = 2
+	MaxLength = 15
+
+	separatorPosition = 8
+	padding           = '0'
+	alphabet          = "23456789CFGHJMPQRVWX"
+	encodingBase      = 20
+
+	pairCodeLength = 10
+	gridCodeLength = MaxLength - pairCodeLength
+	gridRows       = 5
+	gridCols       = 4
+
+	latMax = 90
+	lngMax = 180
+
+	// pairPrecision is the inverse of the last pair digit's size, 20^3
+	pairPrecision = 8000
+	// pairFirstPlaceValue is the value of the first pair digit, 20^4
+	pairFirstPlaceValue = 160000
+	// gridLatFirstPlaceValue and gridLngFirstPlaceValue are the values of the
+	// first grid digit's row and column, gridRows^4 and gridCols^4
+	gridLatFirstPlaceValue = 625
+	gridLngFirstPlaceValue = 256
+	// finalLatPrecision and finalLngPrecision are the inverse of the size of
+	// a full-length code, in degrees
+	finalLatPrecision = pairPrecision * 3125 // gridRows^5
+	finalLngPrecision = pairPrecision * 1024 // gridCols^5
+)
+
+var (
+	// ErrInvalid is returned for a string that isn't a plus code
+	ErrInvalid = errors.New("not a valid plus code")
+	// ErrShort is returned for a valid code that names a spot only relative
+	// to a reference location, such as "CJ2V+" or "9QCJ+2V Lagos"
+	ErrShort = errors.New("not a full plus code")
+)
+
+// Area is the cell a code names
+type Area struct {
+	LatLo  float64 `json:"south"`
+	LngLo  float64 `json:"west"`
+	LatHi  float64 `json:"north"`
+	LngHi  float64 `json:"east"`
+	Length int     `json:"code_length"`
+}
+
+// Center returns the middle of the area, capped at the poles and the
+// antimeridian. It is rounded to 1e-9 degrees, well inside the smallest cell,
+// to drop floating point noise.
+func (a Area) Center() (lat, lng float64) {
+	lat = math.Min((a.LatLo+a.LatHi)/2, latMax)
+	lng = math.Min((a.LngLo+a.LngHi)/2, lngMax)
+	return math.Round(lat*1e9) / 1e9, math.Round(lng*1e9) / 1e9
+}
+
+// Encode returns the code of the given length for a location. Latitudes are
+// clipped to [-90, 90] and longitudes wrapped into [-180, 180). Lengths are
+// clamped to [MinLength, MaxLength], and below 10 rounded up to an even number.
+func Encode(lat, lng float64, length int) string {
+	switch {
+	case length < MinLength:
+		length = MinLength
+	case length > MaxLength:
+		length = MaxLength
+	case length < pairCodeLength && length%2 == 1:
+		length++
+	}
+
+	latVal := latitudeAsInteger(lat)
+	lngVal := longitudeAsInteger(lng)
+
+	var digits [MaxLength]byte
+	if length > pairCodeLength {
+		for i := MaxLength - 1; i >= pairCodeLength; i-- {
+			digits[i] = alphabet[(latVal%gridRows)*gridCols+lngVal%gridCols]
+			latVal /= gridRows
+			lngVal /= gridCols
+		}
+	} else {
+		latVal /= finalLatPrecision / pairPrecision
+		lngVal /= finalLngPrecision / pairPrecision
+	}
+	for i := pairCodeLength/2 - 1; i >= 0; i-- {
+		digits[2*i] = alphabet[latVal%encodingBase]
+		digits[2*i+1] = alphabet[lngVal%encodingBase]
+		latVal /= encodingBase
+		lngVal /= encodingBase
+	}
+
+	var b strings.Builder
+	b.Grow(length + 1)
+	if length < separatorPosition {
+		b.Write(digits[:length])
+		b.WriteString(strings.Repeat(string(padding), separatorPosition-length))
+		b.WriteByte(Separator)
+		return b.String()
+	}
+	b.Write(digits[:separatorPosition])
+	b.WriteByte(Separator)
+	b.Write(digits[separatorPosition:length])
+	return b.String()
+}
+
+// Decode returns the area a full code names. Letters may be in either case.
+func Decode(code string) (Area, error) {
+	if err := CheckFull(code); err != nil {
+		return Area{}, err
+	}
+
+	code = strings.ToUpper(code)
+	code = strings.ReplaceAll(code, string(Separator), "")
+	code = strings.TrimRight(code, string(padding))
+	if len(code) > MaxLength {
+		code = code[:MaxLength]
+	}
+
+	// Work in integers of the final precision so cell edges are exact
+	latVal := int64(-latMax * pairPrecision)
+	lngVal := int64(-lngMax * pairPrecision)
+	placeValue := int64(pairFirstPlaceValue)
+	pairDigits := len(code)
+	if pairDigits > pairCodeLength {
+		pairDigits = pairCodeLength
+	}
+	for i := 0; i < pairDigits; i += 2 {
+		latVal += int64(strings.IndexByte(alphabet, code[i])) * placeValue
+		lngVal += int64(strings.IndexByte(alphabet, code[i+1])) * placeValue
+		if i < pairDigits-2 {
+			placeValue /= encodingBase
+		}
+	}
+	latVal *= finalLatPrecision / pairPrecision
+	lngVal *= finalLngPrecision / pairPrecision
+	latSize := placeValue * (finalLatPrecision / pairPrecision)
+	lngSize := placeValue * (finalLngPrecision / pairPrecision)
+
+	if len(code) > pairCodeLength {
+		rowValue := int64(gridLatFirstPlaceValue)
+		colValue := int64(gridLngFirstPlaceValue)
+		for i := pairCodeLength; i < len(code); i++ {
+			d := int64(strings.IndexByte(alphabet, code[i]))
+			latVal += d / gridCols * rowValue
+			lngVal += d % gridCols * colValue
+			if i < len(code)-1 {
+				rowValue /= gridRows
+				colValue /= gridCols
+			}
+		}
+		latSize, lngSize = rowValue, colValue
+	}
+
+	return Area{
+		LatLo:  float64(latVal) / finalLatPrecision,
+		LngLo:  float64(lngVal) / finalLngPrecision,
+		LatHi:  float64(latVal+latSize) / finalLatPrecision,
+		LngHi:  float64(lngVal+lngSize) / finalLngPrecision,
+		Length: len(code),
+	}, nil
+}
+
+// CheckFull returns nil for a full code, ErrShort for a valid short code and
+// ErrInvalid otherwise
+func CheckFull(code string) error {
+	if !IsValid(code) {
+		return ErrInvalid
+	}
+	if strings.IndexByte(code, Separator) < separatorPosition {
+		return ErrShort
+	}
+	code = strings.ToUpper(code)
+	// The first digits can't take the location past the poles or the antimeridian
+	if int64(strings.IndexByte(alphabet, code[0]))*encodingBase >= latMax*2 {
+		return ErrInvalid
+	}
+	if len(code) > 1 && int64(strings.IndexByte(alphabet, code[1]))*encodingBase >= lngMax*2 {
+		return ErrInvalid
+	}
+	return nil
+}
+
+// IsValid reports whether code is a full or short plus code
+func IsValid(code string) bool {
+	if len(code) < 2 {
+		return false
+	}
+	code = strings.ToUpper(code)
+
+	sep := strings.IndexByte(code, Separator)
+	if sep < 0 || sep != strings.LastIndexByte(code, Separator) || sep > separatorPosition || sep%2 == 1 {
+		return false
+	}
+	// A single digit after the separator isn't allowed
+	if len(code)-sep-1 == 1 {
+		return false
+	}
+
+	if pad := strings.IndexByte(code, padding); pad >= 0 {
+		// Padding is only allowed in full codes, from an even position, in
+		// an even run that ends at the separator
+		if sep < separatorPosition || pad == 0 || pad%2 == 1 {
+			return false
+		}
+		run := strings.TrimRight(code[:sep], string(padding))
+		if len(run) != pad || (sep-pad)%2 == 1 {
+			return false
+		}
+		if len(code) > sep+1 {
+			return false
+		}
+	}
+
+	for i := 0; i < len(code); i++ {
+		c := code[i]
+		if c == Separator || c == padding {
+			continue
+		}
+		if strings.IndexByte(alphabet, c) < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// latitudeAsInteger converts a latitude to a positive integer in units of
+// the final precision, clipping it to the valid range
+func latitudeAsInteger(lat float64) int64 {
+	latVal := int64(math.Floor(lat * finalLatPrecision))
+	latVal += latMax * finalLatPrecision
+	if latVal < 0 {
+		return 0
+	}
+	if latVal >= 2*latMax*finalLatPrecision {
+		return 2*latMax*finalLatPrecision - 1
+	}
+	return latVal
+}
+
+// longitudeAsInteger converts a longitude to a positive integer in units of
+// the final precision, wrapping it into [-180, 180)
+func longitudeAsInteger(lng float64) int64 {
+	const full = 2 * lngMax * finalLngPrecision
+	lngVal := int64(math.Floor(lng * finalLngPrecision))
+	lngVal += lngMax * finalLngPrecision
+	lngVal %= full
+	if lngVal < 0 {
+		lngVal += full
+	}
+	return lngVal
+}
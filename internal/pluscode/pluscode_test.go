@@ -0,0 +1,143 @@
+package pluscode
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+// Vectors are from the reference test data of github.com/google/open-location-code
+// (encoding.csv, decoding.csv and validityTests.csv), plus two Nigerian
+// locations checked against an independent implementation of the spec
+
+func TestEncode(t *testing.T) {
+	tests := []struct {
+		lat, lng float64
+		length   int
+		want     string
+	}{
+		{20.375, 2.775, 6, "7FG49Q00+"},
+		{20.3700625, 2.7821875, 10, "7FG49QCJ+2V"},
+		{20.3701135, 2.78223535156, 13, "7FG49QCJ+2VXGJ"},
+		{47.0000625, 8.0000625, 10, "8FVC2222+22"},
+		{-41.2730625, 174.7859375, 10, "4VCPPQGP+Q9"},
+		{47.365590, 8.524997, 10, "8FVC9G8F+6X"},
+		{1.286785, 103.854503, 11, "6PH57VP3+PR6"},
+		{-89.9999375, -179.9999375, 10, "22222222+22"},
+		{0.5, 179.5, 4, "6VGX0000+"},
+		// Latitudes clip to the pole, longitudes wrap
+		{90, 1, 4, "CFX30000+"},
+		{92, 1, 4, "CFX30000+"},
+		{1, 181, 4, "62H30000+"},
+		// Lagos and Abuja
+		{6.5244, 3.3792, 10, "6FR5G9FH+QM"},
+		{6.5244, 3.3792, 11, "6FR5G9FH+QM8"},
+		{9.0579, 7.4951, 10, "6FX93F5W+52"},
+		{20.3701135, 2.78223535156, 15, "7FG49QCJ+2VXGJFH"},
+		// Odd lengths below 10 round up
+		{20.375, 2.775, 5, "7FG49Q00+"},
+	}
+	for _, tt := range tests {
+		if got := Encode(tt.lat, tt.lng, tt.length); got != tt.want {
+			t.Errorf("Encode(%v, %v, %d) = %s, want %s", tt.lat, tt.lng, tt.length, got, tt.want)
+		}
+	}
+	if long, max := Encode(20.3701135, 2.78223535156, 20), Encode(20.3701135, 2.78223535156, MaxLength); long != max {
+		t.Errorf("length 20 = %s, want clamped to %s", long, max)
+	}
+}
+
+func TestDecode(t *testing.T) {
+	tests := []struct {
+		code                       string
+		latLo, lngLo, latHi, lngHi float64
+		length                     int
+	}{
+		{"7FG49Q00+", 20.35, 2.75, 20.4, 2.8, 6},
+		{"7FG49QCJ+2V", 20.37, 2.782125, 20.370125, 2.78225, 10},
+		{"7fg49qcj+2v", 20.37, 2.782125, 20.370125, 2.78225, 10},
+		{"7FG49QCJ+2VX", 20.3701, 2.78221875, 20.370125, 2.78225, 11},
+		{"8FVC2222+22", 47.0, 8.0, 47.000125, 8.000125, 10},
+		{"22222222+22", -90, -180, -89.999875, -179.999875, 10},
+		{"CFX30000+", 89, 1, 90, 2, 4},
+	}
+	for _, tt := range tests {
+		area, err := Decode(tt.code)
+		if err != nil {
+			t.Errorf("Decode(%s): %v", tt.code, err)
+			continue
+		}
+		got := []float64{area.LatLo, area.LngLo, area.LatHi, area.LngHi}
+		want := []float64{tt.latLo, tt.lngLo, tt.latHi, tt.lngHi}
+		for i := range got {
+			if math.Abs(got[i]-want[i]) > 1e-10 {
+				t.Errorf("Decode(%s) = %+v, want %v", tt.code, area, want)
+				break
+			}
+		}
+		if area.Length != tt.length {
+			t.Errorf("Decode(%s) length %d, want %d", tt.code, area.Length, tt.length)
+		}
+	}
+}
+
+// A code decodes to the cell its location was encoded in
+func TestRoundTrip(t *testing.T) {
+	points := [][2]float64{{6.5244, 3.3792}, {9.0579, 7.4951}, {-33.8688, 151.2093}, {0, 0}, {-89.99, 179.99}}
+	for _, p := range points {
+		for _, length := range []int{4, 8, 10, 11, 15} {
+			code := Encode(p[0], p[1], length)
+			area, err := Decode(code)
+			if err != nil {
+				t.Fatalf("Decode(%s): %v", code, err)
+			}
+			if p[0] < area.LatLo || p[0] >= area.LatHi || p[1] < area.LngLo || p[1] >= area.LngHi {
+				t.Errorf("%v encoded as %s, which decodes to %+v", p, code, area)
+			}
+			if lat, lng := area.Center(); Encode(lat, lng, length) != code {
+				t.Errorf("center of %s encodes to %s", code, Encode(lat, lng, length))
+			}
+		}
+	}
+}
+
+func TestValidity(t *testing.T) {
+	tests := []struct {
+		code  string
+		valid bool
+		full  error // CheckFull's result
+	}{
+		{"8FWC2345+G6", true, nil},
+		{"8FWC2345+G6G", true, nil},
+		{"8fwc2345+", true, nil},
+		{"8FWCX400+", true, nil},
+		{"WC2345+G6g", true, ErrShort},
+		{"2345+G6", true, ErrShort},
+		{"45+G6", true, ErrShort},
+		{"G+", false, ErrInvalid},
+		{"+", false, ErrInvalid},
+		{"8FWC2345+G", false, ErrInvalid},
+		{"8FWC2_45+G6", false, ErrInvalid},
+		{"8FWC2η45+G6", false, ErrInvalid},
+		{"8FWC2345+G6+", false, ErrInvalid},
+		{"8FWC2345G6+", false, ErrInvalid},
+		{"8FWC2300+G6", false, ErrInvalid},
+		{"WC2300+G6g", false, ErrInvalid},
+		{"WC2345+G", false, ErrInvalid},
+		{"WC2300+", false, ErrInvalid},
+		// Well formed, but past the pole and the antimeridian
+		{"F2222222+22", true, ErrInvalid},
+		{"2W222222+22", true, ErrInvalid},
+	}
+	for _, tt := range tests {
+		if err := CheckFull(tt.code); !errors.Is(err, tt.full) {
+			t.Errorf("CheckFull(%s) = %v, want %v", tt.code, err, tt.full)
+		}
+		if got := IsValid(tt.code); got != tt.valid {
+			t.Errorf("IsValid(%s) = %v, want %v", tt.code, got, tt.valid)
+		}
+		if _, err := Decode(tt.code); tt.full != nil && err == nil {
+			t.Errorf("Decode(%s) succeeded, want %v", tt.code, tt.full)
+		}
+	}
+}
@@ -0,0 +1,392 @@
+// Package scheduler runs the server's periodic background jobs. A job is
+// registered with a name, an interval or a cron expression, a timeout and
+// the function to run; the scheduler runs it on time, recovers its panics,
+// records each run in Postgres and beats the worker health registry for it.
+// A singleton job runs on one instance at a time, under a Redis lock, and
+// once per scheduled run across instances: the instance that runs it claims
+// the time up to its next run, and the others skip their runs until then.
+// Any job can be run at once on request.
+// Closing the scheduler cancels the runs in progress and waits for them, so
+// its jobs stop in one place at shutdown.
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"runtime/debug"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+)
+
+// recordTimeout bounds saving a run's status, which outlives the run's context
+const recordTimeout = 5 * time.Second
+
+// maxClaimSlack caps how long before a singleton job's next run its claim
+// on the current one expires
+const maxClaimSlack = 5 * time.Second
+
+var (
+	ErrUnknownJob = errors.New("unknown job")
+	ErrClosed     = errors.New("scheduler closed")
+)
+
+// Health is told about every job, so readiness reflects a job that stopped
+// succeeding. services.HealthRegistry implements it.
+type Health interface {
+	Register(name string, interval time.Duration)
+	Beat(name string)
+}
+
+// Locks coordinates singleton jobs between instances. database.RedisDB
+// implements it.
+type Locks interface {
+	AcquireJobLock(ctx context.Context, name, token string, ttl time.Duration) (bool, error)
+	ReleaseJobLock(ctx context.Context, name, token string) error
+	ClaimJobRun(ctx context.Context, name, token string, ttl time.Duration) (bool, error)
+}
+
+// Runs stores the runs of every instance. database.PostgresDB implements it.
+type Runs interface {
+	RecordJobRun(ctx context.Context, run *models.JobRun) error
+	GetJobStatuses(ctx context.Context) ([]models.JobStatus, error)
+}
+
+// Job is a periodic background job. Exactly one of Every and Cron is set.
+type Job struct {
+	Name      string
+	Every     time.Duration // interval from the start of one run to the next; the first run is at Start
+	Cron      string        // five-field cron expression, in UTC
+	Singleton bool          // run on one instance at a time
+	Timeout   time.Duration // the run's context is cancelled after it
+	Run       func(ctx context.Context) error
+}
+
+// JobInfo is a job as this instance runs it, with its recorded status
+type JobInfo struct {
+	models.JobStatus
+	Running bool `json:"running"` // on this instance, now
+}
+
+type schedule interface {
+	next(after time.Time) time.Time
+	String() string
+}
+
+type entry struct {
+	job      Job
+	schedule schedule
+	trigger  chan struct{} // a run-now request; at most one waits
+	running  atomic.Bool
+	nextRun  atomic.Int64 // this instance's next run, in Unix nanoseconds
+}
+
+// Scheduler runs registered jobs from Start until Close
+type Scheduler struct {
+	runs     Runs
+	locks    Locks
+	health   Health
+	instance string
+
+	mu      sync.Mutex
+	jobs    map[string]*entry
+	order   []*entry // in registration order
+	started bool
+	closed  bool
+
+	ctx       context.Context // cancelled by Close
+	cancel    context.CancelFunc
+	wg        sync.WaitGroup
+	closeOnce sync.Once
+}
+
+func New(runs Runs, locks Locks, health Health) *Scheduler {
+	ctx, cancel := context.WithCancel(context.Background())
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return &Scheduler{
+		runs:     runs,
+		locks:    locks,
+		health:   health,
+		instance: fmt.Sprintf("%s:%d", host, os.Getpid()),
+		jobs:     make(map[string]*entry),
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+}
+
+// Register adds a job. A job registered after Start starts running at once.
+func (s *Scheduler) Register(job Job) error {
+	if job.Name == "" || job.Run == nil {
+		return fmt.Errorf("job needs a name and a function to run")
+	}
+	if job.Timeout <= 0 {
+		return fmt.Errorf("job %s needs a timeout", job.Name)
+	}
+	var sched schedule
+	var interval time.Duration
+	switch {
+	case job.Every > 0 && job.Cron == "":
+		sched = every(job.Every)
+		interval = job.Every
+	case job.Every == 0 && job.Cron != "":
+		c, err := parseCron(job.Cron)
+		if err != nil {
+			return fmt.Errorf("job %s: %w", job.Name, err)
+		}
+		sched = c
+		// Health expects a beat at least this often: the longest gap
+		// between runs seen over the next few runs
+		at := time.Now()
+		for range 4 {
+			next := c.next(at)
+			interval = max(interval, next.Sub(at))
+			at = next
+		}
+	default:
+		return fmt.Errorf("job %s needs either an interval or a cron expression", job.Name)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return ErrClosed
+	}
+	if _, ok := s.jobs[job.Name]; ok {
+		return fmt.Errorf("job %s is already registered", job.Name)
+	}
+	e := &entry{job: job, schedule: sched, trigger: make(chan struct{}, 1)}
+	s.jobs[job.Name] = e
+	s.order = append(s.order, e)
+	s.health.Register(job.Name, interval)
+	if s.started {
+		s.wg.Add(1)
+		go s.loop(e)
+	}
+	return nil
+}
+
+// MustRegister is Register for jobs defined in code, panicking on an error:
+// a wrong definition is a bug to catch at startup
+func (s *Scheduler) MustRegister(job Job) {
+	if err := s.Register(job); err != nil {
+		panic(err)
+	}
+}
+
+// Start runs the registered jobs
+func (s *Scheduler) Start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.started || s.closed {
+		return
+	}
+	s.started = true
+	for _, e := range s.order {
+		s.wg.Add(1)
+		go s.loop(e)
+	}
+}
+
+// Close stops scheduling runs, cancels the ones in progress and waits for
+// them to return
+func (s *Scheduler) Close() {
+	s.closeOnce.Do(func() {
+		s.mu.Lock()
+		s.closed = true
+		s.mu.Unlock()
+		s.cancel()
+	})
+	s.wg.Wait()
+}
+
+// RunNow runs a job on this instance as soon as it isn't running, without
+// moving its schedule. A singleton job held by another instance is skipped
+// as on schedule. Requests made while one is waiting are folded into it.
+func (s *Scheduler) RunNow(name string) error {
+	s.mu.Lock()
+	e, ok := s.jobs[name]
+	closed := s.closed
+	s.mu.Unlock()
+	if !ok {
+		return ErrUnknownJob
+	}
+	if closed {
+		return ErrClosed
+	}
+	select {
+	case e.trigger <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Jobs returns the jobs this instance runs, by name, with their status as
+// last recorded by any instance. next_run_at is this instance's.
+func (s *Scheduler) Jobs(ctx context.Context) ([]JobInfo, error) {
+	stored, err := s.runs.GetJobStatuses(ctx)
+	if err != nil {
+		return nil, err
+	}
+	byName := make(map[string]models.JobStatus, len(stored))
+	for _, status := range stored {
+		byName[status.Name] = status
+	}
+
+	s.mu.Lock()
+	entries := make([]*entry, len(s.order))
+	copy(entries, s.order)
+	s.mu.Unlock()
+
+	jobs := make([]JobInfo, 0, len(entries))
+	for _, e := range entries {
+		status, ok := byName[e.job.Name]
+		if !ok {
+			status = models.JobStatus{Name: e.job.Name}
+		}
+		status.Schedule = e.schedule.String()
+		status.Singleton = e.job.Singleton
+		status.NextRunAt = nil
+		if next := e.nextRun.Load(); next != 0 {
+			at := time.Unix(0, next)
+			status.NextRunAt = &at
+		}
+		jobs = append(jobs, JobInfo{JobStatus: status, Running: e.running.Load()})
+	}
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].Name < jobs[j].Name })
+	return jobs, nil
+}
+
+// loop runs a job on its schedule, and on request, until Close
+func (s *Scheduler) loop(e *entry) {
+	defer s.wg.Done()
+
+	next := time.Now()
+	if _, isCron := e.schedule.(*cronSchedule); isCron {
+		next = e.schedule.next(next)
+	}
+	for {
+		e.nextRun.Store(next.UnixNano())
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-s.ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		case <-e.trigger:
+			timer.Stop()
+			s.run(e, false)
+			continue // the schedule stands
+		}
+		started := time.Now()
+		s.run(e, true)
+		next = e.schedule.next(started)
+		if now := time.Now(); next.Before(now) {
+			next = now // overran its interval; no backlog of runs is caught up
+		}
+	}
+}
+
+// run runs a job once, under its lock if it is a singleton, and records it.
+// A scheduled run of a singleton job first claims the time up to its next
+// run, so instances on different phases don't each run it once per interval.
+func (s *Scheduler) run(e *entry, scheduled bool) {
+	job := e.job
+	ctx, cancel := context.WithTimeout(s.ctx, job.Timeout)
+	defer cancel()
+
+	if job.Singleton {
+		token := s.instance + ":" + uuid.NewString()
+		if scheduled {
+			now := time.Now()
+			claimed, err := s.locks.ClaimJobRun(ctx, job.Name, token, claimTTL(now, e.schedule.next(now)))
+			if err != nil {
+				log.Printf("ERROR: Failed to claim the run of job %s, skipping it: %v", job.Name, err)
+				return
+			}
+			if !claimed {
+				// Another instance ran it this interval
+				s.health.Beat(job.Name)
+				return
+			}
+		}
+		acquired, err := s.locks.AcquireJobLock(ctx, job.Name, token, job.Timeout)
+		if err != nil {
+			log.Printf("ERROR: Failed to take the lock of job %s, skipping this run: %v", job.Name, err)
+			return
+		}
+		if !acquired {
+			// Another instance is running it, which is what it's for
+			s.health.Beat(job.Name)
+			return
+		}
+		defer func() {
+			releaseCtx, cancel := context.WithTimeout(context.Background(), recordTimeout)
+			defer cancel()
+			if err := s.locks.ReleaseJobLock(releaseCtx, job.Name, token); err != nil {
+				log.Printf("WARN: Failed to release the lock of job %s; it expires on its own: %v", job.Name, err)
+			}
+		}()
+	}
+
+	e.running.Store(true)
+	started := time.Now()
+	err := call(ctx, job)
+	duration := time.Since(started)
+	e.running.Store(false)
+
+	run := &models.JobRun{
+		Name:      job.Name,
+		Schedule:  e.schedule.String(),
+		Singleton: job.Singleton,
+		StartedAt: started,
+		Duration:  duration,
+		Instance:  s.instance,
+		NextRunAt: e.schedule.next(started),
+	}
+	switch {
+	case err != nil && s.ctx.Err() != nil:
+		run.Error = "stopped by shutdown: " + err.Error()
+		log.Printf("INFO: Job %s stopped by shutdown after %v", job.Name, duration.Round(time.Millisecond))
+	case err != nil:
+		run.Error = err.Error()
+		log.Printf("ERROR: Job %s failed after %v: %v", job.Name, duration.Round(time.Millisecond), err)
+	default:
+		s.health.Beat(job.Name)
+	}
+
+	recordCtx, cancelRecord := context.WithTimeout(context.Background(), recordTimeout)
+	defer cancelRecord()
+	if err := s.runs.RecordJobRun(recordCtx, run); err != nil {
+		log.Printf("WARN: Failed to record run of job %s: %v", job.Name, err)
+	}
+}
+
+// claimTTL is how long a scheduled run claims a singleton job: until just
+// before the next run is due, so the instance whose turn it is then isn't
+// turned away by the claim
+func claimTTL(now, next time.Time) time.Duration {
+	until := next.Sub(now)
+	return max(until-min(until/10, maxClaimSlack), time.Millisecond)
+}
+
+// call runs the job's function, turning a panic into an error
+func call(ctx context.Context, job Job) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("ERROR: Job %s panicked: %v\n%s", job.Name, r, debug.Stack())
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return job.Run(ctx)
+}
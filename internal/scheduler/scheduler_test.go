@@ -0,0 +1,176 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+)
+
+// fakeLocks is an in-memory stand-in for the Redis locks, shared by the
+// schedulers of a test as Redis is by instances
+type fakeLocks struct {
+	mu   sync.Mutex
+	keys map[string]fakeLock
+}
+
+type fakeLock struct {
+	token   string
+	expires time.Time
+}
+
+func newFakeLocks() *fakeLocks {
+	return &fakeLocks{keys: make(map[string]fakeLock)}
+}
+
+func (f *fakeLocks) setNX(key, token string, ttl time.Duration) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if held, ok := f.keys[key]; ok && time.Now().Before(held.expires) {
+		return false
+	}
+	f.keys[key] = fakeLock{token: token, expires: time.Now().Add(ttl)}
+	return true
+}
+
+func (f *fakeLocks) AcquireJobLock(ctx context.Context, name, token string, ttl time.Duration) (bool, error) {
+	return f.setNX("lock:"+name, token, ttl), nil
+}
+
+func (f *fakeLocks) ReleaseJobLock(ctx context.Context, name, token string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.keys["lock:"+name].token == token {
+		delete(f.keys, "lock:"+name)
+	}
+	return nil
+}
+
+func (f *fakeLocks) ClaimJobRun(ctx context.Context, name, token string, ttl time.Duration) (bool, error) {
+	return f.setNX("claim:"+name, token, ttl), nil
+}
+
+type fakeRuns struct {
+	mu   sync.Mutex
+	runs []*models.JobRun
+}
+
+func (f *fakeRuns) RecordJobRun(ctx context.Context, run *models.JobRun) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.runs = append(f.runs, run)
+	return nil
+}
+
+func (f *fakeRuns) GetJobStatuses(ctx context.Context) ([]models.JobStatus, error) {
+	return nil, nil
+}
+
+type fakeHealth struct{}
+
+func (fakeHealth) Register(name string, interval time.Duration) {}
+func (fakeHealth) Beat(name string)                             {}
+
+func countingJob(name string, every time.Duration, runs *atomic.Int32) Job {
+	return Job{
+		Name:      name,
+		Every:     every,
+		Singleton: true,
+		Timeout:   time.Second,
+		Run: func(ctx context.Context) error {
+			runs.Add(1)
+			return nil
+		},
+	}
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// Two instances started out of phase run a singleton job once per
+// interval between them, not once each
+func TestSingletonRunsOncePerInterval(t *testing.T) {
+	const (
+		interval = 100 * time.Millisecond
+		window   = 1050 * time.Millisecond
+	)
+	locks, runs := newFakeLocks(), &fakeRuns{}
+	var count atomic.Int32
+
+	first := New(runs, locks, fakeHealth{})
+	first.MustRegister(countingJob("sweep", interval, &count))
+	second := New(runs, locks, fakeHealth{})
+	second.MustRegister(countingJob("sweep", interval, &count))
+
+	first.Start()
+	time.Sleep(interval / 3)
+	second.Start()
+	time.Sleep(window)
+	first.Close()
+	second.Close()
+
+	// One run at Start and one per interval after, give or take timing
+	want := int32(window/interval) + 1
+	if got := count.Load(); got < want-2 || got > want+1 {
+		t.Errorf("ran %d times in %v, want about %d", got, window, want)
+	}
+}
+
+// A run on request happens even though the scheduled run claimed the
+// interval, and leaves the schedule where it was
+func TestRunNow(t *testing.T) {
+	s := New(&fakeRuns{}, newFakeLocks(), fakeHealth{})
+	var count atomic.Int32
+	s.MustRegister(countingJob("digest", time.Hour, &count))
+	s.Start()
+	defer s.Close()
+
+	waitFor(t, "the first run", func() bool { return count.Load() == 1 })
+	next := s.jobs["digest"].nextRun.Load()
+
+	if err := s.RunNow("digest"); err != nil {
+		t.Fatalf("RunNow: %v", err)
+	}
+	waitFor(t, "the requested run", func() bool { return count.Load() == 2 })
+	if got := s.jobs["digest"].nextRun.Load(); got != next {
+		t.Errorf("next run moved from %v to %v", time.Unix(0, next), time.Unix(0, got))
+	}
+
+	if err := s.RunNow("unknown"); !errors.Is(err, ErrUnknownJob) {
+		t.Errorf("RunNow(unknown) = %v, want ErrUnknownJob", err)
+	}
+	s.Close()
+	if err := s.RunNow("digest"); !errors.Is(err, ErrClosed) {
+		t.Errorf("RunNow after Close = %v, want ErrClosed", err)
+	}
+}
+
+func TestClaimTTL(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		next time.Duration
+		want time.Duration
+	}{
+		{time.Minute, time.Minute - 5*time.Second},
+		{10 * time.Second, 9 * time.Second},
+		{time.Hour, time.Hour - maxClaimSlack},
+		{0, time.Millisecond},
+	}
+	for _, tt := range tests {
+		if got := claimTTL(now, now.Add(tt.next)); got != tt.want {
+			t.Errorf("claimTTL(next in %v) = %v, want %v", tt.next, got, tt.want)
+		}
+	}
+}
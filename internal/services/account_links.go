@@ -0,0 +1,117 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/database"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+)
+
+// accountLinkCacheTTL bounds how long an authorization decision is reused.
+// Accept and revoke invalidate the entry, so this only matters if that fails.
+const accountLinkCacheTTL = time.Minute
+
+// AccountLinkService manages guardian/ward links and answers authorization
+// checks for them from a short-lived Redis cache
+type AccountLinkService struct {
+	postgres *database.PostgresDB
+	redis    *database.RedisDB
+}
+
+func NewAccountLinkService(postgres *database.PostgresDB, redis *database.RedisDB) *AccountLinkService {
+	return &AccountLinkService{
+		postgres: postgres,
+		redis:    redis,
+	}
+}
+
+// ValidateLinkPermissions rejects unknown permissions
+func ValidateLinkPermissions(permissions []string) error {
+	for _, p := range permissions {
+		switch p {
+		case models.LinkPermissionAlerts, models.LinkPermissionTracking:
+		default:
+			return fmt.Errorf("unknown permission %q", p)
+		}
+	}
+	return nil
+}
+
+// Request creates a pending link that the ward must accept
+func (s *AccountLinkService) Request(ctx context.Context, guardianID, wardID uuid.UUID, permissions []string) (*models.AccountLink, error) {
+	link := &models.AccountLink{
+		ID:             uuid.New(),
+		GuardianUserID: guardianID,
+		WardUserID:     wardID,
+		Permissions:    dedupe(permissions),
+		Status:         models.LinkStatusPending,
+		CreatedAt:      time.Now(),
+	}
+	if err := s.postgres.CreateAccountLink(ctx, link); err != nil {
+		return nil, err
+	}
+	return link, nil
+}
+
+// Accept activates a pending link. Returns false if it was not pending.
+func (s *AccountLinkService) Accept(ctx context.Context, link *models.AccountLink) (bool, error) {
+	ok, err := s.postgres.ActivateAccountLink(ctx, link.ID)
+	if err != nil || !ok {
+		return ok, err
+	}
+	return true, s.invalidate(ctx, link)
+}
+
+// Revoke ends a link. The cached decision is dropped so the guardian loses
+// access on their next request. Returns false if it was already revoked.
+func (s *AccountLinkService) Revoke(ctx context.Context, link *models.AccountLink) (bool, error) {
+	ok, err := s.postgres.RevokeAccountLink(ctx, link.ID)
+	if err != nil || !ok {
+		return ok, err
+	}
+	return true, s.invalidate(ctx, link)
+}
+
+// ActiveLink returns the active link from guardian to ward, or nil.
+// Implements middleware.LinkChecker.
+func (s *AccountLinkService) ActiveLink(ctx context.Context, guardianID, wardID uuid.UUID) (*models.AccountLink, error) {
+	link, found, err := s.redis.GetCachedAccountLink(ctx, guardianID, wardID)
+	if err != nil {
+		log.Printf("WARN: Account link cache read failed: %v", err)
+	} else if found {
+		return link, nil
+	}
+
+	link, err = s.postgres.GetActiveAccountLink(ctx, guardianID, wardID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.redis.CacheAccountLink(ctx, guardianID, wardID, link, accountLinkCacheTTL); err != nil {
+		log.Printf("WARN: Account link cache write failed: %v", err)
+	}
+	return link, nil
+}
+
+func (s *AccountLinkService) invalidate(ctx context.Context, link *models.AccountLink) error {
+	if err := s.redis.InvalidateAccountLink(ctx, link.GuardianUserID, link.WardUserID); err != nil {
+		return fmt.Errorf("link %s updated but cache invalidation failed (expires within %s): %w", link.ID, accountLinkCacheTTL, err)
+	}
+	return nil
+}
+
+func dedupe(values []string) []string {
+	seen := make(map[string]bool, len(values))
+	result := make([]string, 0, len(values))
+	for _, v := range values {
+		if !seen[v] {
+			seen[v] = true
+			result = append(result, v)
+		}
+	}
+	return result
+}
@@ -0,0 +1,119 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/config"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+)
+
+// A user whose heartbeats went stale while they used the app is held at
+// CAUTION, for at most the cap past the heartbeat window
+func TestSuppressForActivity(t *testing.T) {
+	cfg := simulationConfig()
+	profile := DefaultScoringProfile(cfg)
+	limit := 30 * time.Minute
+	hb := simulatedHeartbeat(0, false)
+	window := profile.heartbeatWindow()
+
+	activeAt := func(after time.Duration) *time.Time {
+		at := hb.Timestamp.Add(after)
+		return &at
+	}
+	tests := []struct {
+		name     string
+		now      time.Duration // since the heartbeat
+		activeAt *time.Time
+		limit    time.Duration
+		want     bool
+	}{
+		{"active after the last heartbeat", window + 5*time.Minute, activeAt(window), limit, true},
+		{"at the cap", window + limit, activeAt(window + limit - time.Minute), limit, true},
+		{"past the cap", window + limit + time.Second, activeAt(window + limit), limit, false},
+		{"active only before the last heartbeat", window + 5*time.Minute, activeAt(-time.Minute), limit, false},
+		{"active at the last heartbeat", window + 5*time.Minute, activeAt(0), limit, false},
+		{"no activity", window + 5*time.Minute, nil, limit, false},
+		{"disabled", window + 5*time.Minute, activeAt(window), 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := NewFakeClock(hb.Timestamp.Add(tt.now))
+			se := &SafetyEvaluator{cfg: config.NewStore(cfg), clock: clock, effects: discardEffects{}}
+			result := se.Assess(&hb, nil, profile)
+			if !isStalenessRisk(result) {
+				t.Fatalf("Assess() = %s %v, want AT_RISK for staleness", result.State, result.RulesFired)
+			}
+
+			got := SuppressForActivity(result, &hb, tt.activeAt, clock.Now(), profile, tt.limit)
+			if got != tt.want {
+				t.Fatalf("SuppressForActivity() = %v, want %v", got, tt.want)
+			}
+			if !tt.want {
+				if result.State != StateAtRisk || firedRule(result, RuleActiveInApp) {
+					t.Errorf("unsuppressed result changed: %s %v", result.State, result.RulesFired)
+				}
+				return
+			}
+			if result.State != StateCaution || !firedRule(result, RuleActiveInApp) || !firedRule(result, RuleHeartbeatStale) {
+				t.Errorf("result = %s %v, want CAUTION held for activity", result.State, result.RulesFired)
+			}
+			if len(result.Reasons) != 1 || result.Reasons[0].Code != models.ReasonActiveInApp || result.Reason != RenderReasons(result.Reasons) {
+				t.Errorf("reasons = %+v, %q", result.Reasons, result.Reason)
+			}
+		})
+	}
+}
+
+// Only staleness is held back: distress, impacts, a prolonged LastGasp and
+// a low score all stand however recently the user opened the app
+func TestSuppressForActivityNotSuppressible(t *testing.T) {
+	cfg := simulationConfig()
+	profile := DefaultScoringProfile(cfg)
+	hb := simulatedHeartbeat(0, false)
+	now := hb.Timestamp.Add(15 * time.Minute)
+	activeAt := now.Add(-time.Minute)
+
+	tests := []struct {
+		name   string
+		result *EvaluationResult
+	}{
+		{"panic", &EvaluationResult{State: StateAlert, Deterministic: true, Reasons: []models.Reason{{Code: models.ReasonPanic}}}},
+		{"impact", &EvaluationResult{State: StateAlert, Deterministic: true, Reasons: []models.Reason{{Code: models.ReasonImpact}}}},
+		{"LastGasp prolonged", &EvaluationResult{State: StateAtRisk, Deterministic: true, RulesFired: []string{RuleLastGaspProlonged}}},
+		{"no heartbeat", &EvaluationResult{State: StateAtRisk, Deterministic: true, RulesFired: []string{RuleNoHeartbeat}}},
+		{"low score", &EvaluationResult{State: StateAtRisk, Score: 30}},
+		{"already CAUTION", &EvaluationResult{State: StateCaution, Score: 70}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := *tt.result
+			if SuppressForActivity(tt.result, &hb, &activeAt, now, profile, time.Hour) {
+				t.Fatalf("%s suppressed", tt.name)
+			}
+			if tt.result.State != before.State || firedRule(tt.result, RuleActiveInApp) {
+				t.Errorf("result changed to %s %v", tt.result.State, tt.result.RulesFired)
+			}
+		})
+	}
+}
+
+// A user held for activity is checked again as soon as the hold can lapse
+func TestNextCheckAfterActivityHold(t *testing.T) {
+	cfg := simulationConfig()
+	cfg.ActivitySuppressionMaxMinutes = 30
+	profile := DefaultScoringProfile(cfg)
+	hb := simulatedHeartbeat(0, false)
+	se := &SafetyEvaluator{cfg: config.NewStore(cfg), clock: NewFakeClock(hb.Timestamp.Add(15 * time.Minute)), effects: discardEffects{}}
+
+	held := &EvaluationResult{State: StateCaution, RulesFired: []string{RuleHeartbeatStale, RuleActiveInApp}}
+	want := hb.Timestamp.Add(profile.heartbeatWindow() + 30*time.Minute + time.Second)
+	if got := se.nextCheck(&hb, held, profile); !got.Equal(want) {
+		t.Errorf("nextCheck() = %s, want %s", got, want)
+	}
+
+	stale := &EvaluationResult{State: StateAtRisk, RulesFired: []string{RuleHeartbeatStale}}
+	if got := se.nextCheck(&hb, stale, profile); !got.IsZero() {
+		t.Errorf("nextCheck() = %s for a stale user, want none", got)
+	}
+}
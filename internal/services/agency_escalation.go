@@ -0,0 +1,666 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/config"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/country"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/database"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/pluscode"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/scheduler"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/utils"
+)
+
+const (
+	agencyEscalationWorker  = "agency_escalation"
+	agencyEscalationEvery   = time.Minute
+	agencyEscalationTimeout = 2 * time.Minute
+	agencyEscalationBatch   = 50
+
+	// defaultReferenceField is the field of a webhook's JSON response read
+	// as the agency's reference when the target names none
+	defaultReferenceField = "reference"
+)
+
+// How a handoff's target was matched to the alert's location, most specific first
+const (
+	AgencyMatchRegionCode      = "region_code"      // the state's ISO 3166-2 code, e.g. NG-LA
+	AgencyMatchRegionName      = "region_name"      // the state's name, e.g. Lagos
+	AgencyMatchCountry         = "country"          // the country's code, e.g. NG
+	AgencyMatchNationalDefault = "national_default" // AGENCY_DEFAULT_CHANNEL and AGENCY_DEFAULT_ENDPOINT
+)
+
+// defaultHandoffTemplates word a handoff on each channel when its target
+// gives no template. A webhook gets the text as its payload's message.
+var defaultHandoffTemplates = map[string]string{
+	models.AgencyChannelSMS: "SafeTrace emergency: {{.FirstName}} needs help. {{.Summary}} " +
+		"Last seen {{.LastSeen}} at {{.Location}}{{if .PlusCode}}, plus code {{.PlusCode}}{{end}}. {{.MapLink}} " +
+		"Call back: {{.Callback}}. Ref {{.AlertID}}",
+	models.AgencyChannelVoice: "This is an automated emergency call from SafeTrace. {{.FirstName}} needs help" +
+		"{{if .Region}} in {{.Region}}{{end}}. {{.Summary}} They were last seen {{.LastSeen}}" +
+		"{{if .Place}} near {{.Place}}{{end}}, at latitude {{printf \"%.5f\" .Lat}}, longitude {{printf \"%.5f\" .Lng}}. " +
+		"Please call back on {{.Callback}}.",
+	models.AgencyChannelWebhook: "SafeTrace emergency: {{.FirstName}} needs help. {{.Summary}} " +
+		"Last seen {{.LastSeen}} at {{.Location}}. Call back: {{.Callback}}.",
+}
+
+var builtinHandoffTemplates = func() map[string]*template.Template {
+	parsed := make(map[string]*template.Template, len(defaultHandoffTemplates))
+	for channel, body := range defaultHandoffTemplates {
+		parsed[channel] = template.Must(template.New(channel).Parse(body))
+	}
+	return parsed
+}()
+
+// HandoffData is what a handoff template renders against
+type HandoffData struct {
+	AlertID    string
+	FirstName  string  // the user's; agencies get no more of their name
+	Summary    string  // what happened, from the alert and its deliveries
+	Location   string  // coordinates and accuracy, e.g. "6.524400, 3.379200 (±12m)"
+	Lat        float64 // 0 with Lng when the location is unknown
+	Lng        float64
+	AccuracyM  int
+	PlusCode   string
+	Place      string // the reverse geocoded place name, if already known
+	MapLink    string
+	LastSeen   string // e.g. "Jan 2, 3:04 PM WAT"
+	Region     string // the state's name, e.g. Lagos
+	RegionCode string // e.g. NG-LA
+	Callback   string // the number to call back on
+}
+
+var sampleHandoffData = HandoffData{
+	AlertID:    "3f9a6c1e-2b7d-4a8f-9c0e-1d2b3a4f5e6d",
+	FirstName:  "Chiamaka",
+	Summary:    "Alert raised Jan 2, 3:04 PM WAT: no heartbeat for 45 minutes at night. 3 contacts alerted, none acknowledged. Battery 8% at the last heartbeat.",
+	Location:   "6.524400, 3.379200 (±12m)",
+	Lat:        6.5244,
+	Lng:        3.3792,
+	AccuracyM:  12,
+	PlusCode:   "6FR5G9FH+QM",
+	Place:      "Allen Avenue, Ikeja, Lagos",
+	MapLink:    "https://www.google.com/maps?q=6.524400,3.379200",
+	LastSeen:   "Jan 2, 3:04 PM WAT",
+	Region:     "Lagos",
+	RegionCode: "NG-LA",
+	Callback:   "+2348012345678",
+}
+
+// AgencyTarget is an emergency response agency alerts in a region are handed
+// off to, as configured in AGENCY_TARGETS_FILE
+type AgencyTarget struct {
+	Region         string `json:"region"`                    // ISO 3166-2 code (NG-LA), state name (Lagos) or country code (NG)
+	Name           string `json:"name"`                      // e.g. "Lagos State Emergency Command"
+	Channel        string `json:"channel"`                   // sms | voice | http_webhook
+	Endpoint       string `json:"endpoint"`                  // the duty number, or the webhook's https URL, which may carry a credential
+	Template       string `json:"template,omitempty"`        // the handoff's wording; empty uses the channel's default
+	ReferenceField string `json:"reference_field,omitempty"` // the webhook response's field holding the agency's reference
+}
+
+// AgencyRoute is the target an alert's handoff goes to and how it was matched
+type AgencyRoute struct {
+	Target    AgencyTarget
+	MatchedBy string // an AgencyMatch* value
+	tmpl      *template.Template
+}
+
+// Render words the handoff. A target's template that fails is logged and
+// the channel's default is used instead, so a handoff is never blocked by
+// its wording.
+func (r *AgencyRoute) Render(data HandoffData) string {
+	if r.tmpl != nil {
+		var b strings.Builder
+		err := r.tmpl.Execute(&b, data)
+		if err == nil {
+			return b.String()
+		}
+		log.Printf("ERROR: Handoff template of agency %q failed to render, using the default: %v", r.Target.Name, err)
+	}
+	var b strings.Builder
+	if err := builtinHandoffTemplates[r.Target.Channel].Execute(&b, data); err != nil {
+		log.Printf("ERROR: Default %s handoff template failed to render: %v", r.Target.Channel, err)
+	}
+	return b.String()
+}
+
+// AgencyTargets is the registry of agency targets. Targets are validated
+// when loaded; a reload that fails keeps the current ones.
+type AgencyTargets struct {
+	mu     sync.RWMutex
+	byCode map[string]*AgencyRoute // ISO 3166-2 and country codes, upper case
+	byName map[string]*AgencyRoute // normalized state names
+}
+
+// NewAgencyTargets validates the targets and returns the registry
+func NewAgencyTargets(targets []AgencyTarget) (*AgencyTargets, error) {
+	t := &AgencyTargets{}
+	if err := t.Load(targets); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// LoadAgencyTargets reads a JSON array of agency targets. An empty path
+// means none.
+func LoadAgencyTargets(path string) ([]AgencyTarget, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var targets []AgencyTarget
+	if err := json.Unmarshal(data, &targets); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return targets, nil
+}
+
+// Load replaces the targets. Every target is validated first; on error the
+// current ones are kept. Phone numbers are normalized.
+func (t *AgencyTargets) Load(targets []AgencyTarget) error {
+	byCode := make(map[string]*AgencyRoute)
+	byName := make(map[string]*AgencyRoute)
+	var problems []string
+	for i, target := range targets {
+		route, err := newAgencyRoute(target)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("target %d (%s): %v", i, target.Region, err))
+			continue
+		}
+		// Codes, NG or NG-LA, and state names are looked up apart
+		region := strings.TrimSpace(target.Region)
+		index, key := byName, normalizeRegionName(region)
+		if isRegionCode(region) {
+			index, key = byCode, strings.ToUpper(region)
+		}
+		if _, dup := index[key]; dup {
+			problems = append(problems, fmt.Sprintf("target %d: region %s has another target", i, region))
+			continue
+		}
+		index[key] = route
+	}
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return fmt.Errorf("invalid agency targets: %s", strings.Join(problems, "; "))
+	}
+
+	t.mu.Lock()
+	t.byCode = byCode
+	t.byName = byName
+	t.mu.Unlock()
+	return nil
+}
+
+// Len returns how many targets are loaded
+func (t *AgencyTargets) Len() int {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return len(t.byCode) + len(t.byName)
+}
+
+// Resolve picks the target for an alert in area: the one of the state's
+// code, then of its name, then of its country, then the national default
+// from config. The bool is false when there is none.
+func (t *AgencyTargets) Resolve(area AdminArea, cfg *config.Config) (*AgencyRoute, bool) {
+	t.mu.RLock()
+	byCode, byName := t.byCode, t.byName
+	t.mu.RUnlock()
+
+	code := strings.ToUpper(area.Code)
+	if route, ok := byCode[code]; ok {
+		return withMatch(route, AgencyMatchRegionCode), true
+	}
+	if route, ok := byName[normalizeRegionName(area.Name)]; ok {
+		return withMatch(route, AgencyMatchRegionName), true
+	}
+	if countryCode, _, found := strings.Cut(code, "-"); found {
+		if route, ok := byCode[countryCode]; ok {
+			return withMatch(route, AgencyMatchCountry), true
+		}
+	}
+	if cfg.AgencyDefaultEndpoint == "" {
+		return nil, false
+	}
+	return &AgencyRoute{
+		Target: AgencyTarget{
+			Region:   "national",
+			Name:     "national default",
+			Channel:  cfg.AgencyDefaultChannel,
+			Endpoint: cfg.AgencyDefaultEndpoint,
+		},
+		MatchedBy: AgencyMatchNationalDefault,
+	}, true
+}
+
+func withMatch(route *AgencyRoute, matchedBy string) *AgencyRoute {
+	r := *route
+	r.MatchedBy = matchedBy
+	return &r
+}
+
+// newAgencyRoute validates a target and parses its template against the
+// sample data
+func newAgencyRoute(target AgencyTarget) (*AgencyRoute, error) {
+	if strings.TrimSpace(target.Region) == "" {
+		return nil, errors.New("region is required")
+	}
+	if strings.TrimSpace(target.Name) == "" {
+		return nil, errors.New("name is required")
+	}
+	switch target.Channel {
+	case models.AgencyChannelSMS, models.AgencyChannelVoice:
+		target.Endpoint = country.Active().NormalizePhone(target.Endpoint)
+		if !utils.IsValidE164(target.Endpoint) {
+			return nil, fmt.Errorf("endpoint of a %s target must be a valid phone number", target.Channel)
+		}
+	case models.AgencyChannelWebhook:
+		if !strings.HasPrefix(target.Endpoint, "https://") {
+			return nil, errors.New("endpoint of an http_webhook target must be an https URL")
+		}
+	default:
+		return nil, fmt.Errorf("channel must be sms, voice or http_webhook, not %q", target.Channel)
+	}
+	if target.ReferenceField != "" && target.Channel != models.AgencyChannelWebhook {
+		return nil, errors.New("reference_field is for http_webhook targets only")
+	}
+
+	route := &AgencyRoute{Target: target}
+	if target.Template != "" {
+		tmpl, err := template.New(target.Region).Parse(target.Template)
+		if err != nil {
+			return nil, err
+		}
+		// Rendering the sample rejects variables that don't exist
+		if err := tmpl.Execute(&strings.Builder{}, sampleHandoffData); err != nil {
+			return nil, err
+		}
+		route.tmpl = tmpl
+	}
+	return route, nil
+}
+
+// isRegionCode reports whether a target's region is a country code (NG) or
+// an ISO 3166-2 subdivision code (NG-LA) rather than a name
+func isRegionCode(region string) bool {
+	countryCode, subdivision, found := strings.Cut(region, "-")
+	if len(countryCode) != 2 || strings.ContainsAny(countryCode, " ") {
+		return false
+	}
+	if !found {
+		return strings.ToUpper(countryCode) == countryCode
+	}
+	return subdivision != "" && len(subdivision) <= 3 && !strings.Contains(subdivision, " ")
+}
+
+// normalizeRegionName compares state names ignoring case and a trailing
+// "State", so "Lagos State" matches Mapbox's "Lagos"
+func normalizeRegionName(name string) string {
+	name = strings.ToLower(strings.Join(strings.Fields(name), " "))
+	return strings.TrimSuffix(name, " state")
+}
+
+// AgencyHandoffPayload is what a webhook target is posted
+type AgencyHandoffPayload struct {
+	Event          string     `json:"event"` // always "alert_handoff"
+	AlertID        string     `json:"alert_id"`
+	FirstName      string     `json:"first_name"`
+	Summary        string     `json:"summary"`
+	Message        string     `json:"message"` // the rendered template
+	Lat            *float64   `json:"lat,omitempty"`
+	Lng            *float64   `json:"lng,omitempty"`
+	AccuracyM      int        `json:"accuracy_m,omitempty"`
+	PlusCode       string     `json:"plus_code,omitempty"`
+	Place          string     `json:"place,omitempty"`
+	MapLink        string     `json:"map_link,omitempty"`
+	LastSeenAt     *time.Time `json:"last_seen_at,omitempty"`
+	Region         string     `json:"region,omitempty"`
+	RegionCode     string     `json:"region_code,omitempty"`
+	CallbackNumber string     `json:"callback_number"`
+	SentAt         time.Time  `json:"sent_at"`
+}
+
+// AgencyEscalation hands alerts of users with auto_escalate_police to the
+// emergency response agency of the state they're in, once an ALERT has gone
+// unresolved for AGENCY_ESCALATION_MINUTES. The user's acknowledgement
+// policy applies as for any escalation: by default an alert a contact
+// acknowledged is not handed off. The state is reverse geocoded from the
+// user's last position and matched against AGENCY_TARGETS_FILE, falling back
+// to the national default. The handoff is sent on the alert outbox workers,
+// by SMS, voice call or webhook, and recorded on the alert with the
+// reference the channel returned. An alert is handed off once; alerts older
+// than ALERT_RECONCILE_AGE_MINUTES are left to the reconciler.
+type AgencyEscalation struct {
+	cfg       *config.Store
+	postgres  *database.PostgresDB
+	targets   *AgencyTargets
+	locations *LocationEncoder
+	notifier  Notifier
+	channels  *ChannelNotifier
+	outbox    *AlertOutbox
+	audit     *AuditLogger
+	scheduler *scheduler.Scheduler
+}
+
+func NewAgencyEscalation(
+	cfg *config.Store,
+	postgres *database.PostgresDB,
+	targets *AgencyTargets,
+	locations *LocationEncoder,
+	notifier Notifier,
+	channels *ChannelNotifier,
+	outbox *AlertOutbox,
+	audit *AuditLogger,
+	scheduler *scheduler.Scheduler,
+) *AgencyEscalation {
+	return &AgencyEscalation{
+		cfg:       cfg,
+		postgres:  postgres,
+		targets:   targets,
+		locations: locations,
+		notifier:  notifier,
+		channels:  channels,
+		outbox:    outbox,
+		audit:     audit,
+		scheduler: scheduler,
+	}
+}
+
+// Start schedules handoffs on one instance at a time
+func (s *AgencyEscalation) Start() {
+	s.scheduler.MustRegister(scheduler.Job{
+		Name:      agencyEscalationWorker,
+		Every:     agencyEscalationEvery,
+		Singleton: true,
+		Timeout:   agencyEscalationTimeout,
+		Run:       s.escalate,
+	})
+}
+
+// escalate hands off every alert due for it. Nothing is looked at when no
+// target and no national default are configured.
+func (s *AgencyEscalation) escalate(ctx context.Context) error {
+	cfg := s.cfg.Current()
+	if s.targets.Len() == 0 && cfg.AgencyDefaultEndpoint == "" {
+		return nil
+	}
+	now := time.Now()
+	createdBefore := now.Add(-time.Duration(cfg.AgencyEscalationMinutes) * time.Minute)
+	createdAfter := now.Add(-time.Duration(cfg.AlertReconcileAgeMinutes) * time.Minute)
+
+	var afterCreated time.Time
+	var afterID uuid.UUID
+	for {
+		candidates, err := s.postgres.GetAgencyCandidates(ctx, createdAfter, createdBefore, afterCreated, afterID, agencyEscalationBatch)
+		if err != nil {
+			return fmt.Errorf("failed to find alerts to hand off: %w", err)
+		}
+		for i := range candidates {
+			s.consider(ctx, &candidates[i], now, cfg)
+		}
+		if len(candidates) < agencyEscalationBatch {
+			return nil
+		}
+		last := candidates[len(candidates)-1]
+		afterCreated, afterID = last.CreatedAt, last.ID
+	}
+}
+
+// consider applies the user's acknowledgement policy to an alert due for a
+// handoff, then claims it and hands it off, or records it as skipped. An
+// alert whose acknowledgement delays escalation is left for a later pass.
+func (s *AgencyEscalation) consider(ctx context.Context, c *models.AgencyCandidate, now time.Time, cfg *config.Config) {
+	escalate, delay := EscalationPolicy(c.Settings, c.Acknowledged > 0)
+	if escalate && delay > 0 && c.FirstAckAt != nil && now.Before(c.FirstAckAt.Add(delay)) {
+		return
+	}
+
+	status := models.HandoffPending
+	if !escalate {
+		status = models.HandoffSkipped
+	}
+	claimed, err := s.postgres.ClaimAgencyHandoff(ctx, c.ID, status, now)
+	if err != nil {
+		log.Printf("ERROR: Failed to claim the agency handoff of alert %s: %v", c.ID, err)
+		return
+	}
+	if !claimed {
+		return
+	}
+	if !escalate {
+		s.recordAudit(&models.AgencyHandoff{AlertID: c.ID, UserID: c.UserID, Status: models.HandoffSkipped}, "")
+		log.Printf("INFO: Alert %s of user %s not handed off to an agency: a contact acknowledged it", c.ID, c.UserID)
+		return
+	}
+	s.handOff(ctx, c, now, cfg)
+}
+
+// handOff resolves the alert's target from the user's last position and
+// queues the handoff on the outbox
+func (s *AgencyEscalation) handOff(ctx context.Context, c *models.AgencyCandidate, now time.Time, cfg *config.Config) {
+	data := HandoffData{
+		AlertID:   c.ID.String(),
+		FirstName: firstName(c.UserName),
+		Callback:  cfg.AgencyCallbackPhone,
+		Location:  "unknown",
+		LastSeen:  "at an unknown time",
+	}
+	if data.Callback == "" {
+		data.Callback = c.UserPhone
+	}
+
+	hb, err := s.postgres.GetLatestHeartbeat(ctx, c.UserID)
+	if err != nil {
+		log.Printf("WARN: Failed to get the last heartbeat of user %s for the handoff of alert %s: %v", c.UserID, c.ID, err)
+	}
+	var lastSeenAt *time.Time
+	switch {
+	case hb != nil:
+		data.Lat, data.Lng, data.AccuracyM = hb.Lat, hb.Lng, hb.AccuracyM
+		data.Location = fmt.Sprintf("%.6f, %.6f (±%dm)", hb.Lat, hb.Lng, hb.AccuracyM)
+		if hb.Coarse {
+			data.Location += ", approximate"
+		}
+		if hb.Landmark != "" {
+			data.Location = fmt.Sprintf("%q, as the user described it; last known %s", hb.Landmark, data.Location)
+		}
+		data.LastSeen = FormatInUserZone(hb.Timestamp, c.Settings, LayoutDateTime)
+		lastSeenAt = &hb.Timestamp
+	case c.PlusCode != nil:
+		// No heartbeat left, e.g. past retention: where the alert was raised
+		if area, err := pluscode.Decode(*c.PlusCode); err == nil {
+			data.Lat, data.Lng = area.Center()
+			data.Location = fmt.Sprintf("%.6f, %.6f", data.Lat, data.Lng)
+			data.LastSeen = FormatInUserZone(c.CreatedAt, c.Settings, LayoutDateTime)
+		}
+	}
+	located := data.Lat != 0 || data.Lng != 0
+
+	var area AdminArea
+	if located {
+		data.PlusCode = s.locations.Codes(ctx, data.Lat, data.Lng).PlusCode
+		data.Place = s.locations.PlaceName(ctx, data.Lat, data.Lng)
+		data.MapLink = googleMapsLink(data.Lat, data.Lng)
+		area, err = s.locations.AdminArea(ctx, data.Lat, data.Lng)
+		if err != nil && !errors.Is(err, ErrGeocodingDisabled) {
+			log.Printf("WARN: Failed to find the state of alert %s, falling back: %v", c.ID, err)
+		}
+		data.Region, data.RegionCode = area.Name, area.Code
+	}
+	data.Summary = handoffSummary(c, hb)
+
+	handoff := &models.AgencyHandoff{AlertID: c.ID, UserID: c.UserID, Region: area.Code, EscalatedAt: now}
+	route, ok := s.targets.Resolve(area, cfg)
+	if !ok {
+		handoff.Status = models.HandoffUnrouted
+		handoff.Error = "no agency target for the alert's location and no national default"
+		s.record(ctx, handoff, "")
+		return
+	}
+	handoff.Target, handoff.Channel = route.Target.Name, route.Target.Channel
+
+	message := route.Render(data)
+	s.outbox.EnqueueMessage(ctx, "agency handoff of alert "+c.ID.String(), func(ctx context.Context) error {
+		reference, err := s.dispatch(ctx, route, message, data, lastSeenAt)
+		handoff.Reference = reference
+		handoff.Status = models.HandoffSent
+		if err != nil {
+			handoff.Status = models.HandoffFailed
+			handoff.Error = err.Error()
+		}
+		s.record(ctx, handoff, route.MatchedBy)
+		return err
+	})
+}
+
+// dispatch sends the handoff on the target's channel and returns the
+// reference the channel gave it: the provider's message or call ID, or the
+// agency's reference from its webhook's response
+func (s *AgencyEscalation) dispatch(ctx context.Context, route *AgencyRoute, message string, data HandoffData, lastSeenAt *time.Time) (string, error) {
+	target := route.Target
+	switch target.Channel {
+	case models.AgencyChannelSMS:
+		var receipt smsReceipt
+		err := s.notifier.SendSMS(withSMSReceipt(ctx, &receipt), MessageAgency, target.Endpoint, message)
+		return receipt.MessageID, err
+	case models.AgencyChannelVoice:
+		return s.notifier.PlaceVoiceCall(ctx, MessageAgency, target.Endpoint, message)
+	case models.AgencyChannelWebhook:
+		payload := AgencyHandoffPayload{
+			Event:          "alert_handoff",
+			AlertID:        data.AlertID,
+			FirstName:      data.FirstName,
+			Summary:        data.Summary,
+			Message:        message,
+			AccuracyM:      data.AccuracyM,
+			PlusCode:       data.PlusCode,
+			Place:          data.Place,
+			MapLink:        data.MapLink,
+			LastSeenAt:     lastSeenAt,
+			Region:         data.Region,
+			RegionCode:     data.RegionCode,
+			CallbackNumber: data.Callback,
+			SentAt:         time.Now(),
+		}
+		if data.Lat != 0 || data.Lng != 0 {
+			payload.Lat, payload.Lng = &data.Lat, &data.Lng
+		}
+		body, err := json.Marshal(payload)
+		if err != nil {
+			return "", err
+		}
+		response, err := s.channels.PostHandoff(ctx, target.Name, target.Endpoint, body)
+		if err != nil {
+			return "", err
+		}
+		field := target.ReferenceField
+		if field == "" {
+			field = defaultReferenceField
+		}
+		return handoffReference(response, field), nil
+	}
+	return "", fmt.Errorf("unknown agency channel %q", target.Channel)
+}
+
+// record stores a handoff's outcome on the alert and audits it
+func (s *AgencyEscalation) record(ctx context.Context, h *models.AgencyHandoff, matchedBy string) {
+	// The send may have used up ctx; the outcome should still be stored
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+	defer cancel()
+
+	at := time.Now()
+	h.HandedOffAt = &at
+	if err := s.postgres.RecordAgencyHandoff(ctx, h); err != nil {
+		log.Printf("ERROR: Failed to record the agency handoff of alert %s: %v", h.AlertID, err)
+	}
+	s.recordAudit(h, matchedBy)
+
+	switch h.Status {
+	case models.HandoffSent:
+		log.Printf("INFO: Handed alert %s off to %s by %s, reference %q", h.AlertID, h.Target, h.Channel, h.Reference)
+	default:
+		log.Printf("ERROR: Agency handoff of alert %s %s: %s", h.AlertID, h.Status, h.Error)
+	}
+}
+
+func (s *AgencyEscalation) recordAudit(h *models.AgencyHandoff, matchedBy string) {
+	userID := h.UserID
+	metadata := map[string]interface{}{"status": h.Status}
+	for key, value := range map[string]string{
+		"region":     h.Region,
+		"target":     h.Target,
+		"channel":    h.Channel,
+		"matched_by": matchedBy,
+		"reference":  h.Reference,
+		"error":      h.Error,
+	} {
+		if value != "" {
+			metadata[key] = value
+		}
+	}
+	s.audit.Record(&models.AuditEvent{
+		ActorRole:     "system",
+		Action:        AuditAgencyHandoff,
+		ObjectType:    "alert",
+		ObjectID:      h.AlertID.String(),
+		SubjectUserID: &userID,
+		Metadata:      metadata,
+	})
+}
+
+// handoffSummary sums up what an investigation bundle of the alert would
+// show so far: when and why it was raised, who was alerted and acknowledged
+// it, and the phone's battery
+func handoffSummary(c *models.AgencyCandidate, hb *models.Heartbeat) string {
+	parts := []string{fmt.Sprintf("Alert raised %s: %s.",
+		FormatInUserZone(c.CreatedAt, c.Settings, LayoutDateTime), strings.TrimSuffix(c.Reason, "."))}
+	if c.Duress {
+		parts = append(parts, "Raised with the user's duress PIN; they may be with whoever threatens them.")
+	}
+	switch {
+	case c.RecipientCount == 0:
+		parts = append(parts, "No contact could be alerted.")
+	case c.Acknowledged == 0:
+		parts = append(parts, fmt.Sprintf("%s alerted, none acknowledged.", plural(c.RecipientCount, "1 contact", fmt.Sprintf("%d contacts", c.RecipientCount))))
+	default:
+		parts = append(parts, fmt.Sprintf("%s alerted, %d acknowledged.", plural(c.RecipientCount, "1 contact", fmt.Sprintf("%d contacts", c.RecipientCount)), c.Acknowledged))
+	}
+	if hb != nil && hb.BatteryPct != nil {
+		parts = append(parts, fmt.Sprintf("Battery %d%% at the last heartbeat.", *hb.BatteryPct))
+	}
+	return strings.Join(parts, " ")
+}
+
+// handoffReference reads the agency's reference from the field of its
+// webhook's JSON response, or "" when there is none
+func handoffReference(response, field string) string {
+	decoder := json.NewDecoder(bytes.NewReader([]byte(response)))
+	decoder.UseNumber()
+	var body map[string]interface{}
+	if err := decoder.Decode(&body); err != nil {
+		return ""
+	}
+	switch v := body[field].(type) {
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	}
+	return ""
+}
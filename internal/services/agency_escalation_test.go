@@ -0,0 +1,323 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/config"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+)
+
+// fakeAgencyTargets is a registry of one target per channel: Lagos by its
+// code on a webhook, Oyo by its name by voice, and the rest of Nigeria by SMS
+func fakeAgencyTargets(t *testing.T) *AgencyTargets {
+	t.Helper()
+	targets, err := NewAgencyTargets([]AgencyTarget{
+		{Region: "NG-LA", Name: "Lagos State Emergency Command", Channel: models.AgencyChannelWebhook, Endpoint: "https://lasema.example/handoff", ReferenceField: "incident_id"},
+		{Region: "Oyo State", Name: "Oyo Duty Desk", Channel: models.AgencyChannelVoice, Endpoint: "08031234567"},
+		{Region: "NG", Name: "Nigeria Police Force", Channel: models.AgencyChannelSMS, Endpoint: "+2348099990000", Template: "{{.FirstName}} needs help at {{.Location}}. Ref {{.AlertID}}"},
+	})
+	if err != nil {
+		t.Fatalf("NewAgencyTargets: %v", err)
+	}
+	return targets
+}
+
+// An alert goes to its state's target by code, then by name, then to its
+// country's, then to the national default, if there is one
+func TestAgencyTargetsResolve(t *testing.T) {
+	targets := fakeAgencyTargets(t)
+	if targets.Len() != 3 {
+		t.Fatalf("Len = %d, want 3", targets.Len())
+	}
+	withDefault := &config.Config{AgencyDefaultChannel: models.AgencyChannelVoice, AgencyDefaultEndpoint: "+2348000000112"}
+
+	tests := []struct {
+		name        string
+		area        AdminArea
+		wantTarget  string
+		wantMatch   string
+		wantChannel string
+	}{
+		{"region code", AdminArea{Code: "NG-LA", Name: "Lagos"}, "Lagos State Emergency Command", AgencyMatchRegionCode, models.AgencyChannelWebhook},
+		{"region code in lower case", AdminArea{Code: "ng-la"}, "Lagos State Emergency Command", AgencyMatchRegionCode, models.AgencyChannelWebhook},
+		{"region name", AdminArea{Code: "NG-OY", Name: "Oyo"}, "Oyo Duty Desk", AgencyMatchRegionName, models.AgencyChannelVoice},
+		{"region name with State", AdminArea{Name: "  oyo   STATE "}, "Oyo Duty Desk", AgencyMatchRegionName, models.AgencyChannelVoice},
+		{"country", AdminArea{Code: "NG-KN", Name: "Kano"}, "Nigeria Police Force", AgencyMatchCountry, models.AgencyChannelSMS},
+		{"another country", AdminArea{Code: "GH-AA", Name: "Greater Accra"}, "national default", AgencyMatchNationalDefault, models.AgencyChannelVoice},
+		{"area unknown", AdminArea{}, "national default", AgencyMatchNationalDefault, models.AgencyChannelVoice},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			route, ok := targets.Resolve(tt.area, withDefault)
+			if !ok {
+				t.Fatalf("Resolve(%+v) found no target", tt.area)
+			}
+			if route.Target.Name != tt.wantTarget || route.MatchedBy != tt.wantMatch || route.Target.Channel != tt.wantChannel {
+				t.Errorf("Resolve(%+v) = %s by %s on %s, want %s by %s on %s", tt.area,
+					route.Target.Name, route.MatchedBy, route.Target.Channel, tt.wantTarget, tt.wantMatch, tt.wantChannel)
+			}
+		})
+	}
+
+	// An area known only by its country matches the country's target by code
+	if route, ok := targets.Resolve(AdminArea{Code: "NG"}, withDefault); !ok || route.MatchedBy != AgencyMatchRegionCode {
+		t.Errorf("Resolve(NG) = %+v, %t; want the country's target by its code", route, ok)
+	}
+
+	// Without a national default, an alert outside every target is unrouted
+	if route, ok := targets.Resolve(AdminArea{Code: "GH-AA"}, &config.Config{AgencyDefaultChannel: models.AgencyChannelSMS}); ok {
+		t.Errorf("Resolve(GH-AA) without a default = %+v, want none", route)
+	}
+	empty, err := NewAgencyTargets(nil)
+	if err != nil {
+		t.Fatalf("NewAgencyTargets(nil): %v", err)
+	}
+	if route, ok := empty.Resolve(AdminArea{Code: "NG-LA"}, withDefault); !ok || route.MatchedBy != AgencyMatchNationalDefault {
+		t.Errorf("Resolve with no targets = %+v, %t; want the national default", route, ok)
+	}
+
+	// A resolved route is a copy: the match doesn't stick to the registry's
+	targets.Resolve(AdminArea{Code: "NG-KN"}, withDefault)
+	if route, _ := targets.Resolve(AdminArea{Code: "NG"}, withDefault); route.MatchedBy != AgencyMatchRegionCode {
+		t.Errorf("an earlier country match leaked into the route: %s", route.MatchedBy)
+	}
+}
+
+func TestAgencyTargetsLoad(t *testing.T) {
+	valid := AgencyTarget{Region: "NG-LA", Name: "Lagos", Channel: models.AgencyChannelSMS, Endpoint: "+2348012345678"}
+	with := func(change func(*AgencyTarget)) AgencyTarget {
+		target := valid
+		change(&target)
+		return target
+	}
+
+	tests := []struct {
+		name    string
+		targets []AgencyTarget
+		want    string
+	}{
+		{"no region", []AgencyTarget{with(func(a *AgencyTarget) { a.Region = " " })}, "region is required"},
+		{"no name", []AgencyTarget{with(func(a *AgencyTarget) { a.Name = "" })}, "name is required"},
+		{"unknown channel", []AgencyTarget{with(func(a *AgencyTarget) { a.Channel = "fax" })}, "channel must be"},
+		{"bad phone number", []AgencyTarget{with(func(a *AgencyTarget) { a.Endpoint = "112" })}, "valid phone number"},
+		{"voice to a URL", []AgencyTarget{with(func(a *AgencyTarget) {
+			a.Channel, a.Endpoint = models.AgencyChannelVoice, "https://lasema.example"
+		})}, "valid phone number"},
+		{"webhook over http", []AgencyTarget{with(func(a *AgencyTarget) {
+			a.Channel, a.Endpoint = models.AgencyChannelWebhook, "http://lasema.example"
+		})}, "https URL"},
+		{"reference field on SMS", []AgencyTarget{with(func(a *AgencyTarget) { a.ReferenceField = "id" })}, "http_webhook targets only"},
+		{"template that doesn't parse", []AgencyTarget{with(func(a *AgencyTarget) { a.Template = "{{.FirstName" })}, "unclosed action"},
+		{"template with an unknown field", []AgencyTarget{with(func(a *AgencyTarget) { a.Template = "{{.Surname}}" })}, "Surname"},
+		{"duplicate code", []AgencyTarget{valid, with(func(a *AgencyTarget) { a.Region = "ng-la" })}, "has another target"},
+		{"duplicate name", []AgencyTarget{
+			with(func(a *AgencyTarget) { a.Region = "Lagos" }),
+			with(func(a *AgencyTarget) { a.Region = "lagos state" }),
+		}, "has another target"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewAgencyTargets(tt.targets)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("NewAgencyTargets = %v, want an error containing %q", err, tt.want)
+			}
+		})
+	}
+
+	// A local duty number is stored in E.164
+	targets := fakeAgencyTargets(t)
+	if route, _ := targets.Resolve(AdminArea{Name: "Oyo"}, &config.Config{}); route.Target.Endpoint != "+2348031234567" {
+		t.Errorf("voice endpoint = %q, want it normalized", route.Target.Endpoint)
+	}
+
+	// A reload that fails keeps the targets loaded
+	if err := targets.Load([]AgencyTarget{valid, with(func(a *AgencyTarget) { a.Channel = "" })}); err == nil {
+		t.Fatal("Load accepted a target without a channel")
+	}
+	if route, ok := targets.Resolve(AdminArea{Code: "NG-LA"}, &config.Config{}); targets.Len() != 3 || !ok || route.Target.Channel != models.AgencyChannelWebhook {
+		t.Errorf("after a failed reload: %d targets, NG-LA on %+v; want the old ones", targets.Len(), route)
+	}
+	if err := targets.Load([]AgencyTarget{valid}); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if route, ok := targets.Resolve(AdminArea{Code: "NG-LA"}, &config.Config{}); targets.Len() != 1 || !ok || route.Target.Channel != models.AgencyChannelSMS {
+		t.Errorf("after a reload: %d targets, NG-LA on %+v; want the new one", targets.Len(), route)
+	}
+}
+
+// A target's template words its handoff; without one the channel's default does
+func TestAgencyRouteRender(t *testing.T) {
+	targets := fakeAgencyTargets(t)
+	cfg := &config.Config{}
+
+	sms, _ := targets.Resolve(AdminArea{Code: "NG-KN"}, cfg)
+	want := "Chiamaka needs help at 6.524400, 3.379200 (±12m). Ref " + sampleHandoffData.AlertID
+	if got := sms.Render(sampleHandoffData); got != want {
+		t.Errorf("custom template = %q, want %q", got, want)
+	}
+
+	voice, _ := targets.Resolve(AdminArea{Name: "Oyo"}, cfg)
+	got := voice.Render(sampleHandoffData)
+	for _, part := range []string{"automated emergency call", "Chiamaka needs help in Lagos", "latitude 6.52440, longitude 3.37920", "call back on +2348012345678"} {
+		if !strings.Contains(got, part) {
+			t.Errorf("voice default %q doesn't contain %q", got, part)
+		}
+	}
+
+	webhook, _ := targets.Resolve(AdminArea{Code: "NG-LA"}, cfg)
+	if got := webhook.Render(HandoffData{FirstName: "Ada", Location: "unknown", Callback: "+2348012345678"}); !strings.HasPrefix(got, "SafeTrace emergency: Ada needs help.") {
+		t.Errorf("webhook default = %q", got)
+	}
+}
+
+// agencyNotifier answers handoff texts and calls as a provider would
+type agencyNotifier struct {
+	Notifier // nil: anything else panics
+
+	mu    sync.Mutex
+	sms   []string // to: message
+	calls []string
+}
+
+func (n *agencyNotifier) SendSMS(ctx context.Context, kind, to, message string) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if kind != MessageAgency {
+		panic("handoff sent as " + kind)
+	}
+	n.sms = append(n.sms, to+": "+message)
+	if receipt, ok := ctx.Value(smsReceiptKey{}).(*smsReceipt); ok {
+		receipt.Provider, receipt.MessageID = "termii", "msg-1"
+	}
+	return nil
+}
+
+func (n *agencyNotifier) PlaceVoiceCall(ctx context.Context, kind, to, message string) (string, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if kind != MessageAgency {
+		panic("handoff called as " + kind)
+	}
+	n.calls = append(n.calls, to+": "+message)
+	return "call-1", nil
+}
+
+// agencyWebhook answers handoff posts with a fixed status and body
+type agencyWebhook struct {
+	status   int
+	response string
+
+	posts    int
+	url      string
+	name     string
+	payloads []AgencyHandoffPayload
+}
+
+func (w *agencyWebhook) PostWebhook(ctx context.Context, ch *models.NotificationChannel, payload []byte) (int, string, error) {
+	w.posts++
+	w.url, w.name = ch.WebhookURL, ch.Name
+	var p AgencyHandoffPayload
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return 0, "", err
+	}
+	w.payloads = append(w.payloads, p)
+	return w.status, w.response, nil
+}
+
+// Each channel sends the handoff to the target's endpoint and returns the
+// reference it was given
+func TestAgencyDispatch(t *testing.T) {
+	ctx := context.Background()
+	targets := fakeAgencyTargets(t)
+	cfg := &config.Config{}
+	notifier := &agencyNotifier{}
+	webhook := &agencyWebhook{status: 201, response: `{"incident_id": 48213, "reference": "ignored"}`}
+	s := &AgencyEscalation{notifier: notifier, channels: &ChannelNotifier{transport: webhook}}
+	data := sampleHandoffData
+	lastSeen := time.Date(2026, 1, 2, 14, 4, 0, 0, time.UTC)
+
+	sms, _ := targets.Resolve(AdminArea{Code: "NG-KN"}, cfg)
+	if ref, err := s.dispatch(ctx, sms, "text", data, nil); err != nil || ref != "msg-1" {
+		t.Errorf("sms = %q, %v; want the provider's message ID", ref, err)
+	}
+	if len(notifier.sms) != 1 || notifier.sms[0] != "+2348099990000: text" {
+		t.Errorf("texts = %q, want the duty number's", notifier.sms)
+	}
+
+	voice, _ := targets.Resolve(AdminArea{Name: "Oyo"}, cfg)
+	if ref, err := s.dispatch(ctx, voice, "spoken", data, nil); err != nil || ref != "call-1" {
+		t.Errorf("voice = %q, %v; want the call ID", ref, err)
+	}
+	if len(notifier.calls) != 1 || notifier.calls[0] != "+2348031234567: spoken" {
+		t.Errorf("calls = %q, want the duty number's", notifier.calls)
+	}
+
+	lagos, _ := targets.Resolve(AdminArea{Code: "NG-LA"}, cfg)
+	if ref, err := s.dispatch(ctx, lagos, "posted", data, &lastSeen); err != nil || ref != "48213" {
+		t.Errorf("webhook = %q, %v; want the agency's incident_id", ref, err)
+	}
+	if webhook.url != "https://lasema.example/handoff" || webhook.name != "Lagos State Emergency Command" {
+		t.Errorf("posted %s to %s", webhook.name, webhook.url)
+	}
+	p := webhook.payloads[0]
+	if p.Event != "alert_handoff" || p.AlertID != data.AlertID || p.FirstName != "Chiamaka" || p.Message != "posted" ||
+		p.Summary != data.Summary || p.CallbackNumber != "+2348012345678" || p.RegionCode != "NG-LA" || p.PlusCode != data.PlusCode {
+		t.Errorf("payload = %+v", p)
+	}
+	if p.Lat == nil || *p.Lat != data.Lat || p.Lng == nil || *p.Lng != data.Lng || p.LastSeenAt == nil || !p.LastSeenAt.Equal(lastSeen) {
+		t.Errorf("payload location %v,%v at %v; want the last heartbeat's", p.Lat, p.Lng, p.LastSeenAt)
+	}
+
+	// An unknown location is left out rather than sent as 0,0
+	if _, err := s.dispatch(ctx, lagos, "posted", HandoffData{AlertID: data.AlertID}, nil); err != nil {
+		t.Fatalf("webhook: %v", err)
+	}
+	if p := webhook.payloads[1]; p.Lat != nil || p.Lng != nil || p.LastSeenAt != nil {
+		t.Errorf("unlocated payload carries %v,%v at %v", p.Lat, p.Lng, p.LastSeenAt)
+	}
+
+	// The national default's webhook reads the default reference field
+	national, _ := targets.Resolve(AdminArea{Code: "GH-AA"}, &config.Config{
+		AgencyDefaultChannel: models.AgencyChannelWebhook, AgencyDefaultEndpoint: "https://npf.example/handoff",
+	})
+	if ref, err := s.dispatch(ctx, national, "posted", data, nil); err != nil || ref != "ignored" {
+		t.Errorf("national default = %q, %v; want the response's reference", ref, err)
+	}
+
+	// An agency rejecting the handoff fails it without a retry
+	webhook.status, webhook.response, webhook.posts = 422, "unknown state", 0
+	if ref, err := s.dispatch(ctx, lagos, "posted", data, nil); err == nil || ref != "" || webhook.posts != 1 {
+		t.Errorf("rejected = %q, %v after %d posts; want an error after one", ref, err, webhook.posts)
+	}
+
+	if _, err := s.dispatch(ctx, &AgencyRoute{Target: AgencyTarget{Channel: "fax"}}, "", data, nil); err == nil {
+		t.Error("dispatched on an unknown channel")
+	}
+}
+
+func TestHandoffReference(t *testing.T) {
+	tests := []struct {
+		response string
+		field    string
+		want     string
+	}{
+		{`{"reference": "LAS-2291"}`, "reference", "LAS-2291"},
+		{`{"incident_id": 48213}`, "incident_id", "48213"},
+		{`{"incident_id": 90071992547409931}`, "incident_id", "90071992547409931"}, // not rounded through a float
+		{`{"reference": "LAS-2291"}`, "incident_id", ""},
+		{`{"reference": {"id": 1}}`, "reference", ""},
+		{`{"reference": null}`, "reference", ""},
+		{`accepted`, "reference", ""},
+		{``, "reference", ""},
+	}
+	for _, tt := range tests {
+		if got := handoffReference(tt.response, tt.field); got != tt.want {
+			t.Errorf("handoffReference(%q, %q) = %q, want %q", tt.response, tt.field, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package services
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+)
+
+const (
+	EscalationOnAckSkip   = "skip"
+	EscalationOnAckDelay  = "delay"
+	EscalationOnAckIgnore = "ignore"
+
+	defaultEscalationAckDelay = 15 * time.Minute
+)
+
+// ackReplies are the SMS bodies treated as an acknowledgment from a contact
+var ackReplies = map[string]bool{
+	"OK":   true,
+	"OKAY": true,
+	"ACK":  true,
+	"YES":  true,
+	"SEEN": true,
+	"1":    true,
+}
+
+// IsAckReply reports whether an inbound SMS is an alert acknowledgment
+func IsAckReply(body string) bool {
+	normalized := strings.ToUpper(strings.Trim(strings.TrimSpace(body), ".!"))
+	return ackReplies[normalized]
+}
+
+// EscalationPolicy decides whether escalation proceeds once the alert's
+// acknowledgment state is known. Without an acknowledgment it always proceeds.
+func EscalationPolicy(settings models.UserSettings, acknowledged bool) (bool, time.Duration) {
+	if !acknowledged {
+		return true, 0
+	}
+
+	switch settings.EscalationOnAck {
+	case EscalationOnAckIgnore:
+		return true, 0
+	case EscalationOnAckDelay:
+		delay := time.Duration(settings.EscalationAckDelayMinutes) * time.Minute
+		if delay <= 0 {
+			delay = defaultEscalationAckDelay
+		}
+		return true, delay
+	default:
+		return false, 0
+	}
+}
+
+// generateAckToken returns an unguessable token for acknowledgment links
+func generateAckToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -2,66 +2,304 @@ package services
 
 import (
 	"context"
+	"encoding/xml"
 	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/twilio/twilio-go"
 	twilioApi "github.com/twilio/twilio-go/rest/api/v2010"
 	"firebase.google.com/go/v4/messaging"
+	"github.com/google/uuid"
 	"github.com/adedejiosvaldo/safetrace/backend/internal/config"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/country"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/database"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/flags"
 	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
 )
 
 type AlertEngine struct {
-	cfg          *config.Config
+	cfg          *config.Store
+	postgres     *database.PostgresDB
+	redis        *database.RedisDB
+	twilioMu     sync.RWMutex
 	twilioClient *twilio.RestClient
 	fcmClient    *messaging.Client
+	sms          *SMSRouter
+	templates    *MessageTemplates
+	locations    *LocationEncoder
+	usage        *SMSUsage
+	maps         *MapSnapshots
+	budget       *OutboundBudget
+	pacer        *ContactPacer
+	transport    messageTransport
 }
 
-func NewAlertEngine(cfg *config.Config, fcmClient *messaging.Client) *AlertEngine {
-	twilioClient := twilio.NewRestClientWithParams(twilio.ClientParams{
+// messageTransport puts messages on the wire. The live transport uses the SMS
+// router, Twilio WhatsApp and FCM; DevNotifier records them instead.
+type messageTransport interface {
+	SendSMS(ctx context.Context, to, body string) error
+	SendWhatsApp(ctx context.Context, to, body, mediaURL string) error // mediaURL may be empty
+	SendPush(ctx context.Context, message *messaging.Message) error
+	PlaceCall(ctx context.Context, to, twiml string) (string, error) // returns the call's ID
+}
+
+func NewAlertEngine(
+	cfg *config.Store,
+	postgres *database.PostgresDB,
+	redis *database.RedisDB,
+	fcmClient *messaging.Client,
+	sms *SMSRouter,
+	templates *MessageTemplates,
+	locations *LocationEncoder,
+	maps *MapSnapshots,
+	budget *OutboundBudget,
+) *AlertEngine {
+	ae := &AlertEngine{
+		cfg:          cfg,
+		postgres:     postgres,
+		redis:        redis,
+		twilioClient: newTwilioClient(cfg.Current()),
+		fcmClient:    fcmClient,
+		sms:          sms,
+		templates:    templates,
+		locations:    locations,
+		usage:        NewSMSUsage(redis),
+		maps:         maps,
+		budget:       budget,
+		pacer:        NewContactPacer(cfg, postgres, redis),
+	}
+	ae.transport = liveTransport{ae}
+
+	// Rebuild clients when credentials are rotated
+	cfg.OnChange(func(next *config.Config) {
+		ae.twilioMu.Lock()
+		ae.twilioClient = newTwilioClient(next)
+		ae.twilioMu.Unlock()
+		sms.Reload(next)
+	})
+
+	return ae
+}
+
+func newTwilioClient(cfg *config.Config) *twilio.RestClient {
+	return twilio.NewRestClientWithParams(twilio.ClientParams{
 		Username: cfg.TwilioAccountSID,
 		Password: cfg.TwilioAuthToken,
 	})
+}
 
-	return &AlertEngine{
-		cfg:          cfg,
-		twilioClient: twilioClient,
-		fcmClient:    fcmClient,
+func (ae *AlertEngine) currentTwilioClient() *twilio.RestClient {
+	ae.twilioMu.RLock()
+	defer ae.twilioMu.RUnlock()
+	return ae.twilioClient
+}
+
+// liveTransport sends through the real providers
+type liveTransport struct {
+	ae *AlertEngine
+}
+
+func (t liveTransport) SendSMS(ctx context.Context, to, body string) error {
+	return t.ae.sms.Send(ctx, to, body)
+}
+
+func (t liveTransport) SendWhatsApp(ctx context.Context, to, body, mediaURL string) error {
+	params := &twilioApi.CreateMessageParams{}
+	params.SetTo("whatsapp:" + to)
+	params.SetFrom("whatsapp:" + t.ae.cfg.Current().TwilioPhoneNumber)
+	params.SetBody(body)
+	if mediaURL != "" {
+		params.SetMediaUrl([]string{mediaURL})
+	}
+
+	resp, err := t.ae.currentTwilioClient().Api.CreateMessage(params)
+	if err != nil {
+		return fmt.Errorf("twilio WhatsApp error: %w", err)
+	}
+
+	if resp.ErrorCode != nil {
+		return fmt.Errorf("twilio error code: %d, message: %s", *resp.ErrorCode, *resp.ErrorMessage)
+	}
+
+	return nil
+}
+
+func (t liveTransport) PlaceCall(ctx context.Context, to, twiml string) (string, error) {
+	params := &twilioApi.CreateCallParams{}
+	params.SetTo(to)
+	params.SetFrom(t.ae.cfg.Current().TwilioPhoneNumber)
+	params.SetTwiml(twiml)
+
+	resp, err := t.ae.currentTwilioClient().Api.CreateCall(params)
+	if err != nil {
+		return "", fmt.Errorf("twilio voice error: %w", err)
+	}
+	if resp.Sid == nil {
+		return "", nil
+	}
+	return *resp.Sid, nil
+}
+
+func (t liveTransport) SendPush(ctx context.Context, message *messaging.Message) error {
+	if t.ae.fcmClient == nil {
+		return fmt.Errorf("FCM client not initialized")
+	}
+	if _, err := t.ae.fcmClient.Send(ctx, message); err != nil {
+		return fmt.Errorf("FCM error: %w", err)
 	}
+	return nil
 }
 
-// SendAlertToContacts sends alerts to all trusted contacts
+// SendAlertToContacts sends alerts to all trusted contacts, honouring each
+// contact's quiet hours, daily cap, pacing and channels. Every attempt is
+// recorded as a delivery row. A user with nobody to alert has the alert sent
+// to FALLBACK_ALERT_PHONE or, without one, marked undeliverable and pushed
+// to themselves.
 func (ae *AlertEngine) SendAlertToContacts(
 	ctx context.Context,
 	user *models.User,
+	alert *models.Alert,
 	heartbeat *models.Heartbeat,
-	score int,
-	reason string,
 ) error {
-	if len(user.TrustedContacts) == 0 {
-		return fmt.Errorf("no trusted contacts configured")
+	// Guardians, then the organization's escalation contacts, come first and
+	// are never suppressed. A phone number is only alerted once.
+	priority := append(ae.guardianContacts(ctx, user.ID), ae.orgEscalationContacts(ctx, user)...)
+	if len(user.TrustedContacts) == 0 && len(priority) == 0 {
+		priority = ae.fallbackContacts()
+		if len(priority) == 0 {
+			return ae.sendUndeliverable(ctx, user, alert)
+		}
+	}
+	isPriority := make(map[string]bool, len(priority))
+	seenPhones := make(map[string]bool, len(priority)+len(user.TrustedContacts))
+	var recipients []models.Contact
+	for _, contact := range priority {
+		if !seenPhones[contact.Phone] {
+			seenPhones[contact.Phone] = true
+			isPriority[contact.ID] = true
+			recipients = append(recipients, contact)
+		}
+	}
+	for _, contact := range user.TrustedContacts {
+		if !seenPhones[contact.Phone] {
+			seenPhones[contact.Phone] = true
+			recipients = append(recipients, contact)
+		}
 	}
 
-	// Generate map link
+	// Map snapshot, made once per alert; without one the alert goes out text-only
+	hasSnapshot := ae.maps.Ensure(ctx, alert, heartbeat)
+
+	// Generate map link. Mapbox links carry the token, so with a snapshot to
+	// show the map the message links to Google Maps instead.
 	mapLink := ae.generateMapLink(heartbeat.Lat, heartbeat.Lng)
+	if hasSnapshot {
+		mapLink = googleMapsLink(heartbeat.Lat, heartbeat.Lng)
+	}
 
-	// Build message
-	message := ae.buildAlertMessage(user, heartbeat, score, reason, mapLink)
+	// Build message, once for every contact without a reference location
+	data := ae.alertMessageData(ctx, user, heartbeat, alert.Score, AlertReasonText(alert), mapLink)
+	data.Note = alert.Note
+	message := ae.templates.Render(TemplateAlert, data)
 
-	// Send to each contact
+	// Send to each contact. Texts carry the alert, so their delivery reports
+	// can confirm it reached someone.
 	var errors []error
-	for _, contact := range user.TrustedContacts {
-		// Send SMS
-		if err := ae.SendSMS(contact.Phone, message); err != nil {
+	now := time.Now()
+	state := string(alert.State)
+	critical := CriticalMessage(alert)
+	pacing := flags.For(ctx, user.ID).Enabled(flags.ContactPacing)
+	smsCtx := withAlertSMS(ctx, alert.ID)
+	attempted := false
+	for _, contact := range recipients {
+		day := ContactDay(contact.Phone, contact.Preferences, now)
+
+		sentToday, err := ae.redis.GetContactDailyCount(ctx, contact.Phone, day)
+		if err != nil {
+			log.Printf("WARN: Failed to read daily count for %s: %v", contact.Phone, err)
+		}
+
+		if reason := SuppressionReason(contact.Phone, contact.Preferences, state, sentToday, now); reason != "" && !isPriority[contact.ID] {
+			ae.recordDelivery(ctx, alert, contact, "sms", models.DeliveryStatusSuppressed, reason)
+			continue
+		}
+
+		// The contact's own preferences span every user they protect. Past
+		// their pacing, a non-critical alert waits for a combined update.
+		channels := ae.pacer.Recipient(ctx, contact.Phone)
+		if !critical && !isPriority[contact.ID] && pacing {
+			entry := &models.ContactDigestEntry{
+				UserID:   user.ID,
+				UserName: user.Name,
+				Text:     fmt.Sprintf("%s at %s (%s)", state, FormatInUserZone(heartbeat.Timestamp, user.Settings, LayoutClock), AlertReasonText(alert)),
+				At:       now,
+			}
+			if !ae.pacer.Admit(ctx, channels, entry) {
+				ae.recordDelivery(ctx, alert, contact, "sms", models.DeliveryStatusSuppressed, contactPacingHeld)
+				continue
+			}
+		}
+
+		recipient := ae.createRecipient(ctx, alert, contact)
+		contactMessage := message
+		if channels.Reference != nil {
+			// Where the user is from the contact's home or workplace
+			contactData := data
+			contactData.FromYou = RelativePlace(*channels.Reference, heartbeat.Lat, heartbeat.Lng)
+			contactMessage = ae.templates.Render(TemplateAlert, contactData)
+		}
+		mediaURL := ""
+		if recipient != nil {
+			if hasSnapshot {
+				// The link is the recipient's own, like their ack link
+				mediaURL = ae.maps.Link(recipient.AckToken)
+				contactMessage += "\n\nMap: " + mediaURL
+			}
+			contactMessage += ackInstructions(ae.cfg.Current().PublicBaseURL, recipient.AckToken)
+		}
+
+		// Send SMS; critical alerts go by SMS whatever the contact chose
+		if !attempted {
+			attempted = true
+			ae.markAttempted(ctx, alert)
+		}
+		var receipt smsReceipt
+		smsOff := !critical && !channels.SMSEnabled
+		if smsOff {
+			ae.recordDelivery(ctx, alert, contact, "sms", models.DeliveryStatusSuppressed, "SMS turned off by the contact")
+		} else if err := ae.SendSMS(withSMSReceipt(smsCtx, &receipt), MessageAlert, contact.Phone, contactMessage); err != nil {
 			errors = append(errors, fmt.Errorf("failed to send SMS to %s: %w", contact.Phone, err))
+			ae.recordDelivery(ctx, alert, contact, "sms", models.DeliveryStatusFailed, err.Error())
+		} else {
+			ae.recordSMSDelivery(ctx, alert, contact, receipt)
+			ae.markRecipientDelivered(ctx, recipient, "sms")
+			ae.usage.Record(ctx, user, 1)
+			if state != StateAlert {
+				if err := ae.redis.IncrContactDailyCount(ctx, contact.Phone, day); err != nil {
+					log.Printf("WARN: Failed to update daily count for %s: %v", contact.Phone, err)
+				}
+			}
 		}
 
-		// Try WhatsApp as well (if number supports it)
+		// Try WhatsApp as well (if number supports it), with the map attached
 		// WhatsApp requires "whatsapp:" prefix
-		if err := ae.SendWhatsApp(contact.Phone, message); err != nil {
+		if !channels.WhatsAppEnabled {
+			continue
+		}
+		if err := ae.SendWhatsApp(ctx, MessageAlert, contact.Phone, contactMessage, mediaURL); err != nil && smsOff {
+			// The contact's only channel
+			errors = append(errors, fmt.Errorf("failed to send WhatsApp to %s: %w", contact.Phone, err))
+			ae.recordDelivery(ctx, alert, contact, "whatsapp", models.DeliveryStatusFailed, err.Error())
+		} else if err != nil {
 			// Log but don't fail - WhatsApp is optional
 			fmt.Printf("WhatsApp failed for %s: %v\n", contact.Phone, err)
+		} else {
+			ae.recordDelivery(ctx, alert, contact, "whatsapp", models.DeliveryStatusSent, "")
+			ae.markRecipientDelivered(ctx, recipient, "whatsapp")
 		}
 	}
 
@@ -72,51 +310,240 @@ func (ae *AlertEngine) SendAlertToContacts(
 	return nil
 }
 
-// SendSMS sends an SMS via Twilio
-func (ae *AlertEngine) SendSMS(to, message string) error {
-	params := &twilioApi.CreateMessageParams{}
-	params.SetTo(to)
-	params.SetFrom(ae.cfg.TwilioPhoneNumber)
-	params.SetBody(message)
+// guardianContacts returns the ward's guardians that receive alerts, as contacts.
+// Lookup failures are logged so trusted contacts are still notified.
+func (ae *AlertEngine) guardianContacts(ctx context.Context, wardID uuid.UUID) []models.Contact {
+	links, err := ae.postgres.GetGuardiansWithPermission(ctx, wardID, models.LinkPermissionAlerts)
+	if err != nil {
+		log.Printf("ERROR: Failed to load guardians for user %s: %v", wardID, err)
+		return nil
+	}
+
+	var contacts []models.Contact
+	for _, link := range links {
+		guardian, err := ae.postgres.GetUserByID(ctx, link.GuardianUserID)
+		if err != nil || guardian == nil {
+			log.Printf("ERROR: Failed to load guardian %s for user %s: %v", link.GuardianUserID, wardID, err)
+			continue
+		}
+		contacts = append(contacts, models.Contact{
+			ID:    "guardian:" + guardian.ID.String(),
+			Name:  guardian.Name,
+			Phone: guardian.Phone,
+		})
+	}
+	return contacts
+}
+
+// orgEscalationContacts returns the escalation contacts of the user's
+// organization, e.g. campus security, with IDs prefixed so they can't clash
+// with the user's own. Lookup failures are logged so the user's own contacts
+// are still notified.
+func (ae *AlertEngine) orgEscalationContacts(ctx context.Context, user *models.User) []models.Contact {
+	if user.OrgID == nil {
+		return nil
+	}
+	org, err := ae.postgres.GetOrganization(ctx, *user.OrgID)
+	if err != nil || org == nil {
+		log.Printf("ERROR: Failed to load organization %s for user %s: %v", *user.OrgID, user.ID, err)
+		return nil
+	}
+
+	contacts := make([]models.Contact, 0, len(org.EscalationContacts))
+	for _, contact := range org.EscalationContacts {
+		contact.ID = "org:" + org.ID.String() + ":" + contact.ID
+		contacts = append(contacts, contact)
+	}
+	return contacts
+}
 
-	resp, err := ae.twilioClient.Api.CreateMessage(params)
+// fallbackContactID identifies the fallback recipient in deliveries
+const fallbackContactID = "fallback"
+
+// fallbackContacts returns the deployment's fallback recipient, e.g. a
+// monitoring desk, as a contact, or none if FALLBACK_ALERT_PHONE is unset
+func (ae *AlertEngine) fallbackContacts() []models.Contact {
+	phone := ae.cfg.Current().FallbackAlertPhone
+	if phone == "" {
+		return nil
+	}
+	return []models.Contact{{ID: fallbackContactID, Name: "Monitoring desk", Phone: phone}}
+}
+
+// sendUndeliverable handles an alert nobody can be sent: it is marked
+// undeliverable, so admins see it first among active alerts, and the user
+// gets a maximum-urgency push instead. It returns an error even when the
+// push went out, as nobody else was told.
+func (ae *AlertEngine) sendUndeliverable(ctx context.Context, user *models.User, alert *models.Alert) error {
+	if err := ae.postgres.MarkAlertUndeliverable(ctx, alert.ID, models.AlertNoRecipients); err != nil {
+		log.Printf("ERROR: Failed to mark alert %s undeliverable: %v", alert.ID, err)
+	}
+
+	token, err := ae.postgres.GetPushToken(ctx, user.ID)
 	if err != nil {
-		return fmt.Errorf("twilio SMS error: %w", err)
+		return fmt.Errorf("no recipients, and the user's device could not be loaded: %w", err)
 	}
+	if token == "" {
+		return fmt.Errorf("no recipients, and the user has no registered device")
+	}
+	err = ae.transport.SendPush(ctx, &messaging.Message{
+		Token: token,
+		Notification: &messaging.Notification{
+			Title: "Nobody was alerted",
+			Body:  "SafeTrace thinks you may need help, but you have no trusted contacts to alert. If you are in danger, call " + country.Active().ForPhone(user.Phone).Emergency.General + " now.",
+		},
+		Data: map[string]string{
+			"type":     "alert_undeliverable",
+			"alert_id": alert.ID.String(),
+		},
+		Android: &messaging.AndroidConfig{
+			Priority: "high",
+			Notification: &messaging.AndroidNotification{
+				Priority:   messaging.PriorityMax,
+				Visibility: messaging.VisibilityPublic,
+				Sound:      "default",
+				Sticky:     true,
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("no recipients, and the push to the user failed: %w", err)
+	}
+	return fmt.Errorf("no recipients; only the user was notified")
+}
 
-	if resp.ErrorCode != nil {
-		return fmt.Errorf("twilio error code: %d, message: %s", *resp.ErrorCode, *resp.ErrorMessage)
+// recordDelivery stores the outcome of a notification attempt; failures are only logged
+func (ae *AlertEngine) recordDelivery(ctx context.Context, alert *models.Alert, contact models.Contact, channel, status, detail string) {
+	ae.storeDelivery(ctx, alert, contact, channel, status, detail, smsReceipt{})
+}
+
+// recordSMSDelivery stores an SMS a provider accepted, with the provider's
+// message ID so the delivery can be traced in its records
+func (ae *AlertEngine) recordSMSDelivery(ctx context.Context, alert *models.Alert, contact models.Contact, receipt smsReceipt) {
+	ae.storeDelivery(ctx, alert, contact, "sms", models.DeliveryStatusSent, "", receipt)
+}
+
+func (ae *AlertEngine) storeDelivery(ctx context.Context, alert *models.Alert, contact models.Contact, channel, status, detail string, receipt smsReceipt) {
+	delivery := &models.AlertDelivery{
+		ID:                uuid.New(),
+		AlertID:           alert.ID,
+		ContactID:         contact.ID,
+		ContactName:       contact.Name,
+		Phone:             contact.Phone,
+		Channel:           channel,
+		Status:            status,
+		Detail:            detail,
+		CreatedAt:         time.Now(),
+		Provider:          receipt.Provider,
+		ProviderMessageID: receipt.MessageID,
+	}
+	if err := ae.postgres.CreateAlertDelivery(ctx, delivery); err != nil {
+		log.Printf("ERROR: Failed to record %s delivery to %s for alert %s: %v", channel, contact.Phone, alert.ID, err)
 	}
+}
 
-	return nil
+// createRecipient registers the contact as an alert recipient with a fresh
+// acknowledgment token. It returns nil if the row could not be stored, in
+// which case the alert is still sent, just without an ack link.
+func (ae *AlertEngine) createRecipient(ctx context.Context, alert *models.Alert, contact models.Contact) *models.AlertRecipient {
+	token, err := generateAckToken()
+	if err != nil {
+		log.Printf("ERROR: Failed to generate ack token for alert %s: %v", alert.ID, err)
+		return nil
+	}
+
+	recipient := &models.AlertRecipient{
+		ID:          uuid.New(),
+		AlertID:     alert.ID,
+		ContactID:   contact.ID,
+		ContactName: contact.Name,
+		Phone:       contact.Phone,
+		Channel:     "sms",
+		AckToken:    token,
+		CreatedAt:   time.Now(),
+	}
+	if err := ae.postgres.CreateAlertRecipient(ctx, recipient); err != nil {
+		log.Printf("ERROR: Failed to record recipient %s for alert %s: %v", contact.Phone, alert.ID, err)
+		return nil
+	}
+	return recipient
 }
 
-// SendWhatsApp sends a WhatsApp message via Twilio
-func (ae *AlertEngine) SendWhatsApp(to, message string) error {
-	params := &twilioApi.CreateMessageParams{}
-	params.SetTo("whatsapp:" + to)
-	params.SetFrom("whatsapp:" + ae.cfg.TwilioPhoneNumber)
-	params.SetBody(message)
+// markAttempted records the first attempt at messaging one of the alert's
+// contacts, for the time-to-alert SLO; a failure is only logged
+func (ae *AlertEngine) markAttempted(ctx context.Context, alert *models.Alert) {
+	if err := ae.postgres.MarkAlertAttempted(ctx, alert.ID, time.Now()); err != nil {
+		log.Printf("WARN: Failed to record first delivery attempt for alert %s: %v", alert.ID, err)
+	}
+}
 
-	resp, err := ae.twilioClient.Api.CreateMessage(params)
+func (ae *AlertEngine) markRecipientDelivered(ctx context.Context, recipient *models.AlertRecipient, channel string) {
+	if recipient == nil {
+		return
+	}
+	if err := ae.postgres.MarkAlertRecipientDelivered(ctx, recipient.ID, channel); err != nil {
+		log.Printf("ERROR: Failed to mark recipient %s delivered: %v", recipient.ID, err)
+	}
+}
+
+// ackInstructions tells the contact how to acknowledge the alert, with a
+// link to baseURL when the deployment has one
+func ackInstructions(baseURL, token string) string {
+	if baseURL == "" {
+		return "\n\nReply OK to let us know you've seen this."
+	}
+	return fmt.Sprintf(
+		"\n\nReply OK or open %s/ack/%s to let us know you've seen this.",
+		strings.TrimRight(baseURL, "/"), token,
+	)
+}
+
+// ShouldEscalate applies the user's acknowledgment policy to an alert. It
+// reports whether the next escalation tier should run and how long to wait first.
+func (ae *AlertEngine) ShouldEscalate(ctx context.Context, user *models.User, alertID uuid.UUID) (bool, time.Duration, error) {
+	acked, err := ae.postgres.HasAlertAcknowledgment(ctx, alertID)
 	if err != nil {
-		return fmt.Errorf("twilio WhatsApp error: %w", err)
+		return false, 0, err
 	}
+	escalate, delay := EscalationPolicy(user.Settings, acked)
+	return escalate, delay, nil
+}
 
-	if resp.ErrorCode != nil {
-		return fmt.Errorf("twilio error code: %d, message: %s", *resp.ErrorCode, *resp.ErrorMessage)
+// SendSMS sends an SMS through the provider best suited to the recipient's
+// carrier, unless the outbound budget drops it
+func (ae *AlertEngine) SendSMS(ctx context.Context, kind, to, message string) error {
+	if err := ae.budget.Claim(ctx, kind); err != nil {
+		return err
 	}
+	return ae.transport.SendSMS(withSMSKind(ctx, kind), to, message)
+}
 
-	return nil
+// SendWhatsApp sends a WhatsApp message via Twilio, with the image at
+// mediaURL attached unless it is empty, unless the outbound budget drops it
+func (ae *AlertEngine) SendWhatsApp(ctx context.Context, kind, to, message, mediaURL string) error {
+	if err := ae.budget.Claim(ctx, kind); err != nil {
+		return err
+	}
+	return ae.transport.SendWhatsApp(ctx, to, message, mediaURL)
 }
 
-// SendPushNotification sends a push notification via FCM
-func (ae *AlertEngine) SendPushNotification(ctx context.Context, fcmToken, title, body string) error {
-	if ae.fcmClient == nil {
-		return fmt.Errorf("FCM client not initialized")
+// PlaceVoiceCall calls a number through Twilio and reads the message out
+// twice, unless the outbound budget drops it, and returns the call's ID
+func (ae *AlertEngine) PlaceVoiceCall(ctx context.Context, kind, to, message string) (string, error) {
+	if err := ae.budget.Claim(ctx, kind); err != nil {
+		return "", err
 	}
+	var said strings.Builder
+	if err := xml.EscapeText(&said, []byte(message)); err != nil {
+		return "", err
+	}
+	twiml := fmt.Sprintf(`<Response><Say>%s</Say><Pause length="2"/><Say>%s</Say></Response>`, said.String(), said.String())
+	return ae.transport.PlaceCall(ctx, to, twiml)
+}
 
-	message := &messaging.Message{
+// SendPushNotification sends a push notification via FCM
+func (ae *AlertEngine) SendPushNotification(ctx context.Context, fcmToken, title, body string) error {
+	return ae.transport.SendPush(ctx, &messaging.Message{
 		Token: fcmToken,
 		Notification: &messaging.Notification{
 			Title: title,
@@ -129,70 +556,218 @@ func (ae *AlertEngine) SendPushNotification(ctx context.Context, fcmToken, title
 				Sound:    "default",
 			},
 		},
-	}
+	})
+}
 
-	_, err := ae.fcmClient.Send(ctx, message)
-	if err != nil {
-		return fmt.Errorf("FCM error: %w", err)
+// SendCheckInPrompt asks the app to check in on a prompt within seconds. A
+// silent one is a data message the app answers in the background; a visible
+// one also shows a notification the user taps to answer.
+func (ae *AlertEngine) SendCheckInPrompt(ctx context.Context, fcmToken, promptID string, visible bool, seconds int) error {
+	message := &messaging.Message{
+		Token: fcmToken,
+		Data: map[string]string{
+			"type":           "check_in",
+			"prompt_id":      promptID,
+			"respond_within": strconv.Itoa(seconds),
+		},
+		Android: &messaging.AndroidConfig{
+			Priority: "high",
+		},
 	}
+	if visible {
+		message.Notification = &messaging.Notification{
+			Title: "Are you okay?",
+			Body:  "Tap to confirm you're okay",
+		}
+		message.Android.Notification = &messaging.AndroidNotification{
+			Priority: messaging.PriorityHigh,
+			Sound:    "default",
+		}
+	}
+	return ae.transport.SendPush(ctx, message)
+}
 
-	return nil
+// SendAutoResolvePrompt asks the user whether to resolve their alert now
+// that they look home safe, within seconds. The app answers on the alert's
+// auto-resolve endpoint.
+func (ae *AlertEngine) SendAutoResolvePrompt(ctx context.Context, fcmToken, alertID string, seconds int) error {
+	return ae.transport.SendPush(ctx, &messaging.Message{
+		Token: fcmToken,
+		Notification: &messaging.Notification{
+			Title: "Home safe?",
+			Body:  "Looks like you're home safe — resolve the alert?",
+		},
+		Data: map[string]string{
+			"type":           "auto_resolve",
+			"alert_id":       alertID,
+			"respond_within": strconv.Itoa(seconds),
+		},
+		Android: &messaging.AndroidConfig{
+			Priority: "high",
+			Notification: &messaging.AndroidNotification{
+				Priority: messaging.PriorityHigh,
+				Sound:    "default",
+			},
+		},
+	})
 }
 
-// SendSilentPing sends a silent check notification to user
-func (ae *AlertEngine) SendSilentPing(ctx context.Context, fcmToken string) error {
-	return ae.SendPushNotification(
-		ctx,
-		fcmToken,
-		"Are you safe?",
-		"Tap to confirm you're okay",
-	)
+// SendProtectionIncomplete warns the user that nobody will be alerted until
+// they add a trusted contact. The notification stays when tapped, and the
+// app keeps it up until the status reports protection complete.
+func (ae *AlertEngine) SendProtectionIncomplete(ctx context.Context, fcmToken string) error {
+	return ae.transport.SendPush(ctx, &messaging.Message{
+		Token: fcmToken,
+		Notification: &messaging.Notification{
+			Title: "You're not protected yet",
+			Body:  "Add a trusted contact so someone is alerted if you need help.",
+		},
+		Data: map[string]string{
+			"type": "protection_incomplete",
+		},
+		Android: &messaging.AndroidConfig{
+			Priority: "high",
+			Notification: &messaging.AndroidNotification{
+				Priority: messaging.PriorityHigh,
+				Sound:    "default",
+				Sticky:   true,
+			},
+		},
+	})
+}
+
+// SendConsentRequired asks the user to review an updated privacy policy;
+// the features of scopes are off until they consent to it again
+func (ae *AlertEngine) SendConsentRequired(ctx context.Context, fcmToken string, scopes []string) error {
+	return ae.transport.SendPush(ctx, &messaging.Message{
+		Token: fcmToken,
+		Notification: &messaging.Notification{
+			Title: "Our privacy policy has changed",
+			Body:  "Review it to keep using the features it covers. They are paused until you do.",
+		},
+		Data: map[string]string{
+			"type":   "consent_required",
+			"scopes": strings.Join(scopes, ","),
+		},
+		Android: &messaging.AndroidConfig{
+			Priority: "high",
+		},
+	})
+}
+
+// SendReportVerification asks the user whether they sent an unverified
+// report, a position sent with their phone number but without the app's
+// keys. The app answers on the report's confirm endpoint.
+func (ae *AlertEngine) SendReportVerification(ctx context.Context, fcmToken, reportID string) error {
+	return ae.transport.SendPush(ctx, &messaging.Message{
+		Token: fcmToken,
+		Notification: &messaging.Notification{
+			Title: "Was this you?",
+			Body:  "Your location was just sent from a phone without SafeTrace's keys. Tap to confirm it was you.",
+		},
+		Data: map[string]string{
+			"type":      "verify_report",
+			"report_id": reportID,
+		},
+		Android: &messaging.AndroidConfig{
+			Priority: "high",
+			Notification: &messaging.AndroidNotification{
+				Priority: messaging.PriorityHigh,
+				Sound:    "default",
+			},
+		},
+	})
+}
+
+// Tracking commands sent to the device as FCM data messages
+const (
+	TrackingStart = "start"
+	TrackingStop  = "stop"
+)
+
+// SendTrackingCommand asks the app to start or stop background tracking
+func (ae *AlertEngine) SendTrackingCommand(ctx context.Context, fcmToken, action string) error {
+	return ae.transport.SendPush(ctx, &messaging.Message{
+		Token: fcmToken,
+		Data: map[string]string{
+			"type":   "tracking",
+			"action": action,
+		},
+		Android: &messaging.AndroidConfig{
+			Priority: "high",
+		},
+	})
 }
 
-// buildAlertMessage constructs the alert SMS message
-func (ae *AlertEngine) buildAlertMessage(
+// SendIntervalAdvice tells the app, silently, how often to send heartbeats
+func (ae *AlertEngine) SendIntervalAdvice(ctx context.Context, fcmToken string, seconds int, reason string) error {
+	return ae.transport.SendPush(ctx, &messaging.Message{
+		Token: fcmToken,
+		Data: map[string]string{
+			"type":                  "heartbeat_interval",
+			"next_interval_seconds": strconv.Itoa(seconds),
+			"reason":                reason,
+		},
+		Android: &messaging.AndroidConfig{
+			Priority: "high",
+		},
+	})
+}
+
+// alertMessageData gathers what the alert SMS message renders. The
+// what3words address is included only if it was looked up in time; the
+// message never waits for it.
+func (ae *AlertEngine) alertMessageData(
+	ctx context.Context,
 	user *models.User,
 	hb *models.Heartbeat,
 	score int,
 	reason string,
 	mapLink string,
-) string {
-	timestamp := hb.Timestamp.Format("Jan 2, 3:04 PM")
-	
-	msg := fmt.Sprintf(
-		"🚨 SAFETRACE ALERT\n\n"+
-			"%s may be in danger.\n\n"+
-			"Last seen: %s\n"+
-			"Location: %.6f, %.6f (±%dm)\n"+
-			"Confidence: %d%%\n"+
-			"Reason: %s\n\n"+
-			"Map: %s\n\n"+
-			"Please check on them immediately.\n"+
-			"Contact: %s",
-		user.Name,
-		timestamp,
-		hb.Lat,
-		hb.Lng,
-		hb.AccuracyM,
-		score,
-		reason,
-		mapLink,
-		user.Phone,
-	)
-
-	return msg
+) MessageData {
+	codes := ae.locations.Codes(ctx, hb.Lat, hb.Lng)
+	place := fmt.Sprintf("%.6f, %.6f (±%dm)", hb.Lat, hb.Lng, hb.AccuracyM)
+	if hb.Landmark != "" {
+		// Typed by the user over USSD, who had no GPS to send
+		place = fmt.Sprintf("%q, as the user described it; last known %s", hb.Landmark, place)
+	}
+	// A heartbeat without a country code leaves the user where they last were
+	var previous *models.Roaming
+	if state, err := ae.redis.GetUserState(ctx, user.ID); err == nil && state != nil {
+		previous = state.Roaming
+	}
+	var roaming string
+	if r := RoamingOf(country.Active().ForPhone(user.Phone), hb.CellInfo, previous); r != nil {
+		roaming = r.Country
+	}
+	return MessageData{
+		Name:         user.Name,
+		Time:         FormatInUserZone(hb.Timestamp, user.Settings, LayoutDateTime),
+		Place:        place,
+		PlusCode:     codes.PlusCode,
+		What3Words:   codes.What3Words,
+		MapLink:      mapLink,
+		Score:        score,
+		Reason:       reason,
+		ContactPhone: user.Phone,
+		Roaming:      roaming,
+	}
 }
 
 // generateMapLink creates a link to view location on map
 func (ae *AlertEngine) generateMapLink(lat, lng float64) string {
-	if ae.cfg.MapboxToken != "" {
+	if token := ae.cfg.Current().MapboxToken; token != "" {
 		// Mapbox static map
 		return fmt.Sprintf(
 			"https://api.mapbox.com/styles/v1/mapbox/streets-v11/static/pin-s+f74e4e(%.6f,%.6f)/%.6f,%.6f,15,0/600x400@2x?access_token=%s",
-			lng, lat, lng, lat, ae.cfg.MapboxToken,
+			lng, lat, lng, lat, token,
 		)
 	}
 	// Fallback to Google Maps
+	return googleMapsLink(lat, lng)
+}
+
+func googleMapsLink(lat, lng float64) string {
 	return fmt.Sprintf("https://www.google.com/maps?q=%.6f,%.6f", lat, lng)
 }
 
@@ -206,26 +781,73 @@ func (ae *AlertEngine) SendHeartbeatReceivedConfirmation(ctx context.Context, fc
 	)
 }
 
-// SendLastGaspAcknowledgment confirms LastGasp received
-func (ae *AlertEngine) SendLastGaspAcknowledgment(to string) error {
+// SendLastGaspAcknowledgment texts the user that their LastGasp was received
+func (ae *AlertEngine) SendLastGaspAcknowledgment(ctx context.Context, user *models.User) error {
 	message := "SafeTrace: Your emergency location has been recorded. We're monitoring your situation."
-	return ae.SendSMS(to, message)
+	if err := ae.SendSMS(ctx, MessageLastGaspAck, user.Phone, message); err != nil {
+		return err
+	}
+	ae.usage.Record(ctx, user, 1)
+	return nil
 }
 
-// SendAlertResolved notifies contacts that user is safe
-func (ae *AlertEngine) SendAlertResolved(ctx context.Context, user *models.User) error {
-	message := fmt.Sprintf(
-		"✅ SafeTrace Update\n\n"+
-			"%s has confirmed they are safe.\n"+
-			"Alert resolved at %s.",
-		user.Name,
-		time.Now().Format("Jan 2, 3:04 PM"),
-	)
+// autoResolvedNote is appended to the resolution message of an alert
+// resolved automatically, so contacts know nobody resolved it by hand
+const autoResolvedNote = "\n(Resolved automatically: they got home to a safe zone and confirmed in the app.)"
+
+// SendAlertResolved notifies contacts, and the organization's escalation
+// contacts who were alerted with them, that user is safe. The message says
+// so when the alert was resolved automatically. Trusted contacts past their
+// pacing get it in their next combined update instead.
+func (ae *AlertEngine) SendAlertResolved(ctx context.Context, user *models.User, automatic bool) error {
+	message := ae.templates.Render(TemplateResolved, MessageData{
+		Name:         user.Name,
+		Time:         FormatInUserZone(time.Now(), user.Settings, LayoutDateTime),
+		ContactPhone: user.Phone,
+	})
+	if automatic {
+		message += autoResolvedNote
+	}
 
 	var errors []error
-	for _, contact := range user.TrustedContacts {
-		if err := ae.SendSMS(contact.Phone, message); err != nil {
+	sent := make(map[string]bool)
+	pacing := flags.For(ctx, user.ID).Enabled(flags.ContactPacing)
+	escalation := ae.orgEscalationContacts(ctx, user)
+	if len(escalation) == 0 && len(user.TrustedContacts) == 0 && len(ae.guardianContacts(ctx, user.ID)) == 0 {
+		// The fallback recipient was alerted in their place
+		escalation = ae.fallbackContacts()
+	}
+	for i, contact := range append(escalation, user.TrustedContacts...) {
+		if sent[contact.Phone] {
+			continue
+		}
+		sent[contact.Phone] = true
+		if i < len(escalation) {
+			if err := ae.SendSMS(ctx, MessageResolved, contact.Phone, message); err != nil {
+				errors = append(errors, err)
+				continue
+			}
+			ae.usage.Record(ctx, user, 1)
+			continue
+		}
+
+		channels := ae.pacer.Recipient(ctx, contact.Phone)
+		entry := &models.ContactDigestEntry{
+			UserID:   user.ID,
+			UserName: user.Name,
+			Text:     "safe again at " + FormatInUserZone(time.Now(), user.Settings, LayoutClock),
+			At:       time.Now(),
+		}
+		if pacing && !ae.pacer.Admit(ctx, channels, entry) {
+			continue
+		}
+		channel, err := ae.sendByChannel(ctx, MessageResolved, channels, message)
+		if err != nil {
 			errors = append(errors, err)
+			continue
+		}
+		if channel == "sms" {
+			ae.usage.Record(ctx, user, 1)
 		}
 	}
 
@@ -235,3 +857,19 @@ func (ae *AlertEngine) SendAlertResolved(ctx context.Context, user *models.User)
 
 	return nil
 }
+
+// SendContactDigest sends a combined update of the messages held back by the
+// contact's pacing
+func (ae *AlertEngine) SendContactDigest(ctx context.Context, phone, message string) error {
+	_, err := ae.sendByChannel(ctx, MessageDigest, ae.pacer.Recipient(ctx, phone), message)
+	return err
+}
+
+// sendByChannel sends a message to a contact by SMS or, if they turned SMS
+// off, by WhatsApp, and returns the channel it went by
+func (ae *AlertEngine) sendByChannel(ctx context.Context, kind string, recipient models.ContactRecipient, message string) (string, error) {
+	if !recipient.SMSEnabled && recipient.WhatsAppEnabled {
+		return "whatsapp", ae.SendWhatsApp(ctx, kind, recipient.Phone, message, "")
+	}
+	return "sms", ae.SendSMS(ctx, kind, recipient.Phone, message)
+}
@@ -0,0 +1,153 @@
+package services
+
+import (
+	"context"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/config"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+)
+
+// AlertNoteMaxRunes is the longest note a panic carries into its alert. A
+// plain-text note this long keeps the alert template, rendered with the
+// sample data, within its segment budget.
+const AlertNoteMaxRunes = 120
+
+// sampleAckToken stands in for a recipient's acknowledgment token in
+// previews; real ones are as long
+const sampleAckToken = "3f9a6c1e2b7d4a8f9c0e1d2b3a4f5e6d"
+
+// CleanAlertNote makes a note typed by the user safe to quote in an alert:
+// runs of whitespace, line breaks included, become one space, control and
+// bidirectional override characters are dropped, and the note is cut to
+// AlertNoteMaxRunes. It returns the note and how many characters were cut.
+// A cut never splits a character from the accents or emoji modifiers that
+// follow it.
+func CleanAlertNote(note string) (string, int) {
+	note = strings.ToValidUTF8(note, "")
+	var b strings.Builder
+	space := false
+	for _, r := range note {
+		switch {
+		case unicode.IsSpace(r):
+			space = true
+			continue
+		case unicode.IsControl(r), isBidiControl(r):
+			continue
+		}
+		if space && b.Len() > 0 {
+			b.WriteByte(' ')
+		}
+		space = false
+		b.WriteRune(r)
+	}
+
+	cleaned := []rune(b.String())
+	if len(cleaned) <= AlertNoteMaxRunes {
+		return string(cleaned), 0
+	}
+	cut := AlertNoteMaxRunes
+	for cut > 0 && (joinsPrevious(cleaned[cut]) || cleaned[cut-1] == '\u200d') {
+		cut--
+	}
+	kept := strings.TrimRight(string(cleaned[:cut]), " ")
+	return kept, len(cleaned) - utf8.RuneCountInString(kept)
+}
+
+// isBidiControl reports whether r is an explicit bidirectional formatting
+// character, which could make a quoted note read as something else
+func isBidiControl(r rune) bool {
+	return (r >= '\u202a' && r <= '\u202e') || (r >= '\u2066' && r <= '\u2069')
+}
+
+// joinsPrevious reports whether r belongs with the character before it: a
+// combining accent (as in Yoruba ẹ́), a zero-width joiner, a variation
+// selector or an emoji skin tone
+func joinsPrevious(r rune) bool {
+	return unicode.Is(unicode.Mn, r) || r == '\u200d' ||
+		unicode.Is(unicode.Variation_Selector, r) || (r >= 0x1f3fb && r <= 0x1f3ff)
+}
+
+// alertNoteKey is the context key of the note of the panic raising an alert
+type alertNoteKey struct{}
+
+// withAlertNote returns ctx carrying the note for the alert raised under it
+func withAlertNote(ctx context.Context, note string) context.Context {
+	if note == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, alertNoteKey{}, note)
+}
+
+// alertNote returns the note an alert raised under ctx carries, if any
+func alertNote(ctx context.Context) string {
+	note, _ := ctx.Value(alertNoteKey{}).(string)
+	return note
+}
+
+// MessageSize is how a rendered message goes out on one channel
+type MessageSize struct {
+	Encoding string `json:"encoding,omitempty"` // gsm7 | ucs2, for SMS
+	Length   int    `json:"length"`             // in encoding units, or characters
+	Segments int    `json:"segments"`
+}
+
+// AlertPreview is the alert a contact would get for a panic with a note
+type AlertPreview struct {
+	Rendered      string                 `json:"rendered"`
+	Note          string                 `json:"note"` // as it would be stored and quoted
+	NoteTruncated bool                   `json:"note_truncated"`
+	NoteDropped   int                    `json:"note_dropped"` // characters cut from the end
+	NoteMaxLength int                    `json:"note_max_length"`
+	Channels      map[string]MessageSize `json:"channels"`
+}
+
+// AlertPreviewer renders the alert template for the app, so a user can see
+// how a note would read before relying on it
+type AlertPreviewer struct {
+	cfg       *config.Store
+	templates *MessageTemplates
+}
+
+func NewAlertPreviewer(cfg *config.Store, templates *MessageTemplates) *AlertPreviewer {
+	return &AlertPreviewer{
+		cfg:       cfg,
+		templates: templates,
+	}
+}
+
+// Preview renders the alert template in use as a contact would get it for a
+// panic from user with the note: their name and phone, the sample location,
+// and the acknowledgment instructions. A map snapshot link, added when one
+// is made, isn't counted.
+func (p *AlertPreviewer) Preview(user *models.User, note string) *AlertPreview {
+	cleaned, dropped := CleanAlertNote(note)
+	data := SampleMessageData()
+	data.Name = user.Name
+	data.ContactPhone = user.Phone
+	data.Note = cleaned
+	rendered := p.templates.Render(TemplateAlert, data) + ackInstructions(p.cfg.Current().PublicBaseURL, sampleAckToken)
+
+	encoding, length, segments := SMSSegments(rendered)
+	return &AlertPreview{
+		Rendered:      rendered,
+		Note:          cleaned,
+		NoteTruncated: dropped > 0,
+		NoteDropped:   dropped,
+		NoteMaxLength: AlertNoteMaxRunes,
+		Channels: map[string]MessageSize{
+			"sms": {
+				Encoding: encoding,
+				Length:   length,
+				Segments: segments,
+			},
+			// WhatsApp messages aren't split
+			"whatsapp": {
+				Length:   utf8.RuneCountInString(rendered),
+				Segments: 1,
+			},
+		},
+	}
+}
@@ -0,0 +1,88 @@
+package services
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/config"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+)
+
+func TestCleanAlertNote(t *testing.T) {
+	tests := []struct {
+		name        string
+		note        string
+		want        string
+		wantDropped int
+	}{
+		{"plain ASCII", "I'm in a blue Toyota on Third Mainland Bridge", "I'm in a blue Toyota on Third Mainland Bridge", 0},
+		{"whitespace and line breaks", "  blue Toyota\r\n\ton   Third Mainland ", "blue Toyota on Third Mainland", 0},
+		{"control characters", "blue\x00 Toyota\x1b[31m", "blue Toyota[31m", 0},
+		{"bidirectional overrides", "call ‮DNALNIAM‬ now", "call DNALNIAM now", 0},
+		{"invalid UTF-8", "blue \xff\xfeToyota", "blue Toyota", 0},
+		{"Yoruba diacritics kept", "Mo wà ní Ọjà Ọba, ẹ̀gbẹ́ ilé ìfowópamọ́", "Mo wà ní Ọjà Ọba, ẹ̀gbẹ́ ilé ìfowópamọ́", 0},
+		{"emoji kept", "Help 🙏🏾 🚨", "Help 🙏🏾 🚨", 0},
+		{"cut at the limit", strings.Repeat("a", AlertNoteMaxRunes+5), strings.Repeat("a", AlertNoteMaxRunes), 5},
+		{"cut keeps an accent with its letter", strings.Repeat("a", AlertNoteMaxRunes-1) + "ẹ̀bb", strings.Repeat("a", AlertNoteMaxRunes-1), 4},
+		{"cut keeps a skin tone with its emoji", strings.Repeat("a", AlertNoteMaxRunes-1) + "🙏🏾", strings.Repeat("a", AlertNoteMaxRunes-1), 2},
+		{"cut keeps a ZWJ sequence whole", strings.Repeat("a", AlertNoteMaxRunes-2) + "👩‍🚒", strings.Repeat("a", AlertNoteMaxRunes-2), 3},
+		{"cut trims a trailing space", strings.Repeat("a", AlertNoteMaxRunes-1) + " bb", strings.Repeat("a", AlertNoteMaxRunes-1), 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, dropped := CleanAlertNote(tt.note)
+			if got != tt.want || dropped != tt.wantDropped {
+				t.Errorf("CleanAlertNote() = %q, %d, want %q, %d", got, dropped, tt.want, tt.wantDropped)
+			}
+		})
+	}
+}
+
+// The preview counts segments of the whole alert: with a template in plain
+// text, as deployments without the emoji use, a plain note keeps it GSM-7
+// and a Yoruba or emoji note turns all of it UCS-2
+func TestAlertPreviewSegments(t *testing.T) {
+	templates, err := NewMessageTemplates(map[string]string{
+		TemplateAlert: "SAFETRACE ALERT: {{.Name}} may be in danger.\n" +
+			"{{if .Note}}{{.Name}} wrote: \"{{.Note}}\"\n{{end}}" +
+			"Map: {{.MapLink}}\nContact: {{.ContactPhone}}",
+	})
+	if err != nil {
+		t.Fatalf("NewMessageTemplates: %v", err)
+	}
+	previewer := NewAlertPreviewer(config.NewStore(&config.Config{PublicBaseURL: "https://api.safetrace.ng"}), templates)
+	user := &models.User{Name: "Adaeze Okafor", Phone: "+2348031234567"}
+
+	tests := []struct {
+		name         string
+		note         string
+		wantEncoding string
+	}{
+		{"no note", "", "gsm7"},
+		{"plain ASCII", "Blue Toyota on Third Mainland Bridge", "gsm7"},
+		{"Yoruba diacritics", "Mo wà ní Ọjà Ọba", "ucs2"},
+		{"emoji", "Help 🙏", "ucs2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			preview := previewer.Preview(user, tt.note)
+			sms := preview.Channels["sms"]
+			encoding, length, segments := SMSSegments(preview.Rendered)
+			if sms.Encoding != tt.wantEncoding || sms.Encoding != encoding || sms.Length != length || sms.Segments != segments {
+				t.Errorf("sms = %+v, want %s as counted: %s, %d, %d", sms, tt.wantEncoding, encoding, length, segments)
+			}
+			if tt.note != "" && !strings.Contains(preview.Rendered, tt.note) {
+				t.Errorf("rendered alert doesn't quote the note: %q", preview.Rendered)
+			}
+			if preview.Channels["whatsapp"].Segments != 1 {
+				t.Errorf("whatsapp = %+v, want one message", preview.Channels["whatsapp"])
+			}
+		})
+	}
+
+	// A note cut to fit is reported, so the app can warn
+	preview := previewer.Preview(user, strings.Repeat("a", AlertNoteMaxRunes+10))
+	if !preview.NoteTruncated || preview.NoteDropped != 10 || preview.NoteMaxLength != AlertNoteMaxRunes {
+		t.Errorf("preview = truncated %v, dropped %d, max %d", preview.NoteTruncated, preview.NoteDropped, preview.NoteMaxLength)
+	}
+}
@@ -0,0 +1,170 @@
+package services
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+)
+
+const (
+	alertOutboxWorker = "alert_outbox"
+	alertOutboxBeat   = time.Minute
+	alertOutboxSize   = 1000
+	// alertSendTimeout bounds delivering one alert to all of the user's contacts
+	alertSendTimeout = 2 * time.Minute
+)
+
+// alertDelivery is an alert waiting to be sent to the user's contacts and
+// channels. Resolutions only go to channels and carry no user or heartbeat.
+// Other messages, such as contact invitations, carry only a send func.
+type alertDelivery struct {
+	event     string // a ChannelEvent
+	user      *models.User
+	alert     *models.Alert
+	heartbeat *models.Heartbeat
+
+	message     func(ctx context.Context) error
+	description string // names the message in logs
+}
+
+// AlertOutbox sends alerts to trusted contacts and notification channels on
+// a fixed number of workers, so a burst of alerts can't open unbounded
+// concurrent provider calls. Alerts still queued at shutdown are sent before
+// Close returns.
+type AlertOutbox struct {
+	notifier Notifier
+	channels *ChannelNotifier
+	workers  int
+	health   *HealthRegistry
+	queue    chan alertDelivery
+
+	mu     sync.RWMutex
+	closed bool
+	wg     sync.WaitGroup
+}
+
+func NewAlertOutbox(notifier Notifier, channels *ChannelNotifier, workers int, health *HealthRegistry) *AlertOutbox {
+	return &AlertOutbox{
+		notifier: notifier,
+		channels: channels,
+		workers:  workers,
+		health:   health,
+		queue:    make(chan alertDelivery, alertOutboxSize),
+	}
+}
+
+// Start launches the send workers
+func (o *AlertOutbox) Start() {
+	o.health.Register(alertOutboxWorker, alertOutboxBeat)
+	for i := 0; i < o.workers; i++ {
+		o.wg.Add(1)
+		go o.run()
+	}
+}
+
+// Enqueue queues a new or escalated alert for delivery. When the queue is
+// full it waits for room until ctx is done; an alert that still can't be
+// queued, or arrives after Close, is sent by the caller rather than dropped.
+func (o *AlertOutbox) Enqueue(ctx context.Context, event string, user *models.User, alert *models.Alert, hb *models.Heartbeat) {
+	o.enqueue(ctx, alertDelivery{event: event, user: user, alert: alert, heartbeat: hb})
+}
+
+// EnqueueResolved queues telling the user's channels the alert was resolved.
+// Contacts aren't messaged about resolutions.
+func (o *AlertOutbox) EnqueueResolved(ctx context.Context, alert *models.Alert) {
+	o.enqueue(ctx, alertDelivery{event: ChannelEventResolved, alert: alert})
+}
+
+// EnqueueMessage queues a message that isn't an alert, e.g. a contact
+// invitation, to be sent on the same workers. Failures are logged.
+func (o *AlertOutbox) EnqueueMessage(ctx context.Context, description string, send func(ctx context.Context) error) {
+	o.enqueue(ctx, alertDelivery{message: send, description: description})
+}
+
+func (o *AlertOutbox) enqueue(ctx context.Context, delivery alertDelivery) {
+	o.mu.RLock()
+	if !o.closed {
+		select {
+		case o.queue <- delivery:
+			o.mu.RUnlock()
+			return
+		case <-ctx.Done():
+		}
+	}
+	o.mu.RUnlock()
+
+	log.Printf("WARN: Alert outbox unavailable, sending %s inline", delivery.name())
+	o.send(delivery)
+}
+
+// Len returns the number of alerts waiting to be sent
+func (o *AlertOutbox) Len() int {
+	return len(o.queue)
+}
+
+// Close stops accepting alerts and waits for the queued ones to be sent
+func (o *AlertOutbox) Close() {
+	o.mu.Lock()
+	if !o.closed {
+		o.closed = true
+		close(o.queue)
+	}
+	o.mu.Unlock()
+	o.wg.Wait()
+}
+
+func (o *AlertOutbox) run() {
+	defer o.wg.Done()
+
+	ticker := time.NewTicker(alertOutboxBeat)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case delivery, ok := <-o.queue:
+			if !ok {
+				return
+			}
+			o.send(delivery)
+			o.health.Beat(alertOutboxWorker)
+		case <-ticker.C:
+			o.health.Beat(alertOutboxWorker)
+		}
+	}
+}
+
+func (o *AlertOutbox) send(d alertDelivery) {
+	ctx, cancel := context.WithTimeout(context.Background(), alertSendTimeout)
+	defer cancel()
+
+	if d.message != nil {
+		if err := d.message(ctx); err != nil {
+			log.Printf("ERROR: Failed to send %s: %v", d.description, err)
+		}
+		return
+	}
+
+	if d.event == ChannelEventResolved {
+		if o.channels != nil {
+			o.channels.NotifyResolved(ctx, d.alert)
+		}
+		return
+	}
+
+	if err := o.notifier.SendAlertToContacts(ctx, d.user, d.alert, d.heartbeat); err != nil {
+		log.Printf("ERROR: Failed to send alert %s for user %s: %v", d.alert.ID, d.user.ID, err)
+	}
+	if o.channels != nil {
+		o.channels.NotifyAlert(ctx, d.event, d.user, d.alert, d.heartbeat)
+	}
+}
+
+func (d alertDelivery) name() string {
+	if d.message != nil {
+		return d.description
+	}
+	return "alert " + d.alert.ID.String()
+}
@@ -0,0 +1,196 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/config"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/database"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/scheduler"
+)
+
+const (
+	alertReconcilerWorker = "alert_reconciler"
+	alertReconcileEvery   = 15 * time.Minute
+	alertReconcileTimeout = 5 * time.Minute
+	alertReconcileBatch   = 100
+)
+
+// AlertReconciler looks at alerts left unresolved past
+// ALERT_RECONCILE_AGE_MINUTES, such as ones that reached nobody during a
+// provider outage or were never resolved once the user was fine. An alert
+// whose user has been SAFE for ALERT_RECONCILE_SAFE_MINUTES is closed as
+// reconciled. One that reached nobody is sent again, once, while the user
+// still isn't SAFE. The rest are flagged for someone to look at. Each action
+// is recorded on the alert and audited.
+type AlertReconciler struct {
+	cfg       *config.Store
+	postgres  *database.PostgresDB
+	evaluator *SafetyEvaluator
+	outbox    *AlertOutbox
+	audit     *AuditLogger
+	scheduler *scheduler.Scheduler
+
+	closed       atomic.Int64
+	redispatched atomic.Int64
+	flagged      atomic.Int64
+}
+
+func NewAlertReconciler(
+	cfg *config.Store,
+	postgres *database.PostgresDB,
+	evaluator *SafetyEvaluator,
+	outbox *AlertOutbox,
+	audit *AuditLogger,
+	scheduler *scheduler.Scheduler,
+) *AlertReconciler {
+	return &AlertReconciler{
+		cfg:       cfg,
+		postgres:  postgres,
+		evaluator: evaluator,
+		outbox:    outbox,
+		audit:     audit,
+		scheduler: scheduler,
+	}
+}
+
+// ClassifyOrphan classes an unresolved alert by what became of its deliveries
+func ClassifyOrphan(a *models.OrphanedAlert) string {
+	switch {
+	case a.Attempted == 0:
+		return models.OrphanNoDeliveries
+	case a.Sent == 0:
+		return models.OrphanAllFailed
+	case a.Acknowledged == 0:
+		return models.OrphanUnacknowledged
+	default:
+		return models.OrphanAcknowledged
+	}
+}
+
+// ReconcileAction decides what to do with an unresolved alert of class at
+// now: close it once the user has been SAFE for safeFor, send it again if it
+// reached nobody, was never reconciled before and the user is known not to
+// be SAFE, and flag it otherwise
+func ReconcileAction(a *models.OrphanedAlert, class string, now time.Time, safeFor time.Duration) string {
+	switch {
+	case a.UserState == StateSafe && a.StateSince != nil && !a.StateSince.After(now.Add(-safeFor)):
+		return models.ReconcileClosed
+	case class == models.OrphanNoDeliveries && a.ReconcileAction == "" && a.UserState != "" && a.UserState != StateSafe:
+		return models.ReconcileRedispatched
+	default:
+		return models.ReconcileFlagged
+	}
+}
+
+// Start schedules reconciliation on one instance at a time; the first pass
+// runs immediately
+func (r *AlertReconciler) Start() {
+	r.scheduler.MustRegister(scheduler.Job{
+		Name:      alertReconcilerWorker,
+		Every:     alertReconcileEvery,
+		Singleton: true,
+		Timeout:   alertReconcileTimeout,
+		Run:       r.reconcile,
+	})
+}
+
+// Closed returns how many alerts this process closed as reconciled
+func (r *AlertReconciler) Closed() int64 {
+	return r.closed.Load()
+}
+
+// Redispatched returns how many alerts this process sent again
+func (r *AlertReconciler) Redispatched() int64 {
+	return r.redispatched.Load()
+}
+
+// Flagged returns how many alerts this process flagged
+func (r *AlertReconciler) Flagged() int64 {
+	return r.flagged.Load()
+}
+
+// reconcile looks at every alert left unresolved past its age
+func (r *AlertReconciler) reconcile(ctx context.Context) error {
+	cfg := r.cfg.Current()
+	now := time.Now()
+	createdBefore := now.Add(-time.Duration(cfg.AlertReconcileAgeMinutes) * time.Minute)
+	safeFor := time.Duration(cfg.AlertReconcileSafeMinutes) * time.Minute
+
+	var afterCreated time.Time
+	var afterID uuid.UUID
+	for {
+		alerts, err := r.postgres.GetOrphanedAlerts(ctx, createdBefore, afterCreated, afterID, alertReconcileBatch)
+		if err != nil {
+			return fmt.Errorf("failed to find unresolved alerts to reconcile: %w", err)
+		}
+		for i := range alerts {
+			r.reconcileAlert(ctx, &alerts[i], now, safeFor)
+		}
+		if len(alerts) < alertReconcileBatch {
+			return nil
+		}
+		last := alerts[len(alerts)-1]
+		afterCreated, afterID = last.CreatedAt, last.ID
+	}
+}
+
+// reconcileAlert acts on one unresolved alert. An alert already recorded
+// with the same class and action is left alone, so a flagged alert is
+// audited once, not on every pass.
+func (r *AlertReconciler) reconcileAlert(ctx context.Context, a *models.OrphanedAlert, now time.Time, safeFor time.Duration) {
+	class := ClassifyOrphan(a)
+	action := ReconcileAction(a, class, now, safeFor)
+
+	var recorded bool
+	var err error
+	if action == models.ReconcileClosed {
+		recorded, err = r.postgres.CloseReconciledAlert(ctx, a.ID, class, now)
+	} else {
+		recorded, err = r.postgres.MarkAlertReconciled(ctx, a.ID, class, action, now)
+	}
+	if err != nil {
+		log.Printf("ERROR: Failed to record reconciliation of alert %s: %v", a.ID, err)
+		return
+	}
+	if !recorded {
+		return
+	}
+
+	userID := a.UserID
+	r.audit.Record(&models.AuditEvent{
+		ActorRole:     "system",
+		Action:        AuditAlertReconcile,
+		ObjectType:    "alert",
+		ObjectID:      a.ID.String(),
+		SubjectUserID: &userID,
+		Metadata: map[string]interface{}{
+			"class":      class,
+			"action":     action,
+			"user_state": a.UserState,
+		},
+	})
+	log.Printf("INFO: Reconciled alert %s of user %s: %s, %s", a.ID, userID, class, action)
+
+	switch action {
+	case models.ReconcileClosed:
+		r.closed.Add(1)
+		// Channels that were told about the alert hear that it's over
+		alert := a.Alert
+		alert.ResolvedAt = &now
+		alert.Resolution = models.AlertResolvedReconciled
+		r.outbox.EnqueueResolved(ctx, &alert)
+	case models.ReconcileRedispatched:
+		r.redispatched.Add(1)
+		if err := r.evaluator.RedispatchAlert(ctx, &a.Alert); err != nil {
+			log.Printf("ERROR: Failed to send alert %s again: %v", a.ID, err)
+		}
+	case models.ReconcileFlagged:
+		r.flagged.Add(1)
+	}
+}
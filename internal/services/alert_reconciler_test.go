@@ -0,0 +1,297 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/config"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+)
+
+func TestClassifyOrphan(t *testing.T) {
+	tests := []struct {
+		attempted, sent, acknowledged int
+		want                          string
+	}{
+		{0, 0, 0, models.OrphanNoDeliveries},
+		{2, 0, 0, models.OrphanAllFailed},
+		{2, 1, 0, models.OrphanUnacknowledged},
+		{2, 2, 1, models.OrphanAcknowledged},
+	}
+	for _, tt := range tests {
+		a := &models.OrphanedAlert{Attempted: tt.attempted, Sent: tt.sent, Acknowledged: tt.acknowledged}
+		if got := ClassifyOrphan(a); got != tt.want {
+			t.Errorf("%d attempted, %d sent, %d acknowledged: %s, want %s", tt.attempted, tt.sent, tt.acknowledged, got, tt.want)
+		}
+	}
+}
+
+func TestReconcileAction(t *testing.T) {
+	now := time.Date(2026, 3, 2, 12, 0, 0, 0, time.UTC)
+	const safeFor = 12 * time.Hour
+	longAgo, recently, exactly := now.Add(-13*time.Hour), now.Add(-time.Hour), now.Add(-safeFor)
+
+	tests := []struct {
+		name     string
+		class    string
+		state    string
+		since    *time.Time
+		previous string
+		want     string
+	}{
+		{"reached nobody, user at risk", models.OrphanNoDeliveries, StateAtRisk, &recently, "", models.ReconcileRedispatched},
+		{"reached nobody, user in caution", models.OrphanNoDeliveries, StateCaution, &longAgo, "", models.ReconcileRedispatched},
+		{"reached nobody, sent again before", models.OrphanNoDeliveries, StateAtRisk, &recently, models.ReconcileRedispatched, models.ReconcileFlagged},
+		{"reached nobody, no recorded state", models.OrphanNoDeliveries, "", nil, "", models.ReconcileFlagged},
+		{"reached nobody, SAFE a while", models.OrphanNoDeliveries, StateSafe, &recently, "", models.ReconcileFlagged},
+		{"reached nobody, SAFE long since", models.OrphanNoDeliveries, StateSafe, &longAgo, "", models.ReconcileClosed},
+		{"all failed, user at risk", models.OrphanAllFailed, StateAtRisk, &longAgo, "", models.ReconcileFlagged},
+		{"all failed, SAFE long since", models.OrphanAllFailed, StateSafe, &longAgo, "", models.ReconcileClosed},
+		{"unacknowledged, user at risk", models.OrphanUnacknowledged, StateAtRisk, &longAgo, "", models.ReconcileFlagged},
+		{"unacknowledged, SAFE exactly long enough", models.OrphanUnacknowledged, StateSafe, &exactly, "", models.ReconcileClosed},
+		{"acknowledged, SAFE long since", models.OrphanAcknowledged, StateSafe, &longAgo, models.ReconcileFlagged, models.ReconcileClosed},
+		{"acknowledged, user in caution", models.OrphanAcknowledged, StateCaution, &longAgo, "", models.ReconcileFlagged},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &models.OrphanedAlert{UserState: tt.state, StateSince: tt.since, ReconcileAction: tt.previous}
+			if got := ReconcileAction(a, tt.class, now, safeFor); got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+type reconcilerFixture struct {
+	reconciler *AlertReconciler
+	outbox     *AlertOutbox
+	audit      *AuditLogger
+	users      map[uuid.UUID]bool
+}
+
+// newReconcilerFixture sets up a reconciler for alerts older than 6 hours,
+// closing those whose user has been SAFE for 12, in front of an outbox and
+// audit log that are never started, so what it sends and records stays
+// queued to be looked at
+func newReconcilerFixture(t *testing.T) *reconcilerFixture {
+	t.Helper()
+	postgres := testPostgres(t)
+	redis := testRedis(t)
+	cfg := config.NewStore(&config.Config{AlertReconcileAgeMinutes: 360, AlertReconcileSafeMinutes: 720})
+	f := &reconcilerFixture{
+		outbox: NewAlertOutbox(nil, nil, 1, nil),
+		audit:  NewAuditLogger(postgres, nil, 100, 10, time.Second),
+		users:  map[uuid.UUID]bool{},
+	}
+	evaluator := &SafetyEvaluator{cfg: cfg, postgres: postgres, redis: redis, outbox: f.outbox, clock: SystemClock{}, effects: discardEffects{}}
+	f.reconciler = NewAlertReconciler(cfg, postgres, evaluator, f.outbox, f.audit, nil)
+	return f
+}
+
+// orphan opens an alert 7 hours ago for a new user whose latest state is
+// state, entered ago, and records deliveries with the given statuses and
+// recipients of whom acknowledged acknowledged it
+func (f *reconcilerFixture) orphan(t *testing.T, state string, ago time.Duration, deliveries []string, acknowledged int) *models.Alert {
+	t.Helper()
+	ctx := context.Background()
+	postgres := f.reconciler.postgres
+	user := createTestUser(t, postgres, "Ada")
+	f.users[user.ID] = true
+	created := time.Now().Add(-7 * time.Hour)
+
+	alert := &models.Alert{ID: uuid.New(), UserID: user.ID, State: models.AlertStateAtRisk, Reasons: models.Reasons{}, SentTo: []string{}, CreatedAt: created}
+	if err := postgres.CreateAlert(ctx, alert); err != nil {
+		t.Fatalf("CreateAlert: %v", err)
+	}
+	for i, status := range deliveries {
+		d := &models.AlertDelivery{ID: uuid.New(), AlertID: alert.ID, ContactID: fmt.Sprintf("contact-%d", i), ContactName: "Bola", Phone: testPhone(), Channel: "sms", Status: status, CreatedAt: created}
+		if err := postgres.CreateAlertDelivery(ctx, d); err != nil {
+			t.Fatalf("CreateAlertDelivery: %v", err)
+		}
+	}
+	for i := 0; i < acknowledged; i++ {
+		r := &models.AlertRecipient{ID: uuid.New(), AlertID: alert.ID, ContactID: fmt.Sprintf("contact-%d", i), ContactName: "Bola", Phone: testPhone(), Channel: "sms", AckToken: uuid.NewString(), CreatedAt: created}
+		if err := postgres.CreateAlertRecipient(ctx, r); err != nil {
+			t.Fatalf("CreateAlertRecipient: %v", err)
+		}
+		if _, err := postgres.AcknowledgeAlertByToken(ctx, r.AckToken, models.AckMethodLink); err != nil {
+			t.Fatalf("AcknowledgeAlertByToken: %v", err)
+		}
+	}
+	if state != "" {
+		transition := &models.StateTransition{UserID: user.ID, ToState: state, Score: 50, Reason: "test", TriggeredBy: "heartbeat", Timestamp: time.Now().Add(-ago)}
+		if _, err := postgres.RecordStateTransition(ctx, transition); err != nil {
+			t.Fatalf("RecordStateTransition: %v", err)
+		}
+	}
+	return alert
+}
+
+// pass runs one reconciliation over the fixture's users' alerts, leaving
+// any other test's alone
+func (f *reconcilerFixture) pass(t *testing.T) {
+	t.Helper()
+	ctx := context.Background()
+	cfg := f.reconciler.cfg.Current()
+	now := time.Now()
+	createdBefore := now.Add(-time.Duration(cfg.AlertReconcileAgeMinutes) * time.Minute)
+	safeFor := time.Duration(cfg.AlertReconcileSafeMinutes) * time.Minute
+
+	var afterCreated time.Time
+	var afterID uuid.UUID
+	for {
+		alerts, err := f.reconciler.postgres.GetOrphanedAlerts(ctx, createdBefore, afterCreated, afterID, alertReconcileBatch)
+		if err != nil {
+			t.Fatalf("GetOrphanedAlerts: %v", err)
+		}
+		for i := range alerts {
+			if f.users[alerts[i].UserID] {
+				f.reconciler.reconcileAlert(ctx, &alerts[i], now, safeFor)
+			}
+		}
+		if len(alerts) < alertReconcileBatch {
+			return
+		}
+		last := alerts[len(alerts)-1]
+		afterCreated, afterID = last.CreatedAt, last.ID
+	}
+}
+
+// recorded returns the class and action stored on each of the fixture's
+// alerts still open
+func (f *reconcilerFixture) recorded(t *testing.T) map[uuid.UUID]models.OrphanedAlert {
+	t.Helper()
+	alerts, err := f.reconciler.postgres.GetOrphanedAlerts(context.Background(), time.Now(), time.Time{}, uuid.Nil, 100_000)
+	if err != nil {
+		t.Fatalf("GetOrphanedAlerts: %v", err)
+	}
+	open := map[uuid.UUID]models.OrphanedAlert{}
+	for _, a := range alerts {
+		if f.users[a.UserID] {
+			open[a.ID] = a
+		}
+	}
+	return open
+}
+
+// audited drains the audit queue, returning the reconcile actions recorded
+// by alert
+func (f *reconcilerFixture) audited() map[string][]string {
+	actions := map[string][]string{}
+	for len(f.audit.queue) > 0 {
+		e := <-f.audit.queue
+		if e.Action == AuditAlertReconcile {
+			actions[e.ObjectID] = append(actions[e.ObjectID], e.Metadata["class"].(string)+"/"+e.Metadata["action"].(string))
+		}
+	}
+	return actions
+}
+
+// sent drains the outbox, returning the events queued by alert
+func (f *reconcilerFixture) sent() map[uuid.UUID][]string {
+	events := map[uuid.UUID][]string{}
+	for len(f.outbox.queue) > 0 {
+		d := <-f.outbox.queue
+		events[d.alert.ID] = append(events[d.alert.ID], d.event)
+	}
+	return events
+}
+
+// Each class of alert left unresolved gets its action, recorded on the
+// alert and audited once; an alert sent again isn't sent a second time
+func TestReconcileOrphans(t *testing.T) {
+	f := newReconcilerFixture(t)
+	ctx := context.Background()
+	sent, failed, suppressed := models.DeliveryStatusSent, models.DeliveryStatusFailed, models.DeliveryStatusSuppressed
+
+	tests := []struct {
+		name       string
+		alert      *models.Alert
+		class      string
+		action     string
+		wantEvents []string
+	}{
+		{"reached nobody, user at risk", f.orphan(t, StateAtRisk, time.Hour, nil, 0),
+			models.OrphanNoDeliveries, models.ReconcileRedispatched, []string{ChannelEventAlert}},
+		{"only suppressed, user in caution", f.orphan(t, StateCaution, time.Hour, []string{suppressed}, 0),
+			models.OrphanNoDeliveries, models.ReconcileRedispatched, []string{ChannelEventAlert}},
+		{"reached nobody, no recorded state", f.orphan(t, "", 0, nil, 0),
+			models.OrphanNoDeliveries, models.ReconcileFlagged, nil},
+		{"all failed, user at risk", f.orphan(t, StateAtRisk, time.Hour, []string{failed, failed}, 0),
+			models.OrphanAllFailed, models.ReconcileFlagged, nil},
+		{"unacknowledged, user at risk", f.orphan(t, StateAtRisk, time.Hour, []string{sent, failed}, 0),
+			models.OrphanUnacknowledged, models.ReconcileFlagged, nil},
+		{"acknowledged, SAFE an hour", f.orphan(t, StateSafe, time.Hour, []string{sent}, 1),
+			models.OrphanAcknowledged, models.ReconcileFlagged, nil},
+		{"acknowledged, SAFE long since", f.orphan(t, StateSafe, 13*time.Hour, []string{sent}, 1),
+			models.OrphanAcknowledged, models.ReconcileClosed, []string{ChannelEventResolved}},
+		{"all failed, SAFE long since", f.orphan(t, StateSafe, 13*time.Hour, []string{failed}, 0),
+			models.OrphanAllFailed, models.ReconcileClosed, []string{ChannelEventResolved}},
+	}
+
+	// Too recent to reconcile
+	recent := createTestUser(t, f.reconciler.postgres, "Ada")
+	f.users[recent.ID] = true
+	young := &models.Alert{ID: uuid.New(), UserID: recent.ID, State: models.AlertStateAtRisk, Reasons: models.Reasons{}, SentTo: []string{}, CreatedAt: time.Now().Add(-time.Hour)}
+	if err := f.reconciler.postgres.CreateAlert(ctx, young); err != nil {
+		t.Fatalf("CreateAlert: %v", err)
+	}
+
+	f.pass(t)
+	open, audited, events := f.recorded(t), f.audited(), f.sent()
+	for _, tt := range tests {
+		id := tt.alert.ID
+		if want := []string{tt.class + "/" + tt.action}; !slices.Equal(audited[id.String()], want) {
+			t.Errorf("%s: audited %v, want %v", tt.name, audited[id.String()], want)
+		}
+		if !slices.Equal(events[id], tt.wantEvents) {
+			t.Errorf("%s: queued %v, want %v", tt.name, events[id], tt.wantEvents)
+		}
+		if tt.action == models.ReconcileClosed {
+			stored, err := f.reconciler.postgres.GetAlertByID(ctx, id)
+			if err != nil || stored == nil || stored.ResolvedAt == nil || stored.Resolution != models.AlertResolvedReconciled {
+				t.Errorf("%s: stored %+v, %v; want resolved as reconciled", tt.name, stored, err)
+			}
+			continue
+		}
+		got, ok := open[id]
+		if !ok || got.ReconcileClass != tt.class || got.ReconcileAction != tt.action {
+			t.Errorf("%s: recorded %q %q, want %s %s", tt.name, got.ReconcileClass, got.ReconcileAction, tt.class, tt.action)
+		}
+	}
+	if got := open[young.ID]; got.ReconcileAction != "" || len(audited[young.ID.String()]) != 0 {
+		t.Errorf("recent alert reconciled: %q", got.ReconcileAction)
+	}
+	r := f.reconciler
+	if r.Redispatched() != 2 || r.Closed() != 2 || r.Flagged() != 4 {
+		t.Errorf("counted %d sent again, %d closed, %d flagged; want 2, 2, 4", r.Redispatched(), r.Closed(), r.Flagged())
+	}
+
+	// The next pass sends nothing again: the alerts sent again are flagged,
+	// and the ones already flagged aren't audited twice
+	f.pass(t)
+	open, audited, events = f.recorded(t), f.audited(), f.sent()
+	if len(events) != 0 {
+		t.Errorf("second pass queued %v", events)
+	}
+	for _, tt := range tests[:2] {
+		id := tt.alert.ID
+		if got := open[id]; got.ReconcileAction != models.ReconcileFlagged {
+			t.Errorf("%s: second pass recorded %q, want flagged", tt.name, got.ReconcileAction)
+		}
+		if want := []string{tt.class + "/" + models.ReconcileFlagged}; !slices.Equal(audited[id.String()], want) {
+			t.Errorf("%s: second pass audited %v, want %v", tt.name, audited[id.String()], want)
+		}
+	}
+	if len(audited) != 2 {
+		t.Errorf("second pass audited %d alerts, want the 2 sent again", len(audited))
+	}
+	if r.Redispatched() != 2 || r.Closed() != 2 || r.Flagged() != 6 {
+		t.Errorf("counted %d sent again, %d closed, %d flagged; want 2, 2, 6", r.Redispatched(), r.Closed(), r.Flagged())
+	}
+}
@@ -0,0 +1,320 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/config"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/database"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/pluscode"
+)
+
+var (
+	// ErrShareInvalid is returned for a dashboard token that doesn't exist
+	ErrShareInvalid = errors.New("dashboard link is not valid")
+	// ErrShareResolved is returned once the token's alert is resolved
+	ErrShareResolved = errors.New("alert has been resolved")
+	// ErrShareReadOnly is returned when a read-only token is used to act
+	ErrShareReadOnly = errors.New("dashboard link is read-only")
+	// ErrShareNotContact is returned when the recipient isn't one of the user's trusted contacts
+	ErrShareNotContact = errors.New("recipient is not a trusted contact of this user")
+)
+
+const (
+	// The dashboard shows the last hour, thinned to a page's worth of points
+	trackBreadcrumbSpan          = time.Hour
+	trackBreadcrumbPoints        = 60
+	trackBreadcrumbToleranceM    = 10
+	trackBreadcrumbMaxHeartbeats = 1000
+	// and the latest unverified reports attached to the alert
+	trackUnverifiedReports = 5
+)
+
+// TrackPage is the contact dashboard of an active alert: what a lightweight
+// web page needs to show whoever holds the link
+type TrackPage struct {
+	AlertID                 uuid.UUID         `json:"alert_id"`
+	AlertRaisedAt           time.Time         `json:"alert_raised_at"`
+	FirstName               string            `json:"first_name"`
+	State                   string            `json:"state"`
+	Score                   int               `json:"score"`
+	LastContactAt           *time.Time        `json:"last_contact_at,omitempty"`
+	SecondsSinceLastContact *int              `json:"seconds_since_last_contact,omitempty"`
+	Location                *TrackPoint       `json:"location,omitempty"`  // latest fix in the last hour
+	Breadcrumb              []TrackPoint      `json:"breadcrumb"`          // last hour, oldest first
+	LastGasp                *TrackPoint       `json:"last_gasp,omitempty"` // active LastGasp position
+	UnverifiedReports       []TrackReport     `json:"unverified_reports"`  // newest first, denied ones left out
+	Scope                   string            `json:"scope"`               // read | acknowledge
+	AcknowledgedAt          *time.Time        `json:"acknowledged_at,omitempty"`
+	Actions                 map[string]string `json:"actions"` // button name to endpoint; empty for read-only links
+}
+
+// TrackPoint is a position on the dashboard. Place names come from reverse
+// geocoding and only show once looked up.
+type TrackPoint struct {
+	Lat       float64   `json:"lat"`
+	Lng       float64   `json:"lng"`
+	AccuracyM int       `json:"accuracy_m"`
+	Timestamp time.Time `json:"timestamp"`
+	Place     string    `json:"place,omitempty"`
+	PlusCode  string    `json:"plus_code,omitempty"`
+}
+
+// TrackReport is an unverified report on the dashboard: a position sent
+// with only the user's phone number, which the page must show apart from
+// the user's own, under Label
+type TrackReport struct {
+	TrackPoint
+	Trust string `json:"trust"` // unverified | confirmed
+	Label string `json:"label"`
+}
+
+// AlertShareService serves the contact dashboard of an alert. Contacts on a
+// feature phone forward the alert SMS; whoever opens its link on a
+// smartphone gets the dashboard. A recipient's ack token opens it with the
+// acknowledge scope, which can acknowledge the alert and request a welfare
+// check as that recipient, and can make further links, read-only or not, to
+// pass on. Every link stops working once the alert is resolved.
+type AlertShareService struct {
+	cfg       *config.Store
+	postgres  *database.PostgresDB
+	evaluator *SafetyEvaluator
+	locations *LocationEncoder
+	welfare   *WelfareCheckService
+}
+
+func NewAlertShareService(
+	cfg *config.Store,
+	postgres *database.PostgresDB,
+	evaluator *SafetyEvaluator,
+	locations *LocationEncoder,
+	welfare *WelfareCheckService,
+) *AlertShareService {
+	return &AlertShareService{
+		cfg:       cfg,
+		postgres:  postgres,
+		evaluator: evaluator,
+		locations: locations,
+		welfare:   welfare,
+	}
+}
+
+// Open looks up a dashboard token and its alert. It fails with
+// ErrShareInvalid for unknown tokens and, returning both, with
+// ErrShareResolved once the alert is resolved.
+func (s *AlertShareService) Open(ctx context.Context, token string) (*models.AlertShare, *models.Alert, error) {
+	share, err := s.postgres.GetAlertShare(ctx, token)
+	if err != nil {
+		return nil, nil, err
+	}
+	if share == nil {
+		return nil, nil, ErrShareInvalid
+	}
+	alert, err := s.postgres.GetAlertByID(ctx, share.Recipient.AlertID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if alert == nil {
+		return nil, nil, ErrShareInvalid
+	}
+	if alert.ResolvedAt != nil {
+		return share, alert, ErrShareResolved
+	}
+	return share, alert, nil
+}
+
+// Page builds the dashboard of an open alert
+func (s *AlertShareService) Page(ctx context.Context, share *models.AlertShare, alert *models.Alert) (*TrackPage, error) {
+	user, err := s.postgres.GetUserByID(ctx, alert.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, ErrShareInvalid
+	}
+
+	page := &TrackPage{
+		AlertID:           alert.ID,
+		AlertRaisedAt:     alert.CreatedAt,
+		FirstName:         firstName(user.Name),
+		State:             string(alert.State),
+		Score:             alert.Score,
+		Breadcrumb:        []TrackPoint{},
+		UnverifiedReports: []TrackReport{},
+		Scope:             share.Scope,
+		AcknowledgedAt:    share.Recipient.AcknowledgedAt,
+		Actions:           map[string]string{},
+	}
+
+	state, err := s.evaluator.CurrentState(ctx, user.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get state: %w", err)
+	}
+	if state != nil {
+		page.State, page.Score = state.State, state.Score
+		if !state.LastHeartbeat.IsZero() {
+			last := state.LastHeartbeat
+			elapsed := int(time.Since(last).Seconds())
+			page.LastContactAt, page.SecondsSinceLastContact = &last, &elapsed
+		}
+	}
+
+	now := time.Now()
+	heartbeats, err := s.postgres.GetHeartbeatsBetween(ctx, user.ID, now.Add(-trackBreadcrumbSpan), now, trackBreadcrumbMaxHeartbeats, false)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get heartbeats: %w", err)
+	}
+	// Heartbeats without a fix would pin the breadcrumb to 0,0
+	located := heartbeats[:0]
+	for _, hb := range heartbeats {
+		if hb.Lat != 0 || hb.Lng != 0 {
+			located = append(located, hb)
+		}
+	}
+	for _, hb := range DownsampleTrack(located, trackBreadcrumbToleranceM, trackBreadcrumbPoints) {
+		page.Breadcrumb = append(page.Breadcrumb, TrackPoint{Lat: hb.Lat, Lng: hb.Lng, AccuracyM: hb.AccuracyM, Timestamp: hb.Timestamp})
+	}
+	if n := len(page.Breadcrumb); n > 0 {
+		page.Location = s.describe(ctx, page.Breadcrumb[n-1])
+	}
+
+	lastGasp, err := s.postgres.GetActiveLastGasp(ctx, user.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get lastgasp: %w", err)
+	}
+	if lastGasp != nil {
+		page.LastGasp = s.describe(ctx, TrackPoint{Lat: lastGasp.Lat, Lng: lastGasp.Lng, AccuracyM: lastGasp.AccuracyM, Timestamp: lastGasp.CreatedAt})
+	}
+
+	reports, err := s.postgres.GetAlertUnverifiedReports(ctx, alert.ID, trackUnverifiedReports)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get unverified reports: %w", err)
+	}
+	for _, r := range reports {
+		point := TrackPoint{Lat: r.Lat, Lng: r.Lng, Timestamp: r.ReportedAt}
+		if r.AccuracyM != nil {
+			point.AccuracyM = *r.AccuracyM
+		}
+		page.UnverifiedReports = append(page.UnverifiedReports, TrackReport{TrackPoint: *s.describe(ctx, point), Trust: r.Trust, Label: reportLabel(r.Trust, page.FirstName)})
+	}
+
+	if share.Scope == models.ShareScopeAcknowledge {
+		base := "/v1/track/" + share.Token
+		page.Actions["acknowledge"] = base + "/acknowledge"
+		page.Actions["share"] = base + "/share"
+		if trustedContact(user, share.Recipient) != nil {
+			page.Actions["welfare_check"] = base + "/welfare-check"
+		}
+	}
+	return page, nil
+}
+
+// reportLabel is how contacts see an unverified report: as the user's only
+// once the user confirmed sending it
+func reportLabel(trust, firstName string) string {
+	if trust == models.ReportConfirmed {
+		return "Confirmed by " + firstName
+	}
+	return "Unverified report"
+}
+
+// describe adds the place name and plus code to a point
+func (s *AlertShareService) describe(ctx context.Context, p TrackPoint) *TrackPoint {
+	p.Place = s.locations.PlaceName(ctx, p.Lat, p.Lng)
+	p.PlusCode = pluscode.Encode(p.Lat, p.Lng, pluscode.DefaultLength)
+	return &p
+}
+
+// Acknowledge records that the recipient has seen the alert
+func (s *AlertShareService) Acknowledge(ctx context.Context, share *models.AlertShare) (*models.AlertRecipient, error) {
+	if share.Scope != models.ShareScopeAcknowledge {
+		return nil, ErrShareReadOnly
+	}
+	recipient, err := s.postgres.AcknowledgeAlertByToken(ctx, share.Recipient.AckToken, models.AckMethodLink)
+	if err != nil {
+		return nil, err
+	}
+	if recipient == nil {
+		return nil, ErrShareInvalid
+	}
+	return recipient, nil
+}
+
+// RequestWelfareCheck asks the user to confirm they are okay, on behalf of
+// the recipient, who must still be one of their trusted contacts. Welfare
+// check errors are passed through.
+func (s *AlertShareService) RequestWelfareCheck(ctx context.Context, share *models.AlertShare, alert *models.Alert) (*models.WelfareCheck, error) {
+	if share.Scope != models.ShareScopeAcknowledge {
+		return nil, ErrShareReadOnly
+	}
+	user, err := s.postgres.GetUserByID(ctx, alert.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, ErrShareInvalid
+	}
+	contact := trustedContact(user, share.Recipient)
+	if contact == nil {
+		return nil, ErrShareNotContact
+	}
+	return s.welfare.Request(ctx, user, WelfareRequester{
+		Type:  models.WelfareRequesterContact,
+		ID:    contact.ID,
+		Name:  contact.Name,
+		Phone: contact.Phone,
+	})
+}
+
+// Share makes a new dashboard token for the same recipient with the given
+// scope. Only acknowledge-capable tokens can make them.
+func (s *AlertShareService) Share(ctx context.Context, share *models.AlertShare, scope string) (*models.AlertShare, error) {
+	if share.Scope != models.ShareScopeAcknowledge {
+		return nil, ErrShareReadOnly
+	}
+	token, err := generateAckToken()
+	if err != nil {
+		return nil, err
+	}
+	shared := &models.AlertShare{Token: token, Scope: scope, Recipient: share.Recipient}
+	if err := s.postgres.CreateAlertShare(ctx, shared, time.Now()); err != nil {
+		return nil, err
+	}
+	return shared, nil
+}
+
+// Link returns the public dashboard link for a token, or "" when
+// PUBLIC_BASE_URL is not set
+func (s *AlertShareService) Link(token string) string {
+	base := s.cfg.Current().PublicBaseURL
+	if base == "" {
+		return ""
+	}
+	return strings.TrimRight(base, "/") + "/track/" + token
+}
+
+// trustedContact returns the user's trusted contact the recipient was, if
+// they still are one with the same phone number
+func trustedContact(user *models.User, recipient models.AlertRecipient) *models.Contact {
+	for i := range user.TrustedContacts {
+		contact := &user.TrustedContacts[i]
+		if contact.ID == recipient.ContactID && contact.Phone == recipient.Phone {
+			return contact
+		}
+	}
+	return nil
+}
+
+// firstName is the first word of a name; the dashboard may be forwarded
+// beyond the user's contacts, so it never shows the full name
+func firstName(name string) string {
+	if fields := strings.Fields(name); len(fields) > 0 {
+		return fields[0]
+	}
+	return ""
+}
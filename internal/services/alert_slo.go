@@ -0,0 +1,365 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/config"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/database"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+)
+
+// Stages of time-to-alert. Total runs from the evaluation that raised the
+// alert being requested to a carrier confirming the first message to a
+// contact delivered; the other three split it.
+const (
+	SLOStageEvaluation = "evaluation" // requested until the alert was created
+	SLOStageOutbox     = "outbox"     // created until a message to a contact was attempted
+	SLOStageDelivery   = "delivery"   // attempted until a carrier confirmed one delivered
+	SLOStageTotal      = "total"
+)
+
+var (
+	sloStages       = []string{SLOStageEvaluation, SLOStageOutbox, SLOStageDelivery}
+	sloMetricStages = []string{SLOStageEvaluation, SLOStageOutbox, SLOStageDelivery, SLOStageTotal}
+)
+
+// sloBuckets are the latency histogram's upper bounds, in seconds
+var sloBuckets = []float64{5, 10, 30, 60, 120, 180, 300, 600, 1800}
+
+// sloBurnWindow is the window GET /metrics reports the burn rate over
+const sloBurnWindow = time.Hour
+
+// AlertSLO measures how long alerts take to reach the user's contacts,
+// against ALERT_SLO_TARGET_SECONDS. Alerts are stamped as they move through
+// the pipeline; the histograms count the confirmations this instance
+// received, while reports and the burn rate read every alert from Postgres.
+type AlertSLO struct {
+	cfg      *config.Store
+	postgres *database.PostgresDB
+
+	mu         sync.Mutex
+	histograms map[string]*latencyHistogram
+	confirmed  int64
+	breaches   int64
+}
+
+func NewAlertSLO(cfg *config.Store, postgres *database.PostgresDB) *AlertSLO {
+	histograms := make(map[string]*latencyHistogram)
+	for _, stage := range sloMetricStages {
+		histograms[stage] = &latencyHistogram{counts: make([]int64, len(sloBuckets))}
+	}
+	return &AlertSLO{
+		cfg:        cfg,
+		postgres:   postgres,
+		histograms: histograms,
+	}
+}
+
+// Delivered records a carrier confirming one of the alert's messages
+// delivered at the given time. Only the first confirmation counts.
+func (s *AlertSLO) Delivered(ctx context.Context, alertID uuid.UUID, at time.Time) error {
+	latency, err := s.postgres.MarkAlertDelivered(ctx, alertID, at)
+	if err != nil || latency == nil {
+		return err
+	}
+
+	stages := stageDurations(latency, at)
+	total := at.Sub(latency.DetectedAt)
+	target := s.target()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for stage, d := range stages {
+		if d != nil {
+			s.histograms[stage].observe(*d)
+		}
+	}
+	s.histograms[SLOStageTotal].observe(total)
+	s.confirmed++
+	if total > target {
+		s.breaches++
+		log.Printf("WARN: Alert %s reached a contact after %s, over the %s target", alertID, total.Round(time.Second), target)
+	}
+	return nil
+}
+
+func (s *AlertSLO) target() time.Duration {
+	return time.Duration(s.cfg.Current().AlertSLOTargetSeconds) * time.Second
+}
+
+// SLOReport is time-to-alert over a range of alerts. Unconfirmed alerts
+// count as breaches once they're older than the target and are left out
+// until then.
+type SLOReport struct {
+	From          time.Time                 `json:"from"`
+	To            time.Time                 `json:"to"`
+	TargetSeconds int                       `json:"target_seconds"`
+	Alerts        int                       `json:"alerts"`
+	Confirmed     int                       `json:"confirmed"`
+	Pending       int                       `json:"pending"` // unconfirmed, still within the target
+	Breaches      int                       `json:"breaches"`
+	BreachRatio   float64                   `json:"breach_ratio"` // breaches among alerts that aren't pending
+	Total         LatencySummary            `json:"total"`        // confirmed alerts
+	Stages        map[string]LatencySummary `json:"stages"`       // alerts that completed the stage
+	Distribution  []LatencyBucket           `json:"distribution"` // total time-to-alert of confirmed alerts
+	Worst         []SlowAlert               `json:"worst"`
+}
+
+// LatencySummary gives percentiles of a set of durations, in seconds; they
+// are null when the set is empty
+type LatencySummary struct {
+	Count int      `json:"count"`
+	P50   *float64 `json:"p50"`
+	P90   *float64 `json:"p90"`
+	P99   *float64 `json:"p99"`
+	Max   *float64 `json:"max"`
+}
+
+// LatencyBucket counts durations up to LE seconds, not cumulatively; the
+// last bucket has a null LE and counts the rest
+type LatencyBucket struct {
+	LE    *float64 `json:"le"`
+	Count int      `json:"count"`
+}
+
+// SlowAlert is one of the slowest alerts with how long each stage took, in
+// seconds. The stage an unconfirmed alert is stuck in is timed until now and
+// the stages after it are null.
+type SlowAlert struct {
+	AlertID      uuid.UUID           `json:"alert_id"`
+	UserID       uuid.UUID           `json:"user_id"`
+	State        models.AlertState   `json:"state"`
+	CreatedAt    time.Time           `json:"created_at"`
+	Confirmed    bool                `json:"confirmed"`
+	TotalSeconds float64             `json:"total_seconds"`
+	Stages       map[string]*float64 `json:"stages"`
+	Slowest      string              `json:"slowest_stage"`
+}
+
+// Report summarizes time-to-alert for the alerts raised in [from, to) and
+// lists up to worst of the slowest
+func (s *AlertSLO) Report(ctx context.Context, from, to time.Time, worst int) (*SLOReport, error) {
+	latencies, err := s.postgres.ListAlertLatencies(ctx, from, to)
+	if err != nil {
+		return nil, err
+	}
+	report := buildSLOReport(latencies, s.target(), time.Now(), worst)
+	report.From, report.To = from, to
+	return report, nil
+}
+
+func buildSLOReport(latencies []models.AlertLatency, target time.Duration, now time.Time, worst int) *SLOReport {
+	report := &SLOReport{
+		TargetSeconds: int(target.Seconds()),
+		Alerts:        len(latencies),
+		Stages:        make(map[string]LatencySummary),
+	}
+
+	var totals []time.Duration
+	stageTimes := make(map[string][]time.Duration)
+	slow := make([]SlowAlert, 0, len(latencies))
+	for i := range latencies {
+		l := &latencies[i]
+		end := now
+		if l.FirstDeliveredAt != nil {
+			end = *l.FirstDeliveredAt
+			report.Confirmed++
+		}
+		total := end.Sub(l.DetectedAt)
+		switch {
+		case total > target:
+			report.Breaches++
+		case l.FirstDeliveredAt == nil:
+			report.Pending++
+		}
+		if l.FirstDeliveredAt != nil {
+			totals = append(totals, total)
+		}
+
+		alert := SlowAlert{
+			AlertID:      l.AlertID,
+			UserID:       l.UserID,
+			State:        l.State,
+			CreatedAt:    l.CreatedAt,
+			Confirmed:    l.FirstDeliveredAt != nil,
+			TotalSeconds: total.Seconds(),
+			Stages:       make(map[string]*float64),
+		}
+		var slowest time.Duration
+		durations := stageDurations(l, now)
+		for _, stage := range sloStages {
+			d := durations[stage]
+			if d == nil {
+				alert.Stages[stage] = nil
+				continue
+			}
+			seconds := d.Seconds()
+			alert.Stages[stage] = &seconds
+			if alert.Slowest == "" || *d > slowest {
+				alert.Slowest, slowest = stage, *d
+			}
+			if stageCompleted(l, stage) {
+				stageTimes[stage] = append(stageTimes[stage], *d)
+			}
+		}
+		slow = append(slow, alert)
+	}
+
+	if judged := report.Alerts - report.Pending; judged > 0 {
+		report.BreachRatio = float64(report.Breaches) / float64(judged)
+	}
+	report.Total = summarize(totals)
+	for _, stage := range sloStages {
+		report.Stages[stage] = summarize(stageTimes[stage])
+	}
+	report.Distribution = distribution(totals)
+
+	sort.SliceStable(slow, func(i, j int) bool { return slow[i].TotalSeconds > slow[j].TotalSeconds })
+	if len(slow) > worst {
+		slow = slow[:worst]
+	}
+	report.Worst = slow
+	return report
+}
+
+// stageDurations times each stage the alert has reached. A stage not yet
+// completed is timed until now; stages after it are nil.
+func stageDurations(l *models.AlertLatency, now time.Time) map[string]*time.Duration {
+	evaluation := l.CreatedAt.Sub(l.DetectedAt)
+	stages := map[string]*time.Duration{SLOStageEvaluation: &evaluation, SLOStageOutbox: nil, SLOStageDelivery: nil}
+
+	attempted := now
+	if l.FirstAttemptAt != nil {
+		attempted = *l.FirstAttemptAt
+	}
+	outbox := attempted.Sub(l.CreatedAt)
+	stages[SLOStageOutbox] = &outbox
+	if l.FirstAttemptAt == nil {
+		return stages
+	}
+
+	delivered := now
+	if l.FirstDeliveredAt != nil {
+		delivered = *l.FirstDeliveredAt
+	}
+	delivery := delivered.Sub(*l.FirstAttemptAt)
+	stages[SLOStageDelivery] = &delivery
+	return stages
+}
+
+func stageCompleted(l *models.AlertLatency, stage string) bool {
+	switch stage {
+	case SLOStageOutbox:
+		return l.FirstAttemptAt != nil
+	case SLOStageDelivery:
+		return l.FirstDeliveredAt != nil
+	}
+	return true
+}
+
+// summarize gives nearest-rank percentiles of the durations
+func summarize(durations []time.Duration) LatencySummary {
+	summary := LatencySummary{Count: len(durations)}
+	if len(durations) == 0 {
+		return summary
+	}
+	sorted := append([]time.Duration(nil), durations...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	percentile := func(p float64) *float64 {
+		rank := int(math.Ceil(p*float64(len(sorted)))) - 1
+		if rank < 0 {
+			rank = 0
+		}
+		seconds := sorted[rank].Seconds()
+		return &seconds
+	}
+	summary.P50 = percentile(0.5)
+	summary.P90 = percentile(0.9)
+	summary.P99 = percentile(0.99)
+	summary.Max = percentile(1)
+	return summary
+}
+
+func distribution(durations []time.Duration) []LatencyBucket {
+	buckets := make([]LatencyBucket, len(sloBuckets)+1)
+	for i := range sloBuckets {
+		buckets[i].LE = &sloBuckets[i]
+	}
+	for _, d := range durations {
+		i := sort.SearchFloat64s(sloBuckets, d.Seconds())
+		buckets[i].Count++
+	}
+	return buckets
+}
+
+// latencyHistogram is a Prometheus histogram over sloBuckets
+type latencyHistogram struct {
+	counts []int64 // per bucket, not cumulative
+	sum    float64
+	count  int64
+}
+
+func (h *latencyHistogram) observe(d time.Duration) {
+	seconds := d.Seconds()
+	if i := sort.SearchFloat64s(sloBuckets, seconds); i < len(sloBuckets) {
+		h.counts[i]++
+	}
+	h.sum += seconds
+	h.count++
+}
+
+// WriteMetrics writes the SLO metrics in the Prometheus text format. The
+// burn rate is read from Postgres; if that fails it is left out.
+func (s *AlertSLO) WriteMetrics(ctx context.Context, w io.Writer) {
+	target := s.target()
+	now := time.Now()
+
+	s.mu.Lock()
+	fmt.Fprintln(w, "# HELP safetrace_alert_latency_seconds Time-to-alert of alerts whose delivery this instance saw confirmed, by stage.")
+	fmt.Fprintln(w, "# TYPE safetrace_alert_latency_seconds histogram")
+	for _, stage := range sloMetricStages {
+		h := s.histograms[stage]
+		var cumulative int64
+		for i, le := range sloBuckets {
+			cumulative += h.counts[i]
+			fmt.Fprintf(w, "safetrace_alert_latency_seconds_bucket{stage=%q,le=%q} %d\n", stage, formatFloat(le), cumulative)
+		}
+		fmt.Fprintf(w, "safetrace_alert_latency_seconds_bucket{stage=%q,le=\"+Inf\"} %d\n", stage, h.count)
+		fmt.Fprintf(w, "safetrace_alert_latency_seconds_sum{stage=%q} %s\n", stage, formatFloat(h.sum))
+		fmt.Fprintf(w, "safetrace_alert_latency_seconds_count{stage=%q} %d\n", stage, h.count)
+	}
+	fmt.Fprintln(w, "# HELP safetrace_alert_slo_confirmed_total Alerts whose first delivery this instance saw confirmed.")
+	fmt.Fprintln(w, "# TYPE safetrace_alert_slo_confirmed_total counter")
+	fmt.Fprintf(w, "safetrace_alert_slo_confirmed_total %d\n", s.confirmed)
+	fmt.Fprintln(w, "# HELP safetrace_alert_slo_breaches_total Of those, alerts confirmed later than the target.")
+	fmt.Fprintln(w, "# TYPE safetrace_alert_slo_breaches_total counter")
+	fmt.Fprintf(w, "safetrace_alert_slo_breaches_total %d\n", s.breaches)
+	s.mu.Unlock()
+
+	fmt.Fprintln(w, "# HELP safetrace_alert_slo_target_seconds Time-to-alert target.")
+	fmt.Fprintln(w, "# TYPE safetrace_alert_slo_target_seconds gauge")
+	fmt.Fprintf(w, "safetrace_alert_slo_target_seconds %s\n", formatFloat(target.Seconds()))
+
+	latencies, err := s.postgres.ListAlertLatencies(ctx, now.Add(-sloBurnWindow), now)
+	if err != nil {
+		log.Printf("WARN: Failed to read alert latencies for metrics: %v", err)
+		return
+	}
+	report := buildSLOReport(latencies, target, now, 0)
+	fmt.Fprintln(w, "# HELP safetrace_alert_slo_burn_rate Fraction of the last hour's alerts that didn't reach a contact within the target.")
+	fmt.Fprintln(w, "# TYPE safetrace_alert_slo_burn_rate gauge")
+	fmt.Fprintf(w, "safetrace_alert_slo_burn_rate %s\n", formatFloat(report.BreachRatio))
+}
+
+func formatFloat(v float64) string {
+	return fmt.Sprintf("%g", v)
+}
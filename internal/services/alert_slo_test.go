@@ -0,0 +1,257 @@
+package services
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/config"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+)
+
+// sloStart is when the alerts below were detected
+var sloStart = time.Date(2024, 3, 1, 2, 0, 0, 0, time.UTC)
+
+// sloLatency is an alert created, attempted and confirmed the given times
+// after it was detected; a negative attempted or delivered leaves it unset
+func sloLatency(created, attempted, delivered time.Duration) models.AlertLatency {
+	l := models.AlertLatency{AlertID: uuid.New(), UserID: uuid.New(), State: models.AlertStateAlert, DetectedAt: sloStart, CreatedAt: sloStart.Add(created)}
+	if attempted >= 0 {
+		at := sloStart.Add(attempted)
+		l.FirstAttemptAt = &at
+	}
+	if delivered >= 0 {
+		at := sloStart.Add(delivered)
+		l.FirstDeliveredAt = &at
+	}
+	return l
+}
+
+func seconds(d *time.Duration) float64 {
+	if d == nil {
+		return -1
+	}
+	return d.Seconds()
+}
+
+func TestStageDurations(t *testing.T) {
+	now := sloStart.Add(10 * time.Minute)
+	tests := []struct {
+		name                         string
+		latency                      models.AlertLatency
+		evaluation, outbox, delivery float64 // seconds; -1 for none
+	}{
+		{"confirmed", sloLatency(5*time.Second, 20*time.Second, 80*time.Second), 5, 15, 60},
+		{"stuck in the outbox", sloLatency(5*time.Second, -1, -1), 5, 595, -1},
+		{"awaiting delivery", sloLatency(5*time.Second, 20*time.Second, -1), 5, 15, 580},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stages := stageDurations(&tt.latency, now)
+			got := []float64{seconds(stages[SLOStageEvaluation]), seconds(stages[SLOStageOutbox]), seconds(stages[SLOStageDelivery])}
+			want := []float64{tt.evaluation, tt.outbox, tt.delivery}
+			for i, stage := range sloStages {
+				if got[i] != want[i] {
+					t.Errorf("%s = %vs, want %vs", stage, got[i], want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestSLOReport(t *testing.T) {
+	target := 3 * time.Minute
+	now := sloStart.Add(time.Hour)
+	fast := sloLatency(2*time.Second, 10*time.Second, 40*time.Second)
+	carrier := sloLatency(2*time.Second, 10*time.Second, 10*time.Minute) // slow delivery
+	backlog := sloLatency(3*time.Second, 5*time.Minute, 6*time.Minute)   // slow outbox
+	stuck := sloLatency(time.Second, -1, -1)                             // never sent
+	pending := sloLatency(time.Second, 5*time.Second, -1)
+	pending.DetectedAt, pending.CreatedAt = now.Add(-time.Minute), now.Add(-59*time.Second)
+	*pending.FirstAttemptAt = now.Add(-55 * time.Second)
+
+	report := buildSLOReport([]models.AlertLatency{fast, carrier, backlog, stuck, pending}, target, now, 3)
+
+	if report.TargetSeconds != 180 || report.Alerts != 5 || report.Confirmed != 3 || report.Pending != 1 || report.Breaches != 3 {
+		t.Errorf("report = target %d, %d alerts, %d confirmed, %d pending, %d breaches; want 180, 5, 3, 1, 3",
+			report.TargetSeconds, report.Alerts, report.Confirmed, report.Pending, report.Breaches)
+	}
+	if report.BreachRatio != 0.75 {
+		t.Errorf("breach ratio = %v, want 0.75 (pending left out)", report.BreachRatio)
+	}
+
+	// Stages count the alerts that completed them
+	counts := map[string]int{SLOStageEvaluation: 5, SLOStageOutbox: 4, SLOStageDelivery: 3}
+	for stage, want := range counts {
+		if got := report.Stages[stage].Count; got != want {
+			t.Errorf("%s count = %d, want %d", stage, got, want)
+		}
+	}
+	if report.Total.Count != 3 || *report.Total.P50 != 360 || *report.Total.Max != 600 {
+		t.Errorf("total = %d, p50 %v, max %v; want 3, 360, 600", report.Total.Count, *report.Total.P50, *report.Total.Max)
+	}
+
+	// The distribution holds the confirmed alerts and nothing else
+	var distributed int
+	for _, b := range report.Distribution {
+		distributed += b.Count
+		if b.LE != nil && *b.LE == 60 && b.Count != 1 {
+			t.Errorf("%d alerts up to 60s, want 1", b.Count)
+		}
+	}
+	if distributed != 3 || report.Distribution[len(report.Distribution)-1].LE != nil {
+		t.Errorf("distribution = %+v", report.Distribution)
+	}
+
+	// The slowest first, each saying where it lost its time
+	want := []struct {
+		id      uuid.UUID
+		slowest string
+	}{{stuck.AlertID, SLOStageOutbox}, {carrier.AlertID, SLOStageDelivery}, {backlog.AlertID, SLOStageOutbox}}
+	if len(report.Worst) != len(want) {
+		t.Fatalf("%d worst, want %d", len(report.Worst), len(want))
+	}
+	for i, w := range want {
+		got := report.Worst[i]
+		if got.AlertID != w.id || got.Slowest != w.slowest {
+			t.Errorf("worst[%d] = %s slowest in %s, want %s in %s", i, got.AlertID, got.Slowest, w.id, w.slowest)
+		}
+	}
+	if stuck := report.Worst[0]; stuck.Confirmed || stuck.Stages[SLOStageDelivery] != nil {
+		t.Errorf("unsent alert = %+v, want unconfirmed with no delivery stage", stuck)
+	}
+}
+
+func TestSLOReportEmpty(t *testing.T) {
+	report := buildSLOReport(nil, 3*time.Minute, sloStart, 10)
+	if report.BreachRatio != 0 || report.Total.P50 != nil || len(report.Worst) != 0 {
+		t.Errorf("empty report = %+v", report)
+	}
+}
+
+// The time an evaluation was requested reaches it through the pool's
+// queue, however long it waited there
+func TestEvaluationPoolStampsRequest(t *testing.T) {
+	requested := make(chan time.Time, 1)
+	pool := newTestEvaluationPool(1, 4, func(ctx context.Context, userID uuid.UUID) (*EvaluationResult, error) {
+		at, _ := ctx.Value(evaluationRequestedKey{}).(time.Time)
+		requested <- at
+		return &EvaluationResult{State: StateSafe}, nil
+	})
+	defer pool.Close()
+
+	before := time.Now()
+	done := pool.Enqueue(uuid.New(), time.Time{})
+	after := time.Now()
+	time.Sleep(20 * time.Millisecond) // queued, with no worker yet
+	pool.Start()
+	<-done
+
+	at := <-requested
+	if at.Before(before) || at.After(after) {
+		t.Errorf("requested at %s, want when enqueued, between %s and %s", at, before, after)
+	}
+}
+
+// Each stage is stamped as the alert moves along: detection when the
+// evaluation was requested, the first attempt once, the alert carried by
+// its texts and their retries, and only the first confirmation counted
+func TestAlertLatencyStamps(t *testing.T) {
+	postgres := testPostgres(t)
+	redis := testRedis(t)
+	ctx := context.Background()
+	user := createTestUser(t, postgres, "Ada")
+	cfg := config.NewStore(&config.Config{AlertSLOTargetSeconds: 180, SMSDefaultProvider: ProviderTermii})
+
+	now := time.Now().Truncate(time.Millisecond)
+	se := &SafetyEvaluator{cfg: cfg, postgres: postgres, clock: NewFakeClock(now), effects: discardEffects{}}
+	newAlert := func() *models.Alert {
+		return &models.Alert{ID: uuid.New(), UserID: user.ID, State: models.AlertStateAlert, Reasons: models.Reasons{}, SentTo: []string{}, CreatedAt: now}
+	}
+
+	// Raised by an evaluation requested 30s earlier, and outside one
+	alert := newAlert()
+	evaluation := context.WithValue(ctx, evaluationRequestedKey{}, now.Add(-30*time.Second))
+	if err := se.createAlert(evaluation, alert); err != nil {
+		t.Fatalf("createAlert: %v", err)
+	}
+	if !alert.DetectedAt.Equal(now.Add(-30 * time.Second)) {
+		t.Errorf("detected at %s, want when the evaluation was requested", alert.DetectedAt)
+	}
+	panicAlert := newAlert()
+	if err := se.createAlert(ctx, panicAlert); err != nil {
+		t.Fatalf("createAlert: %v", err)
+	}
+	if !panicAlert.DetectedAt.Equal(now) {
+		t.Errorf("alert outside an evaluation detected at %s, want when created", panicAlert.DetectedAt)
+	}
+
+	ae := &AlertEngine{postgres: postgres}
+	ae.markAttempted(ctx, alert)
+	time.Sleep(10 * time.Millisecond)
+	again := time.Now()
+	ae.markAttempted(ctx, alert) // an escalation doesn't move it
+
+	// The alert's texts, retries included, trace back to it
+	router := NewSMSRouter(cfg.Current(), redis, nil)
+	termii, at := NewFakeSMSProvider(ProviderTermii), NewFakeSMSProvider(ProviderAfricasTalking)
+	router.Register(termii)
+	router.Register(at)
+	if err := router.sendVia(withAlertSMS(withSMSKind(ctx, MessageAlert), alert.ID), termii, "+2348031234567", "alert", 1); err != nil {
+		t.Fatalf("sendVia: %v", err)
+	}
+	if err := router.HandleDeliveryFailure(ctx, ProviderTermii, termii.Messages()[0].ID); err != nil {
+		t.Fatalf("HandleDeliveryFailure: %v", err)
+	}
+	delivery, err := router.Delivery(ctx, ProviderAfricasTalking, at.Messages()[0].ID)
+	if err != nil || delivery == nil || delivery.AlertID == nil || *delivery.AlertID != alert.ID {
+		t.Fatalf("retry tracked as %+v (%v), want it to carry alert %s", delivery, err, alert.ID)
+	}
+
+	slo := NewAlertSLO(cfg, postgres)
+	delivered := time.Now()
+	if err := slo.Delivered(ctx, *delivery.AlertID, delivered); err != nil {
+		t.Fatalf("Delivered: %v", err)
+	}
+	if err := slo.Delivered(ctx, alert.ID, delivered.Add(time.Minute)); err != nil {
+		t.Fatalf("Delivered again: %v", err)
+	}
+	if total := slo.histograms[SLOStageTotal].count; total != 1 || slo.confirmed != 1 {
+		t.Errorf("%d totals observed, %d confirmed; want 1 each", total, slo.confirmed)
+	}
+
+	latencies, err := postgres.ListAlertLatencies(ctx, now.Add(-time.Second), now.Add(time.Second))
+	if err != nil {
+		t.Fatalf("ListAlertLatencies: %v", err)
+	}
+	var stamped *models.AlertLatency
+	for i := range latencies {
+		if latencies[i].AlertID == alert.ID {
+			stamped = &latencies[i]
+		}
+	}
+	if stamped == nil {
+		t.Fatalf("alert %s not in the SLO", alert.ID)
+	}
+	if !stamped.DetectedAt.Equal(alert.DetectedAt) || !stamped.CreatedAt.Equal(now) {
+		t.Errorf("stored detected %s, created %s; want %s, %s", stamped.DetectedAt, stamped.CreatedAt, alert.DetectedAt, now)
+	}
+	if stamped.FirstAttemptAt == nil || stamped.FirstDeliveredAt == nil {
+		t.Fatalf("stored stages = %+v, want attempted and delivered", stamped)
+	}
+	if !stamped.FirstAttemptAt.Before(again) {
+		t.Errorf("first attempt %s moved by the second at %s", stamped.FirstAttemptAt, again)
+	}
+	if !stamped.FirstDeliveredAt.Equal(delivered.Truncate(time.Microsecond)) {
+		t.Errorf("delivered at %s, want the first confirmation %s", stamped.FirstDeliveredAt, delivered)
+	}
+
+	var metrics strings.Builder
+	slo.WriteMetrics(ctx, &metrics)
+	if !strings.Contains(metrics.String(), `safetrace_alert_latency_seconds_count{stage="total"} 1`) {
+		t.Errorf("metrics missing the confirmed alert:\n%s", metrics.String())
+	}
+}
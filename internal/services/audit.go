@@ -0,0 +1,240 @@
+package services
+
+import (
+	"context"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/database"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+)
+
+// Audit actions
+const (
+	AuditStatusView          = "user.status.view"
+	AuditLastGaspView        = "lastgasp.view"
+	AuditTrailsView          = "blackbox.trails.view"
+	AuditTrailMigrationStart = "blackbox.migration.start"
+	AuditContactAdd          = "contact.add"
+	AuditContactUpdate       = "contact.update"
+	AuditContactDelete       = "contact.delete"
+	AuditContactRestore      = "contact.restore"
+	AuditAlertResolve        = "alert.resolve"
+	AuditAlertAutoResolve    = "alert.auto_resolve"
+	AuditAlertRecipientsView = "alert.recipients.view"
+	AuditBroadcastCreate     = "broadcast.create"
+	AuditBroadcastAbort      = "broadcast.abort"
+	AuditSimulationRun       = "simulation.run"
+	AuditEvaluateAt          = "simulation.evaluate_at"
+	AuditAuditView           = "audit.view"
+	AuditLinkRequest         = "account_link.request"
+	AuditLinkAccept          = "account_link.accept"
+	AuditLinkRevoke          = "account_link.revoke"
+	AuditGuardianRulesUpdate = "account_link.rules.update"
+	AuditGuardianRulesDelete = "account_link.rules.delete"
+	AuditGuardianNotice      = "account_link.notice"
+	AuditTrackingCommand     = "tracking.command"
+	AuditScoreHistoryView    = "score_history.view"
+	AuditStateHistoryView    = "state_history.view"
+	AuditAlertsView          = "alerts.view"
+	AuditContactTokenIssue   = "contact_token.issue"
+	AuditContactPrefsUpdate  = "contact.preferences.update"
+	AuditSignatureLockout    = "heartbeat.signature_lockout"
+	AuditSignatureUnlock     = "heartbeat.signature_unlock"
+	AuditAlertsExport        = "alerts.export"
+	AuditTrackExport         = "heartbeat_track.export"
+	AuditHeatmapExport       = "heatmap.export"
+	AuditProtectionPause     = "protection.pause"
+	AuditProtectionResume    = "protection.resume"
+	AuditProtectionView      = "protection.view"
+	AuditWatchStart          = "watch.start"
+	AuditWatchComplete       = "watch.complete"
+	AuditWatchExpire         = "watch.expire"
+	AuditWatchView           = "watch.view"
+	AuditChannelCreate       = "channel.create"
+	AuditChannelUpdate       = "channel.update"
+	AuditChannelDelete       = "channel.delete"
+	AuditChannelTest         = "channel.test"
+	AuditCandidateSet        = "scoring.candidate_set"
+	AuditCandidateClear      = "scoring.candidate_clear"
+	AuditCandidatePromote    = "scoring.promote"
+	AuditShadowDiffView      = "shadow.diff.view"
+	AuditWelfareRequest      = "welfare_check.request"
+	AuditWelfareConfirm      = "welfare_check.confirm"
+	AuditWelfareUnanswered   = "welfare_check.unanswered"
+	AuditOrgCreate           = "org.create"
+	AuditOrgUpdate           = "org.update"
+	AuditOrgAdminToken       = "org.admin_token"
+	AuditOrgInvite           = "org.invite"
+	AuditOrgJoin             = "org.join"
+	AuditOrgLeave            = "org.leave"
+	AuditOrgMemberRemove     = "org.member_remove"
+	AuditOrgMembersView      = "org.members.view"
+	AuditAdminStatsView      = "admin.stats.view"
+	AuditAdminAlertsView     = "admin.alerts.view"
+	AuditDashboardView       = "alert_dashboard.view"
+	AuditDashboardAck        = "alert_dashboard.acknowledge"
+	AuditDashboardShare      = "alert_dashboard.share"
+	AuditOutboundLimitRaise  = "outbound.limit_raise"
+	AuditOutboundLimitReset  = "outbound.limit_reset"
+	AuditResponderQuery      = "responder.alerts.query"
+	AuditResponderAck        = "responder.alert.acknowledge"
+	AuditResponderKeyIssue   = "responder_key.issue"
+	AuditResponderKeyRevoke  = "responder_key.revoke"
+	AuditSettingsUpdate      = "user.settings.update"
+	AuditSLOView             = "slo.view"
+	AuditBundleRequest       = "alert.bundle.request"
+	AuditBundleView          = "alert.bundle.view"
+	AuditBundleDownload      = "alert.bundle.download"
+	AuditCheckInAnswer       = "check_in.answer"
+	AuditHomeView            = "home.view"
+	AuditConsentGrant        = "consent.grant"
+	AuditConsentRevoke       = "consent.revoke"
+	AuditConsentView         = "consent.view"
+	AuditConsentSupersede    = "consent.supersede"
+	AuditFeatureFlagSet      = "feature_flag.set"
+	AuditFeatureFlagClear    = "feature_flag.clear"
+	AuditAlertReconcile      = "alert.reconcile"
+	AuditReconcileView       = "alert.reconcile.view"
+	AuditJobRunNow           = "job.run_now"
+	AuditAgencyHandoff       = "alert.agency_handoff"
+	AuditHandoffsView        = "alert.agency_handoffs.view"
+	AuditUnverifiedReport    = "heartbeat.unverified"
+	AuditUnverifiedAnswer    = "heartbeat.unverified.answer"
+)
+
+const auditWriterWorker = "audit_writer"
+
+// AuditLogger queues audit events and writes them in batches off the request
+// path. When the queue is full events are dropped and counted rather than
+// slowing the request down.
+type AuditLogger struct {
+	postgres      *database.PostgresDB
+	health        *HealthRegistry
+	queue         chan *models.AuditEvent
+	batchSize     int
+	flushInterval time.Duration
+	dropped       atomic.Int64
+
+	closeOnce sync.Once
+	done      chan struct{}
+}
+
+func NewAuditLogger(
+	postgres *database.PostgresDB,
+	health *HealthRegistry,
+	queueSize int,
+	batchSize int,
+	flushInterval time.Duration,
+) *AuditLogger {
+	return &AuditLogger{
+		postgres:      postgres,
+		health:        health,
+		queue:         make(chan *models.AuditEvent, queueSize),
+		batchSize:     batchSize,
+		flushInterval: flushInterval,
+		done:          make(chan struct{}),
+	}
+}
+
+// Start launches the writer goroutine
+func (a *AuditLogger) Start() {
+	a.health.Register(auditWriterWorker, a.flushInterval)
+	go a.run()
+}
+
+// Record queues an event without blocking. ID and CreatedAt are filled in.
+func (a *AuditLogger) Record(event *models.AuditEvent) {
+	if a == nil {
+		return
+	}
+	event.ID = uuid.New()
+	event.CreatedAt = time.Now()
+	if event.Metadata == nil {
+		event.Metadata = map[string]interface{}{}
+	}
+
+	select {
+	case a.queue <- event:
+	default:
+		if n := a.dropped.Add(1); n == 1 || n%1000 == 0 {
+			log.Printf("WARN: Audit queue full, %d events dropped so far", n)
+		}
+	}
+}
+
+// Dropped returns how many events were discarded because the queue was full
+func (a *AuditLogger) Dropped() int64 {
+	if a == nil {
+		return 0
+	}
+	return a.dropped.Load()
+}
+
+// Len returns the number of events waiting to be written
+func (a *AuditLogger) Len() int {
+	if a == nil {
+		return 0
+	}
+	return len(a.queue)
+}
+
+// Close stops the writer after flushing queued events
+func (a *AuditLogger) Close() {
+	a.closeOnce.Do(func() {
+		close(a.queue)
+	})
+	<-a.done
+}
+
+func (a *AuditLogger) run() {
+	defer close(a.done)
+
+	ticker := time.NewTicker(a.flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]*models.AuditEvent, 0, a.batchSize)
+	for {
+		select {
+		case event, ok := <-a.queue:
+			if !ok {
+				a.flush(batch)
+				return
+			}
+			batch = append(batch, event)
+			if len(batch) >= a.batchSize {
+				a.flush(batch)
+				batch = make([]*models.AuditEvent, 0, a.batchSize)
+			}
+
+		case <-ticker.C:
+			ok := true
+			if len(batch) > 0 {
+				ok = a.flush(batch)
+				batch = make([]*models.AuditEvent, 0, a.batchSize)
+			}
+			if ok {
+				a.health.Beat(auditWriterWorker)
+			}
+		}
+	}
+}
+
+// flush writes a batch and reports whether it was stored
+func (a *AuditLogger) flush(batch []*models.AuditEvent) bool {
+	if len(batch) == 0 {
+		return true
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if _, err := a.postgres.CopyAuditEvents(ctx, batch); err != nil {
+		log.Printf("ERROR: Failed to write %d audit events: %v", len(batch), err)
+		return false
+	}
+	return true
+}
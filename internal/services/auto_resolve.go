@@ -0,0 +1,189 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/config"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/database"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/flags"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+)
+
+// autoResolveStreakTTL forgets a streak of healthy heartbeats that stopped
+// without breaking, e.g. when the phone went quiet at home
+const autoResolveStreakTTL = 6 * time.Hour
+
+// ErrNoAutoResolvePrompt is returned when confirming an alert's resolution
+// the user wasn't asked about, or was asked too long ago
+var ErrNoAutoResolvePrompt = errors.New("no auto-resolve prompt is waiting")
+
+// AutoResolver resolves an alert the user is plainly safe from, for users
+// who opted in with settings.auto_resolve_zones. Once AUTO_RESOLVE_HEARTBEATS
+// healthy heartbeats in a row come from one of those safe zones, the user is
+// asked once to confirm they're home safe; confirming within
+// AUTO_RESOLVE_PROMPT_MINUTES resolves the alert as automatic and tells
+// their contacts. Alerts raised by a panic, a duress PIN or a detected
+// impact are never resolved this way.
+type AutoResolver struct {
+	cfg      *config.Store
+	postgres *database.PostgresDB
+	redis    *database.RedisDB
+	notifier Notifier
+	outbox   *AlertOutbox
+}
+
+func NewAutoResolver(cfg *config.Store, postgres *database.PostgresDB, redis *database.RedisDB, notifier Notifier, outbox *AlertOutbox) *AutoResolver {
+	return &AutoResolver{
+		cfg:      cfg,
+		postgres: postgres,
+		redis:    redis,
+		notifier: notifier,
+		outbox:   outbox,
+	}
+}
+
+// ExplicitDistress reports whether an alert was raised by the user asking
+// for help or by a detected impact; only they or an admin resolve those
+func ExplicitDistress(alert *models.Alert) bool {
+	if alert.Duress {
+		return true
+	}
+	for _, reason := range alert.Reasons {
+		switch reason.Code {
+		case models.ReasonPanic, models.ReasonDuress, models.ReasonImpact:
+			return true
+		}
+	}
+	return false
+}
+
+// HomeSafe reports whether a heartbeat counts towards auto-resolving an
+// alert: its evaluation is SAFE with a score at or above the safe
+// threshold, and it was sent from one of the user's auto-resolve zones
+func HomeSafe(settings models.UserSettings, hb *models.Heartbeat, result *EvaluationResult, profile ScoringProfile) bool {
+	if result.State != StateSafe || result.Score < profile.SafeThreshold {
+		return false
+	}
+	for _, i := range settings.AutoResolveZones {
+		if i >= 0 && i < len(settings.SafeZones) && GeofenceContains(settings.SafeZones[i], hb.Lat, hb.Lng) {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateAutoResolveZones checks auto-resolve zones name distinct safe zones
+func ValidateAutoResolveZones(zones []int, safeZones []models.Geofence) error {
+	seen := make(map[int]bool, len(zones))
+	for _, i := range zones {
+		if i < 0 || i >= len(safeZones) {
+			return fmt.Errorf("%d is not the index of a safe zone", i)
+		}
+		if seen[i] {
+			return fmt.Errorf("%d is listed twice", i)
+		}
+		seen[i] = true
+	}
+	return nil
+}
+
+// Observe counts a live heartbeat, just evaluated to result, towards
+// auto-resolving the user's open alert, and prompts them once the streak is
+// long enough. A heartbeat that doesn't count starts the streak over.
+// Failures are logged; the alert then waits to be resolved by hand, as it
+// does for users with the auto_resolve flag off.
+func (r *AutoResolver) Observe(ctx context.Context, hb *models.Heartbeat, result *EvaluationResult, profile ScoringProfile) {
+	if !flags.For(ctx, hb.UserID).Enabled(flags.AutoResolve) {
+		return
+	}
+	alert, err := r.postgres.GetLatestAlert(ctx, hb.UserID)
+	if err != nil {
+		log.Printf("WARN: Latest alert unavailable for auto-resolving user %s: %v", hb.UserID, err)
+		return
+	}
+	if alert == nil || alert.ResolvedAt != nil || ExplicitDistress(alert) {
+		return
+	}
+	user, err := r.postgres.GetUserByID(ctx, hb.UserID)
+	if err != nil || user == nil || len(user.Settings.AutoResolveZones) == 0 {
+		return
+	}
+
+	if !HomeSafe(user.Settings, hb, result, profile) {
+		if err := r.redis.ResetAutoResolveStreak(ctx, alert.ID); err != nil {
+			log.Printf("WARN: Failed to reset auto-resolve streak of alert %s: %v", alert.ID, err)
+		}
+		return
+	}
+	cfg := r.cfg.Current()
+	streak, err := r.redis.IncrAutoResolveStreak(ctx, alert.ID, autoResolveStreakTTL)
+	if err != nil {
+		log.Printf("WARN: Failed to count auto-resolve streak of alert %s: %v", alert.ID, err)
+		return
+	}
+	if streak < int64(cfg.AutoResolveHeartbeats) {
+		return
+	}
+
+	// One prompt at a time; one that goes unanswered needs a new streak
+	timeout := time.Duration(cfg.AutoResolvePromptMinutes) * time.Minute
+	claimed, err := r.redis.ClaimAutoResolvePrompt(ctx, alert.ID, timeout)
+	if err != nil || !claimed {
+		return
+	}
+	if err := r.redis.ResetAutoResolveStreak(ctx, alert.ID); err != nil {
+		log.Printf("WARN: Failed to reset auto-resolve streak of alert %s: %v", alert.ID, err)
+	}
+	token, err := r.postgres.GetPushToken(ctx, user.ID)
+	if err != nil || token == "" {
+		return
+	}
+	if err := r.notifier.SendAutoResolvePrompt(ctx, token, alert.ID.String(), int(timeout.Seconds())); err != nil {
+		log.Printf("WARN: Failed to send auto-resolve prompt for alert %s: %v", alert.ID, err)
+		return
+	}
+	log.Printf("INFO: User %s looks home safe; asked to resolve alert %s", user.ID, alert.ID)
+}
+
+// Confirm resolves the user's alert automatically after they confirmed a
+// prompt, and tells their contacts and channels. It returns
+// ErrNoAutoResolvePrompt unless the prompt about this alert is still open
+// and the user's auto_resolve flag is still on.
+func (r *AutoResolver) Confirm(ctx context.Context, alert *models.Alert) error {
+	if alert.ResolvedAt != nil || ExplicitDistress(alert) || !flags.For(ctx, alert.UserID).Enabled(flags.AutoResolve) {
+		return ErrNoAutoResolvePrompt
+	}
+	taken, err := r.redis.TakeAutoResolvePrompt(ctx, alert.ID)
+	if err != nil {
+		return err
+	}
+	if !taken {
+		return ErrNoAutoResolvePrompt
+	}
+
+	now := time.Now()
+	resolved, err := r.postgres.AutoResolveAlert(ctx, alert.ID, now)
+	if err != nil {
+		return err
+	}
+	if !resolved {
+		return ErrNoAutoResolvePrompt
+	}
+	alert.ResolvedAt = &now
+	alert.Resolution = models.AlertResolvedAuto
+
+	user, err := r.postgres.GetUserByID(ctx, alert.UserID)
+	if err != nil || user == nil {
+		log.Printf("ERROR: Failed to load user %s to announce auto-resolved alert %s: %v", alert.UserID, alert.ID, err)
+	} else {
+		r.outbox.EnqueueMessage(ctx, "auto-resolution of alert "+alert.ID.String(), func(ctx context.Context) error {
+			return r.notifier.SendAlertResolved(ctx, user, true)
+		})
+	}
+	r.outbox.EnqueueResolved(ctx, alert)
+	return nil
+}
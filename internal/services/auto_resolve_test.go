@@ -0,0 +1,285 @@
+package services
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/config"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+)
+
+// homeZone is a 200m safe zone around a Lagos address, workZone another on
+// Victoria Island; awayLat is 2km north of home
+var (
+	homeZone   = models.Geofence{Type: "radius", Center: &models.GeoPoint{Lat: 6.5244, Lng: 3.3792}, RadiusM: 200}
+	workZone   = models.Geofence{Type: "radius", Center: &models.GeoPoint{Lat: 6.4281, Lng: 3.4219}, RadiusM: 200}
+	awayLat    = 6.5424
+	autoTarget = ScoringProfile{SafeThreshold: 80, CautionThreshold: 50}
+)
+
+func TestHomeSafe(t *testing.T) {
+	optedIn := models.UserSettings{SafeZones: []models.Geofence{workZone, homeZone}, AutoResolveZones: []int{1}}
+	safe := &EvaluationResult{State: StateSafe, Score: 90}
+	atHome := &models.Heartbeat{Lat: 6.5245, Lng: 3.3793}
+
+	tests := []struct {
+		name     string
+		settings models.UserSettings
+		hb       *models.Heartbeat
+		result   *EvaluationResult
+		want     bool
+	}{
+		{"home safe", optedIn, atHome, safe, true},
+		{"at the threshold", optedIn, atHome, &EvaluationResult{State: StateSafe, Score: 80}, true},
+		{"not SAFE", optedIn, atHome, &EvaluationResult{State: StateCaution, Score: 90}, false},
+		{"below the threshold", optedIn, atHome, &EvaluationResult{State: StateSafe, Score: 79}, false},
+		{"away from home", optedIn, &models.Heartbeat{Lat: awayLat, Lng: 3.3792}, safe, false},
+		{"in a safe zone that doesn't resolve", optedIn, &models.Heartbeat{Lat: 6.4281, Lng: 3.4219}, safe, false},
+		{"not opted in", models.UserSettings{SafeZones: []models.Geofence{workZone, homeZone}}, atHome, safe, false},
+		{"zone since removed", models.UserSettings{SafeZones: []models.Geofence{workZone}, AutoResolveZones: []int{1}}, atHome, safe, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := HomeSafe(tt.settings, tt.hb, tt.result, autoTarget); got != tt.want {
+				t.Errorf("HomeSafe = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExplicitDistress(t *testing.T) {
+	tests := []struct {
+		name  string
+		alert models.Alert
+		want  bool
+	}{
+		{"stale heartbeat", models.Alert{Reasons: models.Reasons{{Code: models.ReasonHeartbeatStale}}}, false},
+		{"panic", models.Alert{Reasons: models.Reasons{{Code: models.ReasonPanic}}}, true},
+		{"panic after staleness", models.Alert{Reasons: models.Reasons{{Code: models.ReasonHeartbeatStale}, {Code: models.ReasonPanic}}}, true},
+		{"duress reason", models.Alert{Reasons: models.Reasons{{Code: models.ReasonDuress}}}, true},
+		{"duress flag", models.Alert{Duress: true, Reasons: models.Reasons{}}, true},
+		{"impact", models.Alert{Reasons: models.Reasons{{Code: models.ReasonImpact}}}, true},
+	}
+	for _, tt := range tests {
+		if got := ExplicitDistress(&tt.alert); got != tt.want {
+			t.Errorf("%s: ExplicitDistress = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestValidateAutoResolveZones(t *testing.T) {
+	zones := []models.Geofence{homeZone, workZone}
+	if err := ValidateAutoResolveZones([]int{1, 0}, zones); err != nil {
+		t.Errorf("both zones: %v", err)
+	}
+	for _, bad := range [][]int{{2}, {-1}, {0, 0}} {
+		if err := ValidateAutoResolveZones(bad, zones); err == nil {
+			t.Errorf("%v accepted", bad)
+		}
+	}
+}
+
+// resolveNotifier records auto-resolve prompts and resolution messages
+type resolveNotifier struct {
+	Notifier // nil: anything else panics
+
+	mu        sync.Mutex
+	prompts   []string
+	automatic []bool
+}
+
+func (n *resolveNotifier) SendAutoResolvePrompt(ctx context.Context, fcmToken, alertID string, seconds int) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.prompts = append(n.prompts, alertID)
+	return nil
+}
+
+func (n *resolveNotifier) SendAlertResolved(ctx context.Context, user *models.User, automatic bool) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.automatic = append(n.automatic, automatic)
+	return nil
+}
+
+func (n *resolveNotifier) Prompts() int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return len(n.prompts)
+}
+
+type autoResolveFixture struct {
+	resolver *AutoResolver
+	notifier *resolveNotifier
+	outbox   *AlertOutbox
+	alert    *models.Alert
+}
+
+// newAutoResolveFixture opens an AT_RISK alert for a user with a push token
+// and the given settings, on a resolver wanting 3 heartbeats in a row
+func newAutoResolveFixture(t *testing.T, settings models.UserSettings, reasons models.Reasons) *autoResolveFixture {
+	t.Helper()
+	postgres := testPostgres(t)
+	redis := testRedis(t)
+	ctx := context.Background()
+	user := createTestUser(t, postgres, "Ada")
+	if err := postgres.UpdateUserSettings(ctx, user.ID, settings); err != nil {
+		t.Fatalf("UpdateUserSettings: %v", err)
+	}
+	if err := postgres.UpsertPushToken(ctx, user.ID, "fcm-"+uuid.NewString()); err != nil {
+		t.Fatalf("UpsertPushToken: %v", err)
+	}
+	alert := &models.Alert{ID: uuid.New(), UserID: user.ID, State: models.AlertStateAtRisk, Reasons: reasons, SentTo: []string{}, CreatedAt: time.Now()}
+	if err := postgres.CreateAlert(ctx, alert); err != nil {
+		t.Fatalf("CreateAlert: %v", err)
+	}
+
+	f := &autoResolveFixture{notifier: &resolveNotifier{}, alert: alert}
+	f.outbox = NewAlertOutbox(f.notifier, nil, 1, nil) // never started: deliveries wait in the queue
+	cfg := config.NewStore(&config.Config{AutoResolveHeartbeats: 3, AutoResolvePromptMinutes: 15})
+	f.resolver = NewAutoResolver(cfg, postgres, redis, f.notifier, f.outbox)
+	return f
+}
+
+// observe has the resolver count a SAFE heartbeat scored score at lat
+func (f *autoResolveFixture) observe(lat float64, score int) {
+	hb := &models.Heartbeat{ID: uuid.New(), UserID: f.alert.UserID, Lat: lat, Lng: 3.3792, Timestamp: time.Now()}
+	state := StateSafe
+	if score < autoTarget.SafeThreshold {
+		state = StateCaution
+	}
+	f.resolver.Observe(context.Background(), hb, &EvaluationResult{State: state, Score: score}, autoTarget)
+}
+
+func (f *autoResolveFixture) latest(t *testing.T) *models.Alert {
+	t.Helper()
+	alert, err := f.resolver.postgres.GetLatestAlert(context.Background(), f.alert.UserID)
+	if err != nil || alert == nil {
+		t.Fatalf("GetLatestAlert = %v, %v", alert, err)
+	}
+	return alert
+}
+
+var optedInHome = models.UserSettings{SafeZones: []models.Geofence{homeZone}, AutoResolveZones: []int{0}}
+
+var staleReasons = models.Reasons{{Code: models.ReasonHeartbeatStale, Params: map[string]interface{}{"minutes": 40}}}
+
+// Only an unbroken streak of healthy heartbeats from home prompts, and only
+// once; confirming resolves the alert as automatic and says so to contacts
+func TestAutoResolve(t *testing.T) {
+	f := newAutoResolveFixture(t, optedInHome, staleReasons)
+	ctx := context.Background()
+
+	if err := f.resolver.Confirm(ctx, f.latest(t)); err != ErrNoAutoResolvePrompt {
+		t.Errorf("Confirm before a prompt = %v, want ErrNoAutoResolvePrompt", err)
+	}
+
+	// Each break starts the streak over: a heartbeat away, one not healthy
+	f.observe(6.5244, 90)
+	f.observe(6.5244, 90)
+	f.observe(awayLat, 95)
+	f.observe(6.5244, 90)
+	f.observe(6.5244, 90)
+	f.observe(6.5244, 60)
+	f.observe(6.5244, 90)
+	f.observe(6.5244, 90)
+	if n := f.notifier.Prompts(); n != 0 {
+		t.Fatalf("%d prompts before 3 healthy heartbeats in a row at home", n)
+	}
+	f.observe(6.5244, 90)
+	if n := f.notifier.Prompts(); n != 1 || f.notifier.prompts[0] != f.alert.ID.String() {
+		t.Fatalf("prompts = %v, want one for alert %s", f.notifier.prompts, f.alert.ID)
+	}
+	for i := 0; i < 4; i++ {
+		f.observe(6.5244, 90)
+	}
+	if n := f.notifier.Prompts(); n != 1 {
+		t.Errorf("%d prompts while one is waiting, want 1", n)
+	}
+	if got := f.latest(t); got.ResolvedAt != nil {
+		t.Fatalf("alert resolved without confirming: %+v", got)
+	}
+
+	if err := f.resolver.Confirm(ctx, f.latest(t)); err != nil {
+		t.Fatalf("Confirm: %v", err)
+	}
+	resolved := f.latest(t)
+	if resolved.ResolvedAt == nil || resolved.Resolution != models.AlertResolvedAuto {
+		t.Errorf("alert resolved at %v as %q, want auto", resolved.ResolvedAt, resolved.Resolution)
+	}
+	if err := f.resolver.Confirm(ctx, resolved); err != ErrNoAutoResolvePrompt {
+		t.Errorf("Confirm again = %v, want ErrNoAutoResolvePrompt", err)
+	}
+
+	// Contacts hear it was resolved automatically; channels that it's over
+	if len(f.outbox.queue) != 2 {
+		t.Fatalf("%d deliveries queued, want the message and the resolution", len(f.outbox.queue))
+	}
+	message := <-f.outbox.queue
+	if err := message.message(ctx); err != nil {
+		t.Fatalf("resolution message: %v", err)
+	}
+	if len(f.notifier.automatic) != 1 || !f.notifier.automatic[0] {
+		t.Errorf("resolution messages automatic = %v, want [true]", f.notifier.automatic)
+	}
+	if event := <-f.outbox.queue; event.event != ChannelEventResolved || event.alert.ID != f.alert.ID {
+		t.Errorf("channel delivery = %s for %v, want resolved for %s", event.event, event.alert, f.alert.ID)
+	}
+}
+
+// A confirmation after the prompt timed out doesn't resolve
+func TestAutoResolvePromptTimeout(t *testing.T) {
+	f := newAutoResolveFixture(t, optedInHome, staleReasons)
+	ctx := context.Background()
+	if claimed, err := f.resolver.redis.ClaimAutoResolvePrompt(ctx, f.alert.ID, 50*time.Millisecond); err != nil || !claimed {
+		t.Fatalf("ClaimAutoResolvePrompt = %v, %v", claimed, err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if err := f.resolver.Confirm(ctx, f.latest(t)); err != ErrNoAutoResolvePrompt {
+		t.Errorf("Confirm after the timeout = %v, want ErrNoAutoResolvePrompt", err)
+	}
+	if got := f.latest(t); got.ResolvedAt != nil {
+		t.Errorf("alert resolved after the timeout: %+v", got)
+	}
+}
+
+// Without opting in, or for an alert the user asked for, no streak prompts
+// and no confirmation resolves
+func TestAutoResolveExcluded(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings models.UserSettings
+		reasons  models.Reasons
+	}{
+		{"not opted in", models.UserSettings{SafeZones: []models.Geofence{homeZone}}, staleReasons},
+		{"panic", optedInHome, models.Reasons{{Code: models.ReasonPanic, Params: map[string]interface{}{"source": "app"}}}},
+		{"duress", optedInHome, models.Reasons{{Code: models.ReasonDuress}}},
+		{"impact", optedInHome, models.Reasons{{Code: models.ReasonImpact}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newAutoResolveFixture(t, tt.settings, tt.reasons)
+			ctx := context.Background()
+			for i := 0; i < 6; i++ {
+				f.observe(6.5244, 95)
+			}
+			if n := f.notifier.Prompts(); n != 0 {
+				t.Errorf("%d prompts, want none", n)
+			}
+			if tt.settings.AutoResolveZones != nil {
+				// Even a prompt claimed some other way doesn't resolve it
+				f.resolver.redis.ClaimAutoResolvePrompt(ctx, f.alert.ID, time.Minute)
+				if err := f.resolver.Confirm(ctx, f.latest(t)); err != ErrNoAutoResolvePrompt {
+					t.Errorf("Confirm = %v, want ErrNoAutoResolvePrompt", err)
+				}
+			}
+			if got := f.latest(t); got.ResolvedAt != nil {
+				t.Errorf("alert resolved: %+v", got)
+			}
+		})
+	}
+}
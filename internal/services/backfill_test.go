@@ -0,0 +1,117 @@
+package services
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+)
+
+// Heartbeats delivered out of order: only one newer than every heartbeat
+// already seen drives state, the rest are stored as backfill
+func TestMarkBackfillOutOfOrder(t *testing.T) {
+	se := &SafetyEvaluator{redis: testRedis(t)}
+	userID := uuid.New()
+	t0 := time.Date(2026, 3, 9, 18, 0, 0, 0, time.UTC)
+
+	deliveries := []struct {
+		name         string
+		at           time.Time
+		wantBackfill bool
+	}{
+		{"first", t0.Add(10 * time.Minute), false},
+		{"queued offline, older", t0, true},
+		{"delayed SMS, older", t0.Add(5 * time.Minute), true},
+		{"same time as the newest", t0.Add(10 * time.Minute), true},
+		{"newer", t0.Add(15 * time.Minute), false},
+		{"a millisecond newer", t0.Add(15*time.Minute + time.Millisecond), false},
+		{"between two seen", t0.Add(12 * time.Minute), true},
+	}
+	for _, d := range deliveries {
+		hb := &models.Heartbeat{ID: uuid.New(), UserID: userID, Timestamp: d.at}
+		se.MarkBackfill(context.Background(), hb)
+		if hb.Backfill != d.wantBackfill {
+			t.Errorf("%s: backfill = %v, want %v", d.name, hb.Backfill, d.wantBackfill)
+		}
+	}
+
+	// Another user's order is their own
+	hb := &models.Heartbeat{ID: uuid.New(), UserID: uuid.New(), Timestamp: t0}
+	se.MarkBackfill(context.Background(), hb)
+	if hb.Backfill {
+		t.Errorf("another user's first heartbeat marked as backfill")
+	}
+
+	// A copy of a heartbeat neither checks nor moves the order
+	dup := &models.Heartbeat{ID: uuid.New(), UserID: userID, Timestamp: t0.Add(time.Hour), DuplicateOf: &hb.ID}
+	se.MarkBackfill(context.Background(), dup)
+	next := &models.Heartbeat{ID: uuid.New(), UserID: userID, Timestamp: t0.Add(30 * time.Minute)}
+	se.MarkBackfill(context.Background(), next)
+	if dup.Backfill || next.Backfill {
+		t.Errorf("duplicate backfill %v, next backfill %v, want neither", dup.Backfill, next.Backfill)
+	}
+}
+
+// Concurrent deliveries can't both pass for the newest: the newest heartbeat
+// always drives state, and afterwards every one of them is backfill
+func TestMarkBackfillConcurrent(t *testing.T) {
+	se := &SafetyEvaluator{redis: testRedis(t)}
+	userID := uuid.New()
+	t0 := time.Date(2026, 3, 9, 18, 0, 0, 0, time.UTC)
+
+	heartbeats := make([]*models.Heartbeat, 50)
+	for i := range heartbeats {
+		heartbeats[i] = &models.Heartbeat{ID: uuid.New(), UserID: userID, Timestamp: t0.Add(time.Duration(i) * time.Second)}
+	}
+	var wg sync.WaitGroup
+	for _, hb := range heartbeats {
+		wg.Add(1)
+		go func(hb *models.Heartbeat) {
+			defer wg.Done()
+			se.MarkBackfill(context.Background(), hb)
+		}(hb)
+	}
+	wg.Wait()
+
+	if heartbeats[len(heartbeats)-1].Backfill {
+		t.Errorf("the newest heartbeat was marked as backfill")
+	}
+	for _, hb := range heartbeats {
+		again := &models.Heartbeat{ID: uuid.New(), UserID: userID, Timestamp: hb.Timestamp}
+		se.MarkBackfill(context.Background(), again)
+		if !again.Backfill {
+			t.Fatalf("heartbeat at %s not backfill after the newest", hb.Timestamp)
+		}
+	}
+}
+
+// A LastGasp that arrives after newer heartbeats is over; it opens no wait.
+// With no storage behind the evaluator, anything but returning early panics.
+func TestTrackLastGaspIgnoresBackfill(t *testing.T) {
+	se := &SafetyEvaluator{clock: SystemClock{}}
+	se.TrackLastGasp(context.Background(), &models.Heartbeat{UserID: uuid.New(), LastGasp: true, Backfill: true})
+	se.TrackLastGasp(context.Background(), &models.Heartbeat{UserID: uuid.New(), Backfill: true})
+}
+
+// A backfilled heartbeat doesn't break or feed a run of weakening signal
+func TestDeadZoneTrendSkipsBackfill(t *testing.T) {
+	cellular := func(rssi int, backfill bool) models.Heartbeat {
+		return models.Heartbeat{Connectivity: models.ConnectivityCellular, CellInfo: models.CellInfo{RSSI: rssi}, Backfill: backfill}
+	}
+	// Newest first
+	heartbeats := []models.Heartbeat{
+		cellular(-105, false),
+		{Connectivity: models.ConnectivityWiFi, Backfill: true},
+		cellular(-60, true),
+		cellular(-98, false),
+		cellular(-90, false),
+	}
+	from, to, ok := DeadZoneTrend(heartbeats)
+	if !ok || from != -90 || to != -105 {
+		t.Errorf("DeadZoneTrend() = %d, %d, %v, want -90, -105, true", from, to, ok)
+	}
+}
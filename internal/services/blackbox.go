@@ -0,0 +1,197 @@
+package services
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/utils"
+)
+
+const (
+	blackboxDataURIPrefix = "data:application/json;base64,"
+	// Trails in object storage are gzipped JSON under blackbox/<user>/<trail>.json.gz
+	blackboxObjectPrefix      = "blackbox/"
+	blackboxObjectContentType = "application/gzip"
+)
+
+// IsInlineTrail reports whether a trail's file_url holds the data itself
+// rather than an object key
+func IsInlineTrail(fileURL string) bool {
+	return strings.HasPrefix(fileURL, "data:")
+}
+
+// BlackboxObjectKey is where a trail's data is kept in object storage
+func BlackboxObjectKey(userID, trailID uuid.UUID) string {
+	return fmt.Sprintf("%s%s/%s.json.gz", blackboxObjectPrefix, userID, trailID)
+}
+
+// LoadBlackboxEntries reads a trail's data points from its data URI or, for
+// a trail moved to object storage, from store
+func LoadBlackboxEntries(ctx context.Context, store ObjectStore, fileURL string) ([]models.BlackboxEntry, error) {
+	if IsInlineTrail(fileURL) {
+		return DecodeBlackboxEntries(fileURL)
+	}
+	if !strings.HasPrefix(fileURL, blackboxObjectPrefix) {
+		return nil, fmt.Errorf("unrecognized trail location")
+	}
+	if store == nil {
+		return nil, ErrObjectStoreDisabled
+	}
+	data, err := store.Get(ctx, fileURL)
+	if err != nil {
+		return nil, err
+	}
+	return DecodeBlackboxObject(data)
+}
+
+// EncodeBlackboxObject is the object storage form of a trail: its data
+// points as gzipped JSON
+func EncodeBlackboxObject(entries []models.BlackboxEntry) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if err := json.NewEncoder(zw).Encode(entries); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// SaveBlackboxTrail stores a trail's data points and returns where, for its
+// file_url: in object storage when it is configured and reachable,
+// otherwise inline as a base64 data URI
+func SaveBlackboxTrail(ctx context.Context, store ObjectStore, userID, trailID uuid.UUID, entries []models.BlackboxEntry) (string, error) {
+	if store != nil {
+		data, err := EncodeBlackboxObject(entries)
+		if err != nil {
+			return "", err
+		}
+		key := BlackboxObjectKey(userID, trailID)
+		err = store.Put(ctx, key, data, blackboxObjectContentType)
+		if err == nil {
+			return key, nil
+		}
+		log.Printf("WARN: Storing trail %s inline: %v", trailID, err)
+	}
+
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return "", err
+	}
+	return blackboxDataURIPrefix + base64.StdEncoding.EncodeToString(data), nil
+}
+
+// DecodeBlackboxObject reads data points written by EncodeBlackboxObject
+func DecodeBlackboxObject(data []byte) ([]models.BlackboxEntry, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("invalid trail object: %w", err)
+	}
+	raw, err := io.ReadAll(zr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid trail object: %w", err)
+	}
+	var entries []models.BlackboxEntry
+	if err := json.Unmarshal(raw, &entries); err != nil {
+		return nil, fmt.Errorf("invalid trail object: %w", err)
+	}
+	return entries, nil
+}
+
+// DecodeBlackboxEntries reads the data points of a trail stored inline as a
+// data URI. Trails uploaded before SaveBlackboxTrail hold raw JSON after
+// the prefix, despite its label; newer ones are base64-encoded as it says.
+func DecodeBlackboxEntries(fileURL string) ([]models.BlackboxEntry, error) {
+	if !strings.HasPrefix(fileURL, blackboxDataURIPrefix) {
+		return nil, fmt.Errorf("trail is not stored inline")
+	}
+	payload := strings.TrimPrefix(fileURL, blackboxDataURIPrefix)
+
+	var entries []models.BlackboxEntry
+	if err := json.Unmarshal([]byte(payload), &entries); err == nil {
+		return entries, nil
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, fmt.Errorf("invalid trail payload: %w", err)
+	}
+	if err := json.Unmarshal(decoded, &entries); err != nil {
+		return nil, fmt.Errorf("invalid trail payload: %w", err)
+	}
+	return entries, nil
+}
+
+// BlackboxHeartbeats converts trail entries to heartbeats for replay
+func BlackboxHeartbeats(userID uuid.UUID, entries []models.BlackboxEntry) []models.Heartbeat {
+	heartbeats := make([]models.Heartbeat, 0, len(entries))
+	for _, e := range entries {
+		heartbeats = append(heartbeats, models.Heartbeat{
+			ID:        uuid.New(),
+			UserID:    userID,
+			Source:    "blackbox",
+			Lat:       e.Lat,
+			Lng:       e.Lng,
+			AccuracyM: e.AccuracyM,
+			CellInfo:  NormalizeCellInfo(e.CellInfo),
+			Timestamp: e.Timestamp,
+		})
+	}
+	return heartbeats
+}
+
+// ChainVerification is the result of checking a trail's hash chain
+type ChainVerification struct {
+	Status     string // models.Trail*
+	Head       string // hash of the last entry, when the chain is intact
+	BreakIndex *int   // first entry whose hash is missing or wrong
+}
+
+// VerifyBlackboxChain checks each entry's hash against the one before it and
+// the signature over the chain head against each of the secrets. A trail
+// with no hashes at all predates chaining and is unverified; one with only
+// some hashes is broken at the first entry without one. An entry with
+// malformed fields can't be hashed again as the client did, so the chain
+// carries on from its own hash.
+func VerifyBlackboxChain(userID uuid.UUID, entries []models.BlackboxEntry, signature string, secrets []string) ChainVerification {
+	hashed := false
+	for i := range entries {
+		if entries[i].Hash != "" {
+			hashed = true
+			break
+		}
+	}
+	if !hashed {
+		return ChainVerification{Status: models.TrailUnverified}
+	}
+
+	prev := utils.BlackboxChainGenesis
+	for i := range entries {
+		if len(entries[i].Malformed) > 0 && entries[i].Hash != "" {
+			prev = strings.ToLower(entries[i].Hash)
+			continue
+		}
+		expected := utils.BlackboxEntryHash(prev, &entries[i])
+		if !strings.EqualFold(entries[i].Hash, expected) {
+			index := i
+			return ChainVerification{Status: models.TrailBroken, BreakIndex: &index}
+		}
+		prev = expected
+	}
+
+	result := ChainVerification{Status: models.TrailVerified, Head: prev}
+	if !utils.VerifyStringSignatureAny(utils.BlackboxChainSigningString(userID, prev), signature, secrets) {
+		result.Status = models.TrailBadSignature
+	}
+	return result
+}
@@ -0,0 +1,257 @@
+package services
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+)
+
+const (
+	// blackboxMaxAccel is the largest acceleration (m/s² per axis) a phone's
+	// accelerometer reports; most saturate at 16g, a few at 32g
+	blackboxMaxAccel = 32 * standardGravity
+	// blackboxMaxGyro is the largest rotation rate (rad/s per axis) a phone's
+	// gyroscope reports, 2000°/s
+	blackboxMaxGyro = 2000 * math.Pi / 180
+)
+
+// nonFiniteTokens are what some JSON encoders write for non-finite floats,
+// with the string each is quoted to
+var nonFiniteTokens = []struct {
+	token, quoted string
+}{
+	{"-Infinity", `"-Infinity"`},
+	{"+Infinity", `"Infinity"`},
+	{"Infinity", `"Infinity"`},
+	{"NaN", `"NaN"`},
+}
+
+// QuoteNonFiniteNumbers quotes the bare NaN and Infinity some encoders write
+// for non-finite floats. They aren't JSON and would fail the whole body;
+// quoted, models.BlackboxEntry rejects just the fields that hold them.
+// Strings are left alone.
+func QuoteNonFiniteNumbers(body []byte) []byte {
+	if !bytes.Contains(body, []byte("NaN")) && !bytes.Contains(body, []byte("Infinity")) {
+		return body
+	}
+
+	out := make([]byte, 0, len(body)+16)
+	inString, escaped := false, false
+	for i := 0; i < len(body); i++ {
+		c := body[i]
+		if inString {
+			switch {
+			case escaped:
+				escaped = false
+			case c == '\\':
+				escaped = true
+			case c == '"':
+				inString = false
+			}
+			out = append(out, c)
+			continue
+		}
+		if c == '"' {
+			inString = true
+			out = append(out, c)
+			continue
+		}
+
+		quoted := false
+		for _, t := range nonFiniteTokens {
+			if bytes.HasPrefix(body[i:], []byte(t.token)) {
+				out = append(out, t.quoted...)
+				i += len(t.token) - 1
+				quoted = true
+				break
+			}
+		}
+		if !quoted {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// ScreenBlackboxEntries splits uploaded entries into those fit to keep and
+// those to quarantine, with why. An entry is rejected when a field could not
+// be decoded, its timestamp is missing, outside start to end or before the
+// last accepted entry's, its coordinates are out of range, or a sensor reads
+// beyond what a phone can measure.
+func ScreenBlackboxEntries(entries []models.BlackboxEntry, start, end time.Time) ([]models.BlackboxEntry, []models.QuarantinedEntry) {
+	accepted := make([]models.BlackboxEntry, 0, len(entries))
+	var quarantined []models.QuarantinedEntry
+	var last time.Time
+	for i := range entries {
+		e := &entries[i]
+		reasons := blackboxEntryProblems(e, start, end, last)
+		if len(reasons) > 0 {
+			quarantined = append(quarantined, models.QuarantinedEntry{
+				Index:     i,
+				Reasons:   reasons,
+				Malformed: e.Malformed,
+				Entry:     *e,
+			})
+			continue
+		}
+		accepted = append(accepted, *e)
+		last = e.Timestamp
+	}
+	return accepted, quarantined
+}
+
+func blackboxEntryProblems(e *models.BlackboxEntry, start, end, last time.Time) []string {
+	if slices.Contains(e.Malformed, "entry") {
+		return []string{"is not an object"}
+	}
+
+	var reasons []string
+	for _, field := range e.Malformed {
+		reasons = append(reasons, field+" could not be decoded")
+	}
+	if !slices.Contains(e.Malformed, "timestamp") {
+		switch {
+		case e.Timestamp.IsZero():
+			reasons = append(reasons, "timestamp is missing")
+		case e.Timestamp.Before(start) || e.Timestamp.After(end):
+			reasons = append(reasons, "timestamp is outside start_ts to end_ts")
+		case e.Timestamp.Before(last):
+			reasons = append(reasons, "timestamp is before the previous data point's")
+		}
+	}
+	if e.Lat < -90 || e.Lat > 90 {
+		reasons = append(reasons, "lat is out of range")
+	}
+	if e.Lng < -180 || e.Lng > 180 {
+		reasons = append(reasons, "lng is out of range")
+	}
+	if e.AccuracyM < 0 {
+		reasons = append(reasons, "accuracy_m is negative")
+	}
+
+	for _, axis := range SensorAxesOutOfRange(e.SensorData) {
+		reasons = append(reasons, "sensor_data."+axis+" is beyond what a phone can measure")
+	}
+	return reasons
+}
+
+// SensorAxesOutOfRange names the axes of a sensor reading beyond what a
+// phone can measure, such as "accel_x"
+func SensorAxesOutOfRange(s models.SensorData) []string {
+	var axes []string
+	for _, axis := range []struct {
+		name  string
+		value float64
+		limit float64
+	}{
+		{"accel_x", s.AccelX, blackboxMaxAccel}, {"accel_y", s.AccelY, blackboxMaxAccel}, {"accel_z", s.AccelZ, blackboxMaxAccel},
+		{"gyro_x", s.GyroX, blackboxMaxGyro}, {"gyro_y", s.GyroY, blackboxMaxGyro}, {"gyro_z", s.GyroZ, blackboxMaxGyro},
+	} {
+		if math.Abs(axis.value) > axis.limit {
+			axes = append(axes, axis.name)
+		}
+	}
+	return axes
+}
+
+// BlackboxQuarantineKey is where a trail's quarantined entries are kept in
+// object storage, beside the trail
+func BlackboxQuarantineKey(userID, trailID uuid.UUID) string {
+	return fmt.Sprintf("%s%s/%s.quarantine.json.gz", blackboxObjectPrefix, userID, trailID)
+}
+
+// SaveBlackboxQuarantine stores a trail's quarantined entries and returns
+// where: in object storage when it is configured and reachable, otherwise
+// inline as a base64 data URI
+func SaveBlackboxQuarantine(ctx context.Context, store ObjectStore, userID, trailID uuid.UUID, entries []models.QuarantinedEntry) (string, error) {
+	if store != nil {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		if err := json.NewEncoder(zw).Encode(entries); err != nil {
+			return "", err
+		}
+		if err := zw.Close(); err != nil {
+			return "", err
+		}
+		key := BlackboxQuarantineKey(userID, trailID)
+		err := store.Put(ctx, key, buf.Bytes(), blackboxObjectContentType)
+		if err == nil {
+			return key, nil
+		}
+		log.Printf("WARN: Storing quarantine of trail %s inline: %v", trailID, err)
+	}
+
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return "", err
+	}
+	return blackboxDataURIPrefix + base64.StdEncoding.EncodeToString(data), nil
+}
+
+// LoadBlackboxQuarantine reads the entries SaveBlackboxQuarantine stored at
+// url; an empty url is a trail with none
+func LoadBlackboxQuarantine(ctx context.Context, store ObjectStore, url string) ([]models.QuarantinedEntry, error) {
+	var data []byte
+	switch {
+	case url == "":
+		return nil, nil
+	case IsInlineTrail(url):
+		decoded, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(url, blackboxDataURIPrefix))
+		if err != nil {
+			return nil, fmt.Errorf("invalid quarantine payload: %w", err)
+		}
+		data = decoded
+	case store == nil:
+		return nil, ErrObjectStoreDisabled
+	default:
+		object, err := store.Get(ctx, url)
+		if err != nil {
+			return nil, err
+		}
+		zr, err := gzip.NewReader(bytes.NewReader(object))
+		if err != nil {
+			return nil, fmt.Errorf("invalid quarantine object: %w", err)
+		}
+		if data, err = io.ReadAll(zr); err != nil {
+			return nil, fmt.Errorf("invalid quarantine object: %w", err)
+		}
+	}
+
+	var entries []models.QuarantinedEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("invalid quarantine payload: %w", err)
+	}
+	for i := range entries {
+		entries[i].Entry.Malformed = entries[i].Malformed
+	}
+	return entries, nil
+}
+
+// RestoreBlackboxUpload puts quarantined entries back among the accepted
+// ones, in the order they were uploaded, so the hash chain can be checked
+// again as it was on upload
+func RestoreBlackboxUpload(accepted []models.BlackboxEntry, quarantined []models.QuarantinedEntry) []models.BlackboxEntry {
+	entries := make([]models.BlackboxEntry, 0, len(accepted)+len(quarantined))
+	next := 0
+	for _, q := range quarantined {
+		for len(entries) < q.Index && next < len(accepted) {
+			entries = append(entries, accepted[next])
+			next++
+		}
+		entries = append(entries, q.Entry)
+	}
+	return append(entries, accepted[next:]...)
+}
@@ -0,0 +1,161 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+)
+
+// Fixtures in testdata/blackbox_uploads are data_points as the apps upload
+// them, bare NaN and Infinity included, for a trail from 10:00 to 10:01
+var (
+	uploadStart = time.Date(2026, 3, 9, 10, 0, 0, 0, time.UTC)
+	uploadEnd   = uploadStart.Add(time.Minute)
+)
+
+func uploadFixture(t *testing.T, name string) []models.BlackboxEntry {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata/blackbox_uploads", name))
+	if err != nil {
+		t.Fatalf("read fixture: %v", err)
+	}
+	var entries []models.BlackboxEntry
+	if err := json.Unmarshal(QuoteNonFiniteNumbers(data), &entries); err != nil {
+		t.Fatalf("decode fixture: %v", err)
+	}
+	return entries
+}
+
+func TestQuoteNonFiniteNumbers(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{`{"lat":6.5}`, `{"lat":6.5}`},
+		{`{"lat":NaN,"lng":Infinity}`, `{"lat":"NaN","lng":"Infinity"}`},
+		{`[-Infinity,+Infinity]`, `["-Infinity","Infinity"]`},
+		{`{"hash":"NaN Infinity"}`, `{"hash":"NaN Infinity"}`},
+		{`{"hash":"a\"NaN","lat":NaN}`, `{"hash":"a\"NaN","lat":"NaN"}`},
+	}
+	for _, tt := range tests {
+		if got := string(QuoteNonFiniteNumbers([]byte(tt.in))); got != tt.want {
+			t.Errorf("QuoteNonFiniteNumbers(%s) = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestScreenBlackboxEntries(t *testing.T) {
+	beyond := "sensor_data.accel_y is beyond what a phone can measure"
+	tests := []struct {
+		fixture  string
+		accepted int
+		rejected map[int][]string // reasons by upload index
+	}{
+		{"nan_accel_burst.json", 45, map[int][]string{
+			5: {"sensor_data.accel_x could not be decoded", "sensor_data.accel_z could not be decoded", beyond},
+			6: {"sensor_data.accel_x could not be decoded", "sensor_data.accel_z could not be decoded", "sensor_data.gyro_x could not be decoded", beyond},
+			7: {beyond},
+		}},
+		{"out_of_order.json", 7, map[int][]string{
+			3: {"timestamp is before the previous data point's"},
+			5: {"timestamp could not be decoded"},
+			7: {"timestamp is outside start_ts to end_ts"},
+		}},
+		{"corrupt.json", 0, map[int][]string{
+			0: {"lat could not be decoded", "lng could not be decoded"},
+			1: {"is not an object"},
+			2: {"timestamp could not be decoded"},
+			3: {"lng is out of range", "accuracy_m is negative"},
+			4: {"sensor_data.accel_x could not be decoded", "timestamp is missing"},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			entries := uploadFixture(t, tt.fixture)
+			accepted, quarantined := ScreenBlackboxEntries(entries, uploadStart, uploadEnd)
+			if len(accepted) != tt.accepted || len(accepted)+len(quarantined) != len(entries) {
+				t.Fatalf("%d accepted, %d quarantined of %d; want %d accepted", len(accepted), len(quarantined), len(entries), tt.accepted)
+			}
+			got := map[int][]string{}
+			for _, q := range quarantined {
+				got[q.Index] = q.Reasons
+				if !reflect.DeepEqual(q.Malformed, entries[q.Index].Malformed) {
+					t.Errorf("entry %d: malformed %v, want %v", q.Index, q.Malformed, entries[q.Index].Malformed)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.rejected) {
+				t.Errorf("rejected = %q\nwant       %q", got, tt.rejected)
+			}
+			for i := 1; i < len(accepted); i++ {
+				if accepted[i].Timestamp.Before(accepted[i-1].Timestamp) {
+					t.Errorf("accepted entry %d is out of order", i)
+				}
+			}
+		})
+	}
+}
+
+// A burst of glitched readings followed by the phone lying still looks like
+// a crash; quarantined, it isn't analyzed at all
+func TestQuarantinedBurstIsNoImpact(t *testing.T) {
+	entries := uploadFixture(t, "nan_accel_burst.json")
+	if DetectImpact(entries, impactParams) == nil {
+		t.Fatal("the fixture's burst doesn't look like an impact; the test proves nothing")
+	}
+	accepted, _ := ScreenBlackboxEntries(entries, uploadStart, uploadEnd)
+	if event := DetectImpact(accepted, impactParams); event != nil {
+		t.Errorf("impact in the accepted entries: %+v", event)
+	}
+}
+
+// Quarantined entries survive storage with their reasons and malformed
+// fields, and go back among the accepted ones where they were uploaded
+func TestBlackboxQuarantineRoundTrip(t *testing.T) {
+	entries := uploadFixture(t, "out_of_order.json")
+	accepted, quarantined := ScreenBlackboxEntries(entries, uploadStart, uploadEnd)
+	userID, trailID := uuid.New(), uuid.New()
+
+	for _, store := range []ObjectStore{newMemoryObjectStore(), nil} {
+		url, err := SaveBlackboxQuarantine(context.Background(), store, userID, trailID, quarantined)
+		if err != nil {
+			t.Fatalf("SaveBlackboxQuarantine: %v", err)
+		}
+		if want := store == nil; IsInlineTrail(url) != want {
+			t.Errorf("quarantine at %.60s, want inline %v", url, want)
+		}
+		loaded, err := LoadBlackboxQuarantine(context.Background(), store, url)
+		if err != nil {
+			t.Fatalf("LoadBlackboxQuarantine: %v", err)
+		}
+		if len(loaded) != len(quarantined) {
+			t.Fatalf("loaded %d entries, want %d", len(loaded), len(quarantined))
+		}
+		for i, q := range loaded {
+			if q.Index != quarantined[i].Index || !reflect.DeepEqual(q.Reasons, quarantined[i].Reasons) ||
+				!reflect.DeepEqual(q.Entry.Malformed, entries[q.Index].Malformed) {
+				t.Errorf("loaded %+v, want %+v", q, quarantined[i])
+			}
+		}
+
+		restored := RestoreBlackboxUpload(accepted, loaded)
+		if len(restored) != len(entries) {
+			t.Fatalf("restored %d entries, want %d", len(restored), len(entries))
+		}
+		for i := range entries {
+			if !restored[i].Timestamp.Equal(entries[i].Timestamp) || restored[i].Lat != entries[i].Lat {
+				t.Errorf("restored entry %d = %+v, want %+v", i, restored[i], entries[i])
+			}
+		}
+	}
+
+	if none, err := LoadBlackboxQuarantine(context.Background(), nil, ""); err != nil || none != nil {
+		t.Errorf("trail without a quarantine = %v, %v", none, err)
+	}
+}
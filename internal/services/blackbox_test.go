@@ -0,0 +1,163 @@
+package services
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/utils"
+)
+
+// Trails go to object storage when there is one, and inline as a real
+// base64 data URI when there isn't or it can't be reached
+func TestSaveBlackboxTrail(t *testing.T) {
+	entries := []models.BlackboxEntry{
+		{Timestamp: time.Date(2026, 3, 9, 10, 0, 0, 0, time.UTC), Lat: 6.5244, Lng: 3.3792, AccuracyM: 12},
+		{Timestamp: time.Date(2026, 3, 9, 10, 0, 5, 0, time.UTC), Lat: 6.5246, Lng: 3.3795, AccuracyM: 15},
+	}
+	userID, trailID := uuid.New(), uuid.New()
+	unreachable := newMemoryObjectStore()
+	unreachable.Fail = errors.New("connection refused")
+
+	tests := []struct {
+		name       string
+		store      ObjectStore
+		wantInline bool
+	}{
+		{"object storage", newMemoryObjectStore(), false},
+		{"no object storage", nil, true},
+		{"object storage unreachable", unreachable, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fileURL, err := SaveBlackboxTrail(context.Background(), tt.store, userID, trailID, entries)
+			if err != nil {
+				t.Fatalf("SaveBlackboxTrail: %v", err)
+			}
+			if IsInlineTrail(fileURL) != tt.wantInline {
+				t.Fatalf("SaveBlackboxTrail() = %.60s, want inline %v", fileURL, tt.wantInline)
+			}
+			if tt.wantInline {
+				if _, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(fileURL, blackboxDataURIPrefix)); err != nil {
+					t.Errorf("inline payload isn't base64: %v", err)
+				}
+			} else if fileURL != BlackboxObjectKey(userID, trailID) {
+				t.Errorf("SaveBlackboxTrail() = %s, want %s", fileURL, BlackboxObjectKey(userID, trailID))
+			}
+
+			got, err := LoadBlackboxEntries(context.Background(), tt.store, fileURL)
+			if err != nil {
+				t.Fatalf("LoadBlackboxEntries: %v", err)
+			}
+			if len(got) != len(entries) || !got[1].Timestamp.Equal(entries[1].Timestamp) || got[1].Lat != entries[1].Lat {
+				t.Errorf("loaded %+v, want %+v", got, entries)
+			}
+		})
+	}
+}
+
+// chainedTrail returns n entries hashed as the apps chain them, and the
+// signature over the chain head
+func chainedTrail(userID uuid.UUID, n int, secret string) ([]models.BlackboxEntry, string) {
+	entries := make([]models.BlackboxEntry, n)
+	prev := utils.BlackboxChainGenesis
+	for i := range entries {
+		entries[i] = models.BlackboxEntry{
+			Timestamp: time.Date(2026, 3, 9, 10, 0, i, 0, time.UTC),
+			Lat:       6.5244 + float64(i)/1e4,
+			Lng:       3.3792,
+			AccuracyM: 12,
+		}
+		entries[i].Hash = utils.BlackboxEntryHash(prev, &entries[i])
+		prev = entries[i].Hash
+	}
+	return entries, utils.SignString(utils.BlackboxChainSigningString(userID, prev), secret)
+}
+
+func TestVerifyBlackboxChain(t *testing.T) {
+	userID := uuid.New()
+	secrets := []string{"current-secret", "previous-secret"}
+
+	tests := []struct {
+		name      string
+		trail     func() ([]models.BlackboxEntry, string)
+		wantState string
+		wantBreak int // -1 for none
+	}{
+		{"intact", func() ([]models.BlackboxEntry, string) {
+			return chainedTrail(userID, 5, "current-secret")
+		}, models.TrailVerified, -1},
+		{"signed with a rotated-out secret still accepted", func() ([]models.BlackboxEntry, string) {
+			return chainedTrail(userID, 5, "previous-secret")
+		}, models.TrailVerified, -1},
+		{"uppercase hashes", func() ([]models.BlackboxEntry, string) {
+			entries, sig := chainedTrail(userID, 5, "current-secret")
+			for i := range entries {
+				entries[i].Hash = strings.ToUpper(entries[i].Hash)
+			}
+			return entries, sig
+		}, models.TrailVerified, -1},
+		{"no hashes", func() ([]models.BlackboxEntry, string) {
+			entries, _ := chainedTrail(userID, 5, "current-secret")
+			for i := range entries {
+				entries[i].Hash = ""
+			}
+			return entries, ""
+		}, models.TrailUnverified, -1},
+		{"entry edited", func() ([]models.BlackboxEntry, string) {
+			entries, sig := chainedTrail(userID, 5, "current-secret")
+			entries[3].Lat = 6.6
+			return entries, sig
+		}, models.TrailBroken, 3},
+		{"entry removed", func() ([]models.BlackboxEntry, string) {
+			entries, sig := chainedTrail(userID, 5, "current-secret")
+			return append(entries[:2], entries[3:]...), sig
+		}, models.TrailBroken, 2},
+		{"entries swapped", func() ([]models.BlackboxEntry, string) {
+			entries, sig := chainedTrail(userID, 5, "current-secret")
+			entries[1], entries[2] = entries[2], entries[1]
+			return entries, sig
+		}, models.TrailBroken, 1},
+		{"hash missing", func() ([]models.BlackboxEntry, string) {
+			entries, sig := chainedTrail(userID, 5, "current-secret")
+			entries[4].Hash = ""
+			return entries, sig
+		}, models.TrailBroken, 4},
+		{"malformed entry carries its own hash", func() ([]models.BlackboxEntry, string) {
+			entries, sig := chainedTrail(userID, 5, "current-secret")
+			entries[2].Lat, entries[2].Malformed = 0, []string{"lat"}
+			return entries, sig
+		}, models.TrailVerified, -1},
+		{"another user's signature", func() ([]models.BlackboxEntry, string) {
+			return chainedTrail(uuid.New(), 5, "current-secret")
+		}, models.TrailBadSignature, -1},
+		{"unknown secret", func() ([]models.BlackboxEntry, string) {
+			return chainedTrail(userID, 5, "attacker-secret")
+		}, models.TrailBadSignature, -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, sig := tt.trail()
+			got := VerifyBlackboxChain(userID, entries, sig, secrets)
+			if got.Status != tt.wantState {
+				t.Fatalf("status = %s, want %s", got.Status, tt.wantState)
+			}
+			if tt.wantBreak < 0 {
+				if got.BreakIndex != nil {
+					t.Errorf("break at %d, want none", *got.BreakIndex)
+				}
+			} else if got.BreakIndex == nil || *got.BreakIndex != tt.wantBreak {
+				t.Errorf("break at %v, want %d", got.BreakIndex, tt.wantBreak)
+			}
+			if intact := got.Status == models.TrailVerified || got.Status == models.TrailBadSignature; intact != (got.Head != "") {
+				t.Errorf("head = %q for a %s trail", got.Head, got.Status)
+			}
+		})
+	}
+}
@@ -0,0 +1,228 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/config"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/database"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+)
+
+const (
+	ChannelPush = "push"
+	ChannelSMS  = "sms"
+)
+
+// BroadcastService resolves geofenced audiences and drains broadcast
+// deliveries at a throttled rate so a single advisory cannot flood Twilio
+type BroadcastService struct {
+	cfg      *config.Store
+	postgres *database.PostgresDB
+	alerter  Notifier
+
+	mu      sync.Mutex
+	cancels map[uuid.UUID]context.CancelFunc
+}
+
+func NewBroadcastService(
+	cfg *config.Store,
+	postgres *database.PostgresDB,
+	alerter Notifier,
+) *BroadcastService {
+	return &BroadcastService{
+		cfg:      cfg,
+		postgres: postgres,
+		alerter:  alerter,
+		cancels:  make(map[uuid.UUID]context.CancelFunc),
+	}
+}
+
+// ResolveAudience returns users whose latest heartbeat within the active window is inside the geofence
+// and who consented to community broadcasts
+func (bs *BroadcastService) ResolveAudience(ctx context.Context, fence models.Geofence) ([]models.UserPosition, error) {
+	minLat, maxLat, minLng, maxLng := GeofenceBounds(fence)
+	since := time.Now().Add(-time.Duration(bs.cfg.Current().BroadcastActiveWindowMinutes) * time.Minute)
+
+	candidates, err := bs.postgres.GetLatestPositionsInBounds(ctx, minLat, maxLat, minLng, maxLng, since)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query positions: %w", err)
+	}
+
+	audience := make([]models.UserPosition, 0, len(candidates))
+	for _, p := range candidates {
+		if GeofenceContains(fence, p.Lat, p.Lng) {
+			audience = append(audience, p)
+		}
+	}
+
+	// Only users who consented to community broadcasts under the current
+	// policy are reached, once a policy version is configured for them
+	version := bs.cfg.Current().ConsentPolicyVersions[models.ConsentCommunityBroadcast]
+	if version == 0 || len(audience) == 0 {
+		return audience, nil
+	}
+	userIDs := make([]uuid.UUID, len(audience))
+	for i, p := range audience {
+		userIDs[i] = p.UserID
+	}
+	consented, err := bs.postgres.FilterConsented(ctx, userIDs, models.ConsentCommunityBroadcast, version)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check consents: %w", err)
+	}
+	return slices.DeleteFunc(audience, func(p models.UserPosition) bool {
+		return !consented[p.UserID]
+	}), nil
+}
+
+// Create records a broadcast, queues one delivery per user per channel and starts sending
+func (bs *BroadcastService) Create(
+	ctx context.Context,
+	createdBy, message string,
+	channels []string,
+	fence models.Geofence,
+	audience []models.UserPosition,
+) (*models.Broadcast, error) {
+	now := time.Now()
+	broadcast := &models.Broadcast{
+		ID:           uuid.New(),
+		CreatedBy:    createdBy,
+		Message:      message,
+		Channels:     channels,
+		Geofence:     fence,
+		AudienceSize: len(audience),
+		Status:       models.BroadcastStatusSending,
+		CreatedAt:    now,
+	}
+
+	deliveries := make([]models.BroadcastDelivery, 0, len(audience)*len(channels))
+	for _, p := range audience {
+		for _, channel := range channels {
+			deliveries = append(deliveries, models.BroadcastDelivery{
+				ID:          uuid.New(),
+				BroadcastID: broadcast.ID,
+				UserID:      p.UserID,
+				Channel:     channel,
+				Status:      "queued",
+				CreatedAt:   now,
+			})
+		}
+	}
+
+	if err := bs.postgres.CreateBroadcast(ctx, broadcast, deliveries); err != nil {
+		return nil, fmt.Errorf("failed to create broadcast: %w", err)
+	}
+
+	bs.start(broadcast.ID, message)
+	return broadcast, nil
+}
+
+// Abort stops sending and marks undelivered messages as aborted
+func (bs *BroadcastService) Abort(ctx context.Context, id uuid.UUID) (int64, error) {
+	bs.mu.Lock()
+	if cancel, ok := bs.cancels[id]; ok {
+		cancel()
+	}
+	bs.mu.Unlock()
+
+	if _, err := bs.postgres.FinishBroadcast(ctx, id, models.BroadcastStatusAborted); err != nil {
+		return 0, err
+	}
+	return bs.postgres.AbortBroadcastDeliveries(ctx, id)
+}
+
+// ResumePending restarts delivery for broadcasts interrupted by a restart
+func (bs *BroadcastService) ResumePending(ctx context.Context) error {
+	ids, err := bs.postgres.GetBroadcastIDsByStatus(ctx, models.BroadcastStatusSending)
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		broadcast, err := bs.postgres.GetBroadcast(ctx, id)
+		if err != nil || broadcast == nil {
+			continue
+		}
+		log.Printf("INFO: Resuming broadcast %s", id)
+		bs.start(id, broadcast.Message)
+	}
+	return nil
+}
+
+func (bs *BroadcastService) start(id uuid.UUID, message string) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	bs.mu.Lock()
+	bs.cancels[id] = cancel
+	bs.mu.Unlock()
+
+	go func() {
+		defer func() {
+			bs.mu.Lock()
+			delete(bs.cancels, id)
+			bs.mu.Unlock()
+			cancel()
+		}()
+		bs.drain(ctx, id, message)
+	}()
+}
+
+// drain sends queued deliveries no faster than BroadcastRatePerSecond
+func (bs *BroadcastService) drain(ctx context.Context, id uuid.UUID, message string) {
+	rate := bs.cfg.Current().BroadcastRatePerSecond
+	if rate <= 0 {
+		rate = 1
+	}
+	ticker := time.NewTicker(time.Second / time.Duration(rate))
+	defer ticker.Stop()
+
+	for {
+		batch, err := bs.postgres.GetQueuedBroadcastDeliveries(ctx, id, 100)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("ERROR: Failed to load deliveries for broadcast %s: %v", id, err)
+			}
+			return
+		}
+
+		if len(batch) == 0 {
+			if _, err := bs.postgres.FinishBroadcast(context.Background(), id, models.BroadcastStatusCompleted); err != nil {
+				log.Printf("ERROR: Failed to complete broadcast %s: %v", id, err)
+			}
+			return
+		}
+
+		for _, d := range batch {
+			select {
+			case <-ctx.Done():
+				return // aborted
+			case <-ticker.C:
+			}
+
+			status, errMsg := models.DeliveryStatusSent, ""
+			if err := bs.send(ctx, d, message); err != nil {
+				status, errMsg = models.DeliveryStatusFailed, err.Error()
+			}
+			if err := bs.postgres.CompleteBroadcastDelivery(context.Background(), d.ID, status, errMsg); err != nil {
+				log.Printf("ERROR: Failed to update broadcast delivery %s: %v", d.ID, err)
+			}
+		}
+	}
+}
+
+func (bs *BroadcastService) send(ctx context.Context, d models.BroadcastDelivery, message string) error {
+	switch d.Channel {
+	case ChannelSMS:
+		return bs.alerter.SendSMS(ctx, MessageBroadcast, d.Phone, message)
+	case ChannelPush:
+		if d.FCMToken == "" {
+			return fmt.Errorf("no push token registered")
+		}
+		return bs.alerter.SendPushNotification(ctx, d.FCMToken, "SafeTrace Advisory", message)
+	}
+	return fmt.Errorf("unknown channel %q", d.Channel)
+}
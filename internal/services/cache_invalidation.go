@@ -0,0 +1,98 @@
+package services
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/cache"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/database"
+)
+
+const (
+	cacheInvalidationWorker = "cache_invalidations"
+	// cacheInvalidationIdle is the longest the listener waits for a
+	// notification before checking in with the health registry
+	cacheInvalidationIdle = 30 * time.Second
+	// Reconnection backoff after the listening connection fails
+	cacheInvalidationMinBackoff = time.Second
+	cacheInvalidationMaxBackoff = 30 * time.Second
+)
+
+// CacheInvalidations keeps this instance's in-process caches in step with
+// the others. It listens for the invalidations Postgres triggers publish on
+// every change to users, contacts and broadcasts, and evicts the entries
+// they name. Whenever it (re)starts listening every cache is flushed, since
+// changes made while it wasn't may have been missed.
+type CacheInvalidations struct {
+	postgres *database.PostgresDB
+	caches   *cache.Registry
+	health   *HealthRegistry
+
+	closeOnce sync.Once
+	ctx       context.Context
+	cancel    context.CancelFunc
+	done      chan struct{}
+}
+
+func NewCacheInvalidations(postgres *database.PostgresDB, health *HealthRegistry) *CacheInvalidations {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &CacheInvalidations{
+		postgres: postgres,
+		caches:   postgres.Caches(),
+		health:   health,
+		ctx:      ctx,
+		cancel:   cancel,
+		done:     make(chan struct{}),
+	}
+}
+
+// Start launches the listening goroutine
+func (c *CacheInvalidations) Start() {
+	c.health.Register(cacheInvalidationWorker, cacheInvalidationIdle)
+	go c.run()
+}
+
+// Close stops listening and waits for the goroutine to finish
+func (c *CacheInvalidations) Close() {
+	c.closeOnce.Do(c.cancel)
+	<-c.done
+}
+
+func (c *CacheInvalidations) run() {
+	defer close(c.done)
+
+	backoff := cacheInvalidationMinBackoff
+	for {
+		listening := false
+		err := c.postgres.ListenInvalidations(c.ctx, cacheInvalidationIdle, func() {
+			listening = true
+			backoff = cacheInvalidationMinBackoff
+			c.caches.Flush()
+			c.health.Beat(cacheInvalidationWorker)
+		}, func(e *cache.Event) {
+			if e != nil {
+				c.caches.Invalidate(*e)
+			}
+			c.health.Beat(cacheInvalidationWorker)
+		})
+		if c.ctx.Err() != nil {
+			return
+		}
+		if listening {
+			log.Printf("WARN: Lost cache invalidation connection, reconnecting: %v", err)
+		} else {
+			log.Printf("ERROR: Failed to listen for cache invalidations, retrying in %s: %v", backoff, err)
+		}
+
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > cacheInvalidationMaxBackoff {
+			backoff = cacheInvalidationMaxBackoff
+		}
+	}
+}
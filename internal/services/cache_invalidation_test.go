@@ -0,0 +1,151 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/cache"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/database"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+)
+
+// propagationBound is how soon another instance must see a change
+const propagationBound = 2 * time.Second
+
+// kindProbe is an event kind only these tests publish
+const kindProbe cache.Kind = "test_probe"
+
+// cacheInstance is one API instance's database handle, its caches kept in
+// step by its own listener
+type cacheInstance struct {
+	postgres      *database.PostgresDB
+	invalidations *CacheInvalidations
+	probes        chan string
+}
+
+func newCacheInstance(t *testing.T) *cacheInstance {
+	t.Helper()
+	i := &cacheInstance{postgres: testPostgres(t), probes: make(chan string, 16)}
+	i.postgres.Caches().Register(kindProbe, cache.Funcs{EvictFunc: func(key string) {
+		select {
+		case i.probes <- key:
+		default: // another instance's probe, nobody waiting for it
+		}
+	}})
+	i.listen(t)
+	return i
+}
+
+// listen starts the instance's listener and waits until it receives
+func (i *cacheInstance) listen(t *testing.T) {
+	t.Helper()
+	i.invalidations = NewCacheInvalidations(i.postgres, NewHealthRegistry())
+	i.invalidations.Start()
+	t.Cleanup(i.invalidations.Close)
+
+	probe := uuid.NewString()
+	for deadline := time.Now().Add(10 * time.Second); ; {
+		if err := i.postgres.PublishInvalidation(context.Background(), cache.Event{Kind: kindProbe, Key: probe}); err != nil {
+			t.Fatalf("PublishInvalidation: %v", err)
+		}
+		select {
+		case key := <-i.probes:
+			if key == probe {
+				return
+			}
+		case <-time.After(50 * time.Millisecond):
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("listener never started receiving")
+		}
+	}
+}
+
+// eventually polls the instance's view of the user until ok holds
+func (i *cacheInstance) eventually(t *testing.T, what string, userID uuid.UUID, ok func(*models.User) bool) {
+	t.Helper()
+	start := time.Now()
+	for {
+		user, err := i.postgres.GetUserByID(context.Background(), userID)
+		if err != nil {
+			t.Fatalf("GetUserByID: %v", err)
+		}
+		if ok(user) {
+			return
+		}
+		if time.Since(start) > propagationBound {
+			t.Fatalf("%s not seen within %s", what, propagationBound)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func safeZoneCount(n int) func(*models.User) bool {
+	return func(u *models.User) bool { return len(u.Settings.SafeZones) == n }
+}
+
+// Two instances sharing a database: B, holding a user in its cache, sees
+// A's changes to them within the propagation bound
+func TestCacheInvalidationAcrossInstances(t *testing.T) {
+	a, b := newCacheInstance(t), newCacheInstance(t)
+	ctx := context.Background()
+	user := createTestUser(t, a.postgres, "Ada")
+
+	b.eventually(t, "the new user", user.ID, safeZoneCount(0)) // now cached on B
+
+	zone := models.Geofence{Type: "radius", Center: &models.GeoPoint{Lat: 6.5244, Lng: 3.3792}, RadiusM: 200}
+	if err := a.postgres.UpdateUserSettings(ctx, user.ID, models.UserSettings{SafeZones: []models.Geofence{zone}}); err != nil {
+		t.Fatalf("UpdateUserSettings: %v", err)
+	}
+	b.eventually(t, "A's new safe zone", user.ID, safeZoneCount(1))
+
+	contact := models.Contact{ID: uuid.NewString(), Name: "Emeka", Phone: testPhone(), Status: models.ContactStatusInvited}
+	if err := a.postgres.AddContact(ctx, user.ID, contact, 5); err != nil {
+		t.Fatalf("AddContact: %v", err)
+	}
+	b.eventually(t, "A's new contact", user.ID, func(u *models.User) bool {
+		return len(u.TrustedContacts) == 1 && u.TrustedContacts[0].Phone == contact.Phone
+	})
+
+	// Events published outside the database reach B's caches too
+	templates := make(chan struct{}, 1)
+	b.postgres.Caches().Register(cache.KindTemplate, cache.Funcs{FlushFunc: func() {
+		select {
+		case templates <- struct{}{}:
+		default:
+		}
+	}})
+	if err := a.postgres.PublishInvalidation(ctx, cache.Event{Kind: cache.KindTemplate}); err != nil {
+		t.Fatalf("PublishInvalidation: %v", err)
+	}
+	select {
+	case <-templates:
+	case <-time.After(propagationBound):
+		t.Fatalf("template change not seen within %s", propagationBound)
+	}
+}
+
+// Changes made while an instance wasn't listening are missed, so it flushes
+// every cache once it listens again
+func TestCacheInvalidationFlushesOnReconnect(t *testing.T) {
+	a, b := newCacheInstance(t), newCacheInstance(t)
+	ctx := context.Background()
+	user := createTestUser(t, a.postgres, "Ada")
+	b.eventually(t, "the new user", user.ID, safeZoneCount(0))
+
+	b.invalidations.Close()
+	zone := models.Geofence{Type: "radius", Center: &models.GeoPoint{Lat: 6.5244, Lng: 3.3792}, RadiusM: 200}
+	if err := a.postgres.UpdateUserSettings(ctx, user.ID, models.UserSettings{SafeZones: []models.Geofence{zone}}); err != nil {
+		t.Fatalf("UpdateUserSettings: %v", err)
+	}
+	time.Sleep(200 * time.Millisecond) // the notification, had anyone listened
+	if stale, err := b.postgres.GetUserByID(ctx, user.ID); err != nil || len(stale.Settings.SafeZones) != 0 {
+		t.Fatalf("B without a listener = %+v, %v; want its cached copy", stale, err)
+	}
+
+	b.listen(t)
+	b.eventually(t, "the change made while B wasn't listening", user.ID, safeZoneCount(1))
+}
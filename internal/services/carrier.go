@@ -0,0 +1,40 @@
+package services
+
+import (
+	"github.com/adedejiosvaldo/safetrace/backend/internal/country"
+)
+
+// Carrier identifies a mobile network operator, e.g. "MTN". Names are those
+// of the country profiles, so an operator present in several countries has
+// one name across them.
+type Carrier string
+
+const CarrierUnknown Carrier = "UNKNOWN"
+
+// NetworkCarrier returns the carrier of a mobile network by its MCC and MNC,
+// for networks of the countries the deployment serves
+func NetworkCarrier(mcc, mnc int) Carrier {
+	p := country.Active().ForMCC(mcc)
+	if p == nil {
+		return CarrierUnknown
+	}
+	return carrierOrUnknown(p.NetworkCarrier(mnc))
+}
+
+// DetectCarrier returns the network a phone number belongs to based on its
+// prefix, for numbers of the countries the deployment serves
+func DetectCarrier(phone string) Carrier {
+	countries := country.Active()
+	p := countries.Of(phone)
+	if p == nil {
+		return CarrierUnknown
+	}
+	return carrierOrUnknown(p.Carrier(countries.NormalizePhone(phone)))
+}
+
+func carrierOrUnknown(name string) Carrier {
+	if name == "" {
+		return CarrierUnknown
+	}
+	return Carrier(name)
+}
@@ -0,0 +1,93 @@
+package services
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+)
+
+// networkTypes maps what devices report as the network type, uppercased
+// with spaces, dashes, underscores and plus signs removed, to the generation
+var networkTypes = map[string]string{
+	"2G": models.NetworkType2G, "GSM": models.NetworkType2G, "GPRS": models.NetworkType2G,
+	"EDGE": models.NetworkType2G, "CDMA": models.NetworkType2G, "1XRTT": models.NetworkType2G,
+	"IDEN": models.NetworkType2G,
+
+	"3G": models.NetworkType3G, "UMTS": models.NetworkType3G, "WCDMA": models.NetworkType3G,
+	"HSPA": models.NetworkType3G, "HSPAP": models.NetworkType3G, "HSDPA": models.NetworkType3G,
+	"HSUPA": models.NetworkType3G, "TDSCDMA": models.NetworkType3G, "EVDO0": models.NetworkType3G,
+	"EVDOA": models.NetworkType3G, "EVDOB": models.NetworkType3G, "EHRPD": models.NetworkType3G,
+
+	"4G": models.NetworkType4G, "LTE": models.NetworkType4G, "LTEA": models.NetworkType4G,
+	"LTECA": models.NetworkType4G, "LTEADVANCED": models.NetworkType4G,
+
+	"5G": models.NetworkType5G, "NR": models.NetworkType5G, "5GNR": models.NetworkType5G,
+	"NRNSA": models.NetworkType5G, "NRSA": models.NetworkType5G, "5GNSA": models.NetworkType5G,
+	"5GSA": models.NetworkType5G,
+}
+
+// NormalizeNetworkType maps a reported network type to one of
+// models.NetworkType*, "unknown" for anything unrecognized or empty
+func NormalizeNetworkType(reported string) string {
+	key := strings.Map(func(r rune) rune {
+		switch r {
+		case ' ', '-', '_', '+':
+			return -1
+		}
+		return r
+	}, strings.ToUpper(reported))
+	if generation, ok := networkTypes[key]; ok {
+		return generation
+	}
+	return models.NetworkTypeUnknown
+}
+
+// NormalizeCellInfo returns the cell info with a normalized network type and
+// Android's "unavailable" placeholders (the largest 32- or 64-bit value) and
+// negative identifiers zeroed, so they read as unknown. It must not be applied
+// before a signature over the cell info is verified.
+func NormalizeCellInfo(c models.CellInfo) models.CellInfo {
+	c.NetworkType = NormalizeNetworkType(c.NetworkType)
+	c.MCC = int(knownIdentifier(models.CellIdentifier(c.MCC)))
+	c.MNC = int(knownIdentifier(models.CellIdentifier(c.MNC)))
+	c.CID = knownIdentifier(c.CID)
+	c.LAC = knownIdentifier(c.LAC)
+	if c.Neighbors != nil {
+		neighbors := make([]models.NeighborCell, len(c.Neighbors))
+		for i, n := range c.Neighbors {
+			n.CID = knownIdentifier(n.CID)
+			neighbors[i] = n
+		}
+		c.Neighbors = neighbors
+	}
+	return c
+}
+
+func knownIdentifier(id models.CellIdentifier) models.CellIdentifier {
+	if id < 0 || id == math.MaxInt32 || id == math.MaxInt64 {
+		return 0
+	}
+	return id
+}
+
+// ValidateCellInfo rejects country and network codes that can't be real once
+// normalized. Any country is accepted, not only those served, so roamers'
+// heartbeats aren't lost; 0 means unknown.
+func ValidateCellInfo(c models.CellInfo) error {
+	if c.MCC > 999 {
+		return fmt.Errorf("mcc must be a mobile country code of at most 3 digits")
+	}
+	if c.MNC > 999 {
+		return fmt.Errorf("mnc must be a mobile network code of at most 3 digits")
+	}
+	return nil
+}
+
+// SameCell reports whether two readings are of the same known cell. Cell IDs
+// are only unique within a network and area, and an unknown cell is never
+// the same as another.
+func SameCell(a, b models.CellInfo) bool {
+	return a.CID != 0 && a.CID == b.CID && a.LAC == b.LAC && a.MCC == b.MCC && a.MNC == b.MNC
+}
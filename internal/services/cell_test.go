@@ -0,0 +1,143 @@
+package services
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+)
+
+// Cell info as real devices send it, 2G through 5G: none may be rejected,
+// and each normalizes to the generation and identifiers it carries
+func TestCellInfoPayloads(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload string
+		want    models.CellInfo
+	}{
+		{"2G GSM", `{"mcc":621,"mnc":20,"cid":31212,"lac":1102,"rssi":-89,"network_type":"GSM"}`,
+			models.CellInfo{MCC: 621, MNC: 20, CID: 31212, LAC: 1102, RSSI: -89, NetworkType: models.NetworkType2G}},
+		{"2G EDGE, string codes", `{"mcc":"621","mnc":"030","cid":"4417","lac":"8301","rssi":-97,"network_type":"EDGE"}`,
+			models.CellInfo{MCC: 621, MNC: 30, CID: 4417, LAC: 8301, RSSI: -97, NetworkType: models.NetworkType2G}},
+		{"3G HSPA+", `{"mcc":621,"mnc":30,"cid":47326042,"lac":40102,"rssi":-81,"network_type":"HSPA+"}`,
+			models.CellInfo{MCC: 621, MNC: 30, CID: 47326042, LAC: 40102, RSSI: -81, NetworkType: models.NetworkType3G}},
+		{"3G UMTS, lowercase", `{"mcc":621,"mnc":50,"cid":12043,"lac":512,"rssi":-90,"network_type":"umts"}`,
+			models.CellInfo{MCC: 621, MNC: 50, CID: 12043, LAC: 512, RSSI: -90, NetworkType: models.NetworkType3G}},
+		{"4G LTE carrier aggregation", `{"mcc":621,"mnc":20,"cid":139812623,"lac":4101,"rssi":-104,"network_type":"LTE_CA"}`,
+			models.CellInfo{MCC: 621, MNC: 20, CID: 139812623, LAC: 4101, RSSI: -104, NetworkType: models.NetworkType4G}},
+		{"4G, TAC unavailable", `{"mcc":621,"mnc":60,"cid":20515841,"lac":2147483647,"rssi":-99,"network_type":"LTE"}`,
+			models.CellInfo{MCC: 621, MNC: 60, CID: 20515841, LAC: 0, RSSI: -99, NetworkType: models.NetworkType4G}},
+		{"5G NSA, 36-bit NCI as a string", `{"mcc":"621","mnc":"20","cid":"45812345601","lac":"300101","rssi":-95,"network_type":"NR_NSA"}`,
+			models.CellInfo{MCC: 621, MNC: 20, CID: 45812345601, LAC: 300101, RSSI: -95, NetworkType: models.NetworkType5G}},
+		{"5G SA, NCI as a float", `{"mcc":621,"mnc":30,"cid":4.5812345601e+10,"lac":300102,"rssi":-92,"network_type":"5G SA"}`,
+			models.CellInfo{MCC: 621, MNC: 30, CID: 45812345601, LAC: 300102, RSSI: -92, NetworkType: models.NetworkType5G}},
+		{"5G, NCI unavailable", `{"mcc":621,"mnc":20,"cid":9223372036854775807,"lac":null,"rssi":-110,"network_type":"NR"}`,
+			models.CellInfo{MCC: 621, MNC: 20, CID: 0, LAC: 0, RSSI: -110, NetworkType: models.NetworkType5G}},
+		{"neighbors", `{"mcc":621,"mnc":20,"cid":31212,"lac":1102,"rssi":-89,"network_type":"GSM","neighbors":[{"cid":"31213","rssi":-95},{"cid":-1,"rssi":-101}]}`,
+			models.CellInfo{MCC: 621, MNC: 20, CID: 31212, LAC: 1102, RSSI: -89, NetworkType: models.NetworkType2G,
+				Neighbors: []models.NeighborCell{{CID: 31213, RSSI: -95}, {CID: 0, RSSI: -101}}}},
+		{"roaming in Ghana", `{"mcc":620,"mnc":1,"cid":33012,"lac":2201,"rssi":-85,"network_type":"LTE"}`,
+			models.CellInfo{MCC: 620, MNC: 1, CID: 33012, LAC: 2201, RSSI: -85, NetworkType: models.NetworkType4G}},
+		{"no cell, as iOS sends it", `{"network_type":""}`,
+			models.CellInfo{NetworkType: models.NetworkTypeUnknown}},
+		{"Wi-Fi calling", `{"mcc":621,"mnc":20,"cid":"","lac":"","rssi":0,"network_type":"IWLAN"}`,
+			models.CellInfo{MCC: 621, MNC: 20, NetworkType: models.NetworkTypeUnknown}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var cell models.CellInfo
+			if err := json.Unmarshal([]byte(tt.payload), &cell); err != nil {
+				t.Fatalf("Unmarshal: %v", err)
+			}
+			cell = NormalizeCellInfo(cell)
+			if err := ValidateCellInfo(cell); err != nil {
+				t.Fatalf("ValidateCellInfo: %v", err)
+			}
+			got, _ := json.Marshal(cell)
+			want, _ := json.Marshal(tt.want)
+			if string(got) != string(want) {
+				t.Errorf("cell =\n%s\nwant\n%s", got, want)
+			}
+		})
+	}
+}
+
+// Identifiers that aren't whole numbers fail the heartbeat rather than
+// being guessed at, as do codes that can't be real
+func TestCellInfoInvalid(t *testing.T) {
+	for _, payload := range []string{
+		`{"cid":1.5}`,
+		`{"cid":"abc"}`,
+		`{"lac":true}`,
+		`{"cid":1e300}`,
+		`{"mcc":"six"}`,
+	} {
+		var cell models.CellInfo
+		if err := json.Unmarshal([]byte(payload), &cell); err == nil {
+			t.Errorf("Unmarshal(%s) = %+v, want an error", payload, cell)
+		}
+	}
+	for _, cell := range []models.CellInfo{{MCC: 6210}, {MCC: 621, MNC: 1000}} {
+		if err := ValidateCellInfo(NormalizeCellInfo(cell)); err == nil {
+			t.Errorf("ValidateCellInfo(%+v) = nil, want an error", cell)
+		}
+	}
+}
+
+// SMS heartbeats carry the same cells, the network type optional
+func TestParseHeartbeatSMSCell(t *testing.T) {
+	prefix := "uid=" + uuid.NewString() + ";ts=2025-11-19T12:50:00Z;lat=6.5244;lng=3.3792;acc=200;"
+	tests := []struct {
+		name string
+		cell string
+		want models.CellInfo
+	}{
+		{"2G, no network type", "cell=621,20,31212,1102,-89",
+			models.CellInfo{MCC: 621, MNC: 20, CID: 31212, LAC: 1102, RSSI: -89, NetworkType: models.NetworkTypeUnknown}},
+		{"3G", "cell=621,30,47326042,40102,-81,WCDMA",
+			models.CellInfo{MCC: 621, MNC: 30, CID: 47326042, LAC: 40102, RSSI: -81, NetworkType: models.NetworkType3G}},
+		{"4G", "cell=621,20,139812623,4101,-104,LTE",
+			models.CellInfo{MCC: 621, MNC: 20, CID: 139812623, LAC: 4101, RSSI: -104, NetworkType: models.NetworkType4G}},
+		{"5G", "cell=621,20,45812345601,300101,-95,NR",
+			models.CellInfo{MCC: 621, MNC: 20, CID: 45812345601, LAC: 300101, RSSI: -95, NetworkType: models.NetworkType5G}},
+	}
+	parser := NewSMSParser()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hb, err := parser.ParseHeartbeatSMS(prefix + tt.cell + ";sig=abc123")
+			if err != nil {
+				t.Fatalf("ParseHeartbeatSMS: %v", err)
+			}
+			got, _ := json.Marshal(hb.CellInfo)
+			want, _ := json.Marshal(tt.want)
+			if string(got) != string(want) {
+				t.Errorf("cell =\n%s\nwant\n%s", got, want)
+			}
+		})
+	}
+}
+
+func TestSameCell(t *testing.T) {
+	cell := models.CellInfo{MCC: 621, MNC: 20, CID: 45812345601, LAC: 300101}
+	tests := []struct {
+		name  string
+		other models.CellInfo
+		want  bool
+	}{
+		{"same", cell, true},
+		{"same but for signal and type", models.CellInfo{MCC: 621, MNC: 20, CID: 45812345601, LAC: 300101, RSSI: -70, NetworkType: "5G"}, true},
+		{"above 32 bits apart", models.CellInfo{MCC: 621, MNC: 20, CID: 45812345601 + 1<<32, LAC: 300101}, false},
+		{"another area", models.CellInfo{MCC: 621, MNC: 20, CID: 45812345601, LAC: 300102}, false},
+		{"another network", models.CellInfo{MCC: 621, MNC: 30, CID: 45812345601, LAC: 300101}, false},
+	}
+	for _, tt := range tests {
+		if got := SameCell(cell, tt.other); got != tt.want {
+			t.Errorf("%s: SameCell = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+	if SameCell(models.CellInfo{}, models.CellInfo{}) {
+		t.Error("two unknown cells are the same")
+	}
+}
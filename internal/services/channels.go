@@ -0,0 +1,441 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/config"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/database"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/pluscode"
+)
+
+// Events sent to notification channels
+const (
+	ChannelEventAlert     = "alert"
+	ChannelEventEscalated = "escalated" // an open alert was raised to a more severe state
+	ChannelEventResolved  = "resolved"
+	ChannelEventTest      = "test"
+)
+
+const (
+	// channelAttempts is how many times a transient failure is tried in all
+	channelAttempts = 3
+	channelBackoff  = 2 * time.Second
+	channelTimeout  = 10 * time.Second
+)
+
+// ChannelMessage is what a channel is told about an alert. Generic webhooks
+// receive it as JSON; Slack and Teams get it formatted as a card.
+type ChannelMessage struct {
+	Event    string          `json:"event"`
+	AlertID  string          `json:"alert_id,omitempty"`
+	UserID   string          `json:"user_id"`
+	UserName string          `json:"user_name"`
+	State    string          `json:"state,omitempty"`
+	Score    int             `json:"score"`
+	Reason   string          `json:"reason,omitempty"`
+	Reasons  []models.Reason `json:"reasons,omitempty"`
+	LastSeen string          `json:"last_seen,omitempty"`
+	MapLink  string          `json:"map_link,omitempty"`
+	PlusCode string          `json:"plus_code,omitempty"`
+	AckURL   string          `json:"ack_url,omitempty"` // acknowledges the alert on behalf of the channel
+	SentAt   time.Time       `json:"sent_at"`
+}
+
+// ChannelError is a failed delivery. Rejected means the endpoint answered
+// with a 4xx other than 429, which retrying won't fix.
+type ChannelError struct {
+	Status   int
+	Rejected bool
+	Err      error
+}
+
+func (e *ChannelError) Error() string {
+	if e.Status != 0 {
+		return fmt.Sprintf("webhook answered %d: %v", e.Status, e.Err)
+	}
+	return fmt.Sprintf("webhook unreachable: %v", e.Err)
+}
+
+func (e *ChannelError) Unwrap() error {
+	return e.Err
+}
+
+// webhookTransport posts a payload to a channel's webhook and returns the status
+type webhookTransport interface {
+	PostWebhook(ctx context.Context, channel *models.NotificationChannel, payload []byte) (int, string, error)
+}
+
+// ChannelNotifier delivers alerts to a user's Slack, Teams and generic
+// webhook channels. Deliveries run on the alert outbox workers; transient
+// failures are retried with backoff, and a channel whose endpoint keeps
+// rejecting requests is disabled.
+type ChannelNotifier struct {
+	cfg       *config.Store
+	postgres  *database.PostgresDB
+	transport webhookTransport
+}
+
+// NewChannelNotifier posts to the real webhooks, or records them on dev when it is non-nil
+func NewChannelNotifier(cfg *config.Store, postgres *database.PostgresDB, dev *DevNotifier) *ChannelNotifier {
+	n := &ChannelNotifier{cfg: cfg, postgres: postgres}
+	if dev != nil {
+		n.transport = dev
+	} else {
+		n.transport = httpWebhooks{client: &http.Client{Timeout: channelTimeout}}
+	}
+	return n
+}
+
+// NotifyAlert sends a new or escalated alert to every enabled channel. Each
+// channel gets its own acknowledgment link.
+func (n *ChannelNotifier) NotifyAlert(ctx context.Context, event string, user *models.User, alert *models.Alert, hb *models.Heartbeat) {
+	channels := n.enabledChannels(ctx, user.ID)
+	for i := range channels {
+		ch := &channels[i]
+		msg := n.alertMessage(event, user, alert, hb)
+		msg.AckURL = n.ackURL(ctx, alert, ch)
+		n.deliver(ctx, ch, msg)
+	}
+}
+
+// NotifyResolved tells every enabled channel the alert was resolved
+func (n *ChannelNotifier) NotifyResolved(ctx context.Context, alert *models.Alert) {
+	channels := n.enabledChannels(ctx, alert.UserID)
+	if len(channels) == 0 {
+		return
+	}
+	user, err := n.postgres.GetUserByID(ctx, alert.UserID)
+	if err != nil || user == nil {
+		log.Printf("ERROR: Failed to load user %s for channel resolution of alert %s: %v", alert.UserID, alert.ID, err)
+		return
+	}
+
+	msg := n.alertMessage(ChannelEventResolved, user, alert, nil)
+	for i := range channels {
+		n.deliver(ctx, &channels[i], msg)
+	}
+}
+
+// SendTest sends a test message once, without retries, and records the
+// outcome like any other delivery
+func (n *ChannelNotifier) SendTest(ctx context.Context, user *models.User, ch *models.NotificationChannel) error {
+	msg := ChannelMessage{
+		Event:    ChannelEventTest,
+		UserID:   user.ID.String(),
+		UserName: user.Name,
+		Reason:   "This is a test. No action is needed.",
+		SentAt:   time.Now(),
+	}
+	err := n.post(ctx, ch, msg)
+	n.record(ctx, ch, err)
+	return err
+}
+
+func (n *ChannelNotifier) enabledChannels(ctx context.Context, userID uuid.UUID) []models.NotificationChannel {
+	channels, err := n.postgres.GetNotificationChannels(ctx, userID, true)
+	if err != nil {
+		log.Printf("ERROR: Failed to load notification channels for user %s: %v", userID, err)
+		return nil
+	}
+	return channels
+}
+
+func (n *ChannelNotifier) alertMessage(event string, user *models.User, alert *models.Alert, hb *models.Heartbeat) ChannelMessage {
+	msg := ChannelMessage{
+		Event:    event,
+		AlertID:  alert.ID.String(),
+		UserID:   user.ID.String(),
+		UserName: user.Name,
+		State:    string(alert.State),
+		Score:    alert.Score,
+		Reason:   AlertReasonText(alert),
+		Reasons:  alert.Reasons,
+		SentAt:   time.Now(),
+	}
+	if hb != nil {
+		msg.LastSeen = FormatInUserZone(hb.Timestamp, user.Settings, LayoutDateTime)
+		msg.MapLink = fmt.Sprintf("https://www.google.com/maps?q=%.6f,%.6f", hb.Lat, hb.Lng)
+		msg.PlusCode = pluscode.Encode(hb.Lat, hb.Lng, pluscode.DefaultLength)
+	}
+	return msg
+}
+
+// ackURL registers the channel as an alert recipient and returns its
+// acknowledgment link, or "" without a public base URL
+func (n *ChannelNotifier) ackURL(ctx context.Context, alert *models.Alert, ch *models.NotificationChannel) string {
+	base := n.cfg.Current().PublicBaseURL
+	if base == "" {
+		return ""
+	}
+	token, err := generateAckToken()
+	if err != nil {
+		log.Printf("ERROR: Failed to generate ack token for alert %s: %v", alert.ID, err)
+		return ""
+	}
+	recipient := &models.AlertRecipient{
+		ID:          uuid.New(),
+		AlertID:     alert.ID,
+		ContactID:   "channel:" + ch.ID.String(),
+		ContactName: ch.Name,
+		Channel:     ch.Type,
+		AckToken:    token,
+		CreatedAt:   time.Now(),
+	}
+	if err := n.postgres.CreateAlertRecipient(ctx, recipient); err != nil {
+		log.Printf("ERROR: Failed to record channel %s as recipient of alert %s: %v", ch.ID, alert.ID, err)
+		return ""
+	}
+	return strings.TrimRight(base, "/") + "/ack/" + token
+}
+
+// deliver posts the message, retrying transient failures, and records the outcome
+func (n *ChannelNotifier) deliver(ctx context.Context, ch *models.NotificationChannel, msg ChannelMessage) {
+	err := retryTransient(ctx, func() error { return n.post(ctx, ch, msg) })
+	if err != nil {
+		log.Printf("ERROR: %s delivery to channel %s (%s) failed: %v", msg.Event, ch.ID, ch.Type, err)
+	}
+	n.record(ctx, ch, err)
+}
+
+// PostHandoff posts an alert's handoff to an emergency response agency's
+// webhook, retrying transient failures as channel deliveries are, and
+// returns the start of the agency's response
+func (n *ChannelNotifier) PostHandoff(ctx context.Context, name, webhookURL string, payload []byte) (string, error) {
+	ch := &models.NotificationChannel{Type: models.ChannelTypeWebhook, Name: name, WebhookURL: webhookURL}
+	var body string
+	err := retryTransient(ctx, func() error {
+		var err error
+		body, err = n.postPayload(ctx, ch, payload)
+		return err
+	})
+	return body, err
+}
+
+// retryTransient calls post up to channelAttempts times with backoff, until
+// it succeeds or fails with a rejection
+func retryTransient(ctx context.Context, post func() error) error {
+	var err error
+	for attempt := 1; attempt <= channelAttempts; attempt++ {
+		if err = post(); err == nil {
+			break
+		}
+		var chErr *ChannelError
+		if errors.As(err, &chErr) && chErr.Rejected || attempt == channelAttempts {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			attempt = channelAttempts
+		case <-time.After(channelBackoff * time.Duration(attempt)):
+		}
+	}
+	return err
+}
+
+func (n *ChannelNotifier) post(ctx context.Context, ch *models.NotificationChannel, msg ChannelMessage) error {
+	payload, err := ChannelPayload(ch.Type, msg)
+	if err != nil {
+		return err
+	}
+	_, err = n.postPayload(ctx, ch, payload)
+	return err
+}
+
+// postPayload posts to the channel's webhook and returns the response body
+// of a 2xx answer
+func (n *ChannelNotifier) postPayload(ctx context.Context, ch *models.NotificationChannel, payload []byte) (string, error) {
+	status, body, err := n.transport.PostWebhook(ctx, ch, payload)
+	if err != nil {
+		return "", &ChannelError{Err: err}
+	}
+	if status >= 200 && status < 300 {
+		return body, nil
+	}
+	return "", &ChannelError{
+		Status:   status,
+		Rejected: status >= 400 && status < 500 && status != http.StatusTooManyRequests,
+		Err:      errors.New(strings.TrimSpace(body)),
+	}
+}
+
+// record stores a delivery's outcome on the channel; failures are only logged
+func (n *ChannelNotifier) record(ctx context.Context, ch *models.NotificationChannel, deliveryErr error) {
+	// The delivery may have used up ctx; the outcome should still be stored
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+	defer cancel()
+
+	if deliveryErr == nil {
+		if err := n.postgres.RecordChannelDelivery(ctx, ch.ID); err != nil {
+			log.Printf("ERROR: Failed to record delivery to channel %s: %v", ch.ID, err)
+		}
+		return
+	}
+
+	var chErr *ChannelError
+	rejected := errors.As(deliveryErr, &chErr) && chErr.Rejected
+	disabled, err := n.postgres.RecordChannelFailure(ctx, ch.ID, deliveryErr.Error(), rejected, n.cfg.Current().ChannelDisableAfterFailures)
+	if err != nil {
+		log.Printf("ERROR: Failed to record failure of channel %s: %v", ch.ID, err)
+		return
+	}
+	if disabled {
+		log.Printf("WARN: Notification channel %s (%s) for user %s disabled after repeated rejections", ch.ID, ch.Type, ch.UserID)
+	}
+}
+
+// httpWebhooks posts to the channel's webhook URL
+type httpWebhooks struct {
+	client *http.Client
+}
+
+func (w httpWebhooks) PostWebhook(ctx context.Context, ch *models.NotificationChannel, payload []byte) (int, string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, ch.WebhookURL, bytes.NewReader(payload))
+	if err != nil {
+		return 0, "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "SafeTrace-Webhook/1")
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	return resp.StatusCode, string(body), nil
+}
+
+// ChannelPayload formats a message for a channel type: a Block Kit message
+// for Slack, an Adaptive Card for Teams and the message itself otherwise
+func ChannelPayload(channelType string, msg ChannelMessage) ([]byte, error) {
+	switch channelType {
+	case models.ChannelTypeSlack:
+		return json.Marshal(slackPayload(msg))
+	case models.ChannelTypeTeams:
+		return json.Marshal(teamsPayload(msg))
+	case models.ChannelTypeWebhook:
+		return json.Marshal(msg)
+	}
+	return nil, fmt.Errorf("unknown channel type %q", channelType)
+}
+
+// channelTitle is the headline of a message
+func channelTitle(msg ChannelMessage) string {
+	switch msg.Event {
+	case ChannelEventAlert:
+		return fmt.Sprintf("🚨 SafeTrace alert: %s may be in danger", msg.UserName)
+	case ChannelEventEscalated:
+		return fmt.Sprintf("🚨 SafeTrace alert escalated to %s: %s", msg.State, msg.UserName)
+	case ChannelEventResolved:
+		return fmt.Sprintf("✅ SafeTrace: %s's alert is resolved", msg.UserName)
+	default:
+		return "SafeTrace test message"
+	}
+}
+
+// channelFacts are the labelled details shown under the title
+func channelFacts(msg ChannelMessage) [][2]string {
+	var facts [][2]string
+	add := func(label, value string) {
+		if value != "" {
+			facts = append(facts, [2]string{label, value})
+		}
+	}
+	if msg.Event != ChannelEventTest && msg.Event != ChannelEventResolved {
+		add("State", msg.State)
+		add("Safety score", fmt.Sprintf("%d/100", msg.Score))
+	}
+	add("Reason", msg.Reason)
+	add("Last seen", msg.LastSeen)
+	add("Plus code", msg.PlusCode)
+	return facts
+}
+
+// channelLinks are the buttons under a message
+func channelLinks(msg ChannelMessage) [][2]string {
+	var links [][2]string
+	if msg.AckURL != "" {
+		links = append(links, [2]string{"Acknowledge", msg.AckURL})
+	}
+	if msg.MapLink != "" {
+		links = append(links, [2]string{"Open map", msg.MapLink})
+	}
+	return links
+}
+
+func slackPayload(msg ChannelMessage) map[string]interface{} {
+	title := channelTitle(msg)
+	blocks := []map[string]interface{}{
+		{"type": "header", "text": map[string]interface{}{"type": "plain_text", "text": title}},
+	}
+
+	var fields []map[string]interface{}
+	for _, f := range channelFacts(msg) {
+		fields = append(fields, map[string]interface{}{"type": "mrkdwn", "text": "*" + f[0] + "*\n" + f[1]})
+	}
+	if len(fields) > 0 {
+		blocks = append(blocks, map[string]interface{}{"type": "section", "fields": fields})
+	}
+
+	var buttons []map[string]interface{}
+	for i, l := range channelLinks(msg) {
+		button := map[string]interface{}{
+			"type": "button",
+			"text": map[string]interface{}{"type": "plain_text", "text": l[0]},
+			"url":  l[1],
+		}
+		if i == 0 && msg.AckURL != "" {
+			button["style"] = "primary"
+		}
+		buttons = append(buttons, button)
+	}
+	if len(buttons) > 0 {
+		blocks = append(blocks, map[string]interface{}{"type": "actions", "elements": buttons})
+	}
+
+	// text is the notification and fallback for clients that don't render blocks
+	return map[string]interface{}{"text": title, "blocks": blocks}
+}
+
+func teamsPayload(msg ChannelMessage) map[string]interface{} {
+	var facts []map[string]interface{}
+	for _, f := range channelFacts(msg) {
+		facts = append(facts, map[string]interface{}{"title": f[0], "value": f[1]})
+	}
+	body := []map[string]interface{}{
+		{"type": "TextBlock", "size": "Large", "weight": "Bolder", "wrap": true, "text": channelTitle(msg)},
+	}
+	if len(facts) > 0 {
+		body = append(body, map[string]interface{}{"type": "FactSet", "facts": facts})
+	}
+
+	actions := []map[string]interface{}{}
+	for _, l := range channelLinks(msg) {
+		actions = append(actions, map[string]interface{}{"type": "Action.OpenUrl", "title": l[0], "url": l[1]})
+	}
+
+	return map[string]interface{}{
+		"type": "message",
+		"attachments": []map[string]interface{}{{
+			"contentType": "application/vnd.microsoft.card.adaptive",
+			"content": map[string]interface{}{
+				"$schema": "http://adaptivecards.io/schemas/adaptive-card.json",
+				"type":    "AdaptiveCard",
+				"version": "1.4",
+				"body":    body,
+				"actions": actions,
+			},
+		}},
+	}
+}
@@ -0,0 +1,98 @@
+package services
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/config"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+)
+
+// withConfig returns a copy of cfg changed by change, as a reload would
+// swap in
+func withConfig(cfg *config.Config, change func(*config.Config)) *config.Config {
+	next := *cfg
+	change(&next)
+	return &next
+}
+
+// A threshold flipped between two evaluations is used by the second
+func TestThresholdReload(t *testing.T) {
+	store := config.NewStore(simulationConfig())
+	clock := NewFakeClock(simulationStart.Add(time.Minute))
+	evaluator := &SafetyEvaluator{cfg: store, clock: clock, effects: discardEffects{}}
+	sim := NewSimulator(store, nil)
+	hb := simulatedHeartbeat(0, false)
+
+	if r := evaluator.Assess(&hb, nil, sim.DefaultProfile()); r.State != StateSafe || r.Score != 88 {
+		t.Fatalf("before the reload = %s %d, want SAFE 88", r.State, r.Score)
+	}
+	store.Swap(withConfig(store.Current(), func(c *config.Config) { c.ScoreSafeThreshold = 90 }))
+	if r := evaluator.Assess(&hb, nil, sim.DefaultProfile()); r.State != StateCaution || r.Score != 88 {
+		t.Errorf("after raising the safe threshold to 90 = %s %d, want CAUTION 88", r.State, r.Score)
+	}
+
+	// The LastGasp escalation is read on each evaluation too
+	lastGasp := &models.LastGasp{LastAt: clock.Now(), ExpiryTs: clock.Now().Add(time.Hour)}
+	clock.Advance(30 * time.Minute)
+	if r := evaluator.Assess(&hb, lastGasp, sim.DefaultProfile()); r.State != StateWaitLastGasp {
+		t.Errorf("30 min into a LastGasp = %s, want WAIT_LASTGASP", r.State)
+	}
+	store.Swap(withConfig(store.Current(), func(c *config.Config) { c.LastGaspEscalateSeconds = 20 * 60 }))
+	if r := evaluator.Assess(&hb, lastGasp, sim.DefaultProfile()); r.State != StateAtRisk || r.RulesFired[0] != RuleLastGaspProlonged {
+		t.Errorf("after lowering the escalation to 20 min = %s %v, want AT_RISK prolonged", r.State, r.RulesFired)
+	}
+
+	// A simulation takes the thresholds current when it starts
+	steps, err := sim.Run([]models.Heartbeat{hb}, sim.DefaultProfile(), 0, time.Time{})
+	if err != nil || steps[0].State != StateCaution {
+		t.Errorf("simulation after the reload = %+v, %v, want CAUTION", steps, err)
+	}
+}
+
+// Evaluations running while the configuration is swapped each see one
+// snapshot or the other, never a mix
+func TestThresholdReloadConcurrent(t *testing.T) {
+	low := simulationConfig()
+	high := withConfig(low, func(c *config.Config) { c.ScoreSafeThreshold = 90 })
+	store := config.NewStore(low)
+	evaluator := &SafetyEvaluator{cfg: store, clock: NewFakeClock(simulationStart.Add(time.Minute)), effects: discardEffects{}}
+	sim := NewSimulator(store, nil)
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			hb := simulatedHeartbeat(0, false)
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				profile := sim.DefaultProfile()
+				r := evaluator.Assess(&hb, nil, profile)
+				want := StateSafe
+				if profile.SafeThreshold == 90 {
+					want = StateCaution
+				}
+				if r.State != want {
+					t.Errorf("threshold %d gave %s, want %s", profile.SafeThreshold, r.State, want)
+					return
+				}
+			}
+		}()
+	}
+	for i := 0; i < 200; i++ {
+		if i%2 == 0 {
+			store.Swap(high)
+		} else {
+			store.Swap(low)
+		}
+	}
+	close(stop)
+	wg.Wait()
+}
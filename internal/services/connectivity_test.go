@@ -0,0 +1,111 @@
+package services
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/config"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+)
+
+// A heartbeat queued offline earns no recency points however fresh it is,
+// while every other connectivity is scored on its age alone
+func TestOfflineQueuedRecency(t *testing.T) {
+	cfg := simulationConfig()
+	profile := DefaultScoringProfile(cfg)
+
+	for _, age := range []time.Duration{0, time.Minute, 5 * time.Minute} {
+		hb := simulatedHeartbeat(0, false)
+		se := &SafetyEvaluator{cfg: config.NewStore(cfg), clock: NewFakeClock(hb.Timestamp.Add(age)), effects: discardEffects{}}
+
+		online := se.Assess(&hb, nil, profile)
+		if online.Breakdown["recency"] == 0 {
+			t.Fatalf("age %s: online heartbeat earned no recency", age)
+		}
+		for _, connectivity := range []string{models.ConnectivityWiFi, models.ConnectivityCellular} {
+			hb.Connectivity = connectivity
+			if got := se.Assess(&hb, nil, profile); got.Score != online.Score {
+				t.Errorf("age %s, %s: score = %d, want %d as without connectivity", age, connectivity, got.Score, online.Score)
+			}
+		}
+
+		hb.Connectivity = models.ConnectivityOfflineQueued
+		queued := se.Assess(&hb, nil, profile)
+		if queued.Breakdown["recency"] != 0 {
+			t.Errorf("age %s: queued recency = %d, want 0", age, queued.Breakdown["recency"])
+		}
+		if want := online.Score - online.Breakdown["recency"]; queued.Score != want {
+			t.Errorf("age %s: queued score = %d, want %d", age, queued.Score, want)
+		}
+		for component, points := range online.Breakdown {
+			if component != "recency" && queued.Breakdown[component] != points {
+				t.Errorf("age %s: queued %s = %d, want %d", age, component, queued.Breakdown[component], points)
+			}
+		}
+		if !hasReason(queued, models.ReasonOfflineQueued) {
+			t.Errorf("age %s: queued reasons = %v, want %s", age, queued.Reasons, models.ReasonOfflineQueued)
+		}
+		if hasReason(online, models.ReasonOfflineQueued) {
+			t.Errorf("age %s: online reasons = %v name %s", age, online.Reasons, models.ReasonOfflineQueued)
+		}
+	}
+}
+
+// Being queued doesn't keep a heartbeat out of the staleness rules: once
+// it is older than the window it is stale like any other
+func TestOfflineQueuedStale(t *testing.T) {
+	cfg := simulationConfig()
+	hb := simulatedHeartbeat(0, false)
+	hb.Connectivity = models.ConnectivityOfflineQueued
+	se := &SafetyEvaluator{cfg: config.NewStore(cfg), clock: NewFakeClock(hb.Timestamp.Add(11 * time.Minute)), effects: discardEffects{}}
+
+	result := se.Assess(&hb, nil, DefaultScoringProfile(cfg))
+	if result.State != StateAtRisk || !slices.Contains(result.RulesFired, RuleHeartbeatStale) {
+		t.Errorf("stale queued heartbeat = %s %v, want AT_RISK from %s", result.State, result.RulesFired, RuleHeartbeatStale)
+	}
+}
+
+func TestDeadZoneTrend(t *testing.T) {
+	// heartbeats builds a newest-first history from connectivity and signal pairs
+	heartbeats := func(steps ...any) []models.Heartbeat {
+		var out []models.Heartbeat
+		for i := 0; i < len(steps); i += 2 {
+			hb := simulatedHeartbeat(-time.Duration(i)*time.Minute, false)
+			hb.Connectivity = steps[i].(string)
+			hb.CellInfo.RSSI = steps[i+1].(int)
+			out = append(out, hb)
+		}
+		return out
+	}
+	const cell, wifi, queued = models.ConnectivityCellular, models.ConnectivityWiFi, models.ConnectivityOfflineQueued
+
+	tests := []struct {
+		name     string
+		history  []models.Heartbeat
+		ok       bool
+		from, to int
+	}{
+		{"falling", heartbeats(cell, -95, cell, -90, cell, -85, cell, -80), true, -80, -95},
+		{"falling over three", heartbeats(cell, -95, cell, -90, cell, -85), true, -85, -95},
+		{"flat steps allowed", heartbeats(cell, -95, cell, -95, cell, -85), true, -85, -95},
+		{"only the latest four", heartbeats(cell, -95, cell, -90, cell, -85, cell, -80, wifi, -50), true, -80, -95},
+		{"too small a drop", heartbeats(cell, -89, cell, -85, cell, -80), false, 0, 0},
+		{"too few", heartbeats(cell, -100, cell, -80), false, 0, 0},
+		{"recovering in between", heartbeats(cell, -95, cell, -100, cell, -85), false, 0, 0},
+		{"wifi among them", heartbeats(cell, -95, wifi, -90, cell, -85), false, 0, 0},
+		{"queued among them", heartbeats(cell, -95, queued, -90, cell, -85), false, 0, 0},
+		{"unknown connectivity", heartbeats("", -95, "", -90, "", -85), false, 0, 0},
+		{"no signal passed over", heartbeats(cell, -95, cell, 0, cell, -90, cell, -80), true, -80, -95},
+		{"empty", nil, false, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			from, to, ok := DeadZoneTrend(tt.history)
+			if ok != tt.ok || from != tt.from || to != tt.to {
+				t.Errorf("DeadZoneTrend = %d, %d, %t; want %d, %d, %t", from, to, ok, tt.from, tt.to, tt.ok)
+			}
+		})
+	}
+
+}
@@ -0,0 +1,225 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/config"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/database"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+)
+
+// consentSweepTimeout bounds one pass over consents outdated by a policy change
+const consentSweepTimeout = 5 * time.Minute
+
+var (
+	// ErrConsentPolicyOutdated is returned for a grant under a policy version
+	// other than the current one of its scope
+	ErrConsentPolicyOutdated = errors.New("policy version is not the current one")
+	// ErrConsentNotRequired is returned for a grant to a scope no policy
+	// version is configured for
+	ErrConsentNotRequired = errors.New("scope needs no consent")
+)
+
+// ConsentRequiredError is returned by ConsentService.Require when the user
+// hasn't consented to scope under its current policy version
+type ConsentRequiredError struct {
+	Scope         string
+	PolicyVersion int
+}
+
+func (e *ConsentRequiredError) Error() string {
+	return fmt.Sprintf("%s consent to policy version %d is required", e.Scope, e.PolicyVersion)
+}
+
+// ConsentService records users' consent to each scope of the privacy policy
+// and is checked by the features that need it before they act:
+//   - tracking: starting background tracking remotely
+//   - audio: turning on the share_audio setting
+//   - community_broadcast: being in a broadcast's audience
+//   - responder_sharing: having alerts found by responder queries
+//   - precision_escalation: turning on the coarse_location setting
+//
+// CONSENT_POLICY_VERSIONS has each scope's current policy version; a scope
+// left out needs no consent. When a version is raised, consents under older
+// ones are closed, the features they allowed turned off and their users
+// asked to consent again.
+type ConsentService struct {
+	cfg      *config.Store
+	postgres *database.PostgresDB
+	notifier Notifier
+	outbox   *AlertOutbox
+	audit    *AuditLogger
+}
+
+func NewConsentService(cfg *config.Store, postgres *database.PostgresDB, notifier Notifier, outbox *AlertOutbox, audit *AuditLogger) *ConsentService {
+	return &ConsentService{
+		cfg:      cfg,
+		postgres: postgres,
+		notifier: notifier,
+		outbox:   outbox,
+		audit:    audit,
+	}
+}
+
+// Start closes consents outdated by the configured policy versions, now and
+// after every config reload that may have raised one
+func (s *ConsentService) Start() {
+	go s.Sweep()
+	s.cfg.OnChange(func(*config.Config) {
+		go s.Sweep()
+	})
+}
+
+// Policies returns the current policy version of every scope that needs consent
+func (s *ConsentService) Policies() map[string]int {
+	return s.cfg.Current().ConsentPolicyVersions
+}
+
+// Require returns a *ConsentRequiredError unless the user has consented to
+// scope under its current policy version, or the scope needs no consent
+func (s *ConsentService) Require(ctx context.Context, userID uuid.UUID, scope string) error {
+	version := s.Policies()[scope]
+	if version == 0 {
+		return nil
+	}
+	ok, err := s.postgres.HasConsent(ctx, userID, scope, version)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return &ConsentRequiredError{Scope: scope, PolicyVersion: version}
+	}
+	return nil
+}
+
+// Grant records c, which must be under its scope's current policy version,
+// and returns the stored consent
+func (s *ConsentService) Grant(ctx context.Context, c *models.Consent) (*models.Consent, error) {
+	version := s.Policies()[c.Scope]
+	switch {
+	case version == 0:
+		return nil, ErrConsentNotRequired
+	case c.PolicyVersion != version:
+		return nil, ErrConsentPolicyOutdated
+	}
+	c.ID = uuid.New()
+	c.GrantedAt = time.Now()
+	return s.postgres.GrantConsent(ctx, c)
+}
+
+// Revoke closes the user's consent to scope and turns off the feature it
+// allowed. It returns the revoked consent, or nil if they had none.
+func (s *ConsentService) Revoke(ctx context.Context, userID uuid.UUID, scope string) (*models.Consent, error) {
+	revoked, err := s.postgres.RevokeConsent(ctx, userID, scope, time.Now())
+	if err != nil || revoked == nil {
+		return nil, err
+	}
+	s.disable(ctx, userID, []string{scope})
+	return revoked, nil
+}
+
+// Sweep closes every consent under an older policy version than its scope's
+// current one, turns off what it allowed and asks its user to consent again.
+// Instances sweeping at once each close different consents.
+func (s *ConsentService) Sweep() {
+	ctx, cancel := context.WithTimeout(context.Background(), consentSweepTimeout)
+	defer cancel()
+
+	outdated := make(map[uuid.UUID][]string)
+	var users []uuid.UUID
+	for _, scope := range models.ConsentScopes {
+		version := s.Policies()[scope]
+		if version == 0 {
+			continue
+		}
+		closed, err := s.postgres.SupersedeOutdatedConsents(ctx, scope, version, time.Now())
+		if err != nil {
+			log.Printf("ERROR: Failed to close %s consents older than policy version %d: %v", scope, version, err)
+			continue
+		}
+		for _, c := range closed {
+			if _, ok := outdated[c.UserID]; !ok {
+				users = append(users, c.UserID)
+			}
+			outdated[c.UserID] = append(outdated[c.UserID], c.Scope)
+
+			s.audit.Record(&models.AuditEvent{
+				ActorRole:     "system",
+				Action:        AuditConsentSupersede,
+				ObjectType:    "consent",
+				ObjectID:      c.ID.String(),
+				SubjectUserID: &c.UserID,
+				Metadata: map[string]interface{}{
+					"scope":          c.Scope,
+					"policy_version": c.PolicyVersion,
+					"current":        version,
+				},
+			})
+		}
+	}
+	if len(users) == 0 {
+		return
+	}
+
+	log.Printf("INFO: Asking %d users to consent again to an updated privacy policy", len(users))
+	for _, userID := range users {
+		scopes := outdated[userID]
+		s.disable(ctx, userID, scopes)
+		s.outbox.EnqueueMessage(ctx, "consent request to user "+userID.String(), func(ctx context.Context) error {
+			token, err := s.postgres.GetPushToken(ctx, userID)
+			if err != nil || token == "" {
+				return err
+			}
+			return s.notifier.SendConsentRequired(ctx, token, scopes)
+		})
+	}
+}
+
+// disable turns off what the user's consent to scopes allowed. Broadcasts
+// and responder queries check consent as they run, so need nothing here.
+// Failures are logged.
+func (s *ConsentService) disable(ctx context.Context, userID uuid.UUID, scopes []string) {
+	if slices.Contains(scopes, models.ConsentAudio) {
+		if err := s.postgres.DisableAudioSharing(ctx, userID); err != nil {
+			log.Printf("WARN: Failed to turn off audio sharing of user %s: %v", userID, err)
+		}
+	}
+	if slices.Contains(scopes, models.ConsentPrecisionEscalation) {
+		// Coarse location mode can't keep an incident precise without the
+		// consent, so it ends, and the user's locations are stored as sent
+		disabled, err := s.postgres.DisableCoarseLocation(ctx, userID)
+		if err != nil {
+			log.Printf("WARN: Failed to turn off coarse location mode of user %s: %v", userID, err)
+		} else if disabled {
+			s.audit.Record(&models.AuditEvent{
+				ActorRole:     "system",
+				Action:        AuditSettingsUpdate,
+				ObjectType:    "user",
+				ObjectID:      userID.String(),
+				SubjectUserID: &userID,
+				Metadata: map[string]interface{}{
+					"changes": map[string]interface{}{
+						"coarse_location": map[string]interface{}{"from": true, "to": false},
+					},
+					"cause": "consent_withdrawn",
+				},
+			})
+		}
+	}
+	if slices.Contains(scopes, models.ConsentTracking) {
+		s.outbox.EnqueueMessage(ctx, "tracking stop to user "+userID.String(), func(ctx context.Context) error {
+			token, err := s.postgres.GetPushToken(ctx, userID)
+			if err != nil || token == "" {
+				return err
+			}
+			return s.notifier.SendTrackingCommand(ctx, token, TrackingStop)
+		})
+	}
+}
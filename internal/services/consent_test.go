@@ -0,0 +1,279 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/config"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/database"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+)
+
+// consentNotifier records the tracking commands and consent requests sent,
+// by push token: a sweep reaches every user in the database
+type consentNotifier struct {
+	Notifier // nil: anything else panics
+
+	mu       sync.Mutex
+	commands map[string][]string
+	requests map[string][][]string // scopes of each consent request
+}
+
+func (n *consentNotifier) SendTrackingCommand(ctx context.Context, token, action string) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.commands[token] = append(n.commands[token], action)
+	return nil
+}
+
+func (n *consentNotifier) SendConsentRequired(ctx context.Context, token string, scopes []string) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.requests[token] = append(n.requests[token], scopes)
+	return nil
+}
+
+type consentFixture struct {
+	cfg      *config.Store
+	postgres *database.PostgresDB
+	notifier *consentNotifier
+	outbox   *AlertOutbox
+	service  *ConsentService
+}
+
+func newConsentFixture(t *testing.T, versions map[string]int) *consentFixture {
+	t.Helper()
+	f := &consentFixture{
+		cfg:      config.NewStore(&config.Config{ConsentPolicyVersions: versions, BroadcastActiveWindowMinutes: 60}),
+		postgres: testPostgres(t),
+		notifier: &consentNotifier{commands: map[string][]string{}, requests: map[string][][]string{}},
+	}
+	f.outbox = NewAlertOutbox(f.notifier, nil, 1, nil) // never started: messages wait in the queue
+	f.service = NewConsentService(f.cfg, f.postgres, f.notifier, f.outbox, nil)
+	return f
+}
+
+// bump raises the configured policy versions, as a config reload would
+func (f *consentFixture) bump(versions map[string]int) {
+	cfg := *f.cfg.Current()
+	cfg.ConsentPolicyVersions = versions
+	f.cfg.Swap(&cfg)
+}
+
+// send runs the queued messages
+func (f *consentFixture) send(t *testing.T) {
+	t.Helper()
+	for len(f.outbox.queue) > 0 {
+		if err := (<-f.outbox.queue).message(context.Background()); err != nil {
+			t.Fatalf("queued message: %v", err)
+		}
+	}
+}
+
+func (f *consentFixture) grant(t *testing.T, userID uuid.UUID, scope string, version int) {
+	t.Helper()
+	if _, err := f.service.Grant(context.Background(), &models.Consent{UserID: userID, Scope: scope, PolicyVersion: version}); err != nil {
+		t.Fatalf("Grant %s v%d: %v", scope, version, err)
+	}
+}
+
+func requiresConsent(err error, scope string, version int) bool {
+	var required *ConsentRequiredError
+	return errors.As(err, &required) && required.Scope == scope && required.PolicyVersion == version
+}
+
+func TestConsentRequireAndGrant(t *testing.T) {
+	f := newConsentFixture(t, map[string]int{models.ConsentTracking: 2, models.ConsentAudio: 1})
+	ctx := context.Background()
+	user := createTestUser(t, f.postgres, "Ada")
+
+	if err := f.service.Require(ctx, user.ID, models.ConsentResponderSharing); err != nil {
+		t.Errorf("scope without a policy version: %v", err)
+	}
+	if err := f.service.Require(ctx, user.ID, models.ConsentTracking); !requiresConsent(err, models.ConsentTracking, 2) {
+		t.Errorf("tracking without consent: %v", err)
+	}
+
+	if _, err := f.service.Grant(ctx, &models.Consent{UserID: user.ID, Scope: models.ConsentTracking, PolicyVersion: 1}); !errors.Is(err, ErrConsentPolicyOutdated) {
+		t.Errorf("grant to an old version: %v", err)
+	}
+	if _, err := f.service.Grant(ctx, &models.Consent{UserID: user.ID, Scope: models.ConsentResponderSharing, PolicyVersion: 1}); !errors.Is(err, ErrConsentNotRequired) {
+		t.Errorf("grant to a scope needing none: %v", err)
+	}
+	f.grant(t, user.ID, models.ConsentTracking, 2)
+	f.grant(t, user.ID, models.ConsentTracking, 2) // kept as is
+	if err := f.service.Require(ctx, user.ID, models.ConsentTracking); err != nil {
+		t.Errorf("tracking after consent: %v", err)
+	}
+	if history, err := f.postgres.GetConsentHistory(ctx, user.ID, 10, 0); err != nil || len(history) != 1 {
+		t.Errorf("history = %+v, %v; want the one consent", history, err)
+	}
+
+	// Withdrawing audio consent turns audio sharing off
+	f.grant(t, user.ID, models.ConsentAudio, 1)
+	if err := f.postgres.UpdateUserSettings(ctx, user.ID, models.UserSettings{ShareAudio: true}); err != nil {
+		t.Fatalf("UpdateUserSettings: %v", err)
+	}
+	revoked, err := f.service.Revoke(ctx, user.ID, models.ConsentAudio)
+	if err != nil || revoked == nil || revoked.RevokedAt == nil {
+		t.Fatalf("Revoke = %+v, %v", revoked, err)
+	}
+	if err := f.service.Require(ctx, user.ID, models.ConsentAudio); !requiresConsent(err, models.ConsentAudio, 1) {
+		t.Errorf("audio after revoking: %v", err)
+	}
+	if current, err := f.postgres.GetUserByID(ctx, user.ID); err != nil || current.Settings.ShareAudio {
+		t.Errorf("share_audio after revoking = %v, %v; want off", current.Settings.ShareAudio, err)
+	}
+	if again, err := f.service.Revoke(ctx, user.ID, models.ConsentAudio); err != nil || again != nil {
+		t.Errorf("revoking again = %+v, %v; want nothing", again, err)
+	}
+}
+
+// A raised policy version supersedes older consents: what they allowed is
+// turned off and the user is asked, once, to consent again
+func TestConsentReconsent(t *testing.T) {
+	f := newConsentFixture(t, map[string]int{models.ConsentTracking: 1, models.ConsentAudio: 1, models.ConsentPrecisionEscalation: 1})
+	ctx := context.Background()
+	user := createTestUser(t, f.postgres, "Ada")
+	token := "token-" + user.ID.String()
+	if err := f.postgres.UpsertPushToken(ctx, user.ID, token); err != nil {
+		t.Fatalf("UpsertPushToken: %v", err)
+	}
+	for _, scope := range []string{models.ConsentTracking, models.ConsentAudio, models.ConsentPrecisionEscalation} {
+		f.grant(t, user.ID, scope, 1)
+	}
+	if err := f.postgres.UpdateUserSettings(ctx, user.ID, models.UserSettings{ShareAudio: true, CoarseLocation: true}); err != nil {
+		t.Fatalf("UpdateUserSettings: %v", err)
+	}
+
+	f.bump(map[string]int{models.ConsentTracking: 2, models.ConsentAudio: 2, models.ConsentPrecisionEscalation: 1})
+	f.service.Sweep()
+	f.send(t)
+
+	if err := f.service.Require(ctx, user.ID, models.ConsentTracking); !requiresConsent(err, models.ConsentTracking, 2) {
+		t.Errorf("tracking after the bump: %v", err)
+	}
+	if err := f.service.Require(ctx, user.ID, models.ConsentPrecisionEscalation); err != nil {
+		t.Errorf("unchanged scope after the bump: %v", err)
+	}
+	current, err := f.postgres.GetUserByID(ctx, user.ID)
+	if err != nil || current.Settings.ShareAudio || !current.Settings.CoarseLocation {
+		t.Errorf("settings after the bump = %+v, %v; want audio off, coarse location left on", current.Settings, err)
+	}
+	if commands := f.notifier.commands[token]; !slices.Equal(commands, []string{TrackingStop}) {
+		t.Errorf("tracking commands = %v, want one stop", commands)
+	}
+	if requests := f.notifier.requests[token]; len(requests) != 1 || !slices.Equal(requests[0], []string{models.ConsentTracking, models.ConsentAudio}) {
+		t.Errorf("consent requests = %v, want one for tracking and audio", requests)
+	}
+
+	open, err := f.postgres.GetOpenConsents(ctx, user.ID)
+	if err != nil || len(open) != 1 || open[0].Scope != models.ConsentPrecisionEscalation {
+		t.Errorf("open consents = %+v, %v; want precision_escalation only", open, err)
+	}
+	history, err := f.postgres.GetConsentHistory(ctx, user.ID, 10, 0)
+	if err != nil || len(history) != 3 {
+		t.Fatalf("history = %+v, %v", history, err)
+	}
+	for _, c := range history {
+		if superseded := c.SupersededAt != nil; superseded != (c.Scope != models.ConsentPrecisionEscalation) {
+			t.Errorf("%s consent superseded at %v", c.Scope, c.SupersededAt)
+		}
+	}
+
+	// Sweeping again, as another instance would, asks nobody twice
+	f.service.Sweep()
+	f.send(t)
+	if commands, requests := f.notifier.commands[token], f.notifier.requests[token]; len(commands) != 1 || len(requests) != 1 {
+		t.Errorf("after a second sweep: %d commands, %d requests", len(commands), len(requests))
+	}
+
+	// An instance still on the old version supersedes nothing newer
+	f.grant(t, user.ID, models.ConsentTracking, 2)
+	f.bump(map[string]int{models.ConsentTracking: 1})
+	f.service.Sweep()
+	if ok, err := f.postgres.HasConsent(ctx, user.ID, models.ConsentTracking, 2); err != nil || !ok {
+		t.Errorf("consent to version 2 after a sweep on version 1 = %v, %v", ok, err)
+	}
+}
+
+// Broadcasts and responder queries leave out users without consent, once a
+// policy version is configured for them
+func TestConsentGatesAudiences(t *testing.T) {
+	f := newConsentFixture(t, nil)
+	ctx := context.Background()
+	fence := models.Geofence{Type: "radius", Center: &models.GeoPoint{Lat: 6.5244, Lng: 3.3792}, RadiusM: 1000}
+	consenting, declining := createTestUser(t, f.postgres, "Ada"), createTestUser(t, f.postgres, "Bola")
+	alerts := map[uuid.UUID]uuid.UUID{}
+	for _, user := range []*models.User{consenting, declining} {
+		hb := simulatedHeartbeat(0, false)
+		hb.UserID = user.ID
+		if err := f.postgres.CreateHeartbeat(ctx, &hb); err != nil {
+			t.Fatalf("CreateHeartbeat: %v", err)
+		}
+		alert := &models.Alert{ID: uuid.New(), UserID: user.ID, State: models.AlertStateAtRisk, Reasons: models.Reasons{}, SentTo: []string{}, CreatedAt: time.Now(), DetectedAt: time.Now()}
+		if err := f.postgres.CreateAlert(ctx, alert); err != nil {
+			t.Fatalf("CreateAlert: %v", err)
+		}
+		alerts[alert.ID] = user.ID
+	}
+
+	broadcasts := NewBroadcastService(f.cfg, f.postgres, nil)
+	reached := func() []uuid.UUID {
+		t.Helper()
+		audience, err := broadcasts.ResolveAudience(ctx, fence)
+		if err != nil {
+			t.Fatalf("ResolveAudience: %v", err)
+		}
+		var ours []uuid.UUID
+		for _, p := range audience {
+			if p.UserID == consenting.ID || p.UserID == declining.ID {
+				ours = append(ours, p.UserID)
+			}
+		}
+		return ours
+	}
+	found := func(version int) []uuid.UUID {
+		t.Helper()
+		candidates, err := f.postgres.FindResponderAlerts(ctx, models.ResponderAlertQuery{
+			KeyID: uuid.New(), Lat: 6.5244, Lng: 3.3792, RadiusM: 1000, Since: time.Now().Add(-time.Hour),
+			MinLat: 6.4, MaxLat: 6.6, MinLng: 3.3, MaxLng: 3.4, Limit: 1000, ConsentVersion: version,
+		})
+		if err != nil {
+			t.Fatalf("FindResponderAlerts: %v", err)
+		}
+		var ours []uuid.UUID
+		for _, a := range candidates {
+			if userID, ok := alerts[a.AlertID]; ok {
+				ours = append(ours, userID)
+			}
+		}
+		return ours
+	}
+
+	if got := reached(); len(got) != 2 {
+		t.Errorf("broadcast reached %v with no policy configured, want both users", got)
+	}
+	if got := found(0); len(got) != 2 {
+		t.Errorf("responders found %v with no policy configured, want both users", got)
+	}
+
+	f.bump(map[string]int{models.ConsentCommunityBroadcast: 1, models.ConsentResponderSharing: 1})
+	f.grant(t, consenting.ID, models.ConsentCommunityBroadcast, 1)
+	f.grant(t, consenting.ID, models.ConsentResponderSharing, 1)
+	if got := reached(); !slices.Equal(got, []uuid.UUID{consenting.ID}) {
+		t.Errorf("broadcast reached %v, want only the user who consented", got)
+	}
+	if got := found(1); !slices.Equal(got, []uuid.UUID{consenting.ID}) {
+		t.Errorf("responders found %v, want only the user who consented", got)
+	}
+	if got := found(2); len(got) != 0 {
+		t.Errorf("responders found %v under version 2, want nobody", got)
+	}
+}
@@ -0,0 +1,331 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/config"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/country"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/database"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/utils"
+)
+
+// contactInviteTTL is how long an invitation link can be confirmed
+const contactInviteTTL = 7 * 24 * time.Hour
+
+// ContactTokenScopes are the scopes every contact access token carries
+var ContactTokenScopes = []string{utils.ScopeReadStatus, utils.ScopeReadAlerts, utils.ScopeWelfareCheck}
+
+var (
+	ErrInviteInvalid   = errors.New("invitation is invalid or has expired")
+	ErrContactNotFound = errors.New("contact is no longer a trusted contact of this user")
+)
+
+// ContactToken is an issued contact access token
+type ContactToken struct {
+	AccessToken string    `json:"access_token"`
+	ExpiresAt   time.Time `json:"expires_at"`
+	UserID      uuid.UUID `json:"user_id"`
+	Scopes      []string  `json:"scopes"`
+}
+
+// ContactAccessService invites trusted contacts and issues, renews and
+// revokes their scoped access tokens
+type ContactAccessService struct {
+	cfg       *config.Store
+	postgres  *database.PostgresDB
+	redis     *database.RedisDB
+	notifier  Notifier
+	templates *MessageTemplates
+	locations *LocationEncoder
+}
+
+func NewContactAccessService(
+	cfg *config.Store,
+	postgres *database.PostgresDB,
+	redis *database.RedisDB,
+	notifier Notifier,
+	templates *MessageTemplates,
+	locations *LocationEncoder,
+) *ContactAccessService {
+	return &ContactAccessService{
+		cfg:       cfg,
+		postgres:  postgres,
+		redis:     redis,
+		notifier:  notifier,
+		templates: templates,
+		locations: locations,
+	}
+}
+
+// Invite texts the contact a link to confirm they will look out for the user
+func (s *ContactAccessService) Invite(ctx context.Context, user *models.User, contact models.Contact) error {
+	if s.cfg.Current().PublicBaseURL == "" {
+		return fmt.Errorf("PUBLIC_BASE_URL is not set, so no invitation link can be sent")
+	}
+
+	code, err := generateAckToken()
+	if err != nil {
+		return err
+	}
+
+	invite := &models.ContactInvite{
+		UserID:    user.ID,
+		ContactID: contact.ID,
+		Phone:     contact.Phone,
+		CreatedAt: time.Now(),
+	}
+	if err := s.redis.SaveContactInvite(ctx, code, invite, contactInviteTTL); err != nil {
+		return fmt.Errorf("failed to store invite: %w", err)
+	}
+
+	message := s.templates.Render(TemplateInvitation, MessageData{
+		Name:         user.Name,
+		ContactPhone: user.Phone,
+		Link:         s.link("/contact/confirm/" + code),
+	})
+	return s.notifier.SendSMS(ctx, MessageInvitation, contact.Phone, message)
+}
+
+// NotifyRemoved tells a contact's previous number that it no longer gets the
+// user's alerts, so a number swapped without the contact knowing doesn't go
+// unnoticed
+func (s *ContactAccessService) NotifyRemoved(ctx context.Context, user *models.User, previous models.Contact) error {
+	message := s.templates.Render(TemplateContactRemoved, MessageData{
+		Name:         user.Name,
+		ContactPhone: user.Phone,
+	})
+	return s.notifier.SendSMS(ctx, MessageInvitation, previous.Phone, message)
+}
+
+// Confirm accepts an invitation and issues the contact's first access token,
+// which is also texted to them as a link
+func (s *ContactAccessService) Confirm(ctx context.Context, code string) (*ContactToken, error) {
+	invite, err := s.redis.TakeContactInvite(ctx, code)
+	if err != nil {
+		return nil, err
+	}
+	if invite == nil {
+		return nil, ErrInviteInvalid
+	}
+
+	user, contact, err := s.currentContact(ctx, invite.UserID, invite.ContactID, invite.Phone)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := s.issue(ctx, user.ID, *contact)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.postgres.ConfirmContact(ctx, user.ID, contact.ID, contact.Phone); err != nil {
+		log.Printf("WARN: Failed to mark contact %s confirmed: %v", contact.ID, err)
+	}
+
+	// The token goes in the fragment so it never reaches server logs
+	message := fmt.Sprintf("SafeTrace: you can now check on %s here: %s", user.Name, s.link("/watch/"+user.ID.String()+"#token="+token.AccessToken))
+	if err := s.notifier.SendSMS(ctx, MessageInvitation, contact.Phone, message); err != nil {
+		log.Printf("WARN: Failed to text access link to contact %s: %v", contact.ID, err)
+	}
+	return token, nil
+}
+
+// Renew replaces a valid contact token with a fresh one and revokes the old one
+func (s *ContactAccessService) Renew(ctx context.Context, claims *utils.TokenClaims) (*ContactToken, error) {
+	userID, err := uuid.Parse(claims.Subject)
+	if err != nil {
+		return nil, utils.ErrInvalidToken
+	}
+
+	_, contact, err := s.currentContact(ctx, userID, claims.ContactID, claims.Phone)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := s.issue(ctx, userID, *contact)
+	if err != nil {
+		return nil, err
+	}
+	if claims.ID != "" {
+		if err := s.redis.RevokeToken(ctx, claims.ID, s.tokenTTL()); err != nil {
+			return nil, fmt.Errorf("new token issued but the old one was not revoked: %w", err)
+		}
+	}
+	return token, nil
+}
+
+// ContactPreferences is what a contact chose for themselves, across every
+// user who lists their phone number
+type ContactPreferences struct {
+	Phone                 string                   `json:"phone"`
+	MaxPerWindow          *int                     `json:"max_per_window"`           // their own pacing; null for the default
+	EffectiveMaxPerWindow int                      `json:"effective_max_per_window"` // 0 when they aren't paced
+	WindowMinutes         int                      `json:"window_minutes"`
+	SMSEnabled            bool                     `json:"sms_enabled"`
+	WhatsAppEnabled       bool                     `json:"whatsapp_enabled"`
+	ReferenceLocation     *models.ContactReference `json:"reference_location"` // null unless they registered one
+	ProtectedUsers        []models.ProtectedUser   `json:"protected_users"`
+}
+
+// Preferences returns the preferences of the contact a token was issued to,
+// as long as they are still the user's contact
+func (s *ContactAccessService) Preferences(ctx context.Context, claims *utils.TokenClaims) (*ContactPreferences, error) {
+	if err := s.checkContact(ctx, claims); err != nil {
+		return nil, err
+	}
+	recipient, err := s.postgres.GetContactRecipient(ctx, claims.Phone)
+	if err != nil {
+		return nil, err
+	}
+	if recipient == nil {
+		recipient = &models.ContactRecipient{Phone: claims.Phone, SMSEnabled: true, WhatsAppEnabled: true}
+	}
+	return s.preferences(ctx, recipient)
+}
+
+// ReferenceUpdate is a reference location as a contact gives it: either
+// coordinates, or an address to geocode
+type ReferenceUpdate struct {
+	Label   string
+	Lat     *float64
+	Lng     *float64
+	Address string
+}
+
+// UpdatePreferences replaces the preferences of the contact a token was
+// issued to. They apply to messages about every user the contact protects. A
+// reference location given as an address is geocoded here, once, within the
+// country of the contact's phone number when it is one the deployment
+// serves; a nil ref clears it.
+func (s *ContactAccessService) UpdatePreferences(ctx context.Context, claims *utils.TokenClaims, update models.ContactRecipient, ref *ReferenceUpdate) (*ContactPreferences, error) {
+	if err := s.checkContact(ctx, claims); err != nil {
+		return nil, err
+	}
+	update.Phone = claims.Phone
+	update.Reference = nil
+	if ref != nil {
+		reference := &models.ContactReference{Label: ref.Label}
+		if ref.Address != "" {
+			var countryCode string
+			if p := country.Active().Of(claims.Phone); p != nil {
+				countryCode = p.Code
+			}
+			lat, lng, name, err := s.locations.Geocode(ctx, ref.Address, countryCode)
+			if errors.Is(err, ErrGeocodingDisabled) || errors.Is(err, ErrAddressNotFound) {
+				return nil, err
+			}
+			if err != nil {
+				return nil, fmt.Errorf("%w: %v", ErrGeocodingFailed, err)
+			}
+			reference.Lat, reference.Lng, reference.Address = lat, lng, name
+		} else {
+			reference.Lat, reference.Lng = *ref.Lat, *ref.Lng
+		}
+		update.Reference = reference
+	}
+	recipient, err := s.postgres.UpdateContactRecipient(ctx, &update)
+	if err != nil {
+		return nil, err
+	}
+	return s.preferences(ctx, recipient)
+}
+
+// checkContact returns ErrContactNotFound unless the token's contact is
+// still on the user's list with the same phone number
+func (s *ContactAccessService) checkContact(ctx context.Context, claims *utils.TokenClaims) error {
+	userID, err := uuid.Parse(claims.Subject)
+	if err != nil {
+		return utils.ErrInvalidToken
+	}
+	_, _, err = s.currentContact(ctx, userID, claims.ContactID, claims.Phone)
+	return err
+}
+
+func (s *ContactAccessService) preferences(ctx context.Context, recipient *models.ContactRecipient) (*ContactPreferences, error) {
+	users, err := s.postgres.ListProtectedUsers(ctx, recipient.Phone)
+	if err != nil {
+		return nil, err
+	}
+	return &ContactPreferences{
+		Phone:                 recipient.Phone,
+		MaxPerWindow:          recipient.MaxPerWindow,
+		EffectiveMaxPerWindow: ContactPacingLimit(*recipient, s.cfg.Current()),
+		WindowMinutes:         int(ContactPacingWindow / time.Minute),
+		SMSEnabled:            recipient.SMSEnabled,
+		WhatsAppEnabled:       recipient.WhatsAppEnabled,
+		ReferenceLocation:     recipient.Reference,
+		ProtectedUsers:        users,
+	}, nil
+}
+
+// RevokeContact revokes every token issued to the contact. Called when the
+// contact is removed or their phone number changes.
+func (s *ContactAccessService) RevokeContact(ctx context.Context, userID uuid.UUID, contactID string) error {
+	return s.redis.RevokeContactTokens(ctx, userID, contactID, s.tokenTTL())
+}
+
+// IsTokenRevoked implements middleware.TokenRevocations
+func (s *ContactAccessService) IsTokenRevoked(ctx context.Context, tokenID string) (bool, error) {
+	return s.redis.IsTokenRevoked(ctx, tokenID)
+}
+
+// currentContact returns the user and contact if the contact is still on the
+// user's list with the same phone number
+func (s *ContactAccessService) currentContact(ctx context.Context, userID uuid.UUID, contactID, phone string) (*models.User, *models.Contact, error) {
+	user, err := s.postgres.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if user == nil {
+		return nil, nil, ErrContactNotFound
+	}
+	for i := range user.TrustedContacts {
+		contact := &user.TrustedContacts[i]
+		if contact.ID == contactID && contact.Phone == phone {
+			return user, contact, nil
+		}
+	}
+	return nil, nil, ErrContactNotFound
+}
+
+func (s *ContactAccessService) issue(ctx context.Context, userID uuid.UUID, contact models.Contact) (*ContactToken, error) {
+	ttl := s.tokenTTL()
+	claims := utils.TokenClaims{
+		Subject:   userID.String(),
+		Role:      utils.RoleContact,
+		Phone:     contact.Phone,
+		ContactID: contact.ID,
+		Scopes:    ContactTokenScopes,
+		ID:        uuid.NewString(),
+	}
+	token, err := utils.IssueToken(claims, s.cfg.Current().JWTSecret, ttl)
+	if err != nil {
+		return nil, err
+	}
+
+	// Untracked tokens could not be revoked on removal, so don't hand them out
+	if err := s.redis.TrackContactToken(ctx, userID, contact.ID, claims.ID, ttl); err != nil {
+		return nil, fmt.Errorf("failed to track contact token: %w", err)
+	}
+
+	return &ContactToken{
+		AccessToken: token,
+		ExpiresAt:   time.Now().Add(ttl),
+		UserID:      userID,
+		Scopes:      ContactTokenScopes,
+	}, nil
+}
+
+func (s *ContactAccessService) tokenTTL() time.Duration {
+	return time.Duration(s.cfg.Current().ContactTokenTTLHours) * time.Hour
+}
+
+func (s *ContactAccessService) link(path string) string {
+	return strings.TrimRight(s.cfg.Current().PublicBaseURL, "/") + path
+}
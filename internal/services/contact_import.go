@@ -0,0 +1,90 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/utils"
+)
+
+// MaxContactImport is how many candidates one bulk import may contain
+const MaxContactImport = 20
+
+// Outcomes of a candidate in a bulk contact import
+const (
+	ImportAdded         = "added"
+	ImportDuplicate     = "duplicate"
+	ImportInvalidPhone  = "invalid_phone"
+	ImportLimitExceeded = "limit_exceeded"
+)
+
+// ContactCandidate is an address book entry offered for import
+type ContactCandidate struct {
+	Name  string `json:"name"`
+	Phone string `json:"phone"`
+}
+
+// ContactImportResult is what happened to one candidate
+type ContactImportResult struct {
+	Index     int    `json:"index"`
+	Name      string `json:"name"`
+	Phone     string `json:"phone"` // normalized when valid, as given otherwise
+	Outcome   string `json:"outcome"`
+	ContactID string `json:"contact_id,omitempty"` // the added contact, or the one it duplicates
+	Detail    string `json:"detail,omitempty"`
+}
+
+// PlanContactImport decides which candidates join the user's existing
+// contacts. Phones are normalized, then compared with the existing contacts
+// and with earlier candidates; a number already present is a duplicate, so
+// repeating an interrupted import adds nothing twice. Candidates past the
+// limit are rejected. It returns the full new contact list and a result per
+// candidate, in order.
+func PlanContactImport(existing models.TrustedContacts, candidates []ContactCandidate, limit int) (models.TrustedContacts, []ContactImportResult) {
+	type known struct {
+		contactID string
+		entry     int // index of the candidate that added it, -1 if it existed
+	}
+	contacts := append(models.TrustedContacts{}, existing...)
+	byPhone := make(map[string]known, len(existing)+len(candidates))
+	for _, c := range existing {
+		byPhone[utils.NormalizePhone(c.Phone)] = known{c.ID, -1}
+	}
+
+	results := make([]ContactImportResult, len(candidates))
+	for i, candidate := range candidates {
+		phone := utils.NormalizePhone(candidate.Phone)
+		name := strings.TrimSpace(candidate.Name)
+		if name == "" {
+			name = phone
+		}
+		result := ContactImportResult{Index: i, Name: name, Phone: phone}
+
+		switch prior, seen := byPhone[phone]; {
+		case !utils.IsValidE164(phone):
+			result.Phone = candidate.Phone
+			result.Outcome = ImportInvalidPhone
+			result.Detail = "not a valid phone number"
+		case seen:
+			result.Outcome = ImportDuplicate
+			result.ContactID = prior.contactID
+			result.Detail = "already a trusted contact"
+			if prior.entry >= 0 {
+				result.Detail = fmt.Sprintf("same number as entry %d", prior.entry)
+			}
+		case len(contacts) >= limit:
+			result.Outcome = ImportLimitExceeded
+			result.Detail = fmt.Sprintf("the limit of %d trusted contacts is reached", limit)
+		default:
+			contact := models.Contact{ID: uuid.New().String(), Name: name, Phone: phone}
+			contacts = append(contacts, contact)
+			byPhone[phone] = known{contact.ID, i}
+			result.Outcome = ImportAdded
+			result.ContactID = contact.ID
+		}
+		results[i] = result
+	}
+	return contacts, results
+}
@@ -0,0 +1,266 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/config"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/database"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+)
+
+// ContactPacingWindow is the window a contact's pacing counts messages over
+const ContactPacingWindow = 10 * time.Minute
+
+// Bounds of the pacing a contact may set for themselves
+const (
+	ContactPacingMin = 1
+	ContactPacingMax = 20
+)
+
+// contactPacingHeld is the delivery detail of a message held for a
+// combined update
+const contactPacingHeld = "held by the contact's pacing; sent in a combined update"
+
+// maxDigestLength keeps a combined update within three SMS segments
+const maxDigestLength = 459
+
+const (
+	contactDigestWorker = "contact_digests"
+	contactDigestEvery  = 10 * time.Second
+	contactDigestBatch  = 100
+	// contactDigestLease is how long a claimed digest waits to be claimed
+	// again if sending it fails before it is taken
+	contactDigestLease = time.Minute
+)
+
+// CriticalMessage reports whether an alert's messages bypass contact pacing:
+// it reached ALERT, or the user asked for help or had a detected impact
+func CriticalMessage(alert *models.Alert) bool {
+	return string(alert.State) == StateAlert || ExplicitDistress(alert)
+}
+
+// ContactPacer spaces out the non-critical messages one phone number gets
+// across every user who lists it as a trusted contact. Past the recipient's
+// limit per ContactPacingWindow (their own, or CONTACT_PACING_MAX_PER_10MIN)
+// messages are held in Redis and go out as one combined update when the
+// window ends. Critical messages are never held or counted.
+type ContactPacer struct {
+	cfg      *config.Store
+	postgres *database.PostgresDB
+	redis    *database.RedisDB
+}
+
+func NewContactPacer(cfg *config.Store, postgres *database.PostgresDB, redis *database.RedisDB) *ContactPacer {
+	return &ContactPacer{
+		cfg:      cfg,
+		postgres: postgres,
+		redis:    redis,
+	}
+}
+
+// Recipient returns the preferences of the person behind phone. A number no
+// user lists, or one that can't be looked up, gets the defaults: every
+// channel, and the deployment's pacing.
+func (p *ContactPacer) Recipient(ctx context.Context, phone string) models.ContactRecipient {
+	recipient, err := p.postgres.GetContactRecipient(ctx, phone)
+	if err != nil {
+		log.Printf("WARN: Failed to load preferences of contact %s: %v", phone, err)
+	}
+	if err != nil || recipient == nil {
+		return models.ContactRecipient{Phone: phone, SMSEnabled: true, WhatsAppEnabled: true}
+	}
+	return *recipient
+}
+
+// ContactPacingLimit returns how many non-critical messages the recipient
+// gets per window: their own choice, or the deployment's. 0 means they
+// aren't paced.
+func ContactPacingLimit(recipient models.ContactRecipient, cfg *config.Config) int {
+	if recipient.MaxPerWindow != nil {
+		return *recipient.MaxPerWindow
+	}
+	return cfg.ContactPacingMaxPer10Min
+}
+
+// Admit reports whether a non-critical message may go to the recipient now.
+// When it may not, entry is held for their next combined update. If Redis
+// can't be reached the message is sent: too many messages beat a lost one.
+func (p *ContactPacer) Admit(ctx context.Context, recipient models.ContactRecipient, entry *models.ContactDigestEntry) bool {
+	limit := ContactPacingLimit(recipient, p.cfg.Current())
+	if limit <= 0 {
+		return true
+	}
+	send, err := p.redis.PaceContactMessage(ctx, recipient.Phone, limit, ContactPacingWindow, entry, time.Now())
+	if err != nil {
+		log.Printf("WARN: Failed to pace message to %s, sending it: %v", recipient.Phone, err)
+		return true
+	}
+	return send
+}
+
+// ContactDigestMessage combines held messages into one update. Each user is
+// named once, with their messages oldest first; users that don't fit in
+// three SMS segments are counted at the end instead.
+func ContactDigestMessage(entries []models.ContactDigestEntry) string {
+	var order []uuid.UUID
+	names := make(map[uuid.UUID]string)
+	texts := make(map[uuid.UUID][]string)
+	for _, entry := range entries {
+		if _, ok := names[entry.UserID]; !ok {
+			order = append(order, entry.UserID)
+		}
+		names[entry.UserID] = entry.UserName
+		texts[entry.UserID] = append(texts[entry.UserID], entry.Text)
+	}
+	if len(order) == 0 {
+		return ""
+	}
+
+	build := func(shown int) string {
+		who := make([]string, shown)
+		parts := make([]string, shown)
+		for i, userID := range order[:shown] {
+			who[i] = names[userID]
+			parts[i] = names[userID] + ": " + strings.Join(texts[userID], ", then ")
+		}
+		message := "SafeTrace: " + updatesFor(shown, who) + ". " + strings.Join(parts, ". ")
+		if rest := len(order) - shown; rest > 0 {
+			message += fmt.Sprintf(" (+%d more)", rest)
+		}
+		return message
+	}
+
+	for shown := len(order); shown > 1; shown-- {
+		if message := build(shown); len(message) <= maxDigestLength {
+			return message
+		}
+	}
+	// One user's updates alone are too long; cut them short
+	message := build(1)
+	if len(message) > maxDigestLength {
+		cut := maxDigestLength - 3
+		for cut > 0 && !utf8.RuneStart(message[cut]) {
+			cut--
+		}
+		message = message[:cut] + "..."
+	}
+	return message
+}
+
+// updatesFor introduces a combined update: "Update for Ada", or "Updates for
+// Ada, Emeka and Tunde"
+func updatesFor(n int, names []string) string {
+	if n == 1 {
+		return "Update for " + names[0]
+	}
+	return "Updates for " + strings.Join(names[:n-1], ", ") + " and " + names[n-1]
+}
+
+// ContactDigests sends the combined updates contact pacing held back, once
+// each recipient's window ends. Claiming a due digest and taking its
+// messages are atomic in Redis, so each update is sent once across
+// instances.
+type ContactDigests struct {
+	redis    *database.RedisDB
+	notifier Notifier
+	health   *HealthRegistry
+
+	closeOnce sync.Once
+	stop      chan struct{}
+	done      chan struct{}
+}
+
+func NewContactDigests(redis *database.RedisDB, notifier Notifier, health *HealthRegistry) *ContactDigests {
+	return &ContactDigests{
+		redis:    redis,
+		notifier: notifier,
+		health:   health,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// Start launches the digest goroutine
+func (d *ContactDigests) Start() {
+	d.health.Register(contactDigestWorker, contactDigestEvery)
+	go d.run()
+}
+
+// Close stops the digest goroutine and waits for a running pass to finish
+func (d *ContactDigests) Close() {
+	d.closeOnce.Do(func() {
+		close(d.stop)
+	})
+	<-d.done
+}
+
+func (d *ContactDigests) run() {
+	defer close(d.done)
+
+	ticker := time.NewTicker(contactDigestEvery)
+	defer ticker.Stop()
+
+	for {
+		if d.sendDue() {
+			d.health.Beat(contactDigestWorker)
+		}
+		select {
+		case <-d.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// sendDue sends every combined update that is due and reports whether the
+// pass completed
+func (d *ContactDigests) sendDue() bool {
+	ctx, cancel := context.WithTimeout(context.Background(), contactDigestLease)
+	defer cancel()
+
+	for {
+		select {
+		case <-d.stop:
+			return true
+		default:
+		}
+		phones, err := d.redis.ClaimDueContactDigests(ctx, time.Now(), contactDigestLease, contactDigestBatch)
+		if err != nil {
+			log.Printf("ERROR: Failed to claim due contact updates: %v", err)
+			return false
+		}
+		for _, phone := range phones {
+			d.send(ctx, phone)
+		}
+		if len(phones) < contactDigestBatch {
+			return true
+		}
+	}
+}
+
+// send takes the messages held for phone and sends them as one update. A
+// digest that can't be taken is left to be claimed again; once taken, a
+// failed send is only logged, like any other message.
+func (d *ContactDigests) send(ctx context.Context, phone string) {
+	entries, err := d.redis.TakeContactDigest(ctx, phone, ContactPacingWindow)
+	if err != nil {
+		log.Printf("ERROR: Failed to take held updates for %s: %v", phone, err)
+		return
+	}
+	if len(entries) == 0 {
+		return
+	}
+	if err := d.notifier.SendContactDigest(ctx, phone, ContactDigestMessage(entries)); err != nil {
+		log.Printf("ERROR: Failed to send combined update of %d messages to %s: %v", len(entries), phone, err)
+		return
+	}
+	log.Printf("INFO: Sent combined update of %d messages to %s", len(entries), phone)
+}
@@ -0,0 +1,179 @@
+package services
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/config"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+)
+
+func TestCriticalMessage(t *testing.T) {
+	stale := models.Reasons{{Code: models.ReasonHeartbeatStale}}
+	tests := []struct {
+		name  string
+		alert models.Alert
+		want  bool
+	}{
+		{"ALERT", models.Alert{State: models.AlertStateAlert, Reasons: stale}, true},
+		{"AT_RISK", models.Alert{State: models.AlertStateAtRisk, Reasons: stale}, false},
+		{"panic at AT_RISK", models.Alert{State: models.AlertStateAtRisk, Reasons: models.Reasons{{Code: models.ReasonPanic}}}, true},
+		{"duress", models.Alert{State: models.AlertStateAtRisk, Duress: true, Reasons: models.Reasons{}}, true},
+		{"impact", models.Alert{State: models.AlertStateAtRisk, Reasons: models.Reasons{{Code: models.ReasonImpact}}}, true},
+	}
+	for _, tt := range tests {
+		if got := CriticalMessage(&tt.alert); got != tt.want {
+			t.Errorf("%s: CriticalMessage = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestContactPacingLimit(t *testing.T) {
+	cfg := &config.Config{ContactPacingMaxPer10Min: 3}
+	own, none := 5, 0
+	if got := ContactPacingLimit(models.ContactRecipient{}, cfg); got != 3 {
+		t.Errorf("default limit = %d, want 3", got)
+	}
+	if got := ContactPacingLimit(models.ContactRecipient{MaxPerWindow: &own}, cfg); got != 5 {
+		t.Errorf("contact's own limit = %d, want 5", got)
+	}
+	if got := ContactPacingLimit(models.ContactRecipient{MaxPerWindow: &none}, cfg); got != 0 {
+		t.Errorf("contact who turned pacing off = %d, want 0", got)
+	}
+
+	// Unpaced, nothing is counted or held
+	p := NewContactPacer(config.NewStore(&config.Config{}), nil, nil)
+	if !p.Admit(context.Background(), models.ContactRecipient{Phone: "+2348030000001"}, &models.ContactDigestEntry{}) {
+		t.Error("unpaced message held")
+	}
+}
+
+func digestEntry(userID uuid.UUID, name, text string) models.ContactDigestEntry {
+	return models.ContactDigestEntry{UserID: userID, UserName: name, Text: text}
+}
+
+func TestContactDigestMessage(t *testing.T) {
+	ada, emeka, tunde := uuid.New(), uuid.New(), uuid.New()
+	tests := []struct {
+		name    string
+		entries []models.ContactDigestEntry
+		want    string
+	}{
+		{"nothing held", nil, ""},
+		{"one user", []models.ContactDigestEntry{digestEntry(ada, "Ada", "CAUTION at 2:04 AM")},
+			"SafeTrace: Update for Ada. Ada: CAUTION at 2:04 AM"},
+		{"each user named once, messages oldest first", []models.ContactDigestEntry{
+			digestEntry(ada, "Ada", "AT_RISK at 2:04 AM"),
+			digestEntry(emeka, "Emeka", "CAUTION at 2:06 AM"),
+			digestEntry(ada, "Ada", "safe again at 2:09 AM"),
+		}, "SafeTrace: Updates for Ada and Emeka. Ada: AT_RISK at 2:04 AM, then safe again at 2:09 AM. Emeka: CAUTION at 2:06 AM"},
+		{"three users", []models.ContactDigestEntry{
+			digestEntry(ada, "Ada", "CAUTION"),
+			digestEntry(emeka, "Emeka", "CAUTION"),
+			digestEntry(tunde, "Tunde", "AT_RISK"),
+		}, "SafeTrace: Updates for Ada, Emeka and Tunde. Ada: CAUTION. Emeka: CAUTION. Tunde: AT_RISK"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ContactDigestMessage(tt.entries); got != tt.want {
+				t.Errorf("message = %q\nwant      %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// An update too long for three segments names the users that fit and counts
+// the rest; one user's alone is cut short on a character boundary
+func TestContactDigestMessageLength(t *testing.T) {
+	long := strings.Repeat("AT_RISK at 2:04 AM (no heartbeat for 40 minutes) ", 3)
+	var entries []models.ContactDigestEntry
+	for _, name := range []string{"Ada", "Emeka", "Tunde", "Ngozi", "Bola"} {
+		entries = append(entries, digestEntry(uuid.New(), name, long))
+	}
+	message := ContactDigestMessage(entries)
+	if len(message) > maxDigestLength || !strings.HasPrefix(message, "SafeTrace: Updates for Ada and Emeka. ") || !strings.HasSuffix(message, " (+3 more)") {
+		t.Errorf("message (%d bytes) = %q", len(message), message)
+	}
+
+	alone := ContactDigestMessage([]models.ContactDigestEntry{digestEntry(uuid.New(), "Adaeze", strings.Repeat("Ọ̀ṣun ", 200))})
+	if len(alone) > maxDigestLength || !strings.HasSuffix(alone, "...") || !utf8.ValidString(alone) {
+		t.Errorf("message (%d bytes, valid %v) = %q", len(alone), utf8.ValidString(alone), alone)
+	}
+}
+
+// digestNotifier records the combined updates sent
+type digestNotifier struct {
+	Notifier // nil: anything else panics
+
+	mu       sync.Mutex
+	messages map[string][]string
+}
+
+func (n *digestNotifier) SendContactDigest(ctx context.Context, phone, message string) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.messages[phone] = append(n.messages[phone], message)
+	return nil
+}
+
+// Past the limit, messages to one number are held across every user it
+// protects, whatever else is paced, and go out as one update when the
+// window ends; the update opens the next window
+func TestContactPacing(t *testing.T) {
+	postgres := testPostgres(t)
+	redis := testRedis(t)
+	ctx := context.Background()
+	p := NewContactPacer(config.NewStore(&config.Config{ContactPacingMaxPer10Min: 2}), postgres, redis)
+	ada, emeka := uuid.New(), uuid.New()
+	contact := p.Recipient(ctx, "+2348030000101")
+	if !contact.SMSEnabled || !contact.WhatsAppEnabled || contact.MaxPerWindow != nil {
+		t.Fatalf("unknown contact = %+v, want the defaults", contact)
+	}
+
+	admit := func(recipient models.ContactRecipient, userID uuid.UUID, name, text string) bool {
+		entry := digestEntry(userID, name, text)
+		return p.Admit(ctx, recipient, &entry)
+	}
+	if !admit(contact, ada, "Ada", "CAUTION") || !admit(contact, emeka, "Emeka", "CAUTION") {
+		t.Fatal("messages within the limit held")
+	}
+	if admit(contact, ada, "Ada", "AT_RISK") || admit(contact, emeka, "Emeka", "safe again") || admit(contact, ada, "Ada", "safe again") {
+		t.Fatal("message past the limit sent")
+	}
+
+	// Another number has its own window, and its own limit when it set one
+	one := 1
+	other := models.ContactRecipient{Phone: "+2348030000102", MaxPerWindow: &one}
+	if !admit(other, ada, "Ada", "CAUTION") || admit(other, ada, "Ada", "AT_RISK") {
+		t.Error("the other contact's limit of 1 not applied")
+	}
+
+	due, err := redis.ClaimDueContactDigests(ctx, time.Now().Add(ContactPacingWindow+time.Minute), time.Minute, 10)
+	if err != nil || len(due) != 2 {
+		t.Fatalf("due updates = %v, %v; want both contacts", due, err)
+	}
+	notifier := &digestNotifier{messages: map[string][]string{}}
+	digests := NewContactDigests(redis, notifier, NewHealthRegistry())
+	for _, phone := range due {
+		digests.send(ctx, phone)
+		digests.send(ctx, phone) // already taken
+	}
+	want := "SafeTrace: Updates for Ada and Emeka. Ada: AT_RISK, then safe again. Emeka: safe again"
+	if got := notifier.messages[contact.Phone]; len(got) != 1 || got[0] != want {
+		t.Errorf("updates = %q, want [%q]", got, want)
+	}
+	if got := notifier.messages[other.Phone]; len(got) != 1 || got[0] != "SafeTrace: Update for Ada. Ada: AT_RISK" {
+		t.Errorf("other contact's updates = %q", got)
+	}
+
+	// The update counts as the first message of the next window
+	if !admit(contact, ada, "Ada", "CAUTION") || admit(contact, ada, "Ada", "AT_RISK") {
+		t.Error("the window after an update doesn't start at one message")
+	}
+}
@@ -0,0 +1,63 @@
+package services
+
+import (
+	"time"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+)
+
+// DeviceFromHeartbeat is the device registry entry for a heartbeat. Clients
+// that don't report a device_id are tracked as one unspecified device.
+func DeviceFromHeartbeat(hb *models.Heartbeat) *models.Device {
+	id := hb.DeviceID
+	if id == "" {
+		id = models.UnspecifiedDevice
+	}
+	return &models.Device{
+		DeviceID:    id,
+		Source:      hb.Source,
+		LastSeen:    hb.Timestamp,
+		HeartbeatID: hb.ID,
+		BatteryPct:  hb.BatteryPct,
+		Lat:         hb.Lat,
+		Lng:         hb.Lng,
+		AccuracyM:   hb.AccuracyM,
+	}
+}
+
+// DeviceDisagreement returns how far apart, in km, devices that reported
+// within window of the freshest one are when that is more than thresholdKm,
+// after allowing for both fixes' accuracy, or 0 if they agree. Devices without a fix are
+// left out, as are ones whose last report is older, such as a backup phone
+// that died: where it was then says nothing about where the user is now.
+func DeviceDisagreement(devices []models.Device, window time.Duration, thresholdKm float64) float64 {
+	if len(devices) < 2 || thresholdKm <= 0 {
+		return 0
+	}
+	var freshest time.Time
+	for _, d := range devices {
+		if d.LastSeen.After(freshest) {
+			freshest = d.LastSeen
+		}
+	}
+
+	var current []models.Device
+	for _, d := range devices {
+		if freshest.Sub(d.LastSeen) > window || (d.Lat == 0 && d.Lng == 0) {
+			continue
+		}
+		current = append(current, d)
+	}
+
+	widest := 0.0
+	for i := range current {
+		for _, other := range current[i+1:] {
+			apart := haversineDistance(current[i].Lat, current[i].Lng, other.Lat, other.Lng)
+			slack := float64(current[i].AccuracyM+other.AccuracyM) / 1000
+			if apart-slack > thresholdKm && apart > widest {
+				widest = apart
+			}
+		}
+	}
+	return widest
+}
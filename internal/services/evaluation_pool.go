@@ -0,0 +1,267 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"hash/fnv"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/config"
+)
+
+// ErrEvaluationsClosed is delivered for evaluations requested after shutdown began
+var ErrEvaluationsClosed = errors.New("evaluation pool closed")
+
+const (
+	evaluationWorker = "evaluation_workers"
+	evaluationBeat   = time.Minute
+)
+
+// evaluationRequestedKey is the context key of when the running evaluation
+// was first requested, the start of an alert's time-to-alert
+type evaluationRequestedKey struct{}
+
+// evaluateFunc runs one evaluation; EvaluateUserSafety in production
+type evaluateFunc func(ctx context.Context, userID uuid.UUID) (*EvaluationResult, error)
+
+// supersededFunc reports whether a heartbeat newer than the user's latest
+// stored one arrived within window and is still to be written. Its own
+// evaluation follows once it is.
+type supersededFunc func(ctx context.Context, userID uuid.UUID, window time.Duration) (bool, error)
+
+// EvaluationPool runs evaluations on a fixed set of shards. A user always
+// hashes to the same shard, so their evaluations run one at a time in the
+// order requested, while different users run in parallel across shards.
+//
+// Requests for a user who is already queued join that queued job instead of
+// adding another: evaluation reads the latest heartbeat and state when it
+// runs, so one pass answers all of them. When a shard's queue is full,
+// callers wait for room rather than dropping the evaluation.
+//
+// Under overload a job can wait long enough for its heartbeat to be old news.
+// A job whose data is older than EVALUATION_STALE_SECONDS when it starts is
+// rechecked first: if a newer heartbeat is still waiting to be written, the
+// job is dropped in favour of the evaluation that heartbeat will queue, so
+// nothing is decided on a picture that has already been replaced. Otherwise
+// it runs, reading the latest heartbeat as every evaluation does.
+type EvaluationPool struct {
+	cfg        *config.Store
+	evaluate   evaluateFunc
+	superseded supersededFunc
+	health     *HealthRegistry
+	shards     []*evaluationShard
+
+	mu        sync.RWMutex
+	closed    bool
+	wg        sync.WaitGroup
+	coalesced atomic.Int64
+	stale     atomic.Int64
+	dropped   atomic.Int64
+	lastLagMs atomic.Int64
+}
+
+type evaluationShard struct {
+	mu      sync.Mutex
+	pending map[uuid.UUID]*evaluationJob // queued, not yet started
+	queue   chan *evaluationJob
+}
+
+type evaluationJob struct {
+	userID     uuid.UUID
+	dataAt     time.Time // newest heartbeat requested for, or the request time when none was
+	enqueuedAt time.Time
+	waiters    []chan EvaluationOutcome
+}
+
+func newEvaluationPool(cfg *config.Store, evaluate evaluateFunc, superseded supersededFunc, health *HealthRegistry) *EvaluationPool {
+	current := cfg.Current()
+	p := &EvaluationPool{
+		cfg:        cfg,
+		evaluate:   evaluate,
+		superseded: superseded,
+		health:     health,
+		shards:     make([]*evaluationShard, current.EvaluationWorkers),
+	}
+	for i := range p.shards {
+		p.shards[i] = &evaluationShard{
+			pending: make(map[uuid.UUID]*evaluationJob),
+			queue:   make(chan *evaluationJob, current.EvaluationQueueSize),
+		}
+	}
+	return p
+}
+
+// Start launches one worker per shard
+func (p *EvaluationPool) Start() {
+	p.health.Register(evaluationWorker, evaluationBeat)
+	for _, shard := range p.shards {
+		p.wg.Add(1)
+		go p.run(shard)
+	}
+}
+
+// Enqueue requests an evaluation of the user for a heartbeat taken at
+// dataAt, or for no heartbeat in particular when it is zero. The outcome is
+// delivered on the returned channel, which the caller may stop waiting on.
+func (p *EvaluationPool) Enqueue(userID uuid.UUID, dataAt time.Time) <-chan EvaluationOutcome {
+	done := make(chan EvaluationOutcome, 1)
+	now := time.Now()
+	if dataAt.IsZero() {
+		dataAt = now
+	}
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.closed {
+		done <- EvaluationOutcome{Err: ErrEvaluationsClosed}
+		return done
+	}
+
+	shard := p.shardFor(userID)
+	shard.mu.Lock()
+	if job, ok := shard.pending[userID]; ok {
+		// The queued job now stands for the newest heartbeat asked about
+		job.waiters = append(job.waiters, done)
+		if dataAt.After(job.dataAt) {
+			job.dataAt = dataAt
+		}
+		shard.mu.Unlock()
+		p.coalesced.Add(1)
+		return done
+	}
+	job := &evaluationJob{userID: userID, dataAt: dataAt, enqueuedAt: now, waiters: []chan EvaluationOutcome{done}}
+	shard.pending[userID] = job
+	shard.mu.Unlock()
+
+	// Waits while the shard is full; later requests for this user coalesce meanwhile
+	shard.queue <- job
+	return done
+}
+
+// Len returns the number of users waiting to be evaluated
+func (p *EvaluationPool) Len() int {
+	n := 0
+	for _, shard := range p.shards {
+		n += len(shard.queue)
+	}
+	return n
+}
+
+// Coalesced returns how many requests joined an already queued evaluation
+func (p *EvaluationPool) Coalesced() int64 {
+	return p.coalesced.Load()
+}
+
+// Lag returns how long the oldest queued evaluation has waited, or how long
+// the last one started had waited when none is queued
+func (p *EvaluationPool) Lag() time.Duration {
+	var oldest time.Time
+	for _, shard := range p.shards {
+		shard.mu.Lock()
+		for _, job := range shard.pending {
+			if oldest.IsZero() || job.enqueuedAt.Before(oldest) {
+				oldest = job.enqueuedAt
+			}
+		}
+		shard.mu.Unlock()
+	}
+	if oldest.IsZero() {
+		return time.Duration(p.lastLagMs.Load()) * time.Millisecond
+	}
+	return time.Since(oldest)
+}
+
+// Stale returns how many evaluations started with data older than the staleness bound
+func (p *EvaluationPool) Stale() int64 {
+	return p.stale.Load()
+}
+
+// Superseded returns how many stale evaluations were dropped for a newer heartbeat's
+func (p *EvaluationPool) Superseded() int64 {
+	return p.dropped.Load()
+}
+
+// Close stops accepting requests and waits for the queued evaluations to run
+func (p *EvaluationPool) Close() {
+	p.mu.Lock()
+	if !p.closed {
+		p.closed = true
+		for _, shard := range p.shards {
+			close(shard.queue)
+		}
+	}
+	p.mu.Unlock()
+	p.wg.Wait()
+}
+
+func (p *EvaluationPool) shardFor(userID uuid.UUID) *evaluationShard {
+	h := fnv.New32a()
+	h.Write(userID[:])
+	return p.shards[h.Sum32()%uint32(len(p.shards))]
+}
+
+func (p *EvaluationPool) run(shard *evaluationShard) {
+	defer p.wg.Done()
+
+	ticker := time.NewTicker(evaluationBeat)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case job, ok := <-shard.queue:
+			if !ok {
+				return
+			}
+			p.process(shard, job)
+			p.health.Beat(evaluationWorker)
+		case <-ticker.C:
+			p.health.Beat(evaluationWorker)
+		}
+	}
+}
+
+func (p *EvaluationPool) process(shard *evaluationShard, job *evaluationJob) {
+	// Once started, new requests queue a fresh job so they see this one's effects
+	shard.mu.Lock()
+	delete(shard.pending, job.userID)
+	waiters := job.waiters
+	dataAt := job.dataAt
+	shard.mu.Unlock()
+
+	now := time.Now()
+	p.lastLagMs.Store(now.Sub(job.enqueuedAt).Milliseconds())
+
+	ctx, cancel := context.WithTimeout(context.Background(), evaluationTimeout)
+	defer cancel()
+	ctx = context.WithValue(ctx, evaluationRequestedKey{}, job.enqueuedAt)
+
+	staleAfter := time.Duration(p.cfg.Current().EvaluationStaleSeconds) * time.Second
+	if now.Sub(dataAt) > staleAfter {
+		p.stale.Add(1)
+		superseded, err := p.superseded(ctx, job.userID, staleAfter)
+		if err != nil {
+			// Unsure, so evaluate: a needless evaluation beats a missed one
+			log.Printf("WARN: Freshness check failed for user %s: %v", job.userID, err)
+		}
+		if superseded {
+			p.dropped.Add(1)
+			for _, done := range waiters {
+				done <- EvaluationOutcome{Superseded: true}
+			}
+			return
+		}
+	}
+
+	result, err := p.evaluate(ctx, job.userID)
+	if err != nil {
+		log.Printf("ERROR: Evaluation failed for user %s: %v", job.userID, err)
+	}
+	for _, done := range waiters {
+		done <- EvaluationOutcome{Result: result, Err: err}
+	}
+}
@@ -0,0 +1,276 @@
+package services
+
+import (
+	"context"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/config"
+)
+
+func newTestEvaluationPool(workers, queue int, evaluate evaluateFunc) *EvaluationPool {
+	cfg := config.NewStore(&config.Config{EvaluationWorkers: workers, EvaluationQueueSize: queue, EvaluationStaleSeconds: 60})
+	superseded := func(context.Context, uuid.UUID, time.Duration) (bool, error) { return false, nil }
+	return newEvaluationPool(cfg, evaluate, superseded, NewHealthRegistry())
+}
+
+// A burst of 10k heartbeats runs on the pool's workers alone: no goroutine
+// per heartbeat, no more evaluations at once than workers, none of a user's
+// at once, and every request answered
+func TestEvaluationPoolBurst(t *testing.T) {
+	const (
+		workers    = 8
+		users      = 500
+		heartbeats = 10_000
+		producers  = 4
+	)
+
+	var (
+		running, peak, evaluations atomic.Int64
+		busy                       sync.Map // user -> struct{} while evaluated
+		overlap                    atomic.Bool
+	)
+	evaluate := func(ctx context.Context, userID uuid.UUID) (*EvaluationResult, error) {
+		if _, loaded := busy.LoadOrStore(userID, struct{}{}); loaded {
+			overlap.Store(true)
+		}
+		n := running.Add(1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		time.Sleep(50 * time.Microsecond)
+		running.Add(-1)
+		busy.Delete(userID)
+		evaluations.Add(1)
+		return &EvaluationResult{State: StateSafe}, nil
+	}
+
+	baseline := runtime.NumGoroutine()
+	pool := newTestEvaluationPool(workers, 64, evaluate)
+	pool.Start()
+
+	ids := make([]uuid.UUID, users)
+	for i := range ids {
+		ids[i] = uuid.New()
+	}
+
+	// Goroutines are sampled while the burst runs
+	stop := make(chan struct{})
+	var maxGoroutines atomic.Int64
+	sampled := make(chan struct{})
+	go func() {
+		defer close(sampled)
+		for {
+			if n := int64(runtime.NumGoroutine()); n > maxGoroutines.Load() {
+				maxGoroutines.Store(n)
+			}
+			select {
+			case <-stop:
+				return
+			case <-time.After(time.Millisecond):
+			}
+		}
+	}()
+
+	outcomes := make(chan (<-chan EvaluationOutcome), heartbeats)
+	var wg sync.WaitGroup
+	for p := 0; p < producers; p++ {
+		wg.Add(1)
+		go func(p int) {
+			defer wg.Done()
+			for i := p; i < heartbeats; i += producers {
+				outcomes <- pool.Enqueue(ids[i%users], time.Now())
+			}
+		}(p)
+	}
+	wg.Wait()
+	close(outcomes)
+
+	answered := 0
+	for done := range outcomes {
+		select {
+		case outcome := <-done:
+			if outcome.Err != nil || outcome.Result == nil {
+				t.Fatalf("outcome = %+v", outcome)
+			}
+			answered++
+		case <-time.After(10 * time.Second):
+			t.Fatalf("no outcome after %d of %d", answered, heartbeats)
+		}
+	}
+	close(stop)
+	<-sampled
+	pool.Close()
+
+	if answered != heartbeats {
+		t.Errorf("%d requests answered, want %d", answered, heartbeats)
+	}
+	if peak.Load() > workers {
+		t.Errorf("%d evaluations ran at once, want at most %d", peak.Load(), workers)
+	}
+	if overlap.Load() {
+		t.Errorf("a user was evaluated twice at once")
+	}
+	// Workers, producers and the sampler, with room for the runtime's own
+	if limit := int64(baseline + workers + producers + 1 + 10); maxGoroutines.Load() > limit {
+		t.Errorf("%d goroutines at peak, want at most %d", maxGoroutines.Load(), limit)
+	}
+	if n := evaluations.Load(); n+pool.Coalesced() != heartbeats {
+		t.Errorf("%d evaluations and %d coalesced, want %d in all", n, pool.Coalesced(), heartbeats)
+	}
+}
+
+// Requests after Close are answered with ErrEvaluationsClosed, not queued
+func TestEvaluationPoolClosed(t *testing.T) {
+	pool := newTestEvaluationPool(2, 4, func(context.Context, uuid.UUID) (*EvaluationResult, error) {
+		return &EvaluationResult{}, nil
+	})
+	pool.Start()
+	pool.Close()
+	if outcome := <-pool.Enqueue(uuid.New(), time.Time{}); outcome.Err != ErrEvaluationsClosed {
+		t.Errorf("Enqueue() after Close = %+v, want ErrEvaluationsClosed", outcome)
+	}
+}
+
+// A flood of stale jobs: users whose newer heartbeat is still buffered have
+// theirs dropped, so nothing is decided on the picture it replaced; users
+// with nothing newer are evaluated on what they have; and once the buffer is
+// written every user is evaluated against their freshest heartbeat
+func TestEvaluationPoolFlood(t *testing.T) {
+	const (
+		users     = 200
+		requests  = 20 // per user
+		producers = 4
+	)
+	now := time.Now()
+	old := now.Add(-20 * time.Minute)
+
+	var (
+		mu          sync.Mutex
+		stored      = map[uuid.UUID]time.Time{} // latest written heartbeat
+		buffered    = map[uuid.UUID]time.Time{} // newer heartbeat not yet written
+		evaluatedOn = map[uuid.UUID]time.Time{} // heartbeat the last evaluation read
+		fromStale   []uuid.UUID                 // evaluated while a newer heartbeat waited
+	)
+	ids := make([]uuid.UUID, users)
+	for i := range ids {
+		ids[i] = uuid.New()
+		stored[ids[i]] = old
+		if i%2 == 0 {
+			buffered[ids[i]] = now
+		}
+	}
+
+	evaluate := func(ctx context.Context, userID uuid.UUID) (*EvaluationResult, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if !buffered[userID].IsZero() {
+			fromStale = append(fromStale, userID)
+		}
+		evaluatedOn[userID] = stored[userID]
+		// A 20-minute-old heartbeat would put the user AT_RISK
+		if now.Sub(stored[userID]) > 10*time.Minute {
+			return &EvaluationResult{State: StateAtRisk}, nil
+		}
+		return &EvaluationResult{State: StateSafe}, nil
+	}
+	superseded := func(ctx context.Context, userID uuid.UUID, window time.Duration) (bool, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		return !buffered[userID].IsZero(), nil
+	}
+	cfg := config.NewStore(&config.Config{EvaluationWorkers: 4, EvaluationQueueSize: 8, EvaluationStaleSeconds: 60})
+	pool := newEvaluationPool(cfg, evaluate, superseded, NewHealthRegistry())
+	pool.Start()
+	defer pool.Close()
+
+	await := func(outcomes []<-chan EvaluationOutcome) []EvaluationOutcome {
+		var got []EvaluationOutcome
+		for _, done := range outcomes {
+			select {
+			case outcome := <-done:
+				got = append(got, outcome)
+			case <-time.After(10 * time.Second):
+				t.Fatalf("no outcome after %d of %d", len(got), len(outcomes))
+			}
+		}
+		return got
+	}
+
+	// The flood, queued faster than the small queue drains
+	var (
+		floodMu sync.Mutex
+		flood   = map[uuid.UUID][]<-chan EvaluationOutcome{}
+		wg      sync.WaitGroup
+	)
+	for p := 0; p < producers; p++ {
+		wg.Add(1)
+		go func(p int) {
+			defer wg.Done()
+			for i := p; i < users*requests; i += producers {
+				id := ids[i%users]
+				done := pool.Enqueue(id, old)
+				floodMu.Lock()
+				flood[id] = append(flood[id], done)
+				floodMu.Unlock()
+			}
+		}(p)
+	}
+	wg.Wait()
+
+	for i, id := range ids {
+		for _, outcome := range await(flood[id]) {
+			if i%2 == 0 && (!outcome.Superseded || outcome.Result != nil) {
+				t.Fatalf("user with a buffered heartbeat got %+v, want superseded", outcome)
+			}
+			if i%2 == 1 && (outcome.Superseded || outcome.Result == nil || outcome.Err != nil) {
+				t.Fatalf("user with nothing newer got %+v, want an evaluation", outcome)
+			}
+		}
+	}
+	if len(fromStale) != 0 {
+		t.Fatalf("%d evaluations ran on superseded data", len(fromStale))
+	}
+	if pool.Superseded() == 0 || pool.Stale() < pool.Superseded() {
+		t.Errorf("stale = %d, superseded = %d", pool.Stale(), pool.Superseded())
+	}
+
+	// The buffer is written, and each written heartbeat queues its evaluation
+	mu.Lock()
+	var written []uuid.UUID
+	for id, at := range buffered {
+		stored[id] = at
+		delete(buffered, id)
+		written = append(written, id)
+	}
+	mu.Unlock()
+	var fresh []<-chan EvaluationOutcome
+	for _, id := range written {
+		fresh = append(fresh, pool.Enqueue(id, now))
+	}
+	for _, outcome := range await(fresh) {
+		if outcome.Superseded || outcome.Result == nil || outcome.Result.State != StateSafe {
+			t.Errorf("fresh evaluation = %+v, want SAFE", outcome)
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	for i, id := range ids {
+		want := old
+		if i%2 == 0 {
+			want = now
+		}
+		if at, ok := evaluatedOn[id]; !ok || !at.Equal(want) {
+			t.Errorf("user %d last evaluated on %v (evaluated %t), want %v", i, at, ok, want)
+		}
+	}
+}
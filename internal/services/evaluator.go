@@ -2,13 +2,20 @@ package services
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"log"
 	"math"
+	"slices"
+	"strconv"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/adedejiosvaldo/safetrace/backend/internal/config"
 	"github.com/adedejiosvaldo/safetrace/backend/internal/database"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/flags"
 	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
 )
 
@@ -18,128 +25,1476 @@ const (
 	StateAtRisk       = "AT_RISK"
 	StateAlert        = "ALERT"
 	StateWaitLastGasp = "WAIT_LASTGASP"
+	StatePaused       = "PAUSED"
 )
 
+// Rules reported in EvaluationResult.RulesFired
+const (
+	RuleNoHeartbeat       = "no_heartbeat"
+	RuleLastGaspActive    = "lastgasp_active"
+	RuleLastGaspRecent    = "lastgasp_recent"
+	RuleLastGaspProlonged = "lastgasp_prolonged"
+	RuleHeartbeatStale    = "heartbeat_stale"
+	RuleProtectionPaused  = "protection_paused"
+	RuleActiveInApp       = "active_in_app"
+	RuleEnteringDeadZone  = "entering_dead_zone"
+	RuleCheckInPending    = "check_in_pending"
+	RuleProbableOutage    = "probable_outage"
+)
+
+// A LastGasp is put down to a dead zone when the heartbeats before it were
+// all on cellular with the signal falling by at least deadZoneSignalDrop dBm
+const (
+	deadZoneLookback      = 30 * time.Minute
+	deadZoneHeartbeats    = 4 // most recent ones looked at
+	deadZoneMinHeartbeats = 3
+	deadZoneSignalDrop    = 10
+)
+
+// locationNudgeEvery limits how often a user active in the app with stale
+// heartbeats is asked to turn location back on
+const locationNudgeEvery = 30 * time.Minute
+
+// alertDedupWindow is how long after an alert another one for the same user
+// is held back
+const alertDedupWindow = 5 * time.Minute
+
+// promptRecheckSlack is how long after a held user's check-in prompt runs
+// out they are evaluated again, giving the prompt monitor time to escalate
+// them itself
+const promptRecheckSlack = 30 * time.Second
+
+// EvaluationEffects receives the evaluator's side effects, so evaluation can
+// run against live Postgres/Redis/alerting or in a sandbox that records nothing
+type EvaluationEffects interface {
+	RecordTransition(ctx context.Context, transition *models.StateTransition) (bool, error)
+	SaveState(ctx context.Context, state *models.UserState) error
+	DropState(ctx context.Context, state *models.UserState) error
+	HandleTransition(ctx context.Context, userID uuid.UUID, state string, score int, reasons []models.Reason, changed bool) error
+	RecordScore(ctx context.Context, record *models.ScoreRecord) error
+	ScheduleCheck(ctx context.Context, userID uuid.UUID, due time.Time) error
+	RecordDiagnostic(ctx context.Context, userID uuid.UUID, diag *models.EvaluationDiagnostic) error
+}
+
 type SafetyEvaluator struct {
-	cfg      *config.Config
-	postgres *database.PostgresDB
-	redis    *database.RedisDB
-	alerter  *AlertEngine
+	cfg       *config.Store
+	postgres  *database.PostgresDB
+	redis     *database.RedisDB
+	notifier  Notifier
+	outbox    *AlertOutbox
+	locations *LocationEncoder
+	profiles  *ScoringProfileStore
+	shadow    *ShadowEvaluator
+	advisor   *IntervalAdvisor
+	outages   *OutageDetector
+	resolver  *AutoResolver
+	warmup    *RedisWarmup
+	pool      *EvaluationPool
+	clock     Clock
+	effects   EvaluationEffects
 }
 
 func NewSafetyEvaluator(
-	cfg *config.Config,
+	cfg *config.Store,
 	postgres *database.PostgresDB,
 	redis *database.RedisDB,
-	alerter *AlertEngine,
+	notifier Notifier,
+	outbox *AlertOutbox,
+	locations *LocationEncoder,
+	profiles *ScoringProfileStore,
+	shadow *ShadowEvaluator,
+	outages *OutageDetector,
+	resolver *AutoResolver,
+	warmup *RedisWarmup,
+	health *HealthRegistry,
 ) *SafetyEvaluator {
+	se := &SafetyEvaluator{
+		cfg:       cfg,
+		postgres:  postgres,
+		redis:     redis,
+		notifier:  notifier,
+		outbox:    outbox,
+		locations: locations,
+		profiles:  profiles,
+		shadow:    shadow,
+		advisor:   NewIntervalAdvisor(cfg, postgres),
+		outages:   outages,
+		resolver:  resolver,
+		warmup:    warmup,
+		clock:     SystemClock{},
+	}
+	se.effects = liveEffects{se}
+	se.pool = newEvaluationPool(cfg, se.EvaluateUserSafety, se.newerHeartbeatPending, health)
+	return se
+}
+
+// resolveProfile returns the active scoring profile, with the user's
+// organization's overrides applied if it has any, and whether it did. An
+// override that no longer validates is logged and left out.
+func (se *SafetyEvaluator) resolveProfile(ctx context.Context, userID uuid.UUID, cfg *config.Config) (ScoringProfile, bool) {
+	profile := se.profiles.Active(cfg)
+	orgID, override, err := se.postgres.GetUserOrgScoringProfile(ctx, userID)
+	if err != nil {
+		log.Printf("WARN: Organization scoring profile unavailable for user %s, using the active one: %v", userID, err)
+		return profile, false
+	}
+	if override == nil {
+		return profile, false
+	}
+	resolved, err := profile.WithOverride(override)
+	if err != nil {
+		log.Printf("WARN: Scoring profile of organization %s is invalid, using the active one: %v", orgID, err)
+		return profile, false
+	}
+	return resolved, true
+}
+
+// Start launches the evaluation workers used by EvaluateAsync
+func (se *SafetyEvaluator) Start() {
+	se.pool.Start()
+}
+
+// Close stops accepting asynchronous evaluations and waits for the queued ones
+func (se *SafetyEvaluator) Close() {
+	se.pool.Close()
+}
+
+// QueueLen returns the number of users waiting to be evaluated
+func (se *SafetyEvaluator) QueueLen() int {
+	return se.pool.Len()
+}
+
+// Coalesced returns how many evaluation requests were folded into one already queued
+func (se *SafetyEvaluator) Coalesced() int64 {
+	return se.pool.Coalesced()
+}
+
+// QueueLag returns how long the oldest queued evaluation has waited, and
+// whether that is past EVALUATION_LAG_DEGRADED_SECONDS
+func (se *SafetyEvaluator) QueueLag() (time.Duration, bool) {
+	lag := se.pool.Lag()
+	return lag, lag > time.Duration(se.cfg.Current().EvaluationLagDegradedSeconds)*time.Second
+}
+
+// StaleEvaluations returns how many queued evaluations started with stale data
+func (se *SafetyEvaluator) StaleEvaluations() int64 {
+	return se.pool.Stale()
+}
+
+// SupersededEvaluations returns how many stale evaluations were dropped
+// because a newer heartbeat's evaluation was on its way
+func (se *SafetyEvaluator) SupersededEvaluations() int64 {
+	return se.pool.Superseded()
+}
+
+// NewSandboxEvaluator returns an evaluator with no storage or alerting,
+// driven by the given clock. Only Assess may be called on it.
+func NewSandboxEvaluator(cfg *config.Config, clock Clock) *SafetyEvaluator {
 	return &SafetyEvaluator{
-		cfg:      cfg,
-		postgres: postgres,
-		redis:    redis,
-		alerter:  alerter,
+		cfg:     config.NewStore(cfg),
+		clock:   clock,
+		effects: discardEffects{},
 	}
 }
 
 type EvaluationResult struct {
-	State  string
-	Score  int
-	Reason string
+	State         string          `json:"state"`
+	Score         int             `json:"score"`
+	Reason        string          `json:"reason"`                          // rendered from Reasons
+	Reasons       []models.Reason `json:"reasons"`                         // why, most important first
+	Breakdown     map[string]int  `json:"breakdown,omitempty"`             // points per scoring component
+	RulesFired    []string        `json:"rules_fired,omitempty"`           // deterministic rules that decided the state
+	Deterministic bool            `json:"deterministic"`                   // state came from a rule, not the score
+	SpoofReasons  []string        `json:"spoof_reasons,omitempty"`         // location inputs were discounted
+	TrendPenalty  int             `json:"trend_penalty,omitempty"`         // points taken off for a deteriorating trend
+	NextInterval  int             `json:"next_interval_seconds,omitempty"` // heartbeat interval advised to the client
+	Anomalies     []string        `json:"anomalies,omitempty"`             // inconsistencies noted without changing the state
+}
+
+// setReasons replaces the result's reasons and renders Reason from them
+func (r *EvaluationResult) setReasons(reasons ...models.Reason) {
+	r.Reasons = reasons
+	r.Reason = RenderReasons(reasons)
+}
+
+// addReason adds a reason after the ones already given
+func (r *EvaluationResult) addReason(reason models.Reason) {
+	r.setReasons(append(r.Reasons, reason)...)
+}
+
+const (
+	// evaluationTimeout bounds an evaluation run by the pool for EvaluateAsync
+	evaluationTimeout = 30 * time.Second
+	// evaluationLockTTL releases the lock if its holder dies mid-evaluation
+	evaluationLockTTL = 30 * time.Second
+	// evaluationLockRetry is how often a waiting evaluation retries the lock
+	evaluationLockRetry = 50 * time.Millisecond
+)
+
+// EvaluationOutcome is delivered by EvaluateAsync
+type EvaluationOutcome struct {
+	Result     *EvaluationResult
+	Err        error
+	Superseded bool // dropped in favour of a newer heartbeat's evaluation
+}
+
+// EvaluateAsync queues EvaluateUserSafety on the user's evaluation worker,
+// detached from the caller's context. Requests made while the user is
+// already queued share that evaluation. The outcome is delivered on the
+// returned channel, which the caller may stop waiting on; errors are logged
+// either way.
+func (se *SafetyEvaluator) EvaluateAsync(userID uuid.UUID) <-chan EvaluationOutcome {
+	return se.pool.Enqueue(userID, time.Time{})
+}
+
+// EvaluateHeartbeatAsync is EvaluateAsync for a new heartbeat. The queued
+// evaluation remembers the heartbeat's time, so if it waits past
+// EVALUATION_STALE_SECONDS it is rechecked before it runs.
+func (se *SafetyEvaluator) EvaluateHeartbeatAsync(hb *models.Heartbeat) <-chan EvaluationOutcome {
+	return se.pool.Enqueue(hb.UserID, hb.Timestamp)
+}
+
+// newerHeartbeatPending reports whether the newest heartbeat seen from the
+// user, arrived within window, is newer than their latest stored one, i.e.
+// is still buffered and will queue its own evaluation once written. One seen
+// longer ago was never stored, e.g. refused by a full buffer, and is ignored.
+func (se *SafetyEvaluator) newerHeartbeatPending(ctx context.Context, userID uuid.UUID, window time.Duration) (bool, error) {
+	seen, err := se.redis.GetLatestHeartbeatTime(ctx, userID)
+	if err != nil || seen == nil || se.clock.Now().Sub(*seen) > window {
+		return false, err
+	}
+	stored, err := se.postgres.GetLatestHeartbeat(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+	return stored == nil || stored.Timestamp.Before(*seen), nil
+}
+
+// MarkDuplicate links a heartbeat to the one it is a copy of, when the client
+// sent the same heartbeat over more than one channel (HTTP and SMS "just in
+// case" in poor coverage). Copies share a fingerprint, see
+// HeartbeatFingerprint, and whichever arrives first is the original. A
+// duplicate is stored for history but drives nothing: no evaluation, LastGasp
+// or device update, and it is left out of history unless asked for. If Redis
+// can't be checked, or HEARTBEAT_DEDUP_WINDOW_SECONDS is 0, the heartbeat is
+// treated as an original.
+func (se *SafetyEvaluator) MarkDuplicate(ctx context.Context, hb *models.Heartbeat) {
+	window := time.Duration(se.cfg.Current().HeartbeatDedupWindowSeconds) * time.Second
+	if window <= 0 {
+		return
+	}
+	original, err := se.redis.ClaimHeartbeatFingerprint(ctx, hb.UserID, HeartbeatFingerprint(hb), hb.ID, window)
+	if err != nil {
+		log.Printf("WARN: Heartbeat duplicate check failed for user %s: %v", hb.UserID, err)
+		return
+	}
+	if original != nil && *original != hb.ID {
+		hb.DuplicateOf = original
+	}
+}
+
+// ReleaseFingerprint forgets the fingerprint of an original heartbeat that
+// couldn't be stored, so a copy arriving over another channel is kept as the
+// original instead of pointing at nothing
+func (se *SafetyEvaluator) ReleaseFingerprint(ctx context.Context, hb *models.Heartbeat) {
+	if hb.DuplicateOf != nil || se.cfg.Current().HeartbeatDedupWindowSeconds <= 0 {
+		return
+	}
+	if err := se.redis.ReleaseHeartbeatFingerprint(ctx, hb.UserID, HeartbeatFingerprint(hb), hb.ID); err != nil {
+		log.Printf("WARN: Failed to release heartbeat fingerprint for user %s: %v", hb.UserID, err)
+	}
+}
+
+// HeartbeatFingerprint identifies a heartbeat by its content, the same
+// whichever channel carried it: its timestamp to the second (SMS drops
+// fractions), position to 4 decimals (about 11m, the precision of SMS),
+// battery and whether it is a LastGasp, so a LastGasp is never taken for a
+// copy of a routine heartbeat. The user is part of the Redis key.
+func HeartbeatFingerprint(hb *models.Heartbeat) string {
+	battery := "-"
+	if hb.BatteryPct != nil {
+		battery = strconv.Itoa(*hb.BatteryPct)
+	}
+	content := fmt.Sprintf("%d|%d|%d|%s|%t",
+		hb.Timestamp.Unix(),
+		int64(math.Round(hb.Lat*1e4)),
+		int64(math.Round(hb.Lng*1e4)),
+		battery,
+		hb.LastGasp,
+	)
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:12])
+}
+
+// MarkBackfill flags a heartbeat that arrived after a newer one from the same
+// user, e.g. queued offline or a delayed SMS. Backfilled heartbeats are stored
+// for history but must not trigger an evaluation, which could otherwise act on
+// an older and worse picture than the one already known. If Redis can't be
+// checked the heartbeat is treated as live; evaluation still reads the newest.
+// Duplicates are history already and left as they are.
+func (se *SafetyEvaluator) MarkBackfill(ctx context.Context, hb *models.Heartbeat) {
+	if hb.DuplicateOf != nil {
+		return
+	}
+	newest, err := se.redis.AdvanceLatestHeartbeat(ctx, hb.UserID, hb.Timestamp)
+	if err != nil {
+		log.Printf("WARN: Heartbeat order check failed for user %s: %v", hb.UserID, err)
+		return
+	}
+	hb.Backfill = !newest
+}
+
+// TrackDevice records an accepted heartbeat as its device's newest, for the
+// device list and for comparing devices. Older heartbeats from the device
+// are ignored, as are duplicates; a failure only leaves the device list behind.
+func (se *SafetyEvaluator) TrackDevice(ctx context.Context, hb *models.Heartbeat) {
+	if hb.DuplicateOf != nil {
+		return
+	}
+	if err := se.redis.TrackDevice(ctx, hb.UserID, DeviceFromHeartbeat(hb)); err != nil {
+		log.Printf("WARN: Failed to track device for user %s: %v", hb.UserID, err)
+	}
+}
+
+// TrackLastGasp applies a stored, live heartbeat to the user's LastGasp: a
+// LastGasp heartbeat opens or re-arms one, and any other heartbeat ends the
+// wait, since the user is evidently back in coverage. Backfilled and
+// duplicate heartbeats are history and change neither. A failure is logged;
+// the LastGasp then expires as before.
+func (se *SafetyEvaluator) TrackLastGasp(ctx context.Context, hb *models.Heartbeat) {
+	if hb.Backfill || hb.DuplicateOf != nil {
+		return
+	}
+	if hb.LastGasp {
+		if err := se.RecordLastGasp(ctx, hb); err != nil {
+			log.Printf("WARN: Failed to record LastGasp for user %s: %v", hb.UserID, err)
+		}
+		return
+	}
+	superseded, err := se.postgres.SupersedeLastGasp(ctx, hb.UserID, se.clock.Now())
+	if err != nil {
+		log.Printf("WARN: Failed to supersede LastGasp for user %s: %v", hb.UserID, err)
+		return
+	}
+	if superseded {
+		log.Printf("INFO: Heartbeat from user %s ended their LastGasp wait", hb.UserID)
+	}
+}
+
+// RecordLastGasp opens a LastGasp at the heartbeat's position. One arriving
+// within LASTGASP_REARM_SECONDS of the user's previous LastGasp re-arms that
+// one instead, so a phone bouncing in and out of coverage keeps one record.
+func (se *SafetyEvaluator) RecordLastGasp(ctx context.Context, hb *models.Heartbeat) error {
+	cfg := se.cfg.Current()
+	now := se.clock.Now()
+	lastGasp := &models.LastGasp{
+		ID:        uuid.New(),
+		UserID:    hb.UserID,
+		Lat:       hb.Lat,
+		Lng:       hb.Lng,
+		AccuracyM: hb.AccuracyM,
+		CellInfo:  hb.CellInfo,
+		CreatedAt: now,
+		ExpiryTs:  now.Add(time.Duration(cfg.LastGaspTimeoutSeconds) * time.Second),
+		LastAt:    now,
+	}
+	rearm := time.Duration(cfg.LastGaspRearmSeconds) * time.Second
+	if err := se.postgres.RecordLastGasp(ctx, lastGasp, rearm); err != nil {
+		return err
+	}
+	if lastGasp.Repeats > 0 {
+		log.Printf("INFO: LastGasp from user %s re-armed the open one (%d repeats)", hb.UserID, lastGasp.Repeats)
+		return nil
+	}
+	se.outages.Record(ctx, hb.UserID, hb.Lat, hb.Lng, hb.CellInfo, now)
+	return nil
 }
 
-// EvaluateUserSafety is the main entry point for safety evaluation
+// EvaluateUserSafety is the main entry point for safety evaluation.
+// Evaluations of the same user are serialized by a Redis lock so concurrent
+// heartbeats cannot both act on the same state transition. Within one
+// instance EvaluateAsync already runs a user's evaluations in order; the
+// lock covers other instances and direct callers.
 func (se *SafetyEvaluator) EvaluateUserSafety(ctx context.Context, userID uuid.UUID) (*EvaluationResult, error) {
+	return se.evaluate(ctx, userID, false)
+}
+
+// EvaluateStrictly evaluates the user now with the tightened profile of a
+// watch, whether or not they are watched. Used when someone has reason to
+// worry before the evaluator does, such as an unanswered welfare check. The
+// result is saved and acted on like any other evaluation.
+func (se *SafetyEvaluator) EvaluateStrictly(ctx context.Context, userID uuid.UUID) (*EvaluationResult, error) {
+	return se.evaluate(ctx, userID, true)
+}
+
+func (se *SafetyEvaluator) evaluate(ctx context.Context, userID uuid.UUID, strict bool) (*EvaluationResult, error) {
+	unlock, err := se.lockUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	// A paused user is not scored, so missed heartbeats alert nobody
+	pause, err := se.postgres.GetActiveProtectionPause(ctx, userID, se.clock.Now())
+	if err != nil {
+		return nil, fmt.Errorf("failed to check protection pause: %w", err)
+	}
+	if pause != nil {
+		return se.holdPaused(ctx, userID, pause)
+	}
+
+	// A user under an active watch is judged more strictly. If the watch
+	// can't be read they are judged as usual.
+	watch, err := se.postgres.GetActiveWatchSession(ctx, userID, se.clock.Now())
+	if err != nil {
+		log.Printf("WARN: Watch session unavailable for user %s: %v", userID, err)
+		watch = nil
+	}
+	var watchUntil *time.Time
+	if watch != nil {
+		watchUntil = &watch.EndsAt
+	}
+
 	// Check for active LastGasp
 	lastGasp, err := se.postgres.GetActiveLastGasp(ctx, userID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to check lastgasp: %w", err)
 	}
 
-	if lastGasp != nil {
-		// User has active LastGasp - wait period
-		return &EvaluationResult{
-			State:  StateWaitLastGasp,
-			Score:  0,
-			Reason: "LastGasp active - monitoring connectivity",
-		}, nil
+	var heartbeat *models.Heartbeat
+	if lastGasp == nil {
+		// The latest heartbeat is the freshest device's, so recency and
+		// battery are judged on it alone, never on a stale backup device
+		heartbeat, err = se.postgres.GetLatestHeartbeat(ctx, userID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get heartbeat: %w", err)
+		}
+		// A coarse heartbeat is judged on its precise fix while Redis has it
+		se.RestoreLocation(ctx, heartbeat)
+	}
+
+	// The client may be following a longer interval we advised; heartbeats
+	// are only late once they are overdue on that
+	prev, err := se.CurrentState(ctx, userID)
+	if err != nil {
+		log.Printf("WARN: Previous state unavailable for user %s: %v", userID, err)
+		prev = nil
+	}
+	trigger := models.TriggeredByMonitor
+	if heartbeat != nil && (prev == nil || heartbeat.CreatedAt.After(prev.UpdatedAt)) {
+		trigger = models.TriggeredByHeartbeat
+	}
+	cfg := se.cfg.Current()
+	profile, overridden := se.resolveProfile(ctx, userID, cfg)
+	allowance := 0
+	if prev != nil {
+		allowance = prev.IntervalAllowanceSeconds
+		profile = profile.WithIntervalAllowance(allowance, cfg.HeartbeatIntervalSeconds)
+	}
+	if watch != nil || strict {
+		profile = profile.Watched()
+	}
+	result := se.Assess(heartbeat, lastGasp, profile)
+	// The flags are decided once, so the live evaluation, its shadow and
+	// its diagnostics all ran with the same
+	ctx = flags.WithCache(ctx)
+	fl := flags.For(ctx, userID)
+	// Kept for the user's diagnostics as it stands when evaluation ends
+	defer se.recordDiagnostic(ctx, userID, trigger, heartbeat, result, fl)
+
+	if heartbeat != nil && isStalenessRisk(result) && fl.Enabled(flags.ActivityHold) {
+		se.applyAppActivity(ctx, userID, heartbeat, result, profile)
+	}
+	// Users going silent together are counted towards outage detection, and
+	// held back from escalating while last seen in an outage zone
+	var outage *models.OutageZone
+	var recheck time.Time
+	if heartbeat != nil && isStalenessRisk(result) {
+		silentAt := heartbeat.Timestamp.Add(profile.heartbeatWindow())
+		se.outages.Record(ctx, userID, heartbeat.Lat, heartbeat.Lng, heartbeat.CellInfo, silentAt)
+		if fl.Enabled(flags.OutageHold) {
+			outage, recheck = se.holdForOutage(ctx, userID, heartbeat.Lat, heartbeat.Lng, heartbeat.CellInfo, silentAt, result)
+		}
+	}
+	if lastGasp != nil && firedRule(result, RuleLastGaspProlonged) && fl.Enabled(flags.OutageHold) {
+		escalatesAt := lastGasp.LastAt.Add(time.Duration(cfg.LastGaspEscalateSeconds) * time.Second)
+		outage, recheck = se.holdForOutage(ctx, userID, lastGasp.Lat, lastGasp.Lng, lastGasp.CellInfo, escalatesAt, result)
+	}
+	if heartbeat != nil && result.State == StateAtRisk && prev != nil && prev.State == StateCaution {
+		if deadline := se.holdForPrompt(ctx, userID, result); !deadline.IsZero() {
+			recheck = deadline.Add(promptRecheckSlack)
+		}
+	}
+	if heartbeat != nil {
+		se.compareDevices(ctx, userID, result, profile)
+	}
+	if slices.Contains(result.RulesFired, RuleLastGaspActive) || slices.Contains(result.RulesFired, RuleLastGaspRecent) ||
+		slices.Contains(result.RulesFired, RuleLastGaspProlonged) {
+		se.explainDeadZone(ctx, userID, result)
+	}
+
+	if !result.Deterministic && profile.TrendWindow > 0 && fl.Enabled(flags.ScoreTrend) {
+		history, err := se.postgres.GetRecentScores(ctx, userID, profile.TrendWindow-1)
+		if err != nil {
+			log.Printf("WARN: Score history unavailable for user %s, skipping trend: %v", userID, err)
+		} else {
+			ApplyTrend(result, history, profile)
+		}
+	}
+
+	// Nothing to chart or compare before the first heartbeat. The shadow
+	// is queued before the score is recorded so its trend history ends
+	// where the live one did. Users scored by their organization's profile
+	// aren't compared: the candidate would replace the active profile, not theirs.
+	if heartbeat != nil || lastGasp != nil {
+		if !overridden {
+			se.shadow.submit(shadowJob{
+				userID:       userID,
+				at:           se.clock.Now(),
+				heartbeat:    heartbeat,
+				lastGasp:     lastGasp,
+				outage:       outage,
+				allowance:    allowance,
+				configured:   cfg.HeartbeatIntervalSeconds,
+				watched:      watch != nil || strict,
+				flags:        fl,
+				activeState:  result.State,
+				activeScore:  result.Score,
+				activeReason: result.Reason,
+			})
+		}
+		if err := se.effects.RecordScore(ctx, se.scoreRecord(userID, result)); err != nil {
+			log.Printf("WARN: Failed to record score history for user %s: %v", userID, err)
+		}
+	}
+
+	if firedRule(result, RuleLastGaspActive) || firedRule(result, RuleLastGaspProlonged) {
+		// User has active LastGasp - wait period, acted on once it has
+		// gone on too long without a heartbeat
+		expiry := lastGasp.ExpiryTs
+		home := userCountry(ctx, se.postgres, userID)
+		_, changed, err := se.updateState(ctx, userID, prev, result.Reason, trigger, func(prev *models.UserState) *models.UserState {
+			state := &models.UserState{
+				UserID:         userID,
+				State:          result.State,
+				Score:          result.Score,
+				LastHeartbeat:  lastGasp.LastAt,
+				LastGaspActive: true,
+				LastGaspExpiry: &expiry,
+				WatchUntil:     watchUntil,
+				Reasons:        result.Reasons,
+				Roaming:        roamingAfter(home, lastGasp.CellInfo, prev),
+				UpdatedAt:      se.clock.Now(),
+			}
+			if firedRule(result, RuleProbableOutage) {
+				state.Outage = outage
+			}
+			carryPayload(state, prev)
+			se.adviseInterval(ctx, state, prev, nil, result)
+			return state
+		})
+		if err != nil {
+			return nil, err
+		}
+		se.scheduleCheck(ctx, userID, earliest(se.lastGaspCheck(lastGasp, result), recheck))
+		if result.State != StateWaitLastGasp {
+			if err := se.effects.HandleTransition(ctx, userID, result.State, result.Score, result.Reasons, changed); err != nil {
+				return nil, fmt.Errorf("failed to handle state transition: %w", err)
+			}
+		}
+		return result, nil
+	}
+
+	// Missing data and a recent LastGasp heartbeat are reported as-is. A
+	// stale heartbeat is recorded and acted on like a score, so a user who
+	// goes silent is alerted on.
+	if heartbeat == nil {
+		se.scheduleCheck(ctx, userID, time.Time{})
+		return result, nil
+	}
+	if result.Deterministic && !firedRule(result, RuleHeartbeatStale) {
+		se.scheduleCheck(ctx, userID, profile.nextChange(heartbeat, se.clock.Now()))
+		if trigger == models.TriggeredByHeartbeat {
+			se.resolver.Observe(ctx, heartbeat, result, profile)
+		}
+		return result, nil
+	}
+
+	// Record the state, and cache it in Redis
+	home := userCountry(ctx, se.postgres, userID)
+	_, changed, err := se.updateState(ctx, userID, prev, result.Reason, trigger, func(prev *models.UserState) *models.UserState {
+		userState := &models.UserState{
+			UserID:         userID,
+			State:          result.State,
+			Score:          result.Score,
+			LastHeartbeat:  heartbeat.Timestamp,
+			LastTrust:      HeartbeatTrust(heartbeat),
+			SpoofSuspected: heartbeat.SpoofSuspected,
+			SpoofReasons:   heartbeat.SpoofReasons,
+			Anomalies:      result.Anomalies,
+			WatchUntil:     watchUntil,
+			Reasons:        result.Reasons,
+			Roaming:        roamingAfter(home, heartbeat.CellInfo, prev),
+			UpdatedAt:      se.clock.Now(),
+		}
+		if firedRule(result, RuleProbableOutage) {
+			userState.Outage = outage
+		}
+		carryPayload(userState, prev)
+		se.adviseInterval(ctx, userState, prev, heartbeat, result)
+		return userState
+	})
+	if err != nil {
+		return nil, err
+	}
+	se.scheduleCheck(ctx, userID, earliest(se.nextCheck(heartbeat, result, profile), recheck))
+
+	// Handle state transitions
+	if err := se.effects.HandleTransition(ctx, userID, result.State, result.Score, result.Reasons, changed); err != nil {
+		return nil, fmt.Errorf("failed to handle state transition: %w", err)
+	}
+
+	// A fresh heartbeat may be the user getting home safe after an alert
+	if trigger == models.TriggeredByHeartbeat {
+		se.resolver.Observe(ctx, heartbeat, result, profile)
+	}
+
+	return result, nil
+}
+
+// earliest returns the earlier of two check times, either of which may be
+// zero for none
+func earliest(a, b time.Time) time.Time {
+	if a.IsZero() || (!b.IsZero() && b.Before(a)) {
+		return b
+	}
+	return a
+}
+
+// lastGaspCheck is when a LastGasp wait next needs evaluating: when it would
+// escalate, or when it expires
+func (se *SafetyEvaluator) lastGaspCheck(lastGasp *models.LastGasp, result *EvaluationResult) time.Time {
+	escalate := time.Duration(se.cfg.Current().LastGaspEscalateSeconds) * time.Second
+	if result.State == StateWaitLastGasp && escalate > 0 {
+		return lastGasp.LastAt.Add(escalate)
+	}
+	return lastGasp.ExpiryTs
+}
+
+// adviseInterval sets the heartbeat interval to advise on the state about to
+// be saved and on the result, and pushes it to the client when it changed
+// enough to be worth not waiting for the next heartbeat response
+func (se *SafetyEvaluator) adviseInterval(ctx context.Context, state, prev *models.UserState, heartbeat *models.Heartbeat, result *EvaluationResult) {
+	user, err := se.postgres.GetUserByID(ctx, state.UserID)
+	if err != nil {
+		log.Printf("WARN: Settings unavailable for interval advice to user %s: %v", state.UserID, err)
+		user = nil
+	}
+	advice := se.advisor.Advise(ctx, user, state.State, state.WatchUntil != nil, heartbeat, se.clock.Now())
+
+	newHeartbeat := heartbeat != nil && (prev == nil || heartbeat.Timestamp.After(prev.LastHeartbeat))
+	state.NextIntervalSeconds = advice.Seconds
+	state.NextIntervalReason = advice.Reason
+	state.IntervalAllowanceSeconds = IntervalAllowance(prev, newHeartbeat, advice.Seconds)
+	result.NextInterval = advice.Seconds
+
+	if prev == nil || !intervalChanged(prev.NextIntervalSeconds, advice.Seconds) {
+		return
+	}
+	token, err := se.postgres.GetPushToken(ctx, state.UserID)
+	if err != nil || token == "" {
+		return
+	}
+	if err := se.notifier.SendIntervalAdvice(ctx, token, advice.Seconds, advice.Reason); err != nil {
+		log.Printf("WARN: Failed to push interval advice to user %s: %v", state.UserID, err)
+	}
+}
+
+// carryInterval keeps the previous interval advice on a state saved without
+// a fresh evaluation, so the staleness allowance isn't lost
+func carryInterval(state, prev *models.UserState) {
+	state.NextIntervalSeconds = prev.NextIntervalSeconds
+	state.NextIntervalReason = prev.NextIntervalReason
+	state.IntervalAllowanceSeconds = prev.IntervalAllowanceSeconds
+}
+
+// carryPayload keeps the fields a newer build wrote on the previous state,
+// which this build doesn't know, so rewriting the state mid-deploy doesn't
+// drop them, and the revision the state is built on. A nil prev carries nothing.
+func carryPayload(state, prev *models.UserState) {
+	if prev != nil {
+		state.RedisPayload = prev.RedisPayload
+		state.Revision = prev.Revision
+	}
+}
+
+// compareDevices notes on the result when the user's devices reporting in
+// the same heartbeat window disagree on where the user is. Like suspected
+// spoofing it is flagged, never alerted on by itself. If the devices can't
+// be read the result stands.
+func (se *SafetyEvaluator) compareDevices(ctx context.Context, userID uuid.UUID, result *EvaluationResult, profile ScoringProfile) {
+	devices, err := se.redis.GetDevices(ctx, userID)
+	if err != nil {
+		log.Printf("WARN: Devices unavailable for user %s, skipping comparison: %v", userID, err)
+		return
+	}
+	km := DeviceDisagreement(devices, profile.heartbeatWindow(), se.cfg.Current().DeviceDisagreementKm)
+	if km == 0 {
+		return
+	}
+	reason := models.Reason{Code: models.ReasonDeviceDisagreement, Params: map[string]interface{}{"km": int(math.Round(km))}}
+	result.Anomalies = append(result.Anomalies, ReasonText(reason))
+	result.addReason(reason)
+}
+
+// isStalenessRisk reports whether the result is AT_RISK only because heartbeats stopped
+func isStalenessRisk(result *EvaluationResult) bool {
+	return result.State == StateAtRisk && firedRule(result, RuleHeartbeatStale)
+}
+
+// firedRule reports whether rule is among the result's RulesFired
+func firedRule(result *EvaluationResult, rule string) bool {
+	for _, r := range result.RulesFired {
+		if r == rule {
+			return true
+		}
+	}
+	return false
+}
+
+// nextCheck returns when the stale-user monitor should next evaluate a user
+// whose latest heartbeat hb was just evaluated to result: when their score
+// could drop or they could go stale with no newer heartbeat, or once activity
+// in the app can no longer hold them at CAUTION. It is zero for a user
+// already AT_RISK for staleness, as nothing changes before their next
+// heartbeat, and that is evaluated anyway.
+func (se *SafetyEvaluator) nextCheck(hb *models.Heartbeat, result *EvaluationResult, profile ScoringProfile) time.Time {
+	if isStalenessRisk(result) {
+		return time.Time{}
+	}
+	if firedRule(result, RuleActiveInApp) {
+		limit := time.Duration(se.cfg.Current().ActivitySuppressionMaxMinutes) * time.Minute
+		return hb.Timestamp.Add(profile.heartbeatWindow() + limit + time.Second)
+	}
+	return profile.nextChange(hb, se.clock.Now())
+}
+
+// scheduleCheck sets when the stale-user monitor next evaluates the user, or
+// stops it tracking them when due is zero. A failure only leaves the user on
+// their previous schedule until the monitor reconciles it with Postgres.
+func (se *SafetyEvaluator) scheduleCheck(ctx context.Context, userID uuid.UUID, due time.Time) {
+	if err := se.effects.ScheduleCheck(ctx, userID, due); err != nil {
+		log.Printf("WARN: Failed to schedule the next check of user %s: %v", userID, err)
+	}
+}
+
+// applyAppActivity holds a staleness-driven AT_RISK at CAUTION while the user
+// is using the app, and nudges them to turn location back on. Explicit
+// distress (TriggerPanic) and detected impacts (RaiseImpact) don't pass
+// through here and are never held back. If activity can't be read the
+// result stands.
+func (se *SafetyEvaluator) applyAppActivity(ctx context.Context, userID uuid.UUID, heartbeat *models.Heartbeat, result *EvaluationResult, profile ScoringProfile) {
+	activeAt, err := se.redis.UserActiveAt(ctx, userID)
+	if err != nil {
+		log.Printf("WARN: App activity unavailable for user %s: %v", userID, err)
+		return
+	}
+	limit := time.Duration(se.cfg.Current().ActivitySuppressionMaxMinutes) * time.Minute
+	if !SuppressForActivity(result, heartbeat, activeAt, se.clock.Now(), profile, limit) {
+		return
+	}
+
+	claimed, err := se.redis.ClaimLocationNudge(ctx, userID, locationNudgeEvery)
+	if err != nil || !claimed {
+		return
+	}
+	token, err := se.postgres.GetPushToken(ctx, userID)
+	if err != nil || token == "" {
+		return
+	}
+	err = se.notifier.SendPushNotification(ctx, token,
+		"Location looks off",
+		"SafeTrace hasn't had your location for a while. Turn location back on so your contacts aren't alerted by mistake.")
+	if err != nil {
+		log.Printf("WARN: Failed to nudge user %s about location: %v", userID, err)
+	}
+}
+
+// holdForPrompt holds an AT_RISK result at CAUTION while a check-in prompt
+// is still waiting on the user, so they are only escalated once every
+// attempt went unanswered. Explicit distress (TriggerPanic) and detected
+// impacts (RaiseImpact) don't pass through here. If the prompt can't be read
+// the result stands. It returns the prompt's deadline, or zero if the result
+// wasn't held.
+func (se *SafetyEvaluator) holdForPrompt(ctx context.Context, userID uuid.UUID, result *EvaluationResult) time.Time {
+	session, err := se.redis.GetPromptSession(ctx, userID)
+	if err != nil {
+		log.Printf("WARN: Check-in prompt unavailable for user %s: %v", userID, err)
+		return time.Time{}
+	}
+	if session == nil || !session.Deadline().After(se.clock.Now()) {
+		return time.Time{}
+	}
+
+	deadline := session.Deadline()
+	result.State = StateCaution
+	result.RulesFired = append(result.RulesFired, RuleCheckInPending)
+	result.addReason(models.Reason{Code: models.ReasonCheckInPending, Params: map[string]interface{}{"until": deadline.UTC().Format(time.RFC3339)}})
+	return deadline
+}
+
+// holdForOutage holds an AT_RISK result at CAUTION, with SuppressForOutage,
+// while the position the user was last seen at is in an outage zone of their
+// network. escalatesAt is when the result would have escalated without it.
+// It returns the zone, if any, and when the hold ends, or zero if the result
+// wasn't held. If zones can't be read the result stands.
+func (se *SafetyEvaluator) holdForOutage(ctx context.Context, userID uuid.UUID, lat, lng float64, cell models.CellInfo, escalatesAt time.Time, result *EvaluationResult) (*models.OutageZone, time.Time) {
+	zone, err := se.outages.ZoneAt(ctx, lat, lng, cell)
+	if err != nil {
+		log.Printf("WARN: Outage zones unavailable for user %s: %v", userID, err)
+		return nil, time.Time{}
+	}
+	grace := time.Duration(se.cfg.Current().OutageGraceMinutes) * time.Minute
+	ends := SuppressForOutage(result, zone, escalatesAt, se.clock.Now(), grace)
+	if !ends.IsZero() {
+		// Checked again as the hold ends, in case nothing else is due before
+		ends = ends.Add(time.Second)
+	}
+	return zone, ends
+}
+
+// explainDeadZone adds to a LastGasp result's reason when the heartbeats
+// leading up to it show the user entering a dead zone. If they can't be
+// read the result stands.
+func (se *SafetyEvaluator) explainDeadZone(ctx context.Context, userID uuid.UUID, result *EvaluationResult) {
+	heartbeats, err := se.postgres.GetHeartbeatsSince(ctx, userID, se.clock.Now().Add(-deadZoneLookback))
+	if err != nil {
+		log.Printf("WARN: Recent heartbeats unavailable for user %s: %v", userID, err)
+		return
+	}
+	from, to, ok := DeadZoneTrend(heartbeats)
+	if !ok {
+		return
+	}
+	result.addReason(models.Reason{Code: models.ReasonEnteringDeadZone, Params: map[string]interface{}{"from_dbm": from, "to_dbm": to}})
+	result.RulesFired = append(result.RulesFired, RuleEnteringDeadZone)
+}
+
+// DeadZoneTrend reports whether a user's latest heartbeats, newest first,
+// look like a phone losing coverage: the last few were all sent over
+// cellular, none with a stronger signal than the one before, and the signal
+// fell by at least deadZoneSignalDrop dBm. Heartbeats without a signal
+// strength, such as most SMS ones, are passed over. It returns the first and
+// last signal strengths of that run.
+func DeadZoneTrend(heartbeats []models.Heartbeat) (from, to int, ok bool) {
+	var run []models.Heartbeat
+	for _, hb := range heartbeats {
+		if len(run) == deadZoneHeartbeats {
+			break
+		}
+		if hb.Backfill {
+			continue
+		}
+		if hb.Connectivity != models.ConnectivityCellular {
+			return 0, 0, false
+		}
+		if hb.CellInfo.RSSI != 0 {
+			run = append(run, hb)
+		}
+	}
+	if len(run) < deadZoneMinHeartbeats {
+		return 0, 0, false
+	}
+	for i := 1; i < len(run); i++ {
+		// run is newest first, so each one may be no stronger than the older one after it
+		if run[i-1].CellInfo.RSSI > run[i].CellInfo.RSSI {
+			return 0, 0, false
+		}
+	}
+	from, to = run[len(run)-1].CellInfo.RSSI, run[0].CellInfo.RSSI
+	if from-to < deadZoneSignalDrop {
+		return 0, 0, false
+	}
+	return from, to, true
+}
+
+// SuppressForActivity turns a staleness-driven AT_RISK into CAUTION when the
+// user was active in the app after their last heartbeat. It only holds for
+// limit past the heartbeat window, so activity alone can't keep a user out
+// of AT_RISK forever; a limit of 0 disables it. It reports whether the
+// result was changed.
+func SuppressForActivity(result *EvaluationResult, heartbeat *models.Heartbeat, activeAt *time.Time, now time.Time, profile ScoringProfile, limit time.Duration) bool {
+	if !isStalenessRisk(result) || activeAt == nil || limit <= 0 {
+		return false
+	}
+	if !activeAt.After(heartbeat.Timestamp) {
+		return false
+	}
+	if now.Sub(heartbeat.Timestamp) > profile.heartbeatWindow()+limit {
+		return false
+	}
+
+	result.State = StateCaution
+	result.setReasons(models.Reason{Code: models.ReasonActiveInApp})
+	result.RulesFired = append(result.RulesFired, RuleActiveInApp)
+	return true
+}
+
+// holdPaused records the PAUSED state, keeping the last known heartbeat and
+// score so the status endpoint still shows them
+func (se *SafetyEvaluator) holdPaused(ctx context.Context, userID uuid.UUID, pause *models.ProtectionPause) (*EvaluationResult, error) {
+	until := pause.PausedUntil
+	result := &EvaluationResult{
+		State:         StatePaused,
+		RulesFired:    []string{RuleProtectionPaused},
+		Deterministic: true,
+	}
+	result.setReasons(models.Reason{Code: models.ReasonProtectionPaused, Params: map[string]interface{}{"until": until.UTC().Format(time.RFC3339)}})
+
+	prev, err := se.CurrentState(ctx, userID)
+	if err != nil {
+		prev = nil
+	}
+	state, _, err := se.updateState(ctx, userID, prev, result.Reason, models.TriggeredByMonitor, func(prev *models.UserState) *models.UserState {
+		state := &models.UserState{
+			UserID:      userID,
+			State:       StatePaused,
+			PausedUntil: &until,
+			Reasons:     result.Reasons,
+			UpdatedAt:   se.clock.Now(),
+		}
+		if prev != nil {
+			state.Score = prev.Score
+			state.LastHeartbeat = prev.LastHeartbeat
+			state.LastTrust = prev.LastTrust
+			carryInterval(state, prev)
+			carryPayload(state, prev)
+			state.Roaming = prev.Roaming
+		}
+		return state
+	})
+	if err != nil {
+		return nil, err
+	}
+	result.Score = state.Score
+	// The protection service evaluates the user again when the pause ends
+	se.scheduleCheck(ctx, userID, time.Time{})
+	return result, nil
+}
+
+func (se *SafetyEvaluator) scoreRecord(userID uuid.UUID, result *EvaluationResult) *models.ScoreRecord {
+	return &models.ScoreRecord{
+		UserID:        userID,
+		EvaluatedAt:   se.clock.Now(),
+		State:         result.State,
+		Score:         result.Score,
+		TrendPenalty:  result.TrendPenalty,
+		Deterministic: result.Deterministic,
+		Breakdown:     result.Breakdown,
+		Reason:        result.Reason,
+	}
+}
+
+// diagnosticEvaluations is how many of a user's latest evaluations are kept
+// for their diagnostics
+const diagnosticEvaluations = 10
+
+// recordDiagnostic keeps the outcome of an evaluation for the user's diagnostics
+func (se *SafetyEvaluator) recordDiagnostic(ctx context.Context, userID uuid.UUID, trigger string, heartbeat *models.Heartbeat, result *EvaluationResult, fl flags.Values) {
+	diag := &models.EvaluationDiagnostic{
+		EvaluatedAt:         se.clock.Now(),
+		TriggeredBy:         trigger,
+		State:               result.State,
+		Score:               result.Score,
+		Deterministic:       result.Deterministic,
+		ReasonCodes:         make([]string, 0, len(result.Reasons)),
+		RulesFired:          result.RulesFired,
+		NextIntervalSeconds: result.NextInterval,
+		Flags:               fl.Map(),
+	}
+	if heartbeat != nil {
+		diag.HeartbeatID = &heartbeat.ID
+	}
+	for _, reason := range result.Reasons {
+		diag.ReasonCodes = append(diag.ReasonCodes, reason.Code)
+	}
+	if err := se.effects.RecordDiagnostic(ctx, userID, diag); err != nil {
+		log.Printf("WARN: Failed to record evaluation diagnostic for user %s: %v", userID, err)
+	}
+}
+
+// TriggerPanic raises an ALERT for an explicit distress message, bypassing
+// scoring. It holds the evaluation lock like any other evaluation.
+func (se *SafetyEvaluator) TriggerPanic(ctx context.Context, userID uuid.UUID, reason models.Reason) error {
+	unlock, err := se.lockUser(ctx, userID)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	changed, err := se.panicState(ctx, userID, reason)
+	if err != nil {
+		return err
+	}
+
+	if err := se.effects.HandleTransition(ctx, userID, StateAlert, 0, []models.Reason{reason}, changed); err != nil {
+		return fmt.Errorf("failed to handle panic: %w", err)
+	}
+	return nil
+}
+
+// RaiseDuress raises an ALERT flagged as duress after the user entered their
+// duress PIN. It is raised directly, bypassing deduplication: being forced to
+// cancel is news even if the user was alerted on moments ago.
+func (se *SafetyEvaluator) RaiseDuress(ctx context.Context, userID uuid.UUID) error {
+	unlock, err := se.lockUser(ctx, userID)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	reason := models.Reason{Code: models.ReasonDuress}
+	if _, err := se.panicState(ctx, userID, reason); err != nil {
+		return err
+	}
+
+	alert := &models.Alert{
+		ID:        uuid.New(),
+		UserID:    userID,
+		State:     models.AlertStateAlert,
+		Score:     0,
+		Reason:    ReasonText(reason),
+		Reasons:   models.Reasons{reason},
+		SentTo:    []string{},
+		Duress:    true,
+		CreatedAt: se.clock.Now(),
+	}
+	if err := se.createAlert(ctx, alert); err != nil {
+		return fmt.Errorf("failed to create duress alert: %w", err)
+	}
+	return se.dispatchAlert(ctx, alert, ChannelEventAlert)
+}
+
+// panicState ends any protection pause and records the user in ALERT for a
+// panic, reporting whether their state changed. Must be called with the
+// evaluation lock held.
+func (se *SafetyEvaluator) panicState(ctx context.Context, userID uuid.UUID, reason models.Reason) (bool, error) {
+	// Asking for help ends a pause; otherwise the next evaluation would hide the alert
+	if pause, err := se.postgres.ResumeProtectionPause(ctx, userID, models.PauseResumedByUser); err != nil {
+		log.Printf("WARN: Failed to end protection pause for user %s on panic: %v", userID, err)
+	} else if pause != nil {
+		log.Printf("INFO: Protection pause for user %s ended by a panic trigger", userID)
+	}
+
+	now := se.clock.Now()
+	prev, err := se.CurrentState(ctx, userID)
+	if err != nil {
+		prev = nil
+	}
+	_, changed, err := se.updateState(ctx, userID, prev, ReasonText(reason), models.TriggeredByPanic, func(prev *models.UserState) *models.UserState {
+		state := &models.UserState{
+			UserID:        userID,
+			State:         StateAlert,
+			Score:         0,
+			LastHeartbeat: now,
+			Reasons:       []models.Reason{reason},
+			UpdatedAt:     now,
+		}
+		carryPayload(state, prev)
+		return state
+	})
+	return changed, err
+}
+
+// RaiseImpact escalates a user to ALERT after an impact was detected in their
+// sensor data. An open AT_RISK or CAUTION alert is upgraded in place and sent
+// again, bypassing deduplication; an open ALERT is left as it is.
+func (se *SafetyEvaluator) RaiseImpact(ctx context.Context, userID uuid.UUID, reason models.Reason) error {
+	unlock, err := se.lockUser(ctx, userID)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	latest, err := se.postgres.GetLatestAlert(ctx, userID)
+	if err != nil {
+		return err
+	}
+	open := latest != nil && latest.ResolvedAt == nil
+	if open && latest.State == models.AlertStateAlert {
+		return nil
+	}
+
+	now := se.clock.Now()
+	prev, err := se.CurrentState(ctx, userID)
+	if err != nil {
+		prev = nil
+	}
+	text := ReasonText(reason)
+	state, changed, err := se.updateState(ctx, userID, prev, text, models.TriggeredByImpact, func(prev *models.UserState) *models.UserState {
+		state := &models.UserState{
+			UserID:        userID,
+			State:         StateAlert,
+			Score:         0,
+			LastHeartbeat: now,
+			Reasons:       []models.Reason{reason},
+			UpdatedAt:     now,
+		}
+		if prev != nil {
+			// The impact says nothing new about when the device was last heard from
+			state.LastHeartbeat = prev.LastHeartbeat
+			carryInterval(state, prev)
+			carryPayload(state, prev)
+			state.Roaming = prev.Roaming
+		}
+		return state
+	})
+	if err != nil {
+		return err
+	}
+
+	if !open {
+		if err := se.effects.HandleTransition(ctx, userID, StateAlert, 0, state.Reasons, changed); err != nil {
+			return fmt.Errorf("failed to raise impact alert: %w", err)
+		}
+		return nil
+	}
+
+	if err := se.postgres.UpgradeAlert(ctx, latest.ID, models.AlertStateAlert, text, state.Reasons); err != nil {
+		return fmt.Errorf("failed to upgrade alert: %w", err)
+	}
+	latest.State = models.AlertStateAlert
+	latest.Score = 0
+	latest.Reason = text
+	latest.Reasons = state.Reasons
+	return se.dispatchAlert(ctx, latest, ChannelEventEscalated)
+}
+
+// RaiseWatchExpired moves a user to AT_RISK and alerts their contacts after a
+// watch session ran out without them confirming they arrived. It reports
+// whether an alert was raised; an open alert is left as it is.
+func (se *SafetyEvaluator) RaiseWatchExpired(ctx context.Context, userID uuid.UUID, reason models.Reason) (bool, error) {
+	unlock, err := se.lockUser(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+
+	return se.raiseAtRisk(ctx, userID, reason, "watch")
+}
+
+// RaisePromptUnanswered moves a user still at CAUTION to AT_RISK and alerts
+// their contacts once every attempt of a check-in prompt went unanswered. It
+// reports whether an alert was raised; a user who left CAUTION meanwhile, is
+// held there by a probable outage, or has an open alert, is left as they are.
+func (se *SafetyEvaluator) RaisePromptUnanswered(ctx context.Context, userID uuid.UUID, reason models.Reason) (bool, error) {
+	unlock, err := se.lockUser(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+
+	state, err := se.CurrentState(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+	// An outage zone's grace outlasts the prompt; the evaluator escalates
+	// the user itself once it runs out
+	if state == nil || state.State != StateCaution || state.Outage != nil {
+		return false, nil
 	}
+	return se.raiseAtRisk(ctx, userID, reason, "check-in")
+}
 
-	// Get latest heartbeat
-	heartbeat, err := se.postgres.GetLatestHeartbeat(ctx, userID)
+// raiseAtRisk moves a user to AT_RISK for reason and alerts their contacts,
+// unless an alert is open. kind names the alert in errors. Must be called
+// with the evaluation lock held.
+func (se *SafetyEvaluator) raiseAtRisk(ctx context.Context, userID uuid.UUID, reason models.Reason, kind string) (bool, error) {
+	latest, err := se.postgres.GetLatestAlert(ctx, userID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get heartbeat: %w", err)
+		return false, err
+	}
+	if latest != nil && latest.ResolvedAt == nil {
+		return false, nil
+	}
+
+	now := se.clock.Now()
+	prev, err := se.CurrentState(ctx, userID)
+	if err != nil {
+		prev = nil
+	}
+	state, _, err := se.updateState(ctx, userID, prev, ReasonText(reason), models.TriggeredByMonitor, func(prev *models.UserState) *models.UserState {
+		state := &models.UserState{
+			UserID:        userID,
+			State:         StateAtRisk,
+			Score:         0,
+			LastHeartbeat: now,
+			Reasons:       []models.Reason{reason},
+			UpdatedAt:     now,
+		}
+		if prev != nil {
+			state.Score = prev.Score
+			state.LastHeartbeat = prev.LastHeartbeat
+			carryInterval(state, prev)
+			carryPayload(state, prev)
+			state.Roaming = prev.Roaming
+		}
+		return state
+	})
+	if err != nil {
+		return false, err
+	}
+
+	// Raised directly: the user may already have been AT_RISK, which a
+	// state transition would treat as nothing new
+	alert := &models.Alert{
+		ID:        uuid.New(),
+		UserID:    userID,
+		State:     models.AlertStateAtRisk,
+		Score:     state.Score,
+		Reason:    ReasonText(reason),
+		Reasons:   models.Reasons{reason},
+		SentTo:    []string{},
+		CreatedAt: now,
+	}
+	if err := se.createAlert(ctx, alert); err != nil {
+		return false, fmt.Errorf("failed to create %s alert: %w", kind, err)
+	}
+	if err := se.dispatchAlert(ctx, alert, ChannelEventAlert); err != nil {
+		return true, err
+	}
+	return true, nil
+}
+
+// stateWriteAttempts bounds how often a state is rebuilt after another writer
+// saved the user's state between its read and its write
+const stateWriteAttempts = 3
+
+// updateState saves the state build makes from prev, the user's current
+// state or nil if they have none, and reports whether it changed. If another
+// writer saved the user's state since prev was read, build runs again on
+// theirs so nothing they wrote is lost, up to stateWriteAttempts times in
+// all. Must be called with the evaluation lock held.
+func (se *SafetyEvaluator) updateState(ctx context.Context, userID uuid.UUID, prev *models.UserState, reason, trigger string, build func(prev *models.UserState) *models.UserState) (*models.UserState, bool, error) {
+	for attempt := 1; ; attempt++ {
+		state := build(prev)
+		changed, err := se.saveState(ctx, state, reason, trigger)
+		if !errors.Is(err, database.ErrStateChanged) {
+			return state, changed, err
+		}
+		if attempt == stateWriteAttempts {
+			return nil, false, fmt.Errorf("state of user %s kept changing while saving it: %w", userID, err)
+		}
+		log.Printf("INFO: State of user %s changed while it was evaluated, rebuilding on the new one", userID)
+		if prev, err = se.CurrentState(ctx, userID); err != nil {
+			return nil, false, err
+		}
+	}
+}
+
+// saveState caches the state in Redis unless another writer saved the
+// user's state after the one it was built on, failing with
+// database.ErrStateChanged and recording nothing if one did. It then records
+// the state in Postgres if it changed, and reports whether it did. If
+// recording fails the cached state is dropped, so the next evaluation,
+// reading Postgres, sees the change again. Must be called with the
+// evaluation lock held.
+func (se *SafetyEvaluator) saveState(ctx context.Context, state *models.UserState, reason, trigger string) (bool, error) {
+	if err := se.effects.SaveState(ctx, state); errors.Is(err, database.ErrStateChanged) {
+		return false, err
+	} else if err != nil {
+		log.Printf("WARN: Failed to cache state for user %s: %v", state.UserID, err)
+	}
+	changed, err := se.effects.RecordTransition(ctx, &models.StateTransition{
+		UserID:      state.UserID,
+		ToState:     state.State,
+		Score:       state.Score,
+		Reason:      reason,
+		TriggeredBy: trigger,
+		Timestamp:   state.UpdatedAt,
+	})
+	if err != nil {
+		if err := se.effects.DropState(ctx, state); err != nil {
+			log.Printf("WARN: Failed to drop unrecorded state of user %s: %v", state.UserID, err)
+		}
+		return false, fmt.Errorf("failed to record state transition: %w", err)
+	}
+	return changed, nil
+}
+
+// CurrentState returns the user's state from the Redis cache. On a miss the
+// latest recorded transition is read back into the cache, with the user's
+// latest heartbeat; it returns nil if the user has no recorded state. A miss
+// while Redis may have lost its data allows for the longest interval.
+func (se *SafetyEvaluator) CurrentState(ctx context.Context, userID uuid.UUID) (*models.UserState, error) {
+	state, cacheErr := se.redis.GetUserState(ctx, userID)
+	if cacheErr == nil && state != nil {
+		return state, nil
+	}
+	if cacheErr != nil {
+		log.Printf("WARN: State cache unavailable for user %s, reading Postgres: %v", userID, cacheErr)
+	}
+
+	lost := cacheErr == nil && se.warmup.Detect(ctx)
+	state, err := restoredState(ctx, se.postgres, userID, se.cfg.Current(), lost)
+	if err != nil || state == nil {
+		return nil, err
+	}
+
+	// A state saved meanwhile is newer than the one rebuilt, and wins
+	if cacheErr == nil {
+		restored, err := se.redis.RestoreUserState(ctx, state)
+		if err != nil {
+			log.Printf("WARN: Failed to cache state for user %s: %v", userID, err)
+		} else if !restored {
+			if cached, err := se.redis.GetUserState(ctx, userID); err == nil && cached != nil {
+				return cached, nil
+			}
+		}
+	}
+	return state, nil
+}
+
+// lockUser waits for the user's evaluation lock and returns its release func
+func (se *SafetyEvaluator) lockUser(ctx context.Context, userID uuid.UUID) (func(), error) {
+	token := uuid.NewString()
+	for {
+		ok, err := se.redis.AcquireEvaluationLock(ctx, userID, token, evaluationLockTTL)
+		if err != nil {
+			return nil, fmt.Errorf("failed to acquire evaluation lock: %w", err)
+		}
+		if ok {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("waiting for evaluation lock: %w", ctx.Err())
+		case <-time.After(evaluationLockRetry):
+		}
+	}
+
+	return func() {
+		// Release even if ctx has expired, otherwise the lock lingers for its TTL
+		releaseCtx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		if err := se.redis.ReleaseEvaluationLock(releaseCtx, userID, token); err != nil {
+			log.Printf("WARN: Failed to release evaluation lock for user %s: %v", userID, err)
+		}
+	}, nil
+}
+
+// Assess computes a user's state from their latest heartbeat and any active
+// LastGasp at the evaluator's current time. It has no side effects.
+func (se *SafetyEvaluator) Assess(heartbeat *models.Heartbeat, lastGasp *models.LastGasp, profile ScoringProfile) *EvaluationResult {
+	now := se.clock.Now()
+
+	// A LastGasp is waited on until a heartbeat supersedes it or it expires,
+	// but a wait that goes on too long is AT_RISK well before then
+	if lastGasp != nil && lastGasp.SupersededAt == nil && lastGasp.ExpiryTs.After(now) {
+		waited := now.Sub(lastGasp.LastAt)
+		minutes := map[string]interface{}{"minutes": int(waited.Minutes())}
+		escalate := time.Duration(se.cfg.Current().LastGaspEscalateSeconds) * time.Second
+		if escalate > 0 && waited >= escalate {
+			result := &EvaluationResult{
+				State:         StateAtRisk,
+				Score:         profile.StaleScore,
+				RulesFired:    []string{RuleLastGaspProlonged},
+				Deterministic: true,
+			}
+			result.setReasons(models.Reason{Code: models.ReasonLastGaspProlonged, Params: minutes})
+			return result
+		}
+		result := &EvaluationResult{
+			State:         StateWaitLastGasp,
+			Score:         0,
+			RulesFired:    []string{RuleLastGaspActive},
+			Deterministic: true,
+		}
+		result.setReasons(models.Reason{Code: models.ReasonLastGaspActive, Params: minutes})
+		return result
 	}
 
 	if heartbeat == nil {
 		// No heartbeat data yet
-		return &EvaluationResult{
-			State:  StateSafe,
-			Score:  100,
-			Reason: "No heartbeat data yet",
-		}, nil
+		result := &EvaluationResult{
+			State:         StateSafe,
+			Score:         100,
+			RulesFired:    []string{RuleNoHeartbeat},
+			Deterministic: true,
+		}
+		result.setReasons(models.Reason{Code: models.ReasonNoHeartbeat})
+		return result
 	}
 
 	// Run deterministic checks first
-	deterministicResult := se.checkDeterministicRules(heartbeat)
-	if deterministicResult != nil {
-		return deterministicResult, nil
+	if result := se.checkDeterministicRules(heartbeat, now, profile); result != nil {
+		return result
 	}
 
 	// Calculate composite score
-	score := se.calculateSafetyScore(ctx, userID, heartbeat)
+	score, breakdown := se.calculateSafetyScore(heartbeat, now, profile)
 
 	// Map score to state
 	var state string
 	var reason string
 
 	switch {
-	case score >= 80:
+	case score >= profile.SafeThreshold:
 		state = StateSafe
-		reason = "All indicators normal"
-	case score >= 50:
+		reason = models.ReasonScoreNormal
+	case score >= profile.CautionThreshold:
 		state = StateCaution
-		reason = "Some indicators concerning - silent check initiated"
+		reason = models.ReasonScoreConcerning
 	default:
 		state = StateAtRisk
-		reason = "Multiple risk indicators detected"
+		reason = models.ReasonScoreAtRisk
 	}
 
-	result := &EvaluationResult{
-		State:  state,
-		Score:  score,
-		Reason: reason,
+	// Suspected spoofing lowers confidence but must not alert contacts on its own
+	if heartbeat.SpoofSuspected {
+		if state == StateAtRisk {
+			trusted := *heartbeat
+			trusted.SpoofSuspected = false
+			if full, _ := se.calculateSafetyScore(&trusted, now, profile); full >= profile.CautionThreshold {
+				state = StateCaution
+				reason = models.ReasonScoreConcerning
+			}
+		}
 	}
 
-	// Update state in Redis
-	userState := &models.UserState{
-		UserID:        userID,
-		State:         state,
-		Score:         score,
-		LastHeartbeat: heartbeat.Timestamp,
-		UpdatedAt:     time.Now(),
+	reasons := []models.Reason{{Code: reason}}
+	if heartbeat.SpoofSuspected {
+		reasons = append(reasons, models.Reason{Code: models.ReasonSpoofSuspected})
 	}
-	se.redis.SetUserState(ctx, userState)
-
-	// Handle state transitions
-	if err := se.handleStateTransition(ctx, userID, state, score, reason); err != nil {
-		return nil, fmt.Errorf("failed to handle state transition: %w", err)
+	if heartbeat.Connectivity == models.ConnectivityOfflineQueued {
+		reasons = append(reasons, models.Reason{Code: models.ReasonOfflineQueued})
 	}
 
-	return result, nil
+	result := &EvaluationResult{
+		State:        state,
+		Score:        score,
+		Breakdown:    breakdown,
+		SpoofReasons: heartbeat.SpoofReasons,
+	}
+	result.setReasons(reasons...)
+	return result
 }
 
 // checkDeterministicRules applies hard rules that override scoring
-func (se *SafetyEvaluator) checkDeterministicRules(hb *models.Heartbeat) *EvaluationResult {
+func (se *SafetyEvaluator) checkDeterministicRules(hb *models.Heartbeat, now time.Time, profile ScoringProfile) *EvaluationResult {
 	// Rule 1: Recent heartbeat within window
-	timeSinceHeartbeat := time.Since(hb.Timestamp)
-	if timeSinceHeartbeat < time.Duration(se.cfg.HeartbeatWindowSeconds)*time.Second {
+	timeSinceHeartbeat := now.Sub(hb.Timestamp)
+	if timeSinceHeartbeat < profile.heartbeatWindow() {
 		if hb.LastGasp {
 			// LastGasp received but recent - monitor
-			return &EvaluationResult{
-				State:  StateCaution,
-				Score:  60,
-				Reason: "LastGasp received - monitoring",
+			result := &EvaluationResult{
+				State:         StateCaution,
+				Score:         profile.LastGaspRecentScore,
+				RulesFired:    []string{RuleLastGaspRecent},
+				Deterministic: true,
 			}
+			result.setReasons(models.Reason{Code: models.ReasonLastGaspRecent})
+			return result
 		}
 		// Normal recent heartbeat
 		return nil // Continue to scoring
@@ -153,89 +1508,121 @@ func (se *SafetyEvaluator) checkDeterministicRules(hb *models.Heartbeat) *Evalua
 	}
 
 	// Rule 3: Heartbeat too old
-	if timeSinceHeartbeat > time.Duration(se.cfg.HeartbeatWindowSeconds)*time.Second {
+	if timeSinceHeartbeat > profile.heartbeatWindow() {
 		missedMinutes := int(timeSinceHeartbeat.Minutes())
-		return &EvaluationResult{
-			State:  StateAtRisk,
-			Score:  30,
-			Reason: fmt.Sprintf("No heartbeat for %d minutes", missedMinutes),
+		result := &EvaluationResult{
+			State:         StateAtRisk,
+			Score:         profile.StaleScore,
+			RulesFired:    []string{RuleHeartbeatStale},
+			Deterministic: true,
 		}
+		result.setReasons(models.Reason{Code: models.ReasonHeartbeatStale, Params: map[string]interface{}{"minutes": missedMinutes}})
+		return result
 	}
 
 	return nil
 }
 
-// calculateSafetyScore computes composite safety score (0-100)
-func (se *SafetyEvaluator) calculateSafetyScore(ctx context.Context, userID uuid.UUID, hb *models.Heartbeat) int {
-	score := 0
+// calculateSafetyScore computes composite safety score (0-100) and the
+// points each component contributed. Each component yields a fraction of
+// its weight in the profile.
+func (se *SafetyEvaluator) calculateSafetyScore(hb *models.Heartbeat, now time.Time, profile ScoringProfile) (int, map[string]int) {
+	w := profile.Weights
+	breakdown := make(map[string]int, 6)
+	points := func(component string, weight int, fraction float64) {
+		breakdown[component] = int(math.Round(float64(weight) * fraction))
+	}
+	// locationPoints pulls location-derived components toward neutral when the fix may be spoofed
+	locationPoints := func(component string, weight int, fraction float64) {
+		if hb.SpoofSuspected {
+			fraction = 0.5 + (fraction-0.5)*profile.SpoofConfidence
+		}
+		points(component, weight, fraction)
+	}
 
-	// Component 1: Heartbeat recency (30 points)
-	timeSinceHeartbeat := time.Since(hb.Timestamp)
-	recencyMinutes := timeSinceHeartbeat.Minutes()
-	
+	// Component 1: Heartbeat recency, allowing for an advised longer interval.
+	// A heartbeat queued offline shows the user was fine when it was
+	// recorded, not that the phone can reach us, so it earns none however
+	// fresh its timestamp.
+	age := profile.heartbeatAge(hb, now)
 	switch {
-	case recencyMinutes < 5:
-		score += 30
-	case recencyMinutes < 10:
-		score += 20
-	case recencyMinutes < 15:
-		score += 10
+	case hb.Connectivity == models.ConnectivityOfflineQueued:
+		points("recency", w.Recency, 0)
+	case age < recencySteps[0]:
+		points("recency", w.Recency, 1)
+	case age < recencySteps[1]:
+		points("recency", w.Recency, 2.0/3)
+	case age < recencySteps[2]:
+		points("recency", w.Recency, 1.0/3)
 	default:
-		score += 0
+		points("recency", w.Recency, 0)
 	}
 
-	// Component 2: GPS accuracy (20 points)
+	// Component 2: GPS accuracy
 	switch {
 	case hb.AccuracyM < 50:
-		score += 20
+		locationPoints("accuracy", w.Accuracy, 1)
 	case hb.AccuracyM < 200:
-		score += 15
+		locationPoints("accuracy", w.Accuracy, 0.75)
 	case hb.AccuracyM < 500:
-		score += 10
+		locationPoints("accuracy", w.Accuracy, 0.5)
 	default:
-		score += 5
+		locationPoints("accuracy", w.Accuracy, 0.25)
 	}
 
-	// Component 3: Movement pattern (20 points)
+	// Component 3: Movement pattern
 	// Check if speed is consistent with expected behavior
 	if hb.Speed != nil {
 		speed := *hb.Speed
 		switch {
 		case speed >= 0 && speed < 100: // Normal speed
-			score += 20
+			locationPoints("movement", w.Movement, 1)
 		case speed >= 100: // Unusually high speed
-			score += 10
+			locationPoints("movement", w.Movement, 0.5)
+		default:
+			locationPoints("movement", w.Movement, 0)
 		}
 	} else {
-		score += 15 // No speed data, neutral
+		locationPoints("movement", w.Movement, 0.75) // No speed data, neutral
 	}
 
-	// Component 4: Signal quality (10 points)
-	if hb.CellInfo.RSSI > -70 {
-		score += 10
-	} else if hb.CellInfo.RSSI > -90 {
-		score += 5
+	// Component 4: Signal quality
+	switch {
+	case hb.CellInfo.RSSI > -70:
+		points("signal", w.Signal, 1)
+	case hb.CellInfo.RSSI > -90:
+		points("signal", w.Signal, 0.5)
+	default:
+		points("signal", w.Signal, 0)
 	}
 
-	// Component 5: Source reliability (5 points)
-	if hb.Source == "http" {
-		score += 5
-	} else {
-		score += 3 // SMS fallback
+	// Component 5: Source reliability, by how strongly the heartbeat is bound to the user
+	switch HeartbeatTrust(hb) {
+	case models.TrustVerifiedDevice:
+		points("source", w.Source, 1)
+	case models.TrustVerifiedPhone:
+		points("source", w.Source, 0.6) // SMS fallback
+	default:
+		points("source", w.Source, 0.2)
 	}
 
-	// Component 6: Battery level (15 points)
+	// Component 6: Battery level
 	if hb.BatteryPct != nil {
 		switch {
 		case *hb.BatteryPct > 20:
-			score += 15
+			points("battery", w.Battery, 1)
 		case *hb.BatteryPct > 5:
-			score += 10
+			points("battery", w.Battery, 2.0/3)
 		default:
-			score += 5
+			points("battery", w.Battery, 1.0/3)
 		}
 	} else {
-		score += 10 // Unknown, neutral
+		points("battery", w.Battery, 2.0/3) // Unknown, neutral
+	}
+
+	score := 0
+	for _, p := range breakdown {
+		score += p
 	}
 
 	// Ensure score is within bounds
@@ -246,28 +1633,93 @@ func (se *SafetyEvaluator) calculateSafetyScore(ctx context.Context, userID uuid
 		score = 0
 	}
 
-	return score
+	return score, breakdown
 }
 
-// handleStateTransition creates alerts and triggers notifications
-func (se *SafetyEvaluator) handleStateTransition(ctx context.Context, userID uuid.UUID, newState string, score int, reason string) error {
-	// Get previous state
-	prevState, err := se.redis.GetUserState(ctx, userID)
-	if err != nil {
+// liveEffects records transitions in Postgres, caches state in Redis and raises alerts
+type liveEffects struct {
+	se *SafetyEvaluator
+}
+
+func (e liveEffects) RecordTransition(ctx context.Context, transition *models.StateTransition) (bool, error) {
+	return e.se.postgres.RecordStateTransition(ctx, transition)
+}
+
+func (e liveEffects) SaveState(ctx context.Context, state *models.UserState) error {
+	if err := e.se.redis.SaveUserState(ctx, state); err != nil {
 		return err
 	}
+	e.se.escalatePrecision(ctx, state)
+	return nil
+}
+
+func (e liveEffects) DropState(ctx context.Context, state *models.UserState) error {
+	return e.se.redis.DropUserState(ctx, state)
+}
+
+func (e liveEffects) HandleTransition(ctx context.Context, userID uuid.UUID, state string, score int, reasons []models.Reason, changed bool) error {
+	return e.se.handleStateTransition(ctx, userID, state, score, reasons, changed)
+}
+
+func (e liveEffects) RecordScore(ctx context.Context, record *models.ScoreRecord) error {
+	return e.se.postgres.InsertScoreRecord(ctx, record)
+}
+
+func (e liveEffects) ScheduleCheck(ctx context.Context, userID uuid.UUID, due time.Time) error {
+	if due.IsZero() {
+		return e.se.redis.UnscheduleCheck(ctx, userID)
+	}
+	return e.se.redis.ScheduleCheck(ctx, userID, due)
+}
+
+// discardEffects drops every side effect (sandboxed evaluation)
+func (e liveEffects) RecordDiagnostic(ctx context.Context, userID uuid.UUID, diag *models.EvaluationDiagnostic) error {
+	return e.se.redis.RecordEvaluationDiagnostic(ctx, userID, diag, diagnosticEvaluations)
+}
+
+type discardEffects struct{}
+
+func (discardEffects) RecordTransition(context.Context, *models.StateTransition) (bool, error) {
+	return false, nil
+}
+
+func (discardEffects) SaveState(context.Context, *models.UserState) error { return nil }
 
+func (discardEffects) DropState(context.Context, *models.UserState) error { return nil }
+
+func (discardEffects) HandleTransition(context.Context, uuid.UUID, string, int, []models.Reason, bool) error {
+	return nil
+}
+
+func (discardEffects) RecordScore(context.Context, *models.ScoreRecord) error { return nil }
+
+func (discardEffects) ScheduleCheck(context.Context, uuid.UUID, time.Time) error { return nil }
+
+func (discardEffects) RecordDiagnostic(context.Context, uuid.UUID, *models.EvaluationDiagnostic) error {
+	return nil
+}
+
+// handleStateTransition creates alerts and triggers notifications. changed
+// reports whether the state differs from the user's last recorded one.
+func (se *SafetyEvaluator) handleStateTransition(ctx context.Context, userID uuid.UUID, newState string, score int, reasons []models.Reason, changed bool) error {
 	// Only act on state changes or critical states
-	if prevState != nil && prevState.State == newState && newState != StateAlert {
+	if !changed && newState != StateAlert {
 		return nil // No change, no action needed
 	}
 
 	// Check if alert was recently sent (deduplication)
 	if newState == StateAtRisk || newState == StateAlert {
-		alreadySent, err := se.redis.CheckAlertSent(ctx, userID, 5*time.Minute)
+		alreadySent, err := se.redis.CheckAlertSent(ctx, userID, alertDedupWindow)
 		if err != nil {
 			return err
 		}
+		// Redis may have lost the dedup key with the rest of its data, so a
+		// missing one is unknown: the latest alert in Postgres decides
+		if !alreadySent && se.warmup.InWindow() {
+			if alreadySent, err = se.warmup.AlertSentRecently(ctx, userID); err != nil {
+				return err
+			}
+		}
 		if alreadySent {
 			return nil // Don't spam alerts
 		}
@@ -276,8 +1728,9 @@ func (se *SafetyEvaluator) handleStateTransition(ctx context.Context, userID uui
 	// Handle state-specific actions
 	switch newState {
 	case StateCaution:
-		// Silent ping - would trigger FCM notification to user
-		// Implemented in alert engine
+		// Ask the user to check in; the prompt monitor escalates them if
+		// every attempt goes unanswered
+		se.startPrompt(ctx, userID)
 		return nil
 
 	case StateAtRisk, StateAlert:
@@ -287,58 +1740,120 @@ func (se *SafetyEvaluator) handleStateTransition(ctx context.Context, userID uui
 			UserID:    userID,
 			State:     models.AlertState(newState),
 			Score:     score,
-			Reason:    reason,
+			Reason:    RenderReasons(reasons),
+			Reasons:   reasons,
 			SentTo:    []string{},
-			CreatedAt: time.Now(),
+			CreatedAt: se.clock.Now(),
 		}
 
-		if err := se.postgres.CreateAlert(ctx, alert); err != nil {
+		if err := se.createAlert(ctx, alert); err != nil {
 			return fmt.Errorf("failed to create alert: %w", err)
 		}
 
-		// Get user details for notification
-		user, err := se.postgres.GetUserByID(ctx, userID)
-		if err != nil {
-			return fmt.Errorf("failed to get user: %w", err)
-		}
+		return se.dispatchAlert(ctx, alert, ChannelEventAlert)
+	}
 
-		if user == nil {
-			return fmt.Errorf("user not found: %s", userID)
-		}
+	return nil
+}
 
-		// Get latest heartbeat for location
-		hb, err := se.postgres.GetLatestHeartbeat(ctx, userID)
-		if err != nil {
-			return err
-		}
+// startPrompt opens a check-in prompt for a user who just moved to CAUTION,
+// on their own ladder and timeout. If it can't be opened the user is left to
+// their score, as if they hadn't answered.
+func (se *SafetyEvaluator) startPrompt(ctx context.Context, userID uuid.UUID) {
+	user, err := se.postgres.GetUserByID(ctx, userID)
+	if err != nil || user == nil {
+		log.Printf("WARN: Check-in prompt not started for user %s: %v", userID, err)
+		return
+	}
+	now := se.clock.Now()
+	session := &models.PromptSession{
+		ID:        uuid.New(),
+		UserID:    userID,
+		Ladder:    PromptLadder(user.Settings),
+		Timeout:   promptTimeout(user.Settings, se.cfg.Current()),
+		StartedAt: now,
+		Due:       now,
+	}
+	if _, err := se.redis.StartPromptSession(ctx, session); err != nil {
+		log.Printf("WARN: Check-in prompt not started for user %s: %v", userID, err)
+	}
+}
 
-		// Send alerts to trusted contacts
-		go func() {
-			ctx := context.Background()
-			if err := se.alerter.SendAlertToContacts(ctx, user, hb, score, reason); err != nil {
-				// Log error (in production, use proper logging)
-				fmt.Printf("Failed to send alerts: %v\n", err)
-			}
+// createAlert stores a new alert, stamped with when the evaluation that
+// raised it was requested; one raised outside an evaluation, e.g. by a
+// panic, was detected when it was created. An alert raised by a panic
+// carries its note.
+func (se *SafetyEvaluator) createAlert(ctx context.Context, alert *models.Alert) error {
+	alert.Note = alertNote(ctx)
+	alert.DetectedAt = alert.CreatedAt
+	if requested, ok := ctx.Value(evaluationRequestedKey{}).(time.Time); ok && requested.Before(alert.CreatedAt) {
+		alert.DetectedAt = requested
+	}
+	return se.postgres.CreateAlert(ctx, alert)
+}
+
+// dispatchAlert queues an alert for the user's trusted contacts and
+// notification channels on the alert outbox. Must be called with the
+// evaluation lock held.
+func (se *SafetyEvaluator) dispatchAlert(ctx context.Context, alert *models.Alert, event string) error {
+	// Get user details for notification
+	user, err := se.postgres.GetUserByID(ctx, alert.UserID)
+	if err != nil {
+		return fmt.Errorf("failed to get user: %w", err)
+	}
+
+	if user == nil {
+		return fmt.Errorf("user not found: %s", alert.UserID)
+	}
+
+	// Get latest heartbeat for location
+	hb, err := se.postgres.GetLatestHeartbeat(ctx, alert.UserID)
+	if err != nil {
+		return err
+	}
+	se.RestoreLocation(ctx, hb)
+
+	// Record where the alert was raised; the what3words address follows
+	// later. An alert sent again keeps where it was raised.
+	if hb != nil && alert.PlusCode == nil {
+		code := se.locations.AnnotateAlert(ctx, alert.ID, hb.Lat, hb.Lng)
+		alert.PlusCode = &code
+	}
 
-			// Mark alert as sent
-			se.redis.MarkAlertSent(ctx, userID, 5*time.Minute)
-		}()
+	// Mark the alert as sent before sending, while the evaluation lock is
+	// held, so the next evaluation sees it even if delivery is still running
+	if err := se.redis.MarkAlertSent(ctx, alert.UserID, alertDedupWindow); err != nil {
+		return fmt.Errorf("failed to mark alert sent: %w", err)
 	}
 
+	se.outbox.Enqueue(ctx, event, user, alert, hb)
 	return nil
 }
 
+// RedispatchAlert sends an alert that reached nobody to the user's contacts
+// and channels again, with the user's current contacts and latest heartbeat
+func (se *SafetyEvaluator) RedispatchAlert(ctx context.Context, alert *models.Alert) error {
+	unlock, err := se.lockUser(ctx, alert.UserID)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	return se.dispatchAlert(ctx, alert, ChannelEventAlert)
+}
+
 // DetectSuddenStop checks for sudden deceleration between heartbeats
 func (se *SafetyEvaluator) DetectSuddenStop(ctx context.Context, userID uuid.UUID) (bool, error) {
 	// Get last 2 heartbeats
-	since := time.Now().Add(-5 * time.Minute)
+	since := se.clock.Now().Add(-5 * time.Minute)
 	heartbeats, err := se.postgres.GetHeartbeatsSince(ctx, userID, since)
-	if err != nil || len(heartbeats) < 2 {
+	if err != nil {
 		return false, err
 	}
-
-	latest := heartbeats[0]
-	previous := heartbeats[1]
+	latest, previous, ok := latestPair(heartbeats)
+	if !ok {
+		return false, nil
+	}
 
 	// Check if both have speed data
 	if latest.Speed == nil || previous.Speed == nil {
@@ -351,7 +1866,7 @@ func (se *SafetyEvaluator) DetectSuddenStop(ctx context.Context, userID uuid.UUI
 		if timeDiff < 60 { // Within 60 seconds
 			// Calculate deceleration
 			deceleration := (*previous.Speed - *latest.Speed) / 3.6 / timeDiff // m/s²
-			if deceleration > 6 { // > 6 m/s² is concerning
+			if deceleration > 6 {                                              // > 6 m/s² is concerning
 				return true, nil
 			}
 		}
@@ -363,17 +1878,22 @@ func (se *SafetyEvaluator) DetectSuddenStop(ctx context.Context, userID uuid.UUI
 // DetectTowerJump checks for suspicious cell tower changes
 func (se *SafetyEvaluator) DetectTowerJump(ctx context.Context, userID uuid.UUID) (bool, error) {
 	// Get last 2 heartbeats
-	since := time.Now().Add(-5 * time.Minute)
+	since := se.clock.Now().Add(-5 * time.Minute)
 	heartbeats, err := se.postgres.GetHeartbeatsSince(ctx, userID, since)
-	if err != nil || len(heartbeats) < 2 {
+	if err != nil {
 		return false, err
 	}
+	latest, previous, ok := latestPair(heartbeats)
+	if !ok {
+		return false, nil
+	}
 
-	latest := heartbeats[0]
-	previous := heartbeats[1]
-
-	// Check if cell IDs are different
-	if latest.CellInfo.CID == previous.CellInfo.CID {
+	// Only a move between two known, different cells of the countries served
+	// counts; a roaming phone's cells can't be placed well enough to judge a jump
+	if latest.CellInfo.CID == 0 || previous.CellInfo.CID == 0 || SameCell(latest.CellInfo, previous.CellInfo) {
+		return false, nil
+	}
+	if OutsideDeployment(latest.CellInfo) || OutsideDeployment(previous.CellInfo) {
 		return false, nil
 	}
 
@@ -393,6 +1913,22 @@ func (se *SafetyEvaluator) DetectTowerJump(ctx context.Context, userID uuid.UUID
 	return false, nil
 }
 
+// latestPair returns the newest heartbeat (of heartbeats, newest first) and
+// the one before it from the same device. Two devices' heartbeats differ in
+// speed, cell and position without the user having moved.
+func latestPair(heartbeats []models.Heartbeat) (models.Heartbeat, models.Heartbeat, bool) {
+	if len(heartbeats) == 0 {
+		return models.Heartbeat{}, models.Heartbeat{}, false
+	}
+	latest := heartbeats[0]
+	for _, hb := range heartbeats[1:] {
+		if hb.DeviceID == latest.DeviceID {
+			return latest, hb, true
+		}
+	}
+	return models.Heartbeat{}, models.Heartbeat{}, false
+}
+
 // haversineDistance calculates distance between two GPS coordinates in km
 func haversineDistance(lat1, lon1, lat2, lon2 float64) float64 {
 	const R = 6371 // Earth radius in km
@@ -0,0 +1,85 @@
+package services
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+)
+
+// ValidateGeofence checks that a geofence is well-formed
+func ValidateGeofence(g models.Geofence) error {
+	switch g.Type {
+	case "radius":
+		if g.Center == nil {
+			return fmt.Errorf("radius geofence requires center")
+		}
+		if g.RadiusM <= 0 || g.RadiusM > 200000 {
+			return fmt.Errorf("radius_m must be between 0 and 200000")
+		}
+		return validatePoint(*g.Center)
+	case "polygon":
+		if len(g.Points) < 3 {
+			return fmt.Errorf("polygon geofence requires at least 3 points")
+		}
+		for _, p := range g.Points {
+			if err := validatePoint(p); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("geofence type must be radius or polygon")
+}
+
+// GeofenceContains reports whether a coordinate lies inside the geofence
+func GeofenceContains(g models.Geofence, lat, lng float64) bool {
+	switch g.Type {
+	case "radius":
+		return haversineDistance(g.Center.Lat, g.Center.Lng, lat, lng)*1000 <= g.RadiusM
+	case "polygon":
+		return pointInPolygon(g.Points, lat, lng)
+	}
+	return false
+}
+
+// GeofenceBounds returns a bounding box (minLat, maxLat, minLng, maxLng) covering the geofence
+func GeofenceBounds(g models.Geofence) (float64, float64, float64, float64) {
+	if g.Type == "radius" {
+		dLat := g.RadiusM / 111320
+		dLng := g.RadiusM / (111320 * math.Max(math.Cos(g.Center.Lat*math.Pi/180), 0.01))
+		return g.Center.Lat - dLat, g.Center.Lat + dLat, g.Center.Lng - dLng, g.Center.Lng + dLng
+	}
+
+	minLat, maxLat := math.Inf(1), math.Inf(-1)
+	minLng, maxLng := math.Inf(1), math.Inf(-1)
+	for _, p := range g.Points {
+		minLat = math.Min(minLat, p.Lat)
+		maxLat = math.Max(maxLat, p.Lat)
+		minLng = math.Min(minLng, p.Lng)
+		maxLng = math.Max(maxLng, p.Lng)
+	}
+	return minLat, maxLat, minLng, maxLng
+}
+
+// pointInPolygon uses ray casting; adequate for the small, non-antimeridian areas we handle
+func pointInPolygon(points []models.GeoPoint, lat, lng float64) bool {
+	inside := false
+	j := len(points) - 1
+	for i := range points {
+		pi, pj := points[i], points[j]
+		if (pi.Lat > lat) != (pj.Lat > lat) &&
+			lng < (pj.Lng-pi.Lng)*(lat-pi.Lat)/(pj.Lat-pi.Lat)+pi.Lng {
+			inside = !inside
+		}
+		j = i
+	}
+	return inside
+}
+
+func validatePoint(p models.GeoPoint) error {
+	if p.Lat < -90 || p.Lat > 90 || p.Lng < -180 || p.Lng > 180 {
+		return fmt.Errorf("coordinate out of range: %.6f, %.6f", p.Lat, p.Lng)
+	}
+	return nil
+}
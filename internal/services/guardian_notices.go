@@ -0,0 +1,316 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/config"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/database"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/scheduler"
+)
+
+const (
+	guardianNoticeWorker  = "guardian_notices"
+	guardianNoticeEvery   = 5 * time.Minute
+	guardianNoticeTimeout = 4 * time.Minute
+	guardianNoticeBatch   = 200
+
+	// stationaryRadiusM is how far fixes may wander, GPS drift included,
+	// while the ward counts as not moving
+	stationaryRadiusM = 200
+	// stationaryLookback is how far past a stationary threshold heartbeats
+	// are read to find when the stillness began
+	stationaryLookback = 6 * time.Hour
+	// stationaryFreshness is how recent the ward's latest fix must be for
+	// the stationary rule to judge it; an older one is the silent rule's
+	stationaryFreshness = 2 * time.Hour
+	// stationaryMaxHeartbeats bounds the heartbeats read for the stationary rule
+	stationaryMaxHeartbeats = 2000
+
+	guardianNoticeTitle = "SafeTrace update"
+	guardianDigestTitle = "SafeTrace daily update"
+)
+
+// ValidateGuardianRules checks rules a guardian sets
+func ValidateGuardianRules(r *models.GuardianRules) error {
+	if r.MaxSilentHours != nil && (*r.MaxSilentHours < 1 || *r.MaxSilentHours > 72) {
+		return fmt.Errorf("max_silent_hours must be between 1 and 72")
+	}
+	if r.MaxStationaryHours != nil && (*r.MaxStationaryHours < 1 || *r.MaxStationaryHours > 24) {
+		return fmt.Errorf("max_stationary_hours must be between 1 and 24")
+	}
+	switch r.Channel {
+	case models.GuardianChannelPush, models.GuardianChannelSMS:
+	default:
+		return fmt.Errorf("channel must be push or sms")
+	}
+	return nil
+}
+
+// GuardianNotices tells guardians of elderly or disabled wards about
+// anomalies smaller than alerts, by the rules they set on their link: the
+// ward's phone hasn't reported for max_silent_hours, or the ward hasn't
+// moved for max_stationary_hours, and an opt-in evening digest when all was
+// well. Notices are informational: they go only to the guardian, on their
+// preferred channel, worded as not an alert, and never touch the alert
+// pipeline or its dedup keys. Each rule notifies an episode (one silence,
+// one stretch without moving) once, and notices of a rule are at least
+// GUARDIAN_NOTICE_COOLDOWN_HOURS apart. Nothing is sent about a ward who
+// paused protection or has an alert open.
+type GuardianNotices struct {
+	cfg       *config.Store
+	postgres  *database.PostgresDB
+	notifier  Notifier
+	audit     *AuditLogger
+	scheduler *scheduler.Scheduler
+}
+
+func NewGuardianNotices(cfg *config.Store, postgres *database.PostgresDB, notifier Notifier, audit *AuditLogger, scheduler *scheduler.Scheduler) *GuardianNotices {
+	return &GuardianNotices{
+		cfg:       cfg,
+		postgres:  postgres,
+		notifier:  notifier,
+		audit:     audit,
+		scheduler: scheduler,
+	}
+}
+
+// Start schedules rule evaluation on one instance at a time
+func (s *GuardianNotices) Start() {
+	s.scheduler.MustRegister(scheduler.Job{
+		Name:      guardianNoticeWorker,
+		Every:     guardianNoticeEvery,
+		Singleton: true,
+		Timeout:   guardianNoticeTimeout,
+		Run:       s.evaluate,
+	})
+}
+
+// evaluate checks the rules of every active link that has one on
+func (s *GuardianNotices) evaluate(ctx context.Context) error {
+	cfg := s.cfg.Current()
+	now := time.Now()
+	after := uuid.Nil
+	for {
+		targets, err := s.postgres.GetGuardianRuleTargets(ctx, after, guardianNoticeBatch)
+		if err != nil {
+			return fmt.Errorf("failed to list guardian rules: %w", err)
+		}
+		for i := range targets {
+			if err := s.check(ctx, &targets[i], now, cfg); err != nil {
+				log.Printf("ERROR: Failed to check the guardian rules of link %s: %v", targets[i].LinkID, err)
+			}
+		}
+		if len(targets) < guardianNoticeBatch {
+			return nil
+		}
+		after = targets[len(targets)-1].LinkID
+	}
+}
+
+// check evaluates one link's rules against the ward's heartbeats
+func (s *GuardianNotices) check(ctx context.Context, t *models.GuardianRuleTarget, now time.Time, cfg *config.Config) error {
+	pause, err := s.postgres.GetActiveProtectionPause(ctx, t.WardUserID, now)
+	if err != nil {
+		return fmt.Errorf("failed to check protection pause: %w", err)
+	}
+	if pause != nil {
+		return nil
+	}
+	alert, err := s.postgres.GetLatestAlert(ctx, t.WardUserID)
+	if err != nil {
+		return fmt.Errorf("failed to get latest alert: %w", err)
+	}
+	if alert != nil && alert.ResolvedAt == nil {
+		return nil
+	}
+	last, err := s.postgres.GetLatestHeartbeat(ctx, t.WardUserID)
+	if err != nil {
+		return fmt.Errorf("failed to get latest heartbeat: %w", err)
+	}
+	if last == nil {
+		return nil
+	}
+
+	cooldown := time.Duration(cfg.GuardianNoticeCooldownHours) * time.Hour
+	if t.MaxSilentHours != nil && now.Sub(last.Timestamp) >= time.Duration(*t.MaxSilentHours)*time.Hour {
+		hours := int(now.Sub(last.Timestamp).Hours())
+		body := fmt.Sprintf("Not an alert. %s's phone hasn't reported since %s (%d %s). It may be switched off or out of battery.",
+			firstName(t.WardName), FormatInUserZone(last.Timestamp, t.WardSettings, "Jan 2, 3:04 PM"), hours, plural(hours, "hour", "hours"))
+		s.notice(ctx, t, models.GuardianRuleSilent, last.Timestamp, now, cooldown, body)
+	}
+	if t.MaxStationaryHours != nil && now.Sub(last.Timestamp) < stationaryFreshness {
+		if err := s.checkStationary(ctx, t, now, cooldown); err != nil {
+			return err
+		}
+	}
+	if t.DailyDigest {
+		if err := s.checkDigest(ctx, t, alert, now, cfg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkStationary notifies when the ward's fixes have stayed in one place
+// for max_stationary_hours. A notice sent since the stillness began was
+// about it, so it isn't notified again, even once it is older than the
+// heartbeats read.
+func (s *GuardianNotices) checkStationary(ctx context.Context, t *models.GuardianRuleTarget, now time.Time, cooldown time.Duration) error {
+	threshold := time.Duration(*t.MaxStationaryHours) * time.Hour
+	heartbeats, err := s.postgres.GetHeartbeatsBetween(ctx, t.WardUserID, now.Add(-threshold-stationaryLookback), now, stationaryMaxHeartbeats, false)
+	if err != nil {
+		return fmt.Errorf("failed to get heartbeats: %w", err)
+	}
+	since, ok := StillSince(heartbeats, stationaryRadiusM)
+	if !ok || now.Sub(since) < threshold {
+		return nil
+	}
+	if t.StationaryNotifiedAt != nil && t.StationaryNotifiedAt.After(since) {
+		return nil
+	}
+
+	hours := int(now.Sub(since).Hours())
+	body := fmt.Sprintf("Not an alert. %s hasn't moved since %s (%d %s), staying within %d m of one place.",
+		firstName(t.WardName), FormatInUserZone(since, t.WardSettings, "Jan 2, 3:04 PM"), hours, plural(hours, "hour", "hours"), stationaryRadiusM)
+	s.notice(ctx, t, models.GuardianRuleStationary, since, now, cooldown, body)
+	return nil
+}
+
+// checkDigest sends the daily digest from GUARDIAN_DIGEST_HOUR in the ward's
+// time zone, if the ward's phone reported that day and nothing happened: no
+// alert raised and no notice sent since local midnight. A day that wasn't
+// all good gets no digest; the guardian has heard about it already.
+func (s *GuardianNotices) checkDigest(ctx context.Context, t *models.GuardianRuleTarget, alert *models.Alert, now time.Time, cfg *config.Config) error {
+	local := now.In(UserLocation(t.WardSettings))
+	if local.Hour() < cfg.GuardianDigestHour {
+		return nil
+	}
+	day := local.Format("2006-01-02")
+	if t.DigestSentOn == day {
+		return nil
+	}
+	midnight := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, local.Location())
+	if alert != nil && !alert.CreatedAt.Before(midnight) {
+		return nil
+	}
+	for _, notified := range []*time.Time{t.SilentNotifiedAt, t.StationaryNotifiedAt} {
+		if notified != nil && !notified.Before(midnight) {
+			return nil
+		}
+	}
+	counts, err := s.postgres.CountHeartbeatsByTrust(ctx, t.WardUserID, midnight)
+	if err != nil {
+		return fmt.Errorf("failed to count heartbeats: %w", err)
+	}
+	reported := 0
+	for _, n := range counts {
+		reported += n
+	}
+	if reported == 0 {
+		return nil
+	}
+
+	claimed, err := s.postgres.ClaimGuardianDigest(ctx, t.LinkID, day)
+	if err != nil || !claimed {
+		return err
+	}
+	body := fmt.Sprintf("All good with %s today: their phone reported %d %s and nothing unusual happened.",
+		firstName(t.WardName), reported, plural(reported, "time", "times"))
+	s.deliver(ctx, t, models.GuardianRuleDigest, guardianDigestTitle, body)
+	return nil
+}
+
+// notice claims a rule's notice about an episode and sends it. A notice
+// already sent about the episode, or one within the cooldown, isn't.
+func (s *GuardianNotices) notice(ctx context.Context, t *models.GuardianRuleTarget, rule string, episode, now time.Time, cooldown time.Duration, body string) {
+	claimed, err := s.postgres.ClaimGuardianNotice(ctx, t.LinkID, rule, episode, now, cooldown)
+	if err != nil {
+		log.Printf("ERROR: Failed to claim the %s notice of link %s: %v", rule, t.LinkID, err)
+		return
+	}
+	if !claimed {
+		return
+	}
+	switch rule {
+	case models.GuardianRuleSilent:
+		t.SilentEpisode, t.SilentNotifiedAt = &episode, &now
+	case models.GuardianRuleStationary:
+		t.StationaryEpisode, t.StationaryNotifiedAt = &episode, &now
+	}
+	s.deliver(ctx, t, rule, guardianNoticeTitle, body)
+}
+
+// deliver sends a notice on the guardian's channel, by SMS when they chose
+// it or have no push token, and records it in the audit log. A failed send
+// is logged and not retried; the notice isn't worth repeating later.
+func (s *GuardianNotices) deliver(ctx context.Context, t *models.GuardianRuleTarget, rule, title, body string) {
+	channel := t.Channel
+	var token string
+	if channel == models.GuardianChannelPush {
+		var err error
+		if token, err = s.postgres.GetPushToken(ctx, t.GuardianUserID); err != nil {
+			log.Printf("WARN: Failed to get the push token of guardian %s, texting instead: %v", t.GuardianUserID, err)
+		}
+		if token == "" {
+			channel = models.GuardianChannelSMS
+		}
+	}
+	var err error
+	if channel == models.GuardianChannelPush {
+		err = s.notifier.SendPushNotification(ctx, token, title, body)
+	} else {
+		err = s.notifier.SendSMS(ctx, MessageGuardian, t.GuardianPhone, title+": "+body)
+	}
+
+	metadata := map[string]interface{}{"rule": rule, "channel": channel, "guardian_id": t.GuardianUserID.String()}
+	if err != nil {
+		log.Printf("WARN: Guardian %s notice of link %s not sent: %v", rule, t.LinkID, err)
+		metadata["error"] = err.Error()
+	}
+	wardID := t.WardUserID
+	s.audit.Record(&models.AuditEvent{
+		ActorRole:     "system",
+		Action:        AuditGuardianNotice,
+		ObjectType:    "account_link",
+		ObjectID:      t.LinkID.String(),
+		SubjectUserID: &wardID,
+		Metadata:      metadata,
+	})
+}
+
+// StillSince classifies the movement at the end of heartbeats, oldest
+// first: it returns when the latest stretch of fixes within radiusM of the
+// latest one began. A fix reporting walking speed or faster ends the
+// stretch. Fixes that are coarse, less accurate than summaryMaxAccuracyM,
+// mocked or suspected of spoofing are left out. A stretch reaching back to
+// the first fix may have begun earlier. ok is false when there is no fix
+// to judge.
+func StillSince(heartbeats []models.Heartbeat, radiusM float64) (since time.Time, ok bool) {
+	var anchor *models.Heartbeat
+	for i := len(heartbeats) - 1; i >= 0; i-- {
+		hb := &heartbeats[i]
+		if !stillnessFix(hb) {
+			continue
+		}
+		if anchor == nil {
+			anchor = hb
+		}
+		moving := hb.Speed != nil && *hb.Speed >= movingSpeed
+		if moving || haversineDistance(anchor.Lat, anchor.Lng, hb.Lat, hb.Lng)*1000 > radiusM {
+			return since, !since.IsZero() // not if the latest fix is moving
+		}
+		since = hb.Timestamp
+	}
+	return since, anchor != nil
+}
+
+// stillnessFix reports whether a heartbeat's fix can tell where the ward is
+func stillnessFix(hb *models.Heartbeat) bool {
+	return !(hb.Lat == 0 && hb.Lng == 0) && !hb.Coarse && hb.AccuracyM <= summaryMaxAccuracyM && !hb.IsMock && !hb.SpoofSuspected
+}
@@ -0,0 +1,318 @@
+package services
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/config"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/database"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+)
+
+func TestValidateGuardianRules(t *testing.T) {
+	hours := func(n int) *int { return &n }
+	tests := []struct {
+		name  string
+		rules models.GuardianRules
+		want  string
+	}{
+		{"silent and stationary", models.GuardianRules{MaxSilentHours: hours(12), MaxStationaryHours: hours(4), Channel: models.GuardianChannelPush}, ""},
+		{"digest only", models.GuardianRules{DailyDigest: true, Channel: models.GuardianChannelSMS}, ""},
+		{"bounds", models.GuardianRules{MaxSilentHours: hours(72), MaxStationaryHours: hours(1), Channel: models.GuardianChannelSMS}, ""},
+		{"silent too short", models.GuardianRules{MaxSilentHours: hours(0), Channel: models.GuardianChannelPush}, "max_silent_hours"},
+		{"silent too long", models.GuardianRules{MaxSilentHours: hours(73), Channel: models.GuardianChannelPush}, "max_silent_hours"},
+		{"stationary too long", models.GuardianRules{MaxStationaryHours: hours(25), Channel: models.GuardianChannelPush}, "max_stationary_hours"},
+		{"no channel", models.GuardianRules{DailyDigest: true}, "channel"},
+		{"alert channel", models.GuardianRules{DailyDigest: true, Channel: "voice"}, "channel"},
+	}
+	for _, tt := range tests {
+		err := ValidateGuardianRules(&tt.rules)
+		if tt.want == "" && err != nil || tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)) {
+			t.Errorf("%s: ValidateGuardianRules = %v, want %q", tt.name, err, tt.want)
+		}
+	}
+}
+
+// stillFix is a heartbeat at the given minute, metres north of the first
+func stillFix(minute int, northM float64) models.Heartbeat {
+	hb := simulatedHeartbeat(time.Duration(minute)*time.Minute, false)
+	hb.Lat += northM / 111_195
+	return hb
+}
+
+func TestStillSince(t *testing.T) {
+	at := func(minute int) time.Time { return simulationStart.Add(time.Duration(minute) * time.Minute) }
+	moving := func(hb models.Heartbeat) models.Heartbeat {
+		speed := 30.0
+		hb.Speed = &speed
+		return hb
+	}
+	with := func(hb models.Heartbeat, change func(*models.Heartbeat)) models.Heartbeat {
+		change(&hb)
+		return hb
+	}
+
+	tests := []struct {
+		name       string
+		heartbeats []models.Heartbeat
+		want       time.Time
+		wantOK     bool
+	}{
+		{"none", nil, time.Time{}, false},
+		{"one fix", []models.Heartbeat{stillFix(0, 0)}, at(0), true},
+		{"drifting within the radius", []models.Heartbeat{stillFix(0, 0), stillFix(20, 150), stillFix(40, -40), stillFix(60, 10)}, at(0), true},
+		{"arrived", []models.Heartbeat{stillFix(0, 5000), stillFix(20, 1200), stillFix(40, 100), stillFix(60, 0)}, at(40), true},
+		{"left and came back", []models.Heartbeat{stillFix(0, 0), stillFix(20, 900), stillFix(40, 0), stillFix(60, 0)}, at(40), true},
+		{"moving before", []models.Heartbeat{stillFix(0, 0), moving(stillFix(20, 0)), stillFix(40, 0)}, at(40), true},
+		{"moving now", []models.Heartbeat{stillFix(0, 0), stillFix(20, 0), moving(stillFix(40, 0))}, time.Time{}, false},
+		{"unusable fixes left out", []models.Heartbeat{
+			stillFix(0, 0),
+			with(stillFix(20, 3000), func(hb *models.Heartbeat) { hb.Coarse = true }),
+			with(stillFix(30, 3000), func(hb *models.Heartbeat) { hb.AccuracyM = 500 }),
+			with(stillFix(40, 3000), func(hb *models.Heartbeat) { hb.IsMock = true }),
+			with(stillFix(50, 3000), func(hb *models.Heartbeat) { hb.SpoofSuspected = true }),
+			with(stillFix(55, 0), func(hb *models.Heartbeat) { hb.Lat, hb.Lng = 0, 0 }),
+			stillFix(60, 0),
+		}, at(0), true},
+		{"no usable fix", []models.Heartbeat{with(stillFix(0, 0), func(hb *models.Heartbeat) { hb.IsMock = true })}, time.Time{}, false},
+	}
+	for _, tt := range tests {
+		since, ok := StillSince(tt.heartbeats, stationaryRadiusM)
+		if ok != tt.wantOK || !since.Equal(tt.want) {
+			t.Errorf("%s: StillSince = %v, %t; want %v, %t", tt.name, since, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+// guardianNotifier records guardian notices. It has only the methods a
+// notice may use: anything of the alert pipeline panics.
+type guardianNotifier struct {
+	Notifier // nil
+
+	mu  sync.Mutex
+	sms []string
+}
+
+func (n *guardianNotifier) SendSMS(ctx context.Context, kind, to, message string) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if kind != MessageGuardian {
+		panic("guardian notice sent as " + kind)
+	}
+	n.sms = append(n.sms, message)
+	return nil
+}
+
+// sent returns the notices texted since it was last called
+func (n *guardianNotifier) sent() []string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	sms := n.sms
+	n.sms = nil
+	return sms
+}
+
+type guardianFixture struct {
+	notices  *GuardianNotices
+	postgres *database.PostgresDB
+	notifier *guardianNotifier
+	audit    *AuditLogger
+	cfg      *config.Config
+	guardian *models.User
+	ward     *models.User
+	linkID   uuid.UUID
+}
+
+// newGuardianFixture links a new guardian to a new ward with the given
+// rules, notified by SMS, under a 6 hour cooldown, in front of an audit log
+// that is never started
+func newGuardianFixture(t *testing.T, silentHours, stationaryHours *int) *guardianFixture {
+	t.Helper()
+	ctx := context.Background()
+	postgres := testPostgres(t)
+	f := &guardianFixture{
+		postgres: postgres,
+		notifier: &guardianNotifier{},
+		audit:    NewAuditLogger(postgres, nil, 100, 10, time.Second),
+		cfg:      &config.Config{GuardianNoticeCooldownHours: 6, GuardianDigestHour: 20},
+		guardian: createTestUser(t, postgres, "Bola Adeyemi"),
+		ward:     createTestUser(t, postgres, "Mama Ngozi Okafor"),
+		linkID:   uuid.New(),
+	}
+	f.notices = NewGuardianNotices(config.NewStore(f.cfg), postgres, f.notifier, f.audit, nil)
+
+	link := &models.AccountLink{ID: f.linkID, GuardianUserID: f.guardian.ID, WardUserID: f.ward.ID, Permissions: []string{}, Status: models.LinkStatusActive, CreatedAt: time.Now()}
+	if err := postgres.CreateAccountLink(ctx, link); err != nil {
+		t.Fatalf("CreateAccountLink: %v", err)
+	}
+	rules := &models.GuardianRules{LinkID: f.linkID, MaxSilentHours: silentHours, MaxStationaryHours: stationaryHours, Channel: models.GuardianChannelSMS, UpdatedAt: time.Now()}
+	if err := postgres.UpsertGuardianRules(ctx, rules); err != nil {
+		t.Fatalf("UpsertGuardianRules: %v", err)
+	}
+	return f
+}
+
+// beat stores a heartbeat of the ward's at, metres north of the others
+func (f *guardianFixture) beat(t *testing.T, at time.Time, northM float64) {
+	t.Helper()
+	hb := stillFix(0, northM)
+	hb.UserID, hb.Timestamp = f.ward.ID, at
+	if err := f.postgres.CreateHeartbeat(context.Background(), &hb); err != nil {
+		t.Fatalf("CreateHeartbeat: %v", err)
+	}
+}
+
+// check runs the worker's check of the link at now, with its rules as stored
+func (f *guardianFixture) check(t *testing.T, now time.Time) {
+	t.Helper()
+	ctx := context.Background()
+	rules, err := f.postgres.GetGuardianRules(ctx, f.linkID)
+	if err != nil || rules == nil {
+		t.Fatalf("GetGuardianRules = %v, %v", rules, err)
+	}
+	target := &models.GuardianRuleTarget{
+		GuardianRules:  *rules,
+		GuardianUserID: f.guardian.ID,
+		GuardianPhone:  f.guardian.Phone,
+		WardUserID:     f.ward.ID,
+		WardName:       f.ward.Name,
+		WardSettings:   f.ward.Settings,
+	}
+	if err := f.notices.check(ctx, target, now, f.cfg); err != nil {
+		t.Fatalf("check: %v", err)
+	}
+}
+
+// wantSent checks the notices texted since the last call start as given
+func (f *guardianFixture) wantSent(t *testing.T, when string, prefixes ...string) {
+	t.Helper()
+	sent := f.notifier.sent()
+	if len(sent) != len(prefixes) {
+		t.Fatalf("%s: sent %q, want %d notices", when, sent, len(prefixes))
+	}
+	for i, prefix := range prefixes {
+		if !strings.HasPrefix(sent[i], prefix) {
+			t.Errorf("%s: sent %q, want it to start %q", when, sent[i], prefix)
+		}
+	}
+}
+
+// A silence is notified once its threshold is crossed, once per episode,
+// and a new silence waits out the cooldown
+func TestGuardianSilentNotice(t *testing.T) {
+	three := 3
+	f := newGuardianFixture(t, &three, nil)
+	base := time.Now().Truncate(time.Minute)
+	f.beat(t, base, 0)
+
+	f.check(t, base.Add(2*time.Hour+59*time.Minute))
+	f.wantSent(t, "under the threshold")
+
+	f.check(t, base.Add(3*time.Hour))
+	f.wantSent(t, "at the threshold", "SafeTrace update: Not an alert. Mama's phone hasn't reported since")
+	f.check(t, base.Add(5*time.Hour))
+	f.check(t, base.Add(9*time.Hour)) // past the cooldown, same silence
+	f.wantSent(t, "the same silence")
+
+	// The phone reports, then goes silent again within the cooldown of
+	// the first notice: the second silence waits until the cooldown ends
+	f.beat(t, base.Add(4*time.Hour), 0)
+	f.check(t, base.Add(7*time.Hour))
+	f.wantSent(t, "within the cooldown")
+	f.check(t, base.Add(9*time.Hour))
+	f.wantSent(t, "after the cooldown", "SafeTrace update: Not an alert.")
+
+	rules, err := f.postgres.GetGuardianRules(context.Background(), f.linkID)
+	if err != nil {
+		t.Fatalf("GetGuardianRules: %v", err)
+	}
+	if rules.SilentEpisode == nil || !rules.SilentEpisode.Equal(base.Add(4*time.Hour)) ||
+		rules.SilentNotifiedAt == nil || !rules.SilentNotifiedAt.Equal(base.Add(9*time.Hour)) {
+		t.Errorf("silent episode %v notified %v; want the second silence's", rules.SilentEpisode, rules.SilentNotifiedAt)
+	}
+
+	// Each notice sent is in the audit log
+	notices := 0
+	for len(f.audit.queue) > 0 {
+		if e := <-f.audit.queue; e.Action == AuditGuardianNotice && e.Metadata["rule"] == models.GuardianRuleSilent {
+			notices++
+		}
+	}
+	if notices != 2 {
+		t.Errorf("%d notices audited, want 2", notices)
+	}
+}
+
+// Stillness is notified from when it began, once, and only while the ward's
+// phone reports
+func TestGuardianStationaryNotice(t *testing.T) {
+	two := 2
+	f := newGuardianFixture(t, nil, &two)
+	base := time.Now().Truncate(time.Minute)
+
+	// Walked home, then stayed put within the radius
+	f.beat(t, base.Add(-2*time.Hour), 3000)
+	f.beat(t, base.Add(-80*time.Minute), 0)
+	for m := -60; m <= 0; m += 20 {
+		f.beat(t, base.Add(time.Duration(m)*time.Minute), float64(m%40))
+	}
+
+	f.check(t, base.Add(39*time.Minute))
+	f.wantSent(t, "under the threshold")
+	f.check(t, base.Add(40*time.Minute))
+	f.wantSent(t, "at the threshold", "SafeTrace update: Not an alert. Mama hasn't moved since")
+	f.check(t, base.Add(90*time.Minute))
+	f.wantSent(t, "the same stillness")
+
+	// Past stationaryFreshness without a heartbeat it's the silent rule's
+	f.beat(t, base.Add(8*time.Hour), 0) // a new stillness the cooldown allows
+	f.check(t, base.Add(10*time.Hour+time.Minute))
+	f.wantSent(t, "a stale latest fix")
+	rules, err := f.postgres.GetGuardianRules(context.Background(), f.linkID)
+	if err != nil {
+		t.Fatalf("GetGuardianRules: %v", err)
+	}
+	if rules.StationaryNotifiedAt == nil || !rules.StationaryNotifiedAt.Equal(base.Add(40*time.Minute)) {
+		t.Errorf("stationary notified at %v, want only the first stillness's", rules.StationaryNotifiedAt)
+	}
+}
+
+// Notices stay out of the alert pipeline: no alert is raised, the alert
+// dedup key isn't marked, so a real alert right after one isn't held back,
+// and an open alert or a pause silences them
+func TestGuardianNoticesLeaveAlertsAlone(t *testing.T) {
+	ctx := context.Background()
+	redis := testRedis(t)
+	one := 1
+	f := newGuardianFixture(t, &one, nil)
+	base := time.Now().Truncate(time.Minute)
+	f.beat(t, base.Add(-2*time.Hour), 0)
+
+	f.check(t, base)
+	f.wantSent(t, "a silence", "SafeTrace update: Not an alert.")
+	for _, id := range []uuid.UUID{f.ward.ID, f.guardian.ID} {
+		if marked, err := redis.CheckAlertSent(ctx, id, alertDedupWindow); err != nil || marked {
+			t.Errorf("alert dedup of %s marked %t, %v by a notice", id, marked, err)
+		}
+		if alert, err := f.postgres.GetLatestAlert(ctx, id); err != nil || alert != nil {
+			t.Errorf("GetLatestAlert(%s) = %+v, %v; want no alert", id, alert, err)
+		}
+	}
+
+	// With the ward's alert open, a new silence isn't notified
+	f.beat(t, base.Add(-time.Hour), 0)
+	alert := &models.Alert{ID: uuid.New(), UserID: f.ward.ID, State: models.AlertStateAlert, Reasons: models.Reasons{}, SentTo: []string{}, CreatedAt: base}
+	if err := f.postgres.CreateAlert(ctx, alert); err != nil {
+		t.Fatalf("CreateAlert: %v", err)
+	}
+	f.check(t, base.Add(7*time.Hour))
+	f.wantSent(t, "an alert open")
+	if marked, _ := redis.CheckAlertSent(ctx, f.ward.ID, alertDedupWindow); marked {
+		t.Error("alert dedup marked while an alert was open")
+	}
+}
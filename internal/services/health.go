@@ -0,0 +1,90 @@
+package services
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// staleFactor is how many missed intervals mark a worker as stalled
+const staleFactor = 3
+
+// HealthRegistry tracks the last successful loop iteration of each background
+// worker so readiness checks can detect a worker that has crashed or hung
+type HealthRegistry struct {
+	mu      sync.RWMutex
+	workers map[string]*workerEntry
+	now     func() time.Time
+}
+
+type workerEntry struct {
+	interval time.Duration
+	lastBeat time.Time
+}
+
+// WorkerStatus is a point-in-time view of a registered worker
+type WorkerStatus struct {
+	Name     string    `json:"name"`
+	Interval string    `json:"interval"`
+	LastBeat time.Time `json:"last_beat"`
+	Healthy  bool      `json:"healthy"`
+}
+
+func NewHealthRegistry() *HealthRegistry {
+	return NewHealthRegistryWithClock(SystemClock{})
+}
+
+// NewHealthRegistryWithClock returns a registry that reads beats and
+// staleness off clock
+func NewHealthRegistryWithClock(clock Clock) *HealthRegistry {
+	return &HealthRegistry{
+		workers: make(map[string]*workerEntry),
+		now:     clock.Now,
+	}
+}
+
+// Register adds a worker that promises to call Beat at least once per interval.
+// Registration counts as the first beat.
+func (h *HealthRegistry) Register(name string, interval time.Duration) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.workers[name] = &workerEntry{interval: interval, lastBeat: h.now()}
+}
+
+// Beat records a successful loop iteration. Unknown names are ignored.
+func (h *HealthRegistry) Beat(name string) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if w, ok := h.workers[name]; ok {
+		w.lastBeat = h.now()
+	}
+}
+
+// Workers returns every registered worker, sorted by name. A worker is
+// unhealthy once it has been silent for more than three intervals.
+func (h *HealthRegistry) Workers() []WorkerStatus {
+	if h == nil {
+		return nil
+	}
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	now := h.now()
+	statuses := make([]WorkerStatus, 0, len(h.workers))
+	for name, w := range h.workers {
+		statuses = append(statuses, WorkerStatus{
+			Name:     name,
+			Interval: w.interval.String(),
+			LastBeat: w.lastBeat,
+			Healthy:  now.Sub(w.lastBeat) <= staleFactor*w.interval,
+		})
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Name < statuses[j].Name })
+	return statuses
+}
@@ -0,0 +1,46 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+// A worker is healthy until it has been silent for three intervals
+func TestHealthRegistryStalledWorker(t *testing.T) {
+	clock := NewFakeClock(time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC))
+	registry := NewHealthRegistryWithClock(clock)
+	registry.Register("sweeper", time.Minute)
+
+	healthy := func() bool {
+		t.Helper()
+		workers := registry.Workers()
+		if len(workers) != 1 {
+			t.Fatalf("%d workers, want 1", len(workers))
+		}
+		return workers[0].Healthy
+	}
+
+	clock.Advance(3 * time.Minute)
+	if !healthy() {
+		t.Errorf("unhealthy after three intervals")
+	}
+	clock.Advance(time.Second)
+	if healthy() {
+		t.Errorf("healthy after more than three silent intervals")
+	}
+	registry.Beat("sweeper")
+	if !healthy() {
+		t.Errorf("unhealthy right after a beat")
+	}
+	registry.Beat("unknown")
+	if n := len(registry.Workers()); n != 1 {
+		t.Errorf("beat of an unknown worker registered it: %d workers", n)
+	}
+
+	var none *HealthRegistry
+	none.Register("sweeper", time.Minute)
+	none.Beat("sweeper")
+	if none.Workers() != nil {
+		t.Errorf("nil registry reported workers")
+	}
+}
@@ -0,0 +1,216 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/database"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+)
+
+// ErrBufferFull is returned when the ingestion buffer cannot accept more heartbeats
+var ErrBufferFull = errors.New("heartbeat buffer full")
+
+// ErrBufferClosed is returned for heartbeats offered after Close
+var ErrBufferClosed = errors.New("heartbeat buffer closed")
+
+// HeartbeatBuffer decouples heartbeat acknowledgement from the Postgres write.
+// Heartbeats are queued in memory and a single writer flushes them with COPY
+// in batches, which keeps pool usage flat as the number of users grows.
+//
+// Crash safety: heartbeats are acknowledged before they are durable. A crash
+// or kill -9 loses whatever is queued (at most bufferSize rows, normally less
+// than one flush interval's worth). Graceful shutdown via Close drains the
+// queue. Heartbeats are periodic, so a lost row is superseded by the next one;
+// LastGasp records are still written synchronously by the handlers.
+type HeartbeatBuffer struct {
+	postgres      *database.PostgresDB
+	evaluator     *SafetyEvaluator
+	queue         chan *models.Heartbeat
+	batchSize     int
+	flushInterval time.Duration
+	health        *HealthRegistry
+
+	mu     sync.RWMutex // held to send on queue, and exclusively to close it
+	closed bool
+	done   chan struct{}
+}
+
+func NewHeartbeatBuffer(
+	postgres *database.PostgresDB,
+	evaluator *SafetyEvaluator,
+	bufferSize int,
+	batchSize int,
+	flushInterval time.Duration,
+	health *HealthRegistry,
+) *HeartbeatBuffer {
+	return &HeartbeatBuffer{
+		postgres:      postgres,
+		evaluator:     evaluator,
+		queue:         make(chan *models.Heartbeat, bufferSize),
+		batchSize:     batchSize,
+		flushInterval: flushInterval,
+		health:        health,
+		done:          make(chan struct{}),
+	}
+}
+
+// heartbeatWriterWorker identifies the writer in the health registry
+const heartbeatWriterWorker = "heartbeat_writer"
+
+// Start launches the writer goroutine
+func (b *HeartbeatBuffer) Start() {
+	b.health.Register(heartbeatWriterWorker, b.flushInterval)
+	go b.run()
+}
+
+// Enqueue adds a heartbeat without blocking; ErrBufferFull signals
+// backpressure, and ErrBufferClosed a buffer shutting down
+func (b *HeartbeatBuffer) Enqueue(hb *models.Heartbeat) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.closed {
+		return ErrBufferClosed
+	}
+	select {
+	case b.queue <- hb:
+		return nil
+	default:
+		return ErrBufferFull
+	}
+}
+
+// Len returns the number of heartbeats waiting to be written
+func (b *HeartbeatBuffer) Len() int {
+	return len(b.queue)
+}
+
+// Close stops accepting heartbeats and waits for the queue to be flushed
+func (b *HeartbeatBuffer) Close() {
+	b.mu.Lock()
+	if !b.closed {
+		b.closed = true
+		close(b.queue)
+	}
+	b.mu.Unlock()
+	<-b.done
+}
+
+func (b *HeartbeatBuffer) run() {
+	defer close(b.done)
+
+	ticker := time.NewTicker(b.flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]*models.Heartbeat, 0, b.batchSize)
+	for {
+		select {
+		case hb, ok := <-b.queue:
+			if !ok {
+				b.flush(batch)
+				return
+			}
+			batch = append(batch, hb)
+			if len(batch) >= b.batchSize {
+				b.flush(batch)
+				batch = make([]*models.Heartbeat, 0, b.batchSize)
+			}
+
+		case <-ticker.C:
+			ok := true
+			if len(batch) > 0 {
+				ok = b.flush(batch)
+				batch = make([]*models.Heartbeat, 0, b.batchSize)
+			}
+			if ok {
+				b.health.Beat(heartbeatWriterWorker)
+			}
+		}
+	}
+}
+
+// A batch COPY that fails is tried again once, after copyRetryDelay, in case
+// the failure was transient; then its rows are inserted one by one so a row
+// Postgres rejects loses only itself
+const (
+	copyAttempts   = 2
+	copyRetryDelay = 500 * time.Millisecond
+)
+
+// heartbeatWriter is what the buffer writes heartbeats with
+type heartbeatWriter interface {
+	CopyHeartbeats(ctx context.Context, heartbeats []*models.Heartbeat) (int64, error)
+	CreateHeartbeat(ctx context.Context, hb *models.Heartbeat) error
+}
+
+// flush writes a batch and reports whether any of it was stored
+func (b *HeartbeatBuffer) flush(batch []*models.Heartbeat) bool {
+	if len(batch) == 0 {
+		return true
+	}
+
+	batch, failed := writeHeartbeats(b.postgres, batch, copyRetryDelay)
+	if len(failed) > 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		// Copies sent over SMS are then kept as the originals
+		for _, hb := range failed {
+			b.evaluator.ReleaseFingerprint(ctx, hb)
+		}
+	}
+	if len(batch) == 0 {
+		return false
+	}
+
+	// Evaluate each user once per batch, for their newest heartbeat, after
+	// it is durable. Backfilled and duplicate heartbeats don't drive state;
+	// copies within the batch were marked as they were received.
+	newest := make(map[uuid.UUID]*models.Heartbeat, len(batch))
+	for _, hb := range batch {
+		if hb.Backfill || hb.DuplicateOf != nil {
+			continue
+		}
+		if seen, ok := newest[hb.UserID]; !ok || hb.Timestamp.After(seen.Timestamp) {
+			newest[hb.UserID] = hb
+		}
+	}
+	for _, hb := range newest {
+		b.evaluator.EvaluateHeartbeatAsync(hb)
+	}
+	return true
+}
+
+// writeHeartbeats stores a batch with COPY, retrying it, and falls back to
+// inserting row by row. It returns the heartbeats stored and those that
+// could not be.
+func writeHeartbeats(w heartbeatWriter, batch []*models.Heartbeat, retryDelay time.Duration) (stored, failed []*models.Heartbeat) {
+	var err error
+	for attempt := 1; attempt <= copyAttempts; attempt++ {
+		if attempt > 1 {
+			time.Sleep(retryDelay)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		_, err = w.CopyHeartbeats(ctx, batch)
+		cancel()
+		if err == nil {
+			return batch, nil
+		}
+	}
+	log.Printf("WARN: Failed to copy %d heartbeats, inserting them one by one: %v", len(batch), err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	for _, hb := range batch {
+		if err := w.CreateHeartbeat(ctx, hb); err != nil {
+			log.Printf("ERROR: Failed to store heartbeat %s of user %s: %v", hb.ID, hb.UserID, err)
+			failed = append(failed, hb)
+			continue
+		}
+		stored = append(stored, hb)
+	}
+	return stored, failed
+}
@@ -0,0 +1,110 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+)
+
+// fakeHeartbeatWriter fails COPY copyFailures times, and both COPY and
+// insert for any batch holding a rejected heartbeat
+type fakeHeartbeatWriter struct {
+	mu           sync.Mutex
+	copyFailures int
+	rejected     map[uuid.UUID]bool
+	copies       int
+	stored       []*models.Heartbeat
+}
+
+func (w *fakeHeartbeatWriter) CopyHeartbeats(ctx context.Context, heartbeats []*models.Heartbeat) (int64, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.copies++
+	if w.copyFailures > 0 {
+		w.copyFailures--
+		return 0, errors.New("connection reset")
+	}
+	for _, hb := range heartbeats {
+		if w.rejected[hb.ID] {
+			return 0, errors.New("violates check constraint")
+		}
+	}
+	w.stored = append(w.stored, heartbeats...)
+	return int64(len(heartbeats)), nil
+}
+
+func (w *fakeHeartbeatWriter) CreateHeartbeat(ctx context.Context, hb *models.Heartbeat) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.rejected[hb.ID] || w.copyFailures > 0 {
+		return errors.New("violates check constraint")
+	}
+	w.stored = append(w.stored, hb)
+	return nil
+}
+
+func heartbeatBatch(n int) []*models.Heartbeat {
+	batch := make([]*models.Heartbeat, n)
+	for i := range batch {
+		batch[i] = &models.Heartbeat{ID: uuid.New(), UserID: uuid.New()}
+	}
+	return batch
+}
+
+func TestWriteHeartbeats(t *testing.T) {
+	batch := heartbeatBatch(5)
+
+	tests := []struct {
+		name       string
+		writer     *fakeHeartbeatWriter
+		wantStored int
+		wantFailed []*models.Heartbeat
+		wantCopies int
+	}{
+		{"copied", &fakeHeartbeatWriter{}, 5, nil, 1},
+		{"transient copy failure retried", &fakeHeartbeatWriter{copyFailures: 1}, 5, nil, 2},
+		{"bad rows isolated", &fakeHeartbeatWriter{rejected: map[uuid.UUID]bool{batch[1].ID: true, batch[3].ID: true}}, 3, []*models.Heartbeat{batch[1], batch[3]}, copyAttempts},
+		{"database down", &fakeHeartbeatWriter{copyFailures: 100}, 0, batch, copyAttempts},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stored, failed := writeHeartbeats(tt.writer, batch, 0)
+			if len(stored) != tt.wantStored || len(tt.writer.stored) != tt.wantStored {
+				t.Errorf("stored %d (writer has %d), want %d", len(stored), len(tt.writer.stored), tt.wantStored)
+			}
+			if len(failed) != len(tt.wantFailed) {
+				t.Fatalf("failed %d, want %d", len(failed), len(tt.wantFailed))
+			}
+			for i := range failed {
+				if failed[i] != tt.wantFailed[i] {
+					t.Errorf("failed[%d] = %s, want %s", i, failed[i].ID, tt.wantFailed[i].ID)
+				}
+			}
+			if tt.writer.copies != tt.wantCopies {
+				t.Errorf("%d COPY attempts, want %d", tt.writer.copies, tt.wantCopies)
+			}
+		})
+	}
+}
+
+// Heartbeats offered once the buffer is closed are refused, not sent on the
+// closed queue, and closing again is harmless
+func TestHeartbeatBufferEnqueueAfterClose(t *testing.T) {
+	buffer := NewHeartbeatBuffer(nil, nil, 4, 2, time.Hour, NewHealthRegistry())
+	buffer.Start()
+	buffer.Close()
+	buffer.Close()
+
+	if err := buffer.Enqueue(&models.Heartbeat{ID: uuid.New()}); !errors.Is(err, ErrBufferClosed) {
+		t.Errorf("Enqueue() after Close = %v, want ErrBufferClosed", err)
+	}
+	if n := buffer.Len(); n != 0 {
+		t.Errorf("%d heartbeats queued after Close, want 0", n)
+	}
+}
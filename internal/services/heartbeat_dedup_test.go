@@ -0,0 +1,182 @@
+package services
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/config"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+)
+
+// appHeartbeat is a heartbeat as the app sends it over HTTP, with a
+// fractional timestamp and a full-precision fix
+func appHeartbeat(userID uuid.UUID) *models.Heartbeat {
+	battery := 64
+	return &models.Heartbeat{
+		ID:         uuid.New(),
+		UserID:     userID,
+		Timestamp:  time.Date(2026, 3, 9, 18, 42, 7, 734_000_000, time.UTC),
+		Lat:        6.5243793,
+		Lng:        3.3792057,
+		AccuracyM:  18,
+		CellInfo:   models.CellInfo{MCC: 621, MNC: 20, CID: 12345, LAC: 678, RSSI: -85, NetworkType: "LTE"},
+		BatteryPct: &battery,
+		Signature:  "c2lnbmF0dXJl",
+	}
+}
+
+// The SMS copy of a heartbeat, parsed from the text the app sends, has the
+// fingerprint of the HTTP one; a heartbeat differing in anything that
+// matters does not
+func TestHeartbeatFingerprint(t *testing.T) {
+	sent := appHeartbeat(uuid.New())
+	parser := NewSMSParser()
+	sms, err := parser.ParseHeartbeatSMS(parser.BuildSMSPayload(sent))
+	if err != nil {
+		t.Fatalf("ParseHeartbeatSMS: %v", err)
+	}
+	if sms.Timestamp.Equal(sent.Timestamp) {
+		t.Fatal("the SMS kept the fraction of a second; the test proves nothing")
+	}
+	if got, want := HeartbeatFingerprint(sms), HeartbeatFingerprint(sent); got != want {
+		t.Errorf("SMS copy fingerprint %s, HTTP %s", got, want)
+	}
+
+	low := 12
+	tests := []struct {
+		name   string
+		change func(hb *models.Heartbeat)
+		same   bool
+	}{
+		{"another ID", func(hb *models.Heartbeat) { hb.ID = uuid.New() }, true},
+		{"a jitter under 11m", func(hb *models.Heartbeat) { hb.Lat += 0.00002 }, true},
+		{"another accuracy", func(hb *models.Heartbeat) { hb.AccuracyM = 200 }, true},
+		{"a second later", func(hb *models.Heartbeat) { hb.Timestamp = hb.Timestamp.Add(time.Second) }, false},
+		{"moved 110m", func(hb *models.Heartbeat) { hb.Lng += 0.001 }, false},
+		{"another battery", func(hb *models.Heartbeat) { hb.BatteryPct = &low }, false},
+		{"no battery", func(hb *models.Heartbeat) { hb.BatteryPct = nil }, false},
+		{"a LastGasp", func(hb *models.Heartbeat) { hb.LastGasp = true }, false},
+	}
+	for _, tt := range tests {
+		hb := *sent
+		tt.change(&hb)
+		if same := HeartbeatFingerprint(&hb) == HeartbeatFingerprint(sent); same != tt.same {
+			t.Errorf("%s: same fingerprint %v, want %v", tt.name, same, tt.same)
+		}
+	}
+}
+
+func newDedupEvaluator(t *testing.T, window int) *SafetyEvaluator {
+	t.Helper()
+	return &SafetyEvaluator{cfg: config.NewStore(&config.Config{HeartbeatDedupWindowSeconds: window}), redis: testRedis(t)}
+}
+
+// Whichever copy arrives first is the original, and the other links to it
+func TestMarkDuplicate(t *testing.T) {
+	se := newDedupEvaluator(t, 600)
+	ctx := context.Background()
+	userID := uuid.New()
+
+	first := appHeartbeat(userID)
+	se.MarkDuplicate(ctx, first)
+	if first.DuplicateOf != nil {
+		t.Fatalf("first copy linked to %s", first.DuplicateOf)
+	}
+	// Retried, the original is still the original
+	se.MarkDuplicate(ctx, first)
+	if first.DuplicateOf != nil {
+		t.Errorf("original linked to %s when checked again", first.DuplicateOf)
+	}
+
+	second := appHeartbeat(userID)
+	second.Timestamp = second.Timestamp.Truncate(time.Second)
+	se.MarkDuplicate(ctx, second)
+	if second.DuplicateOf == nil || *second.DuplicateOf != first.ID {
+		t.Errorf("second copy linked to %v, want %s", second.DuplicateOf, first.ID)
+	}
+
+	// Another user's identical heartbeat is their own
+	other := appHeartbeat(uuid.New())
+	se.MarkDuplicate(ctx, other)
+	if other.DuplicateOf != nil {
+		t.Errorf("another user's heartbeat linked to %s", other.DuplicateOf)
+	}
+
+	// Turned off, nothing is a copy
+	off := newDedupEvaluator(t, 0)
+	off.redis = se.redis
+	copied := appHeartbeat(userID)
+	off.MarkDuplicate(ctx, copied)
+	if copied.DuplicateOf != nil {
+		t.Errorf("with HEARTBEAT_DEDUP_WINDOW_SECONDS 0, linked to %s", copied.DuplicateOf)
+	}
+}
+
+// Copies arriving at once over both channels: exactly one is the original
+func TestMarkDuplicateConcurrent(t *testing.T) {
+	se := newDedupEvaluator(t, 600)
+	userID := uuid.New()
+
+	copies := make([]*models.Heartbeat, 20)
+	for i := range copies {
+		copies[i] = appHeartbeat(userID)
+	}
+	var wg sync.WaitGroup
+	for _, hb := range copies {
+		wg.Add(1)
+		go func(hb *models.Heartbeat) {
+			defer wg.Done()
+			se.MarkDuplicate(context.Background(), hb)
+		}(hb)
+	}
+	wg.Wait()
+
+	var original *models.Heartbeat
+	for _, hb := range copies {
+		if hb.DuplicateOf == nil {
+			if original != nil {
+				t.Fatalf("both %s and %s taken for the original", original.ID, hb.ID)
+			}
+			original = hb
+		}
+	}
+	if original == nil {
+		t.Fatal("no copy taken for the original")
+	}
+	for _, hb := range copies {
+		if hb != original && *hb.DuplicateOf != original.ID {
+			t.Errorf("copy linked to %s, want %s", hb.DuplicateOf, original.ID)
+		}
+	}
+}
+
+// An original that couldn't be stored gives up its fingerprint, so the next
+// copy is stored as the original; a duplicate's release changes nothing
+func TestReleaseFingerprint(t *testing.T) {
+	se := newDedupEvaluator(t, 600)
+	ctx := context.Background()
+	userID := uuid.New()
+
+	lost := appHeartbeat(userID)
+	se.MarkDuplicate(ctx, lost)
+	se.ReleaseFingerprint(ctx, lost)
+
+	retried := appHeartbeat(userID)
+	se.MarkDuplicate(ctx, retried)
+	if retried.DuplicateOf != nil {
+		t.Fatalf("copy of a heartbeat never stored linked to %s", retried.DuplicateOf)
+	}
+
+	duplicate := appHeartbeat(userID)
+	se.MarkDuplicate(ctx, duplicate)
+	se.ReleaseFingerprint(ctx, duplicate)
+	late := appHeartbeat(userID)
+	se.MarkDuplicate(ctx, late)
+	if late.DuplicateOf == nil || *late.DuplicateOf != retried.ID {
+		t.Errorf("after a duplicate's release, linked to %v, want %s", late.DuplicateOf, retried.ID)
+	}
+}
@@ -0,0 +1,174 @@
+package services
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+)
+
+const (
+	// HeatmapMinPrecision and HeatmapMaxPrecision bound the geohash length of
+	// heatmap bins: 3 is about 156km across, 7 about 150m
+	HeatmapMinPrecision = 3
+	HeatmapMaxPrecision = 7
+	// heatmapMergeLevels is how many times a bin with too few users is folded
+	// into its parent before it is dropped
+	heatmapMergeLevels = 2
+)
+
+const geohashBase32 = "0123456789bcdefghjkmnpqrstuvwxyz"
+
+// HeatmapBin is one published cell of the incident heatmap. A merged bin
+// holds only the events of child cells too sparse to publish on their own,
+// so it may overlap finer bins without counting anything twice.
+type HeatmapBin struct {
+	Geohash   string  `json:"geohash"`
+	Precision int     `json:"precision"`
+	Lat       float64 `json:"lat"`
+	Lng       float64 `json:"lng"`
+	Alerts    int     `json:"alerts"`
+	LastGasps int     `json:"last_gasps"`
+	Users     int     `json:"users"`
+	Merged    bool    `json:"merged"`
+}
+
+// BuildHeatmap aggregates samples into bins, enforcing k-anonymity: a bin is
+// published only if at least minUsers distinct users contributed to it. A
+// sparser bin is folded into its parent cell, up to heatmapMergeLevels
+// times, and dropped if still too sparse. Bins come back ordered by geohash.
+func BuildHeatmap(samples []models.HeatmapSample, minUsers int) []HeatmapBin {
+	type agg struct {
+		users     map[uuid.UUID]struct{}
+		alerts    int
+		lastGasps int
+		merged    bool
+	}
+	add := func(cells map[string]*agg, cell string, a *agg) {
+		into, ok := cells[cell]
+		if !ok {
+			into = &agg{users: map[uuid.UUID]struct{}{}}
+			cells[cell] = into
+		}
+		for u := range a.users {
+			into.users[u] = struct{}{}
+		}
+		into.alerts += a.alerts
+		into.lastGasps += a.lastGasps
+	}
+
+	cells := map[string]*agg{}
+	for _, s := range samples {
+		add(cells, s.Geohash, &agg{
+			users:     map[uuid.UUID]struct{}{s.UserID: {}},
+			alerts:    s.Alerts,
+			lastGasps: s.LastGasps,
+		})
+	}
+
+	var bins []HeatmapBin
+	for level := 0; len(cells) > 0; level++ {
+		parents := map[string]*agg{}
+		for cell, a := range cells {
+			if len(a.users) >= minUsers {
+				lat, lng := GeohashCenter(cell)
+				bins = append(bins, HeatmapBin{
+					Geohash:   cell,
+					Precision: len(cell),
+					Lat:       lat,
+					Lng:       lng,
+					Alerts:    a.alerts,
+					LastGasps: a.lastGasps,
+					Users:     len(a.users),
+					Merged:    a.merged,
+				})
+				continue
+			}
+			if level < heatmapMergeLevels && len(cell) > 1 {
+				parent := cell[:len(cell)-1]
+				add(parents, parent, a)
+				parents[parent].merged = true
+			}
+		}
+		cells = parents
+	}
+
+	sort.Slice(bins, func(i, j int) bool {
+		return bins[i].Geohash < bins[j].Geohash
+	})
+	return bins
+}
+
+// GeohashBounds returns the box (minLat, maxLat, minLng, maxLng) covered by a
+// geohash. Characters outside the geohash alphabet are ignored.
+func GeohashBounds(hash string) (float64, float64, float64, float64) {
+	minLat, maxLat := -90.0, 90.0
+	minLng, maxLng := -180.0, 180.0
+	even := true
+	for _, r := range hash {
+		idx := strings.IndexRune(geohashBase32, r)
+		if idx < 0 {
+			continue
+		}
+		for bit := 4; bit >= 0; bit-- {
+			set := idx&(1<<bit) != 0
+			if even {
+				mid := (minLng + maxLng) / 2
+				if set {
+					minLng = mid
+				} else {
+					maxLng = mid
+				}
+			} else {
+				mid := (minLat + maxLat) / 2
+				if set {
+					minLat = mid
+				} else {
+					maxLat = mid
+				}
+			}
+			even = !even
+		}
+	}
+	return minLat, maxLat, minLng, maxLng
+}
+
+// GeohashCenter returns the center of a geohash cell
+func GeohashCenter(hash string) (float64, float64) {
+	minLat, maxLat, minLng, maxLng := GeohashBounds(hash)
+	return (minLat + maxLat) / 2, (minLng + maxLng) / 2
+}
+
+// GeohashEncode returns the geohash of the given length containing a point
+func GeohashEncode(lat, lng float64, length int) string {
+	minLat, maxLat := -90.0, 90.0
+	minLng, maxLng := -180.0, 180.0
+	hash := make([]byte, 0, length)
+	even := true
+	for len(hash) < length {
+		idx := 0
+		for bit := 4; bit >= 0; bit-- {
+			if even {
+				mid := (minLng + maxLng) / 2
+				if lng >= mid {
+					idx |= 1 << bit
+					minLng = mid
+				} else {
+					maxLng = mid
+				}
+			} else {
+				mid := (minLat + maxLat) / 2
+				if lat >= mid {
+					idx |= 1 << bit
+					minLat = mid
+				} else {
+					maxLat = mid
+				}
+			}
+			even = !even
+		}
+		hash = append(hash, geohashBase32[idx])
+	}
+	return string(hash)
+}
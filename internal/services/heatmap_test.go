@@ -0,0 +1,154 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+)
+
+// samples returns one sample with an alert from each of n new users in cell
+func heatmapSamples(cell string, n int) []models.HeatmapSample {
+	samples := make([]models.HeatmapSample, n)
+	for i := range samples {
+		samples[i] = models.HeatmapSample{Geohash: cell, UserID: uuid.New(), Alerts: 1}
+	}
+	return samples
+}
+
+func binsByGeohash(bins []HeatmapBin) map[string]HeatmapBin {
+	m := make(map[string]HeatmapBin, len(bins))
+	for _, b := range bins {
+		m[b.Geohash] = b
+	}
+	return m
+}
+
+func TestBuildHeatmap(t *testing.T) {
+	var samples []models.HeatmapSample
+	// A busy cell next to a sparse one
+	samples = append(samples, heatmapSamples("s14u5", 6)...)
+	samples = append(samples, heatmapSamples("s14u4", 2)...)
+	// Three sparse siblings that publish together as their parent
+	samples = append(samples, heatmapSamples("s14v1", 2)...)
+	samples = append(samples, heatmapSamples("s14v2", 2)...)
+	samples = append(samples, heatmapSamples("s14v3", 1)...)
+	// One user, many events, alone in a cell
+	for i := 0; i < 20; i++ {
+		samples = append(samples, models.HeatmapSample{Geohash: "s0000", UserID: samples[0].UserID, Alerts: 1, LastGasps: 1})
+	}
+
+	bins := binsByGeohash(BuildHeatmap(samples, 5))
+	if len(bins) != 2 {
+		t.Fatalf("bins = %v, want s14u5 and s14v", bins)
+	}
+	if b := bins["s14u5"]; b.Users != 6 || b.Alerts != 6 || b.Merged || b.Precision != 5 {
+		t.Errorf("s14u5 = %+v", b)
+	}
+	if b := bins["s14v"]; b.Users != 5 || b.Alerts != 5 || !b.Merged || b.Precision != 4 {
+		t.Errorf("s14v = %+v", b)
+	}
+	for _, hidden := range []string{"s14u4", "s14u", "s14", "s0000", "s000", "s00"} {
+		if _, ok := bins[hidden]; ok {
+			t.Errorf("%s published with fewer than 5 users", hidden)
+		}
+	}
+}
+
+// A user with events in several sparse cells counts once where they merge
+func TestBuildHeatmapDistinctUsers(t *testing.T) {
+	user := uuid.New()
+	samples := []models.HeatmapSample{
+		{Geohash: "s14v1", UserID: user, Alerts: 1},
+		{Geohash: "s14v2", UserID: user, Alerts: 1},
+		{Geohash: "s14v3", UserID: user, LastGasps: 1},
+	}
+	samples = append(samples, heatmapSamples("s14v4", 3)...)
+	if bins := BuildHeatmap(samples, 5); len(bins) != 0 {
+		t.Errorf("bins = %+v from 4 distinct users, want none", bins)
+	}
+
+	samples = append(samples, heatmapSamples("s14v5", 1)...)
+	bins := BuildHeatmap(samples, 5)
+	if len(bins) != 1 || bins[0].Users != 5 || bins[0].Alerts != 6 || bins[0].LastGasps != 1 {
+		t.Errorf("bins = %+v, want one bin of 5 users", bins)
+	}
+}
+
+// Whatever the input, every published bin has at least minUsers users, no
+// event is counted twice, and nothing identifies a user
+func TestBuildHeatmapRandom(t *testing.T) {
+	rng := rand.New(rand.NewSource(1869))
+	users := make([]uuid.UUID, 40)
+	for i := range users {
+		users[i] = uuid.New()
+	}
+	for run := 0; run < 200; run++ {
+		minUsers := 2 + rng.Intn(6)
+		var samples []models.HeatmapSample
+		alerts := 0
+		for i := rng.Intn(300); i > 0; i-- {
+			cell := fmt.Sprintf("s1%c%c%c", geohashBase32[rng.Intn(3)], geohashBase32[rng.Intn(4)], geohashBase32[rng.Intn(8)])
+			s := models.HeatmapSample{Geohash: cell, UserID: users[rng.Intn(len(users))], Alerts: rng.Intn(3), LastGasps: rng.Intn(2)}
+			alerts += s.Alerts
+			samples = append(samples, s)
+		}
+
+		bins := BuildHeatmap(samples, minUsers)
+		published := 0
+		for i, b := range bins {
+			if b.Users < minUsers {
+				t.Fatalf("run %d: %s published with %d users, k = %d", run, b.Geohash, b.Users, minUsers)
+			}
+			if b.Precision != len(b.Geohash) || b.Precision < 3 {
+				t.Fatalf("run %d: %+v", run, b)
+			}
+			if i > 0 && bins[i-1].Geohash >= b.Geohash {
+				t.Fatalf("run %d: bins out of order or repeated at %s", run, b.Geohash)
+			}
+			published += b.Alerts
+		}
+		if published > alerts {
+			t.Fatalf("run %d: %d alerts published of %d", run, published, alerts)
+		}
+
+		out, _ := json.Marshal(bins)
+		for _, u := range users {
+			if strings.Contains(string(out), u.String()) {
+				t.Fatalf("run %d: user %s in the output", run, u)
+			}
+		}
+	}
+}
+
+func TestGeohash(t *testing.T) {
+	points := [][2]float64{{6.5244, 3.3792}, {9.0765, 7.3986}, {-33.9249, 18.4241}, {51.5074, -0.1278}, {0, 0}}
+	for _, p := range points {
+		hash := GeohashEncode(p[0], p[1], HeatmapMaxPrecision)
+		for n := HeatmapMinPrecision; n <= HeatmapMaxPrecision; n++ {
+			cell := hash[:n]
+			if GeohashEncode(p[0], p[1], n) != cell {
+				t.Errorf("GeohashEncode(%v, %d) isn't a prefix of the longer hash", p, n)
+			}
+			minLat, maxLat, minLng, maxLng := GeohashBounds(cell)
+			if p[0] < minLat || p[0] > maxLat || p[1] < minLng || p[1] > maxLng {
+				t.Errorf("%v outside its cell %s", p, cell)
+			}
+			if lat, lng := GeohashCenter(cell); GeohashEncode(lat, lng, n) != cell {
+				t.Errorf("center of %s encodes elsewhere", cell)
+			}
+		}
+	}
+	// Reference values of the standard geohash
+	if got := GeohashEncode(51.5074, -0.1278, 7); got != "gcpvj0d" {
+		t.Errorf("London = %s, want gcpvj0d", got)
+	}
+	if got := GeohashEncode(6.5244, 3.3792, 5); got != "s14mh" {
+		t.Errorf("Lagos = %s, want s14mh", got)
+	}
+}
@@ -0,0 +1,108 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/database"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+)
+
+// Integration tests run against the migrated Postgres of TEST_DATABASE_URL
+// and the Redis of TEST_REDIS_URL, and are skipped without them
+
+func testPostgres(t *testing.T) *database.PostgresDB {
+	t.Helper()
+	url := os.Getenv("TEST_DATABASE_URL")
+	if url == "" {
+		t.Skip("TEST_DATABASE_URL not set")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	db, err := database.NewPostgresDB(ctx, url, 0)
+	if err != nil {
+		t.Fatalf("NewPostgresDB: %v", err)
+	}
+	t.Cleanup(db.Close)
+	return db
+}
+
+// testRedis connects under a namespace of its own, so tests don't share keys
+func testRedis(t *testing.T) *database.RedisDB {
+	t.Helper()
+	url := os.Getenv("TEST_REDIS_URL")
+	if url == "" {
+		t.Skip("TEST_REDIS_URL not set")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	namespace := "test-" + strings.ReplaceAll(uuid.NewString(), "-", "")[:12]
+	r, err := database.NewRedisDB(ctx, url, namespace)
+	if err != nil {
+		t.Fatalf("NewRedisDB: %v", err)
+	}
+	t.Cleanup(func() { r.Close() })
+	return r
+}
+
+// testPhone returns a Nigerian mobile number no other test run uses
+func testPhone() string {
+	return fmt.Sprintf("+234809%07d", rand.Intn(10_000_000))
+}
+
+// createTestUser stores a user with a fresh phone number
+func createTestUser(t *testing.T, postgres *database.PostgresDB, name string) *models.User {
+	t.Helper()
+	now := time.Now()
+	user := &models.User{ID: uuid.New(), Phone: testPhone(), Name: name, CreatedAt: now, UpdatedAt: now}
+	if err := postgres.CreateUser(context.Background(), user); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	return user
+}
+
+// memoryObjectStore keeps objects in memory; Fail makes every Put fail
+type memoryObjectStore struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+	Fail    error
+}
+
+func newMemoryObjectStore() *memoryObjectStore {
+	return &memoryObjectStore{objects: make(map[string][]byte)}
+}
+
+func (s *memoryObjectStore) Put(ctx context.Context, key string, data []byte, contentType string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.Fail != nil {
+		return s.Fail
+	}
+	s.objects[key] = append([]byte(nil), data...)
+	return nil
+}
+
+func (s *memoryObjectStore) Get(ctx context.Context, key string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, ok := s.objects[key]
+	if !ok {
+		return nil, fmt.Errorf("object %s not found", key)
+	}
+	return data, nil
+}
+
+func (s *memoryObjectStore) Delete(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.objects, key)
+	return nil
+}
@@ -0,0 +1,391 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/config"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/database"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+)
+
+const (
+	// HomeViewBudget is the most a home view should encode to, so the home
+	// screen fits in a few packets on a slow connection
+	HomeViewBudget = 4 << 10
+	// homeReasonsMax is how many of the status reasons the home view carries
+	homeReasonsMax = 3
+	// homeTextMax and homeTextTrimmed are the runes of free text (alert
+	// reason, pause reason, watch note, landmark, requester name) the home
+	// view carries, and carries once it runs over its budget
+	homeTextMax     = 140
+	homeTextTrimmed = 40
+)
+
+// Kinds of pending check-in on the home view
+const (
+	HomeCheckInPrompt  = "prompt"        // a check-in prompt at CAUTION; answered with POST /check-in
+	HomeCheckInWelfare = "welfare_check" // a contact's welfare check; answered with POST /welfare-check/confirm
+)
+
+// HomeView is everything the app's home screen shows, in one response.
+// Every section is always present; null means the user has none, e.g. no
+// alert is active, rather than that it wasn't looked up. It has no relative
+// times, so it only changes when something about the user does.
+type HomeView struct {
+	UserID        uuid.UUID          `json:"user_id"`
+	Status        *HomeStatus        `json:"status"`         // null until the user was first evaluated
+	Location      *HomeLocation      `json:"location"`       // null until their first live heartbeat
+	Alert         *HomeAlert         `json:"alert"`          // null unless an alert is unresolved
+	Contacts      HomeContacts       `json:"contacts"`       // never null
+	Protection    HomeProtection     `json:"protection"`     // never null
+	NextHeartbeat *HomeNextHeartbeat `json:"next_heartbeat"` // null while none is expected, e.g. paused
+	CheckIn       *HomeCheckIn       `json:"check_in"`       // null unless the user is asked to check in
+
+	body    []byte
+	version string
+}
+
+// HomeStatus is the user's state, as GET /status has it, in brief
+type HomeStatus struct {
+	State          string          `json:"state"`
+	Score          int             `json:"score"`
+	Reasons        []models.Reason `json:"reasons"` // the most important few
+	SpoofSuspected bool            `json:"spoof_suspected"`
+	UpdatedAt      time.Time       `json:"updated_at"`
+}
+
+// HomeLocation is where the user's latest live heartbeat put them
+type HomeLocation struct {
+	Lat       float64   `json:"lat"`
+	Lng       float64   `json:"lng"`
+	AccuracyM int       `json:"accuracy_m"`
+	Landmark  *string   `json:"landmark"` // what the user said, for heartbeats without GPS
+	Source    string    `json:"source"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// HomeAlert is the user's newest unresolved alert
+type HomeAlert struct {
+	ID            uuid.UUID         `json:"id"`
+	State         models.AlertState `json:"state"`
+	Reason        string            `json:"reason"`
+	Duress        bool              `json:"duress"`
+	Undeliverable *string           `json:"undeliverable"` // why it reached nobody, see models.AlertNoRecipients
+	CreatedAt     time.Time         `json:"created_at"`
+}
+
+// HomeContacts counts the user's trusted contacts by whether they confirmed
+// their invitation
+type HomeContacts struct {
+	Total     int `json:"total"`
+	Confirmed int `json:"confirmed"`
+	Invited   int `json:"invited"`
+}
+
+// HomeProtection is whether the user is being watched over, and how closely
+type HomeProtection struct {
+	Status         string     `json:"status"` // complete | incomplete, see ProtectionStatusOf
+	Monitoring     bool       `json:"monitoring"`
+	Pause          *HomePause `json:"pause"` // null unless protection is paused
+	Watch          *HomeWatch `json:"watch"` // null unless a watch is on
+	LastGaspActive bool       `json:"last_gasp_active"`
+}
+
+// HomePause is the user's protection pause
+type HomePause struct {
+	Reason      *string   `json:"reason"`
+	PausedUntil time.Time `json:"paused_until"`
+}
+
+// HomeWatch is the user's watch session
+type HomeWatch struct {
+	Note      *string   `json:"note"`
+	StartedAt time.Time `json:"started_at"`
+	EndsAt    time.Time `json:"ends_at"`
+}
+
+// HomeNextHeartbeat is when the server next expects to hear from the user,
+// as advised to their client
+type HomeNextHeartbeat struct {
+	ExpectedBy      time.Time `json:"expected_by"`
+	IntervalSeconds int       `json:"interval_seconds"`
+	Reason          *string   `json:"reason"` // why the interval was advised, e.g. stationary
+}
+
+// HomeCheckIn is what the user is asked to answer; a check-in prompt or a
+// welfare check, whichever is due first
+type HomeCheckIn struct {
+	Kind        string    `json:"kind"` // prompt | welfare_check
+	ID          uuid.UUID `json:"id"`
+	RespondBy   time.Time `json:"respond_by"`
+	RequestedBy *string   `json:"requested_by"` // who asked for a welfare check; null for a prompt
+}
+
+// Body is the view encoded as JSON, within HomeViewBudget where it can be
+func (v *HomeView) Body() []byte {
+	return v.body
+}
+
+// Version changes whenever Body does
+func (v *HomeView) Version() string {
+	return v.version
+}
+
+// HomeViewService assembles the home view from one Postgres query and the
+// user's state and check-in prompt in Redis
+type HomeViewService struct {
+	cfg       *config.Store
+	postgres  *database.PostgresDB
+	redis     *database.RedisDB
+	evaluator *SafetyEvaluator
+}
+
+func NewHomeViewService(cfg *config.Store, postgres *database.PostgresDB, redis *database.RedisDB, evaluator *SafetyEvaluator) *HomeViewService {
+	return &HomeViewService{
+		cfg:       cfg,
+		postgres:  postgres,
+		redis:     redis,
+		evaluator: evaluator,
+	}
+}
+
+// Build assembles the user's home view. The Postgres query runs alongside the
+// Redis reads; the user, with their contacts, comes from the caller.
+func (s *HomeViewService) Build(ctx context.Context, user *models.User) (*HomeView, error) {
+	now := time.Now()
+
+	var (
+		wg          sync.WaitGroup
+		snapshot    *models.HomeSnapshot
+		snapshotErr error
+	)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		snapshot, snapshotErr = s.postgres.GetHomeSnapshot(ctx, user.ID, now)
+	}()
+
+	state, err := s.evaluator.CurrentState(ctx, user.ID)
+	if err != nil {
+		wg.Wait()
+		return nil, err
+	}
+	prompt, err := s.redis.GetPromptSession(ctx, user.ID)
+	wg.Wait()
+	if err != nil {
+		return nil, err
+	}
+	if snapshotErr != nil {
+		return nil, snapshotErr
+	}
+
+	view := newHomeView(user, state, snapshot, prompt, s.cfg.Current().HeartbeatIntervalSeconds)
+	if err := view.encode(); err != nil {
+		return nil, err
+	}
+	return view, nil
+}
+
+// MaxAge is how long the app may show the view without revalidating: the
+// heartbeat interval, as for GET /status, but not at all while something on
+// it needs the user's attention
+func (s *HomeViewService) MaxAge(v *HomeView) time.Duration {
+	if v.Alert != nil || v.CheckIn != nil {
+		return 0
+	}
+	if v.Status != nil {
+		switch v.Status.State {
+		case StateAtRisk, StateAlert, StateWaitLastGasp:
+			return 0
+		}
+	}
+	if v.NextHeartbeat != nil {
+		return time.Duration(v.NextHeartbeat.IntervalSeconds) * time.Second
+	}
+	return time.Duration(s.cfg.Current().HeartbeatIntervalSeconds) * time.Second
+}
+
+func newHomeView(user *models.User, state *models.UserState, snapshot *models.HomeSnapshot, prompt *models.PromptSession, defaultInterval int) *HomeView {
+	view := &HomeView{
+		UserID: user.ID,
+		Protection: HomeProtection{
+			Status:     ProtectionStatusOf(user),
+			Monitoring: snapshot.Pause == nil,
+		},
+	}
+
+	for _, contact := range user.TrustedContacts {
+		view.Contacts.Total++
+		if contact.Status == models.ContactStatusConfirmed {
+			view.Contacts.Confirmed++
+		} else {
+			view.Contacts.Invited++
+		}
+	}
+
+	if state != nil {
+		view.Status = &HomeStatus{
+			State:          state.State,
+			Score:          state.Score,
+			Reasons:        state.Reasons[:min(len(state.Reasons), homeReasonsMax)],
+			SpoofSuspected: state.SpoofSuspected,
+			UpdatedAt:      state.UpdatedAt,
+		}
+		if view.Status.Reasons == nil {
+			view.Status.Reasons = []models.Reason{}
+		}
+		view.Protection.LastGaspActive = state.LastGaspActive
+
+		if snapshot.Pause == nil && state.State != StatePaused && !state.LastHeartbeat.IsZero() {
+			interval := state.NextIntervalSeconds
+			if interval == 0 {
+				interval = defaultInterval
+			}
+			view.NextHeartbeat = &HomeNextHeartbeat{
+				ExpectedBy:      state.LastHeartbeat.Add(time.Duration(interval) * time.Second),
+				IntervalSeconds: interval,
+				Reason:          optionalText(state.NextIntervalReason),
+			}
+		}
+	}
+
+	if hb := snapshot.Heartbeat; hb != nil {
+		view.Location = &HomeLocation{
+			Lat:       hb.Lat,
+			Lng:       hb.Lng,
+			AccuracyM: hb.AccuracyM,
+			Landmark:  optionalText(hb.Landmark),
+			Source:    hb.Source,
+			Timestamp: hb.Timestamp,
+		}
+	}
+	if a := snapshot.Alert; a != nil {
+		view.Alert = &HomeAlert{
+			ID:            a.ID,
+			State:         a.State,
+			Reason:        a.Reason,
+			Duress:        a.Duress,
+			Undeliverable: optionalText(a.Undeliverable),
+			CreatedAt:     a.CreatedAt,
+		}
+	}
+	if p := snapshot.Pause; p != nil {
+		view.Protection.Pause = &HomePause{
+			Reason:      optionalText(p.Reason),
+			PausedUntil: p.PausedUntil,
+		}
+	}
+	if w := snapshot.Watch; w != nil {
+		view.Protection.Watch = &HomeWatch{
+			Note:      optionalText(w.Note),
+			StartedAt: w.StartedAt,
+			EndsAt:    w.EndsAt,
+		}
+	}
+
+	if prompt != nil {
+		view.CheckIn = &HomeCheckIn{
+			Kind:      HomeCheckInPrompt,
+			ID:        prompt.ID,
+			RespondBy: prompt.Deadline(),
+		}
+	}
+	if w := snapshot.WelfareCheck; w != nil && (view.CheckIn == nil || w.RespondBy.Before(view.CheckIn.RespondBy)) {
+		view.CheckIn = &HomeCheckIn{
+			Kind:        HomeCheckInWelfare,
+			ID:          w.ID,
+			RespondBy:   w.RespondBy,
+			RequestedBy: optionalText(w.RequesterName),
+		}
+	}
+
+	view.trimText(homeTextMax)
+	return view
+}
+
+// encode sets the view's body and version, shedding detail while the body is
+// over HomeViewBudget: status reasons past the first and their parameters,
+// then free text past homeTextTrimmed runes
+func (v *HomeView) encode() error {
+	sheds := []func(){
+		func() {
+			if v.Status != nil && len(v.Status.Reasons) > 1 {
+				v.Status.Reasons = v.Status.Reasons[:1]
+			}
+		},
+		func() {
+			if v.Status != nil {
+				reasons := make([]models.Reason, len(v.Status.Reasons))
+				for i, r := range v.Status.Reasons {
+					reasons[i] = models.Reason{Code: r.Code}
+				}
+				v.Status.Reasons = reasons
+			}
+		},
+		func() { v.trimText(homeTextTrimmed) },
+	}
+
+	body, err := json.Marshal(v)
+	for i := 0; err == nil && len(body) > HomeViewBudget && i < len(sheds); i++ {
+		sheds[i]()
+		body, err = json.Marshal(v)
+	}
+	if err != nil {
+		return err
+	}
+	if len(body) > HomeViewBudget {
+		log.Printf("WARN: Home view of user %s is %d bytes, over its %d byte budget", v.UserID, len(body), HomeViewBudget)
+	}
+
+	sum := sha256.Sum256(body)
+	v.body, v.version = body, hex.EncodeToString(sum[:12])
+	return nil
+}
+
+// trimText cuts the view's free text to limit runes
+func (v *HomeView) trimText(limit int) {
+	if v.Location != nil {
+		v.Location.Landmark = clipText(v.Location.Landmark, limit)
+	}
+	if v.Alert != nil {
+		v.Alert.Reason = *clipText(&v.Alert.Reason, limit)
+	}
+	if v.Protection.Pause != nil {
+		v.Protection.Pause.Reason = clipText(v.Protection.Pause.Reason, limit)
+	}
+	if v.Protection.Watch != nil {
+		v.Protection.Watch.Note = clipText(v.Protection.Watch.Note, limit)
+	}
+	if v.CheckIn != nil {
+		v.CheckIn.RequestedBy = clipText(v.CheckIn.RequestedBy, limit)
+	}
+}
+
+// clipText cuts text to limit runes, ending it with an ellipsis when cut
+func clipText(text *string, limit int) *string {
+	if text == nil {
+		return nil
+	}
+	runes := []rune(*text)
+	if len(runes) <= limit {
+		return text
+	}
+	clipped := string(runes[:limit-1]) + "…"
+	return &clipped
+}
+
+// optionalText is nil for empty text, which the home view shows as null
+func optionalText(text string) *string {
+	if text == "" {
+		return nil
+	}
+	return &text
+}
@@ -0,0 +1,273 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/config"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+)
+
+var homeNow = time.Date(2026, 3, 9, 18, 0, 0, 0, time.UTC)
+
+func encodedHomeView(t *testing.T, user *models.User, state *models.UserState, snapshot *models.HomeSnapshot, prompt *models.PromptSession) *HomeView {
+	t.Helper()
+	view := newHomeView(user, state, snapshot, prompt, 300)
+	if err := view.encode(); err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	return view
+}
+
+// A user with nothing to show gets every section, null where there is none,
+// so the app never has to tell a missing section from an empty one
+func TestHomeViewNulls(t *testing.T) {
+	view := encodedHomeView(t, &models.User{ID: uuid.New()}, nil, &models.HomeSnapshot{}, nil)
+
+	var body map[string]json.RawMessage
+	if err := json.Unmarshal(view.Body(), &body); err != nil {
+		t.Fatalf("body %s: %v", view.Body(), err)
+	}
+	for _, section := range []string{"status", "location", "alert", "next_heartbeat", "check_in"} {
+		if raw, ok := body[section]; !ok || string(raw) != "null" {
+			t.Errorf("%s = %s, want null", section, raw)
+		}
+	}
+	if got := string(body["contacts"]); got != `{"total":0,"confirmed":0,"invited":0}` {
+		t.Errorf("contacts = %s", got)
+	}
+	if got := string(body["protection"]); got != `{"status":"incomplete","monitoring":true,"pause":null,"watch":null,"last_gasp_active":false}` {
+		t.Errorf("protection = %s", got)
+	}
+}
+
+func TestHomeViewSections(t *testing.T) {
+	user := &models.User{ID: uuid.New(), TrustedContacts: models.TrustedContacts{
+		{ID: "c1", Status: models.ContactStatusConfirmed},
+		{ID: "c2", Status: models.ContactStatusInvited},
+		{ID: "c3", Status: models.ContactStatusConfirmed},
+	}}
+	state := &models.UserState{
+		UserID: user.ID, State: StateCaution, Score: 55, UpdatedAt: homeNow, LastHeartbeat: homeNow.Add(-time.Minute),
+		LastGaspActive: true, NextIntervalSeconds: 600, NextIntervalReason: "stationary",
+		Reasons: []models.Reason{{Code: "A"}, {Code: "B"}, {Code: "C"}, {Code: "D"}, {Code: "E"}},
+	}
+	snapshot := &models.HomeSnapshot{
+		Heartbeat: &models.Heartbeat{Lat: 6.5244, Lng: 3.3792, AccuracyM: 12, Source: "gps", Timestamp: homeNow.Add(-time.Minute)},
+		Alert:     &models.Alert{ID: uuid.New(), State: models.AlertStateAtRisk, Reason: "No heartbeat", CreatedAt: homeNow},
+		Watch:     &models.WatchSession{StartedAt: homeNow.Add(-time.Hour), EndsAt: homeNow.Add(time.Hour)},
+	}
+	view := newHomeView(user, state, snapshot, nil, 300)
+
+	if view.Contacts != (HomeContacts{Total: 3, Confirmed: 2, Invited: 1}) {
+		t.Errorf("contacts = %+v", view.Contacts)
+	}
+	if view.Protection.Status != ProtectionComplete || !view.Protection.Monitoring || !view.Protection.LastGaspActive ||
+		view.Protection.Watch == nil || view.Protection.Watch.Note != nil {
+		t.Errorf("protection = %+v", view.Protection)
+	}
+	if view.Status == nil || len(view.Status.Reasons) != homeReasonsMax || len(state.Reasons) != 5 {
+		t.Errorf("status = %+v; want %d reasons, the state's left alone", view.Status, homeReasonsMax)
+	}
+	if next := view.NextHeartbeat; next == nil || next.IntervalSeconds != 600 || !next.ExpectedBy.Equal(homeNow.Add(9*time.Minute)) ||
+		next.Reason == nil || *next.Reason != "stationary" {
+		t.Errorf("next heartbeat = %+v, want 600s after the last one, for being stationary", next)
+	}
+	if view.Location == nil || view.Location.Landmark != nil || view.Location.Source != "gps" {
+		t.Errorf("location = %+v", view.Location)
+	}
+	if view.Alert == nil || view.Alert.ID != snapshot.Alert.ID || view.Alert.Undeliverable != nil {
+		t.Errorf("alert = %+v", view.Alert)
+	}
+
+	// Without an advised interval the configured one is expected
+	state.NextIntervalSeconds = 0
+	if next := newHomeView(user, state, snapshot, nil, 300).NextHeartbeat; next == nil || next.IntervalSeconds != 300 {
+		t.Errorf("next heartbeat = %+v, want the default 300s", next)
+	}
+
+	// Paused, nobody is monitoring and no heartbeat is expected
+	snapshot.Pause = &models.ProtectionPause{Reason: "at the cinema", PausedUntil: homeNow.Add(2 * time.Hour)}
+	paused := newHomeView(user, state, snapshot, nil, 300)
+	if paused.Protection.Monitoring || paused.Protection.Pause == nil || *paused.Protection.Pause.Reason != "at the cinema" || paused.NextHeartbeat != nil {
+		t.Errorf("paused = %+v, next heartbeat %+v", paused.Protection, paused.NextHeartbeat)
+	}
+}
+
+// A check-in prompt and a welfare check may both be pending; the one due
+// first is shown
+func TestHomeViewCheckIn(t *testing.T) {
+	user := &models.User{ID: uuid.New()}
+	prompt := &models.PromptSession{ID: uuid.New(), Ladder: []string{"push", "sms"}, Timeout: 2 * time.Minute, Due: homeNow}
+	welfare := func(in time.Duration) *models.HomeSnapshot {
+		return &models.HomeSnapshot{WelfareCheck: &models.WelfareCheck{ID: uuid.New(), RequesterName: "Emeka", RespondBy: homeNow.Add(in)}}
+	}
+
+	if c := newHomeView(user, nil, &models.HomeSnapshot{}, prompt, 300).CheckIn; c == nil || c.Kind != HomeCheckInPrompt ||
+		c.ID != prompt.ID || !c.RespondBy.Equal(homeNow.Add(4*time.Minute)) || c.RequestedBy != nil {
+		t.Errorf("prompt check-in = %+v", c)
+	}
+	if c := newHomeView(user, nil, welfare(time.Minute), prompt, 300).CheckIn; c == nil || c.Kind != HomeCheckInWelfare ||
+		c.RequestedBy == nil || *c.RequestedBy != "Emeka" {
+		t.Errorf("check-in with the welfare check due first = %+v", c)
+	}
+	if c := newHomeView(user, nil, welfare(10*time.Minute), prompt, 300).CheckIn; c == nil || c.Kind != HomeCheckInPrompt {
+		t.Errorf("check-in with the prompt due first = %+v", c)
+	}
+}
+
+// Long free text is clipped, and a view still over its budget sheds status
+// reasons past the first, then their parameters, then text, until it fits
+func TestHomeViewBudget(t *testing.T) {
+	user := &models.User{ID: uuid.New()}
+	withText := func(text string) *models.HomeSnapshot {
+		return &models.HomeSnapshot{
+			Heartbeat:    &models.Heartbeat{Landmark: text, Timestamp: homeNow},
+			Alert:        &models.Alert{ID: uuid.New(), Reason: text, CreatedAt: homeNow},
+			Pause:        &models.ProtectionPause{Reason: text, PausedUntil: homeNow.Add(time.Hour)},
+			Watch:        &models.WatchSession{Note: text, EndsAt: homeNow.Add(time.Hour)},
+			WelfareCheck: &models.WelfareCheck{ID: uuid.New(), RequesterName: text, RespondBy: homeNow},
+		}
+	}
+	reasons := make([]models.Reason, 10)
+	for i := range reasons {
+		reasons[i] = models.Reason{Code: models.ReasonHeartbeatStale, Params: map[string]interface{}{"detail": strings.Repeat("x", 3000)}}
+	}
+	state := &models.UserState{UserID: user.ID, State: StateAtRisk, Reasons: reasons, UpdatedAt: homeNow}
+	runes := func(text string) int { return len([]rune(text)) }
+
+	// Long text is clipped to the longer limit, and bulky reasons go first
+	view := encodedHomeView(t, user, state, withText(strings.Repeat("Ọ̀ṣun ", 100)), nil)
+	if n := len(view.Body()); n > HomeViewBudget {
+		t.Fatalf("body is %d bytes, over the %d byte budget", n, HomeViewBudget)
+	}
+	if len(view.Status.Reasons) != 1 || view.Status.Reasons[0].Params != nil {
+		t.Errorf("reasons = %v, want the first, without parameters", view.Status.Reasons)
+	}
+	if got := view.Alert.Reason; runes(got) != homeTextMax || !strings.HasSuffix(got, "…") {
+		t.Errorf("alert reason = %q, want %d runes ending in an ellipsis", got, homeTextMax)
+	}
+	if len(state.Reasons) != 10 || state.Reasons[0].Params == nil {
+		t.Error("the state's reasons were changed")
+	}
+
+	// Text that escapes to six bytes a character is clipped further
+	view = encodedHomeView(t, user, nil, withText(strings.Repeat("<>", 100)), nil)
+	if n := len(view.Body()); n > HomeViewBudget {
+		t.Fatalf("body is %d bytes, over the %d byte budget", n, HomeViewBudget)
+	}
+	for name, text := range map[string]string{
+		"landmark": *view.Location.Landmark, "alert reason": view.Alert.Reason, "pause reason": *view.Protection.Pause.Reason,
+		"watch note": *view.Protection.Watch.Note, "requester": *view.CheckIn.RequestedBy,
+	} {
+		if runes(text) != homeTextTrimmed {
+			t.Errorf("%s is %d runes, want %d", name, runes(text), homeTextTrimmed)
+		}
+	}
+
+	// Short text is left alone
+	view = encodedHomeView(t, user, nil, withText("Ikeja"), nil)
+	if view.Alert.Reason != "Ikeja" || *view.Location.Landmark != "Ikeja" {
+		t.Errorf("short text = %q, %q", view.Alert.Reason, *view.Location.Landmark)
+	}
+}
+
+func TestHomeViewVersion(t *testing.T) {
+	user := &models.User{ID: uuid.New()}
+	state := &models.UserState{UserID: user.ID, State: StateSafe, Score: 90, UpdatedAt: homeNow, LastHeartbeat: homeNow}
+	a := encodedHomeView(t, user, state, &models.HomeSnapshot{}, nil)
+	b := encodedHomeView(t, user, state, &models.HomeSnapshot{}, nil)
+	if a.Version() == "" || a.Version() != b.Version() {
+		t.Errorf("versions of the same view = %q, %q", a.Version(), b.Version())
+	}
+	state.Score = 85
+	if c := encodedHomeView(t, user, state, &models.HomeSnapshot{}, nil); c.Version() == a.Version() {
+		t.Error("version unchanged with the score")
+	}
+}
+
+// The app reuses a calm view for the heartbeat interval, and revalidates one
+// needing attention every time
+func TestHomeViewMaxAge(t *testing.T) {
+	s := &HomeViewService{cfg: config.NewStore(&config.Config{HeartbeatIntervalSeconds: 300})}
+	next := &HomeNextHeartbeat{IntervalSeconds: 600}
+	tests := []struct {
+		name string
+		view HomeView
+		want time.Duration
+	}{
+		{"new user", HomeView{}, 300 * time.Second},
+		{"safe", HomeView{Status: &HomeStatus{State: StateSafe}, NextHeartbeat: next}, 600 * time.Second},
+		{"caution", HomeView{Status: &HomeStatus{State: StateCaution}}, 300 * time.Second},
+		{"at risk", HomeView{Status: &HomeStatus{State: StateAtRisk}, NextHeartbeat: next}, 0},
+		{"alert", HomeView{Status: &HomeStatus{State: StateAlert}}, 0},
+		{"waiting after a LastGasp", HomeView{Status: &HomeStatus{State: StateWaitLastGasp}}, 0},
+		{"active alert", HomeView{Alert: &HomeAlert{}, NextHeartbeat: next}, 0},
+		{"check-in pending", HomeView{CheckIn: &HomeCheckIn{}, NextHeartbeat: next}, 0},
+	}
+	for _, tt := range tests {
+		if got := s.MaxAge(&tt.view); got != tt.want {
+			t.Errorf("%s: max age = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+// Build reads each section from the store it lives in
+func TestHomeViewBuild(t *testing.T) {
+	postgres := testPostgres(t)
+	redis := testRedis(t)
+	ctx := context.Background()
+	cfg := config.NewStore(&config.Config{HeartbeatIntervalSeconds: 300})
+	se := &SafetyEvaluator{cfg: cfg, postgres: postgres, redis: redis, clock: SystemClock{}, effects: discardEffects{}}
+	s := NewHomeViewService(cfg, postgres, redis, se)
+	user := createTestUser(t, postgres, "Ada")
+
+	empty, err := s.Build(ctx, user)
+	if err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	if empty.Status != nil || empty.Location != nil || empty.Alert != nil || empty.CheckIn != nil || empty.Protection.Pause != nil {
+		t.Errorf("new user's view = %s", empty.Body())
+	}
+
+	hb := simulatedHeartbeat(0, false)
+	hb.UserID, hb.Timestamp = user.ID, time.Now().Add(-time.Minute).Truncate(time.Microsecond)
+	if err := postgres.CreateHeartbeat(ctx, &hb); err != nil {
+		t.Fatalf("CreateHeartbeat: %v", err)
+	}
+	alert := &models.Alert{ID: uuid.New(), UserID: user.ID, State: models.AlertStateAtRisk, Reason: "No heartbeat",
+		Reasons: models.Reasons{{Code: models.ReasonHeartbeatStale}}, SentTo: []string{}, CreatedAt: time.Now(), DetectedAt: time.Now()}
+	if err := postgres.CreateAlert(ctx, alert); err != nil {
+		t.Fatalf("CreateAlert: %v", err)
+	}
+	state := &models.UserState{UserID: user.ID, State: StateAtRisk, Score: 30, LastHeartbeat: hb.Timestamp, UpdatedAt: time.Now(),
+		Reasons: []models.Reason{{Code: models.ReasonHeartbeatStale}}}
+	if err := redis.SaveUserState(ctx, state); err != nil {
+		t.Fatalf("SaveUserState: %v", err)
+	}
+
+	view, err := s.Build(ctx, user)
+	if err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	if view.Status == nil || view.Status.State != StateAtRisk {
+		t.Errorf("status = %+v, want AT_RISK from Redis", view.Status)
+	}
+	if view.Location == nil || view.Location.Lat != hb.Lat || !view.Location.Timestamp.Equal(hb.Timestamp) {
+		t.Errorf("location = %+v, want the heartbeat's", view.Location)
+	}
+	if view.Alert == nil || view.Alert.ID != alert.ID {
+		t.Errorf("alert = %+v, want %s", view.Alert, alert.ID)
+	}
+	if view.NextHeartbeat == nil || !view.NextHeartbeat.ExpectedBy.Equal(hb.Timestamp.Add(5*time.Minute)) {
+		t.Errorf("next heartbeat = %+v", view.NextHeartbeat)
+	}
+	if view.Version() == empty.Version() || s.MaxAge(view) != 0 {
+		t.Errorf("version %s (was %s), max age %s", view.Version(), empty.Version(), s.MaxAge(view))
+	}
+}
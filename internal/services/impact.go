@@ -0,0 +1,189 @@
+package services
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+)
+
+// standardGravity converts accelerometer readings (m/s², gravity included, as
+// reported by the apps) to g
+const standardGravity = 9.80665
+
+const (
+	// impactSettleTime is how long after a spike the phone may keep moving
+	// (rolling, sliding) before it must come to rest
+	impactSettleTime = 5 * time.Second
+	// impactFreeFallWindow is how far before a spike a free fall is looked for
+	impactFreeFallWindow = 2 * time.Second
+	// freeFallMaxG is the magnitude below which the phone is falling freely
+	freeFallMaxG = 0.3
+	// stillToleranceG is how far from 1g a resting phone's reading may be
+	stillToleranceG = 0.15
+	// stillMaxGyro is the rotation rate (rad/s) below which the phone is at rest
+	stillMaxGyro = 0.3
+)
+
+// ImpactParams configures crash-signature detection
+type ImpactParams struct {
+	ThresholdG float64       // minimum acceleration magnitude of the impact
+	StillFor   time.Duration // how long the phone must then lie motionless
+}
+
+// ImpactEvent is a crash signature found in a sensor trail
+type ImpactEvent struct {
+	At       time.Time     `json:"at"`
+	PeakG    float64       `json:"peak_g"`
+	StillFor time.Duration `json:"still_for"`
+}
+
+// DetectImpact looks for a crash signature: a spike above ThresholdG followed,
+// within a few seconds, by at least StillFor of no movement at all. Potholes
+// fail the stillness test because the vehicle keeps moving; dropped phones are
+// recognized by the free fall before the spike. Entries without sensor data
+// are ignored. Returns the first impact found, or nil.
+func DetectImpact(entries []models.BlackboxEntry, params ImpactParams) *ImpactEvent {
+	samples := make([]models.BlackboxEntry, 0, len(entries))
+	for _, e := range entries {
+		if e.SensorData != (models.SensorData{}) {
+			samples = append(samples, e)
+		}
+	}
+	sort.SliceStable(samples, func(i, j int) bool {
+		return samples[i].Timestamp.Before(samples[j].Timestamp)
+	})
+
+	for i := 0; i < len(samples); i++ {
+		peak := accelG(samples[i].SensorData)
+		if peak < params.ThresholdG {
+			continue
+		}
+
+		// A spike spans several samples; take the strongest and continue from
+		// the last one above the threshold
+		at := samples[i].Timestamp
+		end := i
+		for end+1 < len(samples) && accelG(samples[end+1].SensorData) >= params.ThresholdG {
+			end++
+			if g := accelG(samples[end].SensorData); g > peak {
+				peak, at = g, samples[end].Timestamp
+			}
+		}
+
+		if !fellBefore(samples, i, impactFreeFallWindow) {
+			if still := stillAfter(samples, end, impactSettleTime); still >= params.StillFor {
+				return &ImpactEvent{At: at, PeakG: math.Round(peak*10) / 10, StillFor: still}
+			}
+		}
+		i = end
+	}
+	return nil
+}
+
+const (
+	// sensorWindowMargin keeps a little more of a live stream than a crash
+	// signature spans, for samples that arrive late
+	sensorWindowMargin = 5 * time.Second
+	// sensorWindowMaxSamples caps a live window whatever rate the phone samples at
+	sensorWindowMaxSamples = 10000
+)
+
+// SensorWindow holds the latest sensor samples streamed from a phone, enough
+// to find a crash signature in as they arrive: the free fall before a spike,
+// the settle time after it and the stillness that confirms it
+type SensorWindow struct {
+	span    time.Duration
+	samples []models.BlackboxEntry
+}
+
+// NewSensorWindow returns an empty window for crash signatures confirmed by
+// stillFor of stillness
+func NewSensorWindow(stillFor time.Duration) *SensorWindow {
+	return &SensorWindow{span: impactFreeFallWindow + impactSettleTime + stillFor + sensorWindowMargin}
+}
+
+// Add appends a sample, which must not be older than the newest one, and
+// forgets the samples that fell out of the window
+func (w *SensorWindow) Add(e models.BlackboxEntry) {
+	w.samples = append(w.samples, e)
+	from := e.Timestamp.Add(-w.span)
+	drop := 0
+	for drop < len(w.samples) && w.samples[drop].Timestamp.Before(from) {
+		drop++
+	}
+	drop = max(drop, len(w.samples)-sensorWindowMaxSamples)
+	if drop > 0 {
+		w.samples = append(w.samples[:0], w.samples[drop:]...)
+	}
+}
+
+// Newest returns the newest sample's timestamp, zero while the window is empty
+func (w *SensorWindow) Newest() time.Time {
+	if len(w.samples) == 0 {
+		return time.Time{}
+	}
+	return w.samples[len(w.samples)-1].Timestamp
+}
+
+// Samples returns the samples in the window, oldest first
+func (w *SensorWindow) Samples() []models.BlackboxEntry {
+	return w.samples
+}
+
+// Forget drops the samples up to and including until, so an impact found
+// in them isn't found again
+func (w *SensorWindow) Forget(until time.Time) {
+	drop := 0
+	for drop < len(w.samples) && !w.samples[drop].Timestamp.After(until) {
+		drop++
+	}
+	w.samples = append(w.samples[:0], w.samples[drop:]...)
+}
+
+// fellBefore reports whether the phone was in free fall shortly before samples[i]
+func fellBefore(samples []models.BlackboxEntry, i int, window time.Duration) bool {
+	from := samples[i].Timestamp.Add(-window)
+	for j := i - 1; j >= 0 && !samples[j].Timestamp.Before(from); j-- {
+		if accelG(samples[j].SensorData) < freeFallMaxG {
+			return true
+		}
+	}
+	return false
+}
+
+// stillAfter returns how long the phone lay motionless after samples[end],
+// counting from the first resting sample within settle of the spike. Zero
+// means it never came to rest in time.
+func stillAfter(samples []models.BlackboxEntry, end int, settle time.Duration) time.Duration {
+	spike := samples[end].Timestamp
+	start := -1
+	for j := end + 1; j < len(samples); j++ {
+		if samples[j].Timestamp.Sub(spike) > settle {
+			break
+		}
+		if atRest(samples[j].SensorData) {
+			start = j
+			break
+		}
+	}
+	if start < 0 {
+		return 0
+	}
+
+	last := start
+	for j := start + 1; j < len(samples) && atRest(samples[j].SensorData); j++ {
+		last = j
+	}
+	return samples[last].Timestamp.Sub(samples[start].Timestamp)
+}
+
+func atRest(s models.SensorData) bool {
+	return math.Abs(accelG(s)-1) <= stillToleranceG &&
+		math.Sqrt(s.GyroX*s.GyroX+s.GyroY*s.GyroY+s.GyroZ*s.GyroZ) <= stillMaxGyro
+}
+
+func accelG(s models.SensorData) float64 {
+	return math.Sqrt(s.AccelX*s.AccelX+s.AccelY*s.AccelY+s.AccelZ*s.AccelZ) / standardGravity
+}
@@ -0,0 +1,311 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/config"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/database"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+)
+
+// ErrImpactQueueFull is returned when no more trails can be queued for analysis
+var ErrImpactQueueFull = errors.New("impact analysis queue full")
+
+const (
+	impactAnalyzerWorker = "impact_analyzer"
+	impactAnalyzerBeat   = time.Minute
+	impactQueueSize      = 1000
+	impactAnalysisTime   = 30 * time.Second
+	// impactBacklogLimit caps how many unanalyzed trails are requeued at startup
+	impactBacklogLimit = 500
+)
+
+// ImpactAnalyzer scans uploaded blackbox trails for crash signatures and
+// raises an alert when one is corroborated by the user's heartbeat timeline.
+// Each trail is analyzed at most once; trails still queued at shutdown are
+// picked up again on the next start.
+type ImpactAnalyzer struct {
+	cfg       *config.Store
+	postgres  *database.PostgresDB
+	redis     *database.RedisDB
+	evaluator *SafetyEvaluator
+	store     ObjectStore // nil without object storage
+	health    *HealthRegistry
+	queue     chan uuid.UUID
+
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+}
+
+func NewImpactAnalyzer(
+	cfg *config.Store,
+	postgres *database.PostgresDB,
+	redis *database.RedisDB,
+	evaluator *SafetyEvaluator,
+	store ObjectStore,
+	health *HealthRegistry,
+) *ImpactAnalyzer {
+	return &ImpactAnalyzer{
+		cfg:       cfg,
+		postgres:  postgres,
+		redis:     redis,
+		evaluator: evaluator,
+		store:     store,
+		health:    health,
+		queue:     make(chan uuid.UUID, impactQueueSize),
+	}
+}
+
+// Start launches the workers and requeues trails left unanalyzed by a previous run
+func (a *ImpactAnalyzer) Start() {
+	a.health.Register(impactAnalyzerWorker, impactAnalyzerBeat)
+	for i := 0; i < a.cfg.Current().ImpactWorkers; i++ {
+		a.wg.Add(1)
+		go a.run()
+	}
+	go a.requeueBacklog()
+}
+
+// Enqueue queues a trail without blocking
+func (a *ImpactAnalyzer) Enqueue(trailID uuid.UUID) error {
+	select {
+	case a.queue <- trailID:
+		return nil
+	default:
+		return ErrImpactQueueFull
+	}
+}
+
+// Close stops accepting trails and waits for the queued ones to be analyzed
+func (a *ImpactAnalyzer) Close() {
+	a.closeOnce.Do(func() {
+		close(a.queue)
+	})
+	a.wg.Wait()
+}
+
+func (a *ImpactAnalyzer) run() {
+	defer a.wg.Done()
+
+	ticker := time.NewTicker(impactAnalyzerBeat)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case trailID, ok := <-a.queue:
+			if !ok {
+				return
+			}
+			a.analyze(trailID)
+			a.health.Beat(impactAnalyzerWorker)
+		case <-ticker.C:
+			a.health.Beat(impactAnalyzerWorker)
+		}
+	}
+}
+
+func (a *ImpactAnalyzer) requeueBacklog() {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	since := time.Now().Add(-time.Duration(a.cfg.Current().BlackboxRetentionHours) * time.Hour)
+	ids, err := a.postgres.GetUncheckedBlackboxTrailIDs(ctx, since, impactBacklogLimit)
+	if err != nil {
+		log.Printf("ERROR: Failed to load unanalyzed blackbox trails: %v", err)
+		return
+	}
+	for _, id := range ids {
+		if err := a.Enqueue(id); err != nil {
+			log.Printf("WARN: Impact analysis backlog truncated: %v", err)
+			return
+		}
+	}
+	if len(ids) > 0 {
+		log.Printf("INFO: Requeued %d blackbox trails for impact analysis", len(ids))
+	}
+}
+
+// analyze claims the trail and checks it, releasing the claim on failure so
+// the trail is retried on the next start
+func (a *ImpactAnalyzer) analyze(trailID uuid.UUID) {
+	ctx, cancel := context.WithTimeout(context.Background(), impactAnalysisTime)
+	defer cancel()
+
+	claimed, err := a.postgres.ClaimBlackboxTrailImpactCheck(ctx, trailID)
+	if err != nil {
+		log.Printf("ERROR: Failed to claim trail %s for impact analysis: %v", trailID, err)
+		return
+	}
+	if !claimed {
+		return
+	}
+
+	if err := a.check(ctx, trailID); err != nil {
+		log.Printf("ERROR: Impact analysis of trail %s failed: %v", trailID, err)
+		if err := a.postgres.ReleaseBlackboxTrailImpactCheck(context.Background(), trailID); err != nil {
+			log.Printf("ERROR: Failed to release trail %s for impact analysis: %v", trailID, err)
+		}
+	}
+}
+
+func (a *ImpactAnalyzer) check(ctx context.Context, trailID uuid.UUID) error {
+	trail, err := a.postgres.GetBlackboxTrail(ctx, trailID)
+	if err != nil {
+		return err
+	}
+	if trail == nil {
+		return nil
+	}
+	if trail.IntegrityStatus == models.TrailBroken || trail.IntegrityStatus == models.TrailBadSignature {
+		// Data that may have been altered must not raise an alert
+		log.Printf("INFO: Skipping impact analysis of trail %s: integrity %s", trailID, trail.IntegrityStatus)
+		return nil
+	}
+
+	// Only accepted data points are stored at file_url; quarantined ones,
+	// such as NaN accelerometer bursts, are never read here, so a sensor
+	// glitch can't pass for an impact
+	entries, err := LoadBlackboxEntries(ctx, a.store, trail.FileURL)
+	if errors.Is(err, ErrObjectStoreDisabled) {
+		log.Printf("WARN: Skipping impact analysis of trail %s: %v", trailID, err)
+		return nil
+	}
+	if err != nil && !IsInlineTrail(trail.FileURL) {
+		// Object storage may only be unreachable for now
+		return err
+	}
+	if err != nil {
+		// Retrying won't help a trail that can't be decoded
+		log.Printf("WARN: Skipping impact analysis of trail %s: %v", trailID, err)
+		return nil
+	}
+
+	cfg := a.cfg.Current()
+	event := DetectImpact(entries, ImpactParams{
+		ThresholdG: cfg.ImpactThresholdG,
+		StillFor:   time.Duration(cfg.ImpactStillSeconds) * time.Second,
+	})
+	if event == nil {
+		return nil
+	}
+	if err := a.postgres.SetBlackboxTrailImpact(ctx, trailID, event.At); err != nil {
+		return err
+	}
+	log.Printf("INFO: Impact of %.1fg at %s in trail %s for user %s, still for %s",
+		event.PeakG, event.At.Format(time.RFC3339), trailID, trail.UserID, event.StillFor)
+
+	escalate, why, err := a.shouldEscalate(ctx, trail.UserID, event.At, cfg)
+	if err != nil {
+		return err
+	}
+	if !escalate {
+		log.Printf("INFO: Not alerting on impact for user %s: %s", trail.UserID, why)
+		return nil
+	}
+
+	reason := models.Reason{Code: models.ReasonImpact, Params: map[string]interface{}{
+		"at": event.At.UTC().Format(time.RFC3339),
+	}}
+	return a.evaluator.RaiseImpact(ctx, trail.UserID, reason)
+}
+
+// AnalyzeLive looks for a crash signature in sensor samples the user's phone
+// is streaming during an alert, and raises an alert on one as a trail's
+// impact would. A phone streaming live doesn't go silent after a crash, so
+// lying still after the spike is all that corroborates it. It returns the
+// impact found, whether or not it was escalated, or nil.
+func (a *ImpactAnalyzer) AnalyzeLive(ctx context.Context, userID uuid.UUID, samples []models.BlackboxEntry) (*ImpactEvent, error) {
+	cfg := a.cfg.Current()
+	event := DetectImpact(samples, ImpactParams{
+		ThresholdG: cfg.ImpactThresholdG,
+		StillFor:   time.Duration(cfg.ImpactStillSeconds) * time.Second,
+	})
+	if event == nil {
+		return nil, nil
+	}
+	log.Printf("INFO: Impact of %.1fg at %s streamed by user %s, still for %s",
+		event.PeakG, event.At.Format(time.RFC3339), userID, event.StillFor)
+
+	why, err := a.escalationBlocked(ctx, userID, event.At)
+	if err != nil {
+		return event, err
+	}
+	if why != "" {
+		log.Printf("INFO: Not alerting on impact for user %s: %s", userID, why)
+		return event, nil
+	}
+
+	reason := models.Reason{Code: models.ReasonImpact, Params: map[string]interface{}{
+		"at": event.At.UTC().Format(time.RFC3339),
+	}}
+	return event, a.evaluator.RaiseImpact(ctx, userID, reason)
+}
+
+// shouldEscalate decides whether an impact in a trail warrants an alert:
+// nothing may block it, and the device must have sent a LastGasp or gone
+// silent around it
+func (a *ImpactAnalyzer) shouldEscalate(ctx context.Context, userID uuid.UUID, at time.Time, cfg *config.Config) (bool, string, error) {
+	why, err := a.escalationBlocked(ctx, userID, at)
+	if err != nil || why != "" {
+		return false, why, err
+	}
+
+	window := time.Duration(cfg.HeartbeatWindowSeconds) * time.Second
+	heartbeats, err := a.postgres.GetHeartbeatsBetween(ctx, userID, at.Add(-time.Minute), at.Add(window), 100, false)
+	if err != nil {
+		return false, "", err
+	}
+	if !impactCorroborated(heartbeats, at) {
+		return false, "device kept sending heartbeats after the impact", nil
+	}
+	return true, "", nil
+}
+
+// escalationBlocked returns why an impact at the given time must not raise
+// an alert, or "" if nothing stops it: the user must currently be outside
+// SAFE and PAUSED and must not have resolved an alert since the impact
+func (a *ImpactAnalyzer) escalationBlocked(ctx context.Context, userID uuid.UUID, at time.Time) (string, error) {
+	state, err := a.evaluator.CurrentState(ctx, userID)
+	if err != nil {
+		return "", err
+	}
+	if state == nil || state.State == StateSafe {
+		return "user is SAFE", nil
+	}
+	if state.State == StatePaused {
+		// Silence is expected while paused, so it corroborates nothing
+		return "protection is paused", nil
+	}
+
+	latest, err := a.postgres.GetLatestAlert(ctx, userID)
+	if err != nil {
+		return "", err
+	}
+	if latest != nil && latest.ResolvedAt != nil && latest.ResolvedAt.After(at) {
+		return "an alert was resolved after the impact", nil
+	}
+	return "", nil
+}
+
+// impactCorroborated reports whether the heartbeat timeline around an impact
+// shows a LastGasp or silence after it
+func impactCorroborated(heartbeats []models.Heartbeat, at time.Time) bool {
+	heard := false
+	for _, hb := range heartbeats {
+		if hb.Source == "blackbox" {
+			continue
+		}
+		if hb.LastGasp {
+			return true
+		}
+		if hb.Timestamp.After(at) {
+			heard = true
+		}
+	}
+	return !heard
+}
@@ -0,0 +1,208 @@
+package services
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+)
+
+var impactParams = ImpactParams{ThresholdG: 4, StillFor: 30 * time.Second}
+
+// sampleInterval is the 50 Hz rate the apps record sensors at
+const sampleInterval = 20 * time.Millisecond
+
+// trace builds a synthetic sensor trail one phase at a time
+type trace struct {
+	at      time.Time
+	entries []models.BlackboxEntry
+}
+
+func newTrace() *trace {
+	return &trace{at: time.Date(2026, 3, 9, 18, 0, 0, 0, time.UTC)}
+}
+
+// phase records d of samples from reading, given the sample's index
+func (tr *trace) phase(d time.Duration, reading func(i int) models.SensorData) *trace {
+	for i := 0; i < int(d/sampleInterval); i++ {
+		tr.entries = append(tr.entries, models.BlackboxEntry{Timestamp: tr.at, SensorData: reading(i)})
+		tr.at = tr.at.Add(sampleInterval)
+	}
+	return tr
+}
+
+// Readings in m/s² and rad/s, gravity included
+func atRestReading(int) models.SensorData {
+	return models.SensorData{AccelZ: standardGravity}
+}
+
+func drivingReading(i int) models.SensorData {
+	return models.SensorData{
+		AccelX: 1.2 * math.Sin(float64(i)/3),
+		AccelZ: standardGravity + 2*math.Sin(float64(i)),
+		GyroZ:  0.4 + 0.2*math.Cos(float64(i)/5),
+	}
+}
+
+func freeFallReading(int) models.SensorData {
+	return models.SensorData{AccelZ: 0.05 * standardGravity, GyroX: 2}
+}
+
+func tumblingReading(i int) models.SensorData {
+	return models.SensorData{AccelX: 6 * math.Sin(float64(i)), AccelZ: standardGravity, GyroX: 3, GyroY: 2}
+}
+
+func spikeReading(g float64) func(int) models.SensorData {
+	return func(int) models.SensorData {
+		return models.SensorData{AccelY: g * standardGravity, GyroX: 5}
+	}
+}
+
+func TestDetectImpact(t *testing.T) {
+	tests := []struct {
+		name  string
+		trace *trace
+		want  bool
+	}{
+		{
+			name: "pothole",
+			trace: newTrace().phase(10*time.Second, drivingReading).
+				phase(60*time.Millisecond, spikeReading(5.5)).
+				phase(40*time.Second, drivingReading),
+		},
+		{
+			name: "pothole then parked",
+			trace: newTrace().phase(10*time.Second, drivingReading).
+				phase(60*time.Millisecond, spikeReading(5.5)).
+				phase(20*time.Second, drivingReading).
+				phase(60*time.Second, atRestReading),
+		},
+		{
+			name: "phone dropped",
+			trace: newTrace().phase(5*time.Second, drivingReading).
+				phase(400*time.Millisecond, freeFallReading).
+				phase(40*time.Millisecond, spikeReading(9)).
+				phase(time.Second, tumblingReading).
+				phase(60*time.Second, atRestReading),
+		},
+		{
+			name: "impact",
+			trace: newTrace().phase(10*time.Second, drivingReading).
+				phase(100*time.Millisecond, spikeReading(12)).
+				phase(2*time.Second, tumblingReading).
+				phase(60*time.Second, atRestReading),
+			want: true,
+		},
+		{
+			name: "impact, then driven away",
+			trace: newTrace().phase(10*time.Second, drivingReading).
+				phase(100*time.Millisecond, spikeReading(12)).
+				phase(2*time.Second, tumblingReading).
+				phase(10*time.Second, atRestReading).
+				phase(30*time.Second, drivingReading),
+		},
+		{
+			name: "impact, still too late",
+			trace: newTrace().phase(10*time.Second, drivingReading).
+				phase(100*time.Millisecond, spikeReading(12)).
+				phase(8*time.Second, tumblingReading).
+				phase(60*time.Second, atRestReading),
+		},
+		{
+			name: "below the threshold",
+			trace: newTrace().phase(10*time.Second, drivingReading).
+				phase(100*time.Millisecond, spikeReading(3.5)).
+				phase(60*time.Second, atRestReading),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := DetectImpact(tt.trace.entries, impactParams)
+			if (got != nil) != tt.want {
+				t.Fatalf("DetectImpact() = %+v, want impact %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDetectImpactEvent(t *testing.T) {
+	tr := newTrace().phase(10*time.Second, drivingReading)
+	spikeAt := tr.at
+	tr.phase(40*time.Millisecond, spikeReading(8)).
+		phase(20*time.Millisecond, spikeReading(12.3)).
+		phase(40*time.Millisecond, spikeReading(6)).
+		phase(2*time.Second, tumblingReading).
+		phase(60*time.Second, atRestReading)
+
+	// Uploaded out of order, and with entries that carry no sensor data
+	entries := append([]models.BlackboxEntry{}, tr.entries...)
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+	entries = append(entries, models.BlackboxEntry{Timestamp: spikeAt.Add(time.Second), Lat: 6.5244, Lng: 3.3792})
+
+	got := DetectImpact(entries, impactParams)
+	if got == nil {
+		t.Fatal("DetectImpact() = nil, want an impact")
+	}
+	if want := spikeAt.Add(40 * time.Millisecond); !got.At.Equal(want) || got.PeakG != 12.3 {
+		t.Errorf("impact at %s, %.1fg, want the strongest sample at %s, 12.3g", got.At, got.PeakG, want)
+	}
+	if got.StillFor < impactParams.StillFor || got.StillFor > 60*time.Second {
+		t.Errorf("still for %s", got.StillFor)
+	}
+}
+
+// A live stream finds the same impact one sample at a time, and only once
+func TestSensorWindow(t *testing.T) {
+	tr := newTrace().phase(10*time.Second, drivingReading).
+		phase(100*time.Millisecond, spikeReading(12)).
+		phase(2*time.Second, tumblingReading).
+		phase(60*time.Second, atRestReading)
+
+	window := NewSensorWindow(impactParams.StillFor)
+	found := 0
+	for _, e := range tr.entries {
+		window.Add(e)
+		if !window.Newest().Equal(e.Timestamp) {
+			t.Fatalf("Newest() = %s, want %s", window.Newest(), e.Timestamp)
+		}
+		if span := e.Timestamp.Sub(window.Samples()[0].Timestamp); span > window.span {
+			t.Fatalf("window spans %s, more than %s", span, window.span)
+		}
+		if event := DetectImpact(window.Samples(), impactParams); event != nil {
+			// As the stream handler does, forget everything seen so far
+			found++
+			window.Forget(window.Newest())
+		}
+	}
+	if found != 1 {
+		t.Errorf("impact found %d times, want once", found)
+	}
+}
+
+func TestImpactCorroborated(t *testing.T) {
+	at := time.Date(2026, 3, 9, 18, 0, 10, 0, time.UTC)
+	heartbeat := func(offset time.Duration, source string, lastGasp bool) models.Heartbeat {
+		return models.Heartbeat{Timestamp: at.Add(offset), Source: source, LastGasp: lastGasp}
+	}
+	tests := []struct {
+		name       string
+		heartbeats []models.Heartbeat
+		want       bool
+	}{
+		{"silence after", []models.Heartbeat{heartbeat(-time.Minute, "http", false)}, true},
+		{"no heartbeats", nil, true},
+		{"heard from after", []models.Heartbeat{heartbeat(-time.Minute, "http", false), heartbeat(time.Minute, "http", false)}, false},
+		{"LastGasp after", []models.Heartbeat{heartbeat(time.Minute, "sms", true)}, true},
+		{"only the blackbox after", []models.Heartbeat{heartbeat(-time.Minute, "http", false), heartbeat(time.Minute, "blackbox", false)}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := impactCorroborated(tt.heartbeats, at); got != tt.want {
+				t.Errorf("impactCorroborated() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,842 @@
+package services
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/config"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/database"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/utils"
+)
+
+const (
+	incidentBundleWorker = "incident_bundle_builder"
+	// Requests wake the builder; the poll picks up bundles requested on
+	// other instances or left behind by a restart
+	incidentBundlePollEvery  = time.Minute
+	incidentBundlePruneEvery = time.Hour
+	incidentBundlePruneBatch = 100
+	// incidentBundleStaleAfter is how long a build may run before another
+	// builder takes the bundle over
+	incidentBundleStaleAfter   = 15 * time.Minute
+	incidentBundleBuildTimeout = 10 * time.Minute
+
+	// A bundle covers the hour before the alert up to its resolution
+	incidentBundleLead          = time.Hour
+	incidentBundleMaxHeartbeats = 50000
+	incidentBundleMaxTrails     = 100
+
+	// incidentBundleManifestVersion starts the manifest's signing string
+	incidentBundleManifestVersion = "safetrace-bundle-v1"
+)
+
+var (
+	// ErrIncidentBundlesDisabled is returned when bundles are requested
+	// without object storage or PUBLIC_BASE_URL
+	ErrIncidentBundlesDisabled = errors.New("investigation bundles are not enabled")
+	// ErrAlertUnresolved is returned for a bundle of an alert still open
+	ErrAlertUnresolved = errors.New("alert is not resolved")
+)
+
+// IncidentBundles builds investigation bundles of resolved alerts: a zip of
+// the alert's timeline, the user's heartbeats as CSV and GPX, the blackbox
+// trails recorded around it with their integrity status, and the alert's
+// deliveries with the providers' message IDs, plus a manifest signed with
+// HMAC_SECRET over each file's SHA-256. Bundles are built in the background
+// and kept in object storage; the download link is signed the same way and
+// expires with the bundle, INCIDENT_BUNDLE_RETENTION_HOURS after it is
+// built. A user who asked for their own bundle gets the link by push.
+type IncidentBundles struct {
+	cfg      *config.Store
+	postgres *database.PostgresDB
+	store    ObjectStore // nil without object storage
+	notifier Notifier
+	health   *HealthRegistry
+
+	wake      chan struct{}
+	closeOnce sync.Once
+	stop      chan struct{}
+	done      chan struct{}
+}
+
+func NewIncidentBundles(cfg *config.Store, postgres *database.PostgresDB, store ObjectStore, notifier Notifier, health *HealthRegistry) *IncidentBundles {
+	return &IncidentBundles{
+		cfg:      cfg,
+		postgres: postgres,
+		store:    store,
+		notifier: notifier,
+		health:   health,
+		wake:     make(chan struct{}, 1),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// Enabled reports whether bundles can be built and linked to
+func (s *IncidentBundles) Enabled() bool {
+	return s.store != nil && s.cfg.Current().PublicBaseURL != ""
+}
+
+// Request queues a bundle of the alert for the token subject requestedBy,
+// or returns the one already queued or being built. The bool reports
+// whether a new bundle was queued.
+func (s *IncidentBundles) Request(ctx context.Context, alert *models.Alert, requestedBy string) (*models.IncidentBundle, bool, error) {
+	if !s.Enabled() {
+		return nil, false, ErrIncidentBundlesDisabled
+	}
+	if alert.ResolvedAt == nil {
+		return nil, false, ErrAlertUnresolved
+	}
+
+	open, err := s.postgres.GetOpenIncidentBundle(ctx, alert.ID)
+	if err != nil {
+		return nil, false, err
+	}
+	if open != nil {
+		return open, false, nil
+	}
+
+	bundle := &models.IncidentBundle{
+		ID:          uuid.New(),
+		AlertID:     alert.ID,
+		UserID:      alert.UserID,
+		RequestedBy: requestedBy,
+		Status:      models.BundlePending,
+		CreatedAt:   time.Now(),
+	}
+	if err := s.postgres.CreateIncidentBundle(ctx, bundle); err != nil {
+		return nil, false, err
+	}
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+	return bundle, true, nil
+}
+
+// Link returns the signed download link of a ready bundle,
+// PUBLIC_BASE_URL/bundles/<id>?expires=<unix>&sig=<signature>, or "" for
+// one that isn't ready
+func (s *IncidentBundles) Link(bundle *models.IncidentBundle) string {
+	if bundle.Status != models.BundleReady || bundle.ExpiresAt == nil {
+		return ""
+	}
+	cfg := s.cfg.Current()
+	expires := bundle.ExpiresAt.Unix()
+	query := url.Values{}
+	query.Set("expires", strconv.FormatInt(expires, 10))
+	query.Set("sig", utils.SignString(incidentBundleLinkString(bundle.ID, expires), cfg.HMACSecret))
+	return strings.TrimRight(cfg.PublicBaseURL, "/") + "/bundles/" + bundle.ID.String() + "?" + query.Encode()
+}
+
+// VerifyLink reports whether a download link's expiry and signature are
+// genuine and the link has not expired
+func (s *IncidentBundles) VerifyLink(id uuid.UUID, expires int64, signature string) bool {
+	if time.Now().Unix() > expires {
+		return false
+	}
+	return utils.VerifyStringSignatureAny(incidentBundleLinkString(id, expires), signature, s.cfg.HMACSecrets())
+}
+
+// Download returns the zip of a ready bundle
+func (s *IncidentBundles) Download(ctx context.Context, bundle *models.IncidentBundle) ([]byte, error) {
+	if s.store == nil {
+		return nil, ErrObjectStoreDisabled
+	}
+	return s.store.Get(ctx, bundle.ObjectKey)
+}
+
+// Start launches the builder, which also deletes expired bundles; the first
+// pass runs immediately. Without object storage there is nothing to do.
+func (s *IncidentBundles) Start() {
+	if s.store == nil {
+		close(s.done)
+		return
+	}
+	s.health.Register(incidentBundleWorker, incidentBundlePollEvery)
+	go s.run()
+}
+
+// Close stops the builder and waits for a running build to finish
+func (s *IncidentBundles) Close() {
+	s.closeOnce.Do(func() {
+		close(s.stop)
+	})
+	<-s.done
+}
+
+func (s *IncidentBundles) run() {
+	defer close(s.done)
+
+	poll := time.NewTicker(incidentBundlePollEvery)
+	defer poll.Stop()
+	prune := time.NewTicker(incidentBundlePruneEvery)
+	defer prune.Stop()
+
+	s.prune()
+	for {
+		if s.buildPending() {
+			s.health.Beat(incidentBundleWorker)
+		}
+		select {
+		case <-s.stop:
+			return
+		case <-s.wake:
+		case <-poll.C:
+		case <-prune.C:
+			s.prune()
+		}
+	}
+}
+
+// buildPending builds queued bundles until none is left or the builder is
+// stopped, and reports whether it could look for them
+func (s *IncidentBundles) buildPending() bool {
+	for {
+		select {
+		case <-s.stop:
+			return true
+		default:
+		}
+
+		now := time.Now()
+		bundle, err := s.postgres.ClaimIncidentBundle(context.Background(), now, now.Add(-incidentBundleStaleAfter))
+		if err != nil {
+			log.Printf("ERROR: Failed to claim an investigation bundle: %v", err)
+			return false
+		}
+		if bundle == nil {
+			return true
+		}
+		s.build(bundle)
+	}
+}
+
+// build makes, stores and records one bundle. A bundle that can't be built
+// is marked failed with the reason; the caller may request another.
+func (s *IncidentBundles) build(bundle *models.IncidentBundle) {
+	ctx, cancel := context.WithTimeout(context.Background(), incidentBundleBuildTimeout)
+	defer cancel()
+
+	fail := func(reason string, err error) {
+		log.Printf("ERROR: Investigation bundle %s of alert %s failed: %s: %v", bundle.ID, bundle.AlertID, reason, err)
+		if err := s.postgres.FailIncidentBundle(ctx, bundle.ID, reason, time.Now()); err != nil {
+			log.Printf("ERROR: Failed to record failure of investigation bundle %s: %v", bundle.ID, err)
+		}
+	}
+
+	alert, err := s.postgres.GetAlertByID(ctx, bundle.AlertID)
+	if err != nil || alert == nil {
+		fail("alert could not be loaded", err)
+		return
+	}
+
+	generatedAt := time.Now().UTC()
+	files, err := s.collect(ctx, bundle, alert)
+	if err != nil {
+		fail("incident records could not be read", err)
+		return
+	}
+	data, signature, err := writeIncidentBundle(bundle, files, generatedAt, s.cfg.Current().HMACSecret)
+	if err != nil {
+		fail("bundle could not be written", err)
+		return
+	}
+
+	key := incidentBundleKey(bundle)
+	if err := s.store.Put(ctx, key, data, "application/zip"); err != nil {
+		fail("bundle could not be stored", err)
+		return
+	}
+	expiresAt := generatedAt.Add(time.Duration(s.cfg.Current().IncidentBundleRetentionHours) * time.Hour)
+	bundle.Status = models.BundleReady
+	bundle.ObjectKey = key
+	bundle.SizeBytes = int64(len(data))
+	bundle.Signature = signature
+	bundle.CompletedAt = &generatedAt
+	bundle.ExpiresAt = &expiresAt
+	if err := s.postgres.CompleteIncidentBundle(ctx, bundle); err != nil {
+		log.Printf("ERROR: Failed to record investigation bundle %s: %v", bundle.ID, err)
+		return
+	}
+	log.Printf("INFO: Built investigation bundle %s of alert %s (%d bytes)", bundle.ID, alert.ID, len(data))
+	s.notify(ctx, bundle, alert)
+}
+
+// notify pushes the download link to the user when they asked for the
+// bundle themselves; admins fetch it from the bundle's status
+func (s *IncidentBundles) notify(ctx context.Context, bundle *models.IncidentBundle, alert *models.Alert) {
+	if bundle.RequestedBy != bundle.UserID.String() {
+		return
+	}
+	token, err := s.postgres.GetPushToken(ctx, bundle.UserID)
+	if err != nil || token == "" {
+		return
+	}
+	var settings models.UserSettings
+	if user, err := s.postgres.GetUserByID(ctx, bundle.UserID); err == nil && user != nil {
+		settings = user.Settings
+	}
+	body := fmt.Sprintf("The investigation bundle of your alert of %s is ready until %s: %s",
+		FormatInUserZone(alert.CreatedAt, settings, LayoutDateTime), FormatInUserZone(*bundle.ExpiresAt, settings, LayoutDateTime), s.Link(bundle))
+	if err := s.notifier.SendPushNotification(ctx, token, "Investigation bundle ready", body); err != nil {
+		log.Printf("WARN: Failed to push investigation bundle %s to user %s: %v", bundle.ID, bundle.UserID, err)
+	}
+}
+
+// prune deletes expired bundles from object storage, a batch at a time
+func (s *IncidentBundles) prune() {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	deleted := 0
+	for {
+		expired, err := s.postgres.GetExpiredIncidentBundles(ctx, time.Now(), incidentBundlePruneBatch)
+		if err != nil {
+			log.Printf("ERROR: Failed to list expired investigation bundles: %v", err)
+			return
+		}
+		for _, bundle := range expired {
+			if err := s.store.Delete(ctx, bundle.ObjectKey); err != nil {
+				log.Printf("ERROR: Failed to delete investigation bundle %s: %v", bundle.ID, err)
+				return
+			}
+			if err := s.postgres.ExpireIncidentBundle(ctx, bundle.ID); err != nil {
+				log.Printf("ERROR: Failed to expire investigation bundle %s: %v", bundle.ID, err)
+				return
+			}
+			deleted++
+		}
+		if len(expired) < incidentBundlePruneBatch {
+			break
+		}
+	}
+	if deleted > 0 {
+		log.Printf("INFO: Deleted %d expired investigation bundles", deleted)
+	}
+}
+
+// incidentBundleFile is one file of a bundle
+type incidentBundleFile struct {
+	Name string
+	Data []byte
+}
+
+// collect reads everything recorded about the alert and renders the
+// bundle's files, manifest aside
+func (s *IncidentBundles) collect(ctx context.Context, bundle *models.IncidentBundle, alert *models.Alert) ([]incidentBundleFile, error) {
+	from := alert.CreatedAt.Add(-incidentBundleLead)
+	to := time.Now()
+	if alert.ResolvedAt != nil {
+		to = *alert.ResolvedAt
+	}
+
+	heartbeats, err := s.postgres.GetHeartbeatsBetween(ctx, alert.UserID, from, to, incidentBundleMaxHeartbeats, false)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get heartbeats: %w", err)
+	}
+	transitions, err := s.postgres.GetStateTransitions(ctx, alert.UserID, from)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get state transitions: %w", err)
+	}
+	deliveries, err := s.postgres.GetAlertDeliveries(ctx, alert.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get deliveries: %w", err)
+	}
+	recipients, err := s.postgres.GetAlertRecipients(ctx, alert.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get recipients: %w", err)
+	}
+	trails, err := s.postgres.GetBlackboxTrails(ctx, alert.UserID, incidentBundleMaxTrails)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get blackbox trails: %w", err)
+	}
+	user, err := s.postgres.GetUserByID(ctx, alert.UserID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+	var settings models.UserSettings
+	if user != nil {
+		settings = user.Settings
+	}
+
+	timeline := incidentTimeline(alert, settings, from, to, heartbeats, transitions, deliveries, recipients)
+	timelineJSON, err := json.MarshalIndent(timeline, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	heartbeatsCSV, err := heartbeatsToCSV(heartbeats)
+	if err != nil {
+		return nil, err
+	}
+	trackGPX, err := heartbeatsToGPX(alert, heartbeats)
+	if err != nil {
+		return nil, err
+	}
+	if deliveries == nil {
+		deliveries = []models.AlertDelivery{}
+	}
+	if recipients == nil {
+		recipients = []models.AlertRecipient{}
+	}
+	deliveriesJSON, err := json.MarshalIndent(map[string]interface{}{
+		"deliveries": deliveries,
+		"recipients": recipients,
+	}, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	files := []incidentBundleFile{
+		{Name: "timeline.json", Data: timelineJSON},
+		{Name: "heartbeats.csv", Data: heartbeatsCSV},
+		{Name: "track.gpx", Data: trackGPX},
+		{Name: "deliveries.json", Data: deliveriesJSON},
+	}
+	for _, trail := range trails {
+		if trail.EndTs.Before(from) || trail.StartTs.After(to) {
+			continue
+		}
+		excerpt := s.trailExcerpt(ctx, &trail, from, to)
+		data, err := json.MarshalIndent(excerpt, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, incidentBundleFile{Name: "blackbox/" + trail.ID.String() + ".json", Data: data})
+	}
+	return files, nil
+}
+
+// trailExcerpt is the part of a blackbox trail inside the bundle's window.
+// IntegrityStatus is the trail's status when it was uploaded; Recheck is
+// its hash chain checked again now, so a stored trail altered since would
+// show as "altered".
+type trailExcerpt struct {
+	TrailID         uuid.UUID              `json:"trail_id"`
+	StartTs         time.Time              `json:"start_ts"`
+	EndTs           time.Time              `json:"end_ts"`
+	DataPoints      int                    `json:"data_points"`
+	UploadedAt      time.Time              `json:"uploaded_at"`
+	IntegrityStatus string                 `json:"integrity_status"`
+	ChainHead       string                 `json:"chain_head,omitempty"`
+	ChainBreakIndex *int                   `json:"chain_break_index,omitempty"`
+	RejectedPoints  int                    `json:"rejected_points"`
+	Recheck         string                 `json:"recheck"`     // intact | altered | unchained | unavailable
+	FirstIndex      int                    `json:"first_index"` // in the upload, quarantined data points included
+	Entries         []models.BlackboxEntry `json:"entries"`
+}
+
+func (s *IncidentBundles) trailExcerpt(ctx context.Context, trail *models.BlackboxTrail, from, to time.Time) trailExcerpt {
+	excerpt := trailExcerpt{
+		TrailID:         trail.ID,
+		StartTs:         trail.StartTs,
+		EndTs:           trail.EndTs,
+		DataPoints:      trail.DataPoints,
+		UploadedAt:      trail.UploadedAt,
+		IntegrityStatus: trail.IntegrityStatus,
+		ChainHead:       trail.ChainHead,
+		ChainBreakIndex: trail.ChainBreakIndex,
+		RejectedPoints:  trail.RejectedPoints,
+		Entries:         []models.BlackboxEntry{},
+	}
+
+	accepted, err := LoadBlackboxEntries(ctx, s.store, trail.FileURL)
+	if err != nil {
+		log.Printf("WARN: Failed to load blackbox trail %s for an investigation bundle: %v", trail.ID, err)
+		excerpt.Recheck = "unavailable"
+		return excerpt
+	}
+	quarantined, err := LoadBlackboxQuarantine(ctx, s.store, trail.QuarantineURL)
+	if err != nil {
+		log.Printf("WARN: Failed to load quarantine of blackbox trail %s for an investigation bundle: %v", trail.ID, err)
+		excerpt.Recheck = "unavailable"
+		return excerpt
+	}
+
+	// The chain was checked on upload over every data point, so it is
+	// checked again with the quarantined ones back in place; the excerpt
+	// itself holds only accepted ones
+	entries := RestoreBlackboxUpload(accepted, quarantined)
+	rejected := make(map[int]bool, len(quarantined))
+	for _, q := range quarantined {
+		rejected[q.Index] = true
+	}
+
+	check := VerifyBlackboxChain(trail.UserID, entries, "", nil)
+	switch {
+	case check.Status == models.TrailUnverified:
+		excerpt.Recheck = "unchained"
+	case trail.IntegrityStatus == models.TrailBroken:
+		// Broken at upload; the same break is all a recheck can find
+		excerpt.Recheck = "intact"
+		if check.BreakIndex == nil || trail.ChainBreakIndex == nil || *check.BreakIndex != *trail.ChainBreakIndex {
+			excerpt.Recheck = "altered"
+		}
+	case check.Head == "" || !strings.EqualFold(check.Head, trail.ChainHead):
+		excerpt.Recheck = "altered"
+	default:
+		excerpt.Recheck = "intact"
+	}
+
+	first := -1
+	for i, e := range entries {
+		if rejected[i] || e.Timestamp.Before(from) || e.Timestamp.After(to) {
+			continue
+		}
+		if first < 0 {
+			first = i
+		}
+		excerpt.Entries = append(excerpt.Entries, e)
+	}
+	excerpt.FirstIndex = first
+	return excerpt
+}
+
+// timelineEvent is one thing that happened around the alert, at a UTC time
+// also given in the user's time zone
+type timelineEvent struct {
+	At     time.Time              `json:"at"`
+	Local  string                 `json:"local"` // e.g. "2026-03-09 03:15:04 WAT"
+	Type   string                 `json:"type"`
+	Detail map[string]interface{} `json:"detail,omitempty"`
+}
+
+type timeline struct {
+	Alert    *models.Alert   `json:"alert"`
+	Timezone string          `json:"timezone"` // the user's, which Local times are in
+	From     time.Time       `json:"from"`
+	To       time.Time       `json:"to"`
+	Events   []timelineEvent `json:"events"`
+}
+
+// timelineLocalLayout is how event times are given in the user's time zone
+const timelineLocalLayout = "2006-01-02 15:04:05"
+
+// incidentTimeline orders what happened from the hour before the alert to its
+// resolution: state changes, last gasps, the alert itself, its deliveries and
+// acknowledgments
+func incidentTimeline(
+	alert *models.Alert,
+	settings models.UserSettings,
+	from, to time.Time,
+	heartbeats []models.Heartbeat,
+	transitions []models.StateTransition,
+	deliveries []models.AlertDelivery,
+	recipients []models.AlertRecipient,
+) timeline {
+	events := []timelineEvent{{
+		At:   alert.CreatedAt,
+		Type: "alert_raised",
+		Detail: map[string]interface{}{
+			"state":  alert.State,
+			"score":  alert.Score,
+			"reason": alert.Reason,
+		},
+	}}
+	for _, t := range transitions {
+		if t.Timestamp.After(to) {
+			continue
+		}
+		detail := map[string]interface{}{
+			"to":           t.ToState,
+			"score":        t.Score,
+			"reason":       t.Reason,
+			"triggered_by": t.TriggeredBy,
+		}
+		if t.FromState != nil {
+			detail["from"] = *t.FromState
+		}
+		events = append(events, timelineEvent{At: t.Timestamp, Type: "state_change", Detail: detail})
+	}
+	for _, hb := range heartbeats {
+		if hb.LastGasp {
+			events = append(events, timelineEvent{At: hb.Timestamp, Type: "last_gasp", Detail: map[string]interface{}{
+				"lat": hb.Lat, "lng": hb.Lng, "battery_pct": hb.BatteryPct,
+			}})
+		}
+	}
+	for _, d := range deliveries {
+		detail := map[string]interface{}{
+			"channel": d.Channel,
+			"status":  d.Status,
+			"contact": d.ContactName,
+		}
+		if d.Detail != "" {
+			detail["detail"] = d.Detail
+		}
+		if d.ProviderMessageID != "" {
+			detail["provider"] = d.Provider
+			detail["provider_message_id"] = d.ProviderMessageID
+		}
+		events = append(events, timelineEvent{At: d.CreatedAt, Type: "delivery", Detail: detail})
+	}
+	for _, r := range recipients {
+		if r.DeliveredAt != nil {
+			events = append(events, timelineEvent{At: *r.DeliveredAt, Type: "delivered", Detail: map[string]interface{}{
+				"contact": r.ContactName, "channel": r.Channel,
+			}})
+		}
+		if r.AcknowledgedAt != nil {
+			detail := map[string]interface{}{"contact": r.ContactName}
+			if r.AckMethod != nil {
+				detail["method"] = *r.AckMethod
+			}
+			events = append(events, timelineEvent{At: *r.AcknowledgedAt, Type: "acknowledged", Detail: detail})
+		}
+	}
+	if alert.ResolvedAt != nil {
+		events = append(events, timelineEvent{At: *alert.ResolvedAt, Type: "alert_resolved"})
+	}
+
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].At.Before(events[j].At)
+	})
+	for i := range events {
+		events[i].Local = FormatInUserZone(events[i].At, settings, timelineLocalLayout)
+		events[i].At = events[i].At.UTC()
+	}
+	return timeline{
+		Alert:    alert,
+		Timezone: UserLocation(settings).String(),
+		From:     from.UTC(),
+		To:       to.UTC(),
+		Events:   events,
+	}
+}
+
+// heartbeatsToCSV writes one row per heartbeat, oldest first
+func heartbeatsToCSV(heartbeats []models.Heartbeat) ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Write([]string{
+		"timestamp", "lat", "lng", "accuracy_m", "battery_pct", "speed_kmh",
+		"source", "trust", "connectivity", "last_gasp", "spoof_suspected",
+	})
+	for _, hb := range heartbeats {
+		battery, speed := "", ""
+		if hb.BatteryPct != nil {
+			battery = strconv.Itoa(*hb.BatteryPct)
+		}
+		if hb.Speed != nil {
+			speed = strconv.FormatFloat(*hb.Speed, 'f', 1, 64)
+		}
+		w.Write([]string{
+			hb.Timestamp.UTC().Format(time.RFC3339),
+			strconv.FormatFloat(hb.Lat, 'f', 6, 64),
+			strconv.FormatFloat(hb.Lng, 'f', 6, 64),
+			strconv.Itoa(hb.AccuracyM),
+			battery,
+			speed,
+			hb.Source,
+			HeartbeatTrust(&hb),
+			hb.Connectivity,
+			strconv.FormatBool(hb.LastGasp),
+			strconv.FormatBool(hb.SpoofSuspected),
+		})
+	}
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
+
+type gpxFile struct {
+	XMLName xml.Name `xml:"gpx"`
+	Xmlns   string   `xml:"xmlns,attr"`
+	Version string   `xml:"version,attr"`
+	Creator string   `xml:"creator,attr"`
+	Track   gpxTrack `xml:"trk"`
+}
+
+type gpxTrack struct {
+	Name    string     `xml:"name"`
+	Segment []gpxTrkPt `xml:"trkseg>trkpt"`
+}
+
+type gpxTrkPt struct {
+	Lat  float64 `xml:"lat,attr"`
+	Lon  float64 `xml:"lon,attr"`
+	Time string  `xml:"time"`
+}
+
+// heartbeatsToGPX writes the heartbeats with a fix as a GPX 1.1 track
+func heartbeatsToGPX(alert *models.Alert, heartbeats []models.Heartbeat) ([]byte, error) {
+	file := gpxFile{
+		Xmlns:   "http://www.topografix.com/GPX/1/1",
+		Version: "1.1",
+		Creator: "SafeTrace",
+		Track:   gpxTrack{Name: "Alert " + alert.ID.String()},
+	}
+	for _, hb := range heartbeats {
+		if hb.Lat == 0 && hb.Lng == 0 {
+			continue
+		}
+		file.Track.Segment = append(file.Track.Segment, gpxTrkPt{
+			Lat:  hb.Lat,
+			Lon:  hb.Lng,
+			Time: hb.Timestamp.UTC().Format(time.RFC3339),
+		})
+	}
+	data, err := xml.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), data...), nil
+}
+
+// IncidentBundleManifest lists a bundle's files with their SHA-256. The
+// signature is HMAC-SHA256 with HMAC_SECRET over SigningString, base64.
+type IncidentBundleManifest struct {
+	BundleID    uuid.UUID                `json:"bundle_id"`
+	AlertID     uuid.UUID                `json:"alert_id"`
+	UserID      uuid.UUID                `json:"user_id"`
+	GeneratedAt time.Time                `json:"generated_at"`
+	Files       []IncidentBundleFileHash `json:"files"`
+	Algorithm   string                   `json:"algorithm"`
+	Signature   string                   `json:"signature"`
+}
+
+// IncidentBundleFileHash is one file of a bundle's manifest
+type IncidentBundleFileHash struct {
+	Name   string `json:"name"`
+	Size   int    `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// SigningString is what the manifest signature covers: the version, bundle
+// and alert IDs and generation time, then "<sha256> <name>" for each file,
+// one per line
+func (m *IncidentBundleManifest) SigningString() string {
+	lines := []string{
+		incidentBundleManifestVersion,
+		m.BundleID.String(),
+		m.AlertID.String(),
+		m.GeneratedAt.UTC().Format(time.RFC3339Nano),
+	}
+	for _, f := range m.Files {
+		lines = append(lines, f.SHA256+" "+f.Name)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// writeIncidentBundle zips the files with their signed manifest.json and
+// returns the zip and the manifest signature.
+func writeIncidentBundle(bundle *models.IncidentBundle, files []incidentBundleFile, generatedAt time.Time, secret string) ([]byte, string, error) {
+	manifest := IncidentBundleManifest{
+		BundleID:    bundle.ID,
+		AlertID:     bundle.AlertID,
+		UserID:      bundle.UserID,
+		GeneratedAt: generatedAt,
+		Algorithm:   "HMAC-SHA256",
+	}
+	for _, f := range files {
+		sum := sha256.Sum256(f.Data)
+		manifest.Files = append(manifest.Files, IncidentBundleFileHash{
+			Name:   f.Name,
+			Size:   len(f.Data),
+			SHA256: hex.EncodeToString(sum[:]),
+		})
+	}
+	manifest.Signature = utils.SignString(manifest.SigningString(), secret)
+	manifestJSON, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, "", err
+	}
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, f := range append(files, incidentBundleFile{Name: "manifest.json", Data: manifestJSON}) {
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: f.Name, Method: zip.Deflate, Modified: generatedAt})
+		if err != nil {
+			return nil, "", err
+		}
+		if _, err := w.Write(f.Data); err != nil {
+			return nil, "", err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, "", err
+	}
+	return buf.Bytes(), manifest.Signature, nil
+}
+
+// VerifyIncidentBundle checks a bundle's zip against its manifest: the
+// signature is genuine under one of secrets, and the files are exactly
+// those listed, with their sizes and SHA-256. It returns the manifest.
+func VerifyIncidentBundle(data []byte, secrets []string) (*IncidentBundleManifest, error) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("not a zip: %w", err)
+	}
+	contents := make(map[string][]byte, len(zr.File))
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", f.Name, err)
+		}
+		var buf bytes.Buffer
+		_, err = buf.ReadFrom(rc)
+		rc.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", f.Name, err)
+		}
+		if _, ok := contents[f.Name]; ok {
+			return nil, fmt.Errorf("%s is in the bundle twice", f.Name)
+		}
+		contents[f.Name] = buf.Bytes()
+	}
+
+	manifestJSON, ok := contents["manifest.json"]
+	if !ok {
+		return nil, errors.New("manifest.json is missing")
+	}
+	delete(contents, "manifest.json")
+	var manifest IncidentBundleManifest
+	if err := json.Unmarshal(manifestJSON, &manifest); err != nil {
+		return nil, fmt.Errorf("manifest.json: %w", err)
+	}
+	if !utils.VerifyStringSignatureAny(manifest.SigningString(), manifest.Signature, secrets) {
+		return nil, errors.New("manifest signature is not valid")
+	}
+
+	for _, f := range manifest.Files {
+		content, ok := contents[f.Name]
+		if !ok {
+			return nil, fmt.Errorf("%s is missing", f.Name)
+		}
+		sum := sha256.Sum256(content)
+		if len(content) != f.Size || hex.EncodeToString(sum[:]) != f.SHA256 {
+			return nil, fmt.Errorf("%s does not match the manifest", f.Name)
+		}
+		delete(contents, f.Name)
+	}
+	for name := range contents {
+		return nil, fmt.Errorf("%s is not in the manifest", name)
+	}
+	return &manifest, nil
+}
+
+func incidentBundleKey(bundle *models.IncidentBundle) string {
+	return "alerts/" + bundle.AlertID.String() + "/bundles/" + bundle.ID.String() + ".zip"
+}
+
+// incidentBundleLinkString is what a download link's signature covers
+func incidentBundleLinkString(id uuid.UUID, expires int64) string {
+	return "bundle:" + id.String() + ":" + strconv.FormatInt(expires, 10)
+}
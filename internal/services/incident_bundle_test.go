@@ -0,0 +1,205 @@
+package services
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/config"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+)
+
+const bundleSecret = "bundle-secret"
+
+// testIncidentBundle writes a bundle of two files signed with bundleSecret
+func testIncidentBundle(t *testing.T) (*models.IncidentBundle, []byte) {
+	t.Helper()
+	bundle := &models.IncidentBundle{ID: uuid.New(), AlertID: uuid.New(), UserID: uuid.New()}
+	files := []incidentBundleFile{
+		{Name: "timeline.json", Data: []byte(`{"events":[]}`)},
+		{Name: "heartbeats.csv", Data: []byte("timestamp,lat,lng\n2024-03-01T02:00:00Z,6.524400,3.379200\n")},
+	}
+	data, signature, err := writeIncidentBundle(bundle, files, time.Date(2024, 3, 1, 4, 0, 0, 0, time.UTC), bundleSecret)
+	if err != nil {
+		t.Fatalf("writeIncidentBundle: %v", err)
+	}
+	if signature == "" {
+		t.Fatal("no manifest signature")
+	}
+	return bundle, data
+}
+
+// rezip copies a bundle, passing each file through edit, which may change
+// it or return nil to leave it out; extra files are added at the end
+func rezip(t *testing.T, data []byte, edit func(name string, content []byte) []byte, extra ...incidentBundleFile) []byte {
+	t.Helper()
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("zip: %v", err)
+	}
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	write := func(name string, content []byte) {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatalf("zip: %v", err)
+		}
+		w.Write(content)
+	}
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatalf("zip: %v", err)
+		}
+		content, _ := io.ReadAll(rc)
+		rc.Close()
+		if content = edit(f.Name, content); content != nil {
+			write(f.Name, content)
+		}
+	}
+	for _, f := range extra {
+		write(f.Name, f.Data)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("zip: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// editManifest rewrites the manifest.json of a bundle
+func editManifest(edit func(m *IncidentBundleManifest)) func(string, []byte) []byte {
+	return func(name string, content []byte) []byte {
+		if name != "manifest.json" {
+			return content
+		}
+		var m IncidentBundleManifest
+		json.Unmarshal(content, &m)
+		edit(&m)
+		out, _ := json.Marshal(m)
+		return out
+	}
+}
+
+func TestIncidentBundleManifest(t *testing.T) {
+	bundle, data := testIncidentBundle(t)
+
+	manifest, err := VerifyIncidentBundle(data, []string{bundleSecret})
+	if err != nil {
+		t.Fatalf("VerifyIncidentBundle: %v", err)
+	}
+	if manifest.BundleID != bundle.ID || manifest.AlertID != bundle.AlertID || manifest.UserID != bundle.UserID {
+		t.Errorf("manifest = %+v, want bundle %s of alert %s", manifest, bundle.ID, bundle.AlertID)
+	}
+	if manifest.Algorithm != "HMAC-SHA256" || len(manifest.Files) != 2 || !manifest.GeneratedAt.Equal(time.Date(2024, 3, 1, 4, 0, 0, 0, time.UTC)) {
+		t.Errorf("manifest = %+v", manifest)
+	}
+	if !strings.HasPrefix(manifest.SigningString(), incidentBundleManifestVersion+"\n"+bundle.ID.String()) {
+		t.Errorf("signing string = %q", manifest.SigningString())
+	}
+
+	// A rotated secret still verifies bundles signed before the rotation
+	if _, err := VerifyIncidentBundle(data, []string{"new-secret", bundleSecret}); err != nil {
+		t.Errorf("with the previous secret: %v", err)
+	}
+
+	unchanged := func(name string, content []byte) []byte { return content }
+	tests := []struct {
+		name    string
+		data    []byte
+		secrets []string
+		reason  string
+	}{
+		{"other secret", data, []string{"other-secret"}, "signature"},
+		{"edited file", rezip(t, data, func(name string, content []byte) []byte {
+			if name == "heartbeats.csv" {
+				return bytes.Replace(content, []byte("6.524400"), []byte("6.624400"), 1)
+			}
+			return content
+		}), nil, "heartbeats.csv does not match"},
+		{"removed file", rezip(t, data, func(name string, content []byte) []byte {
+			if name == "timeline.json" {
+				return nil
+			}
+			return content
+		}), nil, "timeline.json is missing"},
+		{"added file", rezip(t, data, unchanged, incidentBundleFile{Name: "note.txt", Data: []byte("planted")}), nil, "note.txt is not in the manifest"},
+		{"file hash rewritten to match an edit", rezip(t, data, editManifest(func(m *IncidentBundleManifest) {
+			m.Files[0].SHA256 = strings.Repeat("0", 64)
+		})), nil, "signature"},
+		{"generation time moved", rezip(t, data, editManifest(func(m *IncidentBundleManifest) {
+			m.GeneratedAt = m.GeneratedAt.Add(-24 * time.Hour)
+		})), nil, "signature"},
+		{"bundle of another alert", rezip(t, data, editManifest(func(m *IncidentBundleManifest) {
+			m.AlertID = uuid.New()
+		})), nil, "signature"},
+		{"file renamed", rezip(t, data, editManifest(func(m *IncidentBundleManifest) {
+			m.Files[1].Name = "heartbeats-2.csv"
+		})), nil, "signature"},
+		{"no manifest", rezip(t, data, func(name string, content []byte) []byte {
+			if name == "manifest.json" {
+				return nil
+			}
+			return content
+		}), nil, "manifest.json is missing"},
+		{"not a zip", []byte("PK not really"), nil, "not a zip"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			secrets := tt.secrets
+			if secrets == nil {
+				secrets = []string{bundleSecret}
+			}
+			_, err := VerifyIncidentBundle(tt.data, secrets)
+			if err == nil || !strings.Contains(err.Error(), tt.reason) {
+				t.Errorf("VerifyIncidentBundle = %v, want an error about %q", err, tt.reason)
+			}
+		})
+	}
+}
+
+func TestIncidentBundleLink(t *testing.T) {
+	cfg := config.NewStore(&config.Config{HMACSecret: bundleSecret, PublicBaseURL: "https://safetrace.test/"})
+	s := NewIncidentBundles(cfg, nil, newMemoryObjectStore(), nil, NewHealthRegistry())
+	expires := time.Now().Add(time.Hour).Truncate(time.Second)
+	bundle := &models.IncidentBundle{ID: uuid.New(), Status: models.BundleReady, ExpiresAt: &expires}
+
+	link := s.Link(bundle)
+	u, err := url.Parse(link)
+	if err != nil || u.Host != "safetrace.test" || u.Path != "/bundles/"+bundle.ID.String() {
+		t.Fatalf("link = %q", link)
+	}
+	sig := u.Query().Get("sig")
+	if u.Query().Get("expires") != strconv.FormatInt(expires.Unix(), 10) {
+		t.Errorf("link expires %s, want %d", u.Query().Get("expires"), expires.Unix())
+	}
+	if !s.VerifyLink(bundle.ID, expires.Unix(), sig) {
+		t.Error("link does not verify")
+	}
+	if s.VerifyLink(uuid.New(), expires.Unix(), sig) {
+		t.Error("link verifies for another bundle")
+	}
+	if s.VerifyLink(bundle.ID, expires.Add(24*time.Hour).Unix(), sig) {
+		t.Error("link verifies with its expiry pushed back")
+	}
+
+	past := time.Now().Add(-time.Minute).Truncate(time.Second)
+	expired := &models.IncidentBundle{ID: bundle.ID, Status: models.BundleReady, ExpiresAt: &past}
+	u, _ = url.Parse(s.Link(expired))
+	if s.VerifyLink(bundle.ID, past.Unix(), u.Query().Get("sig")) {
+		t.Error("expired link verifies")
+	}
+
+	for _, status := range []string{models.BundlePending, models.BundleBuilding, models.BundleFailed} {
+		if link := s.Link(&models.IncidentBundle{ID: bundle.ID, Status: status, ExpiresAt: &expires}); link != "" {
+			t.Errorf("%s bundle linked: %s", status, link)
+		}
+	}
+}
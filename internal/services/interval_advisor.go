@@ -0,0 +1,267 @@
+package services
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/cache"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/config"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/country"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/database"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+)
+
+// Reasons reported with an advised heartbeat interval
+const (
+	IntervalAtRisk        = "at_risk"
+	IntervalWatch         = "watch"
+	IntervalDangerZone    = "danger_zone"
+	IntervalCaution       = "caution"
+	IntervalMovingAtNight = "moving_at_night"
+	IntervalSafeZone      = "safe_zone"
+	IntervalStationary    = "stationary"
+	IntervalDefault       = "default"
+	IntervalLowBattery    = "low_battery" // appended when a relaxed interval was stretched further
+)
+
+const (
+	// movingSpeed and stationarySpeed are heartbeat speeds (km/h) above which
+	// the user counts as travelling, and below which as staying put
+	movingSpeed     = 5.0
+	stationarySpeed = 1.0
+	// lowBatteryPct stretches relaxed intervals to save what is left
+	lowBatteryPct = 20
+	// nightStart and nightEnd bound the hours, local time, treated as night
+	nightStart = 21
+	nightEnd   = 6
+	// intervalChangeRatio is how much the advice must move before the client
+	// is pushed the new interval instead of picking it up on its next heartbeat
+	intervalChangeRatio = 0.25
+	// dangerZoneRefresh is how long the danger zone list is cached, so
+	// broadcasts age out of it; new ones evict it when they are created
+	dangerZoneRefresh = time.Minute
+)
+
+// IntervalInputs is what the interval advice is decided from
+type IntervalInputs struct {
+	State        string
+	Watching     bool              // the user has an active watch session
+	Heartbeat    *models.Heartbeat // nil if there is none
+	Settings     models.UserSettings
+	InSafeZone   bool
+	InDangerZone bool
+	LocalTime    time.Time // now, in the user's time zone
+}
+
+// IntervalLimits are the deployment's bounds and default interval, in seconds
+type IntervalLimits struct {
+	Default int
+	Min     int
+	Max     int
+}
+
+// IntervalAdvice is a recommended heartbeat interval and why it was chosen
+type IntervalAdvice struct {
+	Seconds int    `json:"seconds"`
+	Reason  string `json:"reason"`
+}
+
+// AdviseInterval picks how often the client should send heartbeats. The
+// first matching row wins:
+//
+//	AT_RISK, ALERT or WAIT_LASTGASP   minimum
+//	under an active watch             minimum
+//	inside a danger zone              minimum
+//	CAUTION                           half the base interval
+//	moving at night                   half the base interval
+//	stationary inside a safe zone     4x the base interval
+//	stationary in the daytime         2x the base interval
+//	otherwise                         the base interval
+//
+// The base is the user's heartbeat_interval setting, or the default. At or
+// under lowBatteryPct a relaxed interval is doubled again; tightened ones
+// never are. The result is kept between the minimum and the maximum, which
+// the user's max_heartbeat_interval may lower.
+func AdviseInterval(in IntervalInputs, limits IntervalLimits) IntervalAdvice {
+	base := limits.Default
+	if in.Settings.HeartbeatInterval > 0 {
+		base = in.Settings.HeartbeatInterval
+	}
+	hb := in.Heartbeat
+	moving := hb != nil && hb.Speed != nil && *hb.Speed >= movingSpeed
+	stationary := hb != nil && hb.Speed != nil && *hb.Speed < stationarySpeed
+	hour := in.LocalTime.Hour()
+	night := hour >= nightStart || hour < nightEnd
+
+	var advice IntervalAdvice
+	switch {
+	case in.State == StateAtRisk || in.State == StateAlert || in.State == StateWaitLastGasp:
+		advice = IntervalAdvice{limits.Min, IntervalAtRisk}
+	case in.Watching:
+		advice = IntervalAdvice{limits.Min, IntervalWatch}
+	case in.InDangerZone:
+		advice = IntervalAdvice{limits.Min, IntervalDangerZone}
+	case in.State == StateCaution:
+		advice = IntervalAdvice{base / 2, IntervalCaution}
+	case moving && night:
+		advice = IntervalAdvice{base / 2, IntervalMovingAtNight}
+	case stationary && in.InSafeZone:
+		advice = IntervalAdvice{base * 4, IntervalSafeZone}
+	case stationary && !night:
+		advice = IntervalAdvice{base * 2, IntervalStationary}
+	default:
+		advice = IntervalAdvice{base, IntervalDefault}
+	}
+
+	if advice.Seconds >= base && hb != nil && hb.BatteryPct != nil && *hb.BatteryPct <= lowBatteryPct {
+		advice.Seconds *= 2
+		advice.Reason += "," + IntervalLowBattery
+	}
+
+	ceiling := limits.Max
+	if in.Settings.MaxHeartbeatInterval > 0 && in.Settings.MaxHeartbeatInterval < ceiling {
+		ceiling = in.Settings.MaxHeartbeatInterval
+	}
+	if advice.Seconds > ceiling {
+		advice.Seconds = ceiling
+	}
+	if advice.Seconds < limits.Min {
+		advice.Seconds = limits.Min
+	}
+	return advice
+}
+
+// IntervalAllowance is the longest interval the client may be following
+// after this advice. A client that just sent a heartbeat may have been
+// answered with either the previous advice or this one; otherwise it may
+// still be on any interval advised since its last heartbeat, since a pushed
+// change isn't guaranteed to arrive.
+func IntervalAllowance(prev *models.UserState, newHeartbeat bool, advised int) int {
+	if prev == nil {
+		return advised
+	}
+	longest := prev.IntervalAllowanceSeconds
+	if newHeartbeat {
+		longest = prev.NextIntervalSeconds
+	}
+	if advised > longest {
+		return advised
+	}
+	return longest
+}
+
+// intervalChanged reports whether advice moved enough to push it to the client
+func intervalChanged(prev, next int) bool {
+	if prev <= 0 || next <= 0 {
+		return false
+	}
+	diff := float64(next - prev)
+	if diff < 0 {
+		diff = -diff
+	}
+	return diff/float64(prev) >= intervalChangeRatio
+}
+
+// IntervalAdvisor gathers the context AdviseInterval needs: the user's
+// settings and safe zones, and danger zones, which are the areas of recent
+// admin broadcasts
+type IntervalAdvisor struct {
+	cfg      *config.Store
+	postgres *database.PostgresDB
+
+	// dangerZones is keyed by the DangerZoneHours it was loaded for
+	dangerZones *cache.Cache[int, []models.Geofence]
+	mu          sync.Mutex
+	lastZones   []models.Geofence // the last list loaded, kept when a refresh fails
+}
+
+func NewIntervalAdvisor(cfg *config.Store, postgres *database.PostgresDB) *IntervalAdvisor {
+	a := &IntervalAdvisor{
+		cfg:         cfg,
+		postgres:    postgres,
+		dangerZones: cache.New[int, []models.Geofence](dangerZoneRefresh, 0),
+	}
+	postgres.Caches().Register(cache.KindGeofences, cache.Funcs{
+		EvictFunc: func(key string) {
+			if key == cache.GeofencesBroadcasts {
+				a.dangerZones.Flush()
+			}
+		},
+		FlushFunc: a.dangerZones.Flush,
+	})
+	postgres.Caches().Track("danger_zones", a.dangerZones)
+	return a
+}
+
+// Advise recommends an interval for the user in the given state. Without
+// the user's settings or the danger zones it advises on what it has.
+func (a *IntervalAdvisor) Advise(ctx context.Context, user *models.User, state string, watching bool, hb *models.Heartbeat, now time.Time) IntervalAdvice {
+	cfg := a.cfg.Current()
+	in := IntervalInputs{
+		State:     state,
+		Watching:  watching,
+		Heartbeat: hb,
+	}
+	if user != nil {
+		in.Settings = user.Settings
+	}
+	in.LocalTime = now.In(UserLocation(in.Settings))
+	if hb != nil {
+		for _, zone := range in.Settings.SafeZones {
+			if GeofenceContains(zone, hb.Lat, hb.Lng) {
+				in.InSafeZone = true
+				break
+			}
+		}
+		for _, zone := range a.currentDangerZones(ctx, now, cfg.DangerZoneHours) {
+			if GeofenceContains(zone, hb.Lat, hb.Lng) {
+				in.InDangerZone = true
+				break
+			}
+		}
+	}
+
+	return AdviseInterval(in, IntervalLimits{
+		Default: cfg.HeartbeatIntervalSeconds,
+		Min:     cfg.HeartbeatIntervalMinSeconds,
+		Max:     cfg.HeartbeatIntervalMaxSeconds,
+	})
+}
+
+// currentDangerZones returns broadcast areas from the last hours, cached
+// until a broadcast is created or for dangerZoneRefresh, loaded once however
+// many evaluations miss at the same time. On a failed refresh the previous
+// list is kept.
+func (a *IntervalAdvisor) currentDangerZones(ctx context.Context, now time.Time, hours int) []models.Geofence {
+	if hours <= 0 {
+		return nil
+	}
+	zones, err := a.dangerZones.GetOrLoad(ctx, hours, func(ctx context.Context) ([]models.Geofence, error) {
+		zones, err := a.postgres.GetBroadcastGeofencesSince(ctx, now.Add(-time.Duration(hours)*time.Hour))
+		if err == nil {
+			a.mu.Lock()
+			a.lastZones = zones
+			a.mu.Unlock()
+		}
+		return zones, err
+	})
+	if err != nil {
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		log.Printf("WARN: Failed to load danger zones, keeping %d cached: %v", len(a.lastZones), err)
+		return a.lastZones
+	}
+	return zones
+}
+
+// deploymentLocation is the time zone of the deployment's home country,
+// the user's until they set their own
+func deploymentLocation() *time.Location {
+	loc, err := loadLocation(country.Active().Home().Timezone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
@@ -0,0 +1,141 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/config"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+)
+
+func TestAdviseInterval(t *testing.T) {
+	limits := IntervalLimits{Default: 300, Min: 60, Max: 1800}
+	day := time.Date(2026, 3, 9, 14, 0, 0, 0, time.UTC)
+	night := time.Date(2026, 3, 9, 23, 0, 0, 0, time.UTC)
+	heartbeat := func(speed float64, battery int) *models.Heartbeat {
+		return &models.Heartbeat{Speed: &speed, BatteryPct: &battery}
+	}
+	moving, still := heartbeat(40, 80), heartbeat(0, 80)
+
+	tests := []struct {
+		name string
+		in   IntervalInputs
+		want IntervalAdvice
+	}{
+		// One row of the decision table each, in order
+		{"AT_RISK", IntervalInputs{State: StateAtRisk, Heartbeat: still, InSafeZone: true, LocalTime: day}, IntervalAdvice{60, IntervalAtRisk}},
+		{"ALERT", IntervalInputs{State: StateAlert, LocalTime: day}, IntervalAdvice{60, IntervalAtRisk}},
+		{"waiting on a LastGasp", IntervalInputs{State: StateWaitLastGasp, LocalTime: day}, IntervalAdvice{60, IntervalAtRisk}},
+		{"watched", IntervalInputs{State: StateSafe, Watching: true, Heartbeat: still, InSafeZone: true, LocalTime: day}, IntervalAdvice{60, IntervalWatch}},
+		{"danger zone", IntervalInputs{State: StateSafe, Heartbeat: still, InSafeZone: true, InDangerZone: true, LocalTime: day}, IntervalAdvice{60, IntervalDangerZone}},
+		{"CAUTION", IntervalInputs{State: StateCaution, Heartbeat: still, LocalTime: day}, IntervalAdvice{150, IntervalCaution}},
+		{"moving at night", IntervalInputs{State: StateSafe, Heartbeat: moving, LocalTime: night}, IntervalAdvice{150, IntervalMovingAtNight}},
+		{"still in a safe zone", IntervalInputs{State: StateSafe, Heartbeat: still, InSafeZone: true, LocalTime: night}, IntervalAdvice{1200, IntervalSafeZone}},
+		{"still in the daytime", IntervalInputs{State: StateSafe, Heartbeat: still, LocalTime: day}, IntervalAdvice{600, IntervalStationary}},
+		{"moving in the daytime", IntervalInputs{State: StateSafe, Heartbeat: moving, LocalTime: day}, IntervalAdvice{300, IntervalDefault}},
+		{"still at night", IntervalInputs{State: StateSafe, Heartbeat: still, LocalTime: night}, IntervalAdvice{300, IntervalDefault}},
+		{"no heartbeat", IntervalInputs{State: StateSafe, LocalTime: day}, IntervalAdvice{300, IntervalDefault}},
+		{"no speed", IntervalInputs{State: StateSafe, Heartbeat: &models.Heartbeat{}, InSafeZone: true, LocalTime: day}, IntervalAdvice{300, IntervalDefault}},
+
+		// Night starts at 21:00 and ends at 06:00, local time
+		{"20:59", IntervalInputs{State: StateSafe, Heartbeat: moving, LocalTime: time.Date(2026, 3, 9, 20, 59, 0, 0, time.UTC)}, IntervalAdvice{300, IntervalDefault}},
+		{"21:00", IntervalInputs{State: StateSafe, Heartbeat: moving, LocalTime: time.Date(2026, 3, 9, 21, 0, 0, 0, time.UTC)}, IntervalAdvice{150, IntervalMovingAtNight}},
+		{"05:59", IntervalInputs{State: StateSafe, Heartbeat: moving, LocalTime: time.Date(2026, 3, 9, 5, 59, 0, 0, time.UTC)}, IntervalAdvice{150, IntervalMovingAtNight}},
+		{"06:00", IntervalInputs{State: StateSafe, Heartbeat: moving, LocalTime: time.Date(2026, 3, 9, 6, 0, 0, 0, time.UTC)}, IntervalAdvice{300, IntervalDefault}},
+
+		// Low battery stretches relaxed intervals only
+		{"low battery, still", IntervalInputs{State: StateSafe, Heartbeat: heartbeat(0, 15), LocalTime: day}, IntervalAdvice{1200, IntervalStationary + "," + IntervalLowBattery}},
+		{"low battery, default", IntervalInputs{State: StateSafe, Heartbeat: heartbeat(10, 20), LocalTime: day}, IntervalAdvice{600, IntervalDefault + "," + IntervalLowBattery}},
+		{"battery just above low", IntervalInputs{State: StateSafe, Heartbeat: heartbeat(10, 21), LocalTime: day}, IntervalAdvice{300, IntervalDefault}},
+		{"low battery, CAUTION", IntervalInputs{State: StateCaution, Heartbeat: heartbeat(0, 5), LocalTime: day}, IntervalAdvice{150, IntervalCaution}},
+		{"low battery, AT_RISK", IntervalInputs{State: StateAtRisk, Heartbeat: heartbeat(0, 5), LocalTime: day}, IntervalAdvice{60, IntervalAtRisk}},
+
+		// Bounds
+		{"user's base", IntervalInputs{State: StateSafe, Heartbeat: moving, Settings: models.UserSettings{HeartbeatInterval: 180}, LocalTime: day}, IntervalAdvice{180, IntervalDefault}},
+		{"capped at the maximum", IntervalInputs{State: StateSafe, Heartbeat: heartbeat(0, 10), InSafeZone: true, LocalTime: day}, IntervalAdvice{1800, IntervalSafeZone + "," + IntervalLowBattery}},
+		{"capped by the user", IntervalInputs{State: StateSafe, Heartbeat: still, InSafeZone: true, Settings: models.UserSettings{MaxHeartbeatInterval: 900}, LocalTime: day}, IntervalAdvice{900, IntervalSafeZone}},
+		{"user cap above the maximum", IntervalInputs{State: StateSafe, Heartbeat: still, InSafeZone: true, Settings: models.UserSettings{HeartbeatInterval: 600, MaxHeartbeatInterval: 7200}, LocalTime: day}, IntervalAdvice{1800, IntervalSafeZone}},
+		{"raised to the minimum", IntervalInputs{State: StateCaution, Settings: models.UserSettings{HeartbeatInterval: 90}, LocalTime: day}, IntervalAdvice{60, IntervalCaution}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := AdviseInterval(tt.in, limits); got != tt.want {
+				t.Errorf("AdviseInterval() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIntervalAllowance(t *testing.T) {
+	prev := &models.UserState{NextIntervalSeconds: 600, IntervalAllowanceSeconds: 1200}
+	tests := []struct {
+		name         string
+		prev         *models.UserState
+		newHeartbeat bool
+		advised      int
+		want         int
+	}{
+		{"first advice", nil, true, 300, 300},
+		{"heartbeat, advice shortened", prev, true, 60, 600},
+		{"heartbeat, advice lengthened", prev, true, 900, 900},
+		{"no heartbeat, advice shortened", prev, false, 60, 1200},
+		{"no heartbeat, advice lengthened", prev, false, 1800, 1800},
+	}
+	for _, tt := range tests {
+		if got := IntervalAllowance(tt.prev, tt.newHeartbeat, tt.advised); got != tt.want {
+			t.Errorf("%s: IntervalAllowance() = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestIntervalChanged(t *testing.T) {
+	tests := []struct {
+		prev, next int
+		want       bool
+	}{
+		{300, 300, false},
+		{300, 360, false},
+		{300, 375, true},
+		{300, 225, true},
+		{300, 240, false},
+		{0, 300, false},
+		{300, 0, false},
+	}
+	for _, tt := range tests {
+		if got := intervalChanged(tt.prev, tt.next); got != tt.want {
+			t.Errorf("intervalChanged(%d, %d) = %v, want %v", tt.prev, tt.next, got, tt.want)
+		}
+	}
+}
+
+// A user told to report every 30 minutes isn't stale 20 minutes after a
+// heartbeat, but is once they are overdue on that interval
+func TestStalenessFollowsAdvisedInterval(t *testing.T) {
+	cfg := simulationConfig()
+	cfg.HeartbeatIntervalSeconds = 300
+	profile := DefaultScoringProfile(cfg)
+	advised := profile.WithIntervalAllowance(1800, cfg.HeartbeatIntervalSeconds)
+	hb := simulatedHeartbeat(0, false)
+
+	tests := []struct {
+		name      string
+		profile   ScoringProfile
+		after     time.Duration
+		wantStale bool
+	}{
+		{"configured interval, 20 minutes", profile, 20 * time.Minute, true},
+		{"advised interval, 20 minutes", advised, 20 * time.Minute, false},
+		{"advised interval, overdue", advised, 36 * time.Minute, true},
+		{"shorter advice allows no less", profile.WithIntervalAllowance(60, cfg.HeartbeatIntervalSeconds), 9 * time.Minute, false},
+		{"watched ignores the allowance", advised.Watched(), 20 * time.Minute, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			se := &SafetyEvaluator{cfg: config.NewStore(cfg), clock: NewFakeClock(hb.Timestamp.Add(tt.after)), effects: discardEffects{}}
+			result := se.Assess(&hb, nil, tt.profile)
+			if stale := isStalenessRisk(result); stale != tt.wantStale {
+				t.Errorf("stale = %v (%s %v), want %v", stale, result.State, result.RulesFired, tt.wantStale)
+			}
+		})
+	}
+}
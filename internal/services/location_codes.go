@@ -0,0 +1,376 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/config"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/database"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/pluscode"
+)
+
+// what3wordsCacheTTL is how long a looked-up address is kept. A 3m square's
+// words never change.
+const what3wordsCacheTTL = 30 * 24 * time.Hour
+
+const what3wordsURL = "https://api.what3words.com/v3/convert-to-3wa"
+
+const (
+	// placeNameCacheTTL is how long a reverse geocoded place name is kept
+	placeNameCacheTTL = 30 * 24 * time.Hour
+	// placeNameTimeout bounds a reverse geocoding lookup
+	placeNameTimeout = 3 * time.Second
+	placeNameURL     = "https://api.mapbox.com/geocoding/v5/mapbox.places/"
+)
+
+var (
+	ErrGeocodingDisabled = errors.New("geocoding is not configured")
+	ErrAddressNotFound   = errors.New("address not found")
+	ErrGeocodingFailed   = errors.New("geocoding failed")
+)
+
+// LocationCodes name a spot precisely where there is no street address
+type LocationCodes struct {
+	PlusCode   string `json:"plus_code"`
+	What3Words string `json:"what3words,omitempty"` // e.g. filled.count.soap; only when configured and already known
+}
+
+// LocationEncoder computes plus codes, offline, and looks up what3words
+// addresses when WHAT3WORDS_API_KEY is set and place names when MAPBOX_TOKEN
+// is. Lookups run in the background
+// with a strict timeout and are cached, so an alert never waits on them: a
+// message includes the words only if they are already known.
+type LocationEncoder struct {
+	cfg      *config.Store
+	postgres *database.PostgresDB
+	redis    *database.RedisDB
+	client   *http.Client
+
+	inflight sync.Map // cache key to struct{}, one lookup per square at a time
+	wg       sync.WaitGroup
+}
+
+func NewLocationEncoder(cfg *config.Store, postgres *database.PostgresDB, redis *database.RedisDB) *LocationEncoder {
+	return &LocationEncoder{
+		cfg:      cfg,
+		postgres: postgres,
+		redis:    redis,
+		client:   &http.Client{},
+	}
+}
+
+// Codes returns the plus code for a location and its what3words address if
+// it is cached. It never calls out.
+func (e *LocationEncoder) Codes(ctx context.Context, lat, lng float64) LocationCodes {
+	codes := LocationCodes{PlusCode: pluscode.Encode(lat, lng, pluscode.DefaultLength)}
+	if e.cfg.Current().What3WordsAPIKey == "" {
+		return codes
+	}
+	words, err := e.redis.GetCachedWhat3Words(ctx, lat, lng)
+	if err != nil {
+		log.Printf("WARN: Failed to read cached what3words address: %v", err)
+	}
+	codes.What3Words = words
+	return codes
+}
+
+// AnnotateAlert stores the plus code of the alert's location on the alert
+// and starts a background what3words lookup that adds the words once known
+func (e *LocationEncoder) AnnotateAlert(ctx context.Context, alertID uuid.UUID, lat, lng float64) string {
+	code := pluscode.Encode(lat, lng, pluscode.DefaultLength)
+	if err := e.postgres.SetAlertPlusCode(ctx, alertID, code); err != nil {
+		log.Printf("ERROR: Failed to store plus code of alert %s: %v", alertID, err)
+	}
+	e.lookupAsync(lat, lng, func(words string) {
+		if err := e.postgres.SetAlertWhat3Words(context.Background(), alertID, words); err != nil {
+			log.Printf("ERROR: Failed to store what3words address of alert %s: %v", alertID, err)
+		}
+	})
+	return code
+}
+
+// lookupAsync looks up the what3words address in the background unless it
+// is cached or already being looked up, and calls found with it
+func (e *LocationEncoder) lookupAsync(lat, lng float64, found func(words string)) {
+	cfg := e.cfg.Current()
+	if cfg.What3WordsAPIKey == "" {
+		return
+	}
+
+	key := fmt.Sprintf("%.5f,%.5f", lat, lng)
+	if _, busy := e.inflight.LoadOrStore(key, struct{}{}); busy {
+		return
+	}
+	e.wg.Add(1)
+	go func() {
+		defer e.wg.Done()
+		defer e.inflight.Delete(key)
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.What3WordsTimeoutMS)*time.Millisecond)
+		defer cancel()
+
+		words, err := e.redis.GetCachedWhat3Words(ctx, lat, lng)
+		if err != nil {
+			log.Printf("WARN: Failed to read cached what3words address: %v", err)
+		}
+		if words == "" {
+			if words, err = e.what3words(ctx, cfg.What3WordsAPIKey, lat, lng); err != nil {
+				log.Printf("WARN: what3words lookup failed: %v", err)
+				return
+			}
+			if err := e.redis.CacheWhat3Words(ctx, lat, lng, words, what3wordsCacheTTL); err != nil {
+				log.Printf("WARN: Failed to cache what3words address: %v", err)
+			}
+		}
+		found(words)
+	}()
+}
+
+// what3words converts coordinates to a 3-word address
+func (e *LocationEncoder) what3words(ctx context.Context, apiKey string, lat, lng float64) (string, error) {
+	query := url.Values{}
+	query.Set("coordinates", fmt.Sprintf("%.6f,%.6f", lat, lng))
+	query.Set("key", apiKey)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, what3wordsURL+"?"+query.Encode(), nil)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		Words string `json:"words"`
+		Error *struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("what3words answered %d: %w", resp.StatusCode, err)
+	}
+	if body.Error != nil {
+		return "", fmt.Errorf("what3words answered %d: %s: %s", resp.StatusCode, body.Error.Code, body.Error.Message)
+	}
+	if resp.StatusCode != http.StatusOK || body.Words == "" {
+		return "", fmt.Errorf("what3words answered %d without an address", resp.StatusCode)
+	}
+	return body.Words, nil
+}
+
+// PlaceName returns the cached name of the place at a location, e.g.
+// "Allen Avenue, Ikeja, Lagos", or "" if it isn't known yet. Unknown places
+// are reverse geocoded with Mapbox in the background when MAPBOX_TOKEN is
+// set, so the name shows up on a later call. It never calls out.
+func (e *LocationEncoder) PlaceName(ctx context.Context, lat, lng float64) string {
+	token := e.cfg.Current().MapboxToken
+	if token == "" || (lat == 0 && lng == 0) {
+		return ""
+	}
+	name, err := e.redis.GetCachedPlaceName(ctx, lat, lng)
+	if err != nil {
+		log.Printf("WARN: Failed to read cached place name: %v", err)
+	}
+	if name == "" {
+		e.lookupPlaceAsync(token, lat, lng)
+	}
+	return name
+}
+
+// lookupPlaceAsync reverse geocodes a location in the background and caches
+// its name, unless it is already being looked up
+func (e *LocationEncoder) lookupPlaceAsync(token string, lat, lng float64) {
+	key := fmt.Sprintf("place:%.3f,%.3f", lat, lng)
+	if _, busy := e.inflight.LoadOrStore(key, struct{}{}); busy {
+		return
+	}
+	e.wg.Add(1)
+	go func() {
+		defer e.wg.Done()
+		defer e.inflight.Delete(key)
+
+		ctx, cancel := context.WithTimeout(context.Background(), placeNameTimeout)
+		defer cancel()
+
+		name, err := e.reverseGeocode(ctx, token, lat, lng)
+		if err != nil {
+			log.Printf("WARN: Reverse geocoding failed: %v", err)
+			return
+		}
+		if err := e.redis.CachePlaceName(ctx, lat, lng, name, placeNameCacheTTL); err != nil {
+			log.Printf("WARN: Failed to cache place name: %v", err)
+		}
+	}()
+}
+
+// reverseGeocode returns the name of the nearest address, neighborhood or town
+func (e *LocationEncoder) reverseGeocode(ctx context.Context, token string, lat, lng float64) (string, error) {
+	query := url.Values{}
+	query.Set("access_token", token)
+	query.Set("types", "address,neighborhood,locality,place")
+	query.Set("limit", "1")
+	endpoint := fmt.Sprintf("%s%.6f,%.6f.json?%s", placeNameURL, lng, lat, query.Encode())
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		Features []struct {
+			PlaceName string `json:"place_name"`
+		} `json:"features"`
+		Message string `json:"message"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("mapbox answered %d: %w", resp.StatusCode, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("mapbox answered %d: %s", resp.StatusCode, body.Message)
+	}
+	if len(body.Features) == 0 || body.Features[0].PlaceName == "" {
+		return "", fmt.Errorf("mapbox found no place at %.6f,%.6f", lat, lng)
+	}
+	return body.Features[0].PlaceName, nil
+}
+
+// Geocode looks up an address in a country, given by its ISO code, with
+// Mapbox and returns its location and full name. Unlike the other lookups it
+// calls out and waits, bounded by placeNameTimeout: it serves a person
+// saving an address, once, not an alert.
+func (e *LocationEncoder) Geocode(ctx context.Context, address, countryCode string) (lat, lng float64, name string, err error) {
+	token := e.cfg.Current().MapboxToken
+	if token == "" {
+		return 0, 0, "", ErrGeocodingDisabled
+	}
+	ctx, cancel := context.WithTimeout(ctx, placeNameTimeout)
+	defer cancel()
+
+	query := url.Values{}
+	query.Set("access_token", token)
+	query.Set("types", "address,poi,neighborhood,locality,place")
+	query.Set("limit", "1")
+	if countryCode != "" {
+		query.Set("country", strings.ToLower(countryCode))
+	}
+	endpoint := placeNameURL + url.PathEscape(address) + ".json?" + query.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return 0, 0, "", err
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return 0, 0, "", err
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		Features []struct {
+			PlaceName string    `json:"place_name"`
+			Center    []float64 `json:"center"` // longitude, latitude
+		} `json:"features"`
+		Message string `json:"message"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return 0, 0, "", fmt.Errorf("mapbox answered %d: %w", resp.StatusCode, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return 0, 0, "", fmt.Errorf("mapbox answered %d: %s", resp.StatusCode, body.Message)
+	}
+	if len(body.Features) == 0 || len(body.Features[0].Center) != 2 {
+		return 0, 0, "", ErrAddressNotFound
+	}
+	f := body.Features[0]
+	return f.Center[1], f.Center[0], f.PlaceName, nil
+}
+
+// AdminArea is the first-level administrative area a location lies in: a
+// state in Nigeria, a region in Ghana
+type AdminArea struct {
+	Code string // ISO 3166-2, e.g. NG-LA; empty if unknown
+	Name string // e.g. Lagos
+}
+
+// AdminArea reverse geocodes the state or region a location lies in with
+// Mapbox and caches it. Like Geocode it calls out and waits, bounded by
+// placeNameTimeout: it serves a handoff decided in the background, not the
+// alert itself. A location in none, e.g. offshore, gives an empty area.
+func (e *LocationEncoder) AdminArea(ctx context.Context, lat, lng float64) (AdminArea, error) {
+	token := e.cfg.Current().MapboxToken
+	if token == "" {
+		return AdminArea{}, ErrGeocodingDisabled
+	}
+	code, name, found, err := e.redis.GetCachedAdminArea(ctx, lat, lng)
+	if err != nil {
+		log.Printf("WARN: Failed to read cached admin area: %v", err)
+	}
+	if found {
+		return AdminArea{Code: code, Name: name}, nil
+	}
+
+	lookupCtx, cancel := context.WithTimeout(ctx, placeNameTimeout)
+	defer cancel()
+	query := url.Values{}
+	query.Set("access_token", token)
+	query.Set("types", "region")
+	query.Set("limit", "1")
+	endpoint := fmt.Sprintf("%s%.6f,%.6f.json?%s", placeNameURL, lng, lat, query.Encode())
+	req, err := http.NewRequestWithContext(lookupCtx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return AdminArea{}, err
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return AdminArea{}, err
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		Features []struct {
+			Text       string `json:"text"`
+			Properties struct {
+				ShortCode string `json:"short_code"`
+			} `json:"properties"`
+		} `json:"features"`
+		Message string `json:"message"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return AdminArea{}, fmt.Errorf("mapbox answered %d: %w", resp.StatusCode, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return AdminArea{}, fmt.Errorf("mapbox answered %d: %s", resp.StatusCode, body.Message)
+	}
+	var area AdminArea
+	if len(body.Features) > 0 {
+		area.Code = strings.ToUpper(body.Features[0].Properties.ShortCode)
+		area.Name = body.Features[0].Text
+	}
+	if err := e.redis.CacheAdminArea(ctx, lat, lng, area.Code, area.Name, placeNameCacheTTL); err != nil {
+		log.Printf("WARN: Failed to cache admin area: %v", err)
+	}
+	return area, nil
+}
+
+// Close waits for lookups in flight, which are bounded by their timeout
+func (e *LocationEncoder) Close() {
+	e.wg.Wait()
+}
@@ -0,0 +1,127 @@
+package services
+
+import (
+	"context"
+	"log"
+	"math"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+)
+
+// Coarse location mode. A user who turns on the coarse_location setting has
+// their heartbeats stored with coordinates truncated to
+// COARSE_LOCATION_DECIMALS places, so neither Postgres nor anything that
+// reads history sees where they are more closely than that. The precise fix
+// of their newest heartbeat is kept in Redis alone, for
+// COARSE_PRECISE_TTL_SECONDS, and the evaluator judges them on it.
+//
+// Once the user is AT_RISK or in ALERT, their heartbeats are stored precise
+// until PRECISION_ESCALATION_BUFFER_MINUTES after the incident ends, and the
+// alert is raised with the precise fix.
+
+// CoarsenCoordinate truncates a latitude or longitude to decimals places
+func CoarsenCoordinate(v float64, decimals int) float64 {
+	scale := math.Pow10(decimals)
+	coarse := math.Trunc(v*scale) / scale
+	if coarse == 0 {
+		return 0 // not -0, for a point just south or west of zero
+	}
+	return coarse
+}
+
+// incidentState reports whether state keeps a coarse user's heartbeats precise
+func incidentState(state string) bool {
+	return state == StateAtRisk || state == StateAlert
+}
+
+// CoarsenLocation truncates the coordinates of a heartbeat about to be
+// stored, when settings are in coarse location mode and the user isn't in an
+// incident, keeping its precise fix in Redis. A heartbeat that can't be told
+// to be in an incident is coarsened: the user asked for it.
+func (se *SafetyEvaluator) CoarsenLocation(ctx context.Context, settings models.UserSettings, hb *models.Heartbeat) {
+	if !settings.CoarseLocation {
+		return
+	}
+	precise, err := se.precisionEscalated(ctx, hb.UserID)
+	if err != nil {
+		log.Printf("WARN: Precision escalation check failed for user %s, storing heartbeat coarse: %v", hb.UserID, err)
+	}
+	if precise {
+		return
+	}
+
+	cfg := se.cfg.Current()
+	// Backfilled heartbeats and copies aren't what the user is judged on
+	if !hb.Backfill && hb.DuplicateOf == nil {
+		loc := &models.PreciseLocation{HeartbeatID: hb.ID, Lat: hb.Lat, Lng: hb.Lng}
+		ttl := time.Duration(cfg.CoarsePreciseTTLSeconds) * time.Second
+		if err := se.redis.SetPreciseLocation(ctx, hb.UserID, loc, ttl); err != nil {
+			log.Printf("WARN: Failed to keep precise location of user %s: %v", hb.UserID, err)
+		}
+	}
+	hb.Lat = CoarsenCoordinate(hb.Lat, cfg.CoarseLocationDecimals)
+	hb.Lng = CoarsenCoordinate(hb.Lng, cfg.CoarseLocationDecimals)
+	hb.Coarse = true
+}
+
+// RestoreLocation puts the precise fix back on a coarse heartbeat read from
+// Postgres, if Redis still has it; otherwise the heartbeat stays coarse
+func (se *SafetyEvaluator) RestoreLocation(ctx context.Context, hb *models.Heartbeat) {
+	if hb == nil || !hb.Coarse {
+		return
+	}
+	loc, err := se.redis.GetPreciseLocation(ctx, hb.UserID)
+	if err != nil {
+		log.Printf("WARN: Precise location of user %s unavailable: %v", hb.UserID, err)
+		return
+	}
+	if loc == nil || loc.HeartbeatID != hb.ID {
+		return
+	}
+	hb.Lat, hb.Lng = loc.Lat, loc.Lng
+	hb.Coarse = false
+}
+
+// precisionEscalated reports whether the user's heartbeats are kept precise:
+// they are in an incident, or one ended within the buffer
+func (se *SafetyEvaluator) precisionEscalated(ctx context.Context, userID uuid.UUID) (bool, error) {
+	escalated, err := se.redis.PrecisionEscalated(ctx, userID)
+	if err != nil || escalated {
+		return escalated, err
+	}
+	// The marker is renewed as the user is evaluated; one who went silent
+	// in an incident may have outlived it
+	state, err := se.redis.GetUserState(ctx, userID)
+	if err != nil || state == nil {
+		return false, err
+	}
+	return incidentState(state.State), nil
+}
+
+// escalatePrecision keeps the heartbeats of a coarse user in an incident
+// precise until the buffer after their latest state in one. Failures are
+// logged.
+func (se *SafetyEvaluator) escalatePrecision(ctx context.Context, state *models.UserState) {
+	if !incidentState(state.State) {
+		return
+	}
+	user, err := se.postgres.GetUserByID(ctx, state.UserID)
+	if err != nil || user == nil || !user.Settings.CoarseLocation {
+		if err != nil {
+			log.Printf("WARN: Failed to escalate location precision of user %s: %v", state.UserID, err)
+		}
+		return
+	}
+	buffer := time.Duration(se.cfg.Current().PrecisionBufferMinutes) * time.Minute
+	started, err := se.redis.EscalatePrecision(ctx, state.UserID, buffer)
+	if err != nil {
+		log.Printf("WARN: Failed to escalate location precision of user %s: %v", state.UserID, err)
+		return
+	}
+	if started {
+		log.Printf("INFO: Heartbeats of coarse user %s are kept precise while %s", state.UserID, state.State)
+	}
+}
@@ -0,0 +1,178 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/config"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+)
+
+func TestCoarsenCoordinate(t *testing.T) {
+	tests := []struct {
+		v        float64
+		decimals int
+		want     float64
+	}{
+		{6.5243793, 2, 6.52},
+		{3.3792057, 2, 3.37},
+		{6.5243793, 3, 6.524},
+		{6.5299999, 1, 6.5},
+		{-0.1867, 2, -0.18}, // towards zero, not down
+		{-0.004, 2, 0},
+		{0, 2, 0},
+		{179.999, 2, 179.99},
+	}
+	for _, tt := range tests {
+		got := CoarsenCoordinate(tt.v, tt.decimals)
+		if got != tt.want {
+			t.Errorf("CoarsenCoordinate(%v, %d) = %v, want %v", tt.v, tt.decimals, got, tt.want)
+		}
+	}
+	if got := CoarsenCoordinate(-0.004, 2); 1/got < 0 {
+		t.Error("a point just west of zero coarsened to -0")
+	}
+}
+
+// privateHeartbeat is a heartbeat of the user's, 6 decimal places precise
+func privateHeartbeat(userID uuid.UUID, after time.Duration) *models.Heartbeat {
+	hb := simulatedHeartbeat(0, false)
+	hb.ID, hb.UserID = uuid.New(), userID
+	hb.Lat, hb.Lng = 6.5243793, 3.3792057
+	hb.Timestamp = time.Now().Add(-time.Hour + after)
+	return &hb
+}
+
+// A coarse user's heartbeats are stored coarse, with the precise fix in
+// Redis alone; from an incident until the buffer after it they are stored
+// precise, then coarse again
+func TestCoarseLocation(t *testing.T) {
+	postgres, redis := testPostgres(t), testRedis(t)
+	ctx := context.Background()
+	cfg := config.NewStore(&config.Config{CoarseLocationDecimals: 2, CoarsePreciseTTLSeconds: 600, PrecisionBufferMinutes: 60})
+	se := &SafetyEvaluator{cfg: cfg, postgres: postgres, redis: redis, clock: SystemClock{}}
+	effects := liveEffects{se: se}
+
+	user := createTestUser(t, postgres, "Ada")
+	user.Settings.CoarseLocation = true
+	if err := postgres.UpdateUserSettings(ctx, user.ID, user.Settings); err != nil {
+		t.Fatalf("UpdateUserSettings: %v", err)
+	}
+
+	// store runs a heartbeat through ingestion and reads it back from Postgres
+	store := func(hb *models.Heartbeat) *models.Heartbeat {
+		t.Helper()
+		se.CoarsenLocation(ctx, user.Settings, hb)
+		if err := postgres.CreateHeartbeat(ctx, hb); err != nil {
+			t.Fatalf("CreateHeartbeat: %v", err)
+		}
+		stored, err := postgres.GetLatestHeartbeat(ctx, user.ID)
+		if err != nil || stored == nil || stored.ID != hb.ID {
+			t.Fatalf("GetLatestHeartbeat = %+v, %v; want %s", stored, err, hb.ID)
+		}
+		return stored
+	}
+	wantCoarse := func(when string, stored *models.Heartbeat) {
+		t.Helper()
+		if !stored.Coarse || stored.Lat != 6.52 || stored.Lng != 3.37 {
+			t.Errorf("%s: stored %v,%v coarse %t; want 6.52,3.37 coarse", when, stored.Lat, stored.Lng, stored.Coarse)
+		}
+	}
+	wantPrecise := func(when string, stored *models.Heartbeat) {
+		t.Helper()
+		if stored.Coarse || stored.Lat != 6.5243793 || stored.Lng != 3.3792057 {
+			t.Errorf("%s: stored %v,%v coarse %t; want precise", when, stored.Lat, stored.Lng, stored.Coarse)
+		}
+	}
+
+	// Day to day, Postgres sees only the coarse fix; the evaluator and the
+	// alert get the precise one back while Redis keeps it
+	first := store(privateHeartbeat(user.ID, 0))
+	wantCoarse("day to day", first)
+	se.RestoreLocation(ctx, first)
+	wantPrecise("restored", first)
+
+	// A backfilled heartbeat is coarsened but doesn't replace the fix the
+	// user is judged on, so the newest coarse heartbeat can't be restored
+	backfill := privateHeartbeat(user.ID, -time.Minute)
+	backfill.Backfill = true
+	se.CoarsenLocation(ctx, user.Settings, backfill)
+	if !backfill.Coarse || backfill.Lat != 6.52 {
+		t.Errorf("backfill kept %v precise", backfill.Lat)
+	}
+	stored, err := postgres.GetLatestHeartbeat(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("GetLatestHeartbeat: %v", err)
+	}
+	se.RestoreLocation(ctx, stored)
+	wantPrecise("restored after a backfill", stored)
+
+	second := store(privateHeartbeat(user.ID, time.Minute))
+	wantCoarse("the next heartbeat", second)
+	se.RestoreLocation(ctx, first)
+	if !first.Coarse {
+		t.Error("an older heartbeat got the newest one's precise fix")
+	}
+
+	// Someone not in coarse mode is untouched
+	other := privateHeartbeat(uuid.New(), 0)
+	se.CoarsenLocation(ctx, models.UserSettings{}, other)
+	if other.Coarse || other.Lat != 6.5243793 {
+		t.Errorf("coarsened %v for a user not in coarse mode", other.Lat)
+	}
+
+	// In an incident they are stored precise
+	if err := effects.SaveState(ctx, &models.UserState{UserID: user.ID, State: StateAtRisk, Score: 40, LastHeartbeat: time.Now()}); err != nil {
+		t.Fatalf("SaveState: %v", err)
+	}
+	wantPrecise("AT_RISK", store(privateHeartbeat(user.ID, 2*time.Minute)))
+	if err := effects.SaveState(ctx, &models.UserState{UserID: user.ID, State: StateAlert, Score: 20, LastHeartbeat: time.Now()}); err != nil {
+		t.Fatalf("SaveState: %v", err)
+	}
+	wantPrecise("ALERT", store(privateHeartbeat(user.ID, 3*time.Minute)))
+
+	// and for the buffer after it ends
+	if err := effects.SaveState(ctx, &models.UserState{UserID: user.ID, State: StateSafe, Score: 90, LastHeartbeat: time.Now()}); err != nil {
+		t.Fatalf("SaveState: %v", err)
+	}
+	wantPrecise("SAFE within the buffer", store(privateHeartbeat(user.ID, 4*time.Minute)))
+
+	// Once the buffer runs out they are coarse again
+	if _, err := redis.EscalatePrecision(ctx, user.ID, 200*time.Millisecond); err != nil {
+		t.Fatalf("EscalatePrecision: %v", err)
+	}
+	time.Sleep(300 * time.Millisecond)
+	wantCoarse("after the buffer", store(privateHeartbeat(user.ID, 5*time.Minute)))
+}
+
+// An incident the escalation marker missed, such as a user gone silent
+// past it, still keeps heartbeats precise while the state says so
+func TestCoarseLocationIncidentWithoutMarker(t *testing.T) {
+	postgres, redis := testPostgres(t), testRedis(t)
+	ctx := context.Background()
+	cfg := config.NewStore(&config.Config{CoarseLocationDecimals: 2, CoarsePreciseTTLSeconds: 600, PrecisionBufferMinutes: 60})
+	se := &SafetyEvaluator{cfg: cfg, postgres: postgres, redis: redis, clock: SystemClock{}}
+	settings := models.UserSettings{CoarseLocation: true}
+
+	user := createTestUser(t, postgres, "Ada")
+	if err := redis.SaveUserState(ctx, &models.UserState{UserID: user.ID, State: StateAlert, Score: 20, LastHeartbeat: time.Now()}); err != nil {
+		t.Fatalf("SaveUserState: %v", err)
+	}
+	hb := privateHeartbeat(user.ID, 0)
+	se.CoarsenLocation(ctx, settings, hb)
+	if hb.Coarse || hb.Lat != 6.5243793 {
+		t.Errorf("coarsened %v in ALERT", hb.Lat)
+	}
+
+	// escalatePrecision leaves users not in coarse mode alone
+	if err := redis.SaveUserState(ctx, &models.UserState{UserID: user.ID, State: StateSafe, Score: 90, LastHeartbeat: time.Now()}); err != nil {
+		t.Fatalf("SaveUserState: %v", err)
+	}
+	se.escalatePrecision(ctx, &models.UserState{UserID: user.ID, State: StateAtRisk})
+	if escalated, err := redis.PrecisionEscalated(ctx, user.ID); err != nil || escalated {
+		t.Errorf("escalated %t, %v for a user whose stored settings aren't coarse", escalated, err)
+	}
+}
@@ -0,0 +1,312 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/config"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/database"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+)
+
+const (
+	// mapSnapshotTimeout bounds making a snapshot; past it the alert goes out text-only
+	mapSnapshotTimeout = 2 * time.Second
+	// The path overlay is part of the URL, which Mapbox limits to 8192 bytes
+	mapSnapshotPoints     = 50
+	mapSnapshotToleranceM = 10
+	mapSnapshotMaxBytes   = 4 << 20
+	mapSnapshotURL        = "https://api.mapbox.com/styles/v1/mapbox/streets-v11/static/"
+	mapSnapshotSize       = "600x400@2x"
+
+	mapSnapshotPrunerWorker = "map_snapshot_pruner"
+	mapSnapshotPruneEvery   = time.Hour
+	mapSnapshotPruneBatch   = 100
+)
+
+// ErrMapSnapshotMissing is returned for an alert without a map snapshot
+var ErrMapSnapshotMissing = errors.New("alert has no map snapshot")
+
+// MapSnapshots renders a static map of an alert, the last hour's breadcrumb
+// and a pin where it was raised, through the Mapbox Static Images API and
+// keeps the PNG in object storage under the alert. WhatsApp alerts carry it
+// as media and SMS alerts link to it, so the Mapbox token never leaves the
+// server. A snapshot is made once per alert and deleted
+// ALERT_MAP_RETENTION_HOURS after the alert is resolved.
+//
+// Snapshots need MAPBOX_TOKEN, PUBLIC_BASE_URL and object storage; without
+// them, or when Mapbox is slow, alerts go out text-only.
+type MapSnapshots struct {
+	cfg      *config.Store
+	postgres *database.PostgresDB
+	store    ObjectStore // nil without object storage
+	health   *HealthRegistry
+	client   *http.Client
+
+	closeOnce sync.Once
+	stop      chan struct{}
+	done      chan struct{}
+}
+
+func NewMapSnapshots(cfg *config.Store, postgres *database.PostgresDB, store ObjectStore, health *HealthRegistry) *MapSnapshots {
+	return &MapSnapshots{
+		cfg:      cfg,
+		postgres: postgres,
+		store:    store,
+		health:   health,
+		client:   &http.Client{},
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// Enabled reports whether alerts get map snapshots
+func (m *MapSnapshots) Enabled() bool {
+	cfg := m.cfg.Current()
+	return m.store != nil && cfg.MapboxToken != "" && cfg.PublicBaseURL != ""
+}
+
+// Ensure makes the alert's snapshot unless it already has one, within
+// mapSnapshotTimeout, and reports whether the alert has one. Failures are
+// logged; the alert is then sent without a map.
+func (m *MapSnapshots) Ensure(ctx context.Context, alert *models.Alert, hb *models.Heartbeat) bool {
+	if !m.Enabled() {
+		return false
+	}
+	ctx, cancel := context.WithTimeout(ctx, mapSnapshotTimeout)
+	defer cancel()
+
+	key, err := m.postgres.GetAlertMapSnapshot(ctx, alert.ID)
+	if err != nil {
+		log.Printf("WARN: Failed to look up map snapshot of alert %s: %v", alert.ID, err)
+		return false
+	}
+	if key != "" {
+		return true
+	}
+
+	image, err := m.render(ctx, alert.UserID, hb)
+	if err != nil {
+		log.Printf("WARN: No map snapshot for alert %s, sending text only: %v", alert.ID, err)
+		return false
+	}
+	key = mapSnapshotKey(alert.ID)
+	if err := m.store.Put(ctx, key, image, "image/png"); err != nil {
+		log.Printf("WARN: Failed to store map snapshot of alert %s: %v", alert.ID, err)
+		return false
+	}
+	if err := m.postgres.SetAlertMapSnapshot(ctx, alert.ID, key, time.Now()); err != nil {
+		log.Printf("WARN: Failed to record map snapshot of alert %s: %v", alert.ID, err)
+		return false
+	}
+	return true
+}
+
+// Image returns the alert's snapshot PNG
+func (m *MapSnapshots) Image(ctx context.Context, alertID uuid.UUID) ([]byte, error) {
+	if m.store == nil {
+		return nil, ErrObjectStoreDisabled
+	}
+	key, err := m.postgres.GetAlertMapSnapshot(ctx, alertID)
+	if err != nil {
+		return nil, err
+	}
+	if key == "" {
+		return nil, ErrMapSnapshotMissing
+	}
+	return m.store.Get(ctx, key)
+}
+
+// Link returns the public link to the snapshot for a recipient's dashboard
+// token, PUBLIC_BASE_URL/map/<token>
+func (m *MapSnapshots) Link(token string) string {
+	return strings.TrimRight(m.cfg.Current().PublicBaseURL, "/") + "/map/" + token
+}
+
+// render fetches the map from Mapbox. The alert point is the heartbeat that
+// raised it, or the latest fix of the last hour if it had none.
+func (m *MapSnapshots) render(ctx context.Context, userID uuid.UUID, hb *models.Heartbeat) ([]byte, error) {
+	now := time.Now()
+	heartbeats, err := m.postgres.GetHeartbeatsBetween(ctx, userID, now.Add(-trackBreadcrumbSpan), now, trackBreadcrumbMaxHeartbeats, false)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get heartbeats: %w", err)
+	}
+	located := heartbeats[:0]
+	for _, h := range heartbeats {
+		if h.Lat != 0 || h.Lng != 0 {
+			located = append(located, h)
+		}
+	}
+	track := DownsampleTrack(located, mapSnapshotToleranceM, mapSnapshotPoints)
+
+	lat, lng := hb.Lat, hb.Lng
+	if lat == 0 && lng == 0 {
+		if len(track) == 0 {
+			return nil, fmt.Errorf("no position in the last %s", trackBreadcrumbSpan)
+		}
+		lat, lng = track[len(track)-1].Lat, track[len(track)-1].Lng
+	}
+
+	overlays := fmt.Sprintf("pin-l+f74e4e(%.6f,%.6f)", lng, lat)
+	viewport := "auto"
+	if len(track) >= 2 {
+		overlays = "path-4+3b82f6-0.8(" + url.PathEscape(encodePolyline(track)) + ")," + overlays
+	} else {
+		viewport = fmt.Sprintf("%.6f,%.6f,15,0", lng, lat)
+	}
+
+	query := url.Values{}
+	query.Set("access_token", m.cfg.Current().MapboxToken)
+	if viewport == "auto" {
+		query.Set("padding", "40")
+	}
+	endpoint := mapSnapshotURL + overlays + "/" + viewport + "/" + mapSnapshotSize + "?" + query.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := m.client.Do(req)
+	if err != nil {
+		// The error carries the URL, and with it the token
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return nil, fmt.Errorf("mapbox request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, mapSnapshotMaxBytes))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read map: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		var failure struct {
+			Message string `json:"message"`
+		}
+		json.Unmarshal(body, &failure)
+		return nil, fmt.Errorf("mapbox answered %d: %s", resp.StatusCode, failure.Message)
+	}
+	if contentType := resp.Header.Get("Content-Type"); !strings.HasPrefix(contentType, "image/png") {
+		return nil, fmt.Errorf("mapbox answered with %q, not a PNG", contentType)
+	}
+	return body, nil
+}
+
+// Start launches the goroutine that deletes expired snapshots; the first
+// pass runs immediately. Without object storage there is nothing to delete.
+func (m *MapSnapshots) Start() {
+	if m.store == nil {
+		close(m.done)
+		return
+	}
+	m.health.Register(mapSnapshotPrunerWorker, mapSnapshotPruneEvery)
+	go m.run()
+}
+
+// Close stops the pruner and waits for a running pass to finish
+func (m *MapSnapshots) Close() {
+	m.closeOnce.Do(func() {
+		close(m.stop)
+	})
+	<-m.done
+}
+
+func (m *MapSnapshots) run() {
+	defer close(m.done)
+
+	ticker := time.NewTicker(mapSnapshotPruneEvery)
+	defer ticker.Stop()
+
+	for {
+		if m.prune() {
+			m.health.Beat(mapSnapshotPrunerWorker)
+		}
+		select {
+		case <-m.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// prune deletes the snapshots of alerts resolved longer ago than the
+// retention, a batch at a time, and reports whether it succeeded
+func (m *MapSnapshots) prune() bool {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	retention := time.Duration(m.cfg.Current().AlertMapRetentionHours) * time.Hour
+	deleted := 0
+	for {
+		expired, err := m.postgres.GetExpiredAlertMapSnapshots(ctx, time.Now().Add(-retention), mapSnapshotPruneBatch)
+		if err != nil {
+			log.Printf("ERROR: Failed to list expired map snapshots: %v", err)
+			return false
+		}
+		for alertID, key := range expired {
+			if err := m.store.Delete(ctx, key); err != nil {
+				log.Printf("ERROR: Failed to delete map snapshot of alert %s: %v", alertID, err)
+				return false
+			}
+			if err := m.postgres.ClearAlertMapSnapshot(ctx, alertID); err != nil {
+				log.Printf("ERROR: Failed to clear map snapshot of alert %s: %v", alertID, err)
+				return false
+			}
+			deleted++
+		}
+		if len(expired) < mapSnapshotPruneBatch {
+			break
+		}
+	}
+	if deleted > 0 {
+		log.Printf("INFO: Deleted %d alert map snapshots older than %s", deleted, retention)
+	}
+	return true
+}
+
+func mapSnapshotKey(alertID uuid.UUID) string {
+	return "alerts/" + alertID.String() + "/map.png"
+}
+
+// encodePolyline encodes a track in Google's polyline format with five
+// decimal places, as Mapbox path overlays take it
+func encodePolyline(track []models.Heartbeat) string {
+	var b strings.Builder
+	var prevLat, prevLng int64
+	for _, p := range track {
+		lat, lng := roundE5(p.Lat), roundE5(p.Lng)
+		encodePolylineValue(&b, lat-prevLat)
+		encodePolylineValue(&b, lng-prevLng)
+		prevLat, prevLng = lat, lng
+	}
+	return b.String()
+}
+
+func encodePolylineValue(b *strings.Builder, v int64) {
+	u := uint64(v) << 1
+	if v < 0 {
+		u = ^u
+	}
+	for u >= 0x20 {
+		b.WriteByte(byte((0x20 | (u & 0x1f)) + 63))
+		u >>= 5
+	}
+	b.WriteByte(byte(u + 63))
+}
+
+func roundE5(x float64) int64 {
+	return int64(math.Round(x * 1e5))
+}
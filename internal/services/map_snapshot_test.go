@@ -0,0 +1,210 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/config"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+)
+
+// fakeMapbox answers the Static Images API in the test's place, counting
+// the requests made and the token sent with them
+type fakeMapbox struct {
+	server   *httptest.Server
+	requests atomic.Int32
+	token    atomic.Value
+}
+
+func newFakeMapbox(t *testing.T, handler http.HandlerFunc) *fakeMapbox {
+	t.Helper()
+	f := &fakeMapbox{}
+	f.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.requests.Add(1)
+		f.token.Store(r.URL.Query().Get("access_token"))
+		handler(w, r)
+	}))
+	t.Cleanup(f.server.Close)
+	return f
+}
+
+// RoundTrip sends every request to the fake, whatever host it was for
+func (f *fakeMapbox) RoundTrip(req *http.Request) (*http.Response, error) {
+	target, _ := url.Parse(f.server.URL)
+	req = req.Clone(req.Context())
+	req.URL.Scheme, req.URL.Host = target.Scheme, target.Host
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func pngHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "image/png")
+	w.Write([]byte("\x89PNG\r\n\x1a\nmap"))
+}
+
+// newTestMapSnapshots returns snapshots rendered by the fake, kept in
+// memory, and an alert to render them for
+func newTestMapSnapshots(t *testing.T, mapbox *fakeMapbox, token string) (*MapSnapshots, *memoryObjectStore, *models.Alert) {
+	t.Helper()
+	postgres := testPostgres(t)
+	user := createTestUser(t, postgres, "Ada")
+	now := time.Now()
+	alert := &models.Alert{ID: uuid.New(), UserID: user.ID, State: models.AlertStateAlert, Reasons: models.Reasons{}, SentTo: []string{}, CreatedAt: now, DetectedAt: now}
+	if err := postgres.CreateAlert(context.Background(), alert); err != nil {
+		t.Fatalf("CreateAlert: %v", err)
+	}
+	store := newMemoryObjectStore()
+	cfg := &config.Config{MapboxToken: token, PublicBaseURL: "https://safetrace.example"}
+	maps := NewMapSnapshots(config.NewStore(cfg), postgres, store, nil)
+	maps.client = &http.Client{Transport: mapbox}
+	return maps, store, alert
+}
+
+var snapshotHeartbeat = &models.Heartbeat{Lat: 6.5244, Lng: 3.3792}
+
+// A snapshot is made once per alert and kept under it
+func TestMapSnapshotEnsure(t *testing.T) {
+	mapbox := newFakeMapbox(t, pngHandler)
+	maps, store, alert := newTestMapSnapshots(t, mapbox, "pk.secret")
+	ctx := context.Background()
+
+	if !maps.Ensure(ctx, alert, snapshotHeartbeat) {
+		t.Fatal("Ensure() = false, want a snapshot")
+	}
+	if token, _ := mapbox.token.Load().(string); token != "pk.secret" {
+		t.Errorf("Mapbox called with token %q", token)
+	}
+	image, err := maps.Image(ctx, alert.ID)
+	if err != nil || !strings.HasPrefix(string(image), "\x89PNG") {
+		t.Fatalf("Image() = %q, %v", image, err)
+	}
+	if _, err := store.Get(ctx, mapSnapshotKey(alert.ID)); err != nil {
+		t.Errorf("snapshot not stored under the alert: %v", err)
+	}
+
+	if !maps.Ensure(ctx, alert, snapshotHeartbeat) {
+		t.Error("second Ensure() = false")
+	}
+	if n := mapbox.requests.Load(); n != 1 {
+		t.Errorf("Mapbox called %d times, want once", n)
+	}
+	if link := maps.Link("tok"); link != "https://safetrace.example/map/tok" {
+		t.Errorf("Link() = %q", link)
+	}
+}
+
+// When Mapbox hangs the alert goes out text-only within the bound, and a
+// later alert can still get its map
+func TestMapSnapshotTimeout(t *testing.T) {
+	hang := make(chan struct{})
+	mapbox := newFakeMapbox(t, func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-hang:
+		}
+	})
+	defer close(hang)
+	maps, store, alert := newTestMapSnapshots(t, mapbox, "pk.secret")
+	ctx := context.Background()
+
+	start := time.Now()
+	if maps.Ensure(ctx, alert, snapshotHeartbeat) {
+		t.Fatal("Ensure() = true with Mapbox hanging")
+	}
+	if elapsed := time.Since(start); elapsed > mapSnapshotTimeout+time.Second {
+		t.Errorf("Ensure() took %s, want about %s", elapsed, mapSnapshotTimeout)
+	}
+	if _, err := maps.Image(ctx, alert.ID); !errors.Is(err, ErrMapSnapshotMissing) {
+		t.Errorf("Image() after a timeout = %v, want ErrMapSnapshotMissing", err)
+	}
+	if len(store.objects) != 0 {
+		t.Errorf("%d objects stored after a timeout", len(store.objects))
+	}
+
+	// A caller already short of time gets no more than it has left
+	short, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	start = time.Now()
+	if maps.Ensure(short, alert, snapshotHeartbeat) {
+		t.Error("Ensure() = true past the caller's deadline")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Ensure() took %s past a 100ms deadline", elapsed)
+	}
+}
+
+// Anything short of a PNG from Mapbox falls back to text-only, and the
+// token never shows up in what is logged
+func TestMapSnapshotFallback(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+	}{
+		{"unauthorized", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"message":"Not Authorized - Invalid Token"}`))
+		}},
+		{"not a PNG", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte("<html>maintenance</html>"))
+		}},
+		{"connection dropped", func(w http.ResponseWriter, r *http.Request) {
+			conn, _, _ := w.(http.Hijacker).Hijack()
+			conn.Close()
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mapbox := newFakeMapbox(t, tt.handler)
+			maps, _, alert := newTestMapSnapshots(t, mapbox, "pk.secret")
+			ctx := context.Background()
+
+			if _, err := maps.render(ctx, alert.UserID, snapshotHeartbeat); err == nil || strings.Contains(err.Error(), "pk.secret") {
+				t.Errorf("render() error = %v, want one without the token", err)
+			}
+			if maps.Ensure(ctx, alert, snapshotHeartbeat) {
+				t.Error("Ensure() = true")
+			}
+			if _, err := maps.Image(ctx, alert.ID); !errors.Is(err, ErrMapSnapshotMissing) {
+				t.Errorf("Image() = %v, want ErrMapSnapshotMissing", err)
+			}
+		})
+	}
+}
+
+// Without a Mapbox token, or a working store, alerts are text-only
+func TestMapSnapshotDisabled(t *testing.T) {
+	mapbox := newFakeMapbox(t, pngHandler)
+	maps, _, alert := newTestMapSnapshots(t, mapbox, "")
+	if maps.Ensure(context.Background(), alert, snapshotHeartbeat) || mapbox.requests.Load() != 0 {
+		t.Errorf("Ensure() without a token made %d requests", mapbox.requests.Load())
+	}
+
+	maps, store, alert := newTestMapSnapshots(t, mapbox, "pk.secret")
+	store.Fail = errors.New("bucket unavailable")
+	if maps.Ensure(context.Background(), alert, snapshotHeartbeat) {
+		t.Error("Ensure() = true with the store failing")
+	}
+	if _, err := maps.Image(context.Background(), alert.ID); !errors.Is(err, ErrMapSnapshotMissing) {
+		t.Errorf("Image() = %v, want ErrMapSnapshotMissing", err)
+	}
+}
+
+// Google's own example of the polyline format
+func TestEncodePolyline(t *testing.T) {
+	track := []models.Heartbeat{{Lat: 38.5, Lng: -120.2}, {Lat: 40.7, Lng: -120.95}, {Lat: 43.252, Lng: -126.453}}
+	if got, want := encodePolyline(track), "_p~iF~ps|U_ulLnnqC_mqNvxq`@"; got != want {
+		t.Errorf("encodePolyline() = %q, want %q", got, want)
+	}
+	if got := encodePolyline(nil); got != "" {
+		t.Errorf("encodePolyline(nil) = %q", got)
+	}
+}
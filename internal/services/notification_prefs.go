@@ -0,0 +1,129 @@
+package services
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/country"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+)
+
+// stateSeverity orders states so they can be compared against a severity floor
+var stateSeverity = map[string]int{
+	StateCaution: 1,
+	StateAtRisk:  2,
+	StateAlert:   3,
+}
+
+// ValidatePreferences checks contact notification preferences for consistency
+func ValidatePreferences(p *models.NotificationPreferences) error {
+	if p == nil {
+		return nil
+	}
+	if p.Timezone != "" {
+		if _, err := time.LoadLocation(p.Timezone); err != nil {
+			return fmt.Errorf("invalid timezone %q", p.Timezone)
+		}
+	}
+	if (p.QuietHoursStart == "") != (p.QuietHoursEnd == "") {
+		return fmt.Errorf("quiet_hours_start and quiet_hours_end must be set together")
+	}
+	if p.QuietHoursStart != "" {
+		if _, err := parseClock(p.QuietHoursStart); err != nil {
+			return fmt.Errorf("invalid quiet_hours_start: %w", err)
+		}
+		if _, err := parseClock(p.QuietHoursEnd); err != nil {
+			return fmt.Errorf("invalid quiet_hours_end: %w", err)
+		}
+	}
+	if p.QuietSeverityFloor != "" {
+		if _, ok := stateSeverity[p.QuietSeverityFloor]; !ok {
+			return fmt.Errorf("quiet_severity_floor must be one of CAUTION, AT_RISK, ALERT")
+		}
+	}
+	if p.MaxDailyNonCritical < 0 {
+		return fmt.Errorf("max_daily_non_critical cannot be negative")
+	}
+	return nil
+}
+
+// ContactLocation returns the contact's configured timezone, falling back to
+// the one of the country of their phone number
+func ContactLocation(phone string, p *models.NotificationPreferences) *time.Location {
+	name := country.Active().ForPhone(phone).Timezone
+	if p != nil && p.Timezone != "" {
+		name = p.Timezone
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+// ContactDay returns the contact's local date at now, as YYYY-MM-DD, the
+// day their daily cap counts against
+func ContactDay(phone string, p *models.NotificationPreferences, now time.Time) string {
+	return now.In(ContactLocation(phone, p)).Format("2006-01-02")
+}
+
+// InQuietHours reports whether now falls inside the quiet window of the
+// contact at phone.
+// Windows that wrap midnight (22:00-07:00) are supported; start == end means none.
+func InQuietHours(phone string, p *models.NotificationPreferences, now time.Time) bool {
+	if p == nil || p.QuietHoursStart == "" || p.QuietHoursEnd == "" {
+		return false
+	}
+	start, err := parseClock(p.QuietHoursStart)
+	if err != nil {
+		return false
+	}
+	end, err := parseClock(p.QuietHoursEnd)
+	if err != nil {
+		return false
+	}
+	if start == end {
+		return false
+	}
+
+	local := now.In(ContactLocation(phone, p))
+	minute := local.Hour()*60 + local.Minute()
+
+	if start < end {
+		return minute >= start && minute < end
+	}
+	return minute >= start || minute < end
+}
+
+// SuppressionReason decides whether a notification to the contact at phone should be held back.
+// It returns "" when the notification should be sent. ALERT always goes through.
+func SuppressionReason(phone string, p *models.NotificationPreferences, state string, sentToday int, now time.Time) string {
+	if p == nil || state == StateAlert {
+		return ""
+	}
+
+	if InQuietHours(phone, p, now) {
+		floor := p.QuietSeverityFloor
+		if floor == "" {
+			floor = StateAlert
+		}
+		if stateSeverity[state] < stateSeverity[floor] {
+			return "suppressed by quiet hours"
+		}
+	}
+
+	if p.MaxDailyNonCritical > 0 && sentToday >= p.MaxDailyNonCritical {
+		return "suppressed by daily cap"
+	}
+
+	return ""
+}
+
+// parseClock parses "HH:MM" into minutes after midnight
+func parseClock(value string) (int, error) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("expected HH:MM, got %q", value)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
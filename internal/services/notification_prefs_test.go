@@ -0,0 +1,98 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+)
+
+const lagosPhone = "+2348031234567"
+
+// Quiet hours are read on the contact's clock: Lagos (UTC+1) for a Nigerian
+// number, or the time zone they set
+func TestInQuietHours(t *testing.T) {
+	overnight := &models.NotificationPreferences{QuietHoursStart: "22:00", QuietHoursEnd: "07:00"}
+	newYork := &models.NotificationPreferences{QuietHoursStart: "22:00", QuietHoursEnd: "07:00", Timezone: "America/New_York"}
+	daytime := &models.NotificationPreferences{QuietHoursStart: "09:00", QuietHoursEnd: "17:00"}
+
+	tests := []struct {
+		name  string
+		prefs *models.NotificationPreferences
+		utc   string
+		want  bool
+	}{
+		{"before the window", overnight, "2026-10-18T20:59:00Z", false},
+		{"window opens", overnight, "2026-10-18T21:00:00Z", true},
+		{"local midnight", overnight, "2026-10-18T23:00:00Z", true},
+		{"UTC midnight", overnight, "2026-10-19T00:00:00Z", true},
+		{"last quiet minute", overnight, "2026-10-19T05:59:00Z", true},
+		{"window closes", overnight, "2026-10-19T06:00:00Z", false},
+		// 22:00 in Lagos is 17:00 in New York (EDT, UTC-4)
+		{"own time zone, Lagos night", newYork, "2026-10-18T21:00:00Z", false},
+		{"own time zone opens", newYork, "2026-10-19T02:00:00Z", true},
+		{"own time zone closes", newYork, "2026-10-19T11:00:00Z", false},
+		{"same-day window", daytime, "2026-10-18T08:00:00Z", true},
+		{"same-day window closes", daytime, "2026-10-18T16:00:00Z", false},
+		{"empty window", &models.NotificationPreferences{QuietHoursStart: "08:00", QuietHoursEnd: "08:00"}, "2026-10-18T07:30:00Z", false},
+		{"no preferences", nil, "2026-10-18T23:00:00Z", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now, err := time.Parse(time.RFC3339, tt.utc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := InQuietHours(lagosPhone, tt.prefs, now); got != tt.want {
+				t.Errorf("InQuietHours(%s) = %v, want %v", tt.utc, got, tt.want)
+			}
+		})
+	}
+}
+
+// The daily cap resets at the contact's midnight, not the server's
+func TestContactDayMidnight(t *testing.T) {
+	clock := NewFakeClock(time.Date(2026, 10, 18, 22, 59, 0, 0, time.UTC))
+	prefs := &models.NotificationPreferences{MaxDailyNonCritical: 2}
+
+	if day := ContactDay(lagosPhone, prefs, clock.Now()); day != "2026-10-18" {
+		t.Errorf("day at 23:59 Lagos = %s, want 2026-10-18", day)
+	}
+	if reason := SuppressionReason(lagosPhone, prefs, StateCaution, 2, clock.Now()); reason != "suppressed by daily cap" {
+		t.Errorf("third CAUTION of the day: %q, want suppressed by daily cap", reason)
+	}
+	if reason := SuppressionReason(lagosPhone, prefs, StateAlert, 2, clock.Now()); reason != "" {
+		t.Errorf("ALERT over the cap: %q, want sent", reason)
+	}
+
+	// 00:00 in Lagos, still the 18th in UTC
+	clock.Advance(time.Minute)
+	if day := ContactDay(lagosPhone, prefs, clock.Now()); day != "2026-10-19" {
+		t.Errorf("day at 00:00 Lagos = %s, want 2026-10-19", day)
+	}
+
+	prefs.Timezone = "America/New_York"
+	if day := ContactDay(lagosPhone, prefs, clock.Now()); day != "2026-10-18" {
+		t.Errorf("day at 19:00 New York = %s, want 2026-10-18", day)
+	}
+}
+
+func TestSuppressionReasonQuietFloor(t *testing.T) {
+	night := time.Date(2026, 10, 18, 23, 0, 0, 0, time.UTC)
+	tests := []struct {
+		floor string
+		state string
+		want  string
+	}{
+		{"", StateAtRisk, "suppressed by quiet hours"},
+		{"", StateAlert, ""},
+		{StateAtRisk, StateCaution, "suppressed by quiet hours"},
+		{StateAtRisk, StateAtRisk, ""},
+	}
+	for _, tt := range tests {
+		prefs := &models.NotificationPreferences{QuietHoursStart: "22:00", QuietHoursEnd: "07:00", QuietSeverityFloor: tt.floor}
+		if got := SuppressionReason(lagosPhone, prefs, tt.state, 0, night); got != tt.want {
+			t.Errorf("floor %q, state %s: %q, want %q", tt.floor, tt.state, got, tt.want)
+		}
+	}
+}
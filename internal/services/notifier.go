@@ -0,0 +1,151 @@
+package services
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"firebase.google.com/go/v4/messaging"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/config"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/database"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+)
+
+// Notifier values for NOTIFIER
+const (
+	NotifierTwilio = "twilio"
+	NotifierDev    = "dev"
+)
+
+// Notifier delivers alerts and messages to users and their contacts.
+// AlertEngine sends through Twilio, the SMS router and FCM; DevNotifier
+// runs the same alert flow but only records what would have been sent.
+type Notifier interface {
+	SendAlertToContacts(ctx context.Context, user *models.User, alert *models.Alert, heartbeat *models.Heartbeat) error
+	SendSMS(ctx context.Context, kind, to, message string) error // kind is a Message* type, for the outbound budget
+	PlaceVoiceCall(ctx context.Context, kind, to, message string) (string, error) // returns the call's ID
+	SendLastGaspAcknowledgment(ctx context.Context, user *models.User) error
+	SendPushNotification(ctx context.Context, fcmToken, title, body string) error
+	SendTrackingCommand(ctx context.Context, fcmToken, action string) error
+	SendIntervalAdvice(ctx context.Context, fcmToken string, seconds int, reason string) error
+	SendCheckInPrompt(ctx context.Context, fcmToken, promptID string, visible bool, seconds int) error
+	SendAutoResolvePrompt(ctx context.Context, fcmToken, alertID string, seconds int) error
+	SendProtectionIncomplete(ctx context.Context, fcmToken string) error
+	SendConsentRequired(ctx context.Context, fcmToken string, scopes []string) error
+	SendReportVerification(ctx context.Context, fcmToken, reportID string) error
+	SendAlertResolved(ctx context.Context, user *models.User, automatic bool) error
+	SendContactDigest(ctx context.Context, phone, message string) error
+}
+
+var (
+	_ Notifier = (*AlertEngine)(nil)
+	_ Notifier = (*DevNotifier)(nil)
+)
+
+// devNotificationLimit is how many recorded messages DevNotifier keeps
+const devNotificationLimit = 500
+
+// DevNotification is a message DevNotifier would have sent
+type DevNotification struct {
+	Channel  string            `json:"channel"` // sms | whatsapp | voice | push | slack | teams | generic_webhook
+	To       string            `json:"to"`      // phone number, FCM token or webhook host
+	Title    string            `json:"title,omitempty"`
+	Body     string            `json:"body,omitempty"`
+	MediaURL string            `json:"media_url,omitempty"`
+	Data     map[string]string `json:"data,omitempty"`
+	SentAt   time.Time         `json:"sent_at"`
+}
+
+// DevNotifier is an AlertEngine whose messages go to the log and an in-memory
+// buffer instead of Twilio or FCM. Recipients, suppression, ack tokens and
+// delivery rows behave as in production, so the alert flow can be exercised
+// end to end without sending anything.
+type DevNotifier struct {
+	*AlertEngine
+
+	mu       sync.Mutex
+	messages []DevNotification
+}
+
+func NewDevNotifier(cfg *config.Store, postgres *database.PostgresDB, redis *database.RedisDB, templates *MessageTemplates, locations *LocationEncoder, maps *MapSnapshots, budget *OutboundBudget) *DevNotifier {
+	dn := &DevNotifier{}
+	dn.AlertEngine = &AlertEngine{
+		cfg:       cfg,
+		postgres:  postgres,
+		redis:     redis,
+		templates: templates,
+		locations: locations,
+		usage:     NewSMSUsage(redis),
+		maps:      maps,
+		budget:    budget,
+		pacer:     NewContactPacer(cfg, postgres, redis),
+		transport: devTransport{dn},
+	}
+	return dn
+}
+
+// Messages returns recorded messages, oldest first
+func (dn *DevNotifier) Messages() []DevNotification {
+	dn.mu.Lock()
+	defer dn.mu.Unlock()
+	out := make([]DevNotification, len(dn.messages))
+	copy(out, dn.messages)
+	return out
+}
+
+// Clear drops all recorded messages
+func (dn *DevNotifier) Clear() {
+	dn.mu.Lock()
+	dn.messages = nil
+	dn.mu.Unlock()
+}
+
+// devTransport records messages on its DevNotifier instead of sending them
+type devTransport struct {
+	dn *DevNotifier
+}
+
+func (t devTransport) SendSMS(ctx context.Context, to, body string) error {
+	t.dn.record(DevNotification{Channel: "sms", To: to, Body: body})
+	return nil
+}
+
+func (t devTransport) SendWhatsApp(ctx context.Context, to, body, mediaURL string) error {
+	t.dn.record(DevNotification{Channel: "whatsapp", To: to, Body: body, MediaURL: mediaURL})
+	return nil
+}
+
+func (t devTransport) PlaceCall(ctx context.Context, to, twiml string) (string, error) {
+	t.dn.record(DevNotification{Channel: "voice", To: to, Body: twiml})
+	return "", nil
+}
+
+func (t devTransport) SendPush(ctx context.Context, message *messaging.Message) error {
+	n := DevNotification{Channel: "push", To: message.Token, Data: message.Data}
+	if message.Notification != nil {
+		n.Title = message.Notification.Title
+		n.Body = message.Notification.Body
+	}
+	t.dn.record(n)
+	return nil
+}
+
+// PostWebhook records a notification channel delivery; the payload is the body
+func (dn *DevNotifier) PostWebhook(ctx context.Context, channel *models.NotificationChannel, payload []byte) (int, string, error) {
+	dn.record(DevNotification{Channel: channel.Type, To: channel.WebhookHost(), Title: channel.Name, Body: string(payload)})
+	return http.StatusOK, "", nil
+}
+
+func (dn *DevNotifier) record(n DevNotification) {
+	n.SentAt = time.Now()
+	log.Printf("INFO: [dev-notifier] channel=%s to=%s title=%q body=%q data=%v", n.Channel, n.To, n.Title, n.Body, n.Data)
+
+	dn.mu.Lock()
+	defer dn.mu.Unlock()
+	dn.messages = append(dn.messages, n)
+	if len(dn.messages) > devNotificationLimit {
+		dn.messages = dn.messages[len(dn.messages)-devNotificationLimit:]
+	}
+}
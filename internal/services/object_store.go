@@ -0,0 +1,62 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"cloud.google.com/go/storage"
+)
+
+// ErrObjectStoreDisabled is returned when a trail lives in object storage
+// but none is configured
+var ErrObjectStoreDisabled = errors.New("object storage is not configured")
+
+// ObjectStore keeps blobs too large for a database row, such as blackbox
+// trails and alert map snapshots, under a key
+type ObjectStore interface {
+	Put(ctx context.Context, key string, data []byte, contentType string) error
+	Get(ctx context.Context, key string) ([]byte, error)
+	Delete(ctx context.Context, key string) error // deleting a missing key succeeds
+}
+
+// GCSObjectStore stores objects in a Google Cloud Storage bucket, e.g. the
+// Firebase project's default bucket
+type GCSObjectStore struct {
+	bucket *storage.BucketHandle
+}
+
+func NewGCSObjectStore(client *storage.Client, bucket string) *GCSObjectStore {
+	return &GCSObjectStore{bucket: client.Bucket(bucket)}
+}
+
+func (s *GCSObjectStore) Put(ctx context.Context, key string, data []byte, contentType string) error {
+	w := s.bucket.Object(key).NewWriter(ctx)
+	w.ContentType = contentType
+	if _, err := w.Write(data); err != nil {
+		w.Close()
+		return fmt.Errorf("failed to write object %s: %w", key, err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("failed to write object %s: %w", key, err)
+	}
+	return nil
+}
+
+func (s *GCSObjectStore) Get(ctx context.Context, key string) ([]byte, error) {
+	r, err := s.bucket.Object(key).NewReader(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read object %s: %w", key, err)
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+func (s *GCSObjectStore) Delete(ctx context.Context, key string) error {
+	err := s.bucket.Object(key).Delete(ctx)
+	if err != nil && !errors.Is(err, storage.ErrObjectNotExist) {
+		return fmt.Errorf("failed to delete object %s: %w", key, err)
+	}
+	return nil
+}
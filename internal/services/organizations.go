@@ -0,0 +1,200 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/config"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/country"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/database"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+)
+
+const (
+	// MaxEscalationContacts bounds the contacts an organization adds to every member's alerts
+	MaxEscalationContacts = 5
+	// orgInvitationTTL is how long an invitation can be accepted
+	orgInvitationTTL = 7 * 24 * time.Hour
+)
+
+// OrganizationService onboards members into organizations. An organization
+// brings default settings, applied when a member joins; escalation contacts,
+// alerted alongside each member's own contacts; and an optional scoring
+// profile override, applied by the evaluator on top of the active profile.
+type OrganizationService struct {
+	cfg       *config.Store
+	postgres  *database.PostgresDB
+	profiles  *ScoringProfileStore
+	notifier  Notifier
+	templates *MessageTemplates
+}
+
+func NewOrganizationService(
+	cfg *config.Store,
+	postgres *database.PostgresDB,
+	profiles *ScoringProfileStore,
+	notifier Notifier,
+	templates *MessageTemplates,
+) *OrganizationService {
+	return &OrganizationService{
+		cfg:       cfg,
+		postgres:  postgres,
+		profiles:  profiles,
+		notifier:  notifier,
+		templates: templates,
+	}
+}
+
+// OrgSettingsError is an organization setting that failed validation
+type OrgSettingsError struct {
+	Field string
+	Err   error
+}
+
+func (e *OrgSettingsError) Error() string {
+	return e.Field + ": " + e.Err.Error()
+}
+
+func (e *OrgSettingsError) Unwrap() error {
+	return e.Err
+}
+
+// Validate normalizes an organization's settings and rejects ones that
+// couldn't be applied: default settings that aren't UserSettings fields or
+// are out of bounds, and a scoring profile override that wouldn't validate
+// over the active profile. A JSON null clears either.
+func (s *OrganizationService) Validate(org *models.Organization) error {
+	cfg := s.cfg.Current()
+
+	if isJSONNull(org.DefaultSettings) {
+		org.DefaultSettings = json.RawMessage("{}")
+	}
+	var defaults models.UserSettings
+	dec := json.NewDecoder(bytes.NewReader(org.DefaultSettings))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&defaults); err != nil {
+		return &OrgSettingsError{"default_settings", fmt.Errorf("must be an object of user settings")}
+	}
+	lo, hi := cfg.HeartbeatIntervalMinSeconds, cfg.HeartbeatIntervalMaxSeconds
+	if v := defaults.HeartbeatInterval; v != 0 && (v < lo || v > hi) {
+		return &OrgSettingsError{"default_settings", fmt.Errorf("heartbeat_interval must be between %d and %d", lo, hi)}
+	}
+	if v := defaults.MaxHeartbeatInterval; v != 0 && (v < lo || v > hi) {
+		return &OrgSettingsError{"default_settings", fmt.Errorf("max_heartbeat_interval must be 0 or between %d and %d", lo, hi)}
+	}
+	if defaults.Timezone != "" {
+		if err := ValidateTimezone(defaults.Timezone); err != nil {
+			return &OrgSettingsError{"default_settings", fmt.Errorf("timezone %w", err)}
+		}
+	}
+	for i, zone := range defaults.SafeZones {
+		if err := ValidateGeofence(zone); err != nil {
+			return &OrgSettingsError{"default_settings", fmt.Errorf("safe_zones[%d]: %w", i, err)}
+		}
+	}
+
+	if len(org.EscalationContacts) > MaxEscalationContacts {
+		return &OrgSettingsError{"escalation_contacts", fmt.Errorf("at most %d allowed", MaxEscalationContacts)}
+	}
+
+	if isJSONNull(org.ScoringProfile) {
+		org.ScoringProfile = nil
+	} else if _, err := s.profiles.Active(cfg).WithOverride(org.ScoringProfile); err != nil {
+		return &OrgSettingsError{"scoring_profile", err}
+	}
+	return nil
+}
+
+// Invite stores an invitation for phone to join the organization, renewing
+// an open one, and texts it. The invitation is kept if the SMS fails.
+func (s *OrganizationService) Invite(ctx context.Context, org *models.Organization, phone, invitedBy string) (*models.OrgInvitation, bool, error) {
+	now := time.Now()
+	inv, err := s.postgres.CreateOrgInvitation(ctx, &models.OrgInvitation{
+		ID:        uuid.New(),
+		OrgID:     org.ID,
+		Phone:     phone,
+		InvitedBy: invitedBy,
+		CreatedAt: now,
+		ExpiresAt: now.Add(orgInvitationTTL),
+	})
+	if err != nil {
+		return nil, false, err
+	}
+
+	message := s.templates.Render(TemplateOrgInvitation, MessageData{
+		Org:  org.Name,
+		Time: FormatInUserZone(inv.ExpiresAt, s.inviteeSettings(ctx, org, phone), LayoutDateTime),
+	})
+	if err := s.notifier.SendSMS(ctx, MessageInvitation, phone, message); err != nil {
+		log.Printf("WARN: Failed to text invitation %s to join organization %s: %v", inv.ID, org.ID, err)
+		return inv, false, nil
+	}
+	return inv, true, nil
+}
+
+// inviteeSettings returns the settings of the user invited at phone, or
+// before they register the organization's defaults in their country's time
+// zone, which the invitation's expiry is given in
+func (s *OrganizationService) inviteeSettings(ctx context.Context, org *models.Organization, phone string) models.UserSettings {
+	user, err := s.postgres.GetUserByPhone(ctx, phone)
+	if err == nil && user != nil {
+		return user.Settings
+	}
+	settings := models.UserSettings{Timezone: country.Active().ForPhone(phone).Timezone}
+	_ = json.Unmarshal(org.DefaultSettings, &settings)
+	return settings
+}
+
+// Accept makes the user a member of the organization that invited them,
+// with its default settings applied over their own. It fails with
+// database.ErrInvitationNotFound or database.ErrAlreadyMember.
+func (s *OrganizationService) Accept(ctx context.Context, user *models.User, invitationID uuid.UUID) (*models.OrgInvitation, *models.Organization, error) {
+	now := time.Now()
+	open, err := s.postgres.GetOpenOrgInvitations(ctx, user.Phone, now)
+	if err != nil {
+		return nil, nil, err
+	}
+	var invitation *models.OrgInvitation
+	for i := range open {
+		if open[i].ID == invitationID {
+			invitation = &open[i]
+		}
+	}
+	if invitation == nil {
+		return nil, nil, database.ErrInvitationNotFound
+	}
+
+	org, err := s.postgres.GetOrganization(ctx, invitation.OrgID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if org == nil {
+		return nil, nil, database.ErrInvitationNotFound
+	}
+
+	settings := user.Settings
+	if err := json.Unmarshal(org.DefaultSettings, &settings); err != nil {
+		return nil, nil, fmt.Errorf("organization %s default settings: %w", org.ID, err)
+	}
+
+	// Re-checked under lock, in case the invitation changed since it was read
+	accepted, err := s.postgres.AcceptOrgInvitation(ctx, invitationID, user, settings, now)
+	if err != nil {
+		return nil, nil, err
+	}
+	accepted.OrgName = org.Name
+	user.OrgID = &org.ID
+	user.Settings = settings
+	return accepted, org, nil
+}
+
+func isJSONNull(raw json.RawMessage) bool {
+	trimmed := bytes.TrimSpace(raw)
+	return len(trimmed) == 0 || bytes.Equal(trimmed, []byte("null"))
+}
@@ -0,0 +1,124 @@
+package services
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/config"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/database"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+)
+
+// OutageDetector correlates users going silent: LastGasps and staleness
+// escalations are counted per geohash cell and network, and a cell where
+// OUTAGE_MIN_USERS unrelated users went silent within OUTAGE_WINDOW_MINUTES
+// becomes a probable outage zone. Those are nearly always a carrier outage
+// or a known dead zone, not incidents, so the evaluator gives users last
+// seen in one longer before escalating. Panics and impacts never pass
+// through it.
+type OutageDetector struct {
+	cfg   *config.Store
+	redis *database.RedisDB
+	clock Clock
+}
+
+func NewOutageDetector(cfg *config.Store, redis *database.RedisDB) *OutageDetector {
+	return &OutageDetector{cfg: cfg, redis: redis, clock: SystemClock{}}
+}
+
+// outageCell returns the geohash cell a position falls in at the configured
+// precision, and false when the position or network is unknown: silence
+// can't be tied to an outage without both
+func outageCell(cfg *config.Config, lat, lng float64, cell models.CellInfo) (string, bool) {
+	if cell.MCC == 0 || (lat == 0 && lng == 0) {
+		return "", false
+	}
+	return GeohashEncode(lat, lng, cfg.OutagePrecision), true
+}
+
+// Record counts a user who went silent at silentAt, last seen at a position
+// on a network. A failure is logged; the user is then only left out of the
+// count.
+func (d *OutageDetector) Record(ctx context.Context, userID uuid.UUID, lat, lng float64, cell models.CellInfo, silentAt time.Time) {
+	cfg := d.cfg.Current()
+	geohash, ok := outageCell(cfg, lat, lng, cell)
+	if !ok {
+		return
+	}
+	users, opened, err := d.redis.RecordOutageUser(ctx, geohash, cell.MCC, cell.MNC, userID, silentAt, d.clock.Now(),
+		time.Duration(cfg.OutageWindowMinutes)*time.Minute, time.Duration(cfg.OutageCooldownMinutes)*time.Minute, cfg.OutageMinUsers)
+	if err != nil {
+		log.Printf("WARN: Failed to count user %s towards outage detection: %v", userID, err)
+		return
+	}
+	if opened {
+		log.Printf("WARN: Probable %s outage in geohash %s: %d users went silent within %d minutes",
+			NetworkCarrier(cell.MCC, cell.MNC), geohash, users, cfg.OutageWindowMinutes)
+	}
+}
+
+// ZoneAt returns the active outage zone a position on a network falls in,
+// or nil if there is none
+func (d *OutageDetector) ZoneAt(ctx context.Context, lat, lng float64, cell models.CellInfo) (*models.OutageZone, error) {
+	geohash, ok := outageCell(d.cfg.Current(), lat, lng, cell)
+	if !ok {
+		return nil, nil
+	}
+	zone, err := d.redis.GetOutageZone(ctx, geohash, cell.MCC, cell.MNC)
+	if err != nil || zone == nil {
+		return nil, err
+	}
+	describeZone(zone)
+	return zone, nil
+}
+
+// Active returns every active outage zone, soonest to end first
+func (d *OutageDetector) Active(ctx context.Context) ([]models.OutageZone, error) {
+	zones, err := d.redis.ActiveOutageZones(ctx, d.clock.Now())
+	if err != nil {
+		return nil, err
+	}
+	for i := range zones {
+		describeZone(&zones[i])
+	}
+	return zones, nil
+}
+
+// describeZone fills in a zone's carrier and center
+func describeZone(zone *models.OutageZone) {
+	zone.Carrier = string(NetworkCarrier(zone.MCC, zone.MNC))
+	zone.Lat, zone.Lng = GeohashCenter(zone.Geohash)
+}
+
+// SuppressForOutage turns a staleness-driven AT_RISK, or a LastGasp wait
+// that went on too long, into CAUTION while the user was last seen in an
+// active outage zone. It only holds for grace past escalatesAt, when the
+// result would have escalated anyway, and no longer than the zone stays
+// active; a grace of 0 disables it. It returns when the hold ends, or zero
+// if the result wasn't changed.
+func SuppressForOutage(result *EvaluationResult, zone *models.OutageZone, escalatesAt, now time.Time, grace time.Duration) time.Time {
+	if zone == nil || grace <= 0 || result.State != StateAtRisk {
+		return time.Time{}
+	}
+	if !firedRule(result, RuleHeartbeatStale) && !firedRule(result, RuleLastGaspProlonged) {
+		return time.Time{}
+	}
+	ends := escalatesAt.Add(grace)
+	if zone.Until.Before(ends) {
+		ends = zone.Until
+	}
+	if !ends.After(now) {
+		return time.Time{}
+	}
+
+	result.State = StateCaution
+	result.setReasons(models.Reason{Code: models.ReasonProbableOutage, Params: map[string]interface{}{
+		"carrier": zone.Carrier,
+		"until":   ends.UTC().Format(time.RFC3339),
+	}})
+	result.RulesFired = append(result.RulesFired, RuleProbableOutage)
+	return ends
+}
@@ -0,0 +1,195 @@
+package services
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/config"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+)
+
+// outageMTN is a Lagos tower on MTN
+var outageMTN = models.CellInfo{MCC: 621, MNC: 30}
+
+func TestOutageCell(t *testing.T) {
+	cfg := &config.Config{OutagePrecision: 5}
+	geohash, ok := outageCell(cfg, 6.5244, 3.3792, outageMTN)
+	if !ok || len(geohash) != 5 || geohash != GeohashEncode(6.5244, 3.3792, 5) {
+		t.Errorf("outageCell = %q, %v; want the 5-character cell", geohash, ok)
+	}
+	if _, ok := outageCell(cfg, 6.5244, 3.3792, models.CellInfo{}); ok {
+		t.Error("silence on an unknown network counted")
+	}
+	if _, ok := outageCell(cfg, 0, 0, outageMTN); ok {
+		t.Error("silence at an unknown position counted")
+	}
+}
+
+func TestSuppressForOutage(t *testing.T) {
+	now := time.Date(2024, 3, 1, 2, 0, 0, 0, time.UTC)
+	escalatesAt := now.Add(-time.Minute)
+	grace := 20 * time.Minute
+	zone := &models.OutageZone{Carrier: "MTN", Until: now.Add(time.Hour)}
+
+	tests := []struct {
+		name  string
+		state string
+		rules []string
+		zone  *models.OutageZone
+		grace time.Duration
+		ends  time.Time // zero: not held
+	}{
+		{"stale heartbeat", StateAtRisk, []string{RuleHeartbeatStale}, zone, grace, escalatesAt.Add(grace)},
+		{"prolonged LastGasp", StateAtRisk, []string{RuleLastGaspProlonged}, zone, grace, escalatesAt.Add(grace)},
+		{"zone ends before the grace", StateAtRisk, []string{RuleHeartbeatStale},
+			&models.OutageZone{Carrier: "MTN", Until: now.Add(5 * time.Minute)}, grace, now.Add(5 * time.Minute)},
+		{"zone already ended", StateAtRisk, []string{RuleHeartbeatStale},
+			&models.OutageZone{Carrier: "MTN", Until: now}, grace, time.Time{}},
+		{"grace already over", StateAtRisk, []string{RuleHeartbeatStale}, zone, 30 * time.Second, time.Time{}},
+		{"no zone", StateAtRisk, []string{RuleHeartbeatStale}, nil, grace, time.Time{}},
+		{"disabled", StateAtRisk, []string{RuleHeartbeatStale}, zone, 0, time.Time{}},
+		{"only CAUTION", StateCaution, []string{RuleHeartbeatStale}, zone, grace, time.Time{}},
+		{"ALERT", StateAlert, []string{RuleHeartbeatStale}, zone, grace, time.Time{}},
+		{"AT_RISK on the score", StateAtRisk, nil, zone, grace, time.Time{}},
+		{"AT_RISK on another rule", StateAtRisk, []string{RuleNoHeartbeat}, zone, grace, time.Time{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := &EvaluationResult{State: tt.state, RulesFired: append([]string(nil), tt.rules...), Deterministic: len(tt.rules) > 0}
+			result.setReasons(models.Reason{Code: models.ReasonHeartbeatStale})
+			ends := SuppressForOutage(result, tt.zone, escalatesAt, now, tt.grace)
+			if !ends.Equal(tt.ends) {
+				t.Fatalf("hold ends %s, want %s", ends, tt.ends)
+			}
+			if ends.IsZero() {
+				if result.State != tt.state || slices.Contains(result.RulesFired, RuleProbableOutage) || result.Reasons[0].Code == models.ReasonProbableOutage {
+					t.Errorf("unheld result changed to %s %v %v", result.State, result.RulesFired, result.Reasons)
+				}
+				return
+			}
+			if result.State != StateCaution || !slices.Contains(result.RulesFired, RuleProbableOutage) {
+				t.Errorf("held result = %s %v, want CAUTION with %s", result.State, result.RulesFired, RuleProbableOutage)
+			}
+			if len(result.Reasons) != 1 || result.Reasons[0].Code != models.ReasonProbableOutage ||
+				result.Reasons[0].Params["carrier"] != "MTN" || result.Reasons[0].Params["until"] != ends.Format(time.RFC3339) {
+				t.Errorf("held reasons = %+v", result.Reasons)
+			}
+		})
+	}
+}
+
+// A cell becomes a zone once enough distinct users of one network went
+// silent there within the window, and stops being one a cooldown after the
+// last of them
+func TestOutageThresholdAndCooldown(t *testing.T) {
+	redis := testRedis(t)
+	ctx := context.Background()
+	clock := NewFakeClock(time.Now().Truncate(time.Millisecond))
+	d := NewOutageDetector(config.NewStore(&config.Config{
+		OutageMinUsers: 3, OutageWindowMinutes: 15, OutagePrecision: 5, OutageCooldownMinutes: 60,
+	}), redis)
+	d.clock = clock
+	lat, lng := 6.5244, 3.3792
+	silent := func(userID uuid.UUID, cell models.CellInfo) {
+		d.Record(ctx, userID, lat, lng, cell, clock.Now())
+	}
+	zoneAt := func() *models.OutageZone {
+		t.Helper()
+		zone, err := d.ZoneAt(ctx, lat, lng, outageMTN)
+		if err != nil {
+			t.Fatalf("ZoneAt: %v", err)
+		}
+		return zone
+	}
+
+	// Two early users are forgotten once the window has passed them
+	silent(uuid.New(), outageMTN)
+	silent(uuid.New(), outageMTN)
+	clock.Advance(16 * time.Minute)
+
+	first := uuid.New()
+	silent(first, outageMTN)
+	silent(first, outageMTN) // counted once
+	silent(uuid.New(), outageMTN)
+	silent(uuid.New(), models.CellInfo{MCC: 621, MNC: 20}) // Airtel's silence isn't MTN's outage
+	if zone := zoneAt(); zone != nil {
+		t.Fatalf("zone opened below the threshold: %+v", zone)
+	}
+
+	silent(uuid.New(), outageMTN)
+	opened := clock.Now()
+	zone := zoneAt()
+	if zone == nil {
+		t.Fatal("no zone at the threshold")
+	}
+	if zone.Users != 3 || zone.Carrier != "MTN" || !zone.Until.Equal(opened.Add(time.Hour)) {
+		t.Errorf("zone = %d users on %s until %s; want 3 on MTN until %s", zone.Users, zone.Carrier, zone.Until, opened.Add(time.Hour))
+	}
+	if other, err := d.ZoneAt(ctx, lat, lng, models.CellInfo{MCC: 621, MNC: 20}); err != nil || other != nil {
+		t.Errorf("zone on Airtel = %+v, %v; want none", other, err)
+	}
+
+	// Another user going silent later keeps it open longer
+	clock.Advance(10 * time.Minute)
+	silent(uuid.New(), outageMTN)
+	extended := clock.Now().Add(time.Hour)
+	active, err := d.Active(ctx)
+	if err != nil {
+		t.Fatalf("Active: %v", err)
+	}
+	if len(active) != 1 || active[0].Users != 4 || !active[0].Until.Equal(extended) || active[0].Carrier != "MTN" {
+		t.Errorf("active = %+v, want one MTN zone of 4 users until %s", active, extended)
+	}
+
+	clock.Set(extended.Add(-time.Second))
+	if active, err := d.Active(ctx); err != nil || len(active) != 1 {
+		t.Errorf("active just before the cooldown ends = %+v, %v", active, err)
+	}
+	clock.Set(extended)
+	if active, err := d.Active(ctx); err != nil || len(active) != 0 {
+		t.Errorf("active after the cooldown = %+v, %v; want none", active, err)
+	}
+}
+
+// A user in an outage zone who asks for help is alerted on all the same:
+// panics and duress never pass through the outage hold
+func TestOutageNeverHoldsPanics(t *testing.T) {
+	f := newPanicFixture(t, 60)
+	ctx := context.Background()
+	se := f.service.evaluator
+	inZone := func() {
+		t.Helper()
+		state := &models.UserState{
+			UserID: f.user.ID, State: StateCaution, Score: 60, LastHeartbeat: time.Now(), UpdatedAt: time.Now(),
+			Reasons: []models.Reason{{Code: models.ReasonProbableOutage}},
+			Outage:  &models.OutageZone{Carrier: "MTN", Lat: 6.5244, Lng: 3.3792, Until: time.Now().Add(time.Hour)},
+		}
+		if err := se.redis.SaveUserState(ctx, state); err != nil {
+			t.Fatalf("SaveUserState: %v", err)
+		}
+	}
+
+	inZone()
+	if err := se.TriggerPanic(ctx, f.user.ID, models.Reason{Code: models.ReasonPanic, Params: map[string]interface{}{"outcome": "sent"}}); err != nil {
+		t.Fatalf("TriggerPanic: %v", err)
+	}
+	if state, err := se.CurrentState(ctx, f.user.ID); err != nil || state == nil || state.State != StateAlert {
+		t.Errorf("state after a panic in an outage zone = %+v, %v; want ALERT", state, err)
+	}
+	if outcomes := f.effects.outcomes(); len(outcomes) != 1 {
+		t.Errorf("alerted on %v, want the panic", outcomes)
+	}
+
+	inZone()
+	if err := se.RaiseDuress(ctx, f.user.ID); err != nil {
+		t.Fatalf("RaiseDuress: %v", err)
+	}
+	alert, err := se.postgres.GetLatestAlert(ctx, f.user.ID)
+	if err != nil || alert == nil || !alert.Duress || alert.State != models.AlertStateAlert {
+		t.Errorf("latest alert = %+v, %v; want the duress ALERT", alert, err)
+	}
+}
@@ -0,0 +1,166 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/config"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/database"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+)
+
+// ErrOutboundBudget is returned for a message dropped because the outbound
+// budget is spent
+var ErrOutboundBudget = errors.New("outbound message budget exhausted")
+
+// ErrOutboundCapTooLow is returned for a raise below the configured caps
+var ErrOutboundCapTooLow = errors.New("caps can only be raised")
+
+// Outbound message types, as counted against the budget
+const (
+	MessageAlert       = "alert"        // alerts to contacts, emergency
+	MessageResolved    = "resolved"     // the user is safe again, emergency
+	MessageLastGaspAck = "lastgasp_ack" // a LastGasp was recorded, emergency
+	MessageWelfare     = "welfare"      // welfare check questions and outcomes, emergency
+	MessageCheckIn     = "check_in"     // check-in prompts to the user at CAUTION, emergency
+	MessageDigest      = "digest"       // combined updates held back by a contact's pacing, emergency
+	MessageAgency      = "agency"       // alert handoffs to emergency response agencies, emergency
+	MessageInvitation  = "invitation"   // contact and organization invitations, contact access links
+	MessageSummary     = "summary"      // daily SMS confirmations
+	MessageBroadcast   = "broadcast"    // area advisories
+	MessageGuardian    = "guardian"     // guardians' notices of tracking gaps and daily digests
+	MessageReply       = "reply"        // answers to texts sent to our numbers
+)
+
+// IsEmergencyMessage reports whether messages of kind may use the emergency
+// reserve and overdraft
+func IsEmergencyMessage(kind string) bool {
+	switch kind {
+	case MessageAlert, MessageResolved, MessageLastGaspAck, MessageWelfare, MessageCheckIn, MessageDigest, MessageAgency:
+		return true
+	}
+	return false
+}
+
+// OutboundBudget caps the SMS and WhatsApp messages sent across all
+// instances, with a token bucket refilled at the per-minute cap and a count
+// per Lagos day, so a runaway evaluator or replayed heartbeats can't drain the
+// provider balance. The last OUTBOUND_EMERGENCY_RESERVE_PCT of each cap is
+// kept for emergency messages: other messages are dropped with
+// ErrOutboundBudget once the rest is used. Emergency messages may then run
+// OUTBOUND_EMERGENCY_OVERDRAFT_PCT past each cap, and are dropped beyond it
+// too, so even a flood of alerts has a ceiling. An admin can raise the caps
+// for a while.
+//
+// The count lives in Redis; when Redis can't be reached messages are sent
+// uncounted rather than risk an alert.
+type OutboundBudget struct {
+	cfg   *config.Store
+	redis *database.RedisDB
+
+	dropped atomic.Int64
+	overCap atomic.Int64
+}
+
+func NewOutboundBudget(cfg *config.Store, redis *database.RedisDB) *OutboundBudget {
+	return &OutboundBudget{
+		cfg:   cfg,
+		redis: redis,
+	}
+}
+
+// Claim counts a message of kind about to be sent, or returns
+// ErrOutboundBudget if it must be dropped
+func (b *OutboundBudget) Claim(ctx context.Context, kind string) error {
+	if b == nil || b.redis == nil {
+		return nil
+	}
+	cfg := b.cfg.Current()
+	now := time.Now()
+	outcome, err := b.redis.ClaimOutboundMessage(ctx, kind, IsEmergencyMessage(kind), now, smsUsageDay(now),
+		cfg.OutboundMessagesPerMinute, cfg.OutboundMessagesPerDay, cfg.OutboundEmergencyReservePct, cfg.OutboundEmergencyOverdraftPct)
+	if err != nil {
+		log.Printf("WARN: Outbound budget unavailable, sending %s message uncounted: %v", kind, err)
+		return nil
+	}
+
+	switch outcome {
+	case database.OutboundDropped:
+		b.dropped.Add(1)
+		if IsEmergencyMessage(kind) {
+			log.Printf("ERROR: Outbound budget and emergency overdraft spent, %s message dropped", kind)
+		} else {
+			log.Printf("WARN: Outbound budget spent, %s message dropped", kind)
+		}
+		return ErrOutboundBudget
+	case database.OutboundOverCap:
+		b.overCap.Add(1)
+		log.Printf("WARN: Outbound budget spent, %s message sent from the emergency overdraft past the cap", kind)
+	}
+	return nil
+}
+
+// Dropped returns how many messages this instance dropped for the budget
+func (b *OutboundBudget) Dropped() int64 {
+	return b.dropped.Load()
+}
+
+// OverCap returns how many emergency messages this instance sent past the cap
+func (b *OutboundBudget) OverCap() int64 {
+	return b.overCap.Load()
+}
+
+// Today returns today's spend against the caps in effect
+func (b *OutboundBudget) Today(ctx context.Context) (*models.OutboundSpend, error) {
+	cfg := b.cfg.Current()
+	now := time.Now()
+	day := smsUsageDay(now)
+
+	minute, total, byType, err := b.redis.OutboundMessageCounts(ctx, now, day)
+	if err != nil {
+		return nil, err
+	}
+	raised, err := b.redis.GetOutboundLimitOverride(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	spend := &models.OutboundSpend{
+		Date:                  day,
+		SentThisMinute:        minute,
+		SentToday:             total,
+		PerMinute:             cfg.OutboundMessagesPerMinute,
+		PerDay:                cfg.OutboundMessagesPerDay,
+		EmergencyReservePct:   cfg.OutboundEmergencyReservePct,
+		EmergencyOverdraftPct: cfg.OutboundEmergencyOverdraftPct,
+		Raised:                raised,
+		ByType:                byType,
+	}
+	if raised != nil {
+		spend.PerMinute = max(spend.PerMinute, raised.PerMinute)
+		spend.PerDay = max(spend.PerDay, raised.PerDay)
+	}
+	return spend, nil
+}
+
+// Raise lifts the caps to perMinute and perDay for d, replacing any earlier
+// raise. Caps can only be raised above the configured ones.
+func (b *OutboundBudget) Raise(ctx context.Context, perMinute, perDay int, d time.Duration) (*models.OutboundLimitOverride, error) {
+	cfg := b.cfg.Current()
+	if perMinute < cfg.OutboundMessagesPerMinute || perDay < cfg.OutboundMessagesPerDay {
+		return nil, fmt.Errorf("%w: at least %d a minute and %d a day", ErrOutboundCapTooLow, cfg.OutboundMessagesPerMinute, cfg.OutboundMessagesPerDay)
+	}
+	if err := b.redis.SetOutboundLimitOverride(ctx, perMinute, perDay, d); err != nil {
+		return nil, err
+	}
+	return &models.OutboundLimitOverride{PerMinute: perMinute, PerDay: perDay, ExpiresAt: time.Now().Add(d)}, nil
+}
+
+// Reset ends a raise early; it reports whether there was one
+func (b *OutboundBudget) Reset(ctx context.Context) (bool, error) {
+	return b.redis.ClearOutboundLimitOverride(ctx)
+}
@@ -0,0 +1,87 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/config"
+)
+
+func TestIsEmergencyMessage(t *testing.T) {
+	for kind, want := range map[string]bool{
+		MessageAlert: true, MessageResolved: true, MessageLastGaspAck: true, MessageWelfare: true,
+		MessageCheckIn: true, MessageDigest: true, MessageAgency: true,
+		MessageInvitation: false, MessageSummary: false, MessageBroadcast: false, MessageGuardian: false,
+		MessageReply: false, "": false,
+	} {
+		if got := IsEmergencyMessage(kind); got != want {
+			t.Errorf("IsEmergencyMessage(%q) = %v, want %v", kind, got, want)
+		}
+	}
+}
+
+// A flood spends the budget: other messages stop short of the reserve,
+// alerts use the reserve and then the overdraft, and are dropped beyond it
+func TestOutboundBudgetExhaustion(t *testing.T) {
+	budget := NewOutboundBudget(config.NewStore(&config.Config{
+		OutboundMessagesPerMinute:     10,
+		OutboundMessagesPerDay:        1000,
+		OutboundEmergencyReservePct:   20,
+		OutboundEmergencyOverdraftPct: 30,
+	}), testRedis(t))
+	ctx := context.Background()
+
+	claims := func(kind string, n int) (sent int) {
+		t.Helper()
+		for i := 0; i < n; i++ {
+			err := budget.Claim(ctx, kind)
+			if err != nil && !errors.Is(err, ErrOutboundBudget) {
+				t.Fatalf("Claim(%s): %v", kind, err)
+			}
+			if err == nil {
+				sent++
+			}
+		}
+		return sent
+	}
+
+	if sent := claims(MessageBroadcast, 20); sent != 8 {
+		t.Errorf("%d broadcasts sent, want 8 before the reserve", sent)
+	}
+	if budget.Dropped() != 12 || budget.OverCap() != 0 {
+		t.Errorf("dropped %d and %d over the cap, want 12 and 0", budget.Dropped(), budget.OverCap())
+	}
+
+	// 2 from the reserve and 3 from the overdraft
+	if sent := claims(MessageAlert, 20); sent != 5 {
+		t.Errorf("%d alerts sent, want 5", sent)
+	}
+	if budget.OverCap() != 3 {
+		t.Errorf("%d alerts over the cap, want 3", budget.OverCap())
+	}
+	if budget.Dropped() != 12+15 {
+		t.Errorf("%d dropped in all, want %d", budget.Dropped(), 12+15)
+	}
+
+	// A raise lets both through again
+	if _, err := budget.Raise(ctx, 100, 1000, time.Minute); err != nil {
+		t.Fatalf("Raise: %v", err)
+	}
+	if sent := claims(MessageBroadcast, 1); sent != 1 {
+		t.Errorf("broadcast dropped after a raise")
+	}
+}
+
+// Without Redis messages go out uncounted rather than risk an alert
+func TestOutboundBudgetWithoutRedis(t *testing.T) {
+	var budget *OutboundBudget
+	if err := budget.Claim(context.Background(), MessageBroadcast); err != nil {
+		t.Errorf("Claim() without a budget = %v, want nil", err)
+	}
+	budget = NewOutboundBudget(config.NewStore(&config.Config{}), nil)
+	if err := budget.Claim(context.Background(), MessageBroadcast); err != nil {
+		t.Errorf("Claim() without Redis = %v, want nil", err)
+	}
+}
@@ -0,0 +1,318 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/config"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/database"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+)
+
+var (
+	// ErrPanicNotPending is returned when a panic was already cancelled or sent
+	ErrPanicNotPending = errors.New("panic is no longer pending")
+	// ErrPanicWrongPIN is returned for a PIN that is neither the user's PIN nor their duress PIN
+	ErrPanicWrongPIN = errors.New("wrong panic PIN")
+	// ErrPanicPINAttempts is returned once the user has tried to change their PIN too often
+	ErrPanicPINAttempts = errors.New("too many panic PIN changes")
+)
+
+const (
+	panicReleaseWorker = "panic_release"
+	panicReleaseEvery  = 2 * time.Second
+	panicReleaseBatch  = 100
+	// panicCreateAttempts bounds retries when a pending panic is settled
+	// while another press is being recorded
+	panicCreateAttempts = 3
+	// A PIN change needs the current PIN; this many attempts per window
+	// keeps it from being guessed
+	panicPINAttemptLimit  = 5
+	panicPINAttemptWindow = time.Hour
+)
+
+// ValidatePanicPIN checks that a PIN is 4 to 8 digits
+func ValidatePanicPIN(pin string) error {
+	if len(pin) < 4 || len(pin) > 8 {
+		return fmt.Errorf("must be 4 to 8 digits")
+	}
+	for _, r := range pin {
+		if r < '0' || r > '9' {
+			return fmt.Errorf("must be 4 to 8 digits")
+		}
+	}
+	return nil
+}
+
+// PanicService holds panics raised from the app for a cancellation window,
+// PANIC_CANCEL_WINDOW_SECONDS, so an accidental press can be cancelled with
+// the user's PIN before their contacts are alerted. A panic is sent when the
+// window runs out, when the user confirms it, or when a wrong PIN is
+// entered. The duress PIN looks like a cancellation to whoever is holding
+// the phone, but sends the alert flagged as duress.
+//
+// Users without a PIN can't cancel, so their panics are sent at once.
+type PanicService struct {
+	cfg       *config.Store
+	postgres  *database.PostgresDB
+	redis     *database.RedisDB
+	evaluator *SafetyEvaluator
+	health    *HealthRegistry
+
+	closeOnce sync.Once
+	stop      chan struct{}
+	done      chan struct{}
+}
+
+func NewPanicService(
+	cfg *config.Store,
+	postgres *database.PostgresDB,
+	redis *database.RedisDB,
+	evaluator *SafetyEvaluator,
+	health *HealthRegistry,
+) *PanicService {
+	return &PanicService{
+		cfg:       cfg,
+		postgres:  postgres,
+		redis:     redis,
+		evaluator: evaluator,
+		health:    health,
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+}
+
+// Trigger records a panic for the user, with the note to quote in its
+// alert, and returns it. It is held pending for the cancellation window, or
+// sent before returning if there is none. While one is pending, pressing
+// again returns it unchanged. The note must already be cleaned.
+func (s *PanicService) Trigger(ctx context.Context, userID uuid.UUID, note string) (*models.PanicRequest, error) {
+	pins, err := s.postgres.GetPanicPINs(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	window := time.Duration(s.cfg.Current().PanicCancelWindowSeconds) * time.Second
+	if pins == nil || pins.PIN == "" {
+		window = 0
+	}
+
+	for attempt := 0; attempt < panicCreateAttempts; attempt++ {
+		now := time.Now()
+		p := &models.PanicRequest{
+			ID:        uuid.New(),
+			UserID:    userID,
+			CreatedAt: now,
+			ReleaseAt: now.Add(window),
+			Note:      note,
+		}
+		created, err := s.postgres.CreatePanicRequest(ctx, p)
+		if err != nil {
+			return nil, err
+		}
+		if created == nil {
+			continue
+		}
+		if created.ID == p.ID && window == 0 {
+			return s.dispatch(ctx, created, models.PanicResolutionImmediate)
+		}
+		return created, nil
+	}
+	return nil, fmt.Errorf("failed to record panic for user %s: pending panic kept changing", userID)
+}
+
+// Cancel settles a pending panic with the PIN the user entered. The user's
+// PIN cancels it; any other PIN sends it and returns ErrPanicWrongPIN. The
+// duress PIN sends it too, but returns it as if it had been cancelled.
+func (s *PanicService) Cancel(ctx context.Context, p *models.PanicRequest, pin string) (*models.PanicRequest, error) {
+	if p.Status != models.PanicPending {
+		return nil, ErrPanicNotPending
+	}
+	pins, err := s.postgres.GetPanicPINs(ctx, p.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case pins != nil && pinMatches(pins.PIN, pin):
+		cancelled, err := s.postgres.CancelPanicRequest(ctx, p.ID, time.Now())
+		if err != nil {
+			return nil, err
+		}
+		// Already sent, or the window ran out and it is about to be
+		if cancelled == nil {
+			return nil, ErrPanicNotPending
+		}
+		log.Printf("INFO: Panic %s cancelled by user %s", p.ID, p.UserID)
+		return cancelled, nil
+
+	case pins != nil && pinMatches(pins.Duress, pin):
+		dispatched, err := s.dispatch(ctx, p, models.PanicResolutionDuress)
+		if err != nil {
+			return nil, err
+		}
+		shown := *dispatched
+		shown.Status = models.PanicCancelled
+		return &shown, nil
+
+	default:
+		if _, err := s.dispatch(ctx, p, models.PanicResolutionWrongPIN); err != nil {
+			return nil, err
+		}
+		return nil, ErrPanicWrongPIN
+	}
+}
+
+// Confirm sends a pending panic without waiting for the window to run out
+func (s *PanicService) Confirm(ctx context.Context, p *models.PanicRequest) (*models.PanicRequest, error) {
+	if p.Status != models.PanicPending {
+		return nil, ErrPanicNotPending
+	}
+	return s.dispatch(ctx, p, models.PanicResolutionConfirmed)
+}
+
+// SetPINs replaces the user's panic PIN and duress PIN; an empty duress PIN
+// clears it. Once a PIN is set, current must match it. Both PINs must
+// already be valid and different.
+func (s *PanicService) SetPINs(ctx context.Context, userID uuid.UUID, current, pin, duress string) error {
+	allowed, err := s.redis.ClaimPanicPINAttempt(ctx, userID, panicPINAttemptWindow, panicPINAttemptLimit)
+	if err != nil {
+		return fmt.Errorf("failed to check panic PIN attempts: %w", err)
+	}
+	if !allowed {
+		return ErrPanicPINAttempts
+	}
+
+	pins, err := s.postgres.GetPanicPINs(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if pins == nil {
+		return fmt.Errorf("user %s not found", userID)
+	}
+	if pins.PIN != "" && !pinMatches(pins.PIN, current) {
+		return ErrPanicWrongPIN
+	}
+
+	next := models.PanicPINs{}
+	if next.PIN, err = hashPIN(pin); err != nil {
+		return err
+	}
+	if duress != "" {
+		if next.Duress, err = hashPIN(duress); err != nil {
+			return err
+		}
+	}
+	return s.postgres.SetPanicPINs(ctx, userID, next)
+}
+
+// Start launches the release goroutine; the first pass runs immediately so
+// panics whose window ran out while the server was down are sent at startup
+func (s *PanicService) Start() {
+	s.health.Register(panicReleaseWorker, panicReleaseEvery)
+	go s.run()
+}
+
+// Close stops the release goroutine and waits for a running pass to finish.
+// Call it before closing the evaluator.
+func (s *PanicService) Close() {
+	s.closeOnce.Do(func() {
+		close(s.stop)
+	})
+	<-s.done
+}
+
+func (s *PanicService) run() {
+	defer close(s.done)
+
+	ticker := time.NewTicker(panicReleaseEvery)
+	defer ticker.Stop()
+
+	for {
+		if s.releaseLapsed() {
+			s.health.Beat(panicReleaseWorker)
+		}
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// releaseLapsed sends every panic whose window ran out uncancelled and
+// reports whether it succeeded
+func (s *PanicService) releaseLapsed() bool {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	for {
+		released, err := s.postgres.ReleaseLapsedPanicRequests(ctx, time.Now(), panicReleaseBatch)
+		if err != nil {
+			log.Printf("ERROR: Failed to release uncancelled panics: %v", err)
+			return false
+		}
+		for i := range released {
+			if err := s.raise(ctx, &released[i]); err != nil {
+				log.Printf("ERROR: Panic alert failed for user %s: %v", released[i].UserID, err)
+			}
+		}
+		if len(released) < panicReleaseBatch {
+			return true
+		}
+	}
+}
+
+// dispatch marks a pending panic sent with the resolution and raises its
+// alert. Only one caller gets to send a panic; the others get ErrPanicNotPending.
+func (s *PanicService) dispatch(ctx context.Context, p *models.PanicRequest, resolution string) (*models.PanicRequest, error) {
+	dispatched, err := s.postgres.DispatchPanicRequest(ctx, p.ID, resolution, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if dispatched == nil {
+		return nil, ErrPanicNotPending
+	}
+	if err := s.raise(ctx, dispatched); err != nil {
+		return nil, fmt.Errorf("failed to raise panic alert: %w", err)
+	}
+	return dispatched, nil
+}
+
+// raise alerts the user's contacts for a dispatched panic
+func (s *PanicService) raise(ctx context.Context, p *models.PanicRequest) error {
+	resolution := ""
+	if p.Resolution != nil {
+		resolution = *p.Resolution
+	}
+	log.Printf("INFO: Panic %s from user %s sent (%s)", p.ID, p.UserID, resolution)
+	ctx = withAlertNote(ctx, p.Note)
+
+	switch resolution {
+	case models.PanicResolutionDuress:
+		return s.evaluator.RaiseDuress(ctx, p.UserID)
+	case models.PanicResolutionWrongPIN:
+		return s.evaluator.TriggerPanic(ctx, p.UserID, PanicReason("app", "", "wrong_pin"))
+	case models.PanicResolutionExpired:
+		return s.evaluator.TriggerPanic(ctx, p.UserID, PanicReason("app", "", "not_cancelled"))
+	default:
+		return s.evaluator.TriggerPanic(ctx, p.UserID, PanicReason("app", "", ""))
+	}
+}
+
+func hashPIN(pin string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(pin), bcrypt.DefaultCost)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash PIN: %w", err)
+	}
+	return string(hash), nil
+}
+
+func pinMatches(hash, pin string) bool {
+	return hash != "" && pin != "" && bcrypt.CompareHashAndPassword([]byte(hash), []byte(pin)) == nil
+}
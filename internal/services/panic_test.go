@@ -0,0 +1,267 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/config"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+)
+
+func TestValidatePanicPIN(t *testing.T) {
+	tests := []struct {
+		pin  string
+		want bool
+	}{
+		{"1234", true},
+		{"12345678", true},
+		{"0000", true},
+		{"123", false},
+		{"123456789", false},
+		{"12a4", false},
+		{"12 34", false},
+		{"١٢٣٤", false}, // digits, but not ASCII ones
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := ValidatePanicPIN(tt.pin) == nil; got != tt.want {
+			t.Errorf("ValidatePanicPIN(%q) valid = %v, want %v", tt.pin, got, tt.want)
+		}
+	}
+}
+
+// panicEffects records the panic transitions the evaluator hands on to be
+// alerted on
+type panicEffects struct {
+	discardEffects
+	mu      sync.Mutex
+	reasons []models.Reason
+}
+
+func (e *panicEffects) HandleTransition(ctx context.Context, userID uuid.UUID, state string, score int, reasons []models.Reason, changed bool) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.reasons = append(e.reasons, reasons...)
+	return nil
+}
+
+// outcomes returns the outcome of each panic alerted on
+func (e *panicEffects) outcomes() []string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	var outcomes []string
+	for _, r := range e.reasons {
+		outcomes = append(outcomes, r.Params["outcome"].(string))
+	}
+	return outcomes
+}
+
+type panicFixture struct {
+	service *PanicService
+	effects *panicEffects
+	user    *models.User
+}
+
+// newPanicFixture sets up a user with PIN 1234 and duress PIN 9999, and a
+// panic service holding panics for the window
+func newPanicFixture(t *testing.T, window int) *panicFixture {
+	t.Helper()
+	postgres := testPostgres(t)
+	redis := testRedis(t)
+	store := config.NewStore(&config.Config{PanicCancelWindowSeconds: window})
+	effects := &panicEffects{}
+	evaluator := &SafetyEvaluator{
+		cfg:      store,
+		postgres: postgres,
+		redis:    redis,
+		outbox:   NewAlertOutbox(nil, nil, 1, nil), // never started: deliveries wait in the queue
+		clock:    SystemClock{},
+		effects:  effects,
+	}
+	f := &panicFixture{
+		service: NewPanicService(store, postgres, redis, evaluator, nil),
+		effects: effects,
+		user:    createTestUser(t, postgres, "Ada"),
+	}
+	if err := f.service.SetPINs(context.Background(), f.user.ID, "", "1234", "9999"); err != nil {
+		t.Fatalf("SetPINs: %v", err)
+	}
+	return f
+}
+
+func (f *panicFixture) trigger(t *testing.T) *models.PanicRequest {
+	t.Helper()
+	p, err := f.service.Trigger(context.Background(), f.user.ID, "")
+	if err != nil {
+		t.Fatalf("Trigger: %v", err)
+	}
+	if p.Status != models.PanicPending {
+		t.Fatalf("triggered panic %s, want pending", p.Status)
+	}
+	return p
+}
+
+// stored reads the panic back, resolution included
+func (f *panicFixture) stored(t *testing.T, id uuid.UUID) *models.PanicRequest {
+	t.Helper()
+	p, err := f.service.postgres.GetPanicRequest(context.Background(), id)
+	if err != nil || p == nil {
+		t.Fatalf("GetPanicRequest = %v, %v", p, err)
+	}
+	return p
+}
+
+func resolutionOf(p *models.PanicRequest) string {
+	if p.Resolution == nil {
+		return ""
+	}
+	return *p.Resolution
+}
+
+// The user's PIN cancels inside the window and nobody is alerted; once it
+// is settled it can't be cancelled or sent again
+func TestPanicCancel(t *testing.T) {
+	f := newPanicFixture(t, 60)
+	ctx := context.Background()
+	p := f.trigger(t)
+
+	// Pressing again while pending is the same panic
+	if again := f.trigger(t); again.ID != p.ID {
+		t.Errorf("second press = panic %s, want %s", again.ID, p.ID)
+	}
+
+	cancelled, err := f.service.Cancel(ctx, p, "1234")
+	if err != nil || cancelled.Status != models.PanicCancelled {
+		t.Fatalf("Cancel = %+v, %v; want cancelled", cancelled, err)
+	}
+	if got := resolutionOf(f.stored(t, p.ID)); got != models.PanicResolutionCancelled {
+		t.Errorf("resolution = %q, want cancelled", got)
+	}
+	if _, err := f.service.Cancel(ctx, cancelled, "1234"); !errors.Is(err, ErrPanicNotPending) {
+		t.Errorf("second Cancel = %v, want ErrPanicNotPending", err)
+	}
+	if _, err := f.service.Confirm(ctx, cancelled); !errors.Is(err, ErrPanicNotPending) {
+		t.Errorf("Confirm after cancelling = %v, want ErrPanicNotPending", err)
+	}
+	// Nor sent by a stale copy still marked pending
+	if _, err := f.service.Cancel(ctx, p, "9999"); !errors.Is(err, ErrPanicNotPending) {
+		t.Errorf("duress PIN on a cancelled panic = %v, want ErrPanicNotPending", err)
+	}
+	if outcomes := f.effects.outcomes(); len(outcomes) != 0 {
+		t.Errorf("cancelled panic alerted on: %v", outcomes)
+	}
+}
+
+// A panic left uncancelled is sent once its window runs out, and can't be
+// cancelled after
+func TestPanicExpiry(t *testing.T) {
+	f := newPanicFixture(t, 1)
+	ctx := context.Background()
+	p := f.trigger(t)
+
+	time.Sleep(1100 * time.Millisecond)
+	// Too late, even before the release pass has run
+	if _, err := f.service.Cancel(ctx, p, "1234"); !errors.Is(err, ErrPanicNotPending) {
+		t.Errorf("Cancel after the window = %v, want ErrPanicNotPending", err)
+	}
+	if !f.service.releaseLapsed() {
+		t.Fatal("releaseLapsed failed")
+	}
+	stored := f.stored(t, p.ID)
+	if stored.Status != models.PanicDispatched || resolutionOf(stored) != models.PanicResolutionExpired {
+		t.Errorf("lapsed panic %s (%s), want dispatched (expired)", stored.Status, resolutionOf(stored))
+	}
+	if outcomes := f.effects.outcomes(); len(outcomes) != 1 || outcomes[0] != "not_cancelled" {
+		t.Errorf("alerted with outcomes %v, want [not_cancelled]", outcomes)
+	}
+
+	// Released once, however often the pass runs
+	f.service.releaseLapsed()
+	if outcomes := f.effects.outcomes(); len(outcomes) != 1 {
+		t.Errorf("lapsed panic alerted on %d times", len(outcomes))
+	}
+}
+
+// A wrong PIN sends the panic at once: whoever is guessing isn't the user
+func TestPanicWrongPIN(t *testing.T) {
+	f := newPanicFixture(t, 60)
+	ctx := context.Background()
+	p := f.trigger(t)
+
+	for _, pin := range []string{"4321", ""} {
+		_, err := f.service.Cancel(ctx, p, pin)
+		if pin == "4321" && !errors.Is(err, ErrPanicWrongPIN) {
+			t.Fatalf("Cancel with a wrong PIN = %v, want ErrPanicWrongPIN", err)
+		}
+		// Already sent by the first wrong PIN
+		if pin == "" && !errors.Is(err, ErrPanicNotPending) {
+			t.Errorf("Cancel after a wrong PIN = %v, want ErrPanicNotPending", err)
+		}
+	}
+	stored := f.stored(t, p.ID)
+	if stored.Status != models.PanicDispatched || resolutionOf(stored) != models.PanicResolutionWrongPIN {
+		t.Errorf("panic %s (%s), want dispatched (wrong_pin)", stored.Status, resolutionOf(stored))
+	}
+	if outcomes := f.effects.outcomes(); len(outcomes) != 1 || outcomes[0] != "wrong_pin" {
+		t.Errorf("alerted with outcomes %v, want [wrong_pin]", outcomes)
+	}
+	// The right PIN is too late now
+	if _, err := f.service.Cancel(ctx, p, "1234"); !errors.Is(err, ErrPanicNotPending) {
+		t.Errorf("Cancel with the PIN after a wrong one = %v, want ErrPanicNotPending", err)
+	}
+}
+
+// The duress PIN looks like a cancellation to whoever holds the phone, but
+// raises an alert flagged as duress
+func TestPanicDuress(t *testing.T) {
+	f := newPanicFixture(t, 60)
+	ctx := context.Background()
+	p := f.trigger(t)
+
+	shown, err := f.service.Cancel(ctx, p, "9999")
+	if err != nil {
+		t.Fatalf("Cancel with the duress PIN: %v", err)
+	}
+	if shown.Status != models.PanicCancelled {
+		t.Errorf("duress cancellation shown as %s, want cancelled", shown.Status)
+	}
+
+	stored := f.stored(t, p.ID)
+	if stored.Status != models.PanicDispatched || resolutionOf(stored) != models.PanicResolutionDuress {
+		t.Errorf("panic %s (%s), want dispatched (duress)", stored.Status, resolutionOf(stored))
+	}
+	alert, err := f.service.postgres.GetLatestAlert(ctx, f.user.ID)
+	if err != nil || alert == nil {
+		t.Fatalf("GetLatestAlert = %v, %v; want the duress alert", alert, err)
+	}
+	if !alert.Duress || alert.State != models.AlertStateAlert || len(alert.Reasons) != 1 || alert.Reasons[0].Code != models.ReasonDuress {
+		t.Errorf("alert = duress %v, %s %v; want a duress ALERT", alert.Duress, alert.State, alert.Reasons)
+	}
+	state, err := f.service.evaluator.CurrentState(ctx, f.user.ID)
+	if err != nil || state == nil || state.State != StateAlert {
+		t.Errorf("state after duress = %+v, %v; want ALERT", state, err)
+	}
+}
+
+// Changing the PINs needs the current one
+func TestSetPanicPINs(t *testing.T) {
+	f := newPanicFixture(t, 60)
+	ctx := context.Background()
+
+	if err := f.service.SetPINs(ctx, f.user.ID, "9999", "5678", ""); !errors.Is(err, ErrPanicWrongPIN) {
+		t.Errorf("SetPINs with the duress PIN as current = %v, want ErrPanicWrongPIN", err)
+	}
+	if err := f.service.SetPINs(ctx, f.user.ID, "1234", "5678", ""); err != nil {
+		t.Fatalf("SetPINs: %v", err)
+	}
+	// The duress PIN was cleared, so it is just a wrong PIN now
+	p := f.trigger(t)
+	if _, err := f.service.Cancel(ctx, p, "9999"); !errors.Is(err, ErrPanicWrongPIN) {
+		t.Errorf("Cancel with the cleared duress PIN = %v, want ErrPanicWrongPIN", err)
+	}
+}
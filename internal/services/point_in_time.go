@@ -0,0 +1,233 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/config"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/database"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/flags"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+	"github.com/google/uuid"
+)
+
+// lateHeartbeatLookback is how far before the evaluated time heartbeats
+// that only arrived after it are counted
+const lateHeartbeatLookback = 24 * time.Hour
+
+// scoreCompareWindow is how close before the evaluated time the recorded
+// evaluation must be for its score to be compared; recency points change
+// as time passes
+const scoreCompareWindow = time.Minute
+
+// Where the profile of a point-in-time evaluation came from
+const (
+	ProfileSourceNamed  = "named"  // asked for by ID
+	ProfileSourceStored = "stored" // the stored profile active at the time
+	ProfileSourceConfig = "config" // none was stored yet; derived from the current config
+)
+
+// ErrScoringProfileNotFound is returned for a named profile that doesn't
+// exist or no longer decodes
+var ErrScoringProfileNotFound = errors.New("scoring profile not found")
+
+// PointInTimeInputs is what the evaluator saw of a user at a past time
+type PointInTimeInputs struct {
+	Heartbeat      *models.Heartbeat       `json:"heartbeat"`
+	LateHeartbeats int                     `json:"late_heartbeats"` // stamped in the 24 hours before, received after
+	LastGasp       *models.LastGasp        `json:"last_gasp"`
+	Pause          *models.ProtectionPause `json:"protection_pause"`
+	Watch          *models.WatchSession    `json:"watch_session"`
+	ProfileSource  string                  `json:"profile_source"`
+	ProfileID      *uuid.UUID              `json:"profile_id,omitempty"`
+	OrgOverride    bool                    `json:"org_override"` // the organization's overrides were applied
+	Profile        ScoringProfile          `json:"profile"`
+	Flags          map[string]bool         `json:"flags"`
+}
+
+// PointInTimeRecord is what live evaluation recorded about the user by then
+type PointInTimeRecord struct {
+	State *models.StateTransition `json:"state"` // the transition in effect
+	Score *models.ScoreRecord     `json:"score"` // the latest evaluation
+}
+
+// PointInTimeEvaluation is a user's evaluation replayed at a past time next
+// to what was recorded then
+type PointInTimeEvaluation struct {
+	UserID           uuid.UUID         `json:"user_id"`
+	At               time.Time         `json:"at"`
+	Inputs           PointInTimeInputs `json:"inputs"`
+	Decision         *EvaluationResult `json:"decision"`
+	Recorded         PointInTimeRecord `json:"recorded"`
+	Diverged         bool              `json:"diverged"`
+	Divergences      []string          `json:"divergences,omitempty"`
+	NotReconstructed []string          `json:"not_reconstructed"` // inputs taken as they are now, or left out
+}
+
+// PointInTimeEvaluator answers what the evaluator would have decided about
+// a user at a past time with the data it had then. Inputs are read as they
+// stood at that time, a heartbeat counting only once it had arrived, and
+// assessed by a sandboxed SafetyEvaluator: nothing is written and nobody is
+// notified.
+type PointInTimeEvaluator struct {
+	cfg      *config.Store
+	postgres *database.PostgresDB
+}
+
+func NewPointInTimeEvaluator(cfg *config.Store, postgres *database.PostgresDB) *PointInTimeEvaluator {
+	return &PointInTimeEvaluator{
+		cfg:      cfg,
+		postgres: postgres,
+	}
+}
+
+// EvaluateAt evaluates the user as of at, with the stored profile named by
+// profileID if it isn't nil, else with the profile that was active then
+func (p *PointInTimeEvaluator) EvaluateAt(ctx context.Context, userID uuid.UUID, at time.Time, profileID *uuid.UUID) (*PointInTimeEvaluation, error) {
+	cfg := p.cfg.Current()
+	eval := &PointInTimeEvaluation{
+		UserID: userID,
+		At:     at,
+		NotReconstructed: []string{
+			"config, feature flags and organization overrides are the current ones",
+			"app activity and outage holds are not applied",
+			"the heartbeat interval advised to the client is not applied",
+		},
+	}
+
+	profile, err := p.profileAt(ctx, userID, at, profileID, cfg, eval)
+	if err != nil {
+		return nil, err
+	}
+
+	if eval.Inputs.Pause, err = p.postgres.GetProtectionPauseAt(ctx, userID, at); err != nil {
+		return nil, fmt.Errorf("failed to read protection pause: %w", err)
+	}
+	if eval.Inputs.Watch, err = p.postgres.GetWatchSessionAt(ctx, userID, at); err != nil {
+		return nil, fmt.Errorf("failed to read watch session: %w", err)
+	}
+	if eval.Inputs.LastGasp, err = p.postgres.GetLastGaspAsOf(ctx, userID, at); err != nil {
+		return nil, fmt.Errorf("failed to read lastgasp: %w", err)
+	}
+	if lg := eval.Inputs.LastGasp; lg != nil && lg.LastAt.After(at) {
+		// Re-armed since, in place: only its first arrival is known to
+		// have been seen by then
+		lg.LastAt = lg.CreatedAt
+		lg.ExpiryTs = lg.CreatedAt.Add(time.Duration(cfg.LastGaspTimeoutSeconds) * time.Second)
+		lg.Repeats = 0
+		eval.NotReconstructed = append(eval.NotReconstructed,
+			"the LastGasp was re-armed later; it is taken as first armed, with that location")
+	}
+	if eval.Inputs.LastGasp == nil {
+		if eval.Inputs.Heartbeat, err = p.postgres.GetLatestHeartbeatAsOf(ctx, userID, at); err != nil {
+			return nil, fmt.Errorf("failed to read heartbeat: %w", err)
+		}
+		if hb := eval.Inputs.Heartbeat; hb != nil && hb.Coarse {
+			eval.NotReconstructed = append(eval.NotReconstructed,
+				"the heartbeat is coarse; it is judged on its coarse location")
+		}
+	}
+	if eval.Inputs.LateHeartbeats, err = p.postgres.CountLateHeartbeats(ctx, userID, at.Add(-lateHeartbeatLookback), at); err != nil {
+		return nil, fmt.Errorf("failed to count late heartbeats: %w", err)
+	}
+
+	if eval.Inputs.Watch != nil {
+		profile = profile.Watched()
+	}
+	eval.Inputs.Profile = profile
+	fl := flags.For(ctx, userID)
+	eval.Inputs.Flags = fl.Map()
+
+	if pause := eval.Inputs.Pause; pause != nil {
+		eval.Decision = &EvaluationResult{
+			State:         StatePaused,
+			RulesFired:    []string{RuleProtectionPaused},
+			Deterministic: true,
+		}
+		eval.Decision.setReasons(models.Reason{Code: models.ReasonProtectionPaused, Params: map[string]interface{}{"until": pause.PausedUntil.UTC().Format(time.RFC3339)}})
+	} else {
+		eval.Decision = NewSandboxEvaluator(cfg, NewFakeClock(at)).Assess(eval.Inputs.Heartbeat, eval.Inputs.LastGasp, profile)
+		if !eval.Decision.Deterministic && profile.TrendWindow > 0 && fl.Enabled(flags.ScoreTrend) {
+			history, err := p.postgres.GetRecentScoresBefore(ctx, userID, at, profile.TrendWindow-1)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read score history: %w", err)
+			}
+			ApplyTrend(eval.Decision, history, profile)
+		}
+	}
+
+	if eval.Recorded.State, err = p.postgres.GetUserStateAt(ctx, userID, at); err != nil {
+		return nil, fmt.Errorf("failed to read state history: %w", err)
+	}
+	scores, err := p.postgres.GetScoreHistory(ctx, userID, time.Time{}, at, 1)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read score history: %w", err)
+	}
+	if len(scores) > 0 {
+		eval.Recorded.Score = &scores[0]
+	}
+	eval.compare()
+	return eval, nil
+}
+
+// profileAt resolves the profile to evaluate with and records where it came from
+func (p *PointInTimeEvaluator) profileAt(ctx context.Context, userID uuid.UUID, at time.Time, profileID *uuid.UUID, cfg *config.Config, eval *PointInTimeEvaluation) (ScoringProfile, error) {
+	if profileID != nil {
+		stored, err := p.postgres.GetScoringProfile(ctx, *profileID)
+		if err != nil {
+			return ScoringProfile{}, fmt.Errorf("failed to read scoring profile: %w", err)
+		}
+		loaded := decodeStoredProfile(stored)
+		if loaded == nil {
+			return ScoringProfile{}, ErrScoringProfileNotFound
+		}
+		eval.Inputs.ProfileSource, eval.Inputs.ProfileID = ProfileSourceNamed, &stored.ID
+		return loaded.profile, nil
+	}
+
+	stored, err := p.postgres.GetScoringProfileAt(ctx, at)
+	if err != nil {
+		return ScoringProfile{}, fmt.Errorf("failed to read scoring profile: %w", err)
+	}
+	profile := DefaultScoringProfile(cfg)
+	eval.Inputs.ProfileSource = ProfileSourceConfig
+	if loaded := decodeStoredProfile(stored); loaded != nil {
+		profile = loaded.profile
+		eval.Inputs.ProfileSource, eval.Inputs.ProfileID = ProfileSourceStored, &stored.ID
+	}
+
+	// As in live evaluation, an override that no longer validates is left out
+	orgID, override, err := p.postgres.GetUserOrgScoringProfile(ctx, userID)
+	if err != nil {
+		return ScoringProfile{}, fmt.Errorf("failed to read organization scoring profile: %w", err)
+	}
+	if override == nil {
+		return profile, nil
+	}
+	resolved, err := profile.WithOverride(override)
+	if err != nil {
+		log.Printf("WARN: Scoring profile of organization %s is invalid, left out of point-in-time evaluation: %v", orgID, err)
+		return profile, nil
+	}
+	eval.Inputs.OrgOverride = true
+	return resolved, nil
+}
+
+// compare notes where the decision differs from what was recorded: the
+// state from the state in effect, the score from an evaluation recorded
+// just before
+func (e *PointInTimeEvaluation) compare() {
+	if e.Recorded.State != nil && e.Recorded.State.ToState != e.Decision.State {
+		e.Divergences = append(e.Divergences,
+			fmt.Sprintf("state: decided %s, recorded %s", e.Decision.State, e.Recorded.State.ToState))
+	}
+	if rec := e.Recorded.Score; rec != nil && !e.Decision.Deterministic && e.At.Sub(rec.EvaluatedAt) <= scoreCompareWindow && rec.Score != e.Decision.Score {
+		e.Divergences = append(e.Divergences,
+			fmt.Sprintf("score: decided %d, recorded %d at %s", e.Decision.Score, rec.Score,
+				rec.EvaluatedAt.UTC().Format(time.RFC3339)))
+	}
+	e.Diverged = len(e.Divergences) > 0
+}
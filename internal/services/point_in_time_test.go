@@ -0,0 +1,176 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/config"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/database"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+)
+
+// Fixtures in testdata/incidents are incidents as captured from production:
+// each heartbeat with when it was stamped and when it was received
+// (created_at), the transitions live evaluation recorded, and what a replay
+// at each check time must have seen
+type incidentFixture struct {
+	Description string                   `json:"description"`
+	Heartbeats  []models.Heartbeat       `json:"heartbeats"`
+	States      []models.StateTransition `json:"states"`
+	Checks      []struct {
+		At             time.Time `json:"at"`
+		Heartbeat      time.Time `json:"heartbeat"` // the latest heartbeat's timestamp
+		LateHeartbeats int       `json:"late_heartbeats"`
+		RecordedState  string    `json:"recorded_state"`
+	} `json:"checks"`
+}
+
+// loadIncident stores the fixture's heartbeats and transitions for a new user
+func loadIncident(t *testing.T, postgres *database.PostgresDB, name string) (*incidentFixture, uuid.UUID) {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata/incidents", name))
+	if err != nil {
+		t.Fatalf("read fixture: %v", err)
+	}
+	var incident incidentFixture
+	if err := json.Unmarshal(data, &incident); err != nil {
+		t.Fatalf("decode fixture: %v", err)
+	}
+
+	ctx := context.Background()
+	user := createTestUser(t, postgres, "Ada")
+	for i := range incident.Heartbeats {
+		hb := &incident.Heartbeats[i]
+		hb.ID, hb.UserID = uuid.New(), user.ID
+		if err := postgres.CreateHeartbeat(ctx, hb); err != nil {
+			t.Fatalf("CreateHeartbeat: %v", err)
+		}
+	}
+	for i := range incident.States {
+		transition := &incident.States[i]
+		transition.UserID = user.ID
+		if _, err := postgres.RecordStateTransition(ctx, transition); err != nil {
+			t.Fatalf("RecordStateTransition: %v", err)
+		}
+	}
+	return &incident, user.ID
+}
+
+// A replay sees a heartbeat only once it was both stamped and received, so
+// heartbeats queued offline are left out until they arrived, and counted as
+// late; the decision is the evaluator's on what it saw, next to the state
+// recorded then
+func TestEvaluateAtIncidentReplay(t *testing.T) {
+	postgres := testPostgres(t)
+	incident, userID := loadIncident(t, postgres, "offline_walk_home.json")
+	ctx := context.Background()
+	cfg := simulationConfig()
+	p := NewPointInTimeEvaluator(config.NewStore(cfg), postgres)
+
+	transitions, err := postgres.GetStateTransitions(ctx, userID, time.Time{})
+	if err != nil {
+		t.Fatalf("GetStateTransitions: %v", err)
+	}
+
+	for _, check := range incident.Checks {
+		eval, err := p.EvaluateAt(ctx, userID, check.At, nil)
+		if err != nil {
+			t.Fatalf("EvaluateAt(%s): %v", check.At.Format(time.TimeOnly), err)
+		}
+		when := check.At.Format(time.TimeOnly)
+
+		hb := eval.Inputs.Heartbeat
+		if hb == nil || !hb.Timestamp.Equal(check.Heartbeat) {
+			t.Errorf("%s: heartbeat %+v, want the one stamped %s", when, hb, check.Heartbeat.Format(time.TimeOnly))
+			continue
+		}
+		if hb.CreatedAt.After(check.At) {
+			t.Errorf("%s: heartbeat received at %s, after the replayed time", when, hb.CreatedAt.Format(time.TimeOnly))
+		}
+		if eval.Inputs.LateHeartbeats != check.LateHeartbeats {
+			t.Errorf("%s: %d late heartbeats, want %d", when, eval.Inputs.LateHeartbeats, check.LateHeartbeats)
+		}
+		if eval.Inputs.LastGasp != nil || eval.Inputs.Pause != nil || eval.Inputs.Watch != nil {
+			t.Errorf("%s: inputs %+v, want a heartbeat only", when, eval.Inputs)
+		}
+
+		// The decision is the evaluator's at that time, on that heartbeat
+		want := NewSandboxEvaluator(cfg, NewFakeClock(check.At)).Assess(hb, nil, eval.Inputs.Profile)
+		if eval.Decision.State != want.State || eval.Decision.Score != want.Score {
+			t.Errorf("%s: decided %s %d, want %s %d", when, eval.Decision.State, eval.Decision.Score, want.State, want.Score)
+		}
+
+		if eval.Recorded.State == nil || eval.Recorded.State.ToState != check.RecordedState {
+			t.Errorf("%s: recorded %+v, want %s", when, eval.Recorded.State, check.RecordedState)
+			continue
+		}
+		if diverged := eval.Decision.State != check.RecordedState; eval.Diverged != diverged {
+			t.Errorf("%s: diverged %t (%q) deciding %s against %s", when, eval.Diverged, eval.Divergences, eval.Decision.State, check.RecordedState)
+		}
+	}
+
+	// Before the first heartbeat arrived there was nothing to judge
+	first := incident.Heartbeats[0]
+	eval, err := p.EvaluateAt(ctx, userID, first.CreatedAt.Add(-time.Second), nil)
+	if err != nil {
+		t.Fatalf("EvaluateAt: %v", err)
+	}
+	if eval.Inputs.Heartbeat != nil || eval.Recorded.State != nil || eval.Decision.State != StateSafe {
+		t.Errorf("before the first arrival: heartbeat %+v, recorded %+v, decided %s", eval.Inputs.Heartbeat, eval.Recorded.State, eval.Decision.State)
+	}
+
+	// A replay writes nothing
+	after, err := postgres.GetStateTransitions(ctx, userID, time.Time{})
+	if err != nil {
+		t.Fatalf("GetStateTransitions: %v", err)
+	}
+	if len(after) != len(transitions) {
+		t.Errorf("%d transitions after replaying, want %d", len(after), len(transitions))
+	}
+	scores, err := postgres.GetScoreHistory(ctx, userID, time.Time{}, time.Now(), 10)
+	if err != nil || len(scores) != 0 {
+		t.Errorf("score history after replaying = %d records, %v; want none", len(scores), err)
+	}
+
+	unknown := uuid.New()
+	if _, err := p.EvaluateAt(ctx, userID, incident.Checks[0].At, &unknown); !errors.Is(err, ErrScoringProfileNotFound) {
+		t.Errorf("EvaluateAt with an unknown profile = %v, want ErrScoringProfileNotFound", err)
+	}
+}
+
+func TestPointInTimeCompare(t *testing.T) {
+	at := time.Date(2026, 3, 10, 20, 47, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		decision EvaluationResult
+		state    string
+		score    *models.ScoreRecord
+		want     int
+	}{
+		{"agreed", EvaluationResult{State: StateCaution, Score: 61}, StateCaution, &models.ScoreRecord{Score: 61, EvaluatedAt: at.Add(-30 * time.Second)}, 0},
+		{"state", EvaluationResult{State: StateAtRisk, Score: 61}, StateCaution, nil, 1},
+		{"score just before", EvaluationResult{State: StateCaution, Score: 55}, StateCaution, &models.ScoreRecord{Score: 61, EvaluatedAt: at.Add(-time.Minute)}, 1},
+		{"score long before", EvaluationResult{State: StateCaution, Score: 55}, StateCaution, &models.ScoreRecord{Score: 61, EvaluatedAt: at.Add(-10 * time.Minute)}, 0},
+		{"deterministic score", EvaluationResult{State: StateCaution, Score: 50, Deterministic: true}, StateCaution, &models.ScoreRecord{Score: 61, EvaluatedAt: at}, 0},
+		{"both", EvaluationResult{State: StateAtRisk, Score: 30}, StateSafe, &models.ScoreRecord{Score: 90, EvaluatedAt: at}, 2},
+		{"nothing recorded", EvaluationResult{State: StateAtRisk, Score: 30}, "", nil, 0},
+	}
+	for _, tt := range tests {
+		eval := &PointInTimeEvaluation{At: at, Decision: &tt.decision}
+		if tt.state != "" {
+			eval.Recorded.State = &models.StateTransition{ToState: tt.state}
+		}
+		eval.Recorded.Score = tt.score
+		eval.compare()
+		if len(eval.Divergences) != tt.want || eval.Diverged != (tt.want > 0) {
+			t.Errorf("%s: diverged %t with %q, want %d divergences", tt.name, eval.Diverged, eval.Divergences, tt.want)
+		}
+	}
+}
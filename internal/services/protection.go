@@ -0,0 +1,197 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/database"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+)
+
+// ErrAlertOpen is returned when pausing would hide an unresolved alert
+var ErrAlertOpen = errors.New("user has an unresolved alert")
+
+const (
+	protectionResumerWorker = "protection_resumer"
+	protectionResumeEvery   = time.Minute
+	protectionResumeBatch   = 100
+)
+
+// ProtectionService pauses and resumes a user's monitoring. While a pause is
+// open the evaluator reports PAUSED instead of scoring; a worker resumes
+// lapsed pauses and tells the user their protection is back on.
+type ProtectionService struct {
+	postgres  *database.PostgresDB
+	evaluator *SafetyEvaluator
+	notifier  Notifier
+	audit     *AuditLogger
+	health    *HealthRegistry
+
+	closeOnce sync.Once
+	stop      chan struct{}
+	done      chan struct{}
+}
+
+func NewProtectionService(
+	postgres *database.PostgresDB,
+	evaluator *SafetyEvaluator,
+	notifier Notifier,
+	audit *AuditLogger,
+	health *HealthRegistry,
+) *ProtectionService {
+	return &ProtectionService{
+		postgres:  postgres,
+		evaluator: evaluator,
+		notifier:  notifier,
+		audit:     audit,
+		health:    health,
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+}
+
+// Pause opens a pause until now+d, or moves the end of the open one. It
+// fails with ErrAlertOpen while the user has an unresolved alert and with
+// ErrWatchActive while they are being watched.
+func (s *ProtectionService) Pause(ctx context.Context, userID uuid.UUID, d time.Duration, reason string) (*models.ProtectionPause, error) {
+	latest, err := s.postgres.GetLatestAlert(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if latest != nil && latest.ResolvedAt == nil {
+		return nil, ErrAlertOpen
+	}
+
+	now := time.Now()
+	if watch, err := s.postgres.GetActiveWatchSession(ctx, userID, now); err != nil {
+		return nil, err
+	} else if watch != nil {
+		return nil, ErrWatchActive
+	}
+
+	// A lapsed pause the worker hasn't closed yet would otherwise be extended
+	if active, err := s.postgres.GetActiveProtectionPause(ctx, userID, now); err != nil {
+		return nil, err
+	} else if active == nil {
+		if _, err := s.postgres.ResumeProtectionPause(ctx, userID, models.PauseResumedByExpiry); err != nil {
+			return nil, err
+		}
+	}
+
+	pause, err := s.postgres.UpsertProtectionPause(ctx, &models.ProtectionPause{
+		ID:          uuid.New(),
+		UserID:      userID,
+		Reason:      reason,
+		PausedAt:    now,
+		PausedUntil: now.Add(d),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Records PAUSED right away so the status endpoint reflects it
+	if _, err := s.evaluator.EvaluateUserSafety(ctx, userID); err != nil {
+		log.Printf("WARN: Failed to record paused state for user %s: %v", userID, err)
+	}
+	return pause, nil
+}
+
+// Resume closes the user's open pause and re-evaluates them. It returns the
+// closed pause, or nil if they were not paused.
+func (s *ProtectionService) Resume(ctx context.Context, userID uuid.UUID) (*models.ProtectionPause, error) {
+	pause, err := s.postgres.ResumeProtectionPause(ctx, userID, models.PauseResumedByUser)
+	if err != nil || pause == nil {
+		return nil, err
+	}
+	s.evaluator.EvaluateAsync(userID)
+	return pause, nil
+}
+
+// Active returns the user's open pause, or nil
+func (s *ProtectionService) Active(ctx context.Context, userID uuid.UUID) (*models.ProtectionPause, error) {
+	return s.postgres.GetActiveProtectionPause(ctx, userID, time.Now())
+}
+
+// Start launches the auto-resume goroutine; the first pass runs immediately
+// so pauses that lapsed while the server was down are resumed at startup
+func (s *ProtectionService) Start() {
+	s.health.Register(protectionResumerWorker, protectionResumeEvery)
+	go s.run()
+}
+
+// Close stops the auto-resume goroutine and waits for a running pass to finish
+func (s *ProtectionService) Close() {
+	s.closeOnce.Do(func() {
+		close(s.stop)
+	})
+	<-s.done
+}
+
+func (s *ProtectionService) run() {
+	defer close(s.done)
+
+	ticker := time.NewTicker(protectionResumeEvery)
+	defer ticker.Stop()
+
+	for {
+		if s.resumeLapsed() {
+			s.health.Beat(protectionResumerWorker)
+		}
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// resumeLapsed resumes every pause that has run out and reports whether it succeeded
+func (s *ProtectionService) resumeLapsed() bool {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	for {
+		pauses, err := s.postgres.ResumeLapsedProtectionPauses(ctx, time.Now(), protectionResumeBatch)
+		if err != nil {
+			log.Printf("ERROR: Failed to resume lapsed protection pauses: %v", err)
+			return false
+		}
+		for i := range pauses {
+			s.resumed(ctx, &pauses[i])
+		}
+		if len(pauses) < protectionResumeBatch {
+			return true
+		}
+	}
+}
+
+// resumed audits an expired pause, re-evaluates the user and tells them
+func (s *ProtectionService) resumed(ctx context.Context, pause *models.ProtectionPause) {
+	log.Printf("INFO: Protection pause for user %s lapsed, monitoring resumed", pause.UserID)
+
+	userID := pause.UserID
+	s.audit.Record(&models.AuditEvent{
+		ActorRole:     "system",
+		Action:        AuditProtectionResume,
+		ObjectType:    "protection_pause",
+		ObjectID:      pause.ID.String(),
+		SubjectUserID: &userID,
+		Metadata:      map[string]interface{}{"resumed_by": models.PauseResumedByExpiry},
+	})
+	s.evaluator.EvaluateAsync(userID)
+
+	token, err := s.postgres.GetPushToken(ctx, userID)
+	if err != nil || token == "" {
+		return
+	}
+	err = s.notifier.SendPushNotification(ctx, token,
+		"Protection resumed",
+		"Your SafeTrace pause has ended and your contacts will be alerted again if something goes wrong.")
+	if err != nil {
+		log.Printf("WARN: Failed to tell user %s their protection resumed: %v", userID, err)
+	}
+}
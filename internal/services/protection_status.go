@@ -0,0 +1,62 @@
+package services
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/database"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+)
+
+// Protection status reported on the user's status
+const (
+	ProtectionComplete   = "complete"
+	ProtectionIncomplete = "incomplete"
+)
+
+// ProtectionStatusOf is incomplete while the user has no trusted contacts.
+// Guardians and organization escalation contacts are still alerted, but
+// they are not the user's own to manage.
+func ProtectionStatusOf(user *models.User) string {
+	if len(user.TrustedContacts) == 0 {
+		return ProtectionIncomplete
+	}
+	return ProtectionComplete
+}
+
+// ProtectionWarnings warns users left with no trusted contacts: when they
+// register a device before adding one, and when they delete their last.
+type ProtectionWarnings struct {
+	postgres *database.PostgresDB
+	notifier Notifier
+	outbox   *AlertOutbox
+}
+
+func NewProtectionWarnings(postgres *database.PostgresDB, notifier Notifier, outbox *AlertOutbox) *ProtectionWarnings {
+	return &ProtectionWarnings{
+		postgres: postgres,
+		notifier: notifier,
+		outbox:   outbox,
+	}
+}
+
+// Check queues the warning for the user. Their contacts are looked at when
+// it is sent, so a contact added meanwhile cancels it; failures are logged.
+func (w *ProtectionWarnings) Check(ctx context.Context, userID uuid.UUID) {
+	w.outbox.EnqueueMessage(ctx, "protection warning to user "+userID.String(), func(ctx context.Context) error {
+		return w.warn(ctx, userID)
+	})
+}
+
+func (w *ProtectionWarnings) warn(ctx context.Context, userID uuid.UUID) error {
+	user, err := w.postgres.GetUserByID(ctx, userID)
+	if err != nil || user == nil || ProtectionStatusOf(user) == ProtectionComplete {
+		return err
+	}
+	token, err := w.postgres.GetPushToken(ctx, userID)
+	if err != nil || token == "" {
+		return err
+	}
+	return w.notifier.SendProtectionIncomplete(ctx, token)
+}
@@ -0,0 +1,190 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/config"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/database"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+)
+
+func TestProtectionStatusOf(t *testing.T) {
+	if got := ProtectionStatusOf(&models.User{}); got != ProtectionIncomplete {
+		t.Errorf("no contacts = %q, want %q", got, ProtectionIncomplete)
+	}
+	user := &models.User{TrustedContacts: models.TrustedContacts{{ID: "c1", Name: "Emeka", Phone: "+2348030000201"}}}
+	if got := ProtectionStatusOf(user); got != ProtectionComplete {
+		t.Errorf("one contact = %q, want %q", got, ProtectionComplete)
+	}
+}
+
+// newRecordingEngine is an alert engine that records what it sends, with
+// fallback as FALLBACK_ALERT_PHONE
+func newRecordingEngine(t *testing.T, postgres *database.PostgresDB, redis *database.RedisDB, fallback string) *DevNotifier {
+	t.Helper()
+	cfg := config.NewStore(&config.Config{FallbackAlertPhone: fallback})
+	templates, err := NewMessageTemplates(nil)
+	if err != nil {
+		t.Fatalf("NewMessageTemplates: %v", err)
+	}
+	return NewDevNotifier(cfg, postgres, redis, templates, NewLocationEncoder(cfg, postgres, redis), NewMapSnapshots(cfg, postgres, nil, nil), nil)
+}
+
+// createNoContactsAlert raises an AT_RISK alert for a new user with no
+// contacts and a registered device
+func createNoContactsAlert(t *testing.T, postgres *database.PostgresDB) (*models.User, *models.Alert, *models.Heartbeat) {
+	t.Helper()
+	ctx := context.Background()
+	user := createTestUser(t, postgres, "Ada")
+	if err := postgres.UpsertPushToken(ctx, user.ID, "token-"+user.ID.String()); err != nil {
+		t.Fatalf("UpsertPushToken: %v", err)
+	}
+	hb := simulatedHeartbeat(0, false)
+	hb.UserID = user.ID
+	now := time.Now()
+	alert := &models.Alert{ID: uuid.New(), UserID: user.ID, State: models.AlertStateAtRisk, Score: 30,
+		Reasons: models.Reasons{{Code: models.ReasonHeartbeatStale}}, SentTo: []string{}, CreatedAt: now, DetectedAt: now}
+	if err := postgres.CreateAlert(ctx, alert); err != nil {
+		t.Fatalf("CreateAlert: %v", err)
+	}
+	return user, alert, &hb
+}
+
+func sentOn(messages []DevNotification, channel string) []DevNotification {
+	var out []DevNotification
+	for _, m := range messages {
+		if m.Channel == channel {
+			out = append(out, m)
+		}
+	}
+	return out
+}
+
+// With no contacts, the alert and its resolution go to the fallback number
+func TestAlertFallbackRecipient(t *testing.T) {
+	postgres := testPostgres(t)
+	ctx := context.Background()
+	const desk = "+2348090000001"
+	engine := newRecordingEngine(t, postgres, testRedis(t), desk)
+	user, alert, hb := createNoContactsAlert(t, postgres)
+
+	if err := engine.SendAlertToContacts(ctx, user, alert, hb); err != nil {
+		t.Fatalf("SendAlertToContacts: %v", err)
+	}
+	sms := sentOn(engine.Messages(), "sms")
+	if len(sms) != 1 || sms[0].To != desk {
+		t.Fatalf("texts = %+v, want one to the fallback number", sms)
+	}
+	if pushes := sentOn(engine.Messages(), "push"); len(pushes) != 0 {
+		t.Errorf("pushes = %+v, want none", pushes)
+	}
+	deliveries, err := postgres.GetAlertDeliveries(ctx, alert.ID)
+	if err != nil {
+		t.Fatalf("GetAlertDeliveries: %v", err)
+	}
+	var recorded bool
+	for _, d := range deliveries {
+		recorded = recorded || (d.ContactID == fallbackContactID && d.Channel == "sms" && d.Phone == desk && d.Status == models.DeliveryStatusSent)
+	}
+	if !recorded {
+		t.Errorf("deliveries = %+v, want the fallback's text", deliveries)
+	}
+	if latest, err := postgres.GetLatestAlert(ctx, user.ID); err != nil || latest == nil || latest.Undeliverable != "" {
+		t.Errorf("alert = %+v, %v; want it delivered", latest, err)
+	}
+
+	engine.Clear()
+	if err := engine.SendAlertResolved(ctx, user, false); err != nil {
+		t.Fatalf("SendAlertResolved: %v", err)
+	}
+	if sms := sentOn(engine.Messages(), "sms"); len(sms) != 1 || sms[0].To != desk {
+		t.Errorf("resolution texts = %+v, want one to the fallback number", sms)
+	}
+}
+
+// With no contacts and no fallback, the alert is marked undeliverable, shown
+// to admins, and only the user is told, by the most urgent push
+func TestAlertNoRecipients(t *testing.T) {
+	postgres := testPostgres(t)
+	ctx := context.Background()
+	engine := newRecordingEngine(t, postgres, testRedis(t), "")
+	user, alert, hb := createNoContactsAlert(t, postgres)
+
+	if err := engine.SendAlertToContacts(ctx, user, alert, hb); err == nil {
+		t.Fatal("an alert that reached nobody reported as sent")
+	}
+	if sms := sentOn(engine.Messages(), "sms"); len(sms) != 0 {
+		t.Errorf("texts = %+v, want none", sms)
+	}
+	pushes := sentOn(engine.Messages(), "push")
+	if len(pushes) != 1 || pushes[0].To != "token-"+user.ID.String() ||
+		pushes[0].Data["type"] != "alert_undeliverable" || pushes[0].Data["alert_id"] != alert.ID.String() {
+		t.Errorf("pushes = %+v, want the undeliverable push to the user", pushes)
+	}
+
+	latest, err := postgres.GetLatestAlert(ctx, user.ID)
+	if err != nil || latest == nil || latest.Undeliverable != models.AlertNoRecipients {
+		t.Fatalf("alert = %+v, %v; want it marked %q", latest, err, models.AlertNoRecipients)
+	}
+	active, err := postgres.GetActiveAlerts(ctx, nil, 1000)
+	if err != nil {
+		t.Fatalf("GetActiveAlerts: %v", err)
+	}
+	for i, a := range active {
+		if a.ID == alert.ID {
+			if a.Undeliverable != models.AlertNoRecipients {
+				t.Errorf("active alert = %+v, want it undeliverable", a)
+			}
+			return
+		}
+		if a.Undeliverable == "" {
+			t.Fatalf("active alert %d is deliverable and listed before the undeliverable one", i)
+		}
+	}
+	t.Error("undeliverable alert not among active alerts")
+}
+
+// A user is warned while they have no contacts: not once they add one, and
+// again when they delete it
+func TestProtectionStatusLifecycle(t *testing.T) {
+	postgres := testPostgres(t)
+	ctx := context.Background()
+	engine := newRecordingEngine(t, postgres, testRedis(t), "")
+	warnings := NewProtectionWarnings(postgres, engine, nil)
+	user, _, _ := createNoContactsAlert(t, postgres)
+
+	check := func(want string) {
+		t.Helper()
+		engine.Clear()
+		current, err := postgres.GetUserByID(ctx, user.ID)
+		if err != nil {
+			t.Fatalf("GetUserByID: %v", err)
+		}
+		if got := ProtectionStatusOf(current); got != want {
+			t.Errorf("protection status = %q, want %q", got, want)
+		}
+		if err := warnings.warn(ctx, user.ID); err != nil {
+			t.Fatalf("warn: %v", err)
+		}
+		pushes := sentOn(engine.Messages(), "push")
+		warned := len(pushes) == 1 && pushes[0].Data["type"] == "protection_incomplete"
+		if warned != (want == ProtectionIncomplete) {
+			t.Errorf("%s: warnings = %+v", want, pushes)
+		}
+	}
+
+	check(ProtectionIncomplete)
+	contact := models.Contact{ID: uuid.NewString(), Name: "Emeka", Phone: testPhone(), Status: models.ContactStatusInvited}
+	if err := postgres.AddContact(ctx, user.ID, contact, 5); err != nil {
+		t.Fatalf("AddContact: %v", err)
+	}
+	check(ProtectionComplete)
+	if err := postgres.DeleteContact(ctx, user.ID, contact.ID); err != nil {
+		t.Fatalf("DeleteContact: %v", err)
+	}
+	check(ProtectionIncomplete)
+}
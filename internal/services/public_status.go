@@ -0,0 +1,248 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/database"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+)
+
+// Public status state buckets
+const (
+	PublicStatusOK        = "ok"
+	PublicStatusAttention = "attention"
+	PublicStatusUnknown   = "unknown"
+)
+
+const (
+	// PublicStatusCacheTTL is how long a token's status is served from cache
+	PublicStatusCacheTTL = 60 * time.Second
+	// publicStatusRevokedTTL is how long a revoked token's tombstone stays
+	// in the cache; long enough to outlive any status computed before the
+	// token was revoked
+	publicStatusRevokedTTL = 2 * PublicStatusCacheTTL
+)
+
+var (
+	// ErrPublicStatusInvalid is returned for a token that doesn't exist, was
+	// rotated, or whose user turned the public status off
+	ErrPublicStatusInvalid = errors.New("public status link is not valid")
+	// ErrPublicStatusDisabled is returned when rotating the token of a user
+	// who hasn't turned the public status on
+	ErrPublicStatusDisabled = errors.New("public status is turned off")
+)
+
+// PublicStatus is everything the public status shows: how the user is, in
+// three buckets, and how long ago they were last heard from. It never has
+// their location, score, reasons or name.
+type PublicStatus struct {
+	StateBucket             string `json:"state_bucket"`               // ok | attention | unknown
+	LastConfirmedMinutesAgo *int   `json:"last_confirmed_minutes_ago"` // null when never heard from
+}
+
+// PublicStatusService manages users' public status tokens and answers for them
+type PublicStatusService struct {
+	postgres *database.PostgresDB
+	redis    *database.RedisDB
+}
+
+func NewPublicStatusService(postgres *database.PostgresDB, redis *database.RedisDB) *PublicStatusService {
+	return &PublicStatusService{
+		postgres: postgres,
+		redis:    redis,
+	}
+}
+
+// Token returns the user's public status token, or nil if they have none.
+// Only users with the public_status setting on have one.
+func (s *PublicStatusService) Token(ctx context.Context, userID uuid.UUID) (*string, error) {
+	return s.postgres.GetPublicStatusToken(ctx, userID)
+}
+
+// Enable gives the user a token if they have none yet and returns it. The
+// token only answers while the public_status setting is on.
+func (s *PublicStatusService) Enable(ctx context.Context, userID uuid.UUID) (string, error) {
+	token, err := s.postgres.GetPublicStatusToken(ctx, userID)
+	if err != nil {
+		return "", err
+	}
+	if token != nil {
+		return *token, nil
+	}
+	return s.replace(ctx, userID)
+}
+
+// Disable clears the user's token, so it stops answering at once
+func (s *PublicStatusService) Disable(ctx context.Context, userID uuid.UUID) error {
+	previous, err := s.postgres.SetPublicStatusToken(ctx, userID, nil)
+	if err != nil {
+		return err
+	}
+	s.revoke(ctx, previous)
+	return nil
+}
+
+// Rotate replaces the user's token with a new one; the old one stops
+// answering at once
+func (s *PublicStatusService) Rotate(ctx context.Context, userID uuid.UUID) (string, error) {
+	token, err := s.postgres.GetPublicStatusToken(ctx, userID)
+	if err != nil {
+		return "", err
+	}
+	if token == nil {
+		return "", ErrPublicStatusDisabled
+	}
+	return s.replace(ctx, userID)
+}
+
+func (s *PublicStatusService) replace(ctx context.Context, userID uuid.UUID) (string, error) {
+	token, err := generateAckToken()
+	if err != nil {
+		return "", fmt.Errorf("failed to generate public status token: %w", err)
+	}
+	previous, err := s.postgres.SetPublicStatusToken(ctx, userID, &token)
+	if err != nil {
+		return "", err
+	}
+	s.revoke(ctx, previous)
+	return token, nil
+}
+
+// revoke tombstones a replaced token's cache entry. The database no longer
+// knows the token, so a failure here leaves it answering from cache for at
+// most PublicStatusCacheTTL.
+func (s *PublicStatusService) revoke(ctx context.Context, token *string) {
+	if token == nil {
+		return
+	}
+	if err := s.redis.RevokePublicStatus(ctx, *token, publicStatusRevokedTTL); err != nil {
+		log.Printf("WARN: Failed to revoke cached public status: %v", err)
+	}
+}
+
+// Status returns the public status behind a token, from cache when it can
+func (s *PublicStatusService) Status(ctx context.Context, token string) (*PublicStatus, error) {
+	data, revoked, err := s.redis.GetCachedPublicStatus(ctx, token)
+	if err != nil {
+		log.Printf("WARN: Failed to read cached public status: %v", err)
+	}
+	if revoked {
+		return nil, ErrPublicStatusInvalid
+	}
+	if data != nil {
+		var status PublicStatus
+		if err := json.Unmarshal(data, &status); err == nil {
+			return &status, nil
+		}
+	}
+
+	userID, err := s.postgres.GetUserIDByPublicStatusToken(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	if userID == nil {
+		return nil, ErrPublicStatusInvalid
+	}
+
+	state, err := s.redis.GetUserState(ctx, *userID)
+	if err != nil {
+		return nil, err
+	}
+	status := publicStatusOf(state, time.Now())
+
+	if data, err := json.Marshal(status); err == nil {
+		if err := s.redis.CachePublicStatus(ctx, token, data, PublicStatusCacheTTL); err != nil {
+			log.Printf("WARN: Failed to cache public status: %v", err)
+		}
+	}
+	return status, nil
+}
+
+// publicStatusOf buckets a user's state for the public. A duress alert reads
+// as ok, as it does to whoever forced the cancel; a pause reads as unknown.
+func publicStatusOf(state *models.UserState, now time.Time) *PublicStatus {
+	if state == nil || state.LastHeartbeat.IsZero() {
+		return &PublicStatus{StateBucket: PublicStatusUnknown}
+	}
+
+	bucket := PublicStatusAttention
+	switch {
+	case state.State == StatePaused:
+		bucket = PublicStatusUnknown
+	case state.State == StateSafe, isDuress(state.Reasons):
+		bucket = PublicStatusOK
+	}
+
+	minutes := int(now.Sub(state.LastHeartbeat).Minutes())
+	if minutes < 0 {
+		minutes = 0
+	}
+	return &PublicStatus{StateBucket: bucket, LastConfirmedMinutesAgo: &minutes}
+}
+
+func isDuress(reasons []models.Reason) bool {
+	for _, r := range reasons {
+		if r.Code == models.ReasonDuress {
+			return true
+		}
+	}
+	return false
+}
+
+// publicStatusColors are the badge's message colours per bucket
+var publicStatusColors = map[string]string{
+	PublicStatusOK:        "#2e7d32",
+	PublicStatusAttention: "#ef6c00",
+	PublicStatusUnknown:   "#757575",
+}
+
+// Badge renders the status as a flat SVG badge, e.g. "safety | ok · 5 min ago"
+func (p *PublicStatus) Badge() []byte {
+	label := "safety"
+	message := p.StateBucket
+	if p.LastConfirmedMinutesAgo != nil {
+		message += " · " + minutesAgo(*p.LastConfirmedMinutesAgo)
+	}
+
+	// Approximate text widths at 11px Verdana; no user input ends up in the SVG
+	const charWidth, padding = 7, 10
+	labelWidth := len(label)*charWidth + padding
+	messageWidth := len([]rune(message))*charWidth + padding
+	width := labelWidth + messageWidth
+
+	return []byte(fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="20" role="img" aria-label="%s: %s">`+
+		`<title>%s: %s</title>`+
+		`<rect width="%d" height="20" fill="#555"/>`+
+		`<rect x="%d" width="%d" height="20" fill="%s"/>`+
+		`<g fill="#fff" text-anchor="middle" font-family="Verdana,DejaVu Sans,sans-serif" font-size="11">`+
+		`<text x="%d" y="14">%s</text>`+
+		`<text x="%d" y="14">%s</text>`+
+		`</g></svg>`,
+		width, label, message,
+		label, message,
+		labelWidth,
+		labelWidth, messageWidth, publicStatusColors[p.StateBucket],
+		labelWidth/2, label,
+		labelWidth+messageWidth/2, message,
+	))
+}
+
+func minutesAgo(minutes int) string {
+	switch {
+	case minutes < 1:
+		return "just now"
+	case minutes < 60:
+		return fmt.Sprintf("%d min ago", minutes)
+	case minutes < 48*60:
+		return fmt.Sprintf("%d h ago", minutes/60)
+	default:
+		return fmt.Sprintf("%d d ago", minutes/(24*60))
+	}
+}
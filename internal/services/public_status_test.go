@@ -0,0 +1,128 @@
+package services
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+)
+
+func TestPublicStatusOf(t *testing.T) {
+	now := time.Date(2026, 3, 9, 22, 0, 0, 0, time.UTC)
+	seen := now.Add(-7*time.Minute - 30*time.Second)
+	duress := []models.Reason{{Code: models.ReasonDuress}}
+
+	tests := []struct {
+		name    string
+		state   *models.UserState
+		bucket  string
+		minutes *int
+	}{
+		{"never evaluated", nil, PublicStatusUnknown, nil},
+		{"never heard from", &models.UserState{State: StateSafe}, PublicStatusUnknown, nil},
+		{"safe", &models.UserState{State: StateSafe, LastHeartbeat: seen}, PublicStatusOK, intPtr(7)},
+		{"caution", &models.UserState{State: StateCaution, LastHeartbeat: seen}, PublicStatusAttention, intPtr(7)},
+		{"at risk", &models.UserState{State: StateAtRisk, LastHeartbeat: seen}, PublicStatusAttention, intPtr(7)},
+		{"waiting on a LastGasp", &models.UserState{State: StateWaitLastGasp, LastHeartbeat: seen}, PublicStatusAttention, intPtr(7)},
+		{"alert", &models.UserState{State: StateAlert, LastHeartbeat: seen}, PublicStatusAttention, intPtr(7)},
+		// Whoever forced the cancel sees the same as for a real one
+		{"duress", &models.UserState{State: StateAlert, LastHeartbeat: seen, Reasons: duress}, PublicStatusOK, intPtr(7)},
+		{"paused", &models.UserState{State: StatePaused, LastHeartbeat: seen}, PublicStatusUnknown, intPtr(7)},
+		{"clock skew", &models.UserState{State: StateSafe, LastHeartbeat: now.Add(time.Minute)}, PublicStatusOK, intPtr(0)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status := publicStatusOf(tt.state, now)
+			if status.StateBucket != tt.bucket || !reflect.DeepEqual(status.LastConfirmedMinutesAgo, tt.minutes) {
+				t.Errorf("status = %s, %v; want %s, %v", status.StateBucket, deref(status.LastConfirmedMinutesAgo), tt.bucket, deref(tt.minutes))
+			}
+		})
+	}
+}
+
+func intPtr(n int) *int { return &n }
+
+func deref(n *int) interface{} {
+	if n == nil {
+		return nil
+	}
+	return *n
+}
+
+// The status carries the bucket and the minutes, and nothing about who or
+// where the user is, however much their state holds
+func TestPublicStatusFields(t *testing.T) {
+	now := time.Now()
+	state := &models.UserState{
+		UserID:        uuid.New(),
+		State:         StateAtRisk,
+		Score:         31,
+		LastHeartbeat: now.Add(-42 * time.Minute),
+		SpoofReasons:  []string{"mock_location"},
+		Reasons:       []models.Reason{{Code: models.ReasonHeartbeatStale, Params: map[string]interface{}{"minutes": 42}}},
+		Roaming:       &models.Roaming{},
+		Outage:        &models.OutageZone{Carrier: "MTN", Lat: 6.5244, Lng: 3.3792},
+	}
+	status := publicStatusOf(state, now)
+
+	data, err := json.Marshal(status)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	want := map[string]interface{}{"state_bucket": PublicStatusAttention, "last_confirmed_minutes_ago": float64(42)}
+	if !reflect.DeepEqual(fields, want) {
+		t.Errorf("status = %s, want only the bucket and minutes", data)
+	}
+
+	never, _ := json.Marshal(publicStatusOf(nil, now))
+	if string(never) != `{"state_bucket":"unknown","last_confirmed_minutes_ago":null}` {
+		t.Errorf("never heard from = %s", never)
+	}
+
+	for _, leak := range []string{state.UserID.String(), "31", "6.52", "3.37", "MTN", "mock_location", "HEARTBEAT_STALE", "AT_RISK"} {
+		if strings.Contains(string(data), leak) || strings.Contains(string(status.Badge()), leak) {
+			t.Errorf("status or badge shows %q", leak)
+		}
+	}
+}
+
+func TestPublicStatusBadge(t *testing.T) {
+	tests := []struct {
+		status  PublicStatus
+		message string
+		color   string
+	}{
+		{PublicStatus{StateBucket: PublicStatusOK, LastConfirmedMinutesAgo: intPtr(0)}, "ok · just now", "#2e7d32"},
+		{PublicStatus{StateBucket: PublicStatusOK, LastConfirmedMinutesAgo: intPtr(5)}, "ok · 5 min ago", "#2e7d32"},
+		{PublicStatus{StateBucket: PublicStatusAttention, LastConfirmedMinutesAgo: intPtr(150)}, "attention · 2 h ago", "#ef6c00"},
+		{PublicStatus{StateBucket: PublicStatusUnknown, LastConfirmedMinutesAgo: intPtr(3 * 24 * 60)}, "unknown · 3 d ago", "#757575"},
+		{PublicStatus{StateBucket: PublicStatusUnknown}, "unknown", "#757575"},
+	}
+	for _, tt := range tests {
+		badge := tt.status.Badge()
+		var svg struct {
+			XMLName xml.Name `xml:"svg"`
+			Title   string   `xml:"title"`
+			Texts   []string `xml:"g>text"`
+		}
+		if err := xml.Unmarshal(badge, &svg); err != nil {
+			t.Fatalf("badge for %q is not well-formed: %v\n%s", tt.message, err, badge)
+		}
+		if svg.Title != "safety: "+tt.message || !reflect.DeepEqual(svg.Texts, []string{"safety", tt.message}) {
+			t.Errorf("badge = %q %q, want %q", svg.Title, svg.Texts, tt.message)
+		}
+		if !strings.Contains(string(badge), `fill="`+tt.color+`"`) {
+			t.Errorf("badge for %q not coloured %s", tt.message, tt.color)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package services
+
+import (
+	"bytes"
+	"log"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+)
+
+// alertMessageReasons is how many reasons an alert message gives, most
+// severe first
+const alertMessageReasons = 2
+
+// reasonTexts renders each reason code, in English, against its params.
+// Params may have come back from JSON, so numbers are printed, never compared.
+var reasonTexts = map[string]string{
+	models.ReasonNoHeartbeat:        "No heartbeat data yet",
+	models.ReasonScoreNormal:        "All indicators normal",
+	models.ReasonScoreConcerning:    "Some indicators concerning - silent check initiated",
+	models.ReasonScoreAtRisk:        "Multiple risk indicators detected",
+	models.ReasonHeartbeatStale:     "No heartbeat for {{.minutes}} minutes",
+	models.ReasonLastGaspActive:     "LastGasp active for {{.minutes}} minutes - monitoring connectivity",
+	models.ReasonLastGaspProlonged:  "LastGasp {{.minutes}} minutes ago and no heartbeat since",
+	models.ReasonLastGaspRecent:     "LastGasp received - monitoring",
+	models.ReasonEnteringDeadZone:   "likely entering a dead zone: cellular only, signal fell from {{.from_dbm}} to {{.to_dbm}} dBm",
+	models.ReasonSpoofSuspected:     "location may be spoofed",
+	models.ReasonOfflineQueued:      "last heartbeat was recorded offline",
+	models.ReasonDeviceDisagreement: "devices report locations {{.km}}km apart",
+	models.ReasonDeterioratingTrend: "deteriorating trend",
+	models.ReasonActiveInApp:        "heartbeats stale but user recently active in app",
+	models.ReasonProtectionPaused:   "protection paused until {{.until}}",
+	models.ReasonPanic: `{{if eq .source "sms"}}Panic SMS ({{.keyword}}) from registered phone` +
+		`{{else if eq .source "ussd"}}USSD help request from registered phone` +
+		`{{else if eq .outcome "wrong_pin"}}Panic from the app; a wrong PIN was entered to cancel it` +
+		`{{else if eq .outcome "not_cancelled"}}Panic from the app, not cancelled` +
+		`{{else}}Panic from the app{{end}}`,
+	models.ReasonDuress: "Panic from the app, then cancelled with the duress PIN; the user may be forced to cancel",
+	models.ReasonImpact: "impact detected in sensor trail at {{.at}}",
+	models.ReasonWatchExpired: "Watch session ended at {{.ended_at}} without the user confirming they arrived; last state {{.last_state}}" +
+		`{{if has . "last_score"}} (score {{.last_score}}){{end}}{{if .note}}. Note: {{.note}}{{end}}`,
+	models.ReasonCheckInPending:    "waiting for the user to answer a check-in prompt until {{.until}}",
+	models.ReasonCheckInUnanswered: "User didn't answer {{.attempts}} check-in prompts over {{.seconds}} seconds",
+	models.ReasonProbableOutage:    "probable network outage in your area ({{.carrier}}); waiting for heartbeats until {{.until}}",
+	models.ReasonLegacy:            "{{.text}}",
+}
+
+// reasonSeverity orders reasons for alert messages; unknown codes rank last
+var reasonSeverity = map[string]int{
+	models.ReasonDuress:             100,
+	models.ReasonPanic:              95,
+	models.ReasonImpact:             90,
+	models.ReasonHeartbeatStale:     80,
+	models.ReasonWatchExpired:       80,
+	models.ReasonCheckInUnanswered:  80,
+	models.ReasonLastGaspProlonged:  85,
+	models.ReasonLastGaspActive:     75,
+	models.ReasonLastGaspRecent:     70,
+	models.ReasonEnteringDeadZone:   65,
+	models.ReasonScoreAtRisk:        60,
+	models.ReasonDeterioratingTrend: 50,
+	models.ReasonScoreConcerning:    40,
+	models.ReasonDeviceDisagreement: 35,
+	models.ReasonSpoofSuspected:     30,
+	models.ReasonOfflineQueued:      25,
+	models.ReasonActiveInApp:        20,
+	models.ReasonCheckInPending:     20,
+	models.ReasonProbableOutage:     20,
+	models.ReasonProtectionPaused:   10,
+	models.ReasonScoreNormal:        5,
+	models.ReasonNoHeartbeat:        5,
+}
+
+var reasonTemplates = parseReasonTexts()
+
+func parseReasonTexts() map[string]*template.Template {
+	funcs := template.FuncMap{
+		"has": func(params map[string]interface{}, key string) bool {
+			_, ok := params[key]
+			return ok
+		},
+	}
+	parsed := make(map[string]*template.Template, len(reasonTexts))
+	for code, text := range reasonTexts {
+		parsed[code] = template.Must(template.New(code).Funcs(funcs).Parse(text))
+	}
+	return parsed
+}
+
+// ReasonText renders one reason. An unknown code, or one whose params don't
+// fit its text, renders as the code itself.
+func ReasonText(reason models.Reason) string {
+	tmpl, ok := reasonTemplates[reason.Code]
+	if !ok {
+		return reason.Code
+	}
+	params := reason.Params
+	if params == nil {
+		params = map[string]interface{}{}
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, params); err != nil {
+		log.Printf("WARN: Failed to render reason %s: %v", reason.Code, err)
+		return reason.Code
+	}
+	return strings.ReplaceAll(buf.String(), "<no value>", "")
+}
+
+// RenderReasons renders reasons as one line: the first, then the others in
+// parentheses
+func RenderReasons(reasons []models.Reason) string {
+	var b strings.Builder
+	for _, reason := range reasons {
+		text := ReasonText(reason)
+		if text == "" {
+			continue
+		}
+		if b.Len() == 0 {
+			b.WriteString(text)
+		} else {
+			b.WriteString(" (" + text + ")")
+		}
+	}
+	return b.String()
+}
+
+// TopReasons returns at most n of reasons, most severe first. Reasons of
+// equal severity keep their order.
+func TopReasons(reasons []models.Reason, n int) []models.Reason {
+	top := append([]models.Reason{}, reasons...)
+	sort.SliceStable(top, func(i, j int) bool {
+		return reasonSeverity[top[i].Code] > reasonSeverity[top[j].Code]
+	})
+	if len(top) > n {
+		top = top[:n]
+	}
+	return top
+}
+
+// AlertReasonText is the reason an alert message gives: its most severe
+// reasons, or the stored text for an alert raised before reason codes
+func AlertReasonText(alert *models.Alert) string {
+	for _, reason := range alert.Reasons {
+		if reason.Code != models.ReasonLegacy {
+			return RenderReasons(TopReasons(alert.Reasons, alertMessageReasons))
+		}
+	}
+	return alert.Reason
+}
+
+// PanicReason is the reason for a panic raised from source: "app", "sms" or
+// "ussd". keyword is the SMS keyword used; outcome, for the app, is
+// "wrong_pin", "not_cancelled" or empty.
+func PanicReason(source, keyword, outcome string) models.Reason {
+	return models.Reason{Code: models.ReasonPanic, Params: map[string]interface{}{
+		"source":  source,
+		"keyword": keyword,
+		"outcome": outcome,
+	}}
+}
@@ -0,0 +1,289 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/config"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+)
+
+// ruleReasons is the code each rule's result leads with, or adds
+var ruleReasons = map[string]string{
+	RuleNoHeartbeat:       models.ReasonNoHeartbeat,
+	RuleLastGaspActive:    models.ReasonLastGaspActive,
+	RuleLastGaspRecent:    models.ReasonLastGaspRecent,
+	RuleLastGaspProlonged: models.ReasonLastGaspProlonged,
+	RuleHeartbeatStale:    models.ReasonHeartbeatStale,
+	RuleProtectionPaused:  models.ReasonProtectionPaused,
+	RuleActiveInApp:       models.ReasonActiveInApp,
+	RuleEnteringDeadZone:  models.ReasonEnteringDeadZone,
+	RuleCheckInPending:    models.ReasonCheckInPending,
+	RuleProbableOutage:    models.ReasonProbableOutage,
+}
+
+// checkRuleReason fails unless result fired rule with its code among its
+// reasons, every reason renders, and Reason is rendered from them
+func checkRuleReason(t *testing.T, result *EvaluationResult, rule string) {
+	t.Helper()
+	code, ok := ruleReasons[rule]
+	if !ok {
+		t.Fatalf("rule %s has no code in ruleReasons", rule)
+	}
+	if !firedRule(result, rule) {
+		t.Fatalf("rules fired = %v, want %s", result.RulesFired, rule)
+	}
+	if !hasReason(result, code) {
+		t.Errorf("rule %s: reasons = %v, want %s", rule, result.Reasons, code)
+	}
+	for _, r := range result.Reasons {
+		if _, ok := reasonTexts[r.Code]; !ok {
+			t.Errorf("rule %s: reason %s has no text", rule, r.Code)
+		}
+	}
+	if result.Reason == "" || result.Reason != RenderReasons(result.Reasons) {
+		t.Errorf("rule %s: reason %q not rendered from %v", rule, result.Reason, result.Reasons)
+	}
+}
+
+// Every rule that needs no store attaches its code; the others are covered
+// by TestStoredRulesEmitReasonCodes
+func TestRulesEmitReasonCodes(t *testing.T) {
+	cfg := simulationConfig()
+	profile := DefaultScoringProfile(cfg)
+	hb := simulatedHeartbeat(0, false)
+	lastGasp := simulatedHeartbeat(0, true)
+	at := func(after time.Duration) *SafetyEvaluator {
+		return &SafetyEvaluator{cfg: config.NewStore(cfg), clock: NewFakeClock(simulationStart.Add(after)), effects: discardEffects{}}
+	}
+	waiting := func(since time.Duration) *models.LastGasp {
+		return &models.LastGasp{LastAt: simulationStart.Add(since), ExpiryTs: simulationStart.Add(since + time.Hour)}
+	}
+
+	covered := map[string]bool{}
+	check := func(name string, result *EvaluationResult, rule string) {
+		t.Run(name, func(t *testing.T) {
+			checkRuleReason(t, result, rule)
+			covered[rule] = true
+		})
+	}
+
+	check("no heartbeat", at(0).Assess(nil, nil, profile), RuleNoHeartbeat)
+	check("lastgasp active", at(10*time.Minute).Assess(&hb, waiting(0), profile), RuleLastGaspActive)
+	check("lastgasp prolonged", at(45*time.Minute).Assess(&hb, waiting(0), profile), RuleLastGaspProlonged)
+	check("lastgasp recent", at(time.Minute).Assess(&lastGasp, nil, profile), RuleLastGaspRecent)
+	stale := at(30*time.Minute).Assess(&hb, nil, profile)
+	check("heartbeat stale", stale, RuleHeartbeatStale)
+
+	activeAt := simulationStart.Add(12 * time.Minute)
+	active := at(30*time.Minute).Assess(&hb, nil, profile)
+	if !SuppressForActivity(active, &hb, &activeAt, simulationStart.Add(30*time.Minute), profile, time.Hour) {
+		t.Fatal("activity didn't suppress a stale heartbeat")
+	}
+	check("active in app", active, RuleActiveInApp)
+
+	zone := &models.OutageZone{Carrier: "MTN", Until: simulationStart.Add(2 * time.Hour)}
+	outage := at(30*time.Minute).Assess(&hb, nil, profile)
+	if SuppressForOutage(outage, zone, simulationStart.Add(10*time.Minute), simulationStart.Add(30*time.Minute), time.Hour).IsZero() {
+		t.Fatal("outage didn't hold a stale heartbeat")
+	}
+	check("probable outage", outage, RuleProbableOutage)
+
+	for _, rule := range []string{RuleNoHeartbeat, RuleLastGaspActive, RuleLastGaspProlonged, RuleLastGaspRecent, RuleHeartbeatStale, RuleActiveInApp, RuleProbableOutage} {
+		if !covered[rule] {
+			t.Errorf("rule %s not exercised", rule)
+		}
+	}
+
+	// Scored results lead with the band they fall in
+	for _, tt := range []struct {
+		name  string
+		after time.Duration
+		hb    func() models.Heartbeat
+		code  string
+	}{
+		{"scored normal", time.Minute, func() models.Heartbeat { return hb }, models.ReasonScoreNormal},
+		{"scored concerning", 9 * time.Minute, func() models.Heartbeat { return hb }, models.ReasonScoreConcerning},
+		{"scored at risk", 9 * time.Minute, func() models.Heartbeat {
+			poor := hb
+			battery := 3
+			poor.AccuracyM, poor.BatteryPct, poor.CellInfo.RSSI = 2000, &battery, -115
+			return poor
+		}, models.ReasonScoreAtRisk},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			scored := tt.hb()
+			result := at(tt.after).Assess(&scored, nil, profile)
+			if result.Deterministic || len(result.Reasons) == 0 || result.Reasons[0].Code != tt.code {
+				t.Errorf("score %d: reasons = %v, want %s first", result.Score, result.Reasons, tt.code)
+			}
+		})
+	}
+}
+
+// The rules that read the user's pause, prompt or recent heartbeats
+func TestStoredRulesEmitReasonCodes(t *testing.T) {
+	postgres := testPostgres(t)
+	redis := testRedis(t)
+	ctx := context.Background()
+	cfg := simulationConfig()
+	se := &SafetyEvaluator{cfg: config.NewStore(cfg), postgres: postgres, redis: redis, clock: SystemClock{}, effects: discardEffects{}}
+
+	t.Run("protection paused", func(t *testing.T) {
+		user := createTestUser(t, postgres, "Paused")
+		result, err := se.holdPaused(ctx, user.ID, &models.ProtectionPause{UserID: user.ID, PausedAt: time.Now(), PausedUntil: time.Now().Add(time.Hour)})
+		if err != nil {
+			t.Fatalf("holdPaused: %v", err)
+		}
+		checkRuleReason(t, result, RuleProtectionPaused)
+	})
+
+	t.Run("check-in pending", func(t *testing.T) {
+		user := createTestUser(t, postgres, "Prompted")
+		session := &models.PromptSession{ID: uuid.New(), UserID: user.ID, Ladder: []string{"push", "sms"}, Timeout: time.Minute, StartedAt: time.Now(), Due: time.Now()}
+		if _, err := redis.StartPromptSession(ctx, session); err != nil {
+			t.Fatalf("StartPromptSession: %v", err)
+		}
+		result := &EvaluationResult{State: StateAtRisk, RulesFired: []string{RuleHeartbeatStale}}
+		result.setReasons(models.Reason{Code: models.ReasonHeartbeatStale, Params: map[string]interface{}{"minutes": 12}})
+		if se.holdForPrompt(ctx, user.ID, result).IsZero() {
+			t.Fatal("result not held for the prompt")
+		}
+		checkRuleReason(t, result, RuleCheckInPending)
+	})
+
+	t.Run("entering dead zone", func(t *testing.T) {
+		user := createTestUser(t, postgres, "Fading")
+		for i, rssi := range []int{-80, -85, -90, -95} {
+			hb := simulatedHeartbeat(0, false)
+			hb.UserID = user.ID
+			hb.Timestamp = time.Now().Add(time.Duration(i-4) * time.Minute)
+			hb.Connectivity = models.ConnectivityCellular
+			hb.CellInfo.RSSI = rssi
+			if err := postgres.CreateHeartbeat(ctx, &hb); err != nil {
+				t.Fatalf("CreateHeartbeat: %v", err)
+			}
+		}
+		result := &EvaluationResult{State: StateCaution, RulesFired: []string{RuleLastGaspRecent}, Deterministic: true}
+		result.setReasons(models.Reason{Code: models.ReasonLastGaspRecent})
+		se.explainDeadZone(ctx, user.ID, result)
+		checkRuleReason(t, result, RuleEnteringDeadZone)
+		if !strings.Contains(result.Reason, "from -80 to -95 dBm") {
+			t.Errorf("reason = %q", result.Reason)
+		}
+	})
+}
+
+// Every code renders in English, the only language reasons have, and
+// renders the same once stored as JSON and read back
+func TestReasonRendering(t *testing.T) {
+	tests := []struct {
+		reason models.Reason
+		want   string
+	}{
+		{models.Reason{Code: models.ReasonNoHeartbeat}, "No heartbeat data yet"},
+		{models.Reason{Code: models.ReasonScoreNormal}, "All indicators normal"},
+		{models.Reason{Code: models.ReasonScoreConcerning}, "Some indicators concerning - silent check initiated"},
+		{models.Reason{Code: models.ReasonScoreAtRisk}, "Multiple risk indicators detected"},
+		{models.Reason{Code: models.ReasonHeartbeatStale, Params: map[string]interface{}{"minutes": 43}}, "No heartbeat for 43 minutes"},
+		{models.Reason{Code: models.ReasonLastGaspActive, Params: map[string]interface{}{"minutes": 12}}, "LastGasp active for 12 minutes - monitoring connectivity"},
+		{models.Reason{Code: models.ReasonLastGaspProlonged, Params: map[string]interface{}{"minutes": 41}}, "LastGasp 41 minutes ago and no heartbeat since"},
+		{models.Reason{Code: models.ReasonLastGaspRecent}, "LastGasp received - monitoring"},
+		{models.Reason{Code: models.ReasonEnteringDeadZone, Params: map[string]interface{}{"from_dbm": -80, "to_dbm": -105}},
+			"likely entering a dead zone: cellular only, signal fell from -80 to -105 dBm"},
+		{models.Reason{Code: models.ReasonSpoofSuspected}, "location may be spoofed"},
+		{models.Reason{Code: models.ReasonOfflineQueued}, "last heartbeat was recorded offline"},
+		{models.Reason{Code: models.ReasonDeviceDisagreement, Params: map[string]interface{}{"km": 14}}, "devices report locations 14km apart"},
+		{models.Reason{Code: models.ReasonDeterioratingTrend, Params: map[string]interface{}{"penalty": 10}}, "deteriorating trend"},
+		{models.Reason{Code: models.ReasonActiveInApp}, "heartbeats stale but user recently active in app"},
+		{models.Reason{Code: models.ReasonProtectionPaused, Params: map[string]interface{}{"until": "2026-03-09T22:00:00Z"}}, "protection paused until 2026-03-09T22:00:00Z"},
+		{PanicReason("app", "", ""), "Panic from the app"},
+		{PanicReason("app", "", "wrong_pin"), "Panic from the app; a wrong PIN was entered to cancel it"},
+		{PanicReason("app", "", "not_cancelled"), "Panic from the app, not cancelled"},
+		{PanicReason("sms", "HELP", ""), "Panic SMS (HELP) from registered phone"},
+		{PanicReason("ussd", "", ""), "USSD help request from registered phone"},
+		{models.Reason{Code: models.ReasonDuress}, "Panic from the app, then cancelled with the duress PIN; the user may be forced to cancel"},
+		{models.Reason{Code: models.ReasonImpact, Params: map[string]interface{}{"at": "2026-03-09T21:14:03Z"}}, "impact detected in sensor trail at 2026-03-09T21:14:03Z"},
+		{models.Reason{Code: models.ReasonWatchExpired, Params: map[string]interface{}{"ended_at": "9:30 PM WAT", "last_state": "SAFE"}},
+			"Watch session ended at 9:30 PM WAT without the user confirming they arrived; last state SAFE"},
+		{models.Reason{Code: models.ReasonCheckInPending, Params: map[string]interface{}{"until": "2026-03-09T22:00:00Z"}}, "waiting for the user to answer a check-in prompt until 2026-03-09T22:00:00Z"},
+		{models.Reason{Code: models.ReasonCheckInUnanswered, Params: map[string]interface{}{"attempts": 3, "seconds": 540}}, "User didn't answer 3 check-in prompts over 540 seconds"},
+		{models.Reason{Code: models.ReasonProbableOutage, Params: map[string]interface{}{"carrier": "MTN", "until": "2026-03-09T22:00:00Z"}},
+			"probable network outage in your area (MTN); waiting for heartbeats until 2026-03-09T22:00:00Z"},
+		{models.Reason{Code: models.ReasonLegacy, Params: map[string]interface{}{"text": "No heartbeat for 43 minutes"}}, "No heartbeat for 43 minutes"},
+	}
+
+	rendered := map[string]bool{}
+	for _, tt := range tests {
+		rendered[tt.reason.Code] = true
+		if got := ReasonText(tt.reason); got != tt.want {
+			t.Errorf("%s = %q, want %q", tt.reason.Code, got, tt.want)
+		}
+
+		data, err := json.Marshal([]models.Reason{tt.reason})
+		if err != nil {
+			t.Fatalf("Marshal %s: %v", tt.reason.Code, err)
+		}
+		var stored []models.Reason
+		if err := json.Unmarshal(data, &stored); err != nil {
+			t.Fatalf("Unmarshal %s: %v", tt.reason.Code, err)
+		}
+		if got := ReasonText(stored[0]); got != tt.want {
+			t.Errorf("%s after JSON = %q, want %q", tt.reason.Code, got, tt.want)
+		}
+	}
+
+	for code := range reasonTexts {
+		if !rendered[code] {
+			t.Errorf("reason %s has no rendering case", code)
+		}
+		if _, ok := reasonSeverity[code]; !ok && code != models.ReasonLegacy {
+			t.Errorf("reason %s has no severity", code)
+		}
+	}
+	for _, code := range ruleReasons {
+		if _, ok := reasonTexts[code]; !ok {
+			t.Errorf("rule reason %s has no text", code)
+		}
+	}
+
+	if got := ReasonText(models.Reason{Code: "SOMETHING_NEW"}); got != "SOMETHING_NEW" {
+		t.Errorf("unknown code = %q, want the code", got)
+	}
+}
+
+func TestRenderReasonsAndTop(t *testing.T) {
+	reasons := []models.Reason{
+		{Code: models.ReasonScoreAtRisk},
+		{Code: models.ReasonSpoofSuspected},
+		{Code: models.ReasonHeartbeatStale, Params: map[string]interface{}{"minutes": 20}},
+		{Code: models.ReasonDeterioratingTrend},
+	}
+	if got, want := RenderReasons(reasons[:2]), "Multiple risk indicators detected (location may be spoofed)"; got != want {
+		t.Errorf("RenderReasons = %q, want %q", got, want)
+	}
+
+	top := TopReasons(reasons, alertMessageReasons)
+	want := []models.Reason{reasons[2], reasons[0]}
+	if !reflect.DeepEqual(top, want) {
+		t.Errorf("TopReasons = %v, want %v", top, want)
+	}
+	if reasons[0].Code != models.ReasonScoreAtRisk {
+		t.Error("TopReasons reordered its input")
+	}
+
+	alert := &models.Alert{Reason: "stored", Reasons: reasons}
+	if got := AlertReasonText(alert); got != "No heartbeat for 20 minutes (Multiple risk indicators detected)" {
+		t.Errorf("AlertReasonText = %q", got)
+	}
+	legacy := &models.Alert{Reason: "Heartbeat missing since 9pm", Reasons: []models.Reason{{Code: models.ReasonLegacy, Params: map[string]interface{}{"text": "Heartbeat missing since 9pm"}}}}
+	if got := AlertReasonText(legacy); got != legacy.Reason {
+		t.Errorf("legacy AlertReasonText = %q, want the stored text", got)
+	}
+}
@@ -0,0 +1,356 @@
+package services
+
+import (
+	"context"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/config"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/database"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+)
+
+const (
+	redisWarmupWorker = "redis_warmup"
+	redisWarmupEvery  = 10 * time.Second
+	// redisWarmupLockTTL is how long another instance waits to take over a
+	// warmup whose instance died; the lock is renewed every batch
+	redisWarmupLockTTL = time.Minute
+	redisWarmupBatch   = 100
+)
+
+// RedisWarmup rebuilds what Redis holds for recently active users after it
+// lost its data to a flush or failover. Without it the first wave of
+// heartbeats would each read their user's state back from Postgres at once,
+// alerts would lose their dedup keys and be sent again, and clients on a
+// long advised interval would look stale to an evaluator that no longer
+// knows it.
+//
+// A sentinel key marks Redis as warm. Every instance checks it every 10
+// seconds, and on a state cache miss; once it is missing the instance opens
+// a warmup window, and the one that takes the lock restores, at
+// REDIS_WARMUP_RATE users a second, each user's state, their alert-dedup key
+// for what is left of its window and their stale-user check. Users with an
+// unresolved alert go first, then those last AT_RISK or CAUTION.
+type RedisWarmup struct {
+	cfg      *config.Store
+	postgres *database.PostgresDB
+	redis    *database.RedisDB
+	health   *HealthRegistry
+
+	windowUntil   atomic.Int64 // unix nanoseconds
+	running       atomic.Bool
+	total         atomic.Int64
+	processed     atomic.Int64
+	restored      atomic.Int64
+	lastCompleted atomic.Int64 // unix seconds
+
+	check     chan struct{}
+	closeOnce sync.Once
+	stop      chan struct{}
+	done      chan struct{}
+}
+
+func NewRedisWarmup(cfg *config.Store, postgres *database.PostgresDB, redis *database.RedisDB, health *HealthRegistry) *RedisWarmup {
+	return &RedisWarmup{
+		cfg:      cfg,
+		postgres: postgres,
+		redis:    redis,
+		health:   health,
+		check:    make(chan struct{}, 1),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// Start launches the warmup goroutine. Its first check runs right away, so
+// a Redis that lost its data while the server was down is warmed at startup.
+func (w *RedisWarmup) Start() {
+	w.health.Register(redisWarmupWorker, redisWarmupEvery)
+	go w.run()
+}
+
+// Close stops the goroutine, abandoning a running warmup to the next
+// instance that finds the sentinel missing, and waits for it to finish
+func (w *RedisWarmup) Close() {
+	w.closeOnce.Do(func() {
+		close(w.stop)
+	})
+	<-w.done
+}
+
+// Running reports whether this instance is warming Redis up
+func (w *RedisWarmup) Running() bool {
+	return w.running.Load()
+}
+
+// Total is how many users the current or last warmup on this instance set
+// out to restore
+func (w *RedisWarmup) Total() int64 {
+	return w.total.Load()
+}
+
+// Processed is how many of them it has gone through
+func (w *RedisWarmup) Processed() int64 {
+	return w.processed.Load()
+}
+
+// Restored is how many of their states it put back; the others had been
+// cached again by an evaluation first
+func (w *RedisWarmup) Restored() int64 {
+	return w.restored.Load()
+}
+
+// LastCompleted is when this instance last finished a warmup, or the zero time
+func (w *RedisWarmup) LastCompleted() time.Time {
+	if ts := w.lastCompleted.Load(); ts > 0 {
+		return time.Unix(ts, 0)
+	}
+	return time.Time{}
+}
+
+// InWindow reports whether Redis may still be missing state: from when the
+// sentinel was found missing until the longest heartbeat interval after it
+// was seen again, by which time every active client has been advised anew
+func (w *RedisWarmup) InWindow() bool {
+	if w == nil {
+		return false
+	}
+	return time.Now().UnixNano() < w.windowUntil.Load()
+}
+
+// Detect reports whether Redis may have lost its data, checking the
+// sentinel outside a warmup window and starting the warmup if it is missing
+func (w *RedisWarmup) Detect(ctx context.Context) bool {
+	if w == nil {
+		return false
+	}
+	if w.InWindow() {
+		return true
+	}
+	exists, err := w.redis.WarmupSentinelExists(ctx)
+	if err != nil || exists {
+		return false
+	}
+	w.openWindow()
+	select {
+	case w.check <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// openWindow opens or extends the warmup window
+func (w *RedisWarmup) openWindow() {
+	grace := time.Duration(w.cfg.Current().HeartbeatIntervalMaxSeconds) * time.Second
+	until := time.Now().Add(grace).UnixNano()
+	for {
+		current := w.windowUntil.Load()
+		if current >= until || w.windowUntil.CompareAndSwap(current, until) {
+			return
+		}
+	}
+}
+
+// AlertSentRecently reports whether the user's latest alert in Postgres is
+// still within its dedup window, restoring the dedup key if so. It stands in
+// for a missing key while Redis may have lost it.
+func (w *RedisWarmup) AlertSentRecently(ctx context.Context, userID uuid.UUID) (bool, error) {
+	alert, err := w.postgres.GetLatestAlert(ctx, userID)
+	if err != nil || alert == nil {
+		return false, err
+	}
+	remaining := alertDedupWindow - time.Since(alert.CreatedAt)
+	if remaining <= 0 {
+		return false, nil
+	}
+	if err := w.redis.RestoreAlertSent(ctx, userID, remaining); err != nil {
+		log.Printf("WARN: Failed to restore alert dedup for user %s: %v", userID, err)
+	}
+	return true, nil
+}
+
+func (w *RedisWarmup) run() {
+	defer close(w.done)
+
+	ticker := time.NewTicker(redisWarmupEvery)
+	defer ticker.Stop()
+
+	for {
+		if w.checkSentinel() {
+			w.health.Beat(redisWarmupWorker)
+		}
+		select {
+		case <-w.stop:
+			return
+		case <-ticker.C:
+		case <-w.check:
+		}
+	}
+}
+
+// checkSentinel warms Redis up if its sentinel is missing and no other
+// instance is already doing it, and reports whether the check completed
+func (w *RedisWarmup) checkSentinel() bool {
+	ctx, cancel := context.WithTimeout(context.Background(), redisWarmupEvery)
+	exists, err := w.redis.WarmupSentinelExists(ctx)
+	if err != nil {
+		cancel()
+		log.Printf("ERROR: Failed to check the Redis warmup sentinel: %v", err)
+		return false
+	}
+	if exists {
+		cancel()
+		return true
+	}
+
+	w.openWindow()
+	token := uuid.NewString()
+	claimed, err := w.redis.ClaimWarmup(ctx, token, redisWarmupLockTTL)
+	cancel()
+	if err != nil {
+		log.Printf("ERROR: Failed to claim the Redis warmup: %v", err)
+		return false
+	}
+	if !claimed {
+		return true // another instance is warming up
+	}
+	if err := w.warmup(token); err != nil {
+		log.Printf("ERROR: Redis warmup stopped after %d of %d users: %v", w.processed.Load(), w.total.Load(), err)
+	}
+	return true
+}
+
+// warmup restores every recently active user, most at risk first, at the
+// configured rate, then sets the sentinel
+func (w *RedisWarmup) warmup(token string) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-w.stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	w.running.Store(true)
+	defer w.running.Store(false)
+
+	cfg := w.cfg.Current()
+	start := time.Now()
+	users, err := w.postgres.GetWarmupUsers(ctx, start.Add(-time.Duration(cfg.RedisWarmupLookbackHours)*time.Hour), start)
+	if err != nil {
+		return err
+	}
+	w.total.Store(int64(len(users)))
+	w.processed.Store(0)
+	w.restored.Store(0)
+	log.Printf("WARN: Redis lost its data; restoring state of %d recently active users", len(users))
+
+	ticker := time.NewTicker(time.Second / time.Duration(cfg.RedisWarmupRate))
+	defer ticker.Stop()
+
+	checks := make(map[uuid.UUID]time.Time, redisWarmupBatch)
+	flush := func() error {
+		_, err := w.redis.ScheduleMissingChecks(ctx, checks)
+		clear(checks)
+		return err
+	}
+	for i, user := range users {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+		w.restore(ctx, user, cfg)
+		if !user.Paused {
+			checks[user.UserID] = firstCheckDue(user.LastHeartbeat, time.Now())
+		}
+		w.processed.Add(1)
+		w.health.Beat(redisWarmupWorker)
+
+		if (i+1)%redisWarmupBatch == 0 {
+			if err := flush(); err != nil {
+				return err
+			}
+			held, err := w.redis.RenewWarmup(ctx, token, redisWarmupLockTTL)
+			if err != nil {
+				return err
+			}
+			if !held {
+				log.Printf("WARN: Redis warmup lock lost after %d users; leaving the rest to its holder", i+1)
+				return nil
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+	if err := w.redis.FinishWarmup(ctx, token, time.Now()); err != nil {
+		return err
+	}
+	w.lastCompleted.Store(time.Now().Unix())
+	log.Printf("INFO: Redis warmup restored %d of %d users in %s", w.restored.Load(), len(users), time.Since(start).Round(time.Second))
+	return nil
+}
+
+// restore puts back the user's state and alert-dedup key, unless
+// evaluations since the data was lost have already. Failures are logged and
+// left to the user's next evaluation.
+func (w *RedisWarmup) restore(ctx context.Context, user models.WarmupUser, cfg *config.Config) {
+	state, err := restoredState(ctx, w.postgres, user.UserID, cfg, true)
+	if err != nil {
+		log.Printf("WARN: Redis warmup skipped state of user %s: %v", user.UserID, err)
+	} else if state != nil {
+		restored, err := w.redis.RestoreUserState(ctx, state)
+		if err != nil {
+			log.Printf("WARN: Redis warmup failed to restore state of user %s: %v", user.UserID, err)
+		} else if restored {
+			w.restored.Add(1)
+		}
+	}
+
+	if user.LastAlertAt != nil {
+		if remaining := alertDedupWindow - time.Since(*user.LastAlertAt); remaining > 0 {
+			if err := w.redis.RestoreAlertSent(ctx, user.UserID, remaining); err != nil {
+				log.Printf("WARN: Redis warmup failed to restore alert dedup of user %s: %v", user.UserID, err)
+			}
+		}
+	}
+}
+
+// restoredState rebuilds the user's state from their latest recorded
+// transition and heartbeat, or returns nil if they have no recorded state.
+// Postgres doesn't keep the interval the client was last advised; when
+// unknownInterval is set the allowance assumes the longest, so a client
+// following one isn't taken for stale before its next heartbeat.
+func restoredState(ctx context.Context, postgres *database.PostgresDB, userID uuid.UUID, cfg *config.Config, unknownInterval bool) (*models.UserState, error) {
+	latest, err := postgres.GetLatestUserState(ctx, userID)
+	if err != nil || latest == nil {
+		return nil, err
+	}
+	state := &models.UserState{
+		UserID:    userID,
+		State:     latest.ToState,
+		Score:     latest.Score,
+		UpdatedAt: latest.Timestamp,
+	}
+	hb, err := postgres.GetLatestHeartbeat(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if hb != nil {
+		state.LastHeartbeat = hb.Timestamp
+		state.LastTrust = HeartbeatTrust(hb)
+		state.Roaming = RoamingOf(userCountry(ctx, postgres, userID), hb.CellInfo, nil)
+	}
+	if unknownInterval {
+		state.IntervalAllowanceSeconds = cfg.HeartbeatIntervalMaxSeconds
+	}
+	return state, nil
+}
@@ -0,0 +1,196 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/config"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/database"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+)
+
+func warmupConfig() *config.Store {
+	return config.NewStore(&config.Config{HeartbeatIntervalMaxSeconds: 900, RedisWarmupRate: 10000, RedisWarmupLookbackHours: 24})
+}
+
+// A missing sentinel is a flush: it opens the warmup window and wakes the
+// warmup goroutine; while the sentinel is there nothing happens
+func TestRedisWarmupDetect(t *testing.T) {
+	var none *RedisWarmup
+	if none.Detect(context.Background()) || none.InWindow() {
+		t.Error("no warmup detects a flush")
+	}
+
+	redis := testRedis(t)
+	ctx := context.Background()
+	warm := NewRedisWarmup(warmupConfig(), nil, redis, NewHealthRegistry())
+	if err := redis.FinishWarmup(ctx, "token", time.Now()); err != nil {
+		t.Fatalf("FinishWarmup: %v", err)
+	}
+	if warm.Detect(ctx) || warm.InWindow() || len(warm.check) != 0 {
+		t.Error("flush detected with the sentinel set")
+	}
+
+	flushed := NewRedisWarmup(warmupConfig(), nil, testRedis(t), NewHealthRegistry()) // a namespace with nothing in it
+	if !flushed.Detect(ctx) {
+		t.Fatal("missing sentinel not detected")
+	}
+	if !flushed.InWindow() || len(flushed.check) != 1 {
+		t.Errorf("after detection: in window %v, %d checks queued; want true, 1", flushed.InWindow(), len(flushed.check))
+	}
+	if until := time.Unix(0, flushed.windowUntil.Load()); until.Before(time.Now().Add(14 * time.Minute)) {
+		t.Errorf("window ends %s, want the longest interval from now", until)
+	}
+	if !flushed.Detect(ctx) || len(flushed.check) != 1 {
+		t.Error("detecting again in the window queued another check")
+	}
+}
+
+// warmupUser is a user last heard from at lastHeartbeat, recorded in state
+func warmupUser(t *testing.T, postgres *database.PostgresDB, name, state string, lastHeartbeat time.Time, backfill bool) uuid.UUID {
+	t.Helper()
+	ctx := context.Background()
+	user := createTestUser(t, postgres, name)
+	hb := simulatedHeartbeat(0, false)
+	hb.UserID, hb.Timestamp, hb.Backfill = user.ID, lastHeartbeat, backfill
+	if err := postgres.CreateHeartbeat(ctx, &hb); err != nil {
+		t.Fatalf("CreateHeartbeat: %v", err)
+	}
+	transition := &models.StateTransition{UserID: user.ID, ToState: state, Score: 50, Reason: "test", TriggeredBy: "heartbeat", Timestamp: lastHeartbeat}
+	if _, err := postgres.RecordStateTransition(ctx, transition); err != nil {
+		t.Fatalf("RecordStateTransition: %v", err)
+	}
+	return user.ID
+}
+
+func createWarmupAlert(t *testing.T, postgres *database.PostgresDB, userID uuid.UUID, at time.Time, resolved bool) {
+	t.Helper()
+	ctx := context.Background()
+	alert := &models.Alert{ID: uuid.New(), UserID: userID, State: models.AlertStateAtRisk, Reasons: models.Reasons{}, SentTo: []string{}, CreatedAt: at, DetectedAt: at}
+	if err := postgres.CreateAlert(ctx, alert); err != nil {
+		t.Fatalf("CreateAlert: %v", err)
+	}
+	if resolved {
+		if err := postgres.ResolveAlert(ctx, alert.ID); err != nil {
+			t.Fatalf("ResolveAlert: %v", err)
+		}
+	}
+}
+
+// Users with an open alert are restored first, then those last AT_RISK or
+// CAUTION, then the rest, each most recently heard from first; users
+// silent past the lookback, or only backfilled, aren't restored
+func TestRedisWarmupPriority(t *testing.T) {
+	postgres := testPostgres(t)
+	redis := testRedis(t)
+	ctx := context.Background()
+	now := time.Now()
+
+	safe := warmupUser(t, postgres, "Safe", StateSafe, now.Add(-time.Minute), false)
+	caution := warmupUser(t, postgres, "Caution", StateCaution, now.Add(-3*time.Hour), false)
+	atRisk := warmupUser(t, postgres, "AtRisk", StateAtRisk, now.Add(-2*time.Hour), false)
+	alerted := warmupUser(t, postgres, "Alerted", StateSafe, now.Add(-5*time.Hour), false)
+	createWarmupAlert(t, postgres, alerted, now.Add(-2*time.Minute), false)
+	resolved := warmupUser(t, postgres, "Resolved", StateSafe, now.Add(-2*time.Minute), false)
+	createWarmupAlert(t, postgres, resolved, now.Add(-time.Hour), true)
+	silent := warmupUser(t, postgres, "Silent", StateAtRisk, now.Add(-30*time.Hour), false)
+	backfilled := warmupUser(t, postgres, "Backfilled", StateAtRisk, now.Add(-time.Hour), true)
+
+	users, err := postgres.GetWarmupUsers(ctx, now.Add(-24*time.Hour), now)
+	if err != nil {
+		t.Fatalf("GetWarmupUsers: %v", err)
+	}
+	ours := map[uuid.UUID]string{safe: "Safe", caution: "Caution", atRisk: "AtRisk", alerted: "Alerted", resolved: "Resolved", silent: "Silent", backfilled: "Backfilled"}
+	var order []string
+	for _, u := range users {
+		if name, ok := ours[u.UserID]; ok {
+			order = append(order, name)
+		}
+	}
+	want := []string{"Alerted", "AtRisk", "Caution", "Safe", "Resolved"}
+	if len(order) != len(want) {
+		t.Fatalf("warmup order = %v, want %v", order, want)
+	}
+	for i := range want {
+		if order[i] != want[i] {
+			t.Fatalf("warmup order = %v, want %v", order, want)
+		}
+	}
+
+	w := NewRedisWarmup(warmupConfig(), postgres, redis, NewHealthRegistry())
+	if !w.checkSentinel() {
+		t.Fatal("warmup check failed")
+	}
+	if exists, err := redis.WarmupSentinelExists(ctx); err != nil || !exists {
+		t.Fatalf("sentinel after the warmup = %v, %v", exists, err)
+	}
+	if w.Processed() != w.Total() || w.Total() < int64(len(want)) || w.LastCompleted().IsZero() {
+		t.Errorf("progress = %d of %d, completed %s", w.Processed(), w.Total(), w.LastCompleted())
+	}
+
+	state, err := redis.GetUserState(ctx, atRisk)
+	if err != nil || state == nil || state.State != StateAtRisk || state.IntervalAllowanceSeconds != 900 {
+		t.Errorf("restored state = %+v, %v; want AT_RISK allowing the longest interval", state, err)
+	}
+	if state, err := redis.GetUserState(ctx, silent); err != nil || state != nil {
+		t.Errorf("state of a user silent past the lookback = %+v, %v; want none", state, err)
+	}
+	// The recent alert's dedup key is back; the old one's window is over
+	if sent, err := redis.CheckAlertSent(ctx, alerted, alertDedupWindow); err != nil || !sent {
+		t.Errorf("dedup of a 2-minute-old alert = %v, %v; want restored", sent, err)
+	}
+	if sent, err := redis.CheckAlertSent(ctx, resolved, alertDedupWindow); err != nil || sent {
+		t.Errorf("dedup of an hour-old alert = %v, %v; want none", sent, err)
+	}
+}
+
+// During the warmup window a transition with no dedup key in Redis is
+// checked against Postgres, so an alert sent before the flush isn't sent
+// again
+func TestRedisWarmupSuppressesSpuriousAlert(t *testing.T) {
+	postgres := testPostgres(t)
+	redis := testRedis(t) // flushed: no sentinel, no dedup keys
+	ctx := context.Background()
+	w := NewRedisWarmup(warmupConfig(), postgres, redis, NewHealthRegistry())
+	se := &SafetyEvaluator{cfg: warmupConfig(), postgres: postgres, redis: redis, warmup: w, clock: SystemClock{}, effects: discardEffects{}}
+
+	user := createTestUser(t, postgres, "Ada")
+	createWarmupAlert(t, postgres, user.ID, time.Now().Add(-time.Minute), false)
+	before, err := postgres.GetLatestAlert(ctx, user.ID)
+	if err != nil || before == nil {
+		t.Fatalf("GetLatestAlert = %v, %v", before, err)
+	}
+
+	if !w.Detect(ctx) {
+		t.Fatal("flush not detected")
+	}
+	if err := se.handleStateTransition(ctx, user.ID, StateAtRisk, 30, []models.Reason{{Code: models.ReasonHeartbeatStale}}, true); err != nil {
+		t.Fatalf("handleStateTransition: %v", err)
+	}
+	after, err := postgres.GetLatestAlert(ctx, user.ID)
+	if err != nil || after == nil || after.ID != before.ID {
+		t.Errorf("latest alert = %+v, %v; want no new alert after %s", after, err, before.ID)
+	}
+	if sent, err := redis.CheckAlertSent(ctx, user.ID, alertDedupWindow); err != nil || !sent {
+		t.Errorf("dedup key = %v, %v; want restored from Postgres", sent, err)
+	}
+
+	// An alert older than the dedup window doesn't hold the next one back
+	old := createTestUser(t, postgres, "Bola")
+	createWarmupAlert(t, postgres, old.ID, time.Now().Add(-alertDedupWindow-time.Minute), false)
+	if recent, err := w.AlertSentRecently(ctx, old.ID); err != nil || recent {
+		t.Errorf("AlertSentRecently of an old alert = %v, %v; want false", recent, err)
+	}
+
+	// A state read back during the window allows for the longest interval
+	if _, err := postgres.RecordStateTransition(ctx, &models.StateTransition{UserID: user.ID, ToState: StateCaution, Score: 60, Reason: "test", TriggeredBy: "heartbeat", Timestamp: time.Now()}); err != nil {
+		t.Fatalf("RecordStateTransition: %v", err)
+	}
+	state, err := se.CurrentState(ctx, user.ID)
+	if err != nil || state == nil || state.State != StateCaution || state.IntervalAllowanceSeconds != 900 {
+		t.Errorf("state read back = %+v, %v; want CAUTION allowing the longest interval", state, err)
+	}
+}
@@ -0,0 +1,74 @@
+package services
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+)
+
+// referenceNearbyKm is the distance under which a contact is told the user is
+// close to their reference location, with no direction: the fixes aren't
+// precise enough for one to mean anything
+const referenceNearbyKm = 0.1
+
+// compassSectors names the eight 45° sectors of the compass, clockwise from north
+var compassSectors = [8]string{
+	"north", "north-east", "east", "south-east",
+	"south", "south-west", "west", "north-west",
+}
+
+// initialBearing returns the compass bearing, in degrees from 0 up to 360,
+// to set out on from the first point to reach the second on a great circle.
+// The sines and cosines of the longitude difference take care of the
+// antimeridian.
+func initialBearing(lat1, lon1, lat2, lon2 float64) float64 {
+	rLat1 := lat1 * math.Pi / 180
+	rLat2 := lat2 * math.Pi / 180
+	dLon := (lon2 - lon1) * math.Pi / 180
+
+	y := math.Sin(dLon) * math.Cos(rLat2)
+	x := math.Cos(rLat1)*math.Sin(rLat2) - math.Sin(rLat1)*math.Cos(rLat2)*math.Cos(dLon)
+
+	bearing := math.Mod(math.Atan2(y, x)*180/math.Pi+360, 360)
+	if bearing == 360 {
+		return 0 // a tiny negative angle rounds up
+	}
+	return bearing
+}
+
+// CompassSector names the sector of the eight-point compass a bearing falls
+// in, e.g. "north-east" from 22.5° up to 67.5°
+func CompassSector(bearing float64) string {
+	turn := math.Mod(math.Mod(bearing+22.5, 360)+360, 360)
+	return compassSectors[int(turn/45)%8]
+}
+
+// RelativePlace describes where a location is from a contact's reference
+// location, e.g. "~4 km north-east of your home". Distances are rounded to
+// what the fixes can support: 100 m steps under a kilometre, tenths under
+// ten kilometres, whole kilometres beyond.
+func RelativePlace(ref models.ContactReference, lat, lng float64) string {
+	of := "your home"
+	if ref.Label == models.ReferenceWork {
+		of = "your workplace"
+	}
+
+	km := haversineDistance(ref.Lat, ref.Lng, lat, lng)
+	if km < referenceNearbyKm {
+		return "within 100 m of " + of
+	}
+
+	var distance string
+	switch {
+	case km < 0.95:
+		distance = fmt.Sprintf("~%d m", int(math.Round(km*10))*100)
+	case km < 9.95:
+		distance = "~" + strconv.FormatFloat(math.Round(km*10)/10, 'f', -1, 64) + " km"
+	default:
+		distance = fmt.Sprintf("~%d km", int(math.Round(km)))
+	}
+	sector := CompassSector(initialBearing(ref.Lat, ref.Lng, lat, lng))
+	return fmt.Sprintf("%s %s of %s", distance, sector, of)
+}
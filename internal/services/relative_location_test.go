@@ -0,0 +1,123 @@
+package services
+
+import (
+	"math"
+	"testing"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+)
+
+// kmPerDegree is the length of a degree of latitude on haversineDistance's
+// sphere
+const kmPerDegree = 6371 * math.Pi / 180
+
+func TestCompassSector(t *testing.T) {
+	tests := []struct {
+		bearing float64
+		want    string
+	}{
+		{0, "north"},
+		{22.49, "north"},
+		{22.5, "north-east"},
+		{67.49, "north-east"},
+		{67.5, "east"},
+		{112.5, "south-east"},
+		{180, "south"},
+		{202.5, "south-west"},
+		{247.5, "west"},
+		{292.5, "north-west"},
+		{337.49, "north-west"},
+		{337.5, "north"},
+		{359.99, "north"},
+		{360, "north"},
+		{-45, "north-west"},
+		{405, "north-east"},
+	}
+	for _, tt := range tests {
+		if got := CompassSector(tt.bearing); got != tt.want {
+			t.Errorf("CompassSector(%v) = %q, want %q", tt.bearing, got, tt.want)
+		}
+	}
+}
+
+// From a home in Lagos, a user 2 km away in each of the eight directions
+func TestRelativePlaceSectors(t *testing.T) {
+	home := models.ContactReference{Label: models.ReferenceHome, Lat: 6.5244, Lng: 3.3792}
+	d := 2 / kmPerDegree
+	diag := d / math.Sqrt2
+	lngScale := 1 / math.Cos(home.Lat*math.Pi/180)
+
+	tests := []struct {
+		dLat, dLng float64
+		want       string
+	}{
+		{d, 0, "~2 km north of your home"},
+		{diag, diag * lngScale, "~2 km north-east of your home"},
+		{0, d * lngScale, "~2 km east of your home"},
+		{-diag, diag * lngScale, "~2 km south-east of your home"},
+		{-d, 0, "~2 km south of your home"},
+		{-diag, -diag * lngScale, "~2 km south-west of your home"},
+		{0, -d * lngScale, "~2 km west of your home"},
+		{diag, -diag * lngScale, "~2 km north-west of your home"},
+	}
+	for _, tt := range tests {
+		if got := RelativePlace(home, home.Lat+tt.dLat, home.Lng+tt.dLng); got != tt.want {
+			t.Errorf("RelativePlace(%+.4f, %+.4f) = %q, want %q", tt.dLat, tt.dLng, got, tt.want)
+		}
+	}
+}
+
+// Across the antimeridian the short way round is the one described
+func TestRelativePlaceAntimeridian(t *testing.T) {
+	tests := []struct {
+		ref      models.ContactReference
+		lat, lng float64
+		want     string
+	}{
+		{models.ContactReference{Lat: -17.8, Lng: 179.98}, -17.8, -179.98, "~4.2 km east of your home"},
+		{models.ContactReference{Lat: -17.8, Lng: -179.98}, -17.8, 179.98, "~4.2 km west of your home"},
+		{models.ContactReference{Lat: 0, Lng: 180}, 0.02, -179.98, "~3.1 km north-east of your home"},
+	}
+	for _, tt := range tests {
+		if got := RelativePlace(tt.ref, tt.lat, tt.lng); got != tt.want {
+			t.Errorf("RelativePlace(%v → %v, %v) = %q, want %q", tt.ref, tt.lat, tt.lng, got, tt.want)
+		}
+	}
+}
+
+// Distances are rounded to what a fix supports, and a tiny one has no
+// direction at all
+func TestRelativePlaceDistances(t *testing.T) {
+	work := models.ContactReference{Label: models.ReferenceWork, Lat: 6.4281, Lng: 3.4219}
+	tests := []struct {
+		km   float64
+		want string
+	}{
+		{0, "within 100 m of your workplace"},
+		{0.00001, "within 100 m of your workplace"},
+		{0.099, "within 100 m of your workplace"},
+		{0.101, "~100 m north of your workplace"},
+		{0.34, "~300 m north of your workplace"},
+		{0.94, "~900 m north of your workplace"},
+		{0.96, "~1 km north of your workplace"},
+		{1.44, "~1.4 km north of your workplace"},
+		{9.94, "~9.9 km north of your workplace"},
+		{9.96, "~10 km north of your workplace"},
+		{42.4, "~42 km north of your workplace"},
+	}
+	for _, tt := range tests {
+		if got := RelativePlace(work, work.Lat+tt.km/kmPerDegree, work.Lng); got != tt.want {
+			t.Errorf("RelativePlace(%v km) = %q, want %q", tt.km, got, tt.want)
+		}
+	}
+}
+
+func TestInitialBearingRange(t *testing.T) {
+	// A hair west of north must not come out as 360
+	if b := initialBearing(6.5, 3.4, 7.5, 3.4-1e-13); b < 0 || b >= 360 {
+		t.Errorf("initialBearing() = %v, want in [0, 360)", b)
+	}
+	if b := initialBearing(6.5, 3.4, 6.5, 3.5); math.Abs(b-90) > 0.01 {
+		t.Errorf("initialBearing() due east = %v", b)
+	}
+}
@@ -0,0 +1,249 @@
+package services
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/config"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/database"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+)
+
+// Responder API limits
+const (
+	ResponderKeyPrefix      = "rk_"
+	ResponderDefaultRadiusM = 3000
+	ResponderMaxRadiusM     = 50000
+	ResponderDefaultWindow  = 2 * time.Hour
+	ResponderMaxWindow      = 24 * time.Hour
+
+	// responderCandidates is how many alerts a query reads before the
+	// operating area clamp and pagination
+	responderCandidates = 500
+	// responderGridM is the grid positions are rounded to for users who
+	// haven't opted into precise sharing
+	responderGridM = 100
+	// metersPerDegreeLat is the length of a degree of latitude
+	metersPerDegreeLat = 111320
+)
+
+// ResponderAlertView is what a responder sees of an alert: no user, no
+// reason, and a position rounded to 100m unless the user opted into
+// precise sharing
+type ResponderAlertView struct {
+	AlertID        uuid.UUID         `json:"alert_id"`
+	State          models.AlertState `json:"state"`
+	Lat            float64           `json:"lat"`
+	Lng            float64           `json:"lng"`
+	Precise        bool              `json:"precise"` // false when rounded to 100m
+	RaisedAt       time.Time         `json:"raised_at"`
+	AgeSeconds     int               `json:"age_seconds"`
+	LocatedAt      time.Time         `json:"located_at"` // when the user was last seen there
+	AckToken       string            `json:"ack_token"`  // POST /responder/ack/:token to acknowledge
+	AcknowledgedAt *time.Time        `json:"acknowledged_at,omitempty"`
+}
+
+// ResponderService issues responder keys and answers their alert queries
+type ResponderService struct {
+	cfg      *config.Store
+	postgres *database.PostgresDB
+	redis    *database.RedisDB
+}
+
+func NewResponderService(cfg *config.Store, postgres *database.PostgresDB, redis *database.RedisDB) *ResponderService {
+	return &ResponderService{
+		cfg:      cfg,
+		postgres: postgres,
+		redis:    redis,
+	}
+}
+
+// ValidateOperatingArea checks a responder's operating area is a well-formed polygon
+func ValidateOperatingArea(area models.Geofence) error {
+	if area.Type != "polygon" {
+		return fmt.Errorf("must be a polygon geofence")
+	}
+	return ValidateGeofence(area)
+}
+
+// IssueKey creates a key for a responder organization. The key is returned
+// only here; just its hash is stored.
+func (s *ResponderService) IssueKey(ctx context.Context, organization string, area models.Geofence) (*models.ResponderKey, string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, "", err
+	}
+	raw := ResponderKeyPrefix + hex.EncodeToString(secret)
+
+	key := &models.ResponderKey{
+		ID:            uuid.New(),
+		Organization:  organization,
+		KeyPrefix:     raw[:len(ResponderKeyPrefix)+8],
+		OperatingArea: area,
+		CreatedAt:     time.Now(),
+	}
+	if err := s.postgres.CreateResponderKey(ctx, key, HashResponderKey(raw)); err != nil {
+		return nil, "", err
+	}
+	return key, raw, nil
+}
+
+// HashResponderKey is how a responder key is stored and looked up
+func HashResponderKey(raw string) string {
+	sum := sha256.Sum256([]byte(raw))
+	return hex.EncodeToString(sum[:])
+}
+
+// Authenticate returns the active key matching raw, or nil
+func (s *ResponderService) Authenticate(ctx context.Context, raw string) (*models.ResponderKey, error) {
+	if !strings.HasPrefix(raw, ResponderKeyPrefix) {
+		return nil, nil
+	}
+	key, err := s.postgres.GetResponderKeyByHash(ctx, HashResponderKey(raw))
+	if err != nil || key == nil {
+		return nil, err
+	}
+	if err := s.postgres.TouchResponderKey(ctx, key.ID); err != nil {
+		log.Printf("WARN: Failed to record use of responder key %s: %v", key.ID, err)
+	}
+	return key, nil
+}
+
+// AllowRequest counts a request against the key's per-minute limit
+func (s *ResponderService) AllowRequest(ctx context.Context, key *models.ResponderKey) (bool, error) {
+	return s.redis.ClaimResponderRequest(ctx, key.ID, time.Minute, s.cfg.Current().ResponderRateLimitPerMinute)
+}
+
+// FindAlerts returns one page of the unresolved alerts raised since since
+// whose user was last seen within radiusM of lat, lng and inside the key's
+// operating area, newest first, with the total across pages. Only users who
+// consented to responder sharing under the current policy are found.
+func (s *ResponderService) FindAlerts(ctx context.Context, key *models.ResponderKey, lat, lng, radiusM float64, since time.Time, limit, offset int) ([]ResponderAlertView, int, error) {
+	minLat, maxLat, minLng, maxLng := GeofenceBounds(key.OperatingArea)
+	candidates, err := s.postgres.FindResponderAlerts(ctx, models.ResponderAlertQuery{
+		KeyID:   key.ID,
+		Lat:     lat,
+		Lng:     lng,
+		RadiusM: radiusM,
+		Since:   since,
+		MinLat:  minLat,
+		MaxLat:  maxLat,
+		MinLng:  minLng,
+		MaxLng:  maxLng,
+		Limit:   responderCandidates,
+
+		ConsentVersion: s.cfg.Current().ConsentPolicyVersions[models.ConsentResponderSharing],
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	alerts := ClampToOperatingArea(candidates, key.OperatingArea)
+	total := len(alerts)
+	if offset >= total {
+		return []ResponderAlertView{}, total, nil
+	}
+	alerts = alerts[offset:min(offset+limit, total)]
+
+	now := time.Now()
+	secret := s.cfg.Current().JWTSecret
+	views := make([]ResponderAlertView, 0, len(alerts))
+	for _, a := range alerts {
+		view := NewResponderAlertView(a, now)
+		view.AckToken = ResponderAckToken(key.ID, a.AlertID, secret)
+		views = append(views, view)
+	}
+	return views, total, nil
+}
+
+// ClampToOperatingArea keeps the alerts whose position lies inside the
+// operating area. A query's radius never reaches past it.
+func ClampToOperatingArea(alerts []models.ResponderAlert, area models.Geofence) []models.ResponderAlert {
+	kept := make([]models.ResponderAlert, 0, len(alerts))
+	for _, a := range alerts {
+		if GeofenceContains(area, a.Lat, a.Lng) {
+			kept = append(kept, a)
+		}
+	}
+	return kept
+}
+
+// NewResponderAlertView shows an alert to a responder, rounding its position
+// to the 100m grid unless the user opted into precise sharing
+func NewResponderAlertView(a models.ResponderAlert, now time.Time) ResponderAlertView {
+	lat, lng := a.Lat, a.Lng
+	if !a.Precise {
+		lat, lng = RoundToGrid(lat, lng, responderGridM)
+	}
+	return ResponderAlertView{
+		AlertID:        a.AlertID,
+		State:          a.State,
+		Lat:            lat,
+		Lng:            lng,
+		Precise:        a.Precise,
+		RaisedAt:       a.CreatedAt,
+		AgeSeconds:     int(now.Sub(a.CreatedAt).Seconds()),
+		LocatedAt:      a.LocatedAt,
+		AcknowledgedAt: a.AcknowledgedAt,
+	}
+}
+
+// RoundToGrid snaps a position to the nearest point of a grid with the
+// given spacing, so nearby positions all report the same point
+func RoundToGrid(lat, lng, meters float64) (float64, float64) {
+	latStep := meters / metersPerDegreeLat
+	lat = math.Round(lat/latStep) * latStep
+	lngStep := meters / (metersPerDegreeLat * math.Max(math.Cos(lat*math.Pi/180), 0.01))
+	lng = math.Round(lng/lngStep) * lngStep
+	return lat, lng
+}
+
+// ResponderAckToken is the callback token a key acknowledges an alert with:
+// the alert ID and a signature binding it to the key, so no state is kept
+// until the acknowledgment arrives and one key's token is useless to another
+func ResponderAckToken(keyID, alertID uuid.UUID, secret string) string {
+	return alertID.String() + "." + responderAckSignature(keyID, alertID, secret)
+}
+
+// ParseResponderAckToken returns the alert a token acknowledges if it was
+// issued to keyID
+func ParseResponderAckToken(token string, keyID uuid.UUID, secret string) (uuid.UUID, bool) {
+	raw, signature, ok := strings.Cut(token, ".")
+	if !ok {
+		return uuid.Nil, false
+	}
+	alertID, err := uuid.Parse(raw)
+	if err != nil {
+		return uuid.Nil, false
+	}
+	expected := responderAckSignature(keyID, alertID, secret)
+	return alertID, hmac.Equal([]byte(signature), []byte(expected))
+}
+
+func responderAckSignature(keyID, alertID uuid.UUID, secret string) string {
+	h := hmac.New(sha256.New, []byte(secret))
+	h.Write([]byte("responder-ack|" + keyID.String() + "|" + alertID.String()))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Acknowledge records that the key's responder has seen the alert. It
+// returns the alert's user, or nil if the token is not the key's or the
+// alert is gone or resolved.
+func (s *ResponderService) Acknowledge(ctx context.Context, key *models.ResponderKey, token string) (uuid.UUID, *uuid.UUID, error) {
+	alertID, ok := ParseResponderAckToken(token, key.ID, s.cfg.Current().JWTSecret)
+	if !ok {
+		return uuid.Nil, nil, nil
+	}
+	userID, err := s.postgres.AcknowledgeResponderAlert(ctx, alertID, key.ID)
+	return alertID, userID, err
+}
@@ -0,0 +1,160 @@
+package services
+
+import (
+	"encoding/json"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+)
+
+// An L-shaped operating area over Lagos: its bounding box includes the
+// notch at the top right, which the area itself doesn't
+var lagosArea = models.Geofence{Type: "polygon", Points: []models.GeoPoint{
+	{Lat: 6.40, Lng: 3.30},
+	{Lat: 6.40, Lng: 3.50},
+	{Lat: 6.50, Lng: 3.50},
+	{Lat: 6.50, Lng: 3.40},
+	{Lat: 6.60, Lng: 3.40},
+	{Lat: 6.60, Lng: 3.30},
+}}
+
+func responderAlert(lat, lng float64, precise bool) models.ResponderAlert {
+	return models.ResponderAlert{AlertID: uuid.New(), UserID: uuid.New(), State: models.AlertStateAtRisk, Lat: lat, Lng: lng, Precise: precise}
+}
+
+// A query's radius and bounding box never reach past the operating area
+func TestClampToOperatingArea(t *testing.T) {
+	inArm := responderAlert(6.55, 3.35, false)     // in the upright arm
+	inFoot := responderAlert(6.45, 3.45, true)     // in the foot
+	inNotch := responderAlert(6.55, 3.45, false)   // inside the bounding box, outside the area
+	outside := responderAlert(6.70, 3.35, false)   // north of it
+	elsewhere := responderAlert(9.07, 7.49, false) // Abuja
+
+	got := ClampToOperatingArea([]models.ResponderAlert{inNotch, inArm, outside, inFoot, elsewhere}, lagosArea)
+	if want := []models.ResponderAlert{inArm, inFoot}; !reflect.DeepEqual(got, want) {
+		t.Errorf("clamped = %v, want the two inside the area in order", got)
+	}
+
+	minLat, maxLat, minLng, maxLng := GeofenceBounds(lagosArea)
+	if minLat != 6.40 || maxLat != 6.60 || minLng != 3.30 || maxLng != 3.50 {
+		t.Errorf("bounds = %f..%f, %f..%f", minLat, maxLat, minLng, maxLng)
+	}
+
+	if got := ClampToOperatingArea(nil, lagosArea); got == nil || len(got) != 0 {
+		t.Errorf("clamp of nothing = %#v, want an empty list", got)
+	}
+}
+
+func TestValidateOperatingArea(t *testing.T) {
+	if err := ValidateOperatingArea(lagosArea); err != nil {
+		t.Errorf("L-shaped area: %v", err)
+	}
+	radius := models.Geofence{Type: "radius", Center: &models.GeoPoint{Lat: 6.5, Lng: 3.4}, RadiusM: 3000}
+	if err := ValidateOperatingArea(radius); err == nil {
+		t.Error("radius area accepted")
+	}
+	if err := ValidateOperatingArea(models.Geofence{Type: "polygon", Points: lagosArea.Points[:2]}); err == nil {
+		t.Error("two-point polygon accepted")
+	}
+}
+
+// Rounding moves a position at most half a grid cell's diagonal, gives
+// nearby positions the same point, and leaves a rounded one where it is
+func TestRoundToGrid(t *testing.T) {
+	maxShiftM := responderGridM * math.Sqrt2 / 2
+	for _, p := range []models.GeoPoint{
+		{Lat: 6.524379, Lng: 3.379206},   // Lagos
+		{Lat: 12.002179, Lng: 8.591956},  // Kano
+		{Lat: 0.000412, Lng: -0.000377},  // by Null Island
+		{Lat: -33.868820, Lng: 151.2093}, // Sydney
+		{Lat: 64.146582, Lng: -21.94265}, // Reykjavik, where a degree of longitude is short
+	} {
+		lat, lng := RoundToGrid(p.Lat, p.Lng, responderGridM)
+		if shift := haversineDistance(p.Lat, p.Lng, lat, lng) * 1000; shift > maxShiftM+1 {
+			t.Errorf("%v moved %.0fm, more than %.0fm", p, shift, maxShiftM)
+		}
+		if lat == p.Lat && lng == p.Lng {
+			t.Errorf("%v not rounded", p)
+		}
+		again, againLng := RoundToGrid(lat, lng, responderGridM)
+		if math.Abs(again-lat) > 1e-9 || math.Abs(againLng-lng) > 1e-9 {
+			t.Errorf("%v rounds to %f,%f, then to %f,%f", p, lat, lng, again, againLng)
+		}
+	}
+
+	// About 20m apart near the middle of one cell
+	step := float64(responderGridM) / metersPerDegreeLat
+	center := math.Round(6.5244/step) * step
+	aLat, aLng := RoundToGrid(center+0.0001, 3.3792, responderGridM)
+	bLat, bLng := RoundToGrid(center-0.0001, 3.3792, responderGridM)
+	if aLat != bLat || aLng != bLng {
+		t.Errorf("neighbours round to %f,%f and %f,%f", aLat, aLng, bLat, bLng)
+	}
+	// 300m apart never share a point
+	cLat, _ := RoundToGrid(center+3*step, 3.3792, responderGridM)
+	if cLat == aLat {
+		t.Errorf("positions 300m apart both round to %f", cLat)
+	}
+}
+
+// Only a user who opted into precise sharing is shown where they are
+func TestResponderAlertViewPrecision(t *testing.T) {
+	now := time.Date(2026, 3, 9, 22, 0, 0, 0, time.UTC)
+
+	for _, precise := range []bool{true, false} {
+		a := responderAlert(6.524379, 3.379206, precise)
+		a.CreatedAt = now.Add(-90 * time.Second)
+		a.LocatedAt = now.Add(-2 * time.Minute)
+		view := NewResponderAlertView(a, now)
+
+		if view.Precise != precise || view.AlertID != a.AlertID || view.AgeSeconds != 90 || !view.LocatedAt.Equal(a.LocatedAt) {
+			t.Errorf("precise %t: view = %+v", precise, view)
+		}
+		exact := view.Lat == a.Lat && view.Lng == a.Lng
+		if exact != precise {
+			t.Errorf("precise %t: shown at %f,%f for %f,%f", precise, view.Lat, view.Lng, a.Lat, a.Lng)
+		}
+		if !precise {
+			lat, lng := RoundToGrid(a.Lat, a.Lng, responderGridM)
+			if view.Lat != lat || view.Lng != lng {
+				t.Errorf("rounded to %f,%f, want the %dm grid's %f,%f", view.Lat, view.Lng, responderGridM, lat, lng)
+			}
+		}
+
+		data, err := json.Marshal(view)
+		if err != nil {
+			t.Fatalf("Marshal: %v", err)
+		}
+		if strings.Contains(string(data), a.UserID.String()) || strings.Contains(string(data), "user") {
+			t.Errorf("view names the user: %s", data)
+		}
+	}
+}
+
+func TestResponderAckToken(t *testing.T) {
+	const ackSecret = "test-secret"
+	keyID, alertID := uuid.New(), uuid.New()
+	token := ResponderAckToken(keyID, alertID, ackSecret)
+
+	if got, ok := ParseResponderAckToken(token, keyID, ackSecret); !ok || got != alertID {
+		t.Errorf("own token = %s, %t", got, ok)
+	}
+	if _, ok := ParseResponderAckToken(token, uuid.New(), ackSecret); ok {
+		t.Error("another key's token accepted")
+	}
+	if _, ok := ParseResponderAckToken(token, keyID, "other-secret"); ok {
+		t.Error("token accepted under another secret")
+	}
+	_, signature, _ := strings.Cut(token, ".")
+	for _, bad := range []string{uuid.New().String() + "." + signature, alertID.String(), "not-a-uuid." + signature, ""} {
+		if _, ok := ParseResponderAckToken(bad, keyID, ackSecret); ok {
+			t.Errorf("token %q accepted", bad)
+		}
+	}
+}
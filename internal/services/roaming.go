@@ -0,0 +1,117 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/google/uuid"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/config"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/country"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/database"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+)
+
+// mccCountries names the countries users are likely to roam into: Nigeria's
+// neighbours first, then the rest of West and Central Africa and the usual
+// travel destinations. Codes not listed are shown as
+// "another country (MCC <code>)".
+var mccCountries = map[int]string{
+	621: "Nigeria", 616: "Benin", 614: "Niger", 624: "Cameroon", 622: "Chad",
+
+	615: "Togo", 620: "Ghana", 612: "Côte d'Ivoire", 613: "Burkina Faso",
+	610: "Mali", 608: "Senegal", 607: "Gambia", 611: "Guinea", 618: "Liberia",
+	619: "Sierra Leone", 632: "Guinea-Bissau", 625: "Cape Verde", 609: "Mauritania",
+	627: "Equatorial Guinea", 628: "Gabon", 629: "Congo", 630: "DR Congo",
+	623: "Central African Republic", 626: "São Tomé and Príncipe",
+
+	602: "Egypt", 603: "Algeria", 604: "Morocco", 639: "Kenya", 640: "Tanzania",
+	641: "Uganda", 636: "Ethiopia", 635: "Rwanda", 655: "South Africa",
+	234: "United Kingdom", 235: "United Kingdom", 310: "United States", 311: "United States",
+	312: "United States", 313: "United States", 314: "United States", 315: "United States",
+	316: "United States", 302: "Canada", 208: "France", 262: "Germany", 204: "Netherlands",
+	222: "Italy", 214: "Spain", 424: "United Arab Emirates", 420: "Saudi Arabia",
+	427: "Qatar", 286: "Turkey", 460: "China", 404: "India", 405: "India",
+}
+
+// RoamingOf returns where a cell reading says the phone of a user from home
+// is roaming, or nil if it's on one of home's networks. An unknown MCC keeps
+// the previous verdict: a heartbeat over Wi-Fi or from a phone without a SIM
+// says nothing about it.
+func RoamingOf(home *country.Profile, cell models.CellInfo, previous *models.Roaming) *models.Roaming {
+	if cell.MCC == 0 {
+		return previous
+	}
+	if home.HomeNetwork(cell.MCC) {
+		return nil
+	}
+	name, ok := mccCountries[cell.MCC]
+	if !ok {
+		name = fmt.Sprintf("another country (MCC %d)", cell.MCC)
+	}
+	return &models.Roaming{MCC: cell.MCC, Country: name}
+}
+
+// roamingAfter is RoamingOf for a user's state, carrying over the previous
+// state's verdict
+func roamingAfter(home *country.Profile, cell models.CellInfo, prev *models.UserState) *models.Roaming {
+	if prev == nil {
+		return RoamingOf(home, cell, nil)
+	}
+	return RoamingOf(home, cell, prev.Roaming)
+}
+
+// IsRoaming reports whether a cell reading is from a network foreign to a
+// user from home
+func IsRoaming(home *country.Profile, cell models.CellInfo) bool {
+	return cell.MCC != 0 && !home.HomeNetwork(cell.MCC)
+}
+
+// OutsideDeployment reports whether a cell reading is from a network of none
+// of the countries the deployment serves, whose cells it can't place well
+func OutsideDeployment(cell models.CellInfo) bool {
+	return cell.MCC != 0 && country.Active().ForMCC(cell.MCC) == nil
+}
+
+// userCountry returns the country of the user: the home country of a
+// single-country deployment, otherwise the one of their phone number. A
+// failed lookup falls back to the home country.
+func userCountry(ctx context.Context, postgres *database.PostgresDB, userID uuid.UUID) *country.Profile {
+	countries := country.Active()
+	if len(countries) == 1 {
+		return countries.Home()
+	}
+	user, err := postgres.GetUserByID(ctx, userID)
+	if err != nil {
+		log.Printf("WARN: Country of user %s unknown, taking the home country: %v", userID, err)
+		return countries.Home()
+	}
+	if user == nil {
+		return countries.Home()
+	}
+	return countries.ForPhone(user.Phone)
+}
+
+// RoamingNote is how status and alert messages describe a roaming user
+func RoamingNote(roaming *models.Roaming) string {
+	if roaming == nil {
+		return ""
+	}
+	return "currently roaming in " + roaming.Country
+}
+
+// RoamingSMSSuppressed reports whether texts to the user are held back
+// because ROAMING_SMS_SUPPRESSED is on and their phone is roaming, where
+// each one is an international SMS. A state lookup failure lets texts through.
+func RoamingSMSSuppressed(ctx context.Context, cfg *config.Store, redis *database.RedisDB, userID uuid.UUID) bool {
+	if !cfg.Current().RoamingSMSSuppressed {
+		return false
+	}
+	state, err := redis.GetUserState(ctx, userID)
+	if err != nil {
+		log.Printf("WARN: Roaming check for user %s failed, texting anyway: %v", userID, err)
+		return false
+	}
+	return state != nil && state.Roaming != nil
+}
@@ -0,0 +1,194 @@
+package services
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/config"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/country"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+)
+
+// neighbourMCCs are the networks of the countries bordering Nigeria
+var neighbourMCCs = map[int]string{616: "Benin", 614: "Niger", 624: "Cameroon", 622: "Chad"}
+
+// roamingHeartbeat is simulatedHeartbeat on another network
+func roamingHeartbeat(mcc int) models.Heartbeat {
+	hb := simulatedHeartbeat(0, false)
+	hb.CellInfo.MCC = mcc
+	return hb
+}
+
+func TestRoamingOf(t *testing.T) {
+	home := country.Active().Home()
+	benin := &models.Roaming{MCC: 616, Country: "Benin"}
+
+	for mcc, name := range neighbourMCCs {
+		hb := roamingHeartbeat(mcc)
+		got := RoamingOf(home, hb.CellInfo, nil)
+		if got == nil || got.MCC != mcc || got.Country != name {
+			t.Errorf("MCC %d: got %+v, want roaming in %s", mcc, got, name)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		mcc      int
+		previous *models.Roaming
+		want     *models.Roaming
+	}{
+		{"home network", 621, nil, nil},
+		{"back home", 621, benin, nil},
+		{"no cell reading keeps the verdict", 0, benin, benin},
+		{"no cell reading at home", 0, nil, nil},
+		{"neighbour replaces the verdict", 614, benin, &models.Roaming{MCC: 614, Country: "Niger"}},
+		{"unknown network", 999, nil, &models.Roaming{MCC: 999, Country: "another country (MCC 999)"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := RoamingOf(home, models.CellInfo{MCC: tt.mcc}, tt.previous)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRoamingAfter(t *testing.T) {
+	home := country.Active().Home()
+	prev := &models.UserState{Roaming: &models.Roaming{MCC: 624, Country: "Cameroon"}}
+
+	if got := roamingAfter(home, models.CellInfo{}, prev); got != prev.Roaming {
+		t.Errorf("no cell reading: got %+v, want the previous verdict", got)
+	}
+	if got := roamingAfter(home, models.CellInfo{}, nil); got != nil {
+		t.Errorf("no state: got %+v, want nil", got)
+	}
+	if got := roamingAfter(home, models.CellInfo{MCC: 621}, prev); got != nil {
+		t.Errorf("home network: got %+v, want nil", got)
+	}
+}
+
+func TestIsRoamingOutsideDeployment(t *testing.T) {
+	home := country.Active().Home()
+	for mcc := range neighbourMCCs {
+		cell := roamingHeartbeat(mcc).CellInfo
+		if !IsRoaming(home, cell) {
+			t.Errorf("MCC %d: not roaming", mcc)
+		}
+		if !OutsideDeployment(cell) {
+			t.Errorf("MCC %d: inside the deployment", mcc)
+		}
+	}
+	for _, mcc := range []int{0, 621} {
+		cell := models.CellInfo{MCC: mcc}
+		if IsRoaming(home, cell) {
+			t.Errorf("MCC %d: roaming", mcc)
+		}
+		if OutsideDeployment(cell) {
+			t.Errorf("MCC %d: outside the deployment", mcc)
+		}
+	}
+}
+
+// In a Nigeria and Ghana deployment each user roams off their own country's
+// networks, and the other country's are placed and named like home's
+func TestRoamingByCountry(t *testing.T) {
+	previous := country.Active()
+	t.Cleanup(func() { country.Use(previous) })
+	countries, err := country.Select([]string{"NG", "GH"})
+	if err != nil {
+		t.Fatalf("Select: %v", err)
+	}
+	country.Use(countries)
+
+	tests := []struct {
+		phone    string
+		mcc      int
+		roaming  string // country roamed into, "" at home
+		outside  bool
+		mnc      int
+		carrier  Carrier
+		numberOf Carrier
+	}{
+		{"+2348031234567", 621, "", false, 30, "MTN", "MTN"},
+		{"+2348031234567", 620, "Ghana", false, 2, "TELECEL", "MTN"},
+		{"+233201234567", 620, "", false, 1, "MTN", "TELECEL"},
+		{"+233201234567", 621, "Nigeria", false, 50, "GLO", "TELECEL"},
+		{"+233201234567", 616, "Benin", true, 1, CarrierUnknown, "TELECEL"},
+		{"+447700900123", 621, "", false, 20, "AIRTEL", CarrierUnknown},
+	}
+	for _, tt := range tests {
+		home := countries.ForPhone(tt.phone)
+		cell := models.CellInfo{MCC: tt.mcc, MNC: tt.mnc}
+		got := RoamingOf(home, cell, nil)
+		if (got == nil) != (tt.roaming == "") || (got != nil && got.Country != tt.roaming) {
+			t.Errorf("%s on MCC %d: roaming %+v, want %q", tt.phone, tt.mcc, got, tt.roaming)
+		}
+		if IsRoaming(home, cell) != (tt.roaming != "") {
+			t.Errorf("%s on MCC %d: IsRoaming disagrees with RoamingOf", tt.phone, tt.mcc)
+		}
+		if OutsideDeployment(cell) != tt.outside {
+			t.Errorf("MCC %d: outside the deployment %v, want %v", tt.mcc, !tt.outside, tt.outside)
+		}
+		if carrier := NetworkCarrier(tt.mcc, tt.mnc); carrier != tt.carrier {
+			t.Errorf("network %d-%d = %s, want %s", tt.mcc, tt.mnc, carrier, tt.carrier)
+		}
+		if carrier := DetectCarrier(tt.phone); carrier != tt.numberOf {
+			t.Errorf("DetectCarrier(%s) = %s, want %s", tt.phone, carrier, tt.numberOf)
+		}
+	}
+}
+
+func TestRoamingNote(t *testing.T) {
+	if got := RoamingNote(&models.Roaming{MCC: 616, Country: "Benin"}); got != "currently roaming in Benin" {
+		t.Errorf("got %q", got)
+	}
+	if got := RoamingNote(nil); got != "" {
+		t.Errorf("not roaming: got %q, want empty", got)
+	}
+}
+
+// Crossing a border changes nothing about how safe a heartbeat looks
+func TestRoamingScoresAsHome(t *testing.T) {
+	cfg := simulationConfig()
+	profile := DefaultScoringProfile(cfg)
+	se := &SafetyEvaluator{cfg: config.NewStore(cfg), clock: NewFakeClock(simulationStart.Add(2 * time.Minute)), effects: discardEffects{}}
+
+	hb := roamingHeartbeat(621)
+	want := se.Assess(&hb, nil, profile)
+	for mcc := range neighbourMCCs {
+		hb := roamingHeartbeat(mcc)
+		got := se.Assess(&hb, nil, profile)
+		if got.State != want.State || got.Score != want.Score || !reflect.DeepEqual(got.Breakdown, want.Breakdown) {
+			t.Errorf("MCC %d: got %s %d %v, want %s %d %v", mcc,
+				got.State, got.Score, got.Breakdown, want.State, want.Score, want.Breakdown)
+		}
+	}
+}
+
+// failingLocator fails the test if a cell is looked up
+type failingLocator struct{ t *testing.T }
+
+func (l failingLocator) LocateCell(ctx context.Context, cell models.CellInfo) (*CellLocation, error) {
+	l.t.Errorf("cell lookup for MCC %d", cell.MCC)
+	return nil, nil
+}
+
+// A foreign tower can't be placed well enough to say a fix is spoofed
+func TestSpoofCheckSkipsForeignTowers(t *testing.T) {
+	postgres := testPostgres(t)
+	user := createTestUser(t, postgres, "Roaming")
+	detector := NewSpoofDetector(postgres, failingLocator{t})
+
+	for mcc := range neighbourMCCs {
+		hb := roamingHeartbeat(mcc)
+		hb.UserID = user.ID
+		detector.Inspect(context.Background(), &hb)
+		if hb.SpoofSuspected {
+			t.Errorf("MCC %d: suspected: %v", mcc, hb.SpoofReasons)
+		}
+	}
+}
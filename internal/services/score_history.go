@@ -0,0 +1,97 @@
+package services
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/config"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/database"
+)
+
+const (
+	scoreHistoryPrunerWorker = "score_history_pruner"
+	scoreHistoryPruneEvery   = time.Hour
+)
+
+// ScoreHistoryPruner deletes score history and shadow results older than
+// SCORE_HISTORY_RETENTION_HOURS. The retention is re-read on every pass, so
+// a config reload takes effect at the next prune.
+type ScoreHistoryPruner struct {
+	cfg      *config.Store
+	postgres *database.PostgresDB
+	health   *HealthRegistry
+
+	closeOnce sync.Once
+	stop      chan struct{}
+	done      chan struct{}
+}
+
+func NewScoreHistoryPruner(cfg *config.Store, postgres *database.PostgresDB, health *HealthRegistry) *ScoreHistoryPruner {
+	return &ScoreHistoryPruner{
+		cfg:      cfg,
+		postgres: postgres,
+		health:   health,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// Start launches the pruning goroutine; the first pass runs immediately
+func (p *ScoreHistoryPruner) Start() {
+	p.health.Register(scoreHistoryPrunerWorker, scoreHistoryPruneEvery)
+	go p.run()
+}
+
+// Close stops the pruner and waits for a running pass to finish
+func (p *ScoreHistoryPruner) Close() {
+	p.closeOnce.Do(func() {
+		close(p.stop)
+	})
+	<-p.done
+}
+
+func (p *ScoreHistoryPruner) run() {
+	defer close(p.done)
+
+	ticker := time.NewTicker(scoreHistoryPruneEvery)
+	defer ticker.Stop()
+
+	for {
+		if p.prune() {
+			p.health.Beat(scoreHistoryPrunerWorker)
+		}
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// prune deletes expired records and reports whether it succeeded
+func (p *ScoreHistoryPruner) prune() bool {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	retention := time.Duration(p.cfg.Current().ScoreHistoryRetentionHours) * time.Hour
+	deleted, err := p.postgres.DeleteScoreHistoryBefore(ctx, time.Now().Add(-retention))
+	if err != nil {
+		log.Printf("ERROR: Failed to prune score history: %v", err)
+		return false
+	}
+	if deleted > 0 {
+		log.Printf("INFO: Pruned %d score history records older than %s", deleted, retention)
+	}
+
+	deleted, err = p.postgres.DeleteShadowResultsBefore(ctx, time.Now().Add(-retention))
+	if err != nil {
+		log.Printf("ERROR: Failed to prune shadow results: %v", err)
+		return false
+	}
+	if deleted > 0 {
+		log.Printf("INFO: Pruned %d shadow results older than %s", deleted, retention)
+	}
+	return true
+}
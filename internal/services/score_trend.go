@@ -0,0 +1,84 @@
+package services
+
+import "github.com/adedejiosvaldo/safetrace/backend/internal/models"
+
+// ApplyTrend penalizes a scored result when the user's recent scores show a
+// sustained decline. history holds the previous instantaneous scores, oldest
+// first. Like suspected spoofing, a trend can lower SAFE to CAUTION (a silent
+// check-in) but never pushes a result to AT_RISK on its own.
+func ApplyTrend(result *EvaluationResult, history []int, profile ScoringProfile) {
+	if result.Deterministic || profile.TrendWindow < 3 || profile.TrendPenalty <= 0 {
+		return
+	}
+	if len(history) < profile.TrendWindow-1 {
+		return
+	}
+
+	series := make([]int, 0, profile.TrendWindow)
+	series = append(series, history[len(history)-(profile.TrendWindow-1):]...)
+	series = append(series, result.Score)
+	if !deteriorating(series, profile.TrendSlope) {
+		return
+	}
+
+	penalty := min(profile.TrendPenalty, result.Score)
+	result.Score -= penalty
+	result.TrendPenalty = penalty
+	if result.Breakdown == nil {
+		result.Breakdown = map[string]int{}
+	}
+	result.Breakdown["trend"] = -penalty
+
+	if result.State == StateSafe && result.Score < profile.SafeThreshold {
+		result.State = StateCaution
+		reasons := append([]models.Reason{}, result.Reasons...)
+		for i := range reasons {
+			if reasons[i].Code == models.ReasonScoreNormal {
+				reasons[i] = models.Reason{Code: models.ReasonScoreConcerning}
+			}
+		}
+		result.setReasons(reasons...)
+	}
+	result.addReason(models.Reason{Code: models.ReasonDeterioratingTrend, Params: map[string]interface{}{"penalty": penalty}})
+}
+
+// deteriorating reports whether scores fall steadily: the least-squares slope
+// is at least minSlope points per evaluation downward, most steps are
+// declines, and the series ends lower than it started. A noisy but flat
+// series fails the slope test; one sharp drop fails the step count.
+func deteriorating(scores []int, minSlope float64) bool {
+	n := len(scores)
+	if n < 3 || scores[n-1] >= scores[0] {
+		return false
+	}
+
+	declines := 0
+	for i := 1; i < n; i++ {
+		if scores[i] < scores[i-1] {
+			declines++
+		}
+	}
+	if declines*2 <= n-1 {
+		return false
+	}
+
+	return scoreSlope(scores) <= -minSlope
+}
+
+// scoreSlope fits a least-squares line through the scores at x = 0..n-1
+func scoreSlope(scores []int) float64 {
+	n := float64(len(scores))
+	var sumX, sumY, sumXY, sumXX float64
+	for i, s := range scores {
+		x, y := float64(i), float64(s)
+		sumX += x
+		sumY += y
+		sumXY += x * y
+		sumXX += x * x
+	}
+	denom := n*sumXX - sumX*sumX
+	if denom == 0 {
+		return 0
+	}
+	return (n*sumXY - sumX*sumY) / denom
+}
@@ -0,0 +1,136 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+)
+
+func TestDeteriorating(t *testing.T) {
+	tests := []struct {
+		name   string
+		scores []int
+		want   bool
+	}{
+		{"decay from 95 to 55 over two hours", []int{95, 90, 85, 80, 75, 70, 65, 60, 55}, true},
+		{"slow decay while still SAFE", []int{100, 98, 96, 94, 92, 90, 88, 86}, true},
+		{"one step from 95 to 55", []int{95, 95, 95, 95, 95, 95, 95, 55}, false},
+		{"noisy but stable", []int{90, 86, 91, 85, 90, 86, 91, 87}, false},
+		{"jitter ending a point lower", []int{90, 89, 90, 89, 90, 89, 90, 88}, false},
+		{"drifting up", []int{80, 82, 81, 84, 83, 86, 85, 88}, false},
+		{"dip and recovery", []int{95, 85, 75, 65, 75, 85, 90}, false},
+		{"too short", []int{95, 55}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := deteriorating(tt.scores, 1); got != tt.want {
+				t.Errorf("deteriorating(%v) = %v, want %v (slope %.2f)", tt.scores, got, tt.want, scoreSlope(tt.scores))
+			}
+		})
+	}
+}
+
+func trendProfile() ScoringProfile {
+	return ScoringProfile{SafeThreshold: 80, CautionThreshold: 50, TrendWindow: 8, TrendSlope: 1, TrendPenalty: 10}
+}
+
+func safeResult(score int) *EvaluationResult {
+	result := &EvaluationResult{State: StateSafe, Score: score, Breakdown: map[string]int{"recency": 30}}
+	result.setReasons(models.Reason{Code: models.ReasonScoreNormal})
+	return result
+}
+
+func hasReason(result *EvaluationResult, code string) bool {
+	for _, r := range result.Reasons {
+		if r.Code == code {
+			return true
+		}
+	}
+	return false
+}
+
+// A score still above the SAFE threshold that has been falling steadily is
+// penalized into a silent CAUTION, with the trend given as a reason
+func TestApplyTrendDecay(t *testing.T) {
+	result := safeResult(86)
+	ApplyTrend(result, []int{100, 98, 96, 94, 92, 90, 88}, trendProfile())
+
+	if result.Score != 76 || result.TrendPenalty != 10 || result.Breakdown["trend"] != -10 {
+		t.Errorf("score %d, penalty %d, breakdown %v, want 76 after a 10 point penalty", result.Score, result.TrendPenalty, result.Breakdown)
+	}
+	if result.State != StateCaution {
+		t.Errorf("state = %s, want CAUTION", result.State)
+	}
+	if !hasReason(result, models.ReasonDeterioratingTrend) || !hasReason(result, models.ReasonScoreConcerning) || hasReason(result, models.ReasonScoreNormal) {
+		t.Errorf("reasons = %+v", result.Reasons)
+	}
+}
+
+// Noise around a steady level is left alone
+func TestApplyTrendNoisy(t *testing.T) {
+	result := safeResult(87)
+	ApplyTrend(result, []int{90, 86, 91, 85, 90, 86, 91}, trendProfile())
+
+	if result.Score != 87 || result.State != StateSafe || result.TrendPenalty != 0 || hasReason(result, models.ReasonDeterioratingTrend) {
+		t.Errorf("result = %+v, want it unchanged", result)
+	}
+	if _, ok := result.Breakdown["trend"]; ok {
+		t.Errorf("breakdown = %v, want no trend component", result.Breakdown)
+	}
+}
+
+func TestApplyTrendLimits(t *testing.T) {
+	decay := []int{100, 98, 96, 94, 92, 90, 88}
+
+	t.Run("never pushes CAUTION to AT_RISK", func(t *testing.T) {
+		result := &EvaluationResult{State: StateCaution, Score: 52}
+		ApplyTrend(result, []int{90, 85, 80, 75, 70, 65, 58}, trendProfile())
+		if result.State != StateCaution || result.Score != 42 || !hasReason(result, models.ReasonDeterioratingTrend) {
+			t.Errorf("result = %+v, want a penalized CAUTION", result)
+		}
+	})
+
+	t.Run("penalty capped at the score", func(t *testing.T) {
+		result := &EvaluationResult{State: StateAtRisk, Score: 4}
+		ApplyTrend(result, []int{40, 34, 28, 22, 16, 10, 7}, trendProfile())
+		if result.Score != 0 || result.TrendPenalty != 4 {
+			t.Errorf("score %d, penalty %d, want 0 and 4", result.Score, result.TrendPenalty)
+		}
+	})
+
+	t.Run("deterministic results", func(t *testing.T) {
+		result := safeResult(86)
+		result.Deterministic = true
+		ApplyTrend(result, decay, trendProfile())
+		if result.Score != 86 || result.TrendPenalty != 0 {
+			t.Errorf("result = %+v, want it unchanged", result)
+		}
+	})
+
+	t.Run("not enough history", func(t *testing.T) {
+		result := safeResult(86)
+		ApplyTrend(result, decay[2:], trendProfile())
+		if result.Score != 86 || result.TrendPenalty != 0 {
+			t.Errorf("result = %+v, want it unchanged", result)
+		}
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		profile := trendProfile()
+		profile.TrendWindow = 0
+		result := safeResult(86)
+		ApplyTrend(result, decay, profile)
+		if result.Score != 86 || result.TrendPenalty != 0 {
+			t.Errorf("result = %+v, want it unchanged", result)
+		}
+	})
+
+	t.Run("only the window counts", func(t *testing.T) {
+		// An old decline followed by a flat stretch as long as the window
+		result := safeResult(86)
+		ApplyTrend(result, []int{100, 95, 90, 86, 86, 87, 86, 86, 85, 86, 86}, trendProfile())
+		if result.TrendPenalty != 0 {
+			t.Errorf("penalty %d from scores outside the window", result.TrendPenalty)
+		}
+	})
+}
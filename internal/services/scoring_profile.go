@@ -0,0 +1,169 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/config"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+)
+
+// ScoreWeights is the maximum number of points each scoring component contributes
+type ScoreWeights struct {
+	Recency  int `json:"recency"`
+	Accuracy int `json:"accuracy"`
+	Movement int `json:"movement"`
+	Signal   int `json:"signal"`
+	Source   int `json:"source"`
+	Battery  int `json:"battery"`
+}
+
+// ScoringProfile holds the tunable parameters of the safety evaluator
+type ScoringProfile struct {
+	HeartbeatWindowSeconds int          `json:"heartbeat_window_seconds"`
+	SafeThreshold          int          `json:"safe_threshold"`        // score >= this is SAFE
+	CautionThreshold       int          `json:"caution_threshold"`     // score >= this is CAUTION
+	StaleScore             int          `json:"stale_score"`           // score when the heartbeat is older than the window
+	LastGaspRecentScore    int          `json:"lastgasp_recent_score"` // score for a recent LastGasp heartbeat
+	SpoofConfidence        float64      `json:"spoof_confidence"`      // 0-1 trust in location components of a suspected spoof
+	TrendWindow            int          `json:"trend_window"`          // evaluations the trend is measured over; 0 disables it
+	TrendSlope             float64      `json:"trend_slope"`           // points lost per evaluation that count as deteriorating
+	TrendPenalty           int          `json:"trend_penalty"`         // points taken off a deteriorating score
+	Weights                ScoreWeights `json:"weights"`
+
+	// intervalSlack is how much longer than the configured interval the
+	// client was advised to wait between heartbeats
+	intervalSlack time.Duration
+}
+
+// Tightening applied while the user is under an active watch
+const (
+	watchWindowDivisor  = 2
+	watchThresholdBoost = 10
+)
+
+// DefaultScoringProfile returns the profile used for live evaluation
+func DefaultScoringProfile(cfg *config.Config) ScoringProfile {
+	return ScoringProfile{
+		HeartbeatWindowSeconds: cfg.HeartbeatWindowSeconds,
+		SafeThreshold:          cfg.ScoreSafeThreshold,
+		CautionThreshold:       cfg.ScoreCautionThreshold,
+		StaleScore:             30,
+		LastGaspRecentScore:    60,
+		SpoofConfidence:        0.5,
+		TrendWindow:            cfg.ScoreTrendWindow,
+		TrendSlope:             1,
+		TrendPenalty:           10,
+		Weights: ScoreWeights{
+			Recency:  30,
+			Accuracy: 20,
+			Movement: 20,
+			Signal:   10,
+			Source:   5,
+			Battery:  15,
+		},
+	}
+}
+
+// Validate rejects profiles that would make the state mapping meaningless
+func (p ScoringProfile) Validate() error {
+	if p.HeartbeatWindowSeconds <= 0 {
+		return fmt.Errorf("heartbeat_window_seconds must be positive")
+	}
+	if p.CautionThreshold < 0 || p.SafeThreshold > 100 || p.CautionThreshold > p.SafeThreshold {
+		return fmt.Errorf("thresholds must satisfy 0 <= caution_threshold <= safe_threshold <= 100")
+	}
+	if p.SpoofConfidence < 0 || p.SpoofConfidence > 1 {
+		return fmt.Errorf("spoof_confidence must be between 0 and 1")
+	}
+	if p.TrendWindow != 0 && p.TrendWindow < 3 {
+		return fmt.Errorf("trend_window must be 0 (disabled) or at least 3")
+	}
+	if p.TrendSlope < 0 || p.TrendPenalty < 0 {
+		return fmt.Errorf("trend_slope and trend_penalty must not be negative")
+	}
+	w := p.Weights
+	if w.Recency < 0 || w.Accuracy < 0 || w.Movement < 0 || w.Signal < 0 || w.Source < 0 || w.Battery < 0 {
+		return fmt.Errorf("weights must not be negative")
+	}
+	return nil
+}
+
+// WithOverride applies a partial profile, e.g. an organization's, over p.
+// Fields the override leaves out keep p's values; the result must validate.
+func (p ScoringProfile) WithOverride(override json.RawMessage) (ScoringProfile, error) {
+	if err := json.Unmarshal(override, &p); err != nil {
+		return ScoringProfile{}, fmt.Errorf("must be a scoring profile object")
+	}
+	if err := p.Validate(); err != nil {
+		return ScoringProfile{}, err
+	}
+	return p, nil
+}
+
+// WithIntervalAllowance extends the profile for a client that may be
+// following an advised interval of allowance seconds instead of the
+// configured one: its heartbeats are only late once they are overdue on
+// that interval, both for the staleness window and for recency scoring.
+func (p ScoringProfile) WithIntervalAllowance(allowance, configured int) ScoringProfile {
+	if allowance > configured {
+		p.intervalSlack = time.Duration(allowance-configured) * time.Second
+	}
+	return p
+}
+
+// Watched tightens the profile for a user who asked to be watched closely:
+// heartbeats go stale in half the window, and a score needs
+// watchThresholdBoost more points to stay SAFE or CAUTION, so trouble is
+// escalated sooner. No advised interval slack is allowed, since the app
+// switches to the shortest interval as soon as the watch starts.
+func (p ScoringProfile) Watched() ScoringProfile {
+	p.HeartbeatWindowSeconds /= watchWindowDivisor
+	if p.HeartbeatWindowSeconds < 1 {
+		p.HeartbeatWindowSeconds = 1
+	}
+	p.SafeThreshold += watchThresholdBoost
+	if p.SafeThreshold > 100 {
+		p.SafeThreshold = 100
+	}
+	p.CautionThreshold += watchThresholdBoost
+	if p.CautionThreshold > p.SafeThreshold {
+		p.CautionThreshold = p.SafeThreshold
+	}
+	p.intervalSlack = 0
+	return p
+}
+
+func (p ScoringProfile) heartbeatWindow() time.Duration {
+	return time.Duration(p.HeartbeatWindowSeconds)*time.Second + p.intervalSlack
+}
+
+// recencySteps are the heartbeat ages at which the recency score drops
+var recencySteps = [3]time.Duration{5 * time.Minute, 10 * time.Minute, 15 * time.Minute}
+
+// nextChange returns the first time after now at which hb, with no newer
+// heartbeat, scores lower on recency or goes stale, or zero if neither is
+// still ahead
+func (p ScoringProfile) nextChange(hb *models.Heartbeat, now time.Time) time.Time {
+	// Stale once it is older than the window, not at it
+	next := hb.Timestamp.Add(p.heartbeatWindow() + time.Millisecond)
+	if !next.After(now) {
+		return time.Time{}
+	}
+	for _, step := range recencySteps {
+		if at := hb.Timestamp.Add(p.intervalSlack + step); at.After(now) && at.Before(next) {
+			next = at
+		}
+	}
+	return next
+}
+
+// heartbeatAge is how overdue a heartbeat is, discounting advised slack
+func (p ScoringProfile) heartbeatAge(hb *models.Heartbeat, now time.Time) time.Duration {
+	age := now.Sub(hb.Timestamp) - p.intervalSlack
+	if age < 0 {
+		return 0
+	}
+	return age
+}
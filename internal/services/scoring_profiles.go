@@ -0,0 +1,238 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/cache"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/config"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/database"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+)
+
+const (
+	scoringProfileRefreshWorker = "scoring_profile_refresh"
+	// scoringProfileRefreshEvery bounds how soon a change made on another
+	// instance is picked up if its invalidation was missed
+	scoringProfileRefreshEvery = 30 * time.Second
+	// scoringProfileReloadTimeout bounds a reload on invalidation
+	scoringProfileReloadTimeout = 10 * time.Second
+)
+
+// ErrNoCandidate is returned when promoting without a candidate profile
+var ErrNoCandidate = errors.New("no candidate scoring profile")
+
+// loadedProfile is a stored profile with its decoded parameters
+type loadedProfile struct {
+	stored  *models.StoredScoringProfile
+	profile ScoringProfile
+}
+
+// ScoringProfileStore keeps the active and candidate scoring profiles in
+// memory, reloading them from the database after every change made here,
+// when another instance's change is published, and periodically. Without a stored active profile live evaluation uses
+// the one derived from config; once one is promoted, the threshold and
+// window settings in config no longer apply.
+type ScoringProfileStore struct {
+	cfg      *config.Store
+	postgres *database.PostgresDB
+	health   *HealthRegistry
+
+	mu        sync.RWMutex
+	active    *loadedProfile
+	candidate *loadedProfile
+
+	closeOnce sync.Once
+	stop      chan struct{}
+	done      chan struct{}
+}
+
+func NewScoringProfileStore(cfg *config.Store, postgres *database.PostgresDB, health *HealthRegistry) *ScoringProfileStore {
+	s := &ScoringProfileStore{
+		cfg:      cfg,
+		postgres: postgres,
+		health:   health,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	postgres.Caches().Register(cache.KindScoringProfile, cache.Funcs{
+		FlushFunc: func() {
+			ctx, cancel := context.WithTimeout(context.Background(), scoringProfileReloadTimeout)
+			defer cancel()
+			s.reload(ctx)
+		},
+	})
+	return s
+}
+
+// Load reads the active and candidate profiles. A stored profile that no
+// longer decodes or validates is logged and ignored.
+func (s *ScoringProfileStore) Load(ctx context.Context) error {
+	active, candidate, err := s.postgres.GetCurrentScoringProfiles(ctx)
+	if err != nil {
+		return err
+	}
+	a, c := decodeStoredProfile(active), decodeStoredProfile(candidate)
+
+	s.mu.Lock()
+	s.active, s.candidate = a, c
+	s.mu.Unlock()
+	return nil
+}
+
+func decodeStoredProfile(stored *models.StoredScoringProfile) *loadedProfile {
+	if stored == nil {
+		return nil
+	}
+	var profile ScoringProfile
+	err := json.Unmarshal(stored.Profile, &profile)
+	if err == nil {
+		err = profile.Validate()
+	}
+	if err != nil {
+		log.Printf("ERROR: Ignoring %s scoring profile %s: %v", stored.Status, stored.ID, err)
+		return nil
+	}
+	return &loadedProfile{stored: stored, profile: profile}
+}
+
+// Active returns the profile live evaluation uses
+func (s *ScoringProfileStore) Active(cfg *config.Config) ScoringProfile {
+	if s != nil {
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+		if s.active != nil {
+			return s.active.profile
+		}
+	}
+	return DefaultScoringProfile(cfg)
+}
+
+// Candidate returns the profile evaluated in the shadow of the active one
+func (s *ScoringProfileStore) Candidate() (*models.StoredScoringProfile, ScoringProfile, bool) {
+	if s == nil {
+		return nil, ScoringProfile{}, false
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.candidate == nil {
+		return nil, ScoringProfile{}, false
+	}
+	return s.candidate.stored, s.candidate.profile, true
+}
+
+// Current returns the stored active and candidate profiles; either may be nil
+func (s *ScoringProfileStore) Current() (active, candidate *models.StoredScoringProfile) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.active != nil {
+		active = s.active.stored
+	}
+	if s.candidate != nil {
+		candidate = s.candidate.stored
+	}
+	return active, candidate
+}
+
+// SetCandidate stores a validated profile as the candidate, replacing any
+// previous one. Shadow results of the previous candidate are kept.
+func (s *ScoringProfileStore) SetCandidate(ctx context.Context, profile ScoringProfile, note, createdBy string) (*models.StoredScoringProfile, error) {
+	if err := profile.Validate(); err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(profile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode profile: %w", err)
+	}
+	stored := &models.StoredScoringProfile{
+		ID:        uuid.New(),
+		Status:    models.ScoringProfileCandidate,
+		Profile:   data,
+		Note:      note,
+		CreatedBy: createdBy,
+		CreatedAt: time.Now(),
+	}
+	if err := s.postgres.ReplaceCandidateProfile(ctx, stored); err != nil {
+		return nil, err
+	}
+	s.reload(ctx)
+	return stored, nil
+}
+
+// ClearCandidate stops shadow evaluation; it reports whether there was a candidate
+func (s *ScoringProfileStore) ClearCandidate(ctx context.Context) (bool, error) {
+	cleared, err := s.postgres.RetireCandidateProfile(ctx)
+	if err != nil {
+		return false, err
+	}
+	s.reload(ctx)
+	return cleared, nil
+}
+
+// Promote atomically makes the candidate with the given ID the active
+// profile. It fails with ErrNoCandidate when there is no candidate and
+// database.ErrCandidateChanged when the candidate is a different one.
+func (s *ScoringProfileStore) Promote(ctx context.Context, candidateID uuid.UUID) (*models.StoredScoringProfile, error) {
+	promoted, err := s.postgres.PromoteCandidateProfile(ctx, candidateID)
+	if errors.Is(err, database.ErrCandidateChanged) {
+		if _, candidate, lerr := s.postgres.GetCurrentScoringProfiles(ctx); lerr == nil && candidate == nil {
+			return nil, ErrNoCandidate
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	s.reload(ctx)
+	return promoted, nil
+}
+
+// reload picks up a change made here right away; on failure the refresh
+// loop catches up
+func (s *ScoringProfileStore) reload(ctx context.Context) {
+	if err := s.Load(ctx); err != nil {
+		log.Printf("WARN: Failed to reload scoring profiles: %v", err)
+	}
+}
+
+// Start launches the refresh goroutine
+func (s *ScoringProfileStore) Start() {
+	s.health.Register(scoringProfileRefreshWorker, scoringProfileRefreshEvery)
+	go s.run()
+}
+
+// Close stops the refresh goroutine
+func (s *ScoringProfileStore) Close() {
+	s.closeOnce.Do(func() {
+		close(s.stop)
+	})
+	<-s.done
+}
+
+func (s *ScoringProfileStore) run() {
+	defer close(s.done)
+
+	ticker := time.NewTicker(scoringProfileRefreshEvery)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), scoringProfileReloadTimeout)
+		err := s.Load(ctx)
+		cancel()
+		if err != nil {
+			log.Printf("WARN: Failed to refresh scoring profiles: %v", err)
+			continue
+		}
+		s.health.Beat(scoringProfileRefreshWorker)
+	}
+}
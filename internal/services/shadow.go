@@ -0,0 +1,264 @@
+package services
+
+import (
+	"context"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/config"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/database"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/flags"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+)
+
+const (
+	shadowWriterWorker = "shadow_writer"
+	shadowBatchSize    = 100
+	shadowFlushEvery   = 5 * time.Second
+)
+
+// shadowJob is one live evaluation to repeat with the candidate profile
+type shadowJob struct {
+	candidateID  uuid.UUID
+	candidate    ScoringProfile
+	userID       uuid.UUID
+	at           time.Time
+	heartbeat    *models.Heartbeat
+	lastGasp     *models.LastGasp
+	outage       *models.OutageZone // the zone the live evaluation found the user last seen in
+	allowance    int
+	configured   int
+	watched      bool
+	flags        flags.Values // those of the live evaluation
+	activeState  string
+	activeScore  int
+	activeReason string
+}
+
+// ShadowEvaluator re-scores live evaluations with the candidate scoring
+// profile and records where the candidate would have decided differently.
+// It runs off the evaluation path on a bounded queue; when the queue is full
+// evaluations are skipped and counted, never delayed. Nothing it computes is
+// saved as user state or alerted on.
+//
+// The candidate sees the same heartbeat, LastGasp, advised interval and
+// watch as the live evaluation, with the same feature flags, and its trend is measured over the live
+// score history, since the candidate has none of its own. Device
+// disagreement only annotates a result and is not repeated.
+type ShadowEvaluator struct {
+	cfg      *config.Store
+	postgres *database.PostgresDB
+	redis    *database.RedisDB
+	profiles *ScoringProfileStore
+	health   *HealthRegistry
+	queue    chan shadowJob
+
+	// The sandbox is only used from the worker goroutine, with its clock
+	// moved to each evaluation's time
+	clock   *FakeClock
+	sandbox *SafetyEvaluator
+
+	evaluated atomic.Int64
+	diverged  atomic.Int64
+	dropped   atomic.Int64
+
+	closeOnce sync.Once
+	done      chan struct{}
+}
+
+func NewShadowEvaluator(
+	cfg *config.Store,
+	postgres *database.PostgresDB,
+	redis *database.RedisDB,
+	profiles *ScoringProfileStore,
+	health *HealthRegistry,
+) *ShadowEvaluator {
+	clock := NewFakeClock(time.Now())
+	return &ShadowEvaluator{
+		cfg:      cfg,
+		postgres: postgres,
+		redis:    redis,
+		profiles: profiles,
+		health:   health,
+		queue:    make(chan shadowJob, cfg.Current().ShadowQueueSize),
+		clock:    clock,
+		sandbox:  NewSandboxEvaluator(cfg.Current(), clock),
+		done:     make(chan struct{}),
+	}
+}
+
+// Start launches the worker goroutine
+func (s *ShadowEvaluator) Start() {
+	s.health.Register(shadowWriterWorker, shadowFlushEvery)
+	go s.run()
+}
+
+// submit queues a live evaluation for shadow scoring without blocking. It
+// does nothing when there is no candidate profile.
+func (s *ShadowEvaluator) submit(job shadowJob) {
+	if s == nil {
+		return
+	}
+	stored, candidate, ok := s.profiles.Candidate()
+	if !ok {
+		return
+	}
+	job.candidateID = stored.ID
+	job.candidate = candidate
+
+	select {
+	case s.queue <- job:
+	default:
+		if n := s.dropped.Add(1); n == 1 || n%1000 == 0 {
+			log.Printf("WARN: Shadow queue full, %d evaluations skipped so far", n)
+		}
+	}
+}
+
+// Len returns the number of evaluations waiting to be shadowed
+func (s *ShadowEvaluator) Len() int {
+	if s == nil {
+		return 0
+	}
+	return len(s.queue)
+}
+
+// Evaluated returns how many evaluations this process shadowed
+func (s *ShadowEvaluator) Evaluated() int64 {
+	if s == nil {
+		return 0
+	}
+	return s.evaluated.Load()
+}
+
+// Diverged returns how many shadowed evaluations reached a different state
+func (s *ShadowEvaluator) Diverged() int64 {
+	if s == nil {
+		return 0
+	}
+	return s.diverged.Load()
+}
+
+// Dropped returns how many evaluations were skipped because the queue was full
+func (s *ShadowEvaluator) Dropped() int64 {
+	if s == nil {
+		return 0
+	}
+	return s.dropped.Load()
+}
+
+// Close stops the worker after shadowing and writing the queued evaluations
+func (s *ShadowEvaluator) Close() {
+	s.closeOnce.Do(func() {
+		close(s.queue)
+	})
+	<-s.done
+}
+
+func (s *ShadowEvaluator) run() {
+	defer close(s.done)
+
+	ticker := time.NewTicker(shadowFlushEvery)
+	defer ticker.Stop()
+
+	batch := make([]*models.ShadowResult, 0, shadowBatchSize)
+	for {
+		select {
+		case job, ok := <-s.queue:
+			if !ok {
+				s.flush(batch)
+				return
+			}
+			batch = append(batch, s.evaluate(job))
+			if len(batch) >= shadowBatchSize {
+				s.flush(batch)
+				batch = make([]*models.ShadowResult, 0, shadowBatchSize)
+			}
+
+		case <-ticker.C:
+			ok := true
+			if len(batch) > 0 {
+				ok = s.flush(batch)
+				batch = make([]*models.ShadowResult, 0, shadowBatchSize)
+			}
+			if ok {
+				s.health.Beat(shadowWriterWorker)
+			}
+		}
+	}
+}
+
+// evaluate scores the job with the candidate profile the way
+// EvaluateUserSafety scores it with the active one
+func (s *ShadowEvaluator) evaluate(job shadowJob) *models.ShadowResult {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	profile := job.candidate.WithIntervalAllowance(job.allowance, job.configured)
+	if job.watched {
+		profile = profile.Watched()
+	}
+	s.clock.Set(job.at)
+	result := s.sandbox.Assess(job.heartbeat, job.lastGasp, profile)
+
+	if job.heartbeat != nil && isStalenessRisk(result) && job.flags.Enabled(flags.ActivityHold) {
+		activeAt, err := s.redis.UserActiveAt(ctx, job.userID)
+		if err == nil {
+			limit := time.Duration(s.cfg.Current().ActivitySuppressionMaxMinutes) * time.Minute
+			SuppressForActivity(result, job.heartbeat, activeAt, job.at, profile, limit)
+		}
+	}
+	grace := time.Duration(s.cfg.Current().OutageGraceMinutes) * time.Minute
+	if job.heartbeat != nil && isStalenessRisk(result) && job.flags.Enabled(flags.OutageHold) {
+		SuppressForOutage(result, job.outage, job.heartbeat.Timestamp.Add(profile.heartbeatWindow()), job.at, grace)
+	}
+	if job.lastGasp != nil && firedRule(result, RuleLastGaspProlonged) && job.flags.Enabled(flags.OutageHold) {
+		escalate := time.Duration(s.cfg.Current().LastGaspEscalateSeconds) * time.Second
+		SuppressForOutage(result, job.outage, job.lastGasp.LastAt.Add(escalate), job.at, grace)
+	}
+	if !result.Deterministic && profile.TrendWindow > 0 && job.flags.Enabled(flags.ScoreTrend) {
+		history, err := s.postgres.GetRecentScoresBefore(ctx, job.userID, job.at, profile.TrendWindow-1)
+		if err == nil {
+			ApplyTrend(result, history, profile)
+		}
+	}
+
+	diverged := result.State != job.activeState
+	s.evaluated.Add(1)
+	if diverged {
+		s.diverged.Add(1)
+	}
+	return &models.ShadowResult{
+		CandidateID:     job.candidateID,
+		UserID:          job.userID,
+		EvaluatedAt:     job.at,
+		ActiveState:     job.activeState,
+		ActiveScore:     job.activeScore,
+		ActiveReason:    job.activeReason,
+		CandidateState:  result.State,
+		CandidateScore:  result.Score,
+		CandidateReason: result.Reason,
+		CandidateRules:  result.RulesFired,
+		Diverged:        diverged,
+		Flags:           job.flags.Map(),
+	}
+}
+
+// flush writes a batch and reports whether it was stored
+func (s *ShadowEvaluator) flush(batch []*models.ShadowResult) bool {
+	if len(batch) == 0 {
+		return true
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if _, err := s.postgres.CopyShadowResults(ctx, batch); err != nil {
+		log.Printf("ERROR: Failed to write %d shadow results: %v", len(batch), err)
+		return false
+	}
+	return true
+}
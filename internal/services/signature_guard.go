@@ -0,0 +1,114 @@
+package services
+
+import (
+	"context"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/config"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/database"
+)
+
+// SignatureGuard tracks heartbeats that fail signature verification and
+// locks a user's heartbeat endpoint after too many of them. Failures are also
+// counted per source IP for the security log, but IPs are never locked out:
+// mobile carriers put many users behind one address.
+type SignatureGuard struct {
+	cfg      *config.Store
+	postgres *database.PostgresDB
+	redis    *database.RedisDB
+	notifier Notifier
+
+	failures atomic.Int64
+	lockouts atomic.Int64
+}
+
+func NewSignatureGuard(
+	cfg *config.Store,
+	postgres *database.PostgresDB,
+	redis *database.RedisDB,
+	notifier Notifier,
+) *SignatureGuard {
+	return &SignatureGuard{
+		cfg:      cfg,
+		postgres: postgres,
+		redis:    redis,
+		notifier: notifier,
+	}
+}
+
+// Lockout returns how long the user's lockout has left, or 0 if none
+func (g *SignatureGuard) Lockout(ctx context.Context, userID uuid.UUID) (time.Duration, error) {
+	return g.redis.SignatureLockout(ctx, userID)
+}
+
+// RecordFailure counts a failed signature and returns the lockout duration
+// if this failure started a lockout, otherwise 0
+func (g *SignatureGuard) RecordFailure(ctx context.Context, userID uuid.UUID, ip string) (time.Duration, error) {
+	g.failures.Add(1)
+
+	cfg := g.cfg.Current()
+	window := time.Duration(cfg.SignatureFailureWindowSeconds) * time.Second
+
+	userFailures, err := g.redis.RecordSignatureFailure(ctx, "user", userID.String(), window)
+	if err != nil {
+		return 0, err
+	}
+	ipFailures, err := g.redis.RecordSignatureFailure(ctx, "ip", ip, window)
+	if err != nil {
+		return 0, err
+	}
+	log.Printf("SECURITY: event=signature_failure user_id=%s ip=%s user_failures=%d ip_failures=%d window=%s",
+		userID, ip, userFailures, ipFailures, window)
+
+	if userFailures < int64(cfg.SignatureFailureThreshold) {
+		return 0, nil
+	}
+
+	lockout := time.Duration(cfg.SignatureLockoutSeconds) * time.Second
+	started, err := g.redis.LockSignatures(ctx, userID, lockout)
+	if err != nil || !started {
+		return 0, err
+	}
+	g.lockouts.Add(1)
+	log.Printf("SECURITY: event=signature_lockout user_id=%s ip=%s user_failures=%d window=%s lockout=%s",
+		userID, ip, userFailures, window, lockout)
+
+	if cfg.SignatureFailureNotify {
+		go g.warnUser(userID)
+	}
+	return lockout, nil
+}
+
+// Clear ends the user's lockout and resets their failure count
+func (g *SignatureGuard) Clear(ctx context.Context, userID uuid.UUID) error {
+	return g.redis.ClearSignatureLockout(ctx, userID)
+}
+
+// Failures returns how many signature failures were recorded since startup
+func (g *SignatureGuard) Failures() int64 {
+	return g.failures.Load()
+}
+
+// Lockouts returns how many lockouts were started since startup
+func (g *SignatureGuard) Lockouts() int64 {
+	return g.lockouts.Load()
+}
+
+func (g *SignatureGuard) warnUser(userID uuid.UUID) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	token, err := g.postgres.GetPushToken(ctx, userID)
+	if err != nil || token == "" {
+		return
+	}
+	err = g.notifier.SendPushNotification(ctx, token,
+		"SafeTrace security warning",
+		"Someone is sending invalid location updates for your account. They were rejected. If your app has stopped checking in, sign in again.")
+	if err != nil {
+		log.Printf("WARN: Failed to warn user %s about signature failures: %v", userID, err)
+	}
+}
@@ -0,0 +1,87 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/config"
+)
+
+func signatureGuard(t *testing.T, threshold, lockoutSeconds int) *SignatureGuard {
+	return NewSignatureGuard(config.NewStore(&config.Config{
+		SignatureFailureThreshold:     threshold,
+		SignatureFailureWindowSeconds: 60,
+		SignatureLockoutSeconds:       lockoutSeconds,
+	}), nil, testRedis(t), nil)
+}
+
+// The threshold-th failure in the window locks the user, once; failures
+// from other users behind the same IP don't count toward it
+func TestSignatureGuardThreshold(t *testing.T) {
+	ctx := context.Background()
+	guard := signatureGuard(t, 3, 60)
+	userID, neighbour := uuid.New(), uuid.New()
+
+	for i := 0; i < 5; i++ {
+		if _, err := guard.RecordFailure(ctx, neighbour, "102.89.0.1"); err != nil {
+			t.Fatalf("RecordFailure: %v", err)
+		}
+	}
+	for i := 1; i <= 2; i++ {
+		if lockout, err := guard.RecordFailure(ctx, userID, "102.89.0.1"); err != nil || lockout != 0 {
+			t.Fatalf("failure %d = %s, %v, want no lockout", i, lockout, err)
+		}
+	}
+	if left, _ := guard.Lockout(ctx, userID); left != 0 {
+		t.Fatalf("locked for %s below the threshold", left)
+	}
+
+	lockout, err := guard.RecordFailure(ctx, userID, "102.89.0.1")
+	if err != nil || lockout != time.Minute {
+		t.Fatalf("failure 3 = %s, %v, want a 1m lockout", lockout, err)
+	}
+	if left, _ := guard.Lockout(ctx, userID); left <= 0 || left > time.Minute {
+		t.Errorf("Lockout() = %s, want up to 1m", left)
+	}
+
+	// Failures during the lockout neither restart nor count it again
+	if lockout, err := guard.RecordFailure(ctx, userID, "102.89.0.1"); err != nil || lockout != 0 {
+		t.Errorf("failure during the lockout = %s, %v, want 0", lockout, err)
+	}
+	if guard.Lockouts() != 2 || guard.Failures() != 9 {
+		t.Errorf("Lockouts(), Failures() = %d, %d, want 2, 9", guard.Lockouts(), guard.Failures())
+	}
+
+	// Clearing ends the lockout and the count starts over
+	if err := guard.Clear(ctx, userID); err != nil {
+		t.Fatalf("Clear: %v", err)
+	}
+	if left, _ := guard.Lockout(ctx, userID); left != 0 {
+		t.Errorf("locked for %s after Clear", left)
+	}
+	if lockout, _ := guard.RecordFailure(ctx, userID, "102.89.0.1"); lockout != 0 {
+		t.Errorf("first failure after Clear locked for %s", lockout)
+	}
+}
+
+func TestSignatureGuardLockoutExpires(t *testing.T) {
+	ctx := context.Background()
+	guard := signatureGuard(t, 1, 1)
+	userID := uuid.New()
+
+	if lockout, err := guard.RecordFailure(ctx, userID, "102.89.0.1"); err != nil || lockout != time.Second {
+		t.Fatalf("RecordFailure() = %s, %v, want a 1s lockout", lockout, err)
+	}
+	time.Sleep(1100 * time.Millisecond)
+	if left, err := guard.Lockout(ctx, userID); err != nil || left != 0 {
+		t.Errorf("Lockout() after expiry = %s, %v, want 0", left, err)
+	}
+
+	// The failures are still in the window, so the next one locks again
+	if lockout, _ := guard.RecordFailure(ctx, userID, "102.89.0.1"); lockout != time.Second {
+		t.Errorf("failure after expiry = %s, want a new 1s lockout", lockout)
+	}
+}
@@ -0,0 +1,368 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/config"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/database"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+)
+
+// Check-in prompt channels, the attempts of a prompt's ladder
+const (
+	PromptPushData         = "push_data"         // FCM data message the app answers in the background
+	PromptPushNotification = "push_notification" // FCM notification the user taps to answer
+	PromptSMS              = "sms"               // text to the user's own phone, answered by replying OK
+)
+
+// DefaultPromptLadder starts with the quietest channel and ends with the one
+// that doesn't depend on the app at all
+var DefaultPromptLadder = []string{PromptPushData, PromptPushNotification, PromptSMS}
+
+// ErrNoCheckInPrompt is returned when there is no prompt waiting to be answered
+var ErrNoCheckInPrompt = errors.New("no check-in prompt is waiting")
+
+const (
+	promptMonitorWorker = "prompt_monitor"
+	promptMonitorEvery  = 2 * time.Second
+	promptMonitorBatch  = 100
+	// promptMonitorLease is how long a claimed prompt waits to be claimed
+	// again if handling it fails before it is rescheduled
+	promptMonitorLease = 30 * time.Second
+)
+
+// ValidatePromptLadder checks a user's own ladder: known channels, each at
+// most once. An empty ladder is the default one.
+func ValidatePromptLadder(ladder []string) error {
+	for i, channel := range ladder {
+		if !slices.Contains(DefaultPromptLadder, channel) {
+			return fmt.Errorf("unknown channel %q", channel)
+		}
+		if slices.Contains(ladder[:i], channel) {
+			return fmt.Errorf("%s is listed twice", channel)
+		}
+	}
+	return nil
+}
+
+// PromptLadder returns the channels a check-in prompt tries for the user, in order
+func PromptLadder(settings models.UserSettings) []string {
+	if len(settings.SilentPromptLadder) == 0 {
+		return DefaultPromptLadder
+	}
+	return settings.SilentPromptLadder
+}
+
+// promptTimeout is how long each attempt waits for an answer: the user's
+// silent_prompt_timeout, or SILENT_PROMPT_SECONDS if they have none
+func promptTimeout(settings models.UserSettings, cfg *config.Config) time.Duration {
+	if settings.SilentPromptTimeout > 0 {
+		return time.Duration(settings.SilentPromptTimeout) * time.Second
+	}
+	return time.Duration(cfg.SilentPromptSeconds) * time.Second
+}
+
+// SilentPrompts asks users at CAUTION to check in before anyone is alerted.
+// The evaluator opens a prompt in Redis when a user moves to CAUTION, and
+// holds them there while it runs. The monitor sends each attempt of the
+// user's ladder when it is due (by default a silent push, then a visible one,
+// then an SMS to their own phone), each waiting silent_prompt_timeout, and
+// moves the user to AT_RISK only once the last went unanswered.
+//
+// An answer from the app or by SMS, or a heartbeat that brings the user out
+// of CAUTION, ends the prompt. Ending a prompt and claiming its next attempt
+// are atomic in Redis, so no attempt is sent after an answer and no attempt
+// is sent twice across instances. How each prompt ended, and which attempt
+// got an answer, is recorded to tune the default ladder.
+type SilentPrompts struct {
+	cfg       *config.Store
+	postgres  *database.PostgresDB
+	redis     *database.RedisDB
+	evaluator *SafetyEvaluator
+	notifier  Notifier
+	templates *MessageTemplates
+	health    *HealthRegistry
+
+	closeOnce sync.Once
+	stop      chan struct{}
+	done      chan struct{}
+}
+
+func NewSilentPrompts(
+	cfg *config.Store,
+	postgres *database.PostgresDB,
+	redis *database.RedisDB,
+	evaluator *SafetyEvaluator,
+	notifier Notifier,
+	templates *MessageTemplates,
+	health *HealthRegistry,
+) *SilentPrompts {
+	return &SilentPrompts{
+		cfg:       cfg,
+		postgres:  postgres,
+		redis:     redis,
+		evaluator: evaluator,
+		notifier:  notifier,
+		templates: templates,
+		health:    health,
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+}
+
+// Answer ends the user's prompt as answered, via the app or SMS, and
+// returns its record. A zero promptID answers whichever prompt is waiting;
+// otherwise only that one. It fails with ErrNoCheckInPrompt when none is.
+// The user is marked active in the app, so stale heartbeats alone don't
+// escalate them right after they said they're okay.
+func (s *SilentPrompts) Answer(ctx context.Context, userID, promptID uuid.UUID, via string) (*models.SilentPrompt, error) {
+	session, err := s.redis.FinishPromptSession(ctx, userID, promptID)
+	if err != nil {
+		return nil, err
+	}
+	if session == nil {
+		return nil, ErrNoCheckInPrompt
+	}
+
+	now := time.Now()
+	ttl := time.Duration(s.cfg.Current().ActivityTTLSeconds) * time.Second
+	if err := s.redis.MarkUserActive(ctx, userID, now, ttl); err != nil {
+		log.Printf("WARN: Failed to mark user %s active after their check-in: %v", userID, err)
+	}
+	return s.record(ctx, session, models.PromptAnswered, via, now), nil
+}
+
+// Start launches the monitor goroutine
+func (s *SilentPrompts) Start() {
+	s.health.Register(promptMonitorWorker, promptMonitorEvery)
+	go s.run()
+}
+
+// Close stops the monitor and waits for a running pass to finish. Call it
+// before closing the evaluator, which escalates unanswered prompts.
+func (s *SilentPrompts) Close() {
+	s.closeOnce.Do(func() {
+		close(s.stop)
+	})
+	<-s.done
+}
+
+func (s *SilentPrompts) run() {
+	defer close(s.done)
+
+	ticker := time.NewTicker(promptMonitorEvery)
+	defer ticker.Stop()
+
+	for {
+		if s.checkDue() {
+			s.health.Beat(promptMonitorWorker)
+		}
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// checkDue handles every prompt that is due and reports whether the pass
+// completed
+func (s *SilentPrompts) checkDue() bool {
+	ctx, cancel := context.WithTimeout(context.Background(), promptMonitorLease)
+	defer cancel()
+
+	for {
+		select {
+		case <-s.stop:
+			return true
+		default:
+		}
+		userIDs, err := s.redis.ClaimDuePrompts(ctx, time.Now(), promptMonitorLease, promptMonitorBatch)
+		if err != nil {
+			log.Printf("ERROR: Failed to claim due check-in prompts: %v", err)
+			return false
+		}
+		for _, userID := range userIDs {
+			s.advance(ctx, userID)
+		}
+		if len(userIDs) < promptMonitorBatch {
+			return true
+		}
+	}
+}
+
+// advance moves a due prompt on: it ends it if the user already left
+// CAUTION, sends the next attempt, or escalates once every attempt went
+// unanswered. On an error the prompt is left to be claimed again.
+func (s *SilentPrompts) advance(ctx context.Context, userID uuid.UUID) {
+	session, err := s.redis.GetPromptSession(ctx, userID)
+	if err != nil {
+		log.Printf("ERROR: Failed to read check-in prompt of user %s: %v", userID, err)
+		return
+	}
+	if session == nil {
+		// Expired, or ended between the claim and the read
+		if err := s.redis.DropPromptDue(ctx, userID); err != nil {
+			log.Printf("WARN: Failed to unschedule check-in prompt of user %s: %v", userID, err)
+		}
+		return
+	}
+
+	state, err := s.evaluator.CurrentState(ctx, userID)
+	if err != nil {
+		log.Printf("ERROR: Failed to read state of user %s for their check-in prompt: %v", userID, err)
+		return
+	}
+	if state == nil || state.State != StateCaution {
+		// Back to SAFE means a heartbeat answered; anything else, e.g. an
+		// alert or a pause, makes the prompt moot
+		outcome, via := models.PromptCleared, ""
+		if state != nil && state.State == StateSafe {
+			outcome, via = models.PromptAnswered, models.PromptViaHeartbeat
+		}
+		s.finish(ctx, session, outcome, via)
+		return
+	}
+
+	if session.Sent == len(session.Ladder) {
+		s.escalate(ctx, session)
+		return
+	}
+
+	// A channel that can't reach the user is skipped without waiting
+	channel := session.Ladder[session.Sent]
+	now := time.Now()
+	to, err := s.recipient(ctx, userID, channel)
+	if err != nil {
+		log.Printf("WARN: Check-in prompt %s can't use %s for user %s, skipping it: %v", session.ID, channel, userID, err)
+	}
+	next := now.Add(session.Timeout)
+	if to == "" {
+		next = now
+	}
+	claimed, err := s.redis.AdvancePromptSession(ctx, session, next)
+	if err != nil {
+		log.Printf("ERROR: Failed to advance check-in prompt %s: %v", session.ID, err)
+		return
+	}
+	if !claimed || to == "" {
+		return
+	}
+	if err := s.send(ctx, session, channel, to, now.Add(session.Timeout)); err != nil {
+		log.Printf("WARN: Failed to send check-in prompt %s attempt %d (%s) to user %s: %v",
+			session.ID, session.Sent+1, channel, userID, err)
+	}
+}
+
+// recipient returns where an attempt on channel goes: the user's push token
+// or phone. It is empty when the channel can't reach them, e.g. with no
+// push token, or by SMS while they roam with ROAMING_SMS_SUPPRESSED on.
+func (s *SilentPrompts) recipient(ctx context.Context, userID uuid.UUID, channel string) (string, error) {
+	switch channel {
+	case PromptPushData, PromptPushNotification:
+		return s.postgres.GetPushToken(ctx, userID)
+	case PromptSMS:
+		if RoamingSMSSuppressed(ctx, s.cfg, s.redis, userID) {
+			return "", nil
+		}
+		user, err := s.postgres.GetUserByID(ctx, userID)
+		if err != nil || user == nil {
+			return "", err
+		}
+		return user.Phone, nil
+	}
+	return "", fmt.Errorf("unknown channel %q", channel)
+}
+
+// send sends one attempt of the prompt on channel, to be answered by respondBy
+func (s *SilentPrompts) send(ctx context.Context, session *models.PromptSession, channel, to string, respondBy time.Time) error {
+	if channel == PromptSMS {
+		var settings models.UserSettings
+		if user, err := s.postgres.GetUserByID(ctx, session.UserID); err == nil && user != nil {
+			settings = user.Settings
+		}
+		message := s.templates.Render(TemplateCheckInPrompt, MessageData{
+			Time: FormatInUserZone(respondBy, settings, LayoutClock),
+		})
+		return s.notifier.SendSMS(ctx, MessageCheckIn, to, message)
+	}
+	seconds := int(session.Timeout.Seconds())
+	return s.notifier.SendCheckInPrompt(ctx, to, session.ID.String(), channel == PromptPushNotification, seconds)
+}
+
+// escalate ends a prompt whose every attempt went unanswered and moves the
+// user to AT_RISK. If the prompt was answered meanwhile, nothing happens.
+func (s *SilentPrompts) escalate(ctx context.Context, session *models.PromptSession) {
+	ended := s.finish(ctx, session, models.PromptEscalated, "")
+	if ended == nil {
+		return
+	}
+	reason := models.Reason{Code: models.ReasonCheckInUnanswered, Params: map[string]interface{}{
+		"attempts": ended.AttemptsSent,
+		"seconds":  int(ended.EndedAt.Sub(ended.StartedAt).Seconds()),
+	}}
+	raised, err := s.evaluator.RaisePromptUnanswered(ctx, session.UserID, reason)
+	if err != nil {
+		log.Printf("ERROR: Failed to escalate unanswered check-in prompt %s of user %s: %v", session.ID, session.UserID, err)
+		return
+	}
+	if raised {
+		log.Printf("INFO: Check-in prompt %s of user %s went unanswered; alert raised", session.ID, session.UserID)
+	}
+}
+
+// finish ends the prompt if it is still the user's, records how it ended and
+// returns the record; nil means it had already ended
+func (s *SilentPrompts) finish(ctx context.Context, session *models.PromptSession, outcome, via string) *models.SilentPrompt {
+	ended, err := s.redis.FinishPromptSession(ctx, session.UserID, session.ID)
+	if err != nil {
+		log.Printf("ERROR: Failed to end check-in prompt %s: %v", session.ID, err)
+		return nil
+	}
+	if ended == nil {
+		return nil
+	}
+	return s.record(ctx, ended, outcome, via, time.Now())
+}
+
+// record stores how an ended prompt went. An answer is credited to the
+// latest attempt sent before it; one before any attempt has none.
+func (s *SilentPrompts) record(ctx context.Context, session *models.PromptSession, outcome, via string, at time.Time) *models.SilentPrompt {
+	prompt := &models.SilentPrompt{
+		ID:             session.ID,
+		UserID:         session.UserID,
+		Ladder:         session.Ladder,
+		TimeoutSeconds: int(session.Timeout.Seconds()),
+		AttemptsSent:   session.Sent,
+		Outcome:        outcome,
+		StartedAt:      session.StartedAt,
+		EndedAt:        at,
+	}
+	if outcome == models.PromptAnswered {
+		prompt.AnsweredVia = &via
+		if session.Sent > 0 {
+			attempt := session.Sent
+			channel := session.Ladder[attempt-1]
+			prompt.AnsweredAttempt = &attempt
+			prompt.AnsweredChannel = &channel
+		}
+	}
+	if err := s.postgres.CreateSilentPrompt(ctx, prompt); err != nil {
+		log.Printf("WARN: Failed to record check-in prompt %s of user %s: %v", session.ID, session.UserID, err)
+	}
+	return prompt
+}
+
+// Stats counts the prompts started in [from, to) by outcome, and the
+// answered ones by the attempt and channel that got the answer
+func (s *SilentPrompts) Stats(ctx context.Context, from, to time.Time) ([]models.SilentPromptStat, error) {
+	return s.postgres.GetSilentPromptStats(ctx, from, to)
+}
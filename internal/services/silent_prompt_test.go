@@ -0,0 +1,262 @@
+package services
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/config"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/database"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+)
+
+// promptNotifier records the check-in prompts sent, as the channel of each
+type promptNotifier struct {
+	Notifier // nil: anything else panics
+
+	mu   sync.Mutex
+	sent []string
+}
+
+func (n *promptNotifier) SendCheckInPrompt(ctx context.Context, fcmToken, promptID string, visible bool, seconds int) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if visible {
+		n.sent = append(n.sent, PromptPushNotification)
+	} else {
+		n.sent = append(n.sent, PromptPushData)
+	}
+	return nil
+}
+
+func (n *promptNotifier) SendSMS(ctx context.Context, kind, to, message string) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.sent = append(n.sent, PromptSMS)
+	return nil
+}
+
+func (n *promptNotifier) Sent() []string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return append([]string(nil), n.sent...)
+}
+
+// promptFixture is a user at CAUTION with a push token, and the monitors of
+// several instances sharing one Redis
+type promptFixture struct {
+	redis     *database.RedisDB
+	instances []*SilentPrompts
+	notifier  *promptNotifier
+	user      *models.User
+	session   *models.PromptSession
+}
+
+// newPromptFixture opens a prompt over the default ladder, each attempt
+// waiting timeout. An outage on the user's state keeps the evaluator from
+// raising an alert once the ladder runs out, which needs the alert engine;
+// the prompt still ends as escalated.
+func newPromptFixture(t *testing.T, instances int, timeout time.Duration) *promptFixture {
+	t.Helper()
+	postgres := testPostgres(t)
+	redis := testRedis(t)
+	ctx := context.Background()
+	cfg := simulationConfig()
+	cfg.SilentPromptSeconds = 10
+	cfg.ActivityTTLSeconds = 300
+	store := config.NewStore(cfg)
+	se := &SafetyEvaluator{cfg: store, postgres: postgres, redis: redis, clock: SystemClock{}, effects: discardEffects{}}
+
+	user := createTestUser(t, postgres, "Prompted")
+	if err := postgres.UpsertPushToken(ctx, user.ID, "fcm-"+uuid.NewString()); err != nil {
+		t.Fatalf("UpsertPushToken: %v", err)
+	}
+	state := &models.UserState{
+		UserID: user.ID, State: StateCaution, Score: 60, LastHeartbeat: time.Now(), UpdatedAt: time.Now(),
+		Outage: &models.OutageZone{Carrier: "MTN", Lat: 6.5244, Lng: 3.3792},
+	}
+	if err := redis.SaveUserState(ctx, state); err != nil {
+		t.Fatalf("SaveUserState: %v", err)
+	}
+
+	templates, err := NewMessageTemplates(nil)
+	if err != nil {
+		t.Fatalf("NewMessageTemplates: %v", err)
+	}
+	f := &promptFixture{redis: redis, notifier: &promptNotifier{}, user: user}
+	for i := 0; i < instances; i++ {
+		f.instances = append(f.instances, NewSilentPrompts(store, postgres, redis, se, f.notifier, templates, NewHealthRegistry()))
+	}
+
+	now := time.Now()
+	f.session = &models.PromptSession{ID: uuid.New(), UserID: user.ID, Ladder: DefaultPromptLadder, Timeout: timeout, StartedAt: now, Due: now}
+	if started, err := redis.StartPromptSession(ctx, f.session); err != nil || !started {
+		t.Fatalf("StartPromptSession = %v, %v", started, err)
+	}
+	return f
+}
+
+// run has every instance's monitor pass over the due prompts, all at once
+// and again every few milliseconds, until the returned stop is called
+func (f *promptFixture) run() (stop func()) {
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for _, s := range f.instances {
+		wg.Add(1)
+		go func(s *SilentPrompts) {
+			defer wg.Done()
+			for {
+				s.checkDue()
+				select {
+				case <-done:
+					return
+				case <-time.After(5 * time.Millisecond):
+				}
+			}
+		}(s)
+	}
+	return func() {
+		close(done)
+		wg.Wait()
+	}
+}
+
+// waitFor polls until cond holds
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); !cond(); {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func (f *promptFixture) open(t *testing.T) bool {
+	t.Helper()
+	session, err := f.redis.GetPromptSession(context.Background(), f.user.ID)
+	if err != nil {
+		t.Fatalf("GetPromptSession: %v", err)
+	}
+	return session != nil
+}
+
+// An answer arriving between attempts stops the ladder there, whichever
+// attempt it follows, across instances all polling the prompt
+func TestCheckInPromptAnsweredBetweenAttempts(t *testing.T) {
+	for answeredAfter := 1; answeredAfter <= len(DefaultPromptLadder); answeredAfter++ {
+		f := newPromptFixture(t, 4, 200*time.Millisecond)
+		stop := f.run()
+		waitFor(t, "the attempt to answer", func() bool { return len(f.notifier.Sent()) >= answeredAfter })
+
+		prompt, err := f.instances[0].Answer(context.Background(), f.user.ID, uuid.Nil, models.PromptViaApp)
+		if err != nil {
+			stop()
+			t.Fatalf("Answer after attempt %d: %v", answeredAfter, err)
+		}
+		time.Sleep(3 * f.session.Timeout) // the rest of the ladder, had it gone on
+		stop()
+
+		if sent := f.notifier.Sent(); len(sent) != answeredAfter {
+			t.Errorf("answered after attempt %d: sent %v", answeredAfter, sent)
+		}
+		if prompt.Outcome != models.PromptAnswered || prompt.AnsweredAttempt == nil || *prompt.AnsweredAttempt != answeredAfter ||
+			*prompt.AnsweredChannel != DefaultPromptLadder[answeredAfter-1] || *prompt.AnsweredVia != models.PromptViaApp {
+			t.Errorf("answered after attempt %d: recorded %+v", answeredAfter, prompt)
+		}
+		if f.open(t) {
+			t.Errorf("answered after attempt %d: prompt still open", answeredAfter)
+		}
+		if _, err := f.instances[0].Answer(context.Background(), f.user.ID, uuid.Nil, models.PromptViaSMS); err != ErrNoCheckInPrompt {
+			t.Errorf("second answer = %v, want ErrNoCheckInPrompt", err)
+		}
+	}
+}
+
+// Unanswered, the ladder sends each attempt once, in order, however many
+// instances poll it, and ends only after the last attempt's wait
+func TestCheckInPromptLadderUnanswered(t *testing.T) {
+	timeout := 200 * time.Millisecond
+	f := newPromptFixture(t, 4, timeout)
+	stop := f.run()
+	defer stop()
+
+	waitFor(t, "the last attempt", func() bool { return len(f.notifier.Sent()) == len(DefaultPromptLadder) })
+	if !f.open(t) {
+		t.Fatal("prompt ended as soon as its last attempt was sent")
+	}
+	waitFor(t, "the escalation", func() bool { return !f.open(t) })
+	ended := time.Now()
+
+	sent := f.notifier.Sent()
+	for i, channel := range DefaultPromptLadder {
+		if i >= len(sent) || sent[i] != channel {
+			t.Fatalf("sent %v, want %v", sent, DefaultPromptLadder)
+		}
+	}
+	if deadline := f.session.StartedAt.Add(time.Duration(len(DefaultPromptLadder)) * timeout); ended.Before(deadline) {
+		t.Errorf("escalated %s before the ladder ran out", deadline.Sub(ended))
+	}
+	time.Sleep(2 * timeout)
+	if sent := f.notifier.Sent(); len(sent) != len(DefaultPromptLadder) {
+		t.Errorf("sent %v after the escalation", sent)
+	}
+}
+
+// Of an answer and the escalation racing for an exhausted prompt, exactly
+// one ends it
+func TestCheckInPromptAnswerRacesEscalation(t *testing.T) {
+	for i := 0; i < 20; i++ {
+		f := newPromptFixture(t, 1, time.Minute)
+		ctx := context.Background()
+		s := f.instances[0]
+		for range DefaultPromptLadder {
+			s.advance(ctx, f.user.ID)
+		}
+		session, err := f.redis.GetPromptSession(ctx, f.user.ID)
+		if err != nil || session == nil || session.Sent != len(DefaultPromptLadder) {
+			t.Fatalf("prompt = %+v (%v), want every attempt sent", session, err)
+		}
+
+		var wg sync.WaitGroup
+		var answer error
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			s.escalate(ctx, session)
+		}()
+		go func() {
+			defer wg.Done()
+			_, answer = s.Answer(ctx, f.user.ID, session.ID, models.PromptViaSMS)
+		}()
+		wg.Wait()
+
+		if answer != nil && answer != ErrNoCheckInPrompt {
+			t.Fatalf("Answer: %v", answer)
+		}
+		if f.open(t) {
+			t.Fatal("prompt still open")
+		}
+		stats, err := s.Stats(ctx, session.StartedAt, session.StartedAt.Add(time.Millisecond))
+		if err != nil {
+			t.Fatalf("Stats: %v", err)
+		}
+		recorded := map[string]int{}
+		for _, stat := range stats {
+			recorded[stat.Outcome] += stat.Count
+		}
+		want := map[string]int{models.PromptEscalated: 1}
+		if answer == nil {
+			want = map[string]int{models.PromptAnswered: 1}
+		}
+		if len(recorded) != 1 || recorded[models.PromptAnswered] != want[models.PromptAnswered] || recorded[models.PromptEscalated] != want[models.PromptEscalated] {
+			t.Fatalf("recorded %v, want %v", recorded, want)
+		}
+		if sent := f.notifier.Sent(); len(sent) != len(DefaultPromptLadder) {
+			t.Fatalf("sent %v", sent)
+		}
+	}
+}
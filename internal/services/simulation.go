@@ -0,0 +1,174 @@
+package services
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/config"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+)
+
+// MaxSimulationSteps bounds the work a single simulation request can cause
+const MaxSimulationSteps = 5000
+
+const (
+	SimulationStepHeartbeat = "heartbeat"
+	SimulationStepTick      = "tick"
+)
+
+// SimulationStep is the evaluator's verdict at one point of a replay
+type SimulationStep struct {
+	At          time.Time  `json:"at"`
+	Kind        string     `json:"kind"` // "heartbeat" | "tick"
+	HeartbeatID *uuid.UUID `json:"heartbeat_id,omitempty"`
+	EvaluationResult
+	WouldAlert bool `json:"would_alert"` // live evaluation would have created an alert here
+}
+
+// Simulator replays heartbeats through a sandboxed SafetyEvaluator: the clock
+// is moved to each step, nothing is written and no alerts are sent.
+type Simulator struct {
+	cfg      *config.Store
+	profiles *ScoringProfileStore
+}
+
+func NewSimulator(cfg *config.Store, profiles *ScoringProfileStore) *Simulator {
+	return &Simulator{cfg: cfg, profiles: profiles}
+}
+
+// DefaultProfile returns the profile live evaluation currently uses
+func (s *Simulator) DefaultProfile() ScoringProfile {
+	return s.profiles.Active(s.cfg.Current())
+}
+
+// Run evaluates the user's state at every heartbeat and, if tick is positive,
+// every tick in between and up to until (which may be zero). Heartbeats with
+// last_gasp set open or re-arm a LastGasp window as they would in production,
+// and other heartbeats supersede it.
+func (s *Simulator) Run(heartbeats []models.Heartbeat, profile ScoringProfile, tick time.Duration, until time.Time) ([]SimulationStep, error) {
+	if len(heartbeats) == 0 {
+		return nil, fmt.Errorf("no heartbeats to simulate")
+	}
+
+	sorted := make([]models.Heartbeat, len(heartbeats))
+	copy(sorted, heartbeats)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Timestamp.Before(sorted[j].Timestamp) })
+
+	end := sorted[len(sorted)-1].Timestamp
+	if until.After(end) {
+		end = until
+	}
+
+	clock := NewFakeClock(sorted[0].Timestamp)
+	cfg := s.cfg.Current()
+	evaluator := NewSandboxEvaluator(cfg, clock)
+	lastGaspTimeout := time.Duration(cfg.LastGaspTimeoutSeconds) * time.Second
+	lastGaspRearm := time.Duration(cfg.LastGaspRearmSeconds) * time.Second
+
+	var (
+		steps     []SimulationStep
+		latest    *models.Heartbeat
+		lastGasp  *models.LastGasp
+		prevState string
+		lastAlert time.Time
+		scores    []int // instantaneous scores of scored steps, for the trend
+	)
+
+	evaluate := func(at time.Time, kind string, heartbeatID *uuid.UUID) error {
+		if len(steps) >= MaxSimulationSteps {
+			return fmt.Errorf("simulation exceeds %d steps; use a larger tick or a shorter range", MaxSimulationSteps)
+		}
+		clock.Set(at)
+
+		result := evaluator.Assess(latest, lastGasp, profile)
+		if !result.Deterministic {
+			ApplyTrend(result, scores, profile)
+			scores = append(scores, result.Score+result.TrendPenalty)
+		}
+		step := SimulationStep{At: at, Kind: kind, HeartbeatID: heartbeatID, EvaluationResult: *result}
+
+		// Mirror EvaluateUserSafety: only LastGasp waits and scored results are
+		// stored, and only scored results and prolonged waits go through the
+		// transition rules
+		switch {
+		case result.State == StateWaitLastGasp:
+			prevState = result.State
+		case !result.Deterministic || firedRule(result, RuleLastGaspProlonged):
+			step.WouldAlert = wouldAlert(prevState, result.State, lastAlert, at)
+			if step.WouldAlert {
+				lastAlert = at
+			}
+			prevState = result.State
+		}
+
+		steps = append(steps, step)
+		return nil
+	}
+
+	for i := range sorted {
+		hb := sorted[i]
+
+		if tick > 0 && latest != nil {
+			for t := latest.Timestamp.Add(tick); t.Before(hb.Timestamp); t = t.Add(tick) {
+				if err := evaluate(t, SimulationStepTick, nil); err != nil {
+					return nil, err
+				}
+			}
+		}
+
+		latest = &hb
+		switch {
+		case hb.LastGasp && lastGasp != nil && hb.Timestamp.Sub(lastGasp.LastAt) < lastGaspRearm:
+			lastGasp.Lat, lastGasp.Lng = hb.Lat, hb.Lng
+			lastGasp.AccuracyM = hb.AccuracyM
+			lastGasp.CellInfo = hb.CellInfo
+			lastGasp.ExpiryTs = hb.Timestamp.Add(lastGaspTimeout)
+			lastGasp.LastAt = hb.Timestamp
+			lastGasp.Repeats++
+			lastGasp.SupersededAt = nil
+		case hb.LastGasp:
+			lastGasp = &models.LastGasp{
+				UserID:    hb.UserID,
+				Lat:       hb.Lat,
+				Lng:       hb.Lng,
+				AccuracyM: hb.AccuracyM,
+				CellInfo:  hb.CellInfo,
+				CreatedAt: hb.Timestamp,
+				ExpiryTs:  hb.Timestamp.Add(lastGaspTimeout),
+				LastAt:    hb.Timestamp,
+			}
+		case lastGasp != nil && lastGasp.SupersededAt == nil && lastGasp.ExpiryTs.After(hb.Timestamp):
+			at := hb.Timestamp
+			lastGasp.SupersededAt = &at
+		}
+
+		id := hb.ID
+		if err := evaluate(hb.Timestamp, SimulationStepHeartbeat, &id); err != nil {
+			return nil, err
+		}
+	}
+
+	if tick > 0 {
+		for t := latest.Timestamp.Add(tick); !t.After(end); t = t.Add(tick) {
+			if err := evaluate(t, SimulationStepTick, nil); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return steps, nil
+}
+
+// wouldAlert applies the transition rules of handleStateTransition: alert on a
+// change into AT_RISK or ALERT (ALERT repeats), at most once per 5 minutes
+func wouldAlert(prevState, newState string, lastAlert, at time.Time) bool {
+	if newState != StateAtRisk && newState != StateAlert {
+		return false
+	}
+	if prevState == newState && newState != StateAlert {
+		return false
+	}
+	return lastAlert.IsZero() || at.Sub(lastAlert) >= 5*time.Minute
+}
@@ -0,0 +1,202 @@
+package services
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/config"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+)
+
+func simulationConfig() *config.Config {
+	return &config.Config{
+		HeartbeatWindowSeconds:  600,
+		LastGaspTimeoutSeconds:  3600,
+		LastGaspRearmSeconds:    900,
+		LastGaspEscalateSeconds: 2400,
+		ScoreSafeThreshold:      80,
+		ScoreCautionThreshold:   50,
+		ScoreTrendWindow:        8,
+	}
+}
+
+// simulationStart is years in the past: a rule reading the wall clock
+// instead of the simulation's would see every heartbeat as stale
+var simulationStart = time.Date(2019, 6, 1, 20, 0, 0, 0, time.UTC)
+
+func simulatedHeartbeat(after time.Duration, lastGasp bool) models.Heartbeat {
+	battery := 80
+	return models.Heartbeat{
+		ID:         uuid.New(),
+		Lat:        6.5244,
+		Lng:        3.3792,
+		AccuracyM:  15,
+		BatteryPct: &battery,
+		CellInfo:   models.CellInfo{MCC: 621, MNC: 20, CID: 1234, LAC: 5, RSSI: -70, NetworkType: "LTE"},
+		Timestamp:  simulationStart.Add(after),
+		LastGasp:   lastGasp,
+	}
+}
+
+// A night replayed on the fake clock: two heartbeats, a LastGasp whose wait
+// is prolonged into AT_RISK, a heartbeat that ends it, then silence
+func TestSimulatorTimeline(t *testing.T) {
+	cfg := simulationConfig()
+	heartbeats := []models.Heartbeat{
+		simulatedHeartbeat(55*time.Minute, false), // out of order on purpose
+		simulatedHeartbeat(0, false),
+		simulatedHeartbeat(5*time.Minute, false),
+		simulatedHeartbeat(10*time.Minute, true),
+	}
+	steps, err := NewSimulator(config.NewStore(cfg), nil).Run(heartbeats, DefaultScoringProfile(cfg), 5*time.Minute, simulationStart.Add(80*time.Minute))
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+
+	type step struct {
+		at    time.Duration
+		kind  string
+		state string
+		score int
+		rule  string
+		alert bool
+	}
+	want := []step{
+		{0, SimulationStepHeartbeat, StateSafe, 88, "", false},
+		{5 * time.Minute, SimulationStepHeartbeat, StateSafe, 88, "", false},
+		{10 * time.Minute, SimulationStepHeartbeat, StateWaitLastGasp, 0, RuleLastGaspActive, false},
+		{15 * time.Minute, SimulationStepTick, StateWaitLastGasp, 0, RuleLastGaspActive, false},
+		{20 * time.Minute, SimulationStepTick, StateWaitLastGasp, 0, RuleLastGaspActive, false},
+		{25 * time.Minute, SimulationStepTick, StateWaitLastGasp, 0, RuleLastGaspActive, false},
+		{30 * time.Minute, SimulationStepTick, StateWaitLastGasp, 0, RuleLastGaspActive, false},
+		{35 * time.Minute, SimulationStepTick, StateWaitLastGasp, 0, RuleLastGaspActive, false},
+		{40 * time.Minute, SimulationStepTick, StateWaitLastGasp, 0, RuleLastGaspActive, false},
+		{45 * time.Minute, SimulationStepTick, StateWaitLastGasp, 0, RuleLastGaspActive, false},
+		{50 * time.Minute, SimulationStepTick, StateAtRisk, 30, RuleLastGaspProlonged, true}, // 40 min after the LastGasp
+		{55 * time.Minute, SimulationStepHeartbeat, StateSafe, 88, "", false},
+		{60 * time.Minute, SimulationStepTick, StateCaution, 78, "", false}, // recency drops at 5 min
+		{65 * time.Minute, SimulationStepTick, StateCaution, 68, "", false}, // and 10 min
+		{70 * time.Minute, SimulationStepTick, StateAtRisk, 30, RuleHeartbeatStale, false},
+		{75 * time.Minute, SimulationStepTick, StateAtRisk, 30, RuleHeartbeatStale, false},
+		{80 * time.Minute, SimulationStepTick, StateAtRisk, 30, RuleHeartbeatStale, false},
+	}
+	var got []step
+	for _, s := range steps {
+		rule := ""
+		if len(s.RulesFired) > 0 {
+			rule = s.RulesFired[0]
+		}
+		got = append(got, step{s.At.Sub(simulationStart), s.Kind, s.State, s.Score, rule, s.WouldAlert})
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("timeline:\n got %+v\nwant %+v", got, want)
+	}
+
+	// Heartbeat steps name their heartbeat; scored ones break the score down
+	if steps[0].HeartbeatID == nil || *steps[0].HeartbeatID != heartbeats[1].ID {
+		t.Errorf("first step's heartbeat = %v, want %v", steps[0].HeartbeatID, heartbeats[1].ID)
+	}
+	if steps[3].HeartbeatID != nil {
+		t.Errorf("tick names heartbeat %v", steps[3].HeartbeatID)
+	}
+	if steps[12].Breakdown["recency"] != 20 {
+		t.Errorf("breakdown at 60 min = %v, want recency 20", steps[12].Breakdown)
+	}
+}
+
+// The same input replays the same way
+func TestSimulatorDeterministic(t *testing.T) {
+	cfg := simulationConfig()
+	heartbeats := []models.Heartbeat{simulatedHeartbeat(0, false), simulatedHeartbeat(7*time.Minute, true)}
+	sim := NewSimulator(config.NewStore(cfg), nil)
+	first, err := sim.Run(heartbeats, DefaultScoringProfile(cfg), time.Minute, simulationStart.Add(2*time.Hour))
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	second, err := sim.Run(heartbeats, DefaultScoringProfile(cfg), time.Minute, simulationStart.Add(2*time.Hour))
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if !reflect.DeepEqual(first, second) {
+		t.Errorf("two runs of the same input differ")
+	}
+}
+
+func TestSimulatorLimits(t *testing.T) {
+	cfg := simulationConfig()
+	sim := NewSimulator(config.NewStore(cfg), nil)
+	if _, err := sim.Run(nil, DefaultScoringProfile(cfg), 0, time.Time{}); err == nil {
+		t.Errorf("Run() of no heartbeats succeeded")
+	}
+	_, err := sim.Run([]models.Heartbeat{simulatedHeartbeat(0, false)}, DefaultScoringProfile(cfg), time.Second, simulationStart.Add(24*time.Hour))
+	if err == nil || !strings.Contains(err.Error(), "steps") {
+		t.Errorf("Run() of a day in 1s ticks = %v, want the step limit", err)
+	}
+}
+
+func TestWouldAlert(t *testing.T) {
+	at := simulationStart
+	tests := []struct {
+		name      string
+		prev, new string
+		lastAlert time.Time
+		want      bool
+	}{
+		{"into AT_RISK", StateCaution, StateAtRisk, time.Time{}, true},
+		{"staying AT_RISK", StateAtRisk, StateAtRisk, time.Time{}, false},
+		{"into ALERT", StateAtRisk, StateAlert, at.Add(-10 * time.Minute), true},
+		{"ALERT repeats", StateAlert, StateAlert, at.Add(-5 * time.Minute), true},
+		{"within the cooldown", StateCaution, StateAtRisk, at.Add(-4 * time.Minute), false},
+		{"CAUTION never alerts", StateSafe, StateCaution, time.Time{}, false},
+		{"SAFE never alerts", StateAtRisk, StateSafe, time.Time{}, false},
+	}
+	for _, tt := range tests {
+		if got := wouldAlert(tt.prev, tt.new, tt.lastAlert, at); got != tt.want {
+			t.Errorf("%s: wouldAlert() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// A phone bouncing in and out of coverage: each heartbeat between LastGasps
+// ends the wait at once, nobody is alerted while it flaps, and the wait after
+// the last LastGasp escalates on its own
+func TestSimulatorFlappingCoverage(t *testing.T) {
+	cfg := simulationConfig()
+	var heartbeats []models.Heartbeat
+	for at := time.Duration(0); at <= 12*time.Minute; at += 2 * time.Minute {
+		heartbeats = append(heartbeats, simulatedHeartbeat(at, at%(4*time.Minute) == 0))
+	}
+	steps, err := NewSimulator(config.NewStore(cfg), nil).Run(heartbeats, DefaultScoringProfile(cfg), 2*time.Minute, simulationStart.Add(60*time.Minute))
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+
+	var states []string
+	var alerts []time.Duration
+	for _, s := range steps {
+		if s.Kind == SimulationStepHeartbeat {
+			states = append(states, s.State)
+		}
+		if s.WouldAlert {
+			alerts = append(alerts, s.At.Sub(simulationStart))
+		}
+		// The wait is counted from the latest LastGasp, not the first
+		if since := s.At.Sub(simulationStart) - 12*time.Minute; s.State == StateWaitLastGasp && since >= 0 {
+			if minutes := s.Reasons[0].Params["minutes"]; minutes != int(since.Minutes()) {
+				t.Errorf("wait %s after the last LastGasp = %v minutes", since, minutes)
+			}
+		}
+	}
+	want := []string{StateWaitLastGasp, StateSafe, StateWaitLastGasp, StateSafe, StateWaitLastGasp, StateSafe, StateWaitLastGasp}
+	if !reflect.DeepEqual(states, want) {
+		t.Errorf("states at heartbeats = %v, want %v", states, want)
+	}
+	// LASTGASP_ESCALATE_SECONDS after the last LastGasp at 12 minutes
+	if !reflect.DeepEqual(alerts, []time.Duration{52 * time.Minute}) {
+		t.Errorf("alerts at %v, want one at 52m", alerts)
+	}
+}
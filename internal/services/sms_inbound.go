@@ -0,0 +1,222 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	twilioClient "github.com/twilio/twilio-go/client"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/config"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/utils"
+)
+
+// ErrInboundSMSUnauthenticated is returned for an inbound webhook that fails
+// the provider's authenticity check
+var ErrInboundSMSUnauthenticated = errors.New("inbound SMS failed authentication")
+
+// InboundSMS is a text sent to one of our numbers, as a provider reported it
+type InboundSMS struct {
+	Provider  string
+	From      string // normalized
+	To        string // our number or shortcode
+	Body      string
+	MessageID string // the provider's
+}
+
+// InboundSMSProvider is implemented by every gateway that forwards texts
+// sent to our numbers
+type InboundSMSProvider interface {
+	// Name returns the provider key used in config and webhook URLs
+	Name() string
+	// ParseInbound checks that a webhook came from the provider, returning
+	// ErrInboundSMSUnauthenticated if it didn't, and extracts the message
+	ParseInbound(r *http.Request) (*InboundSMS, error)
+	// Respond answers the webhook. A reply to the sender is carried in the
+	// response where the provider supports it, and Respond reports whether
+	// it did; otherwise it has to be sent as a message of its own.
+	Respond(w http.ResponseWriter, reply string) bool
+}
+
+// InboundSMSProviderFor returns the named provider's inbound webhook
+// handling, if it is configured: Twilio needs TWILIO_AUTH_TOKEN, Termii
+// TERMII_WEBHOOK_SECRET and Africa's Talking AFRICASTALKING_WEBHOOK_TOKEN,
+// so a webhook that can't be checked is never taken
+func InboundSMSProviderFor(cfg *config.Config, name string) (InboundSMSProvider, bool) {
+	switch name {
+	case ProviderTwilio:
+		if cfg.TwilioAuthToken != "" {
+			return &twilioInbound{authToken: cfg.TwilioAuthToken, baseURL: cfg.PublicBaseURL}, true
+		}
+	case ProviderTermii:
+		if cfg.TermiiWebhookSecret != "" {
+			return &termiiInbound{secret: cfg.TermiiWebhookSecret}, true
+		}
+	case ProviderAfricasTalking:
+		if cfg.AfricasTalkingWebhookToken != "" {
+			return &africasTalkingInbound{token: cfg.AfricasTalkingWebhookToken}, true
+		}
+	}
+	return nil, false
+}
+
+// twilioInbound takes form-encoded webhooks signed with X-Twilio-Signature,
+// checked against the URL Twilio called, and replies in TwiML
+type twilioInbound struct {
+	authToken string
+	baseURL   string
+}
+
+func (p *twilioInbound) Name() string {
+	return ProviderTwilio
+}
+
+func (p *twilioInbound) ParseInbound(r *http.Request) (*InboundSMS, error) {
+	if err := r.ParseForm(); err != nil {
+		return nil, err
+	}
+	if !twilioSignatureValid(p.authToken, webhookURL(p.baseURL, r), r) {
+		return nil, ErrInboundSMSUnauthenticated
+	}
+	return &InboundSMS{
+		Provider:  ProviderTwilio,
+		From:      utils.NormalizePhone(r.PostForm.Get("From")),
+		To:        r.PostForm.Get("To"),
+		Body:      r.PostForm.Get("Body"),
+		MessageID: r.PostForm.Get("MessageSid"),
+	}, nil
+}
+
+// Respond answers in TwiML; an empty reply is an empty <Response/>, which
+// sends nothing back
+func (p *twilioInbound) Respond(w http.ResponseWriter, reply string) bool {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+	if reply == "" {
+		io.WriteString(w, `<?xml version="1.0" encoding="UTF-8"?><Response/>`)
+		return true
+	}
+	io.WriteString(w, `<?xml version="1.0" encoding="UTF-8"?><Response><Message>`)
+	xml.EscapeText(w, []byte(reply))
+	io.WriteString(w, `</Message></Response>`)
+	return true
+}
+
+// twilioSignatureValid reports whether a webhook's X-Twilio-Signature is
+// Twilio's for the URL it called and the form it posted, parsed already
+func twilioSignatureValid(authToken, url string, r *http.Request) bool {
+	params := make(map[string]string, len(r.PostForm))
+	for name := range r.PostForm {
+		params[name] = r.PostForm.Get(name)
+	}
+	validator := twilioClient.NewRequestValidator(authToken)
+	return validator.Validate(url, params, r.Header.Get("X-Twilio-Signature"))
+}
+
+// webhookURL is the URL a provider called, as it saw it: behind a proxy
+// the request's own host and scheme aren't, so PUBLIC_BASE_URL is used
+// when it is set
+func webhookURL(baseURL string, r *http.Request) string {
+	if baseURL != "" {
+		return strings.TrimRight(baseURL, "/") + r.URL.RequestURI()
+	}
+	scheme := "https"
+	if r.TLS == nil && r.Header.Get("X-Forwarded-Proto") != "https" {
+		scheme = "http"
+	}
+	return scheme + "://" + r.Host + r.URL.RequestURI()
+}
+
+// termiiInbound takes JSON webhooks signed with X-Termii-Signature, and
+// can't reply in its response
+type termiiInbound struct {
+	secret string
+}
+
+func (p *termiiInbound) Name() string {
+	return ProviderTermii
+}
+
+func (p *termiiInbound) ParseInbound(r *http.Request) (*InboundSMS, error) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	if !TermiiSignatureValid(p.secret, body, r.Header.Get("X-Termii-Signature")) {
+		return nil, ErrInboundSMSUnauthenticated
+	}
+
+	var event struct {
+		ID       string `json:"id"`
+		Sender   string `json:"sender"`
+		Receiver string `json:"receiver"`
+		Message  string `json:"message"`
+	}
+	if err := json.Unmarshal(body, &event); err != nil {
+		return nil, fmt.Errorf("termii inbound message: %w", err)
+	}
+	return &InboundSMS{
+		Provider:  ProviderTermii,
+		From:      utils.NormalizePhone(event.Sender),
+		To:        event.Receiver,
+		Body:      event.Message,
+		MessageID: event.ID,
+	}, nil
+}
+
+func (p *termiiInbound) Respond(w http.ResponseWriter, reply string) bool {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	io.WriteString(w, `{"status":"received"}`)
+	return false
+}
+
+// TermiiSignatureValid reports whether signature is Termii's for a webhook
+// body: the hex HMAC-SHA512 of the body, keyed with the webhook secret
+func TermiiSignatureValid(secret string, body []byte, signature string) bool {
+	mac := hmac.New(sha512.New, []byte(secret))
+	mac.Write(body)
+	expected := hex.EncodeToString(mac.Sum(nil))
+	return hmac.Equal([]byte(expected), []byte(strings.ToLower(signature)))
+}
+
+// africasTalkingInbound takes form-encoded webhooks. Africa's Talking
+// doesn't sign them, so the callback URL carries a token of ours,
+// ?token=..., and the answer is an empty 200.
+type africasTalkingInbound struct {
+	token string
+}
+
+func (p *africasTalkingInbound) Name() string {
+	return ProviderAfricasTalking
+}
+
+func (p *africasTalkingInbound) ParseInbound(r *http.Request) (*InboundSMS, error) {
+	token := r.URL.Query().Get("token")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(p.token)) != 1 {
+		return nil, ErrInboundSMSUnauthenticated
+	}
+	if err := r.ParseForm(); err != nil {
+		return nil, err
+	}
+	return &InboundSMS{
+		Provider:  ProviderAfricasTalking,
+		From:      utils.NormalizePhone(r.PostForm.Get("from")),
+		To:        r.PostForm.Get("to"),
+		Body:      r.PostForm.Get("text"),
+		MessageID: r.PostForm.Get("id"),
+	}, nil
+}
+
+func (p *africasTalkingInbound) Respond(w http.ResponseWriter, reply string) bool {
+	w.WriteHeader(http.StatusOK)
+	return false
+}
@@ -0,0 +1,276 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/config"
+)
+
+// Fixtures in testdata/sms_inbound are webhook bodies as each provider sends
+// them. Signatures were computed outside Go, with each provider's documented
+// algorithm, so they check our reading of it rather than echo it.
+const (
+	twilioTestToken   = "twilio-test-auth-token"
+	twilioFixtureURL  = "https://api.safetrace.ng/v1/sms/webhook/twilio" // what the fixture was signed for
+	twilioFixtureSig  = "HCnOSSw8AGiMG7iEASYVNtnBJjo="
+	termiiTestSecret  = "termii-test-secret"
+	termiiFixtureSig  = "c1f6632b5ca1b5b08108571e181936dcbf64e1a5d72a84cb7a7281ea136f68c4c828eb0840218849ad184ee9aa2117ca467fc0b824dd4b5fd61ac901e12246e0"
+	atTestToken       = "at-test-token"
+	inboundFixtureDir = "testdata/sms_inbound"
+)
+
+func fixture(t *testing.T, name string) []byte {
+	t.Helper()
+	body, err := os.ReadFile(filepath.Join(inboundFixtureDir, name))
+	if err != nil {
+		t.Fatalf("read fixture: %v", err)
+	}
+	return body
+}
+
+func inboundProvider(t *testing.T, cfg *config.Config, name string) InboundSMSProvider {
+	t.Helper()
+	provider, ok := InboundSMSProviderFor(cfg, name)
+	if !ok {
+		t.Fatalf("provider %s not configured", name)
+	}
+	return provider
+}
+
+func formRequest(target string, body []byte) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, target, bytes.NewReader(body))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return r
+}
+
+func TestInboundSMSProviderFor(t *testing.T) {
+	cfg := &config.Config{}
+	for _, name := range []string{ProviderTwilio, ProviderTermii, ProviderAfricasTalking, "unknown"} {
+		if _, ok := InboundSMSProviderFor(cfg, name); ok {
+			t.Errorf("%s is taken without its secret", name)
+		}
+	}
+}
+
+// The URL Twilio signed is rebuilt from PUBLIC_BASE_URL, or else from the
+// Host header and the scheme, which a proxy reports in X-Forwarded-Proto
+func TestTwilioInboundSignature(t *testing.T) {
+	body := fixture(t, "twilio.form")
+
+	tests := []struct {
+		name    string
+		baseURL string
+		request func() *http.Request
+		sig     string
+		wantErr error
+	}{
+		{
+			name:    "public base URL behind a proxy",
+			baseURL: "https://api.safetrace.ng/",
+			request: func() *http.Request {
+				return formRequest("http://10.0.0.7:8080/v1/sms/webhook/twilio", body)
+			},
+			sig: twilioFixtureSig,
+		},
+		{
+			name: "forwarded proto",
+			request: func() *http.Request {
+				r := formRequest("http://api.safetrace.ng/v1/sms/webhook/twilio", body)
+				r.Header.Set("X-Forwarded-Proto", "https")
+				return r
+			},
+			sig: twilioFixtureSig,
+		},
+		{
+			name: "served over TLS",
+			request: func() *http.Request {
+				r := formRequest(twilioFixtureURL, body)
+				r.TLS = &tls.ConnectionState{}
+				return r
+			},
+			sig: twilioFixtureSig,
+		},
+		{
+			name: "plain http without a forwarded proto",
+			request: func() *http.Request {
+				return formRequest("http://api.safetrace.ng/v1/sms/webhook/twilio", body)
+			},
+			sig:     twilioFixtureSig,
+			wantErr: ErrInboundSMSUnauthenticated,
+		},
+		{
+			name:    "wrong signature",
+			baseURL: "https://api.safetrace.ng",
+			request: func() *http.Request {
+				return formRequest("/v1/sms/webhook/twilio", body)
+			},
+			sig:     "vOEb5UThFn24KEfnOFLQY2AE5FY=",
+			wantErr: ErrInboundSMSUnauthenticated,
+		},
+		{
+			name:    "tampered body",
+			baseURL: "https://api.safetrace.ng",
+			request: func() *http.Request {
+				return formRequest("/v1/sms/webhook/twilio", bytes.Replace(body, []byte("SAFE"), []byte("HELP"), 1))
+			},
+			sig:     twilioFixtureSig,
+			wantErr: ErrInboundSMSUnauthenticated,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := inboundProvider(t, &config.Config{TwilioAuthToken: twilioTestToken, PublicBaseURL: tt.baseURL}, ProviderTwilio)
+			r := tt.request()
+			r.Header.Set("X-Twilio-Signature", tt.sig)
+			msg, err := provider.ParseInbound(r)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ParseInbound() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			want := InboundSMS{Provider: ProviderTwilio, From: "+2348031234567", To: "+2342013300000", Body: "SAFE 1234", MessageID: "SM1f0e8ae6ade43cb3c0ce4525424e404f"}
+			if *msg != want {
+				t.Errorf("ParseInbound() = %+v, want %+v", *msg, want)
+			}
+		})
+	}
+}
+
+// Twilio's own published example, with the query string it signs
+func TestTwilioInboundReferenceVector(t *testing.T) {
+	provider := inboundProvider(t, &config.Config{TwilioAuthToken: "12345", PublicBaseURL: "https://mycompany.com"}, ProviderTwilio)
+	form := "CallSid=CA1234567890ABCDE&Caller=%2B14158675309&Digits=1234&From=%2B14158675309&To=%2B18005551212&ReasonConferenceEnded=test&Reason=Participant"
+	r := formRequest("/myapp.php?foo=1&bar=2", []byte(form))
+	r.Header.Set("X-Twilio-Signature", "vOEb5UThFn24KEfnOFLQY2AE5FY=")
+	if _, err := provider.ParseInbound(r); err != nil {
+		t.Fatalf("ParseInbound() = %v", err)
+	}
+}
+
+func TestTermiiInbound(t *testing.T) {
+	body := fixture(t, "termii.json")
+	provider := inboundProvider(t, &config.Config{TermiiWebhookSecret: termiiTestSecret}, ProviderTermii)
+
+	tests := []struct {
+		name    string
+		body    []byte
+		sig     string
+		wantErr error
+	}{
+		{"valid", body, termiiFixtureSig, nil},
+		{"upper-case hex", body, strings.ToUpper(termiiFixtureSig), nil},
+		{"missing signature", body, "", ErrInboundSMSUnauthenticated},
+		{"tampered body", bytes.Replace(body, []byte("HELP"), []byte("SAFE"), 1), termiiFixtureSig, ErrInboundSMSUnauthenticated},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/v1/sms/webhook/termii", bytes.NewReader(tt.body))
+			r.Header.Set("Content-Type", "application/json")
+			r.Header.Set("X-Termii-Signature", tt.sig)
+			msg, err := provider.ParseInbound(r)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ParseInbound() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			want := InboundSMS{Provider: ProviderTermii, From: "+2348031234567", To: "SafeTrace", Body: "HELP Ikeja", MessageID: "2d6a3b1c-5f2e-4a7b-9c1d-0e8f7a6b5c4d"}
+			if *msg != want {
+				t.Errorf("ParseInbound() = %+v, want %+v", *msg, want)
+			}
+		})
+	}
+}
+
+func TestAfricasTalkingInbound(t *testing.T) {
+	body := fixture(t, "africastalking.form")
+	provider := inboundProvider(t, &config.Config{AfricasTalkingWebhookToken: atTestToken}, ProviderAfricasTalking)
+
+	tests := []struct {
+		name    string
+		target  string
+		wantErr error
+	}{
+		{"valid", "/v1/sms/webhook/africastalking?token=" + atTestToken, nil},
+		{"wrong token", "/v1/sms/webhook/africastalking?token=guess", ErrInboundSMSUnauthenticated},
+		{"no token", "/v1/sms/webhook/africastalking", ErrInboundSMSUnauthenticated},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg, err := provider.ParseInbound(formRequest(tt.target, body))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ParseInbound() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			want := InboundSMS{Provider: ProviderAfricasTalking, From: "+2348031234567", To: "24466", Body: "SAFE", MessageID: "ATXid_5b8e2f0c1d9a4c7e"}
+			if *msg != want {
+				t.Errorf("ParseInbound() = %+v, want %+v", *msg, want)
+			}
+		})
+	}
+}
+
+func TestInboundRespond(t *testing.T) {
+	cfg := &config.Config{TwilioAuthToken: "t", TermiiWebhookSecret: "s", AfricasTalkingWebhookToken: "a"}
+	tests := []struct {
+		provider  string
+		reply     string
+		wantSent  bool
+		wantBody  string
+		wantCType string
+	}{
+		{ProviderTwilio, "Stay safe & <reply> STOP", true, `<?xml version="1.0" encoding="UTF-8"?><Response><Message>Stay safe &amp; &lt;reply&gt; STOP</Message></Response>`, "application/xml"},
+		{ProviderTwilio, "", true, `<?xml version="1.0" encoding="UTF-8"?><Response/>`, "application/xml"},
+		{ProviderTermii, "Stay safe", false, `{"status":"received"}`, "application/json"},
+		{ProviderAfricasTalking, "Stay safe", false, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.provider+"/"+tt.reply, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			sent := inboundProvider(t, cfg, tt.provider).Respond(w, tt.reply)
+			if sent != tt.wantSent {
+				t.Errorf("Respond() = %v, want %v", sent, tt.wantSent)
+			}
+			if w.Code != http.StatusOK || w.Body.String() != tt.wantBody || w.Header().Get("Content-Type") != tt.wantCType {
+				t.Errorf("response %d %q %q, want 200 %q %q", w.Code, w.Header().Get("Content-Type"), w.Body.String(), tt.wantCType, tt.wantBody)
+			}
+		})
+	}
+}
+
+// A reply goes out through the provider the text came in on, so it comes
+// from the number the sender wrote to
+func TestSMSRouterSendFrom(t *testing.T) {
+	router := NewSMSRouter(&config.Config{SMSDefaultProvider: ProviderTermii}, nil, nil)
+	termii, at := NewFakeSMSProvider(ProviderTermii), NewFakeSMSProvider(ProviderAfricasTalking)
+	router.Register(termii)
+	router.Register(at)
+	ctx := context.Background()
+
+	if err := router.SendFrom(ctx, ProviderAfricasTalking, "+2348031234567", "reply"); err != nil {
+		t.Fatalf("SendFrom: %v", err)
+	}
+	if len(at.Sent) != 1 || len(termii.Sent) != 0 {
+		t.Fatalf("sent %d via Africa's Talking and %d via Termii, want 1 and 0", len(at.Sent), len(termii.Sent))
+	}
+
+	// An unknown provider is routed as any message
+	if err := router.SendFrom(ctx, "unknown", "+2348031234567", "reply"); err != nil {
+		t.Fatalf("SendFrom: %v", err)
+	}
+	if len(termii.Sent) != 1 {
+		t.Errorf("sent %d via the default provider, want 1", len(termii.Sent))
+	}
+}
@@ -10,6 +10,9 @@ import (
 	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
 )
 
+// maxDeviceIDLength matches the heartbeats.device_id column
+const maxDeviceIDLength = 64
+
 // SMSParser handles parsing of compressed SMS heartbeat payloads
 type SMSParser struct{}
 
@@ -17,8 +20,49 @@ func NewSMSParser() *SMSParser {
 	return &SMSParser{}
 }
 
+// PanicSMS is a degraded distress message from a registered phone:
+// "HELP", or "LG lat,lng" with the sender's last known position
+type PanicSMS struct {
+	Keyword     string
+	Lat         float64
+	Lng         float64
+	HasLocation bool
+}
+
+// ParsePanicSMS recognizes the short panic formats. ok is false for anything else.
+func ParsePanicSMS(smsBody string) (*PanicSMS, bool) {
+	fields := strings.Fields(strings.TrimSpace(smsBody))
+	if len(fields) == 0 {
+		return nil, false
+	}
+
+	keyword := strings.ToUpper(strings.Trim(fields[0], ".!"))
+	switch {
+	case keyword == "HELP" && len(fields) == 1:
+		return &PanicSMS{Keyword: keyword}, true
+
+	case keyword == "LG" && len(fields) >= 2:
+		coords := strings.Split(strings.Join(fields[1:], ""), ",")
+		if len(coords) != 2 {
+			return nil, false
+		}
+		lat, err := strconv.ParseFloat(coords[0], 64)
+		if err != nil || lat < -90 || lat > 90 {
+			return nil, false
+		}
+		lng, err := strconv.ParseFloat(coords[1], 64)
+		if err != nil || lng < -180 || lng > 180 {
+			return nil, false
+		}
+		return &PanicSMS{Keyword: keyword, Lat: lat, Lng: lng, HasLocation: true}, true
+	}
+
+	return nil, false
+}
+
 // ParseHeartbeatSMS parses compressed SMS format:
 // uid=uuid;ts=2025-11-19T12:50Z;lat=6.5244;lng=3.3792;acc=200;cell=621,20,12345,678,-85;sig=abc123
+// with optional bat, spd, lg and dev (device_id) fields before sig
 func (sp *SMSParser) ParseHeartbeatSMS(smsBody string) (*models.Heartbeat, error) {
 	parts := strings.Split(smsBody, ";")
 	if len(parts) < 6 {
@@ -42,11 +86,11 @@ func (sp *SMSParser) ParseHeartbeatSMS(smsBody string) (*models.Heartbeat, error
 
 		switch key {
 		case "uid":
-			userID, err := uuid.Parse(value)
-			if err != nil {
-				return nil, fmt.Errorf("invalid user ID: %w", err)
+			// Carriers mangle multipart messages; a bad uid is left unset so
+			// the handler can fall back to the sender's phone
+			if userID, err := uuid.Parse(value); err == nil {
+				hb.UserID = userID
 			}
-			hb.UserID = userID
 
 		case "ts":
 			timestamp, err := time.Parse(time.RFC3339, value)
@@ -98,15 +142,18 @@ func (sp *SMSParser) ParseHeartbeatSMS(smsBody string) (*models.Heartbeat, error
 		case "lg":
 			hb.LastGasp = value == "1" || value == "true"
 
+		case "dev":
+			if len(value) > maxDeviceIDLength {
+				return nil, fmt.Errorf("invalid device id: longer than %d characters", maxDeviceIDLength)
+			}
+			hb.DeviceID = value
+
 		case "sig":
 			hb.Signature = value
 		}
 	}
 
-	// Validate required fields
-	if hb.UserID == uuid.Nil {
-		return nil, fmt.Errorf("missing user ID")
-	}
+	// Validate required fields. UserID may be uuid.Nil if uid was missing or corrupted.
 	if hb.Timestamp.IsZero() {
 		return nil, fmt.Errorf("missing timestamp")
 	}
@@ -117,7 +164,8 @@ func (sp *SMSParser) ParseHeartbeatSMS(smsBody string) (*models.Heartbeat, error
 	return hb, nil
 }
 
-// parseCellInfo parses cell info from CSV format: mcc,mnc,cid,lac,rssi
+// parseCellInfo parses cell info from CSV format: mcc,mnc,cid,lac,rssi with
+// an optional network type after it, normalized
 func (sp *SMSParser) parseCellInfo(cellStr string) (models.CellInfo, error) {
 	parts := strings.Split(cellStr, ",")
 	if len(parts) < 5 {
@@ -134,12 +182,13 @@ func (sp *SMSParser) parseCellInfo(cellStr string) (models.CellInfo, error) {
 		return models.CellInfo{}, fmt.Errorf("invalid MNC: %w", err)
 	}
 
-	cid, err := strconv.Atoi(parts[2])
+	// NR cell identities don't fit in 32 bits
+	cid, err := strconv.ParseInt(parts[2], 10, 64)
 	if err != nil {
 		return models.CellInfo{}, fmt.Errorf("invalid CID: %w", err)
 	}
 
-	lac, err := strconv.Atoi(parts[3])
+	lac, err := strconv.ParseInt(parts[3], 10, 64)
 	if err != nil {
 		return models.CellInfo{}, fmt.Errorf("invalid LAC: %w", err)
 	}
@@ -149,26 +198,41 @@ func (sp *SMSParser) parseCellInfo(cellStr string) (models.CellInfo, error) {
 		return models.CellInfo{}, fmt.Errorf("invalid RSSI: %w", err)
 	}
 
-	return models.CellInfo{
-		MCC:  mcc,
-		MNC:  mnc,
-		CID:  cid,
-		LAC:  lac,
-		RSSI: rssi,
-	}, nil
+	networkType := ""
+	if len(parts) > 5 {
+		networkType = parts[5]
+	}
+
+	cellInfo := NormalizeCellInfo(models.CellInfo{
+		MCC:         mcc,
+		MNC:         mnc,
+		CID:         models.CellIdentifier(cid),
+		LAC:         models.CellIdentifier(lac),
+		RSSI:        rssi,
+		NetworkType: networkType,
+	})
+	if err := ValidateCellInfo(cellInfo); err != nil {
+		return models.CellInfo{}, err
+	}
+	return cellInfo, nil
 }
 
 // BuildSMSPayload creates compressed SMS payload (for mobile client reference)
 func (sp *SMSParser) BuildSMSPayload(hb *models.Heartbeat) string {
+	cell := fmt.Sprintf("cell=%d,%d,%d,%d,%d",
+		hb.CellInfo.MCC, hb.CellInfo.MNC, hb.CellInfo.CID,
+		hb.CellInfo.LAC, hb.CellInfo.RSSI)
+	if hb.CellInfo.NetworkType != "" {
+		cell += "," + hb.CellInfo.NetworkType
+	}
+
 	parts := []string{
 		fmt.Sprintf("uid=%s", hb.UserID),
 		fmt.Sprintf("ts=%s", hb.Timestamp.Format(time.RFC3339)),
 		fmt.Sprintf("lat=%.6f", hb.Lat),
 		fmt.Sprintf("lng=%.6f", hb.Lng),
 		fmt.Sprintf("acc=%d", hb.AccuracyM),
-		fmt.Sprintf("cell=%d,%d,%d,%d,%d",
-			hb.CellInfo.MCC, hb.CellInfo.MNC, hb.CellInfo.CID,
-			hb.CellInfo.LAC, hb.CellInfo.RSSI),
+		cell,
 	}
 
 	if hb.BatteryPct != nil {
@@ -183,6 +247,10 @@ func (sp *SMSParser) BuildSMSPayload(hb *models.Heartbeat) string {
 		parts = append(parts, "lg=1")
 	}
 
+	if hb.DeviceID != "" {
+		parts = append(parts, fmt.Sprintf("dev=%s", hb.DeviceID))
+	}
+
 	parts = append(parts, fmt.Sprintf("sig=%s", hb.Signature))
 
 	return strings.Join(parts, ";")
@@ -0,0 +1,64 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+// A uid mangled in transit is left unset rather than failing the message,
+// so the sender's phone can identify it
+func TestParseHeartbeatSMSUID(t *testing.T) {
+	userID := uuid.New()
+	rest := ";ts=2025-11-19T12:50:00Z;lat=6.5244;lng=3.3792;acc=200;cell=621,20,12345,678,-85;sig=abc123"
+	tests := []struct {
+		name string
+		uid  string
+		want uuid.UUID
+	}{
+		{"valid", "uid=" + userID.String(), userID},
+		{"truncated", "uid=" + userID.String()[:20], uuid.Nil},
+		{"garbled", "uid=0b5c5a0f-3d81-4824-82e1-3803d1@56b95", uuid.Nil},
+		{"empty", "uid=", uuid.Nil},
+		{"missing", "v=1", uuid.Nil},
+	}
+	parser := NewSMSParser()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hb, err := parser.ParseHeartbeatSMS(tt.uid + rest)
+			if err != nil {
+				t.Fatalf("ParseHeartbeatSMS: %v", err)
+			}
+			if hb.UserID != tt.want {
+				t.Errorf("UserID = %s, want %s", hb.UserID, tt.want)
+			}
+		})
+	}
+}
+
+func TestParsePanicSMS(t *testing.T) {
+	tests := []struct {
+		body   string
+		want   *PanicSMS
+		wantOK bool
+	}{
+		{"HELP", &PanicSMS{Keyword: "HELP"}, true},
+		{"  help! ", &PanicSMS{Keyword: "HELP"}, true},
+		{"LG 6.5244,3.3792", &PanicSMS{Keyword: "LG", Lat: 6.5244, Lng: 3.3792, HasLocation: true}, true},
+		{"lg 6.5244, 3.3792", &PanicSMS{Keyword: "LG", Lat: 6.5244, Lng: 3.3792, HasLocation: true}, true},
+		{"HELP me", nil, false},
+		{"LG", nil, false},
+		{"LG 6.5244", nil, false},
+		{"LG 96.5,3.3", nil, false},
+		{"LG 6.5,183.3", nil, false},
+		{"LG six,three", nil, false},
+		{"", nil, false},
+		{"uid=abc;ts=2025-11-19T12:50Z", nil, false},
+	}
+	for _, tt := range tests {
+		got, ok := ParsePanicSMS(tt.body)
+		if ok != tt.wantOK || (ok && *got != *tt.want) {
+			t.Errorf("ParsePanicSMS(%q) = %+v, %v, want %+v, %v", tt.body, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
@@ -0,0 +1,370 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/twilio/twilio-go"
+	twilioApi "github.com/twilio/twilio-go/rest/api/v2010"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/utils"
+)
+
+const (
+	ProviderTwilio         = "twilio"
+	ProviderTermii         = "termii"
+	ProviderAfricasTalking = "africastalking"
+)
+
+// Outcomes of a provider's delivery report
+const (
+	SMSReportPending   = "pending" // queued, sent or otherwise not final yet
+	SMSReportDelivered = "delivered"
+	SMSReportFailed    = "failed"
+)
+
+// ErrStatusCallbackUnauthenticated is returned for a delivery report that
+// fails the provider's authenticity check
+var ErrStatusCallbackUnauthenticated = errors.New("SMS status callback failed authentication")
+
+// SMSProvider is implemented by every outbound SMS gateway
+type SMSProvider interface {
+	// Name returns the provider key used in config and callback URLs
+	Name() string
+	// Send delivers a message and returns the provider's message ID
+	Send(ctx context.Context, to, message string) (string, error)
+	// ParseStatusCallback checks that a delivery report webhook came from
+	// the provider, returning ErrStatusCallbackUnauthenticated if it didn't,
+	// and extracts the message ID and the outcome, an SMSReport value
+	ParseStatusCallback(r *http.Request) (messageID, outcome string, err error)
+}
+
+var providerHTTPClient = &http.Client{Timeout: 10 * time.Second}
+
+// TwilioProvider sends SMS via Twilio. Its delivery reports are signed with
+// X-Twilio-Signature over the status callback URL.
+type TwilioProvider struct {
+	client         *twilio.RestClient
+	authToken      string
+	from           string
+	statusCallback string
+}
+
+func NewTwilioProvider(accountSID, authToken, from, statusCallback string) *TwilioProvider {
+	return &TwilioProvider{
+		client: twilio.NewRestClientWithParams(twilio.ClientParams{
+			Username: accountSID,
+			Password: authToken,
+		}),
+		authToken:      authToken,
+		from:           from,
+		statusCallback: statusCallback,
+	}
+}
+
+func (p *TwilioProvider) Name() string {
+	return ProviderTwilio
+}
+
+func (p *TwilioProvider) Send(ctx context.Context, to, message string) (string, error) {
+	params := &twilioApi.CreateMessageParams{}
+	params.SetTo(to)
+	params.SetFrom(p.from)
+	params.SetBody(message)
+	if p.statusCallback != "" {
+		params.SetStatusCallback(p.statusCallback)
+	}
+
+	resp, err := p.client.Api.CreateMessage(params)
+	if err != nil {
+		return "", fmt.Errorf("twilio SMS error: %w", err)
+	}
+
+	if resp.ErrorCode != nil {
+		return "", fmt.Errorf("twilio error code: %d, message: %s", *resp.ErrorCode, *resp.ErrorMessage)
+	}
+
+	if resp.Sid == nil {
+		return "", nil
+	}
+	return *resp.Sid, nil
+}
+
+func (p *TwilioProvider) ParseStatusCallback(r *http.Request) (string, string, error) {
+	if err := r.ParseForm(); err != nil {
+		return "", "", err
+	}
+	if p.statusCallback == "" || !twilioSignatureValid(p.authToken, p.statusCallback, r) {
+		return "", "", ErrStatusCallbackUnauthenticated
+	}
+	messageID := r.PostForm.Get("MessageSid")
+	if messageID == "" {
+		return "", "", fmt.Errorf("missing MessageSid")
+	}
+
+	switch r.PostForm.Get("MessageStatus") {
+	case "delivered":
+		return messageID, SMSReportDelivered, nil
+	case "failed", "undelivered":
+		return messageID, SMSReportFailed, nil
+	}
+	return messageID, SMSReportPending, nil
+}
+
+// TermiiProvider sends SMS via Termii. The "dnd" channel is used so messages
+// reach MTN and Glo subscribers who have Do-Not-Disturb enabled. Delivery
+// reports are signed like inbound messages, with TERMII_WEBHOOK_SECRET.
+type TermiiProvider struct {
+	apiKey        string
+	senderID      string
+	webhookSecret string
+	baseURL       string
+}
+
+func NewTermiiProvider(apiKey, senderID, webhookSecret string) *TermiiProvider {
+	return &TermiiProvider{
+		apiKey:        apiKey,
+		senderID:      senderID,
+		webhookSecret: webhookSecret,
+		baseURL:       "https://api.ng.termii.com",
+	}
+}
+
+func (p *TermiiProvider) Name() string {
+	return ProviderTermii
+}
+
+func (p *TermiiProvider) Send(ctx context.Context, to, message string) (string, error) {
+	payload, err := json.Marshal(map[string]string{
+		"to":      strings.TrimPrefix(utils.NormalizePhone(to), "+"),
+		"from":    p.senderID,
+		"sms":     message,
+		"type":    "plain",
+		"channel": "dnd",
+		"api_key": p.apiKey,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.baseURL+"/api/sms/send", bytes.NewReader(payload))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := providerHTTPClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("termii SMS error: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		MessageID string `json:"message_id"`
+		Message   string `json:"message"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("termii response error: %w", err)
+	}
+	if resp.StatusCode >= 300 {
+		return "", fmt.Errorf("termii error: status %d, message: %s", resp.StatusCode, result.Message)
+	}
+
+	return result.MessageID, nil
+}
+
+func (p *TermiiProvider) ParseStatusCallback(r *http.Request) (string, string, error) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return "", "", err
+	}
+	if p.webhookSecret == "" || !TermiiSignatureValid(p.webhookSecret, body, r.Header.Get("X-Termii-Signature")) {
+		return "", "", ErrStatusCallbackUnauthenticated
+	}
+
+	var report struct {
+		MessageID string `json:"message_id"`
+		Status    string `json:"status"`
+	}
+	if err := json.Unmarshal(body, &report); err != nil {
+		return "", "", err
+	}
+	if report.MessageID == "" {
+		return "", "", fmt.Errorf("missing message_id")
+	}
+
+	switch report.Status {
+	case "Message Failed", "DND Active on Phone Number", "Rejected", "Expired":
+		return report.MessageID, SMSReportFailed, nil
+	case "DELIVERED", "Delivered":
+		return report.MessageID, SMSReportDelivered, nil
+	}
+	return report.MessageID, SMSReportPending, nil
+}
+
+// AfricasTalkingProvider sends SMS via Africa's Talking. Its delivery reports
+// aren't signed, so the callback URL carries AFRICASTALKING_WEBHOOK_TOKEN,
+// ?token=..., as the inbound one does.
+type AfricasTalkingProvider struct {
+	username     string
+	apiKey       string
+	senderID     string
+	webhookToken string
+	baseURL      string
+}
+
+func NewAfricasTalkingProvider(username, apiKey, senderID, webhookToken string) *AfricasTalkingProvider {
+	baseURL := "https://api.africastalking.com"
+	if username == "sandbox" {
+		baseURL = "https://api.sandbox.africastalking.com"
+	}
+	return &AfricasTalkingProvider{
+		username:     username,
+		apiKey:       apiKey,
+		senderID:     senderID,
+		webhookToken: webhookToken,
+		baseURL:      baseURL,
+	}
+}
+
+func (p *AfricasTalkingProvider) Name() string {
+	return ProviderAfricasTalking
+}
+
+func (p *AfricasTalkingProvider) Send(ctx context.Context, to, message string) (string, error) {
+	form := url.Values{}
+	form.Set("username", p.username)
+	form.Set("to", utils.NormalizePhone(to))
+	form.Set("message", message)
+	if p.senderID != "" {
+		form.Set("from", p.senderID)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.baseURL+"/version1/messaging", strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("apiKey", p.apiKey)
+
+	resp, err := providerHTTPClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("africastalking SMS error: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		SMSMessageData struct {
+			Message    string `json:"Message"`
+			Recipients []struct {
+				MessageID string `json:"messageId"`
+				Status    string `json:"status"`
+			} `json:"Recipients"`
+		} `json:"SMSMessageData"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("africastalking response error: %w", err)
+	}
+	if len(result.SMSMessageData.Recipients) == 0 {
+		return "", fmt.Errorf("africastalking error: %s", result.SMSMessageData.Message)
+	}
+
+	recipient := result.SMSMessageData.Recipients[0]
+	if recipient.Status != "Success" {
+		return "", fmt.Errorf("africastalking error: %s", recipient.Status)
+	}
+
+	return recipient.MessageID, nil
+}
+
+func (p *AfricasTalkingProvider) ParseStatusCallback(r *http.Request) (string, string, error) {
+	token := r.URL.Query().Get("token")
+	if p.webhookToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(p.webhookToken)) != 1 {
+		return "", "", ErrStatusCallbackUnauthenticated
+	}
+	if err := r.ParseForm(); err != nil {
+		return "", "", err
+	}
+	messageID := r.PostForm.Get("id")
+	if messageID == "" {
+		return "", "", fmt.Errorf("missing id")
+	}
+
+	switch r.PostForm.Get("status") {
+	case "Success":
+		return messageID, SMSReportDelivered, nil
+	case "Failed", "Rejected":
+		return messageID, SMSReportFailed, nil
+	}
+	return messageID, SMSReportPending, nil
+}
+
+// FakeSMSProvider records messages in memory instead of sending them.
+// Use it in tests and local development.
+type FakeSMSProvider struct {
+	name string
+
+	mu   sync.Mutex
+	Sent []FakeSMS
+	// Fail makes every Send return this error when set
+	Fail error
+}
+
+// FakeSMS is a message captured by FakeSMSProvider
+type FakeSMS struct {
+	ID      string
+	To      string
+	Message string
+}
+
+func NewFakeSMSProvider(name string) *FakeSMSProvider {
+	return &FakeSMSProvider{name: name}
+}
+
+func (p *FakeSMSProvider) Name() string {
+	return p.name
+}
+
+func (p *FakeSMSProvider) Send(ctx context.Context, to, message string) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.Fail != nil {
+		return "", p.Fail
+	}
+
+	id := uuid.New().String()
+	p.Sent = append(p.Sent, FakeSMS{ID: id, To: to, Message: message})
+	return id, nil
+}
+
+func (p *FakeSMSProvider) ParseStatusCallback(r *http.Request) (string, string, error) {
+	if err := r.ParseForm(); err != nil {
+		return "", "", err
+	}
+
+	outcome := r.PostForm.Get("status")
+	if outcome != SMSReportDelivered && outcome != SMSReportFailed {
+		outcome = SMSReportPending
+	}
+	return r.PostForm.Get("id"), outcome, nil
+}
+
+// Messages returns a copy of the captured messages
+func (p *FakeSMSProvider) Messages() []FakeSMS {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]FakeSMS(nil), p.Sent...)
+}
@@ -0,0 +1,160 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/config"
+)
+
+// Delivery reports as each provider sends them, signed outside Go like the
+// inbound fixtures
+const (
+	twilioStatusURL  = "https://api.safetrace.ng/v1/sms/status/twilio"
+	twilioStatusForm = "MessageSid=SM5c0f2b8e1f9d4e7a&MessageStatus=undelivered&ErrorCode=30003&To=%2B2348031234567"
+	twilioStatusSig  = "Z62IIsVeVIj7EtawDUPHuzKLgjo="
+	termiiStatusBody = `{"message_id":"3017544054459136","status":"Message Failed","receiver":"2348031234567"}`
+	termiiStatusSig  = "abe9f422c476808e602c29b884dd1671002ad00e070dac5d98e6c8913921ff4e897d60e5ae8a03d2316678aaf505d3a22b17bbc8ab38dd95ee72dd837dce5167"
+	atStatusForm     = "id=ATXid_4d1f&status=Failed&phoneNumber=%2B2348031234567"
+)
+
+// A delivery report is only read once it is shown to come from the
+// provider, and never when the secret to check it with isn't configured
+func TestStatusCallbackAuthentication(t *testing.T) {
+	twilio := NewTwilioProvider("AC123", twilioTestToken, "+15005550006", twilioStatusURL)
+	termii := NewTermiiProvider("key", "SafeTrace", termiiTestSecret)
+	at := NewAfricasTalkingProvider("safetrace", "key", "", atTestToken)
+
+	twilioRequest := func(form, sig string) *http.Request {
+		r := formRequest("http://10.0.0.7:8080/v1/sms/status/twilio", []byte(form))
+		r.Header.Set("X-Twilio-Signature", sig)
+		return r
+	}
+	termiiRequest := func(body, sig string) *http.Request {
+		r := httptest.NewRequest(http.MethodPost, "/v1/sms/status/termii", bytes.NewReader([]byte(body)))
+		r.Header.Set("Content-Type", "application/json")
+		r.Header.Set("X-Termii-Signature", sig)
+		return r
+	}
+
+	tests := []struct {
+		name        string
+		provider    SMSProvider
+		request     *http.Request
+		wantID      string
+		wantOutcome string
+		wantErr     error
+	}{
+		{"twilio signed", twilio, twilioRequest(twilioStatusForm, twilioStatusSig), "SM5c0f2b8e1f9d4e7a", SMSReportFailed, nil},
+		{"twilio unsigned", twilio, twilioRequest(twilioStatusForm, ""), "", "", ErrStatusCallbackUnauthenticated},
+		{"twilio forged outcome", twilio, twilioRequest(strings.Replace(twilioStatusForm, "undelivered", "delivered", 1), twilioStatusSig), "", "", ErrStatusCallbackUnauthenticated},
+		{"twilio without a callback URL", NewTwilioProvider("AC123", twilioTestToken, "+15005550006", ""), twilioRequest(twilioStatusForm, twilioStatusSig), "", "", ErrStatusCallbackUnauthenticated},
+		{"termii signed", termii, termiiRequest(termiiStatusBody, termiiStatusSig), "3017544054459136", SMSReportFailed, nil},
+		{"termii unsigned", termii, termiiRequest(termiiStatusBody, ""), "", "", ErrStatusCallbackUnauthenticated},
+		{"termii tampered", termii, termiiRequest(`{"message_id":"3017544054459136","status":"Delivered"}`, termiiStatusSig), "", "", ErrStatusCallbackUnauthenticated},
+		{"termii without a secret", NewTermiiProvider("key", "SafeTrace", ""), termiiRequest(termiiStatusBody, termiiStatusSig), "", "", ErrStatusCallbackUnauthenticated},
+		{"africastalking token", at, formRequest("/v1/sms/status/africastalking?token="+atTestToken, []byte(atStatusForm)), "ATXid_4d1f", SMSReportFailed, nil},
+		{"africastalking wrong token", at, formRequest("/v1/sms/status/africastalking?token=guess", []byte(atStatusForm)), "", "", ErrStatusCallbackUnauthenticated},
+		{"africastalking no token", at, formRequest("/v1/sms/status/africastalking", []byte(atStatusForm)), "", "", ErrStatusCallbackUnauthenticated},
+		{"africastalking without a token", NewAfricasTalkingProvider("safetrace", "key", "", ""), formRequest("/v1/sms/status/africastalking?token=", []byte(atStatusForm)), "", "", ErrStatusCallbackUnauthenticated},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, outcome, err := tt.provider.ParseStatusCallback(tt.request)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ParseStatusCallback() error = %v, want %v", err, tt.wantErr)
+			}
+			if id != tt.wantID || outcome != tt.wantOutcome {
+				t.Errorf("ParseStatusCallback() = %q, %q, want %q, %q", id, outcome, tt.wantID, tt.wantOutcome)
+			}
+		})
+	}
+}
+
+func newTestRetryRouter(t *testing.T, perMinute int) (*SMSRouter, *FakeSMSProvider, *FakeSMSProvider) {
+	t.Helper()
+	redis := testRedis(t)
+	budget := NewOutboundBudget(config.NewStore(&config.Config{
+		OutboundMessagesPerMinute:     perMinute,
+		OutboundMessagesPerDay:        1000,
+		OutboundEmergencyReservePct:   50,
+		OutboundEmergencyOverdraftPct: 50,
+	}), redis)
+	router := NewSMSRouter(&config.Config{SMSDefaultProvider: ProviderTermii}, redis, budget)
+	termii, at := NewFakeSMSProvider(ProviderTermii), NewFakeSMSProvider(ProviderAfricasTalking)
+	router.Register(termii)
+	router.Register(at)
+	return router, termii, at
+}
+
+// A failure reported twice, as providers do and as two instances may each
+// receive, is retried once
+func TestHandleDeliveryFailureRetriesOnce(t *testing.T) {
+	router, termii, at := newTestRetryRouter(t, 100)
+	ctx := withSMSKind(context.Background(), MessageAlert)
+
+	if err := router.sendVia(ctx, termii, "+2348031234567", "alert", 1); err != nil {
+		t.Fatalf("sendVia: %v", err)
+	}
+	id := termii.Messages()[0].ID
+	for i := 0; i < 3; i++ {
+		if err := router.HandleDeliveryFailure(context.Background(), ProviderTermii, id); err != nil {
+			t.Fatalf("HandleDeliveryFailure #%d: %v", i+1, err)
+		}
+	}
+	if sent := at.Messages(); len(sent) != 1 {
+		t.Fatalf("%d retries via Africa's Talking, want 1", len(sent))
+	}
+
+	// The retry failing too is the last attempt
+	delivery, err := router.Delivery(context.Background(), ProviderAfricasTalking, at.Messages()[0].ID)
+	if err != nil || delivery == nil || delivery.Attempts != smsMaxAttempts {
+		t.Fatalf("retry tracked as %+v (%v), want attempt %d", delivery, err, smsMaxAttempts)
+	}
+	if err := router.HandleDeliveryFailure(context.Background(), ProviderAfricasTalking, delivery.MessageID); err != nil {
+		t.Fatalf("HandleDeliveryFailure: %v", err)
+	}
+	if len(termii.Messages()) != 1 {
+		t.Errorf("retried past %d attempts", smsMaxAttempts)
+	}
+}
+
+// Retries are counted against the outbound budget: once it is spent a
+// non-emergency retry is dropped, and an emergency one still goes out
+func TestHandleDeliveryFailureBudget(t *testing.T) {
+	router, termii, at := newTestRetryRouter(t, 2)
+
+	for _, kind := range []string{MessageReply, MessageAlert} {
+		if err := router.budget.Claim(context.Background(), kind); err != nil {
+			t.Fatalf("Claim(%s): %v", kind, err)
+		}
+	}
+
+	reply := withSMSKind(context.Background(), MessageReply)
+	if err := router.sendVia(reply, termii, "+2348031234567", "reply", 1); err != nil {
+		t.Fatalf("sendVia: %v", err)
+	}
+	err := router.HandleDeliveryFailure(context.Background(), ProviderTermii, termii.Messages()[0].ID)
+	if !errors.Is(err, ErrOutboundBudget) {
+		t.Fatalf("HandleDeliveryFailure() error = %v, want ErrOutboundBudget", err)
+	}
+	if len(at.Messages()) != 0 {
+		t.Fatalf("a reply was retried past the budget")
+	}
+
+	alert := withSMSKind(context.Background(), MessageAlert)
+	if err := router.sendVia(alert, termii, "+2348031234567", "alert", 1); err != nil {
+		t.Fatalf("sendVia: %v", err)
+	}
+	if err := router.HandleDeliveryFailure(context.Background(), ProviderTermii, termii.Messages()[1].ID); err != nil {
+		t.Fatalf("HandleDeliveryFailure: %v", err)
+	}
+	if len(at.Messages()) != 1 {
+		t.Errorf("an alert retry was dropped")
+	}
+}
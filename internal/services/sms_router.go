@@ -0,0 +1,322 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/config"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/database"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+)
+
+const (
+	// smsDeliveryTTL is how long we wait for a delivery report before forgetting a message
+	smsDeliveryTTL = 24 * time.Hour
+	// smsMaxAttempts caps retries across providers for a single message
+	smsMaxAttempts = 2
+)
+
+// alertSMSKey is the context key of the alert a message being sent carries,
+// so the carrier's delivery report can be traced back to the alert
+type alertSMSKey struct{}
+
+// withAlertSMS marks messages sent with ctx as carrying the alert
+func withAlertSMS(ctx context.Context, alertID uuid.UUID) context.Context {
+	return context.WithValue(ctx, alertSMSKey{}, alertID)
+}
+
+// smsKindKey is the context key of the outbound budget's type of a message
+// being sent, so a retry of it is counted as the same type
+type smsKindKey struct{}
+
+// withSMSKind marks messages sent with ctx as of kind, a Message* type
+func withSMSKind(ctx context.Context, kind string) context.Context {
+	return context.WithValue(ctx, smsKindKey{}, kind)
+}
+
+// smsReceiptKey is the context key of the smsReceipt a message being sent
+// fills in
+type smsReceiptKey struct{}
+
+// smsReceipt is the provider that accepted a message and its ID there
+type smsReceipt struct {
+	Provider  string
+	MessageID string
+}
+
+// withSMSReceipt has the provider and message ID of a message sent with ctx
+// written to receipt once a provider accepts it
+func withSMSReceipt(ctx context.Context, receipt *smsReceipt) context.Context {
+	return context.WithValue(ctx, smsReceiptKey{}, receipt)
+}
+
+// SMSRouter picks the provider most likely to deliver to a destination's carrier
+// and retries on another provider when delivery fails. The first attempt is
+// counted against the outbound budget by the caller; retries are counted here.
+type SMSRouter struct {
+	mu              sync.RWMutex
+	providers       map[string]SMSProvider
+	order           []string
+	routes          map[Carrier]string
+	defaultProvider string
+	redis           *database.RedisDB
+	budget          *OutboundBudget
+}
+
+// NewSMSRouter builds a router with every provider that has credentials configured
+func NewSMSRouter(cfg *config.Config, redis *database.RedisDB, budget *OutboundBudget) *SMSRouter {
+	router := buildSMSRouter(cfg)
+	router.redis = redis
+	router.budget = budget
+	return router
+}
+
+// Reload rebuilds providers and carrier routes from a new config snapshot,
+// e.g. after credentials were rotated. In-flight sends finish on the old client.
+func (r *SMSRouter) Reload(cfg *config.Config) {
+	fresh := buildSMSRouter(cfg)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.providers = fresh.providers
+	r.order = fresh.order
+	r.routes = fresh.routes
+	r.defaultProvider = fresh.defaultProvider
+}
+
+func buildSMSRouter(cfg *config.Config) *SMSRouter {
+	router := &SMSRouter{
+		providers:       make(map[string]SMSProvider),
+		routes:          make(map[Carrier]string),
+		defaultProvider: cfg.SMSDefaultProvider,
+	}
+
+	if cfg.TwilioAccountSID != "" {
+		router.Register(NewTwilioProvider(
+			cfg.TwilioAccountSID,
+			cfg.TwilioAuthToken,
+			cfg.TwilioPhoneNumber,
+			statusCallbackURL(cfg.PublicBaseURL, ProviderTwilio),
+		))
+	}
+	if cfg.TermiiAPIKey != "" {
+		router.Register(NewTermiiProvider(cfg.TermiiAPIKey, cfg.TermiiSenderID, cfg.TermiiWebhookSecret))
+	}
+	if cfg.AfricasTalkingAPIKey != "" {
+		router.Register(NewAfricasTalkingProvider(
+			cfg.AfricasTalkingUsername,
+			cfg.AfricasTalkingAPIKey,
+			cfg.AfricasTalkingSenderID,
+			cfg.AfricasTalkingWebhookToken,
+		))
+	}
+
+	for carrier, provider := range cfg.SMSCarrierRoutes {
+		router.routes[Carrier(strings.ToUpper(carrier))] = strings.ToLower(provider)
+	}
+
+	return router
+}
+
+// Register adds a provider. The first provider registered is the last-resort default.
+func (r *SMSRouter) Register(provider SMSProvider) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, exists := r.providers[provider.Name()]; !exists {
+		r.order = append(r.order, provider.Name())
+	}
+	r.providers[provider.Name()] = provider
+}
+
+// Provider returns a registered provider by name
+func (r *SMSRouter) Provider(name string) (SMSProvider, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	p, ok := r.providers[name]
+	return p, ok
+}
+
+// SetRoute overrides the provider used for a carrier
+func (r *SMSRouter) SetRoute(carrier Carrier, provider string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.routes[carrier] = provider
+}
+
+// Send routes a message to the best provider for the destination's carrier.
+// If that provider rejects the message outright, the alternate is tried immediately.
+func (r *SMSRouter) Send(ctx context.Context, to, message string) error {
+	primary := r.providerFor(to)
+	if primary == nil {
+		return fmt.Errorf("no SMS provider configured")
+	}
+
+	err := r.sendVia(ctx, primary, to, message, 1)
+	if err == nil {
+		return nil
+	}
+
+	alternate := r.alternateFor(primary.Name())
+	if alternate == nil {
+		return err
+	}
+
+	log.Printf("WARN: SMS via %s to %s failed (%v), retrying via %s", primary.Name(), to, err, alternate.Name())
+	if err := r.budget.Claim(ctx, smsKind(ctx)); err != nil {
+		return err
+	}
+	return r.sendVia(ctx, alternate, to, message, 2)
+}
+
+// SendFrom sends a message through the named provider, so a reply comes
+// from the number it answers. Without that provider it is routed as by Send.
+func (r *SMSRouter) SendFrom(ctx context.Context, providerName, to, message string) error {
+	provider, ok := r.Provider(providerName)
+	if !ok {
+		return r.Send(withSMSKind(ctx, MessageReply), to, message)
+	}
+	return r.sendVia(withSMSKind(ctx, MessageReply), provider, to, message, 1)
+}
+
+// HandleDeliveryFailure retries a message that a provider reported as
+// undelivered, once however often the failure is reported, unless the
+// outbound budget drops the retry
+func (r *SMSRouter) HandleDeliveryFailure(ctx context.Context, providerName, messageID string) error {
+	if r.redis == nil {
+		return nil
+	}
+
+	delivery, err := r.redis.GetSMSDelivery(ctx, providerName, messageID)
+	if err != nil {
+		return err
+	}
+	if delivery == nil {
+		return nil // Unknown or expired - nothing to retry
+	}
+	if delivery.Attempts >= smsMaxAttempts {
+		log.Printf("WARN: SMS %s to %s undelivered after %d attempts", messageID, delivery.To, delivery.Attempts)
+		return nil
+	}
+
+	alternate := r.alternateFor(providerName)
+	if alternate == nil {
+		return fmt.Errorf("no alternate SMS provider for %s", providerName)
+	}
+
+	claimed, err := r.redis.ClaimSMSRetry(ctx, providerName, messageID, smsDeliveryTTL)
+	if err != nil {
+		return err
+	}
+	if !claimed {
+		return nil // Already retried
+	}
+
+	if delivery.AlertID != nil {
+		ctx = withAlertSMS(ctx, *delivery.AlertID)
+	}
+	kind := delivery.Kind
+	if kind == "" && delivery.AlertID != nil {
+		kind = MessageAlert // tracked before messages kept their type
+	}
+	ctx = withSMSKind(ctx, kind)
+	if err := r.budget.Claim(ctx, kind); err != nil {
+		return err
+	}
+
+	log.Printf("INFO: SMS %s via %s undelivered, retrying via %s", messageID, providerName, alternate.Name())
+	return r.sendVia(ctx, alternate, delivery.To, delivery.Message, delivery.Attempts+1)
+}
+
+// Delivery returns the message a provider reported on, or nil if it is
+// unknown or was sent too long ago to still be tracked
+func (r *SMSRouter) Delivery(ctx context.Context, providerName, messageID string) (*models.SMSDelivery, error) {
+	if r.redis == nil {
+		return nil, nil
+	}
+	return r.redis.GetSMSDelivery(ctx, providerName, messageID)
+}
+
+func (r *SMSRouter) sendVia(ctx context.Context, provider SMSProvider, to, message string, attempt int) error {
+	messageID, err := provider.Send(ctx, to, message)
+	if err != nil {
+		return err
+	}
+	if receipt, ok := ctx.Value(smsReceiptKey{}).(*smsReceipt); ok {
+		receipt.Provider, receipt.MessageID = provider.Name(), messageID
+	}
+
+	if r.redis != nil && messageID != "" {
+		delivery := &models.SMSDelivery{
+			MessageID: messageID,
+			Provider:  provider.Name(),
+			To:        to,
+			Message:   message,
+			Kind:      smsKind(ctx),
+			Attempts:  attempt,
+			SentAt:    time.Now(),
+		}
+		if alertID, ok := ctx.Value(alertSMSKey{}).(uuid.UUID); ok {
+			delivery.AlertID = &alertID
+		}
+		if err := r.redis.SaveSMSDelivery(ctx, delivery, smsDeliveryTTL); err != nil {
+			log.Printf("WARN: Failed to track SMS delivery %s: %v", messageID, err)
+		}
+	}
+
+	return nil
+}
+
+// smsKind returns the type of the message being sent with ctx, or "" if it
+// wasn't given
+func smsKind(ctx context.Context) string {
+	kind, _ := ctx.Value(smsKindKey{}).(string)
+	return kind
+}
+
+// providerFor selects the provider configured for the destination's carrier
+func (r *SMSRouter) providerFor(to string) SMSProvider {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if name, ok := r.routes[DetectCarrier(to)]; ok {
+		if p, ok := r.providers[name]; ok {
+			return p
+		}
+	}
+	if p, ok := r.providers[r.defaultProvider]; ok {
+		return p
+	}
+	if len(r.order) > 0 {
+		return r.providers[r.order[0]]
+	}
+	return nil
+}
+
+// alternateFor returns a provider other than the named one, preferring the default
+func (r *SMSRouter) alternateFor(name string) SMSProvider {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if name != r.defaultProvider {
+		if p, ok := r.providers[r.defaultProvider]; ok {
+			return p
+		}
+	}
+	for _, candidate := range r.order {
+		if candidate != name {
+			return r.providers[candidate]
+		}
+	}
+	return nil
+}
+
+func statusCallbackURL(baseURL, provider string) string {
+	if baseURL == "" {
+		return ""
+	}
+	return strings.TrimRight(baseURL, "/") + "/v1/sms/status/" + provider
+}
@@ -0,0 +1,53 @@
+package services
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/database"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+)
+
+// smsUsageTopUsers is how many of the costliest users admin stats list
+const smsUsageTopUsers = 10
+
+// SMSUsage counts the SMS sent on each user's behalf per day in Redis, so an
+// admin can spot one costing too much, e.g. an SMS-fallback phone stuck
+// reporting every minute. Counted are alerts and resolutions to their
+// contacts, replies and acknowledgments to their SMS and their daily SMS
+// confirmations. Counting never fails a send.
+type SMSUsage struct {
+	redis *database.RedisDB
+}
+
+func NewSMSUsage(redis *database.RedisDB) *SMSUsage {
+	return &SMSUsage{redis: redis}
+}
+
+// smsUsageDay is the day SMS sent at t are counted under, in Lagos time
+func smsUsageDay(t time.Time) string {
+	return t.In(deploymentLocation()).Format("2006-01-02")
+}
+
+// Record counts n SMS sent on the user's behalf now
+func (u *SMSUsage) Record(ctx context.Context, user *models.User, n int) {
+	if u == nil || u.redis == nil || user == nil || n <= 0 {
+		return
+	}
+	if err := u.redis.CountOutboundSMS(ctx, user.ID, user.OrgID, smsUsageDay(time.Now()), n); err != nil {
+		log.Printf("WARN: Failed to count outbound SMS for user %s: %v", user.ID, err)
+	}
+}
+
+// Today returns today's counts for every user, or for the organization's
+// members when orgID is set
+func (u *SMSUsage) Today(ctx context.Context, orgID *uuid.UUID) (*models.OutboundSMSStats, error) {
+	day := smsUsageDay(time.Now())
+	total, users, err := u.redis.OutboundSMSCounts(ctx, orgID, day, smsUsageTopUsers)
+	if err != nil {
+		return nil, err
+	}
+	return &models.OutboundSMSStats{Date: day, Total: total, TopUsers: users}, nil
+}
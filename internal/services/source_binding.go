@@ -0,0 +1,86 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+)
+
+// Heartbeat sources, named after the channel each heartbeat arrived on
+const (
+	SourceHTTP = "http"
+	SourceSMS  = "sms"
+	SourceUSSD = "ussd"
+)
+
+var (
+	// ErrSourceMismatch means the payload claims a source other than the channel it arrived on
+	ErrSourceMismatch = errors.New("heartbeat source does not match the channel it arrived on")
+	// ErrUnsigned means an HTTP heartbeat reached binding without a verified
+	// signature or an authenticated stream
+	ErrUnsigned = errors.New("HTTP heartbeats must carry a valid signature")
+)
+
+// SourceEvidence is what an ingestion path knows about where a heartbeat came from
+type SourceEvidence struct {
+	Channel        string // SourceHTTP, SourceSMS or SourceUSSD
+	ClaimedSource  string // source named in the payload, empty if none
+	SignatureValid bool   // the app's HMAC signature verified
+	StreamAuthed   bool   // arrived on a stream opened with the user's access token
+	SenderVerified bool   // the SMS or USSD session came from the user's registered phone
+}
+
+// BindSource sets a heartbeat's source from the channel it actually arrived
+// on and its trust level from the credentials that channel checked. A payload
+// claiming a different source is rejected rather than trusted.
+//
+// Signatures use the shared HMAC secret, so verified_device currently means
+// "sent by a genuine app"; it becomes per-device once devices get their own keys.
+// Streamed heartbeats aren't signed one by one; the access token the app
+// opened the stream with vouches for them the same way.
+func BindSource(hb *models.Heartbeat, ev SourceEvidence) error {
+	if ev.ClaimedSource != "" && ev.ClaimedSource != ev.Channel {
+		return fmt.Errorf("%w: claimed %q, arrived via %s", ErrSourceMismatch, ev.ClaimedSource, ev.Channel)
+	}
+
+	switch ev.Channel {
+	case SourceHTTP:
+		if !ev.SignatureValid && !ev.StreamAuthed {
+			return ErrUnsigned
+		}
+		hb.Trust = models.TrustVerifiedDevice
+	case SourceSMS:
+		// An SMS only goes out over the cellular network
+		hb.Connectivity = models.ConnectivityCellular
+		hb.Trust = models.TrustUnverified
+		if ev.SenderVerified {
+			hb.Trust = models.TrustVerifiedPhone
+		}
+	case SourceUSSD:
+		// The gateway reports the number the network connected, which the
+		// session was matched to, so it is as strong as a verified SMS sender
+		hb.Connectivity = models.ConnectivityCellular
+		hb.Trust = models.TrustUnverified
+		if ev.SenderVerified {
+			hb.Trust = models.TrustVerifiedPhone
+		}
+	default:
+		return fmt.Errorf("unknown ingestion channel %q", ev.Channel)
+	}
+	hb.Source = ev.Channel
+	return nil
+}
+
+// HeartbeatTrust returns a heartbeat's trust level. Heartbeats that never went
+// through binding (simulator input, blackbox replays) keep the score they had
+// before trust levels existed: HTTP as a device, anything else as a phone.
+func HeartbeatTrust(hb *models.Heartbeat) string {
+	if hb.Trust != "" {
+		return hb.Trust
+	}
+	if hb.Source == SourceHTTP {
+		return models.TrustVerifiedDevice
+	}
+	return models.TrustVerifiedPhone
+}
@@ -0,0 +1,96 @@
+package services
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+)
+
+// A heartbeat's source is the channel it arrived on; a payload signed for
+// another channel is refused there rather than taken at its word
+func TestBindSource(t *testing.T) {
+	type bindCase struct {
+		name      string
+		ev        SourceEvidence
+		wantErr   error
+		wantTrust string
+	}
+	tests := []bindCase{
+		{"signed app heartbeat", SourceEvidence{Channel: SourceHTTP, ClaimedSource: SourceHTTP, SignatureValid: true}, nil, models.TrustVerifiedDevice},
+		{"no claimed source", SourceEvidence{Channel: SourceHTTP, SignatureValid: true}, nil, models.TrustVerifiedDevice},
+		{"stream", SourceEvidence{Channel: SourceHTTP, StreamAuthed: true}, nil, models.TrustVerifiedDevice},
+		{"SMS signature replayed over HTTP", SourceEvidence{Channel: SourceHTTP, ClaimedSource: SourceSMS, SignatureValid: true}, ErrSourceMismatch, ""},
+		{"HTTP payload replayed over SMS", SourceEvidence{Channel: SourceSMS, ClaimedSource: SourceHTTP, SenderVerified: true}, ErrSourceMismatch, ""},
+		{"USSD claimed over SMS", SourceEvidence{Channel: SourceSMS, ClaimedSource: SourceUSSD}, ErrSourceMismatch, ""},
+		{"unsigned HTTP", SourceEvidence{Channel: SourceHTTP}, ErrUnsigned, ""},
+		{"verified SMS sender", SourceEvidence{Channel: SourceSMS, SenderVerified: true}, nil, models.TrustVerifiedPhone},
+		{"unknown SMS sender", SourceEvidence{Channel: SourceSMS}, nil, models.TrustUnverified},
+		{"verified USSD session", SourceEvidence{Channel: SourceUSSD, SenderVerified: true}, nil, models.TrustVerifiedPhone},
+		{"unknown USSD session", SourceEvidence{Channel: SourceUSSD}, nil, models.TrustUnverified},
+		{"tracker claimed over HTTP", SourceEvidence{Channel: SourceHTTP, ClaimedSource: "tracker", SignatureValid: true}, ErrSourceMismatch, ""},
+		{"source claimed in another case", SourceEvidence{Channel: SourceHTTP, ClaimedSource: "HTTP", SignatureValid: true}, ErrSourceMismatch, ""},
+	}
+
+	// Every channel refuses every other source, whatever credentials it checked
+	channels := []string{SourceHTTP, SourceSMS, SourceUSSD}
+	for _, channel := range channels {
+		for _, claimed := range channels {
+			if claimed == channel {
+				continue
+			}
+			tests = append(tests, bindCase{
+				name:    claimed + " claimed over " + channel,
+				ev:      SourceEvidence{Channel: channel, ClaimedSource: claimed, SignatureValid: true, StreamAuthed: true, SenderVerified: true},
+				wantErr: ErrSourceMismatch,
+			})
+		}
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hb := &models.Heartbeat{}
+			err := BindSource(hb, tt.ev)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("BindSource() = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				if hb.Source != "" || hb.Trust != "" {
+					t.Errorf("rejected heartbeat was bound %s/%s", hb.Source, hb.Trust)
+				}
+				return
+			}
+			if hb.Source != tt.ev.Channel || hb.Trust != tt.wantTrust {
+				t.Errorf("bound %s/%s, want %s/%s", hb.Source, hb.Trust, tt.ev.Channel, tt.wantTrust)
+			}
+			if tt.ev.Channel != SourceHTTP && hb.Connectivity != models.ConnectivityCellular {
+				t.Errorf("connectivity = %q, want cellular", hb.Connectivity)
+			}
+		})
+	}
+}
+
+func TestBindSourceUnknownChannel(t *testing.T) {
+	hb := &models.Heartbeat{}
+	if err := BindSource(hb, SourceEvidence{Channel: "tracker", SignatureValid: true}); err == nil || hb.Source != "" {
+		t.Errorf("BindSource() = %v, source %q, want an error", err, hb.Source)
+	}
+}
+
+// Heartbeats never bound keep the trust their source had before binding
+func TestHeartbeatTrust(t *testing.T) {
+	tests := []struct {
+		hb   models.Heartbeat
+		want string
+	}{
+		{models.Heartbeat{Source: SourceHTTP}, models.TrustVerifiedDevice},
+		{models.Heartbeat{Source: SourceSMS}, models.TrustVerifiedPhone},
+		{models.Heartbeat{Source: "blackbox"}, models.TrustVerifiedPhone},
+		{models.Heartbeat{Source: SourceSMS, Trust: models.TrustUnverified}, models.TrustUnverified},
+		{models.Heartbeat{Source: SourceHTTP, Trust: models.TrustUnverified}, models.TrustUnverified},
+	}
+	for _, tt := range tests {
+		if got := HeartbeatTrust(&tt.hb); got != tt.want {
+			t.Errorf("HeartbeatTrust(%s/%q) = %s, want %s", tt.hb.Source, tt.hb.Trust, got, tt.want)
+		}
+	}
+}
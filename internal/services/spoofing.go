@@ -0,0 +1,141 @@
+package services
+
+import (
+	"context"
+	"log"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/database"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+)
+
+// Reasons recorded in Heartbeat.SpoofReasons
+const (
+	SpoofMockFlag           = "mock_location_flag"     // client reported a mock provider
+	SpoofImpossibleAccuracy = "impossible_accuracy"    // consistently 1-3m fixes on a 2G-only connection
+	SpoofZeroVariance       = "zero_variance"          // accuracy and speed identical across many samples
+	SpoofCellMismatch       = "cell_location_mismatch" // GPS fix far from the serving tower
+)
+
+const (
+	// spoofHistorySize is how many previous heartbeats the heuristics look at
+	spoofHistorySize = 20
+	// spoofMinSamples is the minimum history before pattern heuristics apply
+	spoofMinSamples = 10
+	// spoofMaxAccuracyM is the accuracy below which a 2G-only fix is implausible
+	spoofMaxAccuracyM = 3
+	// spoofCellSlackMeters is added to the tower range before flagging a mismatch
+	spoofCellSlackMeters = 2000
+)
+
+// CellLocation is the known position and coverage radius of a cell tower
+type CellLocation struct {
+	Lat    float64
+	Lng    float64
+	RangeM int
+}
+
+// CellLocator resolves a serving cell to its tower location. Returns nil if unknown.
+type CellLocator interface {
+	LocateCell(ctx context.Context, cell models.CellInfo) (*CellLocation, error)
+}
+
+// SpoofDetector flags heartbeats that look like they come from a mock-location app.
+// Suspicion is recorded on the heartbeat and lowers scoring confidence; it never alerts.
+type SpoofDetector struct {
+	postgres *database.PostgresDB
+	cells    CellLocator
+}
+
+// NewSpoofDetector creates a detector. cells may be nil until a cell geolocation
+// source is available, in which case the tower check is skipped.
+func NewSpoofDetector(postgres *database.PostgresDB, cells CellLocator) *SpoofDetector {
+	return &SpoofDetector{
+		postgres: postgres,
+		cells:    cells,
+	}
+}
+
+// Inspect runs the heuristics against the recent history of the device that
+// sent the heartbeat and records the verdict on it; a user's other devices
+// report their own accuracy and speed. Lookup failures skip the affected check.
+// So does a network outside the countries served for the tower check: cell
+// databases cover foreign networks too sparsely for a missing or distant
+// tower to mean anything.
+func (d *SpoofDetector) Inspect(ctx context.Context, hb *models.Heartbeat) {
+	recent, err := d.postgres.GetRecentHeartbeats(ctx, hb.UserID, hb.DeviceID, spoofHistorySize)
+	if err != nil {
+		log.Printf("WARN: Spoof check for user %s skipped history: %v", hb.UserID, err)
+	}
+
+	var tower *CellLocation
+	if d.cells != nil && !OutsideDeployment(hb.CellInfo) {
+		tower, err = d.cells.LocateCell(ctx, hb.CellInfo)
+		if err != nil {
+			log.Printf("WARN: Spoof check for user %s skipped cell lookup: %v", hb.UserID, err)
+		}
+	}
+
+	hb.SpoofReasons = DetectSpoofing(hb, recent, tower)
+	hb.SpoofSuspected = len(hb.SpoofReasons) > 0
+}
+
+// DetectSpoofing returns the heuristics a heartbeat trips, given previous
+// heartbeats (newest first) and the serving tower's location if known
+func DetectSpoofing(hb *models.Heartbeat, recent []models.Heartbeat, tower *CellLocation) []string {
+	var reasons []string
+
+	if hb.IsMock {
+		reasons = append(reasons, SpoofMockFlag)
+	}
+
+	samples := append([]models.Heartbeat{*hb}, recent...)
+
+	if impossibleAccuracy(samples) {
+		reasons = append(reasons, SpoofImpossibleAccuracy)
+	}
+	if zeroVariance(samples) {
+		reasons = append(reasons, SpoofZeroVariance)
+	}
+	if tower != nil {
+		distance := haversineDistance(hb.Lat, hb.Lng, tower.Lat, tower.Lng) * 1000
+		if distance > float64(tower.RangeM+hb.AccuracyM+spoofCellSlackMeters) {
+			reasons = append(reasons, SpoofCellMismatch)
+		}
+	}
+
+	return reasons
+}
+
+// impossibleAccuracy is true when the latest fix and at least 80% of the history
+// claim 1-3m accuracy while only a 2G connection is available
+func impossibleAccuracy(samples []models.Heartbeat) bool {
+	if len(samples) < spoofMinSamples || !isImplausibleFix(samples[0]) {
+		return false
+	}
+	count := 0
+	for _, s := range samples {
+		if isImplausibleFix(s) {
+			count++
+		}
+	}
+	return count*5 >= len(samples)*4
+}
+
+func isImplausibleFix(hb models.Heartbeat) bool {
+	return hb.CellInfo.NetworkType == "2G" && hb.AccuracyM >= 1 && hb.AccuracyM <= spoofMaxAccuracyM
+}
+
+// zeroVariance is true when accuracy and speed are exactly the same over the
+// whole history; real GPS readings always jitter
+func zeroVariance(samples []models.Heartbeat) bool {
+	if len(samples) < spoofMinSamples || samples[0].Speed == nil {
+		return false
+	}
+	first := samples[0]
+	for _, s := range samples[1:] {
+		if s.Speed == nil || *s.Speed != *first.Speed || s.AccuracyM != first.AccuracyM {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,201 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/config"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/database"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/scheduler"
+)
+
+const (
+	staleMonitorWorker = "stale_monitor"
+	staleMonitorEvery  = time.Minute
+	staleMonitorBatch  = 500
+	// staleMonitorLease is how long a claimed user waits to be claimed again
+	// if their evaluation doesn't reschedule them, e.g. because it failed
+	staleMonitorLease = 5 * time.Minute
+	// staleMonitorReconcileEvery is how often the schedule is checked
+	// against Postgres for users Redis lost
+	staleMonitorReconcileEvery = 30 * time.Minute
+	staleMonitorReconcileBatch = 1000
+)
+
+// StaleMonitor evaluates users whose heartbeats stopped, who would otherwise
+// only be evaluated when the next one arrives. It doesn't scan users: each
+// evaluation schedules the user's next check in a Redis sorted set, at the
+// moment their score could drop or they could go stale, and the monitor
+// claims only the users whose check is due and queues their evaluations.
+// Postgres is only read for those users, and every 30 minutes to put back
+// any recently active user missing from Redis.
+type StaleMonitor struct {
+	cfg       *config.Store
+	postgres  *database.PostgresDB
+	redis     *database.RedisDB
+	evaluator *SafetyEvaluator
+	scheduler *scheduler.Scheduler
+
+	lastReconcile time.Time // only touched by the monitor's job, which doesn't overlap itself
+
+	tracked  atomic.Int64
+	due      atomic.Int64
+	claimed  atomic.Int64
+	lastTick atomic.Int64 // duration of the last pass, in nanoseconds
+}
+
+func NewStaleMonitor(
+	cfg *config.Store,
+	postgres *database.PostgresDB,
+	redis *database.RedisDB,
+	evaluator *SafetyEvaluator,
+	scheduler *scheduler.Scheduler,
+) *StaleMonitor {
+	return &StaleMonitor{
+		cfg:       cfg,
+		postgres:  postgres,
+		redis:     redis,
+		evaluator: evaluator,
+		scheduler: scheduler,
+	}
+}
+
+// Start schedules the monitor's pass on every instance: claiming a check
+// takes it from the schedule, so instances share the due users. The first
+// pass reconciles with Postgres, so users who went silent while the server
+// was down are checked. The scheduler is closed before the evaluator, which
+// runs the queued evaluations.
+func (m *StaleMonitor) Start() {
+	m.scheduler.MustRegister(scheduler.Job{
+		Name:    staleMonitorWorker,
+		Every:   staleMonitorEvery,
+		Timeout: 2 * staleMonitorEvery, // a reconciliation, then the due users
+		Run:     m.pass,
+	})
+}
+
+// Tracked returns how many users were scheduled at the last pass
+func (m *StaleMonitor) Tracked() int64 {
+	return m.tracked.Load()
+}
+
+// Due returns how many of them were due at the last pass
+func (m *StaleMonitor) Due() int64 {
+	return m.due.Load()
+}
+
+// Claimed returns how many checks the monitor has claimed since startup
+func (m *StaleMonitor) Claimed() int64 {
+	return m.claimed.Load()
+}
+
+// LastTick returns how long the last pass took
+func (m *StaleMonitor) LastTick() time.Duration {
+	return time.Duration(m.lastTick.Load())
+}
+
+// pass reconciles the schedule when it is due to, then checks due users
+func (m *StaleMonitor) pass(ctx context.Context) error {
+	if time.Since(m.lastReconcile) >= staleMonitorReconcileEvery {
+		if err := m.reconcile(ctx); err != nil {
+			log.Printf("ERROR: Failed to reconcile the stale-user schedule: %v", err)
+		} else {
+			m.lastReconcile = time.Now()
+		}
+	}
+	return m.checkDue(ctx)
+}
+
+// checkDue claims every due user and queues their evaluation. Queuing waits
+// while the evaluation workers are backed up, so a burst of due users is
+// worked off at their pace. A pass cut short by shutdown has done its part.
+func (m *StaleMonitor) checkDue(ctx context.Context) error {
+	start := time.Now()
+	tracked, due, err := m.redis.DueCheckCounts(ctx, start)
+	if err != nil {
+		return fmt.Errorf("failed to count due stale-user checks: %w", err)
+	}
+	m.tracked.Store(tracked)
+	m.due.Store(due)
+
+	for {
+		if ctx.Err() != nil {
+			return nil
+		}
+		userIDs, err := m.redis.ClaimDueChecks(ctx, time.Now(), staleMonitorLease, staleMonitorBatch)
+		if err != nil {
+			return fmt.Errorf("failed to claim due stale-user checks: %w", err)
+		}
+		for _, userID := range userIDs {
+			m.evaluator.EvaluateAsync(userID)
+		}
+		m.claimed.Add(int64(len(userIDs)))
+		if len(userIDs) < staleMonitorBatch {
+			break
+		}
+	}
+
+	elapsed := time.Since(start)
+	m.lastTick.Store(int64(elapsed))
+	if elapsed > staleMonitorEvery/2 {
+		log.Printf("WARN: Stale-user pass took %v for %d due users", elapsed, due)
+	}
+	return nil
+}
+
+// reconcile schedules users missing from Redis, e.g. after it lost its data,
+// from their latest heartbeat in Postgres. Only users who may not be stale
+// yet are read: the heartbeat window, an advised interval at the maximum and
+// activity in the app or an outage zone's grace are the longest anyone can
+// stay out of AT_RISK without a heartbeat. A user already scheduled keeps
+// their schedule.
+func (m *StaleMonitor) reconcile(ctx context.Context) error {
+	cfg := m.cfg.Current()
+	now := time.Now()
+	horizon := time.Duration(cfg.HeartbeatWindowSeconds)*time.Second +
+		time.Duration(cfg.HeartbeatIntervalMaxSeconds)*time.Second +
+		time.Duration(max(cfg.ActivitySuppressionMaxMinutes, cfg.OutageGraceMinutes))*time.Minute
+	latest, err := m.postgres.GetTrackedUsers(ctx, now.Add(-horizon), now)
+	if err != nil {
+		return err
+	}
+
+	added := int64(0)
+	batch := make(map[uuid.UUID]time.Time, staleMonitorReconcileBatch)
+	flush := func() error {
+		n, err := m.redis.ScheduleMissingChecks(ctx, batch)
+		added += n
+		clear(batch)
+		return err
+	}
+	for userID, ts := range latest {
+		batch[userID] = firstCheckDue(ts, now)
+		if len(batch) == staleMonitorReconcileBatch {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+	if added > 0 {
+		log.Printf("WARN: Stale-user schedule was missing %d of %d recently active users; rescheduled", added, len(latest))
+	}
+	return nil
+}
+
+// firstCheckDue is when a user missing from the schedule is first checked:
+// the earliest their score can drop after their latest heartbeat at ts. The
+// evaluation then schedules them exactly.
+func firstCheckDue(ts, now time.Time) time.Time {
+	due := ts.Add(recencySteps[0])
+	if due.Before(now) {
+		return now
+	}
+	return due
+}
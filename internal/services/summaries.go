@@ -0,0 +1,238 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/config"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/database"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+)
+
+const (
+	dailySummaryWorker = "daily_summary"
+	dailySummaryEvery  = 15 * time.Minute
+	dailySummaryBatch  = 200
+	// dailySummaryGrace is how long after local midnight a day is summarized,
+	// so heartbeats queued on the phone or sent by SMS have arrived
+	dailySummaryGrace = 30 * time.Minute
+	// dailySummaryLookback finds users active on the day due in any time zone
+	dailySummaryLookback = 48 * time.Hour
+	// summaryMaxAccuracyM leaves coarse fixes out of distance and safe zone
+	// crossings; a cell-tower fix jumping around would add phantom kilometers
+	summaryMaxAccuracyM = 100
+)
+
+// summaryEpisodeStates make up an episode, least severe first
+var summaryEpisodeStates = []string{StateCaution, StateAtRisk, StateAlert}
+
+// DailySummaryService sends each active user a daily summary of what their
+// devices reported, so a service that is silent when all is well still shows
+// it is working. A worker summarizes the previous local day once it is over
+// in the user's time zone, stores it and pushes it to the user. Summaries are
+// aggregated in Postgres; heartbeats are never loaded one by one. Users can
+// turn them off with the daily_summary_disabled setting.
+//
+// Users on SMS fallback get no reply to each heartbeat; with the
+// sms_daily_confirmation setting they get one text a day instead, counting
+// the SMS heartbeats received.
+type DailySummaryService struct {
+	cfg      *config.Store
+	postgres *database.PostgresDB
+	redis    *database.RedisDB
+	notifier Notifier
+	usage    *SMSUsage
+	health   *HealthRegistry
+
+	closeOnce sync.Once
+	stop      chan struct{}
+	done      chan struct{}
+}
+
+func NewDailySummaryService(cfg *config.Store, postgres *database.PostgresDB, redis *database.RedisDB, notifier Notifier, usage *SMSUsage, health *HealthRegistry) *DailySummaryService {
+	return &DailySummaryService{
+		cfg:      cfg,
+		postgres: postgres,
+		redis:    redis,
+		notifier: notifier,
+		usage:    usage,
+		health:   health,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// SummaryDay returns the latest local day in loc that ended at least grace
+// before now, as YYYY-MM-DD, and its bounds [from, to). Days are cut at
+// local midnight, so a heartbeat at 00:05 belongs to the new day, and may be
+// 23 or 25 hours long when the clocks change.
+func SummaryDay(now time.Time, loc *time.Location, grace time.Duration) (string, time.Time, time.Time) {
+	local := now.Add(-grace).In(loc)
+	to := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, loc)
+	from := time.Date(local.Year(), local.Month(), local.Day()-1, 0, 0, 0, 0, loc)
+	return from.Format("2006-01-02"), from, to
+}
+
+// Start launches the summary worker
+func (s *DailySummaryService) Start() {
+	s.health.Register(dailySummaryWorker, dailySummaryEvery)
+	go s.run()
+}
+
+// Close stops the worker and waits for a running pass to finish
+func (s *DailySummaryService) Close() {
+	s.closeOnce.Do(func() {
+		close(s.stop)
+	})
+	<-s.done
+}
+
+func (s *DailySummaryService) run() {
+	defer close(s.done)
+
+	ticker := time.NewTicker(dailySummaryEvery)
+	defer ticker.Stop()
+
+	for {
+		if s.summarizeDue() {
+			s.health.Beat(dailySummaryWorker)
+		}
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// summarizeDue summarizes the due day of every recently active user who
+// doesn't have it yet, and reports whether the pass completed
+func (s *DailySummaryService) summarizeDue() bool {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+
+	now := time.Now()
+	after := uuid.Nil
+	for {
+		candidates, err := s.postgres.ListDailySummaryCandidates(ctx, now.Add(-dailySummaryLookback), after, dailySummaryBatch)
+		if err != nil {
+			log.Printf("ERROR: Failed to list users due a daily summary: %v", err)
+			return false
+		}
+		for i := range candidates {
+			select {
+			case <-s.stop:
+				return true
+			default:
+			}
+			if err := s.summarize(ctx, &candidates[i], now); err != nil {
+				log.Printf("ERROR: Failed to summarize the day for user %s: %v", candidates[i].UserID, err)
+			}
+		}
+		if len(candidates) < dailySummaryBatch {
+			return true
+		}
+		after = candidates[len(candidates)-1].UserID
+	}
+}
+
+// summarize builds, stores and sends the candidate's due summary, unless it
+// exists or they weren't active that day. Another instance may race to build
+// the same one; only the one that stores it sends it. The summary is stored
+// even when only the SMS confirmation is sent, so that goes out once a day.
+func (s *DailySummaryService) summarize(ctx context.Context, c *models.DailySummaryCandidate, now time.Time) error {
+	loc := UserLocation(c.Settings)
+	date, from, to := SummaryDay(now, loc, dailySummaryGrace)
+	if c.LastDate >= date {
+		return nil
+	}
+
+	summary := &models.DailySummary{
+		UserID:    c.UserID,
+		Date:      date,
+		Timezone:  loc.String(),
+		CreatedAt: now,
+	}
+	active, err := s.postgres.BuildDailySummary(ctx, summary, from, to, summaryMaxAccuracyM, summaryEpisodeStates, c.Settings.SafeZones)
+	if err != nil || !active {
+		return err
+	}
+	created, err := s.postgres.CreateDailySummary(ctx, summary)
+	if err != nil || !created {
+		return err
+	}
+
+	if c.Settings.SMSDailyConfirmation {
+		s.confirmSMS(ctx, c, summary)
+	}
+	if c.Settings.DailySummaryDisabled {
+		return nil
+	}
+
+	token, err := s.postgres.GetPushToken(ctx, c.UserID)
+	if err != nil || token == "" {
+		return err
+	}
+	title, body := SummaryNotification(summary)
+	if err := s.notifier.SendPushNotification(ctx, token, title, body); err != nil {
+		return fmt.Errorf("summary for %s stored but not sent: %w", date, err)
+	}
+	return s.postgres.MarkDailySummaryNotified(ctx, c.UserID, date, time.Now())
+}
+
+// confirmSMS texts the user how many SMS heartbeats arrived on the summary's
+// day, if any did and they aren't roaming with ROAMING_SMS_SUPPRESSED on. A
+// failure is only logged; the push still goes out.
+func (s *DailySummaryService) confirmSMS(ctx context.Context, c *models.DailySummaryCandidate, summary *models.DailySummary) {
+	count := summary.HeartbeatsBySource["sms"]
+	if count == 0 || c.Phone == "" || RoamingSMSSuppressed(ctx, s.cfg, s.redis, c.UserID) {
+		return
+	}
+	message := fmt.Sprintf("SafeTrace: SMS mode active, %d %s received on %s", count, plural(count, "heartbeat", "heartbeats"), summary.Date)
+	if err := s.notifier.SendSMS(ctx, MessageSummary, c.Phone, message); err != nil {
+		log.Printf("WARN: Daily SMS confirmation for %s not sent to user %s: %v", summary.Date, c.UserID, err)
+		return
+	}
+	s.usage.Record(ctx, &models.User{ID: c.UserID, OrgID: c.OrgID}, 1)
+}
+
+// SummaryNotification returns the push notification for a summary
+func SummaryNotification(s *models.DailySummary) (string, string) {
+	parts := []string{
+		fmt.Sprintf("%.1f km", s.DistanceM/1000),
+		fmt.Sprintf("%d check-ins", s.Heartbeats),
+	}
+	if s.MinBatteryPct != nil {
+		parts = append(parts, fmt.Sprintf("lowest battery %d%%", *s.MinBatteryPct))
+	}
+	body := strings.Join(parts, ", ") + ". "
+
+	open := 0
+	for _, e := range s.Episodes {
+		if e.ResolvedAt == nil {
+			open++
+		}
+	}
+	switch {
+	case len(s.Episodes) == 0:
+		body += "No alerts."
+	case open == 0:
+		body += fmt.Sprintf("%d alert %s, all resolved.", len(s.Episodes), plural(len(s.Episodes), "episode", "episodes"))
+	default:
+		body += fmt.Sprintf("%d alert %s, %d still open.", len(s.Episodes), plural(len(s.Episodes), "episode", "episodes"), open)
+	}
+	return "Your SafeTrace day", body
+}
+
+func plural(n int, one, many string) string {
+	if n == 1 {
+		return one
+	}
+	return many
+}
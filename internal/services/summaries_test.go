@@ -0,0 +1,84 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+// A day is summarized once it has ended in the user's time zone, plus the
+// grace period, and spans local midnight to midnight
+func TestSummaryDay(t *testing.T) {
+	lagos, err := time.LoadLocation("Africa/Lagos")
+	if err != nil {
+		t.Fatal(err)
+	}
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		loc    *time.Location
+		utc    string
+		date   string
+		length time.Duration
+	}{
+		{"Lagos before the grace ends", lagos, "2026-10-18T23:29:00Z", "2026-10-17", 24 * time.Hour},
+		{"Lagos once the grace ends", lagos, "2026-10-18T23:30:00Z", "2026-10-18", 24 * time.Hour},
+		// UTC midnight is 01:00 in Lagos; the day is still the one that just ended there
+		{"Lagos at UTC midnight", lagos, "2026-10-19T00:00:00Z", "2026-10-18", 24 * time.Hour},
+		{"New York, Lagos already summarized", newYork, "2026-10-18T23:30:00Z", "2026-10-17", 24 * time.Hour},
+		{"New York once the grace ends", newYork, "2026-10-19T04:30:00Z", "2026-10-18", 24 * time.Hour},
+		// Clocks go back on 1 November and forward on 8 March
+		{"25-hour day", newYork, "2026-11-02T05:30:00Z", "2026-11-01", 25 * time.Hour},
+		{"23-hour day", newYork, "2026-03-09T04:30:00Z", "2026-03-08", 23 * time.Hour},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now, err := time.Parse(time.RFC3339, tt.utc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			date, from, to := SummaryDay(now, tt.loc, dailySummaryGrace)
+			if date != tt.date {
+				t.Errorf("SummaryDay(%s) = %s, want %s", tt.utc, date, tt.date)
+			}
+			if to.Sub(from) != tt.length {
+				t.Errorf("day %s lasts %v, want %v", date, to.Sub(from), tt.length)
+			}
+			if local := from.In(tt.loc); local.Hour() != 0 || local.Minute() != 0 {
+				t.Errorf("day starts at %v, want local midnight", local)
+			}
+		})
+	}
+}
+
+// Stepping the clock across local midnight moves the due day exactly once
+// the grace period after it
+func TestSummaryDayAcrossMidnight(t *testing.T) {
+	lagos, err := time.LoadLocation("Africa/Lagos")
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := NewFakeClock(time.Date(2026, 10, 18, 22, 0, 0, 0, time.UTC)) // 23:00 Lagos
+	due := func() string {
+		date, _, _ := SummaryDay(clock.Now(), lagos, dailySummaryGrace)
+		return date
+	}
+
+	first := due()
+	var changedAt time.Time
+	for i := 0; i < 120; i++ {
+		clock.Advance(time.Minute)
+		if d := due(); d != first && changedAt.IsZero() {
+			changedAt = clock.Now()
+		}
+	}
+	if want := time.Date(2026, 10, 18, 23, 30, 0, 0, time.UTC); !changedAt.Equal(want) {
+		t.Errorf("due day changed at %v, want %v", changedAt, want)
+	}
+	if first != "2026-10-17" || due() != "2026-10-18" {
+		t.Errorf("due days %s then %s, want 2026-10-17 then 2026-10-18", first, due())
+	}
+}
@@ -0,0 +1,454 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+	"unicode/utf16"
+)
+
+// Outbound message templates
+const (
+	TemplateAlert          = "alert"
+	TemplateResolved       = "resolved"
+	TemplateInvitation     = "invitation"
+	TemplateContactRemoved = "contact_removed"
+	TemplateLowBattery     = "low_battery"
+	TemplateTest           = "test"
+
+	TemplateWelfarePrompt     = "welfare_prompt"
+	TemplateWelfareConfirmed  = "welfare_confirmed"
+	TemplateWelfareUnanswered = "welfare_unanswered"
+
+	TemplateCheckInPrompt = "check_in_prompt"
+
+	TemplateOrgInvitation = "org_invitation"
+)
+
+// MessageData is the variable set every template renders against. Fields a
+// template doesn't use are ignored; a field that doesn't exist fails validation.
+type MessageData struct {
+	Name         string `json:"name"`          // the protected user
+	Time         string `json:"time"`          // when they were last seen, or when the message is about, e.g. "Jan 2, 3:04 PM WAT"
+	Place        string `json:"place"`         // coordinates and accuracy, after the user's landmark if they gave one
+	PlusCode     string `json:"plus_code"`     // plus code of the position, e.g. 6FR5G9FH+QM
+	What3Words   string `json:"what3words"`    // what3words address of the position, empty unless already looked up
+	MapLink      string `json:"map_link"`      // link to the position on a map
+	Score        int    `json:"score"`         // safety score, 0-100
+	Reason       string `json:"reason"`        // why the alert was raised
+	ContactPhone string `json:"contact_phone"` // the user's own phone
+	Link         string `json:"link"`          // action link, e.g. the invitation confirmation
+	Battery      int    `json:"battery"`       // battery percentage
+	Requester    string `json:"requester"`     // the contact or guardian who asked for a welfare check
+	State        string `json:"state"`         // the user's safety state, e.g. AT_RISK
+	Org          string `json:"org"`           // the organization inviting the user
+	Roaming      string `json:"roaming"`       // country the user's phone is roaming in, empty at home
+	FromYou      string `json:"from_you"`      // where the user is from the recipient's reference location, e.g. "~4 km north-east of your home"; empty without one
+	Note         string `json:"note"`          // the user's own note sent with a panic, cleaned by CleanAlertNote; empty without one
+}
+
+// messageTemplateSpec is a built-in template and the SMS segments it may use
+type messageTemplateSpec struct {
+	body     string
+	segments int
+}
+
+// defaultTemplates are used when a deployment doesn't override them, and as
+// the fallback when an override fails to render
+var defaultTemplates = map[string]messageTemplateSpec{
+	TemplateAlert: {
+		body: "🚨 SAFETRACE ALERT\n\n" +
+			"{{.Name}} may be in danger.\n\n" +
+			"{{if .Note}}{{.Name}} wrote: \"{{.Note}}\"\n\n{{end}}" +
+			"Last seen: {{.Time}}\n" +
+			"Location: {{.Place}}\n" +
+			"{{if .FromYou}}That is {{.FromYou}}\n{{end}}" +
+			"{{if .Roaming}}Currently roaming in {{.Roaming}}\n{{end}}" +
+			"{{if .PlusCode}}Plus code: {{.PlusCode}}\n{{end}}" +
+			"{{if .What3Words}}what3words: ///{{.What3Words}}\n{{end}}" +
+			"Confidence: {{.Score}}%\n" +
+			"Reason: {{.Reason}}\n\n" +
+			"Map: {{.MapLink}}\n\n" +
+			"Please check on them immediately.\n" +
+			"Contact: {{.ContactPhone}}",
+		// Acknowledgment instructions are appended after rendering, on top of this
+		segments: 10,
+	},
+	TemplateResolved: {
+		body: "✅ SafeTrace Update\n\n" +
+			"{{.Name}} has confirmed they are safe.\n" +
+			"Alert resolved at {{.Time}}.",
+		segments: 2,
+	},
+	TemplateInvitation: {
+		body:     "{{.Name}} added you as a trusted contact on SafeTrace. Confirm to be able to check on them: {{.Link}}",
+		segments: 2,
+	},
+	TemplateContactRemoved: {
+		body: "SafeTrace: {{.Name}} replaced this number in their trusted contacts, so it will no longer get their alerts. " +
+			"If you didn't expect this, check with them directly.",
+		segments: 2,
+	},
+	TemplateLowBattery: {
+		body:     "SafeTrace: {{.Name}}'s phone is at {{.Battery}}% battery. If it dies you will be alerted when they stop checking in. Last seen {{.Time}}: {{.MapLink}}",
+		segments: 2,
+	},
+	TemplateTest: {
+		body:     "SafeTrace test message for {{.Name}}. No action is needed.",
+		segments: 1,
+	},
+	TemplateWelfarePrompt: {
+		body:     "SafeTrace: your contact {{.Requester}} asked us to check on you. Open the app or reply OK by {{.Time}} to confirm you're okay.",
+		segments: 2,
+	},
+	TemplateWelfareConfirmed: {
+		body:     "SafeTrace: {{.Name}} confirmed they're okay at {{.Time}}, after your check.",
+		segments: 1,
+	},
+	TemplateWelfareUnanswered: {
+		body: "SafeTrace: {{.Name}} didn't confirm they're okay after your check. " +
+			"Status: {{.State}} (score {{.Score}}). " +
+			"{{if .MapLink}}Last seen {{.Time}}: {{.MapLink}}{{if .PlusCode}} ({{.PlusCode}}){{end}}{{else}}No location on record.{{end}}",
+		segments: 2,
+	},
+	TemplateCheckInPrompt: {
+		body:     "SafeTrace: are you okay? Reply OK or open the app by {{.Time}}, or your trusted contacts will be alerted.",
+		segments: 1,
+	},
+	TemplateOrgInvitation: {
+		body: "SafeTrace: {{.Org}} invited you to join them on SafeTrace. " +
+			"Their escalation contacts will be alerted with yours if you're in danger. Accept in the app by {{.Time}}.",
+		segments: 2,
+	},
+}
+
+// sampleMessageData renders templates at load time and in previews. Values
+// are on the long side so the segment check holds for real messages.
+var sampleMessageData = MessageData{
+	Name:         "Oluwaseun Adebayo-Okonkwo",
+	Time:         "Dec 28, 11:45 PM WAT",
+	Place:        "6.524379, 3.379206 (±120m)",
+	PlusCode:     "6FR5G9FH+QM",
+	What3Words:   "workers.tickling.hydrant",
+	MapLink:      "https://www.google.com/maps?q=6.524379,3.379206",
+	Score:        35,
+	Reason:       "No heartbeat for 25 minutes after a sudden stop",
+	ContactPhone: "+2348012345678",
+	Link:         "https://safetrace.example.com/contact/confirm/3f9a6c1e2b7d4a8f9c0e1d2b3a4f5e6d",
+	Battery:      8,
+	Requester:    "Chiamaka Nwosu-Ogunleye",
+	State:        "AT_RISK",
+	Org:          "University of Lagos Student Affairs Division",
+	Roaming:      "Central African Republic",
+	FromYou:      "~125 km north-west of your workplace",
+	Note:         "I'm in a blue Toyota Corolla on Third Mainland Bridge heading to Ikeja. The driver changed route and won't stop",
+}
+
+// SampleMessageData returns the data templates are validated and previewed against
+func SampleMessageData() MessageData {
+	return sampleMessageData
+}
+
+// ErrUnknownTemplate is returned for a template name that doesn't exist
+var ErrUnknownTemplate = errors.New("unknown message template")
+
+// MessageTemplates renders outbound messages from the built-in templates and
+// any per-deployment overrides. Overrides are validated when loaded; one that
+// still fails to render falls back to the built-in template so an alert is
+// never blocked by its wording.
+type MessageTemplates struct {
+	mu        sync.RWMutex
+	overrides map[string]*template.Template
+	sources   map[string]string
+}
+
+var builtinTemplates = func() map[string]*template.Template {
+	parsed := make(map[string]*template.Template, len(defaultTemplates))
+	for name, spec := range defaultTemplates {
+		parsed[name] = template.Must(template.New(name).Parse(spec.body))
+	}
+	return parsed
+}()
+
+// NewMessageTemplates validates the overrides (name to template text) and
+// returns the template set
+func NewMessageTemplates(overrides map[string]string) (*MessageTemplates, error) {
+	t := &MessageTemplates{}
+	if err := t.Load(overrides); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// LoadTemplateOverrides reads a JSON object of template name to template text.
+// An empty path means no overrides.
+func LoadTemplateOverrides(path string) (map[string]string, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var overrides map[string]string
+	if err := json.Unmarshal(data, &overrides); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return overrides, nil
+}
+
+// Load replaces the overrides. Every override is validated first; on error
+// the current set is kept.
+func (t *MessageTemplates) Load(overrides map[string]string) error {
+	parsed := make(map[string]*template.Template, len(overrides))
+	var problems []string
+	for name, body := range overrides {
+		tmpl, _, err := compileTemplate(name, body)
+		if err != nil {
+			problems = append(problems, err.Error())
+			continue
+		}
+		parsed[name] = tmpl
+	}
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return fmt.Errorf("invalid message templates: %s", strings.Join(problems, "; "))
+	}
+
+	t.mu.Lock()
+	t.overrides = parsed
+	t.sources = overrides
+	t.mu.Unlock()
+	return nil
+}
+
+// Render renders the named template. A failing override is logged and the
+// built-in template is used instead.
+func (t *MessageTemplates) Render(name string, data MessageData) string {
+	t.mu.RLock()
+	override := t.overrides[name]
+	t.mu.RUnlock()
+
+	if override != nil {
+		out, err := execute(override, data)
+		if err == nil {
+			return out
+		}
+		log.Printf("ERROR: Message template %q failed to render, using the default: %v", name, err)
+	}
+
+	builtin, ok := builtinTemplates[name]
+	if !ok {
+		log.Printf("ERROR: No message template named %q", name)
+		return ""
+	}
+	out, err := execute(builtin, data)
+	if err != nil {
+		log.Printf("ERROR: Default message template %q failed to render: %v", name, err)
+	}
+	return out
+}
+
+// TemplateInfo describes a template as it is currently in use
+type TemplateInfo struct {
+	Name       string `json:"name"`
+	Body       string `json:"body"`
+	Overridden bool   `json:"overridden"`
+	Default    string `json:"default"`
+	Segments   int    `json:"max_segments"`
+}
+
+// List returns every template, sorted by name
+func (t *MessageTemplates) List() []TemplateInfo {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	infos := make([]TemplateInfo, 0, len(defaultTemplates))
+	for name, spec := range defaultTemplates {
+		info := TemplateInfo{Name: name, Body: spec.body, Default: spec.body, Segments: spec.segments}
+		if body, ok := t.sources[name]; ok {
+			info.Body = body
+			info.Overridden = true
+		}
+		infos = append(infos, info)
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+	return infos
+}
+
+// TemplatePreview is a template rendered against sample data
+type TemplatePreview struct {
+	Name     string `json:"name"`
+	Rendered string `json:"rendered"`
+	Encoding string `json:"encoding"` // gsm7 | ucs2
+	Length   int    `json:"length"`   // in encoding units
+	Segments int    `json:"segments"`
+	Budget   int    `json:"max_segments"`
+	// Valid is false when the template would be rejected as an override
+	Valid bool   `json:"valid"`
+	Error string `json:"error,omitempty"`
+}
+
+// Preview renders body as the named template against data, which defaults
+// to the sample data. An empty body previews the template currently in use.
+// Templates that don't parse or render are an error; one that renders but
+// would be rejected as an override is returned with Valid false.
+func (t *MessageTemplates) Preview(name, body string, data *MessageData) (*TemplatePreview, error) {
+	if body == "" {
+		t.mu.RLock()
+		source, ok := t.sources[name]
+		t.mu.RUnlock()
+		if ok {
+			body = source
+		} else if spec, ok := defaultTemplates[name]; ok {
+			body = spec.body
+		}
+	}
+
+	tmpl, sample, err := parseTemplate(name, body)
+	if err != nil {
+		return nil, err
+	}
+	rendered := sample
+	if data != nil {
+		if rendered, err = execute(tmpl, *data); err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+	}
+
+	encoding, length, segments := SMSSegments(rendered)
+	preview := &TemplatePreview{
+		Name:     name,
+		Rendered: rendered,
+		Encoding: encoding,
+		Length:   length,
+		Segments: segments,
+		Budget:   defaultTemplates[name].segments,
+		Valid:    true,
+	}
+	if err := checkBudget(name, sample); err != nil {
+		preview.Valid = false
+		preview.Error = err.Error()
+	}
+	return preview, nil
+}
+
+// compileTemplate parses a template and checks it renders against the sample
+// data within its segment budget. It returns the template and that rendering.
+func compileTemplate(name, body string) (*template.Template, string, error) {
+	tmpl, rendered, err := parseTemplate(name, body)
+	if err != nil {
+		return nil, "", err
+	}
+	if err := checkBudget(name, rendered); err != nil {
+		return nil, "", err
+	}
+	return tmpl, rendered, nil
+}
+
+// parseTemplate parses a template and renders it against the sample data,
+// which rejects variables that don't exist
+func parseTemplate(name, body string) (*template.Template, string, error) {
+	if _, ok := defaultTemplates[name]; !ok {
+		return nil, "", fmt.Errorf("%w: %q", ErrUnknownTemplate, name)
+	}
+	if strings.TrimSpace(body) == "" {
+		return nil, "", fmt.Errorf("%s: template is empty", name)
+	}
+
+	tmpl, err := template.New(name).Parse(body)
+	if err != nil {
+		return nil, "", fmt.Errorf("%s: %w", name, err)
+	}
+	rendered, err := execute(tmpl, sampleMessageData)
+	if err != nil {
+		return nil, "", fmt.Errorf("%s: %w", name, err)
+	}
+	return tmpl, rendered, nil
+}
+
+// checkBudget checks a sample rendering fits the template's SMS segment budget
+func checkBudget(name, rendered string) error {
+	budget := defaultTemplates[name].segments
+	if _, _, segments := SMSSegments(rendered); segments > budget {
+		return fmt.Errorf("%s: renders to %d SMS segments with sample data, the budget is %d", name, segments, budget)
+	}
+	return nil
+}
+
+func execute(tmpl *template.Template, data MessageData) (string, error) {
+	var b strings.Builder
+	if err := tmpl.Execute(&b, data); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// gsm7Basic is the GSM 03.38 basic character set; gsm7Extended characters
+// take two septets
+const (
+	gsm7Basic = "@£$¥èéùìòÇ\nØø\rÅåΔ_ΦΓΛΩΠΨΣΘΞÆæßÉ !\"#¤%&'()*+,-./0123456789:;<=>?" +
+		"¡ABCDEFGHIJKLMNOPQRSTUVWXYZÄÖÑÜ§¿abcdefghijklmnopqrstuvwxyzäöñüà"
+	gsm7Extended = "^{}\\[~]|€\f"
+)
+
+// SMSSegments returns how a message is encoded, its length in that
+// encoding's units and how many SMS segments it is sent as. A message is
+// GSM-7 if every character is in the GSM 03.38 set, in septets, an extended
+// character taking two; otherwise UCS-2, in UTF-16 code units, a character
+// outside the BMP (most emoji) taking two. Past one segment (160 septets or
+// 70 units), each carries a header and holds 153 septets or 67 units, and a
+// two-unit character never straddles two segments, so a segment may end
+// one unit short.
+func SMSSegments(message string) (encoding string, length int, segments int) {
+	encoding = "gsm7"
+	single, multi := 160, 153
+	width := gsm7Width
+	for _, r := range message {
+		if gsm7Width(r) == 0 {
+			encoding = "ucs2"
+			single, multi = 70, 67
+			width = utf16.RuneLen
+			break
+		}
+	}
+
+	for _, r := range message {
+		length += width(r)
+	}
+	switch {
+	case length == 0:
+		return encoding, 0, 0
+	case length <= single:
+		return encoding, length, 1
+	}
+
+	used := 0
+	segments = 1
+	for _, r := range message {
+		w := width(r)
+		if used+w > multi {
+			segments++
+			used = 0
+		}
+		used += w
+	}
+	return encoding, length, segments
+}
+
+// gsm7Width returns how many septets r takes in GSM-7, 0 if it can't be encoded
+func gsm7Width(r rune) int {
+	switch {
+	case strings.ContainsRune(gsm7Basic, r):
+		return 1
+	case strings.ContainsRune(gsm7Extended, r):
+		return 2
+	}
+	return 0
+}
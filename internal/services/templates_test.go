@@ -0,0 +1,43 @@
+package services
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSMSSegments(t *testing.T) {
+	tests := []struct {
+		name         string
+		message      string
+		wantEncoding string
+		wantLength   int
+		wantSegments int
+	}{
+		{"empty", "", "gsm7", 0, 0},
+		{"plain ASCII", "I'm in a blue Toyota on Third Mainland Bridge", "gsm7", 45, 1},
+		{"ASCII filling one segment", strings.Repeat("a", 160), "gsm7", 160, 1},
+		{"ASCII one past a segment", strings.Repeat("a", 161), "gsm7", 161, 2},
+		{"ASCII filling two segments", strings.Repeat("a", 306), "gsm7", 306, 2},
+		{"ASCII one past two segments", strings.Repeat("a", 307), "gsm7", 307, 3},
+		{"extended characters take two septets", "Fare: €5 [cash]", "gsm7", 18, 1},
+		{"extended character not split across segments", strings.Repeat("a", 152) + "€" + strings.Repeat("a", 10), "gsm7", 164, 2},
+		{"accents outside GSM-7 switch to UCS-2", "Àdúgbò? é è à ù", "ucs2", 15, 1},
+		{"GSM-7 accents", "Café près de là", "gsm7", 15, 1},
+		{"Yoruba diacritics", "Ẹ kú àárọ̀, mo wà ní Ọjà Ọba", "ucs2", 28, 1},
+		{"Yoruba past one UCS-2 segment", strings.Repeat("ẹ", 71), "ucs2", 71, 2},
+		{"emoji takes two units", "Help 🙏", "ucs2", 7, 1},
+		{"emoji with skin tone and ZWJ", "👩🏾‍🚒", "ucs2", 7, 1},
+		{"emoji filling one UCS-2 segment", strings.Repeat("🚨", 35), "ucs2", 70, 1},
+		{"emoji not split across segments", strings.Repeat("a", 66) + "🚨" + strings.Repeat("a", 10), "ucs2", 78, 2},
+		{"one emoji turns ASCII into UCS-2", strings.Repeat("a", 80) + "🚨", "ucs2", 82, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encoding, length, segments := SMSSegments(tt.message)
+			if encoding != tt.wantEncoding || length != tt.wantLength || segments != tt.wantSegments {
+				t.Errorf("SMSSegments() = %s, %d, %d, want %s, %d, %d",
+					encoding, length, segments, tt.wantEncoding, tt.wantLength, tt.wantSegments)
+			}
+		})
+	}
+}
@@ -0,0 +1,62 @@
+package services
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/country"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+)
+
+// Layouts of times rendered for people, to be passed to FormatInUserZone
+const (
+	LayoutClock    = "3:04 PM"
+	LayoutDateTime = "Jan 2, 3:04 PM"
+)
+
+// locations caches time zones by name, as time.LoadLocation reads the zone
+// database on every call
+var locations sync.Map
+
+func loadLocation(name string) (*time.Location, error) {
+	if loc, ok := locations.Load(name); ok {
+		return loc.(*time.Location), nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, err
+	}
+	locations.Store(name, loc)
+	return loc, nil
+}
+
+// UserLocation returns the user's time zone, falling back to the one of the
+// deployment's home country. Users are given their own country's at sign-up.
+func UserLocation(settings models.UserSettings) *time.Location {
+	if settings.Timezone != "" {
+		if loc, err := loadLocation(settings.Timezone); err == nil {
+			return loc
+		}
+	}
+	return deploymentLocation()
+}
+
+// ValidateTimezone rejects names that aren't IANA time zones
+func ValidateTimezone(name string) error {
+	if name == "" || name == "Local" {
+		return fmt.Errorf("must be an IANA time zone, e.g. %s", country.Active().Home().Timezone)
+	}
+	if _, err := time.LoadLocation(name); err != nil {
+		return fmt.Errorf("must be an IANA time zone, e.g. %s", country.Active().Home().Timezone)
+	}
+	return nil
+}
+
+// FormatInUserZone renders t in the user's time zone followed by the zone's
+// abbreviation, e.g. "Jan 2, 3:15 AM WAT". Messages about a user give times
+// in their zone, whoever reads them, and the abbreviation keeps a contact
+// elsewhere from taking them for their own.
+func FormatInUserZone(t time.Time, settings models.UserSettings, layout string) string {
+	return t.In(UserLocation(settings)).Format(layout + " MST")
+}
@@ -0,0 +1,92 @@
+package services
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+)
+
+func mustLoad(t *testing.T, name string) *time.Location {
+	t.Helper()
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Fatalf("LoadLocation(%q): %v", name, err)
+	}
+	return loc
+}
+
+func TestFormatInUserZone(t *testing.T) {
+	at := time.Date(2026, 3, 9, 2, 15, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		timezone string
+		at       time.Time
+		layout   string
+		want     string
+	}{
+		{"WAT", "Africa/Lagos", at, LayoutDateTime, "Mar 9, 3:15 AM WAT"},
+		{"WAT clock", "Africa/Lagos", at, LayoutClock, "3:15 AM WAT"},
+		{"WAT across midnight", "Africa/Lagos", time.Date(2026, 3, 8, 23, 30, 0, 0, time.UTC), LayoutDateTime, "Mar 9, 12:30 AM WAT"},
+		{"WAT has no DST", "Africa/Lagos", time.Date(2026, 7, 9, 2, 15, 0, 0, time.UTC), LayoutDateTime, "Jul 9, 3:15 AM WAT"},
+		{"unset falls back to the deployment's", "", at, LayoutDateTime, "Mar 9, 3:15 AM WAT"},
+		{"invalid falls back to the deployment's", "Mars/Olympus_Mons", at, LayoutDateTime, "Mar 9, 3:15 AM WAT"},
+		{"another zone", "Africa/Nairobi", at, LayoutDateTime, "Mar 9, 5:15 AM EAT"},
+		{"before DST", "Europe/London", time.Date(2026, 3, 28, 12, 0, 0, 0, time.UTC), LayoutDateTime, "Mar 28, 12:00 PM GMT"},
+		{"after DST", "Europe/London", time.Date(2026, 3, 30, 12, 0, 0, 0, time.UTC), LayoutDateTime, "Mar 30, 1:00 PM BST"},
+		// The instant counts, not the zone it was recorded in
+		{"recorded elsewhere", "Africa/Lagos", at.In(mustLoad(t, "America/New_York")), LayoutDateTime, "Mar 9, 3:15 AM WAT"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := FormatInUserZone(tt.at, models.UserSettings{Timezone: tt.timezone}, tt.layout)
+			if got != tt.want {
+				t.Errorf("FormatInUserZone() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// A contact in another zone, served by an instance in yet another, reads
+// the user's time with its zone spelled out
+func TestMessageTimeIsUsers(t *testing.T) {
+	local := time.Local
+	time.Local = mustLoad(t, "America/New_York")
+	t.Cleanup(func() { time.Local = local })
+
+	templates, err := NewMessageTemplates(nil)
+	if err != nil {
+		t.Fatalf("NewMessageTemplates: %v", err)
+	}
+	user := models.UserSettings{Timezone: "Africa/Lagos"}
+	lastSeen := time.Date(2026, 3, 9, 2, 15, 0, 0, time.UTC).Local()
+
+	msg := templates.Render(TemplateAlert, MessageData{
+		Name: "Adaeze",
+		Time: FormatInUserZone(lastSeen, user, LayoutDateTime),
+	})
+	if !strings.Contains(msg, "Last seen: Mar 9, 3:15 AM WAT") {
+		t.Errorf("alert = %q, want the time in WAT", msg)
+	}
+	if strings.Contains(msg, "EST") || strings.Contains(msg, "9:15 PM") {
+		t.Errorf("alert = %q, uses the server's zone", msg)
+	}
+}
+
+func TestValidateTimezone(t *testing.T) {
+	for name, valid := range map[string]bool{
+		"Africa/Lagos":     true,
+		"Europe/London":    true,
+		"UTC":              true,
+		"":                 false,
+		"Local":            false,
+		"WAT":              false,
+		"Africa/Atlantis":  false,
+		"../../etc/passwd": false,
+	} {
+		if err := ValidateTimezone(name); (err == nil) != valid {
+			t.Errorf("ValidateTimezone(%q) = %v, want valid %v", name, err, valid)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package services
+
+import (
+	"math"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+)
+
+// earthRadiusMeters is the mean Earth radius used for local projections
+const earthRadiusMeters = 6371000
+
+// DownsampleTrack thins a heartbeat track for display. Points that deviate
+// less than toleranceM from the simplified line are dropped (Douglas-Peucker),
+// then, if more than maxPoints remain, every nth point is kept. The first and
+// last points always survive. A toleranceM of 0 skips simplification; a
+// maxPoints below 2 disables the cap.
+func DownsampleTrack(track []models.Heartbeat, toleranceM float64, maxPoints int) []models.Heartbeat {
+	if len(track) <= 2 {
+		return track
+	}
+
+	kept := track
+	if toleranceM > 0 {
+		keep := make([]bool, len(track))
+		keep[0], keep[len(track)-1] = true, true
+		simplify(track, 0, len(track)-1, toleranceM, keep)
+
+		kept = make([]models.Heartbeat, 0, len(track))
+		for i, hb := range track {
+			if keep[i] {
+				kept = append(kept, hb)
+			}
+		}
+	}
+
+	if maxPoints < 2 || len(kept) <= maxPoints {
+		return kept
+	}
+	step := float64(len(kept)-1) / float64(maxPoints-1)
+	capped := make([]models.Heartbeat, 0, maxPoints)
+	for i := 0; i < maxPoints; i++ {
+		capped = append(capped, kept[int(math.Round(float64(i)*step))])
+	}
+	return capped
+}
+
+// simplify marks the points between first and last that must be kept. It
+// works on an explicit stack so long tracks can't exhaust the goroutine stack.
+func simplify(track []models.Heartbeat, first, last int, toleranceM float64, keep []bool) {
+	type span struct{ first, last int }
+	stack := []span{{first, last}}
+	for len(stack) > 0 {
+		s := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		farthest, maxDist := -1, toleranceM
+		for i := s.first + 1; i < s.last; i++ {
+			if d := distanceToSegment(track[i], track[s.first], track[s.last]); d > maxDist {
+				farthest, maxDist = i, d
+			}
+		}
+		if farthest < 0 {
+			continue
+		}
+		keep[farthest] = true
+		stack = append(stack, span{s.first, farthest}, span{farthest, s.last})
+	}
+}
+
+// distanceToSegment returns the distance in meters from p to the segment a-b,
+// using an equirectangular projection around a, which is accurate at the
+// scale of consecutive heartbeats
+func distanceToSegment(p, a, b models.Heartbeat) float64 {
+	cosLat := math.Cos(a.Lat * math.Pi / 180)
+	project := func(hb models.Heartbeat) (float64, float64) {
+		x := (hb.Lng - a.Lng) * math.Pi / 180 * cosLat * earthRadiusMeters
+		y := (hb.Lat - a.Lat) * math.Pi / 180 * earthRadiusMeters
+		return x, y
+	}
+
+	px, py := project(p)
+	bx, by := project(b)
+
+	lengthSq := bx*bx + by*by
+	if lengthSq == 0 {
+		return math.Hypot(px, py)
+	}
+	t := math.Max(0, math.Min(1, (px*bx+py*by)/lengthSq))
+	return math.Hypot(px-t*bx, py-t*by)
+}
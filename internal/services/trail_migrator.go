@@ -0,0 +1,215 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/config"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/database"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+)
+
+// ErrTrailMigrationRunning is returned when a migration run is already in progress
+var ErrTrailMigrationRunning = errors.New("trail migration already running")
+
+// TrailMigrationOptions tune one migration run
+type TrailMigrationOptions struct {
+	Limit       int  // most trails to attempt; 0 for all
+	RetryFailed bool // also attempt trails whose migration failed before
+}
+
+// TrailMigrationRun is the progress of the current or last run
+type TrailMigrationRun struct {
+	Running    bool       `json:"running"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	Attempted  int        `json:"attempted"`
+	Migrated   int        `json:"migrated"`
+	Failed     int        `json:"failed"`
+	Skipped    int        `json:"skipped"` // changed by someone else mid-run
+	LastError  string     `json:"last_error,omitempty"`
+}
+
+// TrailMigrator moves blackbox trails stored inline as data URIs to object
+// storage. Each trail is decoded, gzipped, uploaded and read back, and only
+// once the copy's hash matches is its file_url rewritten to the object key.
+// Progress lives in blackbox_trail_migrations, so a stopped run picks up
+// where it left off; trails are walked at a configured rate to keep the
+// load on the database down.
+type TrailMigrator struct {
+	cfg      *config.Store
+	postgres *database.PostgresDB
+	store    ObjectStore
+
+	mu     sync.Mutex
+	run    TrailMigrationRun
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func NewTrailMigrator(cfg *config.Store, postgres *database.PostgresDB, store ObjectStore) *TrailMigrator {
+	return &TrailMigrator{
+		cfg:      cfg,
+		postgres: postgres,
+		store:    store,
+	}
+}
+
+// Start begins a run in the background. It fails with ErrObjectStoreDisabled
+// without object storage and ErrTrailMigrationRunning while a run is going.
+func (m *TrailMigrator) Start(opts TrailMigrationOptions) error {
+	if m.store == nil {
+		return ErrObjectStoreDisabled
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.run.Running {
+		return ErrTrailMigrationRunning
+	}
+	now := time.Now()
+	m.run = TrailMigrationRun{Running: true, StartedAt: &now}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	m.cancel = cancel
+	m.done = make(chan struct{})
+	go m.migrate(ctx, opts, m.done)
+	return nil
+}
+
+// Status returns the current or last run
+func (m *TrailMigrator) Status() TrailMigrationRun {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.run
+}
+
+// Close stops a running migration after the trail in hand and waits for it
+func (m *TrailMigrator) Close() {
+	m.mu.Lock()
+	cancel, done := m.cancel, m.done
+	m.mu.Unlock()
+	if cancel == nil {
+		return
+	}
+	cancel()
+	<-done
+}
+
+func (m *TrailMigrator) migrate(ctx context.Context, opts TrailMigrationOptions, done chan struct{}) {
+	defer close(done)
+	defer func() {
+		m.mu.Lock()
+		now := time.Now()
+		m.run.Running = false
+		m.run.FinishedAt = &now
+		log.Printf("INFO: Trail migration finished: %d migrated, %d failed, %d skipped",
+			m.run.Migrated, m.run.Failed, m.run.Skipped)
+		m.mu.Unlock()
+	}()
+
+	cfg := m.cfg.Current()
+	pace := time.Duration(float64(time.Second) / cfg.BlackboxMigrationRate)
+	ticker := time.NewTicker(pace)
+	defer ticker.Stop()
+
+	var afterUploaded time.Time
+	var afterID uuid.UUID
+	attempted := 0
+	for {
+		batch := cfg.BlackboxMigrationBatch
+		if opts.Limit > 0 && opts.Limit-attempted < batch {
+			batch = opts.Limit - attempted
+		}
+		if batch <= 0 {
+			return
+		}
+		trails, err := m.postgres.GetInlineTrails(ctx, afterUploaded, afterID, opts.RetryFailed, batch)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("ERROR: Trail migration stopped: %v", err)
+				m.record(func(r *TrailMigrationRun) { r.LastError = err.Error() })
+			}
+			return
+		}
+
+		for i := range trails {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			trail := &trails[i]
+			afterUploaded, afterID = trail.UploadedAt, trail.ID
+			attempted++
+			m.migrateTrail(ctx, trail)
+		}
+		if len(trails) < batch {
+			return
+		}
+	}
+}
+
+// migrateTrail moves one trail and records the outcome
+func (m *TrailMigrator) migrateTrail(ctx context.Context, trail *models.InlineTrail) {
+	key, sum, size, err := m.copyTrail(ctx, trail)
+	if err == nil {
+		err = m.postgres.CompleteTrailMigration(ctx, trail.ID, trail.FileURL, key, sum, size)
+	}
+
+	switch {
+	case err == nil:
+		m.record(func(r *TrailMigrationRun) { r.Attempted++; r.Migrated++ })
+	case errors.Is(err, database.ErrTrailChanged):
+		m.record(func(r *TrailMigrationRun) { r.Attempted++; r.Skipped++ })
+	case ctx.Err() != nil:
+		// Shutting down; the trail is still inline and the next run retries it
+	default:
+		log.Printf("WARN: Failed to migrate trail %s: %v", trail.ID, err)
+		if rerr := m.postgres.RecordTrailMigrationFailure(ctx, trail.ID, len(trail.FileURL), err.Error()); rerr != nil {
+			log.Printf("ERROR: Failed to record migration failure of trail %s: %v", trail.ID, rerr)
+		}
+		m.record(func(r *TrailMigrationRun) { r.Attempted++; r.Failed++; r.LastError = err.Error() })
+	}
+}
+
+// copyTrail uploads the trail's data and verifies the stored copy. It
+// returns the object key, the SHA-256 of the object and its size.
+func (m *TrailMigrator) copyTrail(ctx context.Context, trail *models.InlineTrail) (string, string, int, error) {
+	entries, err := DecodeBlackboxEntries(trail.FileURL)
+	if err != nil {
+		return "", "", 0, err
+	}
+	data, err := EncodeBlackboxObject(entries)
+	if err != nil {
+		return "", "", 0, fmt.Errorf("failed to encode trail: %w", err)
+	}
+	sum := sha256.Sum256(data)
+
+	key := BlackboxObjectKey(trail.UserID, trail.ID)
+	if err := m.store.Put(ctx, key, data, blackboxObjectContentType); err != nil {
+		return "", "", 0, err
+	}
+	stored, err := m.store.Get(ctx, key)
+	if err != nil {
+		return "", "", 0, err
+	}
+	if storedSum := sha256.Sum256(stored); !bytes.Equal(storedSum[:], sum[:]) {
+		return "", "", 0, fmt.Errorf("stored copy of %s does not match: sha256 %x, expected %x", key, storedSum, sum)
+	}
+	return key, hex.EncodeToString(sum[:]), len(data), nil
+}
+
+func (m *TrailMigrator) record(update func(*TrailMigrationRun)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	update(&m.run)
+}
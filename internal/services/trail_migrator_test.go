@@ -0,0 +1,70 @@
+package services
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+)
+
+// Fixtures in testdata/blackbox are file_url values as trails hold them:
+// raw JSON under the base64 label, as uploads wrote them at first, and
+// payloads base64-encoded as the label says, as uploads write them now
+func trailFixture(t *testing.T, name string) string {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata/blackbox", name))
+	if err != nil {
+		t.Fatalf("read fixture: %v", err)
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// Both kinds of inline row move to the same object, and a row that is
+// neither is left for the failure report
+func TestTrailMigratorFixtures(t *testing.T) {
+	tests := []struct {
+		fixture string
+		wantErr bool
+	}{
+		{"raw_json.uri", false},
+		{"base64.uri", false},
+		{"corrupt.uri", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			store := newMemoryObjectStore()
+			migrator := NewTrailMigrator(nil, nil, store)
+			trail := &models.InlineTrail{ID: uuid.New(), UserID: uuid.New(), FileURL: trailFixture(t, tt.fixture)}
+
+			key, sum, size, err := migrator.copyTrail(context.Background(), trail)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("copyTrail() = %s, want an error", key)
+				}
+				if len(store.objects) != 0 {
+					t.Errorf("%d objects stored for a corrupt trail", len(store.objects))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("copyTrail: %v", err)
+			}
+			if key != BlackboxObjectKey(trail.UserID, trail.ID) || len(sum) != 64 || size != len(store.objects[key]) {
+				t.Errorf("copyTrail() = %s, %s, %d", key, sum, size)
+			}
+
+			entries, err := LoadBlackboxEntries(context.Background(), store, key)
+			if err != nil {
+				t.Fatalf("LoadBlackboxEntries: %v", err)
+			}
+			if len(entries) != 3 || entries[2].CellInfo.CID != 56790 || entries[0].Lat != 6.5244 {
+				t.Errorf("migrated entries = %+v", entries)
+			}
+		})
+	}
+}
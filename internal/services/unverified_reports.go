@@ -0,0 +1,260 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"log"
+	"math/bits"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/config"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/database"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+)
+
+const (
+	// unverifiedWindow is what the per-phone and per-IP limits count over
+	unverifiedWindow = time.Hour
+	// unverifiedChallengeTTL is how long a proof-of-work challenge can be answered
+	unverifiedChallengeTTL = 5 * time.Minute
+)
+
+var (
+	// ErrReportLimited is returned when a phone number or IP sent more
+	// reports than the hourly limit
+	ErrReportLimited = errors.New("too many unverified reports")
+	// ErrReportNotFound is returned for a report that doesn't exist or isn't the user's
+	ErrReportNotFound = errors.New("unverified report not found")
+	// ErrReportAnswered is returned when the user already answered for the report
+	ErrReportAnswered = errors.New("unverified report already answered")
+)
+
+// ReportChallenge is a proof of work asked of a client sending more reports
+// than usual. It answers by sending the report again with the header
+// X-Proof-Of-Work: <challenge>:<nonce>, for a nonce such that the SHA-256
+// of "<challenge>:<nonce>" starts with Bits zero bits. A challenge is for
+// one phone number and one answer.
+type ReportChallenge struct {
+	Challenge string `json:"challenge"`
+	Bits      int    `json:"bits"`
+	ExpiresIn int    `json:"expires_in"` // seconds
+}
+
+// UnverifiedReportInput is a report as received, unauthenticated
+type UnverifiedReportInput struct {
+	Phone       string // normalized
+	Lat         float64
+	Lng         float64
+	AccuracyM   *int
+	ReportedAt  time.Time
+	ClientIP    string
+	ProofOfWork string // the X-Proof-Of-Work header, if sent
+}
+
+// UnverifiedReports takes positions sent with only a phone number, from
+// apps that lost their keys (a reinstall, a new phone, corrupted storage),
+// so a user in trouble isn't silenced by their app's state. Nothing proves
+// who sent one, so a report is never evaluated: it can't make the user look
+// safer. It is attached to the user's active alert for contacts to see,
+// labelled unverified, and the user's registered device is asked whether it
+// was them, which confirms or denies it. Reports are limited per phone
+// number and per client IP; past the first few an hour, the client must
+// solve a proof of work.
+type UnverifiedReports struct {
+	cfg      *config.Store
+	postgres *database.PostgresDB
+	redis    *database.RedisDB
+	notifier Notifier
+	audit    *AuditLogger
+}
+
+func NewUnverifiedReports(cfg *config.Store, postgres *database.PostgresDB, redis *database.RedisDB, notifier Notifier, audit *AuditLogger) *UnverifiedReports {
+	return &UnverifiedReports{
+		cfg:      cfg,
+		postgres: postgres,
+		redis:    redis,
+		notifier: notifier,
+		audit:    audit,
+	}
+}
+
+// Submit counts a report against the limits and, if it is within them,
+// stores it for the user with the phone number. It returns a challenge
+// instead when the report needs a proof of work it didn't carry. A number
+// that isn't a user's is accepted the same way and dropped, so the endpoint
+// doesn't tell who is registered.
+func (s *UnverifiedReports) Submit(ctx context.Context, in UnverifiedReportInput) (*ReportChallenge, error) {
+	cfg := s.cfg.Current()
+	phoneCount, ipCount, err := s.redis.CountUnverifiedReport(ctx, in.Phone, in.ClientIP, unverifiedWindow)
+	if err != nil {
+		return nil, err
+	}
+	if phoneCount > cfg.UnverifiedPerPhonePerHour || ipCount > cfg.UnverifiedPerIPPerHour {
+		return nil, ErrReportLimited
+	}
+	if phoneCount > cfg.UnverifiedFreePerHour || ipCount > cfg.UnverifiedFreePerHour {
+		solved, err := s.checkWork(ctx, in.Phone, in.ProofOfWork, cfg.UnverifiedPoWBits)
+		if err != nil {
+			return nil, err
+		}
+		if !solved {
+			return s.challenge(ctx, in.Phone, cfg.UnverifiedPoWBits)
+		}
+	}
+
+	user, err := s.postgres.GetUserByPhone(ctx, in.Phone)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		log.Printf("INFO: Unverified report from %s for an unregistered number dropped", in.ClientIP)
+		return nil, nil
+	}
+
+	report := &models.UnverifiedReport{
+		ID:         uuid.New(),
+		UserID:     user.ID,
+		Lat:        in.Lat,
+		Lng:        in.Lng,
+		AccuracyM:  in.AccuracyM,
+		ReportedAt: in.ReportedAt,
+		ReceivedAt: time.Now(),
+		ClientIP:   in.ClientIP,
+		Trust:      models.ReportUnverified,
+	}
+	latest, err := s.postgres.GetLatestAlert(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	if latest != nil && latest.ResolvedAt == nil {
+		report.AlertID = &latest.ID
+	}
+	if err := s.postgres.CreateUnverifiedReport(ctx, report); err != nil {
+		return nil, err
+	}
+
+	s.requestVerification(ctx, report)
+	metadata := map[string]interface{}{"ip": in.ClientIP, "verification_sent": report.VerificationSentAt != nil}
+	if report.AlertID != nil {
+		metadata["alert_id"] = report.AlertID.String()
+	}
+	s.audit.Record(&models.AuditEvent{
+		ActorRole:     "anonymous",
+		Action:        AuditUnverifiedReport,
+		ObjectType:    "unverified_report",
+		ObjectID:      report.ID.String(),
+		SubjectUserID: &user.ID,
+		Metadata:      metadata,
+	})
+	log.Printf("INFO: Unverified report %s stored for user %s from %s", report.ID, user.ID, in.ClientIP)
+	return nil, nil
+}
+
+// requestVerification asks the user's registered device whether they sent
+// the report. Without a device the report stays unverified.
+func (s *UnverifiedReports) requestVerification(ctx context.Context, report *models.UnverifiedReport) {
+	token, err := s.postgres.GetPushToken(ctx, report.UserID)
+	if err != nil {
+		log.Printf("WARN: Failed to get the push token of user %s to verify report %s: %v", report.UserID, report.ID, err)
+		return
+	}
+	if token == "" {
+		return
+	}
+	if err := s.notifier.SendReportVerification(ctx, token, report.ID.String()); err != nil {
+		log.Printf("WARN: Failed to ask user %s to verify report %s: %v", report.UserID, report.ID, err)
+		return
+	}
+	now := time.Now()
+	if err := s.postgres.MarkReportVerificationSent(ctx, report.ID, now); err != nil {
+		log.Printf("WARN: Failed to record the verification push of report %s: %v", report.ID, err)
+		return
+	}
+	report.VerificationSentAt = &now
+}
+
+// Answer records the user's answer to the verification push: a confirmed
+// report is labelled as the user's, a denied one is hidden from contacts.
+// Either way it is still never evaluated.
+func (s *UnverifiedReports) Answer(ctx context.Context, userID, reportID uuid.UUID, confirmed bool) (*models.UnverifiedReport, error) {
+	report, err := s.postgres.GetUnverifiedReport(ctx, reportID)
+	if err != nil {
+		return nil, err
+	}
+	if report == nil || report.UserID != userID {
+		return nil, ErrReportNotFound
+	}
+	if report.Trust != models.ReportUnverified {
+		return nil, ErrReportAnswered
+	}
+
+	trust := models.ReportDenied
+	if confirmed {
+		trust = models.ReportConfirmed
+	}
+	now := time.Now()
+	answered, err := s.postgres.AnswerUnverifiedReport(ctx, reportID, trust, now)
+	if err != nil {
+		return nil, err
+	}
+	if !answered {
+		return nil, ErrReportAnswered
+	}
+	if !confirmed {
+		log.Printf("WARN: User %s denied sending unverified report %s from %s", userID, reportID, report.ClientIP)
+	}
+	report.Trust, report.AnsweredAt = trust, &now
+	return report, nil
+}
+
+// challenge issues a proof-of-work challenge for phone
+func (s *UnverifiedReports) challenge(ctx context.Context, phone string, bits int) (*ReportChallenge, error) {
+	challenge, err := generateAckToken()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.redis.IssueReportChallenge(ctx, challenge, phone, unverifiedChallengeTTL); err != nil {
+		return nil, err
+	}
+	return &ReportChallenge{
+		Challenge: challenge,
+		Bits:      bits,
+		ExpiresIn: int(unverifiedChallengeTTL.Seconds()),
+	}, nil
+}
+
+// checkWork reports whether proof answers a challenge issued for phone with
+// enough work. The challenge is used up either way.
+func (s *UnverifiedReports) checkWork(ctx context.Context, phone, proof string, bits int) (bool, error) {
+	challenge, nonce, found := strings.Cut(proof, ":")
+	if !found || challenge == "" || nonce == "" {
+		return false, nil
+	}
+	issuedFor, err := s.redis.ClaimReportChallenge(ctx, challenge)
+	if err != nil {
+		return false, err
+	}
+	return issuedFor == phone && ProofOfWorkValid(challenge, nonce, bits), nil
+}
+
+// ProofOfWorkValid reports whether the SHA-256 of "<challenge>:<nonce>"
+// starts with at least bits zero bits
+func ProofOfWorkValid(challenge, nonce string, bits int) bool {
+	sum := sha256.Sum256([]byte(challenge + ":" + nonce))
+	return leadingZeroBits(sum[:]) >= bits
+}
+
+func leadingZeroBits(b []byte) int {
+	n := 0
+	for _, x := range b {
+		if x != 0 {
+			return n + bits.LeadingZeros8(x)
+		}
+		n += 8
+	}
+	return n
+}
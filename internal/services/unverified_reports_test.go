@@ -0,0 +1,230 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/config"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+)
+
+// verificationNotifier records the verification pushes; any other
+// notification is a test failure, as the nil Notifier panics
+type verificationNotifier struct {
+	Notifier
+
+	mu      sync.Mutex
+	reports []string
+}
+
+func (n *verificationNotifier) SendReportVerification(ctx context.Context, fcmToken, reportID string) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.reports = append(n.reports, reportID)
+	return nil
+}
+
+func TestReportLabel(t *testing.T) {
+	tests := []struct {
+		trust string
+		want  string
+	}{
+		{models.ReportUnverified, "Unverified report"},
+		{models.ReportConfirmed, "Confirmed by Ada"},
+		{models.ReportDenied, "Unverified report"}, // never shown, but never as the user's
+	}
+	for _, tt := range tests {
+		if got := reportLabel(tt.trust, "Ada"); got != tt.want {
+			t.Errorf("reportLabel(%s) = %q, want %q", tt.trust, got, tt.want)
+		}
+	}
+}
+
+func TestProofOfWorkValid(t *testing.T) {
+	tests := []struct {
+		sum  []byte
+		want int
+	}{
+		{[]byte{0x80}, 0},
+		{[]byte{0x01}, 7},
+		{[]byte{0x00, 0x0f}, 12},
+		{make([]byte, 32), 256},
+	}
+	for _, tt := range tests {
+		if got := leadingZeroBits(tt.sum); got != tt.want {
+			t.Errorf("leadingZeroBits(%x) = %d, want %d", tt.sum, got, tt.want)
+		}
+	}
+
+	// sha256("abc:181") starts 00 36: ten zero bits
+	if !ProofOfWorkValid("abc", "181", 10) {
+		t.Errorf("abc:181 has 10 leading zero bits")
+	}
+	// and sha256("abc:180") starts c6: none
+	if ProofOfWorkValid("abc", "181", 11) || ProofOfWorkValid("abc", "180", 1) {
+		t.Errorf("abc:181 has only 10 leading zero bits, abc:180 none")
+	}
+}
+
+func solveWork(challenge string, bits int) string {
+	for i := 0; ; i++ {
+		if nonce := strconv.Itoa(i); ProofOfWorkValid(challenge, nonce, bits) {
+			return nonce
+		}
+	}
+}
+
+func newTestUnverifiedReports(t *testing.T, cfg *config.Config) (*UnverifiedReports, *verificationNotifier) {
+	t.Helper()
+	postgres, redis := testPostgres(t), testRedis(t)
+	notifier := &verificationNotifier{}
+	return NewUnverifiedReports(config.NewStore(cfg), postgres, redis, notifier, nil), notifier
+}
+
+func unverifiedTestConfig() *config.Config {
+	return &config.Config{
+		UnverifiedPerPhonePerHour: 10,
+		UnverifiedPerIPPerHour:    10,
+		UnverifiedFreePerHour:     10,
+		UnverifiedPoWBits:         4,
+	}
+}
+
+// A report is stored unverified on the active alert and never evaluated:
+// it leaves no heartbeat, score or state behind. Confirming it labels it
+// as the user's; it still isn't evaluated.
+func TestUnverifiedReportConfirm(t *testing.T) {
+	reports, notifier := newTestUnverifiedReports(t, unverifiedTestConfig())
+	postgres, redis := reports.postgres, reports.redis
+	ctx := context.Background()
+
+	user := createTestUser(t, postgres, "Ada")
+	alert := &models.Alert{ID: uuid.New(), UserID: user.ID, State: models.AlertStateAlert, Score: 20, Reason: "test", CreatedAt: time.Now()}
+	if err := postgres.CreateAlert(ctx, alert); err != nil {
+		t.Fatalf("CreateAlert: %v", err)
+	}
+	if err := postgres.UpsertPushToken(ctx, user.ID, "fcm-"+user.ID.String()); err != nil {
+		t.Fatalf("UpsertPushToken: %v", err)
+	}
+
+	challenge, err := reports.Submit(ctx, UnverifiedReportInput{Phone: user.Phone, Lat: 6.6018, Lng: 3.3515, ReportedAt: time.Now(), ClientIP: "192.0.2.10"})
+	if err != nil || challenge != nil {
+		t.Fatalf("Submit() = %v, %v; want accepted", challenge, err)
+	}
+
+	stored, err := postgres.GetAlertUnverifiedReports(ctx, alert.ID, 10)
+	if err != nil {
+		t.Fatalf("GetAlertUnverifiedReports: %v", err)
+	}
+	if len(stored) != 1 {
+		t.Fatalf("%d reports on the alert, want 1", len(stored))
+	}
+	report := stored[0]
+	if report.Trust != models.ReportUnverified || report.VerificationSentAt == nil {
+		t.Errorf("stored report = %+v, want unverified with a verification sent", report)
+	}
+	if len(notifier.reports) != 1 || notifier.reports[0] != report.ID.String() {
+		t.Errorf("verification pushes %v, want [%s]", notifier.reports, report.ID)
+	}
+
+	assertNotEvaluated := func() {
+		t.Helper()
+		if hb, err := postgres.GetLatestHeartbeat(ctx, user.ID); err != nil || hb != nil {
+			t.Errorf("GetLatestHeartbeat() = %v, %v; want none", hb, err)
+		}
+		if scores, err := postgres.GetScoreHistory(ctx, user.ID, time.Time{}, time.Now(), 10); err != nil || len(scores) != 0 {
+			t.Errorf("GetScoreHistory() = %v, %v; want none", scores, err)
+		}
+		if state, err := redis.GetUserState(ctx, user.ID); err != nil || state != nil {
+			t.Errorf("GetUserState() = %+v, %v; want none", state, err)
+		}
+	}
+	assertNotEvaluated()
+
+	if _, err := reports.Answer(ctx, uuid.New(), report.ID, true); !errors.Is(err, ErrReportNotFound) {
+		t.Errorf("Answer() by another user = %v, want ErrReportNotFound", err)
+	}
+	answered, err := reports.Answer(ctx, user.ID, report.ID, true)
+	if err != nil {
+		t.Fatalf("Answer: %v", err)
+	}
+	if answered.Trust != models.ReportConfirmed || answered.AnsweredAt == nil {
+		t.Errorf("answered report = %+v, want confirmed", answered)
+	}
+	if label := reportLabel(answered.Trust, user.Name); label != "Confirmed by Ada" {
+		t.Errorf("label %q, want Confirmed by Ada", label)
+	}
+	if _, err := reports.Answer(ctx, user.ID, report.ID, false); !errors.Is(err, ErrReportAnswered) {
+		t.Errorf("second Answer() = %v, want ErrReportAnswered", err)
+	}
+	assertNotEvaluated()
+}
+
+// A denied report is hidden from contacts
+func TestUnverifiedReportDeny(t *testing.T) {
+	reports, notifier := newTestUnverifiedReports(t, unverifiedTestConfig())
+	postgres := reports.postgres
+	ctx := context.Background()
+
+	user := createTestUser(t, postgres, "Ada")
+	alert := &models.Alert{ID: uuid.New(), UserID: user.ID, State: models.AlertStateAlert, Reason: "test", CreatedAt: time.Now()}
+	if err := postgres.CreateAlert(ctx, alert); err != nil {
+		t.Fatalf("CreateAlert: %v", err)
+	}
+	if _, err := reports.Submit(ctx, UnverifiedReportInput{Phone: user.Phone, Lat: 6.6, Lng: 3.3, ReportedAt: time.Now(), ClientIP: "192.0.2.11"}); err != nil {
+		t.Fatalf("Submit: %v", err)
+	}
+	if len(notifier.reports) != 0 {
+		t.Errorf("verification pushed to a user without a device")
+	}
+	stored, err := postgres.GetAlertUnverifiedReports(ctx, alert.ID, 10)
+	if err != nil || len(stored) != 1 {
+		t.Fatalf("GetAlertUnverifiedReports() = %v, %v; want one report", stored, err)
+	}
+
+	if _, err := reports.Answer(ctx, user.ID, stored[0].ID, false); err != nil {
+		t.Fatalf("Answer: %v", err)
+	}
+	if stored, err := postgres.GetAlertUnverifiedReports(ctx, alert.ID, 10); err != nil || len(stored) != 0 {
+		t.Errorf("denied report still shown: %v, %v", stored, err)
+	}
+}
+
+// Past the free reports a proof of work is asked for, and past the limit
+// reports are refused. Unregistered numbers are answered the same way.
+func TestUnverifiedReportLimits(t *testing.T) {
+	cfg := unverifiedTestConfig()
+	cfg.UnverifiedFreePerHour, cfg.UnverifiedPerPhonePerHour = 1, 4
+	reports, _ := newTestUnverifiedReports(t, cfg)
+	ctx := context.Background()
+	in := UnverifiedReportInput{Phone: testPhone(), Lat: 6.6, Lng: 3.3, ReportedAt: time.Now(), ClientIP: "192.0.2.12"}
+
+	if challenge, err := reports.Submit(ctx, in); err != nil || challenge != nil {
+		t.Fatalf("first Submit() = %v, %v; want accepted", challenge, err)
+	}
+	challenge, err := reports.Submit(ctx, in)
+	if err != nil || challenge == nil {
+		t.Fatalf("second Submit() = %v, %v; want a challenge", challenge, err)
+	}
+	if challenge.Bits != cfg.UnverifiedPoWBits {
+		t.Errorf("challenge bits %d, want %d", challenge.Bits, cfg.UnverifiedPoWBits)
+	}
+
+	in.ProofOfWork = challenge.Challenge + ":" + solveWork(challenge.Challenge, challenge.Bits)
+	if next, err := reports.Submit(ctx, in); err != nil || next != nil {
+		t.Fatalf("Submit() with proof of work = %v, %v; want accepted", next, err)
+	}
+	// A challenge answers once
+	if next, err := reports.Submit(ctx, in); err != nil || next == nil {
+		t.Fatalf("Submit() with a used proof = %v, %v; want a new challenge", next, err)
+	}
+	if _, err := reports.Submit(ctx, in); !errors.Is(err, ErrReportLimited) {
+		t.Errorf("Submit() past the limit = %v, want ErrReportLimited", err)
+	}
+}
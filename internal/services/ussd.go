@@ -0,0 +1,315 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/google/uuid"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/config"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/country"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/database"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+)
+
+const (
+	// ussdSessionTTL outlives a gateway session, which networks end after
+	// about three minutes
+	ussdSessionTTL = 5 * time.Minute
+	// ussdLandmarkMaxLen bounds the landmark a caller can type, in characters
+	ussdLandmarkMaxLen = 120
+	// ussdAccuracyM is the least accuracy a USSD check-in claims: it has no
+	// fix of its own, only the user's last known position
+	ussdAccuracyM = 5000
+
+	// USSDDeviceID is the device USSD heartbeats are tracked under
+	USSDDeviceID = "ussd"
+)
+
+// Steps of the USSD menu
+const (
+	ussdStepMenu     = "menu"
+	ussdStepLandmark = "landmark"
+)
+
+const (
+	ussdMenuText     = "SafeTrace\n1. Check in\n2. I need help\n3. Share my area"
+	ussdLandmarkText = "Where are you? Enter a landmark, street or area:"
+)
+
+// USSDCall is one callback from the USSD gateway, in Africa's Talking's
+// protocol: Text is everything the caller has entered in the session so far,
+// each answer separated by '*'
+type USSDCall struct {
+	SessionID   string
+	ServiceCode string
+	PhoneNumber string
+	NetworkCode string // MCC and MNC of the caller's network, e.g. 62130
+	Text        string
+}
+
+// USSDReply is what the caller sees next; End closes the session
+type USSDReply struct {
+	Text string
+	End  bool
+}
+
+// String renders the reply as the gateway expects it: CON keeps the session
+// open for another answer, END closes it
+func (r USSDReply) String() string {
+	if r.End {
+		return "END " + r.Text
+	}
+	return "CON " + r.Text
+}
+
+// USSDSessionStore keeps USSD sessions between callbacks; RedisDB is one
+type USSDSessionStore interface {
+	GetUSSDSession(ctx context.Context, sessionID string) (*models.USSDSession, error)
+	SaveUSSDSession(ctx context.Context, sessionID string, session *models.USSDSession, ttl time.Duration) error
+	DeleteUSSDSession(ctx context.Context, sessionID string) error
+}
+
+// USSDActions carries out what a caller chose in the menu
+type USSDActions interface {
+	// CheckIn records a heartbeat for the caller; landmark is empty for a plain check-in
+	CheckIn(ctx context.Context, user *models.User, call USSDCall, landmark string) error
+	// Help raises a panic alert for the caller
+	Help(ctx context.Context, user *models.User, call USSDCall) error
+}
+
+// USSDMenu is the menu registered phones get when they dial the short code.
+// "Check in" records a heartbeat, "I need help" alerts the caller's contacts
+// and "Share my area" asks for a landmark, then records a heartbeat with it.
+// Dialing straight into an option (*short*code*1#) skips the menu.
+type USSDMenu struct {
+	sessions USSDSessionStore
+	actions  USSDActions
+}
+
+func NewUSSDMenu(sessions USSDSessionStore, actions USSDActions) *USSDMenu {
+	return &USSDMenu{
+		sessions: sessions,
+		actions:  actions,
+	}
+}
+
+// Handle answers one callback of a registered caller's session. The reply is
+// always usable; a non-nil error is a failed action or session lookup for
+// the caller to log, and the reply then says it failed.
+func (m *USSDMenu) Handle(ctx context.Context, user *models.User, call USSDCall) (USSDReply, error) {
+	session, err := m.sessions.GetUSSDSession(ctx, call.SessionID)
+	if err != nil {
+		return USSDReply{Text: "Sorry, something went wrong. Please try again.", End: true}, fmt.Errorf("failed to load USSD session: %w", err)
+	}
+	if session == nil {
+		session = &models.USSDSession{Step: ussdStepMenu}
+	}
+
+	input, ok := ussdInput(call.Text, session.Consumed)
+	if !ok {
+		// The gateway's text no longer extends what was answered; start over
+		session = &models.USSDSession{Step: ussdStepMenu}
+		input = ""
+	}
+	session.Consumed = len(call.Text)
+
+	var reply USSDReply
+	switch session.Step {
+	case ussdStepLandmark:
+		reply, err = m.landmark(ctx, user, call, session, input)
+	default:
+		reply, err = m.menu(ctx, user, call, session, input)
+	}
+
+	if reply.End {
+		if err := m.sessions.DeleteUSSDSession(ctx, call.SessionID); err != nil {
+			log.Printf("WARN: Failed to delete USSD session %s: %v", call.SessionID, err)
+		}
+	} else if saveErr := m.sessions.SaveUSSDSession(ctx, call.SessionID, session, ussdSessionTTL); saveErr != nil {
+		return USSDReply{Text: "Sorry, something went wrong. Please try again.", End: true}, fmt.Errorf("failed to save USSD session: %w", saveErr)
+	}
+	return reply, err
+}
+
+func (m *USSDMenu) menu(ctx context.Context, user *models.User, call USSDCall, session *models.USSDSession, input string) (USSDReply, error) {
+	switch input {
+	case "":
+		return USSDReply{Text: ussdMenuText}, nil
+	case "1":
+		if err := m.actions.CheckIn(ctx, user, call, ""); err != nil {
+			return USSDReply{Text: "Sorry, we could not record your check-in. Please try again.", End: true}, err
+		}
+		return USSDReply{Text: "Checked in. Stay safe.", End: true}, nil
+	case "2":
+		emergency := country.Active().ForPhone(user.Phone).Emergency.General
+		if err := m.actions.Help(ctx, user, call); err != nil {
+			return USSDReply{Text: "Sorry, we could not alert your contacts. Call " + emergency + " for emergency help.", End: true}, err
+		}
+		return USSDReply{Text: "Your contacts have been alerted. If you are in danger, call " + emergency + ".", End: true}, nil
+	case "3":
+		session.Step = ussdStepLandmark
+		return USSDReply{Text: ussdLandmarkText}, nil
+	default:
+		return USSDReply{Text: "Invalid choice.\n" + ussdMenuText}, nil
+	}
+}
+
+func (m *USSDMenu) landmark(ctx context.Context, user *models.User, call USSDCall, session *models.USSDSession, input string) (USSDReply, error) {
+	landmark := CleanLandmark(input)
+	if landmark == "" {
+		return USSDReply{Text: ussdLandmarkText}, nil
+	}
+	if err := m.actions.CheckIn(ctx, user, call, landmark); err != nil {
+		return USSDReply{Text: "Sorry, we could not record your area. Please try again.", End: true}, err
+	}
+	return USSDReply{Text: "Thank you. Your contacts can see you are at " + landmark + ".", End: true}, nil
+}
+
+// ussdInput returns the caller's latest answer: what text adds to the
+// consumed part already answered. It reports false when text doesn't extend
+// it, which happens when the session expired or the gateway restarted it.
+func ussdInput(text string, consumed int) (string, bool) {
+	if consumed > len(text) {
+		return "", false
+	}
+	if consumed == 0 {
+		return strings.TrimSpace(text), true
+	}
+	rest := text[consumed:]
+	if rest != "" && rest[0] != '*' {
+		return "", false
+	}
+	return strings.TrimSpace(strings.TrimPrefix(rest, "*")), true
+}
+
+// CleanLandmark tidies a landmark typed by a caller: control characters are
+// dropped, runs of spaces collapsed, and it is cut to ussdLandmarkMaxLen
+// characters
+func CleanLandmark(s string) string {
+	s = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return ' '
+		}
+		return r
+	}, s)
+	s = strings.Join(strings.Fields(s), " ")
+	if runes := []rune(s); len(runes) > ussdLandmarkMaxLen {
+		s = strings.TrimSpace(string(runes[:ussdLandmarkMaxLen]))
+	}
+	return s
+}
+
+// USSDService records what USSD callers choose: a check-in is a heartbeat
+// without GPS, carrying the caller's network and any landmark they typed,
+// and a help request raises a panic alert like a HELP text does. Neither has
+// a position of its own, so both carry the last known one. Callers are
+// identified by the number the gateway reports, so USSD heartbeats are
+// trusted like SMS from the user's registered phone.
+type USSDService struct {
+	cfg       *config.Store
+	postgres  *database.PostgresDB
+	evaluator *SafetyEvaluator
+}
+
+func NewUSSDService(cfg *config.Store, postgres *database.PostgresDB, evaluator *SafetyEvaluator) *USSDService {
+	return &USSDService{
+		cfg:       cfg,
+		postgres:  postgres,
+		evaluator: evaluator,
+	}
+}
+
+// CheckIn stores a USSD heartbeat and evaluates the user
+func (s *USSDService) CheckIn(ctx context.Context, user *models.User, call USSDCall, landmark string) error {
+	hb := s.heartbeat(user, call)
+	hb.Landmark = landmark
+	if last, err := s.postgres.GetLatestHeartbeat(ctx, user.ID); err == nil && last != nil {
+		// The cell is unknown, so the position is only as good as the network's reach
+		s.evaluator.RestoreLocation(ctx, last)
+		hb.Lat, hb.Lng = last.Lat, last.Lng
+		hb.AccuracyM = max(last.AccuracyM, ussdAccuracyM)
+	}
+	s.evaluator.MarkBackfill(ctx, hb)
+	s.evaluator.CoarsenLocation(ctx, user.Settings, hb)
+
+	if err := s.postgres.CreateHeartbeat(ctx, hb); err != nil {
+		return fmt.Errorf("failed to store USSD heartbeat: %w", err)
+	}
+	s.evaluator.TrackDevice(ctx, hb)
+	s.evaluator.TrackLastGasp(ctx, hb)
+
+	if !hb.Backfill {
+		s.evaluator.EvaluateHeartbeatAsync(hb)
+	}
+	log.Printf("INFO: USSD check-in from user %s", user.ID)
+	return nil
+}
+
+// Help records a LastGasp at the user's last known position and alerts their contacts
+func (s *USSDService) Help(ctx context.Context, user *models.User, call USSDCall) error {
+	hb := s.heartbeat(user, call)
+	hb.LastGasp = true
+	// USSD carries no position; use the last one we have
+	if last, err := s.postgres.GetLatestHeartbeat(ctx, user.ID); err == nil && last != nil {
+		s.evaluator.RestoreLocation(ctx, last)
+		hb.Lat, hb.Lng = last.Lat, last.Lng
+		hb.AccuracyM = last.AccuracyM
+		hb.CellInfo = last.CellInfo
+		hb.Landmark = last.Landmark
+	}
+
+	if err := s.postgres.CreateHeartbeat(ctx, hb); err != nil {
+		log.Printf("ERROR: Failed to store USSD help heartbeat for user %s: %v", user.ID, err)
+	}
+
+	if err := s.evaluator.RecordLastGasp(ctx, hb); err != nil {
+		log.Printf("ERROR: Failed to store USSD help lastgasp for user %s: %v", user.ID, err)
+	}
+
+	if err := s.evaluator.TriggerPanic(ctx, user.ID, PanicReason("ussd", "", "")); err != nil {
+		return fmt.Errorf("panic alert failed: %w", err)
+	}
+	log.Printf("INFO: USSD help request from user %s raised an alert", user.ID)
+	return nil
+}
+
+// heartbeat builds a USSD heartbeat for the caller, bound to the USSD source
+func (s *USSDService) heartbeat(user *models.User, call USSDCall) *models.Heartbeat {
+	now := time.Now()
+	hb := &models.Heartbeat{
+		ID:           uuid.New(),
+		UserID:       user.ID,
+		DeviceID:     USSDDeviceID,
+		CellInfo:     ussdCellInfo(call.NetworkCode),
+		Timestamp:    now,
+		CreatedAt:    now,
+		IdentifiedBy: models.IdentifiedBySender,
+	}
+	// The user was looked up by the caller's number, so the phone is verified
+	BindSource(hb, SourceEvidence{Channel: SourceUSSD, SenderVerified: true})
+	return hb
+}
+
+// ussdCellInfo is all a USSD session says about the caller's network: the
+// MCC and MNC in the gateway's network code. The cell itself is unknown.
+func ussdCellInfo(networkCode string) models.CellInfo {
+	info := models.CellInfo{NetworkType: models.NetworkTypeUnknown}
+	if len(networkCode) < 5 || len(networkCode) > 6 {
+		return info
+	}
+	mcc, err := strconv.Atoi(networkCode[:3])
+	if err != nil {
+		return info
+	}
+	mnc, err := strconv.Atoi(networkCode[3:])
+	if err != nil {
+		return info
+	}
+	info.MCC, info.MNC = mcc, mnc
+	return info
+}
@@ -0,0 +1,304 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+)
+
+// fakeGateway plays a USSD gateway against a USSDMenu: it keeps sessions in
+// memory and sends each answer with everything entered before it, '*'
+// separated, as Africa's Talking does
+type fakeGateway struct {
+	t        *testing.T
+	menu     *USSDMenu
+	user     *models.User
+	sessions map[string]*models.USSDSession
+	ttls     []time.Duration
+	failLoad error
+
+	checkIns  []string // landmark of each check-in
+	helps     int
+	actionErr error
+
+	text map[string]string // input so far per session
+}
+
+func newFakeGateway(t *testing.T) *fakeGateway {
+	g := &fakeGateway{
+		t:        t,
+		user:     &models.User{ID: uuid.New(), Phone: "+2348012345678"},
+		sessions: map[string]*models.USSDSession{},
+		text:     map[string]string{},
+	}
+	g.menu = NewUSSDMenu(g, g)
+	return g
+}
+
+func (g *fakeGateway) GetUSSDSession(ctx context.Context, sessionID string) (*models.USSDSession, error) {
+	if g.failLoad != nil {
+		return nil, g.failLoad
+	}
+	if s, ok := g.sessions[sessionID]; ok {
+		copied := *s
+		return &copied, nil
+	}
+	return nil, nil
+}
+
+func (g *fakeGateway) SaveUSSDSession(ctx context.Context, sessionID string, session *models.USSDSession, ttl time.Duration) error {
+	copied := *session
+	g.sessions[sessionID] = &copied
+	g.ttls = append(g.ttls, ttl)
+	return nil
+}
+
+func (g *fakeGateway) DeleteUSSDSession(ctx context.Context, sessionID string) error {
+	delete(g.sessions, sessionID)
+	return nil
+}
+
+func (g *fakeGateway) CheckIn(ctx context.Context, user *models.User, call USSDCall, landmark string) error {
+	if user != g.user {
+		g.t.Errorf("CheckIn for %v, want the caller", user.ID)
+	}
+	if g.actionErr != nil {
+		return g.actionErr
+	}
+	g.checkIns = append(g.checkIns, landmark)
+	return nil
+}
+
+func (g *fakeGateway) Help(ctx context.Context, user *models.User, call USSDCall) error {
+	if g.actionErr != nil {
+		return g.actionErr
+	}
+	g.helps++
+	return nil
+}
+
+// dial opens a session; its first callback carries any options dialed
+// straight into, like *384*1#
+func (g *fakeGateway) dial(sessionID, text string) (USSDReply, error) {
+	g.text[sessionID] = text
+	return g.send(sessionID, text)
+}
+
+// answer sends the caller's next answer in a session
+func (g *fakeGateway) answer(sessionID, answer string) (USSDReply, error) {
+	if g.text[sessionID] == "" {
+		g.text[sessionID] = answer
+	} else {
+		g.text[sessionID] += "*" + answer
+	}
+	return g.send(sessionID, g.text[sessionID])
+}
+
+func (g *fakeGateway) send(sessionID, text string) (USSDReply, error) {
+	return g.menu.Handle(context.Background(), g.user, USSDCall{
+		SessionID:   sessionID,
+		ServiceCode: "*384*7#",
+		PhoneNumber: g.user.Phone,
+		NetworkCode: "62130",
+		Text:        text,
+	})
+}
+
+// expectReply checks a reply's text start and whether it ends the session
+func expectReply(t *testing.T, step string, reply USSDReply, err error, prefix string, end bool) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("%s: %v", step, err)
+	}
+	if !strings.HasPrefix(reply.Text, prefix) || reply.End != end {
+		t.Fatalf("%s: reply = %q (end %t), want %q... (end %t)", step, reply.Text, reply.End, prefix, end)
+	}
+}
+
+func TestUSSDCheckIn(t *testing.T) {
+	g := newFakeGateway(t)
+	reply, err := g.dial("s1", "")
+	expectReply(t, "dial", reply, err, ussdMenuText, false)
+	if reply.String() != "CON "+ussdMenuText {
+		t.Errorf("rendered = %q, want CON and the menu", reply.String())
+	}
+	if g.sessions["s1"] == nil || g.ttls[0] != ussdSessionTTL {
+		t.Fatalf("session after dial = %+v, ttls %v", g.sessions["s1"], g.ttls)
+	}
+
+	reply, err = g.answer("s1", "1")
+	expectReply(t, "check in", reply, err, "Checked in.", true)
+	if !strings.HasPrefix(reply.String(), "END ") {
+		t.Errorf("rendered = %q, want END", reply.String())
+	}
+	if len(g.checkIns) != 1 || g.checkIns[0] != "" {
+		t.Errorf("check-ins = %q, want one without a landmark", g.checkIns)
+	}
+	if _, ok := g.sessions["s1"]; ok {
+		t.Error("session kept after it ended")
+	}
+}
+
+func TestUSSDHelp(t *testing.T) {
+	g := newFakeGateway(t)
+	g.dial("s1", "")
+	reply, err := g.answer("s1", "2")
+	expectReply(t, "help", reply, err, "Your contacts have been alerted.", true)
+	if !strings.Contains(reply.Text, "call 112") {
+		t.Errorf("reply = %q, want Nigeria's emergency number", reply.Text)
+	}
+	if g.helps != 1 || len(g.checkIns) != 0 {
+		t.Errorf("helps = %d, check-ins = %d; want 1 and 0", g.helps, len(g.checkIns))
+	}
+}
+
+func TestUSSDShareArea(t *testing.T) {
+	g := newFakeGateway(t)
+	g.dial("s1", "")
+	reply, err := g.answer("s1", "3")
+	expectReply(t, "share", reply, err, ussdLandmarkText, false)
+
+	// A blank landmark is asked for again
+	reply, err = g.answer("s1", "  ")
+	expectReply(t, "blank landmark", reply, err, ussdLandmarkText, false)
+	if len(g.checkIns) != 0 {
+		t.Fatalf("check-ins after blank landmark = %q", g.checkIns)
+	}
+
+	reply, err = g.answer("s1", "Ikeja   Under\tBridge")
+	expectReply(t, "landmark", reply, err, "Thank you. Your contacts can see you are at Ikeja Under Bridge.", true)
+	if len(g.checkIns) != 1 || g.checkIns[0] != "Ikeja Under Bridge" {
+		t.Errorf("check-ins = %q, want the tidied landmark", g.checkIns)
+	}
+}
+
+func TestUSSDInvalidChoice(t *testing.T) {
+	g := newFakeGateway(t)
+	g.dial("s1", "")
+	reply, err := g.answer("s1", "9")
+	expectReply(t, "invalid", reply, err, "Invalid choice.\n"+ussdMenuText, false)
+
+	// The menu takes another answer after a wrong one
+	reply, err = g.answer("s1", "1")
+	expectReply(t, "check in", reply, err, "Checked in.", true)
+	if len(g.checkIns) != 1 {
+		t.Errorf("check-ins = %d, want 1", len(g.checkIns))
+	}
+}
+
+// Dialing *384*7*1# or *384*7*3*landmark# skips the menu
+func TestUSSDDialStraightIn(t *testing.T) {
+	g := newFakeGateway(t)
+	reply, err := g.dial("s1", "1")
+	expectReply(t, "dial 1", reply, err, "Checked in.", true)
+
+	reply, err = g.dial("s2", "3")
+	expectReply(t, "dial 3", reply, err, ussdLandmarkText, false)
+	reply, err = g.answer("s2", "Yaba market")
+	expectReply(t, "landmark", reply, err, "Thank you.", true)
+
+	if len(g.checkIns) != 2 || g.checkIns[1] != "Yaba market" {
+		t.Errorf("check-ins = %q", g.checkIns)
+	}
+}
+
+// Sessions don't see each other's answers
+func TestUSSDSessionsApart(t *testing.T) {
+	g := newFakeGateway(t)
+	g.dial("s1", "")
+	g.dial("s2", "")
+	reply, err := g.answer("s1", "3")
+	expectReply(t, "s1 share", reply, err, ussdLandmarkText, false)
+	reply, err = g.answer("s2", "2")
+	expectReply(t, "s2 help", reply, err, "Your contacts have been alerted.", true)
+	reply, err = g.answer("s1", "Lekki toll gate")
+	expectReply(t, "s1 landmark", reply, err, "Thank you.", true)
+}
+
+// A gateway text that no longer extends what was answered starts the menu over
+func TestUSSDRestartedSession(t *testing.T) {
+	g := newFakeGateway(t)
+	g.dial("s1", "")
+	g.answer("s1", "3")
+	reply, err := g.send("s1", "")
+	expectReply(t, "restarted", reply, err, ussdMenuText, false)
+	if g.sessions["s1"].Step != ussdStepMenu {
+		t.Errorf("step = %q, want the menu", g.sessions["s1"].Step)
+	}
+
+	// Text that diverges from what was answered restarts it too
+	g.answer("s1", "3")
+	reply, err = g.send("s1", "2x")
+	expectReply(t, "diverged", reply, err, ussdMenuText, false)
+	if len(g.checkIns) != 0 || g.helps != 0 {
+		t.Errorf("restart acted: check-ins %q, helps %d", g.checkIns, g.helps)
+	}
+}
+
+func TestUSSDFailures(t *testing.T) {
+	failed := errors.New("down")
+
+	g := newFakeGateway(t)
+	g.actionErr = failed
+	g.dial("s1", "")
+	reply, err := g.answer("s1", "1")
+	if !errors.Is(err, failed) || !reply.End || !strings.Contains(reply.Text, "could not record your check-in") {
+		t.Errorf("failed check-in = %q, %v", reply.Text, err)
+	}
+	g.dial("s2", "")
+	reply, err = g.answer("s2", "2")
+	if !errors.Is(err, failed) || !reply.End || !strings.Contains(reply.Text, "Call 112") {
+		t.Errorf("failed help = %q, %v", reply.Text, err)
+	}
+	if _, ok := g.sessions["s1"]; ok {
+		t.Error("session kept after a failed action")
+	}
+
+	g = newFakeGateway(t)
+	g.failLoad = failed
+	reply, err = g.dial("s1", "1")
+	if !errors.Is(err, failed) || !reply.End || len(g.checkIns) != 0 {
+		t.Errorf("unreadable session = %q, %v, check-ins %q", reply.Text, err, g.checkIns)
+	}
+}
+
+func TestCleanLandmark(t *testing.T) {
+	long := strings.Repeat("é", ussdLandmarkMaxLen+10)
+	tests := map[string]string{
+		"  Ikeja  ":           "Ikeja",
+		"Oshodi\nbus\x00stop": "Oshodi bus stop",
+		"\t\r\n":              "",
+		long:                  strings.Repeat("é", ussdLandmarkMaxLen),
+	}
+	for in, want := range tests {
+		if got := CleanLandmark(in); got != want {
+			t.Errorf("CleanLandmark(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestUSSDCellInfo(t *testing.T) {
+	tests := []struct {
+		code     string
+		mcc, mnc int
+	}{
+		{"62130", 621, 30},
+		{"621020", 621, 20},
+		{"", 0, 0},
+		{"6213", 0, 0},
+		{"6213000", 0, 0},
+		{"62a30", 0, 0},
+	}
+	for _, tt := range tests {
+		info := ussdCellInfo(tt.code)
+		if info.MCC != tt.mcc || info.MNC != tt.mnc || info.CID != 0 || info.NetworkType != models.NetworkTypeUnknown {
+			t.Errorf("ussdCellInfo(%q) = %+v, want MCC %d MNC %d and an unknown cell", tt.code, info, tt.mcc, tt.mnc)
+		}
+	}
+}
@@ -0,0 +1,271 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/database"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+)
+
+var (
+	// ErrProtectionPaused is returned when starting a watch while protection is paused
+	ErrProtectionPaused = errors.New("protection is paused")
+	// ErrWatchActive is returned when pausing protection during a watch
+	ErrWatchActive = errors.New("user has an active watch")
+)
+
+const (
+	watchMonitorWorker = "watch_monitor"
+	// watchMonitorEvery is also how often watched users are re-evaluated,
+	// instead of only when a heartbeat arrives or the stale monitor finds
+	// them due
+	watchMonitorEvery = 30 * time.Second
+	watchExpireBatch  = 100
+	// watchStateUnknown is recorded for a watch whose user had no state
+	watchStateUnknown = "UNKNOWN"
+)
+
+// WatchService runs watch sessions: a user asks to be watched more closely
+// for a while, e.g. on a walk home. While the watch is open the evaluator
+// applies a tightened profile and a worker re-evaluates the user every pass.
+// A watch that runs out without the user confirming they arrived raises an
+// AT_RISK alert unless they were last seen SAFE.
+type WatchService struct {
+	postgres  *database.PostgresDB
+	redis     *database.RedisDB
+	evaluator *SafetyEvaluator
+	notifier  Notifier
+	audit     *AuditLogger
+	health    *HealthRegistry
+
+	closeOnce sync.Once
+	stop      chan struct{}
+	done      chan struct{}
+}
+
+func NewWatchService(
+	postgres *database.PostgresDB,
+	redis *database.RedisDB,
+	evaluator *SafetyEvaluator,
+	notifier Notifier,
+	audit *AuditLogger,
+	health *HealthRegistry,
+) *WatchService {
+	return &WatchService{
+		postgres:  postgres,
+		redis:     redis,
+		evaluator: evaluator,
+		notifier:  notifier,
+		audit:     audit,
+		health:    health,
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+}
+
+// Begin opens a watch until now+d, or extends the open one to now+d. It
+// reports whether an open watch was extended, and fails with
+// ErrProtectionPaused while the user's protection is paused.
+func (s *WatchService) Begin(ctx context.Context, userID uuid.UUID, d time.Duration, note string) (*models.WatchSession, bool, error) {
+	now := time.Now()
+	pause, err := s.postgres.GetActiveProtectionPause(ctx, userID, now)
+	if err != nil {
+		return nil, false, err
+	}
+	if pause != nil {
+		return nil, false, ErrProtectionPaused
+	}
+
+	active, err := s.postgres.GetActiveWatchSession(ctx, userID, now)
+	if err != nil {
+		return nil, false, err
+	}
+	// A lapsed watch the worker hasn't closed yet would otherwise be
+	// extended without its outcome being checked
+	if active == nil {
+		s.expireLapsed()
+	}
+
+	watch, err := s.postgres.UpsertWatchSession(ctx, &models.WatchSession{
+		ID:        uuid.New(),
+		UserID:    userID,
+		Note:      note,
+		StartedAt: now,
+		EndsAt:    now.Add(d),
+	})
+	if err != nil {
+		return nil, false, err
+	}
+
+	// Records the tightened state right away so the status endpoint shows the watch
+	if _, err := s.evaluator.EvaluateUserSafety(ctx, userID); err != nil {
+		log.Printf("WARN: Failed to evaluate user %s on watch start: %v", userID, err)
+	}
+	return watch, active != nil, nil
+}
+
+// Complete closes the user's open watch as "I arrived" and re-evaluates them.
+// It returns the closed watch, or nil if they had none.
+func (s *WatchService) Complete(ctx context.Context, userID uuid.UUID) (*models.WatchSession, error) {
+	watch, err := s.postgres.CompleteWatchSession(ctx, userID)
+	if err != nil || watch == nil {
+		return nil, err
+	}
+	s.evaluator.EvaluateAsync(userID)
+	return watch, nil
+}
+
+// Active returns the user's open watch, or nil
+func (s *WatchService) Active(ctx context.Context, userID uuid.UUID) (*models.WatchSession, error) {
+	return s.postgres.GetActiveWatchSession(ctx, userID, time.Now())
+}
+
+// Start launches the monitor goroutine; the first pass runs immediately so
+// watches that ran out while the server was down are settled at startup
+func (s *WatchService) Start() {
+	s.health.Register(watchMonitorWorker, watchMonitorEvery)
+	go s.run()
+}
+
+// Close stops the monitor goroutine and waits for a running pass to finish
+func (s *WatchService) Close() {
+	s.closeOnce.Do(func() {
+		close(s.stop)
+	})
+	<-s.done
+}
+
+func (s *WatchService) run() {
+	defer close(s.done)
+
+	ticker := time.NewTicker(watchMonitorEvery)
+	defer ticker.Stop()
+
+	for {
+		if s.expireLapsed() && s.checkActive() {
+			s.health.Beat(watchMonitorWorker)
+		}
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// expireLapsed settles every watch that has run out and reports whether it succeeded
+func (s *WatchService) expireLapsed() bool {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	for {
+		watches, err := s.postgres.ExpireLapsedWatchSessions(ctx, time.Now(), watchExpireBatch)
+		if err != nil {
+			log.Printf("ERROR: Failed to expire lapsed watch sessions: %v", err)
+			return false
+		}
+		for i := range watches {
+			s.expired(ctx, &watches[i])
+		}
+		if len(watches) < watchExpireBatch {
+			return true
+		}
+	}
+}
+
+// checkActive queues an evaluation of every watched user and reports whether
+// the watches could be listed
+func (s *WatchService) checkActive() bool {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	watches, err := s.postgres.GetActiveWatchSessions(ctx, time.Now())
+	if err != nil {
+		log.Printf("ERROR: Failed to list active watch sessions: %v", err)
+		return false
+	}
+	for _, w := range watches {
+		s.evaluator.EvaluateAsync(w.UserID)
+	}
+	return true
+}
+
+// expired raises an alert for a watch that ran out while the user was not
+// known to be SAFE, records the outcome and audits it. A user last seen SAFE
+// is only told the watch ended.
+func (s *WatchService) expired(ctx context.Context, watch *models.WatchSession) {
+	userID := watch.UserID
+	lastState := watchStateUnknown
+	lastScore := -1
+	state, err := s.evaluator.CurrentState(ctx, userID)
+	if err != nil {
+		log.Printf("WARN: State unavailable for user %s at watch expiry: %v", userID, err)
+	} else if state != nil {
+		lastState = state.State
+		lastScore = state.Score
+	}
+
+	alerted := false
+	if lastState != StateSafe {
+		var settings models.UserSettings
+		if user, err := s.postgres.GetUserByID(ctx, userID); err == nil && user != nil {
+			settings = user.Settings
+		}
+		alerted, err = s.evaluator.RaiseWatchExpired(ctx, userID, watchExpiredReason(watch, settings, lastState, lastScore))
+		if err != nil {
+			log.Printf("ERROR: Failed to raise watch expiry alert for user %s: %v", userID, err)
+		}
+	}
+	log.Printf("INFO: Watch for user %s ran out in state %s (alert raised: %t)", userID, lastState, alerted)
+
+	if err := s.postgres.RecordWatchOutcome(ctx, watch.ID, lastState, alerted); err != nil {
+		log.Printf("WARN: Failed to record outcome of watch %s: %v", watch.ID, err)
+	}
+	s.audit.Record(&models.AuditEvent{
+		ActorRole:     "system",
+		Action:        AuditWatchExpire,
+		ObjectType:    "watch_session",
+		ObjectID:      watch.ID.String(),
+		SubjectUserID: &userID,
+		Metadata: map[string]interface{}{
+			"last_state":   lastState,
+			"alert_raised": alerted,
+		},
+	})
+	s.evaluator.EvaluateAsync(userID)
+
+	if lastState != StateSafe {
+		return
+	}
+	token, err := s.postgres.GetPushToken(ctx, userID)
+	if err != nil || token == "" {
+		return
+	}
+	err = s.notifier.SendPushNotification(ctx, token,
+		"Watch ended",
+		"Your SafeTrace watch has ended. Monitoring is back to normal.")
+	if err != nil {
+		log.Printf("WARN: Failed to tell user %s their watch ended: %v", userID, err)
+	}
+}
+
+// watchExpiredReason is the alert reason for a watch that ran out, giving
+// contacts the context the user set it up with
+func watchExpiredReason(watch *models.WatchSession, settings models.UserSettings, lastState string, lastScore int) models.Reason {
+	params := map[string]interface{}{
+		"ended_at":   FormatInUserZone(watch.EndsAt, settings, LayoutClock),
+		"last_state": lastState,
+	}
+	if lastScore >= 0 {
+		params["last_score"] = lastScore
+	}
+	if watch.Note != "" {
+		params["note"] = watch.Note
+	}
+	return models.Reason{Code: models.ReasonWatchExpired, Params: params}
+}
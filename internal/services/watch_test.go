@@ -0,0 +1,94 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/config"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+)
+
+// Contacts are told when the watch ended, in the user's zone, with what the
+// user noted and the last state known
+func TestWatchExpiredReason(t *testing.T) {
+	watch := &models.WatchSession{EndsAt: time.Date(2026, 3, 9, 20, 30, 0, 0, time.UTC), Note: "walking home from Yaba"}
+	settings := models.UserSettings{Timezone: "Africa/Lagos"}
+
+	tests := []struct {
+		name      string
+		watch     *models.WatchSession
+		lastState string
+		lastScore int
+		want      string
+	}{
+		{"with a note", watch, StateCaution, 62,
+			"Watch session ended at 9:30 PM WAT without the user confirming they arrived; last state CAUTION (score 62). Note: walking home from Yaba"},
+		{"no note, no state", &models.WatchSession{EndsAt: watch.EndsAt}, watchStateUnknown, -1,
+			"Watch session ended at 9:30 PM WAT without the user confirming they arrived; last state UNKNOWN"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reason := watchExpiredReason(tt.watch, settings, tt.lastState, tt.lastScore)
+			if reason.Code != models.ReasonWatchExpired {
+				t.Errorf("code = %s", reason.Code)
+			}
+			if got := ReasonText(reason); got != tt.want {
+				t.Errorf("reason =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+// Under a watch, heartbeats go stale in half the window and a score needs
+// more points to stay SAFE
+func TestWatchedProfile(t *testing.T) {
+	cfg := simulationConfig()
+	profile := DefaultScoringProfile(cfg)
+	watched := profile.Watched()
+	if watched.HeartbeatWindowSeconds != 300 || watched.SafeThreshold != 90 || watched.CautionThreshold != 60 {
+		t.Errorf("watched profile = window %d, thresholds %d/%d", watched.HeartbeatWindowSeconds, watched.SafeThreshold, watched.CautionThreshold)
+	}
+
+	hb := simulatedHeartbeat(0, false)
+	se := &SafetyEvaluator{cfg: config.NewStore(cfg), clock: NewFakeClock(hb.Timestamp.Add(6 * time.Minute)), effects: discardEffects{}}
+	if result := se.Assess(&hb, nil, profile); isStalenessRisk(result) {
+		t.Errorf("unwatched user stale after 6 minutes")
+	}
+	if result := se.Assess(&hb, nil, watched); !isStalenessRisk(result) {
+		t.Errorf("watched user = %s %v after 6 minutes, want stale", result.State, result.RulesFired)
+	}
+}
+
+// A watch and a protection pause never overlap: neither starts while the
+// other is open
+func TestWatchPauseExclusive(t *testing.T) {
+	postgres := testPostgres(t)
+	ctx := context.Background()
+	now := time.Now()
+
+	paused := createTestUser(t, postgres, "Ada")
+	if _, err := postgres.UpsertProtectionPause(ctx, &models.ProtectionPause{
+		ID: uuid.New(), UserID: paused.ID, PausedAt: now, PausedUntil: now.Add(time.Hour),
+	}); err != nil {
+		t.Fatalf("UpsertProtectionPause: %v", err)
+	}
+	watches := NewWatchService(postgres, nil, nil, nil, nil, nil)
+	if _, _, err := watches.Begin(ctx, paused.ID, 30*time.Minute, ""); !errors.Is(err, ErrProtectionPaused) {
+		t.Errorf("Begin() while paused = %v, want ErrProtectionPaused", err)
+	}
+
+	watched := createTestUser(t, postgres, "Bola")
+	if _, err := postgres.UpsertWatchSession(ctx, &models.WatchSession{
+		ID: uuid.New(), UserID: watched.ID, StartedAt: now, EndsAt: now.Add(30 * time.Minute),
+	}); err != nil {
+		t.Fatalf("UpsertWatchSession: %v", err)
+	}
+	protection := NewProtectionService(postgres, nil, nil, nil, nil)
+	if _, err := protection.Pause(ctx, watched.ID, time.Hour, "gym"); !errors.Is(err, ErrWatchActive) {
+		t.Errorf("Pause() while watched = %v, want ErrWatchActive", err)
+	}
+}
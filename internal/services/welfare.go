@@ -0,0 +1,293 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/config"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/database"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/pluscode"
+)
+
+var (
+	// ErrWelfareCheckPending is returned when the user already has a check waiting on them
+	ErrWelfareCheckPending = errors.New("a welfare check is already pending")
+	// ErrWelfareCheckLimit is returned when the requester has used up today's checks on the user
+	ErrWelfareCheckLimit = errors.New("daily welfare check limit reached")
+)
+
+const (
+	welfareMonitorWorker = "welfare_monitor"
+	welfareMonitorEvery  = 30 * time.Second
+	welfareExpireBatch   = 100
+	// welfareStateUnknown is reported when the user couldn't be evaluated
+	welfareStateUnknown = "UNKNOWN"
+)
+
+// WelfareRequester is the trusted contact or guardian asking for a check
+type WelfareRequester struct {
+	Type  string // models.WelfareRequesterContact | models.WelfareRequesterGuardian
+	ID    string // contact ID or guardian user ID
+	Name  string
+	Phone string
+}
+
+// WelfareCheckService runs welfare checks: a trusted contact or guardian who
+// is worried asks SafeTrace to check on the user. The user is asked by push
+// and SMS to confirm they are okay. If they don't in time, they are evaluated
+// strictly and the requester is texted their latest status and location.
+//
+// To keep the feature from being used to track the user covertly, the user
+// is always told who asked, only one check can be pending at a time, each
+// requester gets WELFARE_CHECK_DAILY_LIMIT checks per user per day, and every
+// check stays on the user's own record.
+type WelfareCheckService struct {
+	cfg       *config.Store
+	postgres  *database.PostgresDB
+	redis     *database.RedisDB
+	evaluator *SafetyEvaluator
+	notifier  Notifier
+	templates *MessageTemplates
+	audit     *AuditLogger
+	health    *HealthRegistry
+
+	closeOnce sync.Once
+	stop      chan struct{}
+	done      chan struct{}
+}
+
+func NewWelfareCheckService(
+	cfg *config.Store,
+	postgres *database.PostgresDB,
+	redis *database.RedisDB,
+	evaluator *SafetyEvaluator,
+	notifier Notifier,
+	templates *MessageTemplates,
+	audit *AuditLogger,
+	health *HealthRegistry,
+) *WelfareCheckService {
+	return &WelfareCheckService{
+		cfg:       cfg,
+		postgres:  postgres,
+		redis:     redis,
+		evaluator: evaluator,
+		notifier:  notifier,
+		templates: templates,
+		audit:     audit,
+		health:    health,
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+}
+
+// Request opens a check on the user and asks them to confirm they are okay.
+// It fails with ErrWelfareCheckPending while another check is waiting and
+// with ErrWelfareCheckLimit once the requester has used up today's checks.
+func (s *WelfareCheckService) Request(ctx context.Context, user *models.User, requester WelfareRequester) (*models.WelfareCheck, error) {
+	pending, err := s.postgres.GetPendingWelfareCheck(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	if pending != nil {
+		return nil, ErrWelfareCheckPending
+	}
+
+	cfg := s.cfg.Current()
+	now := time.Now()
+	day := now.In(deploymentLocation()).Format("2006-01-02")
+	allowed, err := s.redis.ClaimWelfareCheck(ctx, user.ID, requester.ID, day, cfg.WelfareCheckDailyLimit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check welfare check limit: %w", err)
+	}
+	if !allowed {
+		return nil, ErrWelfareCheckLimit
+	}
+
+	check, err := s.postgres.CreateWelfareCheck(ctx, &models.WelfareCheck{
+		ID:             uuid.New(),
+		UserID:         user.ID,
+		RequesterType:  requester.Type,
+		RequesterID:    requester.ID,
+		RequesterName:  requester.Name,
+		RequesterPhone: requester.Phone,
+		RequestedAt:    now,
+		RespondBy:      now.Add(time.Duration(cfg.WelfareCheckTimeoutMinutes) * time.Minute),
+	})
+	if err != nil {
+		return nil, err
+	}
+	// Another requester got in between the check above and the insert
+	if check == nil {
+		return nil, ErrWelfareCheckPending
+	}
+
+	s.prompt(ctx, user, check)
+	return check, nil
+}
+
+// prompt asks the user, by push and SMS, to confirm they are okay. The SMS is
+// skipped while they roam with ROAMING_SMS_SUPPRESSED on.
+func (s *WelfareCheckService) prompt(ctx context.Context, user *models.User, check *models.WelfareCheck) {
+	message := s.templates.Render(TemplateWelfarePrompt, MessageData{
+		Name:      user.Name,
+		Time:      FormatInUserZone(check.RespondBy, user.Settings, LayoutClock),
+		Requester: check.RequesterName,
+	})
+
+	if token, err := s.postgres.GetPushToken(ctx, user.ID); err == nil && token != "" {
+		err = s.notifier.SendPushNotification(ctx, token,
+			"Are you okay?",
+			check.RequesterName+" asked SafeTrace to check on you. Tap to confirm you're okay.")
+		if err != nil {
+			log.Printf("WARN: Failed to push welfare check %s to user %s: %v", check.ID, user.ID, err)
+		}
+	}
+	if RoamingSMSSuppressed(ctx, s.cfg, s.redis, user.ID) {
+		log.Printf("INFO: Welfare check %s not texted to user %s, who is roaming", check.ID, user.ID)
+		return
+	}
+	if err := s.notifier.SendSMS(ctx, MessageWelfare, user.Phone, message); err != nil {
+		log.Printf("WARN: Failed to text welfare check %s to user %s: %v", check.ID, user.ID, err)
+	}
+}
+
+// Confirm records that the user is okay, closing their pending check, and
+// tells the requester. It returns the check, or nil if none was pending.
+func (s *WelfareCheckService) Confirm(ctx context.Context, userID uuid.UUID) (*models.WelfareCheck, error) {
+	check, err := s.postgres.ConfirmWelfareCheck(ctx, userID)
+	if err != nil || check == nil {
+		return nil, err
+	}
+
+	user, err := s.postgres.GetUserByID(ctx, userID)
+	if err != nil || user == nil {
+		log.Printf("WARN: User %s unavailable to tell requester of welfare check %s: %v", userID, check.ID, err)
+		return check, nil
+	}
+	message := s.templates.Render(TemplateWelfareConfirmed, MessageData{
+		Name: user.Name,
+		Time: FormatInUserZone(*check.ResolvedAt, user.Settings, LayoutClock),
+	})
+	s.tellRequester(ctx, check, message)
+	return check, nil
+}
+
+// History returns the user's most recent checks, newest first
+func (s *WelfareCheckService) History(ctx context.Context, userID uuid.UUID, limit int) ([]models.WelfareCheck, error) {
+	return s.postgres.GetWelfareChecks(ctx, userID, limit)
+}
+
+// Start launches the monitor goroutine; the first pass runs immediately so
+// checks that ran out while the server was down are settled at startup
+func (s *WelfareCheckService) Start() {
+	s.health.Register(welfareMonitorWorker, welfareMonitorEvery)
+	go s.run()
+}
+
+// Close stops the monitor goroutine and waits for a running pass to finish
+func (s *WelfareCheckService) Close() {
+	s.closeOnce.Do(func() {
+		close(s.stop)
+	})
+	<-s.done
+}
+
+func (s *WelfareCheckService) run() {
+	defer close(s.done)
+
+	ticker := time.NewTicker(welfareMonitorEvery)
+	defer ticker.Stop()
+
+	for {
+		if s.expireLapsed() {
+			s.health.Beat(welfareMonitorWorker)
+		}
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// expireLapsed settles every check that went unanswered and reports whether it succeeded
+func (s *WelfareCheckService) expireLapsed() bool {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	for {
+		checks, err := s.postgres.ExpireLapsedWelfareChecks(ctx, time.Now(), welfareExpireBatch)
+		if err != nil {
+			log.Printf("ERROR: Failed to expire unanswered welfare checks: %v", err)
+			return false
+		}
+		for i := range checks {
+			s.unanswered(ctx, &checks[i])
+		}
+		if len(checks) < welfareExpireBatch {
+			return true
+		}
+	}
+}
+
+// unanswered evaluates the user strictly, which alerts their contacts as
+// usual if it finds them at risk, and texts the requester the result with
+// the user's last known location
+func (s *WelfareCheckService) unanswered(ctx context.Context, check *models.WelfareCheck) {
+	userID := check.UserID
+	state, score := welfareStateUnknown, 0
+	result, err := s.evaluator.EvaluateStrictly(ctx, userID)
+	if err != nil {
+		log.Printf("ERROR: Failed to evaluate user %s for welfare check %s: %v", userID, check.ID, err)
+	} else {
+		state, score = result.State, result.Score
+		if err := s.postgres.RecordWelfareOutcome(ctx, check.ID, state, score); err != nil {
+			log.Printf("WARN: Failed to record outcome of welfare check %s: %v", check.ID, err)
+		}
+	}
+	log.Printf("INFO: Welfare check %s on user %s went unanswered; state %s", check.ID, userID, state)
+
+	s.audit.Record(&models.AuditEvent{
+		ActorRole:     "system",
+		Action:        AuditWelfareUnanswered,
+		ObjectType:    "welfare_check",
+		ObjectID:      check.ID.String(),
+		SubjectUserID: &userID,
+		Metadata: map[string]interface{}{
+			"requester_type": check.RequesterType,
+			"requester_id":   check.RequesterID,
+			"state":          state,
+		},
+	})
+
+	user, err := s.postgres.GetUserByID(ctx, userID)
+	if err != nil || user == nil {
+		log.Printf("WARN: User %s unavailable to tell requester of welfare check %s: %v", userID, check.ID, err)
+		return
+	}
+	data := MessageData{Name: user.Name, State: state, Score: score}
+	hb, err := s.postgres.GetLatestHeartbeat(ctx, userID)
+	if err != nil {
+		log.Printf("WARN: Last location of user %s unavailable for welfare check %s: %v", userID, check.ID, err)
+	} else if hb != nil {
+		data.Time = FormatInUserZone(hb.Timestamp, user.Settings, LayoutDateTime)
+		data.MapLink = fmt.Sprintf("https://www.google.com/maps?q=%.6f,%.6f", hb.Lat, hb.Lng)
+		data.PlusCode = pluscode.Encode(hb.Lat, hb.Lng, pluscode.DefaultLength)
+	}
+	s.tellRequester(ctx, check, s.templates.Render(TemplateWelfareUnanswered, data))
+}
+
+func (s *WelfareCheckService) tellRequester(ctx context.Context, check *models.WelfareCheck, message string) {
+	if check.RequesterPhone == "" {
+		return
+	}
+	if err := s.notifier.SendSMS(ctx, MessageWelfare, check.RequesterPhone, message); err != nil {
+		log.Printf("WARN: Failed to text requester of welfare check %s: %v", check.ID, err)
+	}
+}
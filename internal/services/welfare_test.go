@@ -0,0 +1,81 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/config"
+)
+
+// welfareNotifier counts the texts sent; any other notification is a test
+// failure, as the nil Notifier panics
+type welfareNotifier struct {
+	Notifier
+	mu  sync.Mutex
+	sms []string
+}
+
+func (n *welfareNotifier) SendSMS(ctx context.Context, kind, to, message string) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.sms = append(n.sms, to)
+	return nil
+}
+
+func (n *welfareNotifier) sent() int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return len(n.sms)
+}
+
+// A user can't be prompted again while a check waits on them, and a
+// requester can't prompt them more than the day's limit, however promptly
+// they confirm
+func TestWelfareCheckLimits(t *testing.T) {
+	postgres := testPostgres(t)
+	redis := testRedis(t)
+	ctx := context.Background()
+
+	templates, err := NewMessageTemplates(nil)
+	if err != nil {
+		t.Fatalf("NewMessageTemplates: %v", err)
+	}
+	cfg := &config.Config{WelfareCheckDailyLimit: 2, WelfareCheckTimeoutMinutes: 15}
+	notifier := &welfareNotifier{}
+	s := NewWelfareCheckService(config.NewStore(cfg), postgres, redis, nil, notifier, templates, nil, nil)
+
+	user := createTestUser(t, postgres, "Ada")
+	contact := WelfareRequester{Type: "contact", ID: "c1", Name: "Tunde", Phone: testPhone()}
+	other := WelfareRequester{Type: "contact", ID: "c2", Name: "Bisi", Phone: testPhone()}
+
+	if _, err := s.Request(ctx, user, contact); err != nil {
+		t.Fatalf("first Request: %v", err)
+	}
+	// Pending blocks every requester, and isn't charged to them
+	if _, err := s.Request(ctx, user, other); !errors.Is(err, ErrWelfareCheckPending) {
+		t.Errorf("Request while pending = %v, want ErrWelfareCheckPending", err)
+	}
+	if _, err := s.Confirm(ctx, user.ID); err != nil {
+		t.Fatalf("Confirm: %v", err)
+	}
+
+	if _, err := s.Request(ctx, user, contact); err != nil {
+		t.Fatalf("second Request: %v", err)
+	}
+	if _, err := s.Confirm(ctx, user.ID); err != nil {
+		t.Fatalf("Confirm: %v", err)
+	}
+	if _, err := s.Request(ctx, user, contact); !errors.Is(err, ErrWelfareCheckLimit) {
+		t.Errorf("Request past the limit = %v, want ErrWelfareCheckLimit", err)
+	}
+	if _, err := s.Request(ctx, user, other); err != nil {
+		t.Errorf("another requester's first Request: %v", err)
+	}
+
+	// Three prompts to the user, and two confirmations to the contact
+	if got := notifier.sent(); got != 5 {
+		t.Errorf("%d texts sent, want 5", got)
+	}
+}
@@ -4,8 +4,14 @@ import (
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
 )
 
 // SignPayload signs a payload using HMAC-SHA256
@@ -45,3 +51,120 @@ func VerifyStringSignature(data, signature, secret string) bool {
 	expectedSignature := SignString(data, secret)
 	return hmac.Equal([]byte(signature), []byte(expectedSignature))
 }
+
+// VerifyStringSignatureAny accepts a signature made with any of the secrets,
+// which lets clients keep working while a secret is being rotated
+func VerifyStringSignatureAny(data, signature string, secrets []string) bool {
+	for _, secret := range secrets {
+		if VerifyStringSignature(data, signature, secret) {
+			return true
+		}
+	}
+	return false
+}
+
+// CanonicalHeartbeatString builds the version 1 signing string for a heartbeat.
+// Fields are pipe-separated in a fixed order with fixed precision so the
+// result does not depend on any JSON encoder:
+//
+//	v1|<user_id>|<unix_ts>|<lat %.6f>|<lng %.6f>|<accuracy_m>|<mcc>,<mnc>,<cid>,<lac>,<rssi>,<network_type>|<battery_pct or ->|<speed %.2f or ->|<last_gasp 0/1>[|<device_id>]
+//
+// user_id is the lowercase UUID; missing battery_pct/speed are encoded as "-".
+// device_id is appended only when set, so clients that don't send one sign
+// as before. Neighbor cells are not signed. See docs/SIGNING.md for test vectors.
+func CanonicalHeartbeatString(hb *models.Heartbeat) string {
+	fields := heartbeatFields("v1", hb)
+	if hb.DeviceID != "" {
+		fields = append(fields, hb.DeviceID)
+	}
+	return strings.Join(fields, "|")
+}
+
+// CanonicalHeartbeatStringV2 builds the version 2 signing string, which also
+// commits to the channel the heartbeat was sent over and a client nonce:
+//
+//	v2|<user_id>|<unix_ts>|...|<last_gasp 0/1>|<source>|<device_id>|<nonce>
+//
+// The fields up to last_gasp are encoded as in version 1. source, device_id
+// and nonce are always present; device_id is empty when not sent.
+func CanonicalHeartbeatStringV2(hb *models.Heartbeat, source, nonce string) string {
+	fields := append(heartbeatFields("v2", hb), source, hb.DeviceID, nonce)
+	return strings.Join(fields, "|")
+}
+
+// heartbeatFields are the signed fields both versions share, from the
+// version through last_gasp
+func heartbeatFields(version string, hb *models.Heartbeat) []string {
+	battery := "-"
+	if hb.BatteryPct != nil {
+		battery = strconv.Itoa(*hb.BatteryPct)
+	}
+	speed := "-"
+	if hb.Speed != nil {
+		speed = strconv.FormatFloat(*hb.Speed, 'f', 2, 64)
+	}
+	lastGasp := "0"
+	if hb.LastGasp {
+		lastGasp = "1"
+	}
+
+	return []string{
+		version,
+		strings.ToLower(hb.UserID.String()),
+		strconv.FormatInt(hb.Timestamp.Unix(), 10),
+		strconv.FormatFloat(hb.Lat, 'f', 6, 64),
+		strconv.FormatFloat(hb.Lng, 'f', 6, 64),
+		strconv.Itoa(hb.AccuracyM),
+		fmt.Sprintf("%d,%d,%d,%d,%d,%s",
+			hb.CellInfo.MCC, hb.CellInfo.MNC, hb.CellInfo.CID,
+			hb.CellInfo.LAC, hb.CellInfo.RSSI, hb.CellInfo.NetworkType),
+		battery,
+		speed,
+		lastGasp,
+	}
+}
+
+// BlackboxChainGenesis is the previous hash of the first entry in a trail
+const BlackboxChainGenesis = "0000000000000000000000000000000000000000000000000000000000000000"
+
+// CanonicalBlackboxEntryString builds the version 1 hashing string for a
+// blackbox trail entry, in the same style as CanonicalHeartbeatString:
+//
+//	b1|<unix_ms>|<lat %.6f>|<lng %.6f>|<accuracy_m>|<mcc>,<mnc>,<cid>,<lac>,<rssi>,<network_type>|<accel_x>,<accel_y>,<accel_z>|<gyro_x>,<gyro_y>,<gyro_z>
+//
+// Sensor values are fixed-point with 4 decimals. The entry's own hash is not
+// part of the string. See docs/SIGNING.md for test vectors.
+func CanonicalBlackboxEntryString(e *models.BlackboxEntry) string {
+	sensor := func(x, y, z float64) string {
+		return strconv.FormatFloat(x, 'f', 4, 64) + "," +
+			strconv.FormatFloat(y, 'f', 4, 64) + "," +
+			strconv.FormatFloat(z, 'f', 4, 64)
+	}
+
+	return strings.Join([]string{
+		"b1",
+		strconv.FormatInt(e.Timestamp.UnixMilli(), 10),
+		strconv.FormatFloat(e.Lat, 'f', 6, 64),
+		strconv.FormatFloat(e.Lng, 'f', 6, 64),
+		strconv.Itoa(e.AccuracyM),
+		fmt.Sprintf("%d,%d,%d,%d,%d,%s",
+			e.CellInfo.MCC, e.CellInfo.MNC, e.CellInfo.CID,
+			e.CellInfo.LAC, e.CellInfo.RSSI, e.CellInfo.NetworkType),
+		sensor(e.SensorData.AccelX, e.SensorData.AccelY, e.SensorData.AccelZ),
+		sensor(e.SensorData.GyroX, e.SensorData.GyroY, e.SensorData.GyroZ),
+	}, "|")
+}
+
+// BlackboxEntryHash chains an entry to the hash of the one before it
+// (BlackboxChainGenesis for the first): lowercase hex of
+// SHA256(prevHash + canonical entry string)
+func BlackboxEntryHash(prevHash string, e *models.BlackboxEntry) string {
+	sum := sha256.Sum256([]byte(prevHash + CanonicalBlackboxEntryString(e)))
+	return hex.EncodeToString(sum[:])
+}
+
+// BlackboxChainSigningString is what the device signs to vouch for a trail:
+// the trail's owner and the hash of its last entry
+func BlackboxChainSigningString(userID uuid.UUID, chainHead string) string {
+	return "b1|" + strings.ToLower(userID.String()) + "|" + chainHead
+}
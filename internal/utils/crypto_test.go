@@ -0,0 +1,258 @@
+package utils
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/models"
+)
+
+// Vectors from docs/SIGNING.md; clients test against the same ones
+const (
+	vectorSecret = "test-secret"
+	vectorNonce  = "3f9c2a7e1b4d4e6f"
+)
+
+func vectorHeartbeat() *models.Heartbeat {
+	battery, speed := 48, 0.0
+	return &models.Heartbeat{
+		UserID:     uuid.MustParse("550e8400-e29b-41d4-a716-446655440000"),
+		Lat:        6.5244,
+		Lng:        3.3792,
+		AccuracyM:  20,
+		CellInfo:   models.CellInfo{MCC: 621, MNC: 20, CID: 12345, LAC: 678, RSSI: -75, NetworkType: "4G"},
+		BatteryPct: &battery,
+		Speed:      &speed,
+		Timestamp:  time.Date(2025, 11, 19, 12, 0, 0, 0, time.UTC),
+	}
+}
+
+func TestCanonicalHeartbeatString(t *testing.T) {
+	tests := []struct {
+		name   string
+		hb     func() *models.Heartbeat
+		string string
+		sig    string
+	}{
+		{
+			name:   "battery and speed",
+			hb:     vectorHeartbeat,
+			string: "v1|550e8400-e29b-41d4-a716-446655440000|1763553600|6.524400|3.379200|20|621,20,12345,678,-75,4G|48|0.00|0",
+			sig:    "HIqcNDdCK+ZYTTUCThZjx4Uz5OrQYs6UroJJhnsx+Io=",
+		},
+		{
+			name: "no battery, speed rounded",
+			hb: func() *models.Heartbeat {
+				hb := vectorHeartbeat()
+				speed := 62.456
+				hb.Lat, hb.Lng, hb.AccuracyM, hb.BatteryPct, hb.Speed = 6.5, 3.35, 150, nil, &speed
+				hb.CellInfo = models.CellInfo{MCC: 621, MNC: 30, CID: 998877, LAC: 1200, RSSI: -101, NetworkType: "3G"}
+				return hb
+			},
+			string: "v1|550e8400-e29b-41d4-a716-446655440000|1763553600|6.500000|3.350000|150|621,30,998877,1200,-101,3G|-|62.46|0",
+			sig:    "/6qTuJaMOWwK1G7McQ5OK+BM5zsyZ0qH4bCu6mYY02Y=",
+		},
+		{
+			name: "empty cell info, last gasp",
+			hb: func() *models.Heartbeat {
+				hb := vectorHeartbeat()
+				hb.Lat, hb.Lng, hb.AccuracyM, hb.BatteryPct, hb.Speed, hb.LastGasp = -1.2921, 36.8219, 5, nil, nil, true
+				hb.CellInfo = models.CellInfo{}
+				return hb
+			},
+			string: "v1|550e8400-e29b-41d4-a716-446655440000|1763553600|-1.292100|36.821900|5|0,0,0,0,0,|-|-|1",
+			sig:    "/YIg258kN6prpjRE8VKkQu1IGSEBSRXMZbqFsIYc3H8=",
+		},
+		{
+			name: "device id",
+			hb: func() *models.Heartbeat {
+				hb := vectorHeartbeat()
+				hb.DeviceID = "pixel-7a"
+				return hb
+			},
+			string: "v1|550e8400-e29b-41d4-a716-446655440000|1763553600|6.524400|3.379200|20|621,20,12345,678,-75,4G|48|0.00|0|pixel-7a",
+			sig:    "pOPyr1lH6OqSiojKXfetaFu9KqDVJgffac++4sZNaJg=",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := CanonicalHeartbeatString(tt.hb())
+			if s != tt.string {
+				t.Fatalf("CanonicalHeartbeatString() =\n%s\nwant\n%s", s, tt.string)
+			}
+			if sig := SignString(s, vectorSecret); sig != tt.sig {
+				t.Errorf("signature %s, want %s", sig, tt.sig)
+			}
+		})
+	}
+}
+
+func TestCanonicalHeartbeatStringV2(t *testing.T) {
+	tests := []struct {
+		name     string
+		source   string
+		deviceID string
+		string   string
+		sig      string
+	}{
+		{
+			name:   "no device id",
+			source: "http",
+			string: "v2|550e8400-e29b-41d4-a716-446655440000|1763553600|6.524400|3.379200|20|621,20,12345,678,-75,4G|48|0.00|0|http||3f9c2a7e1b4d4e6f",
+			sig:    "MXPsAokPlnQ6ZkLcP6QEngEuGiIHm0Aj55wYGh4nUug=",
+		},
+		{
+			name:     "device id",
+			source:   "http",
+			deviceID: "pixel-7a",
+			string:   "v2|550e8400-e29b-41d4-a716-446655440000|1763553600|6.524400|3.379200|20|621,20,12345,678,-75,4G|48|0.00|0|http|pixel-7a|3f9c2a7e1b4d4e6f",
+			sig:      "jOnllmM2Pu45GDRnT6pHL80oKIkH8cmP+5s+iW5Uphc=",
+		},
+		{
+			name:     "signed for another channel",
+			source:   "sms",
+			deviceID: "pixel-7a",
+			string:   "v2|550e8400-e29b-41d4-a716-446655440000|1763553600|6.524400|3.379200|20|621,20,12345,678,-75,4G|48|0.00|0|sms|pixel-7a|3f9c2a7e1b4d4e6f",
+			sig:      "MN2eqnoiMOpVA/Is3kU8B02y5zdBcIhwIftViUHnzQA=",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hb := vectorHeartbeat()
+			hb.DeviceID = tt.deviceID
+			s := CanonicalHeartbeatStringV2(hb, tt.source, vectorNonce)
+			if s != tt.string {
+				t.Fatalf("CanonicalHeartbeatStringV2() =\n%s\nwant\n%s", s, tt.string)
+			}
+			if sig := SignString(s, vectorSecret); sig != tt.sig {
+				t.Errorf("signature %s, want %s", sig, tt.sig)
+			}
+		})
+	}
+}
+
+// A v2 signature covers the source, device and nonce: one made for SMS, for
+// another device or with another nonce doesn't verify for the HTTP heartbeat
+func TestSignatureV2Binding(t *testing.T) {
+	hb := vectorHeartbeat()
+	hb.DeviceID = "pixel-7a"
+	sig := SignString(CanonicalHeartbeatStringV2(hb, "http", vectorNonce), vectorSecret)
+
+	other := vectorHeartbeat()
+	other.DeviceID = "pixel-8"
+	tests := []struct {
+		name string
+		data string
+		want bool
+	}{
+		{"as signed", CanonicalHeartbeatStringV2(hb, "http", vectorNonce), true},
+		{"other channel", CanonicalHeartbeatStringV2(hb, "sms", vectorNonce), false},
+		{"other device", CanonicalHeartbeatStringV2(other, "http", vectorNonce), false},
+		{"other nonce", CanonicalHeartbeatStringV2(hb, "http", "0000000000000000"), false},
+		{"as v1", CanonicalHeartbeatString(hb), false},
+	}
+	for _, tt := range tests {
+		if got := VerifyStringSignatureAny(tt.data, sig, []string{"rotated-out", vectorSecret}); got != tt.want {
+			t.Errorf("%s: verified = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func vectorBlackboxEntries() []models.BlackboxEntry {
+	cell := func(rssi int) models.CellInfo {
+		return models.CellInfo{MCC: 621, MNC: 20, CID: 12345, LAC: 678, RSSI: rssi, NetworkType: "4G"}
+	}
+	return []models.BlackboxEntry{
+		{
+			Timestamp:  time.Date(2025, 11, 19, 12, 0, 0, 0, time.UTC),
+			Lat:        6.5244,
+			Lng:        3.3792,
+			AccuracyM:  12,
+			CellInfo:   cell(-75),
+			SensorData: models.SensorData{AccelX: 0.12, AccelY: -0.034, AccelZ: 9.81, GyroX: 0.001, GyroZ: -0.5},
+		},
+		{
+			Timestamp:  time.Date(2025, 11, 19, 12, 0, 0, 250_000_000, time.UTC),
+			Lat:        6.52441,
+			Lng:        3.37925,
+			AccuracyM:  15,
+			CellInfo:   cell(-77),
+			SensorData: models.SensorData{AccelX: 38.5, AccelY: -12.25, AccelZ: 4, GyroX: 2.75, GyroY: -1.5, GyroZ: 0.125},
+		},
+	}
+}
+
+// The b1 vectors of docs/SIGNING.md, entry strings through to the chain signature
+func TestBlackboxChain(t *testing.T) {
+	want := []struct {
+		string string
+		hash   string
+	}{
+		{
+			string: "b1|1763553600000|6.524400|3.379200|12|621,20,12345,678,-75,4G|0.1200,-0.0340,9.8100|0.0010,0.0000,-0.5000",
+			hash:   "046a986cd826fbcd284beeefbddd1a949e8f3a1b62b2c76b216ef7886f8b5ad6",
+		},
+		{
+			string: "b1|1763553600250|6.524410|3.379250|15|621,20,12345,678,-77,4G|38.5000,-12.2500,4.0000|2.7500,-1.5000,0.1250",
+			hash:   "7741538e33c5e3f3d0bd01e1e74d77fac9552fe6ae5d9b3425cac968e6524912",
+		},
+	}
+
+	prev := BlackboxChainGenesis
+	for i, e := range vectorBlackboxEntries() {
+		if s := CanonicalBlackboxEntryString(&e); s != want[i].string {
+			t.Fatalf("entry %d string =\n%s\nwant\n%s", i, s, want[i].string)
+		}
+		if hash := BlackboxEntryHash(prev, &e); hash != want[i].hash {
+			t.Fatalf("entry %d hash = %s, want %s", i, hash, want[i].hash)
+		}
+		prev = want[i].hash
+	}
+
+	userID := uuid.MustParse("550e8400-e29b-41d4-a716-446655440000")
+	signing := BlackboxChainSigningString(userID, prev)
+	if signing != "b1|550e8400-e29b-41d4-a716-446655440000|"+want[1].hash {
+		t.Errorf("signing string = %s", signing)
+	}
+	if sig := SignString(signing, vectorSecret); sig != "o1qTbFVyE0/anXADW1w9Gi4hjrkkj1IA1mSxNOYbYJE=" {
+		t.Errorf("chain signature = %s", sig)
+	}
+}
+
+// What the entry string leaves out or rounds away doesn't change the hash;
+// everything it covers does
+func TestCanonicalBlackboxEntryString(t *testing.T) {
+	base := vectorBlackboxEntries()[0]
+	tests := []struct {
+		name   string
+		change func(e *models.BlackboxEntry)
+		same   bool
+	}{
+		{"own hash", func(e *models.BlackboxEntry) { e.Hash = "ffff" }, true},
+		{"sub-millisecond time", func(e *models.BlackboxEntry) { e.Timestamp = e.Timestamp.Add(400 * time.Microsecond) }, true},
+		{"other time zone", func(e *models.BlackboxEntry) { e.Timestamp = e.Timestamp.In(time.FixedZone("WAT", 3600)) }, true},
+		{"beyond 6 decimals", func(e *models.BlackboxEntry) { e.Lat += 1e-8 }, true},
+		{"beyond 4 decimals", func(e *models.BlackboxEntry) { e.SensorData.AccelZ += 1e-6 }, true},
+		{"a millisecond", func(e *models.BlackboxEntry) { e.Timestamp = e.Timestamp.Add(time.Millisecond) }, false},
+		{"latitude", func(e *models.BlackboxEntry) { e.Lat += 1e-6 }, false},
+		{"longitude", func(e *models.BlackboxEntry) { e.Lng = -e.Lng }, false},
+		{"accuracy", func(e *models.BlackboxEntry) { e.AccuracyM++ }, false},
+		{"network type", func(e *models.BlackboxEntry) { e.CellInfo.NetworkType = "5G" }, false},
+		{"gyroscope", func(e *models.BlackboxEntry) { e.SensorData.GyroY = 0.0001 }, false},
+	}
+	for _, tt := range tests {
+		e := base
+		tt.change(&e)
+		if same := BlackboxEntryHash(BlackboxChainGenesis, &e) == BlackboxEntryHash(BlackboxChainGenesis, &base); same != tt.same {
+			t.Errorf("%s: hash unchanged = %v, want %v", tt.name, same, tt.same)
+		}
+	}
+
+	// Missing sensor values are zeros, as the apps send them
+	empty := models.BlackboxEntry{Timestamp: base.Timestamp}
+	if s, want := CanonicalBlackboxEntryString(&empty), "b1|1763553600000|0.000000|0.000000|0|0,0,0,0,0,|0.0000,0.0000,0.0000|0.0000,0.0000,0.0000"; s != want {
+		t.Errorf("empty entry =\n%s\nwant\n%s", s, want)
+	}
+}
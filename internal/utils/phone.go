@@ -0,0 +1,29 @@
+package utils
+
+import (
+	"strings"
+
+	"github.com/adedejiosvaldo/safetrace/backend/internal/country"
+)
+
+// NormalizePhone converts a phone number to E.164. Local numbers (0803...)
+// and bare country-code numbers (234803...) of the countries the deployment
+// serves get their calling code; spaces, dashes, dots and parentheses are
+// stripped. Numbers already in international form are kept as-is apart from
+// the stripping.
+func NormalizePhone(phone string) string {
+	return country.Active().NormalizePhone(phone)
+}
+
+// IsValidE164 reports whether phone is "+" followed by 8 to 15 digits
+func IsValidE164(phone string) bool {
+	if !strings.HasPrefix(phone, "+") || len(phone) < 9 || len(phone) > 16 {
+		return false
+	}
+	for _, r := range phone[1:] {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,45 @@
+package utils
+
+import "testing"
+
+// Every way a Nigerian number is written normalizes to the one E.164 form,
+// so the same number can't be registered twice under different spellings
+func TestNormalizePhone(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"+2348031234567", "+2348031234567"},
+		{"08031234567", "+2348031234567"},
+		{"2348031234567", "+2348031234567"},
+		{"002348031234567", "+2348031234567"},
+		{" 0803 123 4567 ", "+2348031234567"},
+		{"(0803) 123-4567", "+2348031234567"},
+		{"+234 803.123.4567", "+2348031234567"},
+		{"+447700900123", "+447700900123"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := NormalizePhone(tt.in); got != tt.want {
+			t.Errorf("NormalizePhone(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestIsValidE164(t *testing.T) {
+	tests := []struct {
+		phone string
+		want  bool
+	}{
+		{"+2348031234567", true},
+		{"+12345678", true},
+		{"+1234567", false},
+		{"+1234567890123456", false},
+		{"2348031234567", false},
+		{"+234803123456a", false},
+	}
+	for _, tt := range tests {
+		if got := IsValidE164(tt.phone); got != tt.want {
+			t.Errorf("IsValidE164(%q) = %v, want %v", tt.phone, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+const (
+	RoleUser     = "user"
+	RoleContact  = "contact"
+	RoleAdmin    = "admin"
+	RoleOrgAdmin = "org_admin" // administers one organization's members
+
+	// RoleResponder is the audit actor role of a responder API key; it is
+	// never carried by a token
+	RoleResponder = "responder"
+)
+
+// Scopes carried by contact access tokens
+const (
+	ScopeReadStatus   = "read_status"   // status, LastGasp and score history
+	ScopeReadAlerts   = "read_alerts"   // the user's alerts
+	ScopeWelfareCheck = "welfare_check" // ask SafeTrace to check on the user
+)
+
+var (
+	ErrInvalidToken = errors.New("invalid token")
+	ErrExpiredToken = errors.New("token expired")
+)
+
+// TokenClaims is the payload of an API access token (HS256 JWT)
+type TokenClaims struct {
+	Subject   string   `json:"sub"`              // user ID the token is about
+	Role      string   `json:"role"`             // user | contact | admin | org_admin
+	Phone     string   `json:"phone,omitempty"`  // contact's phone for contact tokens
+	ContactID string   `json:"cid,omitempty"`    // contact the token was issued to
+	Scopes    []string `json:"scopes,omitempty"` // what a contact token may do
+	OrgID     string   `json:"org,omitempty"`    // organization an org_admin token administers
+	ID        string   `json:"jti,omitempty"`    // token ID, used for revocation
+	IssuedAt  int64    `json:"iat"`
+	ExpiresAt int64    `json:"exp"`
+}
+
+// HasScope reports whether the token carries scope
+func (c *TokenClaims) HasScope(scope string) bool {
+	for _, s := range c.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+var tokenHeader = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+
+// IssueToken signs claims with the JWT secret, valid for ttl
+func IssueToken(claims TokenClaims, secret string, ttl time.Duration) (string, error) {
+	now := time.Now()
+	claims.IssuedAt = now.Unix()
+	claims.ExpiresAt = now.Add(ttl).Unix()
+
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := tokenHeader + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return signingInput + "." + signToken(signingInput, secret), nil
+}
+
+// ParseToken verifies a token's signature and expiry and returns its claims
+func ParseToken(token, secret string) (*TokenClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 || parts[0] != tokenHeader {
+		return nil, ErrInvalidToken
+	}
+
+	expected := signToken(parts[0]+"."+parts[1], secret)
+	if !hmac.Equal([]byte(parts[2]), []byte(expected)) {
+		return nil, ErrInvalidToken
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	var claims TokenClaims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, ErrInvalidToken
+	}
+	if time.Now().Unix() >= claims.ExpiresAt {
+		return nil, ErrExpiredToken
+	}
+
+	return &claims, nil
+}
+
+func signToken(signingInput, secret string) string {
+	h := hmac.New(sha256.New, []byte(secret))
+	h.Write([]byte(signingInput))
+	return base64.RawURLEncoding.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,16 @@
+-- Create alert_deliveries table (one row per contact per channel per alert)
+CREATE TABLE IF NOT EXISTS alert_deliveries (
+    id UUID PRIMARY KEY,
+    alert_id UUID NOT NULL REFERENCES alerts(id) ON DELETE CASCADE,
+    contact_id VARCHAR(64) NOT NULL,
+    contact_name VARCHAR(255) NOT NULL,
+    phone VARCHAR(20) NOT NULL,
+    channel VARCHAR(20) NOT NULL,
+    status VARCHAR(20) NOT NULL CHECK (status IN ('sent', 'failed', 'suppressed')),
+    detail TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+-- Indexes
+CREATE INDEX IF NOT EXISTS idx_alert_deliveries_alert_id ON alert_deliveries(alert_id);
+CREATE INDEX IF NOT EXISTS idx_alert_deliveries_phone_created ON alert_deliveries(phone, created_at DESC);
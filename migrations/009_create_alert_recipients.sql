@@ -0,0 +1,18 @@
+-- Create alert_recipients table (one row per contact per alert, tracks acknowledgment)
+CREATE TABLE IF NOT EXISTS alert_recipients (
+    id UUID PRIMARY KEY,
+    alert_id UUID NOT NULL REFERENCES alerts(id) ON DELETE CASCADE,
+    contact_id VARCHAR(64) NOT NULL,
+    contact_name VARCHAR(255) NOT NULL,
+    phone VARCHAR(20) NOT NULL,
+    channel VARCHAR(20) NOT NULL,
+    ack_token VARCHAR(64) NOT NULL UNIQUE,
+    delivered_at TIMESTAMP,
+    acknowledged_at TIMESTAMP,
+    ack_method VARCHAR(20) CHECK (ack_method IN ('sms', 'link', 'voice')),
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+-- Indexes
+CREATE INDEX IF NOT EXISTS idx_alert_recipients_alert_id ON alert_recipients(alert_id);
+CREATE INDEX IF NOT EXISTS idx_alert_recipients_phone_created ON alert_recipients(phone, created_at DESC);
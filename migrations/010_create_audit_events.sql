@@ -0,0 +1,18 @@
+-- Create audit_events table (who read or changed sensitive data)
+CREATE TABLE IF NOT EXISTS audit_events (
+    id UUID PRIMARY KEY,
+    actor_id VARCHAR(64) NOT NULL DEFAULT '',
+    actor_role VARCHAR(20) NOT NULL,
+    action VARCHAR(64) NOT NULL,
+    object_type VARCHAR(32) NOT NULL,
+    object_id VARCHAR(64) NOT NULL DEFAULT '',
+    subject_user_id UUID,
+    request_id VARCHAR(64) NOT NULL DEFAULT '',
+    metadata JSONB NOT NULL DEFAULT '{}'::jsonb,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+-- Indexes
+CREATE INDEX IF NOT EXISTS idx_audit_events_subject_created ON audit_events(subject_user_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_audit_events_actor_created ON audit_events(actor_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_audit_events_created ON audit_events(created_at DESC);
@@ -0,0 +1,6 @@
+-- Mock-location flag reported by the client, and the server's spoofing verdict
+ALTER TABLE heartbeats ADD COLUMN IF NOT EXISTS is_mock BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE heartbeats ADD COLUMN IF NOT EXISTS spoof_suspected BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE heartbeats ADD COLUMN IF NOT EXISTS spoof_reasons TEXT[];
+
+CREATE INDEX IF NOT EXISTS idx_heartbeats_spoof_suspected ON heartbeats(user_id, timestamp DESC) WHERE spoof_suspected;
@@ -0,0 +1,15 @@
+-- Every evaluation result, for charting and trend detection. Pruned by retention.
+CREATE TABLE IF NOT EXISTS score_history (
+    id BIGSERIAL PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    evaluated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    state VARCHAR(20) NOT NULL,
+    score INT NOT NULL,
+    trend_penalty INT NOT NULL DEFAULT 0, -- points the trend took off; score + trend_penalty is the instantaneous score
+    deterministic BOOLEAN NOT NULL DEFAULT FALSE,
+    breakdown JSONB,
+    reason TEXT
+);
+
+CREATE INDEX IF NOT EXISTS idx_score_history_user_time ON score_history(user_id, evaluated_at DESC);
+CREATE INDEX IF NOT EXISTS idx_score_history_evaluated_at ON score_history(evaluated_at);
@@ -0,0 +1,7 @@
+-- Crash-signature analysis of blackbox trails: when each trail was analyzed and
+-- the time of any impact found, so a trail is only analyzed once
+ALTER TABLE blackbox_trails ADD COLUMN IF NOT EXISTS impact_checked_at TIMESTAMPTZ;
+ALTER TABLE blackbox_trails ADD COLUMN IF NOT EXISTS impact_at TIMESTAMPTZ;
+
+CREATE INDEX IF NOT EXISTS idx_blackbox_trails_impact_unchecked ON blackbox_trails(uploaded_at)
+    WHERE impact_checked_at IS NULL;
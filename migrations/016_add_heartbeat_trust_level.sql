@@ -0,0 +1,9 @@
+-- How strongly a heartbeat is bound to the user: signed by the app (verified_device),
+-- sent from the registered phone (verified_phone), or neither (unverified)
+ALTER TABLE heartbeats ADD COLUMN IF NOT EXISTS trust_level TEXT NOT NULL DEFAULT 'unverified'
+    CHECK (trust_level IN ('verified_device', 'verified_phone', 'unverified'));
+
+-- Every stored HTTP heartbeat passed signature verification; SMS heartbeats
+-- attributed by sender came from the registered phone
+UPDATE heartbeats SET trust_level = 'verified_device' WHERE source = 'http';
+UPDATE heartbeats SET trust_level = 'verified_phone' WHERE source = 'sms' AND identified_by = 'sender';
@@ -0,0 +1,6 @@
+-- Mark heartbeats that arrived after a newer one (offline backfill, delayed SMS)
+ALTER TABLE heartbeats ADD COLUMN IF NOT EXISTS backfill BOOLEAN NOT NULL DEFAULT false;
+
+-- The latest heartbeat that drives state ignores backfilled ones
+CREATE INDEX IF NOT EXISTS idx_heartbeats_user_live_timestamp
+    ON heartbeats(user_id, timestamp DESC) WHERE NOT backfill;
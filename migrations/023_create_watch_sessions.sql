@@ -0,0 +1,20 @@
+-- Create watch_sessions table (user-requested periods of closer monitoring)
+CREATE TABLE IF NOT EXISTS watch_sessions (
+    id UUID PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    note TEXT NOT NULL DEFAULT '',
+    started_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    ends_at TIMESTAMP NOT NULL,
+    ended_at TIMESTAMP,
+    ended_by VARCHAR(20) CHECK (ended_by IN ('completed', 'expiry')),
+    last_state VARCHAR(20),
+    alert_raised BOOLEAN NOT NULL DEFAULT false,
+    CHECK (ends_at > started_at)
+);
+
+-- At most one open watch per user
+CREATE UNIQUE INDEX IF NOT EXISTS idx_watch_sessions_open
+    ON watch_sessions(user_id) WHERE ended_at IS NULL;
+CREATE INDEX IF NOT EXISTS idx_watch_sessions_expiry
+    ON watch_sessions(ends_at) WHERE ended_at IS NULL;
+CREATE INDEX IF NOT EXISTS idx_watch_sessions_user ON watch_sessions(user_id, started_at DESC);
@@ -0,0 +1,5 @@
+-- Plus code and what3words address of where an alert was raised, so
+-- responders can find places without a street address. NULL for alerts
+-- raised without a known position, including all historical rows.
+ALTER TABLE alerts ADD COLUMN IF NOT EXISTS plus_code VARCHAR(16);
+ALTER TABLE alerts ADD COLUMN IF NOT EXISTS what3words VARCHAR(100);
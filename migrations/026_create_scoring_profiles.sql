@@ -0,0 +1,36 @@
+-- Stored scoring profiles. At most one is active (used for live evaluation,
+-- falling back to the config-derived profile when there is none) and one is
+-- a candidate evaluated in the shadow of the active one.
+CREATE TABLE IF NOT EXISTS scoring_profiles (
+    id UUID PRIMARY KEY,
+    status VARCHAR(10) NOT NULL CHECK (status IN ('candidate', 'active', 'retired')),
+    profile JSONB NOT NULL,
+    note TEXT NOT NULL DEFAULT '',
+    created_by VARCHAR(64) NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    promoted_at TIMESTAMPTZ,
+    retired_at TIMESTAMPTZ
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_scoring_profiles_active ON scoring_profiles(status) WHERE status = 'active';
+CREATE UNIQUE INDEX IF NOT EXISTS idx_scoring_profiles_candidate ON scoring_profiles(status) WHERE status = 'candidate';
+
+-- What the candidate profile concluded next to the active one, per
+-- evaluation. Never acted on. Pruned with score history.
+CREATE TABLE IF NOT EXISTS shadow_results (
+    id BIGSERIAL PRIMARY KEY,
+    candidate_id UUID NOT NULL REFERENCES scoring_profiles(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    evaluated_at TIMESTAMPTZ NOT NULL,
+    active_state VARCHAR(20) NOT NULL,
+    active_score INT NOT NULL,
+    active_reason TEXT NOT NULL,
+    candidate_state VARCHAR(20) NOT NULL,
+    candidate_score INT NOT NULL,
+    candidate_reason TEXT NOT NULL,
+    candidate_rules JSONB NOT NULL DEFAULT '[]'::jsonb,
+    diverged BOOLEAN NOT NULL -- the states differ
+);
+
+CREATE INDEX IF NOT EXISTS idx_shadow_results_candidate_time ON shadow_results(candidate_id, evaluated_at);
+CREATE INDEX IF NOT EXISTS idx_shadow_results_evaluated_at ON shadow_results(evaluated_at);
@@ -0,0 +1,23 @@
+-- Create welfare_checks table (checks on a user requested by a contact or guardian)
+CREATE TABLE IF NOT EXISTS welfare_checks (
+    id UUID PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    requester_type VARCHAR(20) NOT NULL CHECK (requester_type IN ('contact', 'guardian')),
+    requester_id VARCHAR(64) NOT NULL,
+    requester_name VARCHAR(255) NOT NULL DEFAULT '',
+    requester_phone VARCHAR(20) NOT NULL DEFAULT '',
+    requested_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    respond_by TIMESTAMP NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'confirmed', 'unanswered')),
+    resolved_at TIMESTAMP,
+    outcome_state VARCHAR(20),
+    outcome_score INTEGER,
+    CHECK (respond_by > requested_at)
+);
+
+-- At most one pending check per user
+CREATE UNIQUE INDEX IF NOT EXISTS idx_welfare_checks_pending
+    ON welfare_checks(user_id) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS idx_welfare_checks_respond_by
+    ON welfare_checks(respond_by) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS idx_welfare_checks_user ON welfare_checks(user_id, requested_at DESC);
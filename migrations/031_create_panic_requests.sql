@@ -0,0 +1,25 @@
+-- Panic PINs, stored as bcrypt hashes and never returned by the API. The
+-- duress PIN appears to cancel a panic but sends it.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS panic_pin_hash TEXT;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS duress_pin_hash TEXT;
+
+-- Alerts sent because the duress PIN was entered
+ALTER TABLE alerts ADD COLUMN IF NOT EXISTS duress BOOLEAN NOT NULL DEFAULT false;
+
+-- Create panic_requests table (panics from the app, held for the cancellation window)
+CREATE TABLE IF NOT EXISTS panic_requests (
+    id UUID PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    release_at TIMESTAMP NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'cancelled', 'dispatched')),
+    resolution VARCHAR(20) CHECK (resolution IN ('cancelled', 'confirmed', 'wrong_pin', 'duress', 'expired', 'immediate')),
+    resolved_at TIMESTAMP,
+    CHECK (release_at >= created_at)
+);
+
+-- At most one pending panic per user; pressing again returns it
+CREATE UNIQUE INDEX IF NOT EXISTS idx_panic_requests_pending
+    ON panic_requests(user_id) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS idx_panic_requests_release_at
+    ON panic_requests(release_at) WHERE status = 'pending';
@@ -0,0 +1,4 @@
+-- How the device was connected when it recorded a heartbeat: wifi,
+-- cellular or offline_queued (recorded offline, uploaded later). NULL for
+-- clients that don't report it, including all historical rows.
+ALTER TABLE heartbeats ADD COLUMN IF NOT EXISTS connectivity VARCHAR(20);
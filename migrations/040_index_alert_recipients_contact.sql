@@ -0,0 +1,4 @@
+-- A contact's delivery history follows their contact id, not the number it
+-- went to, so it survives a change of number. Also finds the unresolved
+-- alerts a contact was sent before their number may change.
+CREATE INDEX IF NOT EXISTS idx_alert_recipients_contact ON alert_recipients(contact_id, created_at DESC);
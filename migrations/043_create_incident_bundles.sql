@@ -0,0 +1,27 @@
+-- The provider that accepted an alert SMS and its message ID (e.g. a Twilio
+-- SID), so a delivery can be traced in the provider's own records
+ALTER TABLE alert_deliveries ADD COLUMN IF NOT EXISTS provider VARCHAR(50);
+ALTER TABLE alert_deliveries ADD COLUMN IF NOT EXISTS provider_message_id VARCHAR(100);
+
+-- Investigation bundles: a zip of everything recorded about a resolved
+-- alert, built in the background and kept in object storage until
+-- expires_at. requested_by is the subject of the token that asked for it.
+CREATE TABLE IF NOT EXISTS incident_bundles (
+    id UUID PRIMARY KEY,
+    alert_id UUID NOT NULL REFERENCES alerts(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    requested_by VARCHAR(100) NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending', -- pending | building | ready | failed | expired
+    object_key VARCHAR(255),
+    size_bytes BIGINT,
+    manifest_signature VARCHAR(100),
+    error TEXT,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    claimed_at TIMESTAMPTZ,
+    completed_at TIMESTAMPTZ,
+    expires_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_incident_bundles_alert ON incident_bundles(alert_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_incident_bundles_pending ON incident_bundles(created_at) WHERE status IN ('pending', 'building');
+CREATE INDEX IF NOT EXISTS idx_incident_bundles_expiry ON incident_bundles(expires_at) WHERE status = 'ready';
@@ -0,0 +1,4 @@
+-- How an alert was resolved: 'manual' by someone resolving it, or 'auto'
+-- when the user confirmed they were home safe after healthy heartbeats from
+-- one of their safe zones. Alerts resolved before this migration have none.
+ALTER TABLE alerts ADD COLUMN IF NOT EXISTS resolution TEXT;
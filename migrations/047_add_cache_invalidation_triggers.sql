@@ -0,0 +1,62 @@
+-- Each API instance caches users, their contacts and geofences in memory.
+-- These triggers publish what changed on the safetrace_cache_invalidation
+-- channel, as {"kind": ..., "key": ...}, for every instance to evict its
+-- copy. NOTIFY is sent on commit, and duplicates in one transaction are
+-- sent once.
+CREATE OR REPLACE FUNCTION notify_cache_invalidation(kind TEXT, key TEXT)
+RETURNS VOID AS $$
+BEGIN
+    PERFORM pg_notify('safetrace_cache_invalidation',
+        json_build_object('kind', kind, 'key', key)::text);
+END;
+$$ language 'plpgsql';
+
+CREATE OR REPLACE FUNCTION users_cache_invalidation()
+RETURNS TRIGGER AS $$
+DECLARE
+    row_id UUID;
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        row_id := OLD.id;
+    ELSE
+        row_id := NEW.id;
+    END IF;
+    PERFORM notify_cache_invalidation('user', row_id::text);
+    IF TG_OP <> 'UPDATE'
+        OR OLD.settings->'safe_zones' IS DISTINCT FROM NEW.settings->'safe_zones' THEN
+        PERFORM notify_cache_invalidation('geofences', row_id::text);
+    END IF;
+    RETURN NULL;
+END;
+$$ language 'plpgsql';
+
+CREATE TRIGGER users_cache_invalidation AFTER INSERT OR UPDATE OR DELETE ON users
+    FOR EACH ROW EXECUTE FUNCTION users_cache_invalidation();
+
+CREATE OR REPLACE FUNCTION contacts_cache_invalidation()
+RETURNS TRIGGER AS $$
+BEGIN
+    IF TG_OP IN ('UPDATE', 'DELETE') THEN
+        PERFORM notify_cache_invalidation('contacts', OLD.user_id::text);
+    END IF;
+    IF TG_OP IN ('INSERT', 'UPDATE') THEN
+        PERFORM notify_cache_invalidation('contacts', NEW.user_id::text);
+    END IF;
+    RETURN NULL;
+END;
+$$ language 'plpgsql';
+
+CREATE TRIGGER contacts_cache_invalidation AFTER INSERT OR UPDATE OR DELETE ON contacts
+    FOR EACH ROW EXECUTE FUNCTION contacts_cache_invalidation();
+
+-- Broadcast areas are the danger zones every instance caches
+CREATE OR REPLACE FUNCTION broadcasts_cache_invalidation()
+RETURNS TRIGGER AS $$
+BEGIN
+    PERFORM notify_cache_invalidation('geofences', 'broadcasts');
+    RETURN NULL;
+END;
+$$ language 'plpgsql';
+
+CREATE TRIGGER broadcasts_cache_invalidation AFTER INSERT OR UPDATE OR DELETE ON broadcasts
+    FOR EACH STATEMENT EXECUTE FUNCTION broadcasts_cache_invalidation();
@@ -0,0 +1,7 @@
+-- Blackbox data points rejected on upload (undecodable fields, timestamps
+-- out of bounds or order, coordinates or sensor readings out of range) are
+-- kept apart from the trail with their reasons: in object storage beside the
+-- trail, or inline as a data URI. data_points counts only the accepted ones.
+ALTER TABLE blackbox_trails
+    ADD COLUMN IF NOT EXISTS rejected_points INTEGER NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS quarantine_url TEXT;
@@ -0,0 +1,9 @@
+-- Link a heartbeat sent over a second channel (HTTP and SMS "just in case")
+-- to the first copy received. NULL for originals, including all historical
+-- rows. Duplicates are kept for audit but drive no state.
+ALTER TABLE heartbeats ADD COLUMN IF NOT EXISTS duplicate_of UUID;
+
+-- The latest heartbeat that drives state ignores duplicates too
+DROP INDEX IF EXISTS idx_heartbeats_user_live_timestamp;
+CREATE INDEX IF NOT EXISTS idx_heartbeats_user_live_timestamp
+    ON heartbeats(user_id, timestamp DESC) WHERE NOT backfill AND duplicate_of IS NULL;
@@ -0,0 +1,3 @@
+-- Heartbeats streamed every few seconds while a user is AT_RISK or in ALERT.
+-- They are stored like any other, but history views keep one a minute.
+ALTER TABLE heartbeats ADD COLUMN IF NOT EXISTS high_frequency BOOLEAN NOT NULL DEFAULT false;
@@ -0,0 +1,9 @@
+-- Heartbeats of users in coarse location mode are stored with truncated
+-- coordinates; the precise fix is kept briefly in Redis only.
+ALTER TABLE heartbeats ADD COLUMN IF NOT EXISTS coarse BOOLEAN NOT NULL DEFAULT false;
+
+-- Coarse users consent to their location being made precise during an
+-- incident
+ALTER TABLE consents DROP CONSTRAINT IF EXISTS consents_scope_check;
+ALTER TABLE consents ADD CONSTRAINT consents_scope_check
+    CHECK (scope IN ('tracking', 'audio', 'community_broadcast', 'responder_sharing', 'precision_escalation'));
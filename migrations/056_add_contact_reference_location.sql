@@ -0,0 +1,8 @@
+-- A contact recipient may register a reference location, their home or
+-- workplace, geocoded once when they set it. Alerts they receive say how far
+-- and which way the user is from it.
+ALTER TABLE contact_recipients
+    ADD COLUMN IF NOT EXISTS ref_label TEXT CHECK (ref_label IN ('home', 'work')),
+    ADD COLUMN IF NOT EXISTS ref_lat DOUBLE PRECISION CHECK (ref_lat BETWEEN -90 AND 90),
+    ADD COLUMN IF NOT EXISTS ref_lng DOUBLE PRECISION CHECK (ref_lng BETWEEN -180 AND 180),
+    ADD COLUMN IF NOT EXISTS ref_address TEXT;
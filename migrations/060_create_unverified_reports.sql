@@ -0,0 +1,24 @@
+-- Positions sent to the unauthenticated fallback heartbeat endpoint with a
+-- user's phone number, by an app that lost its keys or by anyone else. They
+-- are kept apart from heartbeats so evaluation never sees them. One that
+-- arrives during an active alert is attached to it for contacts to see,
+-- labelled by its trust, which the user's answer to the verification push
+-- sent to their registered device moves to confirmed or denied.
+CREATE TABLE IF NOT EXISTS unverified_reports (
+    id UUID PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    alert_id UUID REFERENCES alerts(id) ON DELETE SET NULL,
+    lat DOUBLE PRECISION NOT NULL,
+    lng DOUBLE PRECISION NOT NULL,
+    accuracy_m INT,
+    reported_at TIMESTAMPTZ NOT NULL,
+    received_at TIMESTAMPTZ NOT NULL,
+    client_ip TEXT,
+    trust VARCHAR(20) NOT NULL DEFAULT 'unverified' CHECK (trust IN ('unverified', 'confirmed', 'denied')),
+    verification_sent_at TIMESTAMPTZ,
+    answered_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_unverified_reports_user ON unverified_reports(user_id, received_at DESC);
+CREATE INDEX IF NOT EXISTS idx_unverified_reports_alert ON unverified_reports(alert_id, received_at)
+    WHERE alert_id IS NOT NULL;